@@ -0,0 +1,355 @@
+// Package agent provides a high-level, session-managed client for atproto
+// services, similar to BskyAgent in the TypeScript SDK.
+//
+// An Agent bundles an xrpc client with session persistence, automatic access
+// token refresh, service proxying headers, and convenience methods for common
+// actions like posting and following. Lower-level calls can be made with any
+// of the generated api/atproto or api/bsky functions through [Agent.Call].
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// DefaultHost is the PDS entryway used when no host is configured
+const DefaultHost = "https://bsky.social"
+
+// AppViewProxy is the service proxy value for the Bluesky AppView
+const AppViewProxy = "did:web:api.bsky.app#bsky_appview"
+
+var ErrNoSession = errors.New("agent has no session; call Login or ResumeSession first")
+
+type AgentConfig struct {
+	// Host is the PDS (or entryway) to talk to. Defaults to DefaultHost.
+	Host string
+	// HTTPClient is used for all requests. Defaults to xrpc's robust client.
+	HTTPClient *http.Client
+	UserAgent  string
+	// Store persists sessions across restarts. Defaults to an in-memory store.
+	Store SessionStore
+	// Proxy, if set, is sent as the atproto-proxy header, eg AppViewProxy
+	Proxy string
+	// AcceptLabelers is sent as the atproto-accept-labelers header
+	AcceptLabelers []string
+}
+
+type Agent struct {
+	host       string
+	httpClient *http.Client
+	userAgent  string
+	store      SessionStore
+
+	lk      sync.Mutex
+	auth    *xrpc.AuthInfo
+	headers map[string]string
+}
+
+func NewAgent(config AgentConfig) *Agent {
+	if config.Host == "" {
+		config.Host = DefaultHost
+	}
+	if config.Store == nil {
+		config.Store = &MemorySessionStore{}
+	}
+
+	a := &Agent{
+		host:       strings.TrimSuffix(config.Host, "/"),
+		httpClient: config.HTTPClient,
+		userAgent:  config.UserAgent,
+		store:      config.Store,
+		headers:    make(map[string]string),
+	}
+	if config.Proxy != "" {
+		a.headers["atproto-proxy"] = config.Proxy
+	}
+	if len(config.AcceptLabelers) > 0 {
+		a.headers["atproto-accept-labelers"] = strings.Join(config.AcceptLabelers, ", ")
+	}
+	return a
+}
+
+// SetProxy changes the atproto-proxy header sent on subsequent requests. An
+// empty string disables proxying.
+func (a *Agent) SetProxy(proxy string) {
+	a.lk.Lock()
+	defer a.lk.Unlock()
+	if proxy == "" {
+		delete(a.headers, "atproto-proxy")
+	} else {
+		a.headers["atproto-proxy"] = proxy
+	}
+}
+
+// Session returns a copy of the current session, or nil if not logged in
+func (a *Agent) Session() *xrpc.AuthInfo {
+	a.lk.Lock()
+	defer a.lk.Unlock()
+	if a.auth == nil {
+		return nil
+	}
+	out := *a.auth
+	return &out
+}
+
+// DID returns the account DID of the current session, or an empty string
+func (a *Agent) DID() string {
+	a.lk.Lock()
+	defer a.lk.Unlock()
+	if a.auth == nil {
+		return ""
+	}
+	return a.auth.Did
+}
+
+// Client returns an xrpc client configured with the agent's current session
+// and headers. The returned client is a snapshot and is not updated when the
+// session is refreshed; prefer [Agent.Call] for anything long-lived.
+func (a *Agent) Client() *xrpc.Client {
+	a.lk.Lock()
+	defer a.lk.Unlock()
+	return a.clientLocked(a.auth)
+}
+
+func (a *Agent) clientLocked(auth *xrpc.AuthInfo) *xrpc.Client {
+	c := &xrpc.Client{
+		Client:  a.httpClient,
+		Host:    a.host,
+		Headers: make(map[string]string, len(a.headers)),
+	}
+	if a.userAgent != "" {
+		ua := a.userAgent
+		c.UserAgent = &ua
+	}
+	for k, v := range a.headers {
+		c.Headers[k] = v
+	}
+	if auth != nil {
+		cp := *auth
+		c.Auth = &cp
+	}
+	return c
+}
+
+func (a *Agent) setSession(ctx context.Context, auth *xrpc.AuthInfo) error {
+	a.auth = auth
+	if err := a.store.Save(ctx, auth); err != nil {
+		return fmt.Errorf("saving session: %w", err)
+	}
+	return nil
+}
+
+// Login creates a new session with a handle (or email) and password. App
+// passwords are recommended.
+func (a *Agent) Login(ctx context.Context, identifier, password string) error {
+	a.lk.Lock()
+	defer a.lk.Unlock()
+
+	out, err := comatproto.ServerCreateSession(ctx, a.clientLocked(nil), &comatproto.ServerCreateSession_Input{
+		Identifier: identifier,
+		Password:   password,
+	})
+	if err != nil {
+		return err
+	}
+
+	return a.setSession(ctx, &xrpc.AuthInfo{
+		AccessJwt:  out.AccessJwt,
+		RefreshJwt: out.RefreshJwt,
+		Handle:     out.Handle,
+		Did:        out.Did,
+	})
+}
+
+// ResumeSession loads a session from the agent's session store. It returns
+// false if the store was empty. The stored session is refreshed immediately,
+// so that an expired or revoked session is detected at startup.
+func (a *Agent) ResumeSession(ctx context.Context) (bool, error) {
+	auth, err := a.store.Load(ctx)
+	if err != nil {
+		return false, fmt.Errorf("loading session: %w", err)
+	}
+	if auth == nil {
+		return false, nil
+	}
+
+	a.lk.Lock()
+	defer a.lk.Unlock()
+	a.auth = auth
+	if err := a.refreshLocked(ctx); err != nil {
+		a.auth = nil
+		return false, err
+	}
+	return true, nil
+}
+
+// Refresh exchanges the refresh token for a new session
+func (a *Agent) Refresh(ctx context.Context) error {
+	a.lk.Lock()
+	defer a.lk.Unlock()
+	return a.refreshLocked(ctx)
+}
+
+func (a *Agent) refreshLocked(ctx context.Context) error {
+	if a.auth == nil {
+		return ErrNoSession
+	}
+
+	// refreshSession is authenticated with the refresh token in place of
+	// the access token
+	c := a.clientLocked(&xrpc.AuthInfo{AccessJwt: a.auth.RefreshJwt})
+	out, err := comatproto.ServerRefreshSession(ctx, c)
+	if err != nil {
+		return fmt.Errorf("refreshing session: %w", err)
+	}
+
+	return a.setSession(ctx, &xrpc.AuthInfo{
+		AccessJwt:  out.AccessJwt,
+		RefreshJwt: out.RefreshJwt,
+		Handle:     out.Handle,
+		Did:        out.Did,
+	})
+}
+
+func isExpiredTokenErr(err error) bool {
+	var xe *xrpc.XRPCError
+	return errors.As(err, &xe) && xe.ErrStr == "ExpiredToken"
+}
+
+// Call runs fn with an authenticated xrpc client. If the request fails because
+// the access token expired, the session is refreshed and fn is retried once.
+//
+//	err := a.Call(ctx, func(c *xrpc.Client) error {
+//		out, err = bsky.FeedGetTimeline(ctx, c, "", "", 50)
+//		return err
+//	})
+func (a *Agent) Call(ctx context.Context, fn func(c *xrpc.Client) error) error {
+	a.lk.Lock()
+	if a.auth == nil {
+		a.lk.Unlock()
+		return ErrNoSession
+	}
+	used := a.auth.AccessJwt
+	c := a.clientLocked(a.auth)
+	a.lk.Unlock()
+
+	err := fn(c)
+	if err == nil || !isExpiredTokenErr(err) {
+		return err
+	}
+
+	a.lk.Lock()
+	// another caller may have already refreshed the session
+	if a.auth == nil || a.auth.AccessJwt == used {
+		if err := a.refreshLocked(ctx); err != nil {
+			a.lk.Unlock()
+			return err
+		}
+	}
+	c = a.clientLocked(a.auth)
+	a.lk.Unlock()
+
+	return fn(c)
+}
+
+// CreateRecord creates a record in the session account's repo, and returns a
+// strong reference to it
+func (a *Agent) CreateRecord(ctx context.Context, collection string, rec lexutil.CBOR) (*comatproto.RepoStrongRef, error) {
+	var out *comatproto.RepoCreateRecord_Output
+	err := a.Call(ctx, func(c *xrpc.Client) error {
+		var err error
+		out, err = comatproto.RepoCreateRecord(ctx, c, &comatproto.RepoCreateRecord_Input{
+			Collection: collection,
+			Repo:       c.Auth.Did,
+			Record:     &lexutil.LexiconTypeDecoder{Val: rec},
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &comatproto.RepoStrongRef{Uri: out.Uri, Cid: out.Cid}, nil
+}
+
+// Post creates a post record. If CreatedAt is empty it is set to the current
+// time.
+func (a *Agent) Post(ctx context.Context, post *bsky.FeedPost) (*comatproto.RepoStrongRef, error) {
+	if post.CreatedAt == "" {
+		post.CreatedAt = syntax.DatetimeNow().String()
+	}
+	return a.CreateRecord(ctx, "app.bsky.feed.post", post)
+}
+
+// PostText creates a plain text post
+func (a *Agent) PostText(ctx context.Context, text string) (*comatproto.RepoStrongRef, error) {
+	return a.Post(ctx, &bsky.FeedPost{Text: text})
+}
+
+// Follow follows the account with the given DID, returning the follow record
+// reference
+func (a *Agent) Follow(ctx context.Context, did string) (*comatproto.RepoStrongRef, error) {
+	if _, err := syntax.ParseDID(did); err != nil {
+		return nil, err
+	}
+	return a.CreateRecord(ctx, "app.bsky.graph.follow", &bsky.GraphFollow{
+		CreatedAt: syntax.DatetimeNow().String(),
+		Subject:   did,
+	})
+}
+
+// Like likes the given record
+func (a *Agent) Like(ctx context.Context, subject *comatproto.RepoStrongRef) (*comatproto.RepoStrongRef, error) {
+	return a.CreateRecord(ctx, "app.bsky.feed.like", &bsky.FeedLike{
+		CreatedAt: syntax.DatetimeNow().String(),
+		Subject:   subject,
+	})
+}
+
+// UploadBlob uploads a blob with the given MIME type
+func (a *Agent) UploadBlob(ctx context.Context, r io.Reader, mimeType string) (*lexutil.LexBlob, error) {
+	// the request body can only be read once, so buffer it in case the
+	// upload needs to be retried after a session refresh
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var out comatproto.RepoUploadBlob_Output
+	err = a.Call(ctx, func(c *xrpc.Client) error {
+		return c.Do(ctx, xrpc.Procedure, mimeType, "com.atproto.repo.uploadBlob", nil, bytes.NewReader(b), &out)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Blob, nil
+}
+
+// UploadImage uploads an image blob, and returns an image embed item ready to
+// be included in a post's app.bsky.embed.images embed
+func (a *Agent) UploadImage(ctx context.Context, r io.Reader, mimeType, alt string) (*bsky.EmbedImages_Image, error) {
+	if !strings.HasPrefix(mimeType, "image/") {
+		return nil, fmt.Errorf("not an image MIME type: %s", mimeType)
+	}
+
+	blob, err := a.UploadBlob(ctx, r, mimeType)
+	if err != nil {
+		return nil, err
+	}
+
+	return &bsky.EmbedImages_Image{
+		Alt:   alt,
+		Image: blob,
+	}, nil
+}
@@ -0,0 +1,169 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// fakePDS issues numbered tokens, and reports every access token except the
+// most recent one as expired
+type fakePDS struct {
+	lk         sync.Mutex
+	gen        int
+	refreshes  int
+	proxyHdr   string
+	createdFor []string
+}
+
+func (f *fakePDS) tokens() map[string]string {
+	return map[string]string{
+		"accessJwt":  fmt.Sprintf("access-%d", f.gen),
+		"refreshJwt": fmt.Sprintf("refresh-%d", f.gen),
+		"handle":     "alice.test",
+		"did":        "did:plc:alice",
+	}
+}
+
+func (f *fakePDS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	switch r.URL.Path {
+	case "/xrpc/com.atproto.server.createSession":
+		f.gen++
+		json.NewEncoder(w).Encode(f.tokens())
+	case "/xrpc/com.atproto.server.refreshSession":
+		if bearer != fmt.Sprintf("refresh-%d", f.gen) {
+			w.WriteHeader(400)
+			json.NewEncoder(w).Encode(map[string]string{"error": "InvalidToken", "message": "bad refresh token"})
+			return
+		}
+		f.gen++
+		f.refreshes++
+		json.NewEncoder(w).Encode(f.tokens())
+	case "/xrpc/com.atproto.repo.createRecord":
+		if bearer != fmt.Sprintf("access-%d", f.gen) {
+			w.WriteHeader(400)
+			json.NewEncoder(w).Encode(map[string]string{"error": "ExpiredToken", "message": "token has expired"})
+			return
+		}
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		f.createdFor = append(f.createdFor, body["repo"].(string))
+		f.proxyHdr = r.Header.Get("atproto-proxy")
+		json.NewEncoder(w).Encode(map[string]string{
+			"uri": "at://did:plc:alice/app.bsky.feed.post/3kabc",
+			"cid": "bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm",
+		})
+	default:
+		w.WriteHeader(404)
+	}
+}
+
+func TestAgentRefreshOnExpiredToken(t *testing.T) {
+	ctx := context.Background()
+	pds := &fakePDS{}
+	srv := httptest.NewServer(pds)
+	defer srv.Close()
+
+	a := NewAgent(AgentConfig{Host: srv.URL, Proxy: AppViewProxy})
+	if _, err := a.PostText(ctx, "no session"); err != ErrNoSession {
+		t.Fatalf("expected ErrNoSession, got %v", err)
+	}
+
+	if err := a.Login(ctx, "alice.test", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	if a.DID() != "did:plc:alice" {
+		t.Fatalf("unexpected DID: %s", a.DID())
+	}
+
+	// invalidate the agent's access token behind its back
+	pds.lk.Lock()
+	pds.gen++
+	pds.lk.Unlock()
+
+	// the refresh token is also stale now, so put a matching one in place
+	a.lk.Lock()
+	a.auth.RefreshJwt = fmt.Sprintf("refresh-%d", pds.gen)
+	a.lk.Unlock()
+
+	ref, err := a.PostText(ctx, "hello world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.Uri != "at://did:plc:alice/app.bsky.feed.post/3kabc" {
+		t.Fatalf("unexpected uri: %s", ref.Uri)
+	}
+	if pds.refreshes != 1 {
+		t.Fatalf("expected one refresh, got %d", pds.refreshes)
+	}
+	if pds.proxyHdr != AppViewProxy {
+		t.Fatalf("proxy header not sent: %q", pds.proxyHdr)
+	}
+	if len(pds.createdFor) != 1 || pds.createdFor[0] != "did:plc:alice" {
+		t.Fatalf("unexpected createRecord calls: %v", pds.createdFor)
+	}
+	if a.Session().AccessJwt != fmt.Sprintf("access-%d", pds.gen) {
+		t.Fatal("agent did not pick up refreshed session")
+	}
+}
+
+func TestAgentResumeSession(t *testing.T) {
+	ctx := context.Background()
+	pds := &fakePDS{}
+	srv := httptest.NewServer(pds)
+	defer srv.Close()
+
+	store := &FileSessionStore{Path: filepath.Join(t.TempDir(), "session.json")}
+
+	a := NewAgent(AgentConfig{Host: srv.URL, Store: store})
+	ok, err := a.ResumeSession(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("expected no stored session")
+	}
+
+	if err := a.Login(ctx, "alice.test", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+
+	b := NewAgent(AgentConfig{Host: srv.URL, Store: store})
+	ok, err = b.ResumeSession(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("expected stored session to be resumed")
+	}
+
+	// resuming refreshes, and the refreshed tokens get persisted
+	saved, err := store.Load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.AccessJwt != b.Session().AccessJwt || saved.AccessJwt == "" {
+		t.Fatalf("refreshed session not persisted: %+v", saved)
+	}
+
+	// the first agent's refresh token was rotated away
+	if err := a.Call(ctx, func(c *xrpc.Client) error {
+		return c.Do(ctx, xrpc.Procedure, "application/json", "com.atproto.repo.createRecord", nil, map[string]string{"repo": "x"}, nil)
+	}); err == nil {
+		t.Fatal("expected stale agent to fail")
+	}
+}
@@ -0,0 +1,99 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// SessionStore persists session tokens between runs, so that long-lived bots
+// don't need to log in with a password on every start
+type SessionStore interface {
+	// Load returns the stored session, or nil if there is none
+	Load(ctx context.Context) (*xrpc.AuthInfo, error)
+	Save(ctx context.Context, auth *xrpc.AuthInfo) error
+}
+
+// MemorySessionStore keeps the session in memory only
+type MemorySessionStore struct {
+	lk   sync.Mutex
+	auth *xrpc.AuthInfo
+}
+
+var _ SessionStore = (*MemorySessionStore)(nil)
+
+func (s *MemorySessionStore) Load(ctx context.Context) (*xrpc.AuthInfo, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if s.auth == nil {
+		return nil, nil
+	}
+	out := *s.auth
+	return &out, nil
+}
+
+func (s *MemorySessionStore) Save(ctx context.Context, auth *xrpc.AuthInfo) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	cp := *auth
+	s.auth = &cp
+	return nil
+}
+
+// FileSessionStore stores the session as JSON in a file, in the same format
+// used by gosky's auth file
+type FileSessionStore struct {
+	Path string
+}
+
+var _ SessionStore = (*FileSessionStore)(nil)
+
+func (s *FileSessionStore) Load(ctx context.Context) (*xrpc.AuthInfo, error) {
+	b, err := os.ReadFile(s.Path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var auth xrpc.AuthInfo
+	if err := json.Unmarshal(b, &auth); err != nil {
+		return nil, err
+	}
+	return &auth, nil
+}
+
+func (s *FileSessionStore) Save(ctx context.Context, auth *xrpc.AuthInfo) error {
+	b, err := json.Marshal(auth)
+	if err != nil {
+		return err
+	}
+
+	// write to a temp file and rename, so a crash mid-write doesn't lose the
+	// refresh token
+	tmp, err := os.CreateTemp(filepath.Dir(s.Path), filepath.Base(s.Path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.Path)
+}
@@ -33,6 +33,8 @@ type SyncSubscribeRepos_Commit struct {
 	Ops    []*SyncSubscribeRepos_RepoOp `json:"ops" cborgen:"ops"`
 	// prev: DEPRECATED -- unused. WARNING -- nullable and optional; stick with optional to ensure golang interoperability.
 	Prev *util.LexLink `json:"prev" cborgen:"prev"`
+	// prevData: The root CID of the MST tree for the previous commit from this repo (indicated by the 'since' revision field in this message). Corresponds to the 'data' field in the repo commit object. NOTE: this field is effectively required for the 'inductive' version of firehose.
+	PrevData *util.LexLink `json:"prevData,omitempty" cborgen:"prevData,omitempty"`
 	// rebase: DEPRECATED -- unused
 	Rebase bool `json:"rebase" cborgen:"rebase"`
 	// repo: The repo this event comes from.
@@ -94,6 +96,24 @@ type SyncSubscribeRepos_RepoOp struct {
 	// cid: For creates and updates, the new record CID. For deletions, null.
 	Cid  *util.LexLink `json:"cid" cborgen:"cid"`
 	Path string        `json:"path" cborgen:"path"`
+	// prev: For updates and deletes, the previous record CID (required for inductive firehose). For creations, field should not be defined.
+	Prev *util.LexLink `json:"prev,omitempty" cborgen:"prev,omitempty"`
+}
+
+// SyncSubscribeRepos_Sync is a "sync" in the com.atproto.sync.subscribeRepos schema.
+//
+// Updates the repo to a new state, without necessarily including that state on the firehose. Used to recover from broken commit streams, data loss incidents, or in situations where upstream host does not know recent state of the repository.
+type SyncSubscribeRepos_Sync struct {
+	// blocks: CAR file containing the commit, as a block. The CAR header must include the commit block CID as the first 'root'.
+	Blocks util.LexBytes `json:"blocks,omitempty" cborgen:"blocks,omitempty"`
+	// did: The account this repo event corresponds to. Must match that in the commit object.
+	Did string `json:"did" cborgen:"did"`
+	// rev: The rev of the commit. This value must match that in the commit object.
+	Rev string `json:"rev" cborgen:"rev"`
+	// seq: The stream sequence number of this message.
+	Seq int64 `json:"seq" cborgen:"seq"`
+	// time: Timestamp of when this message was originally broadcast.
+	Time string `json:"time" cborgen:"time"`
 }
 
 // SyncSubscribeRepos_Tombstone is a "tombstone" in the com.atproto.sync.subscribeRepos schema.
@@ -0,0 +1,278 @@
+package bsky
+
+// Hand-written helpers for building FeedPost embeds, so callers don't need to
+// assemble the union structs (and remember the lexicon constraints) by hand.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/lex/util"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// Constraints from the app.bsky.embed.* lexicons
+const (
+	EmbedImagesMax       = 4
+	EmbedImageMaxSize    = 1_000_000
+	EmbedThumbMaxSize    = 1_000_000
+	EmbedVideoMaxSize    = 100_000_000
+	EmbedCaptionMaxSize  = 20_000
+	EmbedCaptionsMax     = 20
+	EmbedVideoMimeType   = "video/mp4"
+	EmbedCaptionMimeType = "text/vtt"
+)
+
+// NewEmbedImage returns a single image for NewEmbedImages. Width and height
+// are the image's pixel dimensions, used as its aspect ratio; pass zero for
+// both if they aren't known.
+func NewEmbedImage(blob *util.LexBlob, alt string, width, height int64) *EmbedImages_Image {
+	img := &EmbedImages_Image{
+		Alt:   alt,
+		Image: blob,
+	}
+	if width > 0 || height > 0 {
+		img.AspectRatio = &EmbedDefs_AspectRatio{Width: width, Height: height}
+	}
+	return img
+}
+
+// NewEmbedImages returns a post embed of up to four images
+func NewEmbedImages(images ...*EmbedImages_Image) (*FeedPost_Embed, error) {
+	embed := &EmbedImages{Images: images}
+	if err := embed.validate(); err != nil {
+		return nil, err
+	}
+	return &FeedPost_Embed{EmbedImages: embed}, nil
+}
+
+// NewEmbedExternal returns a post embed of an external link card. Thumb is
+// optional; see UploadEmbedBlob for uploading one.
+func NewEmbedExternal(uri, title, description string, thumb *util.LexBlob) (*FeedPost_Embed, error) {
+	embed := &EmbedExternal{External: &EmbedExternal_External{
+		Uri:         uri,
+		Title:       title,
+		Description: description,
+		Thumb:       thumb,
+	}}
+	if err := embed.validate(); err != nil {
+		return nil, err
+	}
+	return &FeedPost_Embed{EmbedExternal: embed}, nil
+}
+
+// NewEmbedVideo returns a post embed of a video. Alt may be empty, and width
+// and height zero if the dimensions aren't known.
+func NewEmbedVideo(blob *util.LexBlob, alt string, width, height int64, captions ...*EmbedVideo_Caption) (*FeedPost_Embed, error) {
+	embed := &EmbedVideo{
+		Video:    blob,
+		Captions: captions,
+	}
+	if alt != "" {
+		embed.Alt = &alt
+	}
+	if width > 0 || height > 0 {
+		embed.AspectRatio = &EmbedDefs_AspectRatio{Width: width, Height: height}
+	}
+	if err := embed.validate(); err != nil {
+		return nil, err
+	}
+	return &FeedPost_Embed{EmbedVideo: embed}, nil
+}
+
+// NewEmbedRecord returns a post embed quoting another record (a post, feed
+// generator, list, etc)
+func NewEmbedRecord(ref *comatproto.RepoStrongRef) (*FeedPost_Embed, error) {
+	embed := &EmbedRecord{Record: ref}
+	if err := embed.validate(); err != nil {
+		return nil, err
+	}
+	return &FeedPost_Embed{EmbedRecord: embed}, nil
+}
+
+// NewEmbedRecordWithMedia returns a post embed quoting a record alongside
+// media. Media must be an images, video, or external embed, as returned by
+// the other constructors.
+func NewEmbedRecordWithMedia(ref *comatproto.RepoStrongRef, media *FeedPost_Embed) (*FeedPost_Embed, error) {
+	if media == nil {
+		return nil, fmt.Errorf("record-with-media embed requires media")
+	}
+	m := &EmbedRecordWithMedia_Media{
+		EmbedImages:   media.EmbedImages,
+		EmbedVideo:    media.EmbedVideo,
+		EmbedExternal: media.EmbedExternal,
+	}
+	embed := &EmbedRecordWithMedia{
+		Record: &EmbedRecord{Record: ref},
+		Media:  m,
+	}
+	if err := embed.validate(); err != nil {
+		return nil, err
+	}
+	return &FeedPost_Embed{EmbedRecordWithMedia: embed}, nil
+}
+
+// UploadEmbedBlob uploads media (eg, an image or an external card thumbnail)
+// to the client's PDS, returning the blob to reference in an embed
+func UploadEmbedBlob(ctx context.Context, c *xrpc.Client, r io.Reader) (*util.LexBlob, error) {
+	out, err := comatproto.RepoUploadBlob(ctx, c, r)
+	if err != nil {
+		return nil, fmt.Errorf("uploading blob: %w", err)
+	}
+	return out.Blob, nil
+}
+
+// ValidateFeedPostEmbed checks an embed against the lexicon constraints: that
+// exactly one variant is set, and the variant's own limits
+func ValidateFeedPostEmbed(e *FeedPost_Embed) error {
+	if e == nil {
+		return nil
+	}
+	var set []func() error
+	if e.EmbedImages != nil {
+		set = append(set, e.EmbedImages.validate)
+	}
+	if e.EmbedVideo != nil {
+		set = append(set, e.EmbedVideo.validate)
+	}
+	if e.EmbedExternal != nil {
+		set = append(set, e.EmbedExternal.validate)
+	}
+	if e.EmbedRecord != nil {
+		set = append(set, e.EmbedRecord.validate)
+	}
+	if e.EmbedRecordWithMedia != nil {
+		set = append(set, e.EmbedRecordWithMedia.validate)
+	}
+	if len(set) != 1 {
+		return fmt.Errorf("post embed must have exactly one variant set, got %d", len(set))
+	}
+	return set[0]()
+}
+
+func checkBlob(what string, b *util.LexBlob, maxSize int64, mimeOK func(string) bool) error {
+	if b == nil {
+		return fmt.Errorf("%s: missing blob", what)
+	}
+	if !b.Ref.Defined() {
+		return fmt.Errorf("%s: blob has no ref", what)
+	}
+	if b.Size > maxSize {
+		return fmt.Errorf("%s: blob too large (%d bytes, max %d)", what, b.Size, maxSize)
+	}
+	if !mimeOK(b.MimeType) {
+		return fmt.Errorf("%s: unsupported mime type %q", what, b.MimeType)
+	}
+	return nil
+}
+
+func isImageMime(m string) bool {
+	return strings.HasPrefix(m, "image/")
+}
+
+func checkAspectRatio(what string, ar *EmbedDefs_AspectRatio) error {
+	if ar != nil && (ar.Width < 1 || ar.Height < 1) {
+		return fmt.Errorf("%s: aspect ratio dimensions must be positive (%dx%d)", what, ar.Width, ar.Height)
+	}
+	return nil
+}
+
+func (e *EmbedImages) validate() error {
+	if len(e.Images) == 0 {
+		return fmt.Errorf("images embed requires at least one image")
+	}
+	if len(e.Images) > EmbedImagesMax {
+		return fmt.Errorf("images embed has %d images (max %d)", len(e.Images), EmbedImagesMax)
+	}
+	for i, img := range e.Images {
+		what := fmt.Sprintf("image %d", i)
+		if img == nil {
+			return fmt.Errorf("%s: nil image", what)
+		}
+		if err := checkBlob(what, img.Image, EmbedImageMaxSize, isImageMime); err != nil {
+			return err
+		}
+		if err := checkAspectRatio(what, img.AspectRatio); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *EmbedExternal) validate() error {
+	ext := e.External
+	if ext == nil {
+		return fmt.Errorf("external embed missing link")
+	}
+	u, err := url.Parse(ext.Uri)
+	if err != nil || u.Scheme == "" {
+		return fmt.Errorf("external embed has invalid uri: %q", ext.Uri)
+	}
+	if ext.Thumb != nil {
+		if err := checkBlob("external thumb", ext.Thumb, EmbedThumbMaxSize, isImageMime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *EmbedVideo) validate() error {
+	if err := checkBlob("video", e.Video, EmbedVideoMaxSize, func(m string) bool { return m == EmbedVideoMimeType }); err != nil {
+		return err
+	}
+	if err := checkAspectRatio("video", e.AspectRatio); err != nil {
+		return err
+	}
+	if len(e.Captions) > EmbedCaptionsMax {
+		return fmt.Errorf("video has %d captions (max %d)", len(e.Captions), EmbedCaptionsMax)
+	}
+	for i, c := range e.Captions {
+		what := fmt.Sprintf("caption %d", i)
+		if c == nil || c.Lang == "" {
+			return fmt.Errorf("%s: missing language", what)
+		}
+		if err := checkBlob(what, c.File, EmbedCaptionMaxSize, func(m string) bool { return m == EmbedCaptionMimeType }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *EmbedRecord) validate() error {
+	ref := e.Record
+	if ref == nil || ref.Uri == "" || ref.Cid == "" {
+		return fmt.Errorf("record embed requires a strong ref (uri and cid)")
+	}
+	if !strings.HasPrefix(ref.Uri, "at://") {
+		return fmt.Errorf("record embed uri is not an at:// uri: %q", ref.Uri)
+	}
+	return nil
+}
+
+func (e *EmbedRecordWithMedia) validate() error {
+	if e.Record == nil {
+		return fmt.Errorf("record-with-media embed missing record")
+	}
+	if err := e.Record.validate(); err != nil {
+		return err
+	}
+
+	m := e.Media
+	if m == nil {
+		return fmt.Errorf("record-with-media embed missing media")
+	}
+	switch {
+	case m.EmbedImages != nil && m.EmbedVideo == nil && m.EmbedExternal == nil:
+		return m.EmbedImages.validate()
+	case m.EmbedVideo != nil && m.EmbedImages == nil && m.EmbedExternal == nil:
+		return m.EmbedVideo.validate()
+	case m.EmbedExternal != nil && m.EmbedImages == nil && m.EmbedVideo == nil:
+		return m.EmbedExternal.validate()
+	default:
+		return fmt.Errorf("record-with-media embed must have exactly one of images, video, or external media")
+	}
+}
@@ -0,0 +1,200 @@
+package bsky
+
+import (
+	"encoding/json"
+	"testing"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/lex/util"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/assert"
+)
+
+func testBlob(t *testing.T, mimeType string, size int64) *util.LexBlob {
+	t.Helper()
+	c, err := cid.NewPrefixV1(cid.Raw, multihash.SHA2_256).Sum([]byte(mimeType))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &util.LexBlob{Ref: util.LexLink(c), MimeType: mimeType, Size: size}
+}
+
+func TestEmbedHelpers(t *testing.T) {
+	image := testBlob(t, "image/jpeg", 1000)
+	video := testBlob(t, EmbedVideoMimeType, 1000)
+	vtt := testBlob(t, EmbedCaptionMimeType, 100)
+	ref := &comatproto.RepoStrongRef{Uri: "at://did:example:abc/app.bsky.feed.post/1", Cid: "bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm"}
+
+	img := func(blob *util.LexBlob) *EmbedImages_Image { return NewEmbedImage(blob, "alt", 0, 0) }
+	images := func(t *testing.T) *FeedPost_Embed {
+		e, err := NewEmbedImages(img(image))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+
+	for _, tc := range []struct {
+		name string
+		make func(t *testing.T) (*FeedPost_Embed, error)
+		// substring of the expected error, or empty for success
+		err string
+	}{
+		{"images", func(*testing.T) (*FeedPost_Embed, error) {
+			return NewEmbedImages(NewEmbedImage(image, "a cat", 640, 480), img(image))
+		}, ""},
+		{"images/none", func(*testing.T) (*FeedPost_Embed, error) { return NewEmbedImages() }, "at least one image"},
+		{"images/too many", func(*testing.T) (*FeedPost_Embed, error) {
+			return NewEmbedImages(img(image), img(image), img(image), img(image), img(image))
+		}, "max 4"},
+		{"images/nil image", func(*testing.T) (*FeedPost_Embed, error) { return NewEmbedImages(img(image), nil) }, "image 1: nil image"},
+		{"images/nil blob", func(*testing.T) (*FeedPost_Embed, error) { return NewEmbedImages(img(nil)) }, "missing blob"},
+		{"images/no ref", func(*testing.T) (*FeedPost_Embed, error) {
+			return NewEmbedImages(img(&util.LexBlob{MimeType: "image/png", Size: 10}))
+		}, "no ref"},
+		{"images/too large", func(t *testing.T) (*FeedPost_Embed, error) {
+			return NewEmbedImages(img(testBlob(t, "image/png", EmbedImageMaxSize+1)))
+		}, "too large"},
+		{"images/not an image", func(*testing.T) (*FeedPost_Embed, error) { return NewEmbedImages(img(video)) }, "unsupported mime type"},
+		{"images/bad aspect ratio", func(*testing.T) (*FeedPost_Embed, error) {
+			return NewEmbedImages(NewEmbedImage(image, "", 640, 0))
+		}, "aspect ratio"},
+
+		{"external", func(*testing.T) (*FeedPost_Embed, error) {
+			return NewEmbedExternal("https://example.com", "Example", "an example", image)
+		}, ""},
+		{"external/no thumb", func(*testing.T) (*FeedPost_Embed, error) {
+			return NewEmbedExternal("https://example.com", "", "", nil)
+		}, ""},
+		{"external/bad uri", func(*testing.T) (*FeedPost_Embed, error) {
+			return NewEmbedExternal("example.com", "", "", nil)
+		}, "invalid uri"},
+		{"external/bad thumb", func(*testing.T) (*FeedPost_Embed, error) {
+			return NewEmbedExternal("https://example.com", "", "", video)
+		}, "external thumb"},
+
+		{"video", func(*testing.T) (*FeedPost_Embed, error) {
+			return NewEmbedVideo(video, "a video", 1920, 1080, &EmbedVideo_Caption{Lang: "en", File: vtt})
+		}, ""},
+		{"video/wrong mime type", func(*testing.T) (*FeedPost_Embed, error) { return NewEmbedVideo(image, "", 0, 0) }, "unsupported mime type"},
+		{"video/nil", func(*testing.T) (*FeedPost_Embed, error) { return NewEmbedVideo(nil, "", 0, 0) }, "missing blob"},
+		{"video/nil caption", func(*testing.T) (*FeedPost_Embed, error) { return NewEmbedVideo(video, "", 0, 0, nil) }, "missing language"},
+		{"video/caption mime type", func(*testing.T) (*FeedPost_Embed, error) {
+			return NewEmbedVideo(video, "", 0, 0, &EmbedVideo_Caption{Lang: "en", File: image})
+		}, "caption 0"},
+
+		{"record", func(*testing.T) (*FeedPost_Embed, error) { return NewEmbedRecord(ref) }, ""},
+		{"record/nil", func(*testing.T) (*FeedPost_Embed, error) { return NewEmbedRecord(nil) }, "strong ref"},
+		{"record/no cid", func(*testing.T) (*FeedPost_Embed, error) {
+			return NewEmbedRecord(&comatproto.RepoStrongRef{Uri: ref.Uri})
+		}, "strong ref"},
+		{"record/not at uri", func(*testing.T) (*FeedPost_Embed, error) {
+			return NewEmbedRecord(&comatproto.RepoStrongRef{Uri: "https://example.com", Cid: ref.Cid})
+		}, "at:// uri"},
+
+		{"recordWithMedia", func(t *testing.T) (*FeedPost_Embed, error) { return NewEmbedRecordWithMedia(ref, images(t)) }, ""},
+		{"recordWithMedia/nil media", func(*testing.T) (*FeedPost_Embed, error) { return NewEmbedRecordWithMedia(ref, nil) }, "requires media"},
+		{"recordWithMedia/empty media", func(*testing.T) (*FeedPost_Embed, error) {
+			return NewEmbedRecordWithMedia(ref, &FeedPost_Embed{})
+		}, "exactly one of"},
+		{"recordWithMedia/record media", func(*testing.T) (*FeedPost_Embed, error) {
+			return NewEmbedRecordWithMedia(ref, &FeedPost_Embed{EmbedRecord: &EmbedRecord{Record: ref}})
+		}, "exactly one of"},
+		{"recordWithMedia/nil record", func(t *testing.T) (*FeedPost_Embed, error) { return NewEmbedRecordWithMedia(nil, images(t)) }, "strong ref"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert := assert.New(t)
+			e, err := tc.make(t)
+			if tc.err != "" {
+				if assert.Error(err) {
+					assert.Contains(err.Error(), tc.err)
+				}
+				assert.Nil(e)
+				return
+			}
+			if !assert.NoError(err) {
+				return
+			}
+			assert.NoError(ValidateFeedPostEmbed(e))
+
+			// the embed survives a round trip through a post record
+			post := &FeedPost{Text: "hi", CreatedAt: "2024-01-01T00:00:00Z", Embed: e}
+			b, err := json.Marshal(post)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out FeedPost
+			if err := json.Unmarshal(b, &out); err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(e, out.Embed)
+		})
+	}
+}
+
+func TestValidateFeedPostEmbed(t *testing.T) {
+	image := testBlob(t, "image/jpeg", 1000)
+	images := &EmbedImages{Images: []*EmbedImages_Image{NewEmbedImage(image, "", 0, 0)}}
+	ref := &comatproto.RepoStrongRef{Uri: "at://did:example:abc/app.bsky.feed.post/1", Cid: "bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm"}
+
+	// decodes a union from JSON, as it would arrive in a record
+	decode := func(t *testing.T, s string) *FeedPost_Embed {
+		var e FeedPost_Embed
+		if err := json.Unmarshal([]byte(s), &e); err != nil {
+			t.Fatal(err)
+		}
+		return &e
+	}
+
+	for _, tc := range []struct {
+		name  string
+		embed func(t *testing.T) *FeedPost_Embed
+		err   string
+	}{
+		{"nil", func(*testing.T) *FeedPost_Embed { return nil }, ""},
+		{"images", func(*testing.T) *FeedPost_Embed { return &FeedPost_Embed{EmbedImages: images} }, ""},
+		{"empty", func(*testing.T) *FeedPost_Embed { return &FeedPost_Embed{} }, "got 0"},
+		{"two variants", func(*testing.T) *FeedPost_Embed {
+			return &FeedPost_Embed{EmbedImages: images, EmbedRecord: &EmbedRecord{Record: ref}}
+		}, "got 2"},
+		{"unknown type", func(t *testing.T) *FeedPost_Embed {
+			return decode(t, `{"$type": "app.bsky.embed.somethingNew", "foo": "bar"}`)
+		}, "got 0"},
+		{"decoded record", func(t *testing.T) *FeedPost_Embed {
+			return decode(t, `{"$type": "app.bsky.embed.record", "record": {"uri": "`+ref.Uri+`", "cid": "`+ref.Cid+`"}}`)
+		}, ""},
+		{"decoded record missing ref", func(t *testing.T) *FeedPost_Embed {
+			return decode(t, `{"$type": "app.bsky.embed.record"}`)
+		}, "strong ref"},
+		{"recordWithMedia unknown media", func(t *testing.T) *FeedPost_Embed {
+			return decode(t, `{"$type": "app.bsky.embed.recordWithMedia", "record": {"record": {"uri": "`+ref.Uri+`", "cid": "`+ref.Cid+`"}}, "media": {"$type": "app.bsky.embed.somethingNew"}}`)
+		}, "exactly one of"},
+		{"recordWithMedia missing media", func(*testing.T) *FeedPost_Embed {
+			return &FeedPost_Embed{EmbedRecordWithMedia: &EmbedRecordWithMedia{Record: &EmbedRecord{Record: ref}}}
+		}, "missing media"},
+		{"recordWithMedia missing record", func(*testing.T) *FeedPost_Embed {
+			return &FeedPost_Embed{EmbedRecordWithMedia: &EmbedRecordWithMedia{Media: &EmbedRecordWithMedia_Media{EmbedImages: images}}}
+		}, "missing record"},
+		{"external missing link", func(*testing.T) *FeedPost_Embed {
+			return &FeedPost_Embed{EmbedExternal: &EmbedExternal{}}
+		}, "missing link"},
+		{"too many captions", func(t *testing.T) *FeedPost_Embed {
+			v := &EmbedVideo{Video: testBlob(t, EmbedVideoMimeType, 1000)}
+			for range EmbedCaptionsMax + 1 {
+				v.Captions = append(v.Captions, &EmbedVideo_Caption{Lang: "en", File: testBlob(t, EmbedCaptionMimeType, 10)})
+			}
+			return &FeedPost_Embed{EmbedVideo: v}
+		}, "captions (max"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateFeedPostEmbed(tc.embed(t))
+			if tc.err == "" {
+				assert.NoError(t, err)
+			} else if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tc.err)
+			}
+		})
+	}
+}
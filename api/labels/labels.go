@@ -0,0 +1,103 @@
+// Package labels provides helpers for working with atproto labels: the
+// canonical label values, validation, self-labels on records, and parsing,
+// signing, and verification of label objects from labeling services.
+//
+// Label objects themselves are the generated [comatproto.LabelDefs_Label]
+// type; this package doesn't wrap them.
+package labels
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Global label values, defined by the protocol and interpreted by all clients
+const (
+	// Hides the subject from all views
+	ValueHide = "!hide"
+	// Shows a generic warning before the subject
+	ValueWarn = "!warn"
+	// Asks clients not to show the subject to logged-out users
+	ValueNoUnauthenticated = "!no-unauthenticated"
+	// Applied by moderation services to taken-down accounts and content
+	ValueTakedown = "!takedown"
+	ValueSuspend  = "!suspend"
+
+	// Adult content values, which users can configure
+	ValuePorn         = "porn"
+	ValueSexual       = "sexual"
+	ValueNudity       = "nudity"
+	ValueGraphicMedia = "graphic-media"
+
+	// Deprecated: replaced by ValueGraphicMedia
+	ValueGore = "gore"
+)
+
+// GlobalValues lists the label values with protocol-wide meaning
+var GlobalValues = []string{
+	ValueHide,
+	ValueWarn,
+	ValueNoUnauthenticated,
+	ValueTakedown,
+	ValueSuspend,
+	ValuePorn,
+	ValueSexual,
+	ValueNudity,
+	ValueGraphicMedia,
+	ValueGore,
+}
+
+// SelfLabelValues lists the values accounts may apply to their own records
+var SelfLabelValues = []string{
+	ValueNoUnauthenticated,
+	ValuePorn,
+	ValueSexual,
+	ValueNudity,
+	ValueGraphicMedia,
+}
+
+// MaxValueLength is the maximum length of a label value, in bytes
+const MaxValueLength = 128
+
+var ErrInvalidValue = errors.New("invalid label value")
+
+// IsSystemValue reports whether val is a reserved system value (those with a
+// "!" prefix), which only has meaning when defined by the protocol
+func IsSystemValue(val string) bool {
+	return strings.HasPrefix(val, "!")
+}
+
+// IsGlobalValue reports whether val is one of GlobalValues
+func IsGlobalValue(val string) bool {
+	for _, v := range GlobalValues {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateValue checks the syntax of a label value. Custom values (defined by
+// individual labelers) must be lowercase ASCII letters and hyphens. System
+// values must be one of the global values.
+func ValidateValue(val string) error {
+	if val == "" {
+		return fmt.Errorf("%w: empty", ErrInvalidValue)
+	}
+	if len(val) > MaxValueLength {
+		return fmt.Errorf("%w: too long (%d bytes)", ErrInvalidValue, len(val))
+	}
+	if IsSystemValue(val) {
+		if !IsGlobalValue(val) {
+			return fmt.Errorf("%w: unknown system value %q", ErrInvalidValue, val)
+		}
+		return nil
+	}
+	for _, c := range val {
+		if !(c >= 'a' && c <= 'z') && c != '-' {
+			return fmt.Errorf("%w: %q must be lowercase letters and hyphens", ErrInvalidValue, val)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package labels
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateValue(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, v := range GlobalValues {
+		assert.NoError(ValidateValue(v), v)
+	}
+	assert.NoError(ValidateValue("spam-ish"))
+
+	for _, v := range []string{"", "!bogus", "Spam", "spam_ish", "spam1", string(make([]byte, 200))} {
+		assert.ErrorIs(ValidateValue(v), ErrInvalidValue, v)
+	}
+}
+
+func TestSelfLabels(t *testing.T) {
+	assert := assert.New(t)
+
+	post := &bsky.FeedPost{Text: "hello"}
+	assert.Nil(GetSelfLabels(post))
+	assert.NoError(AddSelfLabels(post, ValuePorn, "custom-thing"))
+	assert.NoError(AddSelfLabels(post, ValuePorn, ValueNoUnauthenticated))
+	assert.Equal([]string{ValuePorn, "custom-thing", ValueNoUnauthenticated}, GetSelfLabels(post))
+	assert.Equal("com.atproto.label.defs#selfLabels", post.Labels.LabelDefs_SelfLabels.LexiconTypeID)
+
+	// system values other than !no-unauthenticated can't be self-applied, and a
+	// failed add leaves the record alone
+	assert.ErrorIs(AddSelfLabels(post, "other", ValueTakedown), ErrInvalidValue)
+	assert.Len(GetSelfLabels(post), 3)
+
+	assert.Error(AddSelfLabels(post, "a", "b", "c", "d", "e", "f", "g", "h"))
+	assert.Len(GetSelfLabels(post), 3)
+
+	profile := &bsky.ActorProfile{}
+	assert.NoError(AddSelfLabels(profile, ValueGraphicMedia))
+	assert.Equal([]string{ValueGraphicMedia}, GetSelfLabels(profile))
+
+	assert.Error(AddSelfLabels(&bsky.FeedLike{}, ValuePorn))
+
+	// survives a round trip through JSON
+	b, err := json.Marshal(post)
+	assert.NoError(err)
+	var out bsky.FeedPost
+	assert.NoError(json.Unmarshal(b, &out))
+	assert.Equal(GetSelfLabels(post), GetSelfLabels(&out))
+}
+
+func testLabel() *comatproto.LabelDefs_Label {
+	return &comatproto.LabelDefs_Label{
+		Src: "did:plc:labeler123",
+		Uri: "at://did:plc:abc111/app.bsky.feed.post/3kabc",
+		Val: "spam",
+		Cts: "2024-06-01T12:00:00.000Z",
+	}
+}
+
+func TestParseLabel(t *testing.T) {
+	assert := assert.New(t)
+
+	l := testLabel()
+	b, err := json.Marshal(l)
+	assert.NoError(err)
+	parsed, err := ParseJSON(b)
+	assert.NoError(err)
+	assert.Equal(l.Val, parsed.Val)
+
+	var buf bytes.Buffer
+	assert.NoError(l.MarshalCBOR(&buf))
+	parsed, err = ParseCBOR(buf.Bytes())
+	assert.NoError(err)
+	assert.Equal(l.Uri, parsed.Uri)
+
+	// account-level labels have a DID subject
+	l.Uri = "did:plc:abc111"
+	assert.NoError(Validate(l))
+
+	for _, mod := range []func(*comatproto.LabelDefs_Label){
+		func(l *comatproto.LabelDefs_Label) { l.Src = "labeler" },
+		func(l *comatproto.LabelDefs_Label) { l.Uri = "https://example.com" },
+		func(l *comatproto.LabelDefs_Label) { l.Cts = "yesterday" },
+		func(l *comatproto.LabelDefs_Label) { l.Val = "Not Valid" },
+	} {
+		l := testLabel()
+		mod(l)
+		assert.ErrorIs(Validate(l), ErrInvalidLabel)
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	priv, err := crypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := priv.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := testLabel()
+	assert.ErrorIs(Verify(l, pub), ErrUnsigned)
+	assert.NoError(Sign(l, priv))
+	assert.Equal(int64(LabelVersion), *l.Ver)
+	assert.NoError(Verify(l, pub))
+
+	// signature survives a CBOR round trip
+	var buf bytes.Buffer
+	assert.NoError(l.MarshalCBOR(&buf))
+	parsed, err := ParseCBOR(buf.Bytes())
+	assert.NoError(err)
+	assert.NoError(Verify(parsed, pub))
+
+	tampered := *l
+	tampered.Val = "not-spam"
+	assert.ErrorIs(Verify(&tampered, pub), ErrBadSignature)
+
+	other, err := crypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, err := other.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.ErrorIs(Verify(l, otherPub), ErrBadSignature)
+
+	dir := identity.NewMockDirectory()
+	dir.Insert(identity.Identity{
+		DID: syntax.DID(l.Src),
+		Keys: map[string]identity.Key{
+			LabelerKeyID: {Type: "Multikey", PublicKeyMultibase: pub.Multibase()},
+		},
+	})
+	assert.NoError(VerifyWithDirectory(ctx, &dir, l))
+	assert.ErrorIs(VerifyWithDirectory(ctx, &dir, &tampered), ErrBadSignature)
+
+	unknown := *l
+	unknown.Src = "did:plc:unknown"
+	assert.Error(VerifyWithDirectory(ctx, &dir, &unknown))
+}
@@ -0,0 +1,151 @@
+package labels
+
+import (
+	"fmt"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+)
+
+// MaxSelfLabels is the most self-labels a record may carry
+const MaxSelfLabels = 10
+
+// IsSelfLabelValue reports whether val is one of SelfLabelValues
+func IsSelfLabelValue(val string) bool {
+	for _, v := range SelfLabelValues {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
+
+// NewSelfLabels builds a self-labels object from the given values, which must
+// be valid, and either SelfLabelValues or custom (non-system) values.
+// Duplicates are dropped.
+func NewSelfLabels(vals ...string) (*comatproto.LabelDefs_SelfLabels, error) {
+	sl := &comatproto.LabelDefs_SelfLabels{
+		LexiconTypeID: "com.atproto.label.defs#selfLabels",
+		Values:        []*comatproto.LabelDefs_SelfLabel{},
+	}
+	if err := addSelfLabels(sl, vals); err != nil {
+		return nil, err
+	}
+	return sl, nil
+}
+
+func addSelfLabels(sl *comatproto.LabelDefs_SelfLabels, vals []string) error {
+	seen := make(map[string]bool, len(sl.Values))
+	for _, v := range sl.Values {
+		seen[v.Val] = true
+	}
+	// validate everything before touching the record
+	var added []*comatproto.LabelDefs_SelfLabel
+	for _, val := range vals {
+		if err := ValidateValue(val); err != nil {
+			return err
+		}
+		if IsSystemValue(val) && !IsSelfLabelValue(val) {
+			return fmt.Errorf("%w: %q can't be self-applied", ErrInvalidValue, val)
+		}
+		if seen[val] {
+			continue
+		}
+		seen[val] = true
+		added = append(added, &comatproto.LabelDefs_SelfLabel{Val: val})
+	}
+	if n := len(sl.Values) + len(added); n > MaxSelfLabels {
+		return fmt.Errorf("too many self-labels (%d, max %d)", n, MaxSelfLabels)
+	}
+	sl.Values = append(sl.Values, added...)
+	return nil
+}
+
+// selfLabelsOf returns a pointer to the self-labels field of a record which
+// supports them, or an error for other record types
+func selfLabelsOf(rec any) (**comatproto.LabelDefs_SelfLabels, error) {
+	switch r := rec.(type) {
+	case *bsky.FeedPost:
+		if r.Labels == nil {
+			r.Labels = &bsky.FeedPost_Labels{}
+		}
+		return &r.Labels.LabelDefs_SelfLabels, nil
+	case *bsky.ActorProfile:
+		if r.Labels == nil {
+			r.Labels = &bsky.ActorProfile_Labels{}
+		}
+		return &r.Labels.LabelDefs_SelfLabels, nil
+	case *bsky.FeedGenerator:
+		if r.Labels == nil {
+			r.Labels = &bsky.FeedGenerator_Labels{}
+		}
+		return &r.Labels.LabelDefs_SelfLabels, nil
+	case *bsky.GraphList:
+		if r.Labels == nil {
+			r.Labels = &bsky.GraphList_Labels{}
+		}
+		return &r.Labels.LabelDefs_SelfLabels, nil
+	case *bsky.LabelerService:
+		if r.Labels == nil {
+			r.Labels = &bsky.LabelerService_Labels{}
+		}
+		return &r.Labels.LabelDefs_SelfLabels, nil
+	default:
+		return nil, fmt.Errorf("record type %T does not support self-labels", rec)
+	}
+}
+
+// AddSelfLabels attaches self-labels to a record being created, keeping any
+// it already has. Supported records are posts, profiles, feed generators,
+// lists, and labeler services (as pointers).
+func AddSelfLabels(rec any, vals ...string) error {
+	field, err := selfLabelsOf(rec)
+	if err != nil {
+		return err
+	}
+	if *field == nil {
+		sl, err := NewSelfLabels(vals...)
+		if err != nil {
+			return err
+		}
+		*field = sl
+		return nil
+	}
+	return addSelfLabels(*field, vals)
+}
+
+// GetSelfLabels returns the self-label values of a record, or nil if it has
+// none (or doesn't support them)
+func GetSelfLabels(rec any) []string {
+	var sl *comatproto.LabelDefs_SelfLabels
+	switch r := rec.(type) {
+	case *bsky.FeedPost:
+		if r.Labels != nil {
+			sl = r.Labels.LabelDefs_SelfLabels
+		}
+	case *bsky.ActorProfile:
+		if r.Labels != nil {
+			sl = r.Labels.LabelDefs_SelfLabels
+		}
+	case *bsky.FeedGenerator:
+		if r.Labels != nil {
+			sl = r.Labels.LabelDefs_SelfLabels
+		}
+	case *bsky.GraphList:
+		if r.Labels != nil {
+			sl = r.Labels.LabelDefs_SelfLabels
+		}
+	case *bsky.LabelerService:
+		if r.Labels != nil {
+			sl = r.Labels.LabelDefs_SelfLabels
+		}
+	}
+	if sl == nil {
+		return nil
+	}
+	out := make([]string, 0, len(sl.Values))
+	for _, v := range sl.Values {
+		out = append(out, v.Val)
+	}
+	return out
+}
@@ -0,0 +1,163 @@
+package labels
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// LabelVersion is the current version of the label object format
+const LabelVersion = 1
+
+// LabelerKeyID is the DID document verification method ID of labeler signing
+// keys
+const LabelerKeyID = "atproto_label"
+
+var (
+	ErrInvalidLabel = errors.New("invalid label")
+	ErrUnsigned     = errors.New("label is not signed")
+	ErrBadSignature = errors.New("label signature does not verify")
+)
+
+// Validate checks the fields of a label object: the source is a DID, the
+// subject an AT-URI or DID, timestamps are valid datetimes, and the value is
+// valid. It doesn't check the signature.
+func Validate(l *comatproto.LabelDefs_Label) error {
+	if _, err := syntax.ParseDID(l.Src); err != nil {
+		return fmt.Errorf("%w: src: %w", ErrInvalidLabel, err)
+	}
+	if strings.HasPrefix(l.Uri, "did:") {
+		if _, err := syntax.ParseDID(l.Uri); err != nil {
+			return fmt.Errorf("%w: uri: %w", ErrInvalidLabel, err)
+		}
+	} else if _, err := syntax.ParseATURI(l.Uri); err != nil {
+		return fmt.Errorf("%w: uri: %w", ErrInvalidLabel, err)
+	}
+	if l.Cid != nil {
+		if _, err := syntax.ParseCID(*l.Cid); err != nil {
+			return fmt.Errorf("%w: cid: %w", ErrInvalidLabel, err)
+		}
+	}
+	if _, err := syntax.ParseDatetimeLenient(l.Cts); err != nil {
+		return fmt.Errorf("%w: cts: %w", ErrInvalidLabel, err)
+	}
+	if l.Exp != nil {
+		if _, err := syntax.ParseDatetimeLenient(*l.Exp); err != nil {
+			return fmt.Errorf("%w: exp: %w", ErrInvalidLabel, err)
+		}
+	}
+	if err := ValidateValue(l.Val); err != nil {
+		return fmt.Errorf("%w: val: %w", ErrInvalidLabel, err)
+	}
+	return nil
+}
+
+// ParseJSON parses and validates a label object in JSON form (as returned by
+// queryLabels, for example)
+func ParseJSON(b []byte) (*comatproto.LabelDefs_Label, error) {
+	var l comatproto.LabelDefs_Label
+	if err := json.Unmarshal(b, &l); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidLabel, err)
+	}
+	if err := Validate(&l); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// ParseCBOR parses and validates a label object in DAG-CBOR form (as sent on
+// subscribeLabels streams, for example)
+func ParseCBOR(b []byte) (*comatproto.LabelDefs_Label, error) {
+	var l comatproto.LabelDefs_Label
+	if err := l.UnmarshalCBOR(bytes.NewReader(b)); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidLabel, err)
+	}
+	if err := Validate(&l); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// signingBytes is the DAG-CBOR encoding of the label without its signature,
+// which is what gets signed
+func signingBytes(l *comatproto.LabelDefs_Label) ([]byte, error) {
+	unsigned := *l
+	unsigned.Sig = nil
+	var buf bytes.Buffer
+	if err := unsigned.MarshalCBOR(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Sign sets the label's version (if unset) and signs it with the labeler's
+// private key
+func Sign(l *comatproto.LabelDefs_Label, key crypto.PrivateKey) error {
+	if l.Ver == nil {
+		ver := int64(LabelVersion)
+		l.Ver = &ver
+	}
+	b, err := signingBytes(l)
+	if err != nil {
+		return err
+	}
+	sig, err := key.HashAndSign(b)
+	if err != nil {
+		return fmt.Errorf("signing label: %w", err)
+	}
+	l.Sig = sig
+	return nil
+}
+
+// Verify checks the label's signature against a labeler public key
+func Verify(l *comatproto.LabelDefs_Label, pub crypto.PublicKey) error {
+	if len(l.Sig) == 0 {
+		return ErrUnsigned
+	}
+	b, err := signingBytes(l)
+	if err != nil {
+		return err
+	}
+	if err := pub.HashAndVerify(b, l.Sig); err != nil {
+		return fmt.Errorf("%w: %w", ErrBadSignature, err)
+	}
+	return nil
+}
+
+// VerifyWithDirectory checks the label's signature against the current
+// labeler key of its source, resolved from the directory. If verification
+// fails, the source's identity is purged from any cache and re-resolved once,
+// in case the key was rotated.
+func VerifyWithDirectory(ctx context.Context, dir identity.Directory, l *comatproto.LabelDefs_Label) error {
+	did, err := syntax.ParseDID(l.Src)
+	if err != nil {
+		return fmt.Errorf("%w: src: %w", ErrInvalidLabel, err)
+	}
+	verify := func() error {
+		ident, err := dir.LookupDID(ctx, did)
+		if err != nil {
+			return fmt.Errorf("resolving labeler identity: %w", err)
+		}
+		pub, err := ident.GetPublicKey(LabelerKeyID)
+		if err != nil {
+			return fmt.Errorf("labeler signing key: %w", err)
+		}
+		return Verify(l, pub)
+	}
+	err = verify()
+	if err == nil || errors.Is(err, ErrUnsigned) {
+		return err
+	}
+	if perr := dir.Purge(ctx, did.AtIdentifier()); perr != nil {
+		return err
+	}
+	return verify()
+}
@@ -29,6 +29,8 @@ type BaseDirectory struct {
 	SkipDNSDomainSuffixes []string
 	// set of fallback DNS servers (eg, domain registrars) to try as a fallback. each entry should be "ip:port", eg "8.8.8.8:53"
 	FallbackDNSServers []string
+	// If not nil, notified of each individual handle and DID resolution attempt (eg, for telemetry)
+	Observer ResolutionObserver
 }
 
 var _ Directory = (*BaseDirectory)(nil)
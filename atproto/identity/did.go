@@ -38,12 +38,16 @@ func (d *BaseDirectory) ResolveDID(ctx context.Context, did syntax.DID) (*DIDDoc
 	start := time.Now()
 	switch did.Method() {
 	case "web":
-		doc, err := d.ResolveDIDWeb(ctx, did)
+		doc, err := observeResolution(ctx, d.Observer, did.String(), SourceWeb, func() (*DIDDocument, error) {
+			return d.ResolveDIDWeb(ctx, did)
+		})
 		elapsed := time.Since(start)
 		slog.Debug("resolve DID", "did", did, "err", err, "duration_ms", elapsed.Milliseconds())
 		return doc, err
 	case "plc":
-		doc, err := d.ResolveDIDPLC(ctx, did)
+		doc, err := observeResolution(ctx, d.Observer, did.String(), SourcePLC, func() (*DIDDocument, error) {
+			return d.ResolveDIDPLC(ctx, did)
+		})
 		elapsed := time.Since(start)
 		slog.Debug("resolve DID", "did", did, "err", err, "duration_ms", elapsed.Milliseconds())
 		return doc, err
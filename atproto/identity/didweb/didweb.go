@@ -0,0 +1,241 @@
+package didweb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// Path at which did:web documents are resolved, relative to the hostname
+const WellKnownPath = "/.well-known/did.json"
+
+// Service and key IDs (DID document fragments) and service types used by atproto services
+const (
+	FeedGeneratorServiceID   = "bsky_fg"
+	FeedGeneratorServiceType = "BskyFeedGenerator"
+	LabelerServiceID         = "atproto_labeler"
+	LabelerServiceType       = "AtprotoLabeler"
+	AppViewServiceID         = "bsky_appview"
+	AppViewServiceType       = "BskyAppView"
+	PDSServiceID             = "atproto_pds"
+	PDSServiceType           = "AtprotoPersonalDataServer"
+
+	AtprotoKeyID = "atproto"
+	LabelerKeyID = "atproto_label"
+)
+
+var ErrInvalidDocument = errors.New("invalid did:web document")
+
+type Config struct {
+	// Hostname the service is served on (no port or path); the DID is did:web:<hostname>
+	Hostname string
+	// Optional alsoKnownAs URIs (eg, "at://<handle>")
+	AlsoKnownAs []string
+	Keys        []Key
+	Services    []Service
+}
+
+// Key is a public key published in the DID document, as a verification method
+type Key struct {
+	// Fragment part of the verification method ID, without the hash (eg, "atproto_label")
+	ID        string
+	PublicKey crypto.PublicKey
+}
+
+// Service is a service endpoint entry in the DID document
+type Service struct {
+	// Fragment part of the service ID, without the hash (eg, "bsky_fg")
+	ID   string
+	Type string
+	// HTTPS URL of the service, usually "https://<hostname>"
+	Endpoint string
+}
+
+func FeedGeneratorService(endpoint string) Service {
+	return Service{ID: FeedGeneratorServiceID, Type: FeedGeneratorServiceType, Endpoint: endpoint}
+}
+
+func LabelerService(endpoint string) Service {
+	return Service{ID: LabelerServiceID, Type: LabelerServiceType, Endpoint: endpoint}
+}
+
+func AppViewService(endpoint string) Service {
+	return Service{ID: AppViewServiceID, Type: AppViewServiceType, Endpoint: endpoint}
+}
+
+// DIDForHostname returns the did:web DID for a service hostname, checking that the hostname is one which would be accepted when resolving the DID
+func DIDForHostname(hostname string) (syntax.DID, error) {
+	handle, err := syntax.ParseHandle(hostname)
+	if err != nil {
+		return "", fmt.Errorf("did:web hostname not a simple hostname: %s", hostname)
+	}
+	if !handle.AllowedTLD() {
+		return "", fmt.Errorf("did:web hostname has disallowed TLD: %s", hostname)
+	}
+	return syntax.ParseDID("did:web:" + handle.Normalize().String())
+}
+
+// Document is a validated did:web DID document, ready to serve. It is immutable, and safe for concurrent use.
+type Document struct {
+	did  syntax.DID
+	doc  identity.DIDDocument
+	body []byte
+}
+
+// JSON form, with the JSON-LD context which identity.DIDDocument omits
+type jsonDocument struct {
+	Context []string `json:"@context"`
+	identity.DIDDocument
+}
+
+var didContext = []string{
+	"https://www.w3.org/ns/did/v1",
+	"https://w3id.org/security/multikey/v1",
+}
+
+// New builds a DID document from the config, and checks that it parses back to the same keys and services
+func New(conf Config) (*Document, error) {
+	did, err := DIDForHostname(conf.Hostname)
+	if err != nil {
+		return nil, err
+	}
+	doc := identity.DIDDocument{
+		DID:         did,
+		AlsoKnownAs: conf.AlsoKnownAs,
+	}
+	for _, uri := range conf.AlsoKnownAs {
+		if _, err := syntax.ParseURI(uri); err != nil {
+			return nil, fmt.Errorf("%w: alsoKnownAs: %w", ErrInvalidDocument, err)
+		}
+	}
+	for _, k := range conf.Keys {
+		if err := checkFragment(k.ID); err != nil {
+			return nil, fmt.Errorf("%w: key: %w", ErrInvalidDocument, err)
+		}
+		if k.PublicKey == nil {
+			return nil, fmt.Errorf("%w: key %s: missing public key", ErrInvalidDocument, k.ID)
+		}
+		doc.VerificationMethod = append(doc.VerificationMethod, identity.DocVerificationMethod{
+			ID:                 did.String() + "#" + k.ID,
+			Type:               "Multikey",
+			Controller:         did.String(),
+			PublicKeyMultibase: k.PublicKey.Multibase(),
+		})
+	}
+	for _, s := range conf.Services {
+		if err := checkFragment(s.ID); err != nil {
+			return nil, fmt.Errorf("%w: service: %w", ErrInvalidDocument, err)
+		}
+		if s.Type == "" {
+			return nil, fmt.Errorf("%w: service %s: missing type", ErrInvalidDocument, s.ID)
+		}
+		u, err := url.Parse(s.Endpoint)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, fmt.Errorf("%w: service %s: endpoint must be an HTTP(S) URL: %q", ErrInvalidDocument, s.ID, s.Endpoint)
+		}
+		doc.Service = append(doc.Service, identity.DocService{
+			ID:              "#" + s.ID,
+			Type:            s.Type,
+			ServiceEndpoint: s.Endpoint,
+		})
+	}
+
+	body, err := json.MarshalIndent(jsonDocument{Context: didContext, DIDDocument: doc}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	d := &Document{did: did, doc: doc, body: body}
+	if err := d.validate(conf); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func checkFragment(id string) error {
+	if id == "" || strings.ContainsAny(id, "#/?: ") {
+		return fmt.Errorf("invalid ID fragment: %q", id)
+	}
+	return nil
+}
+
+// round-trips the serialized document through the identity parser, the way a resolving client would see it
+func (d *Document) validate(conf Config) error {
+	var parsed identity.DIDDocument
+	if err := json.Unmarshal(d.body, &parsed); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidDocument, err)
+	}
+	ident := identity.ParseIdentity(&parsed)
+	if len(ident.Keys) != len(conf.Keys) {
+		return fmt.Errorf("%w: duplicate key IDs", ErrInvalidDocument)
+	}
+	if len(ident.Services) != len(conf.Services) {
+		return fmt.Errorf("%w: duplicate service IDs", ErrInvalidDocument)
+	}
+	for _, k := range conf.Keys {
+		pub, err := ident.GetPublicKey(k.ID)
+		if err != nil {
+			return fmt.Errorf("%w: key %s: %w", ErrInvalidDocument, k.ID, err)
+		}
+		if !pub.Equal(k.PublicKey) {
+			return fmt.Errorf("%w: key %s did not round-trip", ErrInvalidDocument, k.ID)
+		}
+	}
+	for _, s := range conf.Services {
+		if ident.GetServiceEndpoint(s.ID) != s.Endpoint {
+			return fmt.Errorf("%w: service %s did not round-trip", ErrInvalidDocument, s.ID)
+		}
+	}
+	return nil
+}
+
+// DID is the did:web DID of the document
+func (d *Document) DID() syntax.DID {
+	return d.did
+}
+
+// ServiceRef is the DID with a service fragment (eg, "did:web:example.com#bsky_fg"), as used in service proxying and service auth
+func (d *Document) ServiceRef(serviceID string) string {
+	return d.did.String() + "#" + serviceID
+}
+
+// DIDDocument returns a copy of the document
+func (d *Document) DIDDocument() identity.DIDDocument {
+	doc := d.doc
+	doc.AlsoKnownAs = append([]string(nil), d.doc.AlsoKnownAs...)
+	doc.VerificationMethod = append([]identity.DocVerificationMethod(nil), d.doc.VerificationMethod...)
+	doc.Service = append([]identity.DocService(nil), d.doc.Service...)
+	return doc
+}
+
+// Identity returns the document as parsed by a resolving client
+func (d *Document) Identity() identity.Identity {
+	doc := d.DIDDocument()
+	return identity.ParseIdentity(&doc)
+}
+
+func (d *Document) MarshalJSON() ([]byte, error) {
+	return d.body, nil
+}
+
+// ServeHTTP serves the DID document as JSON. Mount it at [WellKnownPath].
+func (d *Document) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		w.Write(d.body)
+	}
+}
@@ -0,0 +1,88 @@
+package didweb
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDocument(t *testing.T) {
+	assert := assert.New(t)
+
+	priv, err := crypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := priv.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := New(Config{
+		Hostname:    "Labeler.Example.com",
+		AlsoKnownAs: []string{"at://labeler.example.com"},
+		Keys:        []Key{{ID: LabelerKeyID, PublicKey: pub}},
+		Services:    []Service{LabelerService("https://labeler.example.com")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal("did:web:labeler.example.com", doc.DID().String())
+	assert.Equal("did:web:labeler.example.com#atproto_labeler", doc.ServiceRef(LabelerServiceID))
+
+	rec := httptest.NewRecorder()
+	doc.ServeHTTP(rec, httptest.NewRequest("GET", WellKnownPath, nil))
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Equal("application/json", rec.Header().Get("Content-Type"))
+
+	var raw map[string]any
+	assert.NoError(json.Unmarshal(rec.Body.Bytes(), &raw))
+	assert.Contains(raw, "@context")
+
+	var served identity.DIDDocument
+	assert.NoError(json.Unmarshal(rec.Body.Bytes(), &served))
+	ident := identity.ParseIdentity(&served)
+	got, err := ident.GetPublicKey(LabelerKeyID)
+	assert.NoError(err)
+	assert.True(pub.Equal(got))
+	assert.Equal("https://labeler.example.com", ident.GetServiceEndpoint(LabelerServiceID))
+	h, err := ident.DeclaredHandle()
+	assert.NoError(err)
+	assert.Equal("labeler.example.com", h.String())
+	assert.Equal(ident, doc.Identity())
+
+	rec = httptest.NewRecorder()
+	doc.ServeHTTP(rec, httptest.NewRequest("POST", WellKnownPath, nil))
+	assert.Equal(http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestDocumentInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	fg := FeedGeneratorService("https://feeds.example.com")
+	bad := []Config{
+		{Hostname: "localhost"},
+		{Hostname: "feeds.example.com:8080"},
+		{Hostname: "feeds.example.local"},
+		{Hostname: "feeds.example.com", AlsoKnownAs: []string{"not a uri"}},
+		{Hostname: "feeds.example.com", Keys: []Key{{ID: "atproto"}}},
+		{Hostname: "feeds.example.com", Services: []Service{{ID: "#bsky_fg", Type: fg.Type, Endpoint: fg.Endpoint}}},
+		{Hostname: "feeds.example.com", Services: []Service{{ID: fg.ID, Type: fg.Type, Endpoint: "feeds.example.com"}}},
+		{Hostname: "feeds.example.com", Services: []Service{{ID: fg.ID, Endpoint: fg.Endpoint}}},
+		{Hostname: "feeds.example.com", Services: []Service{fg, fg}},
+	}
+	for _, conf := range bad {
+		_, err := New(conf)
+		assert.Error(err, conf)
+	}
+
+	doc, err := New(Config{Hostname: "feeds.example.com", Services: []Service{fg}})
+	assert.NoError(err)
+	assert.Len(doc.DIDDocument().Service, 1)
+}
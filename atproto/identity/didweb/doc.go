@@ -0,0 +1,14 @@
+/*
+Package didweb helps services (feed generators, labelers, AppViews, etc) publish their own did:web identity.
+
+A Document is built from a hostname, plus any public keys and service endpoints the service should declare, and is checked against the same parsing rules used when resolving identities (see [identity.ParseIdentity]). The Document is an http.Handler which serves the DID document; mount it at [WellKnownPath] on the service's hostname:
+
+	doc, err := didweb.New(didweb.Config{
+		Hostname: "feeds.example.com",
+		Services: []didweb.Service{didweb.FeedGeneratorService("https://feeds.example.com")},
+	})
+	http.Handle(didweb.WellKnownPath, doc)
+
+With echo, use echo.WrapHandler(doc).
+*/
+package didweb
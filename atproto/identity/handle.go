@@ -188,18 +188,24 @@ func (d *BaseDirectory) ResolveHandle(ctx context.Context, handle syntax.Handle)
 		start := time.Now()
 		triedAuthoritative := false
 		triedFallback := false
-		did, dnsErr = d.ResolveHandleDNS(ctx, handle)
+		did, dnsErr = observeResolution(ctx, d.Observer, handle.String(), SourceDNS, func() (syntax.DID, error) {
+			return d.ResolveHandleDNS(ctx, handle)
+		})
 		if errors.Is(dnsErr, ErrHandleNotFound) && d.TryAuthoritativeDNS {
 			slog.Debug("attempting authoritative handle DNS resolution", "handle", handle)
 			triedAuthoritative = true
 			// try harder with authoritative lookup
-			did, dnsErr = d.ResolveHandleDNSAuthoritative(ctx, handle)
+			did, dnsErr = observeResolution(ctx, d.Observer, handle.String(), SourceDNSAuthoritative, func() (syntax.DID, error) {
+				return d.ResolveHandleDNSAuthoritative(ctx, handle)
+			})
 		}
 		if errors.Is(dnsErr, ErrHandleNotFound) && len(d.FallbackDNSServers) > 0 {
 			slog.Debug("attempting fallback DNS resolution", "handle", handle)
 			triedFallback = true
 			// try harder with fallback lookup
-			did, dnsErr = d.ResolveHandleDNSFallback(ctx, handle)
+			did, dnsErr = observeResolution(ctx, d.Observer, handle.String(), SourceDNSFallback, func() (syntax.DID, error) {
+				return d.ResolveHandleDNSFallback(ctx, handle)
+			})
 		}
 		elapsed := time.Since(start)
 		slog.Debug("resolve handle DNS", "handle", handle, "err", dnsErr, "did", did, "authoritative", triedAuthoritative, "fallback", triedFallback, "duration_ms", elapsed.Milliseconds())
@@ -209,7 +215,9 @@ func (d *BaseDirectory) ResolveHandle(ctx context.Context, handle syntax.Handle)
 	}
 
 	start := time.Now()
-	did, httpErr := d.ResolveHandleWellKnown(ctx, handle)
+	did, httpErr := observeResolution(ctx, d.Observer, handle.String(), SourceWellKnown, func() (syntax.DID, error) {
+		return d.ResolveHandleWellKnown(ctx, handle)
+	})
 	elapsed := time.Since(start)
 	slog.Debug("resolve handle HTTP well-known", "handle", handle, "err", httpErr, "did", did, "duration_ms", elapsed.Milliseconds())
 	if nil == httpErr { // if *not* an error
@@ -0,0 +1,219 @@
+/*
+Package handlepolicy implements configurable rules for which handles a hosting service (eg, a PDS) will allocate to accounts.
+
+Checks include syntax and per-domain length rules, reserved words, offensive terms, and "confusable" handles which look visually similar to a handle already in use.
+*/
+package handlepolicy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// Reasons a handle may be rejected, used as PolicyError.Reason
+const (
+	ReasonInvalid    = "invalid"
+	ReasonDomain     = "domain-not-allowed"
+	ReasonLength     = "length"
+	ReasonHyphen     = "hyphen"
+	ReasonReserved   = "reserved"
+	ReasonOffensive  = "offensive"
+	ReasonConfusable = "confusable"
+)
+
+// A baseline set of labels which services commonly keep for themselves, or which could be used to impersonate operators
+var DefaultReservedLabels = []string{
+	"abuse",
+	"admin",
+	"administrator",
+	"api",
+	"help",
+	"hostmaster",
+	"mod",
+	"moderator",
+	"noreply",
+	"official",
+	"postmaster",
+	"root",
+	"security",
+	"staff",
+	"support",
+	"system",
+	"webmaster",
+	"www",
+}
+
+// Returned when a handle is not allowed by policy. Messages are safe to show to end users.
+type PolicyError struct {
+	Reason  string
+	Message string
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("handle not allowed (%s): %s", e.Reason, e.Message)
+}
+
+// Returns true if the error indicates a handle policy rejection (as opposed to an internal error)
+func IsPolicyError(err error) bool {
+	var pe *PolicyError
+	return errors.As(err, &pe)
+}
+
+// Rules for handles under a single domain suffix
+type DomainRule struct {
+	// Minimum and maximum length of the label (the part of the handle before the domain suffix). Zero for no limit
+	MinLength int
+	MaxLength int
+	// If true, labels may not start or end with a hyphen, or contain consecutive hyphens
+	StrictHyphens bool
+}
+
+// An existing handle, and the account which has it
+type ExistingHandle struct {
+	Handle syntax.Handle
+	DID    syntax.DID
+}
+
+// Store provides the state needed for policy checks
+type Store interface {
+	// Returns true if the exact handle has been reserved by an administrator
+	IsReserved(ctx context.Context, handle syntax.Handle) (bool, error)
+	// Returns existing handles under the given domain suffix with the given label skeleton (see Skeleton)
+	HandlesWithSkeleton(ctx context.Context, domain, skeleton string) ([]ExistingHandle, error)
+}
+
+type Policy struct {
+	// Domain suffixes (including leading period, eg ".example.com") which handles may be allocated under, and rules for each
+	Domains map[string]DomainRule
+	// Labels which may not be used under any domain, eg "admin" or "support". Compared case-insensitively, and also by skeleton
+	ReservedLabels []string
+	// Terms which may not appear anywhere in a label (after removing hyphens and normalizing case)
+	OffensiveTerms []string
+	// Optional additional offensive content check (eg, a slur regex). Returns a non-empty string describing the match if the label should be rejected
+	OffensiveCheck func(label string) string
+	// Optional; without a store, reservation and confusable checks are skipped
+	Store Store
+
+	reservedOnce      sync.Once
+	reservedSkeletons map[string]bool
+}
+
+// Returns the configured domain suffix for a handle (the longest match), or an empty string if none match
+func (p *Policy) domainFor(handle syntax.Handle) string {
+	h := handle.Normalize().String()
+	var best string
+	for d := range p.Domains {
+		if strings.HasSuffix(h, strings.ToLower(d)) && len(d) > len(best) {
+			best = d
+		}
+	}
+	return best
+}
+
+// Check returns nil if the handle may be allocated to the account with the given DID. The DID may be empty for new accounts; existing handles belonging to the same DID are never considered confusable.
+//
+// Policy rejections are returned as *PolicyError; other errors indicate a failure to check.
+func (p *Policy) Check(ctx context.Context, raw string, did syntax.DID) error {
+	handle, err := syntax.ParseHandle(raw)
+	if err != nil {
+		return &PolicyError{Reason: ReasonInvalid, Message: err.Error()}
+	}
+	handle = handle.Normalize()
+
+	domain := p.domainFor(handle)
+	if domain == "" {
+		return &PolicyError{Reason: ReasonDomain, Message: "handle must be under one of the domains served by this service"}
+	}
+	rule := p.Domains[domain]
+	label := strings.TrimSuffix(handle.String(), strings.ToLower(domain))
+	if label == "" || strings.Contains(label, ".") {
+		return &PolicyError{Reason: ReasonInvalid, Message: "handle must be a single name followed by the service domain"}
+	}
+	if rule.MinLength > 0 && len(label) < rule.MinLength {
+		return &PolicyError{Reason: ReasonLength, Message: fmt.Sprintf("handle must be at least %d characters", rule.MinLength)}
+	}
+	if rule.MaxLength > 0 && len(label) > rule.MaxLength {
+		return &PolicyError{Reason: ReasonLength, Message: fmt.Sprintf("handle must be at most %d characters", rule.MaxLength)}
+	}
+	if rule.StrictHyphens && (strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") || strings.Contains(label, "--")) {
+		return &PolicyError{Reason: ReasonHyphen, Message: "handle may not start or end with a hyphen, or contain consecutive hyphens"}
+	}
+
+	skel := Skeleton(label)
+	p.reservedOnce.Do(func() {
+		p.reservedSkeletons = make(map[string]bool, len(p.ReservedLabels))
+		for _, r := range p.ReservedLabels {
+			p.reservedSkeletons[Skeleton(r)] = true
+		}
+	})
+	if p.reservedSkeletons[skel] {
+		return &PolicyError{Reason: ReasonReserved, Message: "handle is reserved"}
+	}
+
+	flat := strings.ReplaceAll(label, "-", "")
+	for _, term := range p.OffensiveTerms {
+		if strings.Contains(flat, strings.ToLower(term)) {
+			return &PolicyError{Reason: ReasonOffensive, Message: "handle contains a disallowed term"}
+		}
+	}
+	if p.OffensiveCheck != nil && p.OffensiveCheck(label) != "" {
+		return &PolicyError{Reason: ReasonOffensive, Message: "handle contains a disallowed term"}
+	}
+
+	if p.Store == nil {
+		return nil
+	}
+	reserved, err := p.Store.IsReserved(ctx, handle)
+	if err != nil {
+		return fmt.Errorf("checking handle reservations: %w", err)
+	}
+	if reserved {
+		return &PolicyError{Reason: ReasonReserved, Message: "handle is reserved"}
+	}
+
+	existing, err := p.Store.HandlesWithSkeleton(ctx, domain, skel)
+	if err != nil {
+		return fmt.Errorf("checking confusable handles: %w", err)
+	}
+	for _, e := range existing {
+		if did != "" && e.DID == did {
+			continue
+		}
+		if e.Handle.Normalize() == handle {
+			// exact collisions are an availability problem, not a policy one; leave them to the caller
+			continue
+		}
+		return &PolicyError{Reason: ReasonConfusable, Message: fmt.Sprintf("handle is too similar to an existing handle (%s)", e.Handle)}
+	}
+	return nil
+}
+
+// ASCII sequences which are easily confused with other sequences, and what they are normalized to. Multi-character sequences are replaced first.
+var confusableSequences = []struct {
+	from, to string
+}{
+	{"rn", "m"},
+	{"vv", "w"},
+	{"cl", "d"},
+	{"0", "o"},
+	{"1", "l"},
+	{"i", "l"},
+	{"5", "s"},
+	{"8", "b"},
+}
+
+// Skeleton returns a normalized form of a handle label, such that labels which are easily visually confused (eg "paypal" and "paypa1", or "modern" and "rnodern") have the same skeleton. Hyphens are ignored.
+//
+// Handles are restricted to ASCII, so this only covers ASCII confusables, not the full Unicode confusables table.
+func Skeleton(label string) string {
+	s := strings.ToLower(strings.ReplaceAll(label, "-", ""))
+	for _, c := range confusableSequences {
+		s = strings.ReplaceAll(s, c.from, c.to)
+	}
+	return s
+}
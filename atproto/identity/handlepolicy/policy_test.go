@@ -0,0 +1,102 @@
+package handlepolicy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type memStore struct {
+	reserved map[syntax.Handle]bool
+	handles  []ExistingHandle
+}
+
+func (m *memStore) IsReserved(ctx context.Context, handle syntax.Handle) (bool, error) {
+	return m.reserved[handle], nil
+}
+
+func (m *memStore) HandlesWithSkeleton(ctx context.Context, domain, skeleton string) ([]ExistingHandle, error) {
+	var out []ExistingHandle
+	for _, h := range m.handles {
+		label := h.Handle.String()[:len(h.Handle.String())-len(domain)]
+		if Skeleton(label) == skeleton {
+			out = append(out, h)
+		}
+	}
+	return out, nil
+}
+
+func TestSkeleton(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(Skeleton("paypal"), Skeleton("paypa1"))
+	assert.Equal(Skeleton("paypal"), Skeleton("PayPaI"))
+	assert.Equal(Skeleton("modern"), Skeleton("rnodern"))
+	assert.Equal(Skeleton("bob"), Skeleton("b-o-b"))
+	assert.Equal(Skeleton("google"), Skeleton("g00gle"))
+	assert.NotEqual(Skeleton("alice"), Skeleton("alicia"))
+}
+
+func TestPolicyCheck(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	store := &memStore{
+		reserved: map[syntax.Handle]bool{"vip.example.com": true},
+		handles: []ExistingHandle{
+			{Handle: "alice.example.com", DID: "did:plc:alice"},
+			{Handle: "alice.other.com", DID: "did:plc:alice2"},
+		},
+	}
+	p := &Policy{
+		Domains: map[string]DomainRule{
+			".example.com": {MinLength: 3, MaxLength: 20, StrictHyphens: true},
+			".other.com":   {},
+		},
+		ReservedLabels: []string{"admin", "support"},
+		OffensiveTerms: []string{"badword"},
+		Store:          store,
+	}
+
+	assert.NoError(p.Check(ctx, "bob.example.com", ""))
+	assert.NoError(p.Check(ctx, "bob.other.com", ""))
+
+	reason := func(err error) string {
+		pe, ok := err.(*PolicyError)
+		if !ok {
+			return ""
+		}
+		return pe.Reason
+	}
+	assert.Equal(ReasonInvalid, reason(p.Check(ctx, "not a handle", "")))
+	assert.Equal(ReasonDomain, reason(p.Check(ctx, "bob.elsewhere.com", "")))
+	assert.Equal(ReasonInvalid, reason(p.Check(ctx, "bob.sub.example.com", "")))
+	assert.Equal(ReasonLength, reason(p.Check(ctx, "bo.example.com", "")))
+	assert.Equal(ReasonLength, reason(p.Check(ctx, "abcdefghijklmnopqrstuvwxyz.example.com", "")))
+	assert.Equal(ReasonHyphen, reason(p.Check(ctx, "bob--x.example.com", "")))
+	assert.NoError(p.Check(ctx, "bob--x.other.com", ""))
+	assert.Equal(ReasonReserved, reason(p.Check(ctx, "admin.example.com", "")))
+	assert.Equal(ReasonReserved, reason(p.Check(ctx, "adm1n.other.com", "")))
+	assert.Equal(ReasonReserved, reason(p.Check(ctx, "vip.example.com", "")))
+	assert.NoError(p.Check(ctx, "vip.other.com", ""))
+	assert.Equal(ReasonOffensive, reason(p.Check(ctx, "my-bad-word.example.com", "")))
+
+	// confusable with an existing handle in the same domain, unless it's the same account
+	assert.Equal(ReasonConfusable, reason(p.Check(ctx, "a1ice.example.com", "")))
+	assert.Equal(ReasonConfusable, reason(p.Check(ctx, "a1ice.example.com", "did:plc:bob")))
+	assert.NoError(p.Check(ctx, "a1ice.example.com", "did:plc:alice"))
+	// exact matches are left to the caller's availability check
+	assert.NoError(p.Check(ctx, "alice.example.com", ""))
+
+	p.OffensiveCheck = func(label string) string {
+		if label == "zzz" {
+			return "zzz"
+		}
+		return ""
+	}
+	assert.Equal(ReasonOffensive, reason(p.Check(ctx, "zzz.example.com", "")))
+	assert.True(IsPolicyError(p.Check(ctx, "zzz.example.com", "")))
+}
@@ -34,3 +34,19 @@ var handleRequestsCoalesced = promauto.NewCounter(prometheus.CounterOpts{
 	Name: "atproto_directory_handle_requests_coalesced",
 	Help: "Number of handle requests coalesced",
 })
+
+var resolutionDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "atproto_directory_resolution_duration_seconds",
+	Help:    "Duration of individual handle and DID resolution attempts, by source and outcome",
+	Buckets: prometheus.ExponentialBuckets(0.005, 2, 12),
+}, []string{"source", "outcome"})
+
+var resolutionsInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "atproto_directory_resolutions_in_flight",
+	Help: "Number of handle and DID resolution attempts currently in progress, by source",
+}, []string{"source"})
+
+var strictDirectoryRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "atproto_directory_strict_rejections_total",
+	Help: "Number of identities rejected by a strict directory for failing bi-directional handle verification, by reason",
+}, []string{"reason"})
@@ -0,0 +1,118 @@
+package identity
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// Which resolution mechanism was used for a single lookup attempt
+type ResolutionSource string
+
+var (
+	SourceDNS              = ResolutionSource("dns")
+	SourceDNSAuthoritative = ResolutionSource("dns-authoritative")
+	SourceDNSFallback      = ResolutionSource("dns-fallback")
+	SourceWellKnown        = ResolutionSource("well-known")
+	SourcePLC              = ResolutionSource("plc")
+	SourceWeb              = ResolutionSource("web")
+)
+
+// Describes a single resolution attempt against a single source. A handle lookup may involve several attempts (eg, DNS then HTTP well-known), each of which is reported separately.
+type ResolutionAttempt struct {
+	// Either a handle or a DID, depending on the source
+	Subject string
+	Source  ResolutionSource
+	// The resolved DID, for handle resolution attempts which succeeded
+	Result string
+	// Nil on success
+	Err      error
+	Duration time.Duration
+}
+
+// Returns true if the attempt failed because the identifier does not exist (as opposed to a network or server error)
+func (a *ResolutionAttempt) NotFound() bool {
+	return errors.Is(a.Err, ErrHandleNotFound) || errors.Is(a.Err, ErrDIDNotFound)
+}
+
+// Hook interface for observing individual handle and DID resolution attempts made by BaseDirectory, eg to feed resolution telemetry into service metrics or detect systematic DNS or PDS failures.
+//
+// Methods are called synchronously on the resolution path, and should return quickly.
+type ResolutionObserver interface {
+	ResolutionStarted(ctx context.Context, subject string, source ResolutionSource)
+	ResolutionSucceeded(ctx context.Context, attempt ResolutionAttempt)
+	ResolutionFailed(ctx context.Context, attempt ResolutionAttempt)
+}
+
+// Fans out resolution events to multiple observers, in order
+type MultiObserver []ResolutionObserver
+
+var _ ResolutionObserver = MultiObserver(nil)
+
+func (m MultiObserver) ResolutionStarted(ctx context.Context, subject string, source ResolutionSource) {
+	for _, o := range m {
+		o.ResolutionStarted(ctx, subject, source)
+	}
+}
+
+func (m MultiObserver) ResolutionSucceeded(ctx context.Context, attempt ResolutionAttempt) {
+	for _, o := range m {
+		o.ResolutionSucceeded(ctx, attempt)
+	}
+}
+
+func (m MultiObserver) ResolutionFailed(ctx context.Context, attempt ResolutionAttempt) {
+	for _, o := range m {
+		o.ResolutionFailed(ctx, attempt)
+	}
+}
+
+// Observer which records resolution attempts in prometheus metrics, labeled by source and outcome ("ok", "not_found", or "error")
+type MetricsObserver struct{}
+
+var _ ResolutionObserver = MetricsObserver{}
+
+func (MetricsObserver) ResolutionStarted(ctx context.Context, subject string, source ResolutionSource) {
+	resolutionsInFlight.WithLabelValues(string(source)).Inc()
+}
+
+func (MetricsObserver) ResolutionSucceeded(ctx context.Context, attempt ResolutionAttempt) {
+	resolutionsInFlight.WithLabelValues(string(attempt.Source)).Dec()
+	resolutionDuration.WithLabelValues(string(attempt.Source), "ok").Observe(attempt.Duration.Seconds())
+}
+
+func (MetricsObserver) ResolutionFailed(ctx context.Context, attempt ResolutionAttempt) {
+	resolutionsInFlight.WithLabelValues(string(attempt.Source)).Dec()
+	outcome := "error"
+	if attempt.NotFound() {
+		outcome = "not_found"
+	}
+	resolutionDuration.WithLabelValues(string(attempt.Source), outcome).Observe(attempt.Duration.Seconds())
+}
+
+// helper which wraps a single resolution attempt with observer calls, if an observer is configured
+func observeResolution[T any](ctx context.Context, obs ResolutionObserver, subject string, source ResolutionSource, fn func() (T, error)) (T, error) {
+	if obs == nil {
+		return fn()
+	}
+	obs.ResolutionStarted(ctx, subject, source)
+	start := time.Now()
+	res, err := fn()
+	attempt := ResolutionAttempt{
+		Subject:  subject,
+		Source:   source,
+		Err:      err,
+		Duration: time.Since(start),
+	}
+	if did, ok := any(res).(syntax.DID); ok {
+		attempt.Result = did.String()
+	}
+	if err != nil {
+		obs.ResolutionFailed(ctx, attempt)
+	} else {
+		obs.ResolutionSucceeded(ctx, attempt)
+	}
+	return res, err
+}
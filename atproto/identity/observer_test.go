@@ -0,0 +1,66 @@
+package identity
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingObserver struct {
+	started   []ResolutionSource
+	succeeded []ResolutionAttempt
+	failed    []ResolutionAttempt
+}
+
+func (r *recordingObserver) ResolutionStarted(ctx context.Context, subject string, source ResolutionSource) {
+	r.started = append(r.started, source)
+}
+
+func (r *recordingObserver) ResolutionSucceeded(ctx context.Context, attempt ResolutionAttempt) {
+	r.succeeded = append(r.succeeded, attempt)
+}
+
+func (r *recordingObserver) ResolutionFailed(ctx context.Context, attempt ResolutionAttempt) {
+	r.failed = append(r.failed, attempt)
+}
+
+func TestResolutionObserver(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	docJSON, err := os.ReadFile("testdata/did_plc_doc.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/did:plc:ewvi7nxzyoun6zhxrhs64oiz" {
+			w.Write(docJSON)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	obs := &recordingObserver{}
+	dir := BaseDirectory{
+		PLCURL:   srv.URL,
+		Observer: MultiObserver{obs, MetricsObserver{}},
+	}
+
+	_, err = dir.ResolveDID(ctx, syntax.DID("did:plc:ewvi7nxzyoun6zhxrhs64oiz"))
+	assert.NoError(err)
+	_, err = dir.ResolveDID(ctx, syntax.DID("did:plc:aaaaaaaaaaaaaaaaaaaaaaaa"))
+	assert.ErrorIs(err, ErrDIDNotFound)
+
+	assert.Equal([]ResolutionSource{SourcePLC, SourcePLC}, obs.started)
+	assert.Equal(1, len(obs.succeeded))
+	assert.Equal("did:plc:ewvi7nxzyoun6zhxrhs64oiz", obs.succeeded[0].Subject)
+	assert.Equal(1, len(obs.failed))
+	assert.True(obs.failed[0].NotFound())
+}
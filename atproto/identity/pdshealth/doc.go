@@ -0,0 +1,15 @@
+/*
+Package pdshealth tracks the health of PDS hosts, so that services which fetch from many of them (relays, backfillers) can deprioritize hosts which are down or misconfigured instead of spending time and retries on them.
+
+A [Prober] checks a single host by calling its describeServer endpoint, recording whether it was reachable, whether its TLS certificate was valid, and how long it took to respond. A [Registry] keeps the latest health of each host in a [Store] (persistent, or [MemStore]), scoring hosts as healthy, degraded or unhealthy from the results of recent probes:
+
+	reg, err := pdshealth.NewRegistry(ctx, pdshealth.NewMemStore(), pdshealth.NewProber())
+	go reg.Run(ctx, time.Minute, listHosts)
+
+	if reg.IsUnhealthy("pds.example.com") {
+		// try again later
+	}
+
+Hosts which have never been checked have [StatusUnknown], and are not considered unhealthy.
+*/
+package pdshealth
@@ -0,0 +1,22 @@
+package pdshealth
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var probesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "atproto_pdshealth_probes_total",
+	Help: "Number of PDS health probes, by result",
+}, []string{"result"})
+
+var probeDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "atproto_pdshealth_probe_duration_seconds",
+	Help:    "Duration of PDS health probes",
+	Buckets: prometheus.ExponentialBuckets(0.01, 2, 12),
+})
+
+var hostStatus = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "atproto_pdshealth_hosts",
+	Help: "Number of tracked PDS hosts, by health status",
+}, []string{"status"})
@@ -0,0 +1,139 @@
+package pdshealth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Path of the endpoint which is probed, relative to the host
+const DescribeServerPath = "/xrpc/com.atproto.server.describeServer"
+
+// Outcome of probing a single host once
+type ProbeResult struct {
+	// Normalized hostname (see [NormalizeHost])
+	Host string
+	// Whether the host accepted a connection and returned a response (even an error response)
+	Reachable bool
+	// False if the TLS handshake failed certificate verification. True for plain HTTP hosts.
+	TLSValid bool
+	// Expiry of the host's TLS certificate, if it was served over HTTPS
+	TLSExpiry *time.Time
+	// DID declared by the server in its describeServer response
+	DID string
+	// Time taken for the request, including connection setup
+	Latency   time.Duration
+	CheckedAt time.Time
+	// Nil if the host returned a valid describeServer response
+	Err error
+}
+
+// Checks hosts by calling their describeServer endpoint
+type Prober struct {
+	Client    *http.Client
+	UserAgent string
+}
+
+func NewProber() *Prober {
+	return &Prober{
+		Client: &http.Client{
+			Timeout: 10 * time.Second,
+			// describeServer shouldn't redirect, and following redirects could hide a misconfigured host
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		UserAgent: "indigo-pdshealth",
+	}
+}
+
+// Returns the hostname (and port, if any) of a host given as either a bare hostname or a URL, lower-cased. This is the key hosts are tracked under.
+func NormalizeHost(host string) string {
+	host = strings.TrimSpace(strings.ToLower(host))
+	host = strings.TrimPrefix(host, "https://")
+	host = strings.TrimPrefix(host, "http://")
+	host, _, _ = strings.Cut(host, "/")
+	return host
+}
+
+// Returns the base URL for a host, assuming HTTPS if no scheme was given
+func baseURL(host string) string {
+	host = strings.TrimRight(strings.TrimSpace(host), "/")
+	if strings.HasPrefix(host, "https://") || strings.HasPrefix(host, "http://") {
+		return host
+	}
+	return "https://" + host
+}
+
+// Checks a single host. The host may be a bare hostname (probed over HTTPS) or a URL (eg, "http://localhost:2583" for development). Failures are reported in the result, not as an error.
+func (p *Prober) Probe(ctx context.Context, host string) *ProbeResult {
+	res := &ProbeResult{
+		Host:      NormalizeHost(host),
+		TLSValid:  true,
+		CheckedAt: time.Now(),
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL(host)+DescribeServerPath, nil)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	req.Header.Set("Accept", "application/json")
+	if p.UserAgent != "" {
+		req.Header.Set("User-Agent", p.UserAgent)
+	}
+
+	start := time.Now()
+	resp, err := p.Client.Do(req)
+	res.Latency = time.Since(start)
+	if err != nil {
+		if isCertificateError(err) {
+			// the host is up, but clients can't safely talk to it
+			res.Reachable = true
+			res.TLSValid = false
+		}
+		res.Err = err
+		return res
+	}
+	defer resp.Body.Close()
+	res.Reachable = true
+
+	if resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
+		exp := resp.TLS.PeerCertificates[0].NotAfter
+		res.TLSExpiry = &exp
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		res.Err = fmt.Errorf("describeServer returned status %d", resp.StatusCode)
+		return res
+	}
+
+	var out struct {
+		Did string `json:"did"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&out); err != nil {
+		res.Err = fmt.Errorf("invalid describeServer response: %w", err)
+		return res
+	}
+	if out.Did == "" {
+		res.Err = errors.New("describeServer response missing did")
+		return res
+	}
+	res.DID = out.Did
+	return res
+}
+
+func isCertificateError(err error) bool {
+	var verr *tls.CertificateVerificationError
+	var uerr x509.UnknownAuthorityError
+	var herr x509.HostnameError
+	var cerr x509.CertificateInvalidError
+	return errors.As(err, &verr) || errors.As(err, &uerr) || errors.As(err, &herr) || errors.As(err, &cerr)
+}
@@ -0,0 +1,296 @@
+package pdshealth
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+type Status string
+
+var (
+	// Never checked
+	StatusUnknown = Status("unknown")
+	StatusHealthy = Status("healthy")
+	// Working, but slow, recently failing, or with a certificate about to expire
+	StatusDegraded  = Status("degraded")
+	StatusUnhealthy = Status("unhealthy")
+)
+
+// Latest known health of a single host
+type HostHealth struct {
+	Host      string
+	Status    Status
+	Reachable bool
+	TLSValid  bool
+	TLSExpiry *time.Time
+	DID       string
+	// Latency of the most recent probe
+	Latency time.Duration
+	// Error from the most recent probe, if it failed
+	LastError           string
+	ConsecutiveFailures int
+	LastChecked         time.Time
+	// Last time the host was found to be healthy or degraded. Nil if never.
+	LastOK *time.Time
+}
+
+// Persists host health. Implementations must be safe for concurrent use.
+type Store interface {
+	// Returns nil (and no error) if the host isn't known
+	GetHost(ctx context.Context, host string) (*HostHealth, error)
+	PutHost(ctx context.Context, h *HostHealth) error
+	ListHosts(ctx context.Context) ([]*HostHealth, error)
+}
+
+// In-memory Store, for tests and for services which don't need health to persist across restarts
+type MemStore struct {
+	lk    sync.Mutex
+	hosts map[string]HostHealth
+}
+
+var _ Store = (*MemStore)(nil)
+
+func NewMemStore() *MemStore {
+	return &MemStore{hosts: make(map[string]HostHealth)}
+}
+
+func (m *MemStore) GetHost(ctx context.Context, host string) (*HostHealth, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	h, ok := m.hosts[host]
+	if !ok {
+		return nil, nil
+	}
+	return &h, nil
+}
+
+func (m *MemStore) PutHost(ctx context.Context, h *HostHealth) error {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	m.hosts[h.Host] = *h
+	return nil
+}
+
+func (m *MemStore) ListHosts(ctx context.Context) ([]*HostHealth, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	out := make([]*HostHealth, 0, len(m.hosts))
+	for _, h := range m.hosts {
+		h := h
+		out = append(out, &h)
+	}
+	return out, nil
+}
+
+// Tracks the health of PDS hosts, backed by a Store. Lookups are served from memory, so they are cheap enough to make on scheduling paths.
+type Registry struct {
+	store  Store
+	prober *Prober
+
+	// Number of consecutive failed probes after which a host is unhealthy (before that, it is degraded)
+	UnhealthyAfter int
+	// Probes slower than this mark the host as degraded
+	SlowLatency time.Duration
+	// Certificates expiring sooner than this mark the host as degraded
+	CertExpiryWarning time.Duration
+	// Number of hosts probed concurrently by Run
+	Concurrency int
+
+	Logger *slog.Logger
+
+	lk    sync.RWMutex
+	hosts map[string]*HostHealth
+}
+
+// Creates a registry, loading any previously recorded health from the store
+func NewRegistry(ctx context.Context, store Store, prober *Prober) (*Registry, error) {
+	if prober == nil {
+		prober = NewProber()
+	}
+	r := &Registry{
+		store:             store,
+		prober:            prober,
+		UnhealthyAfter:    3,
+		SlowLatency:       3 * time.Second,
+		CertExpiryWarning: 7 * 24 * time.Hour,
+		Concurrency:       10,
+		Logger:            slog.Default().With("system", "pdshealth"),
+		hosts:             make(map[string]*HostHealth),
+	}
+	all, err := store.ListHosts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range all {
+		r.hosts[h.Host] = h
+		hostStatus.WithLabelValues(string(h.Status)).Inc()
+	}
+	return r, nil
+}
+
+// Returns the latest recorded health of a host, or nil if it has never been checked
+func (r *Registry) Get(host string) *HostHealth {
+	r.lk.RLock()
+	defer r.lk.RUnlock()
+	h, ok := r.hosts[NormalizeHost(host)]
+	if !ok {
+		return nil
+	}
+	cp := *h
+	return &cp
+}
+
+func (r *Registry) Status(host string) Status {
+	r.lk.RLock()
+	defer r.lk.RUnlock()
+	h, ok := r.hosts[NormalizeHost(host)]
+	if !ok {
+		return StatusUnknown
+	}
+	return h.Status
+}
+
+// Returns true if the host is known to be unhealthy. Work against unhealthy hosts should be postponed or retried later, not dropped: hosts which come back are marked healthy on their next successful probe.
+func (r *Registry) IsUnhealthy(host string) bool {
+	return r.Status(host) == StatusUnhealthy
+}
+
+// Returns all tracked hosts, ordered by hostname
+func (r *Registry) Hosts() []*HostHealth {
+	r.lk.RLock()
+	out := make([]*HostHealth, 0, len(r.hosts))
+	for _, h := range r.hosts {
+		cp := *h
+		out = append(out, &cp)
+	}
+	r.lk.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Host < out[j].Host })
+	return out
+}
+
+// Probes a host and records the result
+func (r *Registry) Check(ctx context.Context, host string) (*HostHealth, error) {
+	return r.Record(ctx, r.prober.Probe(ctx, host))
+}
+
+// Updates a host's health with the result of a probe, persisting it to the store
+func (r *Registry) Record(ctx context.Context, res *ProbeResult) (*HostHealth, error) {
+	r.lk.Lock()
+	prev, ok := r.hosts[res.Host]
+	h := &HostHealth{Host: res.Host, Status: StatusUnknown}
+	if ok {
+		cp := *prev
+		h = &cp
+	}
+	oldStatus := h.Status
+
+	h.Reachable = res.Reachable
+	h.TLSValid = res.TLSValid
+	h.TLSExpiry = res.TLSExpiry
+	h.Latency = res.Latency
+	h.LastChecked = res.CheckedAt
+	if res.DID != "" {
+		h.DID = res.DID
+	}
+	if res.Err != nil {
+		h.LastError = res.Err.Error()
+		h.ConsecutiveFailures++
+	} else {
+		h.LastError = ""
+		h.ConsecutiveFailures = 0
+	}
+	h.Status = r.score(h, res)
+	if h.Status != StatusUnhealthy {
+		t := res.CheckedAt
+		h.LastOK = &t
+	}
+	r.hosts[h.Host] = h
+	cp := *h
+	r.lk.Unlock()
+
+	result := "ok"
+	if res.Err != nil {
+		result = "error"
+	}
+	probesTotal.WithLabelValues(result).Inc()
+	probeDuration.Observe(res.Latency.Seconds())
+	if oldStatus != h.Status {
+		if ok {
+			hostStatus.WithLabelValues(string(oldStatus)).Dec()
+		}
+		hostStatus.WithLabelValues(string(h.Status)).Inc()
+		r.Logger.Info("host health changed", "host", h.Host, "from", oldStatus, "to", h.Status, "err", h.LastError)
+	}
+
+	if err := r.store.PutHost(ctx, &cp); err != nil {
+		return &cp, err
+	}
+	return &cp, nil
+}
+
+func (r *Registry) score(h *HostHealth, res *ProbeResult) Status {
+	// a bad certificate won't fix itself between retries
+	if !res.TLSValid {
+		return StatusUnhealthy
+	}
+	if res.Err != nil {
+		if h.ConsecutiveFailures >= r.UnhealthyAfter {
+			return StatusUnhealthy
+		}
+		return StatusDegraded
+	}
+	if r.SlowLatency > 0 && res.Latency > r.SlowLatency {
+		return StatusDegraded
+	}
+	if r.CertExpiryWarning > 0 && res.TLSExpiry != nil && time.Until(*res.TLSExpiry) < r.CertExpiryWarning {
+		return StatusDegraded
+	}
+	return StatusHealthy
+}
+
+// Probes the hosts returned by listHosts every interval, until the context is cancelled
+func (r *Registry) Run(ctx context.Context, interval time.Duration, listHosts func(ctx context.Context) ([]string, error)) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		hosts, err := listHosts(ctx)
+		if err != nil {
+			r.Logger.Error("failed to list hosts to probe", "err", err)
+		} else {
+			r.CheckAll(ctx, hosts)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Probes all the given hosts, Concurrency at a time
+func (r *Registry) CheckAll(ctx context.Context, hosts []string) {
+	n := max(r.Concurrency, 1)
+	sem := make(chan struct{}, n)
+	var wg sync.WaitGroup
+	for _, host := range hosts {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case sem <- struct{}{}:
+		}
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if _, err := r.Check(ctx, host); err != nil {
+				r.Logger.Error("failed to record host health", "host", host, "err", err)
+			}
+		}(host)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,136 @@
+package pdshealth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeHost(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("pds.example.com", NormalizeHost("pds.example.com"))
+	assert.Equal("pds.example.com", NormalizeHost("https://PDS.example.com/"))
+	assert.Equal("localhost:2583", NormalizeHost("http://localhost:2583/xrpc"))
+}
+
+func TestProbe(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != DescribeServerPath {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"did":"did:web:pds.example.com","availableUserDomains":[]}`)
+	}))
+	defer srv.Close()
+
+	p := NewProber()
+	p.Client = srv.Client()
+	res := p.Probe(ctx, srv.URL)
+	assert.NoError(res.Err)
+	assert.True(res.Reachable)
+	assert.True(res.TLSValid)
+	assert.NotNil(res.TLSExpiry)
+	assert.Equal("did:web:pds.example.com", res.DID)
+
+	// the test server's certificate isn't trusted by default
+	res = NewProber().Probe(ctx, srv.URL)
+	assert.Error(res.Err)
+	assert.True(res.Reachable)
+	assert.False(res.TLSValid)
+
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer broken.Close()
+	res = NewProber().Probe(ctx, broken.URL)
+	assert.Error(res.Err)
+	assert.True(res.Reachable)
+	assert.True(res.TLSValid)
+}
+
+func TestRegistry(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	store := NewMemStore()
+	r, err := NewRegistry(ctx, store, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	host := "pds.example.com"
+	assert.Equal(StatusUnknown, r.Status(host))
+	assert.False(r.IsUnhealthy(host))
+
+	ok := func() *ProbeResult {
+		return &ProbeResult{Host: host, Reachable: true, TLSValid: true, Latency: time.Millisecond, CheckedAt: time.Now()}
+	}
+	failed := func() *ProbeResult {
+		return &ProbeResult{Host: host, TLSValid: true, CheckedAt: time.Now(), Err: errors.New("connection refused")}
+	}
+
+	if _, err := r.Record(ctx, ok()); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(StatusHealthy, r.Status("https://pds.example.com"))
+
+	// failures degrade the host, then make it unhealthy
+	for i := 1; i <= 3; i++ {
+		h, err := r.Record(ctx, failed())
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(i, h.ConsecutiveFailures)
+	}
+	assert.True(r.IsUnhealthy(host))
+	h := r.Get(host)
+	assert.Equal("connection refused", h.LastError)
+	assert.NotNil(h.LastOK)
+
+	// and one success brings it back
+	if _, err := r.Record(ctx, ok()); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(StatusHealthy, r.Status(host))
+
+	slow := ok()
+	slow.Latency = time.Minute
+	if _, err := r.Record(ctx, slow); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(StatusDegraded, r.Status(host))
+
+	expiring := ok()
+	exp := time.Now().Add(time.Hour)
+	expiring.TLSExpiry = &exp
+	if _, err := r.Record(ctx, expiring); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(StatusDegraded, r.Status(host))
+
+	badCert := failed()
+	badCert.Reachable = true
+	badCert.TLSValid = false
+	if _, err := r.Record(ctx, badCert); err != nil {
+		t.Fatal(err)
+	}
+	assert.True(r.IsUnhealthy(host))
+
+	// state is reloaded from the store
+	r2, err := NewRegistry(ctx, store, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(r2.IsUnhealthy(host))
+	assert.Len(r2.Hosts(), 1)
+}
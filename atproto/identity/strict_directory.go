@@ -0,0 +1,114 @@
+package identity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// Indicates that the DID document declared a handle, but that handle did not resolve back to the DID. Only returned by [StrictDirectory]; other directories return an Identity with the special `handle.invalid` value instead.
+var ErrHandleNotVerified = errors.New("declared handle did not resolve back to DID")
+
+// Returned by [StrictDirectory] when an identity fails bi-directional handle verification. Wraps one of [ErrHandleNotDeclared], [ErrHandleNotVerified], or [ErrHandleMismatch], so it can be checked with either errors.As or errors.Is.
+type HandleVerificationError struct {
+	DID syntax.DID
+	// the handle which was looked up, or declared in the DID document. May be empty
+	Handle syntax.Handle
+	Err    error
+}
+
+func (e *HandleVerificationError) Error() string {
+	if e.Handle == "" {
+		return fmt.Sprintf("%s: %s", e.DID, e.Err)
+	}
+	return fmt.Sprintf("%s (%s): %s", e.DID, e.Handle, e.Err)
+}
+
+func (e *HandleVerificationError) Unwrap() error {
+	return e.Err
+}
+
+// StrictDirectory wraps another Directory, and refuses to return any identity whose handle has not been bi-directionally verified: the DID document must declare the handle, and the handle must resolve back to the DID. This is stricter than the usual Directory behavior for DID lookups, which is to return the identity with a `handle.invalid` handle.
+//
+// Intended for contexts (eg, moderation) where acting on an account with an unverified handle is worse than failing the lookup.
+type StrictDirectory struct {
+	Inner Directory
+	// If true, accounts which don't declare any handle at all are returned (with the `handle.invalid` value) rather than rejected
+	AllowUndeclaredHandle bool
+}
+
+var _ Directory = (*StrictDirectory)(nil)
+
+func NewStrictDirectory(inner Directory) *StrictDirectory {
+	return &StrictDirectory{Inner: inner}
+}
+
+func (d *StrictDirectory) reject(did syntax.DID, h syntax.Handle, err error) error {
+	var reason string
+	switch {
+	case errors.Is(err, ErrHandleNotDeclared):
+		reason = "not_declared"
+	case errors.Is(err, ErrHandleNotVerified):
+		reason = "not_verified"
+	default:
+		reason = "mismatch"
+	}
+	strictDirectoryRejections.WithLabelValues(reason).Inc()
+	return &HandleVerificationError{DID: did, Handle: h, Err: err}
+}
+
+func (d *StrictDirectory) check(ident *Identity) (*Identity, error) {
+	if !ident.Handle.IsInvalidHandle() {
+		return ident, nil
+	}
+	declared, err := ident.DeclaredHandle()
+	if errors.Is(err, ErrHandleNotDeclared) {
+		if d.AllowUndeclaredHandle {
+			return ident, nil
+		}
+		return nil, d.reject(ident.DID, "", ErrHandleNotDeclared)
+	} else if err != nil {
+		return nil, err
+	}
+	return nil, d.reject(ident.DID, declared, ErrHandleNotVerified)
+}
+
+func (d *StrictDirectory) LookupHandle(ctx context.Context, h syntax.Handle) (*Identity, error) {
+	h = h.Normalize()
+	ident, err := d.Inner.LookupHandle(ctx, h)
+	if errors.Is(err, ErrHandleMismatch) || errors.Is(err, ErrHandleNotDeclared) {
+		return nil, d.reject("", h, err)
+	} else if err != nil {
+		return nil, err
+	}
+	if ident.Handle != h {
+		return nil, d.reject(ident.DID, h, ErrHandleMismatch)
+	}
+	return ident, nil
+}
+
+func (d *StrictDirectory) LookupDID(ctx context.Context, did syntax.DID) (*Identity, error) {
+	ident, err := d.Inner.LookupDID(ctx, did)
+	if err != nil {
+		return nil, err
+	}
+	return d.check(ident)
+}
+
+func (d *StrictDirectory) Lookup(ctx context.Context, a syntax.AtIdentifier) (*Identity, error) {
+	handle, err := a.AsHandle()
+	if nil == err { // if not an error, is a handle
+		return d.LookupHandle(ctx, handle)
+	}
+	did, err := a.AsDID()
+	if nil == err { // if not an error, is a DID
+		return d.LookupDID(ctx, did)
+	}
+	return nil, fmt.Errorf("at-identifier neither a Handle nor a DID")
+}
+
+func (d *StrictDirectory) Purge(ctx context.Context, a syntax.AtIdentifier) error {
+	return d.Inner.Purge(ctx, a)
+}
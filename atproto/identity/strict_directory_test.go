@@ -0,0 +1,71 @@
+package identity
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStrictDirectory(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	inner := NewMockDirectory()
+	verified := Identity{
+		DID:         syntax.DID("did:plc:abc111"),
+		Handle:      syntax.Handle("handle.example.com"),
+		AlsoKnownAs: []string{"at://handle.example.com"},
+	}
+	undeclared := Identity{
+		DID:    syntax.DID("did:plc:abc222"),
+		Handle: syntax.HandleInvalid,
+	}
+	unverified := Identity{
+		DID:         syntax.DID("did:plc:abc333"),
+		Handle:      syntax.HandleInvalid,
+		AlsoKnownAs: []string{"at://someone-else.example.com"},
+	}
+	inner.Insert(verified)
+	inner.Insert(undeclared)
+	inner.Insert(unverified)
+	// handle resolves to a DID whose document declares a different handle
+	inner.Handles[syntax.Handle("stale.example.com")] = verified.DID
+
+	d := NewStrictDirectory(&inner)
+
+	out, err := d.LookupDID(ctx, verified.DID)
+	assert.NoError(err)
+	assert.Equal(&verified, out)
+	out, err = d.LookupHandle(ctx, syntax.Handle("Handle.Example.com"))
+	assert.NoError(err)
+	assert.Equal(&verified, out)
+
+	_, err = d.LookupDID(ctx, undeclared.DID)
+	assert.ErrorIs(err, ErrHandleNotDeclared)
+
+	_, err = d.LookupDID(ctx, unverified.DID)
+	assert.ErrorIs(err, ErrHandleNotVerified)
+	var verr *HandleVerificationError
+	assert.True(errors.As(err, &verr))
+	assert.Equal(unverified.DID, verr.DID)
+	assert.Equal(syntax.Handle("someone-else.example.com"), verr.Handle)
+
+	_, err = d.LookupHandle(ctx, syntax.Handle("stale.example.com"))
+	assert.ErrorIs(err, ErrHandleMismatch)
+
+	// other errors pass through unchanged
+	_, err = d.LookupDID(ctx, syntax.DID("did:plc:abc999"))
+	assert.ErrorIs(err, ErrDIDNotFound)
+	assert.False(errors.As(err, &verr))
+
+	d.AllowUndeclaredHandle = true
+	out, err = d.LookupDID(ctx, undeclared.DID)
+	assert.NoError(err)
+	assert.True(out.Handle.IsInvalidHandle())
+	_, err = d.LookupDID(ctx, unverified.DID)
+	assert.ErrorIs(err, ErrHandleNotVerified)
+}
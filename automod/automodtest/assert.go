@@ -0,0 +1,141 @@
+package automodtest
+
+import (
+	"slices"
+	"testing"
+
+	toolsozone "github.com/bluesky-social/indigo/api/ozone"
+)
+
+// Returns events for a subject (DID or AT-URI string) which match a filter, oldest first
+func (fo *FakeOzone) eventsFor(subject string, match func(evt *toolsozone.ModerationDefs_ModEventView) bool) []*toolsozone.ModerationDefs_ModEventView {
+	fo.mu.Lock()
+	defer fo.mu.Unlock()
+	var out []*toolsozone.ModerationDefs_ModEventView
+	for _, evt := range fo.events {
+		if eventSubject(evt) == subject && match(evt) {
+			out = append(out, evt)
+		}
+	}
+	return out
+}
+
+// Labels returns the label values applied to a subject (DID or AT-URI string) by emitted events, in order, not accounting for negation
+func (fo *FakeOzone) Labels(subject string) []string {
+	var out []string
+	for _, evt := range fo.eventsFor(subject, func(evt *toolsozone.ModerationDefs_ModEventView) bool {
+		return evt.Event.ModerationDefs_ModEventLabel != nil
+	}) {
+		out = append(out, evt.Event.ModerationDefs_ModEventLabel.CreateLabelVals...)
+	}
+	return out
+}
+
+// Tags returns the tags added to a subject (DID or AT-URI string) by emitted events, in order
+func (fo *FakeOzone) Tags(subject string) []string {
+	var out []string
+	for _, evt := range fo.eventsFor(subject, func(evt *toolsozone.ModerationDefs_ModEventView) bool {
+		return evt.Event.ModerationDefs_ModEventTag != nil
+	}) {
+		out = append(out, evt.Event.ModerationDefs_ModEventTag.Add...)
+	}
+	return out
+}
+
+// Reports returns the reports filed against a subject (DID or AT-URI string), in order
+func (fo *FakeOzone) Reports(subject string) []*toolsozone.ModerationDefs_ModEventReport {
+	var out []*toolsozone.ModerationDefs_ModEventReport
+	for _, evt := range fo.eventsFor(subject, func(evt *toolsozone.ModerationDefs_ModEventView) bool {
+		return evt.Event.ModerationDefs_ModEventReport != nil
+	}) {
+		out = append(out, evt.Event.ModerationDefs_ModEventReport)
+	}
+	return out
+}
+
+// Returns true if a takedown event was emitted for the subject (DID or AT-URI string)
+func (fo *FakeOzone) TakenDown(subject string) bool {
+	return len(fo.eventsFor(subject, func(evt *toolsozone.ModerationDefs_ModEventView) bool {
+		return evt.Event.ModerationDefs_ModEventTakedown != nil
+	})) > 0
+}
+
+// AssertLabel checks that the label value was applied to the subject (DID or AT-URI string)
+func (fo *FakeOzone) AssertLabel(t testing.TB, subject, val string) bool {
+	t.Helper()
+	labels := fo.Labels(subject)
+	if !slices.Contains(labels, val) {
+		t.Errorf("expected label %q on %s; got %v", val, subject, labels)
+		return false
+	}
+	return true
+}
+
+// AssertNoLabel checks that the label value was not applied to the subject (DID or AT-URI string)
+func (fo *FakeOzone) AssertNoLabel(t testing.TB, subject, val string) bool {
+	t.Helper()
+	if slices.Contains(fo.Labels(subject), val) {
+		t.Errorf("unexpected label %q on %s", val, subject)
+		return false
+	}
+	return true
+}
+
+// AssertTag checks that the tag was added to the subject (DID or AT-URI string)
+func (fo *FakeOzone) AssertTag(t testing.TB, subject, tag string) bool {
+	t.Helper()
+	tags := fo.Tags(subject)
+	if !slices.Contains(tags, tag) {
+		t.Errorf("expected tag %q on %s; got %v", tag, subject, tags)
+		return false
+	}
+	return true
+}
+
+// AssertReport checks that the subject (DID or AT-URI string) was reported. If reasonType is not empty, a report must have that reason type (eg "com.atproto.moderation.defs#reasonSpam").
+func (fo *FakeOzone) AssertReport(t testing.TB, subject, reasonType string) bool {
+	t.Helper()
+	reports := fo.Reports(subject)
+	for _, r := range reports {
+		if reasonType == "" || (r.ReportType != nil && *r.ReportType == reasonType) {
+			return true
+		}
+	}
+	t.Errorf("expected report on %s (reasonType=%q); got %d reports", subject, reasonType, len(reports))
+	return false
+}
+
+// AssertReportCount checks the number of reports filed against the subject (DID or AT-URI string), eg to verify de-duplication
+func (fo *FakeOzone) AssertReportCount(t testing.TB, subject string, count int) bool {
+	t.Helper()
+	if n := len(fo.Reports(subject)); n != count {
+		t.Errorf("expected %d reports on %s; got %d", count, subject, n)
+		return false
+	}
+	return true
+}
+
+// AssertTakedown checks that the subject (DID or AT-URI string) was taken down
+func (fo *FakeOzone) AssertTakedown(t testing.TB, subject string) bool {
+	t.Helper()
+	if !fo.TakenDown(subject) {
+		t.Errorf("expected takedown of %s", subject)
+		return false
+	}
+	return true
+}
+
+// AssertNoEvents checks that no moderation events were emitted at all
+func (fo *FakeOzone) AssertNoEvents(t testing.TB) bool {
+	t.Helper()
+	events := fo.Events()
+	if len(events) > 0 {
+		types := make([]string, len(events))
+		for i, evt := range events {
+			types[i] = eventType(evt) + " " + eventSubject(evt)
+		}
+		t.Errorf("expected no moderation events; got %d: %v", len(events), types)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,23 @@
+/*
+Package automodtest provides in-memory fake Ozone and PDS servers, and a Harness which wires an automod Engine up to them, for end-to-end tests of rules.
+
+Unit tests of individual rules (like those in the rules package) call a rule function directly and inspect the resulting effects. Harness tests instead push events through the full Engine, including account metadata hydration, persistence of moderation actions, and de-duplication, and then assert on the moderation events which arrived at the fake Ozone instance:
+
+	h := automodtest.NewHarness(t, engine.RuleSet{
+		PostRules: []engine.PostRuleFunc{rules.BadHashtagsPostRule},
+	})
+	h.Sets.Sets["bad-hashtags"] = map[string]bool{"slur": true}
+	id := h.AddAccount("did:plc:abc111", "handle.example.com")
+
+	uri := h.CreateRecord(ctx, id.DID, "app.bsky.feed.post", "3kabc123", &appbsky.FeedPost{Text: "hello", Tags: []string{"slur"}})
+	h.Ozone.AssertReport(t, uri.String(), automod.ReportReasonRude)
+
+Records for either kind of test can be generated with a FixtureGen, instead of being built by hand. It produces realistic posts (with rich-text facets), profiles, and follows, with tunable probabilities and a fixed seed, and can generate arbitrary records from Lexicon schemas:
+
+	g := automodtest.NewFixtureGen(1)
+	post := g.Post(automodtest.PostSpec{Hashtags: []string{"slur"}})
+	op, err := g.CreateOp(id.DID, "app.bsky.feed.post", post)
+
+Both fakes serve a small set of XRPC endpoints with default behaviors backed by in-memory state, and also support scripted responses (see FakeServer.Script) to simulate errors or unusual upstream state.
+*/
+package automodtest
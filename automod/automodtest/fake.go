@@ -0,0 +1,110 @@
+package automodtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+// A scripted response to a single XRPC request. Body is serialized as JSON.
+type Response struct {
+	Status int
+	Body   any
+}
+
+// Returns a scripted XRPC error response, in the standard error body format
+func ErrorResponse(status int, name, message string) Response {
+	return Response{
+		Status: status,
+		Body:   map[string]string{"error": name, "message": message},
+	}
+}
+
+// FakeServer is the shared base of the fake Ozone and PDS servers: an HTTP server dispatching XRPC requests by method NSID, with support for scripted responses and a log of calls.
+type FakeServer struct {
+	Server *httptest.Server
+
+	mu       sync.Mutex
+	handlers map[string]http.HandlerFunc
+	scripted map[string][]Response
+	calls    map[string]int
+}
+
+func newFakeServer() *FakeServer {
+	fs := &FakeServer{
+		handlers: make(map[string]http.HandlerFunc),
+		scripted: make(map[string][]Response),
+		calls:    make(map[string]int),
+	}
+	fs.Server = httptest.NewServer(http.HandlerFunc(fs.serveHTTP))
+	return fs
+}
+
+// Base URL of the server, eg for use as an xrpc.Client Host
+func (fs *FakeServer) URL() string {
+	return fs.Server.URL
+}
+
+func (fs *FakeServer) Close() {
+	fs.Server.Close()
+}
+
+// Script queues responses for an XRPC method (by NSID). Each request to the method consumes one scripted response, in order; once they are used up, the default handler resumes.
+func (fs *FakeServer) Script(nsid string, resps ...Response) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.scripted[nsid] = append(fs.scripted[nsid], resps...)
+}
+
+// Number of requests received for an XRPC method (by NSID), including scripted and unhandled requests
+func (fs *FakeServer) Calls(nsid string) int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.calls[nsid]
+}
+
+func (fs *FakeServer) handle(nsid string, h http.HandlerFunc) {
+	fs.handlers[nsid] = h
+}
+
+func (fs *FakeServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	nsid, ok := strings.CutPrefix(r.URL.Path, "/xrpc/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	fs.mu.Lock()
+	fs.calls[nsid]++
+	var resp *Response
+	if queue := fs.scripted[nsid]; len(queue) > 0 {
+		resp = &queue[0]
+		fs.scripted[nsid] = queue[1:]
+	}
+	h := fs.handlers[nsid]
+	fs.mu.Unlock()
+
+	if resp != nil {
+		writeJSON(w, resp.Status, resp.Body)
+		return
+	}
+	if h == nil {
+		writeError(w, http.StatusNotImplemented, "MethodNotImplemented", "method not implemented by fake server: "+nsid)
+		return
+	}
+	h(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if body != nil {
+		json.NewEncoder(w).Encode(body)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, name, message string) {
+	writeJSON(w, status, map[string]string{"error": name, "message": message})
+}
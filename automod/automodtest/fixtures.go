@@ -0,0 +1,555 @@
+package automodtest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/lexicon"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/automod/engine"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// default vocabulary for generated text
+var fixtureWords = strings.Fields(`
+	the a of and to in is it that for on with as at this be by from not are was but
+	day night coffee morning weekend photo art cat dog garden music show game book
+	city park train rain sun beach river mountain friends family dinner lunch
+	new old good great little big happy tired busy quiet cold warm
+	just really finally today tomorrow again still maybe always never
+	love like think know want need see made found went watching reading listening
+`)
+
+var fixtureLangs = []string{"en", "en", "en", "ja", "pt", "de", "es", "fr", "ko"}
+
+// FixtureGen generates realistic fake records and identities for rule unit tests and backtests, so that tests don't need to hand-build records (and their CBOR encodings and CIDs).
+//
+// Output is deterministic for a given seed. The probabilities can be tuned to bias generated records towards the features a rule cares about; for example, setting HashtagProb to 1 means every generated post has hashtags.
+//
+// Typed helpers (Post, Profile, Follow) cover common app.bsky records, including consistent rich-text facets. Record generates arbitrary records from Lexicon schemas in Catalog.
+type FixtureGen struct {
+	Rand *rand.Rand
+	// Lexicon schemas used by Record. Not needed by the typed helpers
+	Catalog lexicon.Catalog
+	// Timestamps are generated within Spread before Now
+	Now    time.Time
+	Spread time.Duration
+	// Vocabulary for generated text
+	Words []string
+
+	// Probabilities (0 to 1) that a generated post includes each feature
+	HashtagProb float64
+	MentionProb float64
+	LinkProb    float64
+	TagProb     float64
+	LangProb    float64
+	// Probability that a non-required field is populated, by Record and the typed helpers
+	OptionalProb float64
+	// Handles are generated with this suffix
+	HandleDomain string
+}
+
+func NewFixtureGen(seed int64) *FixtureGen {
+	return &FixtureGen{
+		Rand:         rand.New(rand.NewSource(seed)),
+		Now:          time.Now(),
+		Spread:       24 * time.Hour,
+		Words:        fixtureWords,
+		HashtagProb:  0.2,
+		MentionProb:  0.2,
+		LinkProb:     0.1,
+		TagProb:      0.05,
+		LangProb:     0.8,
+		OptionalProb: 0.5,
+		HandleDomain: "example.com",
+	}
+}
+
+func (g *FixtureGen) chance(p float64) bool {
+	return p > 0 && g.Rand.Float64() < p
+}
+
+func (g *FixtureGen) word() string {
+	return g.Words[g.Rand.Intn(len(g.Words))]
+}
+
+func (g *FixtureGen) words(n int) string {
+	out := make([]string, n)
+	for i := range out {
+		out[i] = g.word()
+	}
+	return strings.Join(out, " ")
+}
+
+// returns a random lowercase alphanumeric string of length n
+func (g *FixtureGen) alnum(n int) string {
+	const chars = "abcdefghijklmnopqrstuvwxyz234567"
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = chars[g.Rand.Intn(len(chars))]
+	}
+	return string(b)
+}
+
+// DID returns a random did:plc
+func (g *FixtureGen) DID() syntax.DID {
+	return syntax.DID("did:plc:" + g.alnum(24))
+}
+
+// Handle returns a random handle under HandleDomain
+func (g *FixtureGen) Handle() syntax.Handle {
+	return syntax.Handle(fmt.Sprintf("%s%d.%s", g.word(), g.Rand.Intn(1000), g.HandleDomain))
+}
+
+// Identity returns a random identity (DID and handle), suitable for inserting in to a MockDirectory
+func (g *FixtureGen) Identity() identity.Identity {
+	return identity.Identity{
+		DID:    g.DID(),
+		Handle: g.Handle(),
+	}
+}
+
+// Time returns a random time within Spread before Now
+func (g *FixtureGen) Time() time.Time {
+	if g.Spread <= 0 {
+		return g.Now
+	}
+	return g.Now.Add(-time.Duration(g.Rand.Int63n(int64(g.Spread))))
+}
+
+// Datetime returns a random timestamp (see Time) in the atproto datetime format
+func (g *FixtureGen) Datetime() string {
+	return g.Time().UTC().Format(syntax.AtprotoDatetimeLayout)
+}
+
+// RecordKey returns a TID record key, for a random time
+func (g *FixtureGen) RecordKey() syntax.RecordKey {
+	return syntax.RecordKey(syntax.NewTIDFromTime(g.Time(), uint(g.Rand.Intn(1024))).String())
+}
+
+// CID returns a CID for random content
+func (g *FixtureGen) CID() syntax.CID {
+	return syntax.CID(g.cidLink().String())
+}
+
+func (g *FixtureGen) cidLink() data.CIDLink {
+	buf := make([]byte, 32)
+	g.Rand.Read(buf)
+	sum := sha256.Sum256(buf)
+	mh, _ := multihash.Encode(sum[:], multihash.SHA2_256)
+	return data.CIDLink(cid.NewCidV1(cid.DagCBOR, mh))
+}
+
+// PostSpec pins parts of a generated post. Empty text and nil slices are filled in randomly; use an empty (non-nil) slice to leave a feature out.
+type PostSpec struct {
+	// Plain text, before any facets are appended
+	Text string
+	// Hashtags (without "#") appended to the text, with tag facets
+	Hashtags []string
+	// Accounts mentioned in the text, with mention facets
+	Mentions []identity.Identity
+	// URLs appended to the text, with link facets
+	Links []string
+	// Outline hashtags, not included in the text
+	Tags  []string
+	Langs []string
+}
+
+// Post generates a post. Facets have byte offsets matching the text, as in posts created by real clients.
+func (g *FixtureGen) Post(spec PostSpec) *appbsky.FeedPost {
+	if spec.Text == "" {
+		spec.Text = g.words(3 + g.Rand.Intn(15))
+	}
+	if spec.Hashtags == nil && g.chance(g.HashtagProb) {
+		for range 1 + g.Rand.Intn(3) {
+			spec.Hashtags = append(spec.Hashtags, g.word())
+		}
+	}
+	if spec.Mentions == nil && g.chance(g.MentionProb) {
+		spec.Mentions = append(spec.Mentions, g.Identity())
+	}
+	if spec.Links == nil && g.chance(g.LinkProb) {
+		spec.Links = append(spec.Links, fmt.Sprintf("https://%s.example.com/%s", g.word(), g.alnum(8)))
+	}
+	if spec.Tags == nil && g.chance(g.TagProb) {
+		spec.Tags = append(spec.Tags, g.word())
+	}
+	if spec.Langs == nil && g.chance(g.LangProb) {
+		spec.Langs = []string{fixtureLangs[g.Rand.Intn(len(fixtureLangs))]}
+	}
+
+	post := &appbsky.FeedPost{
+		LexiconTypeID: "app.bsky.feed.post",
+		CreatedAt:     g.Datetime(),
+		Tags:          spec.Tags,
+		Langs:         spec.Langs,
+	}
+	var text strings.Builder
+	text.WriteString(spec.Text)
+	addFacet := func(s string, feat *appbsky.RichtextFacet_Features_Elem) {
+		text.WriteString(" ")
+		start := text.Len()
+		text.WriteString(s)
+		post.Facets = append(post.Facets, &appbsky.RichtextFacet{
+			Features: []*appbsky.RichtextFacet_Features_Elem{feat},
+			Index: &appbsky.RichtextFacet_ByteSlice{
+				ByteStart: int64(start),
+				ByteEnd:   int64(text.Len()),
+			},
+		})
+	}
+	for _, ident := range spec.Mentions {
+		addFacet("@"+ident.Handle.String(), &appbsky.RichtextFacet_Features_Elem{
+			RichtextFacet_Mention: &appbsky.RichtextFacet_Mention{
+				LexiconTypeID: "app.bsky.richtext.facet#mention",
+				Did:           ident.DID.String(),
+			},
+		})
+	}
+	for _, link := range spec.Links {
+		addFacet(link, &appbsky.RichtextFacet_Features_Elem{
+			RichtextFacet_Link: &appbsky.RichtextFacet_Link{
+				LexiconTypeID: "app.bsky.richtext.facet#link",
+				Uri:           link,
+			},
+		})
+	}
+	for _, tag := range spec.Hashtags {
+		addFacet("#"+tag, &appbsky.RichtextFacet_Features_Elem{
+			RichtextFacet_Tag: &appbsky.RichtextFacet_Tag{
+				LexiconTypeID: "app.bsky.richtext.facet#tag",
+				Tag:           tag,
+			},
+		})
+	}
+	post.Text = text.String()
+	return post
+}
+
+// Profile generates an account profile record
+func (g *FixtureGen) Profile() *appbsky.ActorProfile {
+	first := g.word()
+	name := strings.ToUpper(first[:1]) + first[1:] + " " + g.word()
+	profile := &appbsky.ActorProfile{
+		LexiconTypeID: "app.bsky.actor.profile",
+		DisplayName:   &name,
+	}
+	if g.chance(g.OptionalProb) {
+		desc := g.words(5 + g.Rand.Intn(20))
+		profile.Description = &desc
+	}
+	if g.chance(g.OptionalProb) {
+		createdAt := g.Datetime()
+		profile.CreatedAt = &createdAt
+	}
+	return profile
+}
+
+// Follow generates a follow of the given account
+func (g *FixtureGen) Follow(subject syntax.DID) *appbsky.GraphFollow {
+	return &appbsky.GraphFollow{
+		LexiconTypeID: "app.bsky.graph.follow",
+		CreatedAt:     g.Datetime(),
+		Subject:       subject.String(),
+	}
+}
+
+// NewRecordOp encodes a record, and returns an engine.RecordOp for it (with the correct CID), for passing to rules or Engine.ProcessRecordOp
+func NewRecordOp(action string, did syntax.DID, collection string, rkey syntax.RecordKey, rec cbg.CBORMarshaler) (engine.RecordOp, error) {
+	buf := new(bytes.Buffer)
+	if err := rec.MarshalCBOR(buf); err != nil {
+		return engine.RecordOp{}, fmt.Errorf("marshaling record: %w", err)
+	}
+	c, err := cid.NewPrefixV1(cid.DagCBOR, multihash.SHA2_256).Sum(buf.Bytes())
+	if err != nil {
+		return engine.RecordOp{}, fmt.Errorf("computing record CID: %w", err)
+	}
+	recCID := syntax.CID(c.String())
+	return engine.RecordOp{
+		Action:     action,
+		DID:        did,
+		Collection: syntax.NSID(collection),
+		RecordKey:  rkey,
+		CID:        &recCID,
+		RecordCBOR: buf.Bytes(),
+	}, nil
+}
+
+// CreateOp is like NewRecordOp, for creation of a record with a random record key
+func (g *FixtureGen) CreateOp(did syntax.DID, collection string, rec cbg.CBORMarshaler) (engine.RecordOp, error) {
+	return NewRecordOp(engine.CreateOp, did, collection, g.RecordKey(), rec)
+}
+
+// maximum nesting of refs followed by Record; optional fields are skipped beyond this
+const fixtureMaxDepth = 8
+
+// Record generates a record of the given type (NSID) from its Lexicon schema in Catalog. The result is in the generic atproto data model format, and passes lexicon.ValidateRecord. Required fields are always populated, and optional fields with probability OptionalProb.
+func (g *FixtureGen) Record(nsid string) (map[string]any, error) {
+	if g.Catalog == nil {
+		return nil, fmt.Errorf("no lexicon catalog configured")
+	}
+	s, err := g.Catalog.Resolve(nsid)
+	if err != nil {
+		return nil, err
+	}
+	rec, ok := s.Def.(lexicon.SchemaRecord)
+	if !ok {
+		return nil, fmt.Errorf("schema is not of record type: %s", nsid)
+	}
+	obj, err := g.genObject(s.ID, rec.Record, 0)
+	if err != nil {
+		return nil, err
+	}
+	obj["$type"] = nsid
+	return obj, nil
+}
+
+// RecordCBOR is like Record, but returns the record encoded as CBOR, along with its CID
+func (g *FixtureGen) RecordCBOR(nsid string) ([]byte, syntax.CID, error) {
+	obj, err := g.Record(nsid)
+	if err != nil {
+		return nil, "", err
+	}
+	b, err := data.MarshalCBOR(obj)
+	if err != nil {
+		return nil, "", err
+	}
+	c, err := cid.NewPrefixV1(cid.DagCBOR, multihash.SHA2_256).Sum(b)
+	if err != nil {
+		return nil, "", err
+	}
+	return b, syntax.CID(c.String()), nil
+}
+
+// returns the fully-qualified version of a (possibly relative) schema ref. id is the ID of the schema the ref appears in
+func fullRef(id, ref string) string {
+	if strings.HasPrefix(ref, "#") {
+		base, _, _ := strings.Cut(id, "#")
+		return base + ref
+	}
+	return ref
+}
+
+func (g *FixtureGen) genObject(id string, s lexicon.SchemaObject, depth int) (map[string]any, error) {
+	obj := make(map[string]any)
+	required := make(map[string]bool)
+	for _, k := range s.Required {
+		required[k] = true
+	}
+	for k, def := range s.Properties {
+		if !required[k] && (depth >= fixtureMaxDepth || !g.chance(g.OptionalProb)) {
+			continue
+		}
+		v, err := g.genValue(id, def.Inner, depth)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+		obj[k] = v
+	}
+	return obj, nil
+}
+
+func (g *FixtureGen) genRef(ref string, depth int) (any, error) {
+	if depth >= fixtureMaxDepth*2 {
+		return nil, fmt.Errorf("schema refs nested too deeply: %s", ref)
+	}
+	s, err := g.Catalog.Resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := s.Def.(lexicon.SchemaToken); ok {
+		return s.ID, nil
+	}
+	return g.genValue(s.ID, s.Def, depth+1)
+}
+
+func (g *FixtureGen) genValue(id string, def any, depth int) (any, error) {
+	switch s := def.(type) {
+	case lexicon.SchemaNull:
+		return nil, nil
+	case lexicon.SchemaBoolean:
+		if s.Const != nil {
+			return *s.Const, nil
+		}
+		return g.Rand.Intn(2) == 1, nil
+	case lexicon.SchemaInteger:
+		return g.genInteger(s), nil
+	case lexicon.SchemaString:
+		return g.genString(s), nil
+	case lexicon.SchemaBytes:
+		n := 16
+		if s.MaxLength != nil {
+			n = *s.MaxLength
+		}
+		if s.MinLength != nil {
+			n = *s.MinLength + g.Rand.Intn(n-*s.MinLength+1)
+		}
+		b := make([]byte, n)
+		g.Rand.Read(b)
+		return data.Bytes(b), nil
+	case lexicon.SchemaCIDLink:
+		return g.cidLink(), nil
+	case lexicon.SchemaBlob:
+		return g.genBlob(s), nil
+	case lexicon.SchemaArray:
+		lo, hi := 0, 3
+		if s.MinLength != nil {
+			lo = *s.MinLength
+			hi = max(hi, lo)
+		}
+		if s.MaxLength != nil {
+			hi = min(hi, *s.MaxLength)
+		}
+		if depth >= fixtureMaxDepth {
+			hi = lo
+		}
+		arr := make([]any, lo+g.Rand.Intn(hi-lo+1))
+		for i := range arr {
+			v, err := g.genValue(id, s.Items.Inner, depth)
+			if err != nil {
+				return nil, err
+			}
+			arr[i] = v
+		}
+		return arr, nil
+	case lexicon.SchemaObject:
+		return g.genObject(id, s, depth+1)
+	case lexicon.SchemaRef:
+		return g.genRef(fullRef(id, s.Ref), depth)
+	case lexicon.SchemaUnion:
+		if len(s.Refs) == 0 {
+			return nil, fmt.Errorf("empty union")
+		}
+		ref := fullRef(id, s.Refs[g.Rand.Intn(len(s.Refs))])
+		v, err := g.genRef(ref, depth)
+		if err != nil {
+			return nil, err
+		}
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("union member is not an object: %s", ref)
+		}
+		obj["$type"] = strings.TrimSuffix(ref, "#main")
+		return obj, nil
+	case lexicon.SchemaUnknown:
+		return map[string]any{"text": g.words(3)}, nil
+	default:
+		return nil, fmt.Errorf("can't generate data for schema type: %T", def)
+	}
+}
+
+func (g *FixtureGen) genInteger(s lexicon.SchemaInteger) int64 {
+	if s.Const != nil {
+		return int64(*s.Const)
+	}
+	if len(s.Enum) > 0 {
+		return int64(s.Enum[g.Rand.Intn(len(s.Enum))])
+	}
+	lo, hi := 0, 1000
+	if s.Minimum != nil {
+		lo = *s.Minimum
+		hi = max(hi, lo)
+	}
+	if s.Maximum != nil {
+		hi = *s.Maximum
+		lo = min(lo, hi)
+	}
+	return int64(lo + g.Rand.Intn(hi-lo+1))
+}
+
+func (g *FixtureGen) genString(s lexicon.SchemaString) string {
+	if s.Const != nil {
+		return *s.Const
+	}
+	if len(s.Enum) > 0 {
+		return s.Enum[g.Rand.Intn(len(s.Enum))]
+	}
+	if len(s.KnownValues) > 0 {
+		return s.KnownValues[g.Rand.Intn(len(s.KnownValues))]
+	}
+	if s.Format != nil {
+		switch *s.Format {
+		case "did":
+			return g.DID().String()
+		case "handle", "at-identifier":
+			return g.Handle().String()
+		case "nsid":
+			return "com.example." + g.word()
+		case "at-uri":
+			return fmt.Sprintf("at://%s/app.bsky.feed.post/%s", g.DID(), g.RecordKey())
+		case "uri":
+			return fmt.Sprintf("https://%s.example.com/%s", g.word(), g.alnum(8))
+		case "cid":
+			return g.CID().String()
+		case "datetime":
+			return g.Datetime()
+		case "language":
+			return fixtureLangs[g.Rand.Intn(len(fixtureLangs))]
+		case "tid", "record-key":
+			return g.RecordKey().String()
+		}
+	}
+
+	// generated text is ASCII, so byte length and grapheme count are the same
+	lo, hi := 0, 200
+	for _, v := range []*int{s.MinLength, s.MinGraphemes} {
+		if v != nil {
+			lo = max(lo, *v)
+		}
+	}
+	for _, v := range []*int{s.MaxLength, s.MaxGraphemes} {
+		if v != nil {
+			hi = min(hi, *v)
+		}
+	}
+	hi = max(hi, lo)
+	n := lo + g.Rand.Intn(hi-lo+1)
+	text := g.words(1 + n/4)
+	for len(text) < n {
+		text += " " + g.word()
+	}
+	text = text[:n]
+	if strings.HasSuffix(text, " ") {
+		text = text[:n-1] + "s"
+	}
+	return text
+}
+
+func (g *FixtureGen) genBlob(s lexicon.SchemaBlob) data.Blob {
+	mimeType := "image/jpeg"
+	if len(s.Accept) > 0 {
+		accept := s.Accept[g.Rand.Intn(len(s.Accept))]
+		switch accept {
+		case "*/*", "image/*":
+		case "video/*":
+			mimeType = "video/mp4"
+		case "audio/*":
+			mimeType = "audio/mpeg"
+		default:
+			if strings.HasSuffix(accept, "/*") {
+				mimeType = strings.TrimSuffix(accept, "*") + "octet-stream"
+			} else {
+				mimeType = accept
+			}
+		}
+	}
+	maxSize := 1_000_000
+	if s.MaxSize != nil {
+		maxSize = *s.MaxSize
+	}
+	return data.Blob{
+		MimeType: mimeType,
+		Size:     int64(1 + g.Rand.Intn(maxSize)),
+		Ref:      g.cidLink(),
+	}
+}
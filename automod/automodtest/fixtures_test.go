@@ -0,0 +1,103 @@
+package automodtest
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/bluesky-social/indigo/atproto/lexicon"
+	"github.com/bluesky-social/indigo/automod/engine"
+	"github.com/bluesky-social/indigo/automod/rules"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFixturePosts(t *testing.T) {
+	assert := assert.New(t)
+
+	g := NewFixtureGen(1)
+	g.HashtagProb = 1
+	g.MentionProb = 1
+	g.LinkProb = 1
+	for range 50 {
+		post := g.Post(PostSpec{})
+		assert.NotEmpty(post.Text)
+		assert.NotEmpty(post.Facets)
+		for _, facet := range post.Facets {
+			span := post.Text[facet.Index.ByteStart:facet.Index.ByteEnd]
+			feat := facet.Features[0]
+			switch {
+			case feat.RichtextFacet_Tag != nil:
+				assert.Equal("#"+feat.RichtextFacet_Tag.Tag, span)
+			case feat.RichtextFacet_Link != nil:
+				assert.Equal(feat.RichtextFacet_Link.Uri, span)
+			case feat.RichtextFacet_Mention != nil:
+				assert.True(strings.HasPrefix(span, "@"))
+			}
+		}
+		_, err := g.CreateOp(g.DID(), "app.bsky.feed.post", post)
+		assert.NoError(err)
+	}
+
+	// same seed, same output
+	assert.Equal(NewFixtureGen(7).Post(PostSpec{}).Text, NewFixtureGen(7).Post(PostSpec{}).Text)
+
+	// pinned parts of the spec are used as-is
+	post := g.Post(PostSpec{Text: "hello", Hashtags: []string{"one"}, Mentions: nil})
+	assert.True(strings.HasPrefix(post.Text, "hello"))
+	assert.Contains(post.Text, "#one")
+}
+
+func TestFixtureRecords(t *testing.T) {
+	assert := assert.New(t)
+
+	cat := lexicon.NewBaseCatalog()
+	if err := cat.LoadDirectory("../../atproto/lexicon/testdata/catalog"); err != nil {
+		t.Fatal(err)
+	}
+	g := NewFixtureGen(1)
+	g.Catalog = &cat
+
+	for _, prob := range []float64{0, 0.5, 1} {
+		g.OptionalProb = prob
+		for range 20 {
+			rec, err := g.Record("example.lexicon.record")
+			if !assert.NoError(err) {
+				return
+			}
+			assert.NoError(lexicon.ValidateRecord(&cat, rec, "example.lexicon.record", 0))
+
+			// round-trips through CBOR, and still validates
+			b, _, err := g.RecordCBOR("example.lexicon.record")
+			assert.NoError(err)
+			out, err := data.UnmarshalCBOR(b)
+			assert.NoError(err)
+			assert.NoError(lexicon.ValidateRecord(&cat, out, "example.lexicon.record", 0))
+		}
+	}
+
+	_, err := g.Record("example.lexicon.query")
+	assert.Error(err)
+}
+
+func TestFixtureHarness(t *testing.T) {
+	ctx := context.Background()
+
+	h := NewHarness(t, engine.RuleSet{
+		PostRules: []engine.PostRuleFunc{rules.BadHashtagsPostRule},
+	})
+	h.Sets.Sets["bad-hashtags"] = map[string]bool{"slur": true}
+
+	g := NewFixtureGen(1)
+	ident := g.Identity()
+	h.AddAccount(ident.DID.String(), ident.Handle.String())
+
+	clean := g.Post(PostSpec{Hashtags: []string{"fine"}})
+	h.CreateRecord(ctx, ident.DID, "app.bsky.feed.post", g.RecordKey().String(), clean)
+	h.Ozone.AssertNoEvents(t)
+
+	bad := g.Post(PostSpec{Hashtags: []string{"slur"}})
+	uri := h.CreateRecord(ctx, ident.DID, "app.bsky.feed.post", g.RecordKey().String(), bad)
+	h.Ozone.AssertReport(t, uri.String(), engine.ReportReasonRude)
+}
@@ -0,0 +1,119 @@
+package automodtest
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/automod/cachestore"
+	"github.com/bluesky-social/indigo/automod/countstore"
+	"github.com/bluesky-social/indigo/automod/engine"
+	"github.com/bluesky-social/indigo/automod/flagstore"
+	"github.com/bluesky-social/indigo/automod/graphstore"
+	"github.com/bluesky-social/indigo/automod/setstore"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// DID the harness engine uses as its moderation account
+const HarnessModDID = "did:plc:automodtest"
+
+// Harness wires an automod Engine, with in-memory stores, to a FakeOzone and FakePDS
+type Harness struct {
+	Engine    *engine.Engine
+	Ozone     *FakeOzone
+	PDS       *FakePDS
+	Directory *identity.MockDirectory
+	// The in-memory stores used by the engine, for setting up fixtures and inspecting state
+	Sets     setstore.MemSetStore
+	Flags    flagstore.MemFlagStore
+	Counters countstore.MemCountStore
+
+	t testing.TB
+}
+
+// NewHarness creates an Engine running the given rules against fresh fake servers. The servers are shut down when the test completes.
+func NewHarness(t testing.TB, rules engine.RuleSet) *Harness {
+	ozone := NewFakeOzone(HarnessModDID)
+	pds := NewFakePDS()
+	t.Cleanup(func() {
+		ozone.Close()
+		pds.Close()
+	})
+
+	dir := identity.NewMockDirectory()
+	h := &Harness{
+		Ozone:     ozone,
+		PDS:       pds,
+		Directory: &dir,
+		Sets:      setstore.NewMemSetStore(),
+		Flags:     flagstore.NewMemFlagStore(),
+		Counters:  countstore.NewMemCountStore(),
+		t:         t,
+	}
+	h.Engine = &engine.Engine{
+		Logger:      slog.Default(),
+		Directory:   h.Directory,
+		Rules:       rules,
+		Counters:    h.Counters,
+		Sets:        h.Sets,
+		Cache:       cachestore.NewMemCacheStore(1000, time.Hour),
+		Flags:       h.Flags,
+		Graph:       graphstore.NewMemGraphStore(graphstore.DefaultWindow),
+		BskyClient:  pds.Client(),
+		OzoneClient: ozone.Client(),
+		AdminClient: pds.Client(),
+	}
+	return h
+}
+
+// AddAccount registers an account with the fake PDS (and AppView), and makes its identity resolvable
+func (h *Harness) AddAccount(did, handle string) identity.Identity {
+	ident := h.PDS.AddAccount(syntax.DID(did), syntax.Handle(handle))
+	h.Directory.Insert(ident)
+	return ident
+}
+
+// CreateRecord stores a record in the fake PDS, and processes the creation through the engine. Fails the test if the engine returns an error.
+func (h *Harness) CreateRecord(ctx context.Context, did syntax.DID, collection, rkey string, rec cbg.CBORMarshaler) syntax.ATURI {
+	h.t.Helper()
+	return h.writeRecord(ctx, engine.CreateOp, did, collection, rkey, rec)
+}
+
+// UpdateRecord is like CreateRecord, but for an update operation
+func (h *Harness) UpdateRecord(ctx context.Context, did syntax.DID, collection, rkey string, rec cbg.CBORMarshaler) syntax.ATURI {
+	h.t.Helper()
+	return h.writeRecord(ctx, engine.UpdateOp, did, collection, rkey, rec)
+}
+
+func (h *Harness) writeRecord(ctx context.Context, action string, did syntax.DID, collection, rkey string, rec cbg.CBORMarshaler) syntax.ATURI {
+	h.t.Helper()
+	op, err := NewRecordOp(action, did, collection, syntax.RecordKey(rkey), rec)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	h.PDS.PutRecord(op.ATURI(), *op.CID, &lexutil.LexiconTypeDecoder{Val: rec})
+	if err := h.Engine.ProcessRecordOp(ctx, op); err != nil {
+		h.t.Fatalf("processing record op: %v", err)
+	}
+	return op.ATURI()
+}
+
+// DeleteRecord removes a record from the fake PDS, and processes the deletion through the engine. Fails the test if the engine returns an error.
+func (h *Harness) DeleteRecord(ctx context.Context, uri syntax.ATURI) {
+	h.t.Helper()
+	op := engine.RecordOp{
+		Action:     engine.DeleteOp,
+		DID:        syntax.DID(uri.Authority().String()),
+		Collection: uri.Collection(),
+		RecordKey:  uri.RecordKey(),
+	}
+	h.PDS.DeleteRecord(uri)
+	if err := h.Engine.ProcessRecordOp(ctx, op); err != nil {
+		h.t.Fatalf("processing record delete: %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package automodtest
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	toolsozone "github.com/bluesky-social/indigo/api/ozone"
+	"github.com/bluesky-social/indigo/automod/engine"
+	"github.com/bluesky-social/indigo/automod/rules"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func spamPostRule(c *engine.RecordContext, post *appbsky.FeedPost) error {
+	if strings.Contains(post.Text, "buy now") {
+		c.AddRecordLabel("spam")
+		c.AddAccountTag("spammer")
+		c.ReportAccount(engine.ReportReasonSpam, "posted spam")
+	}
+	if strings.Contains(post.Text, "extremely bad") {
+		c.TakedownRecord()
+	}
+	return nil
+}
+
+func TestHarnessEffects(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	h := NewHarness(t, engine.RuleSet{
+		PostRules: []engine.PostRuleFunc{spamPostRule},
+	})
+	id := h.AddAccount("did:plc:abc111", "handle.example.com")
+	did := id.DID.String()
+
+	h.CreateRecord(ctx, id.DID, "app.bsky.feed.post", "3kabc111", &appbsky.FeedPost{Text: "hello world"})
+	h.Ozone.AssertNoEvents(t)
+
+	uri := h.CreateRecord(ctx, id.DID, "app.bsky.feed.post", "3kabc222", &appbsky.FeedPost{Text: "buy now!"})
+	h.Ozone.AssertLabel(t, uri.String(), "spam")
+	h.Ozone.AssertTag(t, did, "spammer")
+	h.Ozone.AssertReport(t, did, engine.ReportReasonSpam)
+
+	// second spam post: account report is de-duplicated against the fake's event history
+	h.CreateRecord(ctx, id.DID, "app.bsky.feed.post", "3kabc333", &appbsky.FeedPost{Text: "buy now again"})
+	h.Ozone.AssertReportCount(t, did, 1)
+
+	bad := h.CreateRecord(ctx, id.DID, "app.bsky.feed.post", "3kabc444", &appbsky.FeedPost{Text: "extremely bad"})
+	h.Ozone.AssertTakedown(t, bad.String())
+	assert.False(h.Ozone.TakenDown(did))
+}
+
+func TestHarnessExistingRule(t *testing.T) {
+	ctx := context.Background()
+
+	h := NewHarness(t, engine.RuleSet{
+		PostRules: []engine.PostRuleFunc{rules.BadHashtagsPostRule},
+	})
+	h.Sets.Sets["bad-hashtags"] = map[string]bool{"slur": true}
+	id := h.AddAccount("did:plc:abc111", "handle.example.com")
+
+	uri := h.CreateRecord(ctx, id.DID, "app.bsky.feed.post", "3kabc111", &appbsky.FeedPost{Text: "some post", Tags: []string{"slur"}})
+	h.Ozone.AssertReport(t, uri.String(), engine.ReportReasonRude)
+}
+
+func TestHarnessScriptedResponses(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	h := NewHarness(t, engine.RuleSet{
+		PostRules: []engine.PostRuleFunc{spamPostRule},
+	})
+	id := h.AddAccount("did:plc:abc111", "handle.example.com")
+	h.Ozone.SetRepo(&toolsozone.ModerationDefs_RepoViewDetail{
+		Did:    id.DID.String(),
+		Handle: id.Handle.String(),
+	})
+
+	// Ozone rejects the first event (the account tag); the engine logs and carries on
+	h.Ozone.Script("tools.ozone.moderation.emitEvent", ErrorResponse(http.StatusBadRequest, "InvalidRequest", "oops"))
+	uri := h.CreateRecord(ctx, id.DID, "app.bsky.feed.post", "3kabc111", &appbsky.FeedPost{Text: "buy now"})
+	assert.Equal(3, h.Ozone.Calls("tools.ozone.moderation.emitEvent"))
+	assert.Empty(h.Ozone.Tags(id.DID.String()))
+	h.Ozone.AssertLabel(t, uri.String(), "spam")
+	h.Ozone.AssertReport(t, id.DID.String(), "")
+
+	// configured repo views track emitted events, and are used to hydrate account metadata
+	h.CreateRecord(ctx, id.DID, "app.bsky.feed.post", "3kabc222", &appbsky.FeedPost{Text: "buy now"})
+	h.Ozone.AssertTag(t, id.DID.String(), "spammer")
+	am, err := h.Engine.GetAccountMeta(ctx, &id)
+	assert.NoError(err)
+	if assert.NotNil(am.Private) {
+		assert.Equal([]string{"spammer"}, am.Private.AccountTags)
+	}
+}
@@ -0,0 +1,282 @@
+package automodtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	toolsozone "github.com/bluesky-social/indigo/api/ozone"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// FakeOzone is an in-memory stand-in for an Ozone moderation service.
+//
+// It records every moderation event emitted to it, which can be inspected with Events or the Assert helpers, and serves them back from queryEvents (so report de-duplication works as it would against a real service). Repo and record views returned by getRepo and getRecord are configured with SetRepo and SetRecord; label, tag, and takedown events are applied to those views as they arrive.
+//
+// Implemented methods:
+//
+//   - tools.ozone.moderation.emitEvent
+//   - tools.ozone.moderation.queryEvents
+//   - tools.ozone.moderation.getRepo
+//   - tools.ozone.moderation.getRecord
+type FakeOzone struct {
+	*FakeServer
+
+	// DID of the moderation account the engine acts as. Events are recorded with this as CreatedBy.
+	ModDID string
+
+	events  []*toolsozone.ModerationDefs_ModEventView
+	repos   map[string]*toolsozone.ModerationDefs_RepoViewDetail
+	records map[string]*toolsozone.ModerationDefs_RecordViewDetail
+}
+
+func NewFakeOzone(modDID string) *FakeOzone {
+	fo := &FakeOzone{
+		FakeServer: newFakeServer(),
+		ModDID:     modDID,
+		repos:      make(map[string]*toolsozone.ModerationDefs_RepoViewDetail),
+		records:    make(map[string]*toolsozone.ModerationDefs_RecordViewDetail),
+	}
+	fo.handle("tools.ozone.moderation.emitEvent", fo.handleEmitEvent)
+	fo.handle("tools.ozone.moderation.queryEvents", fo.handleQueryEvents)
+	fo.handle("tools.ozone.moderation.getRepo", fo.handleGetRepo)
+	fo.handle("tools.ozone.moderation.getRecord", fo.handleGetRecord)
+	return fo
+}
+
+// Returns a client configured to act as the moderation account, suitable for Engine.OzoneClient
+func (fo *FakeOzone) Client() *xrpc.Client {
+	return &xrpc.Client{
+		Host: fo.URL(),
+		Auth: &xrpc.AuthInfo{Did: fo.ModDID},
+	}
+}
+
+// Configures the view returned by getRepo for an account. Without one, getRepo returns RepoNotFound.
+func (fo *FakeOzone) SetRepo(rv *toolsozone.ModerationDefs_RepoViewDetail) {
+	fo.mu.Lock()
+	defer fo.mu.Unlock()
+	if rv.Moderation == nil {
+		rv.Moderation = &toolsozone.ModerationDefs_ModerationDetail{}
+	}
+	fo.repos[rv.Did] = rv
+}
+
+// Configures the view returned by getRecord for a record. Without one, getRecord returns RecordNotFound.
+func (fo *FakeOzone) SetRecord(rv *toolsozone.ModerationDefs_RecordViewDetail) {
+	fo.mu.Lock()
+	defer fo.mu.Unlock()
+	if rv.Moderation == nil {
+		rv.Moderation = &toolsozone.ModerationDefs_ModerationDetail{}
+	}
+	fo.records[rv.Uri] = rv
+}
+
+// Returns a copy of all moderation events emitted so far, oldest first
+func (fo *FakeOzone) Events() []*toolsozone.ModerationDefs_ModEventView {
+	fo.mu.Lock()
+	defer fo.mu.Unlock()
+	return slices.Clone(fo.events)
+}
+
+// Removes all recorded events (but not repo or record views)
+func (fo *FakeOzone) Reset() {
+	fo.mu.Lock()
+	defer fo.mu.Unlock()
+	fo.events = nil
+}
+
+// Returns the DID or AT-URI string of an event subject
+func eventSubject(evt *toolsozone.ModerationDefs_ModEventView) string {
+	if evt.Subject == nil {
+		return ""
+	}
+	switch {
+	case evt.Subject.AdminDefs_RepoRef != nil:
+		return evt.Subject.AdminDefs_RepoRef.Did
+	case evt.Subject.RepoStrongRef != nil:
+		return evt.Subject.RepoStrongRef.Uri
+	default:
+		return ""
+	}
+}
+
+// Returns the lexicon type of an event, eg "tools.ozone.moderation.defs#modEventReport"
+func eventType(evt *toolsozone.ModerationDefs_ModEventView) string {
+	if evt.Event == nil {
+		return ""
+	}
+	e := evt.Event
+	switch {
+	case e.ModerationDefs_ModEventTakedown != nil:
+		return "tools.ozone.moderation.defs#modEventTakedown"
+	case e.ModerationDefs_ModEventReverseTakedown != nil:
+		return "tools.ozone.moderation.defs#modEventReverseTakedown"
+	case e.ModerationDefs_ModEventComment != nil:
+		return "tools.ozone.moderation.defs#modEventComment"
+	case e.ModerationDefs_ModEventReport != nil:
+		return "tools.ozone.moderation.defs#modEventReport"
+	case e.ModerationDefs_ModEventLabel != nil:
+		return "tools.ozone.moderation.defs#modEventLabel"
+	case e.ModerationDefs_ModEventAcknowledge != nil:
+		return "tools.ozone.moderation.defs#modEventAcknowledge"
+	case e.ModerationDefs_ModEventEscalate != nil:
+		return "tools.ozone.moderation.defs#modEventEscalate"
+	case e.ModerationDefs_ModEventMute != nil:
+		return "tools.ozone.moderation.defs#modEventMute"
+	case e.ModerationDefs_ModEventUnmute != nil:
+		return "tools.ozone.moderation.defs#modEventUnmute"
+	case e.ModerationDefs_ModEventEmail != nil:
+		return "tools.ozone.moderation.defs#modEventEmail"
+	case e.ModerationDefs_ModEventTag != nil:
+		return "tools.ozone.moderation.defs#modEventTag"
+	default:
+		return ""
+	}
+}
+
+func (fo *FakeOzone) handleEmitEvent(w http.ResponseWriter, r *http.Request) {
+	var input toolsozone.ModerationEmitEvent_Input
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidRequest", err.Error())
+		return
+	}
+	if input.Event == nil || input.Subject == nil {
+		writeError(w, http.StatusBadRequest, "InvalidRequest", "event and subject are required")
+		return
+	}
+
+	// the input and view union types share lexicon type IDs, so round-trip through JSON to convert
+	var view toolsozone.ModerationDefs_ModEventView
+	if err := convertJSON(input.Event, &view.Event); err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidRequest", err.Error())
+		return
+	}
+	if err := convertJSON(input.Subject, &view.Subject); err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidRequest", err.Error())
+		return
+	}
+	view.CreatedBy = input.CreatedBy
+	view.CreatedAt = syntax.DatetimeNow().String()
+	view.SubjectBlobCids = input.SubjectBlobCids
+	if view.SubjectBlobCids == nil {
+		view.SubjectBlobCids = []string{}
+	}
+
+	fo.mu.Lock()
+	defer fo.mu.Unlock()
+	view.Id = int64(len(fo.events) + 1)
+	fo.events = append(fo.events, &view)
+	fo.applyEvent(&view)
+
+	writeJSON(w, http.StatusOK, &view)
+}
+
+// updates any configured repo or record view with the effects of an event. caller must hold the lock.
+func (fo *FakeOzone) applyEvent(evt *toolsozone.ModerationDefs_ModEventView) {
+	subj := eventSubject(evt)
+	var labels *[]*comatproto.LabelDefs_Label
+	var mod *toolsozone.ModerationDefs_ModerationDetail
+	if rv, ok := fo.repos[subj]; ok {
+		labels, mod = &rv.Labels, rv.Moderation
+	} else if rv, ok := fo.records[subj]; ok {
+		labels, mod = &rv.Labels, rv.Moderation
+	} else {
+		return
+	}
+	if mod.SubjectStatus == nil {
+		mod.SubjectStatus = &toolsozone.ModerationDefs_SubjectStatusView{}
+	}
+	status := mod.SubjectStatus
+
+	switch e := evt.Event; {
+	case e.ModerationDefs_ModEventLabel != nil:
+		for _, val := range e.ModerationDefs_ModEventLabel.CreateLabelVals {
+			*labels = append(*labels, &comatproto.LabelDefs_Label{
+				Src: fo.ModDID,
+				Uri: subj,
+				Val: val,
+				Cts: evt.CreatedAt,
+			})
+		}
+		for _, val := range e.ModerationDefs_ModEventLabel.NegateLabelVals {
+			*labels = slices.DeleteFunc(*labels, func(l *comatproto.LabelDefs_Label) bool { return l.Val == val })
+		}
+	case e.ModerationDefs_ModEventTag != nil:
+		for _, tag := range e.ModerationDefs_ModEventTag.Add {
+			if !slices.Contains(status.Tags, tag) {
+				status.Tags = append(status.Tags, tag)
+			}
+		}
+		for _, tag := range e.ModerationDefs_ModEventTag.Remove {
+			status.Tags = slices.DeleteFunc(status.Tags, func(t string) bool { return t == tag })
+		}
+	case e.ModerationDefs_ModEventTakedown != nil:
+		t := true
+		status.Takendown = &t
+	case e.ModerationDefs_ModEventReverseTakedown != nil:
+		f := false
+		status.Takendown = &f
+	}
+}
+
+func (fo *FakeOzone) handleQueryEvents(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	subject := q.Get("subject")
+	createdBy := q.Get("createdBy")
+	types := q["types"]
+
+	fo.mu.Lock()
+	defer fo.mu.Unlock()
+	out := toolsozone.ModerationQueryEvents_Output{
+		Events: []*toolsozone.ModerationDefs_ModEventView{},
+	}
+	// newest first, like the real service
+	for i := len(fo.events) - 1; i >= 0; i-- {
+		evt := fo.events[i]
+		if subject != "" && eventSubject(evt) != subject {
+			continue
+		}
+		if createdBy != "" && evt.CreatedBy != createdBy {
+			continue
+		}
+		if len(types) > 0 && !slices.Contains(types, eventType(evt)) {
+			continue
+		}
+		out.Events = append(out.Events, evt)
+	}
+
+	writeJSON(w, http.StatusOK, &out)
+}
+
+func (fo *FakeOzone) handleGetRepo(w http.ResponseWriter, r *http.Request) {
+	fo.mu.Lock()
+	defer fo.mu.Unlock()
+	rv, ok := fo.repos[r.URL.Query().Get("did")]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "RepoNotFound", "repo not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, rv)
+}
+
+func (fo *FakeOzone) handleGetRecord(w http.ResponseWriter, r *http.Request) {
+	fo.mu.Lock()
+	defer fo.mu.Unlock()
+	rv, ok := fo.records[r.URL.Query().Get("uri")]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "RecordNotFound", "record not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, rv)
+}
+
+func convertJSON(from, to any) error {
+	b, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, to)
+}
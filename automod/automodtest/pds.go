@@ -0,0 +1,258 @@
+package automodtest
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+type fakeRecord struct {
+	uri   syntax.ATURI
+	cid   string
+	value *lexutil.LexiconTypeDecoder
+}
+
+// FakePDS is an in-memory stand-in for the upstream services automod reads account and record data from: the account's PDS, the AppView, and the PDS/entryway admin API. A single fake serves all of them, and can be used for Engine.BskyClient and Engine.AdminClient as well as the PDS service endpoint of test identities.
+//
+// Implemented methods:
+//
+//   - com.atproto.repo.getRecord
+//   - com.atproto.repo.listRecords
+//   - com.atproto.sync.getBlob
+//   - com.atproto.admin.getAccountInfo
+//   - app.bsky.actor.getProfile
+//   - app.bsky.graph.getRelationships
+type FakePDS struct {
+	*FakeServer
+
+	profiles      map[string]*appbsky.ActorDefs_ProfileViewDetailed
+	accounts      map[string]*comatproto.AdminDefs_AccountView
+	records       map[string]*fakeRecord
+	blobs         map[string][]byte
+	relationships map[string]*appbsky.GraphDefs_Relationship
+}
+
+func NewFakePDS() *FakePDS {
+	fp := &FakePDS{
+		FakeServer:    newFakeServer(),
+		profiles:      make(map[string]*appbsky.ActorDefs_ProfileViewDetailed),
+		accounts:      make(map[string]*comatproto.AdminDefs_AccountView),
+		records:       make(map[string]*fakeRecord),
+		blobs:         make(map[string][]byte),
+		relationships: make(map[string]*appbsky.GraphDefs_Relationship),
+	}
+	fp.handle("com.atproto.repo.getRecord", fp.handleGetRecord)
+	fp.handle("com.atproto.repo.listRecords", fp.handleListRecords)
+	fp.handle("com.atproto.sync.getBlob", fp.handleGetBlob)
+	fp.handle("com.atproto.admin.getAccountInfo", fp.handleGetAccountInfo)
+	fp.handle("app.bsky.actor.getProfile", fp.handleGetProfile)
+	fp.handle("app.bsky.graph.getRelationships", fp.handleGetRelationships)
+	return fp
+}
+
+// Returns an unauthenticated client for the server, suitable for Engine.BskyClient and Engine.AdminClient
+func (fp *FakePDS) Client() *xrpc.Client {
+	return &xrpc.Client{
+		Host: fp.URL(),
+	}
+}
+
+// AddAccount registers an account with a minimal profile and admin account view (both created now), and returns an identity with this server as its PDS endpoint.
+func (fp *FakePDS) AddAccount(did syntax.DID, handle syntax.Handle) identity.Identity {
+	now := syntax.DatetimeNow().String()
+	fp.SetProfile(&appbsky.ActorDefs_ProfileViewDetailed{
+		Did:       did.String(),
+		Handle:    handle.String(),
+		CreatedAt: &now,
+	})
+	fp.SetAccountInfo(&comatproto.AdminDefs_AccountView{
+		Did:       did.String(),
+		Handle:    handle.String(),
+		IndexedAt: now,
+	})
+	return identity.Identity{
+		DID:    did,
+		Handle: handle,
+		Services: map[string]identity.Service{
+			"atproto_pds": {
+				Type: "AtprotoPersonalDataServer",
+				URL:  fp.URL(),
+			},
+		},
+	}
+}
+
+// Configures the AppView profile returned by getProfile. Without one, getProfile returns a 400 error.
+func (fp *FakePDS) SetProfile(pv *appbsky.ActorDefs_ProfileViewDetailed) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	fp.profiles[pv.Did] = pv
+}
+
+// Configures the private account metadata returned by admin getAccountInfo
+func (fp *FakePDS) SetAccountInfo(av *comatproto.AdminDefs_AccountView) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	fp.accounts[av.Did] = av
+}
+
+// Stores a record, to be returned by getRecord and listRecords
+func (fp *FakePDS) PutRecord(uri syntax.ATURI, cid syntax.CID, val *lexutil.LexiconTypeDecoder) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	fp.records[uri.String()] = &fakeRecord{uri: uri, cid: cid.String(), value: val}
+}
+
+func (fp *FakePDS) DeleteRecord(uri syntax.ATURI) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	delete(fp.records, uri.String())
+}
+
+// Stores blob data, to be returned by getBlob (regardless of which account is requested)
+func (fp *FakePDS) PutBlob(cid string, data []byte) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	fp.blobs[cid] = data
+}
+
+// Configures the relationship between two accounts, as returned by getRelationships. Unconfigured pairs are returned with no follow relationship.
+func (fp *FakePDS) SetRelationship(actor syntax.DID, rel *appbsky.GraphDefs_Relationship) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	fp.relationships[actor.String()+" "+rel.Did] = rel
+}
+
+func (fp *FakePDS) handleGetRecord(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	uri := "at://" + q.Get("repo") + "/" + q.Get("collection") + "/" + q.Get("rkey")
+
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	rec, ok := fp.records[uri]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "RecordNotFound", "record not found: "+uri)
+		return
+	}
+	writeJSON(w, http.StatusOK, &comatproto.RepoGetRecord_Output{
+		Uri:   uri,
+		Cid:   &rec.cid,
+		Value: rec.value,
+	})
+}
+
+func (fp *FakePDS) handleListRecords(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	repo := q.Get("repo")
+	collection := q.Get("collection")
+	limit := 50
+	if l, err := strconv.Atoi(q.Get("limit")); err == nil && l > 0 {
+		limit = l
+	}
+
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	var matches []*fakeRecord
+	for _, rec := range fp.records {
+		if rec.uri.Authority().String() == repo && rec.uri.Collection().String() == collection {
+			matches = append(matches, rec)
+		}
+	}
+	// newest (highest TID) first, the default order of the real endpoint. cursors are not supported.
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].uri.RecordKey() > matches[j].uri.RecordKey()
+	})
+	if q.Get("reverse") == "true" {
+		for i, j := 0, len(matches)-1; i < j; i, j = i+1, j-1 {
+			matches[i], matches[j] = matches[j], matches[i]
+		}
+	}
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+
+	out := comatproto.RepoListRecords_Output{
+		Records: []*comatproto.RepoListRecords_Record{},
+	}
+	for _, rec := range matches {
+		out.Records = append(out.Records, &comatproto.RepoListRecords_Record{
+			Uri:   rec.uri.String(),
+			Cid:   rec.cid,
+			Value: rec.value,
+		})
+	}
+	writeJSON(w, http.StatusOK, &out)
+}
+
+func (fp *FakePDS) handleGetBlob(w http.ResponseWriter, r *http.Request) {
+	fp.mu.Lock()
+	data, ok := fp.blobs[r.URL.Query().Get("cid")]
+	fp.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusBadRequest, "BlobNotFound", "blob not found")
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(data)
+}
+
+func (fp *FakePDS) handleGetAccountInfo(w http.ResponseWriter, r *http.Request) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	av, ok := fp.accounts[r.URL.Query().Get("did")]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "AccountNotFound", "account not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, av)
+}
+
+func (fp *FakePDS) handleGetProfile(w http.ResponseWriter, r *http.Request) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	actor := r.URL.Query().Get("actor")
+	pv, ok := fp.profiles[actor]
+	if !ok {
+		// also allow lookup by handle
+		for _, p := range fp.profiles {
+			if p.Handle == actor {
+				pv, ok = p, true
+				break
+			}
+		}
+	}
+	if !ok {
+		writeError(w, http.StatusBadRequest, "InvalidRequest", "Profile not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, pv)
+}
+
+func (fp *FakePDS) handleGetRelationships(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	actor := q.Get("actor")
+
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	out := appbsky.GraphGetRelationships_Output{
+		Actor:         &actor,
+		Relationships: []*appbsky.GraphGetRelationships_Output_Relationships_Elem{},
+	}
+	for _, other := range q["others"] {
+		rel, ok := fp.relationships[actor+" "+other]
+		if !ok {
+			rel = &appbsky.GraphDefs_Relationship{Did: other}
+		}
+		out.Relationships = append(out.Relationships, &appbsky.GraphGetRelationships_Output_Relationships_Elem{
+			GraphDefs_Relationship: rel,
+		})
+	}
+	writeJSON(w, http.StatusOK, &out)
+}
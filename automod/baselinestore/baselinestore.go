@@ -0,0 +1,139 @@
+package baselinestore
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// DefaultAlpha is the default EWMA smoothing factor: the weight given to each newly completed hour (or, for hour-of-day averages, day)
+const DefaultAlpha = 0.1
+
+// MinSamples is the number of completed hours a baseline needs before its scores are meaningful. Scores are zero until then.
+const MinSamples = 24
+
+// MinHourOfDaySamples is the number of days of history for an hour of the day before that hour's own average is used as the expected count
+const MinHourOfDaySamples = 3
+
+// MaxGap is the longest idle period, in hours, which is folded in to a baseline hour-by-hour. After longer gaps, earlier hours have decayed away anyway.
+const MaxGap = 7 * 24
+
+// BaselineStore is an interface for maintaining hourly rate baselines, keyed by a namespace ("name") and a key within it (eg, an account DID).
+//
+// Baselines roll forward lazily: hours are folded in to the averages when the baseline is next observed or read. Hours with no observations count as zero.
+type BaselineStore interface {
+	// records "n" events at time "t"
+	Observe(ctx context.Context, name, key string, t time.Time, n float64) error
+	// returns the baseline as of time "t", with Count being the number of events so far in t's hour. Returns the zero Baseline if nothing has been observed.
+	GetBaseline(ctx context.Context, name, key string, t time.Time) (Baseline, error)
+}
+
+// Baseline is an adaptive estimate of the hourly rate of some event
+type Baseline struct {
+	// start of the current (incomplete) hour
+	Hour time.Time `json:"hour"`
+	// events so far in the current hour
+	Count float64 `json:"count"`
+	// EWMA of hourly counts, and of their variance, over completed hours
+	Mean     float64 `json:"mean"`
+	Variance float64 `json:"variance"`
+	// number of completed hours folded in to Mean and Variance
+	Samples int `json:"samples"`
+	// EWMA of hourly counts for each UTC hour of the day, and the number of days folded in to each
+	HourOfDay        [24]float64 `json:"hourOfDay"`
+	HourOfDaySamples [24]int     `json:"hourOfDaySamples"`
+}
+
+func hourOf(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Hour)
+}
+
+// adds a completed hour's count to the averages
+func (b *Baseline) fold(hour time.Time, count, alpha float64) {
+	if b.Samples == 0 {
+		b.Mean = count
+		b.Variance = 0
+	} else {
+		diff := count - b.Mean
+		incr := alpha * diff
+		b.Mean += incr
+		b.Variance = (1 - alpha) * (b.Variance + diff*incr)
+	}
+	b.Samples++
+
+	h := hour.Hour()
+	if b.HourOfDaySamples[h] == 0 {
+		b.HourOfDay[h] = count
+	} else {
+		b.HourOfDay[h] += alpha * (count - b.HourOfDay[h])
+	}
+	b.HourOfDaySamples[h]++
+}
+
+// rolls the baseline forward to the hour containing "t", folding in every completed hour since the current one. "count" returns the number of events in a completed hour.
+func (b *Baseline) advance(t time.Time, alpha float64, count func(hour time.Time) float64) {
+	to := hourOf(t)
+	if b.Hour.IsZero() {
+		b.Hour = to
+		return
+	}
+	if !to.After(b.Hour) {
+		return
+	}
+	from := b.Hour
+	if to.Sub(from) > MaxGap*time.Hour {
+		from = to.Add(-MaxGap * time.Hour)
+	}
+	for h := from; h.Before(to); h = h.Add(time.Hour) {
+		b.fold(h, count(h), alpha)
+	}
+	b.Hour = to
+	b.Count = 0
+}
+
+// hours between the baseline's current hour and the hour containing "t" (exclusive), which would be folded in by advance
+func (b *Baseline) pendingHours(t time.Time) []time.Time {
+	to := hourOf(t)
+	if b.Hour.IsZero() || !to.After(b.Hour) {
+		return nil
+	}
+	from := b.Hour
+	if to.Sub(from) > MaxGap*time.Hour {
+		from = to.Add(-MaxGap * time.Hour)
+	}
+	var out []time.Time
+	for h := from; h.Before(to); h = h.Add(time.Hour) {
+		out = append(out, h)
+	}
+	return out
+}
+
+// Standard deviation of hourly counts. Floored at one event, so that baselines with very regular (or no) activity don't turn small changes in to huge scores.
+func (b Baseline) StdDev() float64 {
+	return math.Max(math.Sqrt(b.Variance), 1)
+}
+
+// Expected count for the current hour: the average for this hour of the day if there are enough days of history, otherwise the overall average.
+func (b Baseline) Expected() float64 {
+	h := b.Hour.Hour()
+	if b.HourOfDaySamples[h] >= MinHourOfDaySamples {
+		return b.HourOfDay[h]
+	}
+	return b.Mean
+}
+
+// Number of standard deviations the current hour's count (so far) is above the average hourly count. Zero if there is less than MinSamples hours of history.
+func (b Baseline) ZScore() float64 {
+	if b.Samples < MinSamples {
+		return 0
+	}
+	return (b.Count - b.Mean) / b.StdDev()
+}
+
+// Like ZScore, but relative to the expected count for this hour of the day (see Expected), so that activity at unusual times of day stands out.
+func (b Baseline) TimeOfDayZScore() float64 {
+	if b.Samples < MinSamples {
+		return 0
+	}
+	return (b.Count - b.Expected()) / b.StdDev()
+}
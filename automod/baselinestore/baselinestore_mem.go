@@ -0,0 +1,57 @@
+package baselinestore
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemBaselineStore is an in-process BaselineStore. It grows without bound, and is intended for testing and other non-production use.
+type MemBaselineStore struct {
+	Alpha float64
+
+	lk        sync.Mutex
+	baselines map[string]*Baseline
+}
+
+func NewMemBaselineStore(alpha float64) *MemBaselineStore {
+	return &MemBaselineStore{
+		Alpha:     alpha,
+		baselines: make(map[string]*Baseline),
+	}
+}
+
+func (s *MemBaselineStore) Observe(ctx context.Context, name, key string, t time.Time, n float64) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	k := name + "/" + key
+	b, ok := s.baselines[k]
+	if !ok {
+		b = &Baseline{}
+		s.baselines[k] = b
+	}
+	b.advance(t, s.Alpha, b.countFor)
+	// observations from an hour which has already been folded in count toward the current hour
+	b.Count += n
+	return nil
+}
+
+func (s *MemBaselineStore) GetBaseline(ctx context.Context, name, key string, t time.Time) (Baseline, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	b, ok := s.baselines[name+"/"+key]
+	if !ok {
+		return Baseline{}, nil
+	}
+	out := *b
+	out.advance(t, s.Alpha, b.countFor)
+	return out, nil
+}
+
+// count for a completed hour, from a baseline which keeps the count of its current hour
+func (b *Baseline) countFor(hour time.Time) float64 {
+	if hour.Equal(b.Hour) {
+		return b.Count
+	}
+	return 0
+}
@@ -0,0 +1,177 @@
+package baselinestore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var redisBaselinePrefix string = "baseline/"
+var redisBaselineCountPrefix string = "baseline-count/"
+
+// hourly counts are kept long enough to be folded in after the longest gap
+var redisBaselineCountTTL = (MaxGap + 24) * time.Hour
+
+// RedisBaselineStore counts events in a separate key per hour, incremented atomically, and keeps the averages as a JSON-encoded Baseline which is only rewritten (in an optimistic transaction) when a new hour starts. This keeps the hot path to a single increment, even for busy baselines.
+type RedisBaselineStore struct {
+	Client *redis.Client
+	Alpha  float64
+}
+
+func NewRedisBaselineStore(redisURL string, alpha float64) (*RedisBaselineStore, error) {
+	ctx := context.Background()
+	opt, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	rdb := redis.NewClient(opt)
+	// check redis connection
+	_, err = rdb.Ping(ctx).Result()
+	if err != nil {
+		return nil, err
+	}
+	rbs := RedisBaselineStore{
+		Client: rdb,
+		Alpha:  alpha,
+	}
+	return &rbs, nil
+}
+
+func redisCountKey(name, key string, hour time.Time) string {
+	return redisBaselineCountPrefix + name + "/" + key + "/" + strconv.FormatInt(hour.Unix(), 10)
+}
+
+func (s *RedisBaselineStore) getState(ctx context.Context, c redis.Cmdable, stateKey string) (*Baseline, error) {
+	raw, err := c.Get(ctx, stateKey).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var b Baseline
+	if err := json.Unmarshal(raw, &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// fetches the counts for the given hours
+func (s *RedisBaselineStore) getCounts(ctx context.Context, c redis.Cmdable, name, key string, hours []time.Time) (map[time.Time]float64, error) {
+	out := make(map[time.Time]float64, len(hours))
+	if len(hours) == 0 {
+		return out, nil
+	}
+	keys := make([]string, len(hours))
+	for i, h := range hours {
+		keys[i] = redisCountKey(name, key, h)
+	}
+	vals, err := c.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range vals {
+		str, ok := v.(string)
+		if !ok {
+			continue
+		}
+		f, err := strconv.ParseFloat(str, 64)
+		if err != nil {
+			return nil, err
+		}
+		out[hours[i]] = f
+	}
+	return out, nil
+}
+
+// rolls the stored baseline forward to the hour containing "t", returning the current hour. Usually the baseline is already current, and this is a single read.
+func (s *RedisBaselineStore) advance(ctx context.Context, name, key string, t time.Time) (time.Time, error) {
+	stateKey := redisBaselinePrefix + name + "/" + key
+	b, err := s.getState(ctx, s.Client, stateKey)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if b != nil && len(b.pendingHours(t)) == 0 {
+		return b.Hour, nil
+	}
+
+	var current time.Time
+	txf := func(tx *redis.Tx) error {
+		b, err := s.getState(ctx, tx, stateKey)
+		if err != nil {
+			return err
+		}
+		if b == nil {
+			b = &Baseline{Hour: hourOf(t)}
+		} else {
+			pending := b.pendingHours(t)
+			if len(pending) == 0 {
+				current = b.Hour
+				return nil
+			}
+			counts, err := s.getCounts(ctx, tx, name, key, pending)
+			if err != nil {
+				return err
+			}
+			b.advance(t, s.Alpha, func(h time.Time) float64 { return counts[h] })
+		}
+		current = b.Hour
+		raw, err := json.Marshal(b)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, stateKey, raw, 0)
+			return nil
+		})
+		return err
+	}
+	for i := 0; i < 3; i++ {
+		err := s.Client.Watch(ctx, txf, stateKey)
+		if errors.Is(err, redis.TxFailedErr) {
+			// another process rolled the baseline forward first
+			continue
+		}
+		return current, err
+	}
+	return hourOf(t), nil
+}
+
+func (s *RedisBaselineStore) Observe(ctx context.Context, name, key string, t time.Time, n float64) error {
+	hour, err := s.advance(ctx, name, key, t)
+	if err != nil {
+		return err
+	}
+	// observations from an hour which has already been folded in count toward the current hour
+	if h := hourOf(t); h.After(hour) {
+		hour = h
+	}
+	countKey := redisCountKey(name, key, hour)
+	multi := s.Client.Pipeline()
+	multi.IncrByFloat(ctx, countKey, n)
+	multi.Expire(ctx, countKey, redisBaselineCountTTL)
+	_, err = multi.Exec(ctx)
+	return err
+}
+
+func (s *RedisBaselineStore) GetBaseline(ctx context.Context, name, key string, t time.Time) (Baseline, error) {
+	b, err := s.getState(ctx, s.Client, redisBaselinePrefix+name+"/"+key)
+	if err != nil || b == nil {
+		return Baseline{}, err
+	}
+	pending := b.pendingHours(t)
+	to := hourOf(t)
+	if to.Before(b.Hour) {
+		to = b.Hour
+	}
+	counts, err := s.getCounts(ctx, s.Client, name, key, append(pending, to))
+	if err != nil {
+		return Baseline{}, err
+	}
+	b.advance(t, s.Alpha, func(h time.Time) float64 { return counts[h] })
+	b.Count = counts[b.Hour]
+	return *b, nil
+}
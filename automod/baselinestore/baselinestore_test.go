@@ -0,0 +1,90 @@
+package baselinestore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemBaselineStoreBasics(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	bs := NewMemBaselineStore(DefaultAlpha)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	b, err := bs.GetBaseline(ctx, "account", "did:plc:abc", start)
+	assert.NoError(err)
+	assert.Equal(0, b.Samples)
+	assert.Equal(0.0, b.ZScore())
+
+	// two days of steady activity: 5 events every hour, plus 20 extra at 09:00 UTC each day
+	for h := 0; h < 48; h++ {
+		hour := start.Add(time.Duration(h) * time.Hour)
+		n := 5.0
+		if hour.Hour() == 9 {
+			n += 20
+		}
+		assert.NoError(bs.Observe(ctx, "account", "did:plc:abc", hour.Add(10*time.Minute), n))
+	}
+
+	// reading rolls the last hour in, without changing the stored baseline
+	now := start.Add(48*time.Hour + 30*time.Minute)
+	b, err = bs.GetBaseline(ctx, "account", "did:plc:abc", now)
+	assert.NoError(err)
+	assert.Equal(48, b.Samples)
+	assert.Equal(0.0, b.Count)
+	assert.InDelta(5.0, b.Mean, 1.5)
+	assert.Less(b.ZScore(), 0.0)
+
+	// a burst in the current hour stands out
+	assert.NoError(bs.Observe(ctx, "account", "did:plc:abc", now, 40))
+	b, err = bs.GetBaseline(ctx, "account", "did:plc:abc", now)
+	assert.NoError(err)
+	assert.Equal(40.0, b.Count)
+	assert.Greater(b.ZScore(), 3.0)
+
+	// other keys are separate
+	b, err = bs.GetBaseline(ctx, "account", "did:plc:other", now)
+	assert.NoError(err)
+	assert.Equal(0, b.Samples)
+
+	// idle hours count as zero
+	b, err = bs.GetBaseline(ctx, "account", "did:plc:abc", now.Add(30*24*time.Hour))
+	assert.NoError(err)
+	assert.Less(b.Mean, 0.1)
+	assert.Equal(49+MaxGap-1, b.Samples)
+}
+
+func TestBaselineTimeOfDay(t *testing.T) {
+	assert := assert.New(t)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := Baseline{Hour: start}
+	// an account which is only ever active at 14:00 UTC
+	for d := 0; d < 5; d++ {
+		day := start.Add(time.Duration(d) * 24 * time.Hour)
+		for h := 0; h < 24; h++ {
+			hour := day.Add(time.Duration(h) * time.Hour)
+			count := 0.0
+			if h == 14 {
+				count = 30
+			}
+			b.advance(hour, DefaultAlpha, b.countFor)
+			b.Count = count
+		}
+	}
+	b.advance(start.Add(5*24*time.Hour+14*time.Hour), DefaultAlpha, b.countFor)
+	assert.Equal(14, b.Hour.Hour())
+	assert.InDelta(30.0, b.Expected(), 1.0)
+
+	// 30 events at 14:00 is normal for this time of day, but not at 03:00
+	b.Count = 30
+	assert.Less(b.TimeOfDayZScore(), 1.0)
+	b.advance(b.Hour.Add(13*time.Hour), DefaultAlpha, b.countFor)
+	assert.Equal(3, b.Hour.Hour())
+	b.Count = 30
+	assert.Greater(b.TimeOfDayZScore(), 3.0)
+}
@@ -0,0 +1,6 @@
+// Automod component for adaptive rate baselines.
+//
+// A baseline tracks how often something happens (eg, records created by one account, or in one collection) per hour, as exponentially weighted moving averages: an overall mean and variance, and a mean for each UTC hour of the day. Rules can compare the current hour's count against the baseline (a z-score) to detect bursts relative to what is normal for that account or collection, instead of using fixed thresholds.
+//
+// Includes an interface and implementations using redis and in-process memory.
+package baselinestore
@@ -0,0 +1,56 @@
+package canarystore
+
+import (
+	"context"
+	"time"
+)
+
+// Interaction kinds recorded by the engine
+const (
+	KindFollow  = "follow"
+	KindReply   = "reply"
+	KindMention = "mention"
+	KindQuote   = "quote"
+	KindLike    = "like"
+	KindRepost  = "repost"
+	KindBlock   = "block"
+)
+
+// DefaultWindow is the default period of time for which hits are retained
+const DefaultWindow = 30 * 24 * time.Hour
+
+// MaxHitsPerActor bounds the number of hits retained for any single account, keeping the most recent
+const MaxHitsPerActor = 100
+
+// A single interaction by an account with a canary
+type Hit struct {
+	// DID or AT-URI of the canary
+	Canary string `json:"canary"`
+	// DID of the interacting account
+	Actor string    `json:"actor"`
+	Kind  string    `json:"kind"`
+	Time  time.Time `json:"time"`
+}
+
+// CanaryStore is an interface for designating canary subjects (DIDs or AT-URIs), and recording interactions with them.
+//
+// Designations are permanent until removed. Hits are only retained for a fixed time window (configured when the store is created), and are returned most recent first.
+type CanaryStore interface {
+	IsCanary(ctx context.Context, subject string) (bool, error)
+	AddCanary(ctx context.Context, subject string) error
+	RemoveCanary(ctx context.Context, subject string) error
+	ListCanaries(ctx context.Context) ([]string, error)
+
+	RecordHit(ctx context.Context, hit Hit) error
+	// returns recent hits by the given account
+	GetActorHits(ctx context.Context, actor string) ([]Hit, error)
+}
+
+// Returns the number of distinct canaries in a list of hits
+func DistinctCanaries(hits []Hit) int {
+	seen := make(map[string]bool, len(hits))
+	for _, h := range hits {
+		seen[h.Canary] = true
+	}
+	return len(seen)
+}
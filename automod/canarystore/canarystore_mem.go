@@ -0,0 +1,98 @@
+package canarystore
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemCanaryStore is an in-process CanaryStore. Expired hits are pruned lazily, when the relevant account is next touched.
+type MemCanaryStore struct {
+	Window time.Duration
+
+	lk       sync.Mutex
+	canaries map[string]bool
+	hits     map[string][]Hit
+}
+
+func NewMemCanaryStore(window time.Duration) *MemCanaryStore {
+	return &MemCanaryStore{
+		Window:   window,
+		canaries: make(map[string]bool),
+		hits:     make(map[string][]Hit),
+	}
+}
+
+func (s *MemCanaryStore) IsCanary(ctx context.Context, subject string) (bool, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	return s.canaries[subject], nil
+}
+
+func (s *MemCanaryStore) AddCanary(ctx context.Context, subject string) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.canaries[subject] = true
+	return nil
+}
+
+func (s *MemCanaryStore) RemoveCanary(ctx context.Context, subject string) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	delete(s.canaries, subject)
+	return nil
+}
+
+func (s *MemCanaryStore) ListCanaries(ctx context.Context) ([]string, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	out := make([]string, 0, len(s.canaries))
+	for c := range s.canaries {
+		out = append(out, c)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// drops expired hits, and any beyond MaxHitsPerActor. hits are kept most recent first
+func (s *MemCanaryStore) prune(actor string) []Hit {
+	cutoff := time.Now().Add(-s.Window)
+	hits := s.hits[actor]
+	n := 0
+	for _, h := range hits {
+		if n >= MaxHitsPerActor || h.Time.Before(cutoff) {
+			break
+		}
+		n++
+	}
+	hits = hits[:n]
+	if n == 0 {
+		delete(s.hits, actor)
+	} else {
+		s.hits[actor] = hits
+	}
+	return hits
+}
+
+func (s *MemCanaryStore) RecordHit(ctx context.Context, hit Hit) error {
+	if hit.Time.IsZero() {
+		hit.Time = time.Now()
+	}
+
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	hits := append([]Hit{hit}, s.hits[hit.Actor]...)
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Time.After(hits[j].Time) })
+	s.hits[hit.Actor] = hits
+	s.prune(hit.Actor)
+	return nil
+}
+
+func (s *MemCanaryStore) GetActorHits(ctx context.Context, actor string) ([]Hit, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	hits := s.prune(actor)
+	return append([]Hit{}, hits...), nil
+}
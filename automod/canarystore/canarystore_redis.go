@@ -0,0 +1,99 @@
+package canarystore
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var redisCanarySetKey string = "canaries"
+var redisCanaryHitsPrefix string = "canary-hits/"
+
+// RedisCanaryStore keeps canary designations in a single redis set, and each account's hits as a sorted set of JSON-encoded hits, scored by time.
+type RedisCanaryStore struct {
+	Client *redis.Client
+	Window time.Duration
+}
+
+func NewRedisCanaryStore(redisURL string, window time.Duration) (*RedisCanaryStore, error) {
+	ctx := context.Background()
+	opt, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	rdb := redis.NewClient(opt)
+	// check redis connection
+	_, err = rdb.Ping(ctx).Result()
+	if err != nil {
+		return nil, err
+	}
+	rcs := RedisCanaryStore{
+		Client: rdb,
+		Window: window,
+	}
+	return &rcs, nil
+}
+
+func (s *RedisCanaryStore) IsCanary(ctx context.Context, subject string) (bool, error) {
+	return s.Client.SIsMember(ctx, redisCanarySetKey, subject).Result()
+}
+
+func (s *RedisCanaryStore) AddCanary(ctx context.Context, subject string) error {
+	return s.Client.SAdd(ctx, redisCanarySetKey, subject).Err()
+}
+
+func (s *RedisCanaryStore) RemoveCanary(ctx context.Context, subject string) error {
+	return s.Client.SRem(ctx, redisCanarySetKey, subject).Err()
+}
+
+func (s *RedisCanaryStore) ListCanaries(ctx context.Context) ([]string, error) {
+	out, err := s.Client.SMembers(ctx, redisCanarySetKey).Result()
+	if err == redis.Nil {
+		return []string{}, nil
+	}
+	return out, err
+}
+
+func (s *RedisCanaryStore) RecordHit(ctx context.Context, hit Hit) error {
+	if hit.Time.IsZero() {
+		hit.Time = time.Now()
+	}
+	b, err := json.Marshal(hit)
+	if err != nil {
+		return err
+	}
+
+	key := redisCanaryHitsPrefix + hit.Actor
+	cutoff := strconv.FormatInt(time.Now().Add(-s.Window).UnixMilli(), 10)
+
+	// add, and trim expired and excess hits, in a single round-trip
+	multi := s.Client.Pipeline()
+	multi.ZAdd(ctx, key, redis.Z{Score: float64(hit.Time.UnixMilli()), Member: b})
+	multi.ZRemRangeByScore(ctx, key, "-inf", "("+cutoff)
+	multi.ZRemRangeByRank(ctx, key, 0, -(MaxHitsPerActor + 1))
+	multi.Expire(ctx, key, s.Window)
+	_, err = multi.Exec(ctx)
+	return err
+}
+
+func (s *RedisCanaryStore) GetActorHits(ctx context.Context, actor string) ([]Hit, error) {
+	cutoff := strconv.FormatInt(time.Now().Add(-s.Window).UnixMilli(), 10)
+	raw, err := s.Client.ZRevRangeByScore(ctx, redisCanaryHitsPrefix+actor, &redis.ZRangeBy{Min: cutoff, Max: "+inf"}).Result()
+	if err == redis.Nil {
+		return []Hit{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	out := make([]Hit, 0, len(raw))
+	for _, r := range raw {
+		var h Hit
+		if err := json.Unmarshal([]byte(r), &h); err != nil {
+			return nil, err
+		}
+		out = append(out, h)
+	}
+	return out, nil
+}
@@ -0,0 +1,66 @@
+package canarystore
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemCanaryStoreBasics(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	cs := NewMemCanaryStore(time.Hour)
+
+	ok, err := cs.IsCanary(ctx, "did:plc:canary")
+	assert.NoError(err)
+	assert.False(ok)
+
+	assert.NoError(cs.AddCanary(ctx, "did:plc:canary"))
+	assert.NoError(cs.AddCanary(ctx, "at://did:plc:other/app.bsky.feed.post/abc"))
+	ok, err = cs.IsCanary(ctx, "did:plc:canary")
+	assert.NoError(err)
+	assert.True(ok)
+
+	list, err := cs.ListCanaries(ctx)
+	assert.NoError(err)
+	assert.Equal([]string{"at://did:plc:other/app.bsky.feed.post/abc", "did:plc:canary"}, list)
+
+	assert.NoError(cs.RemoveCanary(ctx, "did:plc:canary"))
+	ok, err = cs.IsCanary(ctx, "did:plc:canary")
+	assert.NoError(err)
+	assert.False(ok)
+
+	now := time.Now()
+	assert.NoError(cs.RecordHit(ctx, Hit{Canary: "did:plc:canary", Actor: "did:plc:a", Kind: KindFollow, Time: now.Add(-time.Minute)}))
+	assert.NoError(cs.RecordHit(ctx, Hit{Canary: "did:plc:canary", Actor: "did:plc:a", Kind: KindMention, Time: now}))
+	assert.NoError(cs.RecordHit(ctx, Hit{Canary: "did:plc:canary2", Actor: "did:plc:a", Kind: KindReply, Time: now.Add(-2 * time.Hour)}))
+
+	hits, err := cs.GetActorHits(ctx, "did:plc:a")
+	assert.NoError(err)
+	if assert.Len(hits, 2) {
+		assert.Equal(KindMention, hits[0].Kind)
+		assert.Equal(KindFollow, hits[1].Kind)
+	}
+	assert.Equal(1, DistinctCanaries(hits))
+
+	hits, err = cs.GetActorHits(ctx, "did:plc:b")
+	assert.NoError(err)
+	assert.Empty(hits)
+}
+
+func TestMemCanaryStoreMaxHits(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	cs := NewMemCanaryStore(time.Hour)
+	for i := 0; i < MaxHitsPerActor+10; i++ {
+		assert.NoError(cs.RecordHit(ctx, Hit{Canary: fmt.Sprintf("did:plc:c%d", i), Actor: "did:plc:a", Kind: KindLike}))
+	}
+	hits, err := cs.GetActorHits(ctx, "did:plc:a")
+	assert.NoError(err)
+	assert.Len(hits, MaxHitsPerActor)
+}
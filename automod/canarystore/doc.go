@@ -0,0 +1,6 @@
+// Automod component for honeypot ("canary") accounts and records.
+//
+// Canaries are subjects (account DIDs or record AT-URIs) which no legitimate account has any reason to interact with, for example an unannounced account which never posts. Any follow, reply, mention, like, etc, targeting a canary is recorded as a "hit" against the interacting account, which rules can use as a strong spam signal.
+//
+// Includes an interface and implementations using redis and in-process memory.
+package canarystore
@@ -34,6 +34,12 @@ type FirehoseConsumer struct {
 	RedisClient *redis.Client
 	Engine      *automod.Engine
 	Host        string
+	// if set, events which were already handled (eg, when replaying after a crash) are skipped
+	Idempotency events.IdempotencyStore
+	// if set, commit ops are tallied by collection and PDS host
+	CollectionStats *events.CollectionStats
+	// if set, the age of events is measured as they arrive, and checked against an SLA
+	AgeWatchdog *events.AgeWatchdog
 
 	// TODO: prefilter record collections; or predicate function?
 	// TODO: enable/disable event types; or predicate function?
@@ -73,27 +79,21 @@ func (fc *FirehoseConsumer) Run(ctx context.Context) error {
 		return fmt.Errorf("subscribing to firehose failed (dialing): %w", err)
 	}
 
-	rsc := &events.RepoStreamCallbacks{
-		RepoCommit: func(evt *comatproto.SyncSubscribeRepos_Commit) error {
-			atomic.StoreInt64(&fc.lastSeq, evt.Seq)
-			return fc.HandleRepoCommit(ctx, evt)
-		},
-		RepoIdentity: func(evt *comatproto.SyncSubscribeRepos_Identity) error {
-			atomic.StoreInt64(&fc.lastSeq, evt.Seq)
-			if err := fc.Engine.ProcessIdentityEvent(ctx, *evt); err != nil {
-				fc.Logger.Error("processing repo identity failed", "did", evt.Did, "seq", evt.Seq, "err", err)
-			}
-			return nil
-		},
-		RepoAccount: func(evt *comatproto.SyncSubscribeRepos_Account) error {
-			atomic.StoreInt64(&fc.lastSeq, evt.Seq)
-			if err := fc.Engine.ProcessAccountEvent(ctx, *evt); err != nil {
-				fc.Logger.Error("processing repo account failed", "did", evt.Did, "seq", evt.Seq, "err", err)
-			}
-			return nil
-		},
-		// NOTE: no longer process #handle events
-		// NOTE: no longer process #tombstone events
+	rsc := fc.repoCallbacks(ctx, func(seq int64) { atomic.StoreInt64(&fc.lastSeq, seq) })
+	handler := rsc.EventHandler
+	if fc.CollectionStats != nil {
+		fc.CollectionStats.Next = handler
+		fc.CollectionStats.HostFunc = fc.pdsHost
+		handler = fc.CollectionStats.EventHandler
+	}
+	if fc.Idempotency != nil {
+		// inside of any stats, so that replayed events aren't counted twice
+		handler = events.NewIdempotentHandler(fc.Idempotency, firehoseHandlerVersion, handler).EventHandler
+	}
+	if fc.AgeWatchdog != nil {
+		// outside of idempotency, so that replayed events still count as lag
+		fc.AgeWatchdog.Next = handler
+		handler = fc.AgeWatchdog.EventHandler
 	}
 
 	var scheduler events.Scheduler
@@ -103,7 +103,7 @@ func (fc *FirehoseConsumer) Run(ctx context.Context) error {
 			fc.Parallelism,
 			1000,
 			fc.Host,
-			rsc.EventHandler,
+			handler,
 		)
 		fc.Logger.Info("hepa scheduler configured", "scheduler", "parallel", "initial", fc.Parallelism)
 	} else {
@@ -112,13 +112,56 @@ func (fc *FirehoseConsumer) Run(ctx context.Context) error {
 		// start at higher parallelism (somewhat arbitrary)
 		scaleSettings.Concurrency = 4
 		scaleSettings.MaxConcurrency = 200
-		scheduler = autoscaling.NewScheduler(scaleSettings, fc.Host, rsc.EventHandler)
+		scheduler = autoscaling.NewScheduler(scaleSettings, fc.Host, handler)
 		fc.Logger.Info("hepa scheduler configured", "scheduler", "autoscaling", "initial", scaleSettings.Concurrency, "max", scaleSettings.MaxConcurrency)
 	}
 
 	return events.HandleRepoStream(ctx, con, scheduler, fc.Logger)
 }
 
+// returns stream callbacks which pass events to the engine. 'received' is called with the sequence number of each event before it is handled
+func (fc *FirehoseConsumer) repoCallbacks(ctx context.Context, received func(seq int64)) *events.RepoStreamCallbacks {
+	return &events.RepoStreamCallbacks{
+		RepoCommit: func(evt *comatproto.SyncSubscribeRepos_Commit) error {
+			received(evt.Seq)
+			return fc.HandleRepoCommit(ctx, evt)
+		},
+		RepoIdentity: func(evt *comatproto.SyncSubscribeRepos_Identity) error {
+			received(evt.Seq)
+			if err := fc.Engine.ProcessIdentityEvent(ctx, *evt); err != nil {
+				fc.Logger.Error("processing repo identity failed", "did", evt.Did, "seq", evt.Seq, "err", err)
+			}
+			return nil
+		},
+		RepoAccount: func(evt *comatproto.SyncSubscribeRepos_Account) error {
+			received(evt.Seq)
+			if err := fc.Engine.ProcessAccountEvent(ctx, *evt); err != nil {
+				fc.Logger.Error("processing repo account failed", "did", evt.Did, "seq", evt.Seq, "err", err)
+			}
+			return nil
+		},
+		// NOTE: no longer process #handle events
+		// NOTE: no longer process #tombstone events
+	}
+}
+
+// returns the hostname of an account's PDS, or empty string if it can't be determined. the identity lookup is shared with (and usually warms the cache for) rule processing
+func (fc *FirehoseConsumer) pdsHost(ctx context.Context, did string) string {
+	d, err := syntax.ParseDID(did)
+	if err != nil {
+		return ""
+	}
+	ident, err := fc.Engine.Directory.LookupDID(ctx, d)
+	if err != nil {
+		return ""
+	}
+	u, err := url.Parse(ident.PDSEndpoint())
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
 // NOTE: for now, this function basically never errors, just logs and returns nil. Should think through error processing better.
 func (fc *FirehoseConsumer) HandleRepoCommit(ctx context.Context, evt *comatproto.SyncSubscribeRepos_Commit) error {
 
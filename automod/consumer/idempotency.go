@@ -0,0 +1,35 @@
+package consumer
+
+import (
+	"context"
+	"time"
+
+	"github.com/bluesky-social/indigo/events"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Included in every idempotency key. Bump this when rule processing changes in a way that should re-process events which were already handled.
+const firehoseHandlerVersion = "hepa-v1"
+
+var idempotencyKeyPrefix = "hepa/done/"
+
+// Redis-backed implementation of events.IdempotencyStore. Keys expire after a TTL, which should be longer than any expected replay window.
+type RedisIdempotencyStore struct {
+	Client *redis.Client
+	TTL    time.Duration
+}
+
+var _ events.IdempotencyStore = (*RedisIdempotencyStore)(nil)
+
+func (s *RedisIdempotencyStore) Seen(ctx context.Context, key string) (bool, error) {
+	n, err := s.Client.Exists(ctx, idempotencyKeyPrefix+key).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (s *RedisIdempotencyStore) Mark(ctx context.Context, key string) error {
+	return s.Client.Set(ctx, idempotencyKeyPrefix+key, 1, s.TTL).Err()
+}
@@ -0,0 +1,218 @@
+package consumer
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/automod"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/events/schedulers/parallel"
+
+	"github.com/carlmjohnson/versioninfo"
+	"github.com/gorilla/websocket"
+)
+
+// FirehoseReplay reprocesses a historical range of firehose events through the engine, eg for a retroactive sweep after adding a new rule. Combine with the engine's DryRun config to preview what rules would have done.
+//
+// Events are read either from a relay or rainbow instance (which serves the range from its own persistence, if it still has it), or from a capture file of raw firehose frames.
+type FirehoseReplay struct {
+	Engine *automod.Engine
+	Logger *slog.Logger
+	// number of events processed concurrently. events for the same account are always processed in order
+	Parallelism int
+	// first and last sequence numbers to process (inclusive). if LastSeq is zero, replay runs to the end of a capture file, or indefinitely from a host
+	FirstSeq int64
+	LastSeq  int64
+
+	lk    sync.Mutex
+	stats ReplayStats
+}
+
+// ReplayStats summarizes a replay
+type ReplayStats struct {
+	// events passed to the engine
+	Events int64 `json:"events"`
+	// events outside the sequence range, or of types rules don't run on
+	Skipped int64 `json:"skipped"`
+	// first and last sequence numbers passed to the engine
+	FirstSeq int64 `json:"firstSeq"`
+	LastSeq  int64 `json:"lastSeq"`
+}
+
+// ReplayHost subscribes to the firehose of a relay or rainbow instance, starting at FirstSeq, and processes events until LastSeq
+func (fr *FirehoseReplay) ReplayHost(ctx context.Context, host string) (*ReplayStats, error) {
+	if err := fr.check(); err != nil {
+		return nil, err
+	}
+	if fr.LastSeq == 0 {
+		fr.Logger.Warn("no last sequence number set; replay will continue with live events until cancelled")
+	}
+
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid host URI: %w", err)
+	}
+	u.Path = "xrpc/com.atproto.sync.subscribeRepos"
+	if fr.FirstSeq > 1 {
+		// the cursor is exclusive
+		u.RawQuery = fmt.Sprintf("cursor=%d", fr.FirstSeq-1)
+	}
+	fr.Logger.Info("subscribing to repo event stream for replay", "upstream", host, "firstSeq", fr.FirstSeq, "lastSeq", fr.LastSeq)
+	con, _, err := websocket.DefaultDialer.DialContext(ctx, u.String(), http.Header{
+		"User-Agent": []string{fmt.Sprintf("hepa/%s", versioninfo.Short())},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("subscribing to firehose failed (dialing): %w", err)
+	}
+
+	// the stream is cancelled at the end of the range, but events already
+	// received are still processed (with the parent context)
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := false
+	sched := parallel.NewScheduler(fr.Parallelism, 1000, host+"/replay", fr.handler(ctx, func() {
+		fr.lk.Lock()
+		done = true
+		fr.lk.Unlock()
+		cancel()
+	}))
+
+	err = events.HandleRepoStream(streamCtx, con, sched, fr.Logger)
+	fr.lk.Lock()
+	defer fr.lk.Unlock()
+	if done {
+		err = nil
+	}
+	stats := fr.stats
+	return &stats, err
+}
+
+// ReplayFile processes events from a capture file: a sequence of raw firehose frames, as received over the websocket (eg, saved with 'websocat -b')
+func (fr *FirehoseReplay) ReplayFile(ctx context.Context, r io.Reader) (*ReplayStats, error) {
+	if err := fr.check(); err != nil {
+		return nil, err
+	}
+
+	sched := parallel.NewScheduler(fr.Parallelism, 1000, "file/replay", fr.handler(ctx, func() {}))
+
+	br := bufio.NewReader(r)
+	var err error
+	for ctx.Err() == nil {
+		var xev events.XRPCStreamEvent
+		if err = xev.Deserialize(br); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = nil
+			} else {
+				err = fmt.Errorf("reading capture file: %w", err)
+			}
+			break
+		}
+		// capture files are in sequence order, so stop reading at the end of the range
+		if fr.LastSeq > 0 && xev.Sequence() > fr.LastSeq {
+			break
+		}
+		if err = sched.AddWork(ctx, eventRepo(&xev), &xev); err != nil {
+			break
+		}
+	}
+	sched.Shutdown()
+
+	if err == nil {
+		err = ctx.Err()
+	}
+	fr.lk.Lock()
+	defer fr.lk.Unlock()
+	stats := fr.stats
+	return &stats, err
+}
+
+func (fr *FirehoseReplay) check() error {
+	if fr.Engine == nil {
+		return fmt.Errorf("nil engine")
+	}
+	if fr.LastSeq > 0 && fr.LastSeq < fr.FirstSeq {
+		return fmt.Errorf("last sequence number (%d) is before first (%d)", fr.LastSeq, fr.FirstSeq)
+	}
+	if fr.Logger == nil {
+		fr.Logger = slog.Default()
+	}
+	if fr.Parallelism <= 0 {
+		fr.Parallelism = 10
+	}
+	return nil
+}
+
+// returns an event handler which processes events in range (with ctx), and calls 'end' once the end of the range is reached
+func (fr *FirehoseReplay) handler(ctx context.Context, end func()) func(context.Context, *events.XRPCStreamEvent) error {
+	fc := &FirehoseConsumer{
+		Engine: fr.Engine,
+		Logger: fr.Logger,
+	}
+	rsc := fc.repoCallbacks(ctx, func(seq int64) {
+		fr.lk.Lock()
+		defer fr.lk.Unlock()
+		fr.stats.Events++
+		if fr.stats.FirstSeq == 0 || seq < fr.stats.FirstSeq {
+			fr.stats.FirstSeq = seq
+		}
+		if seq > fr.stats.LastSeq {
+			fr.stats.LastSeq = seq
+		}
+	})
+	rsc.RepoInfo = func(evt *comatproto.SyncSubscribeRepos_Info) error {
+		if evt.Name == events.InfoOutdatedCursor {
+			fr.Logger.Warn("upstream no longer has the start of the replay range; replaying from its oldest event", "firstSeq", fr.FirstSeq)
+		} else {
+			fr.Logger.Info("info event from upstream", "name", evt.Name, "message", evt.Message)
+		}
+		return nil
+	}
+
+	return func(_ context.Context, xev *events.XRPCStreamEvent) error {
+		if xev.RepoInfo != nil {
+			return rsc.EventHandler(ctx, xev)
+		}
+		seq := xev.Sequence()
+		inRange := seq >= fr.FirstSeq && (fr.LastSeq == 0 || seq <= fr.LastSeq)
+		if !inRange || (xev.RepoCommit == nil && xev.RepoIdentity == nil && xev.RepoAccount == nil) {
+			fr.lk.Lock()
+			fr.stats.Skipped++
+			fr.lk.Unlock()
+			if fr.LastSeq > 0 && seq > fr.LastSeq {
+				end()
+			}
+			return nil
+		}
+		if err := rsc.EventHandler(ctx, xev); err != nil {
+			return err
+		}
+		if seq == fr.LastSeq {
+			end()
+		}
+		return nil
+	}
+}
+
+// returns the account an event is about, for ordering processing
+func eventRepo(xev *events.XRPCStreamEvent) string {
+	switch {
+	case xev.RepoCommit != nil:
+		return xev.RepoCommit.Repo
+	case xev.RepoIdentity != nil:
+		return xev.RepoIdentity.Did
+	case xev.RepoAccount != nil:
+		return xev.RepoAccount.Did
+	case xev.RepoSync != nil:
+		return xev.RepoSync.Did
+	default:
+		return ""
+	}
+}
@@ -2,6 +2,8 @@ package countstore
 
 import (
 	"context"
+	"fmt"
+	"sort"
 
 	"github.com/puzpuzpuz/xsync/v3"
 )
@@ -69,3 +71,113 @@ func (s MemCountStore) IncrementDistinct(ctx context.Context, name, bucket, val
 	}
 	return nil
 }
+
+// Export snapshots the store, in key order. Markers are the last key exported.
+func (s MemCountStore) Export(ctx context.Context, marker string, fn func(*SnapshotEntry) error) error {
+	kind, after, err := parseSnapshotMarker(marker)
+	if err != nil {
+		return err
+	}
+
+	if kind == SnapshotKindCount {
+		var keys []string
+		s.Counts.Range(func(k string, _ int) bool {
+			keys = append(keys, k)
+			return true
+		})
+		err := exportSortedKeys(ctx, SnapshotKindCount, keys, after, fn, func(k string) *SnapshotEntry {
+			v, ok := s.Counts.Load(k)
+			if !ok {
+				return nil
+			}
+			return &SnapshotEntry{Kind: SnapshotKindCount, Key: k, Count: v}
+		})
+		if err != nil {
+			return err
+		}
+		kind, after = SnapshotKindDistinct, ""
+	}
+
+	if kind == SnapshotKindDistinct {
+		var keys []string
+		s.DistinctCounts.Range(func(k string, _ *xsync.MapOf[string, bool]) bool {
+			keys = append(keys, k)
+			return true
+		})
+		err := exportSortedKeys(ctx, SnapshotKindDistinct, keys, after, fn, func(k string) *SnapshotEntry {
+			nested, ok := s.DistinctCounts.Load(k)
+			if !ok {
+				return nil
+			}
+			e := &SnapshotEntry{Kind: SnapshotKindDistinct, Key: k}
+			nested.Range(func(v string, _ bool) bool {
+				e.Values = append(e.Values, v)
+				return true
+			})
+			sort.Strings(e.Values)
+			e.Count = len(e.Values)
+			return e
+		})
+		if err != nil {
+			return err
+		}
+		return fn(&SnapshotEntry{Marker: snapshotMarkerDone})
+	}
+	return nil
+}
+
+// calls fn for the entry for each key after the given one, in order, with a
+// marker after each batch
+func exportSortedKeys(ctx context.Context, kind string, keys []string, after string, fn func(*SnapshotEntry) error, entry func(string) *SnapshotEntry) error {
+	sort.Strings(keys)
+	i := 0
+	if after != "" {
+		i = sort.SearchStrings(keys, after)
+		if i < len(keys) && keys[i] == after {
+			i++
+		}
+	}
+	n := 0
+	for ; i < len(keys); i++ {
+		e := entry(keys[i])
+		if e == nil {
+			continue
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+		n++
+		if n%snapshotBatchSize == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := fn(&SnapshotEntry{Marker: kind + ":" + keys[i]}); err != nil {
+				return err
+			}
+		}
+	}
+	// the next phase starts at its beginning
+	if kind == SnapshotKindCount {
+		return fn(&SnapshotEntry{Marker: SnapshotKindDistinct + ":"})
+	}
+	return nil
+}
+
+func (s MemCountStore) Import(ctx context.Context, e *SnapshotEntry) error {
+	switch e.Kind {
+	case SnapshotKindCount:
+		s.Counts.Store(e.Key, e.Count)
+	case SnapshotKindDistinct:
+		if e.HLL != nil {
+			return ErrIncompatibleSnapshot
+		}
+		nested := xsync.NewMapOf[string, bool]()
+		for _, v := range e.Values {
+			nested.Store(v, true)
+		}
+		s.DistinctCounts.Store(e.Key, nested)
+	default:
+		return fmt.Errorf("unknown snapshot entry kind: %q", e.Kind)
+	}
+	return nil
+}
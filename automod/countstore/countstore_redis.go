@@ -2,6 +2,9 @@ package countstore
 
 import (
 	"context"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -119,3 +122,143 @@ func (s *RedisCountStore) IncrementDistinct(ctx context.Context, name, bucket, v
 	_, err := multi.Exec(ctx)
 	return err
 }
+
+// Export snapshots the store. Markers are Redis SCAN cursors, so counters
+// created or deleted during the export may be missed, or exported twice.
+func (s *RedisCountStore) Export(ctx context.Context, marker string, fn func(*SnapshotEntry) error) error {
+	kind, pos, err := parseSnapshotMarker(marker)
+	if err != nil {
+		return err
+	}
+	var cursor uint64
+	if pos != "" {
+		cursor, err = strconv.ParseUint(pos, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid snapshot marker: %q", marker)
+		}
+	}
+
+	for _, phase := range []string{SnapshotKindCount, SnapshotKindDistinct} {
+		if kind != phase {
+			continue
+		}
+		prefix := redisCountPrefix
+		if phase == SnapshotKindDistinct {
+			prefix = redisDistinctPrefix
+		}
+		for {
+			keys, next, err := s.Client.Scan(ctx, cursor, prefix+"*", snapshotBatchSize).Result()
+			if err != nil {
+				return err
+			}
+			if err := s.exportKeys(ctx, phase, prefix, keys, fn); err != nil {
+				return err
+			}
+			cursor = next
+			if cursor == 0 {
+				break
+			}
+			if err := fn(&SnapshotEntry{Marker: phase + ":" + strconv.FormatUint(cursor, 10)}); err != nil {
+				return err
+			}
+		}
+		if phase == SnapshotKindCount {
+			if err := fn(&SnapshotEntry{Marker: SnapshotKindDistinct + ":"}); err != nil {
+				return err
+			}
+			kind = SnapshotKindDistinct
+		}
+	}
+	if kind == SnapshotKindDistinct {
+		return fn(&SnapshotEntry{Marker: snapshotMarkerDone})
+	}
+	return nil
+}
+
+func (s *RedisCountStore) exportKeys(ctx context.Context, kind, prefix string, keys []string, fn func(*SnapshotEntry) error) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	pipe := s.Client.Pipeline()
+	vals := make([]*redis.StringCmd, len(keys))
+	ttls := make([]*redis.DurationCmd, len(keys))
+	for i, k := range keys {
+		vals[i] = pipe.Get(ctx, k)
+		ttls[i] = pipe.PTTL(ctx, k)
+	}
+	// missing keys (expired since the scan) show up as redis.Nil errors
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return err
+	}
+
+	now := time.Now()
+	for i, k := range keys {
+		v, err := vals[i].Bytes()
+		if err == redis.Nil {
+			continue
+		} else if err != nil {
+			return err
+		}
+		e := &SnapshotEntry{Kind: kind, Key: strings.TrimPrefix(k, prefix)}
+		if kind == SnapshotKindCount {
+			e.Count, err = strconv.Atoi(string(v))
+			if err != nil {
+				return fmt.Errorf("counter %s: %w", k, err)
+			}
+		} else {
+			e.HLL = v
+			c, err := s.Client.PFCount(ctx, k).Result()
+			if err != nil && err != redis.Nil {
+				return err
+			}
+			e.Count = int(c)
+		}
+		// negative for no expiry
+		if ttl := ttls[i].Val(); ttl > 0 {
+			exp := now.Add(ttl)
+			e.ExpiresAt = &exp
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *RedisCountStore) Import(ctx context.Context, e *SnapshotEntry) error {
+	var expiration time.Duration
+	if e.ExpiresAt != nil {
+		expiration = time.Until(*e.ExpiresAt)
+		if expiration <= 0 {
+			// expired since the snapshot
+			return nil
+		}
+	}
+
+	switch e.Kind {
+	case SnapshotKindCount:
+		return s.Client.Set(ctx, redisCountPrefix+e.Key, e.Count, expiration).Err()
+	case SnapshotKindDistinct:
+		key := redisDistinctPrefix + e.Key
+		if e.HLL != nil {
+			return s.Client.Set(ctx, key, e.HLL, expiration).Err()
+		}
+		vals := make([]interface{}, len(e.Values))
+		for i, v := range e.Values {
+			vals[i] = v
+		}
+		_, err := s.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, key)
+			if len(vals) > 0 {
+				pipe.PFAdd(ctx, key, vals...)
+			}
+			if expiration > 0 {
+				pipe.Expire(ctx, key, expiration)
+			}
+			return nil
+		})
+		return err
+	default:
+		return fmt.Errorf("unknown snapshot entry kind: %q", e.Kind)
+	}
+}
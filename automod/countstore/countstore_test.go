@@ -1,7 +1,11 @@
 package countstore
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"sort"
 	"sync"
 	"testing"
 	"time"
@@ -104,3 +108,75 @@ func TestMemCountStoreConcurrent(t *testing.T) {
 	assert.NoError(err)
 	assert.Equal(1, c)
 }
+
+func TestMemCountStoreSnapshot(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	cs := NewMemCountStore()
+	for i := 0; i < 1500; i++ {
+		assert.NoError(cs.IncrementPeriod(ctx, "test1", fmt.Sprintf("val%d", i), PeriodTotal))
+	}
+	assert.NoError(cs.IncrementDistinct(ctx, "test2", "bucket", "one"))
+	assert.NoError(cs.IncrementDistinct(ctx, "test2", "bucket", "two"))
+
+	var buf bytes.Buffer
+	assert.NoError(WriteSnapshot(ctx, cs, &buf, ""))
+
+	restored := NewMemCountStore()
+	n, err := ReadSnapshot(ctx, restored, bytes.NewReader(buf.Bytes()))
+	assert.NoError(err)
+	assert.Equal(1503, n)
+	c, err := restored.GetCount(ctx, "test1", "val1234", PeriodTotal)
+	assert.NoError(err)
+	assert.Equal(1, c)
+	c, err = restored.GetCountDistinct(ctx, "test2", "bucket", PeriodDay)
+	assert.NoError(err)
+	assert.Equal(2, c)
+
+	// an interrupted export resumes from the last marker in the partial file
+	errStop := errors.New("stop")
+	var partial bytes.Buffer
+	written := 0
+	err = WriteSnapshot(ctx, exportFunc(func(ctx context.Context, marker string, fn func(*SnapshotEntry) error) error {
+		return cs.Export(ctx, marker, func(e *SnapshotEntry) error {
+			if written == 1200 {
+				return errStop
+			}
+			written++
+			return fn(e)
+		})
+	}), &partial, "")
+	assert.ErrorIs(err, errStop)
+
+	marker, off, err := LastSnapshotMarker(bytes.NewReader(partial.Bytes()))
+	assert.NoError(err)
+	assert.Equal("count:test1/val"+lastSortedKey(1000), marker)
+	partial.Truncate(int(off))
+	assert.NoError(WriteSnapshot(ctx, cs, &partial, marker))
+
+	resumed := NewMemCountStore()
+	n, err = ReadSnapshot(ctx, resumed, bytes.NewReader(partial.Bytes()))
+	assert.NoError(err)
+	assert.Equal(1503, n)
+
+	marker, _, err = LastSnapshotMarker(bytes.NewReader(partial.Bytes()))
+	assert.NoError(err)
+	assert.Equal(snapshotMarkerDone, marker)
+}
+
+type exportFunc func(ctx context.Context, marker string, fn func(*SnapshotEntry) error) error
+
+func (f exportFunc) Export(ctx context.Context, marker string, fn func(*SnapshotEntry) error) error {
+	return f(ctx, marker, fn)
+}
+
+// the nth of the test values "val0".."val1499", in string order
+func lastSortedKey(n int) string {
+	keys := make([]string, 1500)
+	for i := range keys {
+		keys[i] = fmt.Sprint(i)
+	}
+	sort.Strings(keys)
+	return keys[n-1]
+}
@@ -0,0 +1,154 @@
+package countstore
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	SnapshotKindCount    = "count"
+	SnapshotKindDistinct = "distinct"
+)
+
+// SnapshotEntry is one line of a counter snapshot: either a single counter, or
+// a resume marker (with only Marker set).
+type SnapshotEntry struct {
+	// SnapshotKindCount or SnapshotKindDistinct
+	Kind string `json:"kind,omitempty"`
+	// Storage key, "{name}/{val}[/{period}]", without any backend prefix
+	Key   string `json:"key,omitempty"`
+	Count int    `json:"count,omitempty"`
+	// Distinct values, from stores which keep them (MemCountStore)
+	Values []string `json:"values,omitempty"`
+	// Raw HyperLogLog state, from RedisCountStore. It can only be imported in
+	// to another RedisCountStore
+	HLL []byte `json:"hll,omitempty"`
+	// When the counter expires, if it does
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+
+	// Passing this to Export continues the export after the preceding entries
+	Marker string `json:"marker,omitempty"`
+}
+
+// Exporter is implemented by count stores which can be snapshotted. It is
+// separate from CountStore, since not every store can list its contents.
+type Exporter interface {
+	// Export calls fn with every stored counter, starting after the resume
+	// marker (empty to start at the beginning). After each batch of counters,
+	// fn is called with a marker entry. Counters modified during the export
+	// may or may not be included.
+	Export(ctx context.Context, marker string, fn func(*SnapshotEntry) error) error
+}
+
+// Importer is implemented by count stores which can restore snapshots.
+// Importing an entry overwrites the counter, so importing the same snapshot
+// more than once has the same result as importing it once.
+type Importer interface {
+	Import(ctx context.Context, e *SnapshotEntry) error
+}
+
+var ErrIncompatibleSnapshot = errors.New("snapshot entry can't be imported in to this store")
+
+// WriteSnapshot exports the store as JSON lines, starting after the resume
+// marker (if any).
+func WriteSnapshot(ctx context.Context, s Exporter, w io.Writer, marker string) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	err := s.Export(ctx, marker, func(e *SnapshotEntry) error {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+		if e.Marker != "" {
+			// so that a partial snapshot ends at a marker
+			return bw.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// LastSnapshotMarker returns the last resume marker in a (possibly partial)
+// snapshot, and the offset just past it: an interrupted snapshot can be
+// truncated to that offset, and continued from the marker.
+func LastSnapshotMarker(r io.Reader) (string, int64, error) {
+	br := bufio.NewReader(r)
+	var (
+		marker string
+		end    int64
+		off    int64
+	)
+	for {
+		line, err := br.ReadBytes('\n')
+		off += int64(len(line))
+		if err == io.EOF {
+			// a trailing partial line is ignored
+			return marker, end, nil
+		}
+		if err != nil {
+			return "", 0, err
+		}
+		var e SnapshotEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return "", 0, fmt.Errorf("reading snapshot at offset %d: %w", off-int64(len(line)), err)
+		}
+		if e.Marker != "" {
+			marker = e.Marker
+			end = off
+		}
+	}
+}
+
+// ReadSnapshot imports a snapshot written by WriteSnapshot, returning the
+// number of counters imported.
+func ReadSnapshot(ctx context.Context, s Importer, r io.Reader) (int, error) {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	n := 0
+	for {
+		var e SnapshotEntry
+		if err := dec.Decode(&e); err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, fmt.Errorf("reading snapshot: %w", err)
+		}
+		if e.Marker != "" {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		if err := s.Import(ctx, &e); err != nil {
+			return n, fmt.Errorf("importing %s %s: %w", e.Kind, e.Key, err)
+		}
+		n++
+	}
+}
+
+// number of counters between resume markers
+const snapshotBatchSize = 1000
+
+// marker for a completed export
+const snapshotMarkerDone = "done"
+
+// parses a resume marker of the form "<kind>:<position>"
+func parseSnapshotMarker(marker string) (kind, pos string, err error) {
+	if marker == "" {
+		return SnapshotKindCount, "", nil
+	}
+	if marker == snapshotMarkerDone {
+		return snapshotMarkerDone, "", nil
+	}
+	kind, pos, ok := strings.Cut(marker, ":")
+	if !ok || (kind != SnapshotKindCount && kind != SnapshotKindDistinct) {
+		return "", "", fmt.Errorf("invalid snapshot marker: %q", marker)
+	}
+	return kind, pos, nil
+}
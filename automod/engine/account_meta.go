@@ -1,6 +1,8 @@
 package engine
 
 import (
+	"fmt"
+	"net/url"
 	"time"
 
 	"github.com/bluesky-social/indigo/atproto/identity"
@@ -28,6 +30,112 @@ type AccountMeta struct {
 	Deactivated          bool
 	// best effort public interpretation of account creation timestamp. not always available, and may be inaccurate/inconsistent for now.
 	CreatedAt *time.Time
+	// derived values, computed by the engine each time account metadata is hydrated. not persisted in the account meta cache.
+	Derived *AccountDerived `json:"-"`
+}
+
+// no accounts exist before this time
+var atprotoAccountEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Values derived from other account metadata. These are computed once (relative to a single reference time) when the account is hydrated, so that all rules processing an event see consistent values.
+type AccountDerived struct {
+	// best-effort account creation time, from either public or private account metadata. nil if not known, or if the timestamp is implausible
+	CreatedAt *time.Time
+	// time since account creation, relative to when metadata was hydrated. nil if creation time is unknown
+	Age *time.Duration
+	// average number of posts per day over the life of the account (accounts less than a day old are treated as being one day old). nil if creation time is unknown
+	PostsPerDay *float64
+	// signup cohort, as "<pds-host>/<ISO-week>", eg "morel.us-east.host.bsky.network/2024-W07". empty if creation time is unknown
+	Cohort string
+}
+
+// returns true if account creation timestamp is plausible: not-nil, not in distant past, not in the future
+func plausibleAccountCreation(when *time.Time, now time.Time) bool {
+	if when == nil {
+		return false
+	}
+	// this is mostly to check for misconfigurations or null values (eg, UNIX epoch zero means "unknown" not actually 1970)
+	if !when.After(atprotoAccountEpoch) {
+		return false
+	}
+	// a timestamp in the future would also indicate some misconfiguration
+	if when.After(now.Add(time.Hour)) {
+		return false
+	}
+	return true
+}
+
+// Computes derived account values relative to the provided reference time.
+func (am *AccountMeta) Derive(now time.Time) AccountDerived {
+	var d AccountDerived
+	// TODO: consider swapping priority order here
+	if plausibleAccountCreation(am.CreatedAt, now) {
+		d.CreatedAt = am.CreatedAt
+	} else if am.Private != nil && plausibleAccountCreation(am.Private.IndexedAt, now) {
+		d.CreatedAt = am.Private.IndexedAt
+	}
+	if d.CreatedAt == nil {
+		return d
+	}
+
+	age := now.Sub(*d.CreatedAt)
+	if age < 0 {
+		age = 0
+	}
+	d.Age = &age
+
+	days := age.Hours() / 24
+	if days < 1 {
+		days = 1
+	}
+	ppd := float64(am.PostsCount) / days
+	d.PostsPerDay = &ppd
+
+	host := "unknown"
+	if am.Identity != nil {
+		if u, err := url.Parse(am.Identity.PDSEndpoint()); err == nil && u.Host != "" {
+			host = u.Host
+		}
+	}
+	year, week := d.CreatedAt.UTC().ISOWeek()
+	d.Cohort = fmt.Sprintf("%s/%d-W%02d", host, year, week)
+	return d
+}
+
+func (am *AccountMeta) setDerived() {
+	d := am.Derive(time.Now())
+	am.Derived = &d
+}
+
+// returns derived values computed by the engine, or computes them on the spot if they were not (eg, in tests)
+func (am *AccountMeta) derived() AccountDerived {
+	if am.Derived != nil {
+		return *am.Derived
+	}
+	return am.Derive(time.Now())
+}
+
+// Time since the account was created. Returns false if creation time is not known.
+func (am *AccountMeta) AccountAge() (time.Duration, bool) {
+	d := am.derived()
+	if d.Age == nil {
+		return 0, false
+	}
+	return *d.Age, true
+}
+
+// Average posts per day over the life of the account. Returns false if creation time is not known.
+func (am *AccountMeta) AvgPostsPerDay() (float64, bool) {
+	d := am.derived()
+	if d.PostsPerDay == nil {
+		return 0, false
+	}
+	return *d.PostsPerDay, true
+}
+
+// Signup cohort (PDS host and ISO week of account creation), suitable for use as a counter key. Returns empty string if creation time is not known.
+func (am *AccountMeta) SignupCohort() string {
+	return am.derived().Cohort
 }
 
 type ProfileSummary struct {
@@ -53,4 +161,14 @@ type AccountPrivate struct {
 	ReviewState     string
 	Appealed        bool
 	AbuseSignatures []AbuseSignature
+	// signup signals; see HashEmailDomain. these are only populated if enabled in engine config
+	//
+	// keyed hash of the email address domain. empty if not known
+	EmailDomainHash string
+	// DID of the account whose invite code was used to sign up. empty if none, or an admin-issued code
+	InvitedBy string
+	// number of invites between this account and one which signed up without an invite (zero for such accounts). nil if not known
+	InviteDepth *int
+	// automod flags on the inviting account
+	InviterFlags []string
 }
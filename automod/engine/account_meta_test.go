@@ -0,0 +1,59 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccountMetaDerive(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Date(2024, 2, 14, 12, 0, 0, 0, time.UTC)
+	am := AccountMeta{
+		Identity: &identity.Identity{
+			DID: syntax.DID("did:plc:abc111"),
+			Services: map[string]identity.Service{
+				"atproto_pds": {
+					Type: "AtprotoPersonalDataServer",
+					URL:  "https://pds.example.com",
+				},
+			},
+		},
+		PostsCount: 50,
+	}
+
+	d := am.Derive(now)
+	assert.Nil(d.Age)
+	assert.Nil(d.PostsPerDay)
+	assert.Equal("", d.Cohort)
+
+	created := now.Add(-10 * 24 * time.Hour)
+	am.CreatedAt = &created
+	d = am.Derive(now)
+	assert.Equal(10*24*time.Hour, *d.Age)
+	assert.Equal(5.0, *d.PostsPerDay)
+	assert.Equal("pds.example.com/2024-W05", d.Cohort)
+
+	// very new accounts are treated as a day old
+	created = now.Add(-time.Hour)
+	d = am.Derive(now)
+	assert.Equal(50.0, *d.PostsPerDay)
+
+	// implausible public timestamp falls back to private metadata
+	old := time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
+	am.CreatedAt = &old
+	am.Private = &AccountPrivate{IndexedAt: &created}
+	d = am.Derive(now)
+	assert.Equal(time.Hour, *d.Age)
+
+	// values computed by the engine take precedence
+	am.Derived = &d
+	age, ok := am.AccountAge()
+	assert.True(ok)
+	assert.Equal(time.Hour, age)
+}
@@ -0,0 +1,35 @@
+package engine
+
+import (
+	"context"
+	"time"
+)
+
+// Rate baselines maintained by the engine for every created record
+const (
+	// records created by an account, keyed by DID
+	BaselineAccountRecords = "account-records"
+	// records created in a collection, keyed by NSID
+	BaselineCollectionRecords = "collection-records"
+)
+
+// records the event in the engine-maintained baselines (for record creations), and in any baselines rules asked for
+func (eng *Engine) persistBaselines(ctx context.Context, eff *Effects, op *RecordOp) error {
+	if eng.Baselines == nil {
+		return nil
+	}
+	now := time.Now()
+	refs := eff.BaselineObservations
+	if op != nil && op.Action == CreateOp {
+		refs = append(refs,
+			BaselineRef{Name: BaselineAccountRecords, Key: op.DID.String()},
+			BaselineRef{Name: BaselineCollectionRecords, Key: op.Collection.String()},
+		)
+	}
+	for _, ref := range refs {
+		if err := eng.Baselines.Observe(ctx, ref.Name, ref.Key, now, 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBaselines(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	eng := EngineTestFixture()
+	var seen []float64
+	eng.Rules.RecordRules = append(eng.Rules.RecordRules, func(c *RecordContext) error {
+		seen = append(seen, c.GetBaseline(BaselineAccountRecords, c.Account.Identity.DID.String()).Count)
+		// not enough history to score
+		assert.Equal(0.0, c.GetBurstScore(BaselineAccountRecords, c.Account.Identity.DID.String()))
+		c.ObserveBaseline("post-text", c.Account.Identity.DID.String())
+		return nil
+	})
+
+	buf := new(bytes.Buffer)
+	assert.NoError((&appbsky.FeedPost{Text: "hello"}).MarshalCBOR(buf))
+	cid1 := syntax.CID("cid123")
+	op := RecordOp{
+		Action:     CreateOp,
+		DID:        syntax.DID("did:plc:abc111"),
+		Collection: syntax.NSID("app.bsky.feed.post"),
+		RecordKey:  syntax.RecordKey("abc123"),
+		CID:        &cid1,
+		RecordCBOR: buf.Bytes(),
+	}
+	for i := 0; i < 3; i++ {
+		assert.NoError(eng.ProcessRecordOp(ctx, op))
+	}
+	// rules see counts from before the current event
+	assert.Equal([]float64{0, 1, 2}, seen)
+
+	count := func(name, key string) float64 {
+		b, err := eng.Baselines.GetBaseline(ctx, name, key, time.Now())
+		assert.NoError(err)
+		return b.Count
+	}
+	assert.Equal(3.0, count(BaselineCollectionRecords, "app.bsky.feed.post"))
+	assert.Equal(3.0, count("post-text", "did:plc:abc111"))
+
+	// deletes aren't counted
+	op.Action = DeleteOp
+	op.RecordCBOR = nil
+	op.CID = nil
+	assert.NoError(eng.ProcessRecordOp(ctx, op))
+	assert.Equal(3.0, count(BaselineAccountRecords, "did:plc:abc111"))
+}
@@ -0,0 +1,150 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RuleBudget limits the resources a single rule may use while processing a single event, so that one expensive rule can't hold up firehose processing.
+//
+// A rule's context is given a deadline of MaxDuration; external lookups (counters, sets, graph queries, account metadata, etc) made after the deadline passes, or beyond MaxExternalCalls, are skipped and return empty results. The rest of the rule still runs, but without any more external state. Budget overruns are counted in metrics and rule stats.
+type RuleBudget struct {
+	// maximum wall time for one execution of the rule. zero for no limit
+	MaxDuration time.Duration
+	// maximum number of external lookups in one execution of the rule. zero for no limit
+	MaxExternalCalls int
+}
+
+// Returned (via the context's Err field) for external lookups skipped because the rule is over budget
+var ErrRuleBudgetExceeded = errors.New("rule exceeded its resource budget")
+
+func (b RuleBudget) isZero() bool {
+	return b.MaxDuration <= 0 && b.MaxExternalCalls <= 0
+}
+
+// per-execution budget tracking for the currently running rule
+type ruleBudgetState struct {
+	maxCalls int
+	calls    int
+	// which limit was exceeded ("duration" or "calls"), if any
+	exceeded string
+}
+
+// ParseRuleBudgets parses rule budgets, as <rule>=<limit>,<limit> where each limit is "time:<duration>" or "calls:<count>" (eg, rules.BadHashtagsPostRule=time:200ms,calls:5). Rule names are as reported in rule stats.
+func ParseRuleBudgets(specs []string) (map[string]RuleBudget, error) {
+	out := make(map[string]RuleBudget)
+	for _, spec := range specs {
+		name, rawLimits, ok := strings.Cut(spec, "=")
+		if !ok || name == "" || rawLimits == "" {
+			return nil, fmt.Errorf("invalid rule budget (expected <rule>=<limit>,<limit>): %s", spec)
+		}
+		budget := RuleBudget{}
+		for _, limit := range strings.Split(rawLimits, ",") {
+			kind, val, _ := strings.Cut(limit, ":")
+			switch kind {
+			case "time":
+				d, err := time.ParseDuration(val)
+				if err != nil || d <= 0 {
+					return nil, fmt.Errorf("invalid rule budget time (%s): %s", limit, spec)
+				}
+				budget.MaxDuration = d
+			case "calls":
+				n, err := strconv.Atoi(val)
+				if err != nil || n <= 0 {
+					return nil, fmt.Errorf("invalid rule budget calls (%s): %s", limit, spec)
+				}
+				budget.MaxExternalCalls = n
+			default:
+				return nil, fmt.Errorf("invalid rule budget limit (%s): %s", limit, spec)
+			}
+		}
+		out[name] = budget
+	}
+	return out, nil
+}
+
+// returns the budget for the named rule: a rule-specific budget if configured, otherwise the default
+func (eng *Engine) ruleBudget(rule string) RuleBudget {
+	if b, ok := eng.Config.RuleBudgets[rule]; ok {
+		return b
+	}
+	return eng.Config.DefaultRuleBudget
+}
+
+// runs a single rule, within its budget, and records stats for the run. 'f' is the rule function (used to identify the rule); 'run' calls it.
+func (c *BaseContext) runRule(f any, run func() error) error {
+	if c.replay != nil {
+		m := c.startReplay()
+		defer c.logReplay(f, m)
+	}
+	before := c.actionCount()
+	if c.engine == nil || (c.engine.Config.DefaultRuleBudget.isZero() && len(c.engine.Config.RuleBudgets) == 0) {
+		err := run()
+		c.recordRuleRun(f, before)
+		return err
+	}
+
+	rule := ruleName(f)
+	budget := c.engine.ruleBudget(rule)
+	if budget.isZero() {
+		err := run()
+		c.recordRuleRun(f, before)
+		return err
+	}
+
+	parent := c.Ctx
+	ctx := parent
+	cancel := func() {}
+	if budget.MaxDuration > 0 {
+		ctx, cancel = context.WithTimeout(parent, budget.MaxDuration)
+	}
+	state := &ruleBudgetState{maxCalls: budget.MaxExternalCalls}
+	c.Ctx = ctx
+	c.budget = state
+	defer func() {
+		cancel()
+		c.Ctx = parent
+		c.budget = nil
+	}()
+
+	err := run()
+	if state.exceeded == "" && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		state.exceeded = "duration"
+	}
+	c.recordRuleRun(f, before)
+	if state.exceeded != "" {
+		ruleBudgetExceededCount.WithLabelValues(rule, state.exceeded).Inc()
+		c.Logger.Warn("rule exceeded its budget", "rule", rule, "budget", state.exceeded, "calls", state.calls)
+		if c.engine.Stats != nil {
+			c.engine.Stats.recordOverBudget(rule)
+		}
+	}
+	return err
+}
+
+// accounts for an external lookup by the running rule. returns false if the lookup should be skipped, because the rule is over budget
+func (c *BaseContext) spend() bool {
+	b := c.budget
+	if b == nil {
+		return true
+	}
+	if b.exceeded == "" {
+		if errors.Is(c.Ctx.Err(), context.DeadlineExceeded) {
+			b.exceeded = "duration"
+		} else if b.maxCalls > 0 && b.calls >= b.maxCalls {
+			b.exceeded = "calls"
+		}
+	}
+	if b.exceeded != "" {
+		if nil == c.Err {
+			c.Err = ErrRuleBudgetExceeded
+		}
+		return false
+	}
+	b.calls++
+	return true
+}
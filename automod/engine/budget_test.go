@@ -0,0 +1,95 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var budgetHits int
+
+func greedyPostRule(c *RecordContext, post *appbsky.FeedPost) error {
+	budgetHits = 0
+	for i := 0; i < 10; i++ {
+		if c.InSet("bad-hashtags", "slur") {
+			budgetHits++
+		}
+	}
+	return nil
+}
+
+func slowPostRule(c *RecordContext, post *appbsky.FeedPost) error {
+	budgetHits = 0
+	time.Sleep(20 * time.Millisecond)
+	if c.InSet("bad-hashtags", "slur") {
+		budgetHits++
+	}
+	return nil
+}
+
+func TestRuleBudgets(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	budgets, err := ParseRuleBudgets([]string{
+		"engine.greedyPostRule=calls:3",
+		"engine.slowPostRule=time:5ms,calls:100",
+	})
+	assert.NoError(err)
+	assert.Equal(RuleBudget{MaxDuration: 5 * time.Millisecond, MaxExternalCalls: 100}, budgets["engine.slowPostRule"])
+	for _, bad := range []string{"engine.slowPostRule", "engine.slowPostRule=time:soon", "engine.slowPostRule=calls:0", "engine.slowPostRule=memory:1"} {
+		_, err := ParseRuleBudgets([]string{bad})
+		assert.Error(err, bad)
+	}
+
+	p := appbsky.FeedPost{Text: "hello"}
+	buf := new(bytes.Buffer)
+	assert.NoError(p.MarshalCBOR(buf))
+	cid1 := syntax.CID("cid123")
+	op := RecordOp{
+		Action:     CreateOp,
+		DID:        syntax.DID("did:plc:abc111"),
+		Collection: syntax.NSID("app.bsky.feed.post"),
+		RecordKey:  syntax.RecordKey("abc123"),
+		CID:        &cid1,
+		RecordCBOR: buf.Bytes(),
+	}
+
+	run := func(rule PostRuleFunc, cfg EngineConfig) *RuleStats {
+		eng := EngineTestFixture()
+		eng.Rules = RuleSet{PostRules: []PostRuleFunc{rule}}
+		eng.Stats = NewRuleStats()
+		eng.Config = cfg
+		assert.NoError(eng.ProcessRecordOp(ctx, op))
+		return eng.Stats
+	}
+
+	// no budgets
+	stats := run(greedyPostRule, EngineConfig{})
+	assert.Equal(10, budgetHits)
+	assert.Equal(int64(0), stats.Rules()[0].OverBudget)
+
+	// external calls beyond the budget are skipped
+	stats = run(greedyPostRule, EngineConfig{RuleBudgets: budgets})
+	assert.Equal(3, budgetHits)
+	assert.Equal(int64(1), stats.Rules()[0].OverBudget)
+
+	// as are calls after the deadline
+	stats = run(slowPostRule, EngineConfig{RuleBudgets: budgets})
+	assert.Equal(0, budgetHits)
+	assert.Equal(int64(1), stats.Rules()[0].OverBudget)
+
+	// the default budget applies to rules without their own
+	stats = run(greedyPostRule, EngineConfig{DefaultRuleBudget: RuleBudget{MaxExternalCalls: 5}})
+	assert.Equal(5, budgetHits)
+	assert.Equal(int64(1), stats.Rules()[0].OverBudget)
+	stats = run(greedyPostRule, EngineConfig{DefaultRuleBudget: RuleBudget{MaxExternalCalls: 5}, RuleBudgets: map[string]RuleBudget{"engine.greedyPostRule": {}}})
+	assert.Equal(10, budgetHits)
+	assert.Equal(int64(0), stats.Rules()[0].OverBudget)
+}
@@ -0,0 +1,126 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/automod/canarystore"
+)
+
+// an interaction target extracted from a record
+type canaryTarget struct {
+	Kind    string
+	Subject string
+}
+
+// adds the record's AT-URI, and the DID of the account it belongs to, as targets
+func addURITarget(out []canaryTarget, kind, raw string) []canaryTarget {
+	uri, err := syntax.ParseATURI(raw)
+	if err != nil {
+		return out
+	}
+	return append(out,
+		canaryTarget{Kind: kind, Subject: uri.String()},
+		canaryTarget{Kind: kind, Subject: uri.Authority().String()},
+	)
+}
+
+// extracts the accounts and records which a created or updated record interacts with. Unknown collections and unparseable records have no targets.
+func canaryTargets(op RecordOp) []canaryTarget {
+	var out []canaryTarget
+	rdr := bytes.NewReader(op.RecordCBOR)
+	switch op.Collection.String() {
+	case "app.bsky.feed.post":
+		var post appbsky.FeedPost
+		if err := post.UnmarshalCBOR(rdr); err != nil {
+			return nil
+		}
+		if post.Reply != nil {
+			if post.Reply.Parent != nil {
+				out = addURITarget(out, canarystore.KindReply, post.Reply.Parent.Uri)
+			}
+			if post.Reply.Root != nil {
+				out = addURITarget(out, canarystore.KindReply, post.Reply.Root.Uri)
+			}
+		}
+		for _, facet := range post.Facets {
+			for _, feat := range facet.Features {
+				if feat.RichtextFacet_Mention != nil {
+					out = append(out, canaryTarget{Kind: canarystore.KindMention, Subject: feat.RichtextFacet_Mention.Did})
+				}
+			}
+		}
+		if post.Embed != nil {
+			if post.Embed.EmbedRecord != nil && post.Embed.EmbedRecord.Record != nil {
+				out = addURITarget(out, canarystore.KindQuote, post.Embed.EmbedRecord.Record.Uri)
+			} else if post.Embed.EmbedRecordWithMedia != nil && post.Embed.EmbedRecordWithMedia.Record != nil && post.Embed.EmbedRecordWithMedia.Record.Record != nil {
+				out = addURITarget(out, canarystore.KindQuote, post.Embed.EmbedRecordWithMedia.Record.Record.Uri)
+			}
+		}
+	case "app.bsky.graph.follow":
+		var follow appbsky.GraphFollow
+		if err := follow.UnmarshalCBOR(rdr); err != nil {
+			return nil
+		}
+		out = append(out, canaryTarget{Kind: canarystore.KindFollow, Subject: follow.Subject})
+	case "app.bsky.graph.block":
+		var block appbsky.GraphBlock
+		if err := block.UnmarshalCBOR(rdr); err != nil {
+			return nil
+		}
+		out = append(out, canaryTarget{Kind: canarystore.KindBlock, Subject: block.Subject})
+	case "app.bsky.feed.like":
+		var like appbsky.FeedLike
+		if err := like.UnmarshalCBOR(rdr); err != nil || like.Subject == nil {
+			return nil
+		}
+		out = addURITarget(out, canarystore.KindLike, like.Subject.Uri)
+	case "app.bsky.feed.repost":
+		var repost appbsky.FeedRepost
+		if err := repost.UnmarshalCBOR(rdr); err != nil || repost.Subject == nil {
+			return nil
+		}
+		out = addURITarget(out, canarystore.KindRepost, repost.Subject.Uri)
+	}
+	return out
+}
+
+// checks the record's interaction targets against the canary store, returning any hits. Interactions by a canary account with itself don't count.
+func (eng *Engine) detectCanaryHits(ctx context.Context, op RecordOp) ([]canarystore.Hit, error) {
+	if eng.Canaries == nil || op.Action == DeleteOp {
+		return nil, nil
+	}
+
+	actor := op.DID.String()
+	seen := make(map[string]bool)
+	var hits []canarystore.Hit
+	for _, t := range canaryTargets(op) {
+		if t.Subject == "" || t.Subject == actor || seen[t.Subject] {
+			continue
+		}
+		seen[t.Subject] = true
+		ok, err := eng.Canaries.IsCanary(ctx, t.Subject)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			hits = append(hits, canarystore.Hit{Canary: t.Subject, Actor: actor, Kind: t.Kind})
+		}
+	}
+	return hits, nil
+}
+
+func (eng *Engine) persistCanaryHits(ctx context.Context, hits []canarystore.Hit) error {
+	if eng.Canaries == nil {
+		return nil
+	}
+	for _, hit := range hits {
+		canaryHitCount.WithLabelValues(hit.Kind).Inc()
+		if err := eng.Canaries.RecordHit(ctx, hit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/automod/canarystore"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanaryHits(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	eng := EngineTestFixture()
+	var recordHits []canarystore.Hit
+	eng.Rules.RecordRules = append(eng.Rules.RecordRules, func(c *RecordContext) error {
+		recordHits = c.CanaryHits
+		return nil
+	})
+
+	canaryDID := "did:plc:canary"
+	canaryPost := "at://did:plc:other/app.bsky.feed.post/honeypot"
+	assert.NoError(eng.Canaries.AddCanary(ctx, canaryDID))
+	assert.NoError(eng.Canaries.AddCanary(ctx, canaryPost))
+
+	cid1 := syntax.CID("cid123")
+	op := RecordOp{
+		Action:    CreateOp,
+		DID:       syntax.DID("did:plc:abc111"),
+		RecordKey: syntax.RecordKey("abc123"),
+		CID:       &cid1,
+	}
+
+	// ordinary post: no hits
+	buf := new(bytes.Buffer)
+	assert.NoError((&appbsky.FeedPost{Text: "hello"}).MarshalCBOR(buf))
+	op.Collection = syntax.NSID("app.bsky.feed.post")
+	op.RecordCBOR = buf.Bytes()
+	assert.NoError(eng.ProcessRecordOp(ctx, op))
+	assert.Empty(recordHits)
+
+	// mention of the canary account
+	buf = new(bytes.Buffer)
+	assert.NoError((&appbsky.FeedPost{
+		Text: "hi @canary",
+		Facets: []*appbsky.RichtextFacet{{
+			Index: &appbsky.RichtextFacet_ByteSlice{ByteStart: 3, ByteEnd: 10},
+			Features: []*appbsky.RichtextFacet_Features_Elem{{
+				RichtextFacet_Mention: &appbsky.RichtextFacet_Mention{Did: canaryDID},
+			}},
+		}},
+	}).MarshalCBOR(buf))
+	op.RecordCBOR = buf.Bytes()
+	assert.NoError(eng.ProcessRecordOp(ctx, op))
+	if assert.Len(recordHits, 1) {
+		assert.Equal(canarystore.KindMention, recordHits[0].Kind)
+		assert.Equal(canaryDID, recordHits[0].Canary)
+	}
+
+	// like of the canary record
+	buf = new(bytes.Buffer)
+	assert.NoError((&appbsky.FeedLike{
+		Subject:   &comatproto.RepoStrongRef{Uri: canaryPost, Cid: "bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm"},
+		CreatedAt: "2024-01-01T00:00:00Z",
+	}).MarshalCBOR(buf))
+	op.Collection = syntax.NSID("app.bsky.feed.like")
+	op.RecordCBOR = buf.Bytes()
+	assert.NoError(eng.ProcessRecordOp(ctx, op))
+	if assert.Len(recordHits, 1) {
+		assert.Equal(canarystore.KindLike, recordHits[0].Kind)
+		assert.Equal(canaryPost, recordHits[0].Canary)
+	}
+
+	hits, err := eng.Canaries.GetActorHits(ctx, "did:plc:abc111")
+	assert.NoError(err)
+	assert.Len(hits, 2)
+	assert.Equal(2, canarystore.DistinctCanaries(hits))
+}
@@ -4,10 +4,14 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"time"
 
 	toolsozone "github.com/bluesky-social/indigo/api/ozone"
 	"github.com/bluesky-social/indigo/atproto/identity"
 	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/automod/baselinestore"
+	"github.com/bluesky-social/indigo/automod/canarystore"
+	"github.com/bluesky-social/indigo/automod/graphstore"
 )
 
 // The primary interface exposed to rules. All other contexts derive from this "base" struct.
@@ -21,6 +25,10 @@ type BaseContext struct {
 
 	engine  *Engine // NOTE: pointer, but expected never to be nil
 	effects *Effects
+	// budget tracking for the currently running rule, if it has a budget
+	budget *ruleBudgetState
+	// inputs read by the currently running rule, if replay logging is enabled
+	replay *replayState
 }
 
 // Both a useful context on it's own (eg, for identity events), and extended by other context types.
@@ -35,6 +43,12 @@ type RecordContext struct {
 	AccountContext
 
 	RecordOp RecordOp
+	// interactions by this record with canary accounts or records. populated before rules run; empty if the engine has no canary store
+	CanaryHits []canarystore.Hit
+	// language of the record: the first declared language, or a guess from the text. empty if unknown
+	Language string
+	// language packs which apply to this record, in order of precedence. empty if the engine has no language packs
+	langPacks []*LanguagePack
 	// TODO: could consider adding commit-level metadata here. probably nullable if so, commit-level metadata isn't always available. might be best to do a separate event/context type for that
 }
 
@@ -137,6 +151,9 @@ func (c *BaseContext) Directory() identity.Directory {
 
 // request external state via engine (indirect)
 func (c *BaseContext) GetCount(name, val, period string) int {
+	if !c.spend() {
+		return 0
+	}
 	out, err := c.engine.Counters.GetCount(c.Ctx, name, val, period)
 	if err != nil {
 		if nil == c.Err {
@@ -144,10 +161,14 @@ func (c *BaseContext) GetCount(name, val, period string) int {
 		}
 		return 0
 	}
+	c.replay.countRead(name, val, period, false, out)
 	return out
 }
 
 func (c *BaseContext) GetCountDistinct(name, bucket, period string) int {
+	if !c.spend() {
+		return 0
+	}
 	out, err := c.engine.Counters.GetCountDistinct(c.Ctx, name, bucket, period)
 	if err != nil {
 		if nil == c.Err {
@@ -155,10 +176,14 @@ func (c *BaseContext) GetCountDistinct(name, bucket, period string) int {
 		}
 		return 0
 	}
+	c.replay.countRead(name, bucket, period, true, out)
 	return out
 }
 
 func (c *BaseContext) InSet(name, val string) bool {
+	if !c.spend() {
+		return false
+	}
 	out, err := c.engine.Sets.InSet(c.Ctx, name, val)
 	if err != nil {
 		if nil == c.Err {
@@ -166,6 +191,88 @@ func (c *BaseContext) InSet(name, val string) bool {
 		}
 		return false
 	}
+	c.replay.setRead(name, val, out)
+	return out
+}
+
+// Returns the number of accounts which both "a" and "b" interacted with (within the graph window). Returns zero if the engine has no graph store configured.
+func (c *BaseContext) GetSharedTargetCount(kind, a, b string) int {
+	if c.engine.Graph == nil || !c.spend() {
+		return 0
+	}
+	out, err := graphstore.SharedTargetCount(c.Ctx, c.engine.Graph, kind, a, b)
+	if err != nil {
+		if nil == c.Err {
+			c.Err = err
+		}
+		return 0
+	}
+	return out
+}
+
+// Returns other accounts which interacted with the same accounts as "did", mapped to the number of shared targets. Returns an empty map if the engine has no graph store configured.
+func (c *BaseContext) GetCoTargeters(kind, did string) map[string]int {
+	if c.engine.Graph == nil || !c.spend() {
+		return map[string]int{}
+	}
+	out, err := graphstore.CoTargeters(c.Ctx, c.engine.Graph, kind, did)
+	if err != nil {
+		if nil == c.Err {
+			c.Err = err
+		}
+		return map[string]int{}
+	}
+	return out
+}
+
+// Returns the local clustering coefficient (0.0 to 1.0) of "did" in the interaction graph. Returns zero if the engine has no graph store configured.
+func (c *BaseContext) GetClusteringCoefficient(kind, did string) float64 {
+	if c.engine.Graph == nil || !c.spend() {
+		return 0
+	}
+	out, err := graphstore.ClusteringCoefficient(c.Ctx, c.engine.Graph, kind, did)
+	if err != nil {
+		if nil == c.Err {
+			c.Err = err
+		}
+		return 0
+	}
+	return out
+}
+
+// Returns the hourly rate baseline for "key" in the "name" namespace (eg, BaselineAccountRecords and an account DID), as of now. Like counters, this does not include the current event. Returns an empty baseline (with zero scores) if the engine has no baseline store configured.
+func (c *BaseContext) GetBaseline(name, key string) baselinestore.Baseline {
+	if c.engine.Baselines == nil || !c.spend() {
+		return baselinestore.Baseline{}
+	}
+	out, err := c.engine.Baselines.GetBaseline(c.Ctx, name, key, time.Now())
+	if err != nil {
+		if nil == c.Err {
+			c.Err = err
+		}
+		return baselinestore.Baseline{}
+	}
+	return out
+}
+
+// Returns how unusual the current hour's activity is for a baseline, as a z-score: the number of standard deviations above its normal hourly rate. Zero for baselines with less than a day of history.
+func (c *BaseContext) GetBurstScore(name, key string) float64 {
+	b := c.GetBaseline(name, key)
+	return b.ZScore()
+}
+
+// Returns recent interactions by this account with canary accounts or records, most recent first (not including any from the current record). Returns an empty list if the engine has no canary store configured.
+func (c *AccountContext) GetCanaryHits() []canarystore.Hit {
+	if c.engine.Canaries == nil || !c.spend() {
+		return []canarystore.Hit{}
+	}
+	out, err := c.engine.Canaries.GetActorHits(c.Ctx, c.Account.Identity.DID.String())
+	if err != nil {
+		if nil == c.Err {
+			c.Err = err
+		}
+		return []canarystore.Hit{}
+	}
 	return out
 }
 
@@ -184,6 +291,7 @@ func NewAccountContext(ctx context.Context, eng *Engine, meta AccountMeta) Accou
 			Logger:  eng.Logger.With("did", meta.Identity.DID),
 			engine:  eng,
 			effects: &Effects{},
+			replay:  eng.newReplayState("account", meta.Identity.DID.String(), nil, &meta),
 		},
 		Account: meta,
 	}
@@ -192,15 +300,26 @@ func NewAccountContext(ctx context.Context, eng *Engine, meta AccountMeta) Accou
 func NewRecordContext(ctx context.Context, eng *Engine, meta AccountMeta, op RecordOp) RecordContext {
 	ac := NewAccountContext(ctx, eng, meta)
 	ac.BaseContext.Logger = ac.BaseContext.Logger.With("collection", op.Collection, "rkey", op.RecordKey)
-	return RecordContext{
+	if ac.replay != nil {
+		ac.replay.kind = "record"
+		ac.replay.subject = op.ATURI().String()
+		ac.replay.eventHash = hashRecordPayload(&op)
+	}
+	rc := RecordContext{
 		AccountContext: ac,
 		RecordOp:       op,
 	}
+	eng.selectLanguagePacks(&rc)
+	return rc
 }
 
 func NewNotificationContext(ctx context.Context, eng *Engine, sender, recipient AccountMeta, reason string, subject syntax.ATURI) NotificationContext {
 	ac := NewAccountContext(ctx, eng, sender)
 	ac.BaseContext.Logger = ac.BaseContext.Logger.With("recipient", recipient.Identity.DID, "reason", reason, "subject", subject.String())
+	if ac.replay != nil {
+		ac.replay.kind = "notification"
+		ac.replay.subject = subject.String()
+	}
 	return NotificationContext{
 		AccountContext: ac,
 		Recipient:      recipient,
@@ -213,6 +332,9 @@ func NewNotificationContext(ctx context.Context, eng *Engine, sender, recipient
 
 // fetch relationship metadata between this account and another account
 func (c *AccountContext) GetAccountRelationship(other syntax.DID) AccountRelationship {
+	if !c.spend() {
+		return AccountRelationship{DID: other}
+	}
 	rel, err := c.engine.GetAccountRelationship(c.Ctx, c.Account.Identity.DID, other)
 	if err != nil {
 		if nil == c.Err {
@@ -227,7 +349,9 @@ func (c *AccountContext) GetAccountRelationship(other syntax.DID) AccountRelatio
 //
 // TODO: should this take an AtIdentifier instead?
 func (c *BaseContext) GetAccountMeta(did syntax.DID) *AccountMeta {
-
+	if !c.spend() {
+		return nil
+	}
 	ident, err := c.engine.Directory.LookupDID(c.Ctx, did)
 	if err != nil {
 		if nil == c.Err {
@@ -259,10 +383,19 @@ func (c *BaseContext) IncrementPeriod(name, val string, period string) {
 	c.effects.IncrementPeriod(name, val, period)
 }
 
+func (c *BaseContext) ObserveBaseline(name, key string) {
+	c.effects.ObserveBaseline(name, key)
+}
+
 func (c *BaseContext) Notify(srv string) {
 	c.effects.Notify(srv)
 }
 
+// Records that this account interacted with "dst" in the interaction graph
+func (c *AccountContext) AddInteraction(kind, dst string) {
+	c.effects.AddGraphEdge(kind, c.Account.Identity.DID.String(), dst)
+}
+
 func (c *AccountContext) AddAccountFlag(val string) {
 	c.effects.AddAccountFlag(val)
 }
@@ -279,10 +412,19 @@ func (c *AccountContext) ReportAccount(reason, comment string) {
 	c.effects.ReportAccount(reason, comment)
 }
 
+// Reports an offense by this account against the named escalation ladder. The engine decides which actions to take, based on the severity and the account's history of offenses.
+func (c *AccountContext) ReportOffense(ladder string, sev Severity, comment string) {
+	c.effects.ReportOffense(ladder, sev, comment)
+}
+
 func (c *AccountContext) TakedownAccount() {
 	c.effects.TakedownAccount()
 }
 
+func (c *AccountContext) MuteAccount(reason string, dur time.Duration) {
+	c.effects.MuteAccount(reason, dur)
+}
+
 func (c *AccountContext) EscalateAccount() {
 	c.effects.EscalateAccount()
 }
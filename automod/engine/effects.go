@@ -2,6 +2,7 @@ package engine
 
 import (
 	"sync"
+	"time"
 )
 
 type CounterRef struct {
@@ -16,6 +17,17 @@ type CounterDistinctRef struct {
 	Val    string
 }
 
+type BaselineRef struct {
+	Name string
+	Key  string
+}
+
+type GraphEdgeRef struct {
+	Kind string
+	Src  string
+	Dst  string
+}
+
 // Mutable container for all the possible side-effects from rule execution.
 //
 // This single type tracks generic effects (eg, counter increments), account-level actions, and record-level actions (even for processing of account-level events which have no possible record-level effects).
@@ -26,6 +38,10 @@ type Effects struct {
 	CounterIncrements []CounterRef
 	// Similar to "CounterIncrements", but for "distinct" style counters
 	CounterDistinctIncrements []CounterDistinctRef // TODO: better variable names
+	// Rate baselines which this event should be counted in, in addition to those maintained by the engine.
+	BaselineObservations []BaselineRef
+	// Interaction graph edges which should be recorded as part of processing this event.
+	GraphEdges []GraphEdgeRef
 	// Label values which should be applied to the overall account, as a result of rule execution.
 	AccountLabels []string
 	// Moderation tags (similar to labels, but private) which should be applied to the overall account, as a result of rule execution.
@@ -36,6 +52,8 @@ type Effects struct {
 	AccountReports []ModReport
 	// If "true", a rule decided that the entire account should have a takedown.
 	AccountTakedown bool
+	// If set, a rule decided that the account should be muted: its events are tagged for downstream fan-out to filter or downrank, without a takedown. If several rules mute the account, the longest mute is kept.
+	AccountMute *MuteRef
 	// If "true", a rule decided that the reported account should be escalated.
 	AccountEscalate bool
 	// If "true", a rule decided that the reports on account should be resolved as acknowledged.
@@ -56,6 +74,8 @@ type Effects struct {
 	RejectEvent bool
 	// Services, if any, which should blast out a notification about this even (eg, Slack)
 	NotifyServices []string
+	// Offenses reported by rules, which get resolved against escalation ladders in to other account-level effects.
+	Offenses []OffenseRef
 }
 
 // Enqueues the named counter to be incremented at the end of all rule processing. Will automatically increment for all time periods.
@@ -82,6 +102,20 @@ func (e *Effects) IncrementDistinct(name, bucket, val string) {
 	e.CounterDistinctIncrements = append(e.CounterDistinctIncrements, CounterDistinctRef{Name: name, Bucket: bucket, Val: val})
 }
 
+// Enqueues an observation (one event, now) of the named rate baseline, to be recorded at the end of all rule processing.
+func (e *Effects) ObserveBaseline(name, key string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.BaselineObservations = append(e.BaselineObservations, BaselineRef{Name: name, Key: key})
+}
+
+// Enqueues an interaction graph edge ("src" interacted with "dst") to be recorded at the end of all rule processing.
+func (e *Effects) AddGraphEdge(kind, src, dst string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.GraphEdges = append(e.GraphEdges, GraphEdgeRef{Kind: kind, Src: src, Dst: dst})
+}
+
 // Enqueues the provided label (string value) to be added to the account at the end of rule processing.
 func (e *Effects) AddAccountLabel(val string) {
 	e.mu.Lock()
@@ -138,6 +172,19 @@ func (e *Effects) TakedownAccount() {
 	e.AccountTakedown = true
 }
 
+// Enqueues the account to be muted (a soft action; see MuteSink) for the given period at the end of rule processing. A zero duration means DefaultMuteDuration.
+func (e *Effects) MuteAccount(reason string, dur time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if dur <= 0 {
+		dur = DefaultMuteDuration
+	}
+	if e.AccountMute != nil && e.AccountMute.Duration >= dur {
+		return
+	}
+	e.AccountMute = &MuteRef{Duration: dur, Reason: reason}
+}
+
 // Enqueues the account to be "escalated" for mod review at the end of rule processing.
 func (e *Effects) EscalateAccount() {
 	e.AccountEscalate = true
@@ -228,6 +275,21 @@ func (e *Effects) Notify(srv string) {
 	e.NotifyServices = append(e.NotifyServices, srv)
 }
 
+// Records an offense against the named escalation ladder. If multiple rules report offenses against the same ladder, only the most severe is kept.
+func (e *Effects) ReportOffense(ladder string, sev Severity, comment string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i, v := range e.Offenses {
+		if v.Ladder == ladder {
+			if sev > v.Severity {
+				e.Offenses[i] = OffenseRef{Ladder: ladder, Severity: sev, Comment: comment}
+			}
+			return
+		}
+	}
+	e.Offenses = append(e.Offenses, OffenseRef{Ladder: ladder, Severity: sev, Comment: comment})
+}
+
 func (e *Effects) Reject() {
 	e.RejectEvent = true
 }
@@ -10,9 +10,13 @@ import (
 	comatproto "github.com/bluesky-social/indigo/api/atproto"
 	"github.com/bluesky-social/indigo/atproto/identity"
 	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/automod/baselinestore"
 	"github.com/bluesky-social/indigo/automod/cachestore"
+	"github.com/bluesky-social/indigo/automod/canarystore"
 	"github.com/bluesky-social/indigo/automod/countstore"
 	"github.com/bluesky-social/indigo/automod/flagstore"
+	"github.com/bluesky-social/indigo/automod/graphstore"
+	"github.com/bluesky-social/indigo/automod/outboxstore"
 	"github.com/bluesky-social/indigo/automod/setstore"
 	"github.com/bluesky-social/indigo/xrpc"
 )
@@ -34,8 +38,20 @@ type Engine struct {
 	Sets      setstore.SetStore
 	Cache     cachestore.CacheStore
 	Flags     flagstore.FlagStore
+	// interaction graph, for coordination detection. may be nil, in which case graph queries return empty results
+	Graph graphstore.GraphStore
+	// honeypot accounts and records, and interactions with them. may be nil, in which case canaries aren't tracked
+	Canaries canarystore.CanaryStore
+	// hourly rate baselines, per account and per collection (and any others rules observe), for adaptive burst detection. may be nil, in which case baselines are always empty
+	Baselines baselinestore.BaselineStore
 	// unlike the other sub-modules, this field (Notifier) may be nil
 	Notifier Notifier
+	// downstream fan-out services which account mutes are propagated to. may be nil, in which case mutes are only logged
+	Mutes MuteSink
+	// moderation actions which failed to persist are queued here for retry. may be nil, in which case failed actions are dropped
+	Outbox outboxstore.OutboxStore
+	// per-language rule parameters, selected by record language. may be nil, in which case only global sets apply
+	LanguagePacks *LanguagePacks
 	// use to fetch public account metadata from AppView; no auth
 	BskyClient *xrpc.Client
 	// used to persist moderation actions in ozone moderation service; optional, admin auth
@@ -44,6 +60,10 @@ type Engine struct {
 	AdminClient *xrpc.Client
 	// used to fetch blobs from upstream PDS instances
 	BlobClient *http.Client
+	// in-process rule and action statistics, for dashboards. optional
+	Stats *RuleStats
+	// if set, the inputs to every rule execution which results in moderation actions are logged here, so incidents can be investigated by replaying them. optional
+	ReplayLog *ReplayLog
 
 	// internal configuration
 	Config EngineConfig
@@ -60,6 +80,22 @@ type EngineConfig struct {
 	QuotaModTakedownDay int
 	// number of misc actions automod can do per day, for all subjects combined (circuit breaker)
 	QuotaModActionDay int
+	// expiration rules for flags, keyed by flag value. flags without a policy never expire
+	FlagPolicies map[string]flagstore.FlagPolicy
+	// escalation ladders which rules can report offenses against, keyed by name
+	Ladders map[string]EscalationLadder
+	// secret key for hashing email domains. if set, signup signals (email domain hash, invite tree) are included in private account metadata
+	SignupSignalsKey []byte
+	// maximum number of hops to walk up the invite tree. zero for DefaultInviteTreeMaxDepth
+	InviteTreeMaxDepth int
+	// number of attempts at a moderation action before it is dropped from the outbox. zero for DefaultOutboxMaxAttempts
+	OutboxMaxAttempts int
+	// resource budget for rules without a specific budget in RuleBudgets. zero for no limits
+	DefaultRuleBudget RuleBudget
+	// per-rule resource budgets, keyed by rule name (as reported in rule stats, eg "rules.BadHashtagsPostRule")
+	RuleBudgets map[string]RuleBudget
+	// if enabled, rules run and their effects are logged (and counted in rule stats), but nothing is persisted: no moderation actions, flags, counters, graph edges, or record history. used to preview new rules against past events
+	DryRun bool
 }
 
 // Entrypoint for external code pushing #identity events in to the engine.
@@ -122,6 +158,9 @@ func (eng *Engine) ProcessIdentityEvent(ctx context.Context, evt comatproto.Sync
 		return fmt.Errorf("rule execution failed: %w", err)
 	}
 	eng.CanonicalLogLineAccount(&ac)
+	if eng.Config.DryRun {
+		return nil
+	}
 	if err := eng.persistAccountModActions(&ac); err != nil {
 		eventErrorCount.WithLabelValues("identity").Inc()
 		return fmt.Errorf("failed to persist actions for identity event: %w", err)
@@ -130,6 +169,14 @@ func (eng *Engine) ProcessIdentityEvent(ctx context.Context, evt comatproto.Sync
 		eventErrorCount.WithLabelValues("identity").Inc()
 		return fmt.Errorf("failed to persist counters for identity event: %w", err)
 	}
+	if err := eng.persistGraphEdges(ctx, ac.effects); err != nil {
+		eventErrorCount.WithLabelValues("identity").Inc()
+		return fmt.Errorf("failed to persist interaction graph for identity event: %w", err)
+	}
+	if err := eng.persistBaselines(ctx, ac.effects, nil); err != nil {
+		eventErrorCount.WithLabelValues("identity").Inc()
+		return fmt.Errorf("failed to persist baselines for identity event: %w", err)
+	}
 	return nil
 }
 
@@ -193,6 +240,9 @@ func (eng *Engine) ProcessAccountEvent(ctx context.Context, evt comatproto.SyncS
 		return fmt.Errorf("rule execution failed: %w", err)
 	}
 	eng.CanonicalLogLineAccount(&ac)
+	if eng.Config.DryRun {
+		return nil
+	}
 	if err := eng.persistAccountModActions(&ac); err != nil {
 		eventErrorCount.WithLabelValues("account").Inc()
 		return fmt.Errorf("failed to persist actions for account event: %w", err)
@@ -201,6 +251,14 @@ func (eng *Engine) ProcessAccountEvent(ctx context.Context, evt comatproto.SyncS
 		eventErrorCount.WithLabelValues("account").Inc()
 		return fmt.Errorf("failed to persist counters for account event: %w", err)
 	}
+	if err := eng.persistGraphEdges(ctx, ac.effects); err != nil {
+		eventErrorCount.WithLabelValues("account").Inc()
+		return fmt.Errorf("failed to persist interaction graph for account event: %w", err)
+	}
+	if err := eng.persistBaselines(ctx, ac.effects, nil); err != nil {
+		eventErrorCount.WithLabelValues("account").Inc()
+		return fmt.Errorf("failed to persist baselines for account event: %w", err)
+	}
 	return nil
 }
 
@@ -255,12 +313,25 @@ func (eng *Engine) ProcessRecordOp(ctx context.Context, op RecordOp) error {
 	rc.Logger.Debug("processing record")
 	switch op.Action {
 	case CreateOp, UpdateOp:
+		rc.CanaryHits, err = eng.detectCanaryHits(ctx, op)
+		if err != nil {
+			rc.Logger.Warn("failed to check for canary interactions", "err", err)
+		}
 		if err := eng.Rules.CallRecordRules(&rc); err != nil {
 			eventErrorCount.WithLabelValues("record").Inc()
 			return fmt.Errorf("rule execution failed: %w", err)
 		}
 	case DeleteOp:
-		if err := eng.Rules.CallRecordDeleteRules(&rc); err != nil {
+		prev, err := eng.GetRecordHistory(ctx, op.ATURI().String())
+		if err != nil {
+			rc.Logger.Warn("failed to fetch record history", "err", err)
+		}
+		if prev != nil {
+			deletedRecordHistoryCount.WithLabelValues("hit").Inc()
+		} else {
+			deletedRecordHistoryCount.WithLabelValues("miss").Inc()
+		}
+		if err := eng.Rules.CallRecordDeleteRules(&rc, prev); err != nil {
 			eventErrorCount.WithLabelValues("record").Inc()
 			return fmt.Errorf("rule execution failed: %w", err)
 		}
@@ -269,6 +340,9 @@ func (eng *Engine) ProcessRecordOp(ctx context.Context, op RecordOp) error {
 		return fmt.Errorf("unexpected op action: %s", op.Action)
 	}
 	eng.CanonicalLogLineRecord(&rc)
+	if eng.Config.DryRun {
+		return nil
+	}
 	// purge the account meta cache when profile is updated
 	if rc.RecordOp.Collection == "app.bsky.actor.profile" {
 		if err := eng.PurgeAccountCaches(ctx, op.DID); err != nil {
@@ -279,10 +353,30 @@ func (eng *Engine) ProcessRecordOp(ctx context.Context, op RecordOp) error {
 		eventErrorCount.WithLabelValues("record").Inc()
 		return fmt.Errorf("failed to persist actions for record event: %w", err)
 	}
+	// remember what happened to this record, for the benefit of any later delete rules
+	if op.Action == DeleteOp {
+		if err := eng.Cache.Purge(ctx, "rec", op.ATURI().String()); err != nil {
+			rc.Logger.Error("failed to purge record history cache", "err", err)
+		}
+	} else if err := eng.updateRecordHistory(&rc, time.Now()); err != nil {
+		rc.Logger.Error("failed to update record history cache", "err", err)
+	}
 	if err := eng.persistCounters(ctx, rc.effects); err != nil {
 		eventErrorCount.WithLabelValues("record").Inc()
 		return fmt.Errorf("failed to persist counts for record event: %w", err)
 	}
+	if err := eng.persistGraphEdges(ctx, rc.effects); err != nil {
+		eventErrorCount.WithLabelValues("record").Inc()
+		return fmt.Errorf("failed to persist interaction graph for record event: %w", err)
+	}
+	if err := eng.persistBaselines(ctx, rc.effects, &op); err != nil {
+		eventErrorCount.WithLabelValues("record").Inc()
+		return fmt.Errorf("failed to persist baselines for record event: %w", err)
+	}
+	if err := eng.persistCanaryHits(ctx, rc.CanaryHits); err != nil {
+		eventErrorCount.WithLabelValues("record").Inc()
+		return fmt.Errorf("failed to persist canary hits for record event: %w", err)
+	}
 	return nil
 }
 
@@ -361,6 +455,7 @@ func (e *Engine) CanonicalLogLineAccount(c *AccountContext) {
 		"accountFlags", c.effects.AccountFlags,
 		"accountTags", c.effects.AccountTags,
 		"accountTakedown", c.effects.AccountTakedown,
+		"accountMute", c.effects.AccountMute != nil,
 		"accountReports", len(c.effects.AccountReports),
 	)
 }
@@ -371,6 +466,7 @@ func (e *Engine) CanonicalLogLineRecord(c *RecordContext) {
 		"accountFlags", c.effects.AccountFlags,
 		"accountTags", c.effects.AccountTags,
 		"accountTakedown", c.effects.AccountTakedown,
+		"accountMute", c.effects.AccountMute != nil,
 		"accountReports", len(c.effects.AccountReports),
 		"recordLabels", c.effects.RecordLabels,
 		"recordFlags", c.effects.RecordFlags,
@@ -386,6 +482,7 @@ func (e *Engine) CanonicalLogLineNotification(c *NotificationContext) {
 		"accountFlags", c.effects.AccountFlags,
 		"accountTags", c.effects.AccountTags,
 		"accountTakedown", c.effects.AccountTakedown,
+		"accountMute", c.effects.AccountMute != nil,
 		"accountReports", len(c.effects.AccountReports),
 		"reject", c.effects.RejectEvent,
 	)
@@ -3,6 +3,7 @@ package engine
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"time"
 
 	toolsozone "github.com/bluesky-social/indigo/api/ozone"
@@ -137,6 +138,7 @@ func NewOzoneEventContext(ctx context.Context, eng *Engine, eventView *toolsozon
 				Logger:  eng.Logger.With("eventID", evt.EventID, "ozoneEventType", evt.EventType, "creatorDID", evt.CreatedBy, "subjectDID", evt.SubjectDID),
 				engine:  eng,
 				effects: &Effects{},
+				replay:  eng.newReplayState("ozone", strconv.FormatInt(evt.EventID, 10), nil, accountMeta),
 			},
 			Account: *accountMeta,
 		},
@@ -201,6 +203,10 @@ func (eng *Engine) ProcessOzoneEvent(ctx context.Context, eventView *toolsozone.
 		eventErrorCount.WithLabelValues("ozoneEvent").Inc()
 		return fmt.Errorf("failed to persist counts for ozone event: %w", err)
 	}
+	if err := eng.persistGraphEdges(ctx, ec.effects); err != nil {
+		eventErrorCount.WithLabelValues("ozoneEvent").Inc()
+		return fmt.Errorf("failed to persist interaction graph for ozone event: %w", err)
+	}
 	return nil
 }
 
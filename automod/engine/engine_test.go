@@ -8,6 +8,7 @@ import (
 	appbsky "github.com/bluesky-social/indigo/api/bsky"
 	"github.com/bluesky-social/indigo/atproto/identity"
 	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/automod/countstore"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -49,3 +50,50 @@ func TestEngineBasics(t *testing.T) {
 	op.RecordCBOR = p2cbor
 	assert.NoError(eng.ProcessRecordOp(ctx, op))
 }
+
+func flagAndCountRule(c *RecordContext, post *appbsky.FeedPost) error {
+	c.Increment("dry-run-test", c.Account.Identity.DID.String())
+	c.AddAccountFlag("dry-run-test")
+	return nil
+}
+
+func TestEngineDryRun(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	p := appbsky.FeedPost{Text: "some post blah"}
+	buf := new(bytes.Buffer)
+	assert.NoError(p.MarshalCBOR(buf))
+	cid1 := syntax.CID("cid123")
+	op := RecordOp{
+		Action:     CreateOp,
+		DID:        syntax.DID("did:plc:abc111"),
+		Collection: syntax.NSID("app.bsky.feed.post"),
+		RecordKey:  syntax.RecordKey("abc123"),
+		CID:        &cid1,
+		RecordCBOR: buf.Bytes(),
+	}
+
+	for _, dryRun := range []bool{true, false} {
+		eng := EngineTestFixture()
+		eng.Rules = RuleSet{PostRules: []PostRuleFunc{flagAndCountRule}}
+		eng.Stats = NewRuleStats()
+		eng.Config.DryRun = dryRun
+		assert.NoError(eng.ProcessRecordOp(ctx, op))
+
+		// rules run either way
+		assert.Equal(int64(1), eng.Stats.Rules()[0].Fires)
+
+		flags, err := eng.Flags.Get(ctx, op.DID.String())
+		assert.NoError(err)
+		count, err := eng.Counters.GetCount(ctx, "dry-run-test", op.DID.String(), countstore.PeriodTotal)
+		assert.NoError(err)
+		if dryRun {
+			assert.Empty(flags)
+			assert.Equal(0, count)
+		} else {
+			assert.Equal([]string{"dry-run-test"}, flags)
+			assert.Equal(1, count)
+		}
+	}
+}
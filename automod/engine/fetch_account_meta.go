@@ -29,6 +29,7 @@ func (e *Engine) GetAccountMeta(ctx context.Context, ident *identity.Identity) (
 			Identity: ident,
 			Profile:  ProfileSummary{},
 		}
+		am.setDerived()
 		return &am, nil
 	}
 
@@ -43,6 +44,7 @@ func (e *Engine) GetAccountMeta(ctx context.Context, ident *identity.Identity) (
 			return nil, fmt.Errorf("parsing AccountMeta from cache: %v", err)
 		}
 		am.Identity = ident
+		am.setDerived()
 		return &am, nil
 	}
 
@@ -70,6 +72,7 @@ func (e *Engine) GetAccountMeta(ctx context.Context, ident *identity.Identity) (
 	}
 	if err != nil {
 		logger.Warn("account profile lookup failed (from bsky appview)", "err", err)
+		am.setDerived()
 		return &am, nil
 	}
 
@@ -159,6 +162,7 @@ func (e *Engine) GetAccountMeta(ctx context.Context, ident *identity.Identity) (
 				}
 				ap.AbuseSignatures = asigs
 			}
+			e.hydrateSignupSignals(ctx, ident.DID.String(), &ap, rd.InvitedBy)
 			am.Private = &ap
 		}
 	}
@@ -180,6 +184,7 @@ func (e *Engine) GetAccountMeta(ctx context.Context, ident *identity.Identity) (
 				return nil, fmt.Errorf("bad entryway account IndexedAt: %w", err)
 			}
 			ap.IndexedAt = &ts
+			e.hydrateSignupSignals(ctx, ident.DID.String(), &ap, pv.InvitedBy)
 			am.Private = &ap
 			if am.CreatedAt == nil {
 				am.CreatedAt = &ts
@@ -199,5 +204,6 @@ func (e *Engine) GetAccountMeta(ctx context.Context, ident *identity.Identity) (
 	if err := e.Cache.Set(ctx, "acct", ident.DID.String(), string(val)); err != nil {
 		logger.Error("writing to account meta cache failed", "err", err)
 	}
+	am.setDerived()
 	return &am, nil
 }
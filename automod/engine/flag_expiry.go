@@ -0,0 +1,103 @@
+package engine
+
+import (
+	"context"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// Re-adds any flags which were triggered again, but were already set, and which have a decay policy. This resets their decay clock.
+func (eng *Engine) refreshDecayingFlags(ctx context.Context, key string, triggered, existing []string) error {
+	if len(eng.Config.FlagPolicies) == 0 {
+		return nil
+	}
+	isExisting := make(map[string]bool, len(existing))
+	for _, f := range existing {
+		isExisting[f] = true
+	}
+	refresh := []string{}
+	for _, f := range dedupeStrings(triggered) {
+		if isExisting[f] && eng.Config.FlagPolicies[f].Decay > 0 {
+			refresh = append(refresh, f)
+		}
+	}
+	if len(refresh) == 0 {
+		return nil
+	}
+	return eng.Flags.Add(ctx, key, refresh)
+}
+
+// Does a single pass over all flagged subjects, removing any flags which have expired according to the configured policies. Also updates the active flag count metrics.
+func (eng *Engine) SweepFlags(ctx context.Context) error {
+	now := time.Now()
+	active := make(map[string]int)
+	expired := 0
+
+	err := eng.Flags.ScanKeys(ctx, func(key string) error {
+		flags, err := eng.Flags.Get(ctx, key)
+		if err != nil {
+			return err
+		}
+		if len(flags) == 0 {
+			return nil
+		}
+		times, err := eng.Flags.GetTimes(ctx, key)
+		if err != nil {
+			return err
+		}
+
+		remove := []string{}
+		for _, f := range flags {
+			policy, ok := eng.Config.FlagPolicies[f]
+			if ok && policy.Expired(times[f], now) {
+				remove = append(remove, f)
+				continue
+			}
+			active[f]++
+		}
+		if len(remove) > 0 {
+			if err := eng.Flags.Remove(ctx, key, remove); err != nil {
+				return err
+			}
+			for _, f := range remove {
+				flagsExpiredCount.WithLabelValues(f).Inc()
+			}
+			expired += len(remove)
+			// account flags are part of cached account metadata
+			if _, err := syntax.ParseDID(key); err == nil {
+				if err := eng.Cache.Purge(ctx, "acct", key); err != nil {
+					eng.Logger.Warn("failed to purge account cache after flag expiry", "did", key, "err", err)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	flagsActiveGauge.Reset()
+	for f, n := range active {
+		flagsActiveGauge.WithLabelValues(f).Set(float64(n))
+	}
+
+	eng.Logger.Info("flag sweep complete", "expired", expired, "duration", time.Since(now))
+	return nil
+}
+
+// Runs SweepFlags periodically, until the context is cancelled.
+func (eng *Engine) RunFlagSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := eng.SweepFlags(ctx); err != nil {
+			eng.Logger.Error("flag sweep failed", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
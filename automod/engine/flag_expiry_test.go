@@ -0,0 +1,52 @@
+package engine
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/automod/flagstore"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSweepFlags(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	eng := EngineTestFixture()
+	eng.Config.FlagPolicies = map[string]flagstore.FlagPolicy{
+		"spam-suspect": flagstore.FlagPolicy{Decay: time.Hour},
+	}
+	flags := eng.Flags.(flagstore.MemFlagStore)
+
+	did := "did:plc:abc111"
+	assert.NoError(flags.Add(ctx, did, []string{"spam-suspect", "permanent"}))
+
+	// nothing has expired yet
+	assert.NoError(eng.SweepFlags(ctx))
+	l, err := flags.Get(ctx, did)
+	assert.NoError(err)
+	assert.ElementsMatch([]string{"spam-suspect", "permanent"}, l)
+
+	// age the flag past its decay period
+	ft := flags.Times[did]["spam-suspect"]
+	ft.Last = time.Now().Add(-2 * time.Hour)
+	flags.Times[did]["spam-suspect"] = ft
+
+	// re-triggering resets the decay clock
+	assert.NoError(eng.refreshDecayingFlags(ctx, did, []string{"spam-suspect"}, l))
+	assert.NoError(eng.SweepFlags(ctx))
+	l, err = flags.Get(ctx, did)
+	assert.NoError(err)
+	assert.ElementsMatch([]string{"spam-suspect", "permanent"}, l)
+
+	// but without re-triggering, the flag gets cleared
+	ft = flags.Times[did]["spam-suspect"]
+	ft.Last = time.Now().Add(-2 * time.Hour)
+	flags.Times[did]["spam-suspect"] = ft
+	assert.NoError(eng.SweepFlags(ctx))
+	l, err = flags.Get(ctx, did)
+	assert.NoError(err)
+	assert.Equal([]string{"permanent"}, l)
+}
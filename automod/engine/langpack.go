@@ -0,0 +1,263 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/bluesky-social/indigo/atproto/data"
+)
+
+// A LanguagePack holds rule parameters (sets and thresholds) which only apply to records in a given language.
+//
+// Sets in a pack extend the global set of the same name: a value matches if it is in the pack set, in the set of any fallback pack, or in the global set. Values in the pack's allow-list for a set never match, which lets a pack suppress global terms which are benign in that language. Thresholds are taken from the first pack in the fallback chain which defines them.
+type LanguagePack struct {
+	// BCP-47 language tag this pack applies to, eg "pt" or "pt-BR"
+	Lang string `json:"lang"`
+	// optional language tag of another pack to consult after this one. the default pack is always consulted last
+	Fallback string `json:"fallback,omitempty"`
+	// additional set values, keyed by set name
+	Sets map[string][]string `json:"sets,omitempty"`
+	// values which never match in this language, keyed by set name
+	Allow map[string][]string `json:"allow,omitempty"`
+	// numeric rule parameters, keyed by name
+	Thresholds map[string]float64 `json:"thresholds,omitempty"`
+
+	sets  map[string]map[string]bool
+	allow map[string]map[string]bool
+}
+
+// Collection of language packs, keyed by lowercase language tag.
+type LanguagePacks struct {
+	Packs map[string]*LanguagePack
+	// language tag of the pack used when a record's language is unknown, or has no pack of its own. optional
+	Default string
+}
+
+type languagePacksJSON struct {
+	Default string          `json:"default"`
+	Packs   []*LanguagePack `json:"packs"`
+}
+
+func toSetMap(in map[string][]string) map[string]map[string]bool {
+	out := make(map[string]map[string]bool, len(in))
+	for name, vals := range in {
+		m := make(map[string]bool, len(vals))
+		for _, v := range vals {
+			m[v] = true
+		}
+		out[name] = m
+	}
+	return out
+}
+
+func NewLanguagePacks(packs []*LanguagePack, def string) (*LanguagePacks, error) {
+	lp := &LanguagePacks{
+		Packs:   make(map[string]*LanguagePack, len(packs)),
+		Default: strings.ToLower(def),
+	}
+	for _, p := range packs {
+		tag := strings.ToLower(p.Lang)
+		if tag == "" {
+			return nil, fmt.Errorf("language pack missing lang")
+		}
+		if _, ok := lp.Packs[tag]; ok {
+			return nil, fmt.Errorf("duplicate language pack: %s", p.Lang)
+		}
+		p.sets = toSetMap(p.Sets)
+		p.allow = toSetMap(p.Allow)
+		lp.Packs[tag] = p
+	}
+	if lp.Default != "" && lp.Packs[lp.Default] == nil {
+		return nil, fmt.Errorf("default language pack not found: %s", def)
+	}
+	for _, p := range packs {
+		if p.Fallback != "" && lp.Packs[strings.ToLower(p.Fallback)] == nil {
+			return nil, fmt.Errorf("fallback language pack not found: %s (for %s)", p.Fallback, p.Lang)
+		}
+	}
+	return lp, nil
+}
+
+// Loads language packs from a JSON file, of the form:
+//
+//	{"default": "en", "packs": [{"lang": "pt", "sets": {"bad-words": [...]}, "thresholds": {...}}, ...]}
+func LoadLanguagePacksJSON(p string) (*LanguagePacks, error) {
+	raw, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	var doc languagePacksJSON
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("parsing language packs: %w", err)
+	}
+	return NewLanguagePacks(doc.Packs, doc.Default)
+}
+
+// Resolves the chain of packs to consult for a record with the given language tags, in order of precedence.
+//
+// The first tag with a pack wins, matching either exactly ("pt-br") or on the base language ("pt"). The chain is then extended with fallback packs, and finally the default pack. The returned string describes how the first pack was selected: "exact", "base", "default", or "none".
+func (lp *LanguagePacks) Select(langs []string) ([]*LanguagePack, string) {
+	var first *LanguagePack
+	match := "none"
+	for _, l := range langs {
+		tag := strings.ToLower(l)
+		if p, ok := lp.Packs[tag]; ok {
+			first, match = p, "exact"
+			break
+		}
+		if base, _, ok := strings.Cut(tag, "-"); ok {
+			if p, ok := lp.Packs[base]; ok {
+				first, match = p, "base"
+				break
+			}
+		}
+	}
+	if first == nil && lp.Default != "" {
+		first, match = lp.Packs[lp.Default], "default"
+	}
+
+	var chain []*LanguagePack
+	seen := make(map[*LanguagePack]bool)
+	for p := first; p != nil && !seen[p]; p = lp.Packs[strings.ToLower(p.Fallback)] {
+		seen[p] = true
+		chain = append(chain, p)
+	}
+	if def := lp.Packs[lp.Default]; def != nil && !seen[def] {
+		chain = append(chain, def)
+	}
+	return chain, match
+}
+
+// Scripts which mostly identify a single language. Latin, Cyrillic, etc are shared by too many languages to guess from.
+var languageScripts = []struct {
+	table *unicode.RangeTable
+	lang  string
+}{
+	{unicode.Hiragana, "ja"},
+	{unicode.Katakana, "ja"},
+	{unicode.Hangul, "ko"},
+	{unicode.Thai, "th"},
+	{unicode.Greek, "el"},
+	{unicode.Hebrew, "he"},
+	{unicode.Arabic, "ar"},
+	{unicode.Devanagari, "hi"},
+	{unicode.Han, "zh"},
+}
+
+// Makes a rough guess at the language of text, based on the writing system used. Returns an empty string if no guess can be made.
+//
+// This is only used as a fallback for records which don't declare any languages.
+func GuessTextLanguage(text string) string {
+	counts := make(map[string]int)
+	total := 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		total++
+		for _, s := range languageScripts {
+			if unicode.Is(s.table, r) {
+				counts[s.lang]++
+				break
+			}
+		}
+	}
+	// Japanese text commonly mixes kana and kanji
+	if counts["ja"] > 0 {
+		counts["ja"] += counts["zh"]
+		delete(counts, "zh")
+	}
+	best := ""
+	for _, s := range languageScripts {
+		if counts[s.lang] > counts[best] {
+			best = s.lang
+		}
+	}
+	if best == "" || counts[best]*2 < total {
+		return ""
+	}
+	return best
+}
+
+// Determines the languages of a record: declared "langs" if there are any, otherwise a guess from the "text" field.
+func recordLanguages(op RecordOp) []string {
+	if len(op.RecordCBOR) == 0 {
+		return nil
+	}
+	rec, err := data.UnmarshalCBOR(op.RecordCBOR)
+	if err != nil {
+		return nil
+	}
+	var langs []string
+	if arr, ok := rec["langs"].([]any); ok {
+		for _, v := range arr {
+			if s, ok := v.(string); ok && s != "" {
+				langs = append(langs, s)
+			}
+		}
+	}
+	if len(langs) > 0 {
+		return langs
+	}
+	if text, ok := rec["text"].(string); ok {
+		if guess := GuessTextLanguage(text); guess != "" {
+			return []string{guess}
+		}
+	}
+	return nil
+}
+
+func (eng *Engine) selectLanguagePacks(c *RecordContext) {
+	langs := recordLanguages(c.RecordOp)
+	if len(langs) > 0 {
+		c.Language = langs[0]
+	}
+	if eng.LanguagePacks == nil {
+		return
+	}
+	chain, match := eng.LanguagePacks.Select(langs)
+	c.langPacks = chain
+	name := "none"
+	if len(chain) > 0 {
+		name = chain[0].Lang
+		c.Logger = c.Logger.With("langPack", name)
+	}
+	languagePackSelectCount.WithLabelValues(name, match).Inc()
+}
+
+// Name of the language pack selected for this record, or empty string if none was.
+func (c *RecordContext) LanguagePack() string {
+	if len(c.langPacks) == 0 {
+		return ""
+	}
+	return c.langPacks[0].Lang
+}
+
+// Checks set membership, taking the record's language packs in to account (see LanguagePack).
+func (c *RecordContext) InSet(name, val string) bool {
+	for _, p := range c.langPacks {
+		if p.allow[name][val] {
+			return false
+		}
+	}
+	for _, p := range c.langPacks {
+		if p.sets[name][val] {
+			languagePackSetHitCount.WithLabelValues(p.Lang, name).Inc()
+			return true
+		}
+	}
+	return c.BaseContext.InSet(name, val)
+}
+
+// Returns a numeric rule parameter from the record's language packs, or def if no pack defines it.
+func (c *RecordContext) Threshold(name string, def float64) float64 {
+	for _, p := range c.langPacks {
+		if v, ok := p.Thresholds[name]; ok {
+			return v
+		}
+	}
+	return def
+}
@@ -0,0 +1,142 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testLanguagePacks(t *testing.T) *LanguagePacks {
+	lp, err := NewLanguagePacks([]*LanguagePack{
+		{
+			Lang:       "en",
+			Sets:       map[string][]string{"bad-words": {"englishword"}},
+			Thresholds: map[string]float64{"max-mentions": 5},
+		},
+		{
+			Lang:       "pt",
+			Sets:       map[string][]string{"bad-words": {"palavra"}},
+			Thresholds: map[string]float64{"max-mentions": 8},
+		},
+		{
+			Lang:     "pt-BR",
+			Fallback: "pt",
+			Sets:     map[string][]string{"bad-words": {"palavrao"}},
+		},
+		{
+			Lang:  "ja",
+			Allow: map[string][]string{"bad-words": {"hardr"}},
+		},
+	}, "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lp
+}
+
+func TestLanguagePackSelect(t *testing.T) {
+	assert := assert.New(t)
+	lp := testLanguagePacks(t)
+
+	langsOf := func(chain []*LanguagePack) []string {
+		var out []string
+		for _, p := range chain {
+			out = append(out, p.Lang)
+		}
+		return out
+	}
+
+	chain, match := lp.Select([]string{"pt-BR"})
+	assert.Equal("exact", match)
+	assert.Equal([]string{"pt-BR", "pt", "en"}, langsOf(chain))
+
+	chain, match = lp.Select([]string{"pt-PT"})
+	assert.Equal("base", match)
+	assert.Equal([]string{"pt", "en"}, langsOf(chain))
+
+	// first language with a pack wins
+	chain, match = lp.Select([]string{"de", "ja"})
+	assert.Equal("exact", match)
+	assert.Equal([]string{"ja", "en"}, langsOf(chain))
+
+	chain, match = lp.Select(nil)
+	assert.Equal("default", match)
+	assert.Equal([]string{"en"}, langsOf(chain))
+
+	_, err := NewLanguagePacks([]*LanguagePack{{Lang: "pt", Fallback: "es"}}, "")
+	assert.Error(err)
+	_, err = NewLanguagePacks([]*LanguagePack{{Lang: "pt"}}, "en")
+	assert.Error(err)
+}
+
+func TestGuessTextLanguage(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("ja", GuessTextLanguage("今日はいい天気ですね"))
+	assert.Equal("ko", GuessTextLanguage("안녕하세요 반갑습니다"))
+	assert.Equal("zh", GuessTextLanguage("今天天气很好"))
+	assert.Equal("el", GuessTextLanguage("καλημέρα κόσμε"))
+	assert.Equal("", GuessTextLanguage("hello world"))
+	assert.Equal("", GuessTextLanguage("hello world, 안녕"))
+	assert.Equal("", GuessTextLanguage(""))
+}
+
+func TestLanguagePackRecordContext(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	eng := EngineTestFixture()
+	eng.LanguagePacks = testLanguagePacks(t)
+	am := AccountMeta{Identity: &identity.Identity{DID: syntax.DID("did:plc:abc111")}}
+
+	recordContext := func(post appbsky.FeedPost) RecordContext {
+		buf := new(bytes.Buffer)
+		assert.NoError(post.MarshalCBOR(buf))
+		return NewRecordContext(ctx, &eng, am, RecordOp{
+			Action:     CreateOp,
+			DID:        am.Identity.DID,
+			Collection: syntax.NSID("app.bsky.feed.post"),
+			RecordKey:  syntax.RecordKey("abc123"),
+			RecordCBOR: buf.Bytes(),
+		})
+	}
+
+	c := recordContext(appbsky.FeedPost{Text: "olá", Langs: []string{"pt-BR"}})
+	assert.Equal("pt-BR", c.Language)
+	assert.Equal("pt-BR", c.LanguagePack())
+	assert.True(c.InSet("bad-words", "palavrao"))
+	assert.True(c.InSet("bad-words", "palavra"))
+	assert.True(c.InSet("bad-words", "englishword"))
+	// global sets still apply
+	assert.True(c.InSet("bad-words", "hardr"))
+	assert.False(c.InSet("bad-words", "fine"))
+	assert.Equal(8.0, c.Threshold("max-mentions", 3))
+	assert.Equal(3.0, c.Threshold("other", 3))
+
+	c = recordContext(appbsky.FeedPost{Text: "hello"})
+	assert.Equal("", c.Language)
+	assert.Equal("en", c.LanguagePack())
+	assert.False(c.InSet("bad-words", "palavra"))
+	assert.Equal(5.0, c.Threshold("max-mentions", 3))
+
+	// detected from text, and the pack's allow-list overrides the global set
+	c = recordContext(appbsky.FeedPost{Text: "こんにちは hardr"})
+	assert.Equal("ja", c.Language)
+	assert.Equal("ja", c.LanguagePack())
+	assert.False(c.InSet("bad-words", "hardr"))
+	assert.True(c.InSet("bad-words", "hardestr"))
+
+	// without packs, only global sets apply
+	eng.LanguagePacks = nil
+	c = recordContext(appbsky.FeedPost{Text: "olá", Langs: []string{"pt-BR"}})
+	assert.Equal("", c.LanguagePack())
+	assert.False(c.InSet("bad-words", "palavrao"))
+	assert.True(c.InSet("bad-words", "hardr"))
+	assert.Equal(3.0, c.Threshold("max-mentions", 3))
+}
@@ -35,6 +35,16 @@ var actionNewFlagCount = promauto.NewCounterVec(prometheus.CounterOpts{
 	Help: "Number of new flags persisted",
 }, []string{"type", "val"})
 
+var flagsActiveGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "automod_active_flags",
+	Help: "Number of subjects with each flag set, as of the last flag sweep",
+}, []string{"val"})
+
+var flagsExpiredCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "automod_expired_flags",
+	Help: "Number of flags removed by expiry policies",
+}, []string{"val"})
+
 var actionNewReportCount = promauto.NewCounterVec(prometheus.CounterOpts{
 	Name: "automod_new_action_reports",
 	Help: "Number of new flags persisted",
@@ -45,6 +55,11 @@ var actionNewTakedownCount = promauto.NewCounterVec(prometheus.CounterOpts{
 	Help: "Number of new takedowns",
 }, []string{"type"})
 
+var actionNewMuteCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "automod_new_action_mutes",
+	Help: "Number of new mutes propagated to downstream fan-out",
+}, []string{"type"})
+
 var actionNewEscalationCount = promauto.NewCounterVec(prometheus.CounterOpts{
 	Name: "automod_new_action_escalations",
 	Help: "Number of new subject escalations",
@@ -74,3 +89,58 @@ var blobDownloadDuration = promauto.NewHistogram(prometheus.HistogramOpts{
 	Name: "automod_blob_download_duration_sec",
 	Help: "Duration of blob download attempts",
 })
+
+var deletedRecordHistoryCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "automod_deleted_record_history_lookups",
+	Help: "Number of record deletions for which earlier record history was (or was not) found in cache",
+}, []string{"result"})
+
+var offenseCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "automod_offenses",
+	Help: "Number of offenses reported against escalation ladders",
+}, []string{"ladder", "severity"})
+
+var ladderStepCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "automod_ladder_steps",
+	Help: "Number of times each escalation ladder step was applied",
+}, []string{"ladder", "step"})
+
+var canaryHitCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "automod_canary_hits",
+	Help: "Number of interactions with canary accounts or records, by kind",
+}, []string{"kind"})
+
+var outboxEnqueuedCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "automod_outbox_enqueued",
+	Help: "Number of failed moderation actions queued for retry, by action",
+}, []string{"action"})
+
+var outboxRetryCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "automod_outbox_retries",
+	Help: "Number of retries of queued moderation actions, by action and result (success, failure, dropped)",
+}, []string{"action", "result"})
+
+var outboxPendingGauge = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "automod_outbox_pending",
+	Help: "Number of moderation actions waiting to be retried",
+})
+
+var languagePackSelectCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "automod_language_pack_selected",
+	Help: "Number of records evaluated with each language pack, and how the pack was matched",
+}, []string{"pack", "match"})
+
+var languagePackSetHitCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "automod_language_pack_set_hits",
+	Help: "Number of set matches which came from a language pack (rather than global sets)",
+}, []string{"pack", "set"})
+
+var ruleBudgetExceededCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "automod_rule_budget_exceeded",
+	Help: "Number of rule executions which exceeded their resource budget, by rule and exceeded limit",
+}, []string{"rule", "budget"})
+
+var replayLogCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "automod_replay_log_entries",
+	Help: "Number of rule executions written to the replay log, by result (ok, error)",
+}, []string{"result"})
@@ -0,0 +1,117 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// how long an account mute lasts, if the rule doesn't say
+const DefaultMuteDuration = 7 * 24 * time.Hour
+
+// A "soft" account action: rather than a takedown, events from the account are tagged so that downstream fan-out (eg, a rainbow firehose splitter) can filter or downrank them.
+type MuteRef struct {
+	Duration time.Duration
+	Reason   string
+}
+
+// Interface for a type which propagates account mutes to downstream fan-out services
+type MuteSink interface {
+	MuteAccount(ctx context.Context, did syntax.DID, until time.Time, reason string) error
+}
+
+// Propagates mutes to one or more rainbow instances, using the admin API ("/admin/mutes").
+type RainbowMuteSink struct {
+	// base URLs of the rainbow instances (eg, "https://rainbow.example.com")
+	Hosts      []string
+	AdminToken string
+	// optional; http.DefaultClient is used if nil
+	Client *http.Client
+}
+
+// JSON body of a rainbow mute request
+type RainbowMute struct {
+	DID    string    `json:"did"`
+	Until  time.Time `json:"until"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+func (s *RainbowMuteSink) MuteAccount(ctx context.Context, did syntax.DID, until time.Time, reason string) error {
+	body, err := json.Marshal(RainbowMute{DID: did.String(), Until: until.UTC(), Reason: reason})
+	if err != nil {
+		return err
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	// every host is attempted, even if an earlier one fails
+	var errs []error
+	for _, host := range s.Hosts {
+		u := strings.TrimSuffix(host, "/") + "/admin/mutes"
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+s.AdminToken)
+		resp, err := client.Do(req)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("muting account on %s: %w", host, err))
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			errs = append(errs, fmt.Errorf("muting account on %s: HTTP status %d", host, resp.StatusCode))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Decides whether an account mute is new: not superseded by a takedown, and not already covered by an earlier mute (tracked in the cache). An earlier mute is only extended if the new one would add more than half its duration, so that repeated offenses don't re-send the mute for every event. Mutes count against the misc mod action quota.
+func (eng *Engine) dedupeMuteAction(ctx context.Context, did syntax.DID, mute *MuteRef, takedown bool) (*time.Time, error) {
+	if mute == nil || takedown || eng.Mutes == nil {
+		return nil, nil
+	}
+	until := time.Now().Add(mute.Duration)
+	prev, err := eng.Cache.Get(ctx, "mute", did.String())
+	if err != nil {
+		return nil, fmt.Errorf("checking previous mute: %w", err)
+	}
+	if prev != "" {
+		if t, err := time.Parse(time.RFC3339, prev); err == nil && t.After(until.Add(-mute.Duration/2)) {
+			return nil, nil
+		}
+	}
+	ok, err := eng.circuitBreakModAction(ctx, true)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return &until, nil
+}
+
+// Sends the mute to the configured sink, and remembers it so that it isn't re-sent for every event from the account
+func (eng *Engine) persistAccountMute(c *AccountContext, until time.Time) {
+	ctx := c.Ctx
+	did := c.Account.Identity.DID
+	reason := c.effects.AccountMute.Reason
+	c.Logger.Info("muting account", "until", until, "reason", reason)
+	actionNewMuteCount.WithLabelValues("account").Inc()
+	if err := eng.Mutes.MuteAccount(ctx, did, until, reason); err != nil {
+		c.Logger.Error("failed to propagate account mute", "err", err)
+		return
+	}
+	if err := eng.Cache.Set(ctx, "mute", did.String(), until.UTC().Format(time.RFC3339)); err != nil {
+		c.Logger.Error("failed to cache account mute", "err", err)
+	}
+	eng.recordAction("mute", "account", did.String(), []string{reason})
+}
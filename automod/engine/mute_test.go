@@ -0,0 +1,97 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fake rainbow admin API, recording mute requests
+type fakeRainbow struct {
+	lk    sync.Mutex
+	mutes []RainbowMute
+}
+
+func (f *fakeRainbow) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/admin/mutes" || r.Header.Get("Authorization") != "Bearer secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var m RainbowMute
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	f.mutes = append(f.mutes, m)
+}
+
+func TestAccountMute(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	rainbow := &fakeRainbow{}
+	srv := httptest.NewServer(rainbow)
+	defer srv.Close()
+
+	dur := time.Hour
+	takedown := false
+	eng := EngineTestFixture()
+	eng.Mutes = &RainbowMuteSink{Hosts: []string{srv.URL}, AdminToken: "secret"}
+	eng.Rules = RuleSet{
+		PostRules: []PostRuleFunc{
+			func(c *RecordContext, post *appbsky.FeedPost) error {
+				c.MuteAccount("spammy", dur)
+				c.MuteAccount("shorter", time.Minute)
+				if takedown {
+					c.TakedownAccount()
+				}
+				return nil
+			},
+		},
+	}
+
+	cid1 := syntax.CID("cid123")
+	p1 := appbsky.FeedPost{Text: "some post blah"}
+	p1buf := new(bytes.Buffer)
+	assert.NoError(p1.MarshalCBOR(p1buf))
+	op := RecordOp{
+		Action:     CreateOp,
+		DID:        syntax.DID("did:plc:abc111"),
+		Collection: syntax.NSID("app.bsky.feed.post"),
+		RecordKey:  syntax.RecordKey("abc123"),
+		CID:        &cid1,
+		RecordCBOR: p1buf.Bytes(),
+	}
+	assert.NoError(eng.ProcessRecordOp(ctx, op))
+	assert.Len(rainbow.mutes, 1)
+	assert.Equal("did:plc:abc111", rainbow.mutes[0].DID)
+	assert.Equal("spammy", rainbow.mutes[0].Reason)
+	assert.WithinDuration(time.Now().Add(time.Hour), rainbow.mutes[0].Until, time.Minute)
+
+	// the account is already muted for long enough
+	assert.NoError(eng.ProcessRecordOp(ctx, op))
+	assert.Len(rainbow.mutes, 1)
+
+	// a longer mute is propagated
+	dur = 24 * time.Hour
+	assert.NoError(eng.ProcessRecordOp(ctx, op))
+	assert.Len(rainbow.mutes, 2)
+
+	// a takedown makes a mute moot
+	dur = 48 * time.Hour
+	takedown = true
+	assert.NoError(eng.ProcessRecordOp(ctx, op))
+	assert.Len(rainbow.mutes, 2)
+}
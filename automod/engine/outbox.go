@@ -0,0 +1,171 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	toolsozone "github.com/bluesky-social/indigo/api/ozone"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/automod/outboxstore"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// how many times a moderation action is attempted (including the original attempt) before it is dropped from the outbox, if not configured
+const DefaultOutboxMaxAttempts = 10
+
+const (
+	outboxBatchSize = 100
+	// how long a claimed outbox entry is reserved for a single worker
+	outboxLease = 5 * time.Minute
+	// how long to wait before the first retry; doubles with each further attempt
+	outboxMinBackoff = 30 * time.Second
+	outboxMaxBackoff = 1 * time.Hour
+	// bound on how long queueing a failed action can take, independent of the (possibly expired) event context
+	outboxPutTimeout = 5 * time.Second
+)
+
+func outboxBackoff(attempts int) time.Duration {
+	d := outboxMinBackoff
+	for i := 1; i < attempts && d < outboxMaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, outboxMaxBackoff)
+}
+
+func (eng *Engine) outboxMaxAttempts() int {
+	if eng.Config.OutboxMaxAttempts > 0 {
+		return eng.Config.OutboxMaxAttempts
+	}
+	return DefaultOutboxMaxAttempts
+}
+
+// Emits a moderation event to the mod service. If that fails, and an outbox is configured, the event is queued to be retried later. The error is returned either way.
+func (eng *Engine) emitModEvent(ctx context.Context, xrpcc *xrpc.Client, action, subjectType, subject string, vals []string, input *toolsozone.ModerationEmitEvent_Input) error {
+	_, err := toolsozone.ModerationEmitEvent(ctx, xrpcc, input)
+	if err != nil {
+		eng.enqueueModEvent(ctx, action, subjectType, subject, vals, input, err)
+		return err
+	}
+	return nil
+}
+
+func (eng *Engine) enqueueModEvent(ctx context.Context, action, subjectType, subject string, vals []string, input *toolsozone.ModerationEmitEvent_Input, cause error) {
+	if eng.Outbox == nil {
+		return
+	}
+	payload, err := json.Marshal(input)
+	if err != nil {
+		eng.Logger.Error("failed to encode moderation action for outbox", "action", action, "subject", subject, "err", err)
+		return
+	}
+	now := time.Now()
+	entry := outboxstore.Entry{
+		ID:          syntax.NewTIDNow(0).String(),
+		Action:      action,
+		SubjectType: subjectType,
+		Subject:     subject,
+		Vals:        vals,
+		Payload:     payload,
+		Attempts:    1,
+		LastError:   cause.Error(),
+		CreatedAt:   now,
+		NextAttempt: now.Add(outboxBackoff(1)),
+	}
+
+	// the original failure may have been the event context timing out, so don't depend on it
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), outboxPutTimeout)
+	defer cancel()
+	if err := eng.Outbox.Put(ctx, entry); err != nil {
+		eng.Logger.Error("failed to queue moderation action for retry", "action", action, "subject", subject, "err", err)
+		return
+	}
+	outboxEnqueuedCount.WithLabelValues(action).Inc()
+	eng.Logger.Warn("queued failed moderation action for retry", "action", action, "subject", subject, "id", entry.ID)
+}
+
+// Does a single pass over moderation actions in the outbox which are due to be retried. Returns the number of actions which were successfully persisted.
+func (eng *Engine) ProcessOutbox(ctx context.Context) (int, error) {
+	if eng.Outbox == nil || eng.OzoneClient == nil {
+		return 0, nil
+	}
+
+	done := 0
+	for {
+		entries, err := eng.Outbox.Due(ctx, time.Now(), outboxBatchSize, outboxLease)
+		if err != nil {
+			return done, fmt.Errorf("fetching due outbox entries: %w", err)
+		}
+		for _, e := range entries {
+			ok, err := eng.retryOutboxEntry(ctx, e)
+			if err != nil {
+				return done, err
+			}
+			if ok {
+				done++
+			}
+		}
+		if len(entries) < outboxBatchSize {
+			break
+		}
+	}
+
+	if n, err := eng.Outbox.Len(ctx); err == nil {
+		outboxPendingGauge.Set(float64(n))
+	}
+	return done, nil
+}
+
+// makes one more attempt at a queued action. returns true if the action was persisted; errors are only returned for outbox failures
+func (eng *Engine) retryOutboxEntry(ctx context.Context, e outboxstore.Entry) (bool, error) {
+	logger := eng.Logger.With("action", e.Action, "subject", e.Subject, "id", e.ID)
+
+	var input toolsozone.ModerationEmitEvent_Input
+	if err := json.Unmarshal(e.Payload, &input); err != nil {
+		logger.Error("dropping undecodable outbox entry", "err", err)
+		outboxRetryCount.WithLabelValues(e.Action, "dropped").Inc()
+		return false, eng.Outbox.Remove(ctx, e.ID)
+	}
+
+	_, err := toolsozone.ModerationEmitEvent(ctx, eng.OzoneClient, &input)
+	if err == nil {
+		logger.Info("persisted queued moderation action", "attempts", e.Attempts+1)
+		outboxRetryCount.WithLabelValues(e.Action, "success").Inc()
+		eng.recordAction(e.Action, e.SubjectType, e.Subject, e.Vals)
+		if did, err := syntax.ParseDID(e.Subject); err == nil {
+			if err := eng.PurgeAccountCaches(ctx, did); err != nil {
+				logger.Warn("failed to purge account caches", "err", err)
+			}
+		}
+		return true, eng.Outbox.Remove(ctx, e.ID)
+	}
+
+	e.Attempts++
+	e.LastError = err.Error()
+	if e.Attempts >= eng.outboxMaxAttempts() {
+		logger.Error("giving up on queued moderation action", "attempts", e.Attempts, "err", err)
+		outboxRetryCount.WithLabelValues(e.Action, "dropped").Inc()
+		return false, eng.Outbox.Remove(ctx, e.ID)
+	}
+	logger.Warn("retry of queued moderation action failed", "attempts", e.Attempts, "err", err)
+	outboxRetryCount.WithLabelValues(e.Action, "failure").Inc()
+	e.NextAttempt = time.Now().Add(outboxBackoff(e.Attempts))
+	return false, eng.Outbox.Put(ctx, e)
+}
+
+// Runs ProcessOutbox periodically, until the context is cancelled.
+func (eng *Engine) RunOutbox(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := eng.ProcessOutbox(ctx); err != nil {
+			eng.Logger.Error("outbox processing failed", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/automod/outboxstore"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fake mod service, where emitting events fails while "down" is set
+type fakeOzone struct {
+	lk      sync.Mutex
+	down    bool
+	emitted int
+}
+
+func (f *fakeOzone) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	switch r.URL.Path {
+	case "/xrpc/tools.ozone.moderation.queryEvents":
+		w.Write([]byte(`{"events":[]}`))
+	case "/xrpc/tools.ozone.moderation.emitEvent":
+		if f.down {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"InvalidRequest","message":"down for maintenance"}`))
+			return
+		}
+		f.emitted++
+		w.Write([]byte(`{}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestOutboxRetry(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	ozone := &fakeOzone{down: true}
+	srv := httptest.NewServer(ozone)
+	defer srv.Close()
+
+	eng := EngineTestFixture()
+	dir := identity.NewMockDirectory()
+	eng.Directory = &dir
+	eng.OzoneClient = &xrpc.Client{
+		Host: srv.URL,
+		Auth: &xrpc.AuthInfo{Did: "did:plc:automod"},
+	}
+	outbox := outboxstore.NewMemOutboxStore()
+	eng.Outbox = outbox
+	eng.Config.OutboxMaxAttempts = 3
+	eng.Rules = RuleSet{
+		RecordRules: []RecordRuleFunc{
+			alwaysTakedownRecordRule,
+			alwaysReportRecordRule,
+		},
+	}
+
+	ident := identity.Identity{
+		DID:    syntax.DID("did:plc:abc111"),
+		Handle: syntax.Handle("handle.example.com"),
+	}
+	dir.Insert(ident)
+	cid1 := syntax.CID("cid123")
+	p1 := appbsky.FeedPost{Text: "some post blah"}
+	p1buf := new(bytes.Buffer)
+	assert.NoError(p1.MarshalCBOR(p1buf))
+	op := RecordOp{
+		Action:     CreateOp,
+		DID:        ident.DID,
+		Collection: syntax.NSID("app.bsky.feed.post"),
+		RecordKey:  syntax.RecordKey("abc123"),
+		CID:        &cid1,
+		RecordCBOR: p1buf.Bytes(),
+	}
+	assert.NoError(eng.ProcessRecordOp(ctx, op))
+
+	// both the report and the takedown failed, and were queued
+	n, err := outbox.Len(ctx)
+	assert.NoError(err)
+	assert.Equal(2, n)
+
+	// nothing is due yet
+	done, err := eng.ProcessOutbox(ctx)
+	assert.NoError(err)
+	assert.Equal(0, done)
+
+	makeDue := func() {
+		entries, err := outbox.Due(ctx, time.Now().Add(24*time.Hour), 0, 0)
+		assert.NoError(err)
+		for _, e := range entries {
+			e.NextAttempt = time.Now().Add(-time.Second)
+			assert.NoError(outbox.Put(ctx, e))
+		}
+	}
+
+	// a failed retry keeps the entries around, with backoff
+	makeDue()
+	done, err = eng.ProcessOutbox(ctx)
+	assert.NoError(err)
+	assert.Equal(0, done)
+	entries, err := outbox.Due(ctx, time.Now().Add(24*time.Hour), 0, 0)
+	assert.NoError(err)
+	assert.Equal(2, len(entries))
+	for _, e := range entries {
+		assert.Equal(2, e.Attempts)
+		assert.Contains(e.LastError, "down for maintenance")
+	}
+
+	// once the service is back, queued actions go through
+	ozone.lk.Lock()
+	ozone.down = false
+	ozone.lk.Unlock()
+	makeDue()
+	done, err = eng.ProcessOutbox(ctx)
+	assert.NoError(err)
+	assert.Equal(2, done)
+	assert.Equal(2, ozone.emitted)
+	n, err = outbox.Len(ctx)
+	assert.NoError(err)
+	assert.Equal(0, n)
+}
+
+func TestOutboxGivesUp(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	ozone := &fakeOzone{down: true}
+	srv := httptest.NewServer(ozone)
+	defer srv.Close()
+
+	eng := EngineTestFixture()
+	eng.OzoneClient = &xrpc.Client{
+		Host: srv.URL,
+		Auth: &xrpc.AuthInfo{Did: "did:plc:automod"},
+	}
+	outbox := outboxstore.NewMemOutboxStore()
+	eng.Outbox = outbox
+	eng.Config.OutboxMaxAttempts = 2
+
+	assert.NoError(outbox.Put(ctx, outboxstore.Entry{
+		ID:          "one",
+		Action:      "takedown",
+		SubjectType: "account",
+		Subject:     "did:plc:abc111",
+		Payload:     []byte(`{"createdBy":"did:plc:automod","event":{"$type":"tools.ozone.moderation.defs#modEventTakedown"},"subject":{"$type":"com.atproto.admin.defs#repoRef","did":"did:plc:abc111"}}`),
+		Attempts:    1,
+		NextAttempt: time.Now().Add(-time.Second),
+	}))
+
+	done, err := eng.ProcessOutbox(ctx)
+	assert.NoError(err)
+	assert.Equal(0, done)
+	n, err := outbox.Len(ctx)
+	assert.NoError(err)
+	assert.Equal(0, n)
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(outboxMinBackoff, outboxBackoff(1))
+	assert.Equal(2*outboxMinBackoff, outboxBackoff(2))
+	assert.Equal(outboxMaxBackoff, outboxBackoff(50))
+}
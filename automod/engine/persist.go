@@ -32,6 +32,21 @@ func (eng *Engine) persistCounters(ctx context.Context, eff *Effects) error {
 	return nil
 }
 
+func (eng *Engine) persistGraphEdges(ctx context.Context, eff *Effects) error {
+	if eng.Graph == nil {
+		return nil
+	}
+	for _, ref := range eff.GraphEdges {
+		if ref.Src == ref.Dst {
+			continue
+		}
+		if err := eng.Graph.AddEdge(ctx, ref.Kind, ref.Src, ref.Dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Persists account-level moderation actions: new labels, new tags, new flags, new takedowns, and reports.
 //
 // If necessary, will "purge" identity and account caches, so that state updates will be picked up for subsequent events.
@@ -40,6 +55,9 @@ func (eng *Engine) persistCounters(ctx context.Context, eff *Effects) error {
 func (eng *Engine) persistAccountModActions(c *AccountContext) error {
 	ctx := c.Ctx
 
+	// offenses turn in to regular effects, which then get de-duplicated below
+	eng.applyLadders(c)
+
 	// de-dupe actions
 	newLabels := dedupeLabelActions(c.effects.AccountLabels, c.Account.AccountLabels, c.Account.AccountNegatedLabels)
 	existingTags := []string{}
@@ -48,6 +66,9 @@ func (eng *Engine) persistAccountModActions(c *AccountContext) error {
 	}
 	newTags := dedupeTagActions(c.effects.AccountTags, existingTags)
 	newFlags := dedupeFlagActions(c.effects.AccountFlags, c.Account.AccountFlags)
+	if err := eng.refreshDecayingFlags(ctx, c.Account.Identity.DID.String(), c.effects.AccountFlags, c.Account.AccountFlags); err != nil {
+		c.Logger.Error("failed to refresh decaying account flags", "err", err)
+	}
 
 	// don't report the same account multiple times on the same day for the same reason. this is a quick check; we also query the mod service API just before creating the report.
 	partialReports, err := eng.dedupeReportActions(ctx, c.Account.Identity.DID.String(), c.effects.AccountReports)
@@ -83,8 +104,13 @@ func (eng *Engine) persistAccountModActions(c *AccountContext) error {
 		}
 	}
 
+	newMute, err := eng.dedupeMuteAction(ctx, c.Account.Identity.DID, c.effects.AccountMute, newTakedown || c.Account.Takendown)
+	if err != nil {
+		return fmt.Errorf("de-duplicating mute: %w", err)
+	}
+
 	anyModActions := newTakedown || newEscalation || newAcknowledge || len(newLabels) > 0 || len(newTags) > 0 || len(newFlags) > 0 || len(newReports) > 0
-	if anyModActions && eng.Notifier != nil {
+	if (anyModActions || newMute != nil) && eng.Notifier != nil {
 		for _, srv := range dedupeStrings(c.effects.NotifyServices) {
 			if err := eng.Notifier.SendAccount(ctx, srv, c); err != nil {
 				c.Logger.Error("failed to deliver notification", "service", srv, "err", err)
@@ -99,6 +125,12 @@ func (eng *Engine) persistAccountModActions(c *AccountContext) error {
 			actionNewFlagCount.WithLabelValues("record", val).Inc()
 		}
 		eng.Flags.Add(ctx, c.Account.Identity.DID.String(), newFlags)
+		eng.recordAction("flag", "account", c.Account.Identity.DID.String(), newFlags)
+	}
+
+	// mutes go to downstream fan-out, not the mod service
+	if newMute != nil {
+		eng.persistAccountMute(c, *newMute)
 	}
 
 	// if we can't actually talk to service, bail out early
@@ -118,7 +150,7 @@ func (eng *Engine) persistAccountModActions(c *AccountContext) error {
 			actionNewLabelCount.WithLabelValues("account", val).Inc()
 		}
 		comment := "[automod]: auto-labeling account"
-		_, err := toolsozone.ModerationEmitEvent(ctx, xrpcc, &toolsozone.ModerationEmitEvent_Input{
+		err := eng.emitModEvent(ctx, xrpcc, "label", "account", c.Account.Identity.DID.String(), newLabels, &toolsozone.ModerationEmitEvent_Input{
 			CreatedBy: xrpcc.Auth.Did,
 			Event: &toolsozone.ModerationEmitEvent_Input_Event{
 				ModerationDefs_ModEventLabel: &toolsozone.ModerationDefs_ModEventLabel{
@@ -135,6 +167,8 @@ func (eng *Engine) persistAccountModActions(c *AccountContext) error {
 		})
 		if err != nil {
 			c.Logger.Error("failed to create account labels", "err", err)
+		} else {
+			eng.recordAction("label", "account", c.Account.Identity.DID.String(), newLabels)
 		}
 	}
 
@@ -145,7 +179,7 @@ func (eng *Engine) persistAccountModActions(c *AccountContext) error {
 			actionNewTagCount.WithLabelValues("account", val).Inc()
 		}
 		comment := "[automod]: auto-tagging account"
-		_, err := toolsozone.ModerationEmitEvent(ctx, xrpcc, &toolsozone.ModerationEmitEvent_Input{
+		err := eng.emitModEvent(ctx, xrpcc, "tag", "account", c.Account.Identity.DID.String(), newTags, &toolsozone.ModerationEmitEvent_Input{
 			CreatedBy: xrpcc.Auth.Did,
 			Event: &toolsozone.ModerationEmitEvent_Input_Event{
 				ModerationDefs_ModEventTag: &toolsozone.ModerationDefs_ModEventTag{
@@ -162,6 +196,8 @@ func (eng *Engine) persistAccountModActions(c *AccountContext) error {
 		})
 		if err != nil {
 			c.Logger.Error("failed to create account tags", "err", err)
+		} else {
+			eng.recordAction("tag", "account", c.Account.Identity.DID.String(), newTags)
 		}
 	}
 
@@ -174,6 +210,7 @@ func (eng *Engine) persistAccountModActions(c *AccountContext) error {
 		}
 		if created {
 			createdReports = true
+			eng.recordAction("report", "account", c.Account.Identity.DID.String(), []string{ReasonShortName(mr.ReasonType)})
 		}
 	}
 
@@ -181,7 +218,7 @@ func (eng *Engine) persistAccountModActions(c *AccountContext) error {
 		c.Logger.Warn("account-takedown")
 		actionNewTakedownCount.WithLabelValues("account").Inc()
 		comment := "[automod]: auto account-takedown"
-		_, err := toolsozone.ModerationEmitEvent(ctx, xrpcc, &toolsozone.ModerationEmitEvent_Input{
+		err := eng.emitModEvent(ctx, xrpcc, "takedown", "account", c.Account.Identity.DID.String(), nil, &toolsozone.ModerationEmitEvent_Input{
 			CreatedBy: xrpcc.Auth.Did,
 			Event: &toolsozone.ModerationEmitEvent_Input_Event{
 				ModerationDefs_ModEventTakedown: &toolsozone.ModerationDefs_ModEventTakedown{
@@ -196,6 +233,8 @@ func (eng *Engine) persistAccountModActions(c *AccountContext) error {
 		})
 		if err != nil {
 			c.Logger.Error("failed to execute account takedown", "err", err)
+		} else {
+			eng.recordAction("takedown", "account", c.Account.Identity.DID.String(), nil)
 		}
 
 		// we don't want to escalate if there is a takedown
@@ -206,7 +245,7 @@ func (eng *Engine) persistAccountModActions(c *AccountContext) error {
 		c.Logger.Warn("account-escalate")
 		actionNewEscalationCount.WithLabelValues("account").Inc()
 		comment := "[automod]: auto account-escalation"
-		_, err := toolsozone.ModerationEmitEvent(ctx, xrpcc, &toolsozone.ModerationEmitEvent_Input{
+		err := eng.emitModEvent(ctx, xrpcc, "escalate", "account", c.Account.Identity.DID.String(), nil, &toolsozone.ModerationEmitEvent_Input{
 			CreatedBy: xrpcc.Auth.Did,
 			Event: &toolsozone.ModerationEmitEvent_Input_Event{
 				ModerationDefs_ModEventEscalate: &toolsozone.ModerationDefs_ModEventEscalate{
@@ -221,6 +260,8 @@ func (eng *Engine) persistAccountModActions(c *AccountContext) error {
 		})
 		if err != nil {
 			c.Logger.Error("failed to execute account escalation", "err", err)
+		} else {
+			eng.recordAction("escalate", "account", c.Account.Identity.DID.String(), nil)
 		}
 	}
 
@@ -228,7 +269,7 @@ func (eng *Engine) persistAccountModActions(c *AccountContext) error {
 		c.Logger.Warn("account-acknowledge")
 		actionNewAcknowledgeCount.WithLabelValues("account").Inc()
 		comment := "[automod]: auto account-acknowledge"
-		_, err := toolsozone.ModerationEmitEvent(ctx, xrpcc, &toolsozone.ModerationEmitEvent_Input{
+		err := eng.emitModEvent(ctx, xrpcc, "acknowledge", "account", c.Account.Identity.DID.String(), nil, &toolsozone.ModerationEmitEvent_Input{
 			CreatedBy: xrpcc.Auth.Did,
 			Event: &toolsozone.ModerationEmitEvent_Input_Event{
 				ModerationDefs_ModEventAcknowledge: &toolsozone.ModerationDefs_ModEventAcknowledge{
@@ -243,6 +284,8 @@ func (eng *Engine) persistAccountModActions(c *AccountContext) error {
 		})
 		if err != nil {
 			c.Logger.Error("failed to execute account acknowledge", "err", err)
+		} else {
+			eng.recordAction("acknowledge", "account", c.Account.Identity.DID.String(), nil)
 		}
 	}
 
@@ -300,6 +343,9 @@ func (eng *Engine) persistRecordModActions(c *RecordContext) error {
 		if err != nil {
 			return fmt.Errorf("failed checking record flag cache: %w", err)
 		}
+		if err := eng.refreshDecayingFlags(ctx, atURI, newFlags, existingFlags); err != nil {
+			c.Logger.Error("failed to refresh decaying record flags", "err", err)
+		}
 		newFlags = dedupeFlagActions(newFlags, existingFlags)
 	}
 
@@ -334,6 +380,7 @@ func (eng *Engine) persistRecordModActions(c *RecordContext) error {
 			actionNewFlagCount.WithLabelValues("record", val).Inc()
 		}
 		eng.Flags.Add(ctx, atURI, newFlags)
+		eng.recordAction("flag", "record", atURI, newFlags)
 	}
 
 	// exit early
@@ -364,7 +411,7 @@ func (eng *Engine) persistRecordModActions(c *RecordContext) error {
 			actionNewLabelCount.WithLabelValues("record", val).Inc()
 		}
 		comment := "[automod]: auto-labeling record"
-		_, err := toolsozone.ModerationEmitEvent(ctx, xrpcc, &toolsozone.ModerationEmitEvent_Input{
+		err := eng.emitModEvent(ctx, xrpcc, "label", "record", atURI, newLabels, &toolsozone.ModerationEmitEvent_Input{
 			CreatedBy: xrpcc.Auth.Did,
 			Event: &toolsozone.ModerationEmitEvent_Input_Event{
 				ModerationDefs_ModEventLabel: &toolsozone.ModerationDefs_ModEventLabel{
@@ -379,6 +426,8 @@ func (eng *Engine) persistRecordModActions(c *RecordContext) error {
 		})
 		if err != nil {
 			c.Logger.Error("failed to create record label", "err", err)
+		} else {
+			eng.recordAction("label", "record", atURI, newLabels)
 		}
 	}
 
@@ -389,7 +438,7 @@ func (eng *Engine) persistRecordModActions(c *RecordContext) error {
 			actionNewTagCount.WithLabelValues("record", val).Inc()
 		}
 		comment := "[automod]: auto-tagging record"
-		_, err := toolsozone.ModerationEmitEvent(ctx, xrpcc, &toolsozone.ModerationEmitEvent_Input{
+		err := eng.emitModEvent(ctx, xrpcc, "tag", "record", atURI, newTags, &toolsozone.ModerationEmitEvent_Input{
 			CreatedBy: xrpcc.Auth.Did,
 			Event: &toolsozone.ModerationEmitEvent_Input_Event{
 				ModerationDefs_ModEventTag: &toolsozone.ModerationDefs_ModEventTag{
@@ -404,21 +453,26 @@ func (eng *Engine) persistRecordModActions(c *RecordContext) error {
 		})
 		if err != nil {
 			c.Logger.Error("failed to create record tag", "err", err)
+		} else {
+			eng.recordAction("tag", "record", atURI, newTags)
 		}
 	}
 
 	for _, mr := range newReports {
-		_, err := eng.createRecordReportIfFresh(ctx, xrpcc, c.RecordOp.ATURI(), c.RecordOp.CID, mr)
+		created, err := eng.createRecordReportIfFresh(ctx, xrpcc, c.RecordOp.ATURI(), c.RecordOp.CID, mr)
 		if err != nil {
 			c.Logger.Error("failed to create record report", "err", err)
 		}
+		if created {
+			eng.recordAction("report", "record", atURI, []string{ReasonShortName(mr.ReasonType)})
+		}
 	}
 
 	if newTakedown {
 		c.Logger.Warn("record-takedown")
 		actionNewTakedownCount.WithLabelValues("record").Inc()
 		comment := "[automod]: automated record-takedown"
-		_, err := toolsozone.ModerationEmitEvent(ctx, xrpcc, &toolsozone.ModerationEmitEvent_Input{
+		err := eng.emitModEvent(ctx, xrpcc, "takedown", "record", atURI, nil, &toolsozone.ModerationEmitEvent_Input{
 			CreatedBy: xrpcc.Auth.Did,
 			Event: &toolsozone.ModerationEmitEvent_Input_Event{
 				ModerationDefs_ModEventTakedown: &toolsozone.ModerationDefs_ModEventTakedown{
@@ -432,6 +486,8 @@ func (eng *Engine) persistRecordModActions(c *RecordContext) error {
 		})
 		if err != nil {
 			c.Logger.Error("failed to execute record takedown", "err", err)
+		} else {
+			eng.recordAction("takedown", "record", atURI, nil)
 		}
 	}
 
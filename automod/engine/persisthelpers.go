@@ -99,10 +99,7 @@ func (eng *Engine) circuitBreakReports(ctx context.Context, reports []ModReport)
 		return nil, fmt.Errorf("checking report action quota: %w", err)
 	}
 
-	quotaModReportDay := eng.Config.QuotaModReportDay
-	if quotaModReportDay == 0 {
-		quotaModReportDay = 10000
-	}
+	quotaModReportDay := eng.quotaModReportDay()
 	if c >= quotaModReportDay {
 		eng.Logger.Warn("CIRCUIT BREAKER: automod reports")
 		return []ModReport{}, nil
@@ -122,10 +119,7 @@ func (eng *Engine) circuitBreakTakedown(ctx context.Context, takedown bool) (boo
 	if err != nil {
 		return false, fmt.Errorf("checking takedown action quota: %w", err)
 	}
-	quotaModTakedownDay := eng.Config.QuotaModTakedownDay
-	if quotaModTakedownDay == 0 {
-		quotaModTakedownDay = 200
-	}
+	quotaModTakedownDay := eng.quotaModTakedownDay()
 	if c >= quotaModTakedownDay {
 		eng.Logger.Warn("CIRCUIT BREAKER: automod takedowns")
 		return false, nil
@@ -146,10 +140,7 @@ func (eng *Engine) circuitBreakModAction(ctx context.Context, action bool) (bool
 	if err != nil {
 		return false, fmt.Errorf("checking mod action quota: %w", err)
 	}
-	quotaModActionDay := eng.Config.QuotaModActionDay
-	if quotaModActionDay == 0 {
-		quotaModActionDay = 2000
-	}
+	quotaModActionDay := eng.quotaModActionDay()
 	if c >= quotaModActionDay {
 		eng.Logger.Warn("CIRCUIT BREAKER: automod action")
 		return false, nil
@@ -168,6 +159,22 @@ func (eng *Engine) createReportIfFresh(ctx context.Context, xrpcc *xrpc.Client,
 	// before creating a report, query to see if automod has already reported this account in the past week for the same reason
 	// NOTE: this is running in an inner loop (if there are multiple reports), which is a bit inefficient, but seems acceptable
 
+	comment := "[automod] " + mr.Comment
+	input := &toolsozone.ModerationEmitEvent_Input{
+		CreatedBy: xrpcc.Auth.Did,
+		Event: &toolsozone.ModerationEmitEvent_Input_Event{
+			ModerationDefs_ModEventReport: &toolsozone.ModerationDefs_ModEventReport{
+				Comment:    &comment,
+				ReportType: &mr.ReasonType,
+			},
+		},
+		Subject: &toolsozone.ModerationEmitEvent_Input_Subject{
+			AdminDefs_RepoRef: &comatproto.AdminDefs_RepoRef{
+				Did: did.String(),
+			},
+		},
+	}
+
 	resp, err := toolsozone.ModerationQueryEvents(
 		ctx,
 		xrpcc,
@@ -192,6 +199,8 @@ func (eng *Engine) createReportIfFresh(ctx context.Context, xrpcc *xrpc.Client,
 	)
 
 	if err != nil {
+		// the report has already passed the daily de-dupe counter, so it won't be tried again unless queued. retries skip this API check
+		eng.enqueueModEvent(ctx, "report", "account", did.String(), []string{ReasonShortName(mr.ReasonType)}, input, err)
 		return false, err
 	}
 	for _, modEvt := range resp.Events {
@@ -219,22 +228,7 @@ func (eng *Engine) createReportIfFresh(ctx context.Context, xrpcc *xrpc.Client,
 
 	eng.Logger.Info("reporting account", "reasonType", mr.ReasonType, "comment", mr.Comment)
 	actionNewReportCount.WithLabelValues("account").Inc()
-	comment := "[automod] " + mr.Comment
-	_, err = toolsozone.ModerationEmitEvent(ctx, xrpcc, &toolsozone.ModerationEmitEvent_Input{
-		CreatedBy: xrpcc.Auth.Did,
-		Event: &toolsozone.ModerationEmitEvent_Input_Event{
-			ModerationDefs_ModEventReport: &toolsozone.ModerationDefs_ModEventReport{
-				Comment:    &comment,
-				ReportType: &mr.ReasonType,
-			},
-		},
-		Subject: &toolsozone.ModerationEmitEvent_Input_Subject{
-			AdminDefs_RepoRef: &comatproto.AdminDefs_RepoRef{
-				Did: did.String(),
-			},
-		},
-	})
-	if err != nil {
+	if err := eng.emitModEvent(ctx, xrpcc, "report", "account", did.String(), []string{ReasonShortName(mr.ReasonType)}, input); err != nil {
 		return false, err
 	}
 	return true, nil
@@ -249,6 +243,23 @@ func (eng *Engine) createRecordReportIfFresh(ctx context.Context, xrpcc *xrpc.Cl
 	// before creating a report, query to see if automod has already reported this account in the past week for the same reason
 	// NOTE: this is running in an inner loop (if there are multiple reports), which is a bit inefficient, but seems acceptable
 
+	comment := "[automod] " + mr.Comment
+	input := &toolsozone.ModerationEmitEvent_Input{
+		CreatedBy: xrpcc.Auth.Did,
+		Event: &toolsozone.ModerationEmitEvent_Input_Event{
+			ModerationDefs_ModEventReport: &toolsozone.ModerationDefs_ModEventReport{
+				Comment:    &comment,
+				ReportType: &mr.ReasonType,
+			},
+		},
+		Subject: &toolsozone.ModerationEmitEvent_Input_Subject{
+			RepoStrongRef: &comatproto.RepoStrongRef{
+				Uri: uri.String(),
+				Cid: cid.String(),
+			},
+		},
+	}
+
 	resp, err := toolsozone.ModerationQueryEvents(
 		ctx,
 		xrpcc,
@@ -272,6 +283,7 @@ func (eng *Engine) createRecordReportIfFresh(ctx context.Context, xrpcc *xrpc.Cl
 		[]string{"tools.ozone.moderation.defs#modEventReport"}, // types []string
 	)
 	if err != nil {
+		eng.enqueueModEvent(ctx, "report", "record", uri.String(), []string{ReasonShortName(mr.ReasonType)}, input, err)
 		return false, err
 	}
 	for _, modEvt := range resp.Events {
@@ -299,23 +311,7 @@ func (eng *Engine) createRecordReportIfFresh(ctx context.Context, xrpcc *xrpc.Cl
 
 	eng.Logger.Info("reporting record", "reasonType", mr.ReasonType, "comment", mr.Comment)
 	actionNewReportCount.WithLabelValues("record").Inc()
-	comment := "[automod] " + mr.Comment
-	_, err = toolsozone.ModerationEmitEvent(ctx, xrpcc, &toolsozone.ModerationEmitEvent_Input{
-		CreatedBy: xrpcc.Auth.Did,
-		Event: &toolsozone.ModerationEmitEvent_Input_Event{
-			ModerationDefs_ModEventReport: &toolsozone.ModerationDefs_ModEventReport{
-				Comment:    &comment,
-				ReportType: &mr.ReasonType,
-			},
-		},
-		Subject: &toolsozone.ModerationEmitEvent_Input_Subject{
-			RepoStrongRef: &comatproto.RepoStrongRef{
-				Uri: uri.String(),
-				Cid: cid.String(),
-			},
-		},
-	})
-	if err != nil {
+	if err := eng.emitModEvent(ctx, xrpcc, "report", "record", uri.String(), []string{ReasonShortName(mr.ReasonType)}, input); err != nil {
 		return false, err
 	}
 	return true, nil
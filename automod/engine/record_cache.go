@@ -0,0 +1,92 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// What automod remembers about a record from earlier create/update events, made available to delete rules. Only records which a rule took action against are remembered. This is best-effort: it depends on the record having been processed by this engine, and on the cache entry not having expired.
+type RecordHistory struct {
+	// CID of the most recent version of the record which was processed
+	CID string `json:"cid,omitempty"`
+	// When a rule first took action against the record (not the record's self-declared createdAt)
+	FirstSeen time.Time `json:"firstSeen"`
+	// When automod last processed an update to the record which a rule took action against
+	LastSeen time.Time `json:"lastSeen"`
+	// Labels, tags, and flags which rules applied to the record (not necessarily all those applied by other moderation systems)
+	Labels []string `json:"labels,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+	Flags  []string `json:"flags,omitempty"`
+	// If any rule reported the record
+	Reported bool `json:"reported,omitempty"`
+	// If any rule took down the record
+	TakenDown bool `json:"takenDown,omitempty"`
+}
+
+// How long the record existed since a rule first took action against it
+func (h *RecordHistory) Lifetime(now time.Time) time.Duration {
+	return now.Sub(h.FirstSeen)
+}
+
+// Returns true if any rule took an action against the record (as opposed to only observing it)
+func (h *RecordHistory) Actioned() bool {
+	return len(h.Labels) > 0 || len(h.Tags) > 0 || len(h.Flags) > 0 || h.Reported || h.TakenDown
+}
+
+// Fetches cached history for the record at the given AT-URI. Returns nil (and no error) if nothing is known.
+func (eng *Engine) GetRecordHistory(ctx context.Context, uri string) (*RecordHistory, error) {
+	existing, err := eng.Cache.Get(ctx, "rec", uri)
+	if err != nil {
+		return nil, fmt.Errorf("failed checking record history cache: %w", err)
+	}
+	if existing == "" {
+		return nil, nil
+	}
+	var h RecordHistory
+	if err := json.Unmarshal([]byte(existing), &h); err != nil {
+		return nil, fmt.Errorf("parsing RecordHistory from cache: %w", err)
+	}
+	return &h, nil
+}
+
+// Merges the outcome of rule execution for a record create/update into the cached record history. Only records which a rule took action against are stored, to avoid a cache write for every record processed.
+func (eng *Engine) updateRecordHistory(c *RecordContext, now time.Time) error {
+	c.effects.mu.Lock()
+	labels := c.effects.RecordLabels
+	tags := c.effects.RecordTags
+	flags := c.effects.RecordFlags
+	reported := len(c.effects.RecordReports) > 0
+	takedown := c.effects.RecordTakedown
+	c.effects.mu.Unlock()
+
+	if len(labels) == 0 && len(tags) == 0 && len(flags) == 0 && !reported && !takedown {
+		return nil
+	}
+
+	uri := c.RecordOp.ATURI().String()
+	h, err := eng.GetRecordHistory(c.Ctx, uri)
+	if err != nil {
+		return err
+	}
+	if h == nil {
+		h = &RecordHistory{FirstSeen: now}
+	}
+	if c.RecordOp.CID != nil {
+		h.CID = c.RecordOp.CID.String()
+	}
+	h.LastSeen = now
+
+	h.Labels = dedupeStrings(append(h.Labels, labels...))
+	h.Tags = dedupeStrings(append(h.Tags, tags...))
+	h.Flags = dedupeStrings(append(h.Flags, flags...))
+	h.Reported = h.Reported || reported
+	h.TakenDown = h.TakenDown || takedown
+
+	val, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	return eng.Cache.Set(c.Ctx, "rec", uri, string(val))
+}
@@ -0,0 +1,86 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeleteRuleRecordHistory(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	var seen []*RecordHistory
+	eng := EngineTestFixture()
+	eng.Rules.DeleteRules = []DeleteRuleFunc{
+		func(c *RecordContext, prev *RecordHistory) error {
+			seen = append(seen, prev)
+			return nil
+		},
+	}
+
+	cid1 := syntax.CID("cid123")
+	p1 := appbsky.FeedPost{
+		Text: "some post blah",
+		Tags: []string{"slur"},
+	}
+	p1buf := new(bytes.Buffer)
+	assert.NoError(p1.MarshalCBOR(p1buf))
+
+	op := RecordOp{
+		Action:     CreateOp,
+		DID:        syntax.DID("did:plc:abc111"),
+		Collection: syntax.NSID("app.bsky.feed.post"),
+		RecordKey:  syntax.RecordKey("abc123"),
+		CID:        &cid1,
+		RecordCBOR: p1buf.Bytes(),
+	}
+	assert.NoError(eng.ProcessRecordOp(ctx, op))
+
+	h, err := eng.GetRecordHistory(ctx, op.ATURI().String())
+	assert.NoError(err)
+	if assert.NotNil(h) {
+		assert.Equal("cid123", h.CID)
+		assert.Equal([]string{"bad-hashtag"}, h.Labels)
+		assert.True(h.Actioned())
+	}
+
+	del := RecordOp{
+		Action:     DeleteOp,
+		DID:        op.DID,
+		Collection: op.Collection,
+		RecordKey:  op.RecordKey,
+	}
+	assert.NoError(eng.ProcessRecordOp(ctx, del))
+	assert.Equal(1, len(seen))
+	if assert.NotNil(seen[0]) {
+		assert.Equal([]string{"bad-hashtag"}, seen[0].Labels)
+	}
+
+	// history is purged after deletion; a second delete has no context
+	assert.NoError(eng.ProcessRecordOp(ctx, del))
+	assert.Equal(2, len(seen))
+	assert.Nil(seen[1])
+
+	// records which no rule acted on are not remembered
+	p2 := appbsky.FeedPost{Text: "a perfectly fine post"}
+	p2buf := new(bytes.Buffer)
+	assert.NoError(p2.MarshalCBOR(p2buf))
+	op2 := RecordOp{
+		Action:     CreateOp,
+		DID:        syntax.DID("did:plc:abc111"),
+		Collection: syntax.NSID("app.bsky.feed.post"),
+		RecordKey:  syntax.RecordKey("abc456"),
+		CID:        &cid1,
+		RecordCBOR: p2buf.Bytes(),
+	}
+	assert.NoError(eng.ProcessRecordOp(ctx, op2))
+	h, err = eng.GetRecordHistory(ctx, op2.ATURI().String())
+	assert.NoError(err)
+	assert.Nil(h)
+}
@@ -0,0 +1,480 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/automod/countstore"
+	"github.com/bluesky-social/indigo/automod/setstore"
+)
+
+// ReplayEntry records the inputs to a single rule execution which resulted in moderation actions: enough to re-run the rule against the same event, and get the same result, when investigating an incident.
+//
+// The event itself is identified by hash rather than included, to keep entries small. It can be re-fetched (eg, from the relay, or with the 'capture-recent' command) and checked against the hash.
+type ReplayEntry struct {
+	Time time.Time `json:"time"`
+	// rule name, as reported in rule stats
+	Rule string `json:"rule"`
+	// type of event context the rule ran in: "account" (for identity and account events), "record", "notification", or "ozone"
+	Kind string `json:"kind"`
+	// DID, AT-URI, or ozone event ID
+	Subject string `json:"subject"`
+	// SHA-256 of the event payload: the record CBOR for record events. empty for deletions, and for other event types, which have no payload beyond account metadata
+	EventHash []byte `json:"eventHash,omitempty"`
+	// SHA-256 of the JSON-encoded account metadata the rule was given
+	AccountHash []byte `json:"accountHash,omitempty"`
+	// counter values read by the rule, in order
+	Counts []ReplayCountRead `json:"counts,omitempty"`
+	// set membership checks made by the rule, in order
+	Sets []ReplaySetRead `json:"sets,omitempty"`
+	// actions added by the rule, eg "account-flag:spam" or "record-takedown"
+	Actions []string `json:"actions"`
+}
+
+type ReplayCountRead struct {
+	Name string `json:"name"`
+	// counter value, or bucket for distinct counters
+	Val      string `json:"val"`
+	Period   string `json:"period"`
+	Distinct bool   `json:"distinct,omitempty"`
+	Result   int    `json:"result"`
+}
+
+type ReplaySetRead struct {
+	Name   string `json:"name"`
+	Val    string `json:"val"`
+	Result bool   `json:"result"`
+}
+
+// version byte at the start of each entry, so that logs can be appended to by later versions
+const replayLogVersion = 1
+
+// ReplayLog writes replay entries to a file (or other writer), in a compact binary format: each entry is a version byte, a uvarint length, and the encoded entry. Entries are written whole, so concurrent writers (or a crash) can't interleave partial entries.
+type ReplayLog struct {
+	lk sync.Mutex
+	w  io.Writer
+}
+
+func NewReplayLog(w io.Writer) *ReplayLog {
+	return &ReplayLog{w: w}
+}
+
+// Opens a replay log file for appending, creating it if needed.
+func OpenReplayLog(path string) (*ReplayLog, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return NewReplayLog(f), nil
+}
+
+func (l *ReplayLog) Write(e *ReplayEntry) error {
+	body := e.encode()
+	buf := make([]byte, 0, len(body)+binary.MaxVarintLen64+1)
+	buf = append(buf, replayLogVersion)
+	buf = binary.AppendUvarint(buf, uint64(len(body)))
+	buf = append(buf, body...)
+
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	_, err := l.w.Write(buf)
+	return err
+}
+
+// Closes the underlying writer, if it is closable.
+func (l *ReplayLog) Close() error {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	if c, ok := l.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (e *ReplayEntry) encode() []byte {
+	var buf []byte
+	str := func(s string) {
+		buf = binary.AppendUvarint(buf, uint64(len(s)))
+		buf = append(buf, s...)
+	}
+	flag := func(b bool) {
+		if b {
+			buf = append(buf, 1)
+		} else {
+			buf = append(buf, 0)
+		}
+	}
+	buf = binary.AppendVarint(buf, e.Time.UnixNano())
+	str(e.Rule)
+	str(e.Kind)
+	str(e.Subject)
+	str(string(e.EventHash))
+	str(string(e.AccountHash))
+	buf = binary.AppendUvarint(buf, uint64(len(e.Counts)))
+	for _, r := range e.Counts {
+		str(r.Name)
+		str(r.Val)
+		str(r.Period)
+		flag(r.Distinct)
+		buf = binary.AppendVarint(buf, int64(r.Result))
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(e.Sets)))
+	for _, r := range e.Sets {
+		str(r.Name)
+		str(r.Val)
+		flag(r.Result)
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(e.Actions)))
+	for _, a := range e.Actions {
+		str(a)
+	}
+	return buf
+}
+
+var errReplayEntryCorrupt = errors.New("corrupt replay log entry")
+
+func decodeReplayEntry(b []byte) (*ReplayEntry, error) {
+	r := bytes.NewReader(b)
+	var err error
+	uvarint := func() uint64 {
+		if err != nil {
+			return 0
+		}
+		var v uint64
+		v, err = binary.ReadUvarint(r)
+		return v
+	}
+	varint := func() int64 {
+		if err != nil {
+			return 0
+		}
+		var v int64
+		v, err = binary.ReadVarint(r)
+		return v
+	}
+	str := func() string {
+		n := uvarint()
+		if err != nil {
+			return ""
+		}
+		if n > uint64(r.Len()) {
+			err = errReplayEntryCorrupt
+			return ""
+		}
+		s := make([]byte, n)
+		_, err = io.ReadFull(r, s)
+		return string(s)
+	}
+	flag := func() bool {
+		if err != nil {
+			return false
+		}
+		var v byte
+		v, err = r.ReadByte()
+		return v != 0
+	}
+	// bounds list lengths, so a corrupt length can't cause a huge allocation
+	count := func() int {
+		n := uvarint()
+		if n > uint64(r.Len()) {
+			err = errReplayEntryCorrupt
+			return 0
+		}
+		return int(n)
+	}
+
+	e := &ReplayEntry{}
+	e.Time = time.Unix(0, varint())
+	e.Rule = str()
+	e.Kind = str()
+	e.Subject = str()
+	if h := str(); h != "" {
+		e.EventHash = []byte(h)
+	}
+	if h := str(); h != "" {
+		e.AccountHash = []byte(h)
+	}
+	for i, n := 0, count(); i < n && err == nil; i++ {
+		e.Counts = append(e.Counts, ReplayCountRead{
+			Name:     str(),
+			Val:      str(),
+			Period:   str(),
+			Distinct: flag(),
+			Result:   int(varint()),
+		})
+	}
+	for i, n := 0, count(); i < n && err == nil; i++ {
+		e.Sets = append(e.Sets, ReplaySetRead{
+			Name:   str(),
+			Val:    str(),
+			Result: flag(),
+		})
+	}
+	for i, n := 0, count(); i < n && err == nil; i++ {
+		e.Actions = append(e.Actions, str())
+	}
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = errReplayEntryCorrupt
+		}
+		return nil, err
+	}
+	return e, nil
+}
+
+// Reads entries written by a ReplayLog.
+type ReplayLogReader struct {
+	r *bufio.Reader
+}
+
+func NewReplayLogReader(r io.Reader) *ReplayLogReader {
+	return &ReplayLogReader{r: bufio.NewReader(r)}
+}
+
+// Returns the next entry, or io.EOF at the end of the log. A partially written final entry (eg, from a crash) results in io.ErrUnexpectedEOF.
+func (rr *ReplayLogReader) Next() (*ReplayEntry, error) {
+	v, err := rr.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if v != replayLogVersion {
+		return nil, fmt.Errorf("unsupported replay log entry version: %d", v)
+	}
+	n, err := binary.ReadUvarint(rr.r)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(rr.r, body); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return decodeReplayEntry(body)
+}
+
+// Returned by the stores from ReplayEntry.CountStore and ReplayEntry.SetStore for reads which weren't recorded.
+var ErrNotRecorded = errors.New("read not recorded in replay entry")
+
+// Returns a CountStore which answers reads with the values recorded in the entry, for re-running the rule deterministically. Increments are discarded.
+func (e *ReplayEntry) CountStore() countstore.CountStore {
+	return replayCountStore{entry: e}
+}
+
+// Returns a SetStore which answers membership checks with the results recorded in the entry.
+func (e *ReplayEntry) SetStore() setstore.SetStore {
+	return replaySetStore{entry: e}
+}
+
+// Checks whether a record operation is the one the entry was recorded for.
+func (e *ReplayEntry) MatchesRecord(op *RecordOp) bool {
+	return e.Kind == "record" && e.Subject == op.ATURI().String() && bytes.Equal(e.EventHash, hashRecordPayload(op))
+}
+
+// Checks whether account metadata is what the rule was given when the entry was recorded.
+func (e *ReplayEntry) MatchesAccount(am *AccountMeta) bool {
+	return bytes.Equal(e.AccountHash, hashAccountMeta(am))
+}
+
+type replayCountStore struct {
+	entry *ReplayEntry
+}
+
+func (s replayCountStore) lookup(name, val, period string, distinct bool) (int, error) {
+	for _, r := range s.entry.Counts {
+		if r.Name == name && r.Val == val && r.Period == period && r.Distinct == distinct {
+			return r.Result, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: count %s/%s/%s", ErrNotRecorded, name, val, period)
+}
+
+func (s replayCountStore) GetCount(ctx context.Context, name, val, period string) (int, error) {
+	return s.lookup(name, val, period, false)
+}
+
+func (s replayCountStore) GetCountDistinct(ctx context.Context, name, bucket, period string) (int, error) {
+	return s.lookup(name, bucket, period, true)
+}
+
+func (s replayCountStore) Increment(ctx context.Context, name, val string) error { return nil }
+
+func (s replayCountStore) IncrementPeriod(ctx context.Context, name, val, period string) error {
+	return nil
+}
+
+func (s replayCountStore) IncrementDistinct(ctx context.Context, name, bucket, val string) error {
+	return nil
+}
+
+type replaySetStore struct {
+	entry *ReplayEntry
+}
+
+func (s replaySetStore) InSet(ctx context.Context, name, val string) (bool, error) {
+	for _, r := range s.entry.Sets {
+		if r.Name == name && r.Val == val {
+			return r.Result, nil
+		}
+	}
+	return false, fmt.Errorf("%w: set %s/%s", ErrNotRecorded, name, val)
+}
+
+// per-event replay state, shared by the contexts for an event. reads are reset before each rule runs
+type replayState struct {
+	kind        string
+	subject     string
+	eventHash   []byte
+	accountHash []byte
+
+	counts []ReplayCountRead
+	sets   []ReplaySetRead
+}
+
+// returns nil if replay logging isn't enabled
+func (eng *Engine) newReplayState(kind, subject string, eventHash []byte, am *AccountMeta) *replayState {
+	if eng == nil || eng.ReplayLog == nil {
+		return nil
+	}
+	return &replayState{
+		kind:        kind,
+		subject:     subject,
+		eventHash:   eventHash,
+		accountHash: hashAccountMeta(am),
+	}
+}
+
+func hashAccountMeta(am *AccountMeta) []byte {
+	b, err := json.Marshal(am)
+	if err != nil {
+		return nil
+	}
+	h := sha256.Sum256(b)
+	return h[:]
+}
+
+func hashRecordPayload(op *RecordOp) []byte {
+	if op.RecordCBOR == nil {
+		return nil
+	}
+	h := sha256.Sum256(op.RecordCBOR)
+	return h[:]
+}
+
+func (rs *replayState) countRead(name, val, period string, distinct bool, result int) {
+	if rs == nil {
+		return
+	}
+	rs.counts = append(rs.counts, ReplayCountRead{Name: name, Val: val, Period: period, Distinct: distinct, Result: result})
+}
+
+func (rs *replayState) setRead(name, val string, result bool) {
+	if rs == nil {
+		return
+	}
+	rs.sets = append(rs.sets, ReplaySetRead{Name: name, Val: val, Result: result})
+}
+
+// clears reads, and marks the effects, before a rule runs
+func (c *BaseContext) startReplay() effectsMark {
+	c.replay.counts = nil
+	c.replay.sets = nil
+	return c.effects.mark()
+}
+
+// writes a replay entry, if the rule which just ran added any actions
+func (c *BaseContext) logReplay(f any, m effectsMark) {
+	actions := c.effects.actionsSince(m)
+	if len(actions) == 0 {
+		return
+	}
+	rs := c.replay
+	err := c.engine.ReplayLog.Write(&ReplayEntry{
+		Time:        time.Now(),
+		Rule:        ruleName(f),
+		Kind:        rs.kind,
+		Subject:     rs.subject,
+		EventHash:   rs.eventHash,
+		AccountHash: rs.accountHash,
+		Counts:      rs.counts,
+		Sets:        rs.sets,
+		Actions:     actions,
+	})
+	if err != nil {
+		replayLogCount.WithLabelValues("error").Inc()
+		c.Logger.Error("failed to write replay log entry", "err", err)
+		return
+	}
+	replayLogCount.WithLabelValues("ok").Inc()
+}
+
+// lengths of the effect lists (and values of the flags), so that the actions added by a rule can be found afterwards
+type effectsMark struct {
+	lists [8]int
+	flags [6]bool
+}
+
+func (e *Effects) actionLists() [8][]string {
+	reasons := func(reports []ModReport) []string {
+		out := make([]string, len(reports))
+		for i, r := range reports {
+			out[i] = r.ReasonType
+		}
+		return out
+	}
+	return [8][]string{e.AccountLabels, e.AccountTags, e.AccountFlags, reasons(e.AccountReports), e.RecordLabels, e.RecordTags, e.RecordFlags, reasons(e.RecordReports)}
+}
+
+var (
+	replayListNames = [8]string{"account-label", "account-tag", "account-flag", "account-report", "record-label", "record-tag", "record-flag", "record-report"}
+	replayFlagNames = [6]string{"account-takedown", "account-escalate", "account-acknowledge", "record-takedown", "reject-event", "account-mute"}
+)
+
+func (e *Effects) actionFlags() [6]bool {
+	return [6]bool{e.AccountTakedown, e.AccountEscalate, e.AccountAcknowledge, e.RecordTakedown, e.RejectEvent, e.AccountMute != nil}
+}
+
+func (e *Effects) mark() effectsMark {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var m effectsMark
+	for i, l := range e.actionLists() {
+		m.lists[i] = len(l)
+	}
+	m.flags = e.actionFlags()
+	return m
+}
+
+func (e *Effects) actionsSince(m effectsMark) []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var out []string
+	for i, l := range e.actionLists() {
+		if m.lists[i] > len(l) {
+			continue
+		}
+		for _, v := range l[m.lists[i]:] {
+			out = append(out, replayListNames[i]+":"+v)
+		}
+	}
+	for i, v := range e.actionFlags() {
+		if v && !m.flags[i] {
+			out = append(out, replayFlagNames[i])
+		}
+	}
+	return out
+}
@@ -0,0 +1,137 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/automod/countstore"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// flags accounts on their third post, if they use a bad hashtag
+func repeatPosterRule(c *RecordContext, post *appbsky.FeedPost) error {
+	did := c.Account.Identity.DID.String()
+	n := c.GetCount("replay-test-posts", did, countstore.PeriodTotal)
+	c.Increment("replay-test-posts", did)
+	if n < 2 {
+		return nil
+	}
+	for _, tag := range post.Tags {
+		if c.InSet("bad-hashtags", tag) {
+			c.AddAccountFlag("repeat-poster")
+			c.AddRecordLabel("bad-hashtag")
+			c.ReportAccount(ReportReasonSpam, "repeat poster")
+		}
+	}
+	return nil
+}
+
+func TestReplayLog(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	p := appbsky.FeedPost{Text: "some post blah", Tags: []string{"fine", "slur"}}
+	buf := new(bytes.Buffer)
+	assert.NoError(p.MarshalCBOR(buf))
+	cid1 := syntax.CID("cid123")
+	op := RecordOp{
+		Action:     CreateOp,
+		DID:        syntax.DID("did:plc:abc111"),
+		Collection: syntax.NSID("app.bsky.feed.post"),
+		RecordKey:  syntax.RecordKey("abc123"),
+		CID:        &cid1,
+		RecordCBOR: buf.Bytes(),
+	}
+
+	logBuf := new(bytes.Buffer)
+	eng := EngineTestFixture()
+	eng.Rules = RuleSet{PostRules: []PostRuleFunc{repeatPosterRule, simpleRule}}
+	eng.ReplayLog = NewReplayLog(logBuf)
+	for i := 0; i < 3; i++ {
+		assert.NoError(eng.ProcessRecordOp(ctx, op))
+	}
+
+	// only rule executions which resulted in actions are logged
+	rr := NewReplayLogReader(logBuf)
+	var entries []*ReplayEntry
+	for {
+		e, err := rr.Next()
+		if err == io.EOF {
+			break
+		}
+		if !assert.NoError(err) {
+			return
+		}
+		entries = append(entries, e)
+	}
+	if !assert.Equal(3, len(entries)) {
+		return
+	}
+	// simpleRule fires on the first two posts; on the last, repeatPosterRule fires first, leaving nothing new for simpleRule to do
+	assert.Equal(ruleName(simpleRule), entries[0].Rule)
+	assert.Equal([]string{"record-label:bad-hashtag"}, entries[0].Actions)
+	assert.Empty(entries[0].Counts)
+	entry := entries[2]
+	assert.Equal(ruleName(repeatPosterRule), entry.Rule)
+	assert.Equal("record", entry.Kind)
+	assert.Equal(op.ATURI().String(), entry.Subject)
+	assert.True(entry.MatchesRecord(&op))
+	assert.WithinDuration(time.Now(), entry.Time, time.Minute)
+	assert.Equal([]ReplayCountRead{{Name: "replay-test-posts", Val: "did:plc:abc111", Period: countstore.PeriodTotal, Result: 2}}, entry.Counts)
+	assert.Equal([]ReplaySetRead{{Name: "bad-hashtags", Val: "fine", Result: false}, {Name: "bad-hashtags", Val: "slur", Result: true}}, entry.Sets)
+	assert.Equal([]string{"account-flag:repeat-poster", "account-report:" + ReportReasonSpam, "record-label:bad-hashtag"}, entry.Actions)
+
+	// the rule gives the same result when re-run with only the logged inputs
+	replay := EngineTestFixture()
+	replay.Counters = entry.CountStore()
+	replay.Sets = entry.SetStore()
+	replay.Rules = RuleSet{PostRules: []PostRuleFunc{repeatPosterRule}}
+	ident, err := replay.Directory.LookupDID(ctx, op.DID)
+	assert.NoError(err)
+	am, err := replay.GetAccountMeta(ctx, ident)
+	assert.NoError(err)
+	assert.True(entry.MatchesAccount(am))
+	rc := NewRecordContext(ctx, &replay, *am, op)
+	assert.NoError(replay.Rules.CallRecordRules(&rc))
+	assert.NoError(rc.Err)
+	eff := ExtractEffects(&rc.BaseContext)
+	assert.Equal([]string{"repeat-poster"}, eff.AccountFlags)
+	assert.Equal([]string{"bad-hashtag"}, eff.RecordLabels)
+	assert.Equal(1, len(eff.AccountReports))
+
+	// reads which weren't logged fail
+	_, err = entry.CountStore().GetCount(ctx, "other", "did:plc:abc111", countstore.PeriodTotal)
+	assert.ErrorIs(err, ErrNotRecorded)
+}
+
+func TestReplayLogTruncated(t *testing.T) {
+	assert := assert.New(t)
+
+	buf := new(bytes.Buffer)
+	l := NewReplayLog(buf)
+	e := &ReplayEntry{
+		Time:    time.Unix(1700000000, 0),
+		Rule:    "rules.SomeRule",
+		Kind:    "account",
+		Subject: "did:plc:abc111",
+		Actions: []string{"account-takedown"},
+	}
+	assert.NoError(l.Write(e))
+	assert.NoError(l.Write(e))
+
+	b := buf.Bytes()
+	rr := NewReplayLogReader(bytes.NewReader(b[:len(b)-3]))
+	got, err := rr.Next()
+	assert.NoError(err)
+	assert.Equal(e.Rule, got.Rule)
+	assert.True(e.Time.Equal(got.Time))
+	assert.Equal(e.Actions, got.Actions)
+	_, err = rr.Next()
+	assert.ErrorIs(err, io.ErrUnexpectedEOF)
+}
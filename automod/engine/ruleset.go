@@ -15,6 +15,7 @@ type RuleSet struct {
 	ProfileRules      []ProfileRuleFunc
 	RecordRules       []RecordRuleFunc
 	RecordDeleteRules []RecordRuleFunc
+	DeleteRules       []DeleteRuleFunc
 	IdentityRules     []IdentityRuleFunc
 	AccountRules      []AccountRuleFunc
 	BlobRules         []BlobRuleFunc
@@ -26,7 +27,7 @@ type RuleSet struct {
 func (r *RuleSet) CallRecordRules(c *RecordContext) error {
 	// first the generic rules
 	for _, f := range r.RecordRules {
-		err := f(c)
+		err := c.runRule(f, func() error { return f(c) })
 		if err != nil {
 			c.Logger.Error("record rule execution failed", "err", err)
 		}
@@ -39,7 +40,7 @@ func (r *RuleSet) CallRecordRules(c *RecordContext) error {
 			return fmt.Errorf("failed to parse app.bsky.feed.post record: %v", err)
 		}
 		for _, f := range r.PostRules {
-			err := f(c, &post)
+			err := c.runRule(f, func() error { return f(c, &post) })
 			if err != nil {
 				c.Logger.Error("post rule execution failed", "err", err)
 			}
@@ -50,7 +51,7 @@ func (r *RuleSet) CallRecordRules(c *RecordContext) error {
 			return fmt.Errorf("failed to parse app.bsky.actor.profile record: %v", err)
 		}
 		for _, f := range r.ProfileRules {
-			err := f(c, &profile)
+			err := c.runRule(f, func() error { return f(c, &profile) })
 			if err != nil {
 				c.Logger.Error("profile rule execution failed", "err", err)
 			}
@@ -68,21 +69,27 @@ func (r *RuleSet) CallRecordRules(c *RecordContext) error {
 	return nil
 }
 
-// NOTE: this will probably be removed and merged in to `CallRecordRules`
-func (r *RuleSet) CallRecordDeleteRules(c *RecordContext) error {
+// Executes rules for record deletions. "prev" is the cached history of the deleted record, if any, and is passed through to DeleteRules.
+func (r *RuleSet) CallRecordDeleteRules(c *RecordContext, prev *RecordHistory) error {
 	for _, f := range r.RecordDeleteRules {
-		err := f(c)
+		err := c.runRule(f, func() error { return f(c) })
 		if err != nil {
 			c.Logger.Error("record delete rule execution failed", "err", err)
 		}
 	}
+	for _, f := range r.DeleteRules {
+		err := c.runRule(f, func() error { return f(c, prev) })
+		if err != nil {
+			c.Logger.Error("delete rule execution failed", "err", err)
+		}
+	}
 	return nil
 }
 
 // Executes rules for identity update events.
 func (r *RuleSet) CallIdentityRules(c *AccountContext) error {
 	for _, f := range r.IdentityRules {
-		err := f(c)
+		err := c.runRule(f, func() error { return f(c) })
 		if err != nil {
 			c.Logger.Error("identity rule execution failed", "err", err)
 		}
@@ -93,7 +100,7 @@ func (r *RuleSet) CallIdentityRules(c *AccountContext) error {
 // Executes rules for account update events.
 func (r *RuleSet) CallAccountRules(c *AccountContext) error {
 	for _, f := range r.AccountRules {
-		err := f(c)
+		err := c.runRule(f, func() error { return f(c) })
 		if err != nil {
 			c.Logger.Error("account rule execution failed", "err", err)
 		}
@@ -103,7 +110,7 @@ func (r *RuleSet) CallAccountRules(c *AccountContext) error {
 
 func (r *RuleSet) CallNotificationRules(c *NotificationContext) error {
 	for _, f := range r.NotificationRules {
-		err := f(c)
+		err := c.runRule(f, func() error { return f(c) })
 		if err != nil {
 			c.Logger.Error("notification rule execution failed", "err", err)
 		}
@@ -113,7 +120,7 @@ func (r *RuleSet) CallNotificationRules(c *NotificationContext) error {
 
 func (r *RuleSet) CallOzoneEventRules(c *OzoneEventContext) error {
 	for _, f := range r.OzoneEventRules {
-		err := f(c)
+		err := c.runRule(f, func() error { return f(c) })
 		if err != nil {
 			c.Logger.Error("ozone event rule execution failed", "err", err)
 		}
@@ -8,6 +8,10 @@ import (
 type IdentityRuleFunc = func(c *AccountContext) error
 type AccountRuleFunc = func(c *AccountContext) error
 type RecordRuleFunc = func(c *RecordContext) error
+
+// Triggers on record deletion. "prev" is what automod remembers about the record from earlier events, or nil if nothing is known.
+type DeleteRuleFunc = func(c *RecordContext, prev *RecordHistory) error
+
 type PostRuleFunc = func(c *RecordContext, post *appbsky.FeedPost) error
 type ProfileRuleFunc = func(c *RecordContext, profile *appbsky.ActorProfile) error
 type BlobRuleFunc = func(c *RecordContext, blob lexutil.LexBlob, data []byte) error
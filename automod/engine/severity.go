@@ -0,0 +1,237 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/automod/countstore"
+)
+
+// How bad a single offense is. Rules report offenses with a severity against a named escalation ladder, and the engine decides what action to take based on the account's history.
+type Severity int
+
+const (
+	// Recorded (and counted towards history), but never escalates on its own
+	SeverityInfo Severity = iota
+	// Moves the account one step up the ladder
+	SeverityLow
+	// Moves the account two steps up the ladder
+	SeverityHigh
+	// Jumps straight to the top step of the ladder
+	SeverityCritical
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityLow:
+		return "low"
+	case SeverityHigh:
+		return "high"
+	case SeverityCritical:
+		return "critical"
+	default:
+		return "unknown"
+	}
+}
+
+func ParseSeverity(raw string) (Severity, error) {
+	switch raw {
+	case "info":
+		return SeverityInfo, nil
+	case "low":
+		return SeverityLow, nil
+	case "high":
+		return SeverityHigh, nil
+	case "critical":
+		return SeverityCritical, nil
+	default:
+		return SeverityInfo, fmt.Errorf("unknown severity: %s", raw)
+	}
+}
+
+// Number of ladder steps an offense of this severity is worth. Critical offenses are handled separately.
+func (s Severity) weight() int {
+	switch s {
+	case SeverityLow:
+		return 1
+	case SeverityHigh:
+		return 2
+	default:
+		return 0
+	}
+}
+
+// An offense reported by a rule, to be resolved against an escalation ladder at the end of rule processing.
+type OffenseRef struct {
+	Ladder   string
+	Severity Severity
+	Comment  string
+}
+
+// The account-level actions taken at a single step of an escalation ladder. Any combination of fields may be set.
+type LadderStep struct {
+	Flag     string
+	Label    string
+	Tag      string
+	Report   string
+	Escalate bool
+	Takedown bool
+	// if non-zero, the account is muted for this long
+	Mute time.Duration
+}
+
+// A sequence of increasingly strong actions taken against an account as it accumulates offenses. The first offense applies the first step, the second offense the second step, and so on; offenses beyond the last step repeat the last step.
+type EscalationLadder struct {
+	Steps []LadderStep
+	// Counter period over which offenses accumulate (eg, countstore.PeriodDay). Defaults to countstore.PeriodTotal.
+	Period string
+}
+
+// counter namespace used to track offenses for the named ladder
+func ladderCounterName(ladder string) string {
+	return "offense-" + ladder
+}
+
+// Resolves any reported offenses against the configured ladders, converting them to regular account-level effects, and enqueues the offense counter increments.
+//
+// Must run before counters are persisted, so that the offense history read here doesn't include this event.
+func (eng *Engine) applyLadders(c *AccountContext) {
+	if len(c.effects.Offenses) == 0 {
+		return
+	}
+	did := c.Account.Identity.DID.String()
+	for _, off := range c.effects.Offenses {
+		ladder, ok := eng.Config.Ladders[off.Ladder]
+		if !ok || len(ladder.Steps) == 0 {
+			c.Logger.Warn("offense reported against unknown escalation ladder", "ladder", off.Ladder)
+			continue
+		}
+		period := ladder.Period
+		if period == "" {
+			period = countstore.PeriodTotal
+		}
+		name := ladderCounterName(off.Ladder)
+		offenseCount.WithLabelValues(off.Ladder, off.Severity.String()).Inc()
+
+		prev := c.GetCount(name, did, period)
+		weight := off.Severity.weight()
+		level := prev + weight
+		if off.Severity == SeverityCritical {
+			weight = max(len(ladder.Steps)-prev, 1)
+			level = len(ladder.Steps)
+		}
+		for range weight {
+			c.effects.IncrementPeriod(name, did, period)
+		}
+		if level <= 0 {
+			continue
+		}
+
+		idx := min(level, len(ladder.Steps)) - 1
+		c.Logger.Info("escalation ladder step", "ladder", off.Ladder, "severity", off.Severity.String(), "previous", prev, "step", idx+1)
+		ladderStepCount.WithLabelValues(off.Ladder, fmt.Sprint(idx+1)).Inc()
+		step := ladder.Steps[idx]
+		if step.Flag != "" {
+			c.effects.AddAccountFlag(step.Flag)
+		}
+		if step.Label != "" {
+			c.effects.AddAccountLabel(step.Label)
+		}
+		if step.Tag != "" {
+			c.effects.AddAccountTag(step.Tag)
+		}
+		if step.Report != "" {
+			comment := off.Comment
+			if comment == "" {
+				comment = fmt.Sprintf("escalation ladder %s, step %d", off.Ladder, idx+1)
+			}
+			c.effects.ReportAccount(step.Report, comment)
+		}
+		if step.Escalate {
+			c.effects.EscalateAccount()
+		}
+		if step.Takedown {
+			c.effects.TakedownAccount()
+		}
+		if step.Mute > 0 {
+			c.effects.MuteAccount(fmt.Sprintf("escalation ladder %s, step %d", off.Ladder, idx+1), step.Mute)
+		}
+	}
+}
+
+// Parses escalation ladders from strings of the form "<name>=<step>,<step>,...". Each step is one or more "+"-separated actions: "flag:<val>", "label:<val>", "tag:<val>", "report:<reason>", "mute:<duration>", "escalate", or "takedown". Report reasons may be a full reason type or a short name like "spam". Eg:
+//
+//	spam=flag:spam-suspect,label:spam,report:spam,takedown
+//
+// An optional "@<period>" suffix on the name sets the counter period, eg "spam@day=...".
+func ParseLadders(specs []string) (map[string]EscalationLadder, error) {
+	out := make(map[string]EscalationLadder)
+	for _, spec := range specs {
+		name, rawSteps, ok := strings.Cut(spec, "=")
+		if !ok || name == "" || rawSteps == "" {
+			return nil, fmt.Errorf("invalid escalation ladder (expected <name>=<step>,<step>,...): %s", spec)
+		}
+		ladder := EscalationLadder{}
+		if n, period, ok := strings.Cut(name, "@"); ok {
+			switch period {
+			case countstore.PeriodTotal, countstore.PeriodDay, countstore.PeriodHour:
+			default:
+				return nil, fmt.Errorf("invalid escalation ladder period (%s): %s", period, spec)
+			}
+			name = n
+			ladder.Period = period
+		}
+		for _, rawStep := range strings.Split(rawSteps, ",") {
+			step := LadderStep{}
+			for _, action := range strings.Split(rawStep, "+") {
+				kind, val, _ := strings.Cut(action, ":")
+				switch kind {
+				case "flag":
+					step.Flag = val
+				case "label":
+					step.Label = val
+				case "tag":
+					step.Tag = val
+				case "report":
+					step.Report = expandReportReason(val)
+				case "escalate":
+					step.Escalate = true
+				case "takedown":
+					step.Takedown = true
+				case "mute":
+					if val == "" {
+						break
+					}
+					d, err := time.ParseDuration(val)
+					if err != nil || d <= 0 {
+						return nil, fmt.Errorf("invalid escalation ladder mute duration (%s): %s", action, spec)
+					}
+					step.Mute = d
+				default:
+					return nil, fmt.Errorf("invalid escalation ladder action (%s): %s", action, spec)
+				}
+				if val == "" && kind != "escalate" && kind != "takedown" {
+					return nil, fmt.Errorf("escalation ladder action missing value (%s): %s", action, spec)
+				}
+			}
+			ladder.Steps = append(ladder.Steps, step)
+		}
+		out[name] = ladder
+	}
+	return out, nil
+}
+
+func expandReportReason(val string) string {
+	if strings.Contains(val, "#") {
+		return val
+	}
+	for _, reason := range []string{ReportReasonSpam, ReportReasonViolation, ReportReasonMisleading, ReportReasonSexual, ReportReasonRude, ReportReasonOther} {
+		if ReasonShortName(reason) == val {
+			return reason
+		}
+	}
+	return val
+}
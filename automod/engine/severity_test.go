@@ -0,0 +1,84 @@
+package engine
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/automod/countstore"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEscalationLadder(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	ladders, err := ParseLadders([]string{"spam=flag:spam-suspect,label:spam,report:spam,takedown"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eng := EngineTestFixture()
+	eng.Config.Ladders = ladders
+	am := AccountMeta{Identity: &identity.Identity{DID: syntax.DID("did:plc:abc111")}}
+
+	// runs a single event, with one rule reporting an offense, and returns the resulting effects
+	offend := func(sev Severity) *Effects {
+		c := NewAccountContext(ctx, &eng, am)
+		c.ReportOffense("spam", sev, "")
+		eng.applyLadders(&c)
+		assert.NoError(eng.persistCounters(ctx, c.effects))
+		return c.effects
+	}
+
+	eff := offend(SeverityInfo)
+	assert.Empty(eff.AccountFlags)
+
+	eff = offend(SeverityLow)
+	assert.Equal([]string{"spam-suspect"}, eff.AccountFlags)
+	assert.Empty(eff.AccountLabels)
+
+	eff = offend(SeverityLow)
+	assert.Equal([]string{"spam"}, eff.AccountLabels)
+
+	// high severity skips over a step
+	eff = offend(SeverityHigh)
+	assert.True(eff.AccountTakedown)
+	assert.Empty(eff.AccountReports)
+
+	// further offenses stay at the top step
+	eff = offend(SeverityLow)
+	assert.True(eff.AccountTakedown)
+
+	// critical goes straight to the top, for a fresh account
+	am2 := AccountMeta{Identity: &identity.Identity{DID: syntax.DID("did:plc:abc222")}}
+	c := NewAccountContext(ctx, &eng, am2)
+	c.ReportOffense("spam", SeverityLow, "")
+	c.ReportOffense("spam", SeverityCritical, "very bad")
+	c.ReportOffense("unknown-ladder", SeverityCritical, "")
+	eng.applyLadders(&c)
+	assert.True(c.effects.AccountTakedown)
+	assert.NoError(eng.persistCounters(ctx, c.effects))
+	assert.Equal(4, c.GetCount(ladderCounterName("spam"), "did:plc:abc222", countstore.PeriodTotal))
+}
+
+func TestParseLadders(t *testing.T) {
+	assert := assert.New(t)
+
+	ladders, err := ParseLadders([]string{"spam@day=flag:a+tag:b,report:com.example#reason+escalate"})
+	assert.NoError(err)
+	l := ladders["spam"]
+	assert.Equal("day", l.Period)
+	assert.Equal([]LadderStep{{Flag: "a", Tag: "b"}, {Report: "com.example#reason", Escalate: true}}, l.Steps)
+
+	ladders, err = ParseLadders([]string{"spam=mute:24h,takedown"})
+	assert.NoError(err)
+	assert.Equal([]LadderStep{{Mute: 24 * time.Hour}, {Takedown: true}}, ladders["spam"].Steps)
+
+	for _, bad := range []string{"spam", "spam=", "spam=bogus", "spam=flag", "spam@week=flag:a", "spam=mute", "spam=mute:soon", "spam=mute:-1h"} {
+		_, err := ParseLadders([]string{bad})
+		assert.Error(err, bad)
+	}
+}
@@ -0,0 +1,106 @@
+package engine
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+)
+
+// Signup signals are extra private account metadata useful for catching signup abuse: which email provider an account used, and where it sits in the invite tree. They are only available with admin access to the account's PDS (or Ozone), and are only populated if EngineConfig.SignupSignalsKey is set.
+//
+// Email domains are never stored in the clear. Instead they are hashed with a secret key (HMAC-SHA256), so that rules and counters can group accounts by domain without the account metadata cache holding a list of email providers which could be reversed with a dictionary of common domains.
+
+// how far up the invite tree to walk, if not configured
+const DefaultInviteTreeMaxDepth = 10
+
+// the "createdBy" value for invite codes which were issued by an administrator, rather than by another account
+const adminInviteCreator = "admin"
+
+// Returns a keyed hash of the domain part of an email address, or empty string if the address has no domain. Domains are compared case-insensitively.
+func HashEmailDomain(key []byte, email string) string {
+	i := strings.LastIndex(email, "@")
+	if i < 0 {
+		return ""
+	}
+	domain := strings.ToLower(strings.TrimSpace(email[i+1:]))
+	if domain == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(domain))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+func (e *Engine) signupSignalsEnabled() bool {
+	return len(e.Config.SignupSignalsKey) > 0
+}
+
+// fills in signup signal fields of private account metadata. invitedBy is the invite code the account signed up with, if any
+func (e *Engine) hydrateSignupSignals(ctx context.Context, did string, ap *AccountPrivate, invitedBy *comatproto.ServerDefs_InviteCode) {
+	if !e.signupSignalsEnabled() {
+		return
+	}
+	if ap.Email != "" {
+		ap.EmailDomainHash = HashEmailDomain(e.Config.SignupSignalsKey, ap.Email)
+	}
+
+	inviter := ""
+	if invitedBy != nil && invitedBy.CreatedBy != adminInviteCreator {
+		inviter = invitedBy.CreatedBy
+	}
+	ap.InvitedBy = inviter
+	// accounts which signed up with an admin code, or none at all, are roots of the tree
+	depth := 0
+	if inviter != "" {
+		depth = e.inviteDepth(ctx, did, inviter)
+		if e.Flags != nil {
+			flags, err := e.Flags.Get(ctx, inviter)
+			if err != nil {
+				e.Logger.Warn("failed to fetch inviter flags", "did", did, "inviter", inviter, "err", err)
+			} else {
+				ap.InviterFlags = flags
+			}
+		}
+	}
+	if depth >= 0 {
+		ap.InviteDepth = &depth
+	}
+}
+
+// walks up the invite tree from an account's inviter, returning the number of invites between the account and a root of the tree. Returns -1 if the depth couldn't be determined. The walk is capped at the configured max depth (which is returned if reached).
+func (e *Engine) inviteDepth(ctx context.Context, did, inviter string) int {
+	maxDepth := e.Config.InviteTreeMaxDepth
+	if maxDepth <= 0 {
+		maxDepth = DefaultInviteTreeMaxDepth
+	}
+	if e.AdminClient == nil {
+		return -1
+	}
+
+	seen := map[string]bool{did: true}
+	depth := 1
+	for depth < maxDepth {
+		if seen[inviter] {
+			// a cycle; shouldn't happen, but don't loop forever
+			e.Logger.Warn("cycle in invite tree", "did", did, "inviter", inviter)
+			return depth
+		}
+		seen[inviter] = true
+
+		info, err := comatproto.AdminGetAccountInfo(ctx, e.AdminClient, inviter)
+		if err != nil {
+			e.Logger.Warn("failed to fetch inviter account info", "did", did, "inviter", inviter, "err", err)
+			return -1
+		}
+		if info.InvitedBy == nil || info.InvitedBy.CreatedBy == "" || info.InvitedBy.CreatedBy == adminInviteCreator {
+			return depth
+		}
+		inviter = info.InvitedBy.CreatedBy
+		depth++
+	}
+	return depth
+}
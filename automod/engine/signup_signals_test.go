@@ -0,0 +1,83 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashEmailDomain(t *testing.T) {
+	assert := assert.New(t)
+	key := []byte("secret")
+
+	h := HashEmailDomain(key, "alice@Example.com")
+	assert.NotEmpty(h)
+	assert.Equal(h, HashEmailDomain(key, "bob@example.COM"))
+	assert.NotEqual(h, HashEmailDomain(key, "alice@example.org"))
+	assert.NotEqual(h, HashEmailDomain([]byte("other"), "alice@example.com"))
+	assert.NotContains(h, "example")
+	assert.Empty(HashEmailDomain(key, "no-domain"))
+	assert.Empty(HashEmailDomain(key, "trailing@"))
+}
+
+func TestSignupSignals(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	// did:plc:root <- did:plc:mid <- did:plc:leaf
+	invitedBy := map[string]string{
+		"did:plc:mid":  "did:plc:root",
+		"did:plc:root": adminInviteCreator,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		did := r.URL.Query().Get("did")
+		out := comatproto.AdminDefs_AccountView{Did: did}
+		if by, ok := invitedBy[did]; ok {
+			out.InvitedBy = &comatproto.ServerDefs_InviteCode{CreatedBy: by}
+		}
+		json.NewEncoder(w).Encode(out)
+	}))
+	defer srv.Close()
+
+	eng := EngineTestFixture()
+	eng.AdminClient = &xrpc.Client{Host: srv.URL}
+	assert.NoError(eng.Flags.Add(ctx, "did:plc:mid", []string{"suspicious"}))
+
+	// disabled by default
+	ap := AccountPrivate{Email: "leaf@example.com"}
+	eng.hydrateSignupSignals(ctx, "did:plc:leaf", &ap, &comatproto.ServerDefs_InviteCode{CreatedBy: "did:plc:mid"})
+	assert.Empty(ap.EmailDomainHash)
+	assert.Nil(ap.InviteDepth)
+
+	eng.Config.SignupSignalsKey = []byte("secret")
+	eng.hydrateSignupSignals(ctx, "did:plc:leaf", &ap, &comatproto.ServerDefs_InviteCode{CreatedBy: "did:plc:mid"})
+	assert.Equal(HashEmailDomain([]byte("secret"), "x@example.com"), ap.EmailDomainHash)
+	assert.Equal("did:plc:mid", ap.InvitedBy)
+	assert.Equal([]string{"suspicious"}, ap.InviterFlags)
+	if assert.NotNil(ap.InviteDepth) {
+		assert.Equal(2, *ap.InviteDepth)
+	}
+
+	// admin-issued codes are roots of the tree
+	ap = AccountPrivate{}
+	eng.hydrateSignupSignals(ctx, "did:plc:root", &ap, &comatproto.ServerDefs_InviteCode{CreatedBy: adminInviteCreator})
+	assert.Empty(ap.InvitedBy)
+	if assert.NotNil(ap.InviteDepth) {
+		assert.Equal(0, *ap.InviteDepth)
+	}
+
+	// walk is capped
+	eng.Config.InviteTreeMaxDepth = 1
+	ap = AccountPrivate{}
+	eng.hydrateSignupSignals(ctx, "did:plc:leaf", &ap, &comatproto.ServerDefs_InviteCode{CreatedBy: "did:plc:mid"})
+	if assert.NotNil(ap.InviteDepth) {
+		assert.Equal(1, *ap.InviteDepth)
+	}
+}
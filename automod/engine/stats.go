@@ -0,0 +1,342 @@
+package engine
+
+import (
+	"context"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/automod/countstore"
+)
+
+const (
+	// number of recent actions retained for dashboards
+	statsRecentActions = 200
+	// upper bound on the number of distinct subjects tracked for "top flagged" stats
+	statsMaxFlaggedSubjects = 10_000
+)
+
+// RuleStats collects in-process statistics about rule execution and moderation actions, so that operators can build dashboards without scraping logs.
+//
+// Statistics are not persisted, and reset when the process restarts. Blob rules run concurrently and are not included in per-rule stats.
+type RuleStats struct {
+	lk      sync.Mutex
+	started time.Time
+	rules   map[string]*ruleCounts
+	flagged map[string]int64
+	recent  []ActionRecord
+	// index of the next slot to write in 'recent', once it is full
+	recentIdx int
+}
+
+type ruleCounts struct {
+	runs       int64
+	fires      int64
+	overBudget int64
+}
+
+// ActionRecord is a single moderation action taken by the engine
+type ActionRecord struct {
+	Time time.Time `json:"time"`
+	// eg, "flag", "label", "tag", "report", "takedown", "escalate", "acknowledge"
+	Action string `json:"action"`
+	// "account" or "record"
+	SubjectType string `json:"subjectType"`
+	// DID or AT-URI
+	Subject string   `json:"subject"`
+	Values  []string `json:"values,omitempty"`
+}
+
+type RuleFireStats struct {
+	Rule  string `json:"rule"`
+	Runs  int64  `json:"runs"`
+	Fires int64  `json:"fires"`
+	// fraction of runs which resulted in a moderation action effect
+	FireRate float64 `json:"fireRate"`
+	// fires per hour, averaged since stats collection started
+	FiresPerHour float64 `json:"firesPerHour"`
+	// number of runs which exceeded the rule's resource budget
+	OverBudget int64 `json:"overBudget"`
+}
+
+type FlaggedSubject struct {
+	DID   string `json:"did"`
+	Flags int64  `json:"flags"`
+}
+
+type QuotaStats struct {
+	Name  string `json:"name"`
+	Used  int    `json:"used"`
+	Limit int    `json:"limit"`
+}
+
+// DashboardSnapshot is a JSON-serializable summary of engine activity
+type DashboardSnapshot struct {
+	Since         time.Time        `json:"since"`
+	Rules         []RuleFireStats  `json:"rules"`
+	TopFlagged    []FlaggedSubject `json:"topFlagged"`
+	Quotas        []QuotaStats     `json:"quotas"`
+	RecentActions []ActionRecord   `json:"recentActions"`
+}
+
+func NewRuleStats() *RuleStats {
+	return &RuleStats{
+		started: time.Now(),
+		rules:   make(map[string]*ruleCounts),
+		flagged: make(map[string]int64),
+	}
+}
+
+var ruleNameCache sync.Map
+
+// returns a short human-readable name for a rule function, like "rules.GtubePostRule"
+func ruleName(f any) string {
+	ptr := reflect.ValueOf(f).Pointer()
+	if name, ok := ruleNameCache.Load(ptr); ok {
+		return name.(string)
+	}
+	name := "unknown"
+	if fn := runtime.FuncForPC(ptr); fn != nil {
+		name = fn.Name()
+		if i := strings.LastIndex(name, "/"); i >= 0 {
+			name = name[i+1:]
+		}
+		// method values get a suffix
+		name = strings.TrimSuffix(name, "-fm")
+	}
+	ruleNameCache.Store(ptr, name)
+	return name
+}
+
+func (s *RuleStats) recordRun(rule string, fired bool) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	rc, ok := s.rules[rule]
+	if !ok {
+		rc = &ruleCounts{}
+		s.rules[rule] = rc
+	}
+	rc.runs++
+	if fired {
+		rc.fires++
+	}
+}
+
+func (s *RuleStats) recordOverBudget(rule string) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	// the run itself has already been recorded
+	if rc, ok := s.rules[rule]; ok {
+		rc.overBudget++
+	}
+}
+
+func (s *RuleStats) recordAction(rec ActionRecord) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	if rec.Action == "flag" {
+		did := rec.Subject
+		if rec.SubjectType == "record" {
+			// AT-URI; attribute to the account
+			did = strings.SplitN(strings.TrimPrefix(did, "at://"), "/", 2)[0]
+		}
+		if _, ok := s.flagged[did]; !ok && len(s.flagged) >= statsMaxFlaggedSubjects {
+			s.pruneFlaggedLocked()
+		}
+		s.flagged[did] += int64(len(rec.Values))
+	}
+
+	if len(s.recent) < statsRecentActions {
+		s.recent = append(s.recent, rec)
+		return
+	}
+	s.recent[s.recentIdx] = rec
+	s.recentIdx = (s.recentIdx + 1) % statsRecentActions
+}
+
+// drops subjects with the lowest flag counts, to keep memory bounded
+func (s *RuleStats) pruneFlaggedLocked() {
+	subjects := s.topFlaggedLocked(statsMaxFlaggedSubjects / 2)
+	s.flagged = make(map[string]int64, len(subjects))
+	for _, fs := range subjects {
+		s.flagged[fs.DID] = fs.Flags
+	}
+}
+
+func (s *RuleStats) topFlaggedLocked(limit int) []FlaggedSubject {
+	out := make([]FlaggedSubject, 0, len(s.flagged))
+	for did, n := range s.flagged {
+		out = append(out, FlaggedSubject{DID: did, Flags: n})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Flags != out[j].Flags {
+			return out[i].Flags > out[j].Flags
+		}
+		return out[i].DID < out[j].DID
+	})
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+// Rules returns execution stats for every rule which has run, sorted by number of fires
+func (s *RuleStats) Rules() []RuleFireStats {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	hours := time.Since(s.started).Hours()
+	out := make([]RuleFireStats, 0, len(s.rules))
+	for name, rc := range s.rules {
+		rfs := RuleFireStats{
+			Rule:       name,
+			Runs:       rc.runs,
+			Fires:      rc.fires,
+			OverBudget: rc.overBudget,
+		}
+		if rc.runs > 0 {
+			rfs.FireRate = float64(rc.fires) / float64(rc.runs)
+		}
+		if hours > 0 {
+			rfs.FiresPerHour = float64(rc.fires) / hours
+		}
+		out = append(out, rfs)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Fires != out[j].Fires {
+			return out[i].Fires > out[j].Fires
+		}
+		return out[i].Rule < out[j].Rule
+	})
+	return out
+}
+
+// TopFlagged returns the accounts which have had the most new flags applied (account or record level)
+func (s *RuleStats) TopFlagged(limit int) []FlaggedSubject {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	return s.topFlaggedLocked(limit)
+}
+
+// RecentActions returns up to 'limit' of the most recent actions, newest first
+func (s *RuleStats) RecentActions(limit int) []ActionRecord {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	n := len(s.recent)
+	if limit > n {
+		limit = n
+	}
+	out := make([]ActionRecord, 0, limit)
+	for i := 0; i < limit; i++ {
+		// walk backwards from the most recently written slot. until the buffer fills up, recentIdx is zero and this is just the end of the slice
+		out = append(out, s.recent[(s.recentIdx-1-i+2*n)%n])
+	}
+	return out
+}
+
+// counts the number of moderation actions (not counters or graph edges) currently enqueued
+func (e *Effects) actionCount() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	n := len(e.AccountLabels) + len(e.AccountTags) + len(e.AccountFlags) + len(e.AccountReports)
+	n += len(e.RecordLabels) + len(e.RecordTags) + len(e.RecordFlags) + len(e.RecordReports)
+	if e.AccountMute != nil {
+		n++
+	}
+	for _, b := range []bool{e.AccountTakedown, e.AccountEscalate, e.AccountAcknowledge, e.RecordTakedown, e.RejectEvent} {
+		if b {
+			n++
+		}
+	}
+	return n
+}
+
+// records that a rule ran, and whether it added any actions since 'before' (the result of 'actionCount' prior to running the rule)
+func (c *BaseContext) recordRuleRun(f any, before int) {
+	if c.engine == nil || c.engine.Stats == nil {
+		return
+	}
+	c.engine.Stats.recordRun(ruleName(f), c.effects.actionCount() > before)
+}
+
+func (c *BaseContext) actionCount() int {
+	if c.engine == nil || c.engine.Stats == nil {
+		return 0
+	}
+	return c.effects.actionCount()
+}
+
+func (eng *Engine) recordAction(action, subjectType, subject string, vals []string) {
+	if eng.Stats == nil {
+		return
+	}
+	eng.Stats.recordAction(ActionRecord{
+		Time:        time.Now(),
+		Action:      action,
+		SubjectType: subjectType,
+		Subject:     subject,
+		Values:      vals,
+	})
+}
+
+func (eng *Engine) quotaModReportDay() int {
+	if eng.Config.QuotaModReportDay == 0 {
+		return 10000
+	}
+	return eng.Config.QuotaModReportDay
+}
+
+func (eng *Engine) quotaModTakedownDay() int {
+	if eng.Config.QuotaModTakedownDay == 0 {
+		return 200
+	}
+	return eng.Config.QuotaModTakedownDay
+}
+
+func (eng *Engine) quotaModActionDay() int {
+	if eng.Config.QuotaModActionDay == 0 {
+		return 2000
+	}
+	return eng.Config.QuotaModActionDay
+}
+
+// QuotaUsage returns current consumption of the daily action quotas (circuit breakers)
+func (eng *Engine) QuotaUsage(ctx context.Context) ([]QuotaStats, error) {
+	quotas := []QuotaStats{
+		{Name: "report", Limit: eng.quotaModReportDay()},
+		{Name: "takedown", Limit: eng.quotaModTakedownDay()},
+		{Name: "mod-action", Limit: eng.quotaModActionDay()},
+	}
+	for i, q := range quotas {
+		used, err := eng.Counters.GetCount(ctx, "automod-quota", q.Name, countstore.PeriodDay)
+		if err != nil {
+			return nil, err
+		}
+		quotas[i].Used = used
+	}
+	return quotas, nil
+}
+
+// Dashboard returns a summary of rule performance and recent activity. Requires the 'Stats' field to be configured; otherwise only quota usage is included.
+func (eng *Engine) Dashboard(ctx context.Context, limit int) (*DashboardSnapshot, error) {
+	quotas, err := eng.QuotaUsage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	snap := DashboardSnapshot{
+		Rules:         []RuleFireStats{},
+		TopFlagged:    []FlaggedSubject{},
+		Quotas:        quotas,
+		RecentActions: []ActionRecord{},
+	}
+	if eng.Stats != nil {
+		snap.Since = eng.Stats.started
+		snap.Rules = eng.Stats.Rules()
+		snap.TopFlagged = eng.Stats.TopFlagged(limit)
+		snap.RecentActions = eng.Stats.RecentActions(limit)
+	}
+	return &snap, nil
+}
@@ -0,0 +1,79 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func flagPostRule(c *RecordContext, post *appbsky.FeedPost) error {
+	if post.Text == "flag me" {
+		c.AddRecordFlag("test-flag")
+	}
+	return nil
+}
+
+func TestRuleStats(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	eng := EngineTestFixture()
+	eng.Rules.PostRules = append(eng.Rules.PostRules, flagPostRule)
+	eng.Stats = NewRuleStats()
+
+	cid1 := syntax.CID("cid123")
+	for i, text := range []string{"hello", "flag me", "flag me"} {
+		p := appbsky.FeedPost{Text: text}
+		buf := new(bytes.Buffer)
+		assert.NoError(p.MarshalCBOR(buf))
+		op := RecordOp{
+			Action:     CreateOp,
+			DID:        syntax.DID("did:plc:abc111"),
+			Collection: syntax.NSID("app.bsky.feed.post"),
+			RecordKey:  syntax.RecordKey(fmt.Sprintf("abc%d", i)),
+			CID:        &cid1,
+			RecordCBOR: buf.Bytes(),
+		}
+		assert.NoError(eng.ProcessRecordOp(ctx, op))
+	}
+
+	snap, err := eng.Dashboard(ctx, 10)
+	assert.NoError(err)
+
+	assert.Equal(2, len(snap.Rules))
+	assert.Equal("engine.flagPostRule", snap.Rules[0].Rule)
+	assert.Equal(int64(3), snap.Rules[0].Runs)
+	assert.Equal(int64(2), snap.Rules[0].Fires)
+	assert.Equal(int64(0), snap.Rules[1].Fires)
+
+	assert.Equal(1, len(snap.TopFlagged))
+	assert.Equal("did:plc:abc111", snap.TopFlagged[0].DID)
+	assert.Equal(int64(2), snap.TopFlagged[0].Flags)
+
+	assert.Equal(2, len(snap.RecentActions))
+	assert.Equal("at://did:plc:abc111/app.bsky.feed.post/abc2", snap.RecentActions[0].Subject)
+	assert.Equal("flag", snap.RecentActions[0].Action)
+
+	assert.Equal(3, len(snap.Quotas))
+	assert.Equal(200, snap.Quotas[1].Limit)
+}
+
+func TestRuleStatsRecentWraparound(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewRuleStats()
+	for i := 0; i < statsRecentActions+5; i++ {
+		s.recordAction(ActionRecord{Action: "label", Subject: fmt.Sprintf("did:plc:%d", i)})
+	}
+	recent := s.RecentActions(3)
+	assert.Equal(3, len(recent))
+	assert.Equal(fmt.Sprintf("did:plc:%d", statsRecentActions+4), recent[0].Subject)
+	assert.Equal(fmt.Sprintf("did:plc:%d", statsRecentActions+2), recent[2].Subject)
+	assert.Equal(statsRecentActions, len(s.RecentActions(1000)))
+}
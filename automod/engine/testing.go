@@ -7,9 +7,12 @@ import (
 	appbsky "github.com/bluesky-social/indigo/api/bsky"
 	"github.com/bluesky-social/indigo/atproto/identity"
 	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/automod/baselinestore"
 	"github.com/bluesky-social/indigo/automod/cachestore"
+	"github.com/bluesky-social/indigo/automod/canarystore"
 	"github.com/bluesky-social/indigo/automod/countstore"
 	"github.com/bluesky-social/indigo/automod/flagstore"
+	"github.com/bluesky-social/indigo/automod/graphstore"
 	"github.com/bluesky-social/indigo/automod/setstore"
 )
 
@@ -64,6 +67,9 @@ func EngineTestFixture() Engine {
 		Counters:  countstore.NewMemCountStore(),
 		Sets:      sets,
 		Flags:     flags,
+		Graph:     graphstore.NewMemGraphStore(graphstore.DefaultWindow),
+		Canaries:  canarystore.NewMemCanaryStore(canarystore.DefaultWindow),
+		Baselines: baselinestore.NewMemBaselineStore(baselinestore.DefaultAlpha),
 		Cache:     cache,
 		Rules:     rules,
 	}
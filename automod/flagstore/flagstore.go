@@ -2,10 +2,66 @@ package flagstore
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"time"
 )
 
 type FlagStore interface {
 	Get(ctx context.Context, key string) ([]string, error)
 	Add(ctx context.Context, key string, flags []string) error
 	Remove(ctx context.Context, key string, flags []string) error
+	// Returns when each flag on the key was first added, and most recently (re-)added. Flags added before timestamps were tracked may be missing from the returned map.
+	GetTimes(ctx context.Context, key string) (map[string]FlagTimes, error)
+	// Calls the provided function for every key which has (or recently had) flags set.
+	ScanKeys(ctx context.Context, fn func(key string) error) error
+}
+
+type FlagTimes struct {
+	First time.Time `json:"first"`
+	Last  time.Time `json:"last"`
+}
+
+// Expiration rules for a single flag value. Either or both fields may be set; zero values mean no expiry.
+type FlagPolicy struct {
+	// flag is cleared this long after it was first added, even if re-triggered
+	TTL time.Duration
+	// flag is cleared this long after it was last added, if not re-triggered
+	Decay time.Duration
+}
+
+func (p FlagPolicy) Expired(t FlagTimes, now time.Time) bool {
+	if p.TTL > 0 && !t.First.IsZero() && now.Sub(t.First) > p.TTL {
+		return true
+	}
+	if p.Decay > 0 && !t.Last.IsZero() && now.Sub(t.Last) > p.Decay {
+		return true
+	}
+	return false
+}
+
+// Parses flag policies from strings of the form "<flag>:<ttl|decay>:<duration>", eg "spam-suspect:decay:720h". Multiple entries for the same flag are merged.
+func ParseFlagPolicies(specs []string) (map[string]FlagPolicy, error) {
+	out := make(map[string]FlagPolicy)
+	for _, spec := range specs {
+		parts := strings.Split(spec, ":")
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid flag policy (expected <flag>:<ttl|decay>:<duration>): %s", spec)
+		}
+		dur, err := time.ParseDuration(parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid flag policy duration (%s): %w", spec, err)
+		}
+		p := out[parts[0]]
+		switch parts[1] {
+		case "ttl":
+			p.TTL = dur
+		case "decay":
+			p.Decay = dur
+		default:
+			return nil, fmt.Errorf("invalid flag policy type (expected ttl or decay): %s", spec)
+		}
+		out[parts[0]] = p
+	}
+	return out, nil
 }
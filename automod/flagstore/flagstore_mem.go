@@ -2,15 +2,19 @@ package flagstore
 
 import (
 	"context"
+	"sort"
+	"time"
 )
 
 type MemFlagStore struct {
-	Data map[string][]string
+	Data  map[string][]string
+	Times map[string]map[string]FlagTimes
 }
 
 func NewMemFlagStore() MemFlagStore {
 	return MemFlagStore{
-		Data: make(map[string][]string),
+		Data:  make(map[string][]string),
+		Times: make(map[string]map[string]FlagTimes),
 	}
 }
 
@@ -30,6 +34,21 @@ func (s MemFlagStore) Add(ctx context.Context, key string, flags []string) error
 	v = append(v, flags...)
 	v = dedupeStrings(v)
 	s.Data[key] = v
+
+	now := time.Now()
+	times, ok := s.Times[key]
+	if !ok {
+		times = make(map[string]FlagTimes)
+		s.Times[key] = times
+	}
+	for _, f := range flags {
+		ft, ok := times[f]
+		if !ok {
+			ft.First = now
+		}
+		ft.Last = now
+		times[f] = ft
+	}
 	return nil
 }
 
@@ -48,6 +67,7 @@ func (s MemFlagStore) Remove(ctx context.Context, key string, flags []string) er
 	}
 	for _, f := range flags {
 		delete(m, f)
+		delete(s.Times[key], f)
 	}
 	out := []string{}
 	for f, _ := range m {
@@ -56,3 +76,86 @@ func (s MemFlagStore) Remove(ctx context.Context, key string, flags []string) er
 	s.Data[key] = out
 	return nil
 }
+
+func (s MemFlagStore) GetTimes(ctx context.Context, key string) (map[string]FlagTimes, error) {
+	out := make(map[string]FlagTimes)
+	for f, ft := range s.Times[key] {
+		out[f] = ft
+	}
+	return out, nil
+}
+
+func (s MemFlagStore) ScanKeys(ctx context.Context, fn func(key string) error) error {
+	keys := make([]string, 0, len(s.Data))
+	for k, v := range s.Data {
+		if len(v) > 0 {
+			keys = append(keys, k)
+		}
+	}
+	for _, k := range keys {
+		if err := fn(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Export snapshots the store, in key order. Markers are the last key exported.
+func (s MemFlagStore) Export(ctx context.Context, marker string, fn func(*SnapshotEntry) error) error {
+	if marker == snapshotMarkerDone {
+		return nil
+	}
+	keys := make([]string, 0, len(s.Data))
+	for k, v := range s.Data {
+		if len(v) > 0 {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	i := 0
+	if marker != "" {
+		i = sort.SearchStrings(keys, marker)
+		if i < len(keys) && keys[i] == marker {
+			i++
+		}
+	}
+
+	for n := 1; i < len(keys); i, n = i+1, n+1 {
+		k := keys[i]
+		e := &SnapshotEntry{Key: k, Flags: append([]string{}, s.Data[k]...)}
+		sort.Strings(e.Flags)
+		if len(s.Times[k]) > 0 {
+			e.Times = make(map[string]FlagTimes, len(s.Times[k]))
+			for f, ft := range s.Times[k] {
+				e.Times[f] = ft
+			}
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+		if n%snapshotBatchSize == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := fn(&SnapshotEntry{Marker: k}); err != nil {
+				return err
+			}
+		}
+	}
+	return fn(&SnapshotEntry{Marker: snapshotMarkerDone})
+}
+
+func (s MemFlagStore) Import(ctx context.Context, e *SnapshotEntry) error {
+	if len(e.Flags) == 0 {
+		delete(s.Data, e.Key)
+		delete(s.Times, e.Key)
+		return nil
+	}
+	s.Data[e.Key] = dedupeStrings(e.Flags)
+	times := make(map[string]FlagTimes, len(e.Times))
+	for f, ft := range e.Times {
+		times[f] = ft
+	}
+	s.Times[e.Key] = times
+	return nil
+}
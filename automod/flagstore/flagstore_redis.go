@@ -2,12 +2,21 @@ package flagstore
 
 import (
 	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
 var redisFlagsPrefix string = "flags/"
 
+// hashes of flag value to unix timestamp (seconds)
+var redisFlagsFirstPrefix string = "flags-first/"
+var redisFlagsLastPrefix string = "flags-last/"
+
 type RedisFlagStore struct {
 	Client *redis.Client
 }
@@ -50,7 +59,16 @@ func (s *RedisFlagStore) Add(ctx context.Context, key string, flags []string) er
 		l = append(l, v)
 	}
 	rkey := redisFlagsPrefix + key
-	return s.Client.SAdd(ctx, rkey, l...).Err()
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	_, err := s.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SAdd(ctx, rkey, l...)
+		for _, f := range flags {
+			pipe.HSetNX(ctx, redisFlagsFirstPrefix+key, f, now)
+			pipe.HSet(ctx, redisFlagsLastPrefix+key, f, now)
+		}
+		return nil
+	})
+	return err
 }
 
 func (s *RedisFlagStore) Remove(ctx context.Context, key string, flags []string) error {
@@ -62,5 +80,129 @@ func (s *RedisFlagStore) Remove(ctx context.Context, key string, flags []string)
 		l = append(l, v)
 	}
 	rkey := redisFlagsPrefix + key
-	return s.Client.SRem(ctx, rkey, l...).Err()
+	_, err := s.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SRem(ctx, rkey, l...)
+		pipe.HDel(ctx, redisFlagsFirstPrefix+key, flags...)
+		pipe.HDel(ctx, redisFlagsLastPrefix+key, flags...)
+		return nil
+	})
+	return err
+}
+
+func (s *RedisFlagStore) GetTimes(ctx context.Context, key string) (map[string]FlagTimes, error) {
+	first, err := s.Client.HGetAll(ctx, redisFlagsFirstPrefix+key).Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	last, err := s.Client.HGetAll(ctx, redisFlagsLastPrefix+key).Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	out := make(map[string]FlagTimes, len(last))
+	for f, v := range first {
+		ft := out[f]
+		ft.First = parseUnixSeconds(v)
+		out[f] = ft
+	}
+	for f, v := range last {
+		ft := out[f]
+		ft.Last = parseUnixSeconds(v)
+		out[f] = ft
+	}
+	return out, nil
+}
+
+func (s *RedisFlagStore) ScanKeys(ctx context.Context, fn func(key string) error) error {
+	iter := s.Client.Scan(ctx, 0, redisFlagsPrefix+"*", 1000).Iterator()
+	for iter.Next(ctx) {
+		if err := fn(strings.TrimPrefix(iter.Val(), redisFlagsPrefix)); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+func parseUnixSeconds(v string) time.Time {
+	i, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(i, 0)
+}
+
+// Export snapshots the store. Markers are SCAN cursors, so keys modified
+// during the export may be skipped or repeated.
+func (s *RedisFlagStore) Export(ctx context.Context, marker string, fn func(*SnapshotEntry) error) error {
+	if marker == snapshotMarkerDone {
+		return nil
+	}
+	var cursor uint64
+	if marker != "" {
+		c, err := strconv.ParseUint(marker, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid snapshot marker: %q", marker)
+		}
+		cursor = c
+	}
+
+	for {
+		keys, next, err := s.Client.Scan(ctx, cursor, redisFlagsPrefix+"*", snapshotBatchSize).Result()
+		if err != nil {
+			return err
+		}
+		for _, rkey := range keys {
+			key := strings.TrimPrefix(rkey, redisFlagsPrefix)
+			flags, err := s.Get(ctx, key)
+			if err != nil {
+				return err
+			}
+			if len(flags) == 0 {
+				continue
+			}
+			sort.Strings(flags)
+			times, err := s.GetTimes(ctx, key)
+			if err != nil {
+				return err
+			}
+			e := &SnapshotEntry{Key: key, Flags: flags}
+			if len(times) > 0 {
+				e.Times = times
+			}
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return fn(&SnapshotEntry{Marker: snapshotMarkerDone})
+		}
+		if err := fn(&SnapshotEntry{Marker: strconv.FormatUint(next, 10)}); err != nil {
+			return err
+		}
+		cursor = next
+	}
+}
+
+func (s *RedisFlagStore) Import(ctx context.Context, e *SnapshotEntry) error {
+	rkey := redisFlagsPrefix + e.Key
+	_, err := s.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, rkey, redisFlagsFirstPrefix+e.Key, redisFlagsLastPrefix+e.Key)
+		if len(e.Flags) == 0 {
+			return nil
+		}
+		l := make([]interface{}, 0, len(e.Flags))
+		for _, f := range e.Flags {
+			l = append(l, f)
+		}
+		pipe.SAdd(ctx, rkey, l...)
+		for f, ft := range e.Times {
+			if !ft.First.IsZero() {
+				pipe.HSet(ctx, redisFlagsFirstPrefix+e.Key, f, strconv.FormatInt(ft.First.Unix(), 10))
+			}
+			if !ft.Last.IsZero() {
+				pipe.HSet(ctx, redisFlagsLastPrefix+e.Key, f, strconv.FormatInt(ft.Last.Unix(), 10))
+			}
+		}
+		return nil
+	})
+	return err
 }
@@ -1,8 +1,11 @@
 package flagstore
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -28,3 +31,100 @@ func TestFlagStoreBasics(t *testing.T) {
 	assert.NoError(err)
 	assert.Equal([]string{"green"}, l)
 }
+
+func TestFlagStoreTimes(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	fs := NewMemFlagStore()
+
+	assert.NoError(fs.Add(ctx, "test1", []string{"red"}))
+	times, err := fs.GetTimes(ctx, "test1")
+	assert.NoError(err)
+	first := times["red"].First
+	assert.False(first.IsZero())
+
+	assert.NoError(fs.Add(ctx, "test1", []string{"red"}))
+	times, err = fs.GetTimes(ctx, "test1")
+	assert.NoError(err)
+	assert.Equal(first, times["red"].First)
+	assert.False(times["red"].Last.Before(first))
+
+	keys := []string{}
+	assert.NoError(fs.ScanKeys(ctx, func(key string) error {
+		keys = append(keys, key)
+		return nil
+	}))
+	assert.Equal([]string{"test1"}, keys)
+
+	assert.NoError(fs.Remove(ctx, "test1", []string{"red"}))
+	times, err = fs.GetTimes(ctx, "test1")
+	assert.NoError(err)
+	assert.Empty(times)
+}
+
+func TestFlagPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	policies, err := ParseFlagPolicies([]string{"spam-suspect:decay:720h", "new-acct:ttl:24h", "new-acct:decay:1h"})
+	assert.NoError(err)
+	assert.Equal(FlagPolicy{Decay: 720 * time.Hour}, policies["spam-suspect"])
+	assert.Equal(FlagPolicy{TTL: 24 * time.Hour, Decay: time.Hour}, policies["new-acct"])
+
+	_, err = ParseFlagPolicies([]string{"spam-suspect:forever:720h"})
+	assert.Error(err)
+	_, err = ParseFlagPolicies([]string{"spam-suspect:720h"})
+	assert.Error(err)
+
+	now := time.Now()
+	p := policies["new-acct"]
+	assert.False(p.Expired(FlagTimes{First: now.Add(-2 * time.Hour), Last: now}, now))
+	assert.True(p.Expired(FlagTimes{First: now.Add(-2 * time.Hour), Last: now.Add(-2 * time.Hour)}, now))
+	assert.True(p.Expired(FlagTimes{First: now.Add(-48 * time.Hour), Last: now}, now))
+	assert.False(FlagPolicy{}.Expired(FlagTimes{First: now.Add(-48 * time.Hour)}, now))
+}
+
+func TestMemFlagStoreSnapshot(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	fs := NewMemFlagStore()
+	for i := 0; i < 1500; i++ {
+		assert.NoError(fs.Add(ctx, fmt.Sprintf("did:plc:%04d", i), []string{"red", "green"}))
+	}
+	assert.NoError(fs.Remove(ctx, "did:plc:0007", []string{"red", "green"}))
+
+	var buf bytes.Buffer
+	assert.NoError(WriteSnapshot(ctx, fs, &buf, ""))
+
+	marker, _, err := LastSnapshotMarker(bytes.NewReader(buf.Bytes()))
+	assert.NoError(err)
+	assert.Equal(snapshotMarkerDone, marker)
+
+	restored := NewMemFlagStore()
+	// existing flags are replaced
+	assert.NoError(restored.Add(ctx, "did:plc:0001", []string{"blue"}))
+	n, err := ReadSnapshot(ctx, restored, bytes.NewReader(buf.Bytes()))
+	assert.NoError(err)
+	assert.Equal(1499, n)
+
+	l, err := restored.Get(ctx, "did:plc:0001")
+	assert.NoError(err)
+	assert.ElementsMatch([]string{"red", "green"}, l)
+	l, err = restored.Get(ctx, "did:plc:0007")
+	assert.NoError(err)
+	assert.Empty(l)
+
+	orig, err := fs.GetTimes(ctx, "did:plc:1234")
+	assert.NoError(err)
+	times, err := restored.GetTimes(ctx, "did:plc:1234")
+	assert.NoError(err)
+	assert.True(orig["red"].First.Equal(times["red"].First))
+
+	// resuming from the mid-way marker exports only the remaining keys
+	var rest bytes.Buffer
+	assert.NoError(WriteSnapshot(ctx, fs, &rest, "did:plc:1000"))
+	n, err = ReadSnapshot(ctx, NewMemFlagStore(), bytes.NewReader(rest.Bytes()))
+	assert.NoError(err)
+	assert.Equal(499, n)
+}
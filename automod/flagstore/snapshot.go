@@ -0,0 +1,118 @@
+package flagstore
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// SnapshotEntry is one line of a flag snapshot: either the flags on a single
+// key, or a resume marker (with only Marker set).
+type SnapshotEntry struct {
+	Key   string   `json:"key,omitempty"`
+	Flags []string `json:"flags,omitempty"`
+	// When each flag was first and last added, where known
+	Times map[string]FlagTimes `json:"times,omitempty"`
+
+	// Passing this to Export continues the export after the preceding entries
+	Marker string `json:"marker,omitempty"`
+}
+
+// Exporter is implemented by flag stores which can be snapshotted.
+type Exporter interface {
+	// Export calls fn with the flags on every key which has any, starting
+	// after the resume marker (empty to start at the beginning). After each
+	// batch of keys, fn is called with a marker entry.
+	Export(ctx context.Context, marker string, fn func(*SnapshotEntry) error) error
+}
+
+// Importer is implemented by flag stores which can restore snapshots.
+// Importing an entry replaces the flags (and flag times) on the key.
+type Importer interface {
+	Import(ctx context.Context, e *SnapshotEntry) error
+}
+
+// number of keys between resume markers
+const snapshotBatchSize = 1000
+
+// marker for a completed export
+const snapshotMarkerDone = "done"
+
+// WriteSnapshot exports the store as JSON lines, starting after the resume
+// marker (if any).
+func WriteSnapshot(ctx context.Context, s Exporter, w io.Writer, marker string) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	err := s.Export(ctx, marker, func(e *SnapshotEntry) error {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+		if e.Marker != "" {
+			// so that a partial snapshot ends at a marker
+			return bw.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// LastSnapshotMarker returns the last resume marker in a (possibly partial)
+// snapshot, and the offset just past it: an interrupted snapshot can be
+// truncated to that offset, and continued from the marker.
+func LastSnapshotMarker(r io.Reader) (string, int64, error) {
+	br := bufio.NewReader(r)
+	var (
+		marker string
+		end    int64
+		off    int64
+	)
+	for {
+		line, err := br.ReadBytes('\n')
+		off += int64(len(line))
+		if err == io.EOF {
+			// a trailing partial line is ignored
+			return marker, end, nil
+		}
+		if err != nil {
+			return "", 0, err
+		}
+		var e SnapshotEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return "", 0, fmt.Errorf("reading snapshot at offset %d: %w", off-int64(len(line)), err)
+		}
+		if e.Marker != "" {
+			marker = e.Marker
+			end = off
+		}
+	}
+}
+
+// ReadSnapshot imports a snapshot written by WriteSnapshot, returning the
+// number of keys imported.
+func ReadSnapshot(ctx context.Context, s Importer, r io.Reader) (int, error) {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	n := 0
+	for {
+		var e SnapshotEntry
+		if err := dec.Decode(&e); err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, fmt.Errorf("reading snapshot: %w", err)
+		}
+		if e.Marker != "" {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		if err := s.Import(ctx, &e); err != nil {
+			return n, fmt.Errorf("importing flags for %s: %w", e.Key, err)
+		}
+		n++
+	}
+}
@@ -0,0 +1,4 @@
+// Automod component for tracking a windowed graph of interactions between accounts (replies, mentions, reposts, etc).
+//
+// Includes an interface and implementations using redis and in-process memory, along with graph queries (shared targets, co-targeting accounts, local clustering coefficient) used by rules to detect brigading and other coordinated behavior.
+package graphstore
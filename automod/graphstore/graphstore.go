@@ -0,0 +1,31 @@
+package graphstore
+
+import (
+	"context"
+	"time"
+)
+
+// Interaction kinds recorded by the default rules. Arbitrary kinds may be used.
+const (
+	KindReply   = "reply"
+	KindMention = "mention"
+	KindRepost  = "repost"
+	KindQuote   = "quote"
+)
+
+// DefaultWindow is the default period of time for which edges are retained
+const DefaultWindow = 24 * time.Hour
+
+// MaxEdgesPerNode bounds the number of edges retained in each direction for any single account, keeping the most recent. This keeps the graph lightweight when very popular accounts receive a large number of interactions.
+const MaxEdgesPerNode = 1000
+
+// GraphStore is an interface for storing directed interaction edges between accounts ("src" interacted with "dst"), separately for each interaction "kind".
+//
+// Edges are only retained for a fixed time window (configured when the store is created). Adding an edge which already exists refreshes its timestamp. Query methods only return edges within the window, with no particular ordering.
+type GraphStore interface {
+	AddEdge(ctx context.Context, kind, src, dst string) error
+	// returns the accounts that "src" interacted with
+	GetTargets(ctx context.Context, kind, src string) ([]string, error)
+	// returns the accounts that interacted with "dst"
+	GetSources(ctx context.Context, kind, dst string) ([]string, error)
+}
@@ -0,0 +1,91 @@
+package graphstore
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemGraphStore is an in-process GraphStore. Expired edges are pruned lazily, when the relevant node is next touched.
+type MemGraphStore struct {
+	Window time.Duration
+
+	lk  sync.Mutex
+	out map[string]map[string]time.Time
+	in  map[string]map[string]time.Time
+}
+
+func NewMemGraphStore(window time.Duration) *MemGraphStore {
+	return &MemGraphStore{
+		Window: window,
+		out:    make(map[string]map[string]time.Time),
+		in:     make(map[string]map[string]time.Time),
+	}
+}
+
+func nodeKey(kind, node string) string {
+	return kind + "/" + node
+}
+
+// adds or refreshes an edge in one direction, enforcing the window and MaxEdgesPerNode
+func addHalfEdge(m map[string]map[string]time.Time, key, other string, now, cutoff time.Time) {
+	edges, ok := m[key]
+	if !ok {
+		edges = make(map[string]time.Time)
+		m[key] = edges
+	}
+	edges[other] = now
+
+	for k, t := range edges {
+		if t.Before(cutoff) {
+			delete(edges, k)
+		}
+	}
+	if len(edges) <= MaxEdgesPerNode {
+		return
+	}
+
+	// drop the oldest edges
+	keys := make([]string, 0, len(edges))
+	for k := range edges {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return edges[keys[i]].Before(edges[keys[j]]) })
+	for _, k := range keys[:len(keys)-MaxEdgesPerNode] {
+		delete(edges, k)
+	}
+}
+
+func (s *MemGraphStore) AddEdge(ctx context.Context, kind, src, dst string) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	now := time.Now()
+	cutoff := now.Add(-s.Window)
+	addHalfEdge(s.out, nodeKey(kind, src), dst, now, cutoff)
+	addHalfEdge(s.in, nodeKey(kind, dst), src, now, cutoff)
+	return nil
+}
+
+func (s *MemGraphStore) get(m map[string]map[string]time.Time, key string) []string {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	cutoff := time.Now().Add(-s.Window)
+	out := []string{}
+	for k, t := range m[key] {
+		if !t.Before(cutoff) {
+			out = append(out, k)
+		}
+	}
+	return out
+}
+
+func (s *MemGraphStore) GetTargets(ctx context.Context, kind, src string) ([]string, error) {
+	return s.get(s.out, nodeKey(kind, src)), nil
+}
+
+func (s *MemGraphStore) GetSources(ctx context.Context, kind, dst string) ([]string, error) {
+	return s.get(s.in, nodeKey(kind, dst)), nil
+}
@@ -0,0 +1,76 @@
+package graphstore
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var redisGraphOutPrefix string = "graph-out/"
+var redisGraphInPrefix string = "graph-in/"
+
+// RedisGraphStore stores each direction of each node's edges as a redis sorted set, scored by the time of the most recent interaction.
+type RedisGraphStore struct {
+	Client *redis.Client
+	Window time.Duration
+}
+
+func NewRedisGraphStore(redisURL string, window time.Duration) (*RedisGraphStore, error) {
+	ctx := context.Background()
+	opt, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	rdb := redis.NewClient(opt)
+	// check redis connection
+	_, err = rdb.Ping(ctx).Result()
+	if err != nil {
+		return nil, err
+	}
+	rgs := RedisGraphStore{
+		Client: rdb,
+		Window: window,
+	}
+	return &rgs, nil
+}
+
+func (s *RedisGraphStore) AddEdge(ctx context.Context, kind, src, dst string) error {
+	now := time.Now()
+	cutoff := strconv.FormatInt(now.Add(-s.Window).UnixMilli(), 10)
+
+	// update both directions, and trim expired and excess edges, in a single round-trip
+	multi := s.Client.Pipeline()
+	for _, half := range [][2]string{
+		{redisGraphOutPrefix + nodeKey(kind, src), dst},
+		{redisGraphInPrefix + nodeKey(kind, dst), src},
+	} {
+		key, member := half[0], half[1]
+		multi.ZAdd(ctx, key, redis.Z{Score: float64(now.UnixMilli()), Member: member})
+		multi.ZRemRangeByScore(ctx, key, "-inf", "("+cutoff)
+		multi.ZRemRangeByRank(ctx, key, 0, -(MaxEdgesPerNode + 1))
+		multi.Expire(ctx, key, s.Window)
+	}
+	_, err := multi.Exec(ctx)
+	return err
+}
+
+func (s *RedisGraphStore) get(ctx context.Context, key string) ([]string, error) {
+	cutoff := strconv.FormatInt(time.Now().Add(-s.Window).UnixMilli(), 10)
+	out, err := s.Client.ZRangeByScore(ctx, key, &redis.ZRangeBy{Min: cutoff, Max: "+inf"}).Result()
+	if err == redis.Nil {
+		return []string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *RedisGraphStore) GetTargets(ctx context.Context, kind, src string) ([]string, error) {
+	return s.get(ctx, redisGraphOutPrefix+nodeKey(kind, src))
+}
+
+func (s *RedisGraphStore) GetSources(ctx context.Context, kind, dst string) ([]string, error) {
+	return s.get(ctx, redisGraphInPrefix+nodeKey(kind, dst))
+}
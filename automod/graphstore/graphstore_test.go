@@ -0,0 +1,105 @@
+package graphstore
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemGraphStoreBasics(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	gs := NewMemGraphStore(time.Hour)
+
+	out, err := gs.GetTargets(ctx, KindReply, "did:plc:a")
+	assert.NoError(err)
+	assert.Empty(out)
+
+	assert.NoError(gs.AddEdge(ctx, KindReply, "did:plc:a", "did:plc:b"))
+	assert.NoError(gs.AddEdge(ctx, KindReply, "did:plc:a", "did:plc:b"))
+	assert.NoError(gs.AddEdge(ctx, KindReply, "did:plc:a", "did:plc:c"))
+	assert.NoError(gs.AddEdge(ctx, KindMention, "did:plc:a", "did:plc:d"))
+
+	out, err = gs.GetTargets(ctx, KindReply, "did:plc:a")
+	assert.NoError(err)
+	assert.ElementsMatch([]string{"did:plc:b", "did:plc:c"}, out)
+
+	out, err = gs.GetSources(ctx, KindReply, "did:plc:b")
+	assert.NoError(err)
+	assert.Equal([]string{"did:plc:a"}, out)
+
+	out, err = gs.GetSources(ctx, KindReply, "did:plc:d")
+	assert.NoError(err)
+	assert.Empty(out)
+
+	// expired edges are not returned
+	gs.Window = 0
+	out, err = gs.GetTargets(ctx, KindReply, "did:plc:a")
+	assert.NoError(err)
+	assert.Empty(out)
+}
+
+func TestMemGraphStoreMaxEdges(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	gs := NewMemGraphStore(time.Hour)
+	for i := 0; i < MaxEdgesPerNode+10; i++ {
+		assert.NoError(gs.AddEdge(ctx, KindReply, "did:plc:a", fmt.Sprintf("did:plc:%d", i)))
+	}
+	out, err := gs.GetTargets(ctx, KindReply, "did:plc:a")
+	assert.NoError(err)
+	assert.Equal(MaxEdgesPerNode, len(out))
+}
+
+func TestGraphQueries(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	gs := NewMemGraphStore(time.Hour)
+
+	// a brigade of three accounts all reply to the same two victims, and to each other
+	brigade := []string{"did:plc:b1", "did:plc:b2", "did:plc:b3"}
+	for _, src := range brigade {
+		for _, dst := range []string{"did:plc:v1", "did:plc:v2"} {
+			assert.NoError(gs.AddEdge(ctx, KindReply, src, dst))
+		}
+		for _, dst := range brigade {
+			if dst != src {
+				assert.NoError(gs.AddEdge(ctx, KindReply, src, dst))
+			}
+		}
+	}
+	// and an organic account replying to unconnected accounts
+	for _, dst := range []string{"did:plc:x1", "did:plc:x2", "did:plc:v1"} {
+		assert.NoError(gs.AddEdge(ctx, KindReply, "did:plc:organic", dst))
+	}
+
+	n, err := SharedTargetCount(ctx, gs, KindReply, "did:plc:b1", "did:plc:b2")
+	assert.NoError(err)
+	// v1, v2, and b3
+	assert.Equal(3, n)
+
+	n, err = SharedTargetCount(ctx, gs, KindReply, "did:plc:b1", "did:plc:organic")
+	assert.NoError(err)
+	assert.Equal(1, n)
+
+	co, err := CoTargeters(ctx, gs, KindReply, "did:plc:b1")
+	assert.NoError(err)
+	assert.Equal(3, co["did:plc:b2"])
+	assert.Equal(3, co["did:plc:b3"])
+	assert.Equal(1, co["did:plc:organic"])
+
+	cc, err := ClusteringCoefficient(ctx, gs, KindReply, "did:plc:b1")
+	assert.NoError(err)
+	// neighbors are v1, v2, b2, b3: links b2-b3, b2-v1, b2-v2, b3-v1, b3-v2 out of 6 pairs
+	assert.InDelta(5.0/6.0, cc, 0.001)
+
+	cc, err = ClusteringCoefficient(ctx, gs, KindReply, "did:plc:organic")
+	assert.NoError(err)
+	assert.Equal(0.0, cc)
+}
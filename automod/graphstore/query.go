@@ -0,0 +1,121 @@
+package graphstore
+
+import (
+	"context"
+	"sort"
+)
+
+// Number of neighbors considered by the more expensive graph queries. Larger neighborhoods are truncated (deterministically, by sorting).
+const MaxQueryNeighbors = 100
+
+// SharedTargetCount returns the number of accounts that both "a" and "b" interacted with.
+func SharedTargetCount(ctx context.Context, g GraphStore, kind, a, b string) (int, error) {
+	ta, err := g.GetTargets(ctx, kind, a)
+	if err != nil {
+		return 0, err
+	}
+	tb, err := g.GetTargets(ctx, kind, b)
+	if err != nil {
+		return 0, err
+	}
+	seen := make(map[string]bool, len(ta))
+	for _, t := range ta {
+		seen[t] = true
+	}
+	n := 0
+	for _, t := range tb {
+		if seen[t] {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// CoTargeters returns the other accounts that interacted with the same accounts as "src", mapped to the number of targets they have in common with "src".
+//
+// A group of accounts which all share many targets (eg, all replying to the same set of accounts within a short window) is a strong brigading signal.
+func CoTargeters(ctx context.Context, g GraphStore, kind, src string) (map[string]int, error) {
+	targets, err := g.GetTargets(ctx, kind, src)
+	if err != nil {
+		return nil, err
+	}
+	targets = truncateNeighbors(targets)
+
+	out := make(map[string]int)
+	for _, t := range targets {
+		sources, err := g.GetSources(ctx, kind, t)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range sources {
+			if s != src {
+				out[s]++
+			}
+		}
+	}
+	return out, nil
+}
+
+// ClusteringCoefficient computes the local clustering coefficient of "node": the fraction of pairs of its neighbors (treating edges as undirected) which are themselves connected. Returns zero for nodes with fewer than two neighbors.
+//
+// A high coefficient means the account's interactions are concentrated within a tight-knit group, as opposed to a broad, organic audience.
+func ClusteringCoefficient(ctx context.Context, g GraphStore, kind, node string) (float64, error) {
+	targets, err := g.GetTargets(ctx, kind, node)
+	if err != nil {
+		return 0, err
+	}
+	sources, err := g.GetSources(ctx, kind, node)
+	if err != nil {
+		return 0, err
+	}
+
+	nset := make(map[string]bool)
+	for _, n := range append(targets, sources...) {
+		if n != node {
+			nset[n] = true
+		}
+	}
+	neighbors := make([]string, 0, len(nset))
+	for n := range nset {
+		neighbors = append(neighbors, n)
+	}
+	neighbors = truncateNeighbors(neighbors)
+	k := len(neighbors)
+	if k < 2 {
+		return 0, nil
+	}
+	inHood := make(map[string]bool, k)
+	for _, n := range neighbors {
+		inHood[n] = true
+	}
+
+	// count each undirected link between neighbors once. looking at
+	// outbound edges from every neighbor finds all of them.
+	links := make(map[[2]string]bool)
+	for _, n := range neighbors {
+		nt, err := g.GetTargets(ctx, kind, n)
+		if err != nil {
+			return 0, err
+		}
+		for _, m := range nt {
+			if m == n || !inHood[m] {
+				continue
+			}
+			pair := [2]string{n, m}
+			if m < n {
+				pair = [2]string{m, n}
+			}
+			links[pair] = true
+		}
+	}
+
+	return float64(len(links)) / float64(k*(k-1)/2), nil
+}
+
+func truncateNeighbors(nodes []string) []string {
+	if len(nodes) <= MaxQueryNeighbors {
+		return nodes
+	}
+	sort.Strings(nodes)
+	return nodes[:MaxQueryNeighbors]
+}
@@ -0,0 +1,6 @@
+// Automod component for moderation actions which failed to persist.
+//
+// When the engine decides on several actions for a subject (eg, a label and a report), some may succeed while others fail, for example because the moderation service's report API is briefly unavailable. Instead of dropping the remaining actions, the engine records each failed action in an outbox, and a background worker retries them with backoff until they succeed or run out of attempts.
+//
+// Includes an interface and implementations using redis and in-process memory.
+package outboxstore
@@ -0,0 +1,41 @@
+package outboxstore
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// A moderation action which could not be persisted, queued for retry
+type Entry struct {
+	ID string `json:"id"`
+	// kind of action, eg "label" or "report"
+	Action string `json:"action"`
+	// "account" or "record"
+	SubjectType string `json:"subjectType"`
+	// DID or AT-URI of the subject
+	Subject string `json:"subject"`
+	// action values (eg, label or tag values), for logging
+	Vals []string `json:"vals,omitempty"`
+	// JSON-encoded input to the moderation service method which failed
+	Payload json.RawMessage `json:"payload"`
+
+	// number of failed attempts so far (including the original attempt)
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"lastError,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+	NextAttempt time.Time `json:"nextAttempt"`
+}
+
+// OutboxStore is an interface for queueing moderation actions to be retried.
+//
+// Entries are claimed by Due, which pushes their next attempt time back by a lease period so that concurrent workers (eg, several hepa instances sharing redis) don't retry the same entry at once. After an attempt, the entry should either be removed (on success, or if giving up), or updated with a new next attempt time.
+type OutboxStore interface {
+	// adds a new entry, or replaces an existing entry with the same ID
+	Put(ctx context.Context, e Entry) error
+	// claims and returns up to limit entries which are due at the given time, earliest first. claimed entries are not returned again until lease has passed
+	Due(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]Entry, error)
+	Remove(ctx context.Context, id string) error
+	// returns the total number of entries
+	Len(ctx context.Context) (int, error)
+}
@@ -0,0 +1,63 @@
+package outboxstore
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemOutboxStore is an in-process OutboxStore. Entries are lost on restart.
+type MemOutboxStore struct {
+	lk      sync.Mutex
+	entries map[string]Entry
+}
+
+func NewMemOutboxStore() *MemOutboxStore {
+	return &MemOutboxStore{
+		entries: make(map[string]Entry),
+	}
+}
+
+func (s *MemOutboxStore) Put(ctx context.Context, e Entry) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.entries[e.ID] = e
+	return nil
+}
+
+func (s *MemOutboxStore) Due(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]Entry, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	out := []Entry{}
+	for _, e := range s.entries {
+		if !e.NextAttempt.After(now) {
+			out = append(out, e)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].NextAttempt.Before(out[j].NextAttempt)
+	})
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	for _, e := range out {
+		e.NextAttempt = now.Add(lease)
+		s.entries[e.ID] = e
+	}
+	return out, nil
+}
+
+func (s *MemOutboxStore) Remove(ctx context.Context, id string) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	delete(s.entries, id)
+	return nil
+}
+
+func (s *MemOutboxStore) Len(ctx context.Context) (int, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	return len(s.entries), nil
+}
@@ -0,0 +1,106 @@
+package outboxstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var redisOutboxEntriesKey string = "automod-outbox/entries"
+var redisOutboxDueKey string = "automod-outbox/due"
+
+// claims due entries atomically, by bumping their score to the end of the lease
+var redisOutboxClaimScript = redis.NewScript(`
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, id in ipairs(ids) do
+	redis.call('ZADD', KEYS[1], ARGV[3], id)
+end
+return ids
+`)
+
+// RedisOutboxStore keeps JSON-encoded entries in a redis hash, keyed by ID, and the schedule in a sorted set of IDs, scored by next attempt time (unix milliseconds).
+type RedisOutboxStore struct {
+	Client *redis.Client
+}
+
+func NewRedisOutboxStore(redisURL string) (*RedisOutboxStore, error) {
+	ctx := context.Background()
+	opt, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	rdb := redis.NewClient(opt)
+	// check redis connection
+	_, err = rdb.Ping(ctx).Result()
+	if err != nil {
+		return nil, err
+	}
+	ros := RedisOutboxStore{
+		Client: rdb,
+	}
+	return &ros, nil
+}
+
+func (s *RedisOutboxStore) Put(ctx context.Context, e Entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = s.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, redisOutboxEntriesKey, e.ID, b)
+		pipe.ZAdd(ctx, redisOutboxDueKey, redis.Z{Score: float64(e.NextAttempt.UnixMilli()), Member: e.ID})
+		return nil
+	})
+	return err
+}
+
+func (s *RedisOutboxStore) Due(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]Entry, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	leaseUntil := now.Add(lease)
+	ids, err := redisOutboxClaimScript.Run(ctx, s.Client, []string{redisOutboxDueKey}, now.UnixMilli(), limit, leaseUntil.UnixMilli()).StringSlice()
+	if err == redis.Nil || len(ids) == 0 {
+		return []Entry{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	vals, err := s.Client.HMGet(ctx, redisOutboxEntriesKey, ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Entry, 0, len(vals))
+	for i, v := range vals {
+		str, ok := v.(string)
+		if !ok {
+			// schedule entry without a body; clean up
+			s.Client.ZRem(ctx, redisOutboxDueKey, ids[i])
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal([]byte(str), &e); err != nil {
+			return nil, fmt.Errorf("decoding outbox entry %s: %w", ids[i], err)
+		}
+		e.NextAttempt = leaseUntil
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+func (s *RedisOutboxStore) Remove(ctx context.Context, id string) error {
+	_, err := s.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HDel(ctx, redisOutboxEntriesKey, id)
+		pipe.ZRem(ctx, redisOutboxDueKey, id)
+		return nil
+	})
+	return err
+}
+
+func (s *RedisOutboxStore) Len(ctx context.Context) (int, error) {
+	n, err := s.Client.ZCard(ctx, redisOutboxDueKey).Result()
+	return int(n), err
+}
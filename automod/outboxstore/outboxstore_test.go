@@ -0,0 +1,61 @@
+package outboxstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testOutboxStore(t *testing.T, s OutboxStore) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	now := time.Now().Truncate(time.Millisecond)
+	assert.NoError(s.Put(ctx, Entry{ID: "a", Action: "label", Subject: "did:plc:a", NextAttempt: now.Add(-time.Minute)}))
+	assert.NoError(s.Put(ctx, Entry{ID: "b", Action: "report", Subject: "did:plc:b", NextAttempt: now.Add(-2 * time.Minute)}))
+	assert.NoError(s.Put(ctx, Entry{ID: "c", Action: "tag", Subject: "did:plc:c", NextAttempt: now.Add(time.Hour)}))
+
+	n, err := s.Len(ctx)
+	assert.NoError(err)
+	assert.Equal(3, n)
+
+	due, err := s.Due(ctx, now, 10, time.Minute)
+	assert.NoError(err)
+	if assert.Equal(2, len(due)) {
+		assert.Equal("b", due[0].ID)
+		assert.Equal("a", due[1].ID)
+	}
+
+	// claimed entries aren't handed out again during the lease
+	due, err = s.Due(ctx, now, 10, time.Minute)
+	assert.NoError(err)
+	assert.Empty(due)
+
+	// but are after it
+	due, err = s.Due(ctx, now.Add(2*time.Minute), 1, time.Minute)
+	assert.NoError(err)
+	assert.Equal(1, len(due))
+
+	assert.NoError(s.Remove(ctx, "a"))
+	assert.NoError(s.Remove(ctx, "b"))
+	assert.NoError(s.Remove(ctx, "c"))
+	n, err = s.Len(ctx)
+	assert.NoError(err)
+	assert.Equal(0, n)
+}
+
+func TestMemOutboxStore(t *testing.T) {
+	testOutboxStore(t, NewMemOutboxStore())
+}
+
+func TestRedisOutboxStore(t *testing.T) {
+	t.Skip("live test, need redis running locally")
+
+	s, err := NewRedisOutboxStore("redis://localhost:6379/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testOutboxStore(t, s)
+}
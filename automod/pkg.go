@@ -14,15 +14,23 @@ type RuleSet = engine.RuleSet
 
 type Notifier = engine.Notifier
 type SlackNotifier = engine.SlackNotifier
+type MuteSink = engine.MuteSink
+type RainbowMuteSink = engine.RainbowMuteSink
 
 type AccountContext = engine.AccountContext
 type RecordContext = engine.RecordContext
 type OzoneEventContext = engine.OzoneEventContext
 type NotificationContext = engine.NotificationContext
 type RecordOp = engine.RecordOp
+type RecordHistory = engine.RecordHistory
+type Severity = engine.Severity
+type EscalationLadder = engine.EscalationLadder
+type LadderStep = engine.LadderStep
+type RuleBudget = engine.RuleBudget
 
 type IdentityRuleFunc = engine.IdentityRuleFunc
 type RecordRuleFunc = engine.RecordRuleFunc
+type DeleteRuleFunc = engine.DeleteRuleFunc
 type PostRuleFunc = engine.PostRuleFunc
 type ProfileRuleFunc = engine.ProfileRuleFunc
 type BlobRuleFunc = engine.BlobRuleFunc
@@ -41,6 +49,14 @@ var (
 	PeriodDay   = countstore.PeriodDay
 	PeriodHour  = countstore.PeriodHour
 
+	SeverityInfo     = engine.SeverityInfo
+	SeverityLow      = engine.SeverityLow
+	SeverityHigh     = engine.SeverityHigh
+	SeverityCritical = engine.SeverityCritical
+
+	BaselineAccountRecords    = engine.BaselineAccountRecords
+	BaselineCollectionRecords = engine.BaselineCollectionRecords
+
 	CreateOp = engine.CreateOp
 	UpdateOp = engine.UpdateOp
 	DeleteOp = engine.DeleteOp
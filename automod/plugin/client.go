@@ -0,0 +1,311 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/automod"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const evaluateMethod = "/automod.plugin.v1.RuleService/Evaluate"
+
+var evaluateStreamDesc = grpc.StreamDesc{
+	StreamName:    "Evaluate",
+	ServerStreams: true,
+	ClientStreams: true,
+}
+
+var ErrTimeout = errors.New("rule plugin did not respond in time")
+
+// Client sends events to an external rule server (a "plugin"), and applies the effects it sends back. Register its rules with AddRules.
+//
+// Events are multiplexed over a single gRPC stream, which is re-opened after any failure. If the plugin doesn't respond within Timeout, the event is processed without it.
+type Client struct {
+	// name of the plugin, for logs and metrics
+	Name string
+	// deadline for the plugin's response to each event. the rule context's own deadline (eg, from a rule budget) also applies
+	Timeout time.Duration
+	// record collections sent to the plugin (NSIDs, or prefixes ending in '*'). empty for all
+	Collections []string
+	Logger      *slog.Logger
+
+	conn *grpc.ClientConn
+
+	lk     sync.Mutex
+	stream *pluginStream
+	nextID uint64
+}
+
+type pluginResult struct {
+	eff *Effects
+	err error
+}
+
+// a single open stream to the plugin, and the events awaiting replies on it
+type pluginStream struct {
+	cs     grpc.ClientStream
+	cancel func()
+
+	sendLk  sync.Mutex
+	lk      sync.Mutex
+	pending map[string]chan pluginResult
+	err     error
+}
+
+// NewClient connects (lazily) to the rule server at target, a gRPC target like "localhost:7000". The connection is unencrypted, so plugins are expected to run alongside hepa or on a private network.
+func NewClient(name, target string, timeout time.Duration) (*Client, error) {
+	conn, err := grpc.Dial(target, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, fmt.Errorf("connecting to rule plugin %s: %w", name, err)
+	}
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	return &Client{
+		Name:    name,
+		Timeout: timeout,
+		Logger:  slog.Default().With("system", "rule-plugin", "plugin", name),
+		conn:    conn,
+	}, nil
+}
+
+// AddRules registers the plugin's rules (for records, record deletions, identity and account events) in a rule set
+func (cl *Client) AddRules(rs *automod.RuleSet) {
+	rs.RecordRules = append(rs.RecordRules, cl.RecordRule)
+	rs.RecordDeleteRules = append(rs.RecordDeleteRules, cl.RecordRule)
+	rs.IdentityRules = append(rs.IdentityRules, cl.IdentityRule)
+	rs.AccountRules = append(rs.AccountRules, cl.AccountRule)
+}
+
+func (cl *Client) Close() error {
+	cl.lk.Lock()
+	if cl.stream != nil {
+		cl.stream.cancel()
+		cl.stream = nil
+	}
+	cl.lk.Unlock()
+	return cl.conn.Close()
+}
+
+func (cl *Client) wantsCollection(nsid syntax.NSID) bool {
+	if len(cl.Collections) == 0 {
+		return true
+	}
+	for _, c := range cl.Collections {
+		if prefix, ok := strings.CutSuffix(c, "*"); ok {
+			if strings.HasPrefix(nsid.String(), prefix) {
+				return true
+			}
+		} else if c == nsid.String() {
+			return true
+		}
+	}
+	return false
+}
+
+func (cl *Client) RecordRule(c *automod.RecordContext) error {
+	if !cl.wantsCollection(c.RecordOp.Collection) {
+		return nil
+	}
+	rec, err := recordSummary(&c.RecordOp)
+	if err != nil {
+		return fmt.Errorf("preparing record for rule plugin %s: %w", cl.Name, err)
+	}
+	eff, err := cl.Evaluate(c.Ctx, &Event{
+		Type:    EventRecord,
+		Account: accountSummary(&c.Account),
+		Record:  rec,
+	})
+	if err != nil {
+		return err
+	}
+	eff.applyRecord(c)
+	return nil
+}
+
+func (cl *Client) IdentityRule(c *automod.AccountContext) error {
+	return cl.accountEvent(c, EventIdentity)
+}
+
+func (cl *Client) AccountRule(c *automod.AccountContext) error {
+	return cl.accountEvent(c, EventAccount)
+}
+
+func (cl *Client) accountEvent(c *automod.AccountContext, typ string) error {
+	eff, err := cl.Evaluate(c.Ctx, &Event{
+		Type:    typ,
+		Account: accountSummary(&c.Account),
+	})
+	if err != nil {
+		return err
+	}
+	eff.applyAccount(c)
+	return nil
+}
+
+// Evaluate sends an event to the plugin, and waits (up to Timeout) for its effects. The event ID is assigned by the client.
+func (cl *Client) Evaluate(ctx context.Context, evt *Event) (*Effects, error) {
+	start := time.Now()
+	eff, err := cl.evaluate(ctx, evt)
+	result := "ok"
+	switch {
+	case errors.Is(err, ErrTimeout):
+		result = "timeout"
+	case err != nil:
+		result = "error"
+	case eff.Error != "":
+		result = "plugin-error"
+		cl.Logger.Warn("rule plugin reported an error", "type", evt.Type, "did", evt.Account.DID, "err", eff.Error)
+	}
+	pluginRequests.WithLabelValues(cl.Name, result).Inc()
+	pluginDuration.WithLabelValues(cl.Name).Observe(time.Since(start).Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("rule plugin %s: %w", cl.Name, err)
+	}
+	return eff, nil
+}
+
+func (cl *Client) evaluate(ctx context.Context, evt *Event) (*Effects, error) {
+	ctx, cancel := context.WithTimeout(ctx, cl.Timeout)
+	defer cancel()
+
+	ps, id, err := cl.openStream()
+	if err != nil {
+		return nil, err
+	}
+	evt.ID = id
+	msg, err := toStruct(evt)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan pluginResult, 1)
+	if err := ps.register(id, ch); err != nil {
+		return nil, err
+	}
+	defer ps.unregister(id)
+
+	ps.sendLk.Lock()
+	err = ps.cs.SendMsg(msg)
+	ps.sendLk.Unlock()
+	if err != nil {
+		cl.resetStream(ps, err)
+		return nil, fmt.Errorf("sending event: %w", err)
+	}
+
+	select {
+	case res := <-ch:
+		return res.eff, res.err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, ErrTimeout
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// returns the current stream (opening one if needed), and a new event ID
+func (cl *Client) openStream() (*pluginStream, string, error) {
+	cl.lk.Lock()
+	defer cl.lk.Unlock()
+	cl.nextID++
+	id := strconv.FormatUint(cl.nextID, 10)
+	if cl.stream != nil {
+		return cl.stream, id, nil
+	}
+
+	// the stream outlives any one event, so isn't tied to a request context
+	ctx, cancel := context.WithCancel(context.Background())
+	cs, err := cl.conn.NewStream(ctx, &evaluateStreamDesc, evaluateMethod)
+	if err != nil {
+		cancel()
+		return nil, "", fmt.Errorf("opening stream: %w", err)
+	}
+	ps := &pluginStream{
+		cs:      cs,
+		cancel:  cancel,
+		pending: make(map[string]chan pluginResult),
+	}
+	cl.stream = ps
+	go cl.receive(ps)
+	return ps, id, nil
+}
+
+// reads replies from a stream until it fails
+func (cl *Client) receive(ps *pluginStream) {
+	for {
+		msg := &structpb.Struct{}
+		if err := ps.cs.RecvMsg(msg); err != nil {
+			cl.resetStream(ps, err)
+			return
+		}
+		var eff Effects
+		if err := fromStruct(msg, &eff); err != nil {
+			cl.Logger.Warn("invalid reply from rule plugin", "err", err)
+			continue
+		}
+		ps.deliver(eff.ID, pluginResult{eff: &eff})
+	}
+}
+
+// drops a failed stream (if it is still current), failing any events waiting on it
+func (cl *Client) resetStream(ps *pluginStream, err error) {
+	cl.lk.Lock()
+	if cl.stream == ps {
+		cl.stream = nil
+		cl.Logger.Warn("rule plugin stream failed", "err", err)
+	}
+	cl.lk.Unlock()
+	ps.cancel()
+	ps.fail(fmt.Errorf("stream failed: %w", err))
+}
+
+func (ps *pluginStream) register(id string, ch chan pluginResult) error {
+	ps.lk.Lock()
+	defer ps.lk.Unlock()
+	if ps.err != nil {
+		return ps.err
+	}
+	ps.pending[id] = ch
+	return nil
+}
+
+func (ps *pluginStream) unregister(id string) {
+	ps.lk.Lock()
+	defer ps.lk.Unlock()
+	delete(ps.pending, id)
+}
+
+// passes a reply to the event waiting for it. replies to events which timed out are dropped
+func (ps *pluginStream) deliver(id string, res pluginResult) {
+	ps.lk.Lock()
+	defer ps.lk.Unlock()
+	if ch, ok := ps.pending[id]; ok {
+		ch <- res
+		delete(ps.pending, id)
+	}
+}
+
+func (ps *pluginStream) fail(err error) {
+	ps.lk.Lock()
+	defer ps.lk.Unlock()
+	if ps.err != nil {
+		return
+	}
+	ps.err = err
+	for id, ch := range ps.pending {
+		ch <- pluginResult{err: err}
+		delete(ps.pending, id)
+	}
+}
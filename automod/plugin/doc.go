@@ -0,0 +1,4 @@
+// automod rules implemented by external "plugin" processes, over gRPC.
+//
+// The protocol is described in plugin.proto. Client connects hepa (or any other automod engine) to a plugin; RegisterRuleServer helps implement a plugin in Go.
+package plugin
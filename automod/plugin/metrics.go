@@ -0,0 +1,17 @@
+package plugin
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var pluginRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "automod_plugin_requests",
+	Help: "Number of events sent to rule plugins, by plugin and result",
+}, []string{"plugin", "result"})
+
+var pluginDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "automod_plugin_duration_seconds",
+	Help:    "Time from sending an event to a rule plugin to receiving its effects (or giving up)",
+	Buckets: prometheus.ExponentialBuckets(0.001, 2, 14),
+}, []string{"plugin"})
@@ -0,0 +1,162 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/automod"
+	"github.com/bluesky-social/indigo/automod/automodtest"
+	"github.com/bluesky-social/indigo/automod/engine"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+// labels posts containing "spam", and stalls on posts containing "slow"
+type testRuleServer struct{}
+
+func (testRuleServer) Evaluate(ctx context.Context, evt *Event) (*Effects, error) {
+	if evt.Type != EventRecord {
+		return &Effects{AccountTags: []string{"seen-" + evt.Type}}, nil
+	}
+	text := string(evt.Record.Value)
+	switch {
+	case strings.Contains(text, "slow"):
+		time.Sleep(200 * time.Millisecond)
+		return nil, nil
+	case strings.Contains(text, "broken"):
+		return nil, errors.New("rule failed")
+	case strings.Contains(text, "spam"):
+		return &Effects{
+			RecordLabels:  []string{"spam"},
+			AccountFlags:  []string{"posted-spam"},
+			RecordReports: []Report{{Reason: automod.ReportReasonSpam, Comment: "spam post: " + evt.Record.URI}},
+		}, nil
+	}
+	return nil, nil
+}
+
+func testPlugin(t *testing.T) *Client {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	RegisterRuleServer(srv, testRuleServer{})
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	cl, err := NewClient("test", lis.Addr().String(), 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cl.Close() })
+	return cl
+}
+
+func TestPluginRecordRule(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	cl := testPlugin(t)
+
+	eng := engine.EngineTestFixture()
+	g := automodtest.NewFixtureGen(1)
+	ident := g.Identity()
+	am1 := automod.AccountMeta{Identity: &ident}
+
+	run := func(text string) (*engine.Effects, error) {
+		op, err := g.CreateOp(ident.DID, "app.bsky.feed.post", g.Post(automodtest.PostSpec{Text: text}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := engine.NewRecordContext(ctx, &eng, am1, op)
+		err = cl.RecordRule(&c)
+		return engine.ExtractEffects(&c.BaseContext), err
+	}
+
+	eff, err := run("buy my spam")
+	assert.NoError(err)
+	assert.Equal([]string{"spam"}, eff.RecordLabels)
+	assert.Equal([]string{"posted-spam"}, eff.AccountFlags)
+	assert.Len(eff.RecordReports, 1)
+
+	eff, err = run("hello")
+	assert.NoError(err)
+	assert.Empty(eff.RecordLabels)
+
+	// a slow plugin times out, without holding up later events
+	_, err = run("slow spam")
+	assert.ErrorIs(err, ErrTimeout)
+	eff, err = run("more spam")
+	assert.NoError(err)
+	assert.Equal([]string{"spam"}, eff.RecordLabels)
+
+	// plugin errors are logged, not returned
+	_, err = run("broken")
+	assert.NoError(err)
+
+	// collections the plugin isn't interested in are skipped
+	cl.Collections = []string{"app.bsky.graph.*"}
+	eff, err = run("spam again")
+	assert.NoError(err)
+	assert.Empty(eff.RecordLabels)
+}
+
+func TestPluginAccountRules(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	cl := testPlugin(t)
+
+	eng := engine.EngineTestFixture()
+	g := automodtest.NewFixtureGen(1)
+	ident := g.Identity()
+	am1 := automod.AccountMeta{Identity: &ident}
+
+	c := engine.NewAccountContext(ctx, &eng, am1)
+	assert.NoError(cl.IdentityRule(&c))
+	assert.NoError(cl.AccountRule(&c))
+	assert.Equal([]string{"seen-identity", "seen-account"}, engine.ExtractEffects(&c.BaseContext).AccountTags)
+}
+
+func TestPluginReconnect(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	srv := grpc.NewServer()
+	RegisterRuleServer(srv, testRuleServer{})
+	go srv.Serve(lis)
+
+	cl, err := NewClient("test", addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	evt := &Event{Type: EventAccount}
+	_, err = cl.Evaluate(ctx, evt)
+	assert.NoError(err)
+
+	// the plugin restarts; the first event after it went away may fail, but the stream is re-opened
+	srv.Stop()
+	lis, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv = grpc.NewServer()
+	RegisterRuleServer(srv, testRuleServer{})
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	assert.Eventually(func() bool {
+		eff, err := cl.Evaluate(ctx, &Event{Type: EventAccount})
+		return err == nil && len(eff.AccountTags) == 1
+	}, 5*time.Second, 50*time.Millisecond)
+}
@@ -0,0 +1,188 @@
+package plugin
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/bluesky-social/indigo/automod"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Event types sent to plugins
+const (
+	EventRecord   = "record"
+	EventIdentity = "identity"
+	EventAccount  = "account"
+)
+
+// Event is sent to a plugin for each event it should evaluate
+type Event struct {
+	// opaque identifier, echoed back in Effects
+	ID      string         `json:"id"`
+	Type    string         `json:"type"`
+	Account AccountSummary `json:"account"`
+	// only set for record events
+	Record *RecordSummary `json:"record,omitempty"`
+}
+
+// AccountSummary is the account metadata sent to plugins
+type AccountSummary struct {
+	DID            string     `json:"did"`
+	Handle         string     `json:"handle,omitempty"`
+	DisplayName    string     `json:"displayName,omitempty"`
+	Description    string     `json:"description,omitempty"`
+	Labels         []string   `json:"labels,omitempty"`
+	Flags          []string   `json:"flags,omitempty"`
+	FollowersCount int64      `json:"followersCount"`
+	FollowsCount   int64      `json:"followsCount"`
+	PostsCount     int64      `json:"postsCount"`
+	Takendown      bool       `json:"takendown"`
+	Deactivated    bool       `json:"deactivated"`
+	CreatedAt      *time.Time `json:"createdAt,omitempty"`
+}
+
+// RecordSummary is the record operation sent to plugins
+type RecordSummary struct {
+	Action     string `json:"action"`
+	URI        string `json:"uri"`
+	Collection string `json:"collection"`
+	RecordKey  string `json:"rkey"`
+	CID        string `json:"cid,omitempty"`
+	// the record, as atproto JSON. not set for deletions
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Effects are a plugin's response to an Event: the moderation actions to take
+type Effects struct {
+	ID string `json:"id"`
+	// error encountered by the plugin, if any. logged by the engine; any effects are still applied
+	Error           string   `json:"error,omitempty"`
+	AccountFlags    []string `json:"accountFlags,omitempty"`
+	AccountLabels   []string `json:"accountLabels,omitempty"`
+	AccountTags     []string `json:"accountTags,omitempty"`
+	AccountReports  []Report `json:"accountReports,omitempty"`
+	AccountTakedown bool     `json:"accountTakedown,omitempty"`
+	// record effects are ignored for non-record events
+	RecordFlags    []string `json:"recordFlags,omitempty"`
+	RecordLabels   []string `json:"recordLabels,omitempty"`
+	RecordTags     []string `json:"recordTags,omitempty"`
+	RecordReports  []Report `json:"recordReports,omitempty"`
+	RecordTakedown bool     `json:"recordTakedown,omitempty"`
+}
+
+// Report is a moderation report filed by a plugin. Reason is one of the automod.ReportReason* values
+type Report struct {
+	Reason  string `json:"reason"`
+	Comment string `json:"comment"`
+}
+
+func accountSummary(am *automod.AccountMeta) AccountSummary {
+	out := AccountSummary{
+		Labels:         am.AccountLabels,
+		Flags:          am.AccountFlags,
+		FollowersCount: am.FollowersCount,
+		FollowsCount:   am.FollowsCount,
+		PostsCount:     am.PostsCount,
+		Takendown:      am.Takendown,
+		Deactivated:    am.Deactivated,
+		CreatedAt:      am.CreatedAt,
+	}
+	if am.Profile.DisplayName != nil {
+		out.DisplayName = *am.Profile.DisplayName
+	}
+	if am.Profile.Description != nil {
+		out.Description = *am.Profile.Description
+	}
+	if am.Identity != nil {
+		out.DID = am.Identity.DID.String()
+		out.Handle = am.Identity.Handle.String()
+	}
+	return out
+}
+
+func recordSummary(op *automod.RecordOp) (*RecordSummary, error) {
+	out := &RecordSummary{
+		Action:     op.Action,
+		URI:        op.ATURI().String(),
+		Collection: op.Collection.String(),
+		RecordKey:  op.RecordKey.String(),
+	}
+	if op.CID != nil {
+		out.CID = op.CID.String()
+	}
+	if len(op.RecordCBOR) > 0 {
+		rec, err := data.UnmarshalCBOR(op.RecordCBOR)
+		if err != nil {
+			return nil, err
+		}
+		out.Value, err = json.Marshal(rec)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// applies effects to an account context
+func (eff *Effects) applyAccount(c *automod.AccountContext) {
+	for _, v := range eff.AccountFlags {
+		c.AddAccountFlag(v)
+	}
+	for _, v := range eff.AccountLabels {
+		c.AddAccountLabel(v)
+	}
+	for _, v := range eff.AccountTags {
+		c.AddAccountTag(v)
+	}
+	for _, r := range eff.AccountReports {
+		c.ReportAccount(r.Reason, r.Comment)
+	}
+	if eff.AccountTakedown {
+		c.TakedownAccount()
+	}
+}
+
+// applies effects to a record context, including account effects
+func (eff *Effects) applyRecord(c *automod.RecordContext) {
+	eff.applyAccount(&c.AccountContext)
+	for _, v := range eff.RecordFlags {
+		c.AddRecordFlag(v)
+	}
+	for _, v := range eff.RecordLabels {
+		c.AddRecordLabel(v)
+	}
+	for _, v := range eff.RecordTags {
+		c.AddRecordTag(v)
+	}
+	for _, r := range eff.RecordReports {
+		c.ReportRecord(r.Reason, r.Comment)
+	}
+	if eff.RecordTakedown {
+		c.TakedownRecord()
+	}
+}
+
+// converts a message to its wire form
+func toStruct(v any) (*structpb.Struct, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	st := &structpb.Struct{}
+	if err := protojson.Unmarshal(b, st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// converts a message from its wire form
+func fromStruct(st *structpb.Struct, v any) error {
+	b, err := protojson.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,71 @@
+package plugin
+
+import (
+	"context"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// RuleServer is implemented by Go rule plugins. Evaluate may be called concurrently. An error is reported back to the engine (which logs it) along with any effects returned.
+type RuleServer interface {
+	Evaluate(ctx context.Context, evt *Event) (*Effects, error)
+}
+
+var ruleServiceDesc = grpc.ServiceDesc{
+	ServiceName: "automod.plugin.v1.RuleService",
+	HandlerType: (*RuleServer)(nil),
+	Streams: []grpc.StreamDesc{{
+		StreamName:    evaluateStreamDesc.StreamName,
+		Handler:       evaluateHandler,
+		ServerStreams: true,
+		ClientStreams: true,
+	}},
+	Metadata: "plugin.proto",
+}
+
+// RegisterRuleServer registers a plugin implementation with a gRPC server
+func RegisterRuleServer(s *grpc.Server, rs RuleServer) {
+	s.RegisterService(&ruleServiceDesc, rs)
+}
+
+func evaluateHandler(srv any, ss grpc.ServerStream) error {
+	rs := srv.(RuleServer)
+	ctx := ss.Context()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	var sendLk sync.Mutex
+	for {
+		msg := &structpb.Struct{}
+		if err := ss.RecvMsg(msg); err != nil {
+			// includes io.EOF when the engine closes the stream
+			return err
+		}
+		var evt Event
+		if err := fromStruct(msg, &evt); err != nil {
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			eff, err := rs.Evaluate(ctx, &evt)
+			if eff == nil {
+				eff = &Effects{}
+			}
+			eff.ID = evt.ID
+			if err != nil {
+				eff.Error = err.Error()
+			}
+			reply, err := toStruct(eff)
+			if err != nil {
+				reply, _ = toStruct(&Effects{ID: evt.ID, Error: err.Error()})
+			}
+			sendLk.Lock()
+			defer sendLk.Unlock()
+			// a failed send means the stream is broken, which RecvMsg will also report
+			_ = ss.SendMsg(reply)
+		}()
+	}
+}
@@ -30,6 +30,8 @@ func DefaultRules() automod.RuleSet {
 			HarassmentTrivialPostRule,
 			NostrSpamPostRule,
 			TrivialSpamPostRule,
+			InteractionGraphPostRule,
+			CoordinatedReplyPostRule,
 		},
 		ProfileRules: []automod.ProfileRuleFunc{
 			GtubeProfileRule,
@@ -42,10 +44,16 @@ func DefaultRules() automod.RuleSet {
 			BadWordRecordKeyRule,
 			BadWordOtherRecordRule,
 			TooManyRepostRule,
+			InteractionGraphRepostRule,
+			CanaryInteractionRule,
+			RecordBurstRule,
 		},
 		RecordDeleteRules: []automod.RecordRuleFunc{
 			DeleteInteractionRule,
 		},
+		DeleteRules: []automod.DeleteRuleFunc{
+			DeleteActionedRecordRule,
+		},
 		IdentityRules: []automod.IdentityRuleFunc{
 			NewAccountRule,
 			BadWordHandleRule,
@@ -0,0 +1,31 @@
+package rules
+
+import (
+	"github.com/bluesky-social/indigo/automod"
+)
+
+// z-score of an account's record creation rate, relative to its own baseline, above which it is flagged
+var recordBurstScore = 8.0
+
+// minimum records created in the current hour before bursts are flagged, so that quiet accounts aren't flagged for a handful of posts
+var recordBurstMinCount = 50.0
+
+var _ automod.RecordRuleFunc = RecordBurstRule
+
+// flags accounts which are creating records much faster than they normally do (at any time of day)
+func RecordBurstRule(c *automod.RecordContext) error {
+	if c.RecordOp.Action != automod.CreateOp {
+		return nil
+	}
+	b := c.GetBaseline(automod.BaselineAccountRecords, c.Account.Identity.DID.String())
+	if b.Count < recordBurstMinCount {
+		return nil
+	}
+	score := b.ZScore()
+	if score < recordBurstScore {
+		return nil
+	}
+	c.Logger.Info("record-burst", "count", b.Count, "mean", b.Mean, "score", score, "timeOfDayScore", b.TimeOfDayZScore())
+	c.AddAccountFlag("record-burst")
+	return nil
+}
@@ -0,0 +1,31 @@
+package rules
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bluesky-social/indigo/automod"
+	"github.com/bluesky-social/indigo/automod/canarystore"
+	"github.com/bluesky-social/indigo/automod/helpers"
+)
+
+// number of distinct canaries an established account needs to interact with before being reported
+var canaryReportDistinct = 2
+
+var _ automod.RecordRuleFunc = CanaryInteractionRule
+
+// flags any account interacting with a canary (honeypot) account or record, and reports young accounts or those which have hit several canaries
+func CanaryInteractionRule(c *automod.RecordContext) error {
+	if len(c.CanaryHits) == 0 {
+		return nil
+	}
+
+	distinct := canarystore.DistinctCanaries(append(c.GetCanaryHits(), c.CanaryHits...))
+	c.Logger.Info("canary-interaction", "kind", c.CanaryHits[0].Kind, "canary", c.CanaryHits[0].Canary, "distinct", distinct)
+	c.AddAccountFlag("canary-interaction")
+
+	if helpers.AccountIsYoungerThan(&c.AccountContext, 7*24*time.Hour) || distinct >= canaryReportDistinct {
+		c.ReportAccount(automod.ReportReasonSpam, fmt.Sprintf("interacted with canary account or record (%d distinct canaries)", distinct))
+	}
+	return nil
+}
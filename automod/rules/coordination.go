@@ -0,0 +1,116 @@
+package rules
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/automod"
+	"github.com/bluesky-social/indigo/automod/graphstore"
+	"github.com/bluesky-social/indigo/automod/helpers"
+)
+
+var _ automod.PostRuleFunc = InteractionGraphPostRule
+
+// records reply, mention, and quote-post edges in the interaction graph. does not take any action itself.
+func InteractionGraphPostRule(c *automod.RecordContext, post *appbsky.FeedPost) error {
+	if post.Reply != nil && post.Reply.Parent != nil && !helpers.IsSelfThread(c, post) {
+		parentURI, err := syntax.ParseATURI(post.Reply.Parent.Uri)
+		if err == nil {
+			c.AddInteraction(graphstore.KindReply, parentURI.Authority().String())
+		}
+	}
+
+	facets, err := helpers.ExtractFacets(post)
+	if err != nil {
+		c.Logger.Warn("invalid facets", "err", err)
+	}
+	for _, facet := range facets {
+		if facet.DID != nil {
+			c.AddInteraction(graphstore.KindMention, *facet.DID)
+		}
+	}
+
+	if post.Embed != nil {
+		var quoteURI string
+		if post.Embed.EmbedRecord != nil && post.Embed.EmbedRecord.Record != nil {
+			quoteURI = post.Embed.EmbedRecord.Record.Uri
+		} else if post.Embed.EmbedRecordWithMedia != nil && post.Embed.EmbedRecordWithMedia.Record != nil && post.Embed.EmbedRecordWithMedia.Record.Record != nil {
+			quoteURI = post.Embed.EmbedRecordWithMedia.Record.Record.Uri
+		}
+		if quoteURI != "" {
+			uri, err := syntax.ParseATURI(quoteURI)
+			if err == nil {
+				c.AddInteraction(graphstore.KindQuote, uri.Authority().String())
+			}
+		}
+	}
+	return nil
+}
+
+var _ automod.RecordRuleFunc = InteractionGraphRepostRule
+
+// records repost edges in the interaction graph
+func InteractionGraphRepostRule(c *automod.RecordContext) error {
+	if c.RecordOp.Collection != "app.bsky.feed.repost" || c.RecordOp.Action != automod.CreateOp {
+		return nil
+	}
+	var repost appbsky.FeedRepost
+	if err := repost.UnmarshalCBOR(bytes.NewReader(c.RecordOp.RecordCBOR)); err != nil {
+		return fmt.Errorf("failed to parse app.bsky.feed.repost record: %v", err)
+	}
+	if repost.Subject == nil {
+		return nil
+	}
+	uri, err := syntax.ParseATURI(repost.Subject.Uri)
+	if err != nil {
+		return nil
+	}
+	c.AddInteraction(graphstore.KindRepost, uri.Authority().String())
+	return nil
+}
+
+// number of reply targets an account needs to share with another account to count as co-targeting
+var coordinatedReplySharedTargets = 5
+
+// number of co-targeting accounts needed to trigger
+var coordinatedReplyGroupSize = 3
+
+// minimum local clustering coefficient (how tightly interconnected the account's reply neighborhood is)
+var coordinatedReplyClustering = 0.5
+
+var _ automod.PostRuleFunc = CoordinatedReplyPostRule
+
+// looks for young accounts replying to the same set of accounts as several other accounts, within a tightly-connected reply graph: a pattern typical of brigading.
+//
+// Only flags; coordination signals are too easy to trip through organic pile-ons to action automatically.
+func CoordinatedReplyPostRule(c *automod.RecordContext, post *appbsky.FeedPost) error {
+	if post.Reply == nil || helpers.IsSelfThread(c, post) {
+		return nil
+	}
+	if c.Account.Identity == nil || !helpers.AccountIsYoungerThan(&c.AccountContext, 14*24*time.Hour) {
+		return nil
+	}
+
+	did := c.Account.Identity.DID.String()
+	group := 0
+	for _, shared := range c.GetCoTargeters(graphstore.KindReply, did) {
+		if shared >= coordinatedReplySharedTargets {
+			group++
+		}
+	}
+	if group < coordinatedReplyGroupSize {
+		return nil
+	}
+
+	cc := c.GetClusteringCoefficient(graphstore.KindReply, did)
+	if cc < coordinatedReplyClustering {
+		return nil
+	}
+
+	c.Logger.Info("coordinated-replies", "groupSize", group, "clustering", cc)
+	c.AddAccountFlag("coordinated-replies")
+	return nil
+}
@@ -0,0 +1,109 @@
+package rules
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/automod"
+	"github.com/bluesky-social/indigo/automod/engine"
+	"github.com/bluesky-social/indigo/automod/graphstore"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInteractionGraphPostRule(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	eng := engine.EngineTestFixture()
+	am1 := automod.AccountMeta{
+		Identity: &identity.Identity{
+			DID:    syntax.DID("did:plc:abc111"),
+			Handle: syntax.Handle("handle.example.com"),
+		},
+	}
+	p1 := appbsky.FeedPost{
+		Text: "@bob.example.com hello",
+		Reply: &appbsky.FeedPost_ReplyRef{
+			Parent: &comatproto.RepoStrongRef{Uri: "at://did:plc:parent/app.bsky.feed.post/abc", Cid: "bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm"},
+			Root:   &comatproto.RepoStrongRef{Uri: "at://did:plc:root/app.bsky.feed.post/abc", Cid: "bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm"},
+		},
+		Facets: []*appbsky.RichtextFacet{
+			{
+				Index: &appbsky.RichtextFacet_ByteSlice{ByteStart: 0, ByteEnd: 16},
+				Features: []*appbsky.RichtextFacet_Features_Elem{
+					{RichtextFacet_Mention: &appbsky.RichtextFacet_Mention{Did: "did:plc:bob"}},
+				},
+			},
+		},
+	}
+	op := engine.RecordOp{
+		Action:     engine.CreateOp,
+		DID:        am1.Identity.DID,
+		Collection: syntax.NSID("app.bsky.feed.post"),
+		RecordKey:  syntax.RecordKey("abc123"),
+	}
+	c1 := engine.NewRecordContext(ctx, &eng, am1, op)
+	assert.NoError(InteractionGraphPostRule(&c1, &p1))
+	eff1 := engine.ExtractEffects(&c1.BaseContext)
+	assert.ElementsMatch([]engine.GraphEdgeRef{
+		{Kind: graphstore.KindReply, Src: "did:plc:abc111", Dst: "did:plc:parent"},
+		{Kind: graphstore.KindMention, Src: "did:plc:abc111", Dst: "did:plc:bob"},
+	}, eff1.GraphEdges)
+}
+
+func TestCoordinatedReplyPostRule(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	eng := engine.EngineTestFixture()
+	now := time.Now()
+	am1 := automod.AccountMeta{
+		Identity: &identity.Identity{
+			DID:    syntax.DID("did:plc:brigade0"),
+			Handle: syntax.Handle("handle.example.com"),
+		},
+		CreatedAt: &now,
+	}
+	p1 := appbsky.FeedPost{
+		Text: "reply",
+		Reply: &appbsky.FeedPost_ReplyRef{
+			Parent: &comatproto.RepoStrongRef{Uri: "at://did:plc:victim0/app.bsky.feed.post/abc", Cid: "bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm"},
+			Root:   &comatproto.RepoStrongRef{Uri: "at://did:plc:victim0/app.bsky.feed.post/abc", Cid: "bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm"},
+		},
+	}
+	op := engine.RecordOp{
+		Action:     engine.CreateOp,
+		DID:        am1.Identity.DID,
+		Collection: syntax.NSID("app.bsky.feed.post"),
+		RecordKey:  syntax.RecordKey("abc123"),
+	}
+
+	// no graph activity yet
+	c1 := engine.NewRecordContext(ctx, &eng, am1, op)
+	assert.NoError(CoordinatedReplyPostRule(&c1, &p1))
+	assert.Empty(engine.ExtractEffects(&c1.BaseContext).AccountFlags)
+
+	// a group of accounts replying to the same victims, and to each other
+	for i := 0; i < 4; i++ {
+		src := fmt.Sprintf("did:plc:brigade%d", i)
+		for j := 0; j < 6; j++ {
+			assert.NoError(eng.Graph.AddEdge(ctx, graphstore.KindReply, src, fmt.Sprintf("did:plc:victim%d", j)))
+		}
+		for j := 0; j < 4; j++ {
+			if i != j {
+				assert.NoError(eng.Graph.AddEdge(ctx, graphstore.KindReply, src, fmt.Sprintf("did:plc:brigade%d", j)))
+			}
+		}
+	}
+
+	c2 := engine.NewRecordContext(ctx, &eng, am1, op)
+	assert.NoError(CoordinatedReplyPostRule(&c2, &p1))
+	assert.Equal([]string{"coordinated-replies"}, engine.ExtractEffects(&c2.BaseContext).AccountFlags)
+}
@@ -0,0 +1,34 @@
+package rules
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bluesky-social/indigo/automod"
+	"github.com/bluesky-social/indigo/automod/countstore"
+)
+
+var actionedDeleteDailyThreshold = 5
+
+var _ automod.DeleteRuleFunc = DeleteActionedRecordRule
+
+// looks for accounts which delete content soon after it has been labeled, flagged, or reported by rules; eg, spam accounts cleaning up after being noticed, or evading takedowns.
+func DeleteActionedRecordRule(c *automod.RecordContext, prev *automod.RecordHistory) error {
+	if prev == nil || !prev.Actioned() {
+		return nil
+	}
+	if prev.Lifetime(time.Now()) > 24*time.Hour {
+		return nil
+	}
+
+	did := c.Account.Identity.DID.String()
+	c.Increment("delete-actioned", did)
+	count := c.GetCount("delete-actioned", did, countstore.PeriodDay) + 1
+	if count == actionedDeleteDailyThreshold {
+		c.Logger.Info("mass-delete-actioned", "deleted-today", count)
+		c.AddAccountFlag("mass-delete-actioned")
+		c.ReportAccount(automod.ReportReasonSpam, fmt.Sprintf("deleted %d records soon after rule actions today (so far)", count))
+		c.Notify("slack")
+	}
+	return nil
+}
@@ -1,14 +1,11 @@
 package rules
 
 import (
-	"bytes"
 	"context"
 	"testing"
 
-	appbsky "github.com/bluesky-social/indigo/api/bsky"
-	"github.com/bluesky-social/indigo/atproto/identity"
-	"github.com/bluesky-social/indigo/atproto/syntax"
 	"github.com/bluesky-social/indigo/automod"
+	"github.com/bluesky-social/indigo/automod/automodtest"
 	"github.com/bluesky-social/indigo/automod/engine"
 
 	"github.com/stretchr/testify/assert"
@@ -19,42 +16,33 @@ func TestBadHashtagPostRule(t *testing.T) {
 	ctx := context.Background()
 
 	eng := engine.EngineTestFixture()
-	am1 := automod.AccountMeta{
-		Identity: &identity.Identity{
-			DID:    syntax.DID("did:plc:abc111"),
-			Handle: syntax.Handle("handle.example.com"),
-		},
-	}
-	cid1 := syntax.CID("cid123")
-	p1 := appbsky.FeedPost{
-		Text: "some post blah",
-	}
-	p1buf := new(bytes.Buffer)
-	assert.NoError(p1.MarshalCBOR(p1buf))
-	p1cbor := p1buf.Bytes()
-	op := engine.RecordOp{
-		Action:     engine.CreateOp,
-		DID:        am1.Identity.DID,
-		Collection: syntax.NSID("app.bsky.feed.post"),
-		RecordKey:  syntax.RecordKey("abc123"),
-		CID:        &cid1,
-		RecordCBOR: p1cbor,
-	}
+	g := automodtest.NewFixtureGen(1)
+	ident := g.Identity()
+	am1 := automod.AccountMeta{Identity: &ident}
+
+	p1 := g.Post(automodtest.PostSpec{Hashtags: []string{}, Tags: []string{}})
+	op, err := g.CreateOp(ident.DID, "app.bsky.feed.post", p1)
+	assert.NoError(err)
 	c1 := engine.NewRecordContext(ctx, &eng, am1, op)
-	assert.NoError(BadHashtagsPostRule(&c1, &p1))
+	assert.NoError(BadHashtagsPostRule(&c1, p1))
 	eff1 := engine.ExtractEffects(&c1.BaseContext)
 	assert.Empty(eff1.RecordFlags)
 
-	p2 := appbsky.FeedPost{
-		Text: "some post blah",
-		Tags: []string{"one", "slur"},
-	}
-	p2buf := new(bytes.Buffer)
-	assert.NoError(p2.MarshalCBOR(p2buf))
-	p2cbor := p2buf.Bytes()
-	op.RecordCBOR = p2cbor
+	// bad word as an outline tag
+	p2 := g.Post(automodtest.PostSpec{Hashtags: []string{}, Tags: []string{"one", "slur"}})
+	op, err = g.CreateOp(ident.DID, "app.bsky.feed.post", p2)
+	assert.NoError(err)
 	c2 := engine.NewRecordContext(ctx, &eng, am1, op)
-	assert.NoError(BadHashtagsPostRule(&c2, &p2))
+	assert.NoError(BadHashtagsPostRule(&c2, p2))
 	eff2 := engine.ExtractEffects(&c2.BaseContext)
 	assert.NotEmpty(eff2.RecordFlags)
+
+	// bad word as a hashtag facet in the post text
+	p3 := g.Post(automodtest.PostSpec{Hashtags: []string{"slur"}, Tags: []string{}})
+	op, err = g.CreateOp(ident.DID, "app.bsky.feed.post", p3)
+	assert.NoError(err)
+	c3 := engine.NewRecordContext(ctx, &eng, am1, op)
+	assert.NoError(BadHashtagsPostRule(&c3, p3))
+	eff3 := engine.ExtractEffects(&c3.BaseContext)
+	assert.NotEmpty(eff3.RecordFlags)
 }
@@ -51,7 +51,7 @@ func AggressivePromotionRule(c *automod.RecordContext, post *appbsky.FeedPost) e
 
 	did := c.Account.Identity.DID.String()
 	uniqueReplies := c.GetCountDistinct("reply-to", did, countstore.PeriodDay)
-	if uniqueReplies >= 10 {
+	if float64(uniqueReplies) >= c.Threshold("promo-multi-reply", 10) {
 		c.AddAccountFlag("promo-multi-reply")
 		c.ReportAccount(automod.ReportReasonSpam, "possible aggressive self-promotion")
 		c.Notify("slack")
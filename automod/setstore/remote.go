@@ -0,0 +1,231 @@
+package setstore
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	toolsozone "github.com/bluesky-social/indigo/api/ozone"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// A remote source of named sets, periodically re-fetched by RemoteSetStore.
+type SetSource interface {
+	// Short human-readable description of the source, for logging
+	Name() string
+	Fetch(ctx context.Context) (map[string][]string, error)
+}
+
+// Fetches sets from a URL serving a JSON object of set names to string arrays (the same format as LoadFromFileJSON).
+//
+// If PublicKey is set, the sets must be signed: a base64-encoded signature of the exact response body is fetched from the same URL with ".sig" appended, and verified against the key.
+type URLSetSource struct {
+	URL       string
+	PublicKey crypto.PublicKey
+	Client    *http.Client
+}
+
+func (s *URLSetSource) Name() string {
+	return s.URL
+}
+
+func (s *URLSetSource) get(ctx context.Context, u string, limit int64) ([]byte, error) {
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: HTTP status %d", u, resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, limit))
+}
+
+// upper bound on the size of a remote set document
+const maxRemoteSetsSize = 64 << 20
+
+func (s *URLSetSource) Fetch(ctx context.Context) (map[string][]string, error) {
+	body, err := s.get(ctx, s.URL, maxRemoteSetsSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.PublicKey != nil {
+		rawSig, err := s.get(ctx, s.URL+".sig", 1024)
+		if err != nil {
+			return nil, fmt.Errorf("fetching set signature: %w", err)
+		}
+		sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(rawSig)))
+		if err != nil {
+			return nil, fmt.Errorf("decoding set signature: %w", err)
+		}
+		if err := s.PublicKey.HashAndVerify(body, sig); err != nil {
+			return nil, fmt.Errorf("invalid set signature from %s: %w", s.URL, err)
+		}
+	}
+
+	var sets map[string][]string
+	if err := json.Unmarshal(body, &sets); err != nil {
+		return nil, fmt.Errorf("parsing sets from %s: %w", s.URL, err)
+	}
+	return sets, nil
+}
+
+// Fetches named sets from an Ozone instance (tools.ozone.set.getValues).
+type OzoneSetSource struct {
+	Client *xrpc.Client
+	Names  []string
+}
+
+func (s *OzoneSetSource) Name() string {
+	return "ozone:" + s.Client.Host
+}
+
+func (s *OzoneSetSource) Fetch(ctx context.Context) (map[string][]string, error) {
+	out := make(map[string][]string, len(s.Names))
+	for _, name := range s.Names {
+		var vals []string
+		cursor := ""
+		for {
+			resp, err := toolsozone.SetGetValues(ctx, s.Client, cursor, 1000, name)
+			if err != nil {
+				return nil, fmt.Errorf("fetching ozone set %s: %w", name, err)
+			}
+			vals = append(vals, resp.Values...)
+			if resp.Cursor == nil || *resp.Cursor == "" || len(resp.Values) == 0 {
+				break
+			}
+			cursor = *resp.Cursor
+		}
+		out[name] = vals
+	}
+	return out, nil
+}
+
+// SetStore which merges local sets with sets periodically fetched from remote sources, so that multiple deployments can share curated lists.
+//
+// Sets with the same name from multiple sources are merged (union). Each refresh builds a complete new set map and swaps it in atomically, so lookups never see a partial update. If a source fails to fetch, the last good copy of its sets is kept.
+type RemoteSetStore struct {
+	Local   MemSetStore
+	Sources []SetSource
+	Logger  *slog.Logger
+
+	sets atomic.Pointer[map[string]map[string]bool]
+
+	// last successful fetch for each source, by index in Sources
+	lk       sync.Mutex
+	lastGood []map[string][]string
+}
+
+var _ SetStore = (*RemoteSetStore)(nil)
+
+func NewRemoteSetStore(local MemSetStore, sources []SetSource) *RemoteSetStore {
+	rs := &RemoteSetStore{
+		Local:    local,
+		Sources:  sources,
+		Logger:   slog.Default().With("system", "remote-setstore"),
+		lastGood: make([]map[string][]string, len(sources)),
+	}
+	rs.merge()
+	return rs
+}
+
+func (rs *RemoteSetStore) InSet(ctx context.Context, name, val string) (bool, error) {
+	sets := *rs.sets.Load()
+	set, ok := sets[name]
+	if !ok {
+		// NOTE: same as MemSetStore, returns false when entire set isn't found
+		return false, nil
+	}
+	return set[val], nil
+}
+
+// Fetches all sources and swaps in the merged result. Returns an error if any source failed, though sets from all other sources are still updated.
+func (rs *RemoteSetStore) Refresh(ctx context.Context) error {
+	var errs []string
+	for i, src := range rs.Sources {
+		sets, err := src.Fetch(ctx)
+		if err != nil {
+			rs.Logger.Warn("failed to fetch remote sets, keeping previous copy", "source", src.Name(), "err", err)
+			errs = append(errs, fmt.Sprintf("%s: %s", src.Name(), err))
+			continue
+		}
+		rs.lk.Lock()
+		rs.lastGood[i] = sets
+		rs.lk.Unlock()
+		rs.Logger.Debug("fetched remote sets", "source", src.Name(), "sets", len(sets))
+	}
+
+	rs.merge()
+
+	if len(errs) > 0 {
+		return fmt.Errorf("fetching remote sets: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// builds the merged set map from local sets and the last good copy of each source, and swaps it in
+func (rs *RemoteSetStore) merge() {
+	merged := make(map[string]map[string]bool, len(rs.Local.Sets))
+	add := func(name string, vals []string) {
+		m, ok := merged[name]
+		if !ok {
+			m = make(map[string]bool, len(vals))
+			merged[name] = m
+		}
+		for _, v := range vals {
+			m[v] = true
+		}
+	}
+
+	for name, set := range rs.Local.Sets {
+		m := make(map[string]bool, len(set))
+		for v := range set {
+			m[v] = true
+		}
+		merged[name] = m
+	}
+
+	rs.lk.Lock()
+	for _, sets := range rs.lastGood {
+		for name, vals := range sets {
+			add(name, vals)
+		}
+	}
+	rs.lk.Unlock()
+
+	rs.sets.Store(&merged)
+}
+
+// Refreshes remote sets every interval, until the context is cancelled.
+func (rs *RemoteSetStore) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := rs.Refresh(ctx); err != nil {
+				rs.Logger.Error("remote set refresh failed", "err", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package setstore
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+// serves a set document at /sets.json, and its signature at /sets.json.sig
+type testSetServer struct {
+	body   string
+	sig    string
+	status int
+}
+
+func (ts *testSetServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if ts.status != 0 {
+		w.WriteHeader(ts.status)
+		return
+	}
+	switch r.URL.Path {
+	case "/sets.json":
+		fmt.Fprint(w, ts.body)
+	case "/sets.json.sig":
+		fmt.Fprint(w, ts.sig)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func signSets(t *testing.T, priv crypto.PrivateKey, body string) string {
+	t.Helper()
+	sig, err := priv.HashAndSign([]byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+func TestURLSetSourceSignature(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	priv, err := crypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := priv.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"bad-words": ["hardr", "hardestr"]}`
+	ts := &testSetServer{body: body, sig: signSets(t, priv, body)}
+	srv := httptest.NewServer(ts)
+	defer srv.Close()
+
+	src := &URLSetSource{URL: srv.URL + "/sets.json", PublicKey: pub}
+	sets, err := src.Fetch(ctx)
+	assert.NoError(err)
+	assert.Equal([]string{"hardr", "hardestr"}, sets["bad-words"])
+
+	// body modified after signing
+	ts.body = `{"bad-words": ["hardr"]}`
+	_, err = src.Fetch(ctx)
+	assert.ErrorContains(err, "invalid set signature")
+
+	// signed by some other key
+	other, err := crypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.sig = signSets(t, other, ts.body)
+	_, err = src.Fetch(ctx)
+	assert.ErrorContains(err, "invalid set signature")
+
+	ts.sig = "not base64!"
+	_, err = src.Fetch(ctx)
+	assert.ErrorContains(err, "decoding set signature")
+
+	// without a key, signatures are not checked
+	unsigned := &URLSetSource{URL: srv.URL + "/sets.json"}
+	sets, err = unsigned.Fetch(ctx)
+	assert.NoError(err)
+	assert.Equal([]string{"hardr"}, sets["bad-words"])
+}
+
+func TestRemoteSetStoreKeepsLastGood(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	ts := &testSetServer{body: `{"bad-words": ["hardr"]}`}
+	srv := httptest.NewServer(ts)
+	defer srv.Close()
+
+	rs := NewRemoteSetStore(NewMemSetStore(), []SetSource{&URLSetSource{URL: srv.URL + "/sets.json"}})
+	ok, err := rs.InSet(ctx, "bad-words", "hardr")
+	assert.NoError(err)
+	assert.False(ok)
+
+	assert.NoError(rs.Refresh(ctx))
+	ok, err = rs.InSet(ctx, "bad-words", "hardr")
+	assert.NoError(err)
+	assert.True(ok)
+
+	// a failed fetch keeps the previous copy of the sets
+	ts.status = http.StatusInternalServerError
+	assert.Error(rs.Refresh(ctx))
+	ok, err = rs.InSet(ctx, "bad-words", "hardr")
+	assert.NoError(err)
+	assert.True(ok)
+
+	// as does a document which doesn't parse
+	ts.status = 0
+	ts.body = `{"bad-words": `
+	assert.Error(rs.Refresh(ctx))
+	ok, err = rs.InSet(ctx, "bad-words", "hardr")
+	assert.NoError(err)
+	assert.True(ok)
+
+	// a successful fetch replaces the previous copy entirely
+	ts.body = `{"bad-words": ["hardestr"]}`
+	assert.NoError(rs.Refresh(ctx))
+	ok, err = rs.InSet(ctx, "bad-words", "hardr")
+	assert.NoError(err)
+	assert.False(ok)
+	ok, err = rs.InSet(ctx, "bad-words", "hardestr")
+	assert.NoError(err)
+	assert.True(ok)
+}
+
+func TestRemoteSetStoreMerge(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	local := NewMemSetStore()
+	local.Sets["bad-words"] = map[string]bool{"local": true}
+	local.Sets["local-only"] = map[string]bool{"a": true}
+
+	first := httptest.NewServer(&testSetServer{body: `{"bad-words": ["one"], "remote-only": ["b"]}`})
+	defer first.Close()
+	failing := &testSetServer{status: http.StatusServiceUnavailable}
+	second := httptest.NewServer(failing)
+	defer second.Close()
+
+	rs := NewRemoteSetStore(local, []SetSource{
+		&URLSetSource{URL: first.URL + "/sets.json"},
+		&URLSetSource{URL: second.URL + "/sets.json"},
+	})
+
+	// one failing source doesn't prevent the others from being updated
+	err := rs.Refresh(ctx)
+	assert.ErrorContains(err, second.URL)
+	assert.NotContains(err.Error(), first.URL)
+
+	failing.status = 0
+	failing.body = `{"bad-words": ["two", "one"]}`
+	assert.NoError(rs.Refresh(ctx))
+
+	// sets with the same name are the union of local and all sources
+	for _, val := range []string{"local", "one", "two"} {
+		ok, err := rs.InSet(ctx, "bad-words", val)
+		assert.NoError(err)
+		assert.True(ok, val)
+	}
+	ok, err := rs.InSet(ctx, "local-only", "a")
+	assert.NoError(err)
+	assert.True(ok)
+	ok, err = rs.InSet(ctx, "remote-only", "b")
+	assert.NoError(err)
+	assert.True(ok)
+	ok, err = rs.InSet(ctx, "remote-only", "a")
+	assert.NoError(err)
+	assert.False(ok)
+
+	// merging doesn't modify the local sets
+	assert.Equal(map[string]bool{"local": true}, local.Sets["bad-words"])
+}
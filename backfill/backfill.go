@@ -6,19 +6,17 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
-	"net/http"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/bluesky-social/indigo/api/atproto"
 	"github.com/bluesky-social/indigo/atproto/identity"
-	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/atproto/identity/pdshealth"
 	"github.com/bluesky-social/indigo/repo"
 	"github.com/bluesky-social/indigo/repomgr"
 
 	"github.com/ipfs/go-cid"
-	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.opentelemetry.io/otel"
 	"golang.org/x/sync/semaphore"
 	"golang.org/x/time/rate"
@@ -70,21 +68,47 @@ type Backfiller struct {
 
 	// Number of backfills to process in parallel
 	ParallelBackfills int
-	// Number of records to process in parallel for each backfill
+	// Number of records to hand to HandleCreateRecord in parallel, across all backfills
 	ParallelRecordCreates int
+	// Number of workers for each of the other stages of the CAR processing
+	// pipeline (see pipeline.go). Zero for the defaults
+	ParallelDownloads int
+	ParallelVerifies  int
+	ParallelDecodes   int
+	// Number of repos which may be queued between pipeline stages
+	PipelineBuffer int
+	// Approximate limit on the CAR bytes held in memory by the pipeline
+	MaxInflightBytes int64
+	// If set, repo commit signatures are checked against the account's signing key
+	VerifyCommitSignatures bool
 	// Prefix match for records to backfill i.e. app.bsky.feed.app/
 	// If empty, all records will be backfilled
 	NSIDFilter string
 	RelayHost  string
 
+	// If set, blobs are enumerated and downloaded for each repo alongside records
+	BlobStore BlobStore
+	// Blobs larger than this many bytes are not downloaded. Zero for no limit
+	MaxBlobSize int64
+	// Number of blobs to download in parallel for each backfill
+	ParallelBlobDownloads int
+
 	syncLimiter *rate.Limiter
+	blobLimiter *rate.Limiter
 
 	magicHeaderKey string
 	magicHeaderVal string
 
 	stop chan chan struct{}
 
+	pipelineOnce sync.Once
+	pipeline     *pipeline
+
 	Directory identity.Directory
+
+	// If set, repos aren't fetched from PDS hosts it reports as unhealthy;
+	// their jobs fail and are retried later instead
+	HostHealth *pdshealth.Registry
 }
 
 var (
@@ -116,6 +140,16 @@ type BackfillOptions struct {
 	NSIDFilter            string
 	SyncRequestsPerSecond int
 	RelayHost             string
+	MaxBlobSize           int64
+	ParallelBlobDownloads int
+	BlobRequestsPerSecond int
+
+	ParallelDownloads      int
+	ParallelVerifies       int
+	ParallelDecodes        int
+	PipelineBuffer         int
+	MaxInflightBytes       int64
+	VerifyCommitSignatures bool
 }
 
 func DefaultBackfillOptions() *BackfillOptions {
@@ -125,6 +159,14 @@ func DefaultBackfillOptions() *BackfillOptions {
 		NSIDFilter:            "",
 		SyncRequestsPerSecond: 2,
 		RelayHost:             "https://bsky.network",
+		MaxBlobSize:           100_000_000,
+		ParallelBlobDownloads: 4,
+		BlobRequestsPerSecond: 10,
+		ParallelDownloads:     10,
+		ParallelVerifies:      4,
+		ParallelDecodes:       4,
+		PipelineBuffer:        10,
+		MaxInflightBytes:      1 << 30,
 	}
 }
 
@@ -141,18 +183,27 @@ func NewBackfiller(
 		opts = DefaultBackfillOptions()
 	}
 	return &Backfiller{
-		Name:                  name,
-		Store:                 store,
-		HandleCreateRecord:    handleCreate,
-		HandleUpdateRecord:    handleUpdate,
-		HandleDeleteRecord:    handleDelete,
-		ParallelBackfills:     opts.ParallelBackfills,
-		ParallelRecordCreates: opts.ParallelRecordCreates,
-		NSIDFilter:            opts.NSIDFilter,
-		syncLimiter:           rate.NewLimiter(rate.Limit(opts.SyncRequestsPerSecond), 1),
-		RelayHost:             opts.RelayHost,
-		stop:                  make(chan chan struct{}, 1),
-		Directory:             identity.DefaultDirectory(),
+		Name:                   name,
+		Store:                  store,
+		HandleCreateRecord:     handleCreate,
+		HandleUpdateRecord:     handleUpdate,
+		HandleDeleteRecord:     handleDelete,
+		ParallelBackfills:      opts.ParallelBackfills,
+		ParallelRecordCreates:  opts.ParallelRecordCreates,
+		NSIDFilter:             opts.NSIDFilter,
+		syncLimiter:            rate.NewLimiter(rate.Limit(opts.SyncRequestsPerSecond), 1),
+		RelayHost:              opts.RelayHost,
+		MaxBlobSize:            opts.MaxBlobSize,
+		ParallelBlobDownloads:  opts.ParallelBlobDownloads,
+		blobLimiter:            rate.NewLimiter(rate.Limit(opts.BlobRequestsPerSecond), 1),
+		ParallelDownloads:      opts.ParallelDownloads,
+		ParallelVerifies:       opts.ParallelVerifies,
+		ParallelDecodes:        opts.ParallelDecodes,
+		PipelineBuffer:         opts.PipelineBuffer,
+		MaxInflightBytes:       opts.MaxInflightBytes,
+		VerifyCommitSignatures: opts.VerifyCommitSignatures,
+		stop:                   make(chan chan struct{}, 1),
+		Directory:              identity.DefaultDirectory(),
 	}
 }
 
@@ -288,71 +339,6 @@ func (b *Backfiller) FlushBuffer(ctx context.Context, job Job) int {
 	return processed
 }
 
-type recordQueueItem struct {
-	recordPath string
-	nodeCid    cid.Cid
-}
-
-type recordResult struct {
-	recordPath string
-	err        error
-}
-
-// Fetches a repo CAR file over HTTP from the indicated host. If successful, parses the CAR and returns repo.Repo
-func (b *Backfiller) fetchRepo(ctx context.Context, did, since, host string) (*repo.Repo, error) {
-	url := fmt.Sprintf("%s/xrpc/com.atproto.sync.getRepo?did=%s", host, did)
-
-	if since != "" {
-		url = url + fmt.Sprintf("&since=%s", since)
-	}
-
-	// GET and CAR decode the body
-	client := &http.Client{
-		Transport: otelhttp.NewTransport(http.DefaultTransport),
-		Timeout:   600 * time.Second,
-	}
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("Accept", "application/vnd.ipld.car")
-	req.Header.Set("User-Agent", fmt.Sprintf("atproto-backfill-%s/0.0.1", b.Name))
-	if b.magicHeaderKey != "" && b.magicHeaderVal != "" {
-		req.Header.Set(b.magicHeaderKey, b.magicHeaderVal)
-	}
-
-	b.syncLimiter.Wait(ctx)
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		reason := "unknown error"
-		if resp.StatusCode == http.StatusBadRequest {
-			reason = "repo not found"
-		} else {
-			reason = resp.Status
-		}
-		return nil, fmt.Errorf("failed to get repo: %s", reason)
-	}
-
-	instrumentedReader := instrumentedReader{
-		source:  resp.Body,
-		counter: backfillBytesProcessed.WithLabelValues(b.Name),
-	}
-
-	defer instrumentedReader.Close()
-
-	repo, err := repo.ReadRepoFromCar(ctx, instrumentedReader)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse repo from CAR file: %w", err)
-	}
-	return repo, nil
-}
-
 // BackfillRepo backfills a repo
 func (b *Backfiller) BackfillRepo(ctx context.Context, job Job) (string, error) {
 	ctx, span := tracer.Start(ctx, "BackfillRepo")
@@ -368,90 +354,35 @@ func (b *Backfiller) BackfillRepo(ctx context.Context, job Job) (string, error)
 	}
 	log.Info(fmt.Sprintf("processing backfill for %s", repoDID))
 
-	// first try with Relay endpoint
-	r, err := b.fetchRepo(ctx, repoDID, job.Rev(), b.RelayHost)
-	if err != nil {
-		slog.Warn("repo CAR fetch from relay failed", "did", repoDID, "since", job.Rev(), "relayHost", b.RelayHost, "err", err)
-		// fallback to direct PDS fetch
-		ident, err := b.Directory.LookupDID(ctx, syntax.DID(repoDID))
-		if err != nil {
-			return "failed resolving DID to PDS repo", fmt.Errorf("resolving DID for PDS repo fetch: %w", err)
-		}
-		pdsHost := ident.PDSEndpoint()
-		if pdsHost == "" {
-			return "DID document missing PDS endpoint", fmt.Errorf("no PDS endpoint for DID: %s", repoDID)
-		}
-		r, err = b.fetchRepo(ctx, repoDID, job.Rev(), pdsHost)
-		if err != nil {
-			slog.Warn("repo CAR fetch from PDS failed", "did", repoDID, "since", job.Rev(), "pdsHost", pdsHost, "err", err)
-			return "repo CAR fetch from PDS failed", err
-		}
-		slog.Info("repo CAR fetch from PDS successful", "did", repoDID, "since", job.Rev(), "pdsHost", pdsHost, "err", err)
-	}
+	b.pipelineOnce.Do(b.startPipeline)
 
-	numRecords := 0
-	numRoutines := b.ParallelRecordCreates
-	recordQueue := make(chan recordQueueItem, numRoutines)
-	recordResults := make(chan recordResult, numRoutines)
-
-	// Producer routine
-	go func() {
-		defer close(recordQueue)
-		if err := r.ForEach(ctx, b.NSIDFilter, func(recordPath string, nodeCid cid.Cid) error {
-			numRecords++
-			recordQueue <- recordQueueItem{recordPath: recordPath, nodeCid: nodeCid}
-			return nil
-		}); err != nil {
-			log.Error("failed to iterate records in repo", "err", err)
-		}
-	}()
-
-	rev := r.SignedCommit().Rev
-
-	// Consumer routines
-	wg := sync.WaitGroup{}
-	for i := 0; i < numRoutines; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for item := range recordQueue {
-				blk, err := r.Blockstore().Get(ctx, item.nodeCid)
-				if err != nil {
-					recordResults <- recordResult{recordPath: item.recordPath, err: fmt.Errorf("failed to get blocks for record: %w", err)}
-					continue
-				}
-
-				raw := blk.RawData()
-
-				err = b.HandleCreateRecord(ctx, repoDID, rev, item.recordPath, &raw, &item.nodeCid)
-				if err != nil {
-					recordResults <- recordResult{recordPath: item.recordPath, err: fmt.Errorf("failed to handle create record: %w", err)}
-					continue
-				}
-
-				backfillRecordsProcessed.WithLabelValues(b.Name).Inc()
-				recordResults <- recordResult{recordPath: item.recordPath, err: err}
-			}
-		}()
+	pr := &pipelineRepo{
+		ctx:   ctx,
+		did:   repoDID,
+		since: job.Rev(),
+		log:   log,
+		done:  make(chan struct{}),
+	}
+	b.pipeline.submit(pr)
+	<-pr.done
+	if pr.err != nil {
+		return pr.failState, pr.err
 	}
 
-	resultWG := sync.WaitGroup{}
-	resultWG.Add(1)
-	// Handle results
-	go func() {
-		defer resultWG.Done()
-		for result := range recordResults {
-			if result.err != nil {
-				log.Error("Error processing record", "record", result.recordPath, "error", result.err)
-			}
+	numBlobs := 0
+	if b.BlobStore != nil {
+		// blobs already in the store are skipped, so a retried job picks up where it left off
+		bres, err := b.BackfillBlobs(ctx, repoDID, job.Rev())
+		if err != nil {
+			log.Error("failed to backfill blobs", "err", err)
 		}
-	}()
-
-	wg.Wait()
-	close(recordResults)
-	resultWG.Wait()
+		if bres != nil {
+			numBlobs = bres.Downloaded
+			log.Info("blob backfill complete", "listed", bres.Listed, "downloaded", bres.Downloaded, "skipped", bres.Skipped, "failed", bres.Failed, "bytes", bres.Bytes)
+		}
+	}
 
-	if err := job.SetRev(ctx, r.SignedCommit().Rev); err != nil {
+	if err := job.SetRev(ctx, pr.rev); err != nil {
 		log.Error("failed to update rev after backfilling repo", "err", err)
 	}
 
@@ -460,7 +391,8 @@ func (b *Backfiller) BackfillRepo(ctx context.Context, job Job) (string, error)
 
 	log.Info("backfill complete",
 		"buffered_records_processed", numProcessed,
-		"records_backfilled", numRecords,
+		"records_backfilled", pr.numRecords,
+		"blobs_backfilled", numBlobs,
 		"duration", time.Since(start),
 	)
 
@@ -0,0 +1,250 @@
+package backfill
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	"github.com/ipfs/go-cid"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// BlobStore is an interface for storing blobs downloaded during backfill
+type BlobStore interface {
+	// HasBlob returns true if the blob has already been stored. Blobs which
+	// are already present are skipped, which allows an interrupted or retried
+	// backfill to resume without re-downloading.
+	HasBlob(ctx context.Context, did string, c cid.Cid) (bool, error)
+	// PutBlob stores a blob. The contents have already been verified against the CID.
+	PutBlob(ctx context.Context, did string, c cid.Cid, data []byte) error
+}
+
+// ErrBlobTooLarge is returned when a blob exceeds the configured MaxBlobSize
+var ErrBlobTooLarge = errors.New("blob exceeds max size")
+
+// BlobBackfillResult summarizes the blobs processed for a single repo
+type BlobBackfillResult struct {
+	Listed     int
+	Skipped    int
+	Downloaded int
+	Failed     int
+	Bytes      int64
+}
+
+// BackfillBlobs enumerates the blobs in a repo (optionally only those added
+// since the given rev) and downloads any which are not already in the
+// BlobStore. Individual blob failures are logged and counted, but do not fail
+// the whole repo.
+func (b *Backfiller) BackfillBlobs(ctx context.Context, did, since string) (*BlobBackfillResult, error) {
+	ctx, span := tracer.Start(ctx, "BackfillBlobs")
+	defer span.End()
+
+	log := slog.With("source", "backfiller_backfill_blobs", "repo", did)
+
+	// blobs are only served by the PDS, not the relay
+	ident, err := b.Directory.LookupDID(ctx, syntax.DID(did))
+	if err != nil {
+		return nil, fmt.Errorf("resolving DID for blob backfill: %w", err)
+	}
+	pdsHost := ident.PDSEndpoint()
+	if pdsHost == "" {
+		return nil, fmt.Errorf("no PDS endpoint for DID: %s", did)
+	}
+
+	client := &xrpc.Client{
+		Client: &http.Client{
+			Transport: otelhttp.NewTransport(http.DefaultTransport),
+			Timeout:   60 * time.Second,
+		},
+		Host: pdsHost,
+	}
+
+	numRoutines := b.ParallelBlobDownloads
+	if numRoutines <= 0 {
+		numRoutines = 1
+	}
+	blobQueue := make(chan cid.Cid, numRoutines)
+
+	var resLk sync.Mutex
+	res := &BlobBackfillResult{}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < numRoutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range blobQueue {
+				n, skipped, err := b.backfillBlob(ctx, client, did, c)
+				resLk.Lock()
+				switch {
+				case err != nil:
+					log.Warn("failed to backfill blob", "cid", c, "err", err)
+					res.Failed++
+					backfillBlobsProcessed.WithLabelValues(b.Name, "failed").Inc()
+				case skipped:
+					res.Skipped++
+					backfillBlobsProcessed.WithLabelValues(b.Name, "skipped").Inc()
+				default:
+					res.Downloaded++
+					res.Bytes += n
+					backfillBlobsProcessed.WithLabelValues(b.Name, "downloaded").Inc()
+				}
+				resLk.Unlock()
+			}
+		}()
+	}
+
+	var listErr error
+	cursor := ""
+	for {
+		b.syncLimiter.Wait(ctx)
+		out, err := atproto.SyncListBlobs(ctx, client, cursor, did, 1000, since)
+		if err != nil {
+			listErr = fmt.Errorf("listing blobs: %w", err)
+			break
+		}
+		for _, s := range out.Cids {
+			c, err := cid.Decode(s)
+			if err != nil {
+				log.Warn("invalid blob CID in listBlobs response", "cid", s, "err", err)
+				continue
+			}
+			res.Listed++
+			blobQueue <- c
+		}
+		if out.Cursor == nil || *out.Cursor == "" || len(out.Cids) == 0 {
+			break
+		}
+		cursor = *out.Cursor
+	}
+	close(blobQueue)
+	wg.Wait()
+
+	return res, listErr
+}
+
+// downloads and stores a single blob. returns the number of bytes stored, or
+// true if the blob was already present
+func (b *Backfiller) backfillBlob(ctx context.Context, client *xrpc.Client, did string, c cid.Cid) (int64, bool, error) {
+	has, err := b.BlobStore.HasBlob(ctx, did, c)
+	if err != nil {
+		return 0, false, fmt.Errorf("checking blob store: %w", err)
+	}
+	if has {
+		return 0, true, nil
+	}
+
+	u := fmt.Sprintf("%s/xrpc/com.atproto.sync.getBlob?did=%s&cid=%s", client.Host, url.QueryEscape(did), url.QueryEscape(c.String()))
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	req.Header.Set("User-Agent", fmt.Sprintf("atproto-backfill-%s/0.0.1", b.Name))
+
+	if b.blobLimiter != nil {
+		b.blobLimiter.Wait(ctx)
+	}
+
+	resp, err := client.Client.Do(req)
+	if err != nil {
+		return 0, false, fmt.Errorf("fetching blob: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, false, fmt.Errorf("fetching blob: %s", resp.Status)
+	}
+
+	max := b.MaxBlobSize
+	if max > 0 && resp.ContentLength > max {
+		return 0, false, fmt.Errorf("%w: %d bytes", ErrBlobTooLarge, resp.ContentLength)
+	}
+
+	var body io.Reader = resp.Body
+	if max > 0 {
+		body = io.LimitReader(resp.Body, max+1)
+	}
+	data, err := io.ReadAll(instrumentedReader{
+		source:  io.NopCloser(body),
+		counter: backfillBlobBytes.WithLabelValues(b.Name),
+	})
+	if err != nil {
+		return 0, false, fmt.Errorf("reading blob: %w", err)
+	}
+	if max > 0 && int64(len(data)) > max {
+		return 0, false, fmt.Errorf("%w: more than %d bytes", ErrBlobTooLarge, max)
+	}
+
+	if err := repo.VerifyBlob(repo.BlobRef{Cid: c, Size: -1}, data, nil); err != nil {
+		return 0, false, err
+	}
+
+	if err := b.BlobStore.PutBlob(ctx, did, c, data); err != nil {
+		return 0, false, fmt.Errorf("storing blob: %w", err)
+	}
+	return int64(len(data)), false, nil
+}
+
+// DiskBlobStore is a BlobStore which writes each blob to a file at
+// <dir>/<did>/<cid>
+type DiskBlobStore struct {
+	dir string
+}
+
+var _ BlobStore = (*DiskBlobStore)(nil)
+
+func NewDiskBlobStore(dir string) (*DiskBlobStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &DiskBlobStore{dir: dir}, nil
+}
+
+func (s *DiskBlobStore) blobPath(did string, c cid.Cid) string {
+	return filepath.Join(s.dir, did, c.String())
+}
+
+func (s *DiskBlobStore) HasBlob(ctx context.Context, did string, c cid.Cid) (bool, error) {
+	_, err := os.Stat(s.blobPath(did, c))
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return false, err
+}
+
+func (s *DiskBlobStore) PutBlob(ctx context.Context, did string, c cid.Cid, data []byte) error {
+	p := s.blobPath(did, c)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	// write to a temporary file and rename, so partially written blobs are never visible
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".tmp-"+c.String())
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
@@ -0,0 +1,107 @@
+package backfill_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/backfill"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+func TestBackfillBlobs(t *testing.T) {
+	ctx := context.Background()
+	did := "did:plc:abc111"
+
+	blobs := map[string][]byte{}
+	var cids []string
+	for _, data := range [][]byte{[]byte("first blob"), []byte("second blob"), make([]byte, 2048)} {
+		c, err := cid.NewPrefixV1(cid.Raw, multihash.SHA2_256).Sum(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blobs[c.String()] = data
+		cids = append(cids, c.String())
+	}
+	// a blob whose contents don't match its CID
+	badCid, _ := cid.NewPrefixV1(cid.Raw, multihash.SHA2_256).Sum([]byte("expected"))
+	blobs[badCid.String()] = []byte("tampered")
+	cids = append(cids, badCid.String())
+
+	downloads := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/xrpc/com.atproto.sync.listBlobs":
+			// page through results two at a time
+			start := 0
+			if r.URL.Query().Get("cursor") != "" {
+				start = 2
+			}
+			end := min(start+2, len(cids))
+			out := map[string]any{"cids": cids[start:end]}
+			if end < len(cids) {
+				out["cursor"] = "next"
+			}
+			json.NewEncoder(w).Encode(out)
+		case "/xrpc/com.atproto.sync.getBlob":
+			downloads++
+			w.Write(blobs[r.URL.Query().Get("cid")])
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	dir := identity.NewMockDirectory()
+	dir.Insert(identity.Identity{
+		DID: syntax.DID(did),
+		Services: map[string]identity.Service{
+			"atproto_pds": {Type: "AtprotoPersonalDataServer", URL: srv.URL},
+		},
+	})
+
+	store, err := backfill.NewDiskBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := backfill.DefaultBackfillOptions()
+	opts.MaxBlobSize = 1024
+	bf := backfill.NewBackfiller("blob-test", nil, nil, nil, nil, opts)
+	bf.Directory = &dir
+	bf.BlobStore = store
+
+	res, err := bf.BackfillBlobs(ctx, did, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Listed != 4 || res.Downloaded != 2 || res.Failed != 2 {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+
+	// running again only retries the blobs which failed
+	downloads = 0
+	res, err = bf.BackfillBlobs(ctx, did, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Skipped != 2 || res.Failed != 2 || downloads != 2 {
+		t.Fatalf("unexpected result on resume: %+v (downloads=%d)", res, downloads)
+	}
+
+	c0, _ := cid.Decode(cids[0])
+	has, err := store.HasBlob(ctx, did, c0)
+	if err != nil || !has {
+		t.Fatalf("expected blob to be stored: %v", err)
+	}
+	has, err = store.HasBlob(ctx, did, badCid)
+	if err != nil || has {
+		t.Fatalf("tampered blob should not be stored: %v", err)
+	}
+}
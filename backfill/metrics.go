@@ -29,3 +29,39 @@ var backfillBytesProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
 	Name: "backfill_bytes_processed_total",
 	Help: "The total number of backfill bytes processed",
 }, []string{"backfiller_name"})
+
+var backfillBlobsProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "backfill_blobs_processed_total",
+	Help: "The total number of backfill blobs processed, by outcome",
+}, []string{"backfiller_name", "status"})
+
+var backfillBlobBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "backfill_blob_bytes_total",
+	Help: "The total number of blob bytes downloaded during backfill",
+}, []string{"backfiller_name"})
+
+var backfillPipelineStageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "backfill_pipeline_stage_duration_seconds",
+	Help:    "Time spent on each item in a stage of the backfill pipeline",
+	Buckets: prometheus.ExponentialBuckets(0.001, 4, 10),
+}, []string{"backfiller_name", "stage"})
+
+var backfillPipelineStageItems = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "backfill_pipeline_stage_items_total",
+	Help: "The total number of items processed by each stage of the backfill pipeline, by outcome",
+}, []string{"backfiller_name", "stage", "status"})
+
+var backfillPipelineQueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "backfill_pipeline_queue_depth",
+	Help: "The number of items waiting for each stage of the backfill pipeline",
+}, []string{"backfiller_name", "stage"})
+
+var backfillPipelineInflightBytes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "backfill_pipeline_inflight_bytes",
+	Help: "The number of repo CAR bytes held in memory by the backfill pipeline",
+}, []string{"backfiller_name"})
+
+var backfillUnhealthyHostSkips = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "backfill_unhealthy_host_skips",
+	Help: "The number of repo fetches put off because the account's PDS was unhealthy",
+}, []string{"backfiller_name"})
@@ -0,0 +1,375 @@
+package backfill
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/repo"
+
+	"github.com/ipfs/go-cid"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"golang.org/x/sync/semaphore"
+)
+
+// Repo CARs are processed in a staged pipeline:
+//
+//	download -> verify -> decode -> sink
+//
+// Each stage has its own pool of workers, and stages are connected by bounded
+// channels, so a slow stage applies backpressure to the ones before it
+// instead of letting work pile up in memory. Stages are shared by all of the
+// repos being backfilled at once.
+//
+// The CAR bytes (and, once parsed, the repo blocks) for a repo count against
+// MaxInflightBytes from the end of the download until the last of the repo's
+// records has been through the sink. Download workers wait for budget before
+// fetching more, so at most MaxInflightBytes plus one CAR per download worker
+// is held in memory at a time.
+
+const (
+	stageDownload = "download"
+	stageVerify   = "verify"
+	stageDecode   = "decode"
+	stageSink     = "sink"
+)
+
+// ErrRepoDIDMismatch is returned when a fetched repo's commit is for a different DID than requested
+var ErrRepoDIDMismatch = errors.New("repo commit DID does not match requested DID")
+
+// pipelineRepo is a single repo passing through the pipeline
+type pipelineRepo struct {
+	ctx   context.Context
+	did   string
+	since string
+	log   *slog.Logger
+
+	car      []byte
+	reserved int64
+	r        *repo.Repo
+
+	rev        string
+	numRecords int
+	// records handed to the sink which haven't been handled yet
+	pending sync.WaitGroup
+
+	// set if the repo failed before reaching the sink
+	failState string
+	err       error
+
+	done chan struct{}
+}
+
+type pipelineRecord struct {
+	p    *pipelineRepo
+	path string
+	cid  cid.Cid
+	raw  []byte
+}
+
+type pipeline struct {
+	b *Backfiller
+
+	downloads chan *pipelineRepo
+	verifies  chan *pipelineRepo
+	decodes   chan *pipelineRepo
+	records   chan *pipelineRecord
+
+	mem      *semaphore.Weighted
+	memLimit int64
+}
+
+func orDefault[T int | int64](v, def T) T {
+	if v <= 0 {
+		return def
+	}
+	return v
+}
+
+// starts the pipeline workers. the pipeline lives as long as the Backfiller
+func (b *Backfiller) startPipeline() {
+	def := DefaultBackfillOptions()
+	buf := orDefault(b.PipelineBuffer, def.PipelineBuffer)
+
+	p := &pipeline{
+		b:         b,
+		downloads: make(chan *pipelineRepo, buf),
+		verifies:  make(chan *pipelineRepo, buf),
+		decodes:   make(chan *pipelineRepo, buf),
+		// records are small and numerous; give the sink a little more slack
+		records:  make(chan *pipelineRecord, buf*10),
+		memLimit: orDefault(b.MaxInflightBytes, def.MaxInflightBytes),
+	}
+	p.mem = semaphore.NewWeighted(p.memLimit)
+
+	for i := 0; i < orDefault(b.ParallelDownloads, def.ParallelDownloads); i++ {
+		go p.runStage(stageDownload, p.downloads, p.download)
+	}
+	for i := 0; i < orDefault(b.ParallelVerifies, def.ParallelVerifies); i++ {
+		go p.runStage(stageVerify, p.verifies, p.verify)
+	}
+	for i := 0; i < orDefault(b.ParallelDecodes, def.ParallelDecodes); i++ {
+		go p.runStage(stageDecode, p.decodes, p.decode)
+	}
+	for i := 0; i < orDefault(b.ParallelRecordCreates, def.ParallelRecordCreates); i++ {
+		go p.runSink()
+	}
+
+	b.pipeline = p
+}
+
+// submit queues a repo at the start of the pipeline
+func (p *pipeline) submit(pr *pipelineRepo) {
+	p.enqueue(stageDownload, p.downloads, pr)
+}
+
+func (p *pipeline) enqueue(stage string, ch chan *pipelineRepo, pr *pipelineRepo) {
+	backfillPipelineQueueDepth.WithLabelValues(p.b.Name, stage).Inc()
+	ch <- pr
+}
+
+// runStage processes repos from in, with fn passing each on to the next stage
+// or failing it
+func (p *pipeline) runStage(stage string, in chan *pipelineRepo, fn func(pr *pipelineRepo) error) {
+	for pr := range in {
+		backfillPipelineQueueDepth.WithLabelValues(p.b.Name, stage).Dec()
+
+		if err := pr.ctx.Err(); err != nil {
+			p.fail(pr, "", err)
+			continue
+		}
+
+		start := time.Now()
+		err := fn(pr)
+		backfillPipelineStageDuration.WithLabelValues(p.b.Name, stage).Observe(time.Since(start).Seconds())
+		if err != nil {
+			backfillPipelineStageItems.WithLabelValues(p.b.Name, stage, "error").Inc()
+			p.fail(pr, pr.failState, err)
+			continue
+		}
+		backfillPipelineStageItems.WithLabelValues(p.b.Name, stage, "ok").Inc()
+	}
+}
+
+func (p *pipeline) fail(pr *pipelineRepo, state string, err error) {
+	pr.failState = state
+	pr.err = err
+	p.finish(pr)
+}
+
+// finish releases the repo's memory budget and wakes up BackfillRepo
+func (p *pipeline) finish(pr *pipelineRepo) {
+	pr.car = nil
+	pr.r = nil
+	if pr.reserved > 0 {
+		p.mem.Release(pr.reserved)
+		backfillPipelineInflightBytes.WithLabelValues(p.b.Name).Sub(float64(pr.reserved))
+		pr.reserved = 0
+	}
+	close(pr.done)
+}
+
+// download fetches the repo CAR, from the relay if possible, falling back to
+// the account's PDS, then waits for memory budget to hold it
+func (p *pipeline) download(pr *pipelineRepo) error {
+	b := p.b
+	ctx := pr.ctx
+
+	car, err := b.fetchRepoCAR(ctx, pr.did, pr.since, b.RelayHost)
+	if err != nil {
+		pr.log.Warn("repo CAR fetch from relay failed", "since", pr.since, "relayHost", b.RelayHost, "err", err)
+		ident, err := b.Directory.LookupDID(ctx, syntax.DID(pr.did))
+		if err != nil {
+			pr.failState = "failed resolving DID to PDS repo"
+			return fmt.Errorf("resolving DID for PDS repo fetch: %w", err)
+		}
+		pdsHost := ident.PDSEndpoint()
+		if pdsHost == "" {
+			pr.failState = "DID document missing PDS endpoint"
+			return fmt.Errorf("no PDS endpoint for DID: %s", pr.did)
+		}
+		if b.HostHealth != nil && b.HostHealth.IsUnhealthy(pdsHost) {
+			backfillUnhealthyHostSkips.WithLabelValues(b.Name).Inc()
+			pr.failState = "failed fetching repo CAR from unhealthy PDS"
+			return fmt.Errorf("PDS is unhealthy: %s", pdsHost)
+		}
+		car, err = b.fetchRepoCAR(ctx, pr.did, pr.since, pdsHost)
+		if err != nil {
+			pr.log.Warn("repo CAR fetch from PDS failed", "since", pr.since, "pdsHost", pdsHost, "err", err)
+			pr.failState = "repo CAR fetch from PDS failed"
+			return err
+		}
+		pr.log.Info("repo CAR fetch from PDS successful", "since", pr.since, "pdsHost", pdsHost)
+	}
+
+	// a repo larger than the whole budget gets the whole budget to itself
+	reserve := min(int64(len(car)), p.memLimit)
+	if err := p.mem.Acquire(ctx, reserve); err != nil {
+		return err
+	}
+	pr.reserved = reserve
+	backfillPipelineInflightBytes.WithLabelValues(b.Name).Add(float64(reserve))
+	pr.car = car
+
+	p.enqueue(stageVerify, p.verifies, pr)
+	return nil
+}
+
+// verify parses the CAR and checks that the commit is for the requested
+// account, and optionally that it is signed by the account's key
+func (p *pipeline) verify(pr *pipelineRepo) error {
+	pr.failState = "repo CAR verification failed"
+
+	r, err := repo.ReadRepoFromCar(pr.ctx, bytes.NewReader(pr.car))
+	if err != nil {
+		return fmt.Errorf("failed to parse repo from CAR file: %w", err)
+	}
+	// the parsed blocks hold their own copy of the data
+	pr.car = nil
+
+	sc := r.SignedCommit()
+	if sc.Did != pr.did {
+		return fmt.Errorf("%w: got %s", ErrRepoDIDMismatch, sc.Did)
+	}
+
+	if p.b.VerifyCommitSignatures {
+		ident, err := p.b.Directory.LookupDID(pr.ctx, syntax.DID(pr.did))
+		if err != nil {
+			return fmt.Errorf("resolving DID to verify commit: %w", err)
+		}
+		pub, err := ident.PublicKey()
+		if err != nil {
+			return fmt.Errorf("getting account signing key: %w", err)
+		}
+		msg, err := sc.Unsigned().BytesForSigning()
+		if err != nil {
+			return err
+		}
+		if err := pub.HashAndVerify(msg, sc.Sig); err != nil {
+			return fmt.Errorf("invalid commit signature: %w", err)
+		}
+	}
+
+	pr.r = r
+	pr.rev = sc.Rev
+	pr.failState = ""
+	p.enqueue(stageDecode, p.decodes, pr)
+	return nil
+}
+
+// decode walks the repo's MST, handing each record to the sink. The repo is
+// finished once the sink has handled all of them
+func (p *pipeline) decode(pr *pipelineRepo) error {
+	r := pr.r
+	err := r.ForEach(pr.ctx, p.b.NSIDFilter, func(recordPath string, nodeCid cid.Cid) error {
+		blk, err := r.Blockstore().Get(pr.ctx, nodeCid)
+		if err != nil {
+			pr.log.Error("Error processing record", "record", recordPath, "error", fmt.Errorf("failed to get blocks for record: %w", err))
+			return nil
+		}
+
+		pr.numRecords++
+		pr.pending.Add(1)
+		backfillPipelineQueueDepth.WithLabelValues(p.b.Name, stageSink).Inc()
+		select {
+		case p.records <- &pipelineRecord{p: pr, path: recordPath, cid: nodeCid, raw: blk.RawData()}:
+			return nil
+		case <-pr.ctx.Done():
+			backfillPipelineQueueDepth.WithLabelValues(p.b.Name, stageSink).Dec()
+			pr.pending.Done()
+			return pr.ctx.Err()
+		}
+	})
+	if err != nil {
+		pr.log.Error("failed to iterate records in repo", "err", err)
+	}
+
+	// don't hold up this worker while the sink catches up
+	go func() {
+		pr.pending.Wait()
+		p.finish(pr)
+	}()
+	return nil
+}
+
+func (p *pipeline) runSink() {
+	b := p.b
+	for rec := range p.records {
+		backfillPipelineQueueDepth.WithLabelValues(b.Name, stageSink).Dec()
+		pr := rec.p
+
+		start := time.Now()
+		err := b.HandleCreateRecord(pr.ctx, pr.did, pr.rev, rec.path, &rec.raw, &rec.cid)
+		backfillPipelineStageDuration.WithLabelValues(b.Name, stageSink).Observe(time.Since(start).Seconds())
+		if err != nil {
+			backfillPipelineStageItems.WithLabelValues(b.Name, stageSink, "error").Inc()
+			pr.log.Error("Error processing record", "record", rec.path, "error", fmt.Errorf("failed to handle create record: %w", err))
+		} else {
+			backfillPipelineStageItems.WithLabelValues(b.Name, stageSink, "ok").Inc()
+			backfillRecordsProcessed.WithLabelValues(b.Name).Inc()
+		}
+		pr.pending.Done()
+	}
+}
+
+// Fetches a repo CAR file over HTTP from the indicated host, returning the raw CAR bytes
+func (b *Backfiller) fetchRepoCAR(ctx context.Context, did, since, host string) ([]byte, error) {
+	url := fmt.Sprintf("%s/xrpc/com.atproto.sync.getRepo?did=%s", host, did)
+
+	if since != "" {
+		url = url + fmt.Sprintf("&since=%s", since)
+	}
+
+	client := &http.Client{
+		Transport: otelhttp.NewTransport(http.DefaultTransport),
+		Timeout:   600 * time.Second,
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/vnd.ipld.car")
+	req.Header.Set("User-Agent", fmt.Sprintf("atproto-backfill-%s/0.0.1", b.Name))
+	if b.magicHeaderKey != "" && b.magicHeaderVal != "" {
+		req.Header.Set(b.magicHeaderKey, b.magicHeaderVal)
+	}
+
+	b.syncLimiter.Wait(ctx)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		reason := "unknown error"
+		if resp.StatusCode == http.StatusBadRequest {
+			reason = "repo not found"
+		} else {
+			reason = resp.Status
+		}
+		return nil, fmt.Errorf("failed to get repo: %s", reason)
+	}
+
+	instrumentedReader := instrumentedReader{
+		source:  resp.Body,
+		counter: backfillBytesProcessed.WithLabelValues(b.Name),
+	}
+
+	defer instrumentedReader.Close()
+
+	car, err := io.ReadAll(instrumentedReader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read repo CAR: %w", err)
+	}
+	return car, nil
+}
@@ -0,0 +1,183 @@
+package backfill_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/identity/pdshealth"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/backfill"
+	"github.com/bluesky-social/indigo/repo"
+
+	"github.com/ipfs/go-cid"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestBackfillPipeline(t *testing.T) {
+	ctx := context.Background()
+
+	car, err := os.ReadFile("../testing/testdata/fakermaker.repo.car")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open("../testing/testdata/fakermaker.repo.car")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := repo.ReadRepoFromCar(ctx, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	did := r.SignedCommit().Did
+	expected := 0
+	if err := r.ForEach(ctx, "", func(string, cid.Cid) error {
+		expected++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// serves the same repo whichever DID is asked for
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(car)
+	}))
+	defer srv.Close()
+
+	var lk sync.Mutex
+	created := map[string]int{}
+	handleCreate := func(ctx context.Context, repo string, rev string, path string, rec *[]byte, cid *cid.Cid) error {
+		lk.Lock()
+		defer lk.Unlock()
+		created[repo]++
+		return nil
+	}
+
+	opts := backfill.DefaultBackfillOptions()
+	opts.RelayHost = srv.URL
+	opts.SyncRequestsPerSecond = 100
+	opts.ParallelRecordCreates = 2
+	// smaller than the CAR, so repos have to wait their turn
+	opts.MaxInflightBytes = 1024
+	bf := backfill.NewBackfiller("pipeline-test", nil, handleCreate, nil, nil, opts)
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "backfill.sqlite")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&backfill.GormDBJob{}); err != nil {
+		t.Fatal(err)
+	}
+	store := backfill.NewGormstore(db)
+	others := []string{"did:plc:other1", "did:plc:other2"}
+	for _, d := range append([]string{did}, others...) {
+		if err := store.EnqueueJob(ctx, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	states := map[string]string{}
+	errs := map[string]error{}
+	for _, d := range append([]string{did}, others...) {
+		job, err := store.GetJob(ctx, d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			state, err := bf.BackfillRepo(ctx, job)
+			lk.Lock()
+			defer lk.Unlock()
+			states[d] = state
+			errs[d] = err
+		}()
+	}
+	wg.Wait()
+
+	if states[did] != backfill.StateComplete || errs[did] != nil {
+		t.Fatalf("unexpected result for %s: %q %v", did, states[did], errs[did])
+	}
+	if created[did] != expected {
+		t.Fatalf("expected %d records created, got %d", expected, created[did])
+	}
+
+	// the served repo doesn't belong to these accounts
+	for _, d := range others {
+		if !errors.Is(errs[d], backfill.ErrRepoDIDMismatch) {
+			t.Fatalf("expected DID mismatch for %s, got %q %v", d, states[d], errs[d])
+		}
+		if created[d] != 0 {
+			t.Fatalf("no records should be created for %s", d)
+		}
+	}
+}
+
+func TestBackfillUnhealthyPDS(t *testing.T) {
+	ctx := context.Background()
+
+	relay := httptest.NewServer(http.NotFoundHandler())
+	defer relay.Close()
+	var pdsRequests int
+	pds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pdsRequests++
+		http.NotFound(w, r)
+	}))
+	defer pds.Close()
+
+	did := "did:plc:unhealthy"
+	dir := identity.NewMockDirectory()
+	dir.Insert(identity.Identity{
+		DID:      syntax.DID(did),
+		Services: map[string]identity.Service{"atproto_pds": {Type: "AtprotoPersonalDataServer", URL: pds.URL}},
+	})
+
+	reg, err := pdshealth.NewRegistry(ctx, pdshealth.NewMemStore(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg.UnhealthyAfter = 1
+	if _, err := reg.Record(ctx, &pdshealth.ProbeResult{Host: pdshealth.NormalizeHost(pds.URL), TLSValid: true, Err: errors.New("down")}); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := backfill.DefaultBackfillOptions()
+	opts.RelayHost = relay.URL
+	opts.SyncRequestsPerSecond = 100
+	bf := backfill.NewBackfiller("unhealthy-test", nil, nil, nil, nil, opts)
+	bf.Directory = &dir
+	bf.HostHealth = reg
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "backfill.sqlite")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&backfill.GormDBJob{}); err != nil {
+		t.Fatal(err)
+	}
+	store := backfill.NewGormstore(db)
+	if err := store.EnqueueJob(ctx, did); err != nil {
+		t.Fatal(err)
+	}
+	job, err := store.GetJob(ctx, did)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := bf.BackfillRepo(ctx, job)
+	if err == nil || state != "failed fetching repo CAR from unhealthy PDS" {
+		t.Fatalf("expected backfill to be put off, got %q %v", state, err)
+	}
+	if pdsRequests != 0 {
+		t.Fatalf("unhealthy PDS should not be fetched from, got %d requests", pdsRequests)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/bluesky-social/indigo/carstore"
 	"github.com/bluesky-social/indigo/models"
 	"github.com/labstack/echo/v4"
 	dto "github.com/prometheus/client_model/go"
@@ -200,6 +201,8 @@ type consumer struct {
 	UserAgent      string    `json:"user_agent"`
 	EventsConsumed uint64    `json:"events_consumed"`
 	ConnectedAt    time.Time `json:"connected_at"`
+	Subject        string    `json:"subject,omitempty"`
+	Tier           string    `json:"tier,omitempty"`
 }
 
 func (bgs *BGS) handleAdminListConsumers(e echo.Context) error {
@@ -218,6 +221,8 @@ func (bgs *BGS) handleAdminListConsumers(e echo.Context) error {
 			UserAgent:      c.UserAgent,
 			EventsConsumed: uint64(m.Counter.GetValue()),
 			ConnectedAt:    c.ConnectedAt,
+			Subject:        c.Subject,
+			Tier:           c.Tier,
 		})
 	}
 
@@ -647,3 +652,228 @@ func (bgs *BGS) handleAdminRequestCrawl(e echo.Context) error {
 
 	return bgs.slurper.SubscribeToPds(ctx, host, true, true) // Override Trusted Domain Check
 }
+
+func (bgs *BGS) handleAdminResyncRepo(e echo.Context) error {
+	ctx := e.Request().Context()
+
+	did := e.QueryParam("did")
+	if did == "" {
+		return fmt.Errorf("must pass a did")
+	}
+
+	priority, err := ParseResyncPriority(e.QueryParam("priority"))
+	if err != nil {
+		return &echo.HTTPError{
+			Code:    400,
+			Message: err.Error(),
+		}
+	}
+
+	ai, err := bgs.Index.LookupUserByDid(ctx, did)
+	if err != nil {
+		return fmt.Errorf("no such user: %w", err)
+	}
+
+	queued := bgs.resyncer.Enqueue(ai.Uid, ai.Did, ai.PDS, priority, driftSourceAdmin)
+
+	return e.JSON(200, map[string]any{
+		"success": true,
+		"queued":  queued,
+	})
+}
+
+func (bgs *BGS) handleAdminGetResyncQueue(e echo.Context) error {
+	limit := 50
+	if limstr := e.QueryParam("limit"); limstr != "" {
+		v, err := strconv.Atoi(limstr)
+		if err != nil {
+			return err
+		}
+
+		limit = v
+	}
+
+	return e.JSON(200, bgs.resyncer.Status(limit))
+}
+
+func (bgs *BGS) archivingCarStore() (*carstore.FileCarStore, error) {
+	fcs, ok := bgs.repoman.CarStore().(*carstore.FileCarStore)
+	if !ok {
+		return nil, &echo.HTTPError{
+			Code:    400,
+			Message: "carstore does not support archiving",
+		}
+	}
+	return fcs, nil
+}
+
+func (bgs *BGS) handleAdminArchiveRepo(e echo.Context) error {
+	ctx := e.Request().Context()
+
+	did := e.QueryParam("did")
+	if did == "" {
+		return fmt.Errorf("must pass a did")
+	}
+
+	fcs, err := bgs.archivingCarStore()
+	if err != nil {
+		return err
+	}
+
+	ai, err := bgs.Index.LookupUserByDid(ctx, did)
+	if err != nil {
+		return fmt.Errorf("no such user: %w", err)
+	}
+
+	if err := fcs.ArchiveRepo(ctx, ai.Uid); err != nil {
+		if errors.Is(err, carstore.ErrNoColdStore) || errors.Is(err, carstore.ErrRepoArchived) {
+			return &echo.HTTPError{
+				Code:    400,
+				Message: err.Error(),
+			}
+		}
+		return fmt.Errorf("failed to archive repo: %w", err)
+	}
+
+	return e.JSON(200, map[string]any{
+		"success": true,
+	})
+}
+
+func (bgs *BGS) handleAdminUnarchiveRepo(e echo.Context) error {
+	ctx := e.Request().Context()
+
+	did := e.QueryParam("did")
+	if did == "" {
+		return fmt.Errorf("must pass a did")
+	}
+
+	fcs, err := bgs.archivingCarStore()
+	if err != nil {
+		return err
+	}
+
+	ai, err := bgs.Index.LookupUserByDid(ctx, did)
+	if err != nil {
+		return fmt.Errorf("no such user: %w", err)
+	}
+
+	if err := fcs.UnarchiveRepo(ctx, ai.Uid); err != nil {
+		return fmt.Errorf("failed to restore repo: %w", err)
+	}
+
+	return e.JSON(200, map[string]any{
+		"success": true,
+	})
+}
+
+func (bgs *BGS) handleAdminDedupStats(e echo.Context) error {
+	fcs, ok := bgs.repoman.CarStore().(*carstore.FileCarStore)
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "carstore does not support dedup")
+	}
+
+	stats, err := fcs.DedupStats(e.Request().Context())
+	if err != nil {
+		return fmt.Errorf("failed to compute dedup stats: %w", err)
+	}
+	return e.JSON(http.StatusOK, stats)
+}
+
+func (bgs *BGS) handleAdminStartConsistencyCheck(e echo.Context) error {
+	opts := DefaultConsistencyCheckOptions()
+	opts.Repair = e.QueryParam("repair") == "true"
+	opts.CheckShardFiles = e.QueryParam("checkShardFiles") == "true"
+	opts.CheckEvents = e.QueryParam("checkEvents") == "true"
+	if v := e.QueryParam("eventsSince"); v != "" {
+		since, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid eventsSince")
+		}
+		opts.EventsSince = since
+	}
+	if v := e.QueryParam("eventLimit"); v != "" {
+		limit, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid eventLimit")
+		}
+		opts.EventLimit = limit
+	}
+
+	if err := bgs.StartConsistencyCheck(opts); err != nil {
+		if errors.Is(err, ErrConsistencyCheckRunning) {
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		}
+		return err
+	}
+
+	return e.JSON(200, map[string]any{
+		"success": true,
+	})
+}
+
+func (bgs *BGS) handleAdminGetConsistencyReport(e echo.Context) error {
+	rep := bgs.ConsistencyReport()
+	if rep == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "no consistency check has been run")
+	}
+	return e.JSON(http.StatusOK, rep)
+}
+
+func (bgs *BGS) handleAdminCollectionStats(e echo.Context) error {
+	if bgs.collectionStats == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "collection stats are not enabled")
+	}
+
+	limit := 50
+	if limstr := e.QueryParam("limit"); limstr != "" {
+		v, err := strconv.Atoi(limstr)
+		if err != nil || v < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid limit")
+		}
+		limit = v
+	}
+
+	return e.JSON(http.StatusOK, bgs.collectionStats.Report(limit))
+}
+
+func (bgs *BGS) handleAdminGetScrubReport(e echo.Context) error {
+	if bgs.scrubber == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "scrubbing is not enabled")
+	}
+	return e.JSON(http.StatusOK, bgs.scrubber.Report())
+}
+
+// handleAdminScrubRepo scrubs a single repo on demand. If the scrubber is
+// running, the result is recorded in its report, and a corrupt repo is
+// repaired if the scrubber is configured to do so.
+func (bgs *BGS) handleAdminScrubRepo(e echo.Context) error {
+	ctx := e.Request().Context()
+
+	did := e.QueryParam("did")
+	if did == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "must pass a did")
+	}
+	ai, err := bgs.Index.LookupUserByDid(ctx, did)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("no such user: %s", err))
+	}
+
+	if bgs.scrubber != nil {
+		res, err := bgs.scrubber.Scrub(ctx, ai.Uid)
+		if err != nil {
+			return err
+		}
+		return e.JSON(http.StatusOK, res)
+	}
+
+	fcs, ok := bgs.repoman.CarStore().(*carstore.FileCarStore)
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "carstore does not support scrubbing")
+	}
+	res, err := fcs.ScrubRepo(ctx, ai.Uid)
+	if err != nil {
+		return err
+	}
+	return e.JSON(http.StatusOK, res)
+}
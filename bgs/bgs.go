@@ -21,6 +21,7 @@ import (
 	"github.com/bluesky-social/indigo/api"
 	atproto "github.com/bluesky-social/indigo/api/atproto"
 	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/crypto"
 	"github.com/bluesky-social/indigo/carstore"
 	"github.com/bluesky-social/indigo/did"
 	"github.com/bluesky-social/indigo/events"
@@ -89,6 +90,12 @@ type BGS struct {
 	// Management of Compaction
 	compactor *Compactor
 
+	// Re-fetching of repos which have drifted from upstream
+	resyncer *Resyncer
+
+	// Hosts emitting invalid events
+	quarantine *hostQuarantine
+
 	// User cache
 	userCache *lru.Cache[string, *User]
 
@@ -96,6 +103,37 @@ type BGS struct {
 	nextCrawlers []*url.URL
 	httpClient   http.Client
 
+	// Outbound mirroring of our event stream to a downstream relay
+	mirror       *Mirror
+	mirrorCancel context.CancelFunc
+
+	// Signs outgoing event frames, if enabled
+	attestor *events.Attestor
+
+	// tallies incoming ops per collection and host. nil if not enabled
+	collectionStats *events.CollectionStats
+
+	// measures the age of incoming events, and checks it against an SLA
+	ageWatchdog *events.AgeWatchdog
+
+	// HMAC key for firehose subscription tokens. nil if not enabled
+	subTokenKey     []byte
+	requireSubToken bool
+
+	// IDs of revoked subscription tokens
+	revokedSubTokensLk sync.RWMutex
+	revokedSubTokens   map[string]bool
+
+	// Running or most recent consistency check
+	consistency consistencyChecker
+
+	// samples repos and checks the integrity of their data. nil if not enabled
+	scrubber    *carstore.Scrubber
+	scrubCancel context.CancelFunc
+
+	// probes PDS hosts' health. nil if not enabled
+	hostHealth *hostHealth
+
 	log *slog.Logger
 }
 
@@ -114,6 +152,14 @@ type SocketConsumer struct {
 	RemoteAddr  string
 	ConnectedAt time.Time
 	EventsSent  promclient.Counter
+
+	// Subject, tier and ID of the consumer's subscription token, if any
+	Subject string
+	Tier    string
+	TokenID string
+
+	// disconnects the consumer
+	cancel context.CancelFunc
 }
 
 type BGSConfig struct {
@@ -124,8 +170,80 @@ type BGSConfig struct {
 	MaxQueuePerPDS       int64
 	NumCompactionWorkers int
 
+	// QuarantineThreshold is the number of invalid events (bad signatures,
+	// non-inductive commits) from a single host within QuarantineWindow that
+	// causes the host to be quarantined. Zero disables automatic quarantine.
+	QuarantineThreshold int
+	QuarantineWindow    time.Duration
+	// OnHostQuarantined, if set, is called whenever a host is quarantined,
+	// for alerting
+	OnHostQuarantined func(host *models.PDS, reason string)
+
 	// NextCrawlers gets forwarded POST /xrpc/com.atproto.sync.requestCrawl
 	NextCrawlers []*url.URL
+
+	// MirrorUpstream, if set, is the base URL (eg "wss://relay2.example.com")
+	// of a downstream relay which this relay will push its output event stream
+	// to, authenticating with MirrorUpstreamToken (an admin token on the
+	// downstream relay)
+	MirrorUpstream      string
+	MirrorUpstreamToken string
+
+	// Resync workers re-fetch full copies of repos which have diverged from
+	// upstream. DriftSampleInterval (if non-zero) is how often DriftSampleSize
+	// random repos are checked against their PDS for drift.
+	ResyncWorkers       int
+	ResyncPerHostLimit  int
+	DriftSampleInterval time.Duration
+	DriftSampleSize     int
+
+	// AttestationKey, if set, is used to sign every frame of the output event
+	// stream, so that downstream consumers can verify it wasn't altered in
+	// transit. The public key is published at /attestation-key
+	AttestationKey crypto.PrivateKey
+
+	// CollectionStats enables tallying of incoming repo ops by collection,
+	// PDS host, and op type (exported as metrics, and reported at
+	// /admin/firehose/collections). Per-collection op and account counts are
+	// also published at /stats/lexicons
+	CollectionStats bool
+	// CollectionStatsWindow is the length of the rolling window kept for
+	// collection stats reports; defaults to an hour
+	CollectionStatsWindow time.Duration
+
+	// EventAgeSLA is the maximum acceptable p99 age (time since creation) of
+	// events arriving from PDSs. Breaches are logged, reported in metrics, and
+	// posted to EventAgeAlertWebhook if set. Zero disables alerting.
+	EventAgeSLA          time.Duration
+	EventAgeAlertWebhook string
+
+	// SubscriptionTokenKey, if set, is the secret used to sign and verify
+	// firehose subscription tokens (issued via /admin/subs/issueToken), which
+	// carry per-consumer rate and connection limits. If
+	// RequireSubscriptionToken is also set, consumers without a valid token
+	// are rejected; otherwise they are served without limits.
+	SubscriptionTokenKey     []byte
+	RequireSubscriptionToken bool
+
+	// ConsistencyCheck, if set, runs a consistency check (see
+	// RunConsistencyCheck) in the background on startup. Checks can also be
+	// started on demand via /admin/consistency/check
+	ConsistencyCheck *ConsistencyCheckOptions
+
+	// ScrubInterval, if non-zero, runs a background integrity scrubber, which
+	// re-hashes the stored blocks of ScrubBatchSize sampled repos every
+	// interval and checks their MSTs are complete (report at
+	// /admin/scrub/report). If ScrubRepair is set, corrupt repos are wiped
+	// and queued for resync from their PDS.
+	ScrubInterval  time.Duration
+	ScrubBatchSize int
+	ScrubRepair    bool
+
+	// HostHealthInterval, if non-zero, is how often registered PDS hosts are
+	// probed for health (reachability, TLS validity, latency; report at
+	// /admin/pds/health). Resyncs from unhealthy hosts are put off until
+	// there is nothing else to do.
+	HostHealthInterval time.Duration
 }
 
 func DefaultBGSConfig() *BGSConfig {
@@ -136,6 +254,12 @@ func DefaultBGSConfig() *BGSConfig {
 		ConcurrencyPerPDS:    100,
 		MaxQueuePerPDS:       1_000,
 		NumCompactionWorkers: 2,
+		QuarantineThreshold:  0,
+		QuarantineWindow:     10 * time.Minute,
+		ResyncWorkers:        4,
+		ResyncPerHostLimit:   2,
+		DriftSampleInterval:  0,
+		DriftSampleSize:      100,
 	}
 }
 
@@ -169,9 +293,48 @@ func NewBGS(db *gorm.DB, ix *indexer.Indexer, repoman *repomgr.RepoManager, evtm
 
 		userCache: uc,
 
+		quarantine: newHostQuarantine(config.QuarantineThreshold, config.QuarantineWindow),
+
 		log: slog.Default().With("system", "bgs"),
 	}
 
+	bgs.quarantine.onQuarantine = config.OnHostQuarantined
+	if err := bgs.loadQuarantinedHosts(); err != nil {
+		return nil, err
+	}
+
+	if config.AttestationKey != nil {
+		a, err := events.NewAttestor(config.AttestationKey)
+		if err != nil {
+			return nil, err
+		}
+		evtman.SetAttestor(a)
+		bgs.attestor = a
+	}
+
+	if config.CollectionStats {
+		bgs.collectionStats = events.NewCollectionStats(nil)
+		if config.CollectionStatsWindow > 0 {
+			bgs.collectionStats.Window = config.CollectionStatsWindow
+		}
+	}
+
+	bgs.ageWatchdog = events.NewAgeWatchdog("relay", config.EventAgeSLA, nil)
+	bgs.ageWatchdog.Logger = bgs.log
+	if config.EventAgeAlertWebhook != "" {
+		bgs.ageWatchdog.OnAlert = events.NewWebhookAlerter(config.EventAgeAlertWebhook, bgs.log)
+	}
+
+	if len(config.SubscriptionTokenKey) > 0 {
+		bgs.subTokenKey = config.SubscriptionTokenKey
+		bgs.requireSubToken = config.RequireSubscriptionToken
+		if err := bgs.loadRevokedSubTokens(); err != nil {
+			return nil, fmt.Errorf("loading revoked subscription tokens: %w", err)
+		}
+	} else if config.RequireSubscriptionToken {
+		return nil, fmt.Errorf("subscription tokens required, but no subscription token key configured")
+	}
+
 	ix.CreateExternalUser = bgs.createExternalUser
 	slOpts := DefaultSlurperOptions()
 	slOpts.SSL = config.SSL
@@ -196,9 +359,46 @@ func NewBGS(db *gorm.DB, ix *indexer.Indexer, repoman *repomgr.RepoManager, evtm
 	compactor.Start(bgs)
 	bgs.compactor = compactor
 
+	rOpts := DefaultResyncerOptions()
+	rOpts.NumWorkers = config.ResyncWorkers
+	rOpts.PerHostLimit = config.ResyncPerHostLimit
+	rOpts.SampleInterval = config.DriftSampleInterval
+	rOpts.SampleSize = config.DriftSampleSize
+	bgs.resyncer = NewResyncer(bgs, rOpts)
+	bgs.resyncer.Start()
+
 	bgs.nextCrawlers = config.NextCrawlers
 	bgs.httpClient.Timeout = time.Second * 5
 
+	if config.MirrorUpstream != "" {
+		m, err := newMirror(bgs, config.MirrorUpstream, config.MirrorUpstreamToken)
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		bgs.mirror = m
+		bgs.mirrorCancel = cancel
+		go m.Run(ctx)
+	}
+
+	if config.ConsistencyCheck != nil {
+		if err := bgs.StartConsistencyCheck(config.ConsistencyCheck); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.ScrubInterval > 0 {
+		if err := bgs.startScrubber(config); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.HostHealthInterval > 0 {
+		if err := bgs.startHostHealth(config.HostHealthInterval); err != nil {
+			return nil, err
+		}
+	}
+
 	return bgs, nil
 }
 
@@ -365,6 +565,7 @@ func (bgs *BGS) StartWithListener(listen net.Listener) error {
 	// TODO: this API is temporary until we formalize what we want here
 
 	e.GET("/xrpc/com.atproto.sync.subscribeRepos", bgs.EventsHandler)
+	e.GET("/push/subscribeRepos", bgs.handlePushRepos)
 	e.GET("/xrpc/com.atproto.sync.getRecord", bgs.HandleComAtprotoSyncGetRecord)
 	e.GET("/xrpc/com.atproto.sync.getRepo", bgs.HandleComAtprotoSyncGetRepo)
 	e.GET("/xrpc/com.atproto.sync.getBlocks", bgs.HandleComAtprotoSyncGetBlocks)
@@ -376,6 +577,8 @@ func (bgs *BGS) StartWithListener(listen net.Listener) error {
 	e.GET("/xrpc/_health", bgs.HandleHealthCheck)
 	e.GET("/_health", bgs.HandleHealthCheck)
 	e.GET("/", bgs.HandleHomeMessage)
+	e.GET("/attestation-key", bgs.HandleAttestationKey)
+	e.GET("/stats/lexicons", bgs.HandleLexiconStats)
 
 	admin := e.Group("/admin", bgs.checkAdminAuth)
 
@@ -386,6 +589,8 @@ func (bgs *BGS) StartWithListener(listen net.Listener) error {
 	admin.POST("/subs/setEnabled", bgs.handleAdminSetSubsEnabled)
 	admin.POST("/subs/killUpstream", bgs.handleAdminKillUpstreamConn)
 	admin.POST("/subs/setPerDayLimit", bgs.handleAdminSetNewPDSPerDayRateLimit)
+	admin.POST("/subs/issueToken", bgs.handleAdminIssueSubscriptionToken)
+	admin.POST("/subs/revokeToken", bgs.handleAdminRevokeSubscriptionToken)
 
 	// Domain-related Admin API
 	admin.GET("/subs/listDomainBans", bgs.handleAdminListDomainBans)
@@ -399,6 +604,19 @@ func (bgs *BGS) StartWithListener(listen net.Listener) error {
 	admin.POST("/repo/compactAll", bgs.handleAdminCompactAllRepos)
 	admin.POST("/repo/reset", bgs.handleAdminResetRepo)
 	admin.POST("/repo/verify", bgs.handleAdminVerifyRepo)
+	admin.POST("/repo/resync", bgs.handleAdminResyncRepo)
+	admin.GET("/repo/resync/queue", bgs.handleAdminGetResyncQueue)
+	admin.POST("/repo/archive", bgs.handleAdminArchiveRepo)
+	admin.POST("/repo/unarchive", bgs.handleAdminUnarchiveRepo)
+	admin.GET("/carstore/dedup", bgs.handleAdminDedupStats)
+
+	// Consistency checks of DB, carstore, and event log
+	admin.POST("/consistency/check", bgs.handleAdminStartConsistencyCheck)
+	admin.GET("/consistency/report", bgs.handleAdminGetConsistencyReport)
+
+	// Carstore integrity scrubbing
+	admin.GET("/scrub/report", bgs.handleAdminGetScrubReport)
+	admin.POST("/scrub/repo", bgs.handleAdminScrubRepo)
 
 	// PDS-related Admin API
 	admin.POST("/pds/requestCrawl", bgs.handleAdminRequestCrawl)
@@ -409,10 +627,21 @@ func (bgs *BGS) StartWithListener(listen net.Listener) error {
 	admin.POST("/pds/block", bgs.handleBlockPDS)
 	admin.POST("/pds/unblock", bgs.handleUnblockPDS)
 	admin.POST("/pds/addTrustedDomain", bgs.handleAdminAddTrustedDomain)
+	admin.POST("/pds/issuePushToken", bgs.handleAdminIssuePushToken)
+	admin.GET("/pds/quarantine/list", bgs.handleAdminListQuarantined)
+	admin.POST("/pds/quarantine", bgs.handleAdminQuarantineHost)
+	admin.POST("/pds/quarantine/release", bgs.handleAdminReleaseHost)
+	admin.GET("/pds/health", bgs.handleAdminListHostHealth)
 
 	// Consumer-related Admin API
 	admin.GET("/consumers/list", bgs.handleAdminListConsumers)
 
+	// Mirror ingestion from an upstream relay
+	admin.GET("/mirror/ingest", bgs.handleAdminMirrorIngest)
+
+	// Per-collection firehose stats
+	admin.GET("/firehose/collections", bgs.handleAdminCollectionStats)
+
 	// In order to support booting on random ports in tests, we need to tell the
 	// Echo instance it's already got a port, and then use its StartServer
 	// method to re-use that listener.
@@ -429,6 +658,17 @@ func (bgs *BGS) Shutdown() []error {
 	}
 
 	bgs.compactor.Shutdown()
+	bgs.resyncer.Shutdown()
+
+	if bgs.mirrorCancel != nil {
+		bgs.mirrorCancel()
+	}
+	if bgs.scrubCancel != nil {
+		bgs.scrubCancel()
+	}
+	if bgs.hostHealth != nil {
+		bgs.hostHealth.cancel()
+	}
 
 	return errs
 }
@@ -447,6 +687,54 @@ func (bgs *BGS) HandleHealthCheck(c echo.Context) error {
 	}
 }
 
+type AttestationKeyResponse struct {
+	// did:key of the key signing event stream frames
+	DIDKey string `json:"didKey"`
+}
+
+// HandleAttestationKey publishes the public key which event stream frames are
+// signed with, for consumers verifying attestations
+func (bgs *BGS) HandleAttestationKey(c echo.Context) error {
+	if bgs.attestor == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "event stream attestation is not enabled")
+	}
+	dk, err := bgs.attestor.DIDKey()
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, AttestationKeyResponse{DIDKey: dk})
+}
+
+// HandleLexiconStats reports op counts and unique account counts per
+// collection over recent windows, for tracking adoption of lexicons. Optional
+// query parameters are "prefix" (an NSID prefix, eg "app.bsky."), "window"
+// (repeatable durations, eg "10m"), and "limit" (default 100, zero for all)
+func (bgs *BGS) HandleLexiconStats(c echo.Context) error {
+	if bgs.collectionStats == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "collection stats are not enabled")
+	}
+
+	limit := 100
+	if limstr := c.QueryParam("limit"); limstr != "" {
+		v, err := strconv.Atoi(limstr)
+		if err != nil || v < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid limit")
+		}
+		limit = v
+	}
+
+	var windows []time.Duration
+	for _, ws := range c.QueryParams()["window"] {
+		w, err := time.ParseDuration(ws)
+		if err != nil || w <= 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid window")
+		}
+		windows = append(windows, w)
+	}
+
+	return c.JSON(http.StatusOK, bgs.collectionStats.LexiconReport(windows, c.QueryParam("prefix"), limit))
+}
+
 var homeMessage string = `
 d8888b. d888888b  d888b  .d8888. db   dD db    db
 88  '8D   '88'   88' Y8b 88'  YP 88 ,8P' '8b  d8'
@@ -629,10 +917,14 @@ func (bgs *BGS) EventsHandler(c echo.Context) error {
 		since = &sval
 	}
 
+	claims, err := bgs.checkSubscriptionToken(c)
+	if err != nil {
+		return err
+	}
+
 	ctx, cancel := context.WithCancel(c.Request().Context())
 	defer cancel()
 
-	// TODO: authhhh
 	conn, err := websocket.Upgrade(c.Response(), c.Request(), c.Response().Header(), 10<<10, 10<<10)
 	if err != nil {
 		return fmt.Errorf("upgrading websocket: %w", err)
@@ -662,7 +954,7 @@ func (bgs *BGS) EventsHandler(c echo.Context) error {
 				}
 
 				if err := conn.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(5*time.Second)); err != nil {
-					bgs.log.Warn("failed to ping client: %s", err)
+					bgs.log.Warn("failed to ping client", "err", err)
 					cancel()
 					return
 				}
@@ -687,7 +979,7 @@ func (bgs *BGS) EventsHandler(c echo.Context) error {
 		for {
 			_, _, err := conn.ReadMessage()
 			if err != nil {
-				bgs.log.Warn("failed to read message from client: %s", err)
+				bgs.log.Warn("failed to read message from client", "err", err)
 				cancel()
 				return
 			}
@@ -696,7 +988,7 @@ func (bgs *BGS) EventsHandler(c echo.Context) error {
 
 	ident := c.RealIP() + "-" + c.Request().UserAgent()
 
-	evts, cleanup, err := bgs.events.Subscribe(ctx, ident, func(evt *events.XRPCStreamEvent) bool { return true }, since)
+	evts, cleanup, err := bgs.events.Subscribe(ctx, ident, bgs.fanoutFilter, since)
 	if err != nil {
 		return err
 	}
@@ -707,10 +999,19 @@ func (bgs *BGS) EventsHandler(c echo.Context) error {
 		RemoteAddr:  c.RealIP(),
 		UserAgent:   c.Request().UserAgent(),
 		ConnectedAt: time.Now(),
+		cancel:      cancel,
 	}
 	sentCounter := eventsSentCounter.WithLabelValues(consumer.RemoteAddr, consumer.UserAgent)
 	consumer.EventsSent = sentCounter
 
+	var limiter *rate.Limiter
+	if claims != nil {
+		consumer.Subject = claims.Subject
+		consumer.Tier = claims.Tier
+		consumer.TokenID = claims.ID
+		limiter = claims.limiter()
+	}
+
 	consumerID := bgs.registerConsumer(&consumer)
 	defer bgs.cleanupConsumer(consumerID)
 
@@ -718,6 +1019,8 @@ func (bgs *BGS) EventsHandler(c echo.Context) error {
 		"consumer_id", consumerID,
 		"remote_addr", consumer.RemoteAddr,
 		"user_agent", consumer.UserAgent,
+		"subject", consumer.Subject,
+		"tier", consumer.Tier,
 	)
 
 	logger.Info("new consumer", "cursor", since)
@@ -730,26 +1033,16 @@ func (bgs *BGS) EventsHandler(c echo.Context) error {
 				return nil
 			}
 
-			wc, err := conn.NextWriter(websocket.BinaryMessage)
-			if err != nil {
-				logger.Error("failed to get next writer", "err", err)
-				return err
+			if limiter != nil {
+				if err := limiter.Wait(ctx); err != nil {
+					return nil
+				}
 			}
 
-			if evt.Preserialized != nil {
-				_, err = wc.Write(evt.Preserialized)
-			} else {
-				err = evt.Serialize(wc)
-			}
-			if err != nil {
+			if err := events.WriteEvent(conn, evt); err != nil {
 				return fmt.Errorf("failed to write event: %w", err)
 			}
 
-			if err := wc.Close(); err != nil {
-				logger.Warn("failed to flush-close our event write", "err", err)
-				return nil
-			}
-
 			lastWriteLk.Lock()
 			lastWrite = time.Now()
 			lastWriteLk.Unlock()
@@ -884,10 +1177,20 @@ func (bgs *BGS) handleFedEvent(ctx context.Context, host *models.PDS, env *event
 	}()
 
 	eventsReceivedCounter.WithLabelValues(host.Host).Add(1)
+	bgs.ageWatchdog.Observe(env)
+
+	if bgs.quarantine.isQuarantined(host.ID) {
+		// still apply and persist the event, it is just not fanned out
+		span.SetAttributes(attribute.Bool("quarantined", true))
+		quarantinedEventsCounter.WithLabelValues(host.Host).Inc()
+	}
 
 	switch {
 	case env.RepoCommit != nil:
 		repoCommitsReceivedCounter.WithLabelValues(host.Host).Add(1)
+		if bgs.collectionStats != nil {
+			bgs.collectionStats.Observe(host.Host, env)
+		}
 		evt := env.RepoCommit
 		bgs.log.Debug("bgs got repo append event", "seq", evt.Seq, "pdsHost", host.Host, "repo", evt.Repo)
 
@@ -1000,6 +1303,20 @@ func (bgs *BGS) handleFedEvent(ctx context.Context, host *models.PDS, env *event
 		}
 
 		if err := bgs.repoman.HandleExternalUserEvent(ctx, host.ID, u.ID, u.Did, evt.Since, evt.Rev, evt.Blocks, evt.Ops); err != nil {
+			if isInvalidEventErr(err) {
+				bgs.noteInvalidEvent(ctx, host, err)
+			}
+
+			// if we hold a newer rev than the one this commit builds on, our
+			// copy has diverged from upstream and replaying events won't fix it
+			if errors.Is(err, carstore.ErrRepoBaseMismatch) && evt.Since != nil {
+				if localRev, rerr := bgs.repoman.GetRepoRev(ctx, u.ID); rerr == nil && localRev > *evt.Since {
+					log.Warn("local repo has drifted from upstream, queueing resync", "pdsHost", host.Host, "seq", evt.Seq, "repo", u.Did, "localRev", localRev, "since", *evt.Since)
+					bgs.resyncer.noteDrift(u.ID, u.Did, host.ID)
+					repoCommitsResultCounter.WithLabelValues(host.Host, "resync").Inc()
+					return nil
+				}
+			}
 
 			if errors.Is(err, carstore.ErrRepoBaseMismatch) || ipld.IsNotFound(err) {
 				ai, lerr := bgs.Index.LookupUser(ctx, u.ID)
@@ -1047,6 +1364,7 @@ func (bgs *BGS) handleFedEvent(ctx context.Context, host *models.PDS, env *event
 				Handle: env.RepoHandle.Handle,
 				Time:   env.RepoHandle.Time,
 			},
+			PrivPdsId: host.ID,
 		})
 		if err != nil {
 			bgs.log.Error("failed to broadcast RepoHandle event", "error", err, "did", env.RepoHandle.Did, "handle", env.RepoHandle.Handle)
@@ -1073,6 +1391,7 @@ func (bgs *BGS) handleFedEvent(ctx context.Context, host *models.PDS, env *event
 				Time:   env.RepoIdentity.Time,
 				Handle: env.RepoIdentity.Handle,
 			},
+			PrivPdsId: host.ID,
 		})
 		if err != nil {
 			bgs.log.Error("failed to broadcast Identity event", "error", err, "did", env.RepoIdentity.Did)
@@ -1148,6 +1467,7 @@ func (bgs *BGS) handleFedEvent(ctx context.Context, host *models.PDS, env *event
 				Active: shouldBeActive,
 				Status: status,
 			},
+			PrivPdsId: host.ID,
 		})
 		if err != nil {
 			bgs.log.Error("failed to broadcast Account event", "error", err, "did", env.RepoAccount.Did)
@@ -1167,6 +1487,24 @@ func (bgs *BGS) handleFedEvent(ctx context.Context, host *models.PDS, env *event
 		}
 
 		return nil
+	case env.RepoSync != nil:
+		bgs.log.Info("bgs got repo sync event", "did", env.RepoSync.Did, "rev", env.RepoSync.Rev, "pdsHost", host.Host)
+
+		// Refetch the DID doc to make sure the PDS is still authoritative
+		bgs.didr.FlushCacheFor(env.RepoSync.Did)
+		ai, err := bgs.createExternalUser(ctx, env.RepoSync.Did)
+		if err != nil {
+			return err
+		}
+
+		if ai.PDS != host.ID {
+			bgs.log.Error("sync event from non-authoritative pds", "seq", env.RepoSync.Seq, "did", env.RepoSync.Did, "event_from", host.Host, "did_doc_declared_pds", ai.PDS)
+			return fmt.Errorf("event from non-authoritative pds")
+		}
+
+		// the upstream repo was replaced, so there is no diff we can apply;
+		// re-crawl it, which will emit commit events for whatever changed
+		return bgs.Index.Crawler.Crawl(ctx, ai)
 	default:
 		return fmt.Errorf("invalid fed event")
 	}
@@ -1203,6 +1541,7 @@ func (bgs *BGS) handleRepoTombstone(ctx context.Context, pds *models.PDS, evt *a
 
 	return bgs.events.AddEvent(ctx, &events.XRPCStreamEvent{
 		RepoTombstone: evt,
+		PrivPdsId:     pds.ID,
 	})
 }
 
@@ -0,0 +1,90 @@
+package bgs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bluesky-social/indigo/api"
+	"github.com/bluesky-social/indigo/carstore"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/indexer"
+	"github.com/bluesky-social/indigo/models"
+	"github.com/bluesky-social/indigo/notifs"
+	"github.com/bluesky-social/indigo/plc"
+	"github.com/bluesky-social/indigo/repomgr"
+	"github.com/bluesky-social/indigo/util"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// testBGS sets up a relay backed by sqlite and an in-memory event log in a
+// temporary directory. It is not listening; tests call its methods and
+// handlers directly.
+func testBGS(t *testing.T, config *BGSConfig) *BGS {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	maindb, err := gorm.Open(sqlite.Open(filepath.Join(dir, "test.sqlite")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cardb, err := gorm.Open(sqlite.Open(filepath.Join(dir, "car.sqlite")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cspath := filepath.Join(dir, "carstore")
+	if err := os.Mkdir(cspath, 0775); err != nil {
+		t.Fatal(err)
+	}
+
+	cs, err := carstore.NewCarStore(cardb, []string{cspath})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repoman := repomgr.NewRepoManager(cs, &util.FakeKeyManager{})
+	notifman := notifs.NewNotificationManager(maindb, repoman.GetRecord)
+	evtman := events.NewEventManager(events.NewMemPersister())
+	didr := plc.NewFakeDid(maindb)
+	rf := indexer.NewRepoFetcher(maindb, repoman, 10)
+
+	ix, err := indexer.NewIndexer(maindb, notifman, evtman, didr, rf, true, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config == nil {
+		config = DefaultBGSConfig()
+	}
+	config.SSL = false
+
+	b, err := NewBGS(maindb, ix, repoman, evtman, didr, rf, &api.TestHandleResolver{}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		b.Shutdown()
+		ix.Shutdown()
+	})
+
+	return b
+}
+
+// testHost adds a PDS host record to the relay's database
+func testHost(t *testing.T, b *BGS, host string) *models.PDS {
+	t.Helper()
+
+	pds := &models.PDS{
+		Host:           host,
+		RateLimit:      10,
+		CrawlRateLimit: 10,
+	}
+	if err := b.db.Create(pds).Error; err != nil {
+		t.Fatal(err)
+	}
+	return pds
+}
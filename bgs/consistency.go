@@ -0,0 +1,358 @@
+package bgs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/carstore"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/models"
+)
+
+// Kinds of inconsistency found by the consistency checker
+const (
+	// active repo in the relay DB with no shards in the carstore
+	issueMissingShards = "missing_shards"
+	// repo with shard rows whose files are gone from disk
+	issueMissingShardFiles = "missing_shard_files"
+	// shards in the carstore for a repo the relay DB doesn't know about
+	issueOrphanShards = "orphan_shards"
+	// repo pointing at a PDS which isn't in the relay DB
+	issueMissingPDS = "missing_pds"
+)
+
+type ConsistencyCheckOptions struct {
+	// Repair, if set, fixes what can be fixed: repos missing shards (or shard
+	// files) are wiped and queued for resync from their PDS, and orphan shards
+	// are deleted. Sequence gaps can't be repaired, only reported.
+	Repair bool
+	// CheckShardFiles stats every shard file on disk, which is slow for large
+	// carstores
+	CheckShardFiles bool
+	// CheckEvents plays back persisted events after EventsSince (at most
+	// EventLimit of them) and checks their sequence numbers for gaps
+	CheckEvents bool
+	EventsSince int64
+	EventLimit  int64
+	// BatchSize is the number of repos checked per DB query
+	BatchSize int
+	// MaxIssues caps the number of issues listed in the report (all issues
+	// are still counted)
+	MaxIssues int
+}
+
+func DefaultConsistencyCheckOptions() *ConsistencyCheckOptions {
+	return &ConsistencyCheckOptions{
+		EventLimit: 1_000_000,
+		BatchSize:  1000,
+		MaxIssues:  1000,
+	}
+}
+
+type ConsistencyIssue struct {
+	Kind   string     `json:"kind"`
+	Uid    models.Uid `json:"uid"`
+	Did    string     `json:"did,omitempty"`
+	PDS    uint       `json:"pds,omitempty"`
+	Detail string     `json:"detail,omitempty"`
+	// action taken, if repairing: "resync", "wipe+resync", "wipe", or "failed"
+	Repair string `json:"repair,omitempty"`
+}
+
+type ConsistencyReport struct {
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	Running    bool       `json:"running"`
+	Repair     bool       `json:"repair"`
+
+	ReposChecked      int                         `json:"reposChecked"`
+	ShardReposChecked int                         `json:"shardReposChecked"`
+	Repaired          int                         `json:"repaired"`
+	RepairsFailed     int                         `json:"repairsFailed"`
+	IssueCounts       map[string]int              `json:"issueCounts"`
+	Issues            []ConsistencyIssue          `json:"issues"`
+	IssuesTruncated   bool                        `json:"issuesTruncated,omitempty"`
+	Events            *events.SeqContinuityReport `json:"events,omitempty"`
+	Error             string                      `json:"error,omitempty"`
+
+	maxIssues int
+}
+
+func (r *ConsistencyReport) add(issue ConsistencyIssue) {
+	r.IssueCounts[issue.Kind]++
+	consistencyIssuesFound.WithLabelValues(issue.Kind).Inc()
+	switch issue.Repair {
+	case "":
+	case "failed":
+		r.RepairsFailed++
+	default:
+		r.Repaired++
+	}
+	if len(r.Issues) < r.maxIssues {
+		r.Issues = append(r.Issues, issue)
+	} else {
+		r.IssuesTruncated = true
+	}
+}
+
+var ErrConsistencyCheckRunning = errors.New("consistency check already running")
+
+// consistencyChecker tracks the running (or last) consistency check
+type consistencyChecker struct {
+	lk      sync.Mutex
+	running bool
+	last    *ConsistencyReport
+}
+
+// StartConsistencyCheck runs a consistency check in the background. Progress
+// and results are available from ConsistencyReport.
+func (bgs *BGS) StartConsistencyCheck(opts *ConsistencyCheckOptions) error {
+	rep, err := bgs.beginConsistencyCheck(opts)
+	if err != nil {
+		return err
+	}
+	go bgs.runConsistencyCheck(context.Background(), opts, rep)
+	return nil
+}
+
+// RunConsistencyCheck runs a consistency check, cross-validating the relay
+// DB, carstore, and event log, and returns the report.
+func (bgs *BGS) RunConsistencyCheck(ctx context.Context, opts *ConsistencyCheckOptions) (*ConsistencyReport, error) {
+	rep, err := bgs.beginConsistencyCheck(opts)
+	if err != nil {
+		return nil, err
+	}
+	bgs.runConsistencyCheck(ctx, opts, rep)
+	if rep.Error != "" {
+		return rep, errors.New(rep.Error)
+	}
+	return rep, nil
+}
+
+// ConsistencyReport returns a copy of the report of the running or most
+// recent consistency check, or nil if none has run
+func (bgs *BGS) ConsistencyReport() *ConsistencyReport {
+	bgs.consistency.lk.Lock()
+	defer bgs.consistency.lk.Unlock()
+	if bgs.consistency.last == nil {
+		return nil
+	}
+	out := *bgs.consistency.last
+	out.IssueCounts = make(map[string]int, len(bgs.consistency.last.IssueCounts))
+	for k, v := range bgs.consistency.last.IssueCounts {
+		out.IssueCounts[k] = v
+	}
+	out.Issues = append([]ConsistencyIssue(nil), bgs.consistency.last.Issues...)
+	return &out
+}
+
+func (bgs *BGS) beginConsistencyCheck(opts *ConsistencyCheckOptions) (*ConsistencyReport, error) {
+	if _, ok := bgs.repoman.CarStore().(*carstore.FileCarStore); !ok {
+		return nil, fmt.Errorf("carstore does not support consistency checks")
+	}
+
+	bgs.consistency.lk.Lock()
+	defer bgs.consistency.lk.Unlock()
+	if bgs.consistency.running {
+		return nil, ErrConsistencyCheckRunning
+	}
+	bgs.consistency.running = true
+	rep := &ConsistencyReport{
+		StartedAt:   time.Now(),
+		Running:     true,
+		Repair:      opts.Repair,
+		IssueCounts: make(map[string]int),
+		maxIssues:   opts.MaxIssues,
+	}
+	bgs.consistency.last = rep
+	return rep, nil
+}
+
+// updates the shared report under lock, so readers see consistent progress
+func (bgs *BGS) updateConsistencyReport(f func()) {
+	bgs.consistency.lk.Lock()
+	defer bgs.consistency.lk.Unlock()
+	f()
+}
+
+func (bgs *BGS) runConsistencyCheck(ctx context.Context, opts *ConsistencyCheckOptions, rep *ConsistencyReport) {
+	start := time.Now()
+	log := bgs.log.With("check", "consistency")
+	log.Info("starting consistency check", "repair", opts.Repair, "checkShardFiles", opts.CheckShardFiles, "checkEvents", opts.CheckEvents)
+
+	err := bgs.checkRepoConsistency(ctx, opts, rep)
+	if err == nil {
+		err = bgs.checkShardOrphans(ctx, opts, rep)
+	}
+	if err == nil && opts.CheckEvents {
+		var seqrep *events.SeqContinuityReport
+		seqrep, err = bgs.events.CheckSeqContinuity(ctx, opts.EventsSince, opts.EventLimit, opts.MaxIssues)
+		if seqrep != nil {
+			consistencyIssuesFound.WithLabelValues("seq_gap").Add(float64(len(seqrep.Gaps)))
+		}
+		bgs.updateConsistencyReport(func() { rep.Events = seqrep })
+	}
+
+	bgs.updateConsistencyReport(func() {
+		now := time.Now()
+		rep.FinishedAt = &now
+		rep.Running = false
+		if err != nil {
+			rep.Error = err.Error()
+		}
+		bgs.consistency.running = false
+	})
+	consistencyCheckDuration.Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		consistencyChecksRun.WithLabelValues("error").Inc()
+		log.Error("consistency check failed", "err", err, "took", time.Since(start))
+		return
+	}
+	consistencyChecksRun.WithLabelValues("ok").Inc()
+	log.Info("consistency check finished", "repos", rep.ReposChecked, "issues", rep.IssueCounts, "repaired", rep.Repaired, "took", time.Since(start))
+}
+
+// checkRepoConsistency pages through every repo in the relay DB, checking it
+// has data in the carstore and a known PDS
+func (bgs *BGS) checkRepoConsistency(ctx context.Context, opts *ConsistencyCheckOptions, rep *ConsistencyReport) error {
+	cs := bgs.repoman.CarStore().(*carstore.FileCarStore)
+
+	var pdsIDs []uint
+	if err := bgs.db.Model(&models.PDS{}).Pluck("id", &pdsIDs).Error; err != nil {
+		return fmt.Errorf("listing PDSs: %w", err)
+	}
+	knownPDS := make(map[uint]bool, len(pdsIDs))
+	for _, id := range pdsIDs {
+		knownPDS[id] = true
+	}
+
+	var cursor models.Uid
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var users []User
+		if err := bgs.db.Model(&User{}).Where("id > ?", cursor).Order("id asc").Limit(opts.BatchSize).Find(&users).Error; err != nil {
+			return fmt.Errorf("listing users: %w", err)
+		}
+		if len(users) == 0 {
+			return nil
+		}
+		cursor = users[len(users)-1].ID
+
+		uids := make([]models.Uid, len(users))
+		for i := range users {
+			uids[i] = users[i].ID
+		}
+		checks, err := cs.CheckUserShards(ctx, uids, opts.CheckShardFiles)
+		if err != nil {
+			return fmt.Errorf("checking shards: %w", err)
+		}
+
+		for i := range users {
+			u := &users[i]
+			if !knownPDS[u.PDS] {
+				bgs.updateConsistencyReport(func() {
+					rep.add(ConsistencyIssue{Kind: issueMissingPDS, Uid: u.ID, Did: u.Did, PDS: u.PDS})
+				})
+			}
+
+			// taken down and tombstoned repos may legitimately have no data
+			if u.TakenDown || u.Tombstoned {
+				continue
+			}
+			chk := checks[u.ID]
+			switch {
+			case chk.Shards == 0:
+				issue := ConsistencyIssue{Kind: issueMissingShards, Uid: u.ID, Did: u.Did, PDS: u.PDS}
+				if opts.Repair {
+					issue.Repair = bgs.repairRepo(ctx, u, false, driftSourceConsistency)
+				}
+				bgs.updateConsistencyReport(func() { rep.add(issue) })
+			case len(chk.MissingFiles) > 0:
+				issue := ConsistencyIssue{
+					Kind:   issueMissingShardFiles,
+					Uid:    u.ID,
+					Did:    u.Did,
+					PDS:    u.PDS,
+					Detail: fmt.Sprintf("%d of %d shard files missing", len(chk.MissingFiles), chk.Shards),
+				}
+				if opts.Repair {
+					issue.Repair = bgs.repairRepo(ctx, u, true, driftSourceConsistency)
+				}
+				bgs.updateConsistencyReport(func() { rep.add(issue) })
+			}
+		}
+		bgs.updateConsistencyReport(func() { rep.ReposChecked += len(users) })
+	}
+}
+
+// checkShardOrphans pages through every repo in the carstore, looking for
+// ones the relay DB doesn't know about
+func (bgs *BGS) checkShardOrphans(ctx context.Context, opts *ConsistencyCheckOptions, rep *ConsistencyReport) error {
+	cs := bgs.repoman.CarStore().(*carstore.FileCarStore)
+
+	var cursor models.Uid
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		uids, err := cs.ShardUsers(ctx, cursor, opts.BatchSize)
+		if err != nil {
+			return fmt.Errorf("listing carstore repos: %w", err)
+		}
+		if len(uids) == 0 {
+			return nil
+		}
+		cursor = uids[len(uids)-1]
+
+		var known []models.Uid
+		if err := bgs.db.Model(&User{}).Where("id in (?)", uids).Pluck("id", &known).Error; err != nil {
+			return fmt.Errorf("looking up users: %w", err)
+		}
+		isKnown := make(map[models.Uid]bool, len(known))
+		for _, uid := range known {
+			isKnown[uid] = true
+		}
+
+		for _, uid := range uids {
+			if isKnown[uid] {
+				continue
+			}
+			issue := ConsistencyIssue{Kind: issueOrphanShards, Uid: uid}
+			if opts.Repair {
+				issue.Repair = "wipe"
+				if err := cs.WipeUserData(ctx, uid); err != nil {
+					bgs.log.Error("failed to wipe orphan shards", "uid", uid, "err", err)
+					issue.Repair = "failed"
+				}
+			}
+			bgs.updateConsistencyReport(func() { rep.add(issue) })
+		}
+		bgs.updateConsistencyReport(func() { rep.ShardReposChecked += len(uids) })
+	}
+}
+
+// repairRepo queues a repo for resync from its PDS, first wiping its local
+// data if that's unreadable (a fresh import doesn't replace old shards).
+// Returns the repair action taken.
+func (bgs *BGS) repairRepo(ctx context.Context, u *User, wipe bool, source string) string {
+	action := "resync"
+	if wipe {
+		action = "wipe+resync"
+		if err := bgs.repoman.CarStore().WipeUserData(ctx, u.ID); err != nil {
+			bgs.log.Error("failed to wipe repo for repair", "did", u.Did, "uid", u.ID, "err", err)
+			return "failed"
+		}
+	}
+	if !bgs.resyncer.Enqueue(u.ID, u.Did, u.PDS, ResyncPriorityLow, source) {
+		return "failed"
+	}
+	return action
+}
@@ -93,6 +93,8 @@ type activeSub struct {
 	lk     sync.RWMutex
 	ctx    context.Context
 	cancel func()
+	// ID of the push token, for connections pushed by the PDS
+	pushTokenID string
 }
 
 func NewSlurper(db *gorm.DB, cb IndexCallback, opts *SlurperOptions) (*Slurper, error) {
@@ -382,6 +384,11 @@ func (s *Slurper) SubscribeToPds(ctx context.Context, host string, reg bool, adm
 		return fmt.Errorf("cannot subscribe to blocked pds")
 	}
 
+	if peering.PushOnly {
+		// it will connect to us
+		return nil
+	}
+
 	if peering.ID == 0 {
 		if !adminOverride && !s.canSlurpHost(host) {
 			return ErrNewSubsDisabled
@@ -431,7 +438,7 @@ func (s *Slurper) RestartAll() error {
 	defer s.lk.Unlock()
 
 	var all []models.PDS
-	if err := s.db.Find(&all, "registered = true AND blocked = false").Error; err != nil {
+	if err := s.db.Find(&all, "registered = true AND blocked = false AND push_only = false").Error; err != nil {
 		return err
 	}
 
@@ -459,7 +466,10 @@ func (s *Slurper) subscribeWithRedialer(ctx context.Context, host *models.PDS, s
 		s.lk.Lock()
 		defer s.lk.Unlock()
 
-		delete(s.active, host.Host)
+		// the host may since have connected to push its events instead
+		if s.active[host.Host] == sub {
+			delete(s.active, host.Host)
+		}
 	}()
 
 	d := websocket.Dialer{
@@ -607,6 +617,21 @@ func (s *Slurper) handleConnection(ctx context.Context, host *models.PDS, con *w
 
 			return nil
 		},
+		RepoSync: func(evt *comatproto.SyncSubscribeRepos_Sync) error {
+			log.Info("got remote repo sync event", "pdsHost", host.Host, "did", evt.Did, "rev", evt.Rev)
+			if err := s.cb(context.TODO(), host, &events.XRPCStreamEvent{
+				RepoSync: evt,
+			}); err != nil {
+				log.Error("failed handling event", "host", host.Host, "seq", evt.Seq, "err", err)
+			}
+			*lastCursor = evt.Seq
+
+			if err := s.updateCursor(sub, *lastCursor); err != nil {
+				return fmt.Errorf("updating cursor: %w", err)
+			}
+
+			return nil
+		},
 		RepoInfo: func(info *comatproto.SyncSubscribeRepos_Info) error {
 			log.Info("info event", "name", info.Name, "message", info.Message, "pdsHost", host.Host)
 			return nil
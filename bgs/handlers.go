@@ -114,14 +114,13 @@ func (s *BGS) handleComAtprotoSyncGetRepo(ctx context.Context, did string, since
 		return nil, fmt.Errorf("account is suspended by its PDS")
 	}
 
-	// TODO: stream the response
-	buf := new(bytes.Buffer)
-	if err := s.repoman.ReadRepo(ctx, u.ID, since, buf); err != nil {
-		log.Error("failed to read repo into buffer", "err", err, "did", did)
-		return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to read repo into buffer")
+	out, err := s.repoman.StreamRepo(ctx, u.ID, since)
+	if err != nil {
+		log.Error("failed to open repo stream", "err", err, "did", did)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to read repo")
 	}
 
-	return buf, nil
+	return out, nil
 }
 
 func (s *BGS) handleComAtprotoSyncGetBlocks(ctx context.Context, cids []string, did string) (io.Reader, error) {
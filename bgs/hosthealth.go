@@ -0,0 +1,174 @@
+package bgs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/identity/pdshealth"
+	"github.com/bluesky-social/indigo/models"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// HostHealthRecord persists the latest health probe results for a PDS host
+type HostHealthRecord struct {
+	Host                string `gorm:"primarykey"`
+	Status              string
+	Reachable           bool
+	TLSValid            bool
+	TLSExpiry           *time.Time
+	Did                 string
+	LatencyMs           int64
+	LastError           string
+	ConsecutiveFailures int
+	LastChecked         time.Time
+	LastOK              *time.Time
+}
+
+// hostHealthStore is a gorm-backed pdshealth.Store
+type hostHealthStore struct {
+	db *gorm.DB
+}
+
+var _ pdshealth.Store = (*hostHealthStore)(nil)
+
+func (s *hostHealthStore) GetHost(ctx context.Context, host string) (*pdshealth.HostHealth, error) {
+	var rec HostHealthRecord
+	if err := s.db.WithContext(ctx).Where("host = ?", host).First(&rec).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return rec.toHostHealth(), nil
+}
+
+func (s *hostHealthStore) PutHost(ctx context.Context, h *pdshealth.HostHealth) error {
+	rec := HostHealthRecord{
+		Host:                h.Host,
+		Status:              string(h.Status),
+		Reachable:           h.Reachable,
+		TLSValid:            h.TLSValid,
+		TLSExpiry:           h.TLSExpiry,
+		Did:                 h.DID,
+		LatencyMs:           h.Latency.Milliseconds(),
+		LastError:           h.LastError,
+		ConsecutiveFailures: h.ConsecutiveFailures,
+		LastChecked:         h.LastChecked,
+		LastOK:              h.LastOK,
+	}
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(&rec).Error
+}
+
+func (s *hostHealthStore) ListHosts(ctx context.Context) ([]*pdshealth.HostHealth, error) {
+	var recs []HostHealthRecord
+	if err := s.db.WithContext(ctx).Find(&recs).Error; err != nil {
+		return nil, err
+	}
+	out := make([]*pdshealth.HostHealth, 0, len(recs))
+	for _, rec := range recs {
+		out = append(out, rec.toHostHealth())
+	}
+	return out, nil
+}
+
+func (rec *HostHealthRecord) toHostHealth() *pdshealth.HostHealth {
+	return &pdshealth.HostHealth{
+		Host:                rec.Host,
+		Status:              pdshealth.Status(rec.Status),
+		Reachable:           rec.Reachable,
+		TLSValid:            rec.TLSValid,
+		TLSExpiry:           rec.TLSExpiry,
+		DID:                 rec.Did,
+		Latency:             time.Duration(rec.LatencyMs) * time.Millisecond,
+		LastError:           rec.LastError,
+		ConsecutiveFailures: rec.ConsecutiveFailures,
+		LastChecked:         rec.LastChecked,
+		LastOK:              rec.LastOK,
+	}
+}
+
+// hostHealth periodically probes registered PDS hosts, so that repo fetches
+// (eg, resyncs) against hosts which are down can be put off in favour of ones
+// which will likely succeed
+type hostHealth struct {
+	registry *pdshealth.Registry
+	cancel   context.CancelFunc
+
+	// hostnames by PDS ID, as of the last probe round
+	lk    sync.RWMutex
+	hosts map[uint]string
+}
+
+func (bgs *BGS) startHostHealth(interval time.Duration) error {
+	if err := bgs.db.AutoMigrate(&HostHealthRecord{}); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	reg, err := pdshealth.NewRegistry(ctx, &hostHealthStore{db: bgs.db}, nil)
+	if err != nil {
+		cancel()
+		return err
+	}
+	reg.Logger = bgs.log.With("job", "hosthealth")
+
+	hh := &hostHealth{
+		registry: reg,
+		cancel:   cancel,
+		hosts:    make(map[uint]string),
+	}
+	bgs.hostHealth = hh
+	go reg.Run(ctx, interval, bgs.listHostsToProbe)
+	return nil
+}
+
+func (bgs *BGS) listHostsToProbe(ctx context.Context) ([]string, error) {
+	var all []models.PDS
+	if err := bgs.db.WithContext(ctx).Find(&all, "registered = true AND blocked = false").Error; err != nil {
+		return nil, err
+	}
+
+	scheme := "http://"
+	if bgs.ssl {
+		scheme = "https://"
+	}
+	byID := make(map[uint]string, len(all))
+	hosts := make([]string, 0, len(all))
+	for _, pds := range all {
+		byID[pds.ID] = pdshealth.NormalizeHost(pds.Host)
+		hosts = append(hosts, scheme+pds.Host)
+	}
+
+	bgs.hostHealth.lk.Lock()
+	bgs.hostHealth.hosts = byID
+	bgs.hostHealth.lk.Unlock()
+	return hosts, nil
+}
+
+// isHostUnhealthy returns true if the PDS has failed its recent health
+// probes. Always false if health probing isn't enabled.
+func (bgs *BGS) isHostUnhealthy(pdsID uint) bool {
+	hh := bgs.hostHealth
+	if hh == nil {
+		return false
+	}
+	hh.lk.RLock()
+	host, ok := hh.hosts[pdsID]
+	hh.lk.RUnlock()
+	if !ok {
+		return false
+	}
+	return hh.registry.IsUnhealthy(host)
+}
+
+func (bgs *BGS) handleAdminListHostHealth(e echo.Context) error {
+	if bgs.hostHealth == nil {
+		return echo.NewHTTPError(404, "host health probing is not enabled")
+	}
+	return e.JSON(200, bgs.hostHealth.registry.Hosts())
+}
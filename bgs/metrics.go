@@ -37,6 +37,26 @@ var rebasesCounter = promauto.NewCounterVec(prometheus.CounterOpts{
 	Help: "The total number of rebase events received",
 }, []string{"pds"})
 
+var invalidEventsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "bgs_invalid_events",
+	Help: "The total number of events that failed validation, by upstream host",
+}, []string{"pds"})
+
+var quarantinedEventsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "bgs_quarantined_events",
+	Help: "The total number of events received from quarantined hosts, which are not relayed",
+}, []string{"pds"})
+
+var quarantinedHosts = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "bgs_quarantined_hosts",
+	Help: "Number of PDS hosts currently in quarantine",
+})
+
+var hostQuarantinesCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "bgs_host_quarantines",
+	Help: "The total number of times a PDS host has been quarantined",
+}, []string{"pds"})
+
 var eventsSentCounter = promauto.NewCounterVec(prometheus.CounterOpts{
 	Name: "events_sent_counter",
 	Help: "The total number of events sent to consumers",
@@ -52,6 +72,21 @@ var connectedInbound = promauto.NewGauge(prometheus.GaugeOpts{
 	Help: "Number of inbound firehoses we are consuming",
 })
 
+var mirrorConnected = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "bgs_mirror_connected",
+	Help: "Whether the outbound mirror connection to a downstream relay is currently up",
+})
+
+var mirrorEventsSent = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "bgs_mirror_events_sent",
+	Help: "The total number of events forwarded to a downstream relay",
+})
+
+var mirrorEventsReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "bgs_mirror_events_received",
+	Help: "The total number of events received from upstream relays in mirror mode",
+}, []string{"status"})
+
 var compactionDuration = promauto.NewHistogram(prometheus.HistogramOpts{
 	Name:    "compaction_duration",
 	Help:    "A histogram of compaction latencies",
@@ -166,3 +201,75 @@ func computeApproximateRequestSize(r *http.Request) int {
 	}
 	return s
 }
+
+var resyncQueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "bgs_resync_queue_depth",
+	Help: "Number of repos waiting to be resynced, by priority",
+}, []string{"priority"})
+
+var resyncsActive = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "bgs_resyncs_active",
+	Help: "Number of repos currently being resynced",
+})
+
+var resyncsCompleted = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "bgs_resyncs_completed",
+	Help: "The total number of repo resyncs attempted, by priority and result",
+}, []string{"priority", "status"})
+
+var resyncsDropped = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "bgs_resyncs_dropped",
+	Help: "The total number of repos not queued for resync because the queue was full",
+})
+
+var resyncRetries = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "bgs_resync_retries",
+	Help: "The total number of failed repo resyncs queued to be tried again",
+})
+
+var resyncsFromUnhealthyHosts = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "bgs_resyncs_unhealthy_host",
+	Help: "The total number of resyncs started from hosts failing health probes, because nothing else was queued",
+})
+
+var resyncDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "bgs_resync_duration_seconds",
+	Help:    "A histogram of how long full repo resyncs take",
+	Buckets: prometheus.ExponentialBuckets(0.05, 2, 14),
+})
+
+var repoDriftDetected = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "bgs_repo_drift_detected",
+	Help: "The total number of repos found to have diverged from upstream, by how it was detected",
+}, []string{"source"})
+
+var driftChecks = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "bgs_drift_sample_checks",
+	Help: "The total number of sampled repos checked against upstream, by result",
+}, []string{"result"})
+
+var pushConnections = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "bgs_push_connections",
+	Help: "The total number of push connections attempted by PDSes, by result",
+}, []string{"result"})
+
+var subTokenChecks = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "bgs_subscription_token_checks",
+	Help: "The total number of firehose subscription token checks, by result",
+}, []string{"result"})
+
+var consistencyChecksRun = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "bgs_consistency_checks",
+	Help: "The total number of consistency checks run, by result",
+}, []string{"result"})
+
+var consistencyCheckDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "bgs_consistency_check_duration_seconds",
+	Help:    "A histogram of how long consistency checks take",
+	Buckets: prometheus.ExponentialBuckets(1, 2, 16),
+})
+
+var consistencyIssuesFound = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "bgs_consistency_issues_found",
+	Help: "The total number of inconsistencies found by consistency checks, by kind",
+}, []string{"kind"})
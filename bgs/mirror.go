@@ -0,0 +1,290 @@
+package bgs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/events/schedulers/sequential"
+	"github.com/bluesky-social/indigo/models"
+
+	"github.com/carlmjohnson/versioninfo"
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// MirrorCursor records the last event sequence number forwarded to a
+// downstream relay, so mirroring can resume after a restart
+type MirrorCursor struct {
+	URL    string `gorm:"primarykey"`
+	Cursor int64
+}
+
+// Mirror forwards this relay's output stream (events which have already been
+// validated and sequenced) to the mirror ingestion endpoint of a downstream
+// relay. This allows relays to be arranged hierarchically, eg with regional
+// relays fed from a single upstream relay instead of crawling every PDS.
+type Mirror struct {
+	bgs *BGS
+	log *slog.Logger
+
+	// base URL of the downstream relay, eg "wss://relay2.example.com"
+	url string
+	// admin token for the downstream relay
+	token string
+
+	cursorLk sync.Mutex
+	cursor   int64
+	dirty    bool
+}
+
+func newMirror(bgs *BGS, url, token string) (*Mirror, error) {
+	if err := bgs.db.AutoMigrate(&MirrorCursor{}); err != nil {
+		return nil, err
+	}
+
+	m := &Mirror{
+		bgs:   bgs,
+		log:   bgs.log.With("mirror", url),
+		url:   strings.TrimSuffix(url, "/"),
+		token: token,
+	}
+
+	var mc MirrorCursor
+	if err := bgs.db.Where("url = ?", m.url).First(&mc).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("loading mirror cursor: %w", err)
+		}
+	} else {
+		m.cursor = mc.Cursor
+	}
+
+	return m, nil
+}
+
+// Run forwards events until the context is cancelled, reconnecting to the
+// downstream relay as needed
+func (m *Mirror) Run(ctx context.Context) {
+	go m.flushCursorLoop(ctx)
+
+	var backoff int
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		before := m.getCursor()
+		if err := m.stream(ctx); err != nil && ctx.Err() == nil {
+			m.log.Warn("mirror connection failed", "err", err, "backoff", backoff)
+		}
+		mirrorConnected.Set(0)
+
+		if m.getCursor() > before {
+			backoff = 0
+		}
+		time.Sleep(sleepForBackoff(backoff))
+		if backoff < 15 {
+			backoff++
+		}
+	}
+}
+
+func (m *Mirror) stream(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	d := websocket.Dialer{
+		HandshakeTimeout: time.Second * 5,
+	}
+	hdr := http.Header{}
+	hdr.Set("Authorization", "Bearer "+m.token)
+	hdr.Set("User-Agent", "indigo-relay-mirror/"+versioninfo.Short())
+
+	con, _, err := d.DialContext(ctx, m.url+"/admin/mirror/ingest", hdr)
+	if err != nil {
+		return fmt.Errorf("dialing downstream relay: %w", err)
+	}
+	defer con.Close()
+
+	mirrorConnected.Set(1)
+
+	// the downstream never sends data messages, but we need to read to
+	// process control frames and notice disconnects
+	go func() {
+		for {
+			if _, _, err := con.ReadMessage(); err != nil {
+				cancel()
+				return
+			}
+		}
+	}()
+
+	var since *int64
+	if cur := m.getCursor(); cur > 0 {
+		since = &cur
+	}
+	m.log.Info("mirroring events to downstream relay", "cursor", since)
+
+	evts, cleanup, err := m.bgs.events.Subscribe(ctx, "mirror-"+m.url, func(evt *events.XRPCStreamEvent) bool { return true }, since)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	for {
+		select {
+		case evt, ok := <-evts:
+			if !ok {
+				return fmt.Errorf("event stream closed")
+			}
+
+			if err := events.WriteEvent(con, evt); err != nil {
+				return err
+			}
+
+			mirrorEventsSent.Inc()
+			if seq := evt.Sequence(); seq > 0 {
+				m.setCursor(seq)
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (m *Mirror) getCursor() int64 {
+	m.cursorLk.Lock()
+	defer m.cursorLk.Unlock()
+	return m.cursor
+}
+
+func (m *Mirror) setCursor(seq int64) {
+	m.cursorLk.Lock()
+	defer m.cursorLk.Unlock()
+	m.cursor = seq
+	m.dirty = true
+}
+
+func (m *Mirror) flushCursorLoop(ctx context.Context) {
+	t := time.NewTicker(time.Second * 10)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := m.flushCursor(); err != nil {
+				m.log.Error("failed to persist mirror cursor", "err", err)
+			}
+		case <-ctx.Done():
+			if err := m.flushCursor(); err != nil {
+				m.log.Error("failed to persist mirror cursor", "err", err)
+			}
+			return
+		}
+	}
+}
+
+func (m *Mirror) flushCursor() error {
+	m.cursorLk.Lock()
+	if !m.dirty {
+		m.cursorLk.Unlock()
+		return nil
+	}
+	mc := MirrorCursor{URL: m.url, Cursor: m.cursor}
+	m.dirty = false
+	m.cursorLk.Unlock()
+
+	return m.bgs.db.Save(&mc).Error
+}
+
+// handleAdminMirrorIngest accepts a websocket stream of events pushed by an
+// upstream relay running in mirror mode. Events are attributed to the account's
+// current PDS and processed exactly as if they had been received from that PDS,
+// so commits are still verified before being re-emitted.
+func (bgs *BGS) handleAdminMirrorIngest(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	con, err := websocket.Upgrade(c.Response(), c.Request(), c.Response().Header(), 10<<10, 10<<10)
+	if err != nil {
+		return fmt.Errorf("upgrading websocket: %w", err)
+	}
+
+	remote := c.RealIP()
+	bgs.log.Info("accepted mirror connection from upstream relay", "remote_addr", remote)
+
+	sched := sequential.NewScheduler("mirror-"+remote, bgs.handleMirroredEvent)
+	if err := events.HandleRepoStream(ctx, con, sched, bgs.log); err != nil {
+		bgs.log.Warn("mirror connection closed", "remote_addr", remote, "err", err)
+	}
+	return nil
+}
+
+func (bgs *BGS) handleMirroredEvent(ctx context.Context, xev *events.XRPCStreamEvent) error {
+	var did string
+	switch {
+	case xev.RepoCommit != nil:
+		did = xev.RepoCommit.Repo
+	case xev.RepoSync != nil:
+		did = xev.RepoSync.Did
+	case xev.RepoHandle != nil:
+		did = xev.RepoHandle.Did
+	case xev.RepoIdentity != nil:
+		did = xev.RepoIdentity.Did
+	case xev.RepoAccount != nil:
+		did = xev.RepoAccount.Did
+	case xev.RepoMigrate != nil:
+		did = xev.RepoMigrate.Did
+	case xev.RepoTombstone != nil:
+		did = xev.RepoTombstone.Did
+	default:
+		// #info and other non-repo frames aren't forwarded
+		return nil
+	}
+
+	host, err := bgs.mirroredEventHost(ctx, did)
+	if err != nil {
+		mirrorEventsReceived.WithLabelValues("nohost").Inc()
+		bgs.log.Warn("failed to find host for mirrored event", "did", did, "seq", xev.Sequence(), "err", err)
+		return nil
+	}
+
+	if err := bgs.handleFedEvent(ctx, host, xev); err != nil {
+		mirrorEventsReceived.WithLabelValues("err").Inc()
+		bgs.log.Warn("failed to handle mirrored event", "did", did, "seq", xev.Sequence(), "pdsHost", host.Host, "err", err)
+		return nil
+	}
+	mirrorEventsReceived.WithLabelValues("ok").Inc()
+	return nil
+}
+
+// returns the PDS which is currently authoritative for the account, resolving
+// the account's identity if it hasn't been seen before
+func (bgs *BGS) mirroredEventHost(ctx context.Context, did string) (*models.PDS, error) {
+	var pdsID uint
+	u, err := bgs.lookupUserByDid(ctx, did)
+	if err == nil && u.PDS != 0 {
+		pdsID = u.PDS
+	} else if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	} else {
+		ai, err := bgs.createExternalUser(ctx, did)
+		if err != nil {
+			return nil, err
+		}
+		pdsID = ai.PDS
+	}
+
+	var host models.PDS
+	if err := bgs.db.First(&host, pdsID).Error; err != nil {
+		return nil, fmt.Errorf("looking up pds %d: %w", pdsID, err)
+	}
+	return &host, nil
+}
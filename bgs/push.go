@@ -0,0 +1,289 @@
+package bgs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/models"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+)
+
+// PushCursorHeader is set on the websocket upgrade response of a push
+// connection, to the last sequence number the relay has from the host. The
+// PDS should send events after it.
+const PushCursorHeader = "Atproto-Relay-Cursor"
+
+// prefix of the signed payload of push tokens, so they can't be confused
+// with subscription tokens
+const pushTokenDomain = "push:"
+
+// lifetime of push tokens issued without an explicit TTL
+const defaultPushTokenTTL = 90 * 24 * time.Hour
+
+var ErrPushHostBlocked = errors.New("host is blocked")
+
+// PushClaims are the claims in a push token. A PDS without public ingress (eg,
+// one hosted at home, behind NAT) can present a push token to open a
+// connection to the relay and push its event stream, instead of the relay
+// dialing out to it ("reverse connection mode"). Push tokens are issued by the
+// admin API and signed with the subscription token key, and can be revoked
+// like subscription tokens, by their ID.
+//
+// The token only establishes which host is pushing: events are handled just
+// like those from a dialed host, so a PDS can't push events for accounts
+// which aren't hosted on it.
+type PushClaims struct {
+	// Hostname (and port, if not the default) of the PDS
+	Host string `json:"host"`
+	// Random token ID
+	ID  string `json:"jti"`
+	Iat int64  `json:"iat"`
+	// Expiry (unix seconds)
+	Exp int64 `json:"exp,omitempty"`
+}
+
+func SignPushToken(key []byte, claims *PushClaims) (string, error) {
+	return signToken(key, pushTokenDomain, claims)
+}
+
+// VerifyPushToken checks the signature and expiry of a push token, and
+// returns its claims
+func VerifyPushToken(key []byte, token string) (*PushClaims, error) {
+	var claims PushClaims
+	if err := verifyToken(key, pushTokenDomain, token, &claims); err != nil {
+		return nil, err
+	}
+	if claims.Exp != 0 && time.Now().After(time.Unix(claims.Exp, 0)) {
+		return nil, ErrSubTokenExpired
+	}
+	if claims.Host == "" {
+		return nil, fmt.Errorf("%w: no host", ErrSubTokenInvalid)
+	}
+	return &claims, nil
+}
+
+// handlePushRepos accepts a websocket connection from a PDS pushing its
+// com.atproto.sync.subscribeRepos stream
+func (bgs *BGS) handlePushRepos(c echo.Context) error {
+	if len(bgs.subTokenKey) == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "push connections are not enabled")
+	}
+
+	claims, err := VerifyPushToken(bgs.subTokenKey, subscriptionTokenFromRequest(c))
+	if err != nil {
+		pushConnections.WithLabelValues("unauthorized").Inc()
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+	if bgs.subTokenRevoked(claims.ID) {
+		pushConnections.WithLabelValues("revoked").Inc()
+		return echo.NewHTTPError(http.StatusUnauthorized, ErrSubTokenRevoked.Error())
+	}
+	host := claims.Host
+
+	banned, err := bgs.domainIsBanned(c.Request().Context(), host)
+	if err != nil {
+		return err
+	}
+	if banned {
+		pushConnections.WithLabelValues("banned").Inc()
+		return echo.NewHTTPError(http.StatusForbidden, "domain is banned")
+	}
+
+	sub, err := bgs.slurper.startPush(host, claims.ID)
+	if err != nil {
+		if errors.Is(err, ErrPushHostBlocked) {
+			pushConnections.WithLabelValues("blocked").Inc()
+			return echo.NewHTTPError(http.StatusForbidden, err.Error())
+		}
+		return err
+	}
+
+	hdr := c.Response().Header()
+	hdr.Set(PushCursorHeader, strconv.FormatInt(sub.pds.Cursor, 10))
+	con, err := websocket.Upgrade(c.Response(), c.Request(), hdr, 10<<10, 10<<10)
+	if err != nil {
+		bgs.slurper.endPush(sub, sub.pds.Cursor)
+		return fmt.Errorf("upgrading websocket: %w", err)
+	}
+
+	pushConnections.WithLabelValues("ok").Inc()
+	bgs.log.Info("accepted push connection", "pdsHost", host, "remote_addr", c.RealIP(), "cursor", sub.pds.Cursor, "jti", claims.ID)
+	if err := bgs.slurper.runPush(sub, con); err != nil && !errors.Is(err, context.Canceled) {
+		bgs.log.Warn("push connection closed", "pdsHost", host, "err", err)
+	}
+	return nil
+}
+
+// startPush registers a push connection from host as its active subscription,
+// replacing any existing one (eg, a connection the PDS has since abandoned)
+func (s *Slurper) startPush(host, tokenID string) (*activeSub, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	var peering models.PDS
+	if err := s.db.Find(&peering, "host = ?", host).Error; err != nil {
+		return nil, err
+	}
+	if peering.Blocked {
+		return nil, ErrPushHostBlocked
+	}
+
+	if old, ok := s.active[host]; ok {
+		old.cancel()
+		delete(s.active, host)
+		// the in-memory cursor may be ahead of the database
+		old.lk.RLock()
+		if old.pds.Cursor > peering.Cursor {
+			peering.Cursor = old.pds.Cursor
+		}
+		old.lk.RUnlock()
+	}
+
+	if peering.ID == 0 {
+		// the push token was issued by an admin, so this skips the new host limits
+		peering = models.PDS{
+			Host:             host,
+			SSL:              s.ssl,
+			Registered:       true,
+			PushOnly:         true,
+			RateLimit:        float64(s.DefaultPerSecondLimit),
+			HourlyEventLimit: s.DefaultPerHourLimit,
+			DailyEventLimit:  s.DefaultPerDayLimit,
+			CrawlRateLimit:   float64(s.DefaultCrawlLimit),
+			RepoLimit:        s.DefaultRepoLimit,
+		}
+		if err := s.db.Create(&peering).Error; err != nil {
+			return nil, err
+		}
+	} else if !peering.Registered || !peering.PushOnly {
+		if err := s.db.Model(models.PDS{}).Where("id = ?", peering.ID).Updates(map[string]any{"registered": true, "push_only": true}).Error; err != nil {
+			return nil, err
+		}
+		peering.Registered = true
+		peering.PushOnly = true
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sub := &activeSub{
+		pds:         &peering,
+		ctx:         ctx,
+		cancel:      cancel,
+		pushTokenID: tokenID,
+	}
+	s.active[host] = sub
+	s.GetOrCreateLimiters(peering.ID, int64(peering.RateLimit), peering.HourlyEventLimit, peering.DailyEventLimit)
+	return sub, nil
+}
+
+// runPush consumes events pushed over con until it closes, or the
+// subscription is cancelled (eg, by the admin API or a newer push connection)
+func (s *Slurper) runPush(sub *activeSub, con *websocket.Conn) error {
+	connectedInbound.Inc()
+	defer connectedInbound.Dec()
+
+	cursor := sub.pds.Cursor
+	defer func() { s.endPush(sub, cursor) }()
+	return s.handleConnection(sub.ctx, sub.pds, con, &cursor, sub)
+}
+
+// endPush removes a push subscription, and persists its cursor (since the
+// periodic flush only covers active subscriptions)
+func (s *Slurper) endPush(sub *activeSub, cursor int64) {
+	sub.cancel()
+
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if s.active[sub.pds.Host] == sub {
+		delete(s.active, sub.pds.Host)
+	}
+	if err := s.db.Model(models.PDS{}).Where("id = ?", sub.pds.ID).Update("cursor", cursor).Error; err != nil {
+		log.Error("failed to persist cursor for push connection", "pdsHost", sub.pds.Host, "err", err)
+	}
+}
+
+// cancelPushToken disconnects push connections which were opened with the
+// push token with the given ID, and returns how many there were
+func (s *Slurper) cancelPushToken(id string) int {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	n := 0
+	for _, sub := range s.active {
+		if sub.pushTokenID == id {
+			sub.cancel()
+			n++
+		}
+	}
+	return n
+}
+
+type issuePushTokenBody struct {
+	// Hostname of the PDS, eg "pds.example.com"
+	Host string `json:"host"`
+	// Token lifetime in seconds; zero for the default (defaultPushTokenTTL)
+	TTL int `json:"ttl"`
+}
+
+type issuePushTokenResponse struct {
+	Token  string      `json:"token"`
+	Claims *PushClaims `json:"claims"`
+}
+
+func (bgs *BGS) handleAdminIssuePushToken(e echo.Context) error {
+	if len(bgs.subTokenKey) == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "push connections are not enabled")
+	}
+
+	var body issuePushTokenBody
+	if err := e.Bind(&body); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	host := strings.TrimSpace(body.Host)
+	if strings.Contains(host, "://") {
+		u, err := url.Parse(host)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "failed to parse hostname")
+		}
+		host = u.Host
+	}
+	if host == "" || strings.ContainsAny(host, "/?#") {
+		return echo.NewHTTPError(http.StatusBadRequest, "must specify a hostname")
+	}
+	if body.TTL < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "ttl must not be negative")
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	ttl := defaultPushTokenTTL
+	if body.TTL > 0 {
+		ttl = time.Duration(body.TTL) * time.Second
+	}
+	now := time.Now()
+	claims := &PushClaims{
+		Host: host,
+		ID:   hex.EncodeToString(nonce),
+		Iat:  now.Unix(),
+		Exp:  now.Add(ttl).Unix(),
+	}
+
+	tok, err := SignPushToken(bgs.subTokenKey, claims)
+	if err != nil {
+		return err
+	}
+
+	bgs.log.Info("issued push token", "pdsHost", claims.Host, "jti", claims.ID)
+	return e.JSON(http.StatusOK, issuePushTokenResponse{Token: tok, Claims: claims})
+}
@@ -0,0 +1,96 @@
+package bgs
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func issueTestPushToken(t *testing.T, e *echo.Echo, body string) *issuePushTokenResponse {
+	t.Helper()
+
+	rec := postJSON(e, "/admin/pds/issuePushToken", body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("issuing push token: %d %s", rec.Code, rec.Body.String())
+	}
+	var out issuePushTokenResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	return &out
+}
+
+func TestIssuePushToken(t *testing.T) {
+	assert := assert.New(t)
+	_, e := testSubTokenBGS(t)
+
+	// tokens always expire, by default after defaultPushTokenTTL
+	out := issueTestPushToken(t, e, `{"host": "https://pds.example.com"}`)
+	assert.Equal("pds.example.com", out.Claims.Host)
+	assert.NotEmpty(out.Claims.ID)
+	assert.InDelta(time.Now().Add(defaultPushTokenTTL).Unix(), out.Claims.Exp, 5)
+
+	out = issueTestPushToken(t, e, `{"host": "pds.example.com", "ttl": 3600}`)
+	assert.InDelta(time.Now().Add(time.Hour).Unix(), out.Claims.Exp, 5)
+
+	claims, err := VerifyPushToken(testSubTokenKey, out.Token)
+	assert.NoError(err)
+	assert.Equal(out.Claims, claims)
+
+	for _, body := range []string{
+		`{"host": ""}`,
+		`{"host": "pds.example.com/path"}`,
+		`{"host": "pds.example.com", "ttl": -1}`,
+	} {
+		assert.Equal(http.StatusBadRequest, postJSON(e, "/admin/pds/issuePushToken", body).Code, body)
+	}
+}
+
+func TestRevokePushToken(t *testing.T) {
+	assert := assert.New(t)
+	_, e := testSubTokenBGS(t)
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	out := issueTestPushToken(t, e, `{"host": "pds.example.com"}`)
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/push/subscribeRepos"
+	dial := func(tok string) (*websocket.Conn, *http.Response, error) {
+		h := http.Header{}
+		h.Set("Authorization", "Bearer "+tok)
+		return websocket.DefaultDialer.Dial(url, h)
+	}
+
+	con, resp, err := dial(out.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer con.Close()
+	assert.Equal("0", resp.Header.Get(PushCursorHeader))
+
+	rec := postJSON(e, "/admin/subs/revokeToken", `{"id": "`+out.Claims.ID+`"}`)
+	assert.Equal(http.StatusOK, rec.Code, rec.Body.String())
+	assert.JSONEq(`{"success": "true", "disconnected": 1}`, rec.Body.String())
+
+	// the open push connection is closed
+	con.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = con.ReadMessage()
+	var ne interface{ Timeout() bool }
+	if errors.As(err, &ne) && ne.Timeout() {
+		t.Fatal("push connection with revoked token not closed")
+	}
+
+	// and the token can't be used again
+	_, resp, err = dial(out.Token)
+	if assert.Error(err) && assert.NotNil(resp) {
+		assert.Equal(http.StatusUnauthorized, resp.StatusCode)
+	}
+}
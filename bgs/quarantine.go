@@ -0,0 +1,291 @@
+package bgs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/carstore"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/models"
+	"github.com/bluesky-social/indigo/repomgr"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// hostQuarantine tracks invalid events per PDS host, and the set of hosts
+// currently in quarantine. A quarantined host stays subscribed and its events
+// are still applied and persisted, so repo state stays current, but they are
+// not fanned out to live consumers.
+type hostQuarantine struct {
+	lk sync.Mutex
+
+	// threshold is the number of invalid events within window that trips a
+	// quarantine. zero disables automatic quarantine.
+	threshold int
+	window    time.Duration
+
+	failures    map[uint][]time.Time
+	quarantined map[uint]bool
+
+	// onQuarantine, if set, is called whenever a host is quarantined
+	onQuarantine func(host *models.PDS, reason string)
+}
+
+func newHostQuarantine(threshold int, window time.Duration) *hostQuarantine {
+	return &hostQuarantine{
+		threshold:   threshold,
+		window:      window,
+		failures:    make(map[uint][]time.Time),
+		quarantined: make(map[uint]bool),
+	}
+}
+
+func (hq *hostQuarantine) isQuarantined(pdsID uint) bool {
+	hq.lk.Lock()
+	defer hq.lk.Unlock()
+	return hq.quarantined[pdsID]
+}
+
+// set updates the quarantine state of a host, and returns false if it was
+// already in the requested state
+func (hq *hostQuarantine) set(pdsID uint, v bool) bool {
+	hq.lk.Lock()
+	defer hq.lk.Unlock()
+	if hq.quarantined[pdsID] == v {
+		return false
+	}
+	if v {
+		hq.quarantined[pdsID] = true
+	} else {
+		delete(hq.quarantined, pdsID)
+	}
+	delete(hq.failures, pdsID)
+	return true
+}
+
+// recordFailure notes an invalid event from the given host, and returns the
+// number of failures in the current window along with whether the host should
+// now be quarantined
+func (hq *hostQuarantine) recordFailure(pdsID uint, now time.Time) (int, bool) {
+	hq.lk.Lock()
+	defer hq.lk.Unlock()
+
+	if hq.quarantined[pdsID] {
+		return 0, false
+	}
+
+	cutoff := now.Add(-hq.window)
+	recent := hq.failures[pdsID]
+	i := 0
+	for i < len(recent) && recent[i].Before(cutoff) {
+		i++
+	}
+	recent = append(recent[i:], now)
+	hq.failures[pdsID] = recent
+
+	if hq.threshold <= 0 || len(recent) < hq.threshold {
+		return len(recent), false
+	}
+
+	return len(recent), true
+}
+
+func (hq *hostQuarantine) recentFailures(pdsID uint, now time.Time) int {
+	hq.lk.Lock()
+	defer hq.lk.Unlock()
+
+	cutoff := now.Add(-hq.window)
+	n := 0
+	for _, t := range hq.failures[pdsID] {
+		if !t.Before(cutoff) {
+			n++
+		}
+	}
+	return n
+}
+
+// isInvalidEventErr returns true for event handling errors that indicate the
+// upstream host emitted a bad event, as opposed to a local failure. Base
+// mismatches are not counted: they usually mean we missed events, and are
+// repaired by a resync.
+func isInvalidEventErr(err error) bool {
+	return errors.Is(err, repomgr.ErrInvalidSignature) || errors.Is(err, carstore.ErrMalformedCarSlice)
+}
+
+// fanoutFilter withholds events from quarantined hosts from live consumers.
+// Such events are still applied and persisted.
+func (bgs *BGS) fanoutFilter(evt *events.XRPCStreamEvent) bool {
+	return evt.PrivPdsId == 0 || !bgs.quarantine.isQuarantined(evt.PrivPdsId)
+}
+
+func (bgs *BGS) loadQuarantinedHosts() error {
+	var hosts []models.PDS
+	if err := bgs.db.Find(&hosts, "quarantined = ?", true).Error; err != nil {
+		return err
+	}
+
+	for _, h := range hosts {
+		if bgs.quarantine.set(h.ID, true) {
+			quarantinedHosts.Inc()
+		}
+	}
+
+	return nil
+}
+
+// noteInvalidEvent is called when an event from the given host failed
+// validation. If the host crosses the configured threshold it is quarantined.
+func (bgs *BGS) noteInvalidEvent(ctx context.Context, host *models.PDS, evtErr error) {
+	invalidEventsCounter.WithLabelValues(host.Host).Inc()
+
+	n, trip := bgs.quarantine.recordFailure(host.ID, time.Now())
+	if !trip {
+		return
+	}
+
+	reason := fmt.Sprintf("automatic: %d invalid events within %s (last: %s)", n, bgs.quarantine.window, evtErr)
+	if err := bgs.QuarantineHost(ctx, host.ID, reason); err != nil {
+		bgs.log.Error("failed to quarantine host", "pdsHost", host.Host, "err", err)
+	}
+}
+
+// QuarantineHost stops fan-out of events from the given PDS while continuing
+// to consume and apply its stream
+func (bgs *BGS) QuarantineHost(ctx context.Context, pdsID uint, reason string) error {
+	if !bgs.quarantine.set(pdsID, true) {
+		return nil
+	}
+
+	now := time.Now()
+	if err := bgs.db.Model(&models.PDS{}).Where("id = ?", pdsID).Updates(map[string]any{
+		"quarantined":       true,
+		"quarantine_reason": reason,
+		"quarantined_at":    &now,
+	}).Error; err != nil {
+		bgs.quarantine.set(pdsID, false)
+		return err
+	}
+
+	quarantinedHosts.Inc()
+
+	var host models.PDS
+	if err := bgs.db.First(&host, "id = ?", pdsID).Error; err != nil {
+		return err
+	}
+
+	hostQuarantinesCounter.WithLabelValues(host.Host).Inc()
+	bgs.log.Error("QUARANTINED PDS HOST: events will not be relayed until released", "pdsHost", host.Host, "pdsID", pdsID, "reason", reason)
+
+	if bgs.quarantine.onQuarantine != nil {
+		bgs.quarantine.onQuarantine(&host, reason)
+	}
+
+	return nil
+}
+
+// ReleaseHost lifts a quarantine on the given PDS
+func (bgs *BGS) ReleaseHost(ctx context.Context, pdsID uint) error {
+	if err := bgs.db.Model(&models.PDS{}).Where("id = ?", pdsID).Updates(map[string]any{
+		"quarantined":       false,
+		"quarantine_reason": "",
+		"quarantined_at":    nil,
+	}).Error; err != nil {
+		return err
+	}
+
+	if bgs.quarantine.set(pdsID, false) {
+		quarantinedHosts.Dec()
+	}
+
+	bgs.log.Warn("released quarantined PDS host", "pdsID", pdsID)
+	return nil
+}
+
+type quarantinedHost struct {
+	Host           string     `json:"host"`
+	Reason         string     `json:"reason"`
+	QuarantinedAt  *time.Time `json:"quarantinedAt"`
+	RecentFailures int        `json:"recentFailures"`
+}
+
+func (bgs *BGS) lookupPDSByHost(host string) (*models.PDS, error) {
+	host = strings.TrimSpace(host)
+	if host == "" {
+		return nil, &echo.HTTPError{
+			Code:    400,
+			Message: "must pass a valid host",
+		}
+	}
+
+	var pds models.PDS
+	if err := bgs.db.First(&pds, "host = ?", host).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &echo.HTTPError{
+				Code:    404,
+				Message: "no such host",
+			}
+		}
+		return nil, err
+	}
+
+	return &pds, nil
+}
+
+func (bgs *BGS) handleAdminListQuarantined(e echo.Context) error {
+	var hosts []models.PDS
+	if err := bgs.db.Find(&hosts, "quarantined = ?", true).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+	out := make([]quarantinedHost, 0, len(hosts))
+	for _, h := range hosts {
+		out = append(out, quarantinedHost{
+			Host:           h.Host,
+			Reason:         h.QuarantineReason,
+			QuarantinedAt:  h.QuarantinedAt,
+			RecentFailures: bgs.quarantine.recentFailures(h.ID, now),
+		})
+	}
+
+	return e.JSON(200, out)
+}
+
+func (bgs *BGS) handleAdminQuarantineHost(e echo.Context) error {
+	pds, err := bgs.lookupPDSByHost(e.QueryParam("host"))
+	if err != nil {
+		return err
+	}
+
+	reason := e.QueryParam("reason")
+	if reason == "" {
+		reason = "manual"
+	}
+
+	if err := bgs.QuarantineHost(e.Request().Context(), pds.ID, reason); err != nil {
+		return err
+	}
+
+	return e.JSON(200, map[string]any{
+		"success": "true",
+	})
+}
+
+func (bgs *BGS) handleAdminReleaseHost(e echo.Context) error {
+	pds, err := bgs.lookupPDSByHost(e.QueryParam("host"))
+	if err != nil {
+		return err
+	}
+
+	if err := bgs.ReleaseHost(e.Request().Context(), pds.ID); err != nil {
+		return err
+	}
+
+	return e.JSON(200, map[string]any{
+		"success": "true",
+	})
+}
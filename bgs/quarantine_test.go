@@ -0,0 +1,261 @@
+package bgs
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/carstore"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/models"
+	"github.com/bluesky-social/indigo/repomgr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHostQuarantineThreshold(t *testing.T) {
+	assert := assert.New(t)
+
+	hq := newHostQuarantine(3, time.Minute)
+	now := time.Now()
+
+	n, trip := hq.recordFailure(1, now)
+	assert.Equal(1, n)
+	assert.False(trip)
+	n, trip = hq.recordFailure(1, now.Add(time.Second))
+	assert.Equal(2, n)
+	assert.False(trip)
+
+	// failures from other hosts don't count
+	_, trip = hq.recordFailure(2, now.Add(time.Second))
+	assert.False(trip)
+
+	n, trip = hq.recordFailure(1, now.Add(2*time.Second))
+	assert.Equal(3, n)
+	assert.True(trip)
+}
+
+func TestHostQuarantineExpiry(t *testing.T) {
+	assert := assert.New(t)
+
+	hq := newHostQuarantine(3, time.Minute)
+	now := time.Now()
+
+	hq.recordFailure(1, now)
+	hq.recordFailure(1, now.Add(time.Second))
+	assert.Equal(2, hq.recentFailures(1, now.Add(time.Second)))
+
+	// both earlier failures have fallen out of the window
+	later := now.Add(2 * time.Minute)
+	assert.Equal(0, hq.recentFailures(1, later))
+	n, trip := hq.recordFailure(1, later)
+	assert.Equal(1, n)
+	assert.False(trip)
+}
+
+func TestHostQuarantineDisabled(t *testing.T) {
+	assert := assert.New(t)
+
+	hq := newHostQuarantine(0, time.Minute)
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		_, trip := hq.recordFailure(1, now)
+		assert.False(trip)
+	}
+}
+
+func TestQuarantineAndRelease(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	b := testBGS(t, nil)
+	host := testHost(t, b, "pds.example.com")
+
+	if err := b.QuarantineHost(ctx, host.ID, "testing"); err != nil {
+		t.Fatal(err)
+	}
+	assert.True(b.quarantine.isQuarantined(host.ID))
+
+	var pds models.PDS
+	if err := b.db.First(&pds, "id = ?", host.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	assert.True(pds.Quarantined)
+	assert.Equal("testing", pds.QuarantineReason)
+	assert.NotNil(pds.QuarantinedAt)
+
+	if err := b.ReleaseHost(ctx, host.ID); err != nil {
+		t.Fatal(err)
+	}
+	assert.False(b.quarantine.isQuarantined(host.ID))
+
+	var released models.PDS
+	if err := b.db.First(&released, "id = ?", host.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	assert.False(released.Quarantined)
+	assert.Equal("", released.QuarantineReason)
+	assert.Nil(released.QuarantinedAt)
+}
+
+func TestQuarantineOnInvalidEvents(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	config := DefaultBGSConfig()
+	config.QuarantineThreshold = 2
+	b := testBGS(t, config)
+	host := testHost(t, b, "pds.example.com")
+
+	b.noteInvalidEvent(ctx, host, repomgr.ErrInvalidSignature)
+	assert.False(b.quarantine.isQuarantined(host.ID))
+	b.noteInvalidEvent(ctx, host, repomgr.ErrInvalidSignature)
+	assert.True(b.quarantine.isQuarantined(host.ID))
+
+	var pds models.PDS
+	if err := b.db.First(&pds, "id = ?", host.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	assert.True(pds.Quarantined)
+	assert.Contains(pds.QuarantineReason, "automatic")
+}
+
+func TestIsInvalidEventErr(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(isInvalidEventErr(fmt.Errorf("checking: %w", repomgr.ErrInvalidSignature)))
+	assert.True(isInvalidEventErr(fmt.Errorf("importing: %w", carstore.ErrMalformedCarSlice)))
+	assert.False(isInvalidEventErr(fmt.Errorf("importing: %w", carstore.ErrRepoBaseMismatch)))
+	assert.False(isInvalidEventErr(fmt.Errorf("database is locked")))
+}
+
+func TestQuarantineHook(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	var alerts []string
+	config := DefaultBGSConfig()
+	config.OnHostQuarantined = func(host *models.PDS, reason string) {
+		alerts = append(alerts, host.Host+": "+reason)
+	}
+	b := testBGS(t, config)
+	host := testHost(t, b, "pds.example.com")
+
+	if err := b.QuarantineHost(ctx, host.ID, "testing"); err != nil {
+		t.Fatal(err)
+	}
+	// already quarantined, so no second alert
+	if err := b.QuarantineHost(ctx, host.ID, "again"); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal([]string{"pds.example.com: testing"}, alerts)
+
+	var pds models.PDS
+	if err := b.db.First(&pds, "id = ?", host.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal("testing", pds.QuarantineReason)
+}
+
+func TestQuarantinedHostEventsNotFannedOut(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	b := testBGS(t, nil)
+	host := testHost(t, b, "pds.example.com")
+	other := testHost(t, b, "other.example.com")
+
+	evts, cleanup, err := b.events.Subscribe(ctx, "test", b.fanoutFilter, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	identity := func(pdsID uint, did string) *events.XRPCStreamEvent {
+		return &events.XRPCStreamEvent{
+			RepoIdentity: &comatproto.SyncSubscribeRepos_Identity{
+				Did:  did,
+				Time: time.Now().Format(time.RFC3339),
+			},
+			PrivPdsId: pdsID,
+		}
+	}
+
+	if err := b.QuarantineHost(ctx, host.ID, "testing"); err != nil {
+		t.Fatal(err)
+	}
+
+	// events from the quarantined host are persisted, but only events from
+	// other hosts are delivered live
+	if err := b.events.AddEvent(ctx, identity(host.ID, "did:plc:quarantined")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.events.AddEvent(ctx, identity(other.ID, "did:plc:other")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case evt := <-evts:
+		assert.Equal("did:plc:other", evt.RepoIdentity.Did)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+
+	// the withheld event can still be replayed from the persister
+	var since int64
+	replay, replayCleanup, err := b.events.Subscribe(ctx, "replay", nil, &since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replayCleanup()
+	select {
+	case evt := <-replay:
+		assert.Equal("did:plc:quarantined", evt.RepoIdentity.Did)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for replayed event")
+	}
+
+	if err := b.ReleaseHost(ctx, host.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.events.AddEvent(ctx, identity(host.ID, "did:plc:released")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case evt := <-evts:
+		assert.Equal("did:plc:released", evt.RepoIdentity.Did)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+}
+
+func TestQuarantinedHostEventsApplied(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	b := testBGS(t, nil)
+	host := testHost(t, b, "pds.example.com")
+
+	if err := b.QuarantineHost(ctx, host.ID, "testing"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the repo is unknown and can't be resolved, so handling this event
+	// fails; it would succeed silently if quarantined events were dropped
+	evt := &events.XRPCStreamEvent{
+		RepoCommit: &comatproto.SyncSubscribeRepos_Commit{
+			Seq:  1,
+			Repo: "did:plc:unknownrepo",
+			Time: time.Now().Format(time.RFC3339),
+		},
+	}
+	assert.Error(b.handleFedEvent(ctx, host, evt))
+
+	// quarantine survives a restart
+	b.quarantine.set(host.ID, false)
+	if err := b.loadQuarantinedHosts(); err != nil {
+		t.Fatal(err)
+	}
+	assert.True(b.quarantine.isQuarantined(host.ID))
+}
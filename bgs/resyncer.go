@@ -0,0 +1,586 @@
+package bgs
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/models"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ResyncPriority orders repos waiting in the resync queue. Higher priorities
+// are always dequeued first.
+type ResyncPriority int
+
+const (
+	ResyncPriorityLow ResyncPriority = iota
+	ResyncPriorityNormal
+	ResyncPriorityHigh
+)
+
+func (p ResyncPriority) String() string {
+	switch p {
+	case ResyncPriorityLow:
+		return "low"
+	case ResyncPriorityNormal:
+		return "normal"
+	case ResyncPriorityHigh:
+		return "high"
+	default:
+		return fmt.Sprintf("priority-%d", int(p))
+	}
+}
+
+func ParseResyncPriority(s string) (ResyncPriority, error) {
+	switch s {
+	case "low":
+		return ResyncPriorityLow, nil
+	case "", "normal":
+		return ResyncPriorityNormal, nil
+	case "high":
+		return ResyncPriorityHigh, nil
+	default:
+		return 0, fmt.Errorf("unknown resync priority: %q", s)
+	}
+}
+
+// Sources of drift, used as metric labels and resync reasons
+const (
+	driftSourceValidation  = "validation"
+	driftSourceSample      = "sample"
+	driftSourceAdmin       = "admin"
+	driftSourceConsistency = "consistency"
+	driftSourceScrub       = "scrub"
+)
+
+type resyncItem struct {
+	uid        models.Uid
+	did        string
+	pds        uint
+	priority   ResyncPriority
+	reason     string
+	enqueuedAt time.Time
+
+	// number of failed attempts so far, and when the next attempt may start
+	attempts  int
+	notBefore time.Time
+
+	// position in the heap, maintained by resyncHeap
+	index int
+}
+
+// resyncHeap orders items by priority, then by age
+type resyncHeap []*resyncItem
+
+func (h resyncHeap) Len() int { return len(h) }
+
+func (h resyncHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].enqueuedAt.Before(h[j].enqueuedAt)
+}
+
+func (h resyncHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *resyncHeap) Push(x any) {
+	item := x.(*resyncItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *resyncHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.index = -1
+	*h = old[:n-1]
+	return item
+}
+
+type ResyncerOptions struct {
+	// NumWorkers is the number of repos which may be re-fetched concurrently
+	NumWorkers int
+	// PerHostLimit is the number of repos from a single PDS which may be
+	// re-fetched concurrently
+	PerHostLimit int
+	// MaxQueue is the maximum number of repos waiting to be resynced. Once
+	// full, new low and normal priority repos are dropped.
+	MaxQueue int
+
+	// SampleInterval is how often a random sample of repos is checked against
+	// upstream for drift. Zero disables sampling.
+	SampleInterval time.Duration
+	// SampleSize is the number of repos checked per sample
+	SampleSize int
+
+	// MaxAttempts is the number of times a repo is tried before it is given
+	// up on. Failed resyncs are queued again, after RetryBackoff for the
+	// first retry, doubling for each one after.
+	MaxAttempts  int
+	RetryBackoff time.Duration
+}
+
+func DefaultResyncerOptions() *ResyncerOptions {
+	return &ResyncerOptions{
+		NumWorkers:     4,
+		PerHostLimit:   2,
+		MaxQueue:       100_000,
+		SampleInterval: 0,
+		SampleSize:     100,
+		MaxAttempts:    3,
+		RetryBackoff:   time.Minute,
+	}
+}
+
+// Resyncer re-fetches full copies of repos whose local state has diverged
+// from upstream. Repos get queued when events fail validation against our
+// local copy, when periodic sampling of getLatestCommit finds a mismatch, or
+// by an admin. Queued repos are processed in priority order, with limits on
+// total and per-host concurrency.
+type Resyncer struct {
+	bgs  *BGS
+	opts ResyncerOptions
+
+	lk            sync.Mutex
+	cond          *sync.Cond
+	queue         resyncHeap
+	members       map[models.Uid]*resyncItem
+	active        map[models.Uid]*resyncItem
+	activePerHost map[uint]int
+	closed        bool
+
+	completed int64
+	failed    int64
+	dropped   int64
+
+	exit chan struct{}
+	wg   sync.WaitGroup
+
+	log *slog.Logger
+}
+
+func NewResyncer(bgs *BGS, opts *ResyncerOptions) *Resyncer {
+	if opts == nil {
+		opts = DefaultResyncerOptions()
+	}
+	r := &Resyncer{
+		bgs:           bgs,
+		opts:          *opts,
+		members:       make(map[models.Uid]*resyncItem),
+		active:        make(map[models.Uid]*resyncItem),
+		activePerHost: make(map[uint]int),
+		exit:          make(chan struct{}),
+		log:           slog.Default().With("system", "resyncer"),
+	}
+	r.cond = sync.NewCond(&r.lk)
+	return r
+}
+
+// Start starts the resync workers, and the drift sampler if configured
+func (r *Resyncer) Start() {
+	r.log.Info("starting resyncer", "workers", r.opts.NumWorkers, "perHostLimit", r.opts.PerHostLimit, "sampleInterval", r.opts.SampleInterval)
+	r.wg.Add(r.opts.NumWorkers)
+	for range r.opts.NumWorkers {
+		go r.doWork()
+	}
+	if r.opts.SampleInterval > 0 && r.opts.SampleSize > 0 {
+		r.wg.Add(1)
+		go r.sampleLoop()
+	}
+}
+
+// Shutdown stops the resyncer, waiting for in-progress resyncs to finish.
+// Queued repos are discarded.
+func (r *Resyncer) Shutdown() {
+	r.log.Info("stopping resyncer")
+	r.lk.Lock()
+	r.closed = true
+	r.cond.Broadcast()
+	r.lk.Unlock()
+	close(r.exit)
+	r.wg.Wait()
+	r.log.Info("resyncer stopped")
+}
+
+// Enqueue adds a repo to the resync queue. If the repo is already queued, its
+// priority is raised if needed. Returns false if the repo was not queued
+// because it is already being resynced, or because the queue is full.
+func (r *Resyncer) Enqueue(uid models.Uid, did string, pds uint, priority ResyncPriority, reason string) bool {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	if r.closed {
+		return false
+	}
+	if _, ok := r.active[uid]; ok {
+		return false
+	}
+
+	if item, ok := r.members[uid]; ok {
+		if priority > item.priority {
+			resyncQueueDepth.WithLabelValues(item.priority.String()).Dec()
+			resyncQueueDepth.WithLabelValues(priority.String()).Inc()
+			item.priority = priority
+			item.reason = reason
+			heap.Fix(&r.queue, item.index)
+		}
+		return true
+	}
+
+	if r.opts.MaxQueue > 0 && len(r.queue) >= r.opts.MaxQueue && priority < ResyncPriorityHigh {
+		r.dropped++
+		resyncsDropped.Inc()
+		return false
+	}
+
+	item := &resyncItem{
+		uid:        uid,
+		did:        did,
+		pds:        pds,
+		priority:   priority,
+		reason:     reason,
+		enqueuedAt: time.Now(),
+	}
+	heap.Push(&r.queue, item)
+	r.members[uid] = item
+	resyncQueueDepth.WithLabelValues(priority.String()).Inc()
+	r.cond.Signal()
+	return true
+}
+
+// next blocks until there is a queued repo whose host has a free slot, and
+// marks it active. Returns nil once the resyncer is shut down.
+func (r *Resyncer) next() *resyncItem {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	for {
+		if r.closed {
+			return nil
+		}
+
+		// items for hosts which are already at their limit, and items waiting
+		// to be retried, get set aside and put back once we've found something
+		// to work on. items for hosts which are failing health probes are only
+		// picked when there is nothing else
+		now := time.Now()
+		var skipped []*resyncItem
+		var found, unhealthy *resyncItem
+		var wake time.Time
+		for len(r.queue) > 0 {
+			item := heap.Pop(&r.queue).(*resyncItem)
+			if item.notBefore.After(now) {
+				skipped = append(skipped, item)
+				if wake.IsZero() || item.notBefore.Before(wake) {
+					wake = item.notBefore
+				}
+				continue
+			}
+			if r.opts.PerHostLimit > 0 && r.activePerHost[item.pds] >= r.opts.PerHostLimit {
+				skipped = append(skipped, item)
+				continue
+			}
+			if r.bgs.isHostUnhealthy(item.pds) {
+				if unhealthy == nil {
+					unhealthy = item
+				} else {
+					skipped = append(skipped, item)
+				}
+				continue
+			}
+			found = item
+			break
+		}
+		if found == nil && unhealthy != nil {
+			found = unhealthy
+			resyncsFromUnhealthyHosts.Inc()
+		} else if unhealthy != nil {
+			skipped = append(skipped, unhealthy)
+		}
+		for _, item := range skipped {
+			heap.Push(&r.queue, item)
+		}
+
+		if found != nil {
+			delete(r.members, found.uid)
+			r.active[found.uid] = found
+			r.activePerHost[found.pds]++
+			resyncQueueDepth.WithLabelValues(found.priority.String()).Dec()
+			resyncsActive.Inc()
+			return found
+		}
+
+		if wake.IsZero() {
+			r.cond.Wait()
+		} else {
+			t := time.AfterFunc(wake.Sub(now), r.cond.Broadcast)
+			r.cond.Wait()
+			t.Stop()
+		}
+	}
+}
+
+func (r *Resyncer) done(item *resyncItem, err error) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	delete(r.active, item.uid)
+	r.activePerHost[item.pds]--
+	if r.activePerHost[item.pds] <= 0 {
+		delete(r.activePerHost, item.pds)
+	}
+	switch {
+	case err == nil:
+		r.completed++
+	case !r.closed && item.attempts+1 < r.opts.MaxAttempts:
+		item.attempts++
+		item.notBefore = time.Now().Add(r.opts.RetryBackoff << (item.attempts - 1))
+		heap.Push(&r.queue, item)
+		r.members[item.uid] = item
+		resyncQueueDepth.WithLabelValues(item.priority.String()).Inc()
+		resyncRetries.Inc()
+		r.log.Info("queued repo for resync retry", "did", item.did, "uid", item.uid, "attempts", item.attempts, "notBefore", item.notBefore)
+	default:
+		r.failed++
+	}
+	resyncsActive.Dec()
+	// a host slot has freed up, so any worker might now have eligible work
+	r.cond.Broadcast()
+}
+
+func (r *Resyncer) doWork() {
+	defer r.wg.Done()
+	for {
+		item := r.next()
+		if item == nil {
+			return
+		}
+
+		start := time.Now()
+		err := r.resync(context.Background(), item)
+		status := "ok"
+		if err != nil {
+			status = "error"
+			r.log.Error("failed to resync repo", "did", item.did, "uid", item.uid, "reason", item.reason, "err", err)
+		} else {
+			r.log.Info("resynced repo", "did", item.did, "uid", item.uid, "reason", item.reason, "took", time.Since(start))
+		}
+		resyncsCompleted.WithLabelValues(item.priority.String(), status).Inc()
+		resyncDuration.Observe(time.Since(start).Seconds())
+		r.done(item, err)
+	}
+}
+
+func (r *Resyncer) resync(ctx context.Context, item *resyncItem) error {
+	ctx, span := tracer.Start(ctx, "ResyncRepo")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("did", item.did),
+		attribute.String("reason", item.reason),
+		attribute.String("priority", item.priority.String()),
+	)
+
+	// don't pull fresh state from a host we currently don't trust
+	if r.bgs.quarantine.isQuarantined(item.pds) {
+		return fmt.Errorf("host %d is quarantined", item.pds)
+	}
+
+	ai, err := r.bgs.Index.LookupUser(ctx, item.uid)
+	if err != nil {
+		return fmt.Errorf("looking up user: %w", err)
+	}
+
+	return r.bgs.repoFetcher.ResyncRepo(ctx, ai)
+}
+
+func (r *Resyncer) sampleLoop() {
+	defer r.wg.Done()
+	t := time.NewTicker(r.opts.SampleInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-r.exit:
+			return
+		case <-t.C:
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				select {
+				case <-r.exit:
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+			if err := r.sampleDrift(ctx, r.opts.SampleSize); err != nil {
+				r.log.Error("drift sampling failed", "err", err)
+			}
+			cancel()
+		}
+	}
+}
+
+// sampleDrift compares the local commit of a random sample of repos with
+// their PDS's latest commit, and queues any which have diverged
+func (r *Resyncer) sampleDrift(ctx context.Context, n int) error {
+	ctx, span := tracer.Start(ctx, "SampleDrift")
+	defer span.End()
+
+	var users []*User
+	if err := r.bgs.db.Model(&User{}).Where("taken_down = ? AND tombstoned = ?", false, false).Order("random()").Limit(n).Find(&users).Error; err != nil {
+		return fmt.Errorf("sampling users: %w", err)
+	}
+
+	hosts := make(map[uint]*models.PDS)
+	drifted := 0
+	for _, u := range users {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if r.bgs.quarantine.isQuarantined(u.PDS) {
+			continue
+		}
+
+		pds, ok := hosts[u.PDS]
+		if !ok {
+			var p models.PDS
+			if err := r.bgs.db.First(&p, "id = ?", u.PDS).Error; err != nil {
+				r.log.Warn("no pds for sampled user", "did", u.Did, "pds", u.PDS, "err", err)
+				hosts[u.PDS] = nil
+				continue
+			}
+			pds = &p
+			hosts[u.PDS] = pds
+		}
+		if pds == nil || pds.Blocked {
+			continue
+		}
+
+		result, err := r.checkDrift(ctx, u, pds)
+		if err != nil {
+			r.log.Debug("drift check failed", "did", u.Did, "err", err)
+		}
+		driftChecks.WithLabelValues(result).Inc()
+		if result == "drift" {
+			drifted++
+			repoDriftDetected.WithLabelValues(driftSourceSample).Inc()
+			r.Enqueue(u.ID, u.Did, u.PDS, ResyncPriorityNormal, driftSourceSample)
+		}
+	}
+
+	r.log.Info("drift sample complete", "checked", len(users), "drifted", drifted)
+	return nil
+}
+
+// checkDrift returns one of "ok", "drift", "behind" (upstream is ahead, which
+// the firehose is expected to catch up on), or "error"
+func (r *Resyncer) checkDrift(ctx context.Context, u *User, pds *models.PDS) (string, error) {
+	if err := r.bgs.repoFetcher.GetOrCreateLimiter(pds.ID, pds.CrawlRateLimit).Wait(ctx); err != nil {
+		return "error", err
+	}
+
+	c := models.ClientForPds(pds)
+	r.bgs.Index.ApplyPDSClientSettings(c)
+	latest, err := comatproto.SyncGetLatestCommit(ctx, c, u.Did)
+	if err != nil {
+		return "error", err
+	}
+
+	rev, err := r.bgs.repoman.GetRepoRev(ctx, u.ID)
+	if err != nil {
+		return "error", err
+	}
+	root, err := r.bgs.repoman.GetRepoRoot(ctx, u.ID)
+	if err != nil {
+		return "error", err
+	}
+
+	switch {
+	case rev == "" || latest.Rev > rev:
+		return "behind", nil
+	case latest.Rev == rev && latest.Cid == root.String():
+		return "ok", nil
+	default:
+		// same rev with a different commit, or we hold a rev the PDS never had
+		return "drift", nil
+	}
+}
+
+// noteDrift is called when an event for a repo shows that our local copy has
+// diverged from upstream
+func (r *Resyncer) noteDrift(uid models.Uid, did string, pds uint) {
+	repoDriftDetected.WithLabelValues(driftSourceValidation).Inc()
+	r.Enqueue(uid, did, pds, ResyncPriorityHigh, driftSourceValidation)
+}
+
+type ResyncQueueEntry struct {
+	Did        string    `json:"did"`
+	Priority   string    `json:"priority"`
+	Reason     string    `json:"reason"`
+	EnqueuedAt time.Time `json:"enqueuedAt"`
+	// Number of failed attempts so far
+	Attempts int `json:"attempts,omitempty"`
+}
+
+type ResyncerStatus struct {
+	Queued    map[string]int     `json:"queued"`
+	Active    []ResyncQueueEntry `json:"active"`
+	Next      []ResyncQueueEntry `json:"next"`
+	Completed int64              `json:"completed"`
+	Failed    int64              `json:"failed"`
+	Dropped   int64              `json:"dropped"`
+}
+
+// Status returns queue depths by priority, in-progress resyncs, and up to
+// limit of the queued repos which will be resynced next
+func (r *Resyncer) Status(limit int) ResyncerStatus {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	st := ResyncerStatus{
+		Queued:    make(map[string]int),
+		Active:    []ResyncQueueEntry{},
+		Next:      []ResyncQueueEntry{},
+		Completed: r.completed,
+		Failed:    r.failed,
+		Dropped:   r.dropped,
+	}
+	for _, item := range r.queue {
+		st.Queued[item.priority.String()]++
+	}
+	for _, item := range r.active {
+		st.Active = append(st.Active, item.entry())
+	}
+
+	// copy the heap to read it out in order without disturbing the queue
+	cp := make(resyncHeap, len(r.queue))
+	for i, item := range r.queue {
+		dup := *item
+		dup.index = i
+		cp[i] = &dup
+	}
+	for len(cp) > 0 && len(st.Next) < limit {
+		st.Next = append(st.Next, heap.Pop(&cp).(*resyncItem).entry())
+	}
+
+	return st
+}
+
+func (item *resyncItem) entry() ResyncQueueEntry {
+	return ResyncQueueEntry{
+		Did:        item.did,
+		Priority:   item.priority.String(),
+		Reason:     item.reason,
+		EnqueuedAt: item.enqueuedAt,
+		Attempts:   item.attempts,
+	}
+}
@@ -0,0 +1,211 @@
+package bgs
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testResyncer(opts *ResyncerOptions) *Resyncer {
+	// the resyncer only needs a BGS to check host health, which is off here
+	return NewResyncer(&BGS{}, opts)
+}
+
+// nextAsync starts waiting for the next item in the background
+func nextAsync(r *Resyncer) <-chan *resyncItem {
+	ch := make(chan *resyncItem, 1)
+	go func() {
+		ch <- r.next()
+	}()
+	return ch
+}
+
+func TestResyncPriorityOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	r := testResyncer(nil)
+	defer r.Shutdown()
+
+	assert.True(r.Enqueue(1, "did:plc:one", 1, ResyncPriorityLow, driftSourceAdmin))
+	assert.True(r.Enqueue(2, "did:plc:two", 1, ResyncPriorityNormal, driftSourceAdmin))
+	assert.True(r.Enqueue(3, "did:plc:three", 1, ResyncPriorityNormal, driftSourceAdmin))
+	assert.True(r.Enqueue(4, "did:plc:four", 1, ResyncPriorityHigh, driftSourceValidation))
+
+	// queueing again raises the priority, but never lowers it
+	assert.True(r.Enqueue(1, "did:plc:one", 1, ResyncPriorityHigh, driftSourceValidation))
+	assert.True(r.Enqueue(4, "did:plc:four", 1, ResyncPriorityLow, driftSourceAdmin))
+
+	st := r.Status(10)
+	assert.Equal(map[string]int{"high": 2, "normal": 2}, st.Queued)
+	if assert.Len(st.Next, 4) {
+		// a raised repo keeps its place by age among the high priority ones
+		assert.Equal("did:plc:one", st.Next[0].Did)
+		assert.Equal(driftSourceValidation, st.Next[0].Reason)
+		assert.Equal("did:plc:four", st.Next[1].Did)
+	}
+
+	var order []string
+	for range 4 {
+		item := r.next()
+		order = append(order, item.did)
+		r.done(item, nil)
+	}
+	assert.Equal([]string{"did:plc:one", "did:plc:four", "did:plc:two", "did:plc:three"}, order)
+}
+
+func TestResyncQueueFull(t *testing.T) {
+	assert := assert.New(t)
+
+	opts := DefaultResyncerOptions()
+	opts.MaxQueue = 1
+	r := testResyncer(opts)
+	defer r.Shutdown()
+
+	assert.True(r.Enqueue(1, "did:plc:one", 1, ResyncPriorityNormal, driftSourceAdmin))
+	assert.False(r.Enqueue(2, "did:plc:two", 1, ResyncPriorityNormal, driftSourceAdmin))
+	assert.False(r.Enqueue(3, "did:plc:three", 1, ResyncPriorityLow, driftSourceAdmin))
+	// high priority repos are queued regardless
+	assert.True(r.Enqueue(4, "did:plc:four", 1, ResyncPriorityHigh, driftSourceValidation))
+
+	st := r.Status(10)
+	assert.Equal(int64(2), st.Dropped)
+	assert.Len(st.Next, 2)
+}
+
+func TestResyncPerHostLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	opts := DefaultResyncerOptions()
+	opts.PerHostLimit = 1
+	r := testResyncer(opts)
+	defer r.Shutdown()
+
+	r.Enqueue(1, "did:plc:one", 10, ResyncPriorityNormal, driftSourceAdmin)
+	r.Enqueue(2, "did:plc:two", 10, ResyncPriorityHigh, driftSourceAdmin)
+	r.Enqueue(3, "did:plc:three", 20, ResyncPriorityNormal, driftSourceAdmin)
+
+	first := r.next()
+	assert.Equal("did:plc:two", first.did)
+
+	// host 10 is at its limit, so host 20 goes next despite being queued later
+	second := r.next()
+	assert.Equal("did:plc:three", second.did)
+
+	ch := nextAsync(r)
+	select {
+	case item := <-ch:
+		t.Fatalf("got %s while host was at its limit", item.did)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	r.done(first, nil)
+	select {
+	case item := <-ch:
+		assert.Equal("did:plc:one", item.did)
+		r.done(item, nil)
+	case <-time.After(time.Second):
+		t.Fatal("queued repo not picked up after host slot freed")
+	}
+	r.done(second, nil)
+
+	assert.Equal(int64(3), r.Status(10).Completed)
+}
+
+func TestResyncStateTransitions(t *testing.T) {
+	assert := assert.New(t)
+
+	r := testResyncer(nil)
+	defer r.Shutdown()
+
+	assert.True(r.Enqueue(1, "did:plc:one", 1, ResyncPriorityNormal, driftSourceAdmin))
+	st := r.Status(10)
+	assert.Equal(1, st.Queued["normal"])
+	assert.Empty(st.Active)
+
+	item := r.next()
+	st = r.Status(10)
+	assert.Empty(st.Queued)
+	assert.Empty(st.Next)
+	if assert.Len(st.Active, 1) {
+		assert.Equal("did:plc:one", st.Active[0].Did)
+	}
+
+	// a repo which is already being resynced isn't queued again
+	assert.False(r.Enqueue(1, "did:plc:one", 1, ResyncPriorityHigh, driftSourceAdmin))
+
+	r.done(item, nil)
+	st = r.Status(10)
+	assert.Empty(st.Active)
+	assert.Equal(int64(1), st.Completed)
+	assert.Equal(int64(0), st.Failed)
+
+	// and can be queued again once done
+	assert.True(r.Enqueue(1, "did:plc:one", 1, ResyncPriorityNormal, driftSourceAdmin))
+}
+
+func TestResyncRetryBackoff(t *testing.T) {
+	assert := assert.New(t)
+
+	opts := DefaultResyncerOptions()
+	opts.MaxAttempts = 3
+	opts.RetryBackoff = 50 * time.Millisecond
+	r := testResyncer(opts)
+	defer r.Shutdown()
+
+	errFetch := errors.New("fetch failed")
+
+	r.Enqueue(1, "did:plc:one", 1, ResyncPriorityNormal, driftSourceAdmin)
+	item := r.next()
+	r.done(item, errFetch)
+
+	st := r.Status(10)
+	assert.Equal(int64(0), st.Failed)
+	if assert.Len(st.Next, 1) {
+		assert.Equal(1, st.Next[0].Attempts)
+	}
+
+	// other repos aren't held up by one waiting to be retried
+	r.Enqueue(2, "did:plc:two", 1, ResyncPriorityLow, driftSourceAdmin)
+	other := r.next()
+	assert.Equal("did:plc:two", other.did)
+	r.done(other, nil)
+
+	start := time.Now()
+	item = r.next()
+	assert.Equal("did:plc:one", item.did)
+	assert.GreaterOrEqual(time.Since(start), 40*time.Millisecond)
+	r.done(item, errFetch)
+
+	// the backoff doubles
+	start = time.Now()
+	item = r.next()
+	assert.Equal(2, item.attempts)
+	assert.GreaterOrEqual(time.Since(start), 90*time.Millisecond)
+
+	// and the repo is given up on after the last attempt
+	r.done(item, errFetch)
+	st = r.Status(10)
+	assert.Empty(st.Next)
+	assert.Empty(st.Active)
+	assert.Equal(int64(1), st.Failed)
+	assert.Equal(int64(1), st.Completed)
+}
+
+func TestResyncShutdown(t *testing.T) {
+	assert := assert.New(t)
+
+	r := testResyncer(nil)
+	ch := nextAsync(r)
+
+	r.Shutdown()
+	select {
+	case item := <-ch:
+		assert.Nil(item)
+	case <-time.After(time.Second):
+		t.Fatal("waiting worker not released by shutdown")
+	}
+
+	assert.False(r.Enqueue(1, "did:plc:one", 1, ResyncPriorityHigh, driftSourceAdmin))
+}
@@ -0,0 +1,47 @@
+package bgs
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bluesky-social/indigo/carstore"
+)
+
+// starts the background carstore scrubber, as configured
+func (bgs *BGS) startScrubber(config *BGSConfig) error {
+	fcs, ok := bgs.repoman.CarStore().(*carstore.FileCarStore)
+	if !ok {
+		return fmt.Errorf("carstore does not support scrubbing")
+	}
+
+	sc := carstore.NewScrubber(fcs)
+	sc.Interval = config.ScrubInterval
+	if config.ScrubBatchSize > 0 {
+		sc.BatchSize = config.ScrubBatchSize
+	}
+	sc.Logger = bgs.log.With("job", "scrub")
+	if config.ScrubRepair {
+		sc.OnCorrupt = bgs.repairCorruptRepo
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	bgs.scrubber = sc
+	bgs.scrubCancel = cancel
+	go sc.Run(ctx)
+	return nil
+}
+
+// repairCorruptRepo wipes a repo found corrupt by the scrubber, and queues it
+// for resync from its PDS. Returns the repair action taken.
+func (bgs *BGS) repairCorruptRepo(ctx context.Context, res *carstore.RepoScrubResult) string {
+	var u User
+	if err := bgs.db.Model(&User{}).Where("id = ?", res.Uid).First(&u).Error; err != nil {
+		bgs.log.Error("failed to look up corrupt repo for repair", "uid", res.Uid, "err", err)
+		return "failed"
+	}
+	// taken down and tombstoned repos won't be resynced anyway
+	if u.TakenDown || u.Tombstoned {
+		return ""
+	}
+	return bgs.repairRepo(ctx, &u, true, driftSourceScrub)
+}
@@ -0,0 +1,343 @@
+package bgs
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/time/rate"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrSubTokenInvalid = errors.New("invalid subscription token")
+	ErrSubTokenExpired = errors.New("subscription token expired")
+	ErrSubTokenRevoked = errors.New("subscription token revoked")
+)
+
+// RevokedSubscriptionToken records a subscription token, by ID, which is no
+// longer accepted even though it is validly signed
+type RevokedSubscriptionToken struct {
+	gorm.Model
+	TokenID string `gorm:"uniqueIndex"`
+}
+
+// SubscriptionClaims are the claims embedded in a subscription token. Tokens
+// are issued by the admin API and presented by firehose consumers, either as
+// a bearer token or in the "token" query parameter. The limits let a public
+// relay offer different service tiers to different consumers.
+type SubscriptionClaims struct {
+	// Name of the consumer the token was issued to
+	Subject string `json:"sub"`
+	// Free-form service tier name, for logging and metrics
+	Tier string `json:"tier,omitempty"`
+	// Random token ID
+	ID  string `json:"jti"`
+	Iat int64  `json:"iat"`
+	// Expiry (unix seconds); zero means the token doesn't expire
+	Exp int64 `json:"exp,omitempty"`
+
+	// Maximum number of events delivered per Window seconds. Zero means
+	// unlimited. Delivery is throttled (not dropped) when the limit is hit, so
+	// a consumer which stays over its limit will eventually fall behind and be
+	// disconnected as a slow consumer.
+	Limit  int `json:"limit,omitempty"`
+	Window int `json:"window,omitempty"`
+	// Maximum number of concurrent connections using tokens for this
+	// subject. Zero means unlimited.
+	MaxConns int `json:"maxConns,omitempty"`
+}
+
+// limiter returns a rate limiter enforcing the claimed limits, or nil if the
+// token isn't rate limited
+func (sc *SubscriptionClaims) limiter() *rate.Limiter {
+	if sc.Limit <= 0 {
+		return nil
+	}
+	window := time.Duration(max(sc.Window, 1)) * time.Second
+	return rate.NewLimiter(rate.Limit(float64(sc.Limit)/window.Seconds()), sc.Limit)
+}
+
+// SignSubscriptionToken serializes and signs (HMAC-SHA256) a set of
+// subscription claims. The result is "<base64url claims>.<base64url mac>".
+func SignSubscriptionToken(key []byte, claims *SubscriptionClaims) (string, error) {
+	return signToken(key, "", claims)
+}
+
+// VerifySubscriptionToken checks the signature and expiry of a subscription
+// token, and returns its claims
+func VerifySubscriptionToken(key []byte, token string) (*SubscriptionClaims, error) {
+	var claims SubscriptionClaims
+	if err := verifyToken(key, "", token, &claims); err != nil {
+		return nil, err
+	}
+	if claims.Exp != 0 && time.Now().After(time.Unix(claims.Exp, 0)) {
+		return nil, ErrSubTokenExpired
+	}
+	return &claims, nil
+}
+
+// signToken signs claims with a MAC over the domain prefix and payload, so
+// that tokens of one kind can't be presented as another
+func signToken(key []byte, domain string, claims any) (string, error) {
+	if len(key) == 0 {
+		return "", fmt.Errorf("no subscription token key configured")
+	}
+	cb, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(cb)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(subTokenMAC(key, domain+payload)), nil
+}
+
+func verifyToken(key []byte, domain, token string, claims any) error {
+	payload, sigstr, ok := strings.Cut(token, ".")
+	if !ok {
+		return fmt.Errorf("%w: malformed token", ErrSubTokenInvalid)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(sigstr)
+	if err != nil {
+		return fmt.Errorf("%w: signature encoding: %w", ErrSubTokenInvalid, err)
+	}
+	if !hmac.Equal(sig, subTokenMAC(key, domain+payload)) {
+		return fmt.Errorf("%w: bad signature", ErrSubTokenInvalid)
+	}
+
+	cb, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return fmt.Errorf("%w: claims encoding: %w", ErrSubTokenInvalid, err)
+	}
+	if err := json.Unmarshal(cb, claims); err != nil {
+		return fmt.Errorf("%w: claims: %w", ErrSubTokenInvalid, err)
+	}
+	return nil
+}
+
+func subTokenMAC(key []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// returns the subscription token presented with a request, if any
+func subscriptionTokenFromRequest(e echo.Context) string {
+	if tok := e.QueryParam("token"); tok != "" {
+		return tok
+	}
+	if auth := e.Request().Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ""
+}
+
+// checkSubscriptionToken verifies the token presented by a new firehose
+// consumer (if subscription tokens are configured). Returns nil claims for
+// anonymous consumers, which are only allowed if tokens aren't required.
+func (bgs *BGS) checkSubscriptionToken(e echo.Context) (*SubscriptionClaims, error) {
+	if len(bgs.subTokenKey) == 0 {
+		return nil, nil
+	}
+
+	tok := subscriptionTokenFromRequest(e)
+	if tok == "" {
+		if bgs.requireSubToken {
+			subTokenChecks.WithLabelValues("missing").Inc()
+			return nil, echo.NewHTTPError(http.StatusUnauthorized, "subscription token required")
+		}
+		subTokenChecks.WithLabelValues("anonymous").Inc()
+		return nil, nil
+	}
+
+	claims, err := VerifySubscriptionToken(bgs.subTokenKey, tok)
+	if err != nil {
+		if errors.Is(err, ErrSubTokenExpired) {
+			subTokenChecks.WithLabelValues("expired").Inc()
+		} else {
+			subTokenChecks.WithLabelValues("invalid").Inc()
+		}
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+
+	if bgs.subTokenRevoked(claims.ID) {
+		subTokenChecks.WithLabelValues("revoked").Inc()
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, ErrSubTokenRevoked.Error())
+	}
+
+	if claims.MaxConns > 0 && bgs.countSubjectConsumers(claims.Subject) >= claims.MaxConns {
+		subTokenChecks.WithLabelValues("too_many_conns").Inc()
+		return nil, echo.NewHTTPError(http.StatusTooManyRequests, "too many connections for subscription token")
+	}
+
+	subTokenChecks.WithLabelValues("ok").Inc()
+	return claims, nil
+}
+
+func (bgs *BGS) countSubjectConsumers(subject string) int {
+	bgs.consumersLk.RLock()
+	defer bgs.consumersLk.RUnlock()
+
+	n := 0
+	for _, c := range bgs.consumers {
+		if c.Subject == subject {
+			n++
+		}
+	}
+	return n
+}
+
+func (bgs *BGS) loadRevokedSubTokens() error {
+	if err := bgs.db.AutoMigrate(&RevokedSubscriptionToken{}); err != nil {
+		return err
+	}
+
+	var revoked []RevokedSubscriptionToken
+	if err := bgs.db.Find(&revoked).Error; err != nil {
+		return err
+	}
+
+	bgs.revokedSubTokensLk.Lock()
+	defer bgs.revokedSubTokensLk.Unlock()
+	bgs.revokedSubTokens = make(map[string]bool, len(revoked))
+	for _, r := range revoked {
+		bgs.revokedSubTokens[r.TokenID] = true
+	}
+	return nil
+}
+
+func (bgs *BGS) subTokenRevoked(id string) bool {
+	bgs.revokedSubTokensLk.RLock()
+	defer bgs.revokedSubTokensLk.RUnlock()
+	return bgs.revokedSubTokens[id]
+}
+
+// RevokeSubscriptionToken stops the subscription (or push) token with the
+// given ID from being accepted, and disconnects any consumers or pushing
+// hosts using it. Returns the number of connections closed.
+func (bgs *BGS) RevokeSubscriptionToken(ctx context.Context, id string) (int, error) {
+	if err := bgs.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&RevokedSubscriptionToken{TokenID: id}).Error; err != nil {
+		return 0, err
+	}
+
+	bgs.revokedSubTokensLk.Lock()
+	bgs.revokedSubTokens[id] = true
+	bgs.revokedSubTokensLk.Unlock()
+
+	bgs.consumersLk.RLock()
+	defer bgs.consumersLk.RUnlock()
+
+	n := 0
+	for _, c := range bgs.consumers {
+		if c.TokenID == id && c.cancel != nil {
+			c.cancel()
+			n++
+		}
+	}
+	// push tokens share the revocation list
+	if bgs.slurper != nil {
+		n += bgs.slurper.cancelPushToken(id)
+	}
+
+	bgs.log.Info("revoked subscription token", "jti", id, "disconnected", n)
+	return n, nil
+}
+
+type issueSubTokenBody struct {
+	Subject string `json:"subject"`
+	Tier    string `json:"tier"`
+	// Token lifetime in seconds; zero for a token which doesn't expire
+	TTL      int `json:"ttl"`
+	Limit    int `json:"limit"`
+	Window   int `json:"window"`
+	MaxConns int `json:"maxConns"`
+}
+
+type issueSubTokenResponse struct {
+	Token  string              `json:"token"`
+	Claims *SubscriptionClaims `json:"claims"`
+}
+
+func (bgs *BGS) handleAdminIssueSubscriptionToken(e echo.Context) error {
+	if len(bgs.subTokenKey) == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "subscription tokens are not enabled")
+	}
+
+	var body issueSubTokenBody
+	if err := e.Bind(&body); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	body.Subject = strings.TrimSpace(body.Subject)
+	if body.Subject == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "must specify a subject")
+	}
+	if body.TTL < 0 || body.Limit < 0 || body.Window < 0 || body.MaxConns < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "ttl and limits must not be negative")
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	now := time.Now()
+	claims := &SubscriptionClaims{
+		Subject:  body.Subject,
+		Tier:     body.Tier,
+		ID:       hex.EncodeToString(nonce),
+		Iat:      now.Unix(),
+		Limit:    body.Limit,
+		Window:   body.Window,
+		MaxConns: body.MaxConns,
+	}
+	if body.TTL > 0 {
+		claims.Exp = now.Add(time.Duration(body.TTL) * time.Second).Unix()
+	}
+
+	tok, err := SignSubscriptionToken(bgs.subTokenKey, claims)
+	if err != nil {
+		return err
+	}
+
+	bgs.log.Info("issued subscription token", "subject", claims.Subject, "tier", claims.Tier, "jti", claims.ID)
+	return e.JSON(http.StatusOK, issueSubTokenResponse{Token: tok, Claims: claims})
+}
+
+type revokeSubTokenBody struct {
+	// ID (the "jti" claim) of the token to revoke
+	ID string `json:"id"`
+}
+
+func (bgs *BGS) handleAdminRevokeSubscriptionToken(e echo.Context) error {
+	if len(bgs.subTokenKey) == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "subscription tokens are not enabled")
+	}
+
+	var body revokeSubTokenBody
+	if err := e.Bind(&body); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	body.ID = strings.TrimSpace(body.ID)
+	if body.ID == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "must specify a token id")
+	}
+
+	n, err := bgs.RevokeSubscriptionToken(e.Request().Context(), body.ID)
+	if err != nil {
+		return err
+	}
+
+	return e.JSON(http.StatusOK, map[string]any{
+		"success":      "true",
+		"disconnected": n,
+	})
+}
@@ -0,0 +1,264 @@
+package bgs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+var testSubTokenKey = []byte("test subscription token key")
+
+func TestSubscriptionTokenRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+
+	claims := &SubscriptionClaims{
+		Subject: "consumer",
+		Tier:    "gold",
+		ID:      "abc123",
+		Iat:     time.Now().Unix(),
+		Exp:     time.Now().Add(time.Hour).Unix(),
+		Limit:   100,
+		Window:  10,
+	}
+	tok, err := SignSubscriptionToken(testSubTokenKey, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := VerifySubscriptionToken(testSubTokenKey, tok)
+	assert.NoError(err)
+	assert.Equal(claims, out)
+
+	_, err = SignSubscriptionToken(nil, claims)
+	assert.Error(err)
+}
+
+func TestSubscriptionTokenInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	tok, err := SignSubscriptionToken(testSubTokenKey, &SubscriptionClaims{Subject: "consumer", ID: "abc123"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushTok, err := SignPushToken(testSubTokenKey, &PushClaims{Host: "pds.example.com", ID: "abc123"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, sig, _ := strings.Cut(tok, ".")
+	forged, err := SignSubscriptionToken([]byte("some other key"), &SubscriptionClaims{Subject: "consumer", Limit: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	forgedPayload, _, _ := strings.Cut(forged, ".")
+
+	for name, bad := range map[string]string{
+		"malformed":      "nodot",
+		"bad encoding":   payload + ".!!!",
+		"other key":      forged,
+		"swapped claims": forgedPayload + "." + sig,
+		"push token":     pushTok,
+	} {
+		_, err := VerifySubscriptionToken(testSubTokenKey, bad)
+		assert.ErrorIs(err, ErrSubTokenInvalid, name)
+	}
+}
+
+func TestSubscriptionTokenExpiry(t *testing.T) {
+	assert := assert.New(t)
+
+	expired, err := SignSubscriptionToken(testSubTokenKey, &SubscriptionClaims{Subject: "consumer", Exp: time.Now().Add(-time.Minute).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = VerifySubscriptionToken(testSubTokenKey, expired)
+	assert.ErrorIs(err, ErrSubTokenExpired)
+
+	// no expiry
+	forever, err := SignSubscriptionToken(testSubTokenKey, &SubscriptionClaims{Subject: "consumer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = VerifySubscriptionToken(testSubTokenKey, forever)
+	assert.NoError(err)
+}
+
+// testSubTokenBGS returns a relay requiring subscription tokens, and an echo
+// server with its subscribeRepos and subscription token admin routes
+func testSubTokenBGS(t *testing.T) (*BGS, *echo.Echo) {
+	t.Helper()
+
+	config := DefaultBGSConfig()
+	config.SubscriptionTokenKey = testSubTokenKey
+	config.RequireSubscriptionToken = true
+	b := testBGS(t, config)
+
+	e := echo.New()
+	e.GET("/xrpc/com.atproto.sync.subscribeRepos", b.EventsHandler)
+	e.POST("/admin/subs/issueToken", b.handleAdminIssueSubscriptionToken)
+	e.POST("/admin/subs/revokeToken", b.handleAdminRevokeSubscriptionToken)
+	e.GET("/push/subscribeRepos", b.handlePushRepos)
+	e.POST("/admin/pds/issuePushToken", b.handleAdminIssuePushToken)
+	return b, e
+}
+
+func postJSON(e *echo.Echo, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", path, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func issueTestSubToken(t *testing.T, e *echo.Echo, body string) *issueSubTokenResponse {
+	t.Helper()
+
+	rec := postJSON(e, "/admin/subs/issueToken", body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("issuing token: %d %s", rec.Code, rec.Body.String())
+	}
+	var out issueSubTokenResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	return &out
+}
+
+func TestIssueSubscriptionToken(t *testing.T) {
+	assert := assert.New(t)
+	_, e := testSubTokenBGS(t)
+
+	out := issueTestSubToken(t, e, `{"subject": "consumer", "tier": "gold", "ttl": 3600, "limit": 100, "window": 10}`)
+	assert.Equal("consumer", out.Claims.Subject)
+	assert.NotEmpty(out.Claims.ID)
+	assert.InDelta(time.Now().Add(time.Hour).Unix(), out.Claims.Exp, 5)
+
+	claims, err := VerifySubscriptionToken(testSubTokenKey, out.Token)
+	assert.NoError(err)
+	assert.Equal(out.Claims, claims)
+
+	for _, body := range []string{
+		`{"subject": " "}`,
+		`{"subject": "consumer", "ttl": -1}`,
+	} {
+		assert.Equal(http.StatusBadRequest, postJSON(e, "/admin/subs/issueToken", body).Code, body)
+	}
+}
+
+func TestSubscribeRejectsBadToken(t *testing.T) {
+	assert := assert.New(t)
+	_, e := testSubTokenBGS(t)
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	expired, err := SignSubscriptionToken(testSubTokenKey, &SubscriptionClaims{Subject: "consumer", Exp: time.Now().Add(-time.Minute).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := SignSubscriptionToken([]byte("some other key"), &SubscriptionClaims{Subject: "consumer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/xrpc/com.atproto.sync.subscribeRepos"
+	for name, tok := range map[string]string{
+		"missing": "",
+		"expired": expired,
+		"forged":  forged,
+	} {
+		h := http.Header{}
+		if tok != "" {
+			h.Set("Authorization", "Bearer "+tok)
+		}
+		_, resp, err := websocket.DefaultDialer.Dial(url, h)
+		if assert.Error(err, name) && assert.NotNil(resp, name) {
+			assert.Equal(http.StatusUnauthorized, resp.StatusCode, name)
+		}
+	}
+
+	// a valid token is accepted, as a query parameter too
+	out := issueTestSubToken(t, e, `{"subject": "consumer"}`)
+	con, _, err := websocket.DefaultDialer.Dial(url+"?token="+out.Token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	con.Close()
+}
+
+func TestRevokeSubscriptionToken(t *testing.T) {
+	assert := assert.New(t)
+	b, e := testSubTokenBGS(t)
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	out := issueTestSubToken(t, e, `{"subject": "consumer"}`)
+	other := issueTestSubToken(t, e, `{"subject": "consumer"}`)
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/xrpc/com.atproto.sync.subscribeRepos"
+	dial := func(tok string) (*websocket.Conn, *http.Response, error) {
+		h := http.Header{}
+		h.Set("Authorization", "Bearer "+tok)
+		return websocket.DefaultDialer.Dial(url, h)
+	}
+
+	con, _, err := dial(out.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer con.Close()
+	otherCon, _, err := dial(other.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer otherCon.Close()
+
+	// wait for both consumers to be registered
+	for i := 0; b.countSubjectConsumers("consumer") < 2; i++ {
+		if i > 100 {
+			t.Fatal("consumers not registered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	rec := postJSON(e, "/admin/subs/revokeToken", `{"id": "`+out.Claims.ID+`"}`)
+	assert.Equal(http.StatusOK, rec.Code, rec.Body.String())
+	assert.JSONEq(`{"success": "true", "disconnected": 1}`, rec.Body.String())
+	assert.Equal(http.StatusBadRequest, postJSON(e, "/admin/subs/revokeToken", `{}`).Code)
+
+	// the consumer using the revoked token is disconnected
+	con.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = con.ReadMessage()
+	var ne interface{ Timeout() bool }
+	if errors.As(err, &ne) && ne.Timeout() {
+		t.Fatal("consumer with revoked token not disconnected")
+	}
+	assert.Error(err)
+
+	// and can't reconnect, while other tokens are unaffected
+	_, resp, err := dial(out.Token)
+	if assert.Error(err) && assert.NotNil(resp) {
+		assert.Equal(http.StatusUnauthorized, resp.StatusCode)
+	}
+	assert.Equal(1, b.countSubjectConsumers("consumer"))
+	assert.False(b.subTokenRevoked(other.Claims.ID))
+
+	// revocations are persisted
+	b.revokedSubTokens = nil
+	if err := b.loadRevokedSubTokens(); err != nil {
+		t.Fatal(err)
+	}
+	assert.True(b.subTokenRevoked(out.Claims.ID))
+
+	// revoking again is harmless
+	n, err := b.RevokeSubscriptionToken(context.Background(), out.Claims.ID)
+	assert.NoError(err)
+	assert.Equal(0, n)
+}
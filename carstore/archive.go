@@ -0,0 +1,475 @@
+package carstore
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/models"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"gorm.io/gorm"
+)
+
+var ErrNoColdStore = fmt.Errorf("carstore has no cold storage configured")
+var ErrRepoArchived = fmt.Errorf("repo is archived to cold storage")
+var ErrRestorePending = fmt.Errorf("repo is being restored from cold storage, try again later")
+
+// ColdStore is object storage for bundles of archived repo shards. It is
+// expected to be cheap and slow (eg, a bucket in an object store).
+type ColdStore interface {
+	Put(ctx context.Context, key string, r io.Reader) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// DirColdStore is a ColdStore backed by a local (or network mounted) directory.
+type DirColdStore struct {
+	Dir string
+}
+
+var _ ColdStore = (*DirColdStore)(nil)
+
+func (d *DirColdStore) Put(ctx context.Context, key string, r io.Reader) error {
+	if err := os.MkdirAll(d.Dir, 0775); err != nil {
+		return err
+	}
+
+	fname := filepath.Join(d.Dir, key)
+	tmp := fname + ".tmp"
+	fi, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(fi, r); err != nil {
+		fi.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := fi.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, fname)
+}
+
+func (d *DirColdStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(d.Dir, key))
+}
+
+func (d *DirColdStore) Delete(ctx context.Context, key string) error {
+	err := os.Remove(filepath.Join(d.Dir, key))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// ArchivedRepo records a repo whose shard files have been moved to cold
+// storage. The CarShard and blockRef rows for the repo are kept, so head and
+// rev lookups keep working without a restore.
+type ArchivedRepo struct {
+	Usr        models.Uid `gorm:"primarykey"`
+	Key        string
+	Shards     int
+	Size       int64
+	ArchivedAt time.Time
+}
+
+// state for a restore running in the background; waiters block on done
+type restoreOp struct {
+	done chan struct{}
+	err  error
+}
+
+// number of lock stripes used to serialize archive and restore of a single repo
+const archiveLockStripes = 64
+
+type archiveState struct {
+	cold          ColdStore
+	restoreBudget time.Duration
+
+	lk        sync.Mutex
+	archived  map[models.Uid]bool
+	restoring map[models.Uid]*restoreOp
+
+	userLks [archiveLockStripes]sync.Mutex
+}
+
+func (as *archiveState) userLock(user models.Uid) *sync.Mutex {
+	return &as.userLks[int(user)%archiveLockStripes]
+}
+
+// SetColdStore enables archiving repos to the given cold storage. Reads of an
+// archived repo transparently restore it; restoreBudget bounds how long a read
+// will wait on the restore before failing with ErrRestorePending (the restore
+// carries on in the background). A zero budget waits for as long as the read's
+// context allows.
+func (cs *FileCarStore) SetColdStore(cold ColdStore, restoreBudget time.Duration) error {
+	if err := cs.meta.meta.AutoMigrate(&ArchivedRepo{}); err != nil {
+		return err
+	}
+
+	var uids []models.Uid
+	if err := cs.meta.meta.Model(&ArchivedRepo{}).Pluck("usr", &uids).Error; err != nil {
+		return err
+	}
+
+	as := &archiveState{
+		cold:          cold,
+		restoreBudget: restoreBudget,
+		archived:      make(map[models.Uid]bool, len(uids)),
+		restoring:     make(map[models.Uid]*restoreOp),
+	}
+	for _, u := range uids {
+		as.archived[u] = true
+	}
+	reposArchivedGauge.Set(float64(len(uids)))
+
+	cs.arc = as
+	return nil
+}
+
+// IsArchived reports whether the repo's shards currently live in cold storage.
+func (cs *FileCarStore) IsArchived(user models.Uid) bool {
+	if cs.arc == nil {
+		return false
+	}
+	cs.arc.lk.Lock()
+	defer cs.arc.lk.Unlock()
+	return cs.arc.archived[user]
+}
+
+func archiveKey(user models.Uid, seq int) string {
+	return fmt.Sprintf("repo-%d-%d.tar", user, seq)
+}
+
+// ArchiveRepo bundles all of a repo's shard files into a single object in cold
+// storage and removes them from local disk. Intended for dormant repos; a
+// write to the repo while it is being archived will land in a new local shard,
+// which is left in place.
+func (cs *FileCarStore) ArchiveRepo(ctx context.Context, user models.Uid) error {
+	ctx, span := otel.Tracer("carstore").Start(ctx, "ArchiveRepo")
+	defer span.End()
+	span.SetAttributes(attribute.Int64("user", int64(user)))
+
+	if cs.arc == nil {
+		return ErrNoColdStore
+	}
+
+	ulk := cs.arc.userLock(user)
+	ulk.Lock()
+	defer ulk.Unlock()
+
+	if cs.IsArchived(user) {
+		return ErrRepoArchived
+	}
+
+	shards, err := cs.meta.GetUserShards(ctx, user)
+	if err != nil {
+		return err
+	}
+	if len(shards) == 0 {
+		return fmt.Errorf("no data found for user %d", user)
+	}
+
+	key := archiveKey(user, shards[len(shards)-1].Seq)
+
+	pr, pw := io.Pipe()
+	sizeCh := make(chan int64, 1)
+	go func() {
+		size, err := writeShardBundle(pw, shards)
+		sizeCh <- size
+		pw.CloseWithError(err)
+	}()
+
+	if err := cs.arc.cold.Put(ctx, key, pr); err != nil {
+		pr.CloseWithError(err)
+		archiveCounter.WithLabelValues("archive", "error").Inc()
+		return fmt.Errorf("writing repo bundle to cold storage: %w", err)
+	}
+	size := <-sizeCh
+
+	rec := &ArchivedRepo{
+		Usr:        user,
+		Key:        key,
+		Shards:     len(shards),
+		Size:       size,
+		ArchivedAt: time.Now(),
+	}
+	if err := cs.meta.meta.WithContext(ctx).Create(rec).Error; err != nil {
+		archiveCounter.WithLabelValues("archive", "error").Inc()
+		return err
+	}
+
+	cs.arc.lk.Lock()
+	cs.arc.archived[user] = true
+	cs.arc.lk.Unlock()
+	cs.removeLastShardCache(user)
+
+	for _, sh := range shards {
+		if err := cs.deleteShardFile(ctx, &sh); err != nil && !os.IsNotExist(err) {
+			cs.log.Warn("failed to remove archived shard file", "shard", sh.ID, "path", sh.Path, "err", err)
+		}
+	}
+
+	archiveCounter.WithLabelValues("archive", "ok").Inc()
+	reposArchivedGauge.Inc()
+	cs.log.Info("archived repo to cold storage", "user", user, "key", key, "shards", len(shards), "size", size)
+	return nil
+}
+
+// writeShardBundle writes the given shard files to w as a tar archive, named
+// by their base file name, and returns the total size of the shard data.
+func writeShardBundle(w io.Writer, shards []CarShard) (int64, error) {
+	tw := tar.NewWriter(w)
+	var total int64
+	for _, sh := range shards {
+		fi, err := os.Open(sh.Path)
+		if err != nil {
+			return 0, err
+		}
+
+		st, err := fi.Stat()
+		if err != nil {
+			fi.Close()
+			return 0, err
+		}
+
+		if err := tw.WriteHeader(&tar.Header{
+			Name:    filepath.Base(sh.Path),
+			Mode:    0664,
+			Size:    st.Size(),
+			ModTime: st.ModTime(),
+		}); err != nil {
+			fi.Close()
+			return 0, err
+		}
+
+		n, err := io.Copy(tw, fi)
+		fi.Close()
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+
+	return total, tw.Close()
+}
+
+// UnarchiveRepo restores an archived repo's shards to local disk, waiting for
+// the restore to finish regardless of the configured latency budget.
+func (cs *FileCarStore) UnarchiveRepo(ctx context.Context, user models.Uid) error {
+	if cs.arc == nil {
+		return ErrNoColdStore
+	}
+	if !cs.IsArchived(user) {
+		return nil
+	}
+
+	op := cs.startRestore(user)
+	select {
+	case <-op.done:
+		return op.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ensureRestored is called before reading shard files for a user. If the repo
+// is archived it kicks off a restore and waits up to the restore budget.
+func (cs *FileCarStore) ensureRestored(ctx context.Context, user models.Uid) error {
+	if cs.arc == nil || !cs.IsArchived(user) {
+		return nil
+	}
+
+	op := cs.startRestore(user)
+
+	var timeout <-chan time.Time
+	if cs.arc.restoreBudget > 0 {
+		t := time.NewTimer(cs.arc.restoreBudget)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	select {
+	case <-op.done:
+		return op.err
+	case <-timeout:
+		restoreBudgetExceeded.Inc()
+		return ErrRestorePending
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// startRestore returns the in-progress restore for the user, starting one if
+// needed. Restores run detached from the caller's context, so that a read
+// which gives up waiting doesn't abandon a partially restored repo.
+func (cs *FileCarStore) startRestore(user models.Uid) *restoreOp {
+	cs.arc.lk.Lock()
+	defer cs.arc.lk.Unlock()
+
+	if op, ok := cs.arc.restoring[user]; ok {
+		return op
+	}
+
+	op := &restoreOp{done: make(chan struct{})}
+	cs.arc.restoring[user] = op
+	go func() {
+		start := time.Now()
+		err := cs.restoreRepo(context.Background(), user)
+
+		cs.arc.lk.Lock()
+		delete(cs.arc.restoring, user)
+		if err == nil && cs.arc.archived[user] {
+			delete(cs.arc.archived, user)
+			reposArchivedGauge.Dec()
+		}
+		cs.arc.lk.Unlock()
+
+		if err != nil {
+			archiveCounter.WithLabelValues("restore", "error").Inc()
+			cs.log.Error("failed to restore repo from cold storage", "user", user, "err", err)
+		} else {
+			archiveCounter.WithLabelValues("restore", "ok").Inc()
+			restoreDuration.Observe(time.Since(start).Seconds())
+		}
+
+		op.err = err
+		close(op.done)
+	}()
+
+	return op
+}
+
+func (cs *FileCarStore) restoreRepo(ctx context.Context, user models.Uid) error {
+	ctx, span := otel.Tracer("carstore").Start(ctx, "restoreRepo")
+	defer span.End()
+	span.SetAttributes(attribute.Int64("user", int64(user)))
+
+	ulk := cs.arc.userLock(user)
+	ulk.Lock()
+	defer ulk.Unlock()
+
+	var rec ArchivedRepo
+	if err := cs.meta.meta.WithContext(ctx).First(&rec, "usr = ?", user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// restored (or wiped) by someone else while we waited on the lock
+			return nil
+		}
+		return err
+	}
+
+	shards, err := cs.meta.GetUserShards(ctx, user)
+	if err != nil {
+		return err
+	}
+	paths := make(map[string]string, len(shards))
+	for _, sh := range shards {
+		paths[filepath.Base(sh.Path)] = sh.Path
+	}
+
+	rc, err := cs.arc.cold.Get(ctx, rec.Key)
+	if err != nil {
+		return fmt.Errorf("reading repo bundle from cold storage: %w", err)
+	}
+	defer rc.Close()
+
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return fmt.Errorf("reading repo bundle: %w", err)
+		}
+
+		path, ok := paths[hdr.Name]
+		if !ok {
+			// shard was compacted away or deleted after archiving
+			continue
+		}
+		if err := restoreShardFile(path, tr); err != nil {
+			return err
+		}
+	}
+
+	if err := cs.meta.meta.WithContext(ctx).Delete(&ArchivedRepo{}, "usr = ?", user).Error; err != nil {
+		return err
+	}
+
+	if err := cs.arc.cold.Delete(ctx, rec.Key); err != nil {
+		cs.log.Warn("failed to delete restored repo bundle from cold storage", "user", user, "key", rec.Key, "err", err)
+	}
+
+	return nil
+}
+
+// restoreShardFile writes a shard file via a temp file, so a crashed restore
+// never leaves a truncated shard in place
+func restoreShardFile(path string, r io.Reader) error {
+	tmp := path + ".restore"
+	fi, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(fi, r); err != nil {
+		fi.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := fi.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// forgetArchive drops any cold storage bundle for the user, for when the
+// repo's data is being wiped.
+func (cs *FileCarStore) forgetArchive(ctx context.Context, user models.Uid) error {
+	if cs.arc == nil || !cs.IsArchived(user) {
+		return nil
+	}
+
+	ulk := cs.arc.userLock(user)
+	ulk.Lock()
+	defer ulk.Unlock()
+
+	var rec ArchivedRepo
+	if err := cs.meta.meta.WithContext(ctx).First(&rec, "usr = ?", user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if err := cs.arc.cold.Delete(ctx, rec.Key); err != nil {
+		return err
+	}
+	if err := cs.meta.meta.WithContext(ctx).Delete(&ArchivedRepo{}, "usr = ?", user).Error; err != nil {
+		return err
+	}
+
+	cs.arc.lk.Lock()
+	if cs.arc.archived[user] {
+		delete(cs.arc.archived, user)
+		reposArchivedGauge.Dec()
+	}
+	cs.arc.lk.Unlock()
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package carstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/models"
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/bluesky-social/indigo/util"
+	"github.com/ipfs/go-cid"
+)
+
+// writes a repo with a few commits for the user, returning the record cids
+func writeTestRepo(t *testing.T, cs CarStore, user models.Uid, n int) []cid.Cid {
+	ctx := context.TODO()
+
+	ds, err := cs.NewDeltaSession(ctx, user, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, rev, err := setupRepo(ctx, ds, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ds.CloseWithRoot(ctx, head, rev); err != nil {
+		t.Fatal(err)
+	}
+
+	var recs []cid.Cid
+	for i := 0; i < n; i++ {
+		ds, err := cs.NewDeltaSession(ctx, user, &rev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr, err := repo.OpenRepo(ctx, ds, head)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rc, _, err := rr.CreateRecord(ctx, "app.bsky.feed.post", &appbsky.FeedPost{
+			Text: fmt.Sprintf("archive me %d", i),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		recs = append(recs, rc)
+
+		kmgr := &util.FakeKeyManager{}
+		head, rev, err = rr.Commit(ctx, kmgr.SignForUser)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ds.CalcDiff(ctx, nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ds.CloseWithRoot(ctx, head, rev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return recs
+}
+
+func TestArchiveRestore(t *testing.T) {
+	ctx := context.TODO()
+
+	csi, cleanup, err := testCarStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	cs := csi.(*FileCarStore)
+
+	if err := cs.ArchiveRepo(ctx, 1); !errors.Is(err, ErrNoColdStore) {
+		t.Fatalf("expected ErrNoColdStore, got %v", err)
+	}
+
+	if err := cs.SetColdStore(&DirColdStore{Dir: t.TempDir()}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	recs := writeTestRepo(t, cs, 1, 5)
+	head, err := cs.GetUserRepoHead(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shards, err := cs.meta.GetUserShards(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cs.ArchiveRepo(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if !cs.IsArchived(1) {
+		t.Fatal("expected repo to be archived")
+	}
+	for _, sh := range shards {
+		if _, err := os.Stat(sh.Path); !os.IsNotExist(err) {
+			t.Fatalf("expected shard file %s to be removed", sh.Path)
+		}
+	}
+
+	// metadata is still available without a restore
+	archHead, err := cs.GetUserRepoHead(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archHead != head {
+		t.Fatalf("head mismatch after archive: %s != %s", archHead, head)
+	}
+	if !cs.IsArchived(1) {
+		t.Fatal("head lookup should not restore the repo")
+	}
+
+	// reading the repo restores it transparently
+	buf := new(bytes.Buffer)
+	if err := cs.ReadUserCar(ctx, 1, "", true, buf); err != nil {
+		t.Fatal(err)
+	}
+	checkRepo(t, cs, buf, recs)
+	if cs.IsArchived(1) {
+		t.Fatal("expected repo to be restored")
+	}
+	for _, sh := range shards {
+		if _, err := os.Stat(sh.Path); err != nil {
+			t.Fatalf("expected shard file %s to be restored: %s", sh.Path, err)
+		}
+	}
+}
+
+// slowColdStore delays reads, to exercise the restore budget
+type slowColdStore struct {
+	DirColdStore
+	delay time.Duration
+}
+
+func (s *slowColdStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	time.Sleep(s.delay)
+	return s.DirColdStore.Get(ctx, key)
+}
+
+func TestArchiveRestoreBudget(t *testing.T) {
+	ctx := context.TODO()
+
+	csi, cleanup, err := testCarStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	cs := csi.(*FileCarStore)
+
+	cold := &slowColdStore{DirColdStore: DirColdStore{Dir: filepath.Join(t.TempDir(), "cold")}, delay: 200 * time.Millisecond}
+	if err := cs.SetColdStore(cold, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	recs := writeTestRepo(t, cs, 2, 3)
+	if err := cs.ArchiveRepo(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cs.ReadUserCar(ctx, 2, "", true, io.Discard); !errors.Is(err, ErrRestorePending) {
+		t.Fatalf("expected ErrRestorePending, got %v", err)
+	}
+
+	// the restore carries on in the background; an explicit unarchive waits for it
+	if err := cs.UnarchiveRepo(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if cs.IsArchived(2) {
+		t.Fatal("expected repo to be restored")
+	}
+
+	buf := new(bytes.Buffer)
+	if err := cs.ReadUserCar(ctx, 2, "", true, buf); err != nil {
+		t.Fatal(err)
+	}
+	checkRepo(t, cs, buf, recs)
+}
@@ -65,6 +65,14 @@ type FileCarStore struct {
 
 	lscLk          sync.Mutex
 	lastShardCache map[models.Uid]*CarShard
+	// set by SetShared; disables lastShardCache
+	shared bool
+
+	// set by SetColdStore, nil if archiving is disabled
+	arc *archiveState
+
+	// set by SetDedup, nil if dedup is disabled
+	dedup *dedupState
 
 	log *slog.Logger
 }
@@ -87,6 +95,9 @@ func NewCarStore(meta *gorm.DB, roots []string) (CarStore, error) {
 	if err := meta.AutoMigrate(&staleRef{}); err != nil {
 		return nil, err
 	}
+	if err := meta.AutoMigrate(&dedupBlock{}, &dedupPack{}); err != nil {
+		return nil, err
+	}
 
 	return &FileCarStore{
 		meta:           &CarStoreGormMeta{meta: meta},
@@ -148,6 +159,14 @@ func (uv *userView) Get(ctx context.Context, k cid.Cid) (blockformat.Block, erro
 		blockGetTotalCounterNormal.Add(1)
 	}
 
+	if err := uv.cs.ensureRestored(ctx, user); err != nil {
+		return nil, err
+	}
+
+	if offset == pooledOffset {
+		return uv.cs.readPooledBlock(ctx, k)
+	}
+
 	if prefetch {
 		return uv.prefetchRead(ctx, k, path, offset)
 	} else {
@@ -276,6 +295,25 @@ type DeltaSession struct {
 	lastRev  string
 }
 
+// SetShared marks the carstore as shared with other processes, which write to
+// the same metadata database and shard directories (eg, several PDS instances
+// on shared storage). Per-process caches of repo state are then bypassed, so
+// that writes made elsewhere are always seen. Writers must coordinate with
+// each other, eg with a repomgr.RepoLocker.
+func (cs *FileCarStore) SetShared(shared bool) {
+	cs.lscLk.Lock()
+	cs.shared = shared
+	cs.lastShardCache = make(map[models.Uid]*CarShard)
+	cs.lscLk.Unlock()
+
+	// each process appends to dedup packs of its own
+	if ds := cs.dedup; ds != nil && shared {
+		ds.lk.Lock()
+		ds.pack = nil
+		ds.lk.Unlock()
+	}
+}
+
 func (cs *FileCarStore) checkLastShardCache(user models.Uid) *CarShard {
 	cs.lscLk.Lock()
 	defer cs.lscLk.Unlock()
@@ -298,6 +336,9 @@ func (cs *FileCarStore) removeLastShardCache(user models.Uid) {
 func (cs *FileCarStore) putLastShardCache(ls *CarShard) {
 	cs.lscLk.Lock()
 	defer cs.lscLk.Unlock()
+	if cs.shared {
+		return
+	}
 
 	cs.lastShardCache[ls.Usr] = ls
 }
@@ -322,10 +363,17 @@ func (cs *FileCarStore) getLastShard(ctx context.Context, user models.Uid) (*Car
 
 var ErrRepoBaseMismatch = fmt.Errorf("attempted a delta session on top of the wrong previous head")
 
+// ErrMalformedCarSlice is returned when an imported car slice can't be parsed
+var ErrMalformedCarSlice = fmt.Errorf("malformed car slice")
+
 func (cs *FileCarStore) NewDeltaSession(ctx context.Context, user models.Uid, since *string) (*DeltaSession, error) {
 	ctx, span := otel.Tracer("carstore").Start(ctx, "NewSession")
 	defer span.End()
 
+	if err := cs.ensureRestored(ctx, user); err != nil {
+		return nil, err
+	}
+
 	// TODO: ensure that we don't write updates on top of the wrong head
 	// this needs to be a compare and swap type operation
 	lastShard, err := cs.getLastShard(ctx, user)
@@ -398,6 +446,10 @@ func (cs *FileCarStore) ReadUserCar(ctx context.Context, user models.Uid, sinceR
 		return fmt.Errorf("no data found for user %d", user)
 	}
 
+	if err := cs.ensureRestored(ctx, user); err != nil {
+		return err
+	}
+
 	// fast path!
 	if err := car.WriteHeader(&car.CarHeader{
 		Roots:   []cid.Cid{shards[0].Root.CID},
@@ -406,10 +458,22 @@ func (cs *FileCarStore) ReadUserCar(ctx context.Context, user models.Uid, sinceR
 		return err
 	}
 
+	shardIds := make([]uint, len(shards))
+	for i, sh := range shards {
+		shardIds[i] = sh.ID
+	}
+	pooled, err := cs.pooledBlocksForShards(ctx, shardIds)
+	if err != nil {
+		return err
+	}
+
 	for _, sh := range shards {
 		if err := cs.writeShardBlocks(ctx, &sh, w); err != nil {
 			return err
 		}
+		if err := cs.writePooledBlocks(ctx, pooled[sh.ID], w); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -616,6 +680,20 @@ func (cs *FileCarStore) writeNewShard(ctx context.Context, root cid.Cid, rev str
 		return nil, fmt.Errorf("failed to write car header: %w", err)
 	}
 
+	// with dedup enabled, pooled blocks are left out of the shard file, but
+	// the returned slice (used for the event) still needs every block
+	fbuf := buf
+	var pooled map[cid.Cid]bool
+	if cs.dedup != nil {
+		pooled, err = cs.poolBlocks(ctx, user, blks)
+		if err != nil {
+			return nil, fmt.Errorf("failed to dedup blocks: %w", err)
+		}
+		if len(pooled) > 0 {
+			fbuf = bytes.NewBuffer(bytes.Clone(buf.Bytes()))
+		}
+	}
+
 	// TODO: writing these blocks in map traversal order is bad, I believe the
 	// optimal ordering will be something like reverse-write-order, but random
 	// is definitely not it
@@ -629,6 +707,20 @@ func (cs *FileCarStore) writeNewShard(ctx context.Context, root cid.Cid, rev str
 			return nil, fmt.Errorf("failed to write block: %w", err)
 		}
 
+		if pooled[k] {
+			brefs = append(brefs, map[string]interface{}{
+				"cid":    models.DbCID{CID: k},
+				"offset": pooledOffset,
+			})
+			dedupBytesSaved.Add(float64(nw))
+			continue
+		}
+		if fbuf != buf {
+			if _, err := LdWrite(fbuf, k.Bytes(), blk.RawData()); err != nil {
+				return nil, fmt.Errorf("failed to write block: %w", err)
+			}
+		}
+
 		/*
 			brefs = append(brefs, &blockRef{
 				Cid:    k.String(),
@@ -647,7 +739,7 @@ func (cs *FileCarStore) writeNewShard(ctx context.Context, root cid.Cid, rev str
 	}
 
 	start := time.Now()
-	path, err := cs.writeNewShardFile(ctx, user, seq, buf.Bytes())
+	path, err := cs.writeNewShardFile(ctx, user, seq, fbuf.Bytes())
 	if err != nil {
 		return nil, fmt.Errorf("failed to write shard file: %w", err)
 	}
@@ -757,11 +849,11 @@ func (cs *FileCarStore) ImportSlice(ctx context.Context, uid models.Uid, since *
 
 	carr, err := car.NewCarReader(bytes.NewReader(carslice))
 	if err != nil {
-		return cid.Undef, nil, err
+		return cid.Undef, nil, fmt.Errorf("%w: %w", ErrMalformedCarSlice, err)
 	}
 
 	if len(carr.Header.Roots) != 1 {
-		return cid.Undef, nil, fmt.Errorf("invalid car file, header must have a single root (has %d)", len(carr.Header.Roots))
+		return cid.Undef, nil, fmt.Errorf("%w: header must have a single root (has %d)", ErrMalformedCarSlice, len(carr.Header.Roots))
 	}
 
 	ds, err := cs.NewDeltaSession(ctx, uid, since)
@@ -776,7 +868,7 @@ func (cs *FileCarStore) ImportSlice(ctx context.Context, uid models.Uid, since *
 			if err == io.EOF {
 				break
 			}
-			return cid.Undef, nil, err
+			return cid.Undef, nil, fmt.Errorf("%w: %w", ErrMalformedCarSlice, err)
 		}
 
 		cids = append(cids, blk.Cid())
@@ -848,6 +940,10 @@ func (cs *FileCarStore) Stat(ctx context.Context, usr models.Uid) ([]UserStat, e
 }
 
 func (cs *FileCarStore) WipeUserData(ctx context.Context, user models.Uid) error {
+	if err := cs.forgetArchive(ctx, user); err != nil {
+		return err
+	}
+
 	shards, err := cs.meta.GetUserShards(ctx, user)
 	if err != nil {
 		return err
@@ -1059,6 +1155,11 @@ func (cs *FileCarStore) CompactUserShards(ctx context.Context, user models.Uid,
 
 	span.SetAttributes(attribute.Int64("user", int64(user)))
 
+	// compacting would pull the repo back out of cold storage
+	if cs.IsArchived(user) {
+		return nil, ErrRepoArchived
+	}
+
 	shards, err := cs.meta.GetUserShards(ctx, user)
 	if err != nil {
 		return nil, err
@@ -1233,7 +1334,7 @@ func (cs *FileCarStore) CompactUserShards(ctx context.Context, user models.Uid,
 			continue
 		}
 
-		if err := cs.compactBucket(ctx, user, b, shardsById, keep); err != nil {
+		if err := cs.compactBucket(ctx, user, b, shardsById, keep, nil); err != nil {
 			return nil, fmt.Errorf("compact bucket: %w", err)
 		}
 
@@ -1302,12 +1403,27 @@ func (cs *FileCarStore) deleteStaleRefs(ctx context.Context, uid models.Uid, bre
 	return cs.meta.SetStaleRef(ctx, uid, staleToKeep)
 }
 
-func (cs *FileCarStore) compactBucket(ctx context.Context, user models.Uid, b *compBucket, shardsById map[uint]CarShard, keep map[cid.Cid]bool) error {
+// blocks in pool (and blocks the bucket's shards already reference from the
+// dedup pool) are referenced from the pool by the new shard, rather than
+// written to it.
+func (cs *FileCarStore) compactBucket(ctx context.Context, user models.Uid, b *compBucket, shardsById map[uint]CarShard, keep map[cid.Cid]bool, pool map[cid.Cid]bool) error {
 	ctx, span := otel.Tracer("carstore").Start(ctx, "compactBucket")
 	defer span.End()
 
 	span.SetAttributes(attribute.Int("shards", len(b.shards)))
 
+	pooled := make(map[cid.Cid]bool, len(pool))
+	for c := range pool {
+		pooled[c] = true
+	}
+	for _, s := range b.shards {
+		for _, br := range s.refs {
+			if br.Offset == pooledOffset {
+				pooled[br.Cid.CID] = true
+			}
+		}
+	}
+
 	last := b.shards[len(b.shards)-1]
 	lastsh := shardsById[last.ID]
 	fi, path, err := cs.openNewCompactedShardFile(ctx, user, last.Seq)
@@ -1329,7 +1445,7 @@ func (cs *FileCarStore) compactBucket(ctx context.Context, user models.Uid, b *c
 	for _, s := range b.shards {
 		sh := shardsById[s.ID]
 		if err := cs.iterateShardBlocks(ctx, &sh, func(blk blockformat.Block) error {
-			if written[blk.Cid()] {
+			if written[blk.Cid()] || pooled[blk.Cid()] {
 				return nil
 			}
 
@@ -1355,6 +1471,16 @@ func (cs *FileCarStore) compactBucket(ctx context.Context, user models.Uid, b *c
 		}
 	}
 
+	for c := range pooled {
+		if keep[c] && !written[c] {
+			nbrefs = append(nbrefs, map[string]interface{}{
+				"cid":    models.DbCID{CID: c},
+				"offset": pooledOffset,
+			})
+			written[c] = true
+		}
+	}
+
 	shard := CarShard{
 		Root:      models.DbCID{CID: root},
 		DataStart: hnw,
@@ -0,0 +1,61 @@
+package carstore
+
+import (
+	"context"
+	"os"
+
+	"github.com/bluesky-social/indigo/models"
+)
+
+// RepoShardCheck summarizes the state of a repo's shards, for consistency
+// checking against the rest of the system
+type RepoShardCheck struct {
+	Shards int `json:"shards"`
+	// shards whose files don't exist on disk (only checked if requested)
+	MissingFiles []string `json:"missingFiles,omitempty"`
+	// archived repos' shard files are expected to be missing from local disk
+	Archived bool `json:"archived,omitempty"`
+}
+
+// CheckUserShards reports on the shards of each of the given repos. Repos with
+// no shards at all are included, with a zero shard count. If checkFiles is
+// set, every shard file of non-archived repos is stat'ed.
+func (cs *FileCarStore) CheckUserShards(ctx context.Context, users []models.Uid, checkFiles bool) (map[models.Uid]*RepoShardCheck, error) {
+	out := make(map[models.Uid]*RepoShardCheck, len(users))
+	for _, u := range users {
+		out[u] = &RepoShardCheck{Archived: cs.IsArchived(u)}
+	}
+	if len(users) == 0 {
+		return out, nil
+	}
+
+	var shards []CarShard
+	if err := cs.meta.meta.WithContext(ctx).Model(CarShard{}).Select("usr", "path").Where("usr in (?)", users).Find(&shards).Error; err != nil {
+		return nil, err
+	}
+	for _, sh := range shards {
+		chk := out[sh.Usr]
+		chk.Shards++
+		if !checkFiles || chk.Archived {
+			continue
+		}
+		if _, err := os.Stat(sh.Path); err != nil {
+			if !os.IsNotExist(err) {
+				return nil, err
+			}
+			chk.MissingFiles = append(chk.MissingFiles, sh.Path)
+		}
+	}
+	return out, nil
+}
+
+// ShardUsers lists (in order) up to limit repos which have at least one shard,
+// with uids greater than after. Used to page through every repo in the
+// carstore.
+func (cs *FileCarStore) ShardUsers(ctx context.Context, after models.Uid, limit int) ([]models.Uid, error) {
+	var users []models.Uid
+	if err := cs.meta.meta.WithContext(ctx).Model(CarShard{}).Distinct("usr").Where("usr > ?", after).Order("usr asc").Limit(limit).Pluck("usr", &users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
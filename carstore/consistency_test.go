@@ -0,0 +1,61 @@
+package carstore
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/bluesky-social/indigo/models"
+)
+
+func TestCheckUserShards(t *testing.T) {
+	ctx := context.TODO()
+	cs := testDedupCarStore(t)
+
+	writeTestRepo(t, cs, 1, 2)
+	writeTestRepo(t, cs, 3, 2)
+
+	checks, err := cs.CheckUserShards(ctx, []models.Uid{1, 2, 3}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checks[1].Shards == 0 || checks[3].Shards == 0 {
+		t.Fatalf("expected shards for users 1 and 3: %+v %+v", checks[1], checks[3])
+	}
+	if checks[2].Shards != 0 {
+		t.Fatalf("expected no shards for user 2, got %d", checks[2].Shards)
+	}
+	if len(checks[1].MissingFiles) != 0 {
+		t.Fatalf("unexpected missing files: %v", checks[1].MissingFiles)
+	}
+
+	shards, err := cs.meta.GetUserShards(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(shards[0].Path); err != nil {
+		t.Fatal(err)
+	}
+	checks, err = cs.CheckUserShards(ctx, []models.Uid{3}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checks[3].MissingFiles) != 1 || checks[3].MissingFiles[0] != shards[0].Path {
+		t.Fatalf("expected missing shard file to be reported: %+v", checks[3])
+	}
+
+	users, err := cs.ShardUsers(ctx, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users[0] != 1 || users[1] != 3 {
+		t.Fatalf("unexpected shard users: %v", users)
+	}
+	users, err = cs.ShardUsers(ctx, 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0] != 3 {
+		t.Fatalf("unexpected shard users after 1: %v", users)
+	}
+}
@@ -0,0 +1,761 @@
+package carstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/models"
+
+	blockformat "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// pooledOffset is the offset recorded in a blockRef for a block which lives in
+// the dedup pool, rather than in the shard file itself
+const pooledOffset int64 = -1
+
+// dedupBlock is a block stored once in the shared dedup pool. Any number of
+// shards (across repos) reference it with blockRefs at pooledOffset; Refs
+// counts them. Blocks whose count drops to zero are left in place, and
+// reclaimed by CompactDedupPool.
+type dedupBlock struct {
+	ID     uint         `gorm:"primarykey"`
+	Cid    models.DbCID `gorm:"uniqueIndex"`
+	Pack   uint         `gorm:"index"`
+	Offset int64
+	// size of the entry in the pack file (length-prefixed cid and data)
+	Size      int64
+	Refs      int64
+	UpdatedAt time.Time
+}
+
+// dedupPack is an append-only file of pooled blocks, in the same framing as
+// the body of a CAR file
+type dedupPack struct {
+	ID   uint `gorm:"primarykey"`
+	Path string
+	Size int64
+}
+
+type DedupOptions struct {
+	// Directory for pack files. Defaults to "dedup" in the first shard
+	// directory
+	Dir string
+	// Blocks smaller than this are always written inline, since the metadata
+	// for a pooled block costs about as much as storing it twice
+	MinBlockSize int
+	// Size at which a new pack file is started
+	PackSize int64
+}
+
+func DefaultDedupOptions() DedupOptions {
+	return DedupOptions{
+		MinBlockSize: 256,
+		PackSize:     64 << 20,
+	}
+}
+
+// how long a zero-ref pooled block is kept before CompactDedupPool will drop
+// it, so that a concurrent write which just found it can still reference it
+const dedupGracePeriod = time.Hour
+
+type dedupState struct {
+	opts DedupOptions
+
+	// protects appends to the current pack
+	lk   sync.Mutex
+	pack *dedupPack
+}
+
+// SetDedup enables block-level deduplication for new writes. A block written
+// by one repo which already exists in another repo's shards is stored once in
+// a shared pool of pack files instead, and referenced from each shard. Blocks
+// which only appear in a single repo stay inline in its shard files, so reads
+// of whole repos remain sequential.
+//
+// Pooled blocks are readable whether or not dedup is enabled; existing
+// duplicates can be moved in to the pool with DedupExistingBlocks.
+func (cs *FileCarStore) SetDedup(opts DedupOptions) error {
+	if opts.Dir == "" {
+		opts.Dir = filepath.Join(cs.rootDirs[0], "dedup")
+	}
+	if opts.PackSize <= 0 {
+		opts.PackSize = DefaultDedupOptions().PackSize
+	}
+	if err := os.MkdirAll(opts.Dir, 0775); err != nil {
+		return err
+	}
+
+	ds := &dedupState{opts: opts}
+	var last dedupPack
+	if err := cs.meta.meta.Order("id desc").Limit(1).Find(&last).Error; err != nil {
+		return err
+	}
+	// other processes sharing the carstore may be appending to the last pack
+	if last.ID != 0 && last.Size < opts.PackSize && !cs.shared {
+		ds.pack = &last
+	}
+
+	cs.dedup = ds
+	return nil
+}
+
+func (cs *FileCarStore) newDedupPack(ctx context.Context) (*dedupPack, error) {
+	p := &dedupPack{}
+	if err := cs.meta.meta.WithContext(ctx).Create(p).Error; err != nil {
+		return nil, err
+	}
+	p.Path = filepath.Join(cs.dedup.opts.Dir, fmt.Sprintf("pack-%d", p.ID))
+	if err := cs.meta.meta.WithContext(ctx).Model(p).Update("path", p.Path).Error; err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// appends blocks to the current pack, and returns the (not yet persisted)
+// dedupBlock rows locating them
+func (cs *FileCarStore) writePoolEntries(ctx context.Context, blks []blockformat.Block) ([]dedupBlock, error) {
+	ds := cs.dedup
+	ds.lk.Lock()
+	defer ds.lk.Unlock()
+
+	if ds.pack == nil || ds.pack.Size >= ds.opts.PackSize {
+		p, err := cs.newDedupPack(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("creating dedup pack: %w", err)
+		}
+		ds.pack = p
+	}
+
+	fi, err := os.OpenFile(ds.pack.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0664)
+	if err != nil {
+		return nil, err
+	}
+	defer fi.Close()
+
+	// trust the file over the database, in case we crashed between the two
+	st, err := fi.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset := st.Size()
+
+	buf := new(bytes.Buffer)
+	rows := make([]dedupBlock, 0, len(blks))
+	for _, blk := range blks {
+		nw, err := LdWrite(buf, blk.Cid().Bytes(), blk.RawData())
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, dedupBlock{
+			Cid:    models.DbCID{CID: blk.Cid()},
+			Pack:   ds.pack.ID,
+			Offset: offset,
+			Size:   nw,
+		})
+		offset += nw
+	}
+
+	if _, err := fi.Write(buf.Bytes()); err != nil {
+		return nil, fmt.Errorf("writing dedup pack: %w", err)
+	}
+	ds.pack.Size = offset
+	if err := cs.meta.meta.WithContext(ctx).Model(ds.pack).Update("size", offset).Error; err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// adds blocks to the pool, with a ref count of zero (refs are counted as
+// shards referencing them are written)
+func (cs *FileCarStore) addToPool(ctx context.Context, blks []blockformat.Block) error {
+	rows, err := cs.writePoolEntries(ctx, blks)
+	if err != nil {
+		return err
+	}
+	// a concurrent writer may have pooled some of the same blocks. theirs
+	// win, and our copies are dead space in the pack
+	return cs.meta.meta.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(rows, 500).Error
+}
+
+func dbCids(cids []cid.Cid) []models.DbCID {
+	out := make([]models.DbCID, len(cids))
+	for i, c := range cids {
+		out[i] = models.DbCID{CID: c}
+	}
+	return out
+}
+
+// gorm can't scan directly into a []models.DbCID
+type cidRow struct {
+	Cid models.DbCID
+}
+
+// batch size for "cid IN (...)" queries
+const dedupQueryChunk = 500
+
+// poolBlocks decides which blocks of a new shard should be referenced from the
+// pool rather than written inline: those already pooled, and those which
+// already exist inline in another repo's shards (which are added to the pool).
+func (cs *FileCarStore) poolBlocks(ctx context.Context, user models.Uid, blks map[cid.Cid]blockformat.Block) (map[cid.Cid]bool, error) {
+	ctx, span := otel.Tracer("carstore").Start(ctx, "poolBlocks")
+	defer span.End()
+
+	var cands []cid.Cid
+	for k, blk := range blks {
+		if len(blk.RawData()) >= cs.dedup.opts.MinBlockSize {
+			cands = append(cands, k)
+		}
+	}
+
+	pooled := make(map[cid.Cid]bool)
+	db := cs.meta.meta.WithContext(ctx)
+	for i := 0; i < len(cands); i += dedupQueryChunk {
+		chunk := dbCids(cands[i:min(i+dedupQueryChunk, len(cands))])
+
+		var found []cidRow
+		if err := db.Model(&dedupBlock{}).Select("cid").Where("cid IN ?", chunk).Scan(&found).Error; err != nil {
+			return nil, fmt.Errorf("looking up pooled blocks: %w", err)
+		}
+		if len(found) > 0 {
+			// keep CompactDedupPool from dropping blocks we are about to reference
+			if err := db.Model(&dedupBlock{}).Where("cid IN ? AND refs <= 0", chunk).Update("updated_at", time.Now()).Error; err != nil {
+				return nil, err
+			}
+		}
+		for _, r := range found {
+			pooled[r.Cid.CID] = true
+		}
+
+		var rest []models.DbCID
+		for _, c := range chunk {
+			if !pooled[c.CID] {
+				rest = append(rest, c)
+			}
+		}
+		if len(rest) == 0 {
+			continue
+		}
+
+		var dups []cidRow
+		if err := db.Raw(`SELECT DISTINCT block_refs.cid FROM block_refs
+JOIN car_shards ON car_shards.id = block_refs.shard
+WHERE block_refs.cid IN ? AND car_shards.usr != ? AND block_refs.offset >= 0`, rest, user).Scan(&dups).Error; err != nil {
+			return nil, fmt.Errorf("looking up duplicate blocks: %w", err)
+		}
+		if len(dups) == 0 {
+			continue
+		}
+
+		toAdd := make([]blockformat.Block, 0, len(dups))
+		for _, r := range dups {
+			toAdd = append(toAdd, blks[r.Cid.CID])
+		}
+		if err := cs.addToPool(ctx, toAdd); err != nil {
+			return nil, fmt.Errorf("adding blocks to pool: %w", err)
+		}
+		dedupNewPooled.Add(float64(len(dups)))
+		for _, r := range dups {
+			pooled[r.Cid.CID] = true
+		}
+	}
+
+	dedupBlocksCounter.WithLabelValues("pooled").Add(float64(len(pooled)))
+	dedupBlocksCounter.WithLabelValues("inline").Add(float64(len(blks) - len(pooled)))
+	span.SetAttributes(attribute.Int("pooled", len(pooled)))
+	return pooled, nil
+}
+
+type pooledLoc struct {
+	Shard  uint
+	Cid    models.DbCID
+	Path   string
+	Offset int64
+}
+
+func (cs *FileCarStore) lookupPooledBlock(ctx context.Context, k cid.Cid) (*pooledLoc, error) {
+	var loc pooledLoc
+	if err := cs.meta.meta.WithContext(ctx).Raw(`SELECT dedup_packs.path, dedup_blocks.offset FROM dedup_blocks
+JOIN dedup_packs ON dedup_packs.id = dedup_blocks.pack
+WHERE dedup_blocks.cid = ?`, models.DbCID{CID: k}).Scan(&loc).Error; err != nil {
+		return nil, err
+	}
+	if loc.Path == "" {
+		return nil, fmt.Errorf("block referenced from dedup pool is missing: %s", k)
+	}
+	return &loc, nil
+}
+
+func (cs *FileCarStore) readPooledBlock(ctx context.Context, k cid.Cid) (blockformat.Block, error) {
+	for attempt := 0; ; attempt++ {
+		loc, err := cs.lookupPooledBlock(ctx, k)
+		if err != nil {
+			return nil, err
+		}
+		fi, err := os.Open(loc.Path)
+		if err != nil {
+			// the pack may have just been compacted away; look the block up again
+			if errors.Is(err, os.ErrNotExist) && attempt == 0 {
+				continue
+			}
+			return nil, err
+		}
+		blk, err := doBlockRead(fi, k, loc.Offset)
+		fi.Close()
+		return blk, err
+	}
+}
+
+// looks up the pooled blocks referenced by each of the given shards. used by
+// ReadUserCar, which writes them after the inline blocks of each shard
+func (cs *FileCarStore) pooledBlocksForShards(ctx context.Context, shardIds []uint) (map[uint][]pooledLoc, error) {
+	out := make(map[uint][]pooledLoc)
+	for i := 0; i < len(shardIds); i += dedupQueryChunk {
+		var locs []pooledLoc
+		if err := cs.meta.meta.WithContext(ctx).Raw(`SELECT block_refs.shard, dedup_blocks.cid, dedup_packs.path, dedup_blocks.offset FROM block_refs
+JOIN dedup_blocks ON dedup_blocks.cid = block_refs.cid
+JOIN dedup_packs ON dedup_packs.id = dedup_blocks.pack
+WHERE block_refs.shard IN ? AND block_refs.offset < 0`, shardIds[i:min(i+dedupQueryChunk, len(shardIds))]).Scan(&locs).Error; err != nil {
+			return nil, fmt.Errorf("looking up pooled blocks for shards: %w", err)
+		}
+		for _, l := range locs {
+			out[l.Shard] = append(out[l.Shard], l)
+		}
+	}
+	return out, nil
+}
+
+func (cs *FileCarStore) writePooledBlocks(ctx context.Context, locs []pooledLoc, w io.Writer) error {
+	files := make(map[string]*os.File)
+	defer func() {
+		for _, fi := range files {
+			fi.Close()
+		}
+	}()
+
+	for _, l := range locs {
+		fi, ok := files[l.Path]
+		if !ok {
+			var err error
+			fi, err = os.Open(l.Path)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			files[l.Path] = fi
+		}
+
+		var blk blockformat.Block
+		var err error
+		if fi != nil {
+			blk, err = doBlockRead(fi, l.Cid.CID, l.Offset)
+		}
+		if fi == nil || err != nil {
+			// moved by pool compaction since we looked it up
+			blk, err = cs.readPooledBlock(ctx, l.Cid.CID)
+			if err != nil {
+				return err
+			}
+		}
+		if _, err := LdWrite(w, blk.Cid().Bytes(), blk.RawData()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// adds a reference to each of the pooled blocks (at most once per cid). must
+// be called within the transaction creating the blockRefs
+func acquirePooledRefs(tx *gorm.DB, cids []models.DbCID) error {
+	for i := 0; i < len(cids); i += dedupQueryChunk {
+		chunk := cids[i:min(i+dedupQueryChunk, len(cids))]
+		if err := tx.Exec(`UPDATE dedup_blocks SET refs = refs + 1, updated_at = ? WHERE cid IN ?`, time.Now(), chunk).Error; err != nil {
+			return fmt.Errorf("updating pooled block refs: %w", err)
+		}
+	}
+	return nil
+}
+
+// drops the references held on pooled blocks by the given shards. must be
+// called within the transaction deleting their blockRefs
+func releasePooledRefs(tx *gorm.DB, shardIds []uint) error {
+	var counts []struct {
+		Cid models.DbCID
+		N   int64
+	}
+	if err := tx.Raw(`SELECT cid, count(*) AS n FROM block_refs WHERE shard IN ? AND block_refs.offset < 0 GROUP BY cid`, shardIds).Scan(&counts).Error; err != nil {
+		return fmt.Errorf("counting pooled block refs: %w", err)
+	}
+
+	byCount := make(map[int64][]models.DbCID)
+	for _, c := range counts {
+		byCount[c.N] = append(byCount[c.N], c.Cid)
+	}
+	now := time.Now()
+	for n, cids := range byCount {
+		for i := 0; i < len(cids); i += dedupQueryChunk {
+			chunk := cids[i:min(i+dedupQueryChunk, len(cids))]
+			if err := tx.Exec(`UPDATE dedup_blocks SET refs = refs - ?, updated_at = ? WHERE cid IN ?`, n, now, chunk).Error; err != nil {
+				return fmt.Errorf("updating pooled block refs: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+type DedupMigrationStats struct {
+	// Duplicated cids examined
+	Candidates int `json:"candidates"`
+	// Blocks moved in to the pool
+	BlocksPooled int `json:"blocksPooled"`
+	// Shards rewritten without their inline copies of pooled blocks
+	ShardsRewritten int `json:"shardsRewritten"`
+	// Shards left alone because their repo is archived
+	ShardsSkipped int `json:"shardsSkipped"`
+	// Approximate bytes freed from shard files
+	BytesSaved int64 `json:"bytesSaved"`
+	// Cursor to pass for the next batch. Empty once all existing blocks
+	// have been examined
+	Cursor string `json:"cursor"`
+}
+
+// DedupExistingBlocks is a migration job for stores written before dedup was
+// enabled (or blocks which were written inline by two repos concurrently).
+// It finds up to batch cids (after cursor) with more than one inline copy,
+// moves them in to the pool, and rewrites the shards holding those copies to
+// reference the pool instead. Call repeatedly with the returned cursor until
+// it is empty.
+//
+// Rewriting a shard is the same operation as compacting it, and the same
+// caveats apply to running it concurrently with writes to the repo.
+func (cs *FileCarStore) DedupExistingBlocks(ctx context.Context, cursor string, batch int) (*DedupMigrationStats, error) {
+	ctx, span := otel.Tracer("carstore").Start(ctx, "DedupExistingBlocks")
+	defer span.End()
+
+	if cs.dedup == nil {
+		return nil, fmt.Errorf("dedup is not enabled")
+	}
+
+	db := cs.meta.meta.WithContext(ctx)
+	var dups []struct {
+		Cid models.DbCID
+		N   int64
+	}
+	q := `SELECT cid, count(*) AS n FROM block_refs WHERE block_refs.offset >= 0 %s GROUP BY cid HAVING count(*) > 1 ORDER BY cid LIMIT ?`
+	if cursor != "" {
+		after, err := cid.Decode(cursor)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cursor: %w", err)
+		}
+		if err := db.Raw(fmt.Sprintf(q, "AND cid > ?"), models.DbCID{CID: after}, batch).Scan(&dups).Error; err != nil {
+			return nil, err
+		}
+	} else {
+		if err := db.Raw(fmt.Sprintf(q, ""), batch).Scan(&dups).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	stats := &DedupMigrationStats{Candidates: len(dups)}
+	if len(dups) == batch {
+		stats.Cursor = dups[len(dups)-1].Cid.CID.String()
+	}
+	if len(dups) == 0 {
+		return stats, nil
+	}
+
+	// make sure each candidate is in the pool
+	cands := make([]models.DbCID, 0, len(dups))
+	for _, d := range dups {
+		cands = append(cands, d.Cid)
+	}
+	var already []cidRow
+	if err := db.Model(&dedupBlock{}).Select("cid").Where("cid IN ?", cands).Scan(&already).Error; err != nil {
+		return nil, err
+	}
+	pooled := make(map[cid.Cid]bool)
+	for _, r := range already {
+		pooled[r.Cid.CID] = true
+	}
+
+	var toAdd []blockformat.Block
+	for _, d := range dups {
+		if pooled[d.Cid.CID] {
+			continue
+		}
+		blk, err := cs.readInlineBlock(ctx, d.Cid.CID)
+		if err != nil {
+			cs.log.Warn("dedup: failed to read duplicated block", "cid", d.Cid.CID, "err", err)
+			continue
+		}
+		if len(blk.RawData()) < cs.dedup.opts.MinBlockSize {
+			continue
+		}
+		toAdd = append(toAdd, blk)
+		stats.BytesSaved += int64(len(blk.RawData())) * (d.N - 1)
+	}
+	if len(toAdd) > 0 {
+		if err := cs.addToPool(ctx, toAdd); err != nil {
+			return nil, err
+		}
+		for _, blk := range toAdd {
+			pooled[blk.Cid()] = true
+		}
+	}
+	stats.BlocksPooled = len(toAdd)
+	dedupMigratedBlocks.Add(float64(len(toAdd)))
+	if len(pooled) == 0 {
+		return stats, nil
+	}
+
+	// rewrite every shard holding an inline copy of a pooled candidate
+	pooledCids := make([]models.DbCID, 0, len(pooled))
+	for c := range pooled {
+		pooledCids = append(pooledCids, models.DbCID{CID: c})
+	}
+	var shardIds []uint
+	if err := db.Raw(`SELECT DISTINCT shard FROM block_refs WHERE cid IN ? AND block_refs.offset >= 0`, pooledCids).Scan(&shardIds).Error; err != nil {
+		return nil, err
+	}
+	var shards []CarShard
+	if err := db.Find(&shards, "id IN ?", shardIds).Error; err != nil {
+		return nil, err
+	}
+	for _, sh := range shards {
+		if cs.IsArchived(sh.Usr) {
+			stats.ShardsSkipped++
+			continue
+		}
+		if err := cs.rewriteShard(ctx, sh, pooled); err != nil {
+			return nil, fmt.Errorf("rewriting shard %d: %w", sh.ID, err)
+		}
+		stats.ShardsRewritten++
+	}
+
+	span.SetAttributes(attribute.Int("pooled", stats.BlocksPooled), attribute.Int("rewritten", stats.ShardsRewritten))
+	return stats, nil
+}
+
+// reads some inline copy of a block, from any repo
+func (cs *FileCarStore) readInlineBlock(ctx context.Context, k cid.Cid) (blockformat.Block, error) {
+	var loc pooledLoc
+	if err := cs.meta.meta.WithContext(ctx).Raw(`SELECT car_shards.path, block_refs.offset FROM block_refs
+JOIN car_shards ON car_shards.id = block_refs.shard
+WHERE block_refs.cid = ? AND block_refs.offset >= 0 LIMIT 1`, models.DbCID{CID: k}).Scan(&loc).Error; err != nil {
+		return nil, err
+	}
+	if loc.Path == "" {
+		return nil, fmt.Errorf("no inline copy of block: %s", k)
+	}
+	fi, err := os.Open(loc.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer fi.Close()
+	return doBlockRead(fi, k, loc.Offset)
+}
+
+// replaces a shard with a copy which references the given blocks from the
+// pool, instead of holding them inline
+func (cs *FileCarStore) rewriteShard(ctx context.Context, sh CarShard, pooled map[cid.Cid]bool) error {
+	refs, err := cs.meta.GetBlockRefsForShards(ctx, []uint{sh.ID})
+	if err != nil {
+		return err
+	}
+	keep := make(map[cid.Cid]bool, len(refs))
+	for _, br := range refs {
+		keep[br.Cid.CID] = true
+	}
+	b := &compBucket{}
+	b.addShardStat(shardStat{ID: sh.ID, Seq: sh.Seq, Total: len(refs), refs: refs})
+	if err := cs.compactBucket(ctx, sh.Usr, b, map[uint]CarShard{sh.ID: sh}, keep, pooled); err != nil {
+		return err
+	}
+	return cs.deleteShards(ctx, []CarShard{sh})
+}
+
+type DedupStats struct {
+	// Live blocks in the pool
+	Blocks int64 `json:"blocks"`
+	// Bytes of live blocks in the pool
+	PoolBytes int64 `json:"poolBytes"`
+	// Bytes those blocks would take up if every reference was stored inline
+	LogicalBytes int64 `json:"logicalBytes"`
+	// Bytes in pack files which are no longer referenced
+	DeadBytes int64 `json:"deadBytes"`
+	// LogicalBytes / PoolBytes
+	Ratio float64 `json:"ratio"`
+}
+
+// DedupStats summarizes the dedup pool, and updates the corresponding metrics
+func (cs *FileCarStore) DedupStats(ctx context.Context) (*DedupStats, error) {
+	db := cs.meta.meta.WithContext(ctx)
+	var stats DedupStats
+	if err := db.Raw(`SELECT count(*) AS blocks, coalesce(sum(size), 0) AS pool_bytes, coalesce(sum(size * refs), 0) AS logical_bytes FROM dedup_blocks WHERE refs > 0`).Scan(&stats).Error; err != nil {
+		return nil, err
+	}
+	var packBytes int64
+	if err := db.Raw(`SELECT coalesce(sum(size), 0) FROM dedup_packs`).Scan(&packBytes).Error; err != nil {
+		return nil, err
+	}
+	stats.DeadBytes = max(packBytes-stats.PoolBytes, 0)
+	if stats.PoolBytes > 0 {
+		stats.Ratio = float64(stats.LogicalBytes) / float64(stats.PoolBytes)
+	}
+
+	dedupPoolBytes.Set(float64(stats.PoolBytes))
+	dedupLogicalBytes.Set(float64(stats.LogicalBytes))
+	dedupDeadBytes.Set(float64(stats.DeadBytes))
+	return &stats, nil
+}
+
+// CompactDedupPool reclaims space in pack files whose fraction of
+// unreferenced bytes is at least minDeadFrac, by copying their live blocks in
+// to the current pack and deleting them. Returns the number of packs removed.
+func (cs *FileCarStore) CompactDedupPool(ctx context.Context, minDeadFrac float64) (int, error) {
+	ctx, span := otel.Tracer("carstore").Start(ctx, "CompactDedupPool")
+	defer span.End()
+
+	if cs.dedup == nil {
+		return 0, fmt.Errorf("dedup is not enabled")
+	}
+
+	db := cs.meta.meta.WithContext(ctx)
+	var packs []dedupPack
+	if err := db.Order("id").Find(&packs).Error; err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, p := range packs {
+		cs.dedup.lk.Lock()
+		current := cs.dedup.pack != nil && cs.dedup.pack.ID == p.ID
+		cs.dedup.lk.Unlock()
+		if current || p.Size == 0 {
+			continue
+		}
+
+		// forget blocks which have been unreferenced for a while
+		if err := db.Where("pack = ? AND refs <= 0 AND updated_at < ?", p.ID, time.Now().Add(-dedupGracePeriod)).Delete(&dedupBlock{}).Error; err != nil {
+			return removed, err
+		}
+		var rows []dedupBlock
+		if err := db.Find(&rows, "pack = ?", p.ID).Error; err != nil {
+			return removed, err
+		}
+		var live int64
+		for _, r := range rows {
+			live += r.Size
+		}
+		if float64(p.Size-live)/float64(p.Size) < minDeadFrac {
+			continue
+		}
+
+		if err := cs.movePoolBlocks(ctx, p, rows); err != nil {
+			return removed, fmt.Errorf("compacting dedup pack %d: %w", p.ID, err)
+		}
+		removed++
+	}
+
+	dedupPacksCompacted.Add(float64(removed))
+	return removed, nil
+}
+
+func (cs *FileCarStore) movePoolBlocks(ctx context.Context, p dedupPack, rows []dedupBlock) error {
+	if len(rows) > 0 {
+		fi, err := os.Open(p.Path)
+		if err != nil {
+			return err
+		}
+		blks := make([]blockformat.Block, 0, len(rows))
+		for _, r := range rows {
+			blk, err := doBlockRead(fi, r.Cid.CID, r.Offset)
+			if err != nil {
+				fi.Close()
+				return err
+			}
+			blks = append(blks, blk)
+		}
+		fi.Close()
+
+		moved, err := cs.writePoolEntries(ctx, blks)
+		if err != nil {
+			return err
+		}
+		if err := cs.meta.meta.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			for _, m := range moved {
+				if err := tx.Model(&dedupBlock{}).Where("cid = ?", m.Cid).Updates(map[string]any{
+					"pack":   m.Pack,
+					"offset": m.Offset,
+				}).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	if err := cs.meta.meta.WithContext(ctx).Delete(&dedupPack{}, p.ID).Error; err != nil {
+		return err
+	}
+	if err := os.Remove(p.Path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// RunDedupMaintenance runs the dedup migration job (if migrate is set) to
+// completion, then periodically updates dedup metrics and compacts the pool,
+// until the context is cancelled.
+func (cs *FileCarStore) RunDedupMaintenance(ctx context.Context, interval time.Duration, migrate bool) {
+	if migrate {
+		var total DedupMigrationStats
+		cursor := ""
+		for ctx.Err() == nil {
+			stats, err := cs.DedupExistingBlocks(ctx, cursor, 1000)
+			if err != nil {
+				cs.log.Error("dedup migration failed", "err", err, "cursor", cursor)
+				break
+			}
+			total.Candidates += stats.Candidates
+			total.BlocksPooled += stats.BlocksPooled
+			total.ShardsRewritten += stats.ShardsRewritten
+			total.ShardsSkipped += stats.ShardsSkipped
+			total.BytesSaved += stats.BytesSaved
+			cs.log.Info("dedup migration progress", "candidates", total.Candidates, "pooled", total.BlocksPooled, "rewritten", total.ShardsRewritten, "bytesSaved", total.BytesSaved)
+			if stats.Cursor == "" {
+				cs.log.Info("dedup migration complete")
+				break
+			}
+			cursor = stats.Cursor
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := cs.DedupStats(ctx); err != nil {
+			cs.log.Error("failed to compute dedup stats", "err", err)
+		}
+		if _, err := cs.CompactDedupPool(ctx, 0.5); err != nil {
+			cs.log.Error("failed to compact dedup pool", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
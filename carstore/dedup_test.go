@@ -0,0 +1,207 @@
+package carstore
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/models"
+	"github.com/ipfs/go-cid"
+)
+
+func testDedupCarStore(t *testing.T) *FileCarStore {
+	t.Helper()
+	csi, cleanup, err := testCarStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cleanup)
+	return csi.(*FileCarStore)
+}
+
+func enableTestDedup(t *testing.T, cs *FileCarStore) {
+	t.Helper()
+	opts := DefaultDedupOptions()
+	// test records are tiny
+	opts.MinBlockSize = 0
+	if err := cs.SetDedup(opts); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func countPooledRefs(t *testing.T, cs *FileCarStore, user models.Uid) int64 {
+	t.Helper()
+	var n int64
+	if err := cs.meta.meta.Raw(`SELECT count(*) FROM block_refs JOIN car_shards ON car_shards.id = block_refs.shard
+WHERE car_shards.usr = ? AND block_refs.offset < 0`, user).Scan(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func sumPoolRefs(t *testing.T, cs *FileCarStore) int64 {
+	t.Helper()
+	var n int64
+	if err := cs.meta.meta.Raw(`SELECT coalesce(sum(refs), 0) FROM dedup_blocks`).Scan(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func checkUserRepo(t *testing.T, cs *FileCarStore, user models.Uid, recs []cid.Cid) {
+	t.Helper()
+	ctx := context.TODO()
+
+	buf := new(bytes.Buffer)
+	if err := cs.ReadUserCar(ctx, user, "", true, buf); err != nil {
+		t.Fatal(err)
+	}
+	checkRepo(t, cs, buf, recs)
+
+	ro, err := cs.ReadOnlySession(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rc := range recs {
+		if _, err := ro.Get(ctx, rc); err != nil {
+			t.Fatalf("reading record %s: %s", rc, err)
+		}
+	}
+}
+
+func TestDedupAcrossRepos(t *testing.T) {
+	ctx := context.TODO()
+	cs := testDedupCarStore(t)
+	enableTestDedup(t, cs)
+
+	recs1 := writeTestRepo(t, cs, 1, 5)
+	if n := countPooledRefs(t, cs, 1); n != 0 {
+		t.Fatalf("first repo should be stored inline, found %d pooled refs", n)
+	}
+
+	// the second repo has the same records, which should come from the pool
+	recs2 := writeTestRepo(t, cs, 2, 5)
+	if n := countPooledRefs(t, cs, 2); n < 5 {
+		t.Fatalf("expected records of second repo to be pooled, found %d pooled refs", n)
+	}
+	if sumPoolRefs(t, cs) != countPooledRefs(t, cs, 2) {
+		t.Fatal("pool ref counts don't match block refs")
+	}
+
+	checkUserRepo(t, cs, 1, recs1)
+	checkUserRepo(t, cs, 2, recs2)
+
+	stats, err := cs.DedupStats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Blocks == 0 || stats.Ratio < 1 {
+		t.Fatalf("unexpected dedup stats: %+v", stats)
+	}
+
+	// compaction keeps pooled blocks in the pool
+	if _, err := cs.CompactUserShards(ctx, 2, false); err != nil {
+		t.Fatal(err)
+	}
+	checkUserRepo(t, cs, 2, recs2)
+	if sumPoolRefs(t, cs) != countPooledRefs(t, cs, 2) {
+		t.Fatal("pool ref counts don't match block refs after compaction")
+	}
+
+	// wiping the second repo releases every reference, and (once the grace
+	// period has passed) the pool can be compacted away
+	if err := cs.WipeUserData(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if n := sumPoolRefs(t, cs); n != 0 {
+		t.Fatalf("expected no pool refs after wipe, found %d", n)
+	}
+	var packs []dedupPack
+	if err := cs.meta.meta.Find(&packs).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := cs.meta.meta.Exec(`UPDATE dedup_blocks SET updated_at = ?`, time.Now().Add(-2*dedupGracePeriod)).Error; err != nil {
+		t.Fatal(err)
+	}
+	cs.dedup.pack = nil
+	removed, err := cs.CompactDedupPool(ctx, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != len(packs) {
+		t.Fatalf("expected %d packs removed, got %d", len(packs), removed)
+	}
+	for _, p := range packs {
+		if _, err := os.Stat(p.Path); !os.IsNotExist(err) {
+			t.Fatalf("expected pack file %s to be removed", p.Path)
+		}
+	}
+	checkUserRepo(t, cs, 1, recs1)
+}
+
+func TestDedupPoolCompactionMovesLiveBlocks(t *testing.T) {
+	ctx := context.TODO()
+	cs := testDedupCarStore(t)
+	enableTestDedup(t, cs)
+
+	writeTestRepo(t, cs, 1, 3)
+	recs2 := writeTestRepo(t, cs, 2, 3)
+
+	// start a new pack, so the one holding user 2's blocks can be compacted
+	cs.dedup.pack = nil
+	removed, err := cs.CompactDedupPool(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed == 0 {
+		t.Fatal("expected a pack to be compacted")
+	}
+	checkUserRepo(t, cs, 2, recs2)
+}
+
+func TestDedupMigration(t *testing.T) {
+	ctx := context.TODO()
+	cs := testDedupCarStore(t)
+
+	// written before dedup is enabled, so everything is inline
+	recs1 := writeTestRepo(t, cs, 1, 5)
+	recs2 := writeTestRepo(t, cs, 2, 5)
+	if _, err := cs.DedupExistingBlocks(ctx, "", 10); err == nil {
+		t.Fatal("expected migration to fail with dedup disabled")
+	}
+
+	enableTestDedup(t, cs)
+	var total DedupMigrationStats
+	cursor := ""
+	for {
+		stats, err := cs.DedupExistingBlocks(ctx, cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		total.BlocksPooled += stats.BlocksPooled
+		total.ShardsRewritten += stats.ShardsRewritten
+		if stats.Cursor == "" {
+			break
+		}
+		cursor = stats.Cursor
+	}
+	if total.BlocksPooled < 5 || total.ShardsRewritten == 0 {
+		t.Fatalf("unexpected migration stats: %+v", total)
+	}
+
+	var dups int64
+	if err := cs.meta.meta.Raw(`SELECT count(*) FROM (SELECT cid FROM block_refs WHERE block_refs.offset >= 0 GROUP BY cid HAVING count(*) > 1) AS d`).Scan(&dups).Error; err != nil {
+		t.Fatal(err)
+	}
+	if dups != 0 {
+		t.Fatalf("expected no inline duplicates after migration, found %d", dups)
+	}
+	if sumPoolRefs(t, cs) != countPooledRefs(t, cs, 1)+countPooledRefs(t, cs, 2) {
+		t.Fatal("pool ref counts don't match block refs after migration")
+	}
+
+	checkUserRepo(t, cs, 1, recs1)
+	checkUserRepo(t, cs, 2, recs2)
+}
@@ -27,6 +27,9 @@ func (cs *CarStoreGormMeta) Init() error {
 	if err := cs.meta.AutoMigrate(&staleRef{}); err != nil {
 		return err
 	}
+	if err := cs.meta.AutoMigrate(&dedupBlock{}, &dedupPack{}); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -138,6 +141,18 @@ func (cs *CarStoreGormMeta) PutShardAndRefs(ctx context.Context, shard *CarShard
 		return fmt.Errorf("failed to create block refs: %w", err)
 	}
 
+	var pooled []models.DbCID
+	for _, ref := range brefs {
+		if off, ok := ref["offset"].(int64); ok && off == pooledOffset {
+			pooled = append(pooled, ref["cid"].(models.DbCID))
+		}
+	}
+	if len(pooled) > 0 {
+		if err := acquirePooledRefs(tx, pooled); err != nil {
+			return err
+		}
+	}
+
 	if len(rmcids) > 0 {
 		cids := make([]cid.Cid, 0, len(rmcids))
 		for c := range rmcids {
@@ -167,6 +182,11 @@ func (cs *CarStoreGormMeta) DeleteShardsAndRefs(ctx context.Context, ids []uint)
 		return err
 	}
 
+	if err := releasePooledRefs(txn, ids); err != nil {
+		txn.Rollback()
+		return err
+	}
+
 	if err := txn.Delete(&blockRef{}, "shard in (?)", ids).Error; err != nil {
 		txn.Rollback()
 		return err
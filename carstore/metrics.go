@@ -16,3 +16,85 @@ var writeShardMetadataDuration = promauto.NewHistogram(prometheus.HistogramOpts{
 	Help:    "Duration of writing shard metadata to DB",
 	Buckets: prometheus.ExponentialBuckets(0.001, 2, 15),
 })
+
+var archiveCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "carstore_archive_ops",
+	Help: "Number of repo archive and restore operations, by result",
+}, []string{"op", "result"})
+
+var reposArchivedGauge = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "carstore_repos_archived",
+	Help: "Number of repos currently archived to cold storage",
+})
+
+var restoreDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "carstore_restore_duration",
+	Help:    "Duration of restoring a repo from cold storage",
+	Buckets: prometheus.ExponentialBuckets(0.01, 2, 15),
+})
+
+var restoreBudgetExceeded = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "carstore_restore_budget_exceeded",
+	Help: "Number of reads which gave up waiting on a repo restore",
+})
+
+var dedupBlocksCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "carstore_dedup_blocks",
+	Help: "Number of blocks written with dedup enabled, by whether they were stored inline or referenced from the pool",
+}, []string{"placement"})
+
+var dedupNewPooled = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "carstore_dedup_new_pooled_blocks",
+	Help: "Number of blocks added to the dedup pool on write",
+})
+
+var dedupBytesSaved = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "carstore_dedup_bytes_saved",
+	Help: "Bytes of pooled blocks which were not written inline to shard files",
+})
+
+var dedupMigratedBlocks = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "carstore_dedup_migrated_blocks",
+	Help: "Number of existing duplicated blocks moved in to the dedup pool",
+})
+
+var dedupPacksCompacted = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "carstore_dedup_packs_compacted",
+	Help: "Number of dedup pack files removed by pool compaction",
+})
+
+var dedupPoolBytes = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "carstore_dedup_pool_bytes",
+	Help: "Bytes of live blocks in the dedup pool",
+})
+
+var dedupLogicalBytes = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "carstore_dedup_logical_bytes",
+	Help: "Bytes pooled blocks would take up if every reference was stored inline; divide by carstore_dedup_pool_bytes for the dedup ratio",
+})
+
+var dedupDeadBytes = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "carstore_dedup_dead_bytes",
+	Help: "Bytes in dedup pack files which are no longer referenced",
+})
+
+var scrubReposCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "carstore_scrub_repos",
+	Help: "Number of repos checked by the integrity scrubber, by result (ok, corrupt, archived, or error)",
+}, []string{"result"})
+
+var scrubBlocksCounter = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "carstore_scrub_blocks",
+	Help: "Number of blocks re-hashed by the integrity scrubber",
+})
+
+var scrubCorruptBlocksCounter = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "carstore_scrub_corrupt_blocks",
+	Help: "Number of blocks found by the integrity scrubber not to match their CID",
+})
+
+var scrubDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "carstore_scrub_duration",
+	Help:    "Duration of scrubbing a single repo",
+	Buckets: prometheus.ExponentialBuckets(0.001, 2, 15),
+})
@@ -0,0 +1,389 @@
+package carstore
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/models"
+	"github.com/bluesky-social/indigo/repo"
+
+	blockformat "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	ipld "github.com/ipfs/go-ipld-format"
+	car "github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+)
+
+// maximum number of block CIDs (or shards) listed in each field of a scrub result
+const scrubMaxListed = 100
+
+// RepoScrubResult is the result of checking the integrity of a single repo's
+// stored data
+type RepoScrubResult struct {
+	Uid  models.Uid `json:"uid"`
+	Head string     `json:"head,omitempty"`
+	// archived repos aren't scrubbed, since that would mean restoring them
+	Archived bool  `json:"archived,omitempty"`
+	Shards   int   `json:"shards"`
+	Blocks   int   `json:"blocks"`
+	Bytes    int64 `json:"bytes"`
+	// blocks whose data doesn't match their CID
+	CorruptBlocks []string `json:"corruptBlocks,omitempty"`
+	// shard (or dedup pack) files which couldn't be read in full
+	UnreadableShards []string `json:"unreadableShards,omitempty"`
+	// blocks reachable from the head commit which aren't stored (or are corrupt)
+	MissingBlocks []string `json:"missingBlocks,omitempty"`
+	// error walking the repo tree from the head commit
+	WalkError string `json:"walkError,omitempty"`
+	// action taken for a corrupt repo, if any
+	Repair string `json:"repair,omitempty"`
+}
+
+// Corrupt reports whether any problems were found
+func (r *RepoScrubResult) Corrupt() bool {
+	return len(r.CorruptBlocks) > 0 || len(r.UnreadableShards) > 0 || len(r.MissingBlocks) > 0 || r.WalkError != ""
+}
+
+func (r *RepoScrubResult) addListed(list *[]string, v string) {
+	if len(*list) < scrubMaxListed {
+		*list = append(*list, v)
+	}
+}
+
+// ScrubRepo checks the integrity of a repo's stored data: every block in its
+// shard files (and any dedup pool blocks they reference) is re-hashed and
+// checked against its CID, and the MST is walked from the head commit to
+// check every node and record is present and intact.
+//
+// Repos being written to or compacted concurrently can look corrupt (eg, if a
+// shard file is removed mid-scrub), so corrupt results are worth confirming
+// with a second scrub.
+func (cs *FileCarStore) ScrubRepo(ctx context.Context, user models.Uid) (*RepoScrubResult, error) {
+	res := &RepoScrubResult{Uid: user}
+	if cs.IsArchived(user) {
+		res.Archived = true
+		return res, nil
+	}
+
+	shards, err := cs.meta.GetUserShards(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	res.Shards = len(shards)
+	if len(shards) == 0 {
+		return res, nil
+	}
+	head := shards[len(shards)-1].Root.CID
+	res.Head = head.String()
+
+	intact := make(map[cid.Cid]bool)
+	check := func(k cid.Cid, data []byte) {
+		res.Blocks++
+		res.Bytes += int64(len(data))
+		sum, err := k.Prefix().Sum(data)
+		if err != nil || !sum.Equals(k) {
+			res.addListed(&res.CorruptBlocks, k.String())
+			return
+		}
+		intact[k] = true
+	}
+
+	shardIds := make([]uint, len(shards))
+	for i := range shards {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		shardIds[i] = shards[i].ID
+		if err := scrubShardFile(shards[i].Path, check); err != nil {
+			res.addListed(&res.UnreadableShards, fmt.Sprintf("%s: %s", shards[i].Path, err))
+		}
+	}
+
+	pooled, err := cs.pooledBlocksForShards(ctx, shardIds)
+	if err != nil {
+		return nil, err
+	}
+	for _, locs := range pooled {
+		for _, loc := range locs {
+			k, data, err := readNodeAt(loc.Path, loc.Offset)
+			if err != nil {
+				res.addListed(&res.UnreadableShards, fmt.Sprintf("%s: %s", loc.Path, err))
+				continue
+			}
+			if k != loc.Cid.CID {
+				res.addListed(&res.CorruptBlocks, loc.Cid.CID.String())
+				continue
+			}
+			check(k, data)
+		}
+	}
+
+	ds, err := cs.ReadOnlySession(user)
+	if err != nil {
+		return nil, err
+	}
+	ss := &scrubStore{Blockstore: ds, intact: intact, res: res}
+	r, err := repo.OpenRepo(ctx, ss, head)
+	if err != nil {
+		res.WalkError = err.Error()
+		return res, nil
+	}
+	if err := r.ForEach(ctx, "", func(k string, v cid.Cid) error {
+		if !intact[v] {
+			res.addListed(&res.MissingBlocks, v.String())
+		}
+		return ctx.Err()
+	}); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		res.WalkError = err.Error()
+	}
+	return res, nil
+}
+
+// reads every block of a shard file, passing each to cb. unlike a CarReader,
+// this doesn't stop at blocks which fail to verify
+func scrubShardFile(path string, cb func(k cid.Cid, data []byte)) error {
+	fi, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fi.Close()
+
+	br := bufio.NewReader(fi)
+	if _, err := car.ReadHeader(br); err != nil {
+		return err
+	}
+	for {
+		k, data, err := carutil.ReadNode(br)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		cb(k, data)
+	}
+}
+
+func readNodeAt(path string, offset int64) (cid.Cid, []byte, error) {
+	fi, err := os.Open(path)
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	defer fi.Close()
+	if _, err := fi.Seek(offset, io.SeekStart); err != nil {
+		return cid.Undef, nil, err
+	}
+	return carutil.ReadNode(bufio.NewReader(fi))
+}
+
+// scrubStore serves only blocks which were found intact, recording any others
+// requested while walking the repo as missing
+type scrubStore struct {
+	blockstore.Blockstore
+	intact map[cid.Cid]bool
+	res    *RepoScrubResult
+}
+
+func (ss *scrubStore) Get(ctx context.Context, k cid.Cid) (blockformat.Block, error) {
+	if !ss.intact[k] {
+		ss.res.addListed(&ss.res.MissingBlocks, k.String())
+		return nil, ipld.ErrNotFound{Cid: k}
+	}
+	return ss.Blockstore.Get(ctx, k)
+}
+
+func (ss *scrubStore) Has(ctx context.Context, k cid.Cid) (bool, error) {
+	return ss.intact[k], nil
+}
+
+// ScrubReport summarizes the work of a Scrubber since it started
+type ScrubReport struct {
+	StartedAt     time.Time  `json:"startedAt"`
+	LastBatchAt   *time.Time `json:"lastBatchAt,omitempty"`
+	ReposScrubbed int        `json:"reposScrubbed"`
+	ReposArchived int        `json:"reposArchived"`
+	ReposCorrupt  int        `json:"reposCorrupt"`
+	Errors        int        `json:"errors"`
+	BlocksChecked int64      `json:"blocksChecked"`
+	BytesChecked  int64      `json:"bytesChecked"`
+	// most recently found corrupt repos, oldest first
+	Corrupt []ScrubbedRepo `json:"corrupt"`
+}
+
+type ScrubbedRepo struct {
+	At time.Time `json:"at"`
+	*RepoScrubResult
+}
+
+// Scrubber checks the integrity of a random sample of repos (see ScrubRepo)
+// every interval, reporting corruption in metrics, logs, and its report.
+type Scrubber struct {
+	cs *FileCarStore
+
+	// Time between batches of repos
+	Interval time.Duration
+	// Number of repos sampled per batch
+	BatchSize int
+	// Maximum number of corrupt repos kept in the report
+	MaxReported int
+	// Optional function called for each repo found corrupt (on two scrubs in a
+	// row). Returns the repair action taken, if any, which is recorded in the
+	// result (eg, "resync")
+	OnCorrupt func(ctx context.Context, res *RepoScrubResult) string
+	Logger    *slog.Logger
+
+	lk     sync.Mutex
+	report ScrubReport
+}
+
+func NewScrubber(cs *FileCarStore) *Scrubber {
+	return &Scrubber{
+		cs:          cs,
+		Interval:    time.Minute,
+		BatchSize:   10,
+		MaxReported: 1000,
+		Logger:      cs.log.With("job", "scrub"),
+		report:      ScrubReport{StartedAt: time.Now(), Corrupt: []ScrubbedRepo{}},
+	}
+}
+
+// Run scrubs a batch of sampled repos every interval, until the context is
+// cancelled
+func (s *Scrubber) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		if err := s.ScrubBatch(ctx); err != nil && ctx.Err() == nil {
+			s.Logger.Error("failed to scrub repos", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ScrubBatch scrubs a batch of repos, starting from a random point in the
+// carstore
+func (s *Scrubber) ScrubBatch(ctx context.Context) error {
+	users, err := s.sample(ctx)
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		if _, err := s.Scrub(ctx, u); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			s.Logger.Error("failed to scrub repo", "uid", u, "err", err)
+		}
+	}
+	now := time.Now()
+	s.lk.Lock()
+	s.report.LastBatchAt = &now
+	s.lk.Unlock()
+	return nil
+}
+
+// picks BatchSize repos with shards, following on from a random uid
+func (s *Scrubber) sample(ctx context.Context) ([]models.Uid, error) {
+	var maxUid models.Uid
+	if err := s.cs.meta.meta.WithContext(ctx).Model(CarShard{}).Select("coalesce(max(usr), 0)").Scan(&maxUid).Error; err != nil {
+		return nil, err
+	}
+	if maxUid == 0 {
+		return nil, nil
+	}
+	after := models.Uid(rand.Int63n(int64(maxUid)))
+	users, err := s.cs.ShardUsers(ctx, after, s.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+	// wrap around to the start
+	if len(users) < s.BatchSize && after > 0 {
+		more, err := s.cs.ShardUsers(ctx, 0, s.BatchSize-len(users))
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range more {
+			if u > after {
+				break
+			}
+			users = append(users, u)
+		}
+	}
+	return users, nil
+}
+
+// Scrub scrubs a single repo, re-checking it if it looks corrupt, and records
+// the result in the report
+func (s *Scrubber) Scrub(ctx context.Context, user models.Uid) (*RepoScrubResult, error) {
+	start := time.Now()
+	res, err := s.cs.ScrubRepo(ctx, user)
+	if err == nil && res.Corrupt() {
+		// confirm, in case the repo changed under us
+		res, err = s.cs.ScrubRepo(ctx, user)
+	}
+	scrubDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		scrubReposCounter.WithLabelValues("error").Inc()
+		s.lk.Lock()
+		s.report.Errors++
+		s.lk.Unlock()
+		return nil, err
+	}
+
+	scrubBlocksCounter.Add(float64(res.Blocks))
+	switch {
+	case res.Archived:
+		scrubReposCounter.WithLabelValues("archived").Inc()
+	case res.Corrupt():
+		scrubReposCounter.WithLabelValues("corrupt").Inc()
+		scrubCorruptBlocksCounter.Add(float64(len(res.CorruptBlocks)))
+		s.Logger.Warn("repo data is corrupt", "uid", user, "head", res.Head, "corruptBlocks", len(res.CorruptBlocks), "unreadableShards", len(res.UnreadableShards), "missingBlocks", len(res.MissingBlocks), "walkError", res.WalkError)
+		if s.OnCorrupt != nil {
+			res.Repair = s.OnCorrupt(ctx, res)
+		}
+	default:
+		scrubReposCounter.WithLabelValues("ok").Inc()
+	}
+
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if res.Archived {
+		s.report.ReposArchived++
+		return res, nil
+	}
+	s.report.ReposScrubbed++
+	s.report.BlocksChecked += int64(res.Blocks)
+	s.report.BytesChecked += res.Bytes
+	if res.Corrupt() {
+		s.report.ReposCorrupt++
+		s.report.Corrupt = append(s.report.Corrupt, ScrubbedRepo{At: time.Now(), RepoScrubResult: res})
+		if over := len(s.report.Corrupt) - s.MaxReported; over > 0 {
+			s.report.Corrupt = append([]ScrubbedRepo(nil), s.report.Corrupt[over:]...)
+		}
+	}
+	return res, nil
+}
+
+// Report returns a copy of the scrubber's report
+func (s *Scrubber) Report() *ScrubReport {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	out := s.report
+	out.Corrupt = append([]ScrubbedRepo(nil), s.report.Corrupt...)
+	return &out
+}
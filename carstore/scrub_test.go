@@ -0,0 +1,81 @@
+package carstore
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/bluesky-social/indigo/models"
+)
+
+func TestScrubRepo(t *testing.T) {
+	ctx := context.TODO()
+	cs := testDedupCarStore(t)
+
+	writeTestRepo(t, cs, 1, 3)
+	writeTestRepo(t, cs, 2, 3)
+
+	res, err := cs.ScrubRepo(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Corrupt() || res.Blocks == 0 || res.Head == "" {
+		t.Fatalf("expected intact repo: %+v", res)
+	}
+
+	// flip the last byte of the newest shard, which is in the data of its last block
+	shards, err := cs.meta.GetUserShards(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := shards[len(shards)-1].Path
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err := os.WriteFile(path, data, 0664); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err = cs.ScrubRepo(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Corrupt() || len(res.CorruptBlocks) != 1 {
+		t.Fatalf("expected corrupt block to be found: %+v", res)
+	}
+
+	// a missing shard file leaves blocks unreachable
+	if err := os.Remove(shards[0].Path); err != nil {
+		t.Fatal(err)
+	}
+	res, err = cs.ScrubRepo(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.UnreadableShards) != 1 || (len(res.MissingBlocks) == 0 && res.WalkError == "") {
+		t.Fatalf("expected missing shard to be reported: %+v", res)
+	}
+
+	var repaired []models.Uid
+	sc := NewScrubber(cs)
+	sc.BatchSize = 5
+	sc.OnCorrupt = func(ctx context.Context, res *RepoScrubResult) string {
+		repaired = append(repaired, res.Uid)
+		return "resync"
+	}
+	if err := sc.ScrubBatch(ctx); err != nil {
+		t.Fatal(err)
+	}
+	rep := sc.Report()
+	if rep.ReposScrubbed != 2 || rep.ReposCorrupt != 1 || rep.LastBatchAt == nil {
+		t.Fatalf("unexpected report: %+v", rep)
+	}
+	if len(rep.Corrupt) != 1 || rep.Corrupt[0].Uid != 1 || rep.Corrupt[0].Repair != "resync" {
+		t.Fatalf("unexpected corrupt repos: %+v", rep.Corrupt)
+	}
+	if len(repaired) != 1 || repaired[0] != 1 {
+		t.Fatalf("expected corrupt repo to be repaired: %v", repaired)
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"time"
 
 	"github.com/bluesky-social/indigo/api"
+	"github.com/bluesky-social/indigo/atproto/crypto"
 	libbgs "github.com/bluesky-social/indigo/bgs"
 	"github.com/bluesky-social/indigo/carstore"
 	"github.com/bluesky-social/indigo/did"
@@ -201,6 +202,58 @@ func run(args []string) error {
 			EnvVars: []string{"RELAY_EVENT_PLAYBACK_TTL"},
 			Value:   72 * time.Hour,
 		},
+		&cli.DurationFlag{
+			Name:    "event-playback-identity-ttl",
+			Usage:   "time to live for playback of identity and account events, if different from --event-playback-ttl (only applies to disk persister)",
+			EnvVars: []string{"RELAY_EVENT_PLAYBACK_IDENTITY_TTL"},
+		},
+		&cli.IntFlag{
+			Name:    "pds-breaker-failures",
+			Usage:   "consecutive failed requests to a PDS (network errors or 5xx responses) after which further requests to it are skipped for a while (0 to disable)",
+			EnvVars: []string{"RELAY_PDS_BREAKER_FAILURES"},
+		},
+		&cli.DurationFlag{
+			Name:    "pds-breaker-timeout",
+			Usage:   "how long requests to a PDS are skipped for after too many failures, before a probe request is allowed",
+			EnvVars: []string{"RELAY_PDS_BREAKER_TIMEOUT"},
+			Value:   30 * time.Second,
+		},
+		&cli.IntFlag{
+			Name:    "quarantine-threshold",
+			Usage:   "number of invalid events from a single PDS within the quarantine window which will quarantine that host (0 to disable)",
+			EnvVars: []string{"RELAY_QUARANTINE_THRESHOLD"},
+			Value:   0,
+		},
+		&cli.DurationFlag{
+			Name:    "quarantine-window",
+			Usage:   "time window over which invalid events are counted towards quarantine",
+			EnvVars: []string{"RELAY_QUARANTINE_WINDOW"},
+			Value:   10 * time.Minute,
+		},
+		&cli.IntFlag{
+			Name:    "resync-workers",
+			Usage:   "number of repos which may be re-fetched concurrently after drifting from upstream",
+			EnvVars: []string{"RELAY_RESYNC_WORKERS"},
+			Value:   4,
+		},
+		&cli.IntFlag{
+			Name:    "resync-per-host-limit",
+			Usage:   "number of repos from a single PDS which may be re-fetched concurrently",
+			EnvVars: []string{"RELAY_RESYNC_PER_HOST_LIMIT"},
+			Value:   2,
+		},
+		&cli.DurationFlag{
+			Name:    "drift-sample-interval",
+			Usage:   "how often to check a random sample of repos against upstream for drift (0 to disable)",
+			EnvVars: []string{"RELAY_DRIFT_SAMPLE_INTERVAL"},
+			Value:   0,
+		},
+		&cli.IntFlag{
+			Name:    "drift-sample-size",
+			Usage:   "number of repos checked per drift sample",
+			EnvVars: []string{"RELAY_DRIFT_SAMPLE_SIZE"},
+			Value:   100,
+		},
 		&cli.IntFlag{
 			Name:    "num-compaction-workers",
 			EnvVars: []string{"RELAY_NUM_COMPACTION_WORKERS"},
@@ -211,11 +264,125 @@ func run(args []string) error {
 			Usage:   "specify list of shard directories for carstore storage, overrides default storage within datadir",
 			EnvVars: []string{"RELAY_CARSTORE_SHARD_DIRS"},
 		},
+		&cli.StringFlag{
+			Name:    "carstore-cold-dir",
+			Usage:   "directory (eg, a mounted bucket) to archive dormant repos to; archiving is disabled if not set",
+			EnvVars: []string{"RELAY_CARSTORE_COLD_DIR"},
+		},
+		&cli.DurationFlag{
+			Name:    "carstore-restore-budget",
+			Usage:   "how long a read of an archived repo waits on restoring it from cold storage before failing (0 to wait indefinitely)",
+			EnvVars: []string{"RELAY_CARSTORE_RESTORE_BUDGET"},
+			Value:   5 * time.Second,
+		},
+		&cli.BoolFlag{
+			Name:    "carstore-dedup",
+			Usage:   "store blocks which appear in more than one repo once, in a shared pool",
+			EnvVars: []string{"RELAY_CARSTORE_DEDUP"},
+		},
+		&cli.IntFlag{
+			Name:    "carstore-dedup-min-block-size",
+			Usage:   "smallest block (in bytes) to deduplicate; smaller blocks are always stored inline",
+			EnvVars: []string{"RELAY_CARSTORE_DEDUP_MIN_BLOCK_SIZE"},
+			Value:   carstore.DefaultDedupOptions().MinBlockSize,
+		},
+		&cli.BoolFlag{
+			Name:    "carstore-dedup-migrate",
+			Usage:   "on startup, move blocks already duplicated across repos in to the dedup pool (requires --carstore-dedup)",
+			EnvVars: []string{"RELAY_CARSTORE_DEDUP_MIGRATE"},
+		},
 		&cli.StringSliceFlag{
 			Name:    "next-crawler",
 			Usage:   "forward POST requestCrawl to this url, should be machine root url and not xrpc/requestCrawl, comma separated list",
 			EnvVars: []string{"RELAY_NEXT_CRAWLER"},
 		},
+		&cli.StringFlag{
+			Name:    "mirror-upstream",
+			Usage:   "base URL (eg, wss://relay2.example.com) of a downstream relay to forward our output event stream to",
+			EnvVars: []string{"RELAY_MIRROR_UPSTREAM"},
+		},
+		&cli.StringFlag{
+			Name:    "mirror-upstream-token",
+			Usage:   "admin token for the mirror-upstream relay",
+			EnvVars: []string{"RELAY_MIRROR_UPSTREAM_TOKEN"},
+		},
+		&cli.StringFlag{
+			Name:    "attestation-key",
+			Usage:   "private key (multibase) to sign every output event stream frame with, so consumers can verify stream integrity",
+			EnvVars: []string{"RELAY_ATTESTATION_KEY"},
+		},
+		&cli.BoolFlag{
+			Name:    "collection-stats",
+			Usage:   "tally incoming repo ops by collection, PDS host, and op type (metrics, and report at /admin/firehose/collections); also publishes per-collection stats at /stats/lexicons",
+			EnvVars: []string{"RELAY_COLLECTION_STATS"},
+		},
+		&cli.DurationFlag{
+			Name:    "collection-stats-window",
+			Usage:   "length of the rolling window kept for collection stats reports",
+			Value:   time.Hour,
+			EnvVars: []string{"RELAY_COLLECTION_STATS_WINDOW"},
+		},
+		&cli.DurationFlag{
+			Name:    "event-age-sla",
+			Usage:   "alert when the p99 age of events arriving from PDSs (time since creation) exceeds this, per minute; zero disables alerting",
+			EnvVars: []string{"RELAY_EVENT_AGE_SLA"},
+		},
+		&cli.StringFlag{
+			Name:    "event-age-alert-webhook",
+			Usage:   "URL to post event age SLA alerts to (eg, a Slack incoming webhook)",
+			EnvVars: []string{"RELAY_EVENT_AGE_ALERT_WEBHOOK"},
+		},
+		&cli.StringFlag{
+			Name:    "subscription-token-key",
+			Usage:   "secret for signing and verifying firehose subscription tokens (issued at /admin/subs/issueToken)",
+			EnvVars: []string{"RELAY_SUBSCRIPTION_TOKEN_KEY"},
+		},
+		&cli.BoolFlag{
+			Name:    "require-subscription-token",
+			Usage:   "reject firehose consumers which don't present a valid subscription token",
+			EnvVars: []string{"RELAY_REQUIRE_SUBSCRIPTION_TOKEN"},
+		},
+		&cli.BoolFlag{
+			Name:    "consistency-check",
+			Usage:   "on startup, check the relay DB, carstore, and event log against each other in the background (report at /admin/consistency/report)",
+			EnvVars: []string{"RELAY_CONSISTENCY_CHECK"},
+		},
+		&cli.BoolFlag{
+			Name:    "consistency-check-repair",
+			Usage:   "repair problems found by the startup consistency check: wipe orphan shards, and resync repos with missing data",
+			EnvVars: []string{"RELAY_CONSISTENCY_CHECK_REPAIR"},
+		},
+		&cli.BoolFlag{
+			Name:    "consistency-check-shard-files",
+			Usage:   "have the startup consistency check stat every shard file on disk (slow for large carstores)",
+			EnvVars: []string{"RELAY_CONSISTENCY_CHECK_SHARD_FILES"},
+		},
+		&cli.BoolFlag{
+			Name:    "consistency-check-events",
+			Usage:   "have the startup consistency check play back persisted events and look for sequence gaps",
+			EnvVars: []string{"RELAY_CONSISTENCY_CHECK_EVENTS"},
+		},
+		&cli.DurationFlag{
+			Name:    "scrub-interval",
+			Usage:   "if non-zero, re-hash the stored blocks of a sample of repos every interval, and check their MSTs are complete (report at /admin/scrub/report)",
+			EnvVars: []string{"RELAY_SCRUB_INTERVAL"},
+		},
+		&cli.IntFlag{
+			Name:    "scrub-batch-size",
+			Usage:   "number of repos sampled by the integrity scrubber each interval",
+			Value:   10,
+			EnvVars: []string{"RELAY_SCRUB_BATCH_SIZE"},
+		},
+		&cli.BoolFlag{
+			Name:    "scrub-repair",
+			Usage:   "wipe repos found corrupt by the integrity scrubber, and queue them for resync from their PDS",
+			EnvVars: []string{"RELAY_SCRUB_REPAIR"},
+		},
+		&cli.DurationFlag{
+			Name:    "host-health-interval",
+			Usage:   "if non-zero, probe registered PDS hosts for health this often (report at /admin/pds/health); resyncs from unhealthy hosts are deprioritized",
+			EnvVars: []string{"RELAY_HOST_HEALTH_INTERVAL"},
+		},
 	}
 
 	app.Action = runBigsky
@@ -350,6 +517,24 @@ func runBigsky(cctx *cli.Context) error {
 		return err
 	}
 
+	if colddir := cctx.String("carstore-cold-dir"); colddir != "" {
+		if err := cstore.(*carstore.FileCarStore).SetColdStore(&carstore.DirColdStore{Dir: colddir}, cctx.Duration("carstore-restore-budget")); err != nil {
+			return err
+		}
+	}
+
+	if cctx.Bool("carstore-dedup") {
+		fcs := cstore.(*carstore.FileCarStore)
+		opts := carstore.DefaultDedupOptions()
+		opts.MinBlockSize = cctx.Int("carstore-dedup-min-block-size")
+		if err := fcs.SetDedup(opts); err != nil {
+			return err
+		}
+		go fcs.RunDedupMaintenance(context.Background(), 10*time.Minute, cctx.Bool("carstore-dedup-migrate"))
+	} else if cctx.Bool("carstore-dedup-migrate") {
+		return fmt.Errorf("--carstore-dedup-migrate requires --carstore-dedup")
+	}
+
 	// DID RESOLUTION
 	// 1. the outside world, PLCSerever or Web
 	// 2. (maybe memcached)
@@ -389,6 +574,12 @@ func runBigsky(cctx *cli.Context) error {
 
 		pOpts := events.DefaultDiskPersistOptions()
 		pOpts.Retention = cctx.Duration("event-playback-ttl")
+		if ttl := cctx.Duration("event-playback-identity-ttl"); ttl > 0 {
+			pOpts.RetentionByType = make(map[string]time.Duration, len(events.IdentityEventTypes))
+			for _, t := range events.IdentityEventTypes {
+				pOpts.RetentionByType[t] = ttl
+			}
+		}
 		dp, err := events.NewDiskPersistence(dpd, "", db, pOpts)
 		if err != nil {
 			return fmt.Errorf("setting up disk persister: %w", err)
@@ -414,8 +605,20 @@ func runBigsky(cctx *cli.Context) error {
 	}
 	defer ix.Shutdown()
 
+	var pdsBreakers *xrpc.Breakers
+	if n := cctx.Int("pds-breaker-failures"); n > 0 {
+		pdsBreakers = xrpc.NewBreakers(xrpc.BreakerConfig{
+			FailureThreshold: n,
+			OpenTimeout:      cctx.Duration("pds-breaker-timeout"),
+			OnStateChange: func(host string, from, to xrpc.BreakerState) {
+				slog.Info("PDS circuit breaker state changed", "host", host, "from", from, "to", to)
+			},
+		})
+	}
+
 	rlskip := cctx.String("bsky-social-rate-limit-skip")
 	ix.ApplyPDSClientSettings = func(c *xrpc.Client) {
+		c.Breakers = pdsBreakers
 		if c.Client == nil {
 			c.Client = util.RobustHTTPClient()
 		}
@@ -467,6 +670,12 @@ func runBigsky(cctx *cli.Context) error {
 	bgsConfig.MaxQueuePerPDS = cctx.Int64("max-queue-per-pds")
 	bgsConfig.DefaultRepoLimit = cctx.Int64("default-repo-limit")
 	bgsConfig.NumCompactionWorkers = cctx.Int("num-compaction-workers")
+	bgsConfig.QuarantineThreshold = cctx.Int("quarantine-threshold")
+	bgsConfig.QuarantineWindow = cctx.Duration("quarantine-window")
+	bgsConfig.ResyncWorkers = cctx.Int("resync-workers")
+	bgsConfig.ResyncPerHostLimit = cctx.Int("resync-per-host-limit")
+	bgsConfig.DriftSampleInterval = cctx.Duration("drift-sample-interval")
+	bgsConfig.DriftSampleSize = cctx.Int("drift-sample-size")
 	nextCrawlers := cctx.StringSlice("next-crawler")
 	if len(nextCrawlers) != 0 {
 		nextCrawlerUrls := make([]*url.URL, len(nextCrawlers))
@@ -480,6 +689,40 @@ func runBigsky(cctx *cli.Context) error {
 		}
 		bgsConfig.NextCrawlers = nextCrawlerUrls
 	}
+	if mu := cctx.String("mirror-upstream"); mu != "" {
+		if cctx.String("mirror-upstream-token") == "" {
+			return fmt.Errorf("mirror-upstream-token is required when mirror-upstream is set")
+		}
+		slog.Info("mirroring event stream to downstream relay", "url", mu)
+		bgsConfig.MirrorUpstream = mu
+		bgsConfig.MirrorUpstreamToken = cctx.String("mirror-upstream-token")
+	}
+	if ak := cctx.String("attestation-key"); ak != "" {
+		key, err := crypto.ParsePrivateMultibase(ak)
+		if err != nil {
+			return fmt.Errorf("failed to parse attestation-key: %w", err)
+		}
+		bgsConfig.AttestationKey = key
+	}
+	bgsConfig.CollectionStats = cctx.Bool("collection-stats")
+	bgsConfig.CollectionStatsWindow = cctx.Duration("collection-stats-window")
+	bgsConfig.EventAgeSLA = cctx.Duration("event-age-sla")
+	bgsConfig.EventAgeAlertWebhook = cctx.String("event-age-alert-webhook")
+	if key := cctx.String("subscription-token-key"); key != "" {
+		bgsConfig.SubscriptionTokenKey = []byte(key)
+	}
+	bgsConfig.RequireSubscriptionToken = cctx.Bool("require-subscription-token")
+	if cctx.Bool("consistency-check") {
+		ccOpts := libbgs.DefaultConsistencyCheckOptions()
+		ccOpts.Repair = cctx.Bool("consistency-check-repair")
+		ccOpts.CheckShardFiles = cctx.Bool("consistency-check-shard-files")
+		ccOpts.CheckEvents = cctx.Bool("consistency-check-events")
+		bgsConfig.ConsistencyCheck = ccOpts
+	}
+	bgsConfig.ScrubInterval = cctx.Duration("scrub-interval")
+	bgsConfig.ScrubBatchSize = cctx.Int("scrub-batch-size")
+	bgsConfig.ScrubRepair = cctx.Bool("scrub-repair")
+	bgsConfig.HostHealthInterval = cctx.Duration("host-health-interval")
 	bgs, err := libbgs.NewBGS(db, ix, repoman, evtman, cachedidr, rf, hr, bgsConfig)
 	if err != nil {
 		return err
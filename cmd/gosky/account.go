@@ -23,6 +23,7 @@ var accountCmd = &cli.Command{
 		resetPasswordCmd,
 		requestAccountDeletionCmd,
 		deleteAccountCmd,
+		accountDoctorCmd,
 	},
 }
 
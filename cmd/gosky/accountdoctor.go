@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/identity/pdshealth"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	"github.com/ipld/go-car"
+	cli "github.com/urfave/cli/v2"
+)
+
+var accountDoctorCmd = &cli.Command{
+	Name:      "doctor",
+	Usage:     "check an account's identity, PDS, and relay for problems, and suggest fixes",
+	ArgsUsage: `<handle-or-did>`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "relay-host",
+			Usage:   "method, hostname, and port of Relay instance to check the repo against",
+			Value:   "https://bsky.network",
+			EnvVars: []string{"ATP_RELAY_HOST"},
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := cctx.Context
+		args, err := needArgs(cctx, "handle-or-did")
+		if err != nil {
+			return err
+		}
+		atid, err := syntax.ParseAtIdentifier(args[0])
+		if err != nil {
+			return err
+		}
+
+		d := newAccountDoctor(cctx.String("plc"), cctx.String("relay-host"))
+		d.run(ctx, *atid)
+
+		problems := 0
+		for _, f := range d.findings {
+			switch f.level {
+			case doctorOK:
+				fmt.Println("OK: " + f.msg)
+			case doctorWarning:
+				fmt.Println("WARNING: " + f.msg)
+			case doctorProblem:
+				fmt.Println("PROBLEM: " + f.msg)
+				problems++
+			}
+			if f.fix != "" {
+				fmt.Println("    fix: " + f.fix)
+			}
+		}
+		if problems > 0 {
+			return cli.Exit(fmt.Sprintf("account has %d problems", problems), 1)
+		}
+		return nil
+	},
+}
+
+type doctorLevel int
+
+const (
+	doctorOK doctorLevel = iota
+	doctorWarning
+	doctorProblem
+)
+
+type doctorFinding struct {
+	level doctorLevel
+	msg   string
+	// what to do about it, for warnings and problems
+	fix string
+}
+
+// accountDoctor runs the checks for the doctor command, collecting findings.
+// Checks which depend on an earlier one that failed are skipped.
+type accountDoctor struct {
+	// uncached, so results reflect any changes just made
+	dir    *identity.BaseDirectory
+	prober *pdshealth.Prober
+	relay  *xrpc.Client
+
+	findings []doctorFinding
+}
+
+func newAccountDoctor(plcHost, relayHost string) *accountDoctor {
+	client := &http.Client{Timeout: 15 * time.Second}
+	return &accountDoctor{
+		dir: &identity.BaseDirectory{
+			PLCURL:              strings.TrimSuffix(plcHost, "/"),
+			HTTPClient:          *client,
+			TryAuthoritativeDNS: true,
+		},
+		prober: pdshealth.NewProber(),
+		relay:  &xrpc.Client{Host: strings.TrimSuffix(relayHost, "/"), Client: client},
+	}
+}
+
+func (d *accountDoctor) ok(format string, args ...any) {
+	d.findings = append(d.findings, doctorFinding{level: doctorOK, msg: fmt.Sprintf(format, args...)})
+}
+
+func (d *accountDoctor) warn(fix, format string, args ...any) {
+	d.findings = append(d.findings, doctorFinding{level: doctorWarning, msg: fmt.Sprintf(format, args...), fix: fix})
+}
+
+func (d *accountDoctor) problem(fix, format string, args ...any) {
+	d.findings = append(d.findings, doctorFinding{level: doctorProblem, msg: fmt.Sprintf(format, args...), fix: fix})
+}
+
+func (d *accountDoctor) run(ctx context.Context, atid syntax.AtIdentifier) {
+	ident := d.checkIdentity(ctx, atid)
+	if ident == nil {
+		return
+	}
+	pds := d.checkDocument(ident)
+	if pds == "" {
+		return
+	}
+	head := d.checkPDS(ctx, ident, pds)
+	d.checkRelay(ctx, ident.DID, head)
+}
+
+func handleFix(h syntax.Handle, did syntax.DID) string {
+	return fmt.Sprintf("publish the DID as a DNS TXT record at _atproto.%s (\"did=%s\"), or serve it at https://%s/.well-known/atproto-did", h, did, h)
+}
+
+// checkIdentity resolves the account, and verifies the handle and DID point
+// at each other. It returns nil if the DID document can't be resolved.
+func (d *accountDoctor) checkIdentity(ctx context.Context, atid syntax.AtIdentifier) *identity.Identity {
+	var did syntax.DID
+	var handle syntax.Handle
+	if atid.IsHandle() {
+		handle, _ = atid.AsHandle()
+		handle = handle.Normalize()
+		resolved, err := d.dir.ResolveHandle(ctx, handle)
+		if err != nil {
+			d.problem(handleFix(handle, "<did>"), "handle %s doesn't resolve: %v", handle, err)
+			return nil
+		}
+		did = resolved
+		d.checkHandleMethods(ctx, handle)
+	} else {
+		did, _ = atid.AsDID()
+	}
+
+	doc, err := d.dir.ResolveDID(ctx, did)
+	if err != nil {
+		fix := ""
+		switch did.Method() {
+		case "plc":
+			fix = fmt.Sprintf("check the DID exists in the PLC directory (gosky did history %s)", did)
+		case "web":
+			fix = fmt.Sprintf("serve the DID document at https://%s/.well-known/did.json", did.Identifier())
+		}
+		d.problem(fix, "DID %s doesn't resolve: %v", did, err)
+		return nil
+	}
+	ident := identity.ParseIdentity(doc)
+	if ident.DID != did {
+		d.problem("the DID document's id must be the DID it is published for", "DID document for %s has id %q", did, doc.DID)
+		return nil
+	}
+	d.ok("DID %s resolves", did)
+
+	updateFix := "update the handle through the account's PDS (gosky handle update), which updates the DID document"
+	declared, err := ident.DeclaredHandle()
+	if err != nil {
+		d.problem(updateFix, "DID document declares no valid handle: %v", err)
+		return &ident
+	}
+	declared = declared.Normalize()
+	if handle != "" && declared != handle {
+		d.problem(updateFix, "handle %s resolves to %s, but its DID document declares handle %s", handle, did, declared)
+	}
+	if declared != handle {
+		resolved, err := d.dir.ResolveHandle(ctx, declared)
+		if err != nil {
+			d.problem(handleFix(declared, did), "declared handle %s doesn't resolve: %v", declared, err)
+			return &ident
+		}
+		if resolved != did {
+			d.problem(handleFix(declared, did)+", or update the handle", "declared handle %s resolves to a different DID, %s", declared, resolved)
+			return &ident
+		}
+		d.checkHandleMethods(ctx, declared)
+	}
+	d.ok("handle %s and DID %s verified in both directions", declared, did)
+	ident.Handle = declared
+	return &ident
+}
+
+// checkHandleMethods warns if the DNS and HTTPS resolution methods disagree
+// about a handle, which resolves differently depending on which the resolver
+// tries first
+func (d *accountDoctor) checkHandleMethods(ctx context.Context, h syntax.Handle) {
+	dnsDID, dnsErr := d.dir.ResolveHandleDNS(ctx, h)
+	httpDID, httpErr := d.dir.ResolveHandleWellKnown(ctx, h)
+	if dnsErr == nil && httpErr == nil && dnsDID != httpDID {
+		d.warn("remove the stale record, or make both the same", "handle %s resolves to %s over DNS, but %s over HTTPS", h, dnsDID, httpDID)
+	}
+}
+
+// checkDocument checks the signing key and PDS service in the DID document,
+// returning the PDS endpoint (if there is a usable one)
+func (d *accountDoctor) checkDocument(ident *identity.Identity) string {
+	keyFix := "the PDS should publish its signing key for the account in the DID document (for did:plc, with a PLC operation signed by a rotation key)"
+	if k, ok := ident.Keys["atproto"]; !ok {
+		d.problem(keyFix, "DID document has no atproto signing key")
+	} else if _, err := ident.PublicKey(); err != nil {
+		d.problem(keyFix, "DID document's atproto signing key is invalid: %v", err)
+	} else if k.Type != "Multikey" {
+		d.warn("republish the key as a Multikey", "DID document's atproto signing key has legacy type %s", k.Type)
+	} else {
+		d.ok("DID document has a valid atproto signing key")
+	}
+
+	svcFix := "publish the account's PDS in the DID document as service #atproto_pds, of type AtprotoPersonalDataServer, with the PDS's base URL (eg, https://pds.example.com)"
+	svc, ok := ident.Services["atproto_pds"]
+	if !ok || svc.URL == "" {
+		d.problem(svcFix, "DID document has no PDS service")
+		return ""
+	}
+	u, err := url.Parse(svc.URL)
+	if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+		d.problem(svcFix, "DID document's PDS endpoint %q isn't a valid URL", svc.URL)
+		return ""
+	}
+	if svc.Type != "AtprotoPersonalDataServer" {
+		d.warn(svcFix, "DID document's PDS service has type %q", svc.Type)
+	}
+	if u.Scheme == "http" && !isLocalHost(u.Hostname()) {
+		d.warn(svcFix, "DID document's PDS endpoint %s isn't HTTPS", svc.URL)
+	}
+	if strings.Trim(u.Path, "/") != "" || u.RawQuery != "" {
+		d.warn(svcFix, "DID document's PDS endpoint %s has a path; clients will ignore or mishandle it", svc.URL)
+	}
+	d.ok("DID document's PDS is %s", svc.URL)
+	return strings.TrimSuffix(svc.URL, "/")
+}
+
+func isLocalHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && (ip.IsLoopback() || ip.IsPrivate())
+}
+
+// checkPDS checks the PDS is reachable and hosts the account, and that its
+// head commit is signed with the key in the DID document. It returns the
+// head commit, if any.
+func (d *accountDoctor) checkPDS(ctx context.Context, ident *identity.Identity, pds string) *comatproto.SyncGetLatestCommit_Output {
+	probe := d.prober.Probe(ctx, pds)
+	switch {
+	case !probe.Reachable:
+		d.problem("check the PDS is running, and that DNS and firewalls let clients reach it", "PDS %s is unreachable: %v", pds, probe.Err)
+		return nil
+	case !probe.TLSValid:
+		d.problem("renew or fix the PDS's TLS certificate", "PDS %s has an invalid TLS certificate: %v", pds, probe.Err)
+		return nil
+	case probe.Err != nil:
+		d.warn("check the PDS is an atproto PDS, and the endpoint in the DID document is its base URL", "PDS %s describeServer failed: %v", pds, probe.Err)
+	default:
+		d.ok("PDS %s is reachable (%s)", pds, probe.Latency.Round(time.Millisecond))
+	}
+	if probe.TLSExpiry != nil && time.Until(*probe.TLSExpiry) < 14*24*time.Hour {
+		d.warn("renew the PDS's TLS certificate (check automatic renewal is working)", "PDS %s TLS certificate expires %s", pds, probe.TLSExpiry.Format(time.RFC3339))
+	}
+
+	did := ident.DID.String()
+	xrpcc := &xrpc.Client{Host: pds, Client: &http.Client{Timeout: 15 * time.Second}}
+	status, err := comatproto.SyncGetRepoStatus(ctx, xrpcc, did)
+	if err != nil {
+		d.problem("check the account exists on this PDS; if it moved, update the DID document's PDS endpoint", "PDS doesn't report a repo for %s: %v", did, err)
+		return nil
+	}
+	if !status.Active {
+		reason := "inactive"
+		if status.Status != nil {
+			reason = *status.Status
+		}
+		d.problem("reactivate the account on its PDS (com.atproto.server.activateAccount), if it should be active", "account is %s on its PDS", reason)
+		return nil
+	}
+
+	head, err := comatproto.SyncGetLatestCommit(ctx, xrpcc, did)
+	if err != nil {
+		d.problem("check the PDS's repo storage for the account", "PDS can't return the repo's head commit: %v", err)
+		return nil
+	}
+	if err := verifyHeadCommit(ctx, xrpcc, ident, head); err != nil {
+		d.problem("the PDS is signing with a key the DID document doesn't declare; publish the PDS's current signing key in the DID document", "head commit %s: %v", head.Cid, err)
+		return head
+	}
+	d.ok("PDS hosts the repo (rev %s), with a head commit signed by the DID document's key", head.Rev)
+	return head
+}
+
+// verifyHeadCommit fetches the head commit block from the PDS, and checks
+// it is for this account, and signed with its current key
+func verifyHeadCommit(ctx context.Context, xrpcc *xrpc.Client, ident *identity.Identity, head *comatproto.SyncGetLatestCommit_Output) error {
+	pub, err := ident.PublicKey()
+	if err != nil {
+		return err
+	}
+	blks, err := comatproto.SyncGetBlocks(ctx, xrpcc, []string{head.Cid}, ident.DID.String())
+	if err != nil {
+		return fmt.Errorf("fetching commit block: %w", err)
+	}
+	carr, err := car.NewCarReader(bytes.NewReader(blks))
+	if err != nil {
+		return err
+	}
+	for {
+		blk, err := carr.Next()
+		if errors.Is(err, io.EOF) {
+			return fmt.Errorf("PDS didn't return the commit block")
+		}
+		if err != nil {
+			return err
+		}
+		if blk.Cid().String() != head.Cid {
+			continue
+		}
+		var sc repo.SignedCommit
+		if err := sc.UnmarshalCBOR(bytes.NewReader(blk.RawData())); err != nil {
+			return fmt.Errorf("decoding commit: %w", err)
+		}
+		if sc.Did != ident.DID.String() {
+			return fmt.Errorf("commit is for a different account, %s", sc.Did)
+		}
+		unsigned, err := sc.Unsigned().BytesForSigning()
+		if err != nil {
+			return err
+		}
+		if err := pub.HashAndVerify(unsigned, sc.Sig); err != nil {
+			return fmt.Errorf("signature doesn't verify with the DID document's key: %w", err)
+		}
+		return nil
+	}
+}
+
+// checkRelay compares the relay's copy of the repo with the PDS's head
+// commit (nil if it couldn't be fetched)
+func (d *accountDoctor) checkRelay(ctx context.Context, did syntax.DID, head *comatproto.SyncGetLatestCommit_Output) {
+	crawlFix := fmt.Sprintf("ask the relay to crawl the PDS (com.atproto.sync.requestCrawl on %s), and check the PDS is emitting events for the account", d.relay.Host)
+	status, err := comatproto.SyncGetRepoStatus(ctx, d.relay, did.String())
+	if err != nil {
+		d.problem(crawlFix, "relay %s doesn't know the repo: %v", d.relay.Host, err)
+		return
+	}
+	if !status.Active {
+		reason := "inactive"
+		if status.Status != nil {
+			reason = *status.Status
+		}
+		fix := "if the account is active on its PDS, have the PDS emit an #account event (eg, by deactivating and reactivating it)"
+		if reason == "takendown" {
+			fix = "the relay operator has taken the account down"
+		}
+		d.problem(fix, "account is %s on relay %s", reason, d.relay.Host)
+		return
+	}
+	if head == nil {
+		return
+	}
+
+	relayHead, err := comatproto.SyncGetLatestCommit(ctx, d.relay, did.String())
+	if err != nil {
+		d.problem(crawlFix, "relay %s can't return the repo's head commit: %v", d.relay.Host, err)
+		return
+	}
+	switch {
+	case relayHead.Cid == head.Cid:
+		d.ok("relay %s is in sync with the PDS (rev %s)", d.relay.Host, head.Rev)
+	case relayHead.Rev < head.Rev:
+		d.warn("if this persists, "+crawlFix, "relay %s is behind the PDS (relay rev %s, PDS rev %s)", d.relay.Host, relayHead.Rev, head.Rev)
+	default:
+		d.problem("the PDS's repo doesn't match what it published (eg, it was restored from a backup); have the PDS emit a #sync event for the account so the relay resyncs", "relay %s has a different repo head than the PDS (relay rev %s, PDS rev %s)", d.relay.Host, relayHead.Rev, head.Rev)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testDoctorKey(t *testing.T) string {
+	t.Helper()
+	priv, err := crypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := priv.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub.Multibase()
+}
+
+func findingLevels(d *accountDoctor) []doctorLevel {
+	var out []doctorLevel
+	for _, f := range d.findings {
+		out = append(out, f.level)
+	}
+	return out
+}
+
+func TestAccountDoctorDocument(t *testing.T) {
+	assert := assert.New(t)
+	key := testDoctorKey(t)
+
+	ident := func(keyType, pdsType, pdsURL string) *identity.Identity {
+		id := &identity.Identity{
+			DID:  "did:plc:abc",
+			Keys: map[string]identity.Key{"atproto": {Type: keyType, PublicKeyMultibase: key}},
+		}
+		if pdsURL != "" {
+			id.Services = map[string]identity.Service{"atproto_pds": {Type: pdsType, URL: pdsURL}}
+		}
+		return id
+	}
+
+	d := &accountDoctor{}
+	assert.Equal("https://pds.example.com", d.checkDocument(ident("Multikey", "AtprotoPersonalDataServer", "https://pds.example.com/")))
+	assert.Equal([]doctorLevel{doctorOK, doctorOK}, findingLevels(d))
+
+	// loopback endpoints may be plain HTTP
+	d = &accountDoctor{}
+	assert.Equal("http://127.0.0.1:2583", d.checkDocument(ident("Multikey", "AtprotoPersonalDataServer", "http://127.0.0.1:2583")))
+	assert.Equal([]doctorLevel{doctorOK, doctorOK}, findingLevels(d))
+
+	for name, tc := range map[string]struct {
+		id     *identity.Identity
+		pds    string
+		levels []doctorLevel
+	}{
+		"no pds":      {ident("Multikey", "", ""), "", []doctorLevel{doctorOK, doctorProblem}},
+		"bad url":     {ident("Multikey", "AtprotoPersonalDataServer", "pds.example.com"), "", []doctorLevel{doctorOK, doctorProblem}},
+		"plain http":  {ident("Multikey", "AtprotoPersonalDataServer", "http://pds.example.com"), "http://pds.example.com", []doctorLevel{doctorOK, doctorWarning, doctorOK}},
+		"path":        {ident("Multikey", "AtprotoPersonalDataServer", "https://pds.example.com/xrpc"), "https://pds.example.com/xrpc", []doctorLevel{doctorOK, doctorWarning, doctorOK}},
+		"odd type":    {ident("Multikey", "PDS", "https://pds.example.com"), "https://pds.example.com", []doctorLevel{doctorOK, doctorWarning, doctorOK}},
+		"bad key":     {ident("Ed25519VerificationKey2020", "AtprotoPersonalDataServer", "https://pds.example.com"), "https://pds.example.com", []doctorLevel{doctorProblem, doctorOK}},
+		"missing key": {&identity.Identity{Services: map[string]identity.Service{"atproto_pds": {Type: "AtprotoPersonalDataServer", URL: "https://pds.example.com"}}}, "https://pds.example.com", []doctorLevel{doctorProblem, doctorOK}},
+	} {
+		d := &accountDoctor{}
+		assert.Equal(tc.pds, d.checkDocument(tc.id), name)
+		assert.Equal(tc.levels, findingLevels(d), name)
+	}
+}
+
+// routes every request (eg, for https://<handle>/.well-known/atproto-did) to
+// a test server
+type testDoctorTransport struct {
+	handler http.Handler
+}
+
+func (tt *testDoctorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec := httptest.NewRecorder()
+	tt.handler.ServeHTTP(rec, req)
+	return rec.Result(), nil
+}
+
+func TestAccountDoctorIdentity(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	key := testDoctorKey(t)
+
+	did := syntax.DID("did:plc:ewvi7nxzyoun6zhxrhs64oiz")
+	handleDIDs := map[string]string{
+		"alice.test": did.String(),
+		"other.test": "did:plc:aaaaaaaaaaaaaaaaaaaaaaaa",
+	}
+	declared := "alice.test"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/atproto-did", func(w http.ResponseWriter, r *http.Request) {
+		d, ok := handleDIDs[r.Host]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, d)
+	})
+	mux.HandleFunc("/"+did.String(), func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"id":          did,
+			"alsoKnownAs": []string{"at://" + declared},
+			"verificationMethod": []map[string]any{{
+				"id":                 did.String() + "#atproto",
+				"type":               "Multikey",
+				"controller":         did,
+				"publicKeyMultibase": key,
+			}},
+			"service": []map[string]any{{
+				"id":              "#atproto_pds",
+				"type":            "AtprotoPersonalDataServer",
+				"serviceEndpoint": "https://pds.example.com",
+			}},
+		})
+	})
+
+	newDoctor := func() *accountDoctor {
+		return &accountDoctor{dir: &identity.BaseDirectory{
+			PLCURL:                "https://plc.example.com",
+			HTTPClient:            http.Client{Transport: &testDoctorTransport{mux}},
+			SkipDNSDomainSuffixes: []string{".test"},
+			// DNS lookups fail, so handles resolve over HTTPS
+			Resolver: net.Resolver{PreferGo: true, Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return nil, errors.New("no DNS in tests")
+			}},
+		}}
+	}
+
+	d := newDoctor()
+	ident := d.checkIdentity(ctx, syntax.AtIdentifier{Inner: did})
+	if assert.NotNil(ident) {
+		assert.Equal(syntax.Handle("alice.test"), ident.Handle)
+	}
+	assert.Equal([]doctorLevel{doctorOK, doctorOK}, findingLevels(d))
+
+	d = newDoctor()
+	ident = d.checkIdentity(ctx, syntax.AtIdentifier{Inner: syntax.Handle("Alice.test")})
+	assert.NotNil(ident)
+	assert.Equal([]doctorLevel{doctorOK, doctorOK}, findingLevels(d))
+
+	// the handle points at the DID, but the DID document declares another
+	d = newDoctor()
+	declared = "other.test"
+	ident = d.checkIdentity(ctx, syntax.AtIdentifier{Inner: syntax.Handle("alice.test")})
+	assert.NotNil(ident)
+	assert.Equal([]doctorLevel{doctorOK, doctorProblem, doctorProblem}, findingLevels(d))
+	assert.Contains(d.findings[1].msg, "declares handle other.test")
+	assert.Contains(d.findings[2].msg, "resolves to a different DID")
+
+	// a handle which doesn't resolve (over DNS or HTTPS) stops the checks
+	d = newDoctor()
+	assert.Nil(d.checkIdentity(ctx, syntax.AtIdentifier{Inner: syntax.Handle("nobody.example.com")}))
+	assert.Equal([]doctorLevel{doctorProblem}, findingLevels(d))
+	assert.Contains(d.findings[0].fix, "_atproto.nobody.example.com")
+
+	d = newDoctor()
+	assert.Nil(d.checkIdentity(ctx, syntax.AtIdentifier{Inner: syntax.DID("did:plc:aaaaaaaaaaaaaaaaaaaaaaaa")}))
+	assert.Equal([]doctorLevel{doctorProblem}, findingLevels(d))
+	assert.Contains(d.findings[0].fix, "gosky did history")
+}
+
+func TestAccountDoctorRelay(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	status := `{"did": "did:plc:abc", "active": true}`
+	latest := `{"cid": "bafyreia", "rev": "3kb"}`
+	relay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "com.atproto.sync.getRepoStatus") && status != "":
+			fmt.Fprint(w, status)
+		case strings.HasSuffix(r.URL.Path, "com.atproto.sync.getLatestCommit"):
+			fmt.Fprint(w, latest)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error": "RepoNotFound", "message": "no such repo"}`)
+		}
+	}))
+	defer relay.Close()
+
+	head := &comatproto.SyncGetLatestCommit_Output{Cid: "bafyreia", Rev: "3kb"}
+	check := func() *accountDoctor {
+		d := &accountDoctor{relay: &xrpc.Client{Host: relay.URL, Client: relay.Client()}}
+		d.checkRelay(ctx, "did:plc:abc", head)
+		return d
+	}
+
+	assert.Equal([]doctorLevel{doctorOK}, findingLevels(check()))
+
+	latest = `{"cid": "bafyreib", "rev": "3ka"}`
+	d := check()
+	assert.Equal([]doctorLevel{doctorWarning}, findingLevels(d))
+	assert.Contains(d.findings[0].msg, "is behind the PDS")
+
+	latest = `{"cid": "bafyreic", "rev": "3kc"}`
+	d = check()
+	assert.Equal([]doctorLevel{doctorProblem}, findingLevels(d))
+	assert.Contains(d.findings[0].fix, "#sync event")
+
+	status = `{"did": "did:plc:abc", "active": false, "status": "takendown"}`
+	d = check()
+	assert.Equal([]doctorLevel{doctorProblem}, findingLevels(d))
+	assert.Contains(d.findings[0].msg, "takendown")
+	assert.Equal("the relay operator has taken the account down", d.findings[0].fix)
+
+	status = ""
+	d = check()
+	assert.Equal([]doctorLevel{doctorProblem}, findingLevels(d))
+	assert.Contains(d.findings[0].msg, "doesn't know the repo")
+}
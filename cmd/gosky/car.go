@@ -7,11 +7,16 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/mst"
 	"github.com/bluesky-social/indigo/repo"
 
 	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
 	cli "github.com/urfave/cli/v2"
 )
 
@@ -20,6 +25,8 @@ var carCmd = &cli.Command{
 	Usage: "sub-commands to work with CAR files on local disk",
 	Subcommands: []*cli.Command{
 		carUnpackCmd,
+		carInspectCmd,
+		carDiffCmd,
 	},
 }
 
@@ -123,3 +130,216 @@ var carUnpackCmd = &cli.Command{
 		return nil
 	},
 }
+
+var carInspectCmd = &cli.Command{
+	Name:  "inspect",
+	Usage: "summarize a repo export CAR file: commit, record counts by collection, and MST shape",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "list",
+			Usage: "list record paths and CIDs",
+		},
+		&cli.StringFlag{
+			Name:  "collection",
+			Usage: "only list records in this collection",
+		},
+		&cli.StringFlag{
+			Name:  "rkey",
+			Usage: "print records with this record key, in any collection (or just --collection), as JSON",
+		},
+	},
+	ArgsUsage: `<car-file>`,
+	Action: func(cctx *cli.Context) error {
+		ctx := context.Background()
+		arg := cctx.Args().First()
+		if arg == "" {
+			return fmt.Errorf("CAR file path arg is required")
+		}
+
+		r, err := readCarFile(ctx, arg)
+		if err != nil {
+			return err
+		}
+		sc := r.SignedCommit()
+
+		prefix := ""
+		if coll := cctx.String("collection"); coll != "" {
+			if _, err := syntax.ParseNSID(coll); err != nil {
+				return err
+			}
+			prefix = coll + "/"
+		}
+
+		if rkey := cctx.String("rkey"); rkey != "" {
+			found := false
+			err = r.ForEach(ctx, prefix, func(k string, v cid.Cid) error {
+				if !strings.HasPrefix(k, prefix) {
+					return repo.ErrDoneIterating
+				}
+				_, rk, ok := strings.Cut(k, "/")
+				if !ok || rk != rkey {
+					return nil
+				}
+				_, rec, err := r.GetRecord(ctx, k)
+				if err != nil {
+					return err
+				}
+				found = true
+				out, err := json.MarshalIndent(map[string]any{"path": k, "cid": v.String(), "value": rec}, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(out))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if !found {
+				return fmt.Errorf("no record found with rkey %s", rkey)
+			}
+			return nil
+		}
+
+		if cctx.Bool("list") {
+			return r.ForEach(ctx, prefix, func(k string, v cid.Cid) error {
+				if !strings.HasPrefix(k, prefix) {
+					return repo.ErrDoneIterating
+				}
+				fmt.Printf("%s\t%s\n", k, v)
+				return nil
+			})
+		}
+
+		counts := make(map[string]int)
+		total := 0
+		err = r.ForEach(ctx, "", func(k string, v cid.Cid) error {
+			coll, _, _ := strings.Cut(k, "/")
+			counts[coll]++
+			total++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		stats, err := mst.Stats(ctx, blockGetter(r.Blockstore()), sc.Data)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("did:\t%s\n", sc.Did)
+		fmt.Printf("rev:\t%s\n", sc.Rev)
+		fmt.Printf("version:\t%d\n", sc.Version)
+		fmt.Printf("data:\t%s\n", sc.Data)
+		fmt.Printf("records:\t%d\n", total)
+		colls := make([]string, 0, len(counts))
+		for coll := range counts {
+			colls = append(colls, coll)
+		}
+		sort.Strings(colls)
+		for _, coll := range colls {
+			fmt.Printf("  %s:\t%d\n", coll, counts[coll])
+		}
+		fmt.Printf("mst nodes:\t%d (%d bytes)\n", stats.Nodes, stats.NodeBytes)
+		fmt.Printf("mst root layer:\t%d\n", stats.RootLayer)
+		for layer := len(stats.LayerNodes) - 1; layer >= 0; layer-- {
+			fmt.Printf("  layer %d:\t%d nodes\n", layer, stats.LayerNodes[layer])
+		}
+		fmt.Printf("mst max entries per node:\t%d\n", stats.MaxEntries)
+		return nil
+	},
+}
+
+var carDiffCmd = &cli.Command{
+	Name:  "diff",
+	Usage: "list records added, removed, or changed between two export CAR files of the same repo",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "allow-different-did",
+			Usage: "compare CAR files even if they are for different accounts",
+		},
+	},
+	ArgsUsage: `<old-car-file> <new-car-file>`,
+	Action: func(cctx *cli.Context) error {
+		ctx := context.Background()
+		if cctx.Args().Len() != 2 {
+			return fmt.Errorf("old and new CAR file path args are required")
+		}
+
+		// both go in one blockstore, so unchanged subtrees are shared and skipped by the diff
+		bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+		var roots []cid.Cid
+		for _, p := range cctx.Args().Slice() {
+			fi, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			root, err := repo.IngestRepo(ctx, bs, fi)
+			fi.Close()
+			if err != nil {
+				return fmt.Errorf("reading %s: %w", p, err)
+			}
+			roots = append(roots, root)
+		}
+
+		oldRepo, err := repo.OpenRepo(ctx, bs, roots[0])
+		if err != nil {
+			return err
+		}
+		newRepo, err := repo.OpenRepo(ctx, bs, roots[1])
+		if err != nil {
+			return err
+		}
+		oldCommit, newCommit := oldRepo.SignedCommit(), newRepo.SignedCommit()
+		if oldCommit.Did != newCommit.Did && !cctx.Bool("allow-different-did") {
+			return fmt.Errorf("CAR files are for different accounts (%s, %s)", oldCommit.Did, newCommit.Did)
+		}
+		fmt.Printf("old:\t%s rev=%s data=%s\n", oldCommit.Did, oldCommit.Rev, oldCommit.Data)
+		fmt.Printf("new:\t%s rev=%s data=%s\n", newCommit.Did, newCommit.Rev, newCommit.Data)
+
+		ops, err := newRepo.DiffSince(ctx, roots[0])
+		if err != nil {
+			return err
+		}
+		sort.Slice(ops, func(i, j int) bool { return ops[i].Rpath < ops[j].Rpath })
+
+		var added, removed, changed int
+		for _, op := range ops {
+			switch op.Op {
+			case "add":
+				added++
+				fmt.Printf("+ %s\t%s\n", op.Rpath, op.NewCid)
+			case "del":
+				removed++
+				fmt.Printf("- %s\t%s\n", op.Rpath, op.OldCid)
+			case "mut":
+				changed++
+				fmt.Printf("~ %s\t%s -> %s\n", op.Rpath, op.OldCid, op.NewCid)
+			default:
+				return fmt.Errorf("unexpected diff op %q for %s", op.Op, op.Rpath)
+			}
+		}
+		fmt.Printf("%d added, %d removed, %d changed\n", added, removed, changed)
+		return nil
+	},
+}
+
+func readCarFile(ctx context.Context, p string) (*repo.Repo, error) {
+	fi, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer fi.Close()
+	return repo.ReadRepoFromCar(ctx, fi)
+}
+
+func blockGetter(bs blockstore.Blockstore) func(context.Context, cid.Cid) ([]byte, error) {
+	return func(ctx context.Context, c cid.Cid) ([]byte, error) {
+		blk, err := bs.Get(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		return blk.RawData(), nil
+	}
+}
@@ -20,6 +20,8 @@ var didCmd = &cli.Command{
 		didGetCmd,
 		didCreateCmd,
 		didKeyCmd,
+		didHistoryCmd,
+		didAuditCmd,
 	},
 }
 
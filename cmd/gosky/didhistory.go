@@ -0,0 +1,460 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	cli "github.com/urfave/cli/v2"
+)
+
+var didHistoryCmd = &cli.Command{
+	Name:      "history",
+	Usage:     "fetch and print the PLC operation log for a DID, with changes between operations",
+	ArgsUsage: `<did>`,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "print the raw audit log as JSON",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := context.TODO()
+		args, err := needArgs(cctx, "did")
+		if err != nil {
+			return err
+		}
+		did, err := syntax.ParseDID(args[0])
+		if err != nil {
+			return err
+		}
+
+		entries, err := fetchPLCAuditLog(ctx, cctx.String("plc"), did)
+		if err != nil {
+			return err
+		}
+
+		if cctx.Bool("json") {
+			b, err := json.MarshalIndent(entries, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(b))
+			return nil
+		}
+
+		ops, err := parsePLCAuditLog(entries)
+		if err != nil {
+			return err
+		}
+		var prev *plcOp
+		for i, op := range ops {
+			status := ""
+			if entries[i].Nullified {
+				status = " [NULLIFIED]"
+			}
+			fmt.Printf("#%d %s %s %s%s\n", i, entries[i].CreatedAt, op.Type, entries[i].CID, status)
+			for _, line := range diffPLCOps(prev, op) {
+				fmt.Println("    " + line)
+			}
+			// nullified operations aren't part of the history which later operations build on
+			if !entries[i].Nullified {
+				prev = op
+			}
+		}
+		return nil
+	},
+}
+
+var didAuditCmd = &cli.Command{
+	Name:      "audit",
+	Usage:     "verify the PLC operation log for a DID (hashes, chain, and signatures), and warn about suspicious patterns",
+	ArgsUsage: `<did>`,
+	Flags: []cli.Flag{
+		&cli.DurationFlag{
+			Name:  "rotation-window",
+			Usage: "warn if rotation keys change more than once within this period",
+			Value: 72 * time.Hour,
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := context.TODO()
+		args, err := needArgs(cctx, "did")
+		if err != nil {
+			return err
+		}
+		did, err := syntax.ParseDID(args[0])
+		if err != nil {
+			return err
+		}
+
+		entries, err := fetchPLCAuditLog(ctx, cctx.String("plc"), did)
+		if err != nil {
+			return err
+		}
+		ops, err := parsePLCAuditLog(entries)
+		if err != nil {
+			return err
+		}
+
+		problems := verifyPLCAuditLog(did, entries, ops)
+		warnings := auditPLCPatterns(entries, ops, cctx.Duration("rotation-window"))
+
+		fmt.Printf("%s: %d operations\n", did, len(entries))
+		for _, w := range warnings {
+			fmt.Println("WARNING: " + w)
+		}
+		for _, p := range problems {
+			fmt.Println("INVALID: " + p)
+		}
+		if len(problems) > 0 {
+			return cli.Exit(fmt.Sprintf("operation log failed verification (%d problems)", len(problems)), 1)
+		}
+		fmt.Println("operation log verified")
+		return nil
+	},
+}
+
+// a single entry in a PLC directory audit log (the /{did}/log/audit endpoint)
+type plcAuditEntry struct {
+	DID       string          `json:"did"`
+	Operation json.RawMessage `json:"operation"`
+	CID       string          `json:"cid"`
+	Nullified bool            `json:"nullified"`
+	CreatedAt string          `json:"createdAt"`
+}
+
+type plcService struct {
+	Type     string `json:"type"`
+	Endpoint string `json:"endpoint"`
+}
+
+// a PLC operation. legacy "create" operations are normalized in to the same fields as "plc_operation"
+type plcOp struct {
+	Type                string                `json:"type"`
+	RotationKeys        []string              `json:"rotationKeys"`
+	VerificationMethods map[string]string     `json:"verificationMethods"`
+	AlsoKnownAs         []string              `json:"alsoKnownAs"`
+	Services            map[string]plcService `json:"services"`
+	Prev                *string               `json:"prev"`
+	Sig                 string                `json:"sig"`
+
+	// legacy "create" fields
+	SigningKey  string `json:"signingKey"`
+	RecoveryKey string `json:"recoveryKey"`
+	Handle      string `json:"handle"`
+	Service     string `json:"service"`
+
+	// generic form, for hashing and signature verification
+	raw map[string]any
+}
+
+func fetchPLCAuditLog(ctx context.Context, host string, did syntax.DID) ([]plcAuditEntry, error) {
+	if !strings.HasPrefix(did.String(), "did:plc:") {
+		return nil, fmt.Errorf("not a did:plc: %s", did)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(host, "/")+"/"+did.String()+"/log/audit", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching audit log failed (code %d): %s", resp.StatusCode, resp.Status)
+	}
+
+	var entries []plcAuditEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("decoding audit log: %w", err)
+	}
+	return entries, nil
+}
+
+func parsePLCAuditLog(entries []plcAuditEntry) ([]*plcOp, error) {
+	ops := make([]*plcOp, len(entries))
+	for i, e := range entries {
+		var op plcOp
+		if err := json.Unmarshal(e.Operation, &op); err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+		if err := json.Unmarshal(e.Operation, &op.raw); err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+		if op.Type == "create" {
+			op.RotationKeys = []string{op.RecoveryKey, op.SigningKey}
+			op.VerificationMethods = map[string]string{"atproto": op.SigningKey}
+			op.AlsoKnownAs = []string{"at://" + op.Handle}
+			op.Services = map[string]plcService{
+				"atproto_pds": {Type: "AtprotoPersonalDataServer", Endpoint: op.Service},
+			}
+		}
+		ops[i] = &op
+	}
+	return ops, nil
+}
+
+// DAG-CBOR encoding of an operation, optionally without the signature
+func (op *plcOp) cbor(withSig bool) ([]byte, error) {
+	obj := op.raw
+	if !withSig {
+		obj = make(map[string]any, len(op.raw))
+		for k, v := range op.raw {
+			if k != "sig" {
+				obj[k] = v
+			}
+		}
+	}
+	return data.MarshalCBOR(obj)
+}
+
+func (op *plcOp) cid() (cid.Cid, error) {
+	b, err := op.cbor(true)
+	if err != nil {
+		return cid.Undef, err
+	}
+	mh, err := multihash.Sum(b, multihash.SHA2_256, -1)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return cid.NewCidV1(cid.DagCBOR, mh), nil
+}
+
+// returns the index of the rotation key (from the given list) which signed the operation, or an error if none did
+func (op *plcOp) signedBy(keys []string) (int, error) {
+	unsigned, err := op.cbor(false)
+	if err != nil {
+		return -1, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(op.Sig)
+	if err != nil {
+		return -1, fmt.Errorf("signature encoding: %w", err)
+	}
+	for i, k := range keys {
+		pub, err := crypto.ParsePublicDIDKey(k)
+		if err != nil {
+			continue
+		}
+		// older operations may have non-canonical (high-S) signatures
+		if err := pub.HashAndVerifyLenient(unsigned, sig); err == nil {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("not signed by any of %d rotation keys", len(keys))
+}
+
+// checks hashes, the prev chain, signatures, and that the genesis operation matches the DID. returns a list of problems (empty if the log is valid)
+func verifyPLCAuditLog(did syntax.DID, entries []plcAuditEntry, ops []*plcOp) []string {
+	problems := []string{}
+	if len(ops) == 0 {
+		return append(problems, "empty operation log")
+	}
+
+	byCID := make(map[string]*plcOp, len(ops))
+	lastValid := ""
+	for i, op := range ops {
+		c, err := op.cid()
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("#%d: encoding operation: %s", i, err))
+			continue
+		}
+		if c.String() != entries[i].CID {
+			problems = append(problems, fmt.Sprintf("#%d: CID mismatch (log says %s, computed %s)", i, entries[i].CID, c))
+		}
+
+		var signers []string
+		if i == 0 {
+			if op.Prev != nil {
+				problems = append(problems, "#0: genesis operation has a prev")
+			}
+			b, err := op.cbor(true)
+			if err == nil {
+				h := sha256.Sum256(b)
+				computed := "did:plc:" + strings.ToLower(base32.StdEncoding.EncodeToString(h[:]))[:24]
+				if computed != did.String() {
+					problems = append(problems, fmt.Sprintf("#0: genesis operation hashes to %s", computed))
+				}
+			}
+			signers = op.RotationKeys
+		} else {
+			if op.Prev == nil {
+				problems = append(problems, fmt.Sprintf("#%d: missing prev", i))
+				continue
+			}
+			prev, ok := byCID[*op.Prev]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("#%d: prev %s is not an earlier operation", i, *op.Prev))
+				continue
+			}
+			if !entries[i].Nullified && *op.Prev != lastValid {
+				// valid forks are only allowed if the intermediate operations were nullified
+				problems = append(problems, fmt.Sprintf("#%d: prev %s is not the latest valid operation (%s)", i, *op.Prev, lastValid))
+			}
+			signers = prev.RotationKeys
+		}
+
+		if _, err := op.signedBy(signers); err != nil {
+			problems = append(problems, fmt.Sprintf("#%d: bad signature: %s", i, err))
+		}
+
+		byCID[entries[i].CID] = op
+		if !entries[i].Nullified {
+			lastValid = entries[i].CID
+		}
+	}
+	return problems
+}
+
+// looks for patterns in a (valid) operation log which may indicate account compromise
+func auditPLCPatterns(entries []plcAuditEntry, ops []*plcOp, rotationWindow time.Duration) []string {
+	warnings := []string{}
+	var rotationChanges []time.Time
+	var prev *plcOp
+	for i, op := range ops {
+		if entries[i].Nullified {
+			warnings = append(warnings, fmt.Sprintf("#%d (%s) was nullified by a later operation signed with a higher-priority rotation key (account recovery, or a contested update)", i, entries[i].CreatedAt))
+			continue
+		}
+		if op.Type == "plc_tombstone" {
+			warnings = append(warnings, fmt.Sprintf("#%d (%s) tombstoned the DID", i, entries[i].CreatedAt))
+		}
+		if prev != nil && !equalStrings(prev.RotationKeys, op.RotationKeys) {
+			t, err := syntax.ParseDatetimeLenient(entries[i].CreatedAt)
+			if err == nil {
+				rotationChanges = append(rotationChanges, t.Time())
+			}
+			if idx, err := op.signedBy(prev.RotationKeys); err == nil && idx > 0 {
+				warnings = append(warnings, fmt.Sprintf("#%d (%s) changed rotation keys, signed by lower-priority key %d", i, entries[i].CreatedAt, idx))
+			}
+		}
+		prev = op
+	}
+
+	sort.Slice(rotationChanges, func(i, j int) bool { return rotationChanges[i].Before(rotationChanges[j]) })
+	for i := 1; i < len(rotationChanges); i++ {
+		if gap := rotationChanges[i].Sub(rotationChanges[i-1]); gap < rotationWindow {
+			warnings = append(warnings, fmt.Sprintf("rotation keys changed twice within %s (%s and %s)", gap.Round(time.Second), rotationChanges[i-1].Format(time.RFC3339), rotationChanges[i].Format(time.RFC3339)))
+		}
+	}
+	return warnings
+}
+
+// human-readable list of changes between two operations. prev may be nil, for the genesis operation
+func diffPLCOps(prev, op *plcOp) []string {
+	out := []string{}
+	if op.Type == "plc_tombstone" {
+		return append(out, "tombstone")
+	}
+	if prev == nil {
+		prev = &plcOp{}
+	}
+
+	removed, added := diffStrings(prev.RotationKeys, op.RotationKeys)
+	for _, k := range removed {
+		out = append(out, "- rotation key "+k)
+	}
+	for _, k := range added {
+		out = append(out, "+ rotation key "+k)
+	}
+	if len(removed) == 0 && len(added) == 0 && !equalStrings(prev.RotationKeys, op.RotationKeys) {
+		out = append(out, "~ rotation keys re-ordered: "+strings.Join(op.RotationKeys, ", "))
+	}
+
+	for _, name := range unionKeys(prev.VerificationMethods, op.VerificationMethods) {
+		if a, b := prev.VerificationMethods[name], op.VerificationMethods[name]; a != b {
+			out = append(out, changeLine("verification method "+name, a, b))
+		}
+	}
+
+	removed, added = diffStrings(prev.AlsoKnownAs, op.AlsoKnownAs)
+	for _, aka := range removed {
+		out = append(out, "- alsoKnownAs "+aka)
+	}
+	for _, aka := range added {
+		out = append(out, "+ alsoKnownAs "+aka)
+	}
+
+	for _, name := range unionKeys(prev.Services, op.Services) {
+		a, b := prev.Services[name], op.Services[name]
+		if a != b {
+			out = append(out, changeLine("service "+name, a.Endpoint, b.Endpoint))
+		}
+	}
+	return out
+}
+
+func changeLine(what, from, to string) string {
+	switch {
+	case from == "":
+		return "+ " + what + " " + to
+	case to == "":
+		return "- " + what + " " + from
+	default:
+		return "~ " + what + " " + from + " -> " + to
+	}
+}
+
+// returns values only in a, and values only in b
+func diffStrings(a, b []string) ([]string, []string) {
+	inA := make(map[string]bool, len(a))
+	inB := make(map[string]bool, len(b))
+	for _, v := range a {
+		inA[v] = true
+	}
+	for _, v := range b {
+		inB[v] = true
+	}
+	var onlyA, onlyB []string
+	for _, v := range a {
+		if !inB[v] {
+			onlyA = append(onlyA, v)
+		}
+	}
+	for _, v := range b {
+		if !inA[v] {
+			onlyB = append(onlyB, v)
+		}
+	}
+	return onlyA, onlyB
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func unionKeys[V any](a, b map[string]V) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testRotationKey(t *testing.T) (crypto.PrivateKey, string) {
+	t.Helper()
+	priv, err := crypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := priv.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv, pub.DIDKey()
+}
+
+// signs an operation and wraps it as an audit log entry
+func signedPLCEntry(t *testing.T, priv crypto.PrivateKey, obj map[string]any, createdAt time.Time) plcAuditEntry {
+	t.Helper()
+	entry := func() plcAuditEntry {
+		b, err := json.Marshal(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return plcAuditEntry{Operation: b, CreatedAt: createdAt.UTC().Format(time.RFC3339)}
+	}
+	ops, err := parsePLCAuditLog([]plcAuditEntry{entry()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned, err := ops[0].cbor(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := priv.HashAndSign(unsigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj["sig"] = base64.RawURLEncoding.EncodeToString(sig)
+
+	e := entry()
+	ops, err = parsePLCAuditLog([]plcAuditEntry{e})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := ops[0].cid()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.CID = c.String()
+	return e
+}
+
+func testPLCOp(prev *string, rotationKeys []string, handle string) map[string]any {
+	keys := make([]any, len(rotationKeys))
+	for i, k := range rotationKeys {
+		keys[i] = k
+	}
+	op := map[string]any{
+		"type":                "plc_operation",
+		"rotationKeys":        keys,
+		"verificationMethods": map[string]any{"atproto": rotationKeys[0]},
+		"alsoKnownAs":         []any{"at://" + handle},
+		"services": map[string]any{
+			"atproto_pds": map[string]any{"type": "AtprotoPersonalDataServer", "endpoint": "https://pds.example.com"},
+		},
+		"prev": nil,
+	}
+	if prev != nil {
+		op["prev"] = *prev
+	}
+	return op
+}
+
+func genesisDID(t *testing.T, e plcAuditEntry) syntax.DID {
+	t.Helper()
+	ops, err := parsePLCAuditLog([]plcAuditEntry{e})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ops[0].cbor(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := sha256.Sum256(b)
+	return syntax.DID("did:plc:" + strings.ToLower(base32.StdEncoding.EncodeToString(h[:]))[:24])
+}
+
+func TestVerifyPLCAuditLog(t *testing.T) {
+	assert := assert.New(t)
+
+	privA, keyA := testRotationKey(t)
+	privB, keyB := testRotationKey(t)
+	now := time.Now()
+
+	genesis := signedPLCEntry(t, privA, testPLCOp(nil, []string{keyA, keyB}, "alice.example.com"), now.Add(-time.Hour))
+	update := signedPLCEntry(t, privA, testPLCOp(&genesis.CID, []string{keyA, keyB}, "alice2.example.com"), now)
+	did := genesisDID(t, genesis)
+
+	entries := []plcAuditEntry{genesis, update}
+	ops, err := parsePLCAuditLog(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(verifyPLCAuditLog(did, entries, ops))
+
+	// genesis doesn't match the DID
+	problems := verifyPLCAuditLog("did:plc:aaaaaaaaaaaaaaaaaaaaaaaa", entries, ops)
+	assert.Len(problems, 1)
+	assert.Contains(problems[0], "genesis operation hashes to")
+
+	// update signed by a key which isn't a rotation key
+	privC, _ := testRotationKey(t)
+	forged := signedPLCEntry(t, privC, testPLCOp(&genesis.CID, []string{keyA, keyB}, "mallory.example.com"), now)
+	entries = []plcAuditEntry{genesis, forged}
+	ops, err = parsePLCAuditLog(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	problems = verifyPLCAuditLog(did, entries, ops)
+	assert.Len(problems, 1)
+	assert.Contains(problems[0], "#1: bad signature")
+
+	// operation modified after it was logged
+	tampered := update
+	tampered.Operation = []byte(strings.Replace(string(update.Operation), "alice2", "mallory", 1))
+	entries = []plcAuditEntry{genesis, tampered}
+	ops, err = parsePLCAuditLog(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	problems = verifyPLCAuditLog(did, entries, ops)
+	assert.Len(problems, 2)
+	assert.Contains(problems[0], "#1: CID mismatch")
+	assert.Contains(problems[1], "#1: bad signature")
+
+	// prev which isn't in the log
+	bogus := "bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm"
+	orphan := signedPLCEntry(t, privB, testPLCOp(&bogus, []string{keyA, keyB}, "alice.example.com"), now)
+	entries = []plcAuditEntry{genesis, orphan}
+	ops, err = parsePLCAuditLog(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	problems = verifyPLCAuditLog(did, entries, ops)
+	assert.Len(problems, 1)
+	assert.Contains(problems[0], "is not an earlier operation")
+
+	assert.Equal([]string{"empty operation log"}, verifyPLCAuditLog(did, nil, nil))
+}
+
+func TestAuditPLCPatterns(t *testing.T) {
+	assert := assert.New(t)
+
+	privA, keyA := testRotationKey(t)
+	privB, keyB := testRotationKey(t)
+	_, keyC := testRotationKey(t)
+	now := time.Now()
+
+	genesis := signedPLCEntry(t, privA, testPLCOp(nil, []string{keyA, keyB}, "alice.example.com"), now.Add(-2*time.Hour))
+	// the lower-priority key replaces the higher-priority one
+	takeover := signedPLCEntry(t, privB, testPLCOp(&genesis.CID, []string{keyB, keyC}, "alice.example.com"), now.Add(-time.Hour))
+	again := signedPLCEntry(t, privB, testPLCOp(&takeover.CID, []string{keyB}, "alice.example.com"), now)
+
+	entries := []plcAuditEntry{genesis, takeover, again}
+	ops, err := parsePLCAuditLog(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	warnings := auditPLCPatterns(entries, ops, 72*time.Hour)
+	assert.Len(warnings, 2)
+	assert.Contains(warnings[0], "#1")
+	assert.Contains(warnings[0], "signed by lower-priority key 1")
+	assert.Contains(warnings[1], "rotation keys changed twice within 1h0m0s")
+
+	// outside the window, only the lower-priority signature is suspicious
+	assert.Len(auditPLCPatterns(entries, ops, time.Minute), 1)
+
+	// nullified operations are skipped, so #2 is compared against the genesis operation
+	entries[1].Nullified = true
+	warnings = auditPLCPatterns(entries, ops, time.Minute)
+	assert.Len(warnings, 2)
+	assert.Contains(warnings[0], "#1")
+	assert.Contains(warnings[0], "was nullified")
+	assert.Contains(warnings[1], "#2")
+	assert.Contains(warnings[1], "signed by lower-priority key 1")
+}
+
+func TestDiffPLCOps(t *testing.T) {
+	assert := assert.New(t)
+
+	entries := []plcAuditEntry{
+		{Operation: []byte(`{"type": "create", "signingKey": "did:key:sign", "recoveryKey": "did:key:recovery", "handle": "alice.example.com", "service": "https://pds.example.com", "prev": null}`)},
+		{Operation: []byte(`{"type": "plc_operation", "rotationKeys": ["did:key:recovery", "did:key:new"], "verificationMethods": {"atproto": "did:key:new"}, "alsoKnownAs": ["at://alice.example.com"], "services": {"atproto_pds": {"type": "AtprotoPersonalDataServer", "endpoint": "https://pds2.example.com"}}, "prev": "x"}`)},
+		{Operation: []byte(`{"type": "plc_tombstone", "prev": "y"}`)},
+	}
+	ops, err := parsePLCAuditLog(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// legacy create operations are normalized
+	assert.Equal([]string{"did:key:recovery", "did:key:sign"}, ops[0].RotationKeys)
+	assert.Equal([]string{
+		"+ rotation key did:key:recovery",
+		"+ rotation key did:key:sign",
+		"+ verification method atproto did:key:sign",
+		"+ alsoKnownAs at://alice.example.com",
+		"+ service atproto_pds https://pds.example.com",
+	}, diffPLCOps(nil, ops[0]))
+
+	assert.Equal([]string{
+		"- rotation key did:key:sign",
+		"+ rotation key did:key:new",
+		"~ verification method atproto did:key:sign -> did:key:new",
+		"~ service atproto_pds https://pds.example.com -> https://pds2.example.com",
+	}, diffPLCOps(ops[0], ops[1]))
+
+	assert.Equal([]string{"tombstone"}, diffPLCOps(ops[1], ops[2]))
+
+	reordered := *ops[1]
+	reordered.RotationKeys = []string{"did:key:new", "did:key:recovery"}
+	assert.Equal([]string{"~ rotation keys re-ordered: did:key:new, did:key:recovery"}, diffPLCOps(ops[1], &reordered))
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/template"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/feedgen"
+
+	cli "github.com/urfave/cli/v2"
+)
+
+var feedgenCmd = &cli.Command{
+	Name:  "feedgen",
+	Usage: "sub-commands for building and running feed generators",
+	Flags: []cli.Flag{},
+	Subcommands: []*cli.Command{
+		feedgenInitCmd,
+		feedgenServeCmd,
+	},
+}
+
+// config file used by "feedgen serve", and written by "feedgen init"
+type feedgenConfig struct {
+	Hostname       string `json:"hostname"`
+	Publisher      string `json:"publisher"`
+	PrivacyPolicy  string `json:"privacyPolicy,omitempty"`
+	TermsOfService string `json:"termsOfService,omitempty"`
+	// feeds are keyed by the record key of their generator record
+	Feeds map[string]feedgenStaticFeed `json:"feeds"`
+}
+
+type feedgenStaticFeed struct {
+	// AT-URIs of posts to serve, in order
+	Posts []string `json:"posts"`
+}
+
+const feedgenMainTemplate = `// Feed generator scaffolded by "gosky feedgen init".
+//
+// Run it with "go run . --listen :3000", behind an HTTPS proxy for
+// {{ .Hostname }}, then publish the feed record with:
+//
+//	gosky create-feed-gen --name {{ .Name }} --did did:web:{{ .Hostname }}
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/feedgen"
+)
+
+// Feed is the feed algorithm. Replace the body of Skeleton with your own
+// logic: return up to req.Limit post AT-URIs, starting after req.Cursor, and
+// a cursor for the next page (or nil at the end of the feed).
+type Feed struct{}
+
+func (f *Feed) Skeleton(ctx context.Context, req *feedgen.SkeletonRequest) (*appbsky.FeedGetFeedSkeleton_Output, error) {
+	out := &appbsky.FeedGetFeedSkeleton_Output{}
+	// eg:
+	// out.Feed = append(out.Feed, &appbsky.FeedDefs_SkeletonFeedPost{Post: "at://did:plc:.../app.bsky.feed.post/..."})
+	return out, nil
+}
+
+func main() {
+	listen := flag.String("listen", ":3000", "address to serve on")
+	hostname := flag.String("hostname", "{{ .Hostname }}", "public hostname of the service")
+	publisher := flag.String("publisher", "{{ .Publisher }}", "DID of the account which publishes the feed record")
+	flag.Parse()
+
+	srv, err := feedgen.NewServer(feedgen.Config{
+		Hostname:     *hostname,
+		PublisherDID: syntax.DID(*publisher),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := srv.AddFeed("{{ .Name }}", &Feed{}); err != nil {
+		log.Fatal(err)
+	}
+	log.Fatal(srv.Start(*listen))
+}
+`
+
+var feedgenInitCmd = &cli.Command{
+	Name:      "init",
+	Usage:     "scaffold a feed generator service (Go program and config file) in a new directory",
+	ArgsUsage: `<dir>`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "hostname",
+			Usage:    "public hostname the service will be served on; the service DID is did:web:<hostname>",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "publisher",
+			Usage:    "DID of the account which will publish the feed generator record",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "name",
+			Usage: "feed name (record key of the feed generator record)",
+			Value: "example",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		dir := cctx.Args().First()
+		if dir == "" {
+			return fmt.Errorf("need to provide a directory as an argument")
+		}
+		params := struct {
+			Hostname  string
+			Publisher string
+			Name      string
+		}{
+			Hostname:  cctx.String("hostname"),
+			Publisher: cctx.String("publisher"),
+			Name:      cctx.String("name"),
+		}
+		// validate up front, the same way the service will
+		srv, err := feedgen.NewServer(feedgen.Config{Hostname: params.Hostname, PublisherDID: syntax.DID(params.Publisher)})
+		if err != nil {
+			return err
+		}
+		if err := srv.AddFeed(params.Name, feedgen.StaticFeed{}); err != nil {
+			return err
+		}
+
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		mainPath := filepath.Join(dir, "main.go")
+		if _, err := os.Stat(mainPath); err == nil {
+			return fmt.Errorf("not overwriting existing file: %s", mainPath)
+		}
+		tmpl := template.Must(template.New("main.go").Parse(feedgenMainTemplate))
+		fi, err := os.Create(mainPath)
+		if err != nil {
+			return err
+		}
+		defer fi.Close()
+		if err := tmpl.Execute(fi, params); err != nil {
+			return err
+		}
+
+		conf := feedgenConfig{
+			Hostname:  params.Hostname,
+			Publisher: params.Publisher,
+			Feeds: map[string]feedgenStaticFeed{
+				params.Name: {Posts: []string{}},
+			},
+		}
+		b, err := json.MarshalIndent(conf, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, "feedgen.json"), append(b, '\n'), 0644); err != nil {
+			return err
+		}
+
+		fmt.Printf("wrote %s and %s\n", mainPath, filepath.Join(dir, "feedgen.json"))
+		fmt.Printf("feed URI: %s\n", srv.FeedURI(params.Name))
+		fmt.Println("next steps:")
+		fmt.Printf("  implement Feed.Skeleton in %s, and run it with 'go run .' (the directory needs a go.mod requiring github.com/bluesky-social/indigo)\n", mainPath)
+		fmt.Printf("  or, to try out the deployment with a fixed list of posts: gosky feedgen serve %s\n", filepath.Join(dir, "feedgen.json"))
+		fmt.Printf("  then publish the feed: gosky create-feed-gen --name %s --did %s\n", params.Name, srv.ServiceDID())
+		return nil
+	},
+}
+
+var feedgenServeCmd = &cli.Command{
+	Name:      "serve",
+	Usage:     "run a feed generator serving fixed lists of posts, from a config file",
+	ArgsUsage: `<config-file>`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "listen",
+			Usage: "address to serve on (the service must be reachable at https://<hostname>/, eg via a reverse proxy)",
+			Value: ":3000",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		path := cctx.Args().First()
+		if path == "" {
+			return fmt.Errorf("need to provide a config file as an argument")
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var conf feedgenConfig
+		if err := json.Unmarshal(b, &conf); err != nil {
+			return fmt.Errorf("parsing config file: %w", err)
+		}
+
+		srv, err := feedgen.NewServer(feedgen.Config{
+			Hostname:       conf.Hostname,
+			PublisherDID:   syntax.DID(conf.Publisher),
+			PrivacyPolicy:  conf.PrivacyPolicy,
+			TermsOfService: conf.TermsOfService,
+			Logger:         log,
+		})
+		if err != nil {
+			return err
+		}
+		names := make([]string, 0, len(conf.Feeds))
+		for name := range conf.Feeds {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for _, p := range conf.Feeds[name].Posts {
+				if _, err := syntax.ParseATURI(p); err != nil {
+					return fmt.Errorf("feed %s: invalid post URI %q: %w", name, p, err)
+				}
+			}
+			if err := srv.AddFeed(name, feedgen.StaticFeed(conf.Feeds[name].Posts)); err != nil {
+				return err
+			}
+			fmt.Printf("serving %s (%d posts)\n", srv.FeedURI(name), len(conf.Feeds[name].Posts))
+		}
+		return srv.Start(cctx.String("listen"))
+	},
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/events/schedulers/sequential"
+	"github.com/bluesky-social/indigo/util/labels"
+
+	"github.com/gorilla/websocket"
+	cli "github.com/urfave/cli/v2"
+)
+
+var labelsCmd = &cli.Command{
+	Name:  "labels",
+	Usage: "sub-commands for working with labelers",
+	Subcommands: []*cli.Command{
+		labelsStreamCmd,
+	},
+}
+
+// one line of JSONL output
+type streamedLabel struct {
+	Seq      int64                       `json:"seq"`
+	Verified bool                        `json:"verified"`
+	Label    *comatproto.LabelDefs_Label `json:"label"`
+}
+
+var labelsStreamCmd = &cli.Command{
+	Name:      "stream",
+	Usage:     "subscribe to a labeler's label stream, verify signatures, and output labels as JSONL",
+	ArgsUsage: `<labeler-handle-or-did>`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "host",
+			Usage: "override labeler service host (eg, wss://mod.example.com); by default taken from the labeler's DID document",
+		},
+		&cli.StringFlag{
+			Name:  "cursor-file",
+			Usage: "path to a file used to persist the stream cursor; the stream resumes from it if it exists",
+		},
+		&cli.Int64Flag{
+			Name:  "cursor",
+			Usage: "sequence number to start from (overrides cursor file)",
+			Value: -1,
+		},
+		&cli.BoolFlag{
+			Name:  "include-invalid",
+			Usage: "output labels with missing or invalid signatures (marked as unverified) instead of skipping them",
+		},
+		&cli.BoolFlag{
+			Name:  "no-verify",
+			Usage: "skip signature verification entirely",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+
+		arg := cctx.Args().First()
+		if arg == "" {
+			return fmt.Errorf("need to provide labeler handle or DID as an argument")
+		}
+		atid, err := syntax.ParseAtIdentifier(arg)
+		if err != nil {
+			return err
+		}
+
+		dir := identity.DefaultDirectory()
+		ident, err := dir.Lookup(ctx, *atid)
+		if err != nil {
+			return fmt.Errorf("resolving labeler identity: %w", err)
+		}
+
+		verify := !cctx.Bool("no-verify")
+		var pub crypto.PublicKey
+		if verify {
+			pub, err = ident.GetPublicKey("atproto_label")
+			if err != nil {
+				return fmt.Errorf("labeler has no declared label signing key: %w", err)
+			}
+		}
+
+		host := cctx.String("host")
+		if host == "" {
+			host = ident.GetServiceEndpoint("atproto_labeler")
+			if host == "" {
+				return fmt.Errorf("labeler DID document does not declare a labeler service endpoint")
+			}
+		}
+		u, err := labelStreamURL(host)
+		if err != nil {
+			return err
+		}
+
+		cursorFile := cctx.String("cursor-file")
+		cursor := cctx.Int64("cursor")
+		if cursor < 0 && cursorFile != "" {
+			cursor, err = readCursorFile(cursorFile)
+			if err != nil {
+				return err
+			}
+		}
+		if cursor >= 0 {
+			q := u.Query()
+			q.Set("cursor", strconv.FormatInt(cursor, 10))
+			u.RawQuery = q.Encode()
+		}
+
+		fmt.Fprintln(os.Stderr, "dialing:", u.String())
+		con, _, err := websocket.DefaultDialer.DialContext(ctx, u.String(), http.Header{})
+		if err != nil {
+			return fmt.Errorf("dial failure: %w", err)
+		}
+
+		go func() {
+			<-ctx.Done()
+			_ = con.Close()
+		}()
+
+		includeInvalid := cctx.Bool("include-invalid")
+		out := json.NewEncoder(os.Stdout)
+		labelerDID := ident.DID.String()
+
+		rsc := &events.RepoStreamCallbacks{
+			LabelLabels: func(evt *comatproto.LabelSubscribeLabels_Labels) error {
+				for _, l := range evt.Labels {
+					ok := false
+					if verify {
+						if l.Src != labelerDID {
+							fmt.Fprintf(os.Stderr, "label (seq=%d) from unexpected source: %s\n", evt.Seq, l.Src)
+						} else if err := (*labels.SignedLabel)(l).VerifySignature(pub); err != nil {
+							fmt.Fprintf(os.Stderr, "label (seq=%d) failed signature verification: %s\n", evt.Seq, err)
+						} else {
+							ok = true
+						}
+						if !ok && !includeInvalid {
+							continue
+						}
+					}
+					if err := out.Encode(streamedLabel{Seq: evt.Seq, Verified: ok, Label: l}); err != nil {
+						return err
+					}
+				}
+				if cursorFile != "" {
+					if err := writeCursorFile(cursorFile, evt.Seq); err != nil {
+						return fmt.Errorf("persisting cursor: %w", err)
+					}
+				}
+				return nil
+			},
+			LabelInfo: func(evt *comatproto.LabelSubscribeLabels_Info) error {
+				msg := ""
+				if evt.Message != nil {
+					msg = *evt.Message
+				}
+				fmt.Fprintf(os.Stderr, "INFO: %s: %s\n", evt.Name, msg)
+				return nil
+			},
+			Error: func(errf *events.ErrorFrame) error {
+				return fmt.Errorf("error frame: %s: %s", errf.Error, errf.Message)
+			},
+		}
+
+		seqScheduler := sequential.NewScheduler(con.RemoteAddr().String(), rsc.EventHandler)
+		err = events.HandleRepoStream(ctx, con, seqScheduler, log)
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	},
+}
+
+// builds the websocket subscribeLabels URL from a service endpoint, which may use http(s) or ws(s) scheme
+func labelStreamURL(host string) (*url.URL, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid labeler host: %w", err)
+	}
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	case "http":
+		u.Scheme = "ws"
+	case "ws", "wss":
+	default:
+		return nil, fmt.Errorf("unsupported labeler host scheme: %s", host)
+	}
+	if !strings.HasSuffix(u.Path, "/xrpc/com.atproto.label.subscribeLabels") {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/xrpc/com.atproto.label.subscribeLabels"
+	}
+	return u, nil
+}
+
+// returns -1 if the cursor file does not exist yet
+func readCursorFile(path string) (int64, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return -1, nil
+		}
+		return -1, err
+	}
+	s := strings.TrimSpace(string(b))
+	if s == "" {
+		return -1, nil
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// writes via a temporary file and rename, so a crash never leaves a truncated cursor
+func writeCursorFile(path string, seq int64) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.WriteString(strconv.FormatInt(seq, 10) + "\n"); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -97,11 +97,14 @@ func run(args []string) {
 		debugCmd,
 		didCmd,
 		handleCmd,
+		labelsCmd,
 		syncCmd,
 		createFeedGeneratorCmd,
+		feedgenCmd,
 		getRecordCmd,
 		listAllRecordsCmd,
 		readRepoStreamCmd,
+		streamMetricsCmd,
 		parseRkey,
 		listLabelsCmd,
 	}
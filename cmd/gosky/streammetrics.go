@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/events"
+
+	"github.com/gorilla/websocket"
+	cli "github.com/urfave/cli/v2"
+)
+
+var streamMetricsCmd = &cli.Command{
+	Name:  "stream-metrics",
+	Usage: "subscribe to a repo event stream and periodically report throughput, lag, and message sizes",
+	Flags: []cli.Flag{
+		&cli.DurationFlag{
+			Name:  "interval",
+			Usage: "how often to print a report",
+			Value: 10 * time.Second,
+		},
+		&cli.DurationFlag{
+			Name:  "duration",
+			Usage: "stop after this long (default: run until interrupted)",
+		},
+		&cli.IntFlag{
+			Name:  "top",
+			Usage: "number of collections to include in each report",
+			Value: 10,
+		},
+		&cli.Int64Flag{
+			Name:  "cursor",
+			Usage: "start from this sequence number, instead of the live tip",
+			Value: -1,
+		},
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "print reports as JSON lines",
+		},
+	},
+	ArgsUsage: `<host>`,
+	Action: func(cctx *cli.Context) error {
+		args, err := needArgs(cctx, "host")
+		if err != nil {
+			return err
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		if d := cctx.Duration("duration"); d > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, d)
+			defer cancel()
+		}
+
+		u := args[0]
+		if !strings.Contains(u, "://") {
+			u = "wss://" + u
+		}
+		if !strings.Contains(u, "/xrpc/") {
+			u = strings.TrimSuffix(u, "/") + "/xrpc/com.atproto.sync.subscribeRepos"
+		}
+		if cur := cctx.Int64("cursor"); cur >= 0 {
+			u = fmt.Sprintf("%s?cursor=%d", u, cur)
+		}
+
+		fmt.Fprintln(os.Stderr, "dialing: ", u)
+		con, _, err := websocket.DefaultDialer.DialContext(ctx, u, http.Header{})
+		if err != nil {
+			return fmt.Errorf("dial failure: %w", err)
+		}
+		defer con.Close()
+
+		go func() {
+			<-ctx.Done()
+			_ = con.Close()
+		}()
+
+		sm := newStreamMetrics()
+		msgs := make(chan []byte, 1000)
+		readErr := make(chan error, 1)
+		go func() {
+			defer close(msgs)
+			for {
+				mt, msg, err := con.ReadMessage()
+				if err != nil {
+					readErr <- err
+					return
+				}
+				if mt != websocket.BinaryMessage {
+					continue
+				}
+				msgs <- msg
+			}
+		}()
+
+		ticker := time.NewTicker(cctx.Duration("interval"))
+		defer ticker.Stop()
+
+		report := func() {
+			rep := sm.report(cctx.Int("top"))
+			if cctx.Bool("json") {
+				b, err := json.Marshal(rep)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, "failed to encode report: ", err)
+					return
+				}
+				fmt.Println(string(b))
+			} else {
+				rep.print()
+			}
+		}
+
+		for {
+			select {
+			case msg, ok := <-msgs:
+				if !ok {
+					report()
+					err := <-readErr
+					if ctx.Err() != nil {
+						return nil
+					}
+					return fmt.Errorf("stream closed: %w", err)
+				}
+				if err := sm.observe(msg); err != nil {
+					return err
+				}
+			case <-ticker.C:
+				report()
+			}
+		}
+	},
+}
+
+// accumulates stats about stream messages between reports
+type streamMetrics struct {
+	start time.Time
+
+	types       map[string]int
+	collections map[string]int
+	sizes       []int
+	lags        []time.Duration
+	lastSeq     int64
+	firstSeq    int64
+}
+
+func newStreamMetrics() *streamMetrics {
+	sm := &streamMetrics{}
+	sm.reset()
+	return sm
+}
+
+func (sm *streamMetrics) reset() {
+	sm.start = time.Now()
+	sm.types = make(map[string]int)
+	sm.collections = make(map[string]int)
+	sm.sizes = sm.sizes[:0]
+	sm.lags = sm.lags[:0]
+	sm.firstSeq = 0
+}
+
+func (sm *streamMetrics) observe(msg []byte) error {
+	var evt events.XRPCStreamEvent
+	if err := evt.Deserialize(bytes.NewReader(msg)); err != nil {
+		return fmt.Errorf("decoding stream message: %w", err)
+	}
+	if evt.Error != nil {
+		return fmt.Errorf("error frame: %s: %s", evt.Error.Error, evt.Error.Message)
+	}
+
+	sm.sizes = append(sm.sizes, len(msg))
+	sm.types[streamEventType(&evt)]++
+
+	if seq := evt.Sequence(); seq > 0 {
+		if sm.firstSeq == 0 {
+			sm.firstSeq = seq
+		}
+		sm.lastSeq = seq
+	}
+
+	if evt.RepoCommit != nil {
+		for _, op := range evt.RepoCommit.Ops {
+			coll, _, _ := strings.Cut(op.Path, "/")
+			sm.collections[coll]++
+		}
+	}
+
+	if ts := streamEventTime(&evt); !ts.IsZero() {
+		sm.lags = append(sm.lags, time.Since(ts))
+	}
+
+	return nil
+}
+
+type streamCount struct {
+	Name  string  `json:"name"`
+	Count int     `json:"count"`
+	Rate  float64 `json:"perSec"`
+}
+
+type streamMetricsReport struct {
+	Time        time.Time     `json:"time"`
+	Window      string        `json:"window"`
+	Events      int           `json:"events"`
+	Rate        float64       `json:"perSec"`
+	Seq         int64         `json:"seq"`
+	SeqAdvance  int64         `json:"seqAdvance"`
+	Types       []streamCount `json:"types"`
+	Collections []streamCount `json:"collections"`
+	LagP50      string        `json:"lagP50"`
+	LagP99      string        `json:"lagP99"`
+	LagMax      string        `json:"lagMax"`
+	SizeP50     int           `json:"sizeP50"`
+	SizeP90     int           `json:"sizeP90"`
+	SizeP99     int           `json:"sizeP99"`
+	SizeMax     int           `json:"sizeMax"`
+}
+
+func (sm *streamMetrics) report(top int) *streamMetricsReport {
+	window := time.Since(sm.start)
+	secs := window.Seconds()
+
+	rep := &streamMetricsReport{
+		Time:        time.Now(),
+		Window:      window.Round(time.Millisecond).String(),
+		Events:      len(sm.sizes),
+		Rate:        float64(len(sm.sizes)) / secs,
+		Seq:         sm.lastSeq,
+		Types:       topCounts(sm.types, 0, secs),
+		Collections: topCounts(sm.collections, top, secs),
+	}
+	if sm.firstSeq > 0 {
+		rep.SeqAdvance = sm.lastSeq - sm.firstSeq
+	}
+
+	sort.Ints(sm.sizes)
+	rep.SizeP50 = percentile(sm.sizes, 0.5)
+	rep.SizeP90 = percentile(sm.sizes, 0.9)
+	rep.SizeP99 = percentile(sm.sizes, 0.99)
+	rep.SizeMax = percentile(sm.sizes, 1)
+
+	sort.Slice(sm.lags, func(i, j int) bool { return sm.lags[i] < sm.lags[j] })
+	rep.LagP50 = percentile(sm.lags, 0.5).Round(time.Millisecond).String()
+	rep.LagP99 = percentile(sm.lags, 0.99).Round(time.Millisecond).String()
+	rep.LagMax = percentile(sm.lags, 1).Round(time.Millisecond).String()
+
+	sm.reset()
+	return rep
+}
+
+func (rep *streamMetricsReport) print() {
+	fmt.Printf("%s  events: %d (%.1f/s over %s)  seq: %d (+%d)\n", rep.Time.Format(time.RFC3339), rep.Events, rep.Rate, rep.Window, rep.Seq, rep.SeqAdvance)
+	fmt.Printf("\tlag: p50=%s p99=%s max=%s\n", rep.LagP50, rep.LagP99, rep.LagMax)
+	fmt.Printf("\tsize (bytes): p50=%d p90=%d p99=%d max=%d\n", rep.SizeP50, rep.SizeP90, rep.SizeP99, rep.SizeMax)
+	for _, c := range rep.Types {
+		fmt.Printf("\ttype %-12s %8d %10.1f/s\n", c.Name, c.Count, c.Rate)
+	}
+	for _, c := range rep.Collections {
+		fmt.Printf("\tcoll %-40s %8d %10.1f/s\n", c.Name, c.Count, c.Rate)
+	}
+}
+
+// sorts counts descending, keeping at most n entries (all entries if n is 0)
+func topCounts(m map[string]int, n int, secs float64) []streamCount {
+	out := make([]streamCount, 0, len(m))
+	for k, v := range m {
+		out = append(out, streamCount{Name: k, Count: v, Rate: float64(v) / secs})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Name < out[j].Name
+	})
+	if n > 0 && len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+// percentile of an already sorted slice; zero value if empty
+func percentile[T int | time.Duration](sorted []T, p float64) T {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(p * float64(len(sorted)-1))
+	return sorted[idx]
+}
+
+func streamEventType(evt *events.XRPCStreamEvent) string {
+	switch {
+	case evt.RepoCommit != nil:
+		return "commit"
+	case evt.RepoSync != nil:
+		return "sync"
+	case evt.RepoIdentity != nil:
+		return "identity"
+	case evt.RepoAccount != nil:
+		return "account"
+	case evt.RepoHandle != nil:
+		return "handle"
+	case evt.RepoMigrate != nil:
+		return "migrate"
+	case evt.RepoTombstone != nil:
+		return "tombstone"
+	case evt.RepoInfo != nil:
+		return "info"
+	default:
+		return "unknown"
+	}
+}
+
+// the server-side timestamp of the event, or zero time if it has none
+func streamEventTime(evt *events.XRPCStreamEvent) time.Time {
+	var s string
+	switch {
+	case evt.RepoCommit != nil:
+		s = evt.RepoCommit.Time
+	case evt.RepoSync != nil:
+		s = evt.RepoSync.Time
+	case evt.RepoIdentity != nil:
+		s = evt.RepoIdentity.Time
+	case evt.RepoAccount != nil:
+		s = evt.RepoAccount.Time
+	case evt.RepoHandle != nil:
+		s = evt.RepoHandle.Time
+	case evt.RepoMigrate != nil:
+		s = evt.RepoMigrate.Time
+	case evt.RepoTombstone != nil:
+		s = evt.RepoTombstone.Time
+	default:
+		return time.Time{}
+	}
+	t, err := syntax.ParseDatetimeLenient(s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t.Time()
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/events"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+)
+
+func serializeTestEvent(t *testing.T, evt *events.XRPCStreamEvent) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	if err := evt.Serialize(buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestStreamMetricsReport(t *testing.T) {
+	assert := assert.New(t)
+
+	root, err := cid.Decode("bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339Nano)
+	commit := func(seq int64, paths ...string) *events.XRPCStreamEvent {
+		var ops []*comatproto.SyncSubscribeRepos_RepoOp
+		for _, p := range paths {
+			ops = append(ops, &comatproto.SyncSubscribeRepos_RepoOp{Action: "create", Path: p})
+		}
+		return &events.XRPCStreamEvent{RepoCommit: &comatproto.SyncSubscribeRepos_Commit{
+			Seq:    seq,
+			Repo:   "did:example:abc",
+			Rev:    "3kabc",
+			Time:   ts,
+			Commit: lexutil.LexLink(root),
+			Ops:    ops,
+			Blocks: []byte{},
+		}}
+	}
+
+	sm := newStreamMetrics()
+	assert.NoError(sm.observe(serializeTestEvent(t, commit(10, "app.bsky.feed.post/1", "app.bsky.feed.like/1"))))
+	assert.NoError(sm.observe(serializeTestEvent(t, commit(11, "app.bsky.feed.like/2"))))
+	assert.NoError(sm.observe(serializeTestEvent(t, &events.XRPCStreamEvent{RepoIdentity: &comatproto.SyncSubscribeRepos_Identity{
+		Seq:  14,
+		Did:  "did:example:abc",
+		Time: ts,
+	}})))
+
+	rep := sm.report(1)
+	assert.Equal(3, rep.Events)
+	assert.Equal(int64(14), rep.Seq)
+	assert.Equal(int64(4), rep.SeqAdvance)
+	assert.Equal([]string{"commit", "identity"}, countNames(rep.Types))
+	assert.Equal(2, rep.Types[0].Count)
+	// only the top collection is reported
+	assert.Equal([]string{"app.bsky.feed.like"}, countNames(rep.Collections))
+	assert.Equal(2, rep.Collections[0].Count)
+	assert.Greater(rep.SizeMax, 0)
+	lag, err := time.ParseDuration(rep.LagP50)
+	assert.NoError(err)
+	assert.GreaterOrEqual(lag, time.Minute)
+
+	// reporting resets the window
+	rep = sm.report(1)
+	assert.Equal(0, rep.Events)
+	assert.Equal(int64(0), rep.SeqAdvance)
+	assert.Empty(rep.Types)
+
+	// error frames end the stream
+	errFrame := &events.XRPCStreamEvent{Error: &events.ErrorFrame{Error: "FutureCursor", Message: "cursor in the future"}}
+	assert.ErrorContains(sm.observe(serializeTestEvent(t, errFrame)), "FutureCursor")
+}
+
+func TestPercentile(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(0, percentile([]int{}, 0.5))
+	sorted := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	assert.Equal(5, percentile(sorted, 0.5))
+	assert.Equal(9, percentile(sorted, 0.9))
+	assert.Equal(10, percentile(sorted, 1))
+}
+
+func countNames(counts []streamCount) []string {
+	var out []string
+	for _, c := range counts {
+		out = append(out, c.Name)
+	}
+	return out
+}
@@ -3,14 +3,17 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"os/signal"
 	"runtime"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/bluesky-social/indigo/atproto/identity"
@@ -18,6 +21,10 @@ import (
 	"github.com/bluesky-social/indigo/atproto/syntax"
 	"github.com/bluesky-social/indigo/automod/capture"
 	"github.com/bluesky-social/indigo/automod/consumer"
+	"github.com/bluesky-social/indigo/automod/countstore"
+	"github.com/bluesky-social/indigo/automod/engine"
+	"github.com/bluesky-social/indigo/automod/flagstore"
+	"github.com/bluesky-social/indigo/xrpc"
 
 	"github.com/carlmjohnson/versioninfo"
 	_ "github.com/joho/godotenv/autoload"
@@ -104,6 +111,32 @@ func run(args []string) error {
 			Usage:   "file path of JSON file containing static sets",
 			EnvVars: []string{"HEPA_SETS_JSON_PATH"},
 		},
+		&cli.StringFlag{
+			Name:    "language-packs-json-path",
+			Usage:   "file path of JSON file containing per-language rule parameter packs",
+			EnvVars: []string{"HEPA_LANGUAGE_PACKS_JSON_PATH"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "remote-sets-url",
+			Usage:   "URL of a JSON document of sets to fetch periodically and merge with local sets",
+			EnvVars: []string{"HEPA_REMOTE_SETS_URLS"},
+		},
+		&cli.StringFlag{
+			Name:    "remote-sets-pubkey",
+			Usage:   "public key (did:key or multibase) which remote set documents must be signed with; signature is fetched from <url>.sig",
+			EnvVars: []string{"HEPA_REMOTE_SETS_PUBKEY"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "ozone-set",
+			Usage:   "name of an Ozone set to fetch periodically and merge with local sets",
+			EnvVars: []string{"HEPA_OZONE_SETS"},
+		},
+		&cli.DurationFlag{
+			Name:    "remote-sets-interval",
+			Usage:   "how often to re-fetch remote and Ozone sets",
+			Value:   5 * time.Minute,
+			EnvVars: []string{"HEPA_REMOTE_SETS_INTERVAL"},
+		},
 		&cli.StringFlag{
 			Name:    "hiveai-api-token",
 			Usage:   "API token for Hive AI image auto-labeling",
@@ -124,6 +157,17 @@ func run(args []string) error {
 			Usage:   "which ruleset config to use: default, no-blobs, only-blobs",
 			EnvVars: []string{"HEPA_RULESET"},
 		},
+		&cli.StringSliceFlag{
+			Name:    "rule-plugin",
+			Usage:   "external rule server (plugin), as 'name=host:port'; events are sent to it over gRPC, in addition to the ruleset (may be repeated)",
+			EnvVars: []string{"HEPA_RULE_PLUGINS"},
+		},
+		&cli.DurationFlag{
+			Name:    "rule-plugin-timeout",
+			Usage:   "how long to wait for a rule plugin's response to each event, before processing the event without it",
+			Value:   2 * time.Second,
+			EnvVars: []string{"HEPA_RULE_PLUGIN_TIMEOUT"},
+		},
 		&cli.StringFlag{
 			Name:    "log-level",
 			Usage:   "log verbosity level (eg: warn, info, debug)",
@@ -180,6 +224,10 @@ func run(args []string) error {
 		processRecordCmd,
 		processRecentCmd,
 		captureRecentCmd,
+		replayCmd,
+		dumpReplayLogCmd,
+		exportStateCmd,
+		importStateCmd,
 	}
 
 	return app.Run(args)
@@ -194,6 +242,7 @@ func configDirectory(cctx *cli.Context) (identity.Directory, error) {
 		PLCLimiter:            rate.NewLimiter(rate.Limit(cctx.Int("plc-rate-limit")), 1),
 		TryAuthoritativeDNS:   true,
 		SkipDNSDomainSuffixes: []string{".bsky.social", ".staging.bsky.dev"},
+		Observer:              identity.MetricsObserver{},
 	}
 	var dir identity.Directory
 	if cctx.String("redis-url") != "" {
@@ -246,17 +295,151 @@ var runCmd = &cli.Command{
 			Usage:   "full URL of slack webhook",
 			EnvVars: []string{"SLACK_WEBHOOK_URL"},
 		},
+		&cli.StringSliceFlag{
+			Name:    "mute-rainbow-host",
+			Usage:   "base URL of a rainbow instance to propagate account mutes to, using its admin API (may be repeated)",
+			EnvVars: []string{"HEPA_MUTE_RAINBOW_HOSTS"},
+		},
+		&cli.StringFlag{
+			Name:    "mute-rainbow-token",
+			Usage:   "admin token for the rainbow instances which account mutes are propagated to",
+			EnvVars: []string{"HEPA_MUTE_RAINBOW_TOKEN"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "flag-policy",
+			Usage:   "expiration policy for a flag, as <flag>:<ttl|decay>:<duration> (eg, spam-suspect:decay:720h)",
+			EnvVars: []string{"HEPA_FLAG_POLICIES"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "escalation-ladder",
+			Usage:   "escalation ladder which rules can report offenses against, as <name>=<step>,<step>,... (eg, spam=flag:spam-suspect,label:spam,report:spam,takedown)",
+			EnvVars: []string{"HEPA_ESCALATION_LADDERS"},
+		},
+		&cli.DurationFlag{
+			Name:    "rule-max-duration",
+			Usage:   "default maximum wall time for a single rule execution; external lookups after this are skipped. zero for no limit",
+			EnvVars: []string{"HEPA_RULE_MAX_DURATION"},
+		},
+		&cli.IntFlag{
+			Name:    "rule-max-external-calls",
+			Usage:   "default maximum number of external lookups (counters, sets, account metadata, etc) in a single rule execution. zero for no limit",
+			EnvVars: []string{"HEPA_RULE_MAX_EXTERNAL_CALLS"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "rule-budget",
+			Usage:   "resource budget for a specific rule, overriding the defaults, as <rule>=time:<duration>,calls:<count> (eg, rules.BadHashtagsPostRule=time:200ms,calls:5)",
+			EnvVars: []string{"HEPA_RULE_BUDGETS"},
+		},
+		&cli.DurationFlag{
+			Name:    "flag-sweep-interval",
+			Usage:   "how often to sweep for expired flags (if any flag policies are configured)",
+			Value:   1 * time.Hour,
+			EnvVars: []string{"HEPA_FLAG_SWEEP_INTERVAL"},
+		},
+		&cli.DurationFlag{
+			Name:    "outbox-interval",
+			Usage:   "how often to retry moderation actions which previously failed to persist (if the mod service is configured)",
+			Value:   1 * time.Minute,
+			EnvVars: []string{"HEPA_OUTBOX_INTERVAL"},
+		},
+		&cli.DurationFlag{
+			Name:    "interaction-graph-window",
+			Usage:   "time period for which interaction graph edges (replies, mentions, reposts) are retained for coordination detection",
+			Value:   24 * time.Hour,
+			EnvVars: []string{"HEPA_INTERACTION_GRAPH_WINDOW"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "canary",
+			Usage:   "DID or AT-URI of a honeypot account or record; any interaction with it is tracked as a spam signal (may be repeated)",
+			EnvVars: []string{"HEPA_CANARIES"},
+		},
+		&cli.DurationFlag{
+			Name:    "canary-window",
+			Usage:   "time period for which interactions with canary accounts and records are retained",
+			Value:   30 * 24 * time.Hour,
+			EnvVars: []string{"HEPA_CANARY_WINDOW"},
+		},
+		&cli.Float64Flag{
+			Name:    "baseline-alpha",
+			Usage:   "smoothing factor (0 to 1) for adaptive hourly rate baselines: the weight given to each new hour, versus history",
+			Value:   0.1,
+			EnvVars: []string{"HEPA_BASELINE_ALPHA"},
+		},
+		&cli.StringFlag{
+			Name:    "signup-signals-key",
+			Usage:   "secret key for hashing account email domains. if set (and admin auth is configured), email domain hashes and invite tree position are included in private account metadata",
+			EnvVars: []string{"HEPA_SIGNUP_SIGNALS_KEY"},
+		},
+		&cli.BoolFlag{
+			Name:    "collection-stats",
+			Usage:   "tally firehose ops by collection, PDS host, and op type (metrics, and report at /collections on the metrics listener)",
+			EnvVars: []string{"HEPA_COLLECTION_STATS"},
+		},
+		&cli.DurationFlag{
+			Name:    "event-age-sla",
+			Usage:   "alert (via slack webhook, if configured) when the p99 age of firehose events exceeds this, per minute. zero disables alerting; ages are always exported as metrics",
+			EnvVars: []string{"HEPA_EVENT_AGE_SLA"},
+		},
+		&cli.DurationFlag{
+			Name:    "idempotency-ttl",
+			Usage:   "if set (and redis is configured), remember handled firehose events for this long, and skip them if re-delivered. zero disables",
+			EnvVars: []string{"HEPA_IDEMPOTENCY_TTL"},
+		}, &cli.StringFlag{
+			Name:    "replay-log",
+			Usage:   "append the inputs (counters and sets read, event hashes) of every rule execution resulting in actions to this file, for incident investigation. see 'dump-replay-log'",
+			EnvVars: []string{"HEPA_REPLAY_LOG"},
+		},
+		&cli.IntFlag{
+			Name:    "breaker-failures",
+			Usage:   "consecutive failed requests to a host (PDS, ozone, etc; network errors or 5xx responses) after which further requests to it fail fast for a while (0 to disable)",
+			EnvVars: []string{"HEPA_BREAKER_FAILURES"},
+		},
+		&cli.DurationFlag{
+			Name:    "breaker-timeout",
+			Usage:   "how long requests to a failing host fail fast for, before a probe request is allowed",
+			EnvVars: []string{"HEPA_BREAKER_TIMEOUT"},
+			Value:   30 * time.Second,
+		},
 	},
 	Action: func(cctx *cli.Context) error {
 		ctx := context.Background()
 		logger := configLogger(cctx, os.Stdout)
 		configOTEL("hepa")
 
+		if n := cctx.Int("breaker-failures"); n > 0 {
+			xrpc.SetDefaultBreakers(xrpc.NewBreakers(xrpc.BreakerConfig{
+				FailureThreshold: n,
+				OpenTimeout:      cctx.Duration("breaker-timeout"),
+				OnStateChange: func(host string, from, to xrpc.BreakerState) {
+					logger.Info("circuit breaker state changed", "host", host, "from", from, "to", to)
+				},
+			}))
+		}
+
 		dir, err := configDirectory(cctx)
 		if err != nil {
 			return fmt.Errorf("failed to configure identity directory: %v", err)
 		}
 
+		flagPolicies, err := flagstore.ParseFlagPolicies(cctx.StringSlice("flag-policy"))
+		if err != nil {
+			return err
+		}
+
+		ladders, err := engine.ParseLadders(cctx.StringSlice("escalation-ladder"))
+		if err != nil {
+			return err
+		}
+
+		ruleBudgets, err := engine.ParseRuleBudgets(cctx.StringSlice("rule-budget"))
+		if err != nil {
+			return err
+		}
+		defaultRuleBudget := engine.RuleBudget{
+			MaxDuration:      cctx.Duration("rule-max-duration"),
+			MaxExternalCalls: cctx.Int("rule-max-external-calls"),
+		}
+
 		srv, err := NewServer(
 			dir,
 			Config{
@@ -269,13 +452,21 @@ var runCmd = &cli.Command{
 				PDSHost:             cctx.String("atp-pds-host"),
 				PDSAdminToken:       cctx.String("pds-admin-token"),
 				SetsFileJSON:        cctx.String("sets-json-path"),
+				LanguagePacksJSON:   cctx.String("language-packs-json-path"),
+				RemoteSetURLs:       cctx.StringSlice("remote-sets-url"),
+				RemoteSetsPublicKey: cctx.String("remote-sets-pubkey"),
+				OzoneSetNames:       cctx.StringSlice("ozone-set"),
 				RedisURL:            cctx.String("redis-url"),
 				SlackWebhookURL:     cctx.String("slack-webhook-url"),
+				MuteRainbowHosts:    cctx.StringSlice("mute-rainbow-host"),
+				MuteRainbowToken:    cctx.String("mute-rainbow-token"),
 				HiveAPIToken:        cctx.String("hiveai-api-token"),
 				AbyssHost:           cctx.String("abyss-host"),
 				AbyssPassword:       cctx.String("abyss-password"),
 				RatelimitBypass:     cctx.String("ratelimit-bypass"),
 				RulesetName:         cctx.String("ruleset"),
+				RulePlugins:         cctx.StringSlice("rule-plugin"),
+				RulePluginTimeout:   cctx.Duration("rule-plugin-timeout"),
 				FirehoseParallelism: cctx.Int("firehose-parallelism"), // DEPRECATED
 				PreScreenHost:       cctx.String("prescreen-host"),
 				PreScreenToken:      cctx.String("prescreen-token"),
@@ -283,12 +474,39 @@ var runCmd = &cli.Command{
 				QuotaModReportDay:   cctx.Int("quota-mod-report-day"),
 				QuotaModTakedownDay: cctx.Int("quota-mod-takedown-day"),
 				QuotaModActionDay:   cctx.Int("quota-mod-action-day"),
+				FlagPolicies:        flagPolicies,
+				Ladders:             ladders,
+				DefaultRuleBudget:   defaultRuleBudget,
+				RuleBudgets:         ruleBudgets,
+				GraphWindow:         cctx.Duration("interaction-graph-window"),
+				Canaries:            cctx.StringSlice("canary"),
+				CanaryWindow:        cctx.Duration("canary-window"),
+				BaselineAlpha:       cctx.Float64("baseline-alpha"),
+				SignupSignalsKey:    cctx.String("signup-signals-key"),
+				CollectionStats:     cctx.Bool("collection-stats"),
+				EventAgeSLA:         cctx.Duration("event-age-sla"),
+				ReplayLogPath:       cctx.String("replay-log"),
 			},
 		)
 		if err != nil {
 			return fmt.Errorf("failed to construct server: %v", err)
 		}
 
+		// expire flags (if configured)
+		if len(flagPolicies) > 0 {
+			go srv.Engine.RunFlagSweeper(ctx, cctx.Duration("flag-sweep-interval"))
+		}
+
+		// retry failed moderation actions (if mod service is configured)
+		if srv.Engine.OzoneClient != nil {
+			go srv.Engine.RunOutbox(ctx, cctx.Duration("outbox-interval"))
+		}
+
+		// refresh remote sets (if configured)
+		if srv.RemoteSets != nil {
+			go srv.RemoteSets.Run(ctx, cctx.Duration("remote-sets-interval"))
+		}
+
 		// ozone event consumer (if configured)
 		if srv.Engine.OzoneClient != nil {
 			oc := consumer.OzoneConsumer{
@@ -330,6 +548,15 @@ var runCmd = &cli.Command{
 				Host:        cctx.String("atp-relay-host"),
 				Parallelism: cctx.Int("firehose-parallelism"),
 				RedisClient: srv.RedisClient,
+				// nil unless enabled
+				CollectionStats: srv.CollectionStats,
+				AgeWatchdog:     srv.AgeWatchdog,
+			}
+			if ttl := cctx.Duration("idempotency-ttl"); ttl > 0 && srv.RedisClient != nil {
+				fc.Idempotency = &consumer.RedisIdempotencyStore{
+					Client: srv.RedisClient,
+					TTL:    ttl,
+				}
 			}
 
 			go func() {
@@ -369,12 +596,15 @@ func configEphemeralServer(cctx *cli.Context) (*Server, error) {
 			PDSHost:             cctx.String("atp-pds-host"),
 			PDSAdminToken:       cctx.String("pds-admin-token"),
 			SetsFileJSON:        cctx.String("sets-json-path"),
+			LanguagePacksJSON:   cctx.String("language-packs-json-path"),
 			RedisURL:            cctx.String("redis-url"),
 			HiveAPIToken:        cctx.String("hiveai-api-token"),
 			AbyssHost:           cctx.String("abyss-host"),
 			AbyssPassword:       cctx.String("abyss-password"),
 			RatelimitBypass:     cctx.String("ratelimit-bypass"),
 			RulesetName:         cctx.String("ruleset"),
+			RulePlugins:         cctx.StringSlice("rule-plugin"),
+			RulePluginTimeout:   cctx.Duration("rule-plugin-timeout"),
 			FirehoseParallelism: cctx.Int("firehose-parallelism"),
 			PreScreenHost:       cctx.String("prescreen-host"),
 			PreScreenToken:      cctx.String("prescreen-token"),
@@ -479,3 +709,340 @@ var captureRecentCmd = &cli.Command{
 		return nil
 	},
 }
+
+var replayCmd = &cli.Command{
+	Name:  "replay",
+	Usage: "reprocess a historical range of firehose events through the current rules (dry-run unless --enforce)",
+	Description: `Events are read from the relay (or rainbow) at --atp-relay-host, which must still have the range in its persistence, or from a capture file of raw firehose frames.
+
+In dry-run mode, rule effects are logged but nothing is persisted. With --enforce, moderation actions, flags, and counters are all persisted, as if the events were live; counters will include events which were already counted the first time around.
+
+Per-rule fire counts are printed to stdout at the end.`,
+	Flags: []cli.Flag{
+		&cli.Int64Flag{
+			Name:     "first-seq",
+			Usage:    "sequence number of the first event to process",
+			Required: true,
+		},
+		&cli.Int64Flag{
+			Name:  "last-seq",
+			Usage: "sequence number of the last event to process (inclusive). required unless replaying a capture file, in which case the default is the end of the file",
+		},
+		&cli.StringFlag{
+			Name:  "capture-file",
+			Usage: "read events from this file of raw firehose frames (eg, saved with 'websocat -b'), instead of the relay",
+		},
+		&cli.BoolFlag{
+			Name:  "enforce",
+			Usage: "persist moderation actions, flags, and counters (by default, effects are only logged)",
+		},
+		&cli.StringFlag{
+			Name:  "replay-log",
+			Usage: "append replay log entries for rules which fire to this file (as with 'run --replay-log')",
+		},
+		&cli.IntFlag{
+			Name:  "parallelism",
+			Usage: "number of events processed concurrently (events for the same account are processed in order)",
+			Value: 10,
+		},
+		&cli.StringSliceFlag{
+			Name:    "flag-policy",
+			Usage:   "expiration policy for a flag, as <flag>:<ttl|decay>:<duration> (eg, spam-suspect:decay:720h)",
+			EnvVars: []string{"HEPA_FLAG_POLICIES"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "escalation-ladder",
+			Usage:   "escalation ladder which rules can report offenses against, as <name>=<step>,<step>,... (eg, spam=flag:spam-suspect,label:spam,report:spam,takedown)",
+			EnvVars: []string{"HEPA_ESCALATION_LADDERS"},
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer cancel()
+
+		captureFile := cctx.String("capture-file")
+		if captureFile == "" && cctx.Int64("last-seq") == 0 {
+			return fmt.Errorf("--last-seq is required when replaying from a relay")
+		}
+
+		flagPolicies, err := flagstore.ParseFlagPolicies(cctx.StringSlice("flag-policy"))
+		if err != nil {
+			return err
+		}
+		ladders, err := engine.ParseLadders(cctx.StringSlice("escalation-ladder"))
+		if err != nil {
+			return err
+		}
+
+		srv, err := configEphemeralServer(cctx)
+		if err != nil {
+			return err
+		}
+		srv.Engine.Config.FlagPolicies = flagPolicies
+		srv.Engine.Config.Ladders = ladders
+		srv.Engine.Config.DryRun = !cctx.Bool("enforce")
+		if p := cctx.String("replay-log"); p != "" {
+			srv.Engine.ReplayLog, err = engine.OpenReplayLog(p)
+			if err != nil {
+				return err
+			}
+			defer srv.Engine.ReplayLog.Close()
+		}
+
+		fr := consumer.FirehoseReplay{
+			Engine:      srv.Engine,
+			Logger:      srv.logger.With("subsystem", "replay", "dryRun", srv.Engine.Config.DryRun),
+			Parallelism: cctx.Int("parallelism"),
+			FirstSeq:    cctx.Int64("first-seq"),
+			LastSeq:     cctx.Int64("last-seq"),
+		}
+		var stats *consumer.ReplayStats
+		if captureFile != "" {
+			f, ferr := os.Open(captureFile)
+			if ferr != nil {
+				return ferr
+			}
+			defer f.Close()
+			stats, err = fr.ReplayFile(ctx, f)
+		} else {
+			stats, err = fr.ReplayHost(ctx, cctx.String("atp-relay-host"))
+		}
+		if stats != nil {
+			srv.logger.Info("replay finished", "events", stats.Events, "skipped", stats.Skipped, "firstSeq", stats.FirstSeq, "lastSeq", stats.LastSeq, "dryRun", srv.Engine.Config.DryRun)
+		}
+		if err != nil {
+			return fmt.Errorf("replay failed: %w", err)
+		}
+
+		out := struct {
+			*consumer.ReplayStats
+			DryRun bool                   `json:"dryRun"`
+			Rules  []engine.RuleFireStats `json:"rules"`
+		}{
+			ReplayStats: stats,
+			DryRun:      srv.Engine.Config.DryRun,
+			Rules:       srv.Engine.Stats.Rules(),
+		}
+		outJSON, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(outJSON))
+		return nil
+	},
+}
+
+var dumpReplayLogCmd = &cli.Command{
+	Name:      "dump-replay-log",
+	Usage:     "print entries from a replay log (see 'run --replay-log') as JSON lines",
+	ArgsUsage: `<file>`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "rule",
+			Usage: "only print entries for this rule",
+		},
+		&cli.StringFlag{
+			Name:  "subject",
+			Usage: "only print entries for this subject (DID, AT-URI, or ozone event ID)",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		if cctx.Args().Len() != 1 {
+			return fmt.Errorf("expected a single replay log file argument")
+		}
+		f, err := os.Open(cctx.Args().First())
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		rr := engine.NewReplayLogReader(f)
+		enc := json.NewEncoder(os.Stdout)
+		for {
+			entry, err := rr.Next()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if rule := cctx.String("rule"); rule != "" && entry.Rule != rule {
+				continue
+			}
+			if subj := cctx.String("subject"); subj != "" && entry.Subject != subj {
+				continue
+			}
+			if err := enc.Encode(entry); err != nil {
+				return err
+			}
+		}
+	},
+}
+
+var exportStateCmd = &cli.Command{
+	Name:  "export-state",
+	Usage: "back up automod counters and flags from redis (--redis-url) to JSON lines snapshot files",
+	Description: `Snapshots are written in batches, each followed by a resume marker. If an export is interrupted, running it again with --resume continues from the last marker in each file.
+
+Counters modified during the export may or may not be included.`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "counters-file",
+			Usage: "write the counter snapshot to this file",
+		},
+		&cli.StringFlag{
+			Name:  "flags-file",
+			Usage: "write the flag snapshot to this file",
+		},
+		&cli.BoolFlag{
+			Name:  "resume",
+			Usage: "continue interrupted exports to the files, instead of overwriting them",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer cancel()
+		logger := configLogger(cctx, os.Stdout)
+
+		redisURL := cctx.String("redis-url")
+		if redisURL == "" {
+			return fmt.Errorf("--redis-url is required")
+		}
+		countersPath, flagsPath := cctx.String("counters-file"), cctx.String("flags-file")
+		if countersPath == "" && flagsPath == "" {
+			return fmt.Errorf("at least one of --counters-file and --flags-file is required")
+		}
+
+		if countersPath != "" {
+			cs, err := countstore.NewRedisCountStore(redisURL)
+			if err != nil {
+				return fmt.Errorf("initializing redis countstore: %v", err)
+			}
+			err = exportSnapshotFile(countersPath, cctx.Bool("resume"), countstore.LastSnapshotMarker, func(w io.Writer, marker string) error {
+				logger.Info("exporting counters", "file", countersPath, "marker", marker)
+				return countstore.WriteSnapshot(ctx, cs, w, marker)
+			})
+			if err != nil {
+				return fmt.Errorf("exporting counters: %w", err)
+			}
+		}
+		if flagsPath != "" {
+			fs, err := flagstore.NewRedisFlagStore(redisURL)
+			if err != nil {
+				return fmt.Errorf("initializing redis flagstore: %v", err)
+			}
+			err = exportSnapshotFile(flagsPath, cctx.Bool("resume"), flagstore.LastSnapshotMarker, func(w io.Writer, marker string) error {
+				logger.Info("exporting flags", "file", flagsPath, "marker", marker)
+				return flagstore.WriteSnapshot(ctx, fs, w, marker)
+			})
+			if err != nil {
+				return fmt.Errorf("exporting flags: %w", err)
+			}
+		}
+		logger.Info("export complete")
+		return nil
+	},
+}
+
+// exportSnapshotFile opens a snapshot file for writing. When resuming, any
+// entries after the last marker are truncated, and the export continues from
+// that marker.
+func exportSnapshotFile(path string, resume bool, lastMarker func(io.Reader) (string, int64, error), export func(w io.Writer, marker string) error) error {
+	if !resume {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := export(f, ""); err != nil {
+			return err
+		}
+		return f.Close()
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	marker, off, err := lastMarker(f)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(off); err != nil {
+		return err
+	}
+	if _, err := f.Seek(off, io.SeekStart); err != nil {
+		return err
+	}
+	if err := export(f, marker); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+var importStateCmd = &cli.Command{
+	Name:  "import-state",
+	Usage: "restore automod counters and flags from snapshot files (see 'export-state') in to redis (--redis-url)",
+	Description: `Imported counters and flags overwrite any existing values for the same keys; other keys are left alone. Importing the same snapshot more than once is harmless, so an interrupted import can simply be re-run.
+
+Distinct counters exported from redis can only be imported in to redis.`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "counters-file",
+			Usage: "read the counter snapshot from this file",
+		},
+		&cli.StringFlag{
+			Name:  "flags-file",
+			Usage: "read the flag snapshot from this file",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer cancel()
+		logger := configLogger(cctx, os.Stdout)
+
+		redisURL := cctx.String("redis-url")
+		if redisURL == "" {
+			return fmt.Errorf("--redis-url is required")
+		}
+		countersPath, flagsPath := cctx.String("counters-file"), cctx.String("flags-file")
+		if countersPath == "" && flagsPath == "" {
+			return fmt.Errorf("at least one of --counters-file and --flags-file is required")
+		}
+
+		if countersPath != "" {
+			cs, err := countstore.NewRedisCountStore(redisURL)
+			if err != nil {
+				return fmt.Errorf("initializing redis countstore: %v", err)
+			}
+			f, err := os.Open(countersPath)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			n, err := countstore.ReadSnapshot(ctx, cs, f)
+			logger.Info("imported counters", "file", countersPath, "count", n)
+			if err != nil {
+				return err
+			}
+		}
+		if flagsPath != "" {
+			fs, err := flagstore.NewRedisFlagStore(redisURL)
+			if err != nil {
+				return fmt.Errorf("initializing redis flagstore: %v", err)
+			}
+			f, err := os.Open(flagsPath)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			n, err := flagstore.ReadSnapshot(ctx, fs, f)
+			logger.Info("imported flags", "file", flagsPath, "count", n)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
@@ -2,23 +2,32 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/bluesky-social/indigo/atproto/crypto"
 	"github.com/bluesky-social/indigo/atproto/identity"
 	"github.com/bluesky-social/indigo/atproto/syntax"
 	"github.com/bluesky-social/indigo/automod"
+	"github.com/bluesky-social/indigo/automod/baselinestore"
 	"github.com/bluesky-social/indigo/automod/cachestore"
+	"github.com/bluesky-social/indigo/automod/canarystore"
 	"github.com/bluesky-social/indigo/automod/countstore"
 	"github.com/bluesky-social/indigo/automod/engine"
 	"github.com/bluesky-social/indigo/automod/flagstore"
+	"github.com/bluesky-social/indigo/automod/graphstore"
+	"github.com/bluesky-social/indigo/automod/outboxstore"
+	"github.com/bluesky-social/indigo/automod/plugin"
 	"github.com/bluesky-social/indigo/automod/rules"
 	"github.com/bluesky-social/indigo/automod/setstore"
 	"github.com/bluesky-social/indigo/automod/visual"
+	"github.com/bluesky-social/indigo/events"
 	"github.com/bluesky-social/indigo/util"
 	"github.com/bluesky-social/indigo/xrpc"
 
@@ -29,6 +38,12 @@ import (
 type Server struct {
 	Engine      *automod.Engine
 	RedisClient *redis.Client
+	// nil unless remote set sources are configured
+	RemoteSets *setstore.RemoteSetStore
+	// nil unless collection stats are enabled
+	CollectionStats *events.CollectionStats
+	// measures firehose event age; always set
+	AgeWatchdog *events.AgeWatchdog
 
 	relayHost           string // DEPRECATED
 	firehoseParallelism int    // DEPRECATED
@@ -45,12 +60,20 @@ type Config struct {
 	PDSHost             string
 	PDSAdminToken       string
 	SetsFileJSON        string
+	LanguagePacksJSON   string
+	RemoteSetURLs       []string
+	RemoteSetsPublicKey string   // did:key or multibase; if set, remote set URLs must be signed
+	OzoneSetNames       []string // ozone sets to fetch, using the ozone admin client
 	RedisURL            string
 	SlackWebhookURL     string
+	MuteRainbowHosts    []string // rainbow instances to propagate account mutes to
+	MuteRainbowToken    string
 	HiveAPIToken        string
 	AbyssHost           string
 	AbyssPassword       string
 	RulesetName         string
+	RulePlugins         []string // external rule servers, as "name=host:port"
+	RulePluginTimeout   time.Duration
 	RatelimitBypass     string
 	FirehoseParallelism int // DEPRECATED
 	PreScreenHost       string
@@ -59,6 +82,18 @@ type Config struct {
 	QuotaModReportDay   int
 	QuotaModTakedownDay int
 	QuotaModActionDay   int
+	FlagPolicies        map[string]flagstore.FlagPolicy
+	Ladders             map[string]engine.EscalationLadder
+	DefaultRuleBudget   engine.RuleBudget
+	RuleBudgets         map[string]engine.RuleBudget
+	GraphWindow         time.Duration
+	Canaries            []string // DIDs or AT-URIs of honeypot accounts and records
+	CanaryWindow        time.Duration
+	BaselineAlpha       float64       // EWMA smoothing factor for hourly rate baselines; zero for the default
+	SignupSignalsKey    string        // secret for hashing email domains; enables signup signals in account metadata
+	CollectionStats     bool          // tally firehose ops by collection and PDS host
+	EventAgeSLA         time.Duration // alert (to slack, if configured) when p99 firehose event age exceeds this; zero disables
+	ReplayLogPath       string        // file to append replay log entries (inputs to rules which took actions) to
 }
 
 func NewServer(dir identity.Directory, config Config) (*Server, error) {
@@ -122,10 +157,77 @@ func NewServer(dir identity.Directory, config Config) (*Server, error) {
 		}
 	}
 
+	var setStore setstore.SetStore = sets
+	var remoteSets *setstore.RemoteSetStore
+	if len(config.RemoteSetURLs) > 0 || len(config.OzoneSetNames) > 0 {
+		var pubkey crypto.PublicKey
+		if config.RemoteSetsPublicKey != "" {
+			var err error
+			if strings.HasPrefix(config.RemoteSetsPublicKey, "did:key:") {
+				pubkey, err = crypto.ParsePublicDIDKey(config.RemoteSetsPublicKey)
+			} else {
+				pubkey, err = crypto.ParsePublicMultibase(config.RemoteSetsPublicKey)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("parsing remote sets public key: %v", err)
+			}
+		}
+		var sources []setstore.SetSource
+		for _, u := range config.RemoteSetURLs {
+			sources = append(sources, &setstore.URLSetSource{
+				URL:       u,
+				PublicKey: pubkey,
+				Client:    util.RobustHTTPClient(),
+			})
+		}
+		if len(config.OzoneSetNames) > 0 {
+			if ozoneClient == nil {
+				return nil, fmt.Errorf("ozone sets configured, but no ozone admin client")
+			}
+			sources = append(sources, &setstore.OzoneSetSource{
+				Client: ozoneClient,
+				Names:  config.OzoneSetNames,
+			})
+		}
+		remoteSets = setstore.NewRemoteSetStore(sets, sources)
+		remoteSets.Logger = logger.With("subsystem", "remote-sets")
+		// a failed initial fetch isn't fatal; local sets are still served, and the periodic refresh will retry
+		if err := remoteSets.Refresh(context.TODO()); err != nil {
+			logger.Warn("initial remote set fetch failed", "err", err)
+		}
+		setStore = remoteSets
+	}
+
+	var langPacks *engine.LanguagePacks
+	if config.LanguagePacksJSON != "" {
+		lp, err := engine.LoadLanguagePacksJSON(config.LanguagePacksJSON)
+		if err != nil {
+			return nil, fmt.Errorf("loading language packs: %v", err)
+		}
+		langPacks = lp
+		logger.Info("loaded language packs from JSON", "path", config.LanguagePacksJSON, "packs", len(lp.Packs))
+	}
+
 	var counters countstore.CountStore
 	var cache cachestore.CacheStore
 	var flags flagstore.FlagStore
+	var graph graphstore.GraphStore
+	var canaries canarystore.CanaryStore
+	var baselines baselinestore.BaselineStore
+	var outbox outboxstore.OutboxStore
 	var rdb *redis.Client
+	graphWindow := config.GraphWindow
+	if graphWindow == 0 {
+		graphWindow = graphstore.DefaultWindow
+	}
+	canaryWindow := config.CanaryWindow
+	if canaryWindow == 0 {
+		canaryWindow = canarystore.DefaultWindow
+	}
+	baselineAlpha := config.BaselineAlpha
+	if baselineAlpha == 0 {
+		baselineAlpha = baselinestore.DefaultAlpha
+	}
 	if config.RedisURL != "" {
 		// generic client, for cursor state
 		opt, err := redis.ParseURL(config.RedisURL)
@@ -156,10 +258,43 @@ func NewServer(dir identity.Directory, config Config) (*Server, error) {
 			return nil, fmt.Errorf("initializing redis flagstore: %v", err)
 		}
 		flags = flg
+
+		grs, err := graphstore.NewRedisGraphStore(config.RedisURL, graphWindow)
+		if err != nil {
+			return nil, fmt.Errorf("initializing redis graphstore: %v", err)
+		}
+		graph = grs
+
+		crs, err := canarystore.NewRedisCanaryStore(config.RedisURL, canaryWindow)
+		if err != nil {
+			return nil, fmt.Errorf("initializing redis canarystore: %v", err)
+		}
+		canaries = crs
+
+		rbs, err := baselinestore.NewRedisBaselineStore(config.RedisURL, baselineAlpha)
+		if err != nil {
+			return nil, fmt.Errorf("initializing redis baselinestore: %v", err)
+		}
+		baselines = rbs
+
+		obs, err := outboxstore.NewRedisOutboxStore(config.RedisURL)
+		if err != nil {
+			return nil, fmt.Errorf("initializing redis outboxstore: %v", err)
+		}
+		outbox = obs
 	} else {
 		counters = countstore.NewMemCountStore()
 		cache = cachestore.NewMemCacheStore(5_000, 1*time.Hour)
 		flags = flagstore.NewMemFlagStore()
+		graph = graphstore.NewMemGraphStore(graphWindow)
+		canaries = canarystore.NewMemCanaryStore(canaryWindow)
+		baselines = baselinestore.NewMemBaselineStore(baselineAlpha)
+		outbox = outboxstore.NewMemOutboxStore()
+	}
+	for _, subj := range config.Canaries {
+		if err := canaries.AddCanary(context.TODO(), subj); err != nil {
+			return nil, fmt.Errorf("adding canary %s: %v", subj, err)
+		}
 	}
 
 	// IMPORTANT: reminder that these are the indigo-edition rules, not production rules
@@ -194,6 +329,19 @@ func NewServer(dir identity.Directory, config Config) (*Server, error) {
 	default:
 		return nil, fmt.Errorf("unknown ruleset config: %s", config.RulesetName)
 	}
+	for _, spec := range config.RulePlugins {
+		name, target, ok := strings.Cut(spec, "=")
+		if !ok || name == "" || target == "" {
+			return nil, fmt.Errorf("invalid rule plugin (expected name=host:port): %s", spec)
+		}
+		pc, err := plugin.NewClient(name, target, config.RulePluginTimeout)
+		if err != nil {
+			return nil, err
+		}
+		pc.Logger = logger.With("system", "rule-plugin", "plugin", name)
+		pc.AddRules(&ruleset)
+		logger.Info("configured rule plugin", "plugin", name, "target", target)
+	}
 
 	var notifier automod.Notifier
 	if config.SlackWebhookURL != "" {
@@ -202,6 +350,15 @@ func NewServer(dir identity.Directory, config Config) (*Server, error) {
 		}
 	}
 
+	var mutes automod.MuteSink
+	if len(config.MuteRainbowHosts) > 0 {
+		mutes = &automod.RainbowMuteSink{
+			Hosts:      config.MuteRainbowHosts,
+			AdminToken: config.MuteRainbowToken,
+			Client:     util.RobustHTTPClient(),
+		}
+	}
+
 	bskyClient := xrpc.Client{
 		Client: util.RobustHTTPClient(),
 		Host:   config.BskyHost,
@@ -210,25 +367,47 @@ func NewServer(dir identity.Directory, config Config) (*Server, error) {
 		bskyClient.Headers = make(map[string]string)
 		bskyClient.Headers["x-ratelimit-bypass"] = config.RatelimitBypass
 	}
+	var replayLog *engine.ReplayLog
+	if config.ReplayLogPath != "" {
+		var err error
+		replayLog, err = engine.OpenReplayLog(config.ReplayLogPath)
+		if err != nil {
+			return nil, fmt.Errorf("opening replay log: %v", err)
+		}
+	}
+
 	blobClient := util.RobustHTTPClient()
 	engine := automod.Engine{
-		Logger:      logger,
-		Directory:   dir,
-		Counters:    counters,
-		Sets:        sets,
-		Flags:       flags,
-		Cache:       cache,
-		Rules:       ruleset,
-		Notifier:    notifier,
-		BskyClient:  &bskyClient,
-		OzoneClient: ozoneClient,
-		AdminClient: adminClient,
-		BlobClient:  blobClient,
+		Logger:        logger,
+		Directory:     dir,
+		Counters:      counters,
+		Sets:          setStore,
+		Flags:         flags,
+		Graph:         graph,
+		Canaries:      canaries,
+		Baselines:     baselines,
+		Outbox:        outbox,
+		LanguagePacks: langPacks,
+		Cache:         cache,
+		Rules:         ruleset,
+		Notifier:      notifier,
+		Mutes:         mutes,
+		BskyClient:    &bskyClient,
+		OzoneClient:   ozoneClient,
+		AdminClient:   adminClient,
+		BlobClient:    blobClient,
+		Stats:         engine.NewRuleStats(),
+		ReplayLog:     replayLog,
 		Config: engine.EngineConfig{
 			ReportDupePeriod:    config.ReportDupePeriod,
 			QuotaModReportDay:   config.QuotaModReportDay,
 			QuotaModTakedownDay: config.QuotaModTakedownDay,
 			QuotaModActionDay:   config.QuotaModActionDay,
+			FlagPolicies:        config.FlagPolicies,
+			SignupSignalsKey:    []byte(config.SignupSignalsKey),
+			Ladders:             config.Ladders,
+			DefaultRuleBudget:   config.DefaultRuleBudget,
+			RuleBudgets:         config.RuleBudgets,
 		},
 	}
 
@@ -238,6 +417,15 @@ func NewServer(dir identity.Directory, config Config) (*Server, error) {
 		logger:              logger,
 		Engine:              &engine,
 		RedisClient:         rdb,
+		RemoteSets:          remoteSets,
+	}
+	if config.CollectionStats {
+		s.CollectionStats = events.NewCollectionStats(nil)
+	}
+	s.AgeWatchdog = events.NewAgeWatchdog("hepa", config.EventAgeSLA, nil)
+	s.AgeWatchdog.Logger = logger
+	if config.SlackWebhookURL != "" {
+		s.AgeWatchdog.OnAlert = events.NewWebhookAlerter(config.SlackWebhookURL, logger)
 	}
 
 	return s, nil
@@ -245,5 +433,34 @@ func NewServer(dir identity.Directory, config Config) (*Server, error) {
 
 func (s *Server) RunMetrics(listen string) error {
 	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc("/dashboard", s.HandleDashboard)
+	if s.CollectionStats != nil {
+		http.Handle("/collections", s.CollectionStats)
+	}
 	return http.ListenAndServe(listen, nil)
 }
+
+// HandleDashboard returns JSON with per-rule fire rates, top flagged accounts, action quota consumption, and recent actions. The optional "limit" query parameter bounds the flagged and recent action lists.
+func (s *Server) HandleDashboard(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, 500)
+	}
+
+	snap, err := s.Engine.Dashboard(r.Context(), limit)
+	if err != nil {
+		s.logger.Error("failed to build dashboard", "err", err)
+		http.Error(w, "failed to build dashboard", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(snap); err != nil {
+		s.logger.Error("failed to write dashboard response", "err", err)
+	}
+}
@@ -1,10 +1,19 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/bluesky-social/indigo/api"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/identity/handlepolicy"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/automod/keyword"
 	"github.com/bluesky-social/indigo/carstore"
 	"github.com/bluesky-social/indigo/pds"
 	"github.com/bluesky-social/indigo/plc"
@@ -82,6 +91,274 @@ func run(args []string) {
 			Value:   ".test",
 			EnvVars: []string{"ATP_PDS_HANDLE_DOMAINS"},
 		},
+		&cli.StringSliceFlag{
+			Name:    "invite-required-domains",
+			Usage:   "handle domain suffixes which require an invite code to create an account",
+			EnvVars: []string{"ATP_PDS_INVITE_REQUIRED_DOMAINS"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "reserved-handle-prefixes",
+			Usage:   "handle prefixes which can not be registered, for all domains or as <suffix>:<prefix>",
+			EnvVars: []string{"ATP_PDS_RESERVED_HANDLE_PREFIXES"},
+		},
+		&cli.StringFlag{
+			Name:    "email-templates-dir",
+			Usage:   "optional directory of email template overrides, laid out as <lang>/<kind>.tmpl",
+			EnvVars: []string{"ATP_PDS_EMAIL_TEMPLATES_DIR"},
+		},
+		&cli.StringFlag{
+			Name:    "admin-password",
+			Usage:   "password for admin HTTP endpoints (basic auth, user 'admin'); admin endpoints are disabled if not set",
+			EnvVars: []string{"ATP_PDS_ADMIN_PASSWORD"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "cors-allow-origins",
+			Usage:   "origins allowed to make cross-origin requests (default: any)",
+			EnvVars: []string{"ATP_PDS_CORS_ALLOW_ORIGINS"},
+		},
+		&cli.DurationFlag{
+			Name:    "hsts-max-age",
+			Usage:   "if set, send a Strict-Transport-Security header with this max-age",
+			EnvVars: []string{"ATP_PDS_HSTS_MAX_AGE"},
+		},
+		&cli.Int64Flag{
+			Name:    "max-body-size",
+			Usage:   "maximum request body size in bytes, for routes other than blob upload",
+			EnvVars: []string{"ATP_PDS_MAX_BODY_SIZE"},
+			Value:   1 << 20,
+		},
+		&cli.Int64Flag{
+			Name:    "max-blob-size",
+			Usage:   "maximum blob upload size in bytes",
+			EnvVars: []string{"ATP_PDS_MAX_BLOB_SIZE"},
+			Value:   50 << 20,
+		},
+		&cli.StringFlag{
+			Name:    "blob-dir",
+			Usage:   "directory of blobs (as <did>/<cid> files) to serve from com.atproto.sync.getBlob",
+			EnvVars: []string{"ATP_PDS_BLOB_DIR"},
+		},
+		&cli.StringFlag{
+			Name:    "blob-url",
+			Usage:   "base URL of remote blob storage (eg an S3 bucket), serving blobs at <url>/<did>/<cid>",
+			EnvVars: []string{"ATP_PDS_BLOB_URL"},
+		},
+		&cli.StringFlag{
+			Name:    "blob-cache-dir",
+			Usage:   "directory for a local cache of blobs from remote blob storage",
+			EnvVars: []string{"ATP_PDS_BLOB_CACHE_DIR"},
+		},
+		&cli.Int64Flag{
+			Name:    "blob-cache-max-bytes",
+			Usage:   "maximum total size of the blob cache",
+			EnvVars: []string{"ATP_PDS_BLOB_CACHE_MAX_BYTES"},
+			Value:   1 << 30,
+		},
+		&cli.BoolFlag{
+			Name:    "takeout",
+			Usage:   "enable account data export (take-out archives), stored under the data directory",
+			EnvVars: []string{"ATP_PDS_TAKEOUT"},
+		},
+		&cli.DurationFlag{
+			Name:    "takeout-ttl",
+			Usage:   "how long completed take-out archives remain available for download",
+			EnvVars: []string{"ATP_PDS_TAKEOUT_TTL"},
+			Value:   24 * time.Hour,
+		},
+		&cli.BoolFlag{
+			Name:    "quotas",
+			Usage:   "track per-account storage usage, and enforce the quota-* limits on writes",
+			EnvVars: []string{"ATP_PDS_QUOTAS"},
+		},
+		&cli.Int64Flag{
+			Name:    "quota-max-repo-bytes",
+			Usage:   "default per-account repo size limit in bytes (0 for unlimited)",
+			EnvVars: []string{"ATP_PDS_QUOTA_MAX_REPO_BYTES"},
+		},
+		&cli.Int64Flag{
+			Name:    "quota-max-blob-bytes",
+			Usage:   "default per-account limit on total size of referenced blobs in bytes (0 for unlimited)",
+			EnvVars: []string{"ATP_PDS_QUOTA_MAX_BLOB_BYTES"},
+		},
+		&cli.Int64Flag{
+			Name:    "quota-max-records",
+			Usage:   "default per-account record count limit (0 for unlimited)",
+			EnvVars: []string{"ATP_PDS_QUOTA_MAX_RECORDS"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "allowed-collections",
+			Usage:   "only accept records in these collections: NSIDs, or prefixes like app.bsky.* (default: all)",
+			EnvVars: []string{"ATP_PDS_ALLOWED_COLLECTIONS"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "denied-collections",
+			Usage:   "reject records in these collections: NSIDs, or prefixes like com.example.*",
+			EnvVars: []string{"ATP_PDS_DENIED_COLLECTIONS"},
+		},
+		&cli.BoolFlag{
+			Name:    "two-factor",
+			Usage:   "let accounts enroll in two-factor authentication (TOTP, or emailed sign-in codes) for session creation",
+			EnvVars: []string{"ATP_PDS_TWO_FACTOR"},
+		},
+		&cli.StringFlag{
+			Name:    "two-factor-issuer",
+			Usage:   "issuer name shown in authenticator apps (default: service URL host)",
+			EnvVars: []string{"ATP_PDS_TWO_FACTOR_ISSUER"},
+		},
+		&cli.StringFlag{
+			Name:    "two-factor-app-passwords",
+			Usage:   "whether app password sessions skip the second factor ('bypass') or need it too ('require')",
+			EnvVars: []string{"ATP_PDS_TWO_FACTOR_APP_PASSWORDS"},
+			Value:   string(pds.AppPasswordBypass),
+		},
+		&cli.BoolFlag{
+			Name:    "event-outbox",
+			Usage:   "record commits in a database outbox before writing them, so firehose events survive crashes mid-write",
+			EnvVars: []string{"ATP_PDS_EVENT_OUTBOX"},
+		},
+		&cli.StringFlag{
+			Name:    "relay-push-url",
+			Usage:   "connect out to this relay (eg wss://relay.example.com) and push events to it, for hosts without public ingress",
+			EnvVars: []string{"ATP_PDS_RELAY_PUSH_URL"},
+		},
+		&cli.StringFlag{
+			Name:    "relay-push-token",
+			Usage:   "push token for --relay-push-url, issued by the relay's admin",
+			EnvVars: []string{"ATP_PDS_RELAY_PUSH_TOKEN"},
+		},
+		&cli.IntFlag{
+			Name:    "signup-max-per-ip",
+			Usage:   "maximum accounts created from one client IP within --signup-ip-window (0 for no limit)",
+			EnvVars: []string{"ATP_PDS_SIGNUP_MAX_PER_IP"},
+		},
+		&cli.DurationFlag{
+			Name:    "signup-ip-window",
+			Usage:   "time window for --signup-max-per-ip",
+			EnvVars: []string{"ATP_PDS_SIGNUP_IP_WINDOW"},
+			Value:   24 * time.Hour,
+		},
+		&cli.IntFlag{
+			Name:    "signup-max-per-asn",
+			Usage:   "maximum accounts created from one autonomous system within --signup-asn-window (0 for no limit; needs --signup-asn-table)",
+			EnvVars: []string{"ATP_PDS_SIGNUP_MAX_PER_ASN"},
+		},
+		&cli.DurationFlag{
+			Name:    "signup-asn-window",
+			Usage:   "time window for --signup-max-per-asn",
+			EnvVars: []string{"ATP_PDS_SIGNUP_ASN_WINDOW"},
+			Value:   24 * time.Hour,
+		},
+		&cli.StringFlag{
+			Name:    "signup-asn-table",
+			Usage:   "file mapping IP prefixes to ASNs, as '<prefix> <asn>' lines",
+			EnvVars: []string{"ATP_PDS_SIGNUP_ASN_TABLE"},
+		},
+		&cli.StringFlag{
+			Name:    "signup-disposable-domains",
+			Usage:   "file listing email domains (one per line) which may not be used to sign up",
+			EnvVars: []string{"ATP_PDS_SIGNUP_DISPOSABLE_DOMAINS"},
+		},
+		&cli.StringFlag{
+			Name:    "signup-captcha",
+			Usage:   "require a captcha on account creation, verified with this provider: 'hcaptcha' or 'turnstile'",
+			EnvVars: []string{"ATP_PDS_SIGNUP_CAPTCHA"},
+		},
+		&cli.StringFlag{
+			Name:    "signup-captcha-secret",
+			Usage:   "secret key for the --signup-captcha provider",
+			EnvVars: []string{"ATP_PDS_SIGNUP_CAPTCHA_SECRET"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "trusted-proxy",
+			Usage:   "address range (CIDR) of a reverse proxy whose X-Forwarded-For header gives the client IP; without any, the connection's address is used",
+			EnvVars: []string{"ATP_PDS_TRUSTED_PROXIES"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "service-auth",
+			Usage:   "accept inter-service auth tokens for a method, as '<nsid>=<issuer did>' (repeat for more issuers), or '<nsid>' for any issuer (not allowed for com.atproto.admin.* methods). eg: com.atproto.admin.updateSubjectStatus=did:plc:<moderation service>",
+			EnvVars: []string{"ATP_PDS_SERVICE_AUTH"},
+		},
+		&cli.BoolFlag{
+			Name:    "service-auth-require-nonce",
+			Usage:   "reject service auth tokens without a 'jti' nonce",
+			EnvVars: []string{"ATP_PDS_SERVICE_AUTH_REQUIRE_NONCE"},
+		},
+		&cli.BoolFlag{
+			Name:    "audit-log",
+			Usage:   "write a security audit log (logins, auth failures, admin actions, etc) as daily JSON-lines files under the data directory",
+			EnvVars: []string{"ATP_PDS_AUDIT_LOG"},
+		},
+		&cli.DurationFlag{
+			Name:    "audit-log-retention",
+			Usage:   "how long audit log files are kept. zero keeps them forever",
+			EnvVars: []string{"ATP_PDS_AUDIT_LOG_RETENTION"},
+			Value:   90 * 24 * time.Hour,
+		},
+		&cli.BoolFlag{
+			Name:    "audit-syslog",
+			Usage:   "also send audit events to the local syslog daemon (AUTH facility)",
+			EnvVars: []string{"ATP_PDS_AUDIT_SYSLOG"},
+		},
+		&cli.StringFlag{
+			Name:    "audit-webhook-url",
+			Usage:   "also POST each audit event as JSON to this URL",
+			EnvVars: []string{"ATP_PDS_AUDIT_WEBHOOK_URL"},
+		},
+		&cli.StringFlag{
+			Name:    "service-mode",
+			Usage:   "mode to start in: normal, read-only (writes rejected), or maintenance (everything but health checks and admin API rejected); can be changed at runtime via POST /admin/mode",
+			Value:   "normal",
+			EnvVars: []string{"ATP_PDS_SERVICE_MODE"},
+		},
+		&cli.BoolFlag{
+			Name:    "handle-policy",
+			Usage:   "enforce handle allocation policy (reserved names, offensive terms, confusable handles)",
+			EnvVars: []string{"ATP_PDS_HANDLE_POLICY"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "reserved-handles",
+			Usage:   "additional handle names (without domain suffix) which may not be registered",
+			EnvVars: []string{"ATP_PDS_RESERVED_HANDLES"},
+		},
+		&cli.IntFlag{
+			Name:    "handle-min-length",
+			Usage:   "minimum length of the name part of a handle",
+			EnvVars: []string{"ATP_PDS_HANDLE_MIN_LENGTH"},
+			Value:   3,
+		},
+		&cli.IntFlag{
+			Name:    "handle-max-length",
+			Usage:   "maximum length of the name part of a handle",
+			EnvVars: []string{"ATP_PDS_HANDLE_MAX_LENGTH"},
+			Value:   18,
+		},
+		&cli.BoolFlag{
+			Name:    "shared-state",
+			Usage:   "coordinate with other instances using the same (postgres) databases and carstore directory, so that several can run behind a load balancer. the service mode is then shared, and an instance starting up adopts the mode already in effect",
+			EnvVars: []string{"ATP_PDS_SHARED_STATE"},
+		},
+		&cli.IntFlag{
+			Name:    "shared-state-max-locked-repos",
+			Usage:   "maximum number of concurrent repo writes per instance, when sharing state; each holds a database connection",
+			EnvVars: []string{"ATP_PDS_SHARED_STATE_MAX_LOCKED_REPOS"},
+			Value:   16,
+		},
+		&cli.DurationFlag{
+			Name:    "shared-state-lock-timeout",
+			Usage:   "how long a write waits for another instance to finish writing to the same repo, when sharing state",
+			EnvVars: []string{"ATP_PDS_SHARED_STATE_LOCK_TIMEOUT"},
+			Value:   30 * time.Second,
+		},
+		&cli.StringFlag{
+			Name:    "labeler-did",
+			Usage:   "DID to sign labels as; enables the admin label endpoints and com.atproto.label.subscribeLabels. the DID document needs an atproto_label key and an atproto_labeler service pointing at this PDS",
+			EnvVars: []string{"ATP_PDS_LABELER_DID"},
+		},
+		&cli.StringFlag{
+			Name:    "labeler-key",
+			Usage:   "private key (multibase) to sign labels with, matching the labeler DID's atproto_label key",
+			EnvVars: []string{"ATP_PDS_LABELER_KEY"},
+		},
 		&cli.IntFlag{
 			Name:    "max-carstore-connections",
 			EnvVars: []string{"MAX_CARSTORE_CONNECTIONS"},
@@ -127,9 +404,13 @@ func run(args []string) {
 		keypath := filepath.Join(datadir, "server.key")
 		jwtsecret := []byte(cctx.String("jwt-secret"))
 
-		// TODO(bnewbold): split this on comma, and have PDS support multiple
-		// domain suffixes that can be registered
-		pdsdomain := cctx.String("handle-domains")
+		handleDomains, err := pds.ParseHandleDomains(strings.Split(cctx.String("handle-domains"), ","), cctx.StringSlice("invite-required-domains"), cctx.StringSlice("reserved-handle-prefixes"))
+		if err != nil {
+			return err
+		}
+		if len(handleDomains) == 0 {
+			return fmt.Errorf("at least one handle domain is required")
+		}
 
 		// ensure data directories exist; won't error if it does
 		os.MkdirAll(csdir, os.ModePerm)
@@ -174,10 +455,255 @@ func run(args []string) {
 			return err
 		}
 
-		srv, err := pds.NewServer(db, cstore, key, pdsdomain, pdshost, didr, jwtsecret)
+		srv, err := pds.NewServer(db, cstore, key, handleDomains[0].Suffix, pdshost, didr, jwtsecret)
 		if err != nil {
 			return err
 		}
+		if err := srv.SetHandleDomains(handleDomains); err != nil {
+			return err
+		}
+
+		if dir := cctx.String("email-templates-dir"); dir != "" {
+			et, err := pds.NewEmailTemplates(dir)
+			if err != nil {
+				return err
+			}
+			srv.SetEmailTemplates(et)
+		}
+
+		srv.SetAdminPassword(cctx.String("admin-password"))
+
+		secCfg := pds.DefaultSecurityConfig()
+		if origins := cctx.StringSlice("cors-allow-origins"); len(origins) > 0 {
+			secCfg.CORSAllowOrigins = origins
+		}
+		secCfg.HSTSMaxAge = int(cctx.Duration("hsts-max-age").Seconds())
+		secCfg.MaxBodySize = cctx.Int64("max-body-size")
+		secCfg.RouteBodyLimits["/xrpc/com.atproto.repo.uploadBlob"] = cctx.Int64("max-blob-size")
+		srv.SetSecurityConfig(secCfg)
+
+		var blobSource pds.BlobSource
+		switch {
+		case cctx.String("blob-dir") != "" && cctx.String("blob-url") != "":
+			return fmt.Errorf("only one of --blob-dir and --blob-url may be set")
+		case cctx.String("blob-dir") != "":
+			blobSource = &pds.DirBlobSource{Dir: cctx.String("blob-dir")}
+		case cctx.String("blob-url") != "":
+			blobSource = &pds.URLBlobSource{
+				BaseURL: cctx.String("blob-url"),
+				Client:  &http.Client{Timeout: 5 * time.Minute},
+			}
+		}
+		if blobSource != nil {
+			if err := srv.SetBlobConfig(&pds.BlobConfig{
+				Source:        blobSource,
+				CacheDir:      cctx.String("blob-cache-dir"),
+				CacheMaxBytes: cctx.Int64("blob-cache-max-bytes"),
+			}); err != nil {
+				return err
+			}
+		}
+
+		if cctx.Bool("takeout") {
+			if err := srv.SetTakeoutConfig(&pds.TakeoutConfig{
+				Dir:        filepath.Join(datadir, "takeout"),
+				TTL:        cctx.Duration("takeout-ttl"),
+				BlobSource: blobSource,
+			}); err != nil {
+				return err
+			}
+			go srv.RunTakeoutCleanup(context.Background(), 10*time.Minute)
+		}
+
+		if cctx.Bool("quotas") {
+			if err := srv.SetQuotaConfig(&pds.QuotaConfig{
+				Default: pds.Quota{
+					MaxRepoBytes: cctx.Int64("quota-max-repo-bytes"),
+					MaxBlobBytes: cctx.Int64("quota-max-blob-bytes"),
+					MaxRecords:   cctx.Int64("quota-max-records"),
+				},
+			}); err != nil {
+				return err
+			}
+		}
+
+		if allow, deny := cctx.StringSlice("allowed-collections"), cctx.StringSlice("denied-collections"); len(allow) > 0 || len(deny) > 0 {
+			if err := srv.SetCollectionPolicy(&pds.CollectionPolicy{
+				Allow: allow,
+				Deny:  deny,
+			}); err != nil {
+				return err
+			}
+		}
+
+		if cctx.Bool("two-factor") {
+			if err := srv.SetTwoFactorConfig(&pds.TwoFactorConfig{
+				Issuer:       cctx.String("two-factor-issuer"),
+				AppPasswords: pds.AppPasswordPolicy(cctx.String("two-factor-app-passwords")),
+			}); err != nil {
+				return err
+			}
+		}
+
+		if proxies := cctx.StringSlice("trusted-proxy"); len(proxies) > 0 {
+			if err := srv.SetTrustedProxies(proxies); err != nil {
+				return err
+			}
+		}
+
+		signupCfg := &pds.SignupConfig{
+			MaxPerIP:  cctx.Int("signup-max-per-ip"),
+			IPWindow:  cctx.Duration("signup-ip-window"),
+			MaxPerASN: cctx.Int("signup-max-per-asn"),
+			ASNWindow: cctx.Duration("signup-asn-window"),
+		}
+		if p := cctx.String("signup-asn-table"); p != "" {
+			t, err := pds.LoadPrefixASNTable(p)
+			if err != nil {
+				return err
+			}
+			signupCfg.ASNLookup = t
+		}
+		if p := cctx.String("signup-disposable-domains"); p != "" {
+			dl, err := pds.LoadDomainList(p)
+			if err != nil {
+				return err
+			}
+			signupCfg.DisposableDomains = dl
+		}
+		switch cctx.String("signup-captcha") {
+		case "":
+		case "hcaptcha":
+			signupCfg.Captcha = pds.NewHCaptchaVerifier(cctx.String("signup-captcha-secret"))
+		case "turnstile":
+			signupCfg.Captcha = pds.NewTurnstileVerifier(cctx.String("signup-captcha-secret"))
+		default:
+			return fmt.Errorf("unknown captcha provider: %s", cctx.String("signup-captcha"))
+		}
+		if signupCfg.Captcha != nil && cctx.String("signup-captcha-secret") == "" {
+			return fmt.Errorf("--signup-captcha-secret is required with --signup-captcha")
+		}
+		if signupCfg.MaxPerIP > 0 || signupCfg.MaxPerASN > 0 || signupCfg.DisposableDomains != nil || signupCfg.Captcha != nil {
+			if err := srv.SetSignupConfig(signupCfg); err != nil {
+				return err
+			}
+		}
+
+		if entries := cctx.StringSlice("service-auth"); len(entries) > 0 {
+			methods := make(map[string][]string)
+			anyIssuer := make(map[string]bool)
+			for _, ent := range entries {
+				nsid, iss, ok := strings.Cut(ent, "=")
+				if !ok {
+					anyIssuer[nsid] = true
+				}
+				if ok && !anyIssuer[nsid] {
+					methods[nsid] = append(methods[nsid], iss)
+				} else {
+					methods[nsid] = nil
+				}
+			}
+			if err := srv.SetServiceAuthConfig(&pds.ServiceAuthConfig{
+				Methods:      methods,
+				RequireNonce: cctx.Bool("service-auth-require-nonce"),
+			}); err != nil {
+				return err
+			}
+		}
+
+		var auditSinks []pds.AuditSink
+		if cctx.Bool("audit-log") {
+			fs, err := pds.NewFileAuditSink(filepath.Join(datadir, "audit"), cctx.Duration("audit-log-retention"))
+			if err != nil {
+				return err
+			}
+			auditSinks = append(auditSinks, fs)
+		}
+		if cctx.Bool("audit-syslog") {
+			ss, err := pds.NewSyslogAuditSink("laputa")
+			if err != nil {
+				return fmt.Errorf("connecting to syslog: %w", err)
+			}
+			auditSinks = append(auditSinks, ss)
+		}
+		if u := cctx.String("audit-webhook-url"); u != "" {
+			auditSinks = append(auditSinks, pds.NewWebhookAuditSink(u))
+		}
+		if len(auditSinks) > 0 {
+			al := pds.NewAuditLog(auditSinks...)
+			defer al.Close()
+			srv.SetAuditLog(al)
+		}
+
+		if err := srv.SetServiceMode(pds.ServiceMode(cctx.String("service-mode")), "", 0); err != nil {
+			return err
+		}
+
+		if cctx.Bool("shared-state") {
+			if err := srv.SetSharedStateConfig(&pds.SharedStateConfig{
+				MaxLockedRepos: cctx.Int("shared-state-max-locked-repos"),
+				LockTimeout:    cctx.Duration("shared-state-lock-timeout"),
+			}); err != nil {
+				return err
+			}
+			go srv.RunSharedState(context.Background())
+		}
+
+		if cctx.Bool("event-outbox") {
+			if err := srv.SetOutboxConfig(&pds.OutboxConfig{}); err != nil {
+				return err
+			}
+			go srv.RunOutbox(context.Background())
+		}
+
+		if labelerDID := cctx.String("labeler-did"); labelerDID != "" {
+			did, err := syntax.ParseDID(labelerDID)
+			if err != nil {
+				return fmt.Errorf("invalid --labeler-did: %w", err)
+			}
+			if cctx.String("labeler-key") == "" {
+				return fmt.Errorf("--labeler-key is required with --labeler-did")
+			}
+			labelerKey, err := crypto.ParsePrivateMultibase(cctx.String("labeler-key"))
+			if err != nil {
+				return fmt.Errorf("invalid --labeler-key: %w", err)
+			}
+			if err := srv.SetLabelerConfig(&pds.LabelerConfig{
+				DID:        did,
+				SigningKey: labelerKey,
+			}); err != nil {
+				return err
+			}
+		}
+
+		if cctx.Bool("handle-policy") {
+			domainRules := make(map[string]handlepolicy.DomainRule)
+			for _, hd := range handleDomains {
+				domainRules[hd.Suffix] = handlepolicy.DomainRule{
+					MinLength:     cctx.Int("handle-min-length"),
+					MaxLength:     cctx.Int("handle-max-length"),
+					StrictHyphens: true,
+				}
+			}
+			policy := &handlepolicy.Policy{
+				Domains:        domainRules,
+				ReservedLabels: append(handlepolicy.DefaultReservedLabels, cctx.StringSlice("reserved-handles")...),
+				OffensiveCheck: keyword.SlugContainsExplicitSlur,
+			}
+			if err := srv.SetHandlePolicy(context.Background(), policy); err != nil {
+				return err
+			}
+		}
+
+		if u := cctx.String("relay-push-url"); u != "" {
+			if cctx.String("relay-push-token") == "" {
+				return fmt.Errorf("--relay-push-token is required with --relay-push-url")
+			}
+			go srv.RunRelayPush(context.Background(), &pds.RelayPushConfig{
+				URL:   u,
+				Token: cctx.String("relay-push-token"),
+			})
+		}
 
 		return srv.RunAPI(":4989")
 	}
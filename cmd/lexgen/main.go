@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
@@ -57,6 +58,41 @@ func expandArgs(args []string) ([]string, error) {
 	return out, nil
 }
 
+// writes OpenAPI and/or JSON Schema exports of lexicons, instead of Go code
+func writeAPIDocs(cctx *cli.Context, schemas []*lex.Schema) error {
+	cfg := lex.OpenAPIConfig{
+		Title:     cctx.String("api-title"),
+		Version:   cctx.String("api-version"),
+		ServerURL: cctx.String("server-url"),
+		Prefixes:  cctx.StringSlice("nsid-prefix"),
+	}
+	write := func(path string, f func(io.Writer, []*lex.Schema, lex.OpenAPIConfig) error) error {
+		if path == "-" {
+			return f(os.Stdout, schemas, cfg)
+		}
+		fi, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer fi.Close()
+		if err := f(fi, schemas, cfg); err != nil {
+			return err
+		}
+		return fi.Close()
+	}
+	if path := cctx.String("openapi"); path != "" {
+		if err := write(path, lex.WriteOpenAPI); err != nil {
+			return fmt.Errorf("--openapi: %w", err)
+		}
+	}
+	if path := cctx.String("jsonschema"); path != "" {
+		if err := write(path, lex.WriteJSONSchema); err != nil {
+			return fmt.Errorf("--jsonschema: %w", err)
+		}
+	}
+	return nil
+}
+
 func main() {
 	app := cli.NewApp()
 
@@ -88,6 +124,30 @@ func main() {
 			Name:  "build-file",
 			Value: "",
 		},
+		&cli.StringFlag{
+			Name:  "openapi",
+			Usage: "instead of Go code, write an OpenAPI 3.1 document for lexicon HTTP endpoints to this file ('-' for stdout)",
+		},
+		&cli.StringFlag{
+			Name:  "jsonschema",
+			Usage: "instead of Go code, write a JSON Schema document for lexicon record and object types to this file ('-' for stdout)",
+		},
+		&cli.StringSliceFlag{
+			Name:  "nsid-prefix",
+			Usage: "for --openapi and --jsonschema, only export lexicons under these NSID prefixes (eg, 'app.bsky.feed')",
+		},
+		&cli.StringFlag{
+			Name:  "api-title",
+			Usage: "title for --openapi and --jsonschema documents",
+		},
+		&cli.StringFlag{
+			Name:  "api-version",
+			Usage: "API version for --openapi documents",
+		},
+		&cli.StringFlag{
+			Name:  "server-url",
+			Usage: "server base URL for --openapi documents (eg, 'https://public.api.bsky.app')",
+		},
 	}
 	app.Action = func(cctx *cli.Context) error {
 		paths, err := expandArgs(cctx.Args().Slice())
@@ -109,6 +169,10 @@ func main() {
 			schemas = append(schemas, s)
 		}
 
+		if cctx.String("openapi") != "" || cctx.String("jsonschema") != "" {
+			return writeAPIDocs(cctx, schemas)
+		}
+
 		buildLiteral := cctx.String("build")
 		buildPath := cctx.String("build-file")
 		var packages []lex.Package
@@ -22,12 +22,14 @@ import (
 	"golang.org/x/time/rate"
 
 	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
 	"github.com/bluesky-social/indigo/search"
 	"github.com/bluesky-social/indigo/util/cliutil"
 
 	"github.com/carlmjohnson/versioninfo"
 	es "github.com/opensearch-project/opensearch-go/v2"
 	cli "github.com/urfave/cli/v2"
+	"gorm.io/gorm"
 )
 
 func main() {
@@ -188,6 +190,27 @@ var runCmd = &cli.Command{
 			Name:    "bulk-profiles-file",
 			EnvVars: []string{"BULK_PROFILES_FILE"},
 		},
+		&cli.BoolFlag{
+			Name:    "moderation",
+			Usage:   "filter relay takedowns and labeled content out of search results (state is kept in the database, so readonly instances need --database-url too)",
+			EnvVars: []string{"PALOMAR_MODERATION"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "labelers",
+			Usage:   "labelers to consume labels from, as DIDs, optionally with the service URL (did:plc:abc=https://labeler.example.com)",
+			EnvVars: []string{"PALOMAR_LABELERS"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "default-labelers",
+			Usage:   "labelers applied to requests without an atproto-accept-labelers header (defaults to all of --labelers)",
+			EnvVars: []string{"PALOMAR_DEFAULT_LABELERS"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "hide-labels",
+			Usage:   "label values which remove results; other labels are added to results",
+			EnvVars: []string{"PALOMAR_HIDE_LABELS"},
+			Value:   cli.NewStringSlice("!takedown", "!hide"),
+		},
 	},
 	Action: func(cctx *cli.Context) error {
 		logLevel := slog.LevelInfo
@@ -272,12 +295,55 @@ var runCmd = &cli.Command{
 			return err
 		}
 
-		// Configure the indexer if we're not in readonly mode
-		if !readonly {
-			db, err := cliutil.SetupDatabase(cctx.String("database-url"), cctx.Int("max-metadb-connections"))
+		var db *gorm.DB
+		if !readonly || cctx.Bool("moderation") {
+			db, err = cliutil.SetupDatabase(cctx.String("database-url"), cctx.Int("max-metadb-connections"))
 			if err != nil {
 				return fmt.Errorf("failed to set up database: %w", err)
 			}
+		}
+
+		if cctx.Bool("moderation") {
+			modConfig := search.ModerationConfig{
+				Logger:     logger,
+				HideLabels: cctx.StringSlice("hide-labels"),
+			}
+			for _, l := range cctx.StringSlice("labelers") {
+				raw, host, _ := strings.Cut(l, "=")
+				did, err := syntax.ParseDID(raw)
+				if err != nil {
+					return fmt.Errorf("invalid labeler %q: %w", l, err)
+				}
+				modConfig.Labelers = append(modConfig.Labelers, search.LabelerConfig{DID: did, Host: host})
+			}
+			if cctx.IsSet("default-labelers") {
+				modConfig.DefaultLabelers = []syntax.DID{}
+				for _, raw := range cctx.StringSlice("default-labelers") {
+					did, err := syntax.ParseDID(raw)
+					if err != nil {
+						return fmt.Errorf("invalid default labeler %q: %w", raw, err)
+					}
+					modConfig.DefaultLabelers = append(modConfig.DefaultLabelers, did)
+				}
+			}
+			mod, err := search.NewModeration(db, &dir, modConfig)
+			if err != nil {
+				return fmt.Errorf("failed to set up moderation: %w", err)
+			}
+			srv.Moderation = mod
+
+			// the indexing instance keeps the shared moderation state up to date
+			if !readonly {
+				go func() {
+					if err := mod.RunLabelers(context.Background()); err != nil {
+						slog.Error("failed to consume labels", "err", err)
+					}
+				}()
+			}
+		}
+
+		// Configure the indexer if we're not in readonly mode
+		if !readonly {
 
 			indexerConfig := search.IndexerConfig{
 				RelayHost:           cctx.String("atp-relay-host"),
@@ -295,6 +361,7 @@ var runCmd = &cli.Command{
 				return fmt.Errorf("failed to set up indexer: %w", err)
 			}
 
+			idx.Moderation = srv.Moderation
 			srv.Indexer = idx
 		}
 
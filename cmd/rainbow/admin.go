@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/bluesky-social/indigo/splitter"
+
+	"github.com/urfave/cli/v2"
+)
+
+var adminHostFlag = &cli.StringFlag{
+	Name:    "rainbow-host",
+	Value:   "http://localhost:2480",
+	Usage:   "base URL of the rainbow instance to connect to",
+	EnvVars: []string{"RAINBOW_HOST"},
+}
+
+var exportEventsCmd = &cli.Command{
+	Name:  "export-events",
+	Usage: "download a range of cached events from a running rainbow instance to a file",
+	Flags: []cli.Flag{
+		adminHostFlag,
+		&cli.Int64Flag{
+			Name:  "since",
+			Usage: "first sequence number to export (inclusive)",
+		},
+		&cli.Int64Flag{
+			Name:  "until",
+			Usage: "last sequence number to export (inclusive); 0 for the current head",
+		},
+		&cli.StringFlag{
+			Name:     "output",
+			Aliases:  []string{"o"},
+			Usage:    "file to write events to ('-' for stdout)",
+			Required: true,
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		q := url.Values{}
+		q.Set("since", fmt.Sprint(cctx.Int64("since")))
+		q.Set("until", fmt.Sprint(cctx.Int64("until")))
+		req, err := adminRequest(cctx, "GET", "/admin/events/export?"+q.Encode(), nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return adminError(resp)
+		}
+
+		var out io.Writer = os.Stdout
+		if fname := cctx.String("output"); fname != "-" {
+			fi, err := os.Create(fname)
+			if err != nil {
+				return err
+			}
+			defer fi.Close()
+			out = fi
+		}
+		n, err := io.Copy(out, resp.Body)
+		if err != nil {
+			return err
+		}
+		log.Info("exported events", "bytes", n)
+		return nil
+	},
+}
+
+var importEventsCmd = &cli.Command{
+	Name:      "import-events",
+	Usage:     "upload events from an export file to a running rainbow instance",
+	ArgsUsage: "<file>",
+	Flags: []cli.Flag{
+		adminHostFlag,
+	},
+	Action: func(cctx *cli.Context) error {
+		fname := cctx.Args().First()
+		if fname == "" {
+			return fmt.Errorf("need to provide export file path as an argument")
+		}
+		fi, err := os.Open(fname)
+		if err != nil {
+			return err
+		}
+		defer fi.Close()
+
+		req, err := adminRequest(cctx, "POST", "/admin/events/import", fi)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return adminError(resp)
+		}
+
+		var stats splitter.ExportStats
+		if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+			return err
+		}
+		log.Info("imported events", "events", stats.Events, "skipped", stats.Skipped, "firstSeq", stats.FirstSeq, "lastSeq", stats.LastSeq)
+		return nil
+	},
+}
+
+var trainDictionaryCmd = &cli.Command{
+	Name:  "train-dictionary",
+	Usage: "train a zstd dictionary for event cache compression on a running rainbow instance's recent events",
+	Flags: []cli.Flag{
+		adminHostFlag,
+		&cli.IntFlag{
+			Name:  "samples",
+			Usage: "number of recent events to train on",
+			Value: 10_000,
+		},
+		&cli.IntFlag{
+			Name:  "size",
+			Usage: "maximum dictionary size, in bytes",
+			Value: 110 << 10,
+		},
+		&cli.StringFlag{
+			Name:     "output",
+			Aliases:  []string{"o"},
+			Usage:    "file to write the dictionary to",
+			Required: true,
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		q := url.Values{}
+		q.Set("samples", fmt.Sprint(cctx.Int("samples")))
+		q.Set("size", fmt.Sprint(cctx.Int("size")))
+		req, err := adminRequest(cctx, "GET", "/admin/events/zstd-dictionary?"+q.Encode(), nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return adminError(resp)
+		}
+
+		dict, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(cctx.String("output"), dict, 0644); err != nil {
+			return err
+		}
+		log.Info("trained dictionary", "bytes", len(dict))
+		return nil
+	},
+}
+
+var listConsumersCmd = &cli.Command{
+	Name:  "list-consumers",
+	Usage: "list consumers connected to a running rainbow instance, with how far behind they are",
+	Flags: []cli.Flag{
+		adminHostFlag,
+	},
+	Action: func(cctx *cli.Context) error {
+		return adminPrint(cctx, "GET", "/admin/consumers")
+	},
+}
+
+var disconnectConsumerCmd = &cli.Command{
+	Name:      "disconnect-consumer",
+	Usage:     "close a consumer's connection to a running rainbow instance (it may reconnect)",
+	ArgsUsage: "<consumer-id>",
+	Flags: []cli.Flag{
+		adminHostFlag,
+	},
+	Action: func(cctx *cli.Context) error {
+		id := cctx.Args().First()
+		if id == "" {
+			return fmt.Errorf("need to provide consumer id (from list-consumers) as an argument")
+		}
+		return adminPrint(cctx, "DELETE", "/admin/consumers/"+url.PathEscape(id))
+	},
+}
+
+var rewindConsumerCmd = &cli.Command{
+	Name:      "rewind-consumer",
+	Usage:     "send a consumer connected to a running rainbow instance back to replay the event cache from a cursor (see event-window)",
+	ArgsUsage: "<consumer-id> <cursor>",
+	Flags: []cli.Flag{
+		adminHostFlag,
+	},
+	Action: func(cctx *cli.Context) error {
+		id, cursor := cctx.Args().Get(0), cctx.Args().Get(1)
+		if id == "" || cursor == "" {
+			return fmt.Errorf("need to provide consumer id (from list-consumers) and cursor (sequence number) as arguments")
+		}
+		return adminPrint(cctx, "POST", "/admin/consumers/"+url.PathEscape(id)+"/rewind?cursor="+url.QueryEscape(cursor))
+	},
+}
+
+var eventWindowCmd = &cli.Command{
+	Name:  "event-window",
+	Usage: "show the range of events a running rainbow instance has cached, which subscribers can replay from",
+	Flags: []cli.Flag{
+		adminHostFlag,
+	},
+	Action: func(cctx *cli.Context) error {
+		return adminPrint(cctx, "GET", "/admin/events/window")
+	},
+}
+
+var upstreamStatusCmd = &cli.Command{
+	Name:  "upstream-status",
+	Usage: "show the state of a running rainbow instance's upstream connection",
+	Flags: []cli.Flag{
+		adminHostFlag,
+	},
+	Action: func(cctx *cli.Context) error {
+		return adminPrint(cctx, "GET", "/admin/upstream")
+	},
+}
+
+// adminPrint makes an admin request, and prints the JSON response to stdout
+func adminPrint(cctx *cli.Context, method, path string) error {
+	req, err := adminRequest(cctx, method, path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return adminError(resp)
+	}
+
+	var out any
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+func adminRequest(cctx *cli.Context, method, path string, body io.Reader) (*http.Request, error) {
+	token := cctx.String("admin-token")
+	if token == "" {
+		return nil, fmt.Errorf("admin token is required (--admin-token or RAINBOW_ADMIN_TOKEN)")
+	}
+	req, err := http.NewRequestWithContext(cctx.Context, method, strings.TrimSuffix(cctx.String("rainbow-host"), "/")+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req, nil
+}
+
+func adminError(resp *http.Response) error {
+	var body struct {
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err == nil && body.Error != "" {
+		return fmt.Errorf("rainbow admin request failed (%d): %s", resp.StatusCode, body.Error)
+	}
+	return fmt.Errorf("rainbow admin request failed: %s", resp.Status)
+}
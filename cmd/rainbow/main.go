@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"github.com/bluesky-social/indigo/events"
 	"log/slog"
 	_ "net/http/pprof"
@@ -82,16 +83,168 @@ func run(args []string) {
 			EnvVars: []string{"RAINBOW_PERSIST_HOURS", "SPLITTER_PERSIST_HOURS"},
 			Usage:   "hours to buffer (float, may be fractional)",
 		},
+		&cli.Float64Flag{
+			Name:    "persist-identity-hours",
+			EnvVars: []string{"RAINBOW_PERSIST_IDENTITY_HOURS"},
+			Usage:   "hours to buffer identity and account events (float, may be fractional), if different from --persist-hours",
+		},
 		&cli.Int64Flag{
 			Name:    "persist-bytes",
 			Value:   0,
 			Usage:   "max bytes target for event cache, 0 to disable size target trimming",
 			EnvVars: []string{"RAINBOW_PERSIST_BYTES", "SPLITTER_PERSIST_BYTES"},
 		},
+		&cli.StringFlag{
+			Name:    "persist-compression",
+			Usage:   "compression for newly cached events: none, snappy or zstd. events cached with any compression can be read back",
+			Value:   "none",
+			EnvVars: []string{"RAINBOW_PERSIST_COMPRESSION"},
+		},
+		&cli.StringFlag{
+			Name:    "persist-zstd-dictionary",
+			Usage:   "zstd dictionary file (see train-dictionary) for event cache compression. must be kept for as long as events compressed with it are cached",
+			EnvVars: []string{"RAINBOW_PERSIST_ZSTD_DICTIONARY"},
+		},
+		&cli.StringFlag{
+			Name:    "warm-start-peer",
+			Usage:   "when starting with an empty event cache, copy the retained window from this rainbow peer (eg, rainbow-1.example.com or http://10.0.0.5:2480)",
+			EnvVars: []string{"RAINBOW_WARM_START_PEER"},
+		},
+		&cli.StringFlag{
+			Name:    "peer-token",
+			Usage:   "consumer API key or JWT to authenticate to the warm start or shared history peer with, if it requires consumer auth",
+			EnvVars: []string{"RAINBOW_PEER_TOKEN"},
+		},
+		&cli.StringFlag{
+			Name:    "shared-history-peer",
+			Usage:   "serve the event window of this rainbow peer (eg, http://10.0.0.5:2480) instead of keeping a cache: live events are consumed from it in place of splitter-host, and older cursors are streamed from it. persist-db is ignored",
+			EnvVars: []string{"RAINBOW_SHARED_HISTORY_PEER"},
+		},
+		&cli.StringFlag{
+			Name:    "admin-token",
+			Usage:   "bearer token for the /admin/ API (event export and import); admin API is disabled if not set",
+			EnvVars: []string{"RAINBOW_ADMIN_TOKEN"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "tls-hostname",
+			Usage:   "serve the API over TLS, with certificates for this hostname provisioned automatically via ACME (Let's Encrypt); may be repeated",
+			EnvVars: []string{"RAINBOW_TLS_HOSTNAMES"},
+		},
+		&cli.StringFlag{
+			Name:    "tls-cache-dir",
+			Usage:   "directory to store ACME certificates and account key in",
+			Value:   "./rainbow-certs",
+			EnvVars: []string{"RAINBOW_TLS_CACHE_DIR"},
+		},
+		&cli.StringFlag{
+			Name:    "acme-email",
+			Usage:   "contact email address for the ACME account (optional)",
+			EnvVars: []string{"RAINBOW_ACME_EMAIL"},
+		},
+		&cli.StringFlag{
+			Name:    "acme-http-listen",
+			Usage:   "listen address for ACME HTTP-01 challenges (and redirects to HTTPS); must be reachable on port 80",
+			Value:   ":80",
+			EnvVars: []string{"RAINBOW_ACME_HTTP_LISTEN"},
+		},
+		&cli.BoolFlag{
+			Name:    "collection-stats",
+			Usage:   "tally upstream repo ops by collection and op type (metrics, and report at /admin/events/collections)",
+			EnvVars: []string{"RAINBOW_COLLECTION_STATS"},
+		},
+		&cli.BoolFlag{
+			Name:    "reuseport",
+			Usage:   "open the API listener with SO_REUSEPORT, so several rainbow processes can share the port (see loopback-listen)",
+			EnvVars: []string{"RAINBOW_REUSEPORT"},
+		},
+		&cli.StringFlag{
+			Name:    "loopback-listen",
+			Usage:   "also serve the API on this private address (eg, 127.0.0.1:2482), for sibling processes sharing the API port to use as their upstream (eg, --splitter-host ws://127.0.0.1:2482)",
+			EnvVars: []string{"RAINBOW_LOOPBACK_LISTEN"},
+		},
+		&cli.StringFlag{
+			Name:    "mutes-file",
+			Value:   "./rainbow-mutes.json",
+			Usage:   "file to keep the list of muted accounts in (managed through the /admin/mutes API)",
+			EnvVars: []string{"RAINBOW_MUTES_PATH"},
+		},
+		&cli.StringFlag{
+			Name:    "consumer-keys-file",
+			Value:   "./rainbow-consumer-keys.json",
+			Usage:   "file to keep consumer API keys and JWT revocations in (managed through the /admin/consumer-keys and /admin/consumer-revocations APIs)",
+			EnvVars: []string{"RAINBOW_CONSUMER_KEYS_PATH"},
+		},
+		&cli.StringFlag{
+			Name:    "consumer-jwt-secret",
+			Usage:   "secret for HS256 JWTs consumers may authenticate with; the token subject names the consumer",
+			EnvVars: []string{"RAINBOW_CONSUMER_JWT_SECRET"},
+		},
+		&cli.BoolFlag{
+			Name:    "require-consumer-auth",
+			Usage:   "reject subscribers which don't present a consumer API key or JWT",
+			EnvVars: []string{"RAINBOW_REQUIRE_CONSUMER_AUTH"},
+		},
+		&cli.BoolFlag{
+			Name:    "permessage-deflate",
+			Usage:   "negotiate permessage-deflate websocket compression with subscribers which offer it",
+			EnvVars: []string{"RAINBOW_PERMESSAGE_DEFLATE"},
+		},
+		&cli.StringFlag{
+			Name:    "zstd-dictionary",
+			Usage:   "zstd dictionary file (eg, Jetstream's) to compress messages against for subscribers which ask for zstd; served at /_rainbow/zstd-dictionary",
+			EnvVars: []string{"RAINBOW_ZSTD_DICTIONARY"},
+		},
+		&cli.DurationFlag{
+			Name:    "event-age-sla",
+			Usage:   "alert when the p99 age of upstream events (time since creation) exceeds this, per minute; zero disables alerting",
+			EnvVars: []string{"RAINBOW_EVENT_AGE_SLA"},
+		},
+		&cli.StringFlag{
+			Name:    "event-age-alert-webhook",
+			Usage:   "URL to post event age SLA alerts to (eg, a Slack incoming webhook)",
+			EnvVars: []string{"RAINBOW_EVENT_AGE_ALERT_WEBHOOK"},
+		},
+		&cli.StringFlag{
+			Name:    "backfill-host",
+			Usage:   "service with longer retention than the event cache (eg, wss://bsky.network), which subscribers with an older cursor are directed to",
+			EnvVars: []string{"RAINBOW_BACKFILL_HOST"},
+		},
+		&cli.IntFlag{
+			Name:    "consumer-max-queued-events",
+			Usage:   "live events which may be waiting to be sent to a subscriber before it is too slow (see slow-consumer-policy); zero for the default (16384)",
+			EnvVars: []string{"RAINBOW_CONSUMER_MAX_QUEUED_EVENTS"},
+		},
+		&cli.Int64Flag{
+			Name:    "consumer-max-queued-bytes",
+			Usage:   "total size of the live events which may be waiting to be sent to a subscriber before it is too slow; zero for no limit",
+			EnvVars: []string{"RAINBOW_CONSUMER_MAX_QUEUED_BYTES"},
+		},
+		&cli.StringFlag{
+			Name:    "slow-consumer-policy",
+			Usage:   "what to do with subscribers which fall too far behind: 'disconnect', or 'replay' them the events they missed from the cache",
+			Value:   splitter.SlowConsumerDisconnect,
+			EnvVars: []string{"RAINBOW_SLOW_CONSUMER_POLICY"},
+		},
+		&cli.Float64Flag{
+			Name:    "consumer-rate-limit",
+			Usage:   "most messages per second sent to any one subscriber, including replays; zero for no limit",
+			EnvVars: []string{"RAINBOW_CONSUMER_RATE_LIMIT"},
+		},
+	}
+
+	app.Commands = []*cli.Command{
+		exportEventsCmd,
+		importEventsCmd,
+		listConsumersCmd,
+		disconnectConsumerCmd,
+		rewindConsumerCmd,
+		eventWindowCmd,
+		upstreamStatusCmd,
+		trainDictionaryCmd,
 	}
 
 	// TODO: slog.SetDefault and set module `var log *slog.Logger` based on flags and env
-	
+
 	app.Action = Splitter
 	err := app.Run(os.Args)
 	if err != nil {
@@ -143,9 +296,10 @@ func Splitter(cctx *cli.Context) error {
 
 	persistPath := cctx.String("persist-db")
 	upstreamHost := cctx.String("splitter-host")
+	sharedHistoryPeer := cctx.String("shared-history-peer")
 	var spl *splitter.Splitter
 	var err error
-	if persistPath != "" {
+	if persistPath != "" && sharedHistoryPeer == "" {
 		log.Info("building splitter with storage at", "path", persistPath)
 		ppopts := events.PebblePersistOptions{
 			DbPath:          persistPath,
@@ -153,17 +307,81 @@ func Splitter(cctx *cli.Context) error {
 			GCPeriod:        5 * time.Minute,
 			MaxBytes:        uint64(cctx.Int64("persist-bytes")),
 		}
+		if h := cctx.Float64("persist-identity-hours"); h > 0 {
+			ppopts.RetentionByType = make(map[string]time.Duration, len(events.IdentityEventTypes))
+			for _, t := range events.IdentityEventTypes {
+				ppopts.RetentionByType[t] = time.Duration(float64(time.Hour) * h)
+			}
+		}
+		if c := cctx.String("persist-compression"); c != "none" {
+			ppopts.Compression = c
+		}
+		if fname := cctx.String("persist-zstd-dictionary"); fname != "" {
+			dict, err := os.ReadFile(fname)
+			if err != nil {
+				return fmt.Errorf("reading event cache zstd dictionary: %w", err)
+			}
+			ppopts.ZstdDictionary = dict
+		}
 		conf := splitter.SplitterConfig{
-			UpstreamHost:  upstreamHost,
-			CursorFile:    cctx.String("cursor-file"),
-			PebbleOptions: &ppopts,
+			UpstreamHost:            upstreamHost,
+			CursorFile:              cctx.String("cursor-file"),
+			PebbleOptions:           &ppopts,
+			WarmStartPeer:           cctx.String("warm-start-peer"),
+			PeerToken:               cctx.String("peer-token"),
+			AdminToken:              cctx.String("admin-token"),
+			TLSHostnames:            cctx.StringSlice("tls-hostname"),
+			TLSCacheDir:             cctx.String("tls-cache-dir"),
+			ACMEEmail:               cctx.String("acme-email"),
+			ACMEHTTPListen:          cctx.String("acme-http-listen"),
+			CollectionStats:         cctx.Bool("collection-stats"),
+			ReusePort:               cctx.Bool("reuseport"),
+			LoopbackListen:          cctx.String("loopback-listen"),
+			MutesFile:               cctx.String("mutes-file"),
+			EventAgeSLA:             cctx.Duration("event-age-sla"),
+			EventAgeAlertWebhook:    cctx.String("event-age-alert-webhook"),
+			BackfillHost:            cctx.String("backfill-host"),
+			ConsumerKeysFile:        cctx.String("consumer-keys-file"),
+			ConsumerJWTSecret:       cctx.String("consumer-jwt-secret"),
+			RequireConsumerAuth:     cctx.Bool("require-consumer-auth"),
+			PerMessageDeflate:       cctx.Bool("permessage-deflate"),
+			ZstdDictionaryFile:      cctx.String("zstd-dictionary"),
+			SharedHistoryPeer:       sharedHistoryPeer,
+			ConsumerMaxQueuedEvents: cctx.Int("consumer-max-queued-events"),
+			ConsumerMaxQueuedBytes:  cctx.Int64("consumer-max-queued-bytes"),
+			SlowConsumerPolicy:      cctx.String("slow-consumer-policy"),
+			ConsumerRateLimit:       cctx.Float64("consumer-rate-limit"),
 		}
 		spl, err = splitter.NewSplitter(conf)
 	} else {
-		log.Info("building in-memory splitter")
+		log.Info("building in-memory splitter", "sharedHistoryPeer", sharedHistoryPeer)
 		conf := splitter.SplitterConfig{
-			UpstreamHost: upstreamHost,
-			CursorFile:   cctx.String("cursor-file"),
+			UpstreamHost:            upstreamHost,
+			CursorFile:              cctx.String("cursor-file"),
+			WarmStartPeer:           cctx.String("warm-start-peer"),
+			PeerToken:               cctx.String("peer-token"),
+			AdminToken:              cctx.String("admin-token"),
+			TLSHostnames:            cctx.StringSlice("tls-hostname"),
+			TLSCacheDir:             cctx.String("tls-cache-dir"),
+			ACMEEmail:               cctx.String("acme-email"),
+			ACMEHTTPListen:          cctx.String("acme-http-listen"),
+			CollectionStats:         cctx.Bool("collection-stats"),
+			ReusePort:               cctx.Bool("reuseport"),
+			LoopbackListen:          cctx.String("loopback-listen"),
+			MutesFile:               cctx.String("mutes-file"),
+			EventAgeSLA:             cctx.Duration("event-age-sla"),
+			EventAgeAlertWebhook:    cctx.String("event-age-alert-webhook"),
+			BackfillHost:            cctx.String("backfill-host"),
+			ConsumerKeysFile:        cctx.String("consumer-keys-file"),
+			ConsumerJWTSecret:       cctx.String("consumer-jwt-secret"),
+			RequireConsumerAuth:     cctx.Bool("require-consumer-auth"),
+			PerMessageDeflate:       cctx.Bool("permessage-deflate"),
+			ZstdDictionaryFile:      cctx.String("zstd-dictionary"),
+			SharedHistoryPeer:       sharedHistoryPeer,
+			ConsumerMaxQueuedEvents: cctx.Int("consumer-max-queued-events"),
+			ConsumerMaxQueuedBytes:  cctx.Int64("consumer-max-queued-bytes"),
+			SlowConsumerPolicy:      cctx.String("slow-consumer-policy"),
+			ConsumerRateLimit:       cctx.Float64("consumer-rate-limit"),
 		}
 		spl, err = splitter.NewSplitter(conf)
 	}
@@ -0,0 +1,260 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// AgeWatchdog measures the end-to-end age of stream events: the time between
+// an event's creation upstream (its "time" field) and when it is processed.
+// Ages are exported as a Prometheus histogram, and summarized as percentiles
+// over fixed windows; when the chosen percentile in a window exceeds the SLA,
+// OnAlert is called (and again when a later window is back within the SLA).
+//
+// Like CollectionStats, it can either be called directly with Observe, or wrap
+// another event handler with Next. Events without a creation time (eg, #info
+// and label events) are not measured.
+type AgeWatchdog struct {
+	// Name of the consumer, used as a metric label and in alerts (eg, "rainbow")
+	Name string
+	// Optional next handler in the pipeline; used by EventHandler
+	Next func(ctx context.Context, xev *XRPCStreamEvent) error
+	// Maximum acceptable age at Percentile. Zero disables alerting (ages are
+	// still measured)
+	SLA time.Duration
+	// Percentile (0-1) of event ages compared against the SLA
+	Percentile float64
+	// Length of each window of events which percentiles are computed over
+	Window time.Duration
+	// Windows with fewer events than this are not checked against the SLA, so
+	// that a handful of stragglers don't trigger an alert
+	MinEvents int
+	// Called (in a new goroutine) when an SLA breach starts or ends. Optional;
+	// breaches are always logged.
+	OnAlert func(AgeAlert)
+	Logger  *slog.Logger
+
+	lk          sync.Mutex
+	windowStart time.Time
+	samples     []time.Duration
+	seen        int
+	breached    bool
+	last        *AgeWindow
+}
+
+// maximum number of ages kept per window; beyond this the window is sampled
+const ageWatchdogMaxSamples = 10_000
+
+// AgeWindow summarizes event ages over a single window
+type AgeWindow struct {
+	Start  time.Time     `json:"start"`
+	End    time.Time     `json:"end"`
+	Events int           `json:"events"`
+	P50    time.Duration `json:"p50"`
+	P90    time.Duration `json:"p90"`
+	P99    time.Duration `json:"p99"`
+	Max    time.Duration `json:"max"`
+	// age at the watchdog's configured percentile
+	Age time.Duration `json:"age"`
+}
+
+// AgeAlert is passed to AgeWatchdog.OnAlert when an SLA breach starts
+// (Breached is true) or ends
+type AgeAlert struct {
+	Name       string        `json:"name"`
+	Breached   bool          `json:"breached"`
+	SLA        time.Duration `json:"sla"`
+	Percentile float64       `json:"percentile"`
+	Window     AgeWindow     `json:"window"`
+}
+
+func (a AgeAlert) String() string {
+	if a.Breached {
+		return fmt.Sprintf("%s: event age SLA breached: p%g age %s exceeds %s (%d events)", a.Name, a.Percentile*100, a.Window.Age.Round(time.Millisecond), a.SLA, a.Window.Events)
+	}
+	return fmt.Sprintf("%s: event age back within SLA: p%g age %s (%d events)", a.Name, a.Percentile*100, a.Window.Age.Round(time.Millisecond), a.Window.Events)
+}
+
+func NewAgeWatchdog(name string, sla time.Duration, next func(ctx context.Context, xev *XRPCStreamEvent) error) *AgeWatchdog {
+	return &AgeWatchdog{
+		Name:       name,
+		Next:       next,
+		SLA:        sla,
+		Percentile: 0.99,
+		Window:     time.Minute,
+		MinEvents:  100,
+	}
+}
+
+// EventHandler measures the age of the event, then passes it on to the next
+// handler (if any)
+func (aw *AgeWatchdog) EventHandler(ctx context.Context, xev *XRPCStreamEvent) error {
+	aw.Observe(xev)
+	if aw.Next != nil {
+		return aw.Next(ctx, xev)
+	}
+	return nil
+}
+
+// Observe measures the age of the event as of now
+func (aw *AgeWatchdog) Observe(xev *XRPCStreamEvent) {
+	created := eventCreatedAt(xev)
+	if created.IsZero() {
+		return
+	}
+	aw.ObserveAge(time.Now(), time.Since(created))
+}
+
+// ObserveAge records an event age, measured at the given time
+func (aw *AgeWatchdog) ObserveAge(now time.Time, age time.Duration) {
+	// clock skew between hosts can make fresh events look like they are from the future
+	age = max(age, 0)
+	eventAgeHistogram.WithLabelValues(aw.Name).Observe(age.Seconds())
+
+	aw.lk.Lock()
+	defer aw.lk.Unlock()
+	if aw.windowStart.IsZero() {
+		aw.windowStart = now
+	}
+	if now.Sub(aw.windowStart) >= aw.Window {
+		aw.closeWindow(now)
+	}
+
+	// reservoir sampling, so long windows of busy streams have bounded memory
+	aw.seen++
+	if len(aw.samples) < ageWatchdogMaxSamples {
+		aw.samples = append(aw.samples, age)
+	} else if i := rand.Intn(aw.seen); i < ageWatchdogMaxSamples {
+		aw.samples[i] = age
+	}
+}
+
+// Last returns the summary of the most recently completed window, or nil if
+// no window has completed yet
+func (aw *AgeWatchdog) Last() *AgeWindow {
+	aw.lk.Lock()
+	defer aw.lk.Unlock()
+	if aw.last == nil {
+		return nil
+	}
+	w := *aw.last
+	return &w
+}
+
+// Breached returns whether the SLA is currently breached
+func (aw *AgeWatchdog) Breached() bool {
+	aw.lk.Lock()
+	defer aw.lk.Unlock()
+	return aw.breached
+}
+
+func agePercentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(p * float64(len(sorted)-1))
+	return sorted[min(max(i, 0), len(sorted)-1)]
+}
+
+// summarizes the current window, checks it against the SLA, and starts a new
+// one. must be called with the lock held
+func (aw *AgeWatchdog) closeWindow(now time.Time) {
+	samples := aw.samples
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	w := &AgeWindow{
+		Start:  aw.windowStart,
+		End:    now,
+		Events: aw.seen,
+		P50:    agePercentile(samples, 0.5),
+		P90:    agePercentile(samples, 0.9),
+		P99:    agePercentile(samples, 0.99),
+		Age:    agePercentile(samples, aw.Percentile),
+	}
+	if len(samples) > 0 {
+		w.Max = samples[len(samples)-1]
+	}
+	aw.last = w
+	aw.windowStart = now
+	aw.samples = samples[:0]
+	aw.seen = 0
+
+	eventAgePercentile.WithLabelValues(aw.Name, "0.5").Set(w.P50.Seconds())
+	eventAgePercentile.WithLabelValues(aw.Name, "0.9").Set(w.P90.Seconds())
+	eventAgePercentile.WithLabelValues(aw.Name, "0.99").Set(w.P99.Seconds())
+
+	if aw.SLA <= 0 || w.Events < aw.MinEvents {
+		return
+	}
+	breached := w.Age > aw.SLA
+	if breached == aw.breached {
+		return
+	}
+	aw.breached = breached
+
+	alert := AgeAlert{
+		Name:       aw.Name,
+		Breached:   breached,
+		SLA:        aw.SLA,
+		Percentile: aw.Percentile,
+		Window:     *w,
+	}
+	log := aw.Logger
+	if log == nil {
+		log = slog.Default().With("system", "events")
+	}
+	if breached {
+		eventAgeBreaches.WithLabelValues(aw.Name).Inc()
+		eventAgeBreached.WithLabelValues(aw.Name).Set(1)
+		log.Warn("event age SLA breached", "name", aw.Name, "age", w.Age, "sla", aw.SLA, "percentile", aw.Percentile, "events", w.Events)
+	} else {
+		eventAgeBreached.WithLabelValues(aw.Name).Set(0)
+		log.Info("event age back within SLA", "name", aw.Name, "age", w.Age, "sla", aw.SLA, "percentile", aw.Percentile, "events", w.Events)
+	}
+	if aw.OnAlert != nil {
+		go aw.OnAlert(alert)
+	}
+}
+
+// NewWebhookAlerter returns an AgeWatchdog.OnAlert function which posts alerts
+// to a webhook URL, as JSON with the alert text in a "text" field (the format
+// used by Slack incoming webhooks), along with the alert itself.
+func NewWebhookAlerter(url string, logger *slog.Logger) func(AgeAlert) {
+	if logger == nil {
+		logger = slog.Default().With("system", "events")
+	}
+	return func(a AgeAlert) {
+		body, err := json.Marshal(struct {
+			Text  string   `json:"text"`
+			Alert AgeAlert `json:"alert"`
+		}{Text: a.String(), Alert: a})
+		if err != nil {
+			logger.Error("failed to encode event age alert", "err", err)
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			logger.Error("failed to send event age alert", "err", err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			logger.Error("failed to send event age alert", "err", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			logger.Error("failed to send event age alert", "status", resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAgeWatchdog(t *testing.T) {
+	assert := assert.New(t)
+
+	alerts := make(chan AgeAlert, 10)
+	aw := NewAgeWatchdog("test", 10*time.Second, nil)
+	aw.MinEvents = 10
+	aw.OnAlert = func(a AgeAlert) { alerts <- a }
+
+	start := time.Now()
+	fill := func(w int, age func(i int) time.Duration, n int) {
+		for i := 0; i < n; i++ {
+			aw.ObserveAge(start.Add(time.Duration(w)*time.Minute+time.Duration(i)*time.Millisecond), age(i))
+		}
+	}
+
+	// healthy window
+	fill(0, func(i int) time.Duration { return time.Duration(i) * time.Millisecond }, 100)
+	assert.Nil(aw.Last())
+
+	// a few slow events aren't enough to move p99
+	fill(1, func(i int) time.Duration {
+		if i == 0 {
+			return time.Minute
+		}
+		return time.Second
+	}, 200)
+	w := aw.Last()
+	assert.Equal(100, w.Events)
+	assert.Equal(49*time.Millisecond, w.P50)
+	assert.Equal(99*time.Millisecond, w.Max)
+	assert.False(aw.Breached())
+
+	// lagging window
+	fill(2, func(i int) time.Duration { return 30 * time.Second }, 100)
+	assert.Equal(time.Second, aw.Last().P99)
+	assert.Equal(time.Minute, aw.Last().Max)
+	assert.False(aw.Breached())
+	fill(3, func(i int) time.Duration { return time.Second }, 5)
+	assert.True(aw.Breached())
+	a := <-alerts
+	assert.True(a.Breached)
+	assert.Equal(30*time.Second, a.Window.Age)
+	assert.Contains(a.String(), "breached")
+
+	// windows with too few events don't change the state
+	fill(4, func(i int) time.Duration { return time.Second }, 5)
+	assert.True(aw.Breached())
+	fill(5, func(i int) time.Duration { return time.Second }, 100)
+	fill(6, func(i int) time.Duration { return time.Second }, 1)
+	assert.False(aw.Breached())
+	a = <-alerts
+	assert.False(a.Breached)
+}
+
+func TestAgeWatchdogHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	var got AgeAlert
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Text  string
+			Alert AgeAlert
+		}
+		assert.NoError(json.NewDecoder(r.Body).Decode(&body))
+		assert.Contains(body.Text, "handler: event age SLA breached")
+		got = body.Alert
+		close(done)
+	}))
+	defer srv.Close()
+
+	handled := 0
+	aw := NewAgeWatchdog("handler", time.Second, func(ctx context.Context, xev *XRPCStreamEvent) error {
+		handled++
+		return nil
+	})
+	aw.Window = 0
+	aw.MinEvents = 1
+	aw.OnAlert = NewWebhookAlerter(srv.URL, nil)
+
+	old := syntax.DatetimeNow().Time().Add(-time.Minute).Format(syntax.AtprotoDatetimeLayout)
+	for i := 0; i < 2; i++ {
+		assert.NoError(aw.EventHandler(context.Background(), &XRPCStreamEvent{RepoCommit: &comatproto.SyncSubscribeRepos_Commit{Time: old}}))
+	}
+	// events without a timestamp are passed through, but not measured
+	assert.NoError(aw.EventHandler(context.Background(), &XRPCStreamEvent{RepoInfo: &comatproto.SyncSubscribeRepos_Info{}}))
+	assert.Equal(3, handled)
+
+	<-done
+	assert.True(got.Breached)
+	assert.Equal(1, got.Window.Events)
+}
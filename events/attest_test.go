@@ -0,0 +1,97 @@
+package events
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAttestedFrames(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	key, err := crypto.GeneratePrivateKeyP256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := key.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	attestor, err := NewAttestor(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	em := NewEventManager(NewMemPersister())
+	em.SetAttestor(attestor)
+
+	live, cleanup, err := em.Subscribe(ctx, "live", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	evt := &XRPCStreamEvent{RepoIdentity: &comatproto.SyncSubscribeRepos_Identity{
+		Did:  "did:example:abc",
+		Time: time.Now().UTC().Format(time.RFC3339Nano),
+	}}
+	if err := em.AddEvent(ctx, evt); err != nil {
+		t.Fatal(err)
+	}
+
+	readFrame := func(evt *XRPCStreamEvent) []byte {
+		server, client := testWebsocketPair(t)
+		assert.NoError(WriteEvent(server, evt))
+		_, msg, err := client.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+
+	frame := readFrame(<-live)
+	got, err := VerifyAttestedFrame(pub, frame)
+	assert.NoError(err)
+	if assert.NotNil(got) {
+		assert.Equal(evt.Sequence(), got.Sequence())
+		assert.Equal("did:example:abc", got.RepoIdentity.Did)
+	}
+
+	// tampering with the body is detected
+	tampered := append([]byte{}, frame...)
+	tampered[len(tampered)-1] ^= 0xff
+	_, err = VerifyAttestedFrame(pub, tampered)
+	assert.Error(err)
+
+	// so is a different key
+	other, err := crypto.GeneratePrivateKeyP256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, err := other.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = VerifyAttestedFrame(otherPub, frame)
+	assert.Error(err)
+
+	// playback is attested too
+	replay, cleanup2, err := em.Subscribe(ctx, "playback", nil, new(int64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup2()
+	_, err = VerifyAttestedFrame(pub, readFrame(<-replay))
+	assert.NoError(err)
+
+	// unattested frames are reported as such
+	plain := &XRPCStreamEvent{RepoIdentity: &comatproto.SyncSubscribeRepos_Identity{Did: "did:example:abc", Seq: 1}}
+	_, err = VerifyAttestedFrame(pub, readFrame(plain))
+	assert.ErrorIs(err, ErrAttestationMissing)
+}
@@ -25,8 +25,13 @@ func (t *EventHeader) MarshalCBOR(w io.Writer) error {
 	}
 
 	cw := cbg.NewCborWriter(w)
+	fieldCount := 3
 
-	if _, err := cw.Write([]byte{162}); err != nil {
+	if t.Attestation == nil {
+		fieldCount--
+	}
+
+	if _, err := cw.Write(cbg.CborEncodeMajorType(cbg.MajMap, uint64(fieldCount))); err != nil {
 		return err
 	}
 
@@ -75,6 +80,24 @@ func (t *EventHeader) MarshalCBOR(w io.Writer) error {
 		}
 	}
 
+	// t.Attestation (events.FrameAttestation) (struct)
+	if t.Attestation != nil {
+
+		if len("att") > 1000000 {
+			return xerrors.Errorf("Value in field \"att\" was too long")
+		}
+
+		if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("att"))); err != nil {
+			return err
+		}
+		if _, err := cw.WriteString(string("att")); err != nil {
+			return err
+		}
+
+		if err := t.Attestation.MarshalCBOR(cw); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -103,7 +126,7 @@ func (t *EventHeader) UnmarshalCBOR(r io.Reader) (err error) {
 
 	n := extra
 
-	nameBuf := make([]byte, 2)
+	nameBuf := make([]byte, 3)
 	for i := uint64(0); i < n; i++ {
 		nameLen, ok, err := cbg.ReadFullStringIntoBuf(cr, nameBuf, 1000000)
 		if err != nil {
@@ -156,6 +179,26 @@ func (t *EventHeader) UnmarshalCBOR(r io.Reader) (err error) {
 
 				t.Op = int64(extraI)
 			}
+			// t.Attestation (events.FrameAttestation) (struct)
+		case "att":
+
+			{
+
+				b, err := cr.ReadByte()
+				if err != nil {
+					return err
+				}
+				if b != cbg.CborNull[0] {
+					if err := cr.UnreadByte(); err != nil {
+						return err
+					}
+					t.Attestation = new(FrameAttestation)
+					if err := t.Attestation.UnmarshalCBOR(cr); err != nil {
+						return xerrors.Errorf("unmarshaling t.Attestation pointer: %w", err)
+					}
+				}
+
+			}
 
 		default:
 			// Field doesn't exist on this type, so ignore it
@@ -301,3 +344,211 @@ func (t *ErrorFrame) UnmarshalCBOR(r io.Reader) (err error) {
 
 	return nil
 }
+func (t *FrameAttestation) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+
+	cw := cbg.NewCborWriter(w)
+
+	if _, err := cw.Write([]byte{163}); err != nil {
+		return err
+	}
+
+	// t.Seq (int64) (int64)
+	if len("seq") > 1000000 {
+		return xerrors.Errorf("Value in field \"seq\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("seq"))); err != nil {
+		return err
+	}
+	if _, err := cw.WriteString(string("seq")); err != nil {
+		return err
+	}
+
+	if t.Seq >= 0 {
+		if err := cw.WriteMajorTypeHeader(cbg.MajUnsignedInt, uint64(t.Seq)); err != nil {
+			return err
+		}
+	} else {
+		if err := cw.WriteMajorTypeHeader(cbg.MajNegativeInt, uint64(-t.Seq-1)); err != nil {
+			return err
+		}
+	}
+
+	// t.Sig ([]uint8) (slice)
+	if len("sig") > 1000000 {
+		return xerrors.Errorf("Value in field \"sig\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("sig"))); err != nil {
+		return err
+	}
+	if _, err := cw.WriteString(string("sig")); err != nil {
+		return err
+	}
+
+	if len(t.Sig) > 2097152 {
+		return xerrors.Errorf("Byte array in field t.Sig was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajByteString, uint64(len(t.Sig))); err != nil {
+		return err
+	}
+
+	if _, err := cw.Write(t.Sig); err != nil {
+		return err
+	}
+
+	// t.Hash ([]uint8) (slice)
+	if len("hash") > 1000000 {
+		return xerrors.Errorf("Value in field \"hash\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("hash"))); err != nil {
+		return err
+	}
+	if _, err := cw.WriteString(string("hash")); err != nil {
+		return err
+	}
+
+	if len(t.Hash) > 2097152 {
+		return xerrors.Errorf("Byte array in field t.Hash was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajByteString, uint64(len(t.Hash))); err != nil {
+		return err
+	}
+
+	if _, err := cw.Write(t.Hash); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (t *FrameAttestation) UnmarshalCBOR(r io.Reader) (err error) {
+	*t = FrameAttestation{}
+
+	cr := cbg.NewCborReader(r)
+
+	maj, extra, err := cr.ReadHeader()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}()
+
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("FrameAttestation: map struct too large (%d)", extra)
+	}
+
+	n := extra
+
+	nameBuf := make([]byte, 4)
+	for i := uint64(0); i < n; i++ {
+		nameLen, ok, err := cbg.ReadFullStringIntoBuf(cr, nameBuf, 1000000)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			// Field doesn't exist on this type, so ignore it
+			if err := cbg.ScanForLinks(cr, func(cid.Cid) {}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		switch string(nameBuf[:nameLen]) {
+		// t.Seq (int64) (int64)
+		case "seq":
+			{
+				maj, extra, err := cr.ReadHeader()
+				if err != nil {
+					return err
+				}
+				var extraI int64
+				switch maj {
+				case cbg.MajUnsignedInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 positive overflow")
+					}
+				case cbg.MajNegativeInt:
+					extraI = int64(extra)
+					if extraI < 0 {
+						return fmt.Errorf("int64 negative overflow")
+					}
+					extraI = -1 - extraI
+				default:
+					return fmt.Errorf("wrong type for int64 field: %d", maj)
+				}
+
+				t.Seq = int64(extraI)
+			}
+			// t.Sig ([]uint8) (slice)
+		case "sig":
+
+			maj, extra, err = cr.ReadHeader()
+			if err != nil {
+				return err
+			}
+
+			if extra > 2097152 {
+				return fmt.Errorf("t.Sig: byte array too large (%d)", extra)
+			}
+			if maj != cbg.MajByteString {
+				return fmt.Errorf("expected byte array")
+			}
+
+			if extra > 0 {
+				t.Sig = make([]uint8, extra)
+			}
+
+			if _, err := io.ReadFull(cr, t.Sig); err != nil {
+				return err
+			}
+
+			// t.Hash ([]uint8) (slice)
+		case "hash":
+
+			maj, extra, err = cr.ReadHeader()
+			if err != nil {
+				return err
+			}
+
+			if extra > 2097152 {
+				return fmt.Errorf("t.Hash: byte array too large (%d)", extra)
+			}
+			if maj != cbg.MajByteString {
+				return fmt.Errorf("expected byte array")
+			}
+
+			if extra > 0 {
+				t.Hash = make([]uint8, extra)
+			}
+
+			if _, err := io.ReadFull(cr, t.Hash); err != nil {
+				return err
+			}
+
+		default:
+			// Field doesn't exist on this type, so ignore it
+			if err := cbg.ScanForLinks(r, func(cid.Cid) {}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,372 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// Label values used in place of a collection or host which isn't exported as
+// its own metric label.
+const (
+	CollectionStatsOther   = "_other"
+	CollectionStatsInvalid = "_invalid"
+	CollectionStatsUnknown = "unknown"
+)
+
+// CollectionStats tallies repo commit operations from a firehose by record
+// collection (NSID), by the PDS host the account is on, and by op type
+// (create, update, delete). Totals are exported as Prometheus counters, and a
+// rolling window of counts is kept in memory to report the most active
+// collections and hosts, and any collections which were seen for the first
+// time within the window (eg, a newly deployed lexicon).
+//
+// It can either be called directly with Observe (when the caller knows which
+// host an event came from, as in the relay), or wrap another event handler
+// with Next (in which case hosts are looked up with HostFunc, if set).
+type CollectionStats struct {
+	// Optional next handler in the pipeline; used by EventHandler
+	Next func(ctx context.Context, xev *XRPCStreamEvent) error
+	// Optional function resolving the PDS host for an account DID. If unset,
+	// or if it returns an empty string, the host is counted as "unknown"
+	HostFunc func(ctx context.Context, did string) string
+
+	// Length of the rolling window kept for reports
+	Window time.Duration
+	// Maximum number of distinct collections and hosts (each) which get their
+	// own metric label; once reached, further values are counted as "_other".
+	// Reports are not subject to this limit.
+	MaxLabels int
+	// Maximum number of distinct collections remembered for detecting new
+	// ones. Once reached, collections not yet seen are still counted, but
+	// won't be reported as new
+	MaxKnownCollections int
+
+	lk          sync.Mutex
+	started     time.Time
+	buckets     []*collectionStatsBucket
+	labelColls  map[string]bool
+	labelHosts  map[string]bool
+	knownColls  map[string]time.Time
+	bucketWidth time.Duration
+}
+
+// number of buckets the rolling window is split in to
+const collectionStatsBuckets = 60
+
+type collectionStatsBucket struct {
+	start       time.Time
+	events      int64
+	collections map[string]*OpCounts
+	hosts       map[string]*OpCounts
+	// accounts with ops in each collection
+	dids map[string]*didSketch
+}
+
+// OpCounts is a count of repo ops, by type
+type OpCounts struct {
+	Total  int64 `json:"total"`
+	Create int64 `json:"create"`
+	Update int64 `json:"update"`
+	Delete int64 `json:"delete"`
+}
+
+func (c *OpCounts) add(action string, n int64) {
+	c.Total += n
+	switch action {
+	case "create":
+		c.Create += n
+	case "update":
+		c.Update += n
+	case "delete":
+		c.Delete += n
+	}
+}
+
+func (c *OpCounts) merge(o *OpCounts) {
+	c.Total += o.Total
+	c.Create += o.Create
+	c.Update += o.Update
+	c.Delete += o.Delete
+}
+
+// NamedOpCounts is the op counts for a single collection or host
+type NamedOpCounts struct {
+	Name string `json:"name"`
+	OpCounts
+}
+
+// NewCollection is a collection first seen within the report window
+type NewCollection struct {
+	Collection string    `json:"collection"`
+	FirstSeen  time.Time `json:"firstSeen"`
+}
+
+// CollectionStatsReport summarizes the rolling window of a CollectionStats
+type CollectionStatsReport struct {
+	Since time.Time `json:"since"`
+	// Number of commit events in the window
+	Events int64 `json:"events"`
+	// Number of ops in those commits
+	Ops            int64           `json:"ops"`
+	Collections    []NamedOpCounts `json:"collections"`
+	Hosts          []NamedOpCounts `json:"hosts"`
+	NewCollections []NewCollection `json:"newCollections"`
+}
+
+func NewCollectionStats(next func(ctx context.Context, xev *XRPCStreamEvent) error) *CollectionStats {
+	return &CollectionStats{
+		Next:                next,
+		Window:              time.Hour,
+		MaxLabels:           500,
+		MaxKnownCollections: 50_000,
+	}
+}
+
+// EventHandler tallies the event, then passes it on to the next handler (if
+// any). Hosts are resolved with HostFunc.
+func (cs *CollectionStats) EventHandler(ctx context.Context, xev *XRPCStreamEvent) error {
+	if xev.RepoCommit != nil {
+		host := ""
+		if cs.HostFunc != nil {
+			host = cs.HostFunc(ctx, xev.RepoCommit.Repo)
+		}
+		cs.Observe(host, xev)
+	}
+	if cs.Next != nil {
+		return cs.Next(ctx, xev)
+	}
+	return nil
+}
+
+// Observe tallies the ops of a commit event, received from the given PDS
+// host. Other event types are ignored.
+func (cs *CollectionStats) Observe(host string, xev *XRPCStreamEvent) {
+	evt := xev.RepoCommit
+	if evt == nil || len(evt.Ops) == 0 {
+		return
+	}
+	if host == "" {
+		host = CollectionStatsUnknown
+	}
+
+	now := time.Now()
+	cs.lk.Lock()
+	defer cs.lk.Unlock()
+
+	b := cs.currentBucket(now)
+	b.events++
+	hostLabel := cs.label(cs.labelHosts, host)
+	for _, op := range evt.Ops {
+		coll := collectionForPath(op.Path)
+		if coll != CollectionStatsInvalid {
+			if _, ok := cs.knownColls[coll]; !ok && len(cs.knownColls) < cs.maxKnown() {
+				cs.knownColls[coll] = now
+				newCollectionsSeen.Inc()
+			}
+		}
+
+		c, ok := b.collections[coll]
+		if !ok {
+			c = &OpCounts{}
+			b.collections[coll] = c
+		}
+		c.add(op.Action, 1)
+		h, ok := b.hosts[host]
+		if !ok {
+			h = &OpCounts{}
+			b.hosts[host] = h
+		}
+		h.add(op.Action, 1)
+		if coll != CollectionStatsInvalid {
+			d, ok := b.dids[coll]
+			if !ok {
+				d = &didSketch{}
+				b.dids[coll] = d
+			}
+			d.add(evt.Repo)
+		}
+
+		collectionOpsCounter.WithLabelValues(cs.label(cs.labelColls, coll), op.Action).Inc()
+		hostOpsCounter.WithLabelValues(hostLabel, op.Action).Inc()
+	}
+}
+
+// returns the collection part of a repo path, or "_invalid" if it isn't a
+// valid NSID
+func collectionForPath(path string) string {
+	coll, _, _ := strings.Cut(path, "/")
+	if _, err := syntax.ParseNSID(coll); err != nil {
+		return CollectionStatsInvalid
+	}
+	return coll
+}
+
+// returns the metric label to use for a value, registering it if there's
+// room. must be called with lock held
+func (cs *CollectionStats) label(labels map[string]bool, v string) string {
+	if v == CollectionStatsInvalid || v == CollectionStatsUnknown || labels[v] {
+		return v
+	}
+	max := cs.MaxLabels
+	if max <= 0 {
+		max = 500
+	}
+	if len(labels) >= max {
+		return CollectionStatsOther
+	}
+	labels[v] = true
+	return v
+}
+
+func (cs *CollectionStats) maxKnown() int {
+	if cs.MaxKnownCollections <= 0 {
+		return 50_000
+	}
+	return cs.MaxKnownCollections
+}
+
+// returns the bucket for the current time, rotating out any which have fallen
+// out of the window. must be called with lock held
+func (cs *CollectionStats) currentBucket(now time.Time) *collectionStatsBucket {
+	if cs.started.IsZero() {
+		window := cs.Window
+		if window <= 0 {
+			window = time.Hour
+		}
+		cs.bucketWidth = max(window/collectionStatsBuckets, time.Second)
+		cs.started = now
+		cs.labelColls = make(map[string]bool)
+		cs.labelHosts = make(map[string]bool)
+		cs.knownColls = make(map[string]time.Time)
+	}
+
+	start := now.Truncate(cs.bucketWidth)
+	if n := len(cs.buckets); n > 0 && cs.buckets[n-1].start.Equal(start) {
+		return cs.buckets[n-1]
+	}
+
+	cs.expire(now)
+	b := &collectionStatsBucket{
+		start:       start,
+		collections: make(map[string]*OpCounts),
+		hosts:       make(map[string]*OpCounts),
+		dids:        make(map[string]*didSketch),
+	}
+	cs.buckets = append(cs.buckets, b)
+	return b
+}
+
+// drops buckets which are entirely outside the window. must be called with
+// lock held
+func (cs *CollectionStats) expire(now time.Time) {
+	cutoff := cs.windowStart(now)
+	i := 0
+	for i < len(cs.buckets) && cs.buckets[i].start.Add(cs.bucketWidth).Before(cutoff) {
+		i++
+	}
+	cs.buckets = cs.buckets[i:]
+}
+
+func (cs *CollectionStats) windowStart(now time.Time) time.Time {
+	return now.Add(-cs.bucketWidth * collectionStatsBuckets)
+}
+
+// Report returns the top collections and hosts by op count over the rolling
+// window (at most limit of each; zero for all), along with collections first
+// seen within the window.
+func (cs *CollectionStats) Report(limit int) *CollectionStatsReport {
+	now := time.Now()
+	cs.lk.Lock()
+	defer cs.lk.Unlock()
+
+	rep := &CollectionStatsReport{
+		Since:          now,
+		Collections:    []NamedOpCounts{},
+		Hosts:          []NamedOpCounts{},
+		NewCollections: []NewCollection{},
+	}
+	if cs.started.IsZero() {
+		return rep
+	}
+	cs.expire(now)
+
+	colls := make(map[string]*OpCounts)
+	hosts := make(map[string]*OpCounts)
+	for _, b := range cs.buckets {
+		rep.Events += b.events
+		for name, c := range b.collections {
+			rep.Ops += c.Total
+			mergeCounts(colls, name, c)
+		}
+		for name, c := range b.hosts {
+			mergeCounts(hosts, name, c)
+		}
+	}
+	rep.Since = cs.windowStart(now)
+	if cs.started.After(rep.Since) {
+		rep.Since = cs.started
+	}
+	rep.Collections = topCounts(colls, limit)
+	rep.Hosts = topCounts(hosts, limit)
+
+	// collections seen when stats started aren't really new
+	for coll, first := range cs.knownColls {
+		if first.After(rep.Since) && first.Sub(cs.started) > cs.bucketWidth {
+			rep.NewCollections = append(rep.NewCollections, NewCollection{Collection: coll, FirstSeen: first})
+		}
+	}
+	sort.Slice(rep.NewCollections, func(i, j int) bool {
+		return rep.NewCollections[i].FirstSeen.After(rep.NewCollections[j].FirstSeen)
+	})
+	return rep
+}
+
+func mergeCounts(m map[string]*OpCounts, name string, c *OpCounts) {
+	t, ok := m[name]
+	if !ok {
+		t = &OpCounts{}
+		m[name] = t
+	}
+	t.merge(c)
+}
+
+func topCounts(m map[string]*OpCounts, limit int) []NamedOpCounts {
+	out := make([]NamedOpCounts, 0, len(m))
+	for name, c := range m {
+		out = append(out, NamedOpCounts{Name: name, OpCounts: *c})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Total != out[j].Total {
+			return out[i].Total > out[j].Total
+		}
+		return out[i].Name < out[j].Name
+	})
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+// ServeHTTP responds with a JSON CollectionStatsReport. The optional "limit"
+// query parameter bounds the number of collections and hosts (default 50).
+func (cs *CollectionStats) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cs.Report(limit))
+}
@@ -0,0 +1,188 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+)
+
+func commitEvent(did string, ops ...*comatproto.SyncSubscribeRepos_RepoOp) *XRPCStreamEvent {
+	return &XRPCStreamEvent{RepoCommit: &comatproto.SyncSubscribeRepos_Commit{Repo: did, Ops: ops}}
+}
+
+func repoOp(action, path string) *comatproto.SyncSubscribeRepos_RepoOp {
+	return &comatproto.SyncSubscribeRepos_RepoOp{Action: action, Path: path}
+}
+
+func TestCollectionStats(t *testing.T) {
+	ctx := context.Background()
+
+	passed := 0
+	cs := NewCollectionStats(func(ctx context.Context, xev *XRPCStreamEvent) error {
+		passed++
+		return nil
+	})
+	cs.MaxLabels = 2
+	cs.HostFunc = func(ctx context.Context, did string) string {
+		if did == "did:plc:alice" {
+			return "pds.example.com"
+		}
+		return ""
+	}
+
+	events := []*XRPCStreamEvent{
+		commitEvent("did:plc:alice",
+			repoOp("create", "app.bsky.feed.post/3kaaaaaaaaa22"),
+			repoOp("create", "app.bsky.feed.like/3kaaaaaaaaa22"),
+		),
+		commitEvent("did:plc:alice", repoOp("delete", "app.bsky.feed.post/3kaaaaaaaaa22")),
+		commitEvent("did:plc:bob", repoOp("update", "app.bsky.actor.profile/self")),
+		commitEvent("did:plc:bob", repoOp("create", "not a collection/abc")),
+		{RepoIdentity: &comatproto.SyncSubscribeRepos_Identity{Did: "did:plc:bob"}},
+	}
+	for _, xev := range events {
+		if err := cs.EventHandler(ctx, xev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if passed != len(events) {
+		t.Fatalf("expected all %d events passed on, got %d", len(events), passed)
+	}
+
+	rep := cs.Report(0)
+	if rep.Events != 4 || rep.Ops != 5 {
+		t.Fatalf("unexpected totals: %d events, %d ops", rep.Events, rep.Ops)
+	}
+	if len(rep.Collections) != 4 {
+		t.Fatalf("expected 4 collections, got %v", rep.Collections)
+	}
+	top := rep.Collections[0]
+	if top.Name != "app.bsky.feed.post" || top.Total != 2 || top.Create != 1 || top.Delete != 1 {
+		t.Fatalf("unexpected top collection: %+v", top)
+	}
+	found := false
+	for _, c := range rep.Collections {
+		if c.Name == CollectionStatsInvalid {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected invalid collection to be counted: %v", rep.Collections)
+	}
+	if len(rep.Hosts) != 2 || rep.Hosts[0].Name != "pds.example.com" || rep.Hosts[0].Total != 3 || rep.Hosts[1].Name != CollectionStatsUnknown {
+		t.Fatalf("unexpected hosts: %v", rep.Hosts)
+	}
+	// everything was seen when stats started
+	if len(rep.NewCollections) != 0 {
+		t.Fatalf("unexpected new collections: %v", rep.NewCollections)
+	}
+
+	if rep := cs.Report(1); len(rep.Collections) != 1 || len(rep.Hosts) != 1 {
+		t.Fatalf("limit not applied: %v %v", rep.Collections, rep.Hosts)
+	}
+
+	// only MaxLabels collections get their own metric label
+	if len(cs.labelColls) != 2 {
+		t.Fatalf("expected 2 labeled collections, got %v", cs.labelColls)
+	}
+	if l := cs.label(cs.labelColls, "com.example.overflow"); l != CollectionStatsOther {
+		t.Fatalf("expected overflow label, got %s", l)
+	}
+
+	// pretend stats have been running for a while, and a new lexicon shows up
+	cs.lk.Lock()
+	cs.started = cs.started.Add(-10 * time.Minute)
+	for coll, first := range cs.knownColls {
+		cs.knownColls[coll] = first.Add(-10 * time.Minute)
+	}
+	cs.lk.Unlock()
+	cs.Observe("pds.example.com", commitEvent("did:plc:alice", repoOp("create", "com.example.fresh/3kaaaaaaaaa22")))
+	rep = cs.Report(0)
+	if len(rep.NewCollections) != 1 || rep.NewCollections[0].Collection != "com.example.fresh" {
+		t.Fatalf("expected new collection, got %v", rep.NewCollections)
+	}
+}
+
+func TestCollectionStatsWindow(t *testing.T) {
+	cs := NewCollectionStats(nil)
+	cs.Window = time.Minute
+	cs.Observe("", commitEvent("did:plc:alice", repoOp("create", "app.bsky.feed.post/3kaaaaaaaaa22")))
+
+	// age the bucket out of the window
+	cs.lk.Lock()
+	for _, b := range cs.buckets {
+		b.start = b.start.Add(-2 * time.Minute)
+	}
+	cs.lk.Unlock()
+
+	rep := cs.Report(0)
+	if rep.Ops != 0 || len(rep.Collections) != 0 {
+		t.Fatalf("expected expired counts, got %+v", rep)
+	}
+}
+
+func TestLexiconReport(t *testing.T) {
+	cs := NewCollectionStats(nil)
+	cs.Window = time.Hour
+
+	for i := 0; i < 3; i++ {
+		cs.Observe("", commitEvent("did:plc:alice", repoOp("create", "com.example.thing/3kaaaaaaaaa22")))
+	}
+	cs.Observe("", commitEvent("did:plc:bob", repoOp("create", "com.example.thing/3kaaaaaaaaa23"), repoOp("update", "com.example.other/self")))
+	cs.Observe("", commitEvent("did:plc:bob", repoOp("create", "app.bsky.feed.post/3kaaaaaaaaa22")))
+	cs.Observe("", commitEvent("did:plc:bob", repoOp("create", "not a collection/abc")))
+
+	rep := cs.LexiconReport([]time.Duration{2 * time.Hour, 5 * time.Minute}, "com.example.", 0)
+	if len(rep.Collections) != 2 {
+		t.Fatalf("expected 2 collections, got %+v", rep.Collections)
+	}
+	top := rep.Collections[0]
+	if top.Collection != "com.example.thing" || len(top.Windows) != 2 {
+		t.Fatalf("unexpected top collection: %+v", top)
+	}
+	// windows are sorted, and capped to the stats window
+	if top.Windows[0].Window != "5m0s" || top.Windows[1].Window != "1h0m0s" {
+		t.Fatalf("unexpected windows: %+v", top.Windows)
+	}
+	for _, w := range top.Windows {
+		if w.Total != 4 || w.Create != 4 || w.UniqueDIDs != 2 {
+			t.Fatalf("unexpected window stats: %+v", w)
+		}
+	}
+
+	rep = cs.LexiconReport(nil, "", 1)
+	if len(rep.Collections) != 1 || rep.Collections[0].Collection != "com.example.thing" {
+		t.Fatalf("unexpected limited report: %+v", rep.Collections)
+	}
+	if len(rep.Collections[0].Windows) != 2 {
+		t.Fatalf("expected default windows capped to stats window: %+v", rep.Collections[0].Windows)
+	}
+}
+
+func TestDIDSketch(t *testing.T) {
+	exact := &didSketch{}
+	big := &didSketch{}
+	for i := 0; i < 100; i++ {
+		exact.add(fmt.Sprintf("did:plc:%d", i))
+	}
+	for i := 0; i < 20000; i++ {
+		big.add(fmt.Sprintf("did:plc:%d", i))
+	}
+	if exact.count() != 100 {
+		t.Fatalf("expected exact count for small sketch, got %d", exact.count())
+	}
+	if n := big.count(); n < 18000 || n > 22000 {
+		t.Fatalf("estimate too far off: %d", n)
+	}
+
+	// merging sketches counts accounts in both once
+	merged := &didSketch{}
+	merged.merge(exact)
+	merged.merge(big)
+	if n := merged.count(); n != big.count() {
+		t.Fatalf("expected merge to be idempotent for overlapping accounts: %d vs %d", n, big.count())
+	}
+}
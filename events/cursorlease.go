@@ -0,0 +1,443 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrLeaseHeld is returned when acquiring a lease which another holder has
+	// not yet released or let expire
+	ErrLeaseHeld = errors.New("cursor lease is held by another consumer")
+	// ErrLeaseLost is returned when renewing or committing with a fencing
+	// token which is no longer current: the lease expired and was acquired by
+	// someone else (or re-acquired), so the caller must stop processing
+	ErrLeaseLost = errors.New("cursor lease lost")
+	// ErrCursorRegression is returned when committing a cursor lower than the
+	// one already stored
+	ErrCursorRegression = errors.New("cursor would move backwards")
+	ErrNoSuchLease      = errors.New("no such cursor lease")
+)
+
+// CursorLease is a named consumer's stored cursor, along with the current
+// holder of the right to advance it.
+//
+// Each acquisition increments Token. Commits and renewals must present the
+// token they were granted, so a holder which stalls past its lease (a
+// long GC pause, a partitioned pod during a redeploy) can't overwrite the
+// cursor of whoever took over: its writes are fenced off with ErrLeaseLost.
+type CursorLease struct {
+	Consumer  string    `json:"consumer"`
+	Holder    string    `json:"holder"`
+	Token     int64     `json:"token"`
+	Cursor    int64     `json:"cursor"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// CursorLeaseStore stores consumer cursors under leases. Implemented by
+// DbCursorLeaseStore, and by CursorLeaseClient for talking to a
+// CursorLeaseServer over HTTP.
+type CursorLeaseStore interface {
+	// Acquire takes the lease for the named consumer, if it is free, expired,
+	// or already held by the same holder. The returned lease carries the
+	// cursor to resume from, and a new fencing token.
+	Acquire(ctx context.Context, consumer, holder string, ttl time.Duration) (*CursorLease, error)
+	// Renew extends a lease which is still held with the given token
+	Renew(ctx context.Context, consumer string, token int64, ttl time.Duration) (*CursorLease, error)
+	// Commit stores a new cursor, if the token is still current
+	Commit(ctx context.Context, consumer string, token int64, cursor int64) error
+	// Release gives up a lease (keeping its cursor), so another holder can
+	// take over without waiting for it to expire
+	Release(ctx context.Context, consumer string, token int64) error
+	// Get returns the current state of a lease
+	Get(ctx context.Context, consumer string) (*CursorLease, error)
+}
+
+// CursorLeaseRecord is the database row for a lease
+type CursorLeaseRecord struct {
+	Consumer  string `gorm:"primarykey"`
+	Holder    string
+	Token     int64
+	Cursor    int64
+	ExpiresAt time.Time
+	UpdatedAt time.Time
+}
+
+func (r *CursorLeaseRecord) lease() *CursorLease {
+	return &CursorLease{
+		Consumer:  r.Consumer,
+		Holder:    r.Holder,
+		Token:     r.Token,
+		Cursor:    r.Cursor,
+		ExpiresAt: r.ExpiresAt,
+	}
+}
+
+// DbCursorLeaseStore keeps leases in a database table. Every transition is a
+// single conditional UPDATE, so any number of processes can share the table.
+type DbCursorLeaseStore struct {
+	db *gorm.DB
+}
+
+var _ CursorLeaseStore = (*DbCursorLeaseStore)(nil)
+
+func NewDbCursorLeaseStore(db *gorm.DB) (*DbCursorLeaseStore, error) {
+	if err := db.AutoMigrate(&CursorLeaseRecord{}); err != nil {
+		return nil, err
+	}
+	return &DbCursorLeaseStore{db: db}, nil
+}
+
+func (s *DbCursorLeaseStore) Acquire(ctx context.Context, consumer, holder string, ttl time.Duration) (*CursorLease, error) {
+	if consumer == "" || holder == "" {
+		return nil, fmt.Errorf("consumer and holder must be set")
+	}
+	db := s.db.WithContext(ctx)
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&CursorLeaseRecord{Consumer: consumer}).Error; err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	res := db.Model(&CursorLeaseRecord{}).
+		Where("consumer = ? AND (expires_at < ? OR holder = ?)", consumer, now, holder).
+		Updates(map[string]any{
+			"holder":     holder,
+			"token":      gorm.Expr("token + 1"),
+			"expires_at": now.Add(ttl),
+		})
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		cursorLeaseOps.WithLabelValues("acquire", "held").Inc()
+		return nil, ErrLeaseHeld
+	}
+	cursorLeaseOps.WithLabelValues("acquire", "ok").Inc()
+	return s.Get(ctx, consumer)
+}
+
+func (s *DbCursorLeaseStore) Renew(ctx context.Context, consumer string, token int64, ttl time.Duration) (*CursorLease, error) {
+	now := time.Now()
+	res := s.db.WithContext(ctx).Model(&CursorLeaseRecord{}).
+		Where("consumer = ? AND token = ? AND expires_at >= ?", consumer, token, now).
+		Update("expires_at", now.Add(ttl))
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		cursorLeaseOps.WithLabelValues("renew", "lost").Inc()
+		return nil, ErrLeaseLost
+	}
+	cursorLeaseOps.WithLabelValues("renew", "ok").Inc()
+	return s.Get(ctx, consumer)
+}
+
+// Commit stores the cursor as long as the token is current. A holder whose
+// lease has expired may still commit if nobody else has acquired it since,
+// as the cursor can't have been advanced by anyone else.
+func (s *DbCursorLeaseStore) Commit(ctx context.Context, consumer string, token int64, cursor int64) error {
+	res := s.db.WithContext(ctx).Model(&CursorLeaseRecord{}).
+		Where("consumer = ? AND token = ? AND cursor <= ?", consumer, token, cursor).
+		Update("cursor", cursor)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected > 0 {
+		cursorLeaseOps.WithLabelValues("commit", "ok").Inc()
+		return nil
+	}
+
+	// work out why
+	cur, err := s.Get(ctx, consumer)
+	if err != nil {
+		return err
+	}
+	if cur.Token != token {
+		cursorLeaseOps.WithLabelValues("commit", "lost").Inc()
+		return ErrLeaseLost
+	}
+	cursorLeaseOps.WithLabelValues("commit", "regression").Inc()
+	return fmt.Errorf("%w: stored %d, committing %d", ErrCursorRegression, cur.Cursor, cursor)
+}
+
+func (s *DbCursorLeaseStore) Release(ctx context.Context, consumer string, token int64) error {
+	res := s.db.WithContext(ctx).Model(&CursorLeaseRecord{}).
+		Where("consumer = ? AND token = ?", consumer, token).
+		Updates(map[string]any{
+			"holder":     "",
+			"expires_at": time.Time{},
+		})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrLeaseLost
+	}
+	cursorLeaseOps.WithLabelValues("release", "ok").Inc()
+	return nil
+}
+
+func (s *DbCursorLeaseStore) Get(ctx context.Context, consumer string) (*CursorLease, error) {
+	var rec CursorLeaseRecord
+	if err := s.db.WithContext(ctx).Find(&rec, "consumer = ?", consumer).Error; err != nil {
+		return nil, err
+	}
+	if rec.Consumer == "" {
+		return nil, ErrNoSuchLease
+	}
+	return rec.lease(), nil
+}
+
+// KeepLeaseAlive renews the lease every third of its TTL until the context is
+// cancelled (returning nil), or the lease is lost (returning ErrLeaseLost).
+// Consumers typically run it alongside their processing loop, and stop
+// processing as soon as it returns an error.
+func KeepLeaseAlive(ctx context.Context, store CursorLeaseStore, lease *CursorLease, ttl time.Duration) error {
+	expires := lease.ExpiresAt
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		if _, err := store.Renew(ctx, lease.Consumer, lease.Token, ttl); err != nil {
+			if errors.Is(err, ErrLeaseLost) {
+				return err
+			}
+			if ctx.Err() != nil {
+				return nil
+			}
+			// transient failures are retried until the lease actually expires
+			if time.Now().After(expires) {
+				return fmt.Errorf("%w: renewal failed until expiry: %w", ErrLeaseLost, err)
+			}
+			continue
+		}
+		expires = time.Now().Add(ttl)
+	}
+}
+
+// CursorLeaseServer exposes a CursorLeaseStore over HTTP:
+//
+//	GET  {prefix}/{consumer}          current lease
+//	POST {prefix}/{consumer}/acquire  {"holder", "ttl"} -> lease
+//	POST {prefix}/{consumer}/renew    {"token", "ttl"} -> lease
+//	POST {prefix}/{consumer}/commit   {"token", "cursor"}
+//	POST {prefix}/{consumer}/release  {"token"}
+//
+// TTLs are in seconds. Conflicts (ErrLeaseHeld, ErrLeaseLost and
+// ErrCursorRegression) are reported with a 409 and an "error" field naming
+// the condition.
+type CursorLeaseServer struct {
+	Store CursorLeaseStore
+	// Maximum TTL a client may request; defaults to ten minutes
+	MaxTTL time.Duration
+}
+
+type cursorLeaseRequest struct {
+	Holder string `json:"holder,omitempty"`
+	Token  int64  `json:"token,omitempty"`
+	Cursor int64  `json:"cursor,omitempty"`
+	// seconds
+	TTL int `json:"ttl,omitempty"`
+}
+
+type cursorLeaseError struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+func (cls *CursorLeaseServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	consumer, action, _ := strings.Cut(path, "/")
+	if consumer == "" {
+		http.Error(w, "must specify a consumer", http.StatusBadRequest)
+		return
+	}
+	consumer, err := url.PathUnescape(consumer)
+	if err != nil {
+		http.Error(w, "invalid consumer name", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	if action == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		lease, err := cls.Store.Get(ctx, consumer)
+		writeCursorLeaseResponse(w, lease, err)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req cursorLeaseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	maxTTL := cls.MaxTTL
+	if maxTTL == 0 {
+		maxTTL = 10 * time.Minute
+	}
+	ttl := time.Duration(req.TTL) * time.Second
+	if (action == "acquire" || action == "renew") && (ttl <= 0 || ttl > maxTTL) {
+		http.Error(w, fmt.Sprintf("ttl must be between 1 and %d seconds", int(maxTTL.Seconds())), http.StatusBadRequest)
+		return
+	}
+
+	switch action {
+	case "acquire":
+		lease, err := cls.Store.Acquire(ctx, consumer, req.Holder, ttl)
+		writeCursorLeaseResponse(w, lease, err)
+	case "renew":
+		lease, err := cls.Store.Renew(ctx, consumer, req.Token, ttl)
+		writeCursorLeaseResponse(w, lease, err)
+	case "commit":
+		err := cls.Store.Commit(ctx, consumer, req.Token, req.Cursor)
+		writeCursorLeaseResponse(w, map[string]bool{"ok": true}, err)
+	case "release":
+		err := cls.Store.Release(ctx, consumer, req.Token)
+		writeCursorLeaseResponse(w, map[string]bool{"ok": true}, err)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+var cursorLeaseErrorNames = map[error]string{
+	ErrLeaseHeld:        "LeaseHeld",
+	ErrLeaseLost:        "LeaseLost",
+	ErrCursorRegression: "CursorRegression",
+	ErrNoSuchLease:      "NoSuchLease",
+}
+
+func writeCursorLeaseResponse(w http.ResponseWriter, out any, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		for target, name := range cursorLeaseErrorNames {
+			if errors.Is(err, target) {
+				status := http.StatusConflict
+				if target == ErrNoSuchLease {
+					status = http.StatusNotFound
+				}
+				w.WriteHeader(status)
+				json.NewEncoder(w).Encode(cursorLeaseError{Error: name, Message: err.Error()})
+				return
+			}
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(cursorLeaseError{Error: "InternalError", Message: err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(out)
+}
+
+// CursorLeaseClient is a CursorLeaseStore backed by a remote
+// CursorLeaseServer
+type CursorLeaseClient struct {
+	// URL the server is mounted at, eg "http://localhost:2470/leases"
+	Host   string
+	Client *http.Client
+}
+
+var _ CursorLeaseStore = (*CursorLeaseClient)(nil)
+
+func NewCursorLeaseClient(host string) *CursorLeaseClient {
+	return &CursorLeaseClient{
+		Host:   strings.TrimSuffix(host, "/"),
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (c *CursorLeaseClient) do(ctx context.Context, consumer, action string, req *cursorLeaseRequest, out any) error {
+	u := c.Host + "/" + url.PathEscape(consumer)
+	method := http.MethodGet
+	var body io.Reader
+	if action != "" {
+		u += "/" + action
+		method = http.MethodPost
+		b, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	hreq, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return err
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.Client.Do(hreq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var lerr cursorLeaseError
+		if err := json.NewDecoder(resp.Body).Decode(&lerr); err != nil {
+			return fmt.Errorf("cursor lease request failed: %s", resp.Status)
+		}
+		for target, name := range cursorLeaseErrorNames {
+			if lerr.Error == name {
+				return fmt.Errorf("%w (%s)", target, lerr.Message)
+			}
+		}
+		return fmt.Errorf("cursor lease request failed (%s): %s", resp.Status, lerr.Message)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *CursorLeaseClient) Acquire(ctx context.Context, consumer, holder string, ttl time.Duration) (*CursorLease, error) {
+	var out CursorLease
+	if err := c.do(ctx, consumer, "acquire", &cursorLeaseRequest{Holder: holder, TTL: int(ttl.Seconds())}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *CursorLeaseClient) Renew(ctx context.Context, consumer string, token int64, ttl time.Duration) (*CursorLease, error) {
+	var out CursorLease
+	if err := c.do(ctx, consumer, "renew", &cursorLeaseRequest{Token: token, TTL: int(ttl.Seconds())}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *CursorLeaseClient) Commit(ctx context.Context, consumer string, token int64, cursor int64) error {
+	return c.do(ctx, consumer, "commit", &cursorLeaseRequest{Token: token, Cursor: cursor}, nil)
+}
+
+func (c *CursorLeaseClient) Release(ctx context.Context, consumer string, token int64) error {
+	return c.do(ctx, consumer, "release", &cursorLeaseRequest{Token: token}, nil)
+}
+
+func (c *CursorLeaseClient) Get(ctx context.Context, consumer string) (*CursorLease, error) {
+	var out CursorLease
+	if err := c.do(ctx, consumer, "", nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
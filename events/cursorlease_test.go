@@ -0,0 +1,161 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// expire forces the consumer's current lease to expire
+func testCursorLeases(t *testing.T, store CursorLeaseStore, expire func(consumer string)) {
+	ctx := context.Background()
+	ttl := time.Minute
+
+	if _, err := store.Get(ctx, "indexer"); !errors.Is(err, ErrNoSuchLease) {
+		t.Fatalf("expected ErrNoSuchLease, got %v", err)
+	}
+
+	a, err := store.Acquire(ctx, "indexer", "pod-a", ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Cursor != 0 || a.Holder != "pod-a" {
+		t.Fatalf("unexpected lease: %+v", a)
+	}
+	if err := store.Commit(ctx, "indexer", a.Token, 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Commit(ctx, "indexer", a.Token, 50); !errors.Is(err, ErrCursorRegression) {
+		t.Fatalf("expected ErrCursorRegression, got %v", err)
+	}
+
+	// a second holder can't take over a live lease
+	if _, err := store.Acquire(ctx, "indexer", "pod-b", ttl); !errors.Is(err, ErrLeaseHeld) {
+		t.Fatalf("expected ErrLeaseHeld, got %v", err)
+	}
+	// but other consumers are independent
+	if _, err := store.Acquire(ctx, "labeler", "pod-b", ttl); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Renew(ctx, "indexer", a.Token, ttl); err != nil {
+		t.Fatal(err)
+	}
+
+	// once the lease expires, the second holder takes over from the stored cursor
+	expire("indexer")
+	b, err := store.Acquire(ctx, "indexer", "pod-b", ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Cursor != 100 || b.Token <= a.Token {
+		t.Fatalf("unexpected lease after takeover: %+v", b)
+	}
+
+	// and the first holder is fenced off
+	if err := store.Commit(ctx, "indexer", a.Token, 200); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("expected ErrLeaseLost, got %v", err)
+	}
+	if _, err := store.Renew(ctx, "indexer", a.Token, ttl); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("expected ErrLeaseLost, got %v", err)
+	}
+
+	if err := store.Commit(ctx, "indexer", b.Token, 150); err != nil {
+		t.Fatal(err)
+	}
+
+	// releasing lets the next holder in immediately
+	if err := store.Release(ctx, "indexer", b.Token); err != nil {
+		t.Fatal(err)
+	}
+	c, err := store.Acquire(ctx, "indexer", "pod-a", ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Cursor != 150 {
+		t.Fatalf("expected cursor 150, got %d", c.Cursor)
+	}
+
+	got, err := store.Get(ctx, "indexer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Token != c.Token || got.Holder != "pod-a" {
+		t.Fatalf("unexpected lease: %+v", got)
+	}
+}
+
+func testDbCursorLeaseStore(t *testing.T) (*DbCursorLeaseStore, func(string)) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "leases.sqlite")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewDbCursorLeaseStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expire := func(consumer string) {
+		if err := db.Model(&CursorLeaseRecord{}).Where("consumer = ?", consumer).Update("expires_at", time.Now().Add(-time.Second)).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store, expire
+}
+
+func TestDbCursorLeaseStore(t *testing.T) {
+	store, expire := testDbCursorLeaseStore(t)
+	testCursorLeases(t, store, expire)
+}
+
+func TestCursorLeaseClient(t *testing.T) {
+	store, expire := testDbCursorLeaseStore(t)
+	srv := httptest.NewServer(http.StripPrefix("/leases", &CursorLeaseServer{Store: store}))
+	defer srv.Close()
+
+	testCursorLeases(t, NewCursorLeaseClient(srv.URL+"/leases"), expire)
+}
+
+func TestKeepLeaseAlive(t *testing.T) {
+	ctx := context.Background()
+	store, expire := testDbCursorLeaseStore(t)
+
+	ttl := 30 * time.Millisecond
+	lease, err := store.Acquire(ctx, "indexer", "pod-a", ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	kctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		done <- KeepLeaseAlive(kctx, store, lease, ttl)
+	}()
+
+	// outlives several TTLs
+	time.Sleep(4 * ttl)
+	if _, err := store.Acquire(ctx, "indexer", "pod-b", ttl); !errors.Is(err, ErrLeaseHeld) {
+		t.Fatalf("expected lease to still be held, got %v", err)
+	}
+
+	// taken over by someone else: the keeper notices
+	expire("indexer")
+	if _, err := store.Acquire(ctx, "indexer", "pod-b", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrLeaseLost) {
+			t.Fatalf("expected ErrLeaseLost, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("keeper did not notice the lost lease")
+	}
+}
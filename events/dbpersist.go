@@ -71,6 +71,7 @@ type RepoEventRecord struct {
 	Since     *string
 	Commit    *models.DbCID
 	Prev      *models.DbCID
+	PrevData  *models.DbCID
 	NewHandle *string // NewHandle is only set if this is a handle change event
 
 	Time   time.Time
@@ -84,6 +85,9 @@ type RepoEventRecord struct {
 	Status *string
 
 	Ops []byte
+
+	// Blocks is only set on RepoSync events, which carry just the commit block
+	Blocks []byte
 }
 
 func NewDbPersistence(db *gorm.DB, cs carstore.CarStore, options *Options) (*DbPersistence, error) {
@@ -173,6 +177,8 @@ func (p *DbPersistence) flushBatchLocked(ctx context.Context) error {
 			e.RepoIdentity.Seq = int64(item.Seq)
 		case e.RepoAccount != nil:
 			e.RepoAccount.Seq = int64(item.Seq)
+		case e.RepoSync != nil:
+			e.RepoSync.Seq = int64(item.Seq)
 		case e.RepoTombstone != nil:
 			e.RepoTombstone.Seq = int64(item.Seq)
 		default:
@@ -234,6 +240,11 @@ func (p *DbPersistence) Persist(ctx context.Context, e *XRPCStreamEvent) error {
 		if err != nil {
 			return err
 		}
+	case e.RepoSync != nil:
+		rer, err = p.RecordFromRepoSync(ctx, e.RepoSync)
+		if err != nil {
+			return err
+		}
 	default:
 		return nil
 	}
@@ -320,6 +331,26 @@ func (p *DbPersistence) RecordFromTombstone(ctx context.Context, evt *comatproto
 	}, nil
 }
 
+func (p *DbPersistence) RecordFromRepoSync(ctx context.Context, evt *comatproto.SyncSubscribeRepos_Sync) (*RepoEventRecord, error) {
+	t, err := time.Parse(util.ISO8601, evt.Time)
+	if err != nil {
+		return nil, err
+	}
+
+	uid, err := p.uidForDid(ctx, evt.Did)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RepoEventRecord{
+		Repo:   uid,
+		Type:   "repo_sync",
+		Time:   t,
+		Rev:    evt.Rev,
+		Blocks: evt.Blocks,
+	}, nil
+}
+
 func (p *DbPersistence) RecordFromRepoCommit(ctx context.Context, evt *comatproto.SyncSubscribeRepos_Commit) (*RepoEventRecord, error) {
 	// TODO: hack hack hack
 	if len(evt.Ops) > 8192 {
@@ -337,6 +368,11 @@ func (p *DbPersistence) RecordFromRepoCommit(ctx context.Context, evt *comatprot
 		prev = &models.DbCID{CID: cid.Cid(*evt.Prev)}
 	}
 
+	var prevData *models.DbCID
+	if evt.PrevData != nil && evt.PrevData.Defined() {
+		prevData = &models.DbCID{CID: cid.Cid(*evt.PrevData)}
+	}
+
 	var blobs []byte
 	if len(evt.Blobs) > 0 {
 		b, err := json.Marshal(evt.Blobs)
@@ -352,15 +388,16 @@ func (p *DbPersistence) RecordFromRepoCommit(ctx context.Context, evt *comatprot
 	}
 
 	rer := RepoEventRecord{
-		Commit: &models.DbCID{CID: cid.Cid(evt.Commit)},
-		Prev:   prev,
-		Repo:   uid,
-		Type:   "repo_append", // TODO: refactor to "#commit"? can "rebase" come through this path?
-		Blobs:  blobs,
-		Time:   t,
-		Rebase: evt.Rebase,
-		Rev:    evt.Rev,
-		Since:  evt.Since,
+		Commit:   &models.DbCID{CID: cid.Cid(evt.Commit)},
+		Prev:     prev,
+		PrevData: prevData,
+		Repo:     uid,
+		Type:     "repo_append", // TODO: refactor to "#commit"? can "rebase" come through this path?
+		Blobs:    blobs,
+		Time:     t,
+		Rebase:   evt.Rebase,
+		Rev:      evt.Rev,
+		Since:    evt.Since,
 	}
 
 	opsb, err := json.Marshal(evt.Ops)
@@ -458,6 +495,8 @@ func (p *DbPersistence) hydrateBatch(ctx context.Context, batch []*RepoEventReco
 				streamEvent, err = p.hydrateAccountEvent(ctx, record)
 			case record.Type == "repo_tombstone":
 				streamEvent, err = p.hydrateTombstone(ctx, record)
+			case record.Type == "repo_sync":
+				streamEvent, err = p.hydrateSyncEvent(ctx, record)
 			default:
 				err = fmt.Errorf("unknown event type: %s", record.Type)
 			}
@@ -569,6 +608,23 @@ func (p *DbPersistence) hydrateAccountEvent(ctx context.Context, rer *RepoEventR
 	}, nil
 }
 
+func (p *DbPersistence) hydrateSyncEvent(ctx context.Context, rer *RepoEventRecord) (*XRPCStreamEvent, error) {
+	did, err := p.didForUid(ctx, rer.Repo)
+	if err != nil {
+		return nil, err
+	}
+
+	return &XRPCStreamEvent{
+		RepoSync: &comatproto.SyncSubscribeRepos_Sync{
+			Seq:    int64(rer.Seq),
+			Did:    did,
+			Rev:    rer.Rev,
+			Blocks: rer.Blocks,
+			Time:   rer.Time.Format(util.ISO8601),
+		},
+	}, nil
+}
+
 func (p *DbPersistence) hydrateTombstone(ctx context.Context, rer *RepoEventRecord) (*XRPCStreamEvent, error) {
 	did, err := p.didForUid(ctx, rer.Repo)
 	if err != nil {
@@ -614,22 +670,29 @@ func (p *DbPersistence) hydrateCommit(ctx context.Context, rer *RepoEventRecord)
 		prevCID = &tmp
 	}
 
+	var prevData *lexutil.LexLink
+	if rer.PrevData != nil && rer.PrevData.CID.Defined() {
+		tmp := lexutil.LexLink(rer.PrevData.CID)
+		prevData = &tmp
+	}
+
 	var ops []*comatproto.SyncSubscribeRepos_RepoOp
 	if err := json.Unmarshal(rer.Ops, &ops); err != nil {
 		return nil, err
 	}
 
 	out := &comatproto.SyncSubscribeRepos_Commit{
-		Seq:    int64(rer.Seq),
-		Repo:   did,
-		Commit: lexutil.LexLink(rer.Commit.CID),
-		Prev:   prevCID,
-		Time:   rer.Time.Format(util.ISO8601),
-		Blobs:  blobCIDs,
-		Rebase: rer.Rebase,
-		Ops:    ops,
-		Rev:    rer.Rev,
-		Since:  rer.Since,
+		Seq:      int64(rer.Seq),
+		Repo:     did,
+		Commit:   lexutil.LexLink(rer.Commit.CID),
+		Prev:     prevCID,
+		PrevData: prevData,
+		Time:     rer.Time.Format(util.ISO8601),
+		Blobs:    blobCIDs,
+		Rebase:   rer.Rebase,
+		Ops:      ops,
+		Rev:      rer.Rev,
+		Since:    rer.Since,
 	}
 
 	cs, err := p.readCarSlice(ctx, rer)
@@ -28,6 +28,7 @@ type DiskPersistence struct {
 	eventsPerFile   int64
 	writeBufferSize int
 	retention       time.Duration
+	retentionByType map[string]time.Duration
 
 	meta *gorm.DB
 
@@ -50,6 +51,9 @@ type DiskPersistence struct {
 	shutdown chan struct{}
 
 	lk sync.Mutex
+	// serializes rewriting log files (trimLogFile) with modifying them in
+	// place (takedowns)
+	fileLk sync.Mutex
 }
 
 type persistJob struct {
@@ -76,6 +80,14 @@ type DiskPersistOptions struct {
 	EventsPerFile   int64
 	WriteBufferSize int
 	Retention       time.Duration
+
+	// RetentionByType keeps events of some frame types (eg, "#identity")
+	// for a different time than Retention, which applies to the rest. Eg,
+	// the small identity and account events (IdentityEventTypes) can be
+	// kept much longer than commits. Log files are kept for the longest
+	// period, with expired events removed. See CompleteFrom for playback
+	// when the periods differ.
+	RetentionByType map[string]time.Duration
 }
 
 func DefaultDiskPersistOptions() *DiskPersistOptions {
@@ -123,6 +135,7 @@ func NewDiskPersistence(primaryDir, archiveDir string, db *gorm.DB, opts *DiskPe
 		archiveDir:      archiveDir,
 		buffers:         bufpool,
 		retention:       opts.Retention,
+		retentionByType: opts.RetentionByType,
 		writers:         wrpool,
 		uidCache:        uidCache,
 		didCache:        didCache,
@@ -149,6 +162,9 @@ type LogFileRef struct {
 	Path     string
 	Archived bool
 	SeqStart int64
+	// events of types kept for up to this long have been removed from the
+	// file (see DiskPersistOptions.RetentionByType)
+	Trimmed time.Duration `gorm:"not null;default:0"`
 }
 
 func (dp *DiskPersistence) resumeLog() error {
@@ -278,6 +294,7 @@ const (
 	evtKindTombstone = 3
 	evtKindIdentity  = 4
 	evtKindAccount   = 5
+	evtKindSync      = 6
 )
 
 var emptyHeader = make([]byte, headerSize)
@@ -381,6 +398,11 @@ var filesGarbageCollected = promauto.NewCounterVec(prometheus.CounterOpts{
 	Help: "Number of files collected during garbage collection",
 }, []string{})
 
+var filesTrimmedCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "disk_persister_garbage_collections_files_trimmed",
+	Help: "Number of files rewritten without expired events during garbage collection",
+}, []string{})
+
 func (dp *DiskPersistence) garbageCollect(ctx context.Context) []error {
 	garbageCollectionsExecuted.WithLabelValues().Inc()
 
@@ -392,7 +414,8 @@ func (dp *DiskPersistence) garbageCollect(ctx context.Context) []error {
 		garbageCollectionErrors.WithLabelValues().Add(float64(len(errs)))
 	}()
 
-	if err := dp.meta.WithContext(ctx).Find(&refs, "created_at < ?", time.Now().Add(-dp.retention)).Error; err != nil {
+	now := time.Now()
+	if err := dp.meta.WithContext(ctx).Find(&refs, "created_at < ?", now.Add(-dp.longestRetention())).Error; err != nil {
 		return []error{err}
 	}
 
@@ -427,13 +450,19 @@ func (dp *DiskPersistence) garbageCollect(ctx context.Context) []error {
 		filesDeleted++
 	}
 
+	// files younger than that may still have expired events
+	filesTrimmed, trimErrs := dp.trimLogFiles(ctx, now)
+	errs = append(errs, trimErrs...)
+
 	refsGarbageCollected.WithLabelValues().Add(float64(refsDeleted))
 	filesGarbageCollected.WithLabelValues().Add(float64(filesDeleted))
+	filesTrimmedCounter.WithLabelValues().Add(float64(filesTrimmed))
 
 	log.Info("garbage collection complete",
 		"filesDeleted", filesDeleted,
 		"refsDeleted", refsDeleted,
 		"oldRefsFound", oldRefsFound,
+		"filesTrimmed", filesTrimmed,
 	)
 
 	return errs
@@ -457,6 +486,8 @@ func (dp *DiskPersistence) doPersist(ctx context.Context, j persistJob) error {
 		e.RepoIdentity.Seq = seq
 	case e.RepoAccount != nil:
 		e.RepoAccount.Seq = seq
+	case e.RepoSync != nil:
+		e.RepoSync.Seq = seq
 	case e.RepoTombstone != nil:
 		e.RepoTombstone.Seq = seq
 	default:
@@ -523,6 +554,12 @@ func (dp *DiskPersistence) Persist(ctx context.Context, e *XRPCStreamEvent) erro
 		if err := e.RepoAccount.MarshalCBOR(cw); err != nil {
 			return fmt.Errorf("failed to marshal: %w", err)
 		}
+	case e.RepoSync != nil:
+		evtKind = evtKindSync
+		did = e.RepoSync.Did
+		if err := e.RepoSync.MarshalCBOR(cw); err != nil {
+			return fmt.Errorf("failed to marshal: %w", err)
+		}
 	case e.RepoTombstone != nil:
 		evtKind = evtKindTombstone
 		did = e.RepoTombstone.Did
@@ -768,6 +805,15 @@ func (dp *DiskPersistence) readEventsFrom(ctx context.Context, since int64, fn s
 			if err := cb(&XRPCStreamEvent{RepoAccount: &evt}); err != nil {
 				return nil, err
 			}
+		case evtKindSync:
+			var evt atproto.SyncSubscribeRepos_Sync
+			if err := evt.UnmarshalCBOR(io.LimitReader(bufr, h.Len64())); err != nil {
+				return nil, err
+			}
+			evt.Seq = h.Seq
+			if err := cb(&XRPCStreamEvent{RepoSync: &evt}); err != nil {
+				return nil, err
+			}
 		case evtKindTombstone:
 			var evt atproto.SyncSubscribeRepos_Tombstone
 			if err := evt.UnmarshalCBOR(io.LimitReader(bufr, h.Len64())); err != nil {
@@ -855,6 +901,8 @@ func (zr *zeroReader) Read(p []byte) (n int, err error) {
 }
 
 func (dp *DiskPersistence) deleteEventsForUser(ctx context.Context, usr models.Uid, fn string) error {
+	dp.fileLk.Lock()
+	defer dp.fileLk.Unlock()
 	return dp.mutateUserEventsInLog(ctx, usr, fn, EvtFlagTakedown, true)
 }
 
@@ -1,6 +1,7 @@
 package events
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"fmt"
@@ -19,6 +20,8 @@ import (
 	pds "github.com/bluesky-social/indigo/pds/data"
 	"github.com/bluesky-social/indigo/repomgr"
 	"github.com/bluesky-social/indigo/util"
+
+	cid "github.com/ipfs/go-cid"
 	"gorm.io/gorm"
 )
 
@@ -377,6 +380,7 @@ func runEventManagerTest(t *testing.T, cs carstore.CarStore, db *gorm.DB, p Even
 		// Check that the contents of the output events match the input events
 		// Clear cache, don't care if one has it and not the other
 		inEvts[outEvtCount].Preserialized = nil
+		inEvts[outEvtCount].frame = nil
 		evt.Preserialized = nil
 		if !reflect.DeepEqual(inEvts[outEvtCount], evt) {
 			t.Logf("%v", inEvts[outEvtCount].RepoCommit)
@@ -515,3 +519,230 @@ func runTakedownTest(t *testing.T, cs carstore.CarStore, db *gorm.DB, p EventPer
 		t.Fatalf("wrong number of events out: %d != %d", evtsCount, exp)
 	}
 }
+
+func TestDiskPersistSyncAndPrevData(t *testing.T) {
+	ctx := context.Background()
+
+	db, _, _, tempPath, err := setupDBs(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempPath)
+
+	db.AutoMigrate(&models.ActorInfo{})
+	db.Create(&models.ActorInfo{
+		Uid: 1,
+		Did: "did:example:123",
+	})
+
+	dp, err := NewDiskPersistence(filepath.Join(tempPath, "diskPrimary"), filepath.Join(tempPath, "diskArchive"), db, &DiskPersistOptions{
+		EventsPerFile: 10,
+		UIDCacheSize:  100000,
+		DIDCacheSize:  100000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dp.Shutdown(ctx)
+
+	evtman := NewEventManager(dp)
+
+	c, err := cid.Decode("bafyreidfayvfuwqa7qlnopdjiqrxzs6blmoeu4rujcjtnci5beludirz2a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := lexutil.LexLink(c)
+
+	if err := evtman.AddEvent(ctx, &XRPCStreamEvent{
+		RepoCommit: &atproto.SyncSubscribeRepos_Commit{
+			Repo:     "did:example:123",
+			Commit:   link,
+			PrevData: &link,
+			Ops: []*atproto.SyncSubscribeRepos_RepoOp{
+				{
+					Action: "update",
+					Cid:    &link,
+					Prev:   &link,
+					Path:   "app.bsky.feed.post/abc",
+				},
+			},
+			Time: time.Now().Format(util.ISO8601),
+		},
+		PrivUid: 1,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := evtman.AddEvent(ctx, &XRPCStreamEvent{
+		RepoSync: &atproto.SyncSubscribeRepos_Sync{
+			Did:    "did:example:123",
+			Rev:    "3kabc",
+			Blocks: []byte{1, 2, 3},
+			Time:   time.Now().Format(util.ISO8601),
+		},
+		PrivUid: 1,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dp.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var out []*XRPCStreamEvent
+	if err := dp.Playback(ctx, 0, func(evt *XRPCStreamEvent) error {
+		out = append(out, evt)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(out) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(out))
+	}
+	if out[0].RepoCommit == nil || out[0].RepoCommit.PrevData == nil || out[0].RepoCommit.PrevData.String() != c.String() {
+		t.Fatalf("commit prevData did not round-trip: %+v", out[0].RepoCommit)
+	}
+	if out[0].RepoCommit.Ops[0].Prev == nil || out[0].RepoCommit.Ops[0].Prev.String() != c.String() {
+		t.Fatal("op prev did not round-trip")
+	}
+	if out[1].RepoSync == nil || out[1].RepoSync.Rev != "3kabc" || !bytes.Equal(out[1].RepoSync.Blocks, []byte{1, 2, 3}) {
+		t.Fatalf("sync event did not round-trip: %+v", out[1].RepoSync)
+	}
+	if out[1].RepoSync.Seq != out[0].RepoCommit.Seq+1 {
+		t.Fatalf("expected sequential seqs, got %d and %d", out[0].RepoCommit.Seq, out[1].RepoSync.Seq)
+	}
+}
+
+func TestDiskPersistRetentionByType(t *testing.T) {
+	ctx := context.Background()
+
+	db, _, _, tempPath, err := setupDBs(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempPath)
+
+	db.AutoMigrate(&models.ActorInfo{})
+	db.Create(&models.ActorInfo{
+		Uid: 1,
+		Did: "did:example:123",
+	})
+
+	retentionByType := make(map[string]time.Duration)
+	for _, typ := range IdentityEventTypes {
+		retentionByType[typ] = 24 * time.Hour
+	}
+	dp, err := NewDiskPersistence(filepath.Join(tempPath, "diskPrimary"), filepath.Join(tempPath, "diskArchive"), db, &DiskPersistOptions{
+		EventsPerFile:   10,
+		UIDCacheSize:    100000,
+		DIDCacheSize:    100000,
+		Retention:       time.Hour,
+		RetentionByType: retentionByType,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dp.Shutdown(ctx)
+
+	evtman := NewEventManager(dp)
+
+	c, err := cid.Decode("bafyreidfayvfuwqa7qlnopdjiqrxzs6blmoeu4rujcjtnci5beludirz2a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := lexutil.LexLink(c)
+
+	// alternate commits and identity events, over several log files
+	for i := 0; i < 45; i++ {
+		evt := &XRPCStreamEvent{PrivUid: 1}
+		if i%2 == 0 {
+			evt.RepoCommit = &atproto.SyncSubscribeRepos_Commit{
+				Repo:   "did:example:123",
+				Commit: link,
+				Time:   time.Now().Format(util.ISO8601),
+			}
+		} else {
+			evt.RepoIdentity = &atproto.SyncSubscribeRepos_Identity{
+				Did:  "did:example:123",
+				Time: time.Now().Format(util.ISO8601),
+			}
+		}
+		if err := evtman.AddEvent(ctx, evt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := dp.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var refs []LogFileRef
+	if err := db.Order("seq_start asc").Find(&refs).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 5 {
+		t.Fatalf("expected 5 log files, got %d", len(refs))
+	}
+	// the first file is past both retention periods, the next two only
+	// past the one for commits
+	age := func(r LogFileRef, d time.Duration) {
+		if err := db.Model(&r).Update("created_at", time.Now().Add(-d)).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	age(refs[0], 25*time.Hour)
+	age(refs[1], 2*time.Hour)
+	age(refs[2], 2*time.Hour)
+
+	if errs := dp.garbageCollect(ctx); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	from, err := dp.CompleteFrom(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != refs[3].SeqStart {
+		t.Fatalf("expected complete window from %d, got %d", refs[3].SeqStart, from)
+	}
+
+	check := func() {
+		t.Helper()
+		var seqs []int64
+		if err := dp.Playback(ctx, 0, func(evt *XRPCStreamEvent) error {
+			seq := evt.Sequence()
+			if seq < from && evt.RepoIdentity == nil {
+				t.Fatalf("expired event %d played back", seq)
+			}
+			seqs = append(seqs, seq)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		// seqs start at 1, so identity events have even seqs
+		var expected []int64
+		for seq := refs[1].SeqStart; seq <= 45; seq++ {
+			if seq >= from || seq%2 == 0 {
+				expected = append(expected, seq)
+			}
+		}
+		if !reflect.DeepEqual(seqs, expected) {
+			t.Fatalf("expected events %v played back, got %v", expected, seqs)
+		}
+	}
+	check()
+
+	// already trimmed files are left alone
+	if errs := dp.garbageCollect(ctx); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	check()
+
+	// the seq check only covers the complete window
+	rep, err := CheckSeqContinuity(ctx, dp, 0, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.First != from || rep.Missing != 0 {
+		t.Fatalf("unexpected seq check report: %+v", rep)
+	}
+}
@@ -0,0 +1,173 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// frame types of the event kinds in disk persister log files
+var diskEvtKindTypes = map[uint32]string{
+	evtKindCommit:    "#commit",
+	evtKindHandle:    "#handle",
+	evtKindTombstone: "#tombstone",
+	evtKindIdentity:  "#identity",
+	evtKindAccount:   "#account",
+	evtKindSync:      "#sync",
+}
+
+// kindRetention returns how long events of a kind are kept
+func (dp *DiskPersistence) kindRetention(kind uint32) time.Duration {
+	if d, ok := dp.retentionByType[diskEvtKindTypes[kind]]; ok {
+		return d
+	}
+	return dp.retention
+}
+
+// retentionTiers returns the distinct retention periods, shortest first
+func (dp *DiskPersistence) retentionTiers() []time.Duration {
+	seen := map[time.Duration]bool{dp.retention: true}
+	tiers := []time.Duration{dp.retention}
+	for _, d := range dp.retentionByType {
+		if !seen[d] {
+			seen[d] = true
+			tiers = append(tiers, d)
+		}
+	}
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i] < tiers[j] })
+	return tiers
+}
+
+// longestRetention is how long any event is kept, and so how long log files
+// are kept
+func (dp *DiskPersistence) longestRetention() time.Duration {
+	tiers := dp.retentionTiers()
+	return tiers[len(tiers)-1]
+}
+
+// trimLogFiles rewrites log files older than the shorter retention periods,
+// dropping the events of kinds which have expired. Files older than the
+// longest period are deleted by garbageCollect.
+func (dp *DiskPersistence) trimLogFiles(ctx context.Context, now time.Time) (int, []error) {
+	tiers := dp.retentionTiers()
+	if len(tiers) == 1 {
+		return 0, nil
+	}
+
+	var refs []LogFileRef
+	if err := dp.meta.WithContext(ctx).Order("seq_start asc").Find(&refs, "created_at < ?", now.Add(-tiers[0])).Error; err != nil {
+		return 0, []error{err}
+	}
+
+	var errs []error
+	trimmed := 0
+	for _, r := range refs {
+		// the longest retention period which has expired for this file
+		var expired time.Duration
+		for _, d := range tiers[:len(tiers)-1] {
+			if r.CreatedAt.Before(now.Add(-d)) {
+				expired = d
+			}
+		}
+		if expired <= r.Trimmed {
+			continue
+		}
+
+		dp.lk.Lock()
+		currentLogfile := dp.logfi.Name()
+		dp.lk.Unlock()
+		if filepath.Join(dp.primaryDir, r.Path) == currentLogfile {
+			continue
+		}
+
+		if err := dp.trimLogFile(ctx, &r, expired); err != nil {
+			errs = append(errs, fmt.Errorf("trimming log file %s: %w", r.Path, err))
+			continue
+		}
+		trimmed++
+	}
+	return trimmed, errs
+}
+
+// trimLogFile rewrites a log file without the events of kinds kept for no
+// longer than expired. The new file replaces the old one atomically, so
+// playbacks which already have the old one open read it to the end.
+func (dp *DiskPersistence) trimLogFile(ctx context.Context, r *LogFileRef, expired time.Duration) error {
+	// takedowns modify log files in place
+	dp.fileLk.Lock()
+	defer dp.fileLk.Unlock()
+
+	fn := filepath.Join(dp.primaryDir, r.Path)
+	in, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := fn + ".trim"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	defer out.Close()
+
+	bufr := bufio.NewReader(in)
+	bufw := bufio.NewWriter(out)
+	scratch := make([]byte, headerSize)
+	for {
+		h, err := readHeader(bufr, scratch)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if dp.kindRetention(h.Kind) <= expired {
+			if _, err := io.CopyN(io.Discard, bufr, h.Len64()); err != nil {
+				return fmt.Errorf("skipping event %d: %w", h.Seq, err)
+			}
+			continue
+		}
+		if _, err := bufw.Write(scratch); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(bufw, bufr, h.Len64()); err != nil {
+			return fmt.Errorf("copying event %d: %w", h.Seq, err)
+		}
+	}
+	if err := bufw.Flush(); err != nil {
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, fn); err != nil {
+		return err
+	}
+
+	r.Trimmed = expired
+	return dp.meta.WithContext(ctx).Model(r).Update("trimmed", expired).Error
+}
+
+// CompleteFrom returns the sequence number from which every persisted event
+// is played back (besides those of taken-down repos), or ErrNoLast if there
+// are no log files. With RetentionByType, older log files still hold the
+// events of the types kept longest; playback from an older cursor sends
+// those, without the events in between.
+func (dp *DiskPersistence) CompleteFrom(ctx context.Context) (int64, error) {
+	var lfr LogFileRef
+	if err := dp.meta.WithContext(ctx).Order("seq_start asc").Limit(1).Find(&lfr, "trimmed = 0").Error; err != nil {
+		return 0, err
+	}
+	if lfr.ID == 0 {
+		return 0, ErrNoLast
+	}
+	return lfr.SeqStart, nil
+}
@@ -8,6 +8,7 @@ import (
 	"io"
 	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	comatproto "github.com/bluesky-social/indigo/api/atproto"
@@ -35,6 +36,9 @@ type EventManager struct {
 
 	persister EventPersistence
 
+	// if set, outgoing frames are signed
+	attestor *Attestor
+
 	log *slog.Logger
 }
 
@@ -63,17 +67,29 @@ type Operation struct {
 	evt *XRPCStreamEvent
 }
 
+// SetAttestor enables signing of every frame sent to subscribers (live and
+// playback). Must be called before any events are added or subscribers
+// connect.
+func (em *EventManager) SetAttestor(a *Attestor) {
+	em.attestor = a
+}
+
 func (em *EventManager) Shutdown(ctx context.Context) error {
 	return em.persister.Shutdown(ctx)
 }
 
 func (em *EventManager) broadcastEvent(evt *XRPCStreamEvent) {
-	// the main thing we do is send it out, so MarshalCBOR once
-	if err := evt.Preserialize(); err != nil {
+	// the main thing we do is send it out, so MarshalCBOR once, into a frame
+	// which all subscribers share
+	frame, err := newSharedFrame(evt, em.attestor)
+	if err != nil {
 		em.log.Error("broadcast serialize failed", "err", err)
 		// serialize isn't going to go better later, this event is cursed
 		return
 	}
+	evt.frame = frame
+	// drop the broadcaster's own reference once everyone has it
+	defer frame.release()
 
 	em.subsLk.Lock()
 	defer em.subsLk.Unlock()
@@ -83,42 +99,66 @@ func (em *EventManager) broadcastEvent(evt *XRPCStreamEvent) {
 	// events out to them, or some similar architecture
 	// Alternatively, we might just want to not allow too many subscribers
 	// directly to the bgs, and have rebroadcasting proxies instead
+	size := int64(frame.buf.Len())
 	for _, s := range em.subs {
 		if s.filter(evt) {
 			s.enqueuedCounter.Inc()
+			if s.maxBytes > 0 && s.queuedBytes.Load()+size > s.maxBytes {
+				em.dropSlowSubscriber(s, "bytes")
+				s.broadcastCounter.Inc()
+				continue
+			}
+			// the subscriber's reference, released once it writes the event
+			frame.retain()
+			s.queuedBytes.Add(size)
 			select {
 			case s.outgoing <- evt:
 			case <-s.done:
+				s.queuedBytes.Add(-size)
+				frame.release()
 			default:
-				// filter out all future messages that would be
-				// sent to this subscriber, but wait for it to
-				// actually be removed by the correct bit of
-				// code
-				s.filter = func(*XRPCStreamEvent) bool { return false }
-
-				em.log.Warn("dropping slow consumer due to event overflow", "bufferSize", len(s.outgoing), "ident", s.ident)
-				go func(torem *Subscriber) {
-					torem.lk.Lock()
-					if !torem.cleanedUp {
-						select {
-						case torem.outgoing <- &XRPCStreamEvent{
-							Error: &ErrorFrame{
-								Error: "ConsumerTooSlow",
-							},
-						}:
-						case <-time.After(time.Second * 5):
-							em.log.Warn("failed to send error frame to backed up consumer", "ident", torem.ident)
-						}
-					}
-					torem.lk.Unlock()
-					torem.cleanup()
-				}(s)
+				s.queuedBytes.Add(-size)
+				frame.release()
+				em.dropSlowSubscriber(s, "events")
 			}
 			s.broadcastCounter.Inc()
 		}
 	}
 }
 
+// dropSlowSubscriber ends the subscription of a subscriber whose queue of live
+// events is full. Must be called with subsLk held.
+func (em *EventManager) dropSlowSubscriber(s *Subscriber, limit string) {
+	// filter out all future messages that would be sent to this
+	// subscriber, but wait for it to actually be removed by the correct
+	// bit of code
+	s.filter = func(*XRPCStreamEvent) bool { return false }
+
+	em.log.Warn("dropping slow consumer due to event overflow", "limit", limit, "queuedEvents", len(s.outgoing), "queuedBytes", s.queuedBytes.Load(), "ident", s.ident)
+	slowSubscribersDropped.WithLabelValues(limit).Inc()
+	go func(torem *Subscriber) {
+		if torem.onOverflow != nil {
+			torem.onOverflow()
+			torem.cleanup()
+			return
+		}
+		torem.lk.Lock()
+		if !torem.cleanedUp {
+			select {
+			case torem.outgoing <- &XRPCStreamEvent{
+				Error: &ErrorFrame{
+					Error: "ConsumerTooSlow",
+				},
+			}:
+			case <-time.After(time.Second * 5):
+				em.log.Warn("failed to send error frame to backed up consumer", "ident", torem.ident)
+			}
+		}
+		torem.lk.Unlock()
+		torem.cleanup()
+	}(s)
+}
+
 func (em *EventManager) persistAndSendEvent(ctx context.Context, evt *XRPCStreamEvent) {
 	// TODO: can cut 5-10% off of disk persister benchmarks by making this function
 	// accept a uid. The lookup inside the persister is notably expensive (despite
@@ -143,6 +183,41 @@ type Subscriber struct {
 	ident            string
 	enqueuedCounter  prometheus.Counter
 	broadcastCounter prometheus.Counter
+
+	// see SubscriberLimits. queuedBytes is the size of the live events
+	// waiting in outgoing
+	maxBytes    int64
+	queuedBytes atomic.Int64
+	onOverflow  func()
+}
+
+// SubscriberLimits bound the live events queued for a subscriber which isn't
+// keeping up with the stream. A subscriber which exceeds either limit is
+// dropped: it is sent a ConsumerTooSlow error frame (unless OnOverflow is
+// set), then its channel is closed.
+//
+// Events are not dropped during playback, which waits for the subscriber
+// instead; the limits apply once it has caught up.
+type SubscriberLimits struct {
+	// MaxQueuedEvents is the number of live events which may be queued. Zero
+	// means the EventManager's default (16k)
+	MaxQueuedEvents int
+	// MaxQueuedBytes is the total serialized size of the live events which
+	// may be queued. Zero means no limit
+	MaxQueuedBytes int64
+	// OnOverflow, if set, is called in place of sending the ConsumerTooSlow
+	// error frame, just before the subscriber's channel is closed (so the
+	// subscriber can, eg, resubscribe from where it got to)
+	OnOverflow func()
+}
+
+// queuedSize is the size an event from the subscriber's queue was counted
+// as. Only valid while the subscriber's reference to the frame is held
+func (s *Subscriber) queuedSize(evt *XRPCStreamEvent) int64 {
+	if f := evt.frame; f != nil && s.maxBytes > 0 {
+		return int64(f.buf.Len())
+	}
+	return 0
 }
 
 const (
@@ -153,6 +228,8 @@ const (
 type EventHeader struct {
 	Op      int64  `cborgen:"op"`
 	MsgType string `cborgen:"t"`
+	// set only by relays with attestation enabled; see Attestor
+	Attestation *FrameAttestation `cborgen:"att,omitempty"`
 }
 
 var (
@@ -168,6 +245,7 @@ type XRPCStreamEvent struct {
 	RepoCommit    *comatproto.SyncSubscribeRepos_Commit
 	RepoHandle    *comatproto.SyncSubscribeRepos_Handle
 	RepoIdentity  *comatproto.SyncSubscribeRepos_Identity
+	RepoSync      *comatproto.SyncSubscribeRepos_Sync
 	RepoInfo      *comatproto.SyncSubscribeRepos_Info
 	RepoMigrate   *comatproto.SyncSubscribeRepos_Migrate
 	RepoTombstone *comatproto.SyncSubscribeRepos_Tombstone
@@ -180,9 +258,25 @@ type XRPCStreamEvent struct {
 	PrivPdsId       uint       `json:"-" cborgen:"-"`
 	PrivRelevantPds []uint     `json:"-" cborgen:"-"`
 	Preserialized   []byte     `json:"-" cborgen:"-"`
+
+	// wire frame shared by all live subscribers, set while broadcasting
+	frame *sharedFrame
 }
 
 func (evt *XRPCStreamEvent) Serialize(wc io.Writer) error {
+	header, obj, err := evt.headerAndBody()
+	if err != nil {
+		return err
+	}
+
+	cborWriter := cbg.NewCborWriter(wc)
+	if err := header.MarshalCBOR(cborWriter); err != nil {
+		return fmt.Errorf("failed to write header: %w", err)
+	}
+	return obj.MarshalCBOR(cborWriter)
+}
+
+func (evt *XRPCStreamEvent) headerAndBody() (EventHeader, lexutil.CBOR, error) {
 	header := EventHeader{Op: EvtKindMessage}
 	var obj lexutil.CBOR
 
@@ -202,6 +296,9 @@ func (evt *XRPCStreamEvent) Serialize(wc io.Writer) error {
 	case evt.RepoAccount != nil:
 		header.MsgType = "#account"
 		obj = evt.RepoAccount
+	case evt.RepoSync != nil:
+		header.MsgType = "#sync"
+		obj = evt.RepoSync
 	case evt.RepoInfo != nil:
 		header.MsgType = "#info"
 		obj = evt.RepoInfo
@@ -211,15 +308,13 @@ func (evt *XRPCStreamEvent) Serialize(wc io.Writer) error {
 	case evt.RepoTombstone != nil:
 		header.MsgType = "#tombstone"
 		obj = evt.RepoTombstone
+	case evt.LabelLabels != nil:
+		header.MsgType = "#labels"
+		obj = evt.LabelLabels
 	default:
-		return fmt.Errorf("unrecognized event kind")
+		return header, nil, fmt.Errorf("unrecognized event kind")
 	}
-
-	cborWriter := cbg.NewCborWriter(wc)
-	if err := header.MarshalCBOR(cborWriter); err != nil {
-		return fmt.Errorf("failed to write header: %w", err)
-	}
-	return obj.MarshalCBOR(cborWriter)
+	return header, obj, nil
 }
 
 func (xevt *XRPCStreamEvent) Deserialize(r io.Reader) error {
@@ -254,6 +349,12 @@ func (xevt *XRPCStreamEvent) Deserialize(r io.Reader) error {
 				return err
 			}
 			xevt.RepoAccount = &evt
+		case "#sync":
+			var evt comatproto.SyncSubscribeRepos_Sync
+			if err := evt.UnmarshalCBOR(r); err != nil {
+				return err
+			}
+			xevt.RepoSync = &evt
 		case "#info":
 			// TODO: this might also be a LabelInfo (as opposed to RepoInfo)
 			var evt comatproto.SyncSubscribeRepos_Info
@@ -327,18 +428,30 @@ var (
 )
 
 func (em *EventManager) Subscribe(ctx context.Context, ident string, filter func(*XRPCStreamEvent) bool, since *int64) (<-chan *XRPCStreamEvent, func(), error) {
+	return em.SubscribeWithLimits(ctx, ident, filter, since, SubscriberLimits{})
+}
+
+// SubscribeWithLimits is Subscribe, with limits on how far the subscriber may
+// fall behind the live stream
+func (em *EventManager) SubscribeWithLimits(ctx context.Context, ident string, filter func(*XRPCStreamEvent) bool, since *int64, limits SubscriberLimits) (<-chan *XRPCStreamEvent, func(), error) {
 	if filter == nil {
 		filter = func(*XRPCStreamEvent) bool { return true }
 	}
+	bufferSize := em.bufferSize
+	if limits.MaxQueuedEvents > 0 {
+		bufferSize = limits.MaxQueuedEvents
+	}
 
 	done := make(chan struct{})
 	sub := &Subscriber{
 		ident:            ident,
-		outgoing:         make(chan *XRPCStreamEvent, em.bufferSize),
+		outgoing:         make(chan *XRPCStreamEvent, bufferSize),
 		filter:           filter,
 		done:             done,
 		enqueuedCounter:  eventsEnqueued.WithLabelValues(ident),
 		broadcastCounter: eventsBroadcast.WithLabelValues(ident),
+		maxBytes:         limits.MaxQueuedBytes,
+		onOverflow:       limits.OnOverflow,
 	}
 
 	sub.cleanup = sync.OnceFunc(func() {
@@ -350,17 +463,39 @@ func (em *EventManager) Subscribe(ctx context.Context, ident string, filter func
 		sub.cleanedUp = true
 	})
 
-	if since == nil {
+	if since == nil && sub.maxBytes == 0 {
 		em.addSubscriber(sub)
 		return sub.outgoing, sub.cleanup, nil
 	}
 
-	out := make(chan *XRPCStreamEvent, em.crossoverBufferSize)
+	crossoverBufferSize := em.crossoverBufferSize
+	if sub.maxBytes > 0 {
+		// events count towards the limit until the subscriber receives
+		// them, so don't buffer any more out of sight
+		crossoverBufferSize = 0
+	}
+	out := make(chan *XRPCStreamEvent, crossoverBufferSize)
+
+	if since == nil {
+		em.addSubscriber(sub)
+		go em.forward(sub, out)
+		return out, sub.cleanup, nil
+	}
 
 	go func() {
 		lastSeq := *since
 		// run playback to get through *most* of the events, getting our current cursor close to realtime
 		if err := em.persister.Playback(ctx, *since, func(e *XRPCStreamEvent) error {
+			if !filter(e) {
+				if seq := SequenceForEvent(e); seq > 0 {
+					lastSeq = seq
+				}
+				return nil
+			}
+			e, err := em.playbackCopy(e)
+			if err != nil {
+				return err
+			}
 			select {
 			case <-done:
 				return ErrPlaybackShutdown
@@ -386,7 +521,13 @@ func (em *EventManager) Subscribe(ctx context.Context, ident string, filter func
 		// now, start buffering events from the live stream
 		em.addSubscriber(sub)
 
-		first := <-sub.outgoing
+		first, ok := <-sub.outgoing
+		if !ok {
+			// unsubscribed (or dropped) before any live events arrived
+			close(out)
+			return
+		}
+		sub.queuedBytes.Add(-sub.queuedSize(first))
 
 		// run playback again to get us to the events that have started buffering
 		if err := em.persister.Playback(ctx, lastSeq, func(e *XRPCStreamEvent) error {
@@ -394,7 +535,14 @@ func (em *EventManager) Subscribe(ctx context.Context, ident string, filter func
 			if seq > SequenceForEvent(first) {
 				return ErrCaughtUp
 			}
+			if !filter(e) {
+				return nil
+			}
 
+			e, err := em.playbackCopy(e)
+			if err != nil {
+				return err
+			}
 			select {
 			case <-done:
 				return ErrPlaybackShutdown
@@ -413,19 +561,70 @@ func (em *EventManager) Subscribe(ctx context.Context, ident string, filter func
 		}
 
 		// now that we are caught up, just copy events from the channel over
-		for evt := range sub.outgoing {
-			select {
-			case out <- evt:
-			case <-done:
-				em.rmSubscriber(sub)
-				return
-			}
-		}
+		em.forward(sub, out)
 	}()
 
 	return out, sub.cleanup, nil
 }
 
+// forward copies live events from the subscriber's queue to out, until the
+// subscription ends
+func (em *EventManager) forward(sub *Subscriber, out chan<- *XRPCStreamEvent) {
+	// lets the subscriber see that it was dropped
+	defer close(out)
+	for evt := range sub.outgoing {
+		// once sent, the subscriber may release the frame at any time
+		size := sub.queuedSize(evt)
+		select {
+		case out <- evt:
+			sub.queuedBytes.Add(-size)
+		case <-sub.done:
+			em.rmSubscriber(sub)
+			return
+		}
+	}
+}
+
+// Persisters may play back the same event objects which are being broadcast
+// live. The live frame references belong to the live subscribers (and the
+// frame field may be written concurrently), so playback hands out a copy
+// without one.
+//
+// Persisted serializations are unsigned, so when attesting, the copy is
+// re-serialized with an attestation.
+func (em *EventManager) playbackCopy(evt *XRPCStreamEvent) (*XRPCStreamEvent, error) {
+	e := withoutFrame(evt)
+	if em.attestor == nil {
+		return e, nil
+	}
+	var buf bytes.Buffer
+	if err := em.attestor.SerializeAttested(&buf, e); err != nil {
+		return nil, fmt.Errorf("attesting playback event: %w", err)
+	}
+	e.Preserialized = buf.Bytes()
+	return e, nil
+}
+
+func withoutFrame(evt *XRPCStreamEvent) *XRPCStreamEvent {
+	return &XRPCStreamEvent{
+		Error:           evt.Error,
+		RepoCommit:      evt.RepoCommit,
+		RepoHandle:      evt.RepoHandle,
+		RepoIdentity:    evt.RepoIdentity,
+		RepoSync:        evt.RepoSync,
+		RepoInfo:        evt.RepoInfo,
+		RepoMigrate:     evt.RepoMigrate,
+		RepoTombstone:   evt.RepoTombstone,
+		RepoAccount:     evt.RepoAccount,
+		LabelLabels:     evt.LabelLabels,
+		LabelInfo:       evt.LabelInfo,
+		PrivUid:         evt.PrivUid,
+		PrivPdsId:       evt.PrivPdsId,
+		PrivRelevantPds: evt.PrivRelevantPds,
+		Preserialized:   evt.Preserialized,
+	}
+}
+
 func SequenceForEvent(evt *XRPCStreamEvent) int64 {
 	return evt.Sequence()
 }
@@ -446,6 +645,10 @@ func (evt *XRPCStreamEvent) Sequence() int64 {
 		return evt.RepoIdentity.Seq
 	case evt.RepoAccount != nil:
 		return evt.RepoAccount.Seq
+	case evt.RepoSync != nil:
+		return evt.RepoSync.Seq
+	case evt.LabelLabels != nil:
+		return evt.LabelLabels.Seq
 	case evt.RepoInfo != nil:
 		return -1
 	case evt.Error != nil:
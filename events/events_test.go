@@ -0,0 +1,141 @@
+package events
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+)
+
+func TestSubscribePlaybackFilter(t *testing.T) {
+	ctx := context.Background()
+	opts := DefaultPebblePersistOptions
+	opts.DbPath = filepath.Join(t.TempDir(), "pebble.db")
+	pp, err := NewPebblePersistance(&opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// not shut down: the subscription's playback goroutine can outlive the
+	// test, and would use the closed database
+	em := NewEventManager(pp)
+
+	seq := int64(0)
+	add := func(did string) {
+		seq++
+		if err := em.AddEvent(ctx, &XRPCStreamEvent{RepoIdentity: &atproto.SyncSubscribeRepos_Identity{Did: did, Seq: seq}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		if i%3 == 0 {
+			add("did:example:alice")
+		} else {
+			add("did:example:bob")
+		}
+	}
+	if err := pp.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	since := int64(0)
+	evts, cleanup, err := em.Subscribe(ctx, "test", func(evt *XRPCStreamEvent) bool {
+		return evt.Repo() == "did:example:alice"
+	}, &since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	// live events end the playback phase; keep adding them until one arrives
+	var seqs []int64
+	timeout := time.After(5 * time.Second)
+	for len(seqs) < 5 {
+		add("did:example:bob")
+		add("did:example:alice")
+		select {
+		case evt := <-evts:
+			if evt.Repo() != "did:example:alice" {
+				t.Fatalf("filtered event sent: %s", evt.Repo())
+			}
+			seqs = append(seqs, evt.Sequence())
+		case <-time.After(50 * time.Millisecond):
+		case <-timeout:
+			t.Fatalf("only got %d events", len(seqs))
+		}
+	}
+	for i, seq := range []int64{1, 4, 7, 10} {
+		if seqs[i] != seq {
+			t.Fatalf("unexpected sequence numbers: %v", seqs)
+		}
+	}
+}
+
+func TestSubscriberLimits(t *testing.T) {
+	ctx := context.Background()
+	em := NewEventManager(NewMemPersister())
+
+	seq := int64(0)
+	add := func() {
+		seq++
+		if err := em.AddEvent(ctx, &XRPCStreamEvent{RepoIdentity: &atproto.SyncSubscribeRepos_Identity{Did: "did:example:alice", Seq: seq}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	drain := func(evts <-chan *XRPCStreamEvent) []*XRPCStreamEvent {
+		t.Helper()
+		var got []*XRPCStreamEvent
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case evt, ok := <-evts:
+				if !ok {
+					return got
+				}
+				got = append(got, evt)
+			case <-timeout:
+				t.Fatalf("subscription not closed after %d events", len(got))
+			}
+		}
+	}
+
+	// too many events: the subscriber is sent an error frame
+	evts, cleanup, err := em.SubscribeWithLimits(ctx, "events", nil, nil, SubscriberLimits{MaxQueuedEvents: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	for i := 0; i < 5; i++ {
+		add()
+	}
+	got := drain(evts)
+	if len(got) != 4 || got[3].Error == nil || got[3].Error.Error != "ConsumerTooSlow" {
+		t.Fatalf("expected 3 events and an error frame, got %d", len(got))
+	}
+
+	// too many bytes: OnOverflow is called instead
+	overflowed := make(chan struct{})
+	evts, cleanup, err = em.SubscribeWithLimits(ctx, "bytes", nil, nil, SubscriberLimits{
+		MaxQueuedBytes: 200,
+		OnOverflow:     func() { close(overflowed) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	for i := 0; i < 20; i++ {
+		add()
+	}
+	<-overflowed
+	// events queued before the overflow may or may not be delivered
+	got = drain(evts)
+	if len(got) >= 20 {
+		t.Fatalf("expected the subscriber to be dropped, got all %d events", len(got))
+	}
+	for _, evt := range got {
+		if evt.Error != nil {
+			t.Fatalf("unexpected error frame: %s", evt.Error.Error)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package events
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+)
+
+// buffers larger than this aren't returned to the pool, so that one huge event
+// doesn't pin a huge buffer forever
+const maxPooledFrameSize = 1 << 20
+
+var frameBufPool = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
+// sharedFrame is the serialized wire form (header + body) of an event being
+// broadcast, shared by every subscriber the event is sent to. Each subscriber
+// holds a reference; when the last one is released the buffer goes back to
+// the pool.
+//
+// The websocket frame is prepared once and cached, so writes to any number of
+// connections use the same encoded bytes.
+type sharedFrame struct {
+	buf  *bytes.Buffer
+	refs atomic.Int64
+
+	prepOnce sync.Once
+	prepared *websocket.PreparedMessage
+	prepErr  error
+}
+
+// newSharedFrame serializes the event into a pooled buffer, returning a frame
+// holding one reference for the caller. If attestor is non-nil the frame is
+// signed (and any unsigned Preserialized bytes are not used).
+func newSharedFrame(evt *XRPCStreamEvent, attestor *Attestor) (*sharedFrame, error) {
+	buf := frameBufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+
+	var err error
+	if attestor != nil {
+		err = attestor.SerializeAttested(buf, evt)
+	} else if evt.Preserialized != nil {
+		_, err = buf.Write(evt.Preserialized)
+	} else {
+		err = evt.Serialize(buf)
+	}
+	if err != nil {
+		frameBufPool.Put(buf)
+		return nil, err
+	}
+
+	f := &sharedFrame{buf: buf}
+	f.refs.Store(1)
+	eventFramesSerialized.Inc()
+	return f, nil
+}
+
+func (f *sharedFrame) retain() {
+	f.refs.Add(1)
+}
+
+func (f *sharedFrame) release() {
+	if f.refs.Add(-1) != 0 {
+		return
+	}
+
+	buf := f.buf
+	f.buf = nil
+	f.prepared = nil
+	if buf.Cap() <= maxPooledFrameSize {
+		frameBufPool.Put(buf)
+	}
+	eventFramesRecycled.Inc()
+}
+
+// websocketMessage returns the prepared websocket message for the frame,
+// encoding it on first use. Only valid while the caller holds a reference.
+func (f *sharedFrame) websocketMessage() (*websocket.PreparedMessage, error) {
+	f.prepOnce.Do(func() {
+		f.prepared, f.prepErr = websocket.NewPreparedMessage(websocket.BinaryMessage, f.buf.Bytes())
+	})
+	return f.prepared, f.prepErr
+}
+
+// WriteEvent writes an event to a websocket connection as a single binary
+// message. Events received from EventManager.Subscribe share a serialized
+// frame with every other subscriber; this writes that frame as-is and releases
+// this subscriber's reference to it, so each event received from a
+// subscription must be written (at most) once. Other events are written from
+// their Preserialized bytes, or serialized on the spot.
+func WriteEvent(conn *websocket.Conn, evt *XRPCStreamEvent) error {
+	_, err := WriteEventSize(conn, evt)
+	return err
+}
+
+// WriteEventSize is WriteEvent, also returning the size of the message
+// written (before any permessage-deflate compression)
+func WriteEventSize(conn *websocket.Conn, evt *XRPCStreamEvent) (int, error) {
+	if f := evt.frame; f != nil {
+		defer f.release()
+		pm, err := f.websocketMessage()
+		if err != nil {
+			return 0, fmt.Errorf("preparing event frame: %w", err)
+		}
+		return f.buf.Len(), conn.WritePreparedMessage(pm)
+	}
+
+	wc, err := conn.NextWriter(websocket.BinaryMessage)
+	if err != nil {
+		return 0, err
+	}
+
+	cw := &countingWriter{w: wc}
+	if evt.Preserialized != nil {
+		_, err = cw.Write(evt.Preserialized)
+	} else {
+		err = evt.Serialize(cw)
+	}
+	if err != nil {
+		wc.Close()
+		return cw.n, fmt.Errorf("failed to write event: %w", err)
+	}
+
+	return cw.n, wc.Close()
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += n
+	return n, err
+}
+
+// ReleaseEvent releases the subscriber's reference to an event's shared frame,
+// for senders which don't send the wire form at all (eg, converting the event
+// to another format). Like WriteEvent, it must be called at most once per
+// event received from a subscription, and not as well as WriteEvent or
+// WithEventBytes.
+func ReleaseEvent(evt *XRPCStreamEvent) {
+	if f := evt.frame; f != nil {
+		f.release()
+	}
+}
+
+// WithEventBytes calls fn with the serialized wire form (header + body) of an
+// event, for senders which transform it before writing (eg, compressing it).
+// Like WriteEvent, this releases the subscriber's reference to a shared frame,
+// so it must be called at most once per event received from a subscription,
+// and fn must not keep b after returning.
+func WithEventBytes(evt *XRPCStreamEvent, fn func(b []byte) error) error {
+	if f := evt.frame; f != nil {
+		defer f.release()
+		return fn(f.buf.Bytes())
+	}
+
+	if evt.Preserialized != nil {
+		return fn(evt.Preserialized)
+	}
+
+	buf := frameBufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer func() {
+		if buf.Cap() <= maxPooledFrameSize {
+			frameBufPool.Put(buf)
+		}
+	}()
+	if err := evt.Serialize(buf); err != nil {
+		return fmt.Errorf("failed to serialize event: %w", err)
+	}
+	return fn(buf.Bytes())
+}
@@ -0,0 +1,155 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+// returns the server and client ends of a websocket connection
+func testWebsocketPair(t *testing.T) (*websocket.Conn, *websocket.Conn) {
+	t.Helper()
+	conns := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		con, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conns <- con
+	}))
+	t.Cleanup(srv.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := <-conns
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return server, client
+}
+
+func readTestEvent(t *testing.T, con *websocket.Conn) *XRPCStreamEvent {
+	t.Helper()
+	mt, msg, err := con.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, websocket.BinaryMessage, mt)
+	var evt XRPCStreamEvent
+	if err := evt.Deserialize(bytes.NewReader(msg)); err != nil {
+		t.Fatal(err)
+	}
+	return &evt
+}
+
+func TestSharedFrameFanout(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	em := NewEventManager(NewMemPersister())
+
+	var subs []<-chan *XRPCStreamEvent
+	for i := 0; i < 3; i++ {
+		ch, cleanup, err := em.Subscribe(ctx, "test", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cleanup()
+		subs = append(subs, ch)
+	}
+
+	evt := &XRPCStreamEvent{RepoIdentity: &comatproto.SyncSubscribeRepos_Identity{
+		Did:  "did:example:abc",
+		Seq:  7,
+		Time: time.Now().UTC().Format(time.RFC3339Nano),
+	}}
+	if err := em.AddEvent(ctx, evt); err != nil {
+		t.Fatal(err)
+	}
+
+	// serialized once; one reference per subscriber
+	frame := evt.frame
+	if !assert.NotNil(frame) {
+		return
+	}
+	assert.Equal(int64(3), frame.refs.Load())
+
+	for _, ch := range subs {
+		got := <-ch
+		assert.Same(evt, got)
+
+		server, client := testWebsocketPair(t)
+		assert.NoError(WriteEvent(server, got))
+		read := readTestEvent(t, client)
+		assert.Equal(evt.Sequence(), read.Sequence())
+	}
+	assert.Equal(int64(0), frame.refs.Load())
+
+	// playback hands out copies without the live frame
+	ch, cleanup, err := em.Subscribe(ctx, "playback", nil, new(int64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	played := <-ch
+	assert.NotSame(evt, played)
+	assert.Nil(played.frame)
+
+	server, client := testWebsocketPair(t)
+	assert.NoError(WriteEvent(server, played))
+	assert.Equal(evt.Sequence(), readTestEvent(t, client).Sequence())
+}
+
+func TestReleaseEvent(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	em := NewEventManager(NewMemPersister())
+	var subs []<-chan *XRPCStreamEvent
+	for i := 0; i < 2; i++ {
+		ch, cleanup, err := em.Subscribe(ctx, "test", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cleanup()
+		subs = append(subs, ch)
+	}
+
+	evt := &XRPCStreamEvent{RepoIdentity: &comatproto.SyncSubscribeRepos_Identity{
+		Did:  "did:example:abc",
+		Seq:  7,
+		Time: time.Now().UTC().Format(time.RFC3339Nano),
+	}}
+	if err := em.AddEvent(ctx, evt); err != nil {
+		t.Fatal(err)
+	}
+	frame := evt.frame
+	if !assert.NotNil(frame) {
+		return
+	}
+
+	// a subscriber which converts events, rather than writing them
+	ReleaseEvent(<-subs[0])
+	assert.Equal(int64(1), frame.refs.Load())
+	ReleaseEvent(<-subs[1])
+	assert.Equal(int64(0), frame.refs.Load())
+	assert.Nil(frame.buf)
+
+	// events without a shared frame have nothing to release
+	ReleaseEvent(&XRPCStreamEvent{RepoIdentity: evt.RepoIdentity})
+}
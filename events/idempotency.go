@@ -0,0 +1,172 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// IdempotencyStore records which events have already been fully handled, so
+// that side effects are not applied twice when a consumer re-processes events
+// after a crash, or when a stream is replayed from an older cursor.
+type IdempotencyStore interface {
+	// Seen returns true if the key has previously been marked as done
+	Seen(ctx context.Context, key string) (bool, error)
+	// Mark records the key as done
+	Mark(ctx context.Context, key string) error
+}
+
+// IdempotencyKey builds the key for an event from its sequence number, the
+// account DID it concerns, and the version of the handler processing it.
+// Bumping the handler version causes all events to be handled again.
+func IdempotencyKey(version string, did string, seq int64) string {
+	return fmt.Sprintf("%s/%s/%d", version, did, seq)
+}
+
+// EventIdempotencyKey returns the idempotency key for a stream event, or false
+// if the event doesn't have a sequence number (eg, #info and error frames).
+// Label events are keyed by sequence number only.
+func EventIdempotencyKey(version string, xev *XRPCStreamEvent) (string, bool) {
+	var did string
+	var seq int64
+	switch {
+	case xev.RepoCommit != nil:
+		did, seq = xev.RepoCommit.Repo, xev.RepoCommit.Seq
+	case xev.RepoHandle != nil:
+		did, seq = xev.RepoHandle.Did, xev.RepoHandle.Seq
+	case xev.RepoIdentity != nil:
+		did, seq = xev.RepoIdentity.Did, xev.RepoIdentity.Seq
+	case xev.RepoAccount != nil:
+		did, seq = xev.RepoAccount.Did, xev.RepoAccount.Seq
+	case xev.RepoMigrate != nil:
+		did, seq = xev.RepoMigrate.Did, xev.RepoMigrate.Seq
+	case xev.RepoTombstone != nil:
+		did, seq = xev.RepoTombstone.Did, xev.RepoTombstone.Seq
+	case xev.RepoSync != nil:
+		did, seq = xev.RepoSync.Did, xev.RepoSync.Seq
+	case xev.LabelLabels != nil:
+		seq = xev.LabelLabels.Seq
+	default:
+		return "", false
+	}
+	return IdempotencyKey(version, did, seq), true
+}
+
+// IdempotentHandler wraps an event handler, skipping events which have already
+// been handled successfully. Events are only marked as done when the wrapped
+// handler returns without error, so failed events are retried on replay.
+//
+// This provides exactly-once application of side effects as long as the
+// wrapped handler's effects are committed before it returns; a crash between
+// the handler returning and the key being marked can still result in a
+// repeat, so handlers should keep their effects small and retry-safe where
+// possible.
+type IdempotentHandler struct {
+	Store IdempotencyStore
+	// Version identifies the handler logic; included in every key
+	Version string
+	Next    func(ctx context.Context, xev *XRPCStreamEvent) error
+}
+
+func NewIdempotentHandler(store IdempotencyStore, version string, next func(ctx context.Context, xev *XRPCStreamEvent) error) *IdempotentHandler {
+	return &IdempotentHandler{
+		Store:   store,
+		Version: version,
+		Next:    next,
+	}
+}
+
+func (ih *IdempotentHandler) EventHandler(ctx context.Context, xev *XRPCStreamEvent) error {
+	key, ok := EventIdempotencyKey(ih.Version, xev)
+	if !ok {
+		return ih.Next(ctx, xev)
+	}
+
+	seen, err := ih.Store.Seen(ctx, key)
+	if err != nil {
+		return fmt.Errorf("checking idempotency key: %w", err)
+	}
+	if seen {
+		eventsIdempotentSkipped.Inc()
+		return nil
+	}
+
+	if err := ih.Next(ctx, xev); err != nil {
+		return err
+	}
+
+	if err := ih.Store.Mark(ctx, key); err != nil {
+		return fmt.Errorf("marking idempotency key: %w", err)
+	}
+	return nil
+}
+
+// MemIdempotencyStore keeps the most recently marked keys in memory. It
+// protects against replays within a single process, but not across restarts.
+type MemIdempotencyStore struct {
+	keys *lru.Cache[string, struct{}]
+}
+
+var _ IdempotencyStore = (*MemIdempotencyStore)(nil)
+
+func NewMemIdempotencyStore(size int) (*MemIdempotencyStore, error) {
+	keys, err := lru.New[string, struct{}](size)
+	if err != nil {
+		return nil, err
+	}
+	return &MemIdempotencyStore{keys: keys}, nil
+}
+
+func (s *MemIdempotencyStore) Seen(ctx context.Context, key string) (bool, error) {
+	return s.keys.Contains(key), nil
+}
+
+func (s *MemIdempotencyStore) Mark(ctx context.Context, key string) error {
+	s.keys.Add(key, struct{}{})
+	return nil
+}
+
+type IdempotencyKeyRecord struct {
+	Key       string    `gorm:"primarykey"`
+	CreatedAt time.Time `gorm:"index"`
+}
+
+// DbIdempotencyStore persists keys in a database table, so they survive restarts
+type DbIdempotencyStore struct {
+	db *gorm.DB
+}
+
+var _ IdempotencyStore = (*DbIdempotencyStore)(nil)
+
+func NewDbIdempotencyStore(db *gorm.DB) (*DbIdempotencyStore, error) {
+	if err := db.AutoMigrate(&IdempotencyKeyRecord{}); err != nil {
+		return nil, err
+	}
+	return &DbIdempotencyStore{db: db}, nil
+}
+
+func (s *DbIdempotencyStore) Seen(ctx context.Context, key string) (bool, error) {
+	var count int64
+	if err := s.db.WithContext(ctx).Model(&IdempotencyKeyRecord{}).Where("key = ?", key).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (s *DbIdempotencyStore) Mark(ctx context.Context, key string) error {
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&IdempotencyKeyRecord{
+		Key:       key,
+		CreatedAt: time.Now(),
+	}).Error
+}
+
+// Prune deletes keys older than the given age, to keep the table small. The
+// age should be longer than any replay window the consumer might encounter.
+func (s *DbIdempotencyStore) Prune(ctx context.Context, maxAge time.Duration) (int64, error) {
+	res := s.db.WithContext(ctx).Where("created_at < ?", time.Now().Add(-maxAge)).Delete(&IdempotencyKeyRecord{})
+	return res.RowsAffected, res.Error
+}
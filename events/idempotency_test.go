@@ -0,0 +1,112 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func testIdempotentHandler(t *testing.T, store IdempotencyStore) {
+	ctx := context.Background()
+
+	calls := 0
+	fail := false
+	ih := NewIdempotentHandler(store, "v1", func(ctx context.Context, xev *XRPCStreamEvent) error {
+		calls++
+		if fail {
+			return errors.New("handler failed")
+		}
+		return nil
+	})
+
+	evt := func(seq int64) *XRPCStreamEvent {
+		return &XRPCStreamEvent{RepoIdentity: &comatproto.SyncSubscribeRepos_Identity{Did: "did:plc:abc", Seq: seq}}
+	}
+
+	// failed events are not marked, and get retried
+	fail = true
+	if err := ih.EventHandler(ctx, evt(1)); err == nil {
+		t.Fatal("expected error")
+	}
+	fail = false
+	for i := 0; i < 3; i++ {
+		if err := ih.EventHandler(ctx, evt(1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != 2 {
+		t.Fatalf("expected 2 calls, got %d", calls)
+	}
+
+	if err := ih.EventHandler(ctx, evt(2)); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Fatalf("expected 3 calls, got %d", calls)
+	}
+
+	// events without a sequence number always pass through
+	info := &XRPCStreamEvent{RepoInfo: &comatproto.SyncSubscribeRepos_Info{Name: "OutdatedCursor"}}
+	ih.EventHandler(ctx, info)
+	ih.EventHandler(ctx, info)
+	if calls != 5 {
+		t.Fatalf("expected 5 calls, got %d", calls)
+	}
+
+	// a new handler version re-processes everything
+	ih.Version = "v2"
+	if err := ih.EventHandler(ctx, evt(1)); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 6 {
+		t.Fatalf("expected 6 calls, got %d", calls)
+	}
+}
+
+func TestMemIdempotencyStore(t *testing.T) {
+	store, err := NewMemIdempotencyStore(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testIdempotentHandler(t, store)
+}
+
+func TestDbIdempotencyStore(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "idem.sqlite")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewDbIdempotencyStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testIdempotentHandler(t, store)
+
+	// marking twice is not an error
+	ctx := context.Background()
+	if err := store.Mark(ctx, IdempotencyKey("v1", "did:plc:abc", 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := store.Prune(ctx, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Fatalf("expected 3 keys pruned, got %d", n)
+	}
+	seen, err := store.Seen(ctx, IdempotencyKey("v1", "did:plc:abc", 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seen {
+		t.Fatal("expected key to be pruned")
+	}
+}
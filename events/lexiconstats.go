@@ -0,0 +1,236 @@
+package events
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LexiconWindowStats is the activity in a single collection over one window
+type LexiconWindowStats struct {
+	// window length, as a Go duration string (eg, "15m0s")
+	Window string `json:"window"`
+	OpCounts
+	// estimated number of distinct accounts with ops in the collection. exact
+	// for small counts, and within a few percent otherwise
+	UniqueDIDs int64 `json:"uniqueDids"`
+}
+
+// LexiconStats is the activity in a single collection over each report window
+type LexiconStats struct {
+	Collection string               `json:"collection"`
+	Windows    []LexiconWindowStats `json:"windows"`
+}
+
+// LexiconStatsReport reports per-collection activity over several recent
+// windows, for tracking adoption of lexicons
+type LexiconStatsReport struct {
+	Time time.Time `json:"time"`
+	// when stats collection started; windows reaching back further than this
+	// are incomplete
+	Since       time.Time      `json:"since"`
+	Collections []LexiconStats `json:"collections"`
+}
+
+// DefaultLexiconWindows are the windows reported by LexiconReport if none are
+// given, along with the full stats window
+var DefaultLexiconWindows = []time.Duration{5 * time.Minute, time.Hour}
+
+// LexiconReport returns op counts and unique account counts for each
+// collection, over each of the given windows (which are capped to the stats
+// window, and rounded up to the bucket width). Collections are restricted to
+// those starting with prefix (if non-empty), and sorted by op count in the
+// longest window, with at most limit returned (zero for all).
+func (cs *CollectionStats) LexiconReport(windows []time.Duration, prefix string, limit int) *LexiconStatsReport {
+	now := time.Now()
+	cs.lk.Lock()
+	defer cs.lk.Unlock()
+
+	rep := &LexiconStatsReport{
+		Time:        now,
+		Since:       now,
+		Collections: []LexiconStats{},
+	}
+	if cs.started.IsZero() {
+		return rep
+	}
+	cs.expire(now)
+	rep.Since = cs.started
+
+	full := cs.bucketWidth * collectionStatsBuckets
+	if len(windows) == 0 {
+		windows = append(slices.Clone(DefaultLexiconWindows), full)
+	}
+	windows = lexiconWindows(windows, full)
+
+	type acc struct {
+		counts []OpCounts
+		dids   []*didSketch
+	}
+	colls := make(map[string]*acc)
+	// buckets are in time order, so walk backwards, widening to each window in turn
+	for i := len(cs.buckets) - 1; i >= 0; i-- {
+		b := cs.buckets[i]
+		age := now.Sub(b.start)
+		for name, c := range b.collections {
+			if name == CollectionStatsInvalid || (prefix != "" && !strings.HasPrefix(name, prefix)) {
+				continue
+			}
+			a, ok := colls[name]
+			if !ok {
+				a = &acc{counts: make([]OpCounts, len(windows)), dids: make([]*didSketch, len(windows))}
+				colls[name] = a
+			}
+			for w, window := range windows {
+				if age >= window+cs.bucketWidth {
+					continue
+				}
+				a.counts[w].merge(c)
+				if s := b.dids[name]; s != nil {
+					if a.dids[w] == nil {
+						a.dids[w] = &didSketch{}
+					}
+					a.dids[w].merge(s)
+				}
+			}
+		}
+	}
+
+	for name, a := range colls {
+		ls := LexiconStats{Collection: name, Windows: make([]LexiconWindowStats, len(windows))}
+		for w, window := range windows {
+			ls.Windows[w] = LexiconWindowStats{
+				Window:   window.String(),
+				OpCounts: a.counts[w],
+			}
+			if a.dids[w] != nil {
+				ls.Windows[w].UniqueDIDs = a.dids[w].count()
+			}
+		}
+		rep.Collections = append(rep.Collections, ls)
+	}
+	last := len(windows) - 1
+	sort.Slice(rep.Collections, func(i, j int) bool {
+		ci, cj := rep.Collections[i], rep.Collections[j]
+		if ci.Windows[last].Total != cj.Windows[last].Total {
+			return ci.Windows[last].Total > cj.Windows[last].Total
+		}
+		return ci.Collection < cj.Collection
+	})
+	if limit > 0 && len(rep.Collections) > limit {
+		rep.Collections = rep.Collections[:limit]
+	}
+	return rep
+}
+
+// caps windows to max, and returns them sorted and deduplicated
+func lexiconWindows(windows []time.Duration, max time.Duration) []time.Duration {
+	out := make([]time.Duration, 0, len(windows))
+	for _, w := range windows {
+		out = append(out, min(w, max))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return slices.Compact(out)
+}
+
+const (
+	// HyperLogLog precision: 2^10 registers, for about 3% standard error
+	didSketchPrecision = 10
+	didSketchRegisters = 1 << didSketchPrecision
+	// sketches stay exact (a set of hashes) up to this many accounts, since
+	// most collections see few accounts per bucket
+	didSketchSparseMax = 256
+)
+
+// didSketch estimates the number of distinct account DIDs: exactly while
+// small, and with a HyperLogLog once there are many
+type didSketch struct {
+	sparse map[uint64]struct{}
+	regs   []uint8
+}
+
+func hashDID(did string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(did))
+	// FNV's high bits are poorly mixed for short, similar inputs; finish with splitmix64
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func (s *didSketch) add(did string) {
+	s.addHash(hashDID(did))
+}
+
+func (s *didSketch) addHash(h uint64) {
+	if s.regs != nil {
+		idx := h >> (64 - didSketchPrecision)
+		rank := uint8(bits.LeadingZeros64(h<<didSketchPrecision|1<<(didSketchPrecision-1)) + 1)
+		if rank > s.regs[idx] {
+			s.regs[idx] = rank
+		}
+		return
+	}
+	if s.sparse == nil {
+		s.sparse = make(map[uint64]struct{})
+	}
+	s.sparse[h] = struct{}{}
+	if len(s.sparse) > didSketchSparseMax {
+		s.densify()
+	}
+}
+
+func (s *didSketch) densify() {
+	s.regs = make([]uint8, didSketchRegisters)
+	for h := range s.sparse {
+		s.addHash(h)
+	}
+	s.sparse = nil
+}
+
+// merge adds all the accounts of o to s
+func (s *didSketch) merge(o *didSketch) {
+	if o.regs != nil {
+		if s.regs == nil {
+			s.densify()
+		}
+		for i, r := range o.regs {
+			if r > s.regs[i] {
+				s.regs[i] = r
+			}
+		}
+		return
+	}
+	for h := range o.sparse {
+		s.addHash(h)
+	}
+}
+
+func (s *didSketch) count() int64 {
+	if s.regs == nil {
+		return int64(len(s.sparse))
+	}
+	m := float64(didSketchRegisters)
+	sum := 0.0
+	zeros := 0
+	for _, r := range s.regs {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	est := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if est <= 2.5*m && zeros > 0 {
+		// linear counting is more accurate for small cardinalities
+		est = m * math.Log(m/float64(zeros))
+	}
+	return int64(est + 0.5)
+}
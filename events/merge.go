@@ -0,0 +1,414 @@
+package events
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	toolsozone "github.com/bluesky-social/indigo/api/ozone"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// Kinds of stream a merged event can come from
+const (
+	MergeKindRepos  = "repos"
+	MergeKindLabels = "labels"
+	MergeKindOzone  = "ozone"
+)
+
+// MergedEvent is an event from one of the sources of a Merger
+type MergedEvent struct {
+	// Name of the source, as configured in the Merger
+	Source string
+	// One of the MergeKind* values
+	Kind string
+	// Position of the event in its source: a sequence number, or for ozone events, the creation time in unix microseconds. -1 for events without a position (eg, #info frames)
+	Cursor int64
+	// Creation time of the event, used to order events across sources. If the event doesn't have one, the time it was received
+	Time time.Time
+
+	// Set for repo and label stream events
+	Stream *XRPCStreamEvent
+	// Set for ozone events
+	Ozone *toolsozone.ModerationDefs_ModEventView
+}
+
+// MergeSource is an upstream event stream, consumed by a Merger
+type MergeSource interface {
+	// Passes events to emit, in order, starting after cursor (or from the current position, if cursor is negative), until ctx is done or emit fails. Transient upstream failures should be retried, rather than returned.
+	Run(ctx context.Context, cursor int64, emit func(*MergedEvent) error) error
+}
+
+type MergerSource struct {
+	// Identifies the source in merged events, logs and metrics
+	Name   string
+	Source MergeSource
+	// Where the position of the source is persisted. Optional; defaults to an in-memory store
+	Cursors CursorStore
+}
+
+type MergerConfig struct {
+	Sources []MergerSource
+	// Called for each event, in order. Events from each source are always handled in the order the source emitted them
+	Handler func(ctx context.Context, evt *MergedEvent) error
+	// How long events are held back to be ordered against events from other sources. Defaults to 1 second
+	Window time.Duration
+	// Number of events which may be waiting to be ordered before sources are paused. Defaults to 1000
+	BufferSize int
+	// How often source cursors are persisted. Defaults to 5 seconds
+	CursorFlushInterval time.Duration
+
+	Logger *slog.Logger
+}
+
+// Merger consumes several event streams (eg, a relay firehose, a labeler's label stream, and an ozone event log), and hands their events to a single handler, roughly ordered by creation time and tagged with their source.
+//
+// Each source has its own cursor, which only advances once its events have been handled, so a restarted merger resumes every source where it left off.
+type Merger struct {
+	cfg    MergerConfig
+	logger *slog.Logger
+
+	mu sync.Mutex
+	// last handled cursor, by source name
+	cursors map[string]int64
+}
+
+func NewMerger(cfg MergerConfig) (*Merger, error) {
+	if cfg.Handler == nil {
+		return nil, fmt.Errorf("merger handler is required")
+	}
+	if len(cfg.Sources) == 0 {
+		return nil, fmt.Errorf("merger needs at least one source")
+	}
+	names := make(map[string]bool)
+	for i, src := range cfg.Sources {
+		if src.Name == "" || src.Source == nil {
+			return nil, fmt.Errorf("merger source %d must have a name and a source", i)
+		}
+		if names[src.Name] {
+			return nil, fmt.Errorf("duplicate merger source name: %s", src.Name)
+		}
+		names[src.Name] = true
+		if src.Cursors == nil {
+			cfg.Sources[i].Cursors = NewMemCursorStore()
+		}
+	}
+	if cfg.Window == 0 {
+		cfg.Window = time.Second
+	}
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = 1000
+	}
+	if cfg.CursorFlushInterval == 0 {
+		cfg.CursorFlushInterval = 5 * time.Second
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	return &Merger{
+		cfg:     cfg,
+		logger:  cfg.Logger.With("system", "merger"),
+		cursors: make(map[string]int64),
+	}, nil
+}
+
+// Cursors returns the last handled cursor of each source (-1 if none)
+func (m *Merger) Cursors() map[string]int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make(map[string]int64, len(m.cursors))
+	for k, v := range m.cursors {
+		out[k] = v
+	}
+	return out
+}
+
+// an event waiting to be ordered
+type mergeItem struct {
+	evt      *MergedEvent
+	received time.Time
+	// ordering key: the event time, but never earlier than the previous event from the same source, so that each source's order is kept
+	order time.Time
+	// arrival order, to break ties
+	n uint64
+}
+
+type mergeHeap []*mergeItem
+
+func (h mergeHeap) Len() int { return len(h) }
+func (h mergeHeap) Less(i, j int) bool {
+	if !h[i].order.Equal(h[j].order) {
+		return h[i].order.Before(h[j].order)
+	}
+	return h[i].n < h[j].n
+}
+func (h mergeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x any)   { *h = append(*h, x.(*mergeItem)) }
+func (h *mergeHeap) Pop() any {
+	old := *h
+	it := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return it
+}
+
+// Run consumes all sources until the context is cancelled (returning nil), or a source fails. Cursors are persisted before returning.
+func (m *Merger) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	in := make(chan *mergeItem, m.cfg.BufferSize)
+	errs := make(chan error, len(m.cfg.Sources))
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+	for _, src := range m.cfg.Sources {
+		cursor, err := src.Cursors.GetCursor(ctx)
+		if err != nil {
+			return fmt.Errorf("reading cursor for merge source %s: %w", src.Name, err)
+		}
+		m.mu.Lock()
+		m.cursors[src.Name] = cursor
+		m.mu.Unlock()
+
+		wg.Add(1)
+		go func(src MergerSource, cursor int64) {
+			defer wg.Done()
+			m.logger.Info("starting merge source", "source", src.Name, "cursor", cursor)
+			emit := func(evt *MergedEvent) error {
+				evt.Source = src.Name
+				if evt.Time.IsZero() {
+					evt.Time = time.Now()
+				}
+				select {
+				case in <- &mergeItem{evt: evt, received: time.Now()}:
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			err := src.Source.Run(ctx, cursor, emit)
+			if ctx.Err() != nil {
+				return
+			}
+			if err == nil {
+				err = errors.New("source ended")
+			}
+			errs <- fmt.Errorf("merge source %s: %w", src.Name, err)
+		}(src, cursor)
+	}
+
+	flushCtx, cancelFlush := context.WithCancel(ctx)
+	defer cancelFlush()
+	go m.flushCursorLoop(flushCtx)
+	defer func() {
+		// use a fresh context, since ctx is likely already cancelled
+		if err := m.flushCursors(context.Background()); err != nil {
+			m.logger.Error("failed to persist merge cursors", "err", err)
+		}
+	}()
+
+	var (
+		pending   mergeHeap
+		lastOrder = make(map[string]time.Time)
+		n         uint64
+	)
+	timer := time.NewTimer(m.cfg.Window)
+	defer timer.Stop()
+	for {
+		// hand off everything which has waited out the window
+		now := time.Now()
+		for pending.Len() > 0 && now.Sub(pending[0].received) >= m.cfg.Window {
+			it := heap.Pop(&pending).(*mergeItem)
+			m.handle(ctx, it.evt)
+			now = time.Now()
+		}
+		mergerBuffered.Set(float64(pending.Len() + len(in)))
+
+		wait := m.cfg.Window
+		if pending.Len() > 0 {
+			wait -= now.Sub(pending[0].received)
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errs:
+			return err
+		case it := <-in:
+			it.order = it.evt.Time
+			if last := lastOrder[it.evt.Source]; it.order.Before(last) {
+				it.order = last
+			}
+			lastOrder[it.evt.Source] = it.order
+			n++
+			it.n = n
+			heap.Push(&pending, it)
+		case <-timer.C:
+		}
+	}
+}
+
+func (m *Merger) handle(ctx context.Context, evt *MergedEvent) {
+	mergerEvents.WithLabelValues(evt.Source).Inc()
+	if err := m.cfg.Handler(ctx, evt); err != nil {
+		mergerHandlerErrors.WithLabelValues(evt.Source).Inc()
+		m.logger.Warn("failed to handle merged event", "source", evt.Source, "kind", evt.Kind, "cursor", evt.Cursor, "err", err)
+	}
+	if evt.Cursor >= 0 {
+		m.mu.Lock()
+		m.cursors[evt.Source] = evt.Cursor
+		m.mu.Unlock()
+	}
+}
+
+func (m *Merger) flushCursors(ctx context.Context) error {
+	cursors := m.Cursors()
+	var errs []error
+	for _, src := range m.cfg.Sources {
+		cursor, ok := cursors[src.Name]
+		if !ok || cursor < 0 {
+			continue
+		}
+		if err := src.Cursors.PutCursor(ctx, cursor); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", src.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m *Merger) flushCursorLoop(ctx context.Context) {
+	t := time.NewTicker(m.cfg.CursorFlushInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := m.flushCursors(ctx); err != nil {
+				m.logger.Error("failed to persist merge cursors", "err", err)
+			}
+		}
+	}
+}
+
+// StreamMergeSource is a repo (subscribeRepos) or label (subscribeLabels) event stream, consumed with a StreamClient
+type StreamMergeSource struct {
+	// MergeKindRepos or MergeKindLabels
+	Kind string
+	// Connection settings. Path defaults to the subscription endpoint for Kind; Handler, NewScheduler and Cursors are set by the source
+	Config StreamClientConfig
+}
+
+func (s *StreamMergeSource) Run(ctx context.Context, cursor int64, emit func(*MergedEvent) error) error {
+	cfg := s.Config
+	if cfg.Path == "" && s.Kind == MergeKindLabels {
+		cfg.Path = "/xrpc/com.atproto.label.subscribeLabels"
+	}
+	// the merger persists the cursor once events are handled; this only lets reconnects resume from the last event received
+	cfg.Cursors = NewMemCursorStore()
+	if err := cfg.Cursors.PutCursor(ctx, cursor); err != nil {
+		return err
+	}
+	cfg.NewScheduler = nil
+	cfg.Handler = func(ctx context.Context, evt *XRPCStreamEvent) error {
+		return emit(&MergedEvent{
+			Kind:   s.Kind,
+			Cursor: evt.Sequence(),
+			Time:   eventCreatedAt(evt),
+			Stream: evt,
+		})
+	}
+	sc, err := NewStreamClient(cfg)
+	if err != nil {
+		return err
+	}
+	return sc.Run(ctx)
+}
+
+// OzoneMergeSource polls an ozone moderation service's event log
+type OzoneMergeSource struct {
+	// Client for the ozone service, with moderator auth
+	Client *xrpc.Client
+	// How often to poll when there are no new events. Defaults to 5 seconds
+	PollInterval time.Duration
+	// Events fetched per request. Defaults to 50
+	Limit  int64
+	Logger *slog.Logger
+}
+
+// Events are fetched in creation order, from after the cursor (as a timestamp). Ozone timestamps have millisecond precision; as with the automod ozone consumer, events created in the same millisecond as the cursor are skipped.
+func (s *OzoneMergeSource) Run(ctx context.Context, cursor int64, emit func(*MergedEvent) error) error {
+	interval := s.PollInterval
+	if interval == 0 {
+		interval = 5 * time.Second
+	}
+	limit := s.Limit
+	if limit == 0 {
+		limit = 50
+	}
+	logger := s.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger = logger.With("system", "ozone-merge-source", "host", s.Client.Host)
+
+	since := time.Now()
+	if cursor >= 0 {
+		since = time.UnixMicro(cursor)
+	}
+	for {
+		resp, err := toolsozone.ModerationQueryEvents(ctx, s.Client,
+			nil, nil, nil, "", since.UTC().Format(syntax.AtprotoDatetimeLayout), "", "", "",
+			false, true, limit, nil, nil, nil, "asc", "", "", nil)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			logger.Warn("ozone query events failed, will retry", "err", err, "delay", interval)
+			resp = &toolsozone.ModerationQueryEvents_Output{}
+		}
+
+		fresh := 0
+		for _, evt := range resp.Events {
+			created, err := syntax.ParseDatetime(evt.CreatedAt)
+			if err != nil {
+				logger.Warn("skipping ozone event with invalid createdAt", "id", evt.Id, "createdAt", evt.CreatedAt)
+				continue
+			}
+			t := created.Time()
+			if !t.After(since) {
+				continue
+			}
+			fresh++
+			if err := emit(&MergedEvent{
+				Kind:   MergeKindOzone,
+				Cursor: t.UnixMicro(),
+				Time:   t,
+				Ozone:  evt,
+			}); err != nil {
+				return err
+			}
+			since = t
+		}
+		if fresh > 0 {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
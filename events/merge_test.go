@@ -0,0 +1,194 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// emits a fixed list of events (cursor i+1 for the i'th), then waits
+type sliceSource struct {
+	times []time.Time
+	// delay before emitting
+	delay time.Duration
+	// fails after emitting, if set
+	err error
+
+	mu    sync.Mutex
+	start int64
+}
+
+func (s *sliceSource) Run(ctx context.Context, cursor int64, emit func(*MergedEvent) error) error {
+	s.mu.Lock()
+	s.start = cursor
+	s.mu.Unlock()
+	time.Sleep(s.delay)
+	for i, t := range s.times {
+		if int64(i+1) <= cursor {
+			continue
+		}
+		if err := emit(&MergedEvent{Kind: MergeKindRepos, Cursor: int64(i + 1), Time: t}); err != nil {
+			return err
+		}
+	}
+	if s.err != nil {
+		return s.err
+	}
+	<-ctx.Done()
+	return nil
+}
+
+type collected struct {
+	mu   sync.Mutex
+	evts []*MergedEvent
+}
+
+func (c *collected) handle(ctx context.Context, evt *MergedEvent) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evts = append(c.evts, evt)
+	return nil
+}
+
+func (c *collected) waitFor(t *testing.T, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c.mu.Lock()
+		if len(c.evts) >= n {
+			var out []string
+			for _, evt := range c.evts {
+				out = append(out, evt.Source+evt.Time.Format("05"))
+			}
+			c.mu.Unlock()
+			return out
+		}
+		c.mu.Unlock()
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %d events", n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestMergerOrdering(t *testing.T) {
+	assert := assert.New(t)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(s int) time.Time { return base.Add(time.Duration(s) * time.Second) }
+
+	repos := &sliceSource{times: []time.Time{at(1), at(3), at(5)}}
+	// arrives later, but within the window
+	labels := &sliceSource{times: []time.Time{at(2), at(4)}, delay: 20 * time.Millisecond}
+	// out of order within the source: its order is kept
+	ozone := &sliceSource{times: []time.Time{at(6), at(0)}}
+	ozoneCursors := NewMemCursorStore()
+	var c collected
+	m, err := NewMerger(MergerConfig{
+		Sources: []MergerSource{
+			{Name: "r", Source: repos},
+			{Name: "l", Source: labels},
+			{Name: "o", Source: ozone, Cursors: ozoneCursors},
+		},
+		Handler: c.handle,
+		Window:  200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- m.Run(ctx) }()
+	assert.Equal([]string{"r01", "l02", "r03", "l04", "r05", "o06", "o00"}, c.waitFor(t, 7))
+	assert.Equal(map[string]int64{"r": 3, "l": 2, "o": 2}, m.Cursors())
+	cancel()
+	assert.NoError(<-done)
+
+	// cursors are persisted, and sources resume from them
+	cur, err := ozoneCursors.GetCursor(context.Background())
+	assert.NoError(err)
+	assert.Equal(int64(2), cur)
+	c = collected{}
+	m, err = NewMerger(MergerConfig{
+		Sources: []MergerSource{{Name: "o", Source: ozone, Cursors: ozoneCursors}},
+		Handler: c.handle,
+		Window:  10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	go func() { done <- m.Run(ctx) }()
+	time.Sleep(50 * time.Millisecond)
+	ozone.mu.Lock()
+	assert.Equal(int64(2), ozone.start)
+	ozone.mu.Unlock()
+	c.mu.Lock()
+	assert.Empty(c.evts)
+	c.mu.Unlock()
+}
+
+func TestMergerSourceFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	var c collected
+	m, err := NewMerger(MergerConfig{
+		Sources: []MergerSource{
+			{Name: "ok", Source: &sliceSource{}},
+			{Name: "bad", Source: &sliceSource{err: errors.New("boom")}},
+		},
+		Handler: c.handle,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Run(context.Background())
+	assert.ErrorContains(err, "merge source bad: boom")
+
+	_, err = NewMerger(MergerConfig{
+		Sources: []MergerSource{{Name: "a", Source: &sliceSource{}}, {Name: "a", Source: &sliceSource{}}},
+		Handler: c.handle,
+	})
+	assert.Error(err)
+}
+
+func TestStreamMergeSource(t *testing.T) {
+	assert := assert.New(t)
+
+	fs := &fakeStream{batches: [][]int64{{1, 2, 3}}}
+	srv := httptest.NewServer(fs)
+	defer srv.Close()
+
+	var c collected
+	m, err := NewMerger(MergerConfig{
+		Sources: []MergerSource{{Name: "relay", Source: &StreamMergeSource{
+			Kind:   MergeKindRepos,
+			Config: StreamClientConfig{Host: srv.URL, MinBackoff: time.Hour},
+		}}},
+		Handler: c.handle,
+		Window:  10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Run(ctx)
+
+	c.waitFor(t, 3)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, evt := range c.evts {
+		assert.Equal("relay", evt.Source)
+		assert.Equal(MergeKindRepos, evt.Kind)
+		assert.Equal(int64(i+1), evt.Cursor)
+		assert.NotNil(evt.Stream.RepoIdentity)
+		assert.False(evt.Time.IsZero())
+	}
+}
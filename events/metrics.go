@@ -24,3 +24,119 @@ var eventsBroadcast = promauto.NewCounterVec(prometheus.CounterOpts{
 	Name: "indigo_events_broadcast_total",
 	Help: "Total number of events broadcast to subscribers",
 }, []string{"pool"})
+
+var eventsIdempotentSkipped = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "indigo_events_idempotent_skipped_total",
+	Help: "Total number of events skipped because they were already handled",
+})
+
+var streamClientConnected = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "indigo_stream_client_connected",
+	Help: "Whether the stream client is currently connected to the upstream host",
+}, []string{"host"})
+
+var streamClientReconnects = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "indigo_stream_client_reconnects_total",
+	Help: "Total number of times the stream client has reconnected to the upstream host",
+}, []string{"host"})
+
+var streamClientStalls = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "indigo_stream_client_stalls_total",
+	Help: "Total number of connections dropped because no events arrived within the stall timeout",
+}, []string{"host"})
+
+var streamClientLastEvent = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "indigo_stream_client_last_event_timestamp_seconds",
+	Help: "Unix timestamp of the most recent event received by the stream client",
+}, []string{"host"})
+
+var streamClientLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "indigo_stream_client_lag_seconds",
+	Help: "Delay between the creation time of the most recent event and its receipt by the stream client",
+}, []string{"host"})
+
+var streamClientBackfills = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "indigo_stream_client_backfills_total",
+	Help: "Number of times the stream client replayed events from a backfill host because its cursor was older than the upstream retains, by result",
+}, []string{"host", "result"})
+
+var streamClientCursor = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "indigo_stream_client_cursor",
+	Help: "Last sequence number fully processed by the stream client",
+}, []string{"host"})
+
+var eventFramesSerialized = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "indigo_events_frames_serialized_total",
+	Help: "Total number of event frames serialized for broadcast, shared by all subscribers",
+})
+
+var eventFramesRecycled = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "indigo_events_frames_recycled_total",
+	Help: "Total number of broadcast event frames released by all subscribers and returned to the buffer pool",
+})
+
+var collectionOpsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "indigo_firehose_collection_ops_total",
+	Help: "Total number of repo ops seen on the firehose, by record collection and op type",
+}, []string{"collection", "action"})
+
+var hostOpsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "indigo_firehose_host_ops_total",
+	Help: "Total number of repo ops seen on the firehose, by PDS host and op type",
+}, []string{"host", "action"})
+
+var newCollectionsSeen = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "indigo_firehose_new_collections_total",
+	Help: "Total number of distinct record collections seen on the firehose since startup",
+})
+
+var cursorLeaseOps = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "indigo_cursor_lease_ops_total",
+	Help: "Total number of cursor lease operations, by operation and result",
+}, []string{"op", "result"})
+
+var eventAgeHistogram = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "indigo_event_age_seconds",
+	Help:    "Time between the creation of stream events and their processing, by consumer",
+	Buckets: []float64{0.1, 0.25, 0.5, 1, 2, 5, 10, 30, 60, 300, 900, 3600},
+}, []string{"consumer"})
+
+var eventAgePercentile = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "indigo_event_age_percentile_seconds",
+	Help: "Percentiles of stream event age over the last completed watchdog window, by consumer",
+}, []string{"consumer", "quantile"})
+
+var eventAgeBreached = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "indigo_event_age_sla_breached",
+	Help: "Whether stream event age currently exceeds the configured SLA, by consumer",
+}, []string{"consumer"})
+
+var eventAgeBreaches = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "indigo_event_age_sla_breaches_total",
+	Help: "Total number of times stream event age started exceeding the configured SLA, by consumer",
+}, []string{"consumer"})
+
+var mergerEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "indigo_merger_events_total",
+	Help: "Total number of events handled by a stream merger, by source",
+}, []string{"source"})
+
+var mergerHandlerErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "indigo_merger_handler_errors_total",
+	Help: "Total number of merged events the handler failed to process, by source",
+}, []string{"source"})
+
+var mergerBuffered = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "indigo_merger_buffered_events",
+	Help: "Number of events waiting in a stream merger to be ordered and handled",
+})
+
+var pebbleValueBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "indigo_pebble_persist_value_bytes_total",
+	Help: "Total bytes of events persisted with compression, before (raw) and after (stored) compressing",
+}, []string{"stage"})
+
+var slowSubscribersDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "indigo_events_slow_subscribers_dropped_total",
+	Help: "Total number of subscribers dropped for falling too far behind, by the limit exceeded (events or bytes)",
+}, []string{"limit"})
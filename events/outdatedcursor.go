@@ -0,0 +1,55 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+)
+
+// InfoOutdatedCursor is the name of the #info frame sent when a subscriber's cursor is older than the events a server retains
+const InfoOutdatedCursor = "OutdatedCursor"
+
+// OutdatedCursorInfo is the machine-readable detail of an OutdatedCursor #info frame, carried as JSON in the frame's message. After the frame, the stream continues from EarliestCursor, so events between Cursor and EarliestCursor are missed unless they are fetched from Backfill.
+type OutdatedCursorInfo struct {
+	// the cursor the subscriber asked for
+	Cursor int64 `json:"cursor"`
+	// the oldest cursor the server can resume from without skipping events
+	EarliestCursor int64 `json:"earliestCursor"`
+	// base URL of a service (eg, a relay) with longer retention, which uses the same sequence numbers. Optional
+	Backfill string `json:"backfill,omitempty"`
+}
+
+// NewOutdatedCursorEvent returns an OutdatedCursor #info event carrying info
+func NewOutdatedCursorEvent(info OutdatedCursorInfo) *XRPCStreamEvent {
+	b, _ := json.Marshal(info)
+	msg := string(b)
+	return &XRPCStreamEvent{RepoInfo: &comatproto.SyncSubscribeRepos_Info{
+		Name:    InfoOutdatedCursor,
+		Message: &msg,
+	}}
+}
+
+// ParseOutdatedCursor returns the detail of an OutdatedCursor #info frame. ok is false for other frames; servers which don't send details (a plain text message, or none) give a nil info with ok true
+func ParseOutdatedCursor(evt *comatproto.SyncSubscribeRepos_Info) (info *OutdatedCursorInfo, ok bool) {
+	if evt == nil || evt.Name != InfoOutdatedCursor {
+		return nil, false
+	}
+	if evt.Message == nil {
+		return nil, true
+	}
+	var oci OutdatedCursorInfo
+	if err := json.Unmarshal([]byte(*evt.Message), &oci); err != nil {
+		return nil, true
+	}
+	return &oci, true
+}
+
+// returned by the scheduler to hand a connection off to a backfill host
+type outdatedCursorError struct {
+	info *OutdatedCursorInfo
+}
+
+func (e *outdatedCursorError) Error() string {
+	return fmt.Sprintf("cursor %d is older than upstream retention (earliest %d); backfilling from %s", e.info.Cursor, e.info.EarliestCursor, e.info.Backfill)
+}
@@ -0,0 +1,145 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression codecs for PebblePersist values
+const (
+	PebbleCompressionNone   = ""
+	PebbleCompressionSnappy = "snappy"
+	PebbleCompressionZstd   = "zstd"
+)
+
+// Compressed values start with this byte, followed by a codec byte.
+// Uncompressed values are a serialized event, which starts with a CBOR map
+// header (0xa0-0xbf), so both can be read from the same database: the
+// codec can be changed (or compression turned on) without migrating.
+const compressedValueMarker = 0x00
+
+const (
+	codecSnappy byte = 1
+	codecZstd   byte = 2
+)
+
+// ErrUnknownCompression is returned when reading a value compressed with a
+// codec this version doesn't support
+var ErrUnknownCompression = errors.New("unknown pebble value compression")
+
+// valueCodec compresses and decompresses PebblePersist values
+type valueCodec struct {
+	codec byte
+	enc   *zstd.Encoder
+	// decodes values compressed with any dictionary (or none) it was
+	// created with, whatever the current codec
+	dec *zstd.Decoder
+}
+
+func newValueCodec(opts *PebblePersistOptions) (*valueCodec, error) {
+	vc := &valueCodec{}
+	var decOpts []zstd.DOption
+	if opts.ZstdDictionary != nil {
+		decOpts = append(decOpts, zstd.WithDecoderDicts(opts.ZstdDictionary))
+	}
+	dec, err := zstd.NewReader(nil, decOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid zstd dictionary: %w", err)
+	}
+	vc.dec = dec
+
+	switch opts.Compression {
+	case PebbleCompressionNone:
+	case PebbleCompressionSnappy:
+		vc.codec = codecSnappy
+	case PebbleCompressionZstd:
+		vc.codec = codecZstd
+		encOpts := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+		if opts.ZstdDictionary != nil {
+			encOpts = append(encOpts, zstd.WithEncoderDict(opts.ZstdDictionary))
+		}
+		enc, err := zstd.NewWriter(nil, encOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("invalid zstd dictionary: %w", err)
+		}
+		vc.enc = enc
+	default:
+		return nil, fmt.Errorf("unknown pebble compression: %q", opts.Compression)
+	}
+	return vc, nil
+}
+
+// encode returns the value to store for a serialized event
+func (vc *valueCodec) encode(blob []byte) []byte {
+	var out []byte
+	switch vc.codec {
+	case codecSnappy:
+		out = append([]byte{compressedValueMarker, codecSnappy}, s2.EncodeSnappy(nil, blob)...)
+	case codecZstd:
+		out = vc.enc.EncodeAll(blob, []byte{compressedValueMarker, codecZstd})
+	default:
+		return blob
+	}
+	pebbleValueBytes.WithLabelValues("raw").Add(float64(len(blob)))
+	pebbleValueBytes.WithLabelValues("stored").Add(float64(len(out)))
+	return out
+}
+
+// decode returns the serialized event for a stored value, which may be
+// shared with the caller's buffer if it wasn't compressed
+func (vc *valueCodec) decode(val []byte) ([]byte, error) {
+	if len(val) == 0 || val[0] != compressedValueMarker {
+		return val, nil
+	}
+	if len(val) < 2 {
+		return nil, fmt.Errorf("truncated compressed value")
+	}
+	switch val[1] {
+	case codecSnappy:
+		return s2.Decode(nil, val[2:])
+	case codecZstd:
+		return vc.dec.DecodeAll(val[2:], nil)
+	default:
+		return nil, fmt.Errorf("%w: codec %d", ErrUnknownCompression, val[1])
+	}
+}
+
+// TrainZstdDictionary builds a zstd dictionary (of at most maxSize bytes)
+// from a sample of up to samples recently persisted events, for use as
+// PebblePersistOptions.ZstdDictionary. Dictionaries trained on a few
+// thousand events typically compress individual events much better than
+// zstd alone, since each event is compressed separately.
+func (pp *PebblePersist) TrainZstdDictionary(ctx context.Context, samples, maxSize int) ([]byte, error) {
+	iter, err := pp.db.NewIterWithContext(ctx, &pebble.IterOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	var input [][]byte
+	for iter.Last(); iter.Valid() && len(input) < samples; iter.Prev() {
+		val, err := iter.ValueAndErr()
+		if err != nil {
+			return nil, err
+		}
+		blob, err := pp.codec.decode(val)
+		if err != nil {
+			return nil, err
+		}
+		input = append(input, append([]byte(nil), blob...))
+	}
+	if len(input) == 0 {
+		return nil, ErrNoLast
+	}
+	return dict.BuildZstdDict(input, dict.Options{
+		MaxDictSize: maxSize,
+		HashBytes:   6,
+		ZstdLevel:   zstd.SpeedDefault,
+	})
+}
@@ -23,6 +23,11 @@ type PebblePersist struct {
 	cancel func()
 
 	options PebblePersistOptions
+	codec   *valueCodec
+
+	// per retention tier (see RetentionByType), the last key GC has
+	// checked
+	gcScanned map[time.Duration][]byte
 }
 
 type PebblePersistOptions struct {
@@ -32,11 +37,27 @@ type PebblePersistOptions struct {
 	// Throw away posts older than some time ago
 	PersistDuration time.Duration
 
+	// RetentionByType keeps events of some frame types (eg, "#identity")
+	// for a different time than PersistDuration, which applies to the
+	// rest. Eg, the small identity and account events (IdentityEventTypes)
+	// can be kept much longer than commits. See CompleteFrom for replay
+	// when the windows differ.
+	RetentionByType map[string]time.Duration
+
 	// Throw away old posts every so often
 	GCPeriod time.Duration
 
 	// MaxBytes is what we _try_ to keep disk usage under
 	MaxBytes uint64
+
+	// Compression is applied to newly persisted events: "" (none), "snappy"
+	// or "zstd". Events persisted with any codec can always be read back.
+	Compression string
+
+	// ZstdDictionary is a zstd dictionary (see TrainZstdDictionary) to
+	// compress events against, with "zstd" compression. It must be kept, and
+	// given here, for as long as events compressed with it are retained.
+	ZstdDictionary []byte
 }
 
 var DefaultPebblePersistOptions = PebblePersistOptions{
@@ -51,6 +72,10 @@ func NewPebblePersistance(opts *PebblePersistOptions) (*PebblePersist, error) {
 	if opts == nil {
 		opts = &DefaultPebblePersistOptions
 	}
+	codec, err := newValueCodec(opts)
+	if err != nil {
+		return nil, err
+	}
 	db, err := pebble.Open(opts.DbPath, &pebble.Options{})
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", opts.DbPath, err)
@@ -58,6 +83,8 @@ func NewPebblePersistance(opts *PebblePersistOptions) (*PebblePersist, error) {
 	pp := new(PebblePersist)
 	pp.options = *opts
 	pp.db = db
+	pp.codec = codec
+	pp.gcScanned = make(map[time.Duration][]byte)
 	return pp, nil
 }
 
@@ -71,7 +98,7 @@ func (pp *PebblePersist) Persist(ctx context.Context, e *XRPCStreamEvent) error
 	if err != nil {
 		return err
 	}
-	blob := e.Preserialized
+	blob := pp.codec.encode(e.Preserialized)
 
 	seq := e.Sequence()
 	nowMillis := time.Now().UnixMilli()
@@ -101,8 +128,12 @@ func (pp *PebblePersist) Persist(ctx context.Context, e *XRPCStreamEvent) error
 	return err
 }
 
-func eventFromPebbleIter(iter *pebble.Iterator) (*XRPCStreamEvent, error) {
-	blob, err := iter.ValueAndErr()
+func (pp *PebblePersist) eventFromIter(iter *pebble.Iterator) (*XRPCStreamEvent, error) {
+	val, err := iter.ValueAndErr()
+	if err != nil {
+		return nil, err
+	}
+	blob, err := pp.codec.decode(val)
 	if err != nil {
 		return nil, err
 	}
@@ -112,7 +143,11 @@ func eventFromPebbleIter(iter *pebble.Iterator) (*XRPCStreamEvent, error) {
 	if err != nil {
 		return nil, err
 	}
-	evt.Preserialized = bytes.Clone(blob)
+	if len(val) > 0 && val[0] != compressedValueMarker {
+		// the iterator's value is only valid until it moves
+		blob = bytes.Clone(blob)
+	}
+	evt.Preserialized = blob
 	return evt, nil
 }
 
@@ -127,7 +162,7 @@ func (pp *PebblePersist) Playback(ctx context.Context, since int64, cb func(*XRP
 	defer iter.Close()
 
 	for iter.First(); iter.Valid(); iter.Next() {
-		evt, err := eventFromPebbleIter(iter)
+		evt, err := pp.eventFromIter(iter)
 		if err != nil {
 			return err
 		}
@@ -167,17 +202,38 @@ func (pp *PebblePersist) GetLast(ctx context.Context) (seq, millis int64, evt *X
 	if err != nil {
 		return 0, 0, nil, err
 	}
+	defer iter.Close()
 	ok := iter.Last()
 	if !ok {
 		return 0, 0, nil, ErrNoLast
 	}
-	evt, err = eventFromPebbleIter(iter)
+	evt, err = pp.eventFromIter(iter)
 	keyblob := iter.Key()
 	seq = int64(binary.BigEndian.Uint64(keyblob[:8]))
 	millis = int64(binary.BigEndian.Uint64(keyblob[8:16]))
 	return seq, millis, evt, nil
 }
 
+// GetFirst returns the sequence number, and persisted time (unix millis), of the oldest retained event, or ErrNoLast if there are none
+func (pp *PebblePersist) GetFirst(ctx context.Context) (seq, millis int64, err error) {
+	iter, err := pp.db.NewIterWithContext(ctx, &pebble.IterOptions{})
+	if err != nil {
+		return 0, 0, err
+	}
+	defer iter.Close()
+	// events without a sequence number have keys with the top bit set, so sort last
+	if !iter.First() || iter.Key()[0]&0x80 != 0 {
+		return 0, 0, ErrNoLast
+	}
+	keyblob := iter.Key()
+	return int64(binary.BigEndian.Uint64(keyblob[:8])), int64(binary.BigEndian.Uint64(keyblob[8:16])), nil
+}
+
+// DiskUsage estimates the disk space taken by retained events
+func (pp *PebblePersist) DiskUsage() (uint64, error) {
+	return pp.db.EstimateDiskUsage(zeroKey[:], ffffKey[:])
+}
+
 // example;
 // ```
 // pp := NewPebblePersistance("/tmp/foo.pebble")
@@ -213,7 +269,11 @@ func init() {
 
 func (pp *PebblePersist) GarbageCollect(ctx context.Context) error {
 	nowMillis := time.Now().UnixMilli()
-	expired := nowMillis - pp.options.PersistDuration.Milliseconds()
+	if err := pp.gcByType(ctx, nowMillis); err != nil {
+		return err
+	}
+	// everything past the longest retention period goes
+	expired := nowMillis - pp.longestRetention().Milliseconds()
 	iter, err := pp.db.NewIterWithContext(ctx, &pebble.IterOptions{})
 	if err != nil {
 		return err
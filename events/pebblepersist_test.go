@@ -1,9 +1,18 @@
 package events
 
 import (
-	"gorm.io/gorm"
+	"bytes"
+	"context"
+	"fmt"
 	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+
+	"github.com/ipfs/go-cid"
+	"gorm.io/gorm"
 )
 
 func TestPebblePersist(t *testing.T) {
@@ -14,3 +23,278 @@ func TestPebblePersist(t *testing.T) {
 	}
 	testPersister(t, factory)
 }
+
+func TestPebblePersistCompressed(t *testing.T) {
+	for _, compression := range []string{PebbleCompressionSnappy, PebbleCompressionZstd} {
+		t.Run(compression, func(t *testing.T) {
+			factory := func(tempPath string, db *gorm.DB) (EventPersistence, error) {
+				opts := DefaultPebblePersistOptions
+				opts.DbPath = filepath.Join(tempPath, "pebble.db")
+				opts.Compression = compression
+				return NewPebblePersistance(&opts)
+			}
+			testPersister(t, factory)
+		})
+	}
+}
+
+func TestPebbleCompressionChanges(t *testing.T) {
+	ctx := context.Background()
+	dbPath := filepath.Join(t.TempDir(), "pebble.db")
+
+	c, err := cid.Decode("bafyreidfayvfuwqa7qlnopdjiqrxzs6blmoeu4rujcjtnci5beludirz2a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := lexutil.LexLink(c)
+
+	// persist a batch of events with each codec, reopening the database in
+	// between, as when compression is turned on for an existing cache
+	var dictionary []byte
+	seq := int64(0)
+	for _, compression := range []string{PebbleCompressionNone, PebbleCompressionSnappy, PebbleCompressionZstd, "zstd+dict"} {
+		opts := DefaultPebblePersistOptions
+		opts.DbPath = dbPath
+		opts.Compression = compression
+		if compression == "zstd+dict" {
+			opts.Compression = PebbleCompressionZstd
+			opts.ZstdDictionary = dictionary
+		}
+		pp, err := NewPebblePersistance(&opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pp.SetEventBroadcaster(func(*XRPCStreamEvent) {})
+		for i := 0; i < 100; i++ {
+			seq++
+			evt := &XRPCStreamEvent{
+				RepoCommit: &atproto.SyncSubscribeRepos_Commit{
+					Repo:   "did:example:alice",
+					Seq:    seq,
+					Commit: link,
+					Rev:    fmt.Sprintf("rev%d", seq),
+					Ops: []*atproto.SyncSubscribeRepos_RepoOp{
+						{Action: "create", Path: fmt.Sprintf("app.bsky.feed.post/%d", seq), Cid: &link},
+					},
+				},
+			}
+			if err := pp.Persist(ctx, evt); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if compression == PebbleCompressionZstd {
+			dictionary, err = pp.TrainZstdDictionary(ctx, 1000, 4<<10)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := pp.Shutdown(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// everything reads back, whatever it was written with, as long as the
+	// dictionary is given
+	opts := DefaultPebblePersistOptions
+	opts.DbPath = dbPath
+	opts.ZstdDictionary = dictionary
+	pp, err := NewPebblePersistance(&opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pp.Shutdown(ctx)
+	var n int64
+	err = pp.Playback(ctx, 0, func(evt *XRPCStreamEvent) error {
+		n++
+		if evt.RepoCommit == nil || evt.RepoCommit.Seq != n || evt.RepoCommit.Rev != fmt.Sprintf("rev%d", n) {
+			return fmt.Errorf("unexpected event %d: %+v", n, evt.RepoCommit)
+		}
+		var roundtrip XRPCStreamEvent
+		return roundtrip.Deserialize(bytes.NewReader(evt.Preserialized))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != seq {
+		t.Fatalf("expected %d events, got %d", seq, n)
+	}
+}
+
+func TestPebbleWindowStats(t *testing.T) {
+	ctx := context.Background()
+	opts := DefaultPebblePersistOptions
+	opts.DbPath = filepath.Join(t.TempDir(), "pebble.db")
+	pp, err := NewPebblePersistance(&opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pp.Shutdown(ctx)
+	pp.SetEventBroadcaster(func(*XRPCStreamEvent) {})
+
+	c, err := cid.Decode("bafyreidfayvfuwqa7qlnopdjiqrxzs6blmoeu4rujcjtnci5beludirz2a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := lexutil.LexLink(c)
+
+	for i := int64(1); i <= 10; i++ {
+		evt := &XRPCStreamEvent{
+			RepoCommit: &atproto.SyncSubscribeRepos_Commit{
+				Repo:   "did:example:alice",
+				Seq:    i,
+				Commit: link,
+				Ops: []*atproto.SyncSubscribeRepos_RepoOp{
+					{Action: "create", Path: "app.bsky.feed.post/abc", Cid: &link},
+					{Action: "delete", Path: "app.bsky.feed.like/def"},
+				},
+			},
+		}
+		if i%5 == 0 {
+			evt = &XRPCStreamEvent{
+				RepoIdentity: &atproto.SyncSubscribeRepos_Identity{Did: "did:example:bob", Seq: i},
+			}
+		}
+		if err := pp.Persist(ctx, evt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := pp.WindowStats(ctx, EventWindow{SinceSeq: 2, UntilSeq: 9, TopRepos: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Total.Events != 8 || stats.FirstSeq != 2 || stats.LastSeq != 9 {
+		t.Fatalf("unexpected window: %+v", stats)
+	}
+	if stats.Types["#commit"].Events != 7 || stats.Types["#identity"].Events != 1 {
+		t.Fatalf("unexpected type counts: %+v", stats.Types)
+	}
+	if stats.Collections["app.bsky.feed.post"] != 7 || stats.Actions["delete"] != 7 {
+		t.Fatalf("unexpected op counts: %+v %+v", stats.Collections, stats.Actions)
+	}
+	if stats.Repos != 2 || len(stats.TopRepos) != 1 || stats.TopRepos[0].DID != "did:example:alice" {
+		t.Fatalf("unexpected repo counts: %+v", stats.TopRepos)
+	}
+
+	stats, err = pp.WindowStats(ctx, EventWindow{Since: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Total.Events != 0 {
+		t.Fatalf("expected empty window: %+v", stats)
+	}
+}
+
+func TestPebbleFirstLast(t *testing.T) {
+	ctx := context.Background()
+	opts := DefaultPebblePersistOptions
+	opts.DbPath = filepath.Join(t.TempDir(), "pebble.db")
+	pp, err := NewPebblePersistance(&opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pp.Shutdown(ctx)
+	pp.SetEventBroadcaster(func(*XRPCStreamEvent) {})
+
+	if _, _, err := pp.GetFirst(ctx); err != ErrNoLast {
+		t.Fatalf("expected ErrNoLast from empty cache, got %v", err)
+	}
+
+	start := time.Now()
+	for seq := int64(5); seq <= 9; seq++ {
+		if err := pp.Persist(ctx, &XRPCStreamEvent{RepoIdentity: &atproto.SyncSubscribeRepos_Identity{Did: "did:example:alice", Seq: seq}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first, firstMillis, err := pp.GetFirst(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last, lastMillis, _, err := pp.GetLast(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != 5 || last != 9 {
+		t.Fatalf("expected window 5-9, got %d-%d", first, last)
+	}
+	if firstMillis < start.UnixMilli() || lastMillis < firstMillis {
+		t.Fatalf("unexpected persisted times %d, %d (started at %d)", firstMillis, lastMillis, start.UnixMilli())
+	}
+	if _, err := pp.DiskUsage(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPebbleRetentionByType(t *testing.T) {
+	ctx := context.Background()
+	c, err := cid.Decode("bafyreidfayvfuwqa7qlnopdjiqrxzs6blmoeu4rujcjtnci5beludirz2a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, compression := range []string{PebbleCompressionNone, PebbleCompressionZstd} {
+		t.Run("compression="+compression, func(t *testing.T) {
+			opts := DefaultPebblePersistOptions
+			opts.DbPath = filepath.Join(t.TempDir(), "pebble.db")
+			opts.Compression = compression
+			opts.PersistDuration = time.Second
+			opts.RetentionByType = map[string]time.Duration{"#identity": time.Hour}
+			pp, err := NewPebblePersistance(&opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer pp.Shutdown(ctx)
+			pp.SetEventBroadcaster(func(*XRPCStreamEvent) {})
+
+			seq := int64(0)
+			persist := func(n int) {
+				for i := 0; i < n; i++ {
+					seq++
+					evt := &XRPCStreamEvent{RepoCommit: &atproto.SyncSubscribeRepos_Commit{Repo: "did:example:alice", Seq: seq, Commit: lexutil.LexLink(c), Rev: fmt.Sprintf("rev%d", seq)}}
+					if seq%2 == 0 {
+						evt = &XRPCStreamEvent{RepoIdentity: &atproto.SyncSubscribeRepos_Identity{Did: "did:example:alice", Seq: seq}}
+					}
+					if err := pp.Persist(ctx, evt); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			// 1-10 expire, except the identity events; 11-14 are retained
+			persist(10)
+			time.Sleep(1200 * time.Millisecond)
+			persist(4)
+			if err := pp.GarbageCollect(ctx); err != nil {
+				t.Fatal(err)
+			}
+			// a second pass has nothing more to do
+			if err := pp.GarbageCollect(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			var seqs []int64
+			if err := pp.Playback(ctx, 0, func(evt *XRPCStreamEvent) error {
+				seqs = append(seqs, evt.Sequence())
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(seqs) != fmt.Sprint([]int64{2, 4, 6, 8, 10, 11, 12, 13, 14}) {
+				t.Fatalf("unexpected events after gc: %v", seqs)
+			}
+
+			first, _, err := pp.GetFirst(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			complete, err := pp.CompleteFrom(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if first != 2 || complete != 11 {
+				t.Fatalf("expected oldest event 2 and complete window from 11, got %d and %d", first, complete)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"sort"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// IdentityEventTypes are the frame types of the (small) identity and account
+// events, which PebblePersistOptions.RetentionByType can keep for longer than
+// commits
+var IdentityEventTypes = []string{"#identity", "#account", "#handle", "#tombstone", "#migrate"}
+
+// retention returns how long events of a frame type are kept
+func (pp *PebblePersist) retention(msgType string) time.Duration {
+	if d, ok := pp.options.RetentionByType[msgType]; ok {
+		return d
+	}
+	return pp.options.PersistDuration
+}
+
+// retentionTiers returns the distinct retention periods, shortest first
+func (pp *PebblePersist) retentionTiers() []time.Duration {
+	seen := map[time.Duration]bool{pp.options.PersistDuration: true}
+	tiers := []time.Duration{pp.options.PersistDuration}
+	for _, d := range pp.options.RetentionByType {
+		if !seen[d] {
+			seen[d] = true
+			tiers = append(tiers, d)
+		}
+	}
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i] < tiers[j] })
+	return tiers
+}
+
+// longestRetention is how long any event is kept
+func (pp *PebblePersist) longestRetention() time.Duration {
+	tiers := pp.retentionTiers()
+	return tiers[len(tiers)-1]
+}
+
+// valueType returns the frame type (eg, "#commit") of a stored event
+func (pp *PebblePersist) valueType(val []byte) (string, error) {
+	blob, err := pp.codec.decode(val)
+	if err != nil {
+		return "", err
+	}
+	var hdr EventHeader
+	if err := hdr.UnmarshalCBOR(bytes.NewReader(blob)); err != nil {
+		return "", err
+	}
+	return hdr.MsgType, nil
+}
+
+// gcByType deletes expired events whose type is kept for less than the
+// longest retention period, which GarbageCollect's range deletion won't have
+// reached. Each tier scans from where it left off, so each event is only
+// checked once per tier.
+func (pp *PebblePersist) gcByType(ctx context.Context, nowMillis int64) error {
+	tiers := pp.retentionTiers()
+	// the longest tier is handled by the range deletion
+	for _, d := range tiers[:len(tiers)-1] {
+		cutoff := nowMillis - d.Milliseconds()
+		deleted, err := pp.gcTier(ctx, d, cutoff)
+		if err != nil {
+			return err
+		}
+		if deleted > 0 {
+			log.Info("pebble gc by type", "retention", d, "deleted", deleted)
+		}
+	}
+	return nil
+}
+
+func (pp *PebblePersist) gcTier(ctx context.Context, d time.Duration, cutoff int64) (int, error) {
+	iter, err := pp.db.NewIterWithContext(ctx, &pebble.IterOptions{LowerBound: pp.gcScanned[d]})
+	if err != nil {
+		return 0, err
+	}
+	defer iter.Close()
+
+	batch := pp.db.NewBatch()
+	defer batch.Close()
+	deleted := 0
+	var last []byte
+	for iter.First(); iter.Valid(); iter.Next() {
+		key := iter.Key()
+		if int64(binary.BigEndian.Uint64(key[8:16])) > cutoff {
+			break
+		}
+		last = append(last[:0], key...)
+		val, err := iter.ValueAndErr()
+		if err != nil {
+			return 0, err
+		}
+		msgType, err := pp.valueType(val)
+		if err != nil {
+			// keep what we can't read for the default period
+			log.Warn("pebble gc can't read event type", "key", key, "err", err)
+			msgType = ""
+		}
+		if pp.retention(msgType) <= d {
+			if err := batch.Delete(key, nil); err != nil {
+				return 0, err
+			}
+			deleted++
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return 0, err
+	}
+	if deleted > 0 {
+		if err := batch.Commit(pebble.Sync); err != nil {
+			return 0, err
+		}
+	}
+	if last != nil {
+		pp.gcScanned[d] = last
+	}
+	return deleted, nil
+}
+
+// CompleteFrom returns the sequence number of the oldest event from which
+// every event is retained, or ErrNoLast if there are none. With
+// RetentionByType, there may be older events of the types kept longest;
+// playback from an older cursor sends those, without the events in between.
+func (pp *PebblePersist) CompleteFrom(ctx context.Context) (int64, error) {
+	first, _, err := pp.GetFirst(ctx)
+	if err != nil {
+		return 0, err
+	}
+	tiers := pp.retentionTiers()
+	if len(tiers) == 1 {
+		return first, nil
+	}
+	// events of every type are kept for at least the shortest period (and
+	// GC has never deleted anything newer), so the complete window starts
+	// at the first event persisted since then. Persisted times increase
+	// with sequence numbers, so binary search for it.
+	cutoff := time.Now().Add(-tiers[0]).UnixMilli()
+	last, _, _, err := pp.GetLast(ctx)
+	if err != nil {
+		return 0, err
+	}
+	iter, err := pp.db.NewIterWithContext(ctx, &pebble.IterOptions{})
+	if err != nil {
+		return 0, err
+	}
+	defer iter.Close()
+
+	var key [8]byte
+	var found int64 = -1
+	lo, hi := first, last
+	for lo <= hi {
+		mid := lo + (hi-lo)/2
+		binary.BigEndian.PutUint64(key[:], uint64(mid))
+		if !iter.SeekGE(key[:]) || iter.Key()[0]&0x80 != 0 {
+			hi = mid - 1
+			continue
+		}
+		seq := int64(binary.BigEndian.Uint64(iter.Key()[:8]))
+		if int64(binary.BigEndian.Uint64(iter.Key()[8:16])) > cutoff {
+			found = seq
+			hi = mid - 1
+		} else {
+			lo = seq + 1
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return 0, err
+	}
+	if found < 0 {
+		// nothing persisted since the cutoff: only events which are
+		// complete from the next one on
+		return last + 1, nil
+	}
+	return found, nil
+}
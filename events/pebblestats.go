@@ -0,0 +1,251 @@
+package events
+
+import (
+	"context"
+	"encoding/binary"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// EventWindow selects a range of persisted events, by sequence number and/or
+// by the time events were persisted. Zero values are unbounded; all bounds are
+// inclusive.
+type EventWindow struct {
+	SinceSeq int64
+	UntilSeq int64
+	Since    time.Time
+	Until    time.Time
+
+	// Number of most active repos (by event count) to include. Zero to omit.
+	TopRepos int
+}
+
+// EventCount is a count of events and their total serialized size
+type EventCount struct {
+	Events int64 `json:"events"`
+	Bytes  int64 `json:"bytes"`
+}
+
+// RepoEventCount is the number of events for a single repo
+type RepoEventCount struct {
+	DID    string `json:"did"`
+	Events int64  `json:"events"`
+}
+
+// EventWindowStats summarizes the persisted events in an EventWindow
+type EventWindowStats struct {
+	// FirstSeq and LastSeq are zero if the window contained no events
+	FirstSeq  int64     `json:"firstSeq"`
+	LastSeq   int64     `json:"lastSeq"`
+	FirstTime time.Time `json:"firstTime"`
+	LastTime  time.Time `json:"lastTime"`
+
+	Total EventCount `json:"total"`
+	// Events which aren't associated with a sequence number (eg, #info)
+	Unsequenced int64 `json:"unsequenced"`
+	// Keyed by message type, eg "#commit" or "#identity"
+	Types map[string]*EventCount `json:"types"`
+	// Number of record operations in commits, keyed by collection NSID
+	Collections map[string]int64 `json:"collections"`
+	// Number of record operations in commits, keyed by action (create, update, delete)
+	Actions map[string]int64 `json:"actions"`
+	// Number of distinct repos with at least one event in the window
+	Repos    int              `json:"repos"`
+	TopRepos []RepoEventCount `json:"topRepos,omitempty"`
+
+	// Average event rate over the window, based on persisted timestamps
+	EventsPerSecond float64 `json:"eventsPerSecond"`
+	BytesPerSecond  float64 `json:"bytesPerSecond"`
+}
+
+// message type of an event, as it would be sent in the stream frame header
+func eventMsgType(evt *XRPCStreamEvent) string {
+	switch {
+	case evt.Error != nil:
+		return "error"
+	case evt.RepoCommit != nil:
+		return "#commit"
+	case evt.RepoHandle != nil:
+		return "#handle"
+	case evt.RepoIdentity != nil:
+		return "#identity"
+	case evt.RepoAccount != nil:
+		return "#account"
+	case evt.RepoSync != nil:
+		return "#sync"
+	case evt.RepoInfo != nil:
+		return "#info"
+	case evt.RepoMigrate != nil:
+		return "#migrate"
+	case evt.RepoTombstone != nil:
+		return "#tombstone"
+	case evt.LabelLabels != nil:
+		return "#labels"
+	default:
+		return "unknown"
+	}
+}
+
+// Repo returns the DID of the repo (account) an event is about, or an empty
+// string for events which aren't about a single repo
+func (evt *XRPCStreamEvent) Repo() string {
+	switch {
+	case evt.RepoCommit != nil:
+		return evt.RepoCommit.Repo
+	case evt.RepoHandle != nil:
+		return evt.RepoHandle.Did
+	case evt.RepoIdentity != nil:
+		return evt.RepoIdentity.Did
+	case evt.RepoAccount != nil:
+		return evt.RepoAccount.Did
+	case evt.RepoSync != nil:
+		return evt.RepoSync.Did
+	case evt.RepoMigrate != nil:
+		return evt.RepoMigrate.Did
+	case evt.RepoTombstone != nil:
+		return evt.RepoTombstone.Did
+	default:
+		return ""
+	}
+}
+
+// WindowStats scans the persisted events in a window and returns summary
+// statistics. Every event in the window is read and decoded, so large windows
+// can be expensive.
+//
+// Persisted timestamps are when this instance stored the event, not when the
+// event was created upstream. Because timestamps are only approximately
+// ordered by sequence number, the scan stops at the first event persisted
+// after w.Until.
+func (pp *PebblePersist) WindowStats(ctx context.Context, w EventWindow) (*EventWindowStats, error) {
+	opts := &pebble.IterOptions{}
+	if w.SinceSeq > 0 {
+		var key [8]byte
+		binary.BigEndian.PutUint64(key[:], uint64(w.SinceSeq))
+		opts.LowerBound = key[:]
+	}
+	if w.UntilSeq > 0 {
+		var key [8]byte
+		binary.BigEndian.PutUint64(key[:], uint64(w.UntilSeq+1))
+		opts.UpperBound = key[:]
+	}
+	iter, err := pp.db.NewIterWithContext(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	var sinceMillis, untilMillis int64
+	if !w.Since.IsZero() {
+		sinceMillis = w.Since.UnixMilli()
+	}
+	if !w.Until.IsZero() {
+		untilMillis = w.Until.UnixMilli()
+	}
+
+	stats := &EventWindowStats{
+		Types:       make(map[string]*EventCount),
+		Collections: make(map[string]int64),
+		Actions:     make(map[string]int64),
+	}
+	repos := make(map[string]int64)
+	var firstMillis, lastMillis int64
+
+	var n int
+	for iter.First(); iter.Valid(); iter.Next() {
+		n++
+		if n%10_000 == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+
+		keyblob := iter.Key()
+		seq := int64(binary.BigEndian.Uint64(keyblob[:8]))
+		millis := int64(binary.BigEndian.Uint64(keyblob[8:16]))
+		if untilMillis > 0 && millis > untilMillis {
+			break
+		}
+		if millis < sinceMillis {
+			continue
+		}
+
+		evt, err := pp.eventFromIter(iter)
+		if err != nil {
+			return nil, err
+		}
+		size := int64(len(evt.Preserialized))
+
+		if firstMillis == 0 {
+			firstMillis = millis
+		}
+		lastMillis = millis
+
+		stats.Total.Events++
+		stats.Total.Bytes += size
+		typ := eventMsgType(evt)
+		tc, ok := stats.Types[typ]
+		if !ok {
+			tc = &EventCount{}
+			stats.Types[typ] = tc
+		}
+		tc.Events++
+		tc.Bytes += size
+
+		if evt.Sequence() < 0 {
+			// unsequenced events are keyed with sequence number -1, so sort after all others
+			stats.Unsequenced++
+		} else {
+			if stats.FirstSeq == 0 {
+				stats.FirstSeq = seq
+			}
+			stats.LastSeq = seq
+		}
+
+		if did := evt.Repo(); did != "" {
+			repos[did]++
+		}
+		if evt.RepoCommit != nil {
+			for _, op := range evt.RepoCommit.Ops {
+				collection, _, _ := strings.Cut(op.Path, "/")
+				stats.Collections[collection]++
+				stats.Actions[op.Action]++
+			}
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+
+	if stats.Total.Events > 0 {
+		stats.FirstTime = time.UnixMilli(firstMillis).UTC()
+		stats.LastTime = time.UnixMilli(lastMillis).UTC()
+		if dt := stats.LastTime.Sub(stats.FirstTime).Seconds(); dt > 0 {
+			stats.EventsPerSecond = float64(stats.Total.Events) / dt
+			stats.BytesPerSecond = float64(stats.Total.Bytes) / dt
+		}
+	}
+
+	stats.Repos = len(repos)
+	if w.TopRepos > 0 {
+		top := make([]RepoEventCount, 0, len(repos))
+		for did, count := range repos {
+			top = append(top, RepoEventCount{DID: did, Events: count})
+		}
+		sort.Slice(top, func(i, j int) bool {
+			if top[i].Events != top[j].Events {
+				return top[i].Events > top[j].Events
+			}
+			return top[i].DID < top[j].DID
+		})
+		if len(top) > w.TopRepos {
+			top = top[:w.TopRepos]
+		}
+		stats.TopRepos = top
+	}
+
+	return stats, nil
+}
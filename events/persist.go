@@ -47,6 +47,8 @@ func (mp *MemPersister) Persist(ctx context.Context, e *XRPCStreamEvent) error {
 		e.RepoIdentity.Seq = mp.seq
 	case e.RepoAccount != nil:
 		e.RepoAccount.Seq = mp.seq
+	case e.RepoSync != nil:
+		e.RepoSync.Seq = mp.seq
 	case e.RepoMigrate != nil:
 		e.RepoMigrate.Seq = mp.seq
 	case e.RepoTombstone != nil:
@@ -0,0 +1,98 @@
+package events
+
+import (
+	"context"
+	"errors"
+)
+
+// SeqGap is a run of sequence numbers missing from playback: every seq
+// strictly between After and Before
+type SeqGap struct {
+	After  int64 `json:"after"`
+	Before int64 `json:"before"`
+}
+
+func (g SeqGap) Missing() int64 {
+	return g.Before - g.After - 1
+}
+
+type SeqContinuityReport struct {
+	Since  int64 `json:"since"`
+	First  int64 `json:"first"`
+	Last   int64 `json:"last"`
+	Events int64 `json:"events"`
+	// total number of sequence numbers missing from playback
+	Missing int64 `json:"missing"`
+	// events with a sequence number not greater than the one before
+	OutOfOrder int64 `json:"outOfOrder"`
+	// the first maxGaps gaps found
+	Gaps      []SeqGap `json:"gaps,omitempty"`
+	Truncated bool     `json:"truncated,omitempty"`
+}
+
+// completeFromPersister is implemented by persisters with retention by event
+// type, which keep older events of some types only
+type completeFromPersister interface {
+	CompleteFrom(ctx context.Context) (int64, error)
+}
+
+var errSeqCheckLimit = errors.New("seq check limit reached")
+
+// CheckSeqContinuity plays back persisted events after since, and reports
+// gaps and regressions in their sequence numbers. Playback stops after limit
+// events, if limit is positive.
+//
+// Persisters don't play back events of taken-down repos, so those show up as
+// gaps too. Persisters which keep some event types longer than the rest (see
+// completeFromPersister) are only checked from where every event is kept.
+func CheckSeqContinuity(ctx context.Context, p EventPersistence, since int64, limit int64, maxGaps int) (*SeqContinuityReport, error) {
+	var from int64
+	if cp, ok := p.(completeFromPersister); ok {
+		var err error
+		from, err = cp.CompleteFrom(ctx)
+		if err != nil && !errors.Is(err, ErrNoLast) {
+			return nil, err
+		}
+		if since < from-1 {
+			since = from - 1
+		}
+	}
+	rep := &SeqContinuityReport{Since: since, First: -1, Last: -1}
+	err := p.Playback(ctx, since, func(evt *XRPCStreamEvent) error {
+		seq := evt.Sequence()
+		if seq < 0 || seq < from {
+			return nil
+		}
+		rep.Events++
+		switch {
+		case rep.First < 0:
+			rep.First = seq
+		case seq <= rep.Last:
+			rep.OutOfOrder++
+			return nil
+		case seq > rep.Last+1:
+			gap := SeqGap{After: rep.Last, Before: seq}
+			rep.Missing += gap.Missing()
+			if len(rep.Gaps) < maxGaps {
+				rep.Gaps = append(rep.Gaps, gap)
+			} else {
+				rep.Truncated = true
+			}
+		}
+		rep.Last = seq
+		if limit > 0 && rep.Events >= limit {
+			return errSeqCheckLimit
+		}
+		return ctx.Err()
+	})
+	if err != nil && !errors.Is(err, errSeqCheckLimit) {
+		return rep, err
+	}
+	return rep, nil
+}
+
+// CheckSeqContinuity checks the sequence numbers of events in this manager's
+// persister. See CheckSeqContinuity.
+func (em *EventManager) CheckSeqContinuity(ctx context.Context, since int64, limit int64, maxGaps int) (*SeqContinuityReport, error) {
+	return CheckSeqContinuity(ctx, em.persister, since, limit, maxGaps)
+}
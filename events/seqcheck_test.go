@@ -0,0 +1,63 @@
+package events
+
+import (
+	"context"
+	"testing"
+
+	atproto "github.com/bluesky-social/indigo/api/atproto"
+)
+
+// plays back commit events with a fixed list of sequence numbers
+type seqListPersister struct {
+	MemPersister
+	seqs []int64
+}
+
+func (p *seqListPersister) Playback(ctx context.Context, since int64, cb func(*XRPCStreamEvent) error) error {
+	for _, seq := range p.seqs {
+		if seq <= since {
+			continue
+		}
+		if err := cb(&XRPCStreamEvent{RepoCommit: &atproto.SyncSubscribeRepos_Commit{Seq: seq}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestCheckSeqContinuity(t *testing.T) {
+	ctx := context.Background()
+	p := &seqListPersister{seqs: []int64{1, 2, 3, 6, 7, 7, 5, 8, 20, 21}}
+
+	rep, err := CheckSeqContinuity(ctx, p, 0, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.First != 1 || rep.Last != 21 || rep.Events != 10 {
+		t.Fatalf("unexpected report: %+v", rep)
+	}
+	if rep.Missing != 2+11 || len(rep.Gaps) != 2 || rep.OutOfOrder != 2 {
+		t.Fatalf("unexpected report: %+v", rep)
+	}
+	if rep.Gaps[0] != (SeqGap{After: 3, Before: 6}) || rep.Gaps[1] != (SeqGap{After: 8, Before: 20}) {
+		t.Fatalf("unexpected gaps: %+v", rep.Gaps)
+	}
+
+	// gap list is capped, but still counted
+	rep, err = CheckSeqContinuity(ctx, p, 0, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Gaps) != 1 || !rep.Truncated || rep.Missing != 13 {
+		t.Fatalf("unexpected report: %+v", rep)
+	}
+
+	// since and limit bound the playback
+	rep, err = CheckSeqContinuity(ctx, p, 3, 2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.First != 6 || rep.Last != 7 || rep.Events != 2 || rep.Missing != 0 {
+		t.Fatalf("unexpected report: %+v", rep)
+	}
+}
@@ -0,0 +1,516 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/gorilla/websocket"
+)
+
+// CursorStore persists the position of a StreamClient in an event stream, so it can resume where it left off after a restart
+type CursorStore interface {
+	// Returns the last persisted cursor, or -1 if there is none
+	GetCursor(ctx context.Context) (int64, error)
+	PutCursor(ctx context.Context, cursor int64) error
+}
+
+// MemCursorStore keeps the cursor in memory only: reconnects resume, but restarts do not
+type MemCursorStore struct {
+	cursor atomic.Int64
+}
+
+func NewMemCursorStore() *MemCursorStore {
+	cs := &MemCursorStore{}
+	cs.cursor.Store(-1)
+	return cs
+}
+
+func (cs *MemCursorStore) GetCursor(ctx context.Context) (int64, error) {
+	return cs.cursor.Load(), nil
+}
+
+func (cs *MemCursorStore) PutCursor(ctx context.Context, cursor int64) error {
+	cs.cursor.Store(cursor)
+	return nil
+}
+
+// FileCursorStore keeps the cursor as a decimal number in a local file
+type FileCursorStore struct {
+	Path string
+}
+
+func (cs *FileCursorStore) GetCursor(ctx context.Context) (int64, error) {
+	b, err := os.ReadFile(cs.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return -1, nil
+		}
+		return -1, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+}
+
+func (cs *FileCursorStore) PutCursor(ctx context.Context, cursor int64) error {
+	// write-then-rename, so a crash never leaves a truncated cursor file
+	tmp := cs.Path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.FormatInt(cursor, 10)), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, cs.Path)
+}
+
+type StreamClientConfig struct {
+	// Base URL of the upstream host, eg "wss://bsky.network". http(s) URLs are converted to ws(s).
+	Host string
+	// XRPC path to subscribe to. Defaults to "/xrpc/com.atproto.sync.subscribeRepos"
+	Path      string
+	UserAgent string
+	// Additional headers sent when connecting (eg, auth)
+	Header http.Header
+
+	// Called for each event. Events are only considered processed (for cursor tracking) once this returns.
+	Handler func(ctx context.Context, evt *XRPCStreamEvent) error
+	// Optional; creates the scheduler which dispatches events to the handler. A new scheduler is created for each connection. Defaults to a sequential scheduler.
+	NewScheduler func(ident string, handler func(context.Context, *XRPCStreamEvent) error) Scheduler
+
+	// Optional; defaults to an in-memory store
+	Cursors CursorStore
+	// How often the processed cursor is persisted. Defaults to 5 seconds.
+	CursorFlushInterval time.Duration
+
+	// Reconnect backoff bounds. Defaults to 1 second and 1 minute.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// If no events arrive for this long, the connection is considered stalled and is re-established. Defaults to 2 minutes; negative to disable.
+	StallTimeout time.Duration
+	// If set, when the upstream reports that the cursor is older than it retains (an OutdatedCursor #info frame naming a backfill host), the missed events are first replayed from the backfill host, and the client then returns to Host. Otherwise the frame is passed to Handler and the missed events are skipped.
+	FollowBackfill bool
+
+	Logger *slog.Logger
+}
+
+// StreamClientStatus is a snapshot of the state of a StreamClient
+type StreamClientStatus struct {
+	Connected bool
+	// Last cursor (sequence number) which has been fully processed, or -1
+	Cursor int64
+	// When the most recent event was received, and the lag between that event's creation ("time" field) and its receipt
+	LastEvent time.Time
+	Lag       time.Duration
+	// Number of times the connection has been (re-)established
+	Connects int64
+}
+
+// StreamClient subscribes to an event stream (eg, a relay firehose) and keeps the subscription alive: it reconnects with backoff when the connection drops or stalls, and resumes from the last processed cursor.
+//
+// Events may be handled concurrently (depending on the scheduler); the tracked cursor is the highest sequence number below which every event has been processed, so resuming never skips an event (though some events may be handled twice).
+type StreamClient struct {
+	cfg    StreamClientConfig
+	logger *slog.Logger
+
+	// the next cursor to resume from, and sequence numbers which have been received but not yet processed
+	mu        sync.Mutex
+	received  int64
+	inflight  map[int64]struct{}
+	cursor    atomic.Int64
+	lastEvent atomic.Int64 // unix millis
+	lag       atomic.Int64 // nanoseconds
+	connected atomic.Bool
+	connects  atomic.Int64
+}
+
+func NewStreamClient(cfg StreamClientConfig) (*StreamClient, error) {
+	if cfg.Handler == nil {
+		return nil, fmt.Errorf("stream client handler is required")
+	}
+	if cfg.Path == "" {
+		cfg.Path = "/xrpc/com.atproto.sync.subscribeRepos"
+	}
+	if cfg.NewScheduler == nil {
+		cfg.NewScheduler = func(ident string, handler func(context.Context, *XRPCStreamEvent) error) Scheduler {
+			return newInlineScheduler(handler)
+		}
+	}
+	if cfg.Cursors == nil {
+		cfg.Cursors = NewMemCursorStore()
+	}
+	if cfg.CursorFlushInterval == 0 {
+		cfg.CursorFlushInterval = 5 * time.Second
+	}
+	if cfg.MinBackoff == 0 {
+		cfg.MinBackoff = time.Second
+	}
+	if cfg.MaxBackoff == 0 {
+		cfg.MaxBackoff = time.Minute
+	}
+	if cfg.StallTimeout == 0 {
+		cfg.StallTimeout = 2 * time.Minute
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	host, err := streamHostURL(cfg.Host)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Host = host
+
+	sc := &StreamClient{
+		cfg:      cfg,
+		logger:   cfg.Logger.With("system", "stream-client", "host", cfg.Host),
+		received: -1,
+		inflight: make(map[int64]struct{}),
+	}
+	sc.cursor.Store(-1)
+	return sc, nil
+}
+
+// normalizes a stream host to a ws(s) base URL
+func streamHostURL(host string) (string, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return "", fmt.Errorf("invalid stream host: %w", err)
+	}
+	switch u.Scheme {
+	case "ws", "wss":
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	default:
+		return "", fmt.Errorf("unsupported stream host scheme: %q", u.Scheme)
+	}
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
+
+// Status returns a snapshot of connection state, cursor, and liveness
+func (sc *StreamClient) Status() StreamClientStatus {
+	st := StreamClientStatus{
+		Connected: sc.connected.Load(),
+		Cursor:    sc.processedCursor(),
+		Lag:       time.Duration(sc.lag.Load()),
+		Connects:  sc.connects.Load(),
+	}
+	if ms := sc.lastEvent.Load(); ms > 0 {
+		st.LastEvent = time.UnixMilli(ms)
+	}
+	return st
+}
+
+// Run connects and processes events until the context is cancelled, reconnecting as needed. The processed cursor is persisted before returning.
+func (sc *StreamClient) Run(ctx context.Context) error {
+	cursor, err := sc.cfg.Cursors.GetCursor(ctx)
+	if err != nil {
+		return fmt.Errorf("reading stream cursor: %w", err)
+	}
+	sc.cursor.Store(cursor)
+	sc.mu.Lock()
+	sc.received = cursor
+	sc.mu.Unlock()
+
+	flushCtx, cancelFlush := context.WithCancel(ctx)
+	defer cancelFlush()
+	go sc.flushCursorLoop(flushCtx)
+	defer func() {
+		// use a fresh context, since ctx is likely already cancelled
+		if err := sc.flushCursor(context.Background()); err != nil {
+			sc.logger.Error("failed to persist stream cursor", "err", err)
+		}
+	}()
+
+	backoff := sc.cfg.MinBackoff
+	for {
+		start := time.Now()
+		err := sc.runConnection(ctx, sc.cfg.Host, -1)
+		if ctx.Err() != nil {
+			return nil
+		}
+		var oce *outdatedCursorError
+		if errors.As(err, &oce) {
+			err = sc.runBackfill(ctx, oce.info)
+			if ctx.Err() != nil {
+				return nil
+			}
+			if err == nil {
+				// go straight back to the primary host
+				backoff = sc.cfg.MinBackoff
+				continue
+			}
+			err = fmt.Errorf("backfill from %s: %w", oce.info.Backfill, err)
+		}
+		// if the connection was healthy for a while, start backoff from the beginning again
+		if time.Since(start) > sc.cfg.MaxBackoff {
+			backoff = sc.cfg.MinBackoff
+		}
+		delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		sc.logger.Warn("stream disconnected, will reconnect", "err", err, "delay", delay, "cursor", sc.processedCursor())
+		streamClientReconnects.WithLabelValues(sc.cfg.Host).Inc()
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+		backoff = min(backoff*2, sc.cfg.MaxBackoff)
+	}
+}
+
+var errBackfillDone = errors.New("backfill reached upstream retention window")
+
+// replays the events the primary host no longer has from the backfill host, up to the primary's earliest cursor
+func (sc *StreamClient) runBackfill(ctx context.Context, info *OutdatedCursorInfo) error {
+	host, err := streamHostURL(info.Backfill)
+	if err != nil {
+		streamClientBackfills.WithLabelValues(sc.cfg.Host, "error").Inc()
+		return err
+	}
+	sc.logger.Warn("cursor is older than upstream retention, backfilling", "cursor", info.Cursor, "earliestCursor", info.EarliestCursor, "backfill", host)
+	err = sc.runConnection(ctx, host, info.EarliestCursor)
+	if errors.Is(err, errBackfillDone) {
+		sc.logger.Info("backfill finished, returning to upstream", "cursor", sc.processedCursor())
+		streamClientBackfills.WithLabelValues(sc.cfg.Host, "ok").Inc()
+		return nil
+	}
+	streamClientBackfills.WithLabelValues(sc.cfg.Host, "error").Inc()
+	return err
+}
+
+// connects to host, and processes events until the connection fails. If until is non-negative, this is a backfill connection, which ends (with errBackfillDone) at the first event after until
+func (sc *StreamClient) runConnection(ctx context.Context, host string, until int64) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	u := host + sc.cfg.Path
+	cursor := sc.processedCursor()
+	if cursor >= 0 {
+		u = fmt.Sprintf("%s?cursor=%d", u, cursor)
+	}
+	header := sc.cfg.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	if sc.cfg.UserAgent != "" {
+		header.Set("User-Agent", sc.cfg.UserAgent)
+	}
+
+	sc.logger.Info("connecting to event stream", "url", host, "cursor", cursor)
+	con, resp, err := websocket.DefaultDialer.DialContext(ctx, u, header)
+	if err != nil {
+		if resp != nil {
+			return fmt.Errorf("dialing stream (status %d): %w", resp.StatusCode, err)
+		}
+		return fmt.Errorf("dialing stream: %w", err)
+	}
+
+	sc.connects.Add(1)
+	sc.connected.Store(true)
+	streamClientConnected.WithLabelValues(host).Set(1)
+	defer func() {
+		sc.connected.Store(false)
+		streamClientConnected.WithLabelValues(host).Set(0)
+	}()
+
+	// any events received but not processed on a previous connection will be re-sent from the cursor
+	sc.mu.Lock()
+	sc.inflight = make(map[int64]struct{})
+	sc.received = cursor
+	sc.mu.Unlock()
+	sc.lastEvent.Store(time.Now().UnixMilli())
+
+	if sc.cfg.StallTimeout > 0 {
+		go sc.stallWatchdog(ctx, cancel)
+	}
+
+	sched := &trackingScheduler{
+		sc:    sc,
+		next:  sc.cfg.NewScheduler(host, sc.handle),
+		until: until,
+	}
+	err = HandleRepoStream(ctx, con, sched, sc.logger)
+	if err == nil {
+		err = errors.New("stream closed")
+	}
+	return err
+}
+
+// cancels the connection if no events have arrived within the stall timeout
+func (sc *StreamClient) stallWatchdog(ctx context.Context, cancel context.CancelFunc) {
+	t := time.NewTicker(sc.cfg.StallTimeout / 4)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			last := time.UnixMilli(sc.lastEvent.Load())
+			if time.Since(last) > sc.cfg.StallTimeout {
+				sc.logger.Warn("event stream stalled, reconnecting", "lastEvent", last)
+				streamClientStalls.WithLabelValues(sc.cfg.Host).Inc()
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// records an event as received (before it is scheduled)
+func (sc *StreamClient) markReceived(evt *XRPCStreamEvent) {
+	now := time.Now()
+	sc.lastEvent.Store(now.UnixMilli())
+	streamClientLastEvent.WithLabelValues(sc.cfg.Host).Set(float64(now.Unix()))
+	if created := eventCreatedAt(evt); !created.IsZero() {
+		lag := now.Sub(created)
+		sc.lag.Store(int64(lag))
+		streamClientLag.WithLabelValues(sc.cfg.Host).Set(lag.Seconds())
+	}
+
+	seq := evt.Sequence()
+	if seq < 0 {
+		return
+	}
+	sc.mu.Lock()
+	sc.inflight[seq] = struct{}{}
+	if seq > sc.received {
+		sc.received = seq
+	}
+	sc.mu.Unlock()
+}
+
+// wraps the configured handler to mark events as processed
+func (sc *StreamClient) handle(ctx context.Context, evt *XRPCStreamEvent) error {
+	if evt.Error != nil {
+		sc.logger.Warn("error frame from event stream", "error", evt.Error.Error, "message", evt.Error.Message)
+	}
+	err := sc.cfg.Handler(ctx, evt)
+	if seq := evt.Sequence(); seq >= 0 {
+		sc.mu.Lock()
+		delete(sc.inflight, seq)
+		sc.mu.Unlock()
+	}
+	return err
+}
+
+// the highest sequence number below which all received events have been processed
+func (sc *StreamClient) processedCursor() int64 {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.received < 0 && len(sc.inflight) == 0 {
+		return sc.cursor.Load()
+	}
+	cursor := sc.received
+	for seq := range sc.inflight {
+		if seq-1 < cursor {
+			cursor = seq - 1
+		}
+	}
+	sc.cursor.Store(cursor)
+	return cursor
+}
+
+func (sc *StreamClient) flushCursor(ctx context.Context) error {
+	cursor := sc.processedCursor()
+	if cursor < 0 {
+		return nil
+	}
+	streamClientCursor.WithLabelValues(sc.cfg.Host).Set(float64(cursor))
+	return sc.cfg.Cursors.PutCursor(ctx, cursor)
+}
+
+func (sc *StreamClient) flushCursorLoop(ctx context.Context) {
+	t := time.NewTicker(sc.cfg.CursorFlushInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := sc.flushCursor(ctx); err != nil {
+				sc.logger.Error("failed to persist stream cursor", "err", err)
+			}
+		}
+	}
+}
+
+// creation time of an event, from its "time" field, if it has one
+func eventCreatedAt(evt *XRPCStreamEvent) time.Time {
+	var s string
+	switch {
+	case evt.RepoCommit != nil:
+		s = evt.RepoCommit.Time
+	case evt.RepoSync != nil:
+		s = evt.RepoSync.Time
+	case evt.RepoIdentity != nil:
+		s = evt.RepoIdentity.Time
+	case evt.RepoAccount != nil:
+		s = evt.RepoAccount.Time
+	case evt.RepoHandle != nil:
+		s = evt.RepoHandle.Time
+	case evt.RepoMigrate != nil:
+		s = evt.RepoMigrate.Time
+	case evt.RepoTombstone != nil:
+		s = evt.RepoTombstone.Time
+	case evt.LabelLabels != nil && len(evt.LabelLabels.Labels) > 0:
+		s = evt.LabelLabels.Labels[0].Cts
+	default:
+		return time.Time{}
+	}
+	t, err := syntax.ParseDatetimeLenient(s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t.Time()
+}
+
+// wraps a Scheduler to record events as received before they are dispatched
+type trackingScheduler struct {
+	sc   *StreamClient
+	next Scheduler
+	// last sequence number to process on a backfill connection, or -1
+	until int64
+}
+
+func (ts *trackingScheduler) AddWork(ctx context.Context, repo string, val *XRPCStreamEvent) error {
+	if info, ok := ParseOutdatedCursor(val.RepoInfo); ok {
+		if ts.until >= 0 {
+			// the backfill host doesn't have them either
+			ts.sc.logger.Warn("backfill host no longer has events from cursor, some events were missed", "info", info)
+		} else if ts.sc.cfg.FollowBackfill && info != nil && info.Backfill != "" && info.EarliestCursor > ts.sc.processedCursor() {
+			return &outdatedCursorError{info: info}
+		}
+	}
+	if ts.until >= 0 && val.Sequence() > ts.until {
+		return errBackfillDone
+	}
+	ts.sc.markReceived(val)
+	return ts.next.AddWork(ctx, repo, val)
+}
+
+func (ts *trackingScheduler) Shutdown() {
+	ts.next.Shutdown()
+}
+
+// the default scheduler: handles each event synchronously as it is read from the stream. (the sequential scheduler package can't be used here, since it imports this package)
+type inlineScheduler struct {
+	handler func(context.Context, *XRPCStreamEvent) error
+}
+
+func newInlineScheduler(handler func(context.Context, *XRPCStreamEvent) error) *inlineScheduler {
+	return &inlineScheduler{handler: handler}
+}
+
+func (s *inlineScheduler) AddWork(ctx context.Context, repo string, val *XRPCStreamEvent) error {
+	return s.handler(ctx, val)
+}
+
+func (s *inlineScheduler) Shutdown() {}
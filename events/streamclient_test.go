@@ -0,0 +1,239 @@
+package events
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+// serves batches of identity events; each connection gets the next batch, then the connection is dropped
+type fakeStream struct {
+	mu      sync.Mutex
+	batches [][]int64
+	cursors []string
+}
+
+func (fs *fakeStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fs.mu.Lock()
+	fs.cursors = append(fs.cursors, r.URL.Query().Get("cursor"))
+	var batch []int64
+	if len(fs.batches) > 0 {
+		batch = fs.batches[0]
+		fs.batches = fs.batches[1:]
+	}
+	fs.mu.Unlock()
+
+	upgrader := websocket.Upgrader{}
+	con, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer con.Close()
+	for _, seq := range batch {
+		evt := &XRPCStreamEvent{RepoIdentity: &comatproto.SyncSubscribeRepos_Identity{
+			Did:  "did:example:abc",
+			Seq:  seq,
+			Time: time.Now().UTC().Format(time.RFC3339Nano),
+		}}
+		wc, err := con.NextWriter(websocket.BinaryMessage)
+		if err != nil {
+			return
+		}
+		if err := evt.Serialize(wc); err != nil {
+			return
+		}
+		wc.Close()
+	}
+}
+
+func (fs *fakeStream) Cursors() []string {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return append([]string{}, fs.cursors...)
+}
+
+func TestStreamClientReconnect(t *testing.T) {
+	assert := assert.New(t)
+
+	fs := &fakeStream{batches: [][]int64{{1, 2, 3}, {4, 5}}}
+	srv := httptest.NewServer(fs)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var mu sync.Mutex
+	var seen []int64
+	cursors := &FileCursorStore{Path: filepath.Join(t.TempDir(), "cursor")}
+	sc, err := NewStreamClient(StreamClientConfig{
+		Host: srv.URL,
+		Handler: func(ctx context.Context, evt *XRPCStreamEvent) error {
+			mu.Lock()
+			defer mu.Unlock()
+			seen = append(seen, evt.Sequence())
+			if len(seen) == 5 {
+				cancel()
+			}
+			return nil
+		},
+		Cursors:    cursors,
+		MinBackoff: 10 * time.Millisecond,
+		MaxBackoff: 20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NoError(sc.Run(ctx))
+	assert.Equal([]int64{1, 2, 3, 4, 5}, seen)
+	assert.Equal([]string{"", "3"}, fs.Cursors()[:2])
+
+	st := sc.Status()
+	assert.Equal(int64(5), st.Cursor)
+	assert.False(st.Connected)
+	assert.GreaterOrEqual(st.Connects, int64(2))
+
+	// cursor is persisted on exit
+	cursor, err := cursors.GetCursor(context.Background())
+	assert.NoError(err)
+	assert.Equal(int64(5), cursor)
+}
+
+func TestStreamClientProcessedCursor(t *testing.T) {
+	assert := assert.New(t)
+
+	sc, err := NewStreamClient(StreamClientConfig{
+		Host:    "https://relay.example.com",
+		Handler: func(ctx context.Context, evt *XRPCStreamEvent) error { return nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal("wss://relay.example.com", sc.cfg.Host)
+	assert.Equal(int64(-1), sc.processedCursor())
+
+	evt := func(seq int64) *XRPCStreamEvent {
+		return &XRPCStreamEvent{RepoIdentity: &comatproto.SyncSubscribeRepos_Identity{Seq: seq}}
+	}
+	sc.markReceived(evt(10))
+	sc.markReceived(evt(11))
+	sc.markReceived(evt(12))
+	assert.Equal(int64(9), sc.processedCursor())
+
+	// out-of-order completion doesn't advance the cursor past unprocessed events
+	assert.NoError(sc.handle(context.Background(), evt(12)))
+	assert.Equal(int64(9), sc.processedCursor())
+	assert.NoError(sc.handle(context.Background(), evt(10)))
+	assert.Equal(int64(10), sc.processedCursor())
+	assert.NoError(sc.handle(context.Background(), evt(11)))
+	assert.Equal(int64(12), sc.processedCursor())
+}
+
+func writeTestEvent(con *websocket.Conn, evt *XRPCStreamEvent) error {
+	wc, err := con.NextWriter(websocket.BinaryMessage)
+	if err != nil {
+		return err
+	}
+	if err := evt.Serialize(wc); err != nil {
+		return err
+	}
+	return wc.Close()
+}
+
+// serves identity events after the requested cursor, from those in seqs
+func testStreamHandler(seqs []int64, before func(con *websocket.Conn, cursor int64) bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cursor := int64(-1)
+		if c := r.URL.Query().Get("cursor"); c != "" {
+			cursor, _ = strconv.ParseInt(c, 10, 64)
+		}
+		upgrader := websocket.Upgrader{}
+		con, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer con.Close()
+		if before != nil && !before(con, cursor) {
+			return
+		}
+		for _, seq := range seqs {
+			if seq <= cursor {
+				continue
+			}
+			if err := writeTestEvent(con, &XRPCStreamEvent{RepoIdentity: &comatproto.SyncSubscribeRepos_Identity{Did: "did:example:abc", Seq: seq}}); err != nil {
+				return
+			}
+		}
+		// hold the connection open until the client goes away
+		con.ReadMessage()
+	}
+}
+
+func TestStreamClientBackfill(t *testing.T) {
+	assert := assert.New(t)
+
+	backfill := httptest.NewServer(testStreamHandler([]int64{1, 2, 3, 4, 5, 6, 7}, nil))
+	defer backfill.Close()
+	// the primary only retains events from 6 on
+	primary := httptest.NewServer(testStreamHandler([]int64{6, 7, 8}, func(con *websocket.Conn, cursor int64) bool {
+		if cursor >= 0 && cursor < 5 {
+			info := NewOutdatedCursorEvent(OutdatedCursorInfo{Cursor: cursor, EarliestCursor: 5, Backfill: backfill.URL})
+			return writeTestEvent(con, info) == nil
+		}
+		return true
+	}))
+	defer primary.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var seen []int64
+	cursors := NewMemCursorStore()
+	cursors.PutCursor(ctx, 2)
+	sc, err := NewStreamClient(StreamClientConfig{
+		Host: primary.URL,
+		Handler: func(ctx context.Context, evt *XRPCStreamEvent) error {
+			seen = append(seen, evt.Sequence())
+			if evt.Sequence() == 8 {
+				cancel()
+			}
+			return nil
+		},
+		Cursors:        cursors,
+		FollowBackfill: true,
+		MinBackoff:     10 * time.Millisecond,
+		MaxBackoff:     20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NoError(sc.Run(ctx))
+	assert.Equal([]int64{3, 4, 5, 6, 7, 8}, seen)
+}
+
+func TestParseOutdatedCursor(t *testing.T) {
+	assert := assert.New(t)
+
+	evt := NewOutdatedCursorEvent(OutdatedCursorInfo{Cursor: 10, EarliestCursor: 100, Backfill: "wss://relay.example.com"})
+	info, ok := ParseOutdatedCursor(evt.RepoInfo)
+	assert.True(ok)
+	assert.Equal(&OutdatedCursorInfo{Cursor: 10, EarliestCursor: 100, Backfill: "wss://relay.example.com"}, info)
+
+	msg := "cursor is too old"
+	info, ok = ParseOutdatedCursor(&comatproto.SyncSubscribeRepos_Info{Name: InfoOutdatedCursor, Message: &msg})
+	assert.True(ok)
+	assert.Nil(info)
+
+	_, ok = ParseOutdatedCursor(&comatproto.SyncSubscribeRepos_Info{Name: "FutureCursor"})
+	assert.False(ok)
+}
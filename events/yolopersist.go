@@ -33,6 +33,8 @@ func (yp *YoloPersister) Persist(ctx context.Context, e *XRPCStreamEvent) error
 		e.RepoIdentity.Seq = yp.seq
 	case e.RepoAccount != nil:
 		e.RepoAccount.Seq = yp.seq
+	case e.RepoSync != nil:
+		e.RepoSync.Seq = yp.seq
 	case e.RepoMigrate != nil:
 		e.RepoMigrate.Seq = yp.seq
 	case e.RepoTombstone != nil:
@@ -0,0 +1,228 @@
+// Package feedgen is a minimal framework for atproto feed generator services.
+//
+// A feed generator is a web service, identified by a did:web DID on its own
+// hostname, which returns "skeletons" of feeds: lists of post AT-URIs, which
+// the AppView hydrates in to full feed views. Each feed is implemented by a
+// Feed, registered with a Server under the record key of the
+// app.bsky.feed.generator record which publishes it. The Server takes care of
+// the did:web document, describeFeedGenerator, and getFeedSkeleton
+// parameter handling.
+package feedgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/identity/didweb"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// number of posts requested, if the client doesn't say
+	DefaultLimit = 50
+	// maximum number of posts which may be requested, per the lexicon
+	MaxLimit = 100
+)
+
+// Returned by a Feed when the cursor can't be parsed
+var ErrBadCursor = errors.New("invalid feed cursor")
+
+// A request for a page of a feed skeleton
+type SkeletonRequest struct {
+	// AT-URI of the feed generator record
+	Feed syntax.ATURI
+	// record key of Feed, which the Feed was registered under
+	Name string
+	// opaque pagination cursor, as returned with a previous page. empty for the first page
+	Cursor string
+	// maximum number of posts to return; between 1 and MaxLimit
+	Limit int
+}
+
+// Feed is implemented by feed algorithms
+type Feed interface {
+	// returns a page of the feed. the output Cursor should be nil at the end of the feed
+	Skeleton(ctx context.Context, req *SkeletonRequest) (*appbsky.FeedGetFeedSkeleton_Output, error)
+}
+
+// FeedFunc is an adapter to use an ordinary function as a Feed
+type FeedFunc func(ctx context.Context, req *SkeletonRequest) (*appbsky.FeedGetFeedSkeleton_Output, error)
+
+func (f FeedFunc) Skeleton(ctx context.Context, req *SkeletonRequest) (*appbsky.FeedGetFeedSkeleton_Output, error) {
+	return f(ctx, req)
+}
+
+type Config struct {
+	// public hostname of the service. the service DID is "did:web:<Hostname>"
+	Hostname string
+	// account whose repo has the app.bsky.feed.generator records for the feeds
+	PublisherDID syntax.DID
+	// optional URLs, included in describeFeedGenerator
+	PrivacyPolicy  string
+	TermsOfService string
+	Logger         *slog.Logger
+}
+
+type Server struct {
+	conf   Config
+	did    syntax.DID
+	didDoc *didweb.Document
+	log    *slog.Logger
+
+	lk    sync.RWMutex
+	feeds map[string]Feed
+}
+
+func NewServer(conf Config) (*Server, error) {
+	didDoc, err := didweb.New(didweb.Config{
+		Hostname: conf.Hostname,
+		Services: []didweb.Service{didweb.FeedGeneratorService("https://" + conf.Hostname)},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid feed generator hostname: %w", err)
+	}
+	if _, err := syntax.ParseDID(conf.PublisherDID.String()); err != nil {
+		return nil, fmt.Errorf("invalid publisher DID: %w", err)
+	}
+	log := conf.Logger
+	if log == nil {
+		log = slog.Default().With("system", "feedgen")
+	}
+	return &Server{
+		conf:   conf,
+		did:    didDoc.DID(),
+		didDoc: didDoc,
+		log:    log,
+		feeds:  make(map[string]Feed),
+	}, nil
+}
+
+// ServiceDID is the did:web DID of the service, which feed generator records must point at
+func (s *Server) ServiceDID() syntax.DID {
+	return s.did
+}
+
+// FeedURI is the AT-URI of the generator record for the named feed
+func (s *Server) FeedURI(name string) syntax.ATURI {
+	return syntax.ATURI(fmt.Sprintf("at://%s/app.bsky.feed.generator/%s", s.conf.PublisherDID, name))
+}
+
+// AddFeed registers a feed under the record key of its generator record, replacing any feed already registered with that name
+func (s *Server) AddFeed(name string, f Feed) error {
+	if _, err := syntax.ParseRecordKey(name); err != nil {
+		return fmt.Errorf("invalid feed name: %w", err)
+	}
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.feeds[name] = f
+	return nil
+}
+
+func (s *Server) feedNames() []string {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+	names := make([]string, 0, len(s.feeds))
+	for name := range s.feeds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RegisterHandlers adds the feed generator routes to an existing echo server
+func (s *Server) RegisterHandlers(e *echo.Echo) {
+	e.GET(didweb.WellKnownPath, echo.WrapHandler(s.didDoc))
+	e.GET("/xrpc/app.bsky.feed.describeFeedGenerator", s.HandleDescribeFeedGenerator)
+	e.GET("/xrpc/app.bsky.feed.getFeedSkeleton", s.HandleGetFeedSkeleton)
+	e.GET("/xrpc/_health", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+	})
+}
+
+// Start serves the feed generator on the given address, until the server fails
+func (s *Server) Start(listen string) error {
+	e := echo.New()
+	e.HideBanner = true
+	e.HTTPErrorHandler = func(err error, c echo.Context) {
+		var herr *echo.HTTPError
+		if errors.As(err, &herr) {
+			c.JSON(herr.Code, map[string]any{"error": herr.Message})
+			return
+		}
+		s.log.Error("feed generator handler error", "path", c.Path(), "err", err)
+		c.JSON(http.StatusInternalServerError, map[string]any{"error": "InternalServerError"})
+	}
+	s.RegisterHandlers(e)
+	s.log.Info("starting feed generator", "did", s.did, "listen", listen, "feeds", s.feedNames())
+	return e.Start(listen)
+}
+
+func (s *Server) HandleDescribeFeedGenerator(c echo.Context) error {
+	out := appbsky.FeedDescribeFeedGenerator_Output{
+		Did:   s.did.String(),
+		Feeds: []*appbsky.FeedDescribeFeedGenerator_Feed{},
+	}
+	for _, name := range s.feedNames() {
+		out.Feeds = append(out.Feeds, &appbsky.FeedDescribeFeedGenerator_Feed{Uri: s.FeedURI(name).String()})
+	}
+	if s.conf.PrivacyPolicy != "" || s.conf.TermsOfService != "" {
+		out.Links = &appbsky.FeedDescribeFeedGenerator_Links{}
+		if s.conf.PrivacyPolicy != "" {
+			out.Links.PrivacyPolicy = &s.conf.PrivacyPolicy
+		}
+		if s.conf.TermsOfService != "" {
+			out.Links.TermsOfService = &s.conf.TermsOfService
+		}
+	}
+	return c.JSON(http.StatusOK, out)
+}
+
+func (s *Server) HandleGetFeedSkeleton(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	uri, err := syntax.ParseATURI(c.QueryParam("feed"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "InvalidRequest", "message": "invalid feed URI"})
+	}
+	limit := DefaultLimit
+	if q := c.QueryParam("limit"); q != "" {
+		limit, err = strconv.Atoi(q)
+		if err != nil || limit < 1 || limit > MaxLimit {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "InvalidRequest", "message": fmt.Sprintf("limit must be between 1 and %d", MaxLimit)})
+		}
+	}
+
+	name := uri.RecordKey().String()
+	s.lk.RLock()
+	feed, ok := s.feeds[name]
+	s.lk.RUnlock()
+	if !ok || uri.Collection() != "app.bsky.feed.generator" || uri.Authority().String() != s.conf.PublisherDID.String() {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "UnknownFeed", "message": "feed not served by this generator"})
+	}
+
+	out, err := feed.Skeleton(ctx, &SkeletonRequest{
+		Feed:   uri,
+		Name:   name,
+		Cursor: c.QueryParam("cursor"),
+		Limit:  limit,
+	})
+	if errors.Is(err, ErrBadCursor) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "InvalidRequest", "message": err.Error()})
+	}
+	if err != nil {
+		return fmt.Errorf("feed %s: %w", name, err)
+	}
+	if out.Feed == nil {
+		out.Feed = []*appbsky.FeedDefs_SkeletonFeedPost{}
+	}
+	return c.JSON(http.StatusOK, out)
+}
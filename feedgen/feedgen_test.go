@@ -0,0 +1,89 @@
+package feedgen
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/identity"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := NewServer(Config{Hostname: "not a hostname", PublisherDID: "did:plc:abc123"})
+	assert.Error(err)
+	_, err = NewServer(Config{Hostname: "feeds.example.com", PublisherDID: "bob"})
+	assert.Error(err)
+
+	srv, err := NewServer(Config{Hostname: "feeds.example.com", PublisherDID: "did:plc:abc123", PrivacyPolicy: "https://example.com/privacy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal("did:web:feeds.example.com", srv.ServiceDID().String())
+	assert.Error(srv.AddFeed("bad/name", StaticFeed{}))
+	assert.NoError(srv.AddFeed("cats", StaticFeed{
+		"at://did:plc:abc123/app.bsky.feed.post/1",
+		"at://did:plc:abc123/app.bsky.feed.post/2",
+		"at://did:plc:abc123/app.bsky.feed.post/3",
+	}))
+
+	e := echo.New()
+	srv.RegisterHandlers(e)
+	get := func(path string, out any) int {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if out != nil && rec.Code == http.StatusOK {
+			assert.NoError(json.Unmarshal(rec.Body.Bytes(), out))
+		}
+		return rec.Code
+	}
+
+	var doc identity.DIDDocument
+	assert.Equal(http.StatusOK, get("/.well-known/did.json", &doc))
+	assert.Equal(srv.ServiceDID(), doc.DID)
+	assert.Equal("https://feeds.example.com", doc.Service[0].ServiceEndpoint)
+
+	var desc appbsky.FeedDescribeFeedGenerator_Output
+	assert.Equal(http.StatusOK, get("/xrpc/app.bsky.feed.describeFeedGenerator", &desc))
+	assert.Len(desc.Feeds, 1)
+	assert.Equal("at://did:plc:abc123/app.bsky.feed.generator/cats", desc.Feeds[0].Uri)
+	assert.Equal("https://example.com/privacy", *desc.Links.PrivacyPolicy)
+
+	skel := func(feed, cursor, limit string) (*appbsky.FeedGetFeedSkeleton_Output, int) {
+		q := url.Values{"feed": {feed}}
+		if cursor != "" {
+			q.Set("cursor", cursor)
+		}
+		if limit != "" {
+			q.Set("limit", limit)
+		}
+		var out appbsky.FeedGetFeedSkeleton_Output
+		code := get("/xrpc/app.bsky.feed.getFeedSkeleton?"+q.Encode(), &out)
+		return &out, code
+	}
+	cats := srv.FeedURI("cats").String()
+	out, code := skel(cats, "", "2")
+	assert.Equal(http.StatusOK, code)
+	assert.Len(out.Feed, 2)
+	assert.Equal("at://did:plc:abc123/app.bsky.feed.post/1", out.Feed[0].Post)
+	out, code = skel(cats, *out.Cursor, "2")
+	assert.Equal(http.StatusOK, code)
+	assert.Len(out.Feed, 1)
+	assert.Nil(out.Cursor)
+
+	_, code = skel(cats, "bogus", "")
+	assert.Equal(http.StatusBadRequest, code)
+	_, code = skel(cats, "", "500")
+	assert.Equal(http.StatusBadRequest, code)
+	_, code = skel("at://did:plc:abc123/app.bsky.feed.generator/dogs", "", "")
+	assert.Equal(http.StatusBadRequest, code)
+	_, code = skel("at://did:plc:other/app.bsky.feed.generator/cats", "", "")
+	assert.Equal(http.StatusBadRequest, code)
+}
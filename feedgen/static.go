@@ -0,0 +1,35 @@
+package feedgen
+
+import (
+	"context"
+	"strconv"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+)
+
+// StaticFeed is a Feed which serves a fixed list of post AT-URIs, in order.
+// It is mostly useful for trying out a feed generator deployment before the
+// real algorithm exists. The cursor is the offset in to the list.
+type StaticFeed []string
+
+func (f StaticFeed) Skeleton(ctx context.Context, req *SkeletonRequest) (*appbsky.FeedGetFeedSkeleton_Output, error) {
+	offset := 0
+	if req.Cursor != "" {
+		v, err := strconv.Atoi(req.Cursor)
+		if err != nil || v < 0 {
+			return nil, ErrBadCursor
+		}
+		offset = v
+	}
+
+	out := &appbsky.FeedGetFeedSkeleton_Output{Feed: []*appbsky.FeedDefs_SkeletonFeedPost{}}
+	end := min(offset+req.Limit, len(f))
+	for i := offset; i < end; i++ {
+		out.Feed = append(out.Feed, &appbsky.FeedDefs_SkeletonFeedPost{Post: f[i]})
+	}
+	if end < len(f) {
+		next := strconv.Itoa(end)
+		out.Cursor = &next
+	}
+	return out, nil
+}
@@ -81,6 +81,12 @@ func NewIndexer(db *gorm.DB, notifman notifs.NotificationManager, evtman *events
 	return ix, nil
 }
 
+// SetEventManager replaces the event manager which repo events are sent to.
+// Must be called before any repo events are handled.
+func (ix *Indexer) SetEventManager(em *events.EventManager) {
+	ix.events = em
+}
+
 func (ix *Indexer) Shutdown() {
 	if ix.Crawler != nil {
 		ix.Crawler.Shutdown()
@@ -100,6 +106,7 @@ func (ix *Indexer) HandleRepoEvent(ctx context.Context, evt *repomgr.RepoEvent)
 			Path:   op.Collection + "/" + op.Rkey,
 			Action: string(op.Kind),
 			Cid:    link,
+			Prev:   (*lexutil.LexLink)(op.PrevCid),
 		})
 
 		if err := ix.handleRepoOp(ctx, evt, &op); err != nil {
@@ -112,6 +119,25 @@ func (ix *Indexer) HandleRepoEvent(ctx context.Context, evt *repomgr.RepoEvent)
 		return err
 	}
 
+	if evt.Sync {
+		// the repo was replaced wholesale (eg, an import with no base
+		// revision); downstream consumers can't apply it as a diff, so tell
+		// them to resync from the new commit instead
+		ix.log.Debug("Sending sync event", "did", did)
+		if err := ix.events.AddEvent(ctx, &events.XRPCStreamEvent{
+			RepoSync: &comatproto.SyncSubscribeRepos_Sync{
+				Did:    did,
+				Rev:    evt.Rev,
+				Blocks: evt.RepoSlice,
+				Time:   time.Now().Format(util.ISO8601),
+			},
+			PrivUid: evt.User,
+		}); err != nil {
+			return fmt.Errorf("failed to push sync event: %s", err)
+		}
+		return nil
+	}
+
 	toobig := false
 	slice := evt.RepoSlice
 	if len(slice) > MaxEventSliceLength || len(outops) > MaxOpsSliceLength {
@@ -123,17 +149,19 @@ func (ix *Indexer) HandleRepoEvent(ctx context.Context, evt *repomgr.RepoEvent)
 	ix.log.Debug("Sending event", "did", did)
 	if err := ix.events.AddEvent(ctx, &events.XRPCStreamEvent{
 		RepoCommit: &comatproto.SyncSubscribeRepos_Commit{
-			Repo:   did,
-			Prev:   (*lexutil.LexLink)(evt.OldRoot),
-			Blocks: slice,
-			Rev:    evt.Rev,
-			Since:  evt.Since,
-			Commit: lexutil.LexLink(evt.NewRoot),
-			Time:   time.Now().Format(util.ISO8601),
-			Ops:    outops,
-			TooBig: toobig,
+			Repo:     did,
+			Prev:     (*lexutil.LexLink)(evt.OldRoot),
+			PrevData: (*lexutil.LexLink)(evt.PrevData),
+			Blocks:   slice,
+			Rev:      evt.Rev,
+			Since:    evt.Since,
+			Commit:   lexutil.LexLink(evt.NewRoot),
+			Time:     time.Now().Format(util.ISO8601),
+			Ops:      outops,
+			TooBig:   toobig,
 		},
-		PrivUid: evt.User,
+		PrivUid:   evt.User,
+		PrivPdsId: evt.PDS,
 	}); err != nil {
 		return fmt.Errorf("failed to push event: %s", err)
 	}
@@ -175,3 +175,31 @@ func (rf *RepoFetcher) FetchAndIndexRepo(ctx context.Context, job *crawlWork) er
 
 	return nil
 }
+
+// ResyncRepo discards the local view of the given repo and re-imports a full
+// copy of it from its PDS. This is used to recover from a local repo which has
+// diverged from upstream, where an incremental fetch would not help.
+func (rf *RepoFetcher) ResyncRepo(ctx context.Context, ai *models.ActorInfo) error {
+	ctx, span := otel.Tracer("indexer").Start(ctx, "ResyncRepo")
+	defer span.End()
+
+	var pds models.PDS
+	if err := rf.db.First(&pds, "id = ?", ai.PDS).Error; err != nil {
+		return fmt.Errorf("expected to find pds record (%d) in db for resyncing one of their users: %w", ai.PDS, err)
+	}
+
+	c := models.ClientForPds(&pds)
+	rf.ApplyPDSClientSettings(c)
+
+	repo, err := rf.fetchRepo(ctx, c, &pds, ai.Did, "")
+	if err != nil {
+		return err
+	}
+
+	if err := rf.repoman.ImportNewRepo(ctx, ai.Uid, ai.Did, bytes.NewReader(repo), nil); err != nil {
+		span.RecordError(err)
+		return fmt.Errorf("failed to import resynced repo (%s): %w", ai.Did, err)
+	}
+
+	return nil
+}
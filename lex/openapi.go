@@ -0,0 +1,560 @@
+package lex
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// OpenAPIConfig controls OpenAPI and JSON Schema export of lexicons
+type OpenAPIConfig struct {
+	// Document metadata (OpenAPI "info" section)
+	Title   string
+	Version string
+	// Optional base URL of a server hosting the endpoints, eg "https://public.api.bsky.app"
+	ServerURL string
+	// NSID prefixes (eg, "app.bsky.feed" or "com.atproto.repo.getRecord") selecting which lexicons to export. Empty to export all. Definitions in other lexicons are included as needed to resolve references.
+	Prefixes []string
+}
+
+func (cfg *OpenAPIConfig) selected(id string) bool {
+	if len(cfg.Prefixes) == 0 {
+		return true
+	}
+	for _, p := range cfg.Prefixes {
+		if id == p || strings.HasPrefix(id, strings.TrimSuffix(p, ".")+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// JSON Schema keywords are assembled as generic maps; encoding/json sorts map keys, so output is deterministic
+type jsonSchema = map[string]any
+
+// converts lexicon definitions to JSON Schema, collecting the definitions which are referenced along the way
+type schemaConverter struct {
+	defs map[string]*TypeSchema
+	// prefix for "$ref" values, eg "#/components/schemas/"
+	refPrefix string
+	// full names of referenced definitions (which need to be emitted)
+	referenced map[string]bool
+}
+
+func newSchemaConverter(schemas []*Schema, refPrefix string) *schemaConverter {
+	sc := &schemaConverter{
+		defs:       make(map[string]*TypeSchema),
+		refPrefix:  refPrefix,
+		referenced: make(map[string]bool),
+	}
+	for _, s := range schemas {
+		for name, def := range s.Defs {
+			def.id = s.ID
+			def.defName = name
+			sc.defs[defFullName(s.ID, name)] = def
+		}
+	}
+	return sc
+}
+
+// full name of a lexicon definition, as used in refs and "$type" values: "nsid" for main defs, otherwise "nsid#name"
+func defFullName(id, name string) string {
+	if name == "main" {
+		return id
+	}
+	return id + "#" + name
+}
+
+// resolves a (possibly local) ref to a full definition name
+func resolveRef(id, ref string) string {
+	if strings.HasPrefix(ref, "#") {
+		ref = id + ref
+	}
+	return strings.TrimSuffix(ref, "#main")
+}
+
+// name of the JSON Schema/OpenAPI component for a definition. component names may not contain '#'
+func componentName(fullname string) string {
+	return strings.Replace(fullname, "#", ".", 1)
+}
+
+func (sc *schemaConverter) ref(id, ref string) jsonSchema {
+	full := resolveRef(id, ref)
+	sc.referenced[full] = true
+	return jsonSchema{"$ref": sc.refPrefix + componentName(full)}
+}
+
+// well-known lexicon string formats which have JSON Schema equivalents. others are passed through as custom formats.
+var lexStringFormats = map[string]string{
+	"datetime": "date-time",
+	"uri":      "uri",
+}
+
+// converts a single lexicon type (within the lexicon with the given id) to JSON Schema
+func (sc *schemaConverter) convert(id string, ts *TypeSchema) (jsonSchema, error) {
+	out := jsonSchema{}
+	if ts.Description != "" {
+		out["description"] = ts.Description
+	}
+
+	switch ts.Type {
+	case "null":
+		out["type"] = "null"
+	case "boolean":
+		out["type"] = "boolean"
+		if ts.Default != nil {
+			out["default"] = ts.Default
+		}
+		if ts.Const != nil {
+			out["const"] = ts.Const
+		}
+	case "integer":
+		out["type"] = "integer"
+		if ts.Minimum != nil {
+			out["minimum"] = ts.Minimum
+		}
+		if ts.Maximum != nil {
+			out["maximum"] = ts.Maximum
+		}
+		if ts.Default != nil {
+			out["default"] = ts.Default
+		}
+		if ts.Const != nil {
+			out["const"] = ts.Const
+		}
+	case "string":
+		out["type"] = "string"
+		if ts.Format != "" {
+			if f, ok := lexStringFormats[ts.Format]; ok {
+				out["format"] = f
+			} else {
+				out["format"] = ts.Format
+			}
+		}
+		if ts.MaxLength > 0 {
+			out["maxLength"] = ts.MaxLength
+		}
+		if ts.MinLength > 0 {
+			out["minLength"] = ts.MinLength
+		}
+		if ts.MaxGraphemes > 0 {
+			out["x-lexicon-maxGraphemes"] = ts.MaxGraphemes
+		}
+		if len(ts.Enum) > 0 {
+			out["enum"] = ts.Enum
+		}
+		if len(ts.KnownValues) > 0 {
+			// open set of values; documented but not enforced
+			out["x-lexicon-knownValues"] = ts.KnownValues
+		}
+		if ts.Default != nil {
+			out["default"] = ts.Default
+		}
+		if ts.Const != nil {
+			out["const"] = ts.Const
+		}
+	case "bytes":
+		out["type"] = "object"
+		out["properties"] = jsonSchema{"$bytes": jsonSchema{"type": "string", "contentEncoding": "base64"}}
+		out["required"] = []string{"$bytes"}
+	case "cid-link":
+		out["type"] = "object"
+		out["properties"] = jsonSchema{"$link": jsonSchema{"type": "string", "format": "cid"}}
+		out["required"] = []string{"$link"}
+	case "blob":
+		out["type"] = "object"
+		out["properties"] = jsonSchema{
+			"$type":    jsonSchema{"const": "blob"},
+			"ref":      jsonSchema{"type": "object", "properties": jsonSchema{"$link": jsonSchema{"type": "string", "format": "cid"}}, "required": []string{"$link"}},
+			"mimeType": jsonSchema{"type": "string"},
+			"size":     jsonSchema{"type": "integer"},
+		}
+		out["required"] = []string{"$type", "ref", "mimeType", "size"}
+	case "unknown":
+		out["type"] = "object"
+	case "token":
+		out["type"] = "string"
+		out["const"] = defFullName(id, ts.defName)
+	case "array":
+		out["type"] = "array"
+		if ts.Items == nil {
+			return nil, fmt.Errorf("array without items in %s", id)
+		}
+		items, err := sc.convert(id, ts.Items)
+		if err != nil {
+			return nil, err
+		}
+		out["items"] = items
+		if ts.MaxLength > 0 {
+			out["maxItems"] = ts.MaxLength
+		}
+		if ts.MinLength > 0 {
+			out["minItems"] = ts.MinLength
+		}
+	case "object", "params":
+		out["type"] = "object"
+		nullable := make(map[string]bool, len(ts.Nullable))
+		for _, n := range ts.Nullable {
+			nullable[n] = true
+		}
+		props := jsonSchema{}
+		if err := orderedMapIter(ts.Properties, func(name string, prop *TypeSchema) error {
+			ps, err := sc.convert(id, prop)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			if nullable[name] {
+				ps = jsonSchema{"anyOf": []jsonSchema{ps, {"type": "null"}}}
+			}
+			props[name] = ps
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+		out["properties"] = props
+		if len(ts.Required) > 0 {
+			out["required"] = ts.Required
+		}
+	case "ref":
+		r := sc.ref(id, ts.Ref)
+		if ts.Description == "" {
+			return r, nil
+		}
+		out["allOf"] = []jsonSchema{r}
+	case "union":
+		var variants []jsonSchema
+		mapping := make(map[string]string, len(ts.Refs))
+		for _, ref := range ts.Refs {
+			r := sc.ref(id, ref)
+			variants = append(variants, r)
+			mapping[resolveRef(id, ref)] = r["$ref"].(string)
+		}
+		if ts.Closed {
+			out["oneOf"] = variants
+			out["discriminator"] = jsonSchema{"propertyName": "$type", "mapping": mapping}
+		} else {
+			// open unions may contain types which aren't known yet; any object with a "$type" is allowed
+			variants = append(variants, jsonSchema{"type": "object", "properties": jsonSchema{"$type": jsonSchema{"type": "string"}}, "required": []string{"$type"}})
+			out["anyOf"] = variants
+		}
+	case "record":
+		if ts.Record == nil {
+			return nil, fmt.Errorf("record without schema in %s", id)
+		}
+		rec, err := sc.convert(id, ts.Record)
+		if err != nil {
+			return nil, err
+		}
+		if props, ok := rec["properties"].(jsonSchema); ok {
+			props["$type"] = jsonSchema{"const": id}
+		}
+		if ts.Key != "" {
+			rec["x-lexicon-recordKey"] = ts.Key
+		}
+		if ts.Description != "" {
+			rec["description"] = ts.Description
+		}
+		return rec, nil
+	default:
+		return nil, fmt.Errorf("unsupported lexicon type %q in %s", ts.Type, id)
+	}
+	return out, nil
+}
+
+// converts all referenced definitions which haven't been converted yet, repeating until references are closed
+func (sc *schemaConverter) convertReferenced(out map[string]jsonSchema) error {
+	for {
+		var pending []string
+		for full := range sc.referenced {
+			if _, ok := out[componentName(full)]; !ok {
+				pending = append(pending, full)
+			}
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+		sort.Strings(pending)
+		for _, full := range pending {
+			def, ok := sc.defs[full]
+			if !ok {
+				return fmt.Errorf("unresolved lexicon reference: %s", full)
+			}
+			js, err := sc.convert(def.id, def)
+			if err != nil {
+				return fmt.Errorf("%s: %w", full, err)
+			}
+			out[componentName(full)] = js
+		}
+	}
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openAPIServer struct {
+	URL string `json:"url"`
+}
+
+type openAPIComponents struct {
+	Schemas         map[string]jsonSchema `json:"schemas"`
+	SecuritySchemes map[string]jsonSchema `json:"securitySchemes"`
+}
+
+type openAPIDoc struct {
+	OpenAPI    string                `json:"openapi"`
+	Info       openAPIInfo           `json:"info"`
+	Servers    []openAPIServer       `json:"servers,omitempty"`
+	Paths      map[string]jsonSchema `json:"paths"`
+	Components openAPIComponents     `json:"components"`
+}
+
+// schema for the body of XRPC error responses
+var xrpcErrorSchema = jsonSchema{
+	"type": "object",
+	"properties": jsonSchema{
+		"error":   jsonSchema{"type": "string"},
+		"message": jsonSchema{"type": "string"},
+	},
+	"required": []string{"error"},
+}
+
+// converts the input or output of an endpoint to an OpenAPI media type map
+func (sc *schemaConverter) content(id, encoding string, schema *TypeSchema) (jsonSchema, error) {
+	var body jsonSchema
+	if schema != nil {
+		var err error
+		body, err = sc.convert(id, schema)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		body = jsonSchema{"type": "string", "format": "binary"}
+	}
+	return jsonSchema{encoding: jsonSchema{"schema": body}}, nil
+}
+
+// converts a query or procedure definition to an OpenAPI operation
+func (sc *schemaConverter) operation(id string, ts *TypeSchema) (jsonSchema, error) {
+	op := jsonSchema{
+		"operationId": id,
+		// group by lexicon namespace (eg, "app.bsky.feed")
+		"tags": []string{id[:strings.LastIndex(id, ".")]},
+	}
+	if ts.Description != "" {
+		op["description"] = ts.Description
+	}
+
+	if ts.Parameters != nil && len(ts.Parameters.Properties) > 0 {
+		required := make(map[string]bool, len(ts.Parameters.Required))
+		for _, r := range ts.Parameters.Required {
+			required[r] = true
+		}
+		var params []jsonSchema
+		if err := orderedMapIter(ts.Parameters.Properties, func(name string, prop *TypeSchema) error {
+			ps, err := sc.convert(id, prop)
+			if err != nil {
+				return fmt.Errorf("parameter %s: %w", name, err)
+			}
+			p := jsonSchema{
+				"name":     name,
+				"in":       "query",
+				"required": required[name],
+				"schema":   ps,
+			}
+			if prop.Description != "" {
+				p["description"] = prop.Description
+			}
+			if prop.Type == "array" {
+				// repeated query parameters, eg "?uris=a&uris=b"
+				p["style"] = "form"
+				p["explode"] = true
+			}
+			params = append(params, p)
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+		op["parameters"] = params
+	}
+
+	if ts.Input != nil {
+		content, err := sc.content(id, ts.Input.Encoding, ts.Input.Schema)
+		if err != nil {
+			return nil, fmt.Errorf("input: %w", err)
+		}
+		rb := jsonSchema{"required": true, "content": content}
+		if ts.Input.Description != "" {
+			rb["description"] = ts.Input.Description
+		}
+		op["requestBody"] = rb
+	}
+
+	ok := jsonSchema{"description": "OK"}
+	if ts.Output != nil {
+		content, err := sc.content(id, ts.Output.Encoding, ts.Output.Schema)
+		if err != nil {
+			return nil, fmt.Errorf("output: %w", err)
+		}
+		ok["content"] = content
+		if ts.Output.Description != "" {
+			ok["description"] = ts.Output.Description
+		}
+	}
+
+	badRequest := jsonSchema{"description": "Bad Request"}
+	errSchema := jsonSchema{"$ref": "#/components/schemas/XRPCError"}
+	if len(ts.Errors) > 0 {
+		names := []string{"InvalidRequest", "ExpiredToken", "InvalidToken"}
+		var desc []string
+		for _, e := range ts.Errors {
+			names = append(names, e.Name)
+			if e.Description != "" {
+				desc = append(desc, fmt.Sprintf("%s: %s", e.Name, e.Description))
+			}
+		}
+		errSchema = jsonSchema{
+			"allOf": []jsonSchema{
+				errSchema,
+				{"properties": jsonSchema{"error": jsonSchema{"type": "string", "enum": names}}},
+			},
+		}
+		if len(desc) > 0 {
+			badRequest["description"] = "Bad Request. " + strings.Join(desc, "; ")
+		}
+	}
+	badRequest["content"] = jsonSchema{EncodingJSON: jsonSchema{"schema": errSchema}}
+	genericErr := func(desc string) jsonSchema {
+		return jsonSchema{
+			"description": desc,
+			"content":     jsonSchema{EncodingJSON: jsonSchema{"schema": jsonSchema{"$ref": "#/components/schemas/XRPCError"}}},
+		}
+	}
+	op["responses"] = jsonSchema{
+		"200": ok,
+		"400": badRequest,
+		"401": genericErr("Unauthorized"),
+		"500": genericErr("Internal Server Error"),
+	}
+	return op, nil
+}
+
+// BuildOpenAPI returns an OpenAPI 3.1 document describing the HTTP (XRPC) endpoints defined by the selected lexicons. Queries become GET operations and procedures become POST operations, under "/xrpc/{nsid}"; subscriptions (WebSocket endpoints) can not be described and are skipped. Every definition referenced by an endpoint is included as a component schema.
+func BuildOpenAPI(schemas []*Schema, cfg OpenAPIConfig) (any, error) {
+	sc := newSchemaConverter(schemas, "#/components/schemas/")
+	doc := openAPIDoc{
+		OpenAPI: "3.1.0",
+		Info: openAPIInfo{
+			Title:   cfg.Title,
+			Version: cfg.Version,
+		},
+		Paths: make(map[string]jsonSchema),
+		Components: openAPIComponents{
+			Schemas: map[string]jsonSchema{"XRPCError": xrpcErrorSchema},
+			SecuritySchemes: map[string]jsonSchema{
+				"bearerAuth": {"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+		},
+	}
+	if doc.Info.Title == "" {
+		doc.Info.Title = "AT Protocol XRPC API"
+	}
+	if doc.Info.Version == "" {
+		doc.Info.Version = "0.0.0"
+	}
+	if cfg.ServerURL != "" {
+		doc.Servers = []openAPIServer{{URL: cfg.ServerURL}}
+	}
+
+	for _, s := range schemas {
+		if !cfg.selected(s.ID) {
+			continue
+		}
+		main, ok := s.Defs["main"]
+		if !ok {
+			continue
+		}
+		var method string
+		switch main.Type {
+		case "query":
+			method = "get"
+		case "procedure":
+			method = "post"
+		default:
+			continue
+		}
+		op, err := sc.operation(s.ID, main)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", s.ID, err)
+		}
+		// lexicons don't declare whether auth is required, so document it as optional
+		op["security"] = []jsonSchema{{}, {"bearerAuth": []string{}}}
+		doc.Paths["/xrpc/"+s.ID] = jsonSchema{method: op}
+	}
+
+	if err := sc.convertReferenced(doc.Components.Schemas); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// BuildJSONSchema returns a JSON Schema (draft 2020-12) document with a definition (under "$defs") for every record and object type in the selected lexicons, plus any definitions they reference.
+func BuildJSONSchema(schemas []*Schema, cfg OpenAPIConfig) (any, error) {
+	sc := newSchemaConverter(schemas, "#/$defs/")
+	defs := make(map[string]jsonSchema)
+	for _, s := range schemas {
+		if !cfg.selected(s.ID) {
+			continue
+		}
+		if err := orderedMapIter(s.Defs, func(name string, def *TypeSchema) error {
+			switch def.Type {
+			case "query", "procedure", "subscription", "permission-set":
+				return nil
+			}
+			sc.referenced[defFullName(s.ID, name)] = true
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+	if err := sc.convertReferenced(defs); err != nil {
+		return nil, err
+	}
+
+	doc := jsonSchema{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"$defs":   defs,
+	}
+	if cfg.Title != "" {
+		doc["title"] = cfg.Title
+	}
+	return doc, nil
+}
+
+// WriteOpenAPI builds an OpenAPI document (see BuildOpenAPI) and writes it as indented JSON
+func WriteOpenAPI(w io.Writer, schemas []*Schema, cfg OpenAPIConfig) error {
+	doc, err := BuildOpenAPI(schemas, cfg)
+	if err != nil {
+		return err
+	}
+	return writeIndentedJSON(w, doc)
+}
+
+// WriteJSONSchema builds a JSON Schema document (see BuildJSONSchema) and writes it as indented JSON
+func WriteJSONSchema(w io.Writer, schemas []*Schema, cfg OpenAPIConfig) error {
+	doc, err := BuildJSONSchema(schemas, cfg)
+	if err != nil {
+		return err
+	}
+	return writeIndentedJSON(w, doc)
+}
+
+func writeIndentedJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(v)
+}
@@ -0,0 +1,216 @@
+package lex
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const testLexicons = `[
+{
+  "lexicon": 1,
+  "id": "com.example.feed.getPosts",
+  "defs": {
+    "main": {
+      "type": "query",
+      "description": "Get posts by URI.",
+      "parameters": {
+        "type": "params",
+        "required": ["uris"],
+        "properties": {
+          "uris": {"type": "array", "items": {"type": "string", "format": "at-uri"}, "maxLength": 25},
+          "limit": {"type": "integer", "minimum": 1, "maximum": 100, "default": 50}
+        }
+      },
+      "output": {
+        "encoding": "application/json",
+        "schema": {
+          "type": "object",
+          "required": ["posts"],
+          "properties": {
+            "posts": {"type": "array", "items": {"type": "ref", "ref": "com.example.feed.defs#postView"}}
+          }
+        }
+      },
+      "errors": [{"name": "NotFound", "description": "no such post"}]
+    }
+  }
+},
+{
+  "lexicon": 1,
+  "id": "com.example.feed.defs",
+  "defs": {
+    "postView": {
+      "type": "object",
+      "required": ["uri", "record"],
+      "nullable": ["parent"],
+      "properties": {
+        "uri": {"type": "string", "format": "at-uri"},
+        "indexedAt": {"type": "string", "format": "datetime"},
+        "record": {"type": "unknown"},
+        "parent": {"type": "ref", "ref": "#postView"},
+        "embed": {"type": "union", "refs": ["com.example.feed.post#image"]}
+      }
+    }
+  }
+},
+{
+  "lexicon": 1,
+  "id": "com.example.feed.post",
+  "defs": {
+    "main": {
+      "type": "record",
+      "key": "tid",
+      "record": {
+        "type": "object",
+        "required": ["text"],
+        "properties": {
+          "text": {"type": "string", "maxLength": 3000, "maxGraphemes": 300},
+          "embed": {"type": "union", "refs": ["#image"], "closed": true}
+        }
+      }
+    },
+    "image": {
+      "type": "object",
+      "required": ["image"],
+      "properties": {
+        "image": {"type": "blob", "accept": ["image/*"]}
+      }
+    }
+  }
+},
+{
+  "lexicon": 1,
+  "id": "com.example.feed.createPost",
+  "defs": {
+    "main": {
+      "type": "procedure",
+      "input": {
+        "encoding": "application/json",
+        "schema": {"type": "object", "required": ["record"], "properties": {"record": {"type": "ref", "ref": "com.example.feed.post"}}}
+      }
+    }
+  }
+},
+{
+  "lexicon": 1,
+  "id": "com.example.other.subscribe",
+  "defs": {
+    "main": {"type": "subscription"}
+  }
+}
+]`
+
+func loadTestLexicons(t *testing.T) []*Schema {
+	var schemas []*Schema
+	if err := json.Unmarshal([]byte(testLexicons), &schemas); err != nil {
+		t.Fatal(err)
+	}
+	return schemas
+}
+
+// round-trips through JSON to get a generic structure for inspection
+func toGeneric(t *testing.T, v any) map[string]any {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out map[string]any
+	if err := json.Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func dig(m map[string]any, path ...string) any {
+	var cur any = m
+	for _, p := range path {
+		next, ok := cur.(map[string]any)
+		if !ok {
+			return nil
+		}
+		cur = next[p]
+	}
+	return cur
+}
+
+func TestBuildOpenAPI(t *testing.T) {
+	doc, err := BuildOpenAPI(loadTestLexicons(t), OpenAPIConfig{Title: "Example", Version: "1.0", Prefixes: []string{"com.example.feed"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := toGeneric(t, doc)
+
+	if dig(m, "paths", "/xrpc/com.example.feed.getPosts", "get", "operationId") != "com.example.feed.getPosts" {
+		t.Fatalf("missing query operation")
+	}
+	if dig(m, "paths", "/xrpc/com.example.feed.createPost", "post", "requestBody", "content", "application/json", "schema", "properties", "record", "$ref") != "#/components/schemas/com.example.feed.post" {
+		t.Fatalf("missing procedure request body ref")
+	}
+	if len(m["paths"].(map[string]any)) != 2 {
+		t.Fatalf("expected only selected query and procedure paths, got %v", m["paths"])
+	}
+
+	params := dig(m, "paths", "/xrpc/com.example.feed.getPosts", "get", "parameters").([]any)
+	if len(params) != 2 {
+		t.Fatalf("expected 2 params, got %d", len(params))
+	}
+	// parameters are sorted by name
+	limit := params[0].(map[string]any)
+	uris := params[1].(map[string]any)
+	if limit["name"] != "limit" || limit["required"] != false || dig(limit, "schema", "maximum") != float64(100) {
+		t.Fatalf("unexpected limit param: %v", limit)
+	}
+	if uris["required"] != true || uris["explode"] != true || dig(uris, "schema", "maxItems") != float64(25) {
+		t.Fatalf("unexpected uris param: %v", uris)
+	}
+
+	errEnum := dig(m, "paths", "/xrpc/com.example.feed.getPosts", "get", "responses", "400", "content", "application/json", "schema", "allOf").([]any)
+	if enum := dig(errEnum[1].(map[string]any), "properties", "error", "enum").([]any); enum[len(enum)-1] != "NotFound" {
+		t.Fatalf("expected NotFound error name, got %v", enum)
+	}
+
+	schemas := dig(m, "components", "schemas").(map[string]any)
+	for _, name := range []string{"XRPCError", "com.example.feed.defs.postView", "com.example.feed.post", "com.example.feed.post.image"} {
+		if _, ok := schemas[name]; !ok {
+			t.Fatalf("missing component schema %s", name)
+		}
+	}
+
+	postView := schemas["com.example.feed.defs.postView"].(map[string]any)
+	if dig(postView, "properties", "indexedAt", "format") != "date-time" {
+		t.Fatalf("datetime format not converted")
+	}
+	if dig(postView, "properties", "parent", "anyOf") == nil {
+		t.Fatalf("nullable property not converted")
+	}
+	// open union allows unknown types
+	if len(dig(postView, "properties", "embed", "anyOf").([]any)) != 2 {
+		t.Fatalf("unexpected open union: %v", dig(postView, "properties", "embed"))
+	}
+
+	post := schemas["com.example.feed.post"].(map[string]any)
+	if dig(post, "properties", "$type", "const") != "com.example.feed.post" {
+		t.Fatalf("record missing $type: %v", post)
+	}
+	if dig(post, "properties", "embed", "discriminator", "mapping", "com.example.feed.post#image") != "#/components/schemas/com.example.feed.post.image" {
+		t.Fatalf("closed union missing discriminator: %v", dig(post, "properties", "embed"))
+	}
+}
+
+func TestBuildJSONSchema(t *testing.T) {
+	doc, err := BuildJSONSchema(loadTestLexicons(t), OpenAPIConfig{Prefixes: []string{"com.example.feed.post"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := toGeneric(t, doc)
+	defs := m["$defs"].(map[string]any)
+	if len(defs) != 2 {
+		t.Fatalf("expected record and image defs, got %v", defs)
+	}
+	if dig(defs, "com.example.feed.post.image", "properties", "image", "properties", "$type", "const") != "blob" {
+		t.Fatalf("blob not converted: %v", defs["com.example.feed.post.image"])
+	}
+	if dig(defs, "com.example.feed.post", "properties", "embed", "oneOf") == nil {
+		t.Fatalf("closed union not converted")
+	}
+}
@@ -7,13 +7,15 @@ import (
 )
 
 type OutputType struct {
-	Encoding string      `json:"encoding"`
-	Schema   *TypeSchema `json:"schema"`
+	Encoding    string      `json:"encoding"`
+	Schema      *TypeSchema `json:"schema"`
+	Description string      `json:"description"`
 }
 
 type InputType struct {
-	Encoding string      `json:"encoding"`
-	Schema   *TypeSchema `json:"schema"`
+	Encoding    string      `json:"encoding"`
+	Schema      *TypeSchema `json:"schema"`
+	Description string      `json:"description"`
 }
 
 // TypeSchema is the content of a lexicon schema file "defs" section.
@@ -48,6 +50,19 @@ type TypeSchema struct {
 	Default any `json:"default"`
 	Minimum any `json:"minimum"`
 	Maximum any `json:"maximum"`
+
+	// these are not used for Go codegen, but are needed for OpenAPI export
+	Format       string     `json:"format"`
+	KnownValues  []string   `json:"knownValues"`
+	MinLength    int        `json:"minLength"`
+	MaxGraphemes int        `json:"maxGraphemes"`
+	Errors       []ErrorDef `json:"errors"`
+}
+
+// ErrorDef is a named error which a query or procedure may return
+type ErrorDef struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
 }
 
 func (s *TypeSchema) WriteRPC(w io.Writer, typename, inputname string) error {
@@ -119,6 +119,16 @@ type PDS struct {
 
 	HourlyEventLimit int64
 	DailyEventLimit  int64
+
+	// Quarantined hosts are still consumed from, but their events are not
+	// applied or passed on to downstream consumers
+	Quarantined      bool
+	QuarantineReason string
+	QuarantinedAt    *time.Time
+
+	// PushOnly hosts connect to the relay to push their event stream (eg,
+	// because they have no public ingress), so the relay doesn't dial them
+	PushOnly bool
 }
 
 func ClientForPds(pds *PDS) *xrpc.Client {
@@ -46,6 +46,7 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"slices"
 
 	"github.com/ipfs/go-cid"
 	cbor "github.com/ipfs/go-ipld-cbor"
@@ -605,7 +606,8 @@ func (mst *MerkleSearchTree) deleteRecurse(ctx context.Context, k string) (*Merk
 			if err != nil {
 				return nil, err
 			}
-			return mst.newTree(append(append(entries[:ix-1], mkTreeEntry(merged)), entries[ix+2:]...)), nil
+			// NOTE: build a new slice; appending to entries[:ix-1] would overwrite this node's entries, which other trees may share
+			return mst.newTree(slices.Concat(entries[:ix-1], []nodeEntry{mkTreeEntry(merged)}, entries[ix+2:])), nil
 		} else {
 			return mst.removeEntry(ctx, ix)
 		}
@@ -884,9 +886,9 @@ func (mst *MerkleSearchTree) appendMerge(ctx context.Context, omst *MerkleSearch
 			return nil, err
 		}
 
-		return mst.newTree(append(append(entries[:len(entries)-1], mkTreeEntry(merged)), tomergeEnts[1:]...)), nil
+		return mst.newTree(slices.Concat(entries[:len(entries)-1], []nodeEntry{mkTreeEntry(merged)}, tomergeEnts[1:])), nil
 	} else {
-		return mst.newTree(append(entries, tomergeEnts...)), nil
+		return mst.newTree(slices.Concat(entries, tomergeEnts)), nil
 	}
 }
 
@@ -984,5 +986,4 @@ func (mst *MerkleSearchTree) WalkLeavesFrom(ctx context.Context, from string, cb
 // TODO: Typescript: MST.walkReachable() -> nodeEntry (iterator)
 // TODO: Typescript: MST.reachableLeaves() -> Leaf[]
 
-// TODO: Typescript: MST.writeToCarStream(car) -> ()
 // TODO: Typescript: MST.cidsForPath(car) -> CID[]
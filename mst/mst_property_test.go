@@ -0,0 +1,361 @@
+package mst
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"math/bits"
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/bluesky-social/indigo/util"
+	"github.com/ipfs/go-cid"
+)
+
+// random keys, with a few collections and variable-length record keys so that many keys share prefixes
+func randPropKeys(r *rand.Rand, n int) []string {
+	colls := []string{"app.bsky.feed.post", "app.bsky.feed.like", "app.bsky.graph.follow", "com.example.record"}
+	const chars = "abcdefghijklmnopqrstuvwxyz234567"
+	seen := make(map[string]bool)
+	var keys []string
+	for len(keys) < n {
+		rkey := make([]byte, 1+r.Intn(13))
+		for i := range rkey {
+			rkey[i] = chars[r.Intn(len(chars))]
+		}
+		k := colls[r.Intn(len(colls))] + "/" + string(rkey)
+		if !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// builds a tree by inserting keys in the given order
+func propTree(t testing.TB, keys []string, vals map[string]cid.Cid) *MerkleSearchTree {
+	t.Helper()
+	ctx := context.Background()
+	tree := NewEmptyMST(util.CborStore(memBs()))
+	for _, k := range keys {
+		nt, err := tree.Add(ctx, k, vals[k], -1)
+		if err != nil {
+			t.Fatalf("adding %s: %v", k, err)
+		}
+		tree = nt
+	}
+	return tree
+}
+
+// checkStructure verifies the structural invariants of a tree, as loaded from its blockstore: every leaf is on the layer given by its key's hash, keys are strictly increasing in order, subtrees are one layer down and not empty, no two subtrees are adjacent, and the root isn't a lone subtree pointer. Returns the keys in order
+func checkStructure(t testing.TB, tree *MerkleSearchTree) []string {
+	t.Helper()
+	ctx := context.Background()
+	root, err := tree.GetPointer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := LoadMST(tree.cst, root)
+
+	var keys []string
+	var walk func(node *MerkleSearchTree, layer int, isRoot bool)
+	walk = func(node *MerkleSearchTree, layer int, isRoot bool) {
+		ents, err := node.getEntries(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ents) == 0 {
+			if !isRoot {
+				t.Fatalf("empty subtree at layer %d", layer)
+			}
+			return
+		}
+		if isRoot && len(ents) == 1 && ents[0].isTree() {
+			t.Fatalf("root is a lone subtree pointer")
+		}
+		if layer < 0 {
+			t.Fatalf("subtree below layer 0")
+		}
+		for i, e := range ents {
+			if e.isTree() {
+				if i > 0 && ents[i-1].isTree() {
+					t.Fatalf("adjacent subtrees at layer %d", layer)
+				}
+				walk(e.Tree, layer-1, false)
+				continue
+			}
+			if h := leadingZerosOnHash(e.Key); h != layer {
+				t.Fatalf("key %s (height %d) on layer %d", e.Key, h, layer)
+			}
+			if len(keys) > 0 && keys[len(keys)-1] >= e.Key {
+				t.Fatalf("key %s after %s", e.Key, keys[len(keys)-1])
+			}
+			keys = append(keys, e.Key)
+		}
+	}
+	layer, err := loaded.getLayer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	walk(loaded, layer, true)
+	return keys
+}
+
+func TestPropertyInsertOrderIndependence(t *testing.T) {
+	for seed := int64(0); seed < 20; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		keys := randPropKeys(r, 1+r.Intn(300))
+		vals := make(map[string]cid.Cid)
+		for _, k := range keys {
+			vals[k] = randCid()
+		}
+
+		tree := propTree(t, keys, vals)
+		expect := mustCidTree(t, tree)
+
+		shuffled := slices.Clone(keys)
+		r.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+		if c := mustCidTree(t, propTree(t, shuffled, vals)); c != expect {
+			t.Fatalf("seed %d: root depends on insertion order: %s != %s", seed, c, expect)
+		}
+
+		sorted := slices.Clone(keys)
+		slices.Sort(sorted)
+		if c := mustCidTree(t, propTree(t, sorted, vals)); c != expect {
+			t.Fatalf("seed %d: sorted insertion gives a different root: %s != %s", seed, c, expect)
+		}
+
+		if got := checkStructure(t, tree); !slices.Equal(got, sorted) {
+			t.Fatalf("seed %d: tree has %d keys, expected %d", seed, len(got), len(sorted))
+		}
+	}
+}
+
+func TestPropertyInsertDeleteRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	for seed := int64(0); seed < 20; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		keys := randPropKeys(r, 2+r.Intn(300))
+		vals := make(map[string]cid.Cid)
+		for _, k := range keys {
+			vals[k] = randCid()
+		}
+		split := r.Intn(len(keys))
+		base, extra := keys[:split], keys[split:]
+
+		baseRoot := mustCidTree(t, propTree(t, base, vals))
+
+		// adding then removing keys gets back to the same tree
+		tree := propTree(t, keys, vals)
+		r.Shuffle(len(extra), func(i, j int) { extra[i], extra[j] = extra[j], extra[i] })
+		for _, k := range extra {
+			nt, err := tree.Delete(ctx, k)
+			if err != nil {
+				t.Fatalf("seed %d: deleting %s: %v", seed, k, err)
+			}
+			tree = nt
+		}
+		if c := mustCidTree(t, tree); c != baseRoot {
+			t.Fatalf("seed %d: root after deletes %s != %s", seed, c, baseRoot)
+		}
+		checkStructure(t, tree)
+
+		// deleting everything gives the empty tree
+		for _, k := range base {
+			nt, err := tree.Delete(ctx, k)
+			if err != nil {
+				t.Fatalf("seed %d: deleting %s: %v", seed, k, err)
+			}
+			tree = nt
+		}
+		if c := mustCidTree(t, tree); c != mustCidTree(t, NewEmptyMST(util.CborStore(memBs()))) {
+			t.Fatalf("seed %d: tree not empty after deleting all keys: %s", seed, c)
+		}
+	}
+}
+
+func TestPropertyUpdateRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	r := rand.New(rand.NewSource(42))
+	keys := randPropKeys(r, 200)
+	vals := make(map[string]cid.Cid)
+	for _, k := range keys {
+		vals[k] = randCid()
+	}
+	tree := propTree(t, keys, vals)
+	root := mustCidTree(t, tree)
+
+	for _, k := range keys[:50] {
+		updated, err := tree.Update(ctx, k, randCid())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mustCidTree(t, updated) == root {
+			t.Fatalf("updating %s didn't change the root", k)
+		}
+		reverted, err := updated.Update(ctx, k, vals[k])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c := mustCidTree(t, reverted); c != root {
+			t.Fatalf("reverting %s: root %s != %s", k, c, root)
+		}
+	}
+}
+
+func TestPropertyLoadRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	r := rand.New(rand.NewSource(7))
+	keys := randPropKeys(r, 500)
+	vals := make(map[string]cid.Cid)
+	for _, k := range keys {
+		vals[k] = randCid()
+	}
+	tree := propTree(t, keys, vals)
+	root := mustCidTree(t, tree)
+
+	// a tree loaded from the blockstore has the same contents and root
+	loaded := LoadMST(tree.cst, root)
+	for _, k := range keys {
+		v, err := loaded.Get(ctx, k)
+		if err != nil {
+			t.Fatalf("getting %s: %v", k, err)
+		}
+		if v != vals[k] {
+			t.Fatalf("value mismatch for %s", k)
+		}
+	}
+	for _, k := range randPropKeys(r, 100) {
+		if _, ok := vals[k]; ok {
+			continue
+		}
+		if _, err := loaded.Get(ctx, k); err != ErrNotFound {
+			t.Fatalf("getting absent key %s: %v", k, err)
+		}
+	}
+	if c := mustCidTree(t, loaded); c != root {
+		t.Fatalf("loaded root %s != %s", c, root)
+	}
+
+	// walking from any key visits exactly the keys at or after it, in order
+	sorted := slices.Clone(keys)
+	slices.Sort(sorted)
+	for _, i := range []int{0, 1, len(sorted) / 2, len(sorted) - 1} {
+		var got []string
+		if err := loaded.WalkLeavesFrom(ctx, sorted[i], func(k string, _ cid.Cid) error {
+			got = append(got, k)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, sorted[i:]) {
+			t.Fatalf("walk from %s: got %d keys, expected %d", sorted[i], len(got), len(sorted)-i)
+		}
+	}
+}
+
+// reference implementation: leading zero bits of the SHA-256 hash, in pairs
+func refLeadingZeros(key []byte) int {
+	hv := sha256.Sum256(key)
+	zeros := 0
+	for _, b := range hv {
+		zeros += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+	return zeros / 2
+}
+
+func FuzzLeadingZerosOnHash(f *testing.F) {
+	for _, s := range []string{"", "asdf", "blue", "88bfafc7", "2a92d355", "884976f5", "app.bsky.feed.post/9adeb165882c"} {
+		f.Add([]byte(s))
+	}
+	f.Fuzz(func(t *testing.T, key []byte) {
+		expect := refLeadingZeros(key)
+		if got := leadingZerosOnHashBytes(key); got != expect {
+			t.Fatalf("leadingZerosOnHashBytes(%q) = %d, expected %d", key, got, expect)
+		}
+		if got := leadingZerosOnHash(string(key)); got != expect {
+			t.Fatalf("leadingZerosOnHash(%q) = %d, expected %d", key, got, expect)
+		}
+	})
+}
+
+func FuzzCountPrefixLen(f *testing.F) {
+	f.Add("abc", "abc")
+	f.Add("", "abc")
+	f.Add("abcde", "abc1")
+	f.Add("jalapeño", "jalapeno")
+	f.Fuzz(func(t *testing.T, a, b string) {
+		n := countPrefixLen(a, b)
+		if n < 0 || n > len(a) || n > len(b) {
+			t.Fatalf("prefix length %d out of range for %q, %q", n, a, b)
+		}
+		if a[:n] != b[:n] {
+			t.Fatalf("%q and %q differ within prefix length %d", a, b, n)
+		}
+		if n < len(a) && n < len(b) && a[n] == b[n] {
+			t.Fatalf("%q and %q share more than prefix length %d", a, b, n)
+		}
+		if m := countPrefixLen(b, a); m != n {
+			t.Fatalf("not symmetric: %d != %d", n, m)
+		}
+	})
+}
+
+// inserts fuzzed keys (one per line; invalid keys are skipped) in several orders, checking the tree is the same each time and well formed
+func FuzzKeyOrdering(f *testing.F) {
+	f.Add("com.example.record/3jzfcijpj2z2a\ncom.example.record/3jzfcijpj2z2b")
+	f.Add("app.bsky.feed.post/a\napp.bsky.feed.post/a0\napp.bsky.feed.post/A\napp.bsky.feed.like/z")
+	f.Add("a/b\na/b.\na/b-\na/b_\na/b:\na/b0\nA/b\n0/b")
+	f.Add(fmt.Sprintf("x/%s\nx/%s", strings.Repeat("a", 250), strings.Repeat("a", 249)))
+	f.Fuzz(func(t *testing.T, input string) {
+		ctx := context.Background()
+		seen := make(map[string]bool)
+		var keys []string
+		for _, k := range strings.Split(input, "\n") {
+			if isValidMstKey(k) && !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+		if len(keys) > 64 {
+			keys = keys[:64]
+		}
+		vals := make(map[string]cid.Cid)
+		for _, k := range keys {
+			vals[k] = strToCid(k)
+		}
+
+		tree := propTree(t, keys, vals)
+		root := mustCidTree(t, tree)
+		sorted := slices.Clone(keys)
+		slices.Sort(sorted)
+		if got := checkStructure(t, tree); !slices.Equal(got, sorted) {
+			t.Fatalf("tree keys %q, expected %q", got, sorted)
+		}
+
+		reversed := slices.Clone(keys)
+		slices.Reverse(reversed)
+		if c := mustCidTree(t, propTree(t, reversed, vals)); c != root {
+			t.Fatalf("reverse insertion gives root %s, expected %s", c, root)
+		}
+		if c := mustCidTree(t, propTree(t, sorted, vals)); c != root {
+			t.Fatalf("sorted insertion gives root %s, expected %s", c, root)
+		}
+
+		for _, k := range keys {
+			nt, err := tree.Delete(ctx, k)
+			if err != nil {
+				t.Fatalf("deleting %s: %v", k, err)
+			}
+			tree = nt
+		}
+		if c := mustCidTree(t, tree); c != mustCidTree(t, NewEmptyMST(util.CborStore(memBs()))) {
+			t.Fatalf("tree not empty after deleting all keys: %s", c)
+		}
+	})
+}
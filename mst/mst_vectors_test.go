@@ -0,0 +1,69 @@
+package mst
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// interop test vectors, from testdata or (if ATPROTO_INTEROP_TESTS is set) a checkout of the atproto-interop-tests repo
+func loadInteropVectors(t *testing.T, name string, v any) {
+	path := filepath.Join("testdata", "interop", name)
+	if dir := os.Getenv("ATPROTO_INTEROP_TESTS"); dir != "" {
+		path = filepath.Join(dir, "mst", name)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+}
+
+func TestVectorsKeyHeights(t *testing.T) {
+	var vectors []struct {
+		Key    string `json:"key"`
+		Height int    `json:"height"`
+	}
+	loadInteropVectors(t, "key_heights.json", &vectors)
+	if len(vectors) == 0 {
+		t.Fatal("no key height vectors")
+	}
+	for _, v := range vectors {
+		if h := leadingZerosOnHash(v.Key); h != v.Height {
+			t.Errorf("height of %q: got %d, expected %d", v.Key, h, v.Height)
+		}
+	}
+}
+
+func TestVectorsCommonPrefix(t *testing.T) {
+	var vectors []struct {
+		Left  string `json:"left"`
+		Right string `json:"right"`
+		Len   int    `json:"len"`
+	}
+	loadInteropVectors(t, "common_prefix.json", &vectors)
+	if len(vectors) == 0 {
+		t.Fatal("no common prefix vectors")
+	}
+	for _, v := range vectors {
+		// prefix lengths of wide characters differ between languages (see TestPrefixLenWide)
+		if !isASCII(v.Left) || !isASCII(v.Right) {
+			continue
+		}
+		if n := countPrefixLen(v.Left, v.Right); n != v.Len {
+			t.Errorf("common prefix of %q and %q: got %d, expected %d", v.Left, v.Right, n, v.Len)
+		}
+	}
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
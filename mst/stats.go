@@ -0,0 +1,99 @@
+package mst
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+)
+
+// TreeStats summarizes the shape of a tree, as computed by Stats.
+type TreeStats struct {
+	// count of nodes (blocks) making up the tree
+	Nodes int
+	// count of leaf entries (keys)
+	Leaves int
+	// total size of the node blocks, not including leaf values
+	NodeBytes int
+	// layer of the root node; leaves at layer 0 are the most common
+	RootLayer int
+	// count of nodes at each layer, indexed by layer
+	LayerNodes []int
+	// largest number of entries in a single node
+	MaxEntries int
+}
+
+// Stats walks the tree rooted at root, like WalkBlocks, and summarizes its
+// shape. Leaf values are not fetched.
+func Stats(ctx context.Context, get func(context.Context, cid.Cid) ([]byte, error), root cid.Cid) (*TreeStats, error) {
+	ts := &TreeStats{RootLayer: -1}
+	if err := ts.walk(ctx, get, root, -1); err != nil {
+		return nil, err
+	}
+	if ts.RootLayer < 0 {
+		ts.RootLayer = 0
+	}
+	return ts, nil
+}
+
+// layer is that of the node, or -1 if not yet known (for the root)
+func (ts *TreeStats) walk(ctx context.Context, get func(context.Context, cid.Cid) ([]byte, error), c cid.Cid, layer int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	raw, err := get(ctx, c)
+	if err != nil {
+		return fmt.Errorf("loading MST node %s: %w", c, err)
+	}
+	var nd nodeData
+	if err := nd.UnmarshalCBOR(bytes.NewReader(raw)); err != nil {
+		return fmt.Errorf("decoding MST node %s: %w", c, err)
+	}
+
+	if layer < 0 {
+		// an empty root is at layer 0; otherwise the first key determines the layer
+		layer = 0
+		if len(nd.Entries) > 0 {
+			layer = leadingZerosOnHashBytes(nd.Entries[0].KeySuffix)
+		}
+		ts.RootLayer = layer
+	}
+	for len(ts.LayerNodes) <= layer {
+		ts.LayerNodes = append(ts.LayerNodes, 0)
+	}
+	ts.LayerNodes[layer]++
+	ts.Nodes++
+	ts.NodeBytes += len(raw)
+	ts.Leaves += len(nd.Entries)
+	if len(nd.Entries) > ts.MaxEntries {
+		ts.MaxEntries = len(nd.Entries)
+	}
+
+	if layer == 0 && (nd.Left != nil || hasSubtrees(nd.Entries)) {
+		return fmt.Errorf("MST node %s at layer 0 has subtrees", c)
+	}
+	if nd.Left != nil {
+		if err := ts.walk(ctx, get, *nd.Left, layer-1); err != nil {
+			return err
+		}
+	}
+	for _, e := range nd.Entries {
+		if e.Tree != nil {
+			if err := ts.walk(ctx, get, *e.Tree, layer-1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func hasSubtrees(entries []treeEntry) bool {
+	for _, e := range entries {
+		if e.Tree != nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package mst
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/bluesky-social/indigo/util"
+	cid "github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+)
+
+func TestStats(t *testing.T) {
+	ctx := context.Background()
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	get := func(ctx context.Context, c cid.Cid) ([]byte, error) {
+		blk, err := bs.Get(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		return blk.RawData(), nil
+	}
+	tree := NewEmptyMST(util.CborStore(bs))
+
+	root, err := tree.GetPointer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err := Stats(ctx, get, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts.Nodes != 1 || ts.Leaves != 0 || ts.RootLayer != 0 {
+		t.Fatalf("unexpected stats for empty tree: %+v", ts)
+	}
+
+	maxLayer := 0
+	for i := 0; i < 500; i++ {
+		k := fmt.Sprintf("app.bsky.feed.post/%06d", i)
+		maxLayer = max(maxLayer, leadingZerosOnHash(k))
+		tree, err = tree.Add(ctx, k, randCid(), -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	root, err = tree.GetPointer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err = Stats(ctx, get, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts.Leaves != 500 {
+		t.Fatalf("expected 500 leaves, got %d", ts.Leaves)
+	}
+	if ts.RootLayer != maxLayer || len(ts.LayerNodes) != maxLayer+1 {
+		t.Fatalf("expected root at layer %d, got %+v", maxLayer, ts)
+	}
+	sum := 0
+	for _, n := range ts.LayerNodes {
+		sum += n
+	}
+	if sum != ts.Nodes || ts.LayerNodes[maxLayer] != 1 {
+		t.Fatalf("inconsistent layer counts: %+v", ts)
+	}
+	if ts.MaxEntries == 0 || ts.NodeBytes == 0 {
+		t.Fatalf("missing node sizes: %+v", ts)
+	}
+}
@@ -0,0 +1,59 @@
+package mst
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+)
+
+// WalkBlocks walks the tree rooted at root, calling cb with the CID and raw
+// bytes of every block: each node (before its children), and each leaf value
+// (in key order). get is used to fetch blocks, eg from a blockstore.
+//
+// Unlike the MerkleSearchTree methods, this doesn't hydrate the tree as it
+// goes: only the nodes on the current path are held in memory, so it is
+// suitable for very large trees. Leaf values which appear under more than one
+// key are visited each time. The walk stops at the first error from get or cb,
+// or when ctx is cancelled.
+//
+// Typescript: MST.writeToCarStream(car) -> ()
+func WalkBlocks(ctx context.Context, get func(context.Context, cid.Cid) ([]byte, error), root cid.Cid, cb func(c cid.Cid, data []byte) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	raw, err := get(ctx, root)
+	if err != nil {
+		return fmt.Errorf("loading MST node %s: %w", root, err)
+	}
+	var nd nodeData
+	if err := nd.UnmarshalCBOR(bytes.NewReader(raw)); err != nil {
+		return fmt.Errorf("decoding MST node %s: %w", root, err)
+	}
+	if err := cb(root, raw); err != nil {
+		return err
+	}
+
+	if nd.Left != nil {
+		if err := WalkBlocks(ctx, get, *nd.Left, cb); err != nil {
+			return err
+		}
+	}
+	for _, e := range nd.Entries {
+		val, err := get(ctx, e.Val)
+		if err != nil {
+			return fmt.Errorf("loading MST leaf %s: %w", e.Val, err)
+		}
+		if err := cb(e.Val, val); err != nil {
+			return err
+		}
+		if e.Tree != nil {
+			if err := WalkBlocks(ctx, get, *e.Tree, cb); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,331 @@
+package pds
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Types of security events recorded in the audit log
+const (
+	AuditLogin           = "login"
+	AuditLoginFailed     = "login_failed"
+	AuditAuthFailed      = "auth_failed"
+	AuditAccountCreated  = "account_created"
+	AuditEmailChange     = "email_change"
+	AuditTwoFactorChange = "two_factor_change"
+	AuditTokenRefresh    = "token_refresh"
+	AuditTokenReuse      = "token_reuse"
+	AuditLogout          = "logout"
+	AuditAdminAction     = "admin_action"
+	AuditAdminDenied     = "admin_denied"
+)
+
+// AuditEvent is a single security-relevant event. Audit events are kept
+// separate from application logs, so that they can be retained and shipped
+// under their own policy.
+type AuditEvent struct {
+	Time time.Time `json:"time"`
+	Type string    `json:"type"`
+	// account the event concerns, if known
+	Did        string `json:"did,omitempty"`
+	Handle     string `json:"handle,omitempty"`
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+	// for failures, why
+	Reason  string            `json:"reason,omitempty"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// AuditSink is a destination for audit events
+type AuditSink interface {
+	Write(evt *AuditEvent) error
+	Close() error
+}
+
+// AuditLog delivers audit events to its sinks, in order, on a background
+// goroutine, so that slow sinks don't hold up request handling. If the
+// queue fills up, events are dropped (and that is logged).
+type AuditLog struct {
+	sinks []AuditSink
+	queue chan *AuditEvent
+	done  chan struct{}
+
+	log *slog.Logger
+}
+
+const auditQueueSize = 4096
+
+func NewAuditLog(sinks ...AuditSink) *AuditLog {
+	al := &AuditLog{
+		sinks: sinks,
+		queue: make(chan *AuditEvent, auditQueueSize),
+		done:  make(chan struct{}),
+		log:   slog.Default().With("system", "pds-audit"),
+	}
+	go al.run()
+	return al
+}
+
+func (al *AuditLog) run() {
+	defer close(al.done)
+	for evt := range al.queue {
+		for _, sink := range al.sinks {
+			if err := sink.Write(evt); err != nil {
+				al.log.Error("failed to write audit event", "type", evt.Type, "sink", fmt.Sprintf("%T", sink), "err", err)
+			}
+		}
+	}
+}
+
+// Log queues an event for delivery to all sinks
+func (al *AuditLog) Log(evt *AuditEvent) {
+	if evt.Time.IsZero() {
+		evt.Time = time.Now().UTC()
+	}
+	select {
+	case al.queue <- evt:
+	default:
+		al.log.Error("audit queue full, dropping event", "type", evt.Type, "did", evt.Did)
+	}
+}
+
+// Close delivers any queued events, then closes all sinks
+func (al *AuditLog) Close() error {
+	close(al.queue)
+	<-al.done
+	var errs []string
+	for _, sink := range al.sinks {
+		if err := sink.Close(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("closing audit sinks: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (s *Server) SetAuditLog(al *AuditLog) {
+	s.auditLog = al
+}
+
+type auditRemoteAddrKey struct{}
+
+// records the client address in the request context, for audit events logged
+// from within handlers
+func auditContextMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		ctx := context.WithValue(req.Context(), auditRemoteAddrKey{}, c.RealIP())
+		c.SetRequest(req.WithContext(ctx))
+		return next(c)
+	}
+}
+
+// remoteAddr returns the client address recorded by auditContextMiddleware
+func remoteAddr(ctx context.Context) string {
+	addr, _ := ctx.Value(auditRemoteAddrKey{}).(string)
+	return addr
+}
+
+// audit records a security event, if an audit log is configured. The
+// remote address is filled in from the request context.
+func (s *Server) audit(ctx context.Context, evt *AuditEvent) {
+	if s.auditLog == nil {
+		return
+	}
+	if evt.RemoteAddr == "" {
+		evt.RemoteAddr = remoteAddr(ctx)
+	}
+	s.auditLog.Log(evt)
+}
+
+// auditAdmin wraps admin routes, recording each admin action and its outcome
+func (s *Server) auditAdmin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		err := next(c)
+		evt := &AuditEvent{
+			Type: AuditAdminAction,
+			Details: map[string]string{
+				"method": c.Request().Method,
+				"path":   c.Path(),
+			},
+		}
+		if did := c.QueryParam("did"); did != "" {
+			evt.Did = did
+		}
+		if err != nil {
+			evt.Reason = err.Error()
+		}
+		s.audit(c.Request().Context(), evt)
+		return err
+	}
+}
+
+// FileAuditSink writes audit events as JSON lines to one file per (UTC)
+// day, named audit-YYYY-MM-DD.jsonl, deleting files older than Retention.
+type FileAuditSink struct {
+	Dir string
+	// files older than this are removed when a new day's file is started. zero keeps them forever
+	Retention time.Duration
+
+	lk  sync.Mutex
+	day string
+	fi  *os.File
+	buf *bufio.Writer
+	now func() time.Time
+}
+
+func NewFileAuditSink(dir string, retention time.Duration) (*FileAuditSink, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileAuditSink{
+		Dir:       dir,
+		Retention: retention,
+		now:       time.Now,
+	}, nil
+}
+
+const auditFilePrefix = "audit-"
+const auditFileSuffix = ".jsonl"
+
+func (fs *FileAuditSink) Write(evt *AuditEvent) error {
+	b, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+
+	fs.lk.Lock()
+	defer fs.lk.Unlock()
+
+	day := fs.now().UTC().Format("2006-01-02")
+	if day != fs.day {
+		if err := fs.rotate(day); err != nil {
+			return err
+		}
+	}
+
+	if _, err := fs.buf.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	// audit events are infrequent and valuable; don't leave them sitting in a buffer
+	return fs.buf.Flush()
+}
+
+// switches to the file for the given day, and prunes expired files
+func (fs *FileAuditSink) rotate(day string) error {
+	if err := fs.closeFile(); err != nil {
+		return err
+	}
+
+	fi, err := os.OpenFile(filepath.Join(fs.Dir, auditFilePrefix+day+auditFileSuffix), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	fs.fi = fi
+	fs.buf = bufio.NewWriter(fi)
+	fs.day = day
+
+	return fs.prune()
+}
+
+func (fs *FileAuditSink) prune() error {
+	if fs.Retention <= 0 {
+		return nil
+	}
+	files, err := fs.Files()
+	if err != nil {
+		return err
+	}
+	cutoff := fs.now().UTC().Add(-fs.Retention).Format("2006-01-02")
+	for _, name := range files {
+		day := strings.TrimSuffix(strings.TrimPrefix(name, auditFilePrefix), auditFileSuffix)
+		if day < cutoff {
+			if err := os.Remove(filepath.Join(fs.Dir, name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Files returns the names of the audit log files in Dir, oldest first
+func (fs *FileAuditSink) Files() ([]string, error) {
+	ents, err := os.ReadDir(fs.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, ent := range ents {
+		if strings.HasPrefix(ent.Name(), auditFilePrefix) && strings.HasSuffix(ent.Name(), auditFileSuffix) {
+			out = append(out, ent.Name())
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func (fs *FileAuditSink) closeFile() error {
+	if fs.fi == nil {
+		return nil
+	}
+	if err := fs.buf.Flush(); err != nil {
+		return err
+	}
+	err := fs.fi.Close()
+	fs.fi = nil
+	fs.buf = nil
+	return err
+}
+
+func (fs *FileAuditSink) Close() error {
+	fs.lk.Lock()
+	defer fs.lk.Unlock()
+	return fs.closeFile()
+}
+
+// WebhookAuditSink POSTs each audit event as JSON to a URL
+type WebhookAuditSink struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewWebhookAuditSink(url string) *WebhookAuditSink {
+	return &WebhookAuditSink{
+		URL:    url,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (ws *WebhookAuditSink) Write(evt *AuditEvent) error {
+	b, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+	resp, err := ws.Client.Post(ws.URL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("audit webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (ws *WebhookAuditSink) Close() error {
+	return nil
+}
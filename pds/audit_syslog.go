@@ -0,0 +1,39 @@
+//go:build !windows && !plan9
+
+package pds
+
+import (
+	"encoding/json"
+	"log/syslog"
+)
+
+// SyslogAuditSink sends audit events as JSON messages to the local syslog
+// daemon, with the AUTH facility
+type SyslogAuditSink struct {
+	w *syslog.Writer
+}
+
+func NewSyslogAuditSink(tag string) (*SyslogAuditSink, error) {
+	w, err := syslog.New(syslog.LOG_AUTH|syslog.LOG_NOTICE, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogAuditSink{w: w}, nil
+}
+
+func (ss *SyslogAuditSink) Write(evt *AuditEvent) error {
+	b, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+	switch evt.Type {
+	case AuditLoginFailed, AuditAuthFailed, AuditTokenReuse, AuditAdminDenied:
+		return ss.w.Warning(string(b))
+	default:
+		return ss.w.Notice(string(b))
+	}
+}
+
+func (ss *SyslogAuditSink) Close() error {
+	return ss.w.Close()
+}
@@ -0,0 +1,21 @@
+//go:build windows || plan9
+
+package pds
+
+import (
+	"fmt"
+)
+
+type SyslogAuditSink struct{}
+
+func NewSyslogAuditSink(tag string) (*SyslogAuditSink, error) {
+	return nil, fmt.Errorf("syslog is not supported on this platform")
+}
+
+func (ss *SyslogAuditSink) Write(evt *AuditEvent) error {
+	return fmt.Errorf("syslog is not supported on this platform")
+}
+
+func (ss *SyslogAuditSink) Close() error {
+	return nil
+}
@@ -0,0 +1,118 @@
+package pds
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/stretchr/testify/assert"
+)
+
+type memAuditSink struct {
+	lk     sync.Mutex
+	events []AuditEvent
+}
+
+func (ms *memAuditSink) Write(evt *AuditEvent) error {
+	ms.lk.Lock()
+	defer ms.lk.Unlock()
+	ms.events = append(ms.events, *evt)
+	return nil
+}
+
+func (ms *memAuditSink) Close() error {
+	return nil
+}
+
+func TestAuditLogSessions(t *testing.T) {
+	assert := assert.New(t)
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+	ctx := context.WithValue(context.Background(), auditRemoteAddrKey{}, "192.0.2.1")
+
+	sink := &memAuditSink{}
+	al := NewAuditLog(sink)
+	s.SetAuditLog(al)
+
+	e := "test@foo.com"
+	p := "password"
+	o, err := s.handleComAtprotoServerCreateAccount(ctx, &atproto.ServerCreateAccount_Input{
+		Email:    &e,
+		Password: &p,
+		Handle:   "testman.test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.handleComAtprotoServerCreateSession(ctx, &atproto.ServerCreateSession_Input{Identifier: o.Handle, Password: "wrong"})
+	assert.Error(err)
+	_, err = s.handleComAtprotoServerCreateSession(ctx, &atproto.ServerCreateSession_Input{Identifier: o.Handle, Password: p})
+	assert.NoError(err)
+
+	u, err := s.lookupUserByDid(ctx, o.Did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(s.handleComAtprotoServerUpdateEmail(context.WithValue(ctx, "user", u), &atproto.ServerUpdateEmail_Input{Email: "new@foo.com"}))
+
+	assert.NoError(al.Close())
+
+	var types []string
+	for _, evt := range sink.events {
+		types = append(types, evt.Type)
+		assert.Equal(o.Did, evt.Did)
+		assert.Equal("192.0.2.1", evt.RemoteAddr)
+		assert.False(evt.Time.IsZero())
+	}
+	assert.Equal([]string{AuditAccountCreated, AuditLoginFailed, AuditLogin, AuditEmailChange}, types)
+	assert.Equal("invalid password", sink.events[1].Reason)
+	assert.Equal("new@foo.com", sink.events[3].Details["new"])
+}
+
+func TestFileAuditSinkRetention(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+
+	fs, err := NewFileAuditSink(dir, 48*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	fs.now = func() time.Time { return now }
+
+	for i := 0; i < 4; i++ {
+		assert.NoError(fs.Write(&AuditEvent{Time: now, Type: AuditLogin, Did: "did:plc:abc"}))
+		assert.NoError(fs.Write(&AuditEvent{Time: now, Type: AuditLogout, Did: "did:plc:abc"}))
+		now = now.Add(24 * time.Hour)
+	}
+	assert.NoError(fs.Close())
+
+	// the oldest day has been pruned
+	files, err := fs.Files()
+	assert.NoError(err)
+	assert.Equal([]string{"audit-2024-03-02.jsonl", "audit-2024-03-03.jsonl", "audit-2024-03-04.jsonl"}, files)
+
+	fi, err := os.Open(filepath.Join(dir, files[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fi.Close()
+	var lines []AuditEvent
+	scan := bufio.NewScanner(fi)
+	for scan.Scan() {
+		var evt AuditEvent
+		assert.NoError(json.Unmarshal(scan.Bytes(), &evt))
+		lines = append(lines, evt)
+	}
+	if assert.Len(lines, 2) {
+		assert.Equal(AuditLogin, lines[0].Type)
+		assert.Equal(AuditLogout, lines[1].Type)
+	}
+}
@@ -8,10 +8,41 @@ import (
 	"time"
 
 	"github.com/bluesky-social/indigo/xrpc"
+	gojwt "github.com/golang-jwt/jwt"
 	"github.com/lestrrat-go/jwx/v2/jwa"
 	"github.com/lestrrat-go/jwx/v2/jwt"
+	"gorm.io/gorm"
 )
 
+const refreshTokenLifetime = 7 * 24 * time.Hour
+
+// RefreshToken records every refresh token issued. Tokens are single-use:
+// refreshing a session marks the presented token used and issues a new one in
+// the same session family. A family is the chain of tokens descending from
+// one login.
+type RefreshToken struct {
+	gorm.Model
+	// the token's jti claim
+	Token     string `gorm:"uniqueIndex"`
+	Did       string `gorm:"index"`
+	Family    string `gorm:"index"`
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	Revoked   bool
+}
+
+var (
+	ErrInvalidRefreshToken = fmt.Errorf("invalid refresh token")
+	ErrRefreshTokenReused  = fmt.Errorf("refresh token has already been used")
+	ErrSessionRevoked      = fmt.Errorf("session has been revoked")
+)
+
+func randomTokenID() string {
+	rval := make([]byte, 16)
+	rand.Read(rval)
+	return base64.RawURLEncoding.EncodeToString(rval)
+}
+
 func makeToken(subject string, scope string, exp time.Time) jwt.Token {
 	tok := jwt.New()
 	tok.Set("scope", scope)
@@ -22,13 +53,22 @@ func makeToken(subject string, scope string, exp time.Time) jwt.Token {
 	return tok
 }
 
+// createAuthTokenForUser starts a new session (and session family) for the user
 func (s *Server) createAuthTokenForUser(ctx context.Context, handle, did string) (*xrpc.AuthInfo, error) {
+	return s.issueSessionTokens(ctx, handle, did, randomTokenID())
+}
+
+// issueSessionTokens creates an access/refresh token pair in the given session
+// family, and records the refresh token
+func (s *Server) issueSessionTokens(ctx context.Context, handle, did, family string) (*xrpc.AuthInfo, error) {
 	accessTok := makeToken(did, "com.atproto.access", time.Now().Add(24*time.Hour))
-	refreshTok := makeToken(did, "com.atproto.refresh", time.Now().Add(7*24*time.Hour))
+	accessTok.Set("fam", family)
 
-	rval := make([]byte, 10)
-	rand.Read(rval)
-	refreshTok.Set("jti", base64.StdEncoding.EncodeToString(rval))
+	exp := time.Now().Add(refreshTokenLifetime)
+	refreshTok := makeToken(did, "com.atproto.refresh", exp)
+	jti := randomTokenID()
+	refreshTok.Set("jti", jti)
+	refreshTok.Set("fam", family)
 
 	accSig, err := jwt.Sign(accessTok, jwt.WithKey(jwa.HS256, s.jwtSigningKey))
 	if err != nil {
@@ -40,6 +80,15 @@ func (s *Server) createAuthTokenForUser(ctx context.Context, handle, did string)
 		return nil, fmt.Errorf("signing refresh token: %w", err)
 	}
 
+	if err := s.db.Create(&RefreshToken{
+		Token:     jti,
+		Did:       did,
+		Family:    family,
+		ExpiresAt: exp,
+	}).Error; err != nil {
+		return nil, fmt.Errorf("recording refresh token: %w", err)
+	}
+
 	return &xrpc.AuthInfo{
 		AccessJwt:  string(accSig),
 		RefreshJwt: string(refSig),
@@ -67,3 +116,96 @@ func (s *Server) createCrossServerAuthToken(ctx context.Context, otherpds string
 		AccessJwt: string(accSig),
 	}, nil
 }
+
+func tokenClaim(tok *gojwt.Token, name string) string {
+	claims, ok := tok.Claims.(gojwt.MapClaims)
+	if !ok {
+		return ""
+	}
+	v, _ := claims[name].(string)
+	return v
+}
+
+// rotateRefreshToken consumes the presented refresh token and issues a new
+// token pair in the same session family.
+//
+// A refresh token that has already been used means that two parties hold it,
+// one of whom stole it. We can't tell which, so the whole family is revoked,
+// logging out both the legitimate client and the attacker.
+func (s *Server) rotateRefreshToken(ctx context.Context, u *User, tok *gojwt.Token) (*xrpc.AuthInfo, error) {
+	jti := tokenClaim(tok, "jti")
+	if jti == "" {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	now := time.Now()
+	res := s.db.Model(&RefreshToken{}).
+		Where("token = ? AND did = ? AND used_at IS NULL AND NOT revoked", jti, u.Did).
+		Update("used_at", now)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		var rt RefreshToken
+		if err := s.db.Find(&rt, "token = ? AND did = ?", jti, u.Did).Error; err != nil {
+			return nil, err
+		}
+		switch {
+		case rt.ID == 0:
+			return nil, ErrInvalidRefreshToken
+		case rt.Revoked:
+			return nil, ErrSessionRevoked
+		default:
+			s.log.Warn("security event: refresh token reuse detected, revoking session family",
+				"did", u.Did, "family", rt.Family, "token", rt.Token, "firstUsed", rt.UsedAt)
+			if err := s.revokeSessionFamily(ctx, rt.Family); err != nil {
+				return nil, err
+			}
+			s.audit(ctx, &AuditEvent{
+				Type:    AuditTokenReuse,
+				Did:     u.Did,
+				Handle:  u.Handle,
+				Reason:  "refresh token reused; session family revoked",
+				Details: map[string]string{"family": rt.Family},
+			})
+			return nil, ErrRefreshTokenReused
+		}
+	}
+
+	var rt RefreshToken
+	if err := s.db.First(&rt, "token = ?", jti).Error; err != nil {
+		return nil, err
+	}
+
+	out, err := s.issueSessionTokens(ctx, u.Handle, u.Did, rt.Family)
+	if err != nil {
+		return nil, err
+	}
+	s.audit(ctx, &AuditEvent{Type: AuditTokenRefresh, Did: u.Did, Handle: u.Handle})
+	return out, nil
+}
+
+// revokeSessionFamily invalidates every refresh token (and, via the fam claim,
+// every access token) descending from the same login
+func (s *Server) revokeSessionFamily(ctx context.Context, family string) error {
+	return s.db.Model(&RefreshToken{}).Where("family = ?", family).Update("revoked", true).Error
+}
+
+// checkSessionFamily rejects tokens belonging to a revoked session family.
+// Tokens issued without a family are let through.
+func (s *Server) checkSessionFamily(ctx context.Context, tok *gojwt.Token) error {
+	family := tokenClaim(tok, "fam")
+	if family == "" {
+		return nil
+	}
+
+	var count int64
+	if err := s.db.Model(&RefreshToken{}).Where("family = ? AND revoked", family).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrSessionRevoked
+	}
+	return nil
+}
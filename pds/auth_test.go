@@ -0,0 +1,76 @@
+package pds
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	gojwt "github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefreshTokenRotation(t *testing.T) {
+	assert := assert.New(t)
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	e := "test@foo.com"
+	p := "password"
+	o, err := s.handleComAtprotoServerCreateAccount(ctx, &atproto.ServerCreateAccount_Input{
+		Email:    &e,
+		Password: &p,
+		Handle:   "testman.test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := s.lookupUserByDid(ctx, o.Did)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parse := func(raw string) *gojwt.Token {
+		tok, err := gojwt.Parse(raw, func(*gojwt.Token) (any, error) { return s.jwtSigningKey, nil })
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tok
+	}
+	refresh := func(raw string) (string, string, error) {
+		tok := parse(raw)
+		ctx := context.WithValue(ctx, "user", u)
+		ctx = context.WithValue(ctx, "authScope", "com.atproto.refresh")
+		ctx = context.WithValue(ctx, "token", tok)
+		out, err := s.handleComAtprotoServerRefreshSession(ctx)
+		if err != nil {
+			return "", "", err
+		}
+		return out.AccessJwt, out.RefreshJwt, nil
+	}
+
+	first := o.RefreshJwt
+	access, second, err := refresh(first)
+	assert.NoError(err)
+	assert.NotEqual(first, second)
+	assert.NoError(s.checkSessionFamily(ctx, parse(access)))
+
+	// a separate login is its own family, unaffected by what follows
+	other, err := s.handleComAtprotoServerCreateSession(ctx, &atproto.ServerCreateSession_Input{
+		Identifier: o.Handle,
+		Password:   "password",
+	})
+	assert.NoError(err)
+
+	// replaying the consumed token revokes the family, including the
+	// legitimately rotated token and its access token
+	_, _, err = refresh(first)
+	assert.ErrorIs(err, ErrRefreshTokenReused)
+	_, _, err = refresh(second)
+	assert.ErrorIs(err, ErrSessionRevoked)
+	assert.ErrorIs(s.checkSessionFamily(ctx, parse(access)), ErrSessionRevoked)
+
+	_, _, err = refresh(other.RefreshJwt)
+	assert.NoError(err)
+	assert.NoError(s.checkSessionFamily(ctx, parse(other.AccessJwt)))
+}
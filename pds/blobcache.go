@@ -0,0 +1,242 @@
+package pds
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+	"golang.org/x/sync/singleflight"
+)
+
+// errBlobNotCached means a blob was fetched, but couldn't be kept in the cache
+// (eg because it is larger than the whole cache), so must be read from the
+// source directly
+var errBlobNotCached = errors.New("blob not cached")
+
+const blobCacheTmpPrefix = ".tmp-"
+
+// blobCache keeps blobs from a (remote) source in a directory, as files named
+// <did>/<cid>, evicting the least recently used once they exceed a total size
+type blobCache struct {
+	dir      string
+	maxBytes int64
+	src      BlobSource
+	fetches  singleflight.Group
+
+	lk sync.Mutex
+	// most recently used at the front
+	lru     *list.List
+	entries map[string]*list.Element
+	size    int64
+}
+
+type blobCacheEntry struct {
+	key  string
+	size int64
+}
+
+func newBlobCache(dir string, maxBytes int64, src BlobSource) (*blobCache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	bc := &blobCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		src:      src,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+	if err := bc.load(); err != nil {
+		return nil, err
+	}
+	return bc, nil
+}
+
+// load indexes blobs cached by a previous run, ordered by modification time
+// (which is bumped on each use)
+func (bc *blobCache) load() error {
+	type found struct {
+		key   string
+		size  int64
+		mtime time.Time
+	}
+	var all []found
+	err := filepath.WalkDir(bc.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), blobCacheTmpPrefix) {
+			// left behind by an interrupted fetch
+			return os.Remove(path)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		key, err := filepath.Rel(bc.dir, path)
+		if err != nil {
+			return err
+		}
+		all = append(all, found{key: filepath.ToSlash(key), size: info.Size(), mtime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].mtime.Before(all[j].mtime) })
+
+	bc.lk.Lock()
+	defer bc.lk.Unlock()
+	for _, f := range all {
+		bc.entries[f.key] = bc.lru.PushFront(&blobCacheEntry{key: f.key, size: f.size})
+		bc.size += f.size
+	}
+	bc.evict()
+	return nil
+}
+
+func (bc *blobCache) path(key string) string {
+	return filepath.Join(bc.dir, filepath.FromSlash(key))
+}
+
+// get opens a cached blob, fetching it from the source first if needed.
+// Returns errBlobNotCached if the blob can't be cached
+func (bc *blobCache) get(ctx context.Context, did string, c cid.Cid) (*os.File, error) {
+	key := did + "/" + c.String()
+	if f := bc.open(key); f != nil {
+		blobCacheRequests.WithLabelValues("hit").Inc()
+		return f, nil
+	}
+
+	// concurrent requests for the same blob share one fetch, which isn't
+	// cancelled if the request which started it goes away
+	_, err, _ := bc.fetches.Do(key, func() (any, error) {
+		return nil, bc.fetch(context.WithoutCancel(ctx), did, c, key)
+	})
+	if err != nil {
+		blobCacheRequests.WithLabelValues("error").Inc()
+		return nil, err
+	}
+	blobCacheRequests.WithLabelValues("miss").Inc()
+	if f := bc.open(key); f != nil {
+		return f, nil
+	}
+	return nil, errBlobNotCached
+}
+
+// open returns the cached file for key, or nil if it isn't cached
+func (bc *blobCache) open(key string) *os.File {
+	bc.lk.Lock()
+	el, ok := bc.entries[key]
+	if ok {
+		bc.lru.MoveToFront(el)
+	}
+	bc.lk.Unlock()
+	if !ok {
+		return nil
+	}
+
+	// once open, the file stays readable even if it is evicted
+	f, err := os.Open(bc.path(key))
+	if err != nil {
+		bc.lk.Lock()
+		if bc.entries[key] == el {
+			bc.remove(el)
+		}
+		bc.lk.Unlock()
+		return nil
+	}
+	now := time.Now()
+	_ = os.Chtimes(f.Name(), now, now)
+	return f
+}
+
+// fetch downloads a blob into the cache, checking it matches its CID
+func (bc *blobCache) fetch(ctx context.Context, did string, c cid.Cid, key string) error {
+	rc, err := bc.src.GetBlob(ctx, did, c)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	dir := filepath.Dir(bc.path(key))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, blobCacheTmpPrefix)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	size, err := io.Copy(tmp, rc)
+	if err != nil {
+		return fmt.Errorf("fetching blob: %w", err)
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	pref := c.Prefix()
+	sum, err := mh.SumStream(tmp, pref.MhType, pref.MhLength)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(sum, c.Hash()) {
+		return fmt.Errorf("blob from source does not match cid %s", c)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if size > bc.maxBytes {
+		return nil
+	}
+	if err := os.Rename(tmp.Name(), bc.path(key)); err != nil {
+		return err
+	}
+
+	bc.lk.Lock()
+	defer bc.lk.Unlock()
+	if el, ok := bc.entries[key]; ok {
+		bc.remove(el)
+	}
+	bc.entries[key] = bc.lru.PushFront(&blobCacheEntry{key: key, size: size})
+	bc.size += size
+	bc.evict()
+	return nil
+}
+
+// evict removes the least recently used blobs until the cache fits within
+// maxBytes. Must be called with lk held
+func (bc *blobCache) evict() {
+	for bc.size > bc.maxBytes {
+		el := bc.lru.Back()
+		if el == nil {
+			break
+		}
+		bc.remove(el)
+		_ = os.Remove(bc.path(el.Value.(*blobCacheEntry).key))
+	}
+	blobCacheBytes.Set(float64(bc.size))
+}
+
+// must be called with lk held
+func (bc *blobCache) remove(el *list.Element) {
+	ent := el.Value.(*blobCacheEntry)
+	bc.lru.Remove(el)
+	delete(bc.entries, ent.key)
+	bc.size -= ent.size
+	blobCacheBytes.Set(float64(bc.size))
+}
@@ -0,0 +1,222 @@
+package pds
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/ipfs/go-cid"
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel"
+)
+
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobSource provides blob contents. This PDS does not store uploaded blobs
+// itself; blobs are served from (and take-out archives include blobs from) a
+// store managed alongside it.
+type BlobSource interface {
+	// Returns ErrBlobNotFound if the blob doesn't exist. The reader may also
+	// implement io.Seeker, in which case it is served directly
+	GetBlob(ctx context.Context, did string, c cid.Cid) (io.ReadCloser, error)
+}
+
+// DirBlobSource reads blobs from files named Dir/<did>/<cid>
+type DirBlobSource struct {
+	Dir string
+}
+
+func (ds *DirBlobSource) GetBlob(ctx context.Context, did string, c cid.Cid) (io.ReadCloser, error) {
+	f, err := os.Open(filepath.Join(ds.Dir, did, c.String()))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrBlobNotFound
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// URLBlobSource fetches blobs from BaseURL/<did>/<cid>, eg an S3 bucket or a
+// CDN in front of one
+type URLBlobSource struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+func (us *URLBlobSource) GetBlob(ctx context.Context, did string, c cid.Cid) (io.ReadCloser, error) {
+	u := strings.TrimSuffix(us.BaseURL, "/") + "/" + did + "/" + c.String()
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := us.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching blob: %w", err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound, http.StatusForbidden:
+		// S3 returns 403 for missing keys unless the client can list the bucket
+		resp.Body.Close()
+		return nil, ErrBlobNotFound
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("fetching blob: unexpected status %d", resp.StatusCode)
+	}
+}
+
+type BlobConfig struct {
+	Source BlobSource
+	// Directory for a local cache of blobs read from Source. Intended for
+	// remote sources; range requests (eg for video) are then served from disk
+	// rather than refetching the whole blob each time. Optional
+	CacheDir string
+	// Maximum total size of cached blobs. Defaults to 1GiB
+	CacheMaxBytes int64
+}
+
+// SetBlobConfig enables com.atproto.sync.getBlob, serving blobs from
+// cfg.Source
+func (s *Server) SetBlobConfig(cfg *BlobConfig) error {
+	if cfg.Source == nil {
+		return fmt.Errorf("blob source must be set")
+	}
+	if cfg.CacheDir != "" {
+		if cfg.CacheMaxBytes <= 0 {
+			cfg.CacheMaxBytes = 1 << 30
+		}
+		bc, err := newBlobCache(cfg.CacheDir, cfg.CacheMaxBytes, cfg.Source)
+		if err != nil {
+			return fmt.Errorf("setting up blob cache: %w", err)
+		}
+		s.blobCache = bc
+	}
+	s.blobConfig = cfg
+	return nil
+}
+
+// bytesBlob is a blob read fully into memory, for sources which can't seek
+type bytesBlob struct {
+	*bytes.Reader
+}
+
+func (bytesBlob) Close() error { return nil }
+
+// openBlob returns a seekable reader for a blob, so that range requests can
+// be served
+func (s *Server) openBlob(ctx context.Context, did string, c cid.Cid) (io.ReadSeekCloser, error) {
+	if s.blobConfig == nil {
+		return nil, echo.NewHTTPError(http.StatusNotImplemented, "blob storage not available")
+	}
+	if _, err := syntax.ParseDID(did); err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "invalid did")
+	}
+	if _, err := s.lookupUserByDid(ctx, did); err != nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "repo not found")
+	}
+
+	if s.blobCache != nil {
+		f, err := s.blobCache.get(ctx, did, c)
+		if err == nil {
+			return f, nil
+		}
+		if !errors.Is(err, errBlobNotCached) {
+			return nil, blobError(err)
+		}
+	}
+
+	rc, err := s.blobConfig.Source.GetBlob(ctx, did, c)
+	if err != nil {
+		return nil, blobError(err)
+	}
+	if rsc, ok := rc.(io.ReadSeekCloser); ok {
+		return rsc, nil
+	}
+	defer rc.Close()
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	return bytesBlob{bytes.NewReader(b)}, nil
+}
+
+func blobError(err error) error {
+	if errors.Is(err, ErrBlobNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "blob not found")
+	}
+	return err
+}
+
+func (s *Server) handleComAtprotoSyncGetBlob(ctx context.Context, cidStr string, did string) (io.Reader, error) {
+	c, err := cid.Decode(cidStr)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "invalid cid")
+	}
+	return s.openBlob(ctx, did, c)
+}
+
+// HandleGetBlob serves com.atproto.sync.getBlob, with support for range
+// requests and conditional requests. Blobs are content addressed, so the CID
+// is a strong ETag
+func (s *Server) HandleGetBlob(c echo.Context) error {
+	ctx, span := otel.Tracer("server").Start(c.Request().Context(), "HandleGetBlob")
+	defer span.End()
+
+	bc, err := cid.Decode(c.QueryParam("cid"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid cid")
+	}
+	etag := `"` + bc.String() + `"`
+
+	h := c.Response().Header()
+	h.Set("ETag", etag)
+	// blobs never change, but may be taken down, so caches must revalidate
+	// eventually
+	h.Set("Cache-Control", "public, max-age=86400")
+	if etagMatches(c.Request().Header.Get("If-None-Match"), etag) {
+		if _, err := s.lookupUserByDid(ctx, c.QueryParam("did")); err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, "repo not found")
+		}
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	blob, err := s.openBlob(ctx, c.QueryParam("did"), bc)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	// the content type is sniffed, so make sure browsers can't be tricked into
+	// running anything
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	http.ServeContent(c.Response(), c.Request(), "", time.Time{}, blob)
+	return nil
+}
+
+// reports whether an If-None-Match header matches an ETag, using the weak
+// comparison as in RFC 9110
+func etagMatches(header, etag string) bool {
+	for _, v := range strings.Split(header, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" || strings.TrimPrefix(v, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
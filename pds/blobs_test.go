@@ -0,0 +1,179 @@
+package pds
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/labstack/echo/v4"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/assert"
+)
+
+func testBlobCid(t *testing.T, b []byte) cid.Cid {
+	c, err := cid.NewPrefixV1(cid.Raw, mh.SHA2_256).Sum(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// serves blobs from memory, without seeking, like a remote source
+type memBlobSource struct {
+	blobs map[string][]byte
+	reads int
+}
+
+func (ms *memBlobSource) GetBlob(ctx context.Context, did string, c cid.Cid) (io.ReadCloser, error) {
+	b, ok := ms.blobs[did+"/"+c.String()]
+	if !ok {
+		return nil, ErrBlobNotFound
+	}
+	ms.reads++
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+func TestHandleGetBlob(t *testing.T) {
+	assert := assert.New(t)
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	did := "did:plc:blobowner"
+	if err := s.db.Create(&User{Did: did, Handle: "blobs.test"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	blob := []byte("0123456789abcdef")
+	bc := testBlobCid(t, blob)
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, did), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, did, bc.String()), blob, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ec := echo.New()
+	ec.GET("/xrpc/com.atproto.sync.getBlob", s.HandleGetBlob)
+	get := func(did, c string, hdr map[string]string) *httptest.ResponseRecorder {
+		q := url.Values{"did": {did}, "cid": {c}}
+		req := httptest.NewRequest("GET", "/xrpc/com.atproto.sync.getBlob?"+q.Encode(), nil)
+		for k, v := range hdr {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		ec.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// not available until a source is configured
+	assert.Equal(http.StatusNotImplemented, get(did, bc.String(), nil).Code)
+	assert.NoError(s.SetBlobConfig(&BlobConfig{Source: &DirBlobSource{Dir: dir}}))
+
+	rec := get(did, bc.String(), nil)
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Equal(blob, rec.Body.Bytes())
+	etag := rec.Header().Get("ETag")
+	assert.Equal(`"`+bc.String()+`"`, etag)
+	assert.Equal("bytes", rec.Header().Get("Accept-Ranges"))
+	assert.Equal("nosniff", rec.Header().Get("X-Content-Type-Options"))
+
+	rec = get(did, bc.String(), map[string]string{"Range": "bytes=4-7"})
+	assert.Equal(http.StatusPartialContent, rec.Code)
+	assert.Equal("4567", rec.Body.String())
+	assert.Equal("bytes 4-7/16", rec.Header().Get("Content-Range"))
+
+	rec = get(did, bc.String(), map[string]string{"Range": "bytes=100-"})
+	assert.Equal(http.StatusRequestedRangeNotSatisfiable, rec.Code)
+
+	rec = get(did, bc.String(), map[string]string{"If-None-Match": `"bafyother", ` + etag})
+	assert.Equal(http.StatusNotModified, rec.Code)
+	assert.Empty(rec.Body.Bytes())
+
+	// a stale If-Range gets the whole blob
+	rec = get(did, bc.String(), map[string]string{"Range": "bytes=4-7", "If-Range": `"bafyother"`})
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Equal(blob, rec.Body.Bytes())
+
+	assert.Equal(http.StatusBadRequest, get(did, "notacid", nil).Code)
+	assert.Equal(http.StatusNotFound, get("did:plc:nobody", bc.String(), nil).Code)
+	assert.Equal(http.StatusNotFound, get(did, testBlobCid(t, []byte("missing")).String(), nil).Code)
+}
+
+func TestBlobCache(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	did := "did:plc:blobowner"
+	src := &memBlobSource{blobs: make(map[string][]byte)}
+	var cids []cid.Cid
+	for _, b := range []string{"aaaaaaaaaa", "bbbbbbbbbb", "cccccccccc"} {
+		c := testBlobCid(t, []byte(b))
+		src.blobs[did+"/"+c.String()] = []byte(b)
+		cids = append(cids, c)
+	}
+	read := func(bc *blobCache, c cid.Cid) string {
+		f, err := bc.get(ctx, did, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		b, err := io.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	dir := t.TempDir()
+	bc, err := newBlobCache(dir, 25, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal("aaaaaaaaaa", read(bc, cids[0]))
+	assert.Equal("aaaaaaaaaa", read(bc, cids[0]))
+	assert.Equal(1, src.reads)
+
+	// the least recently used blob is evicted
+	assert.Equal("bbbbbbbbbb", read(bc, cids[1]))
+	assert.Equal("aaaaaaaaaa", read(bc, cids[0]))
+	assert.Equal("cccccccccc", read(bc, cids[2]))
+	assert.Equal(3, src.reads)
+	assert.Equal(int64(20), bc.size)
+	_, err = os.Stat(filepath.Join(dir, did, cids[1].String()))
+	assert.True(os.IsNotExist(err))
+
+	// the index is rebuilt from disk
+	bc, err = newBlobCache(dir, 25, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(int64(20), bc.size)
+	assert.Equal("aaaaaaaaaa", read(bc, cids[0]))
+	assert.Equal(3, src.reads)
+
+	// blobs larger than the cache are passed through
+	big := []byte("dddddddddddddddddddddddddddddd")
+	bigCid := testBlobCid(t, big)
+	src.blobs[did+"/"+bigCid.String()] = big
+	_, err = bc.get(ctx, did, bigCid)
+	assert.ErrorIs(err, errBlobNotCached)
+	assert.Equal(int64(20), bc.size)
+
+	// content which doesn't match the cid isn't cached
+	bad := testBlobCid(t, []byte("expected"))
+	src.blobs[did+"/"+bad.String()] = []byte("something else")
+	_, err = bc.get(ctx, did, bad)
+	assert.Error(err)
+	_, err = os.Stat(filepath.Join(dir, did, bad.String()))
+	assert.True(os.IsNotExist(err))
+
+	_, err = bc.get(ctx, did, testBlobCid(t, []byte("missing")))
+	assert.ErrorIs(err, ErrBlobNotFound)
+}
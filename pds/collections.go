@@ -0,0 +1,125 @@
+package pds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	comatprototypes "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+var ErrCollectionNotAllowed = errors.New("collection not allowed")
+
+// CollectionError is returned by writes to a record collection which the
+// server's CollectionPolicy doesn't accept
+type CollectionError struct {
+	Collection string
+}
+
+func (ce *CollectionError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrCollectionNotAllowed, ce.Collection)
+}
+
+func (ce *CollectionError) Unwrap() error {
+	return ErrCollectionNotAllowed
+}
+
+// CollectionPolicy restricts which record collections accounts on this
+// server may write to. Entries are either an exact NSID, or a prefix ending
+// in ".*" which matches every NSID under it (eg, "app.bsky.*").
+//
+// If Allow is empty, every collection not denied is accepted. Deny takes
+// precedence over Allow. Deletes are always accepted, so that accounts can
+// clean up records in collections which were blocked after being written.
+type CollectionPolicy struct {
+	Allow []string
+	Deny  []string
+}
+
+func validateCollectionPattern(p string) error {
+	if prefix, ok := strings.CutSuffix(p, ".*"); ok {
+		// the prefix needs to be a plausible NSID authority, which we check by
+		// completing it with a name
+		if _, err := syntax.ParseNSID(prefix + ".x"); err != nil {
+			return fmt.Errorf("invalid collection pattern %q: %w", p, err)
+		}
+		return nil
+	}
+	if _, err := syntax.ParseNSID(p); err != nil {
+		return fmt.Errorf("invalid collection pattern %q: %w", p, err)
+	}
+	return nil
+}
+
+func matchCollection(patterns []string, nsid string) bool {
+	for _, p := range patterns {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if strings.HasPrefix(nsid, prefix) {
+				return true
+			}
+		} else if p == nsid {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows reports whether the policy accepts writes to a collection
+func (cp *CollectionPolicy) Allows(nsid string) bool {
+	if matchCollection(cp.Deny, nsid) {
+		return false
+	}
+	return len(cp.Allow) == 0 || matchCollection(cp.Allow, nsid)
+}
+
+// SetCollectionPolicy restricts the record collections accepted by
+// createRecord, putRecord and applyWrites. A nil policy accepts everything.
+func (s *Server) SetCollectionPolicy(cp *CollectionPolicy) error {
+	if cp != nil {
+		for _, p := range append(append([]string{}, cp.Allow...), cp.Deny...) {
+			if err := validateCollectionPattern(p); err != nil {
+				return err
+			}
+		}
+	}
+	s.collectionPolicy = cp
+	return nil
+}
+
+// checkCollection returns a CollectionError if writes to the collection
+// aren't accepted. op is the kind of write, for metrics.
+func (s *Server) checkCollection(ctx context.Context, op, collection string) error {
+	if s.collectionPolicy == nil || s.collectionPolicy.Allows(collection) {
+		return nil
+	}
+	// collection names come straight from clients; don't let junk blow up the
+	// metric's cardinality
+	label := collection
+	if _, err := syntax.ParseNSID(collection); err != nil {
+		label = "invalid"
+	}
+	collectionWritesRejected.WithLabelValues(label, op).Inc()
+	s.log.Info("rejected write to disallowed collection", "collection", collection, "op", op)
+	return &CollectionError{Collection: collection}
+}
+
+func (s *Server) checkApplyWritesCollections(ctx context.Context, writes []*comatprototypes.RepoApplyWrites_Input_Writes_Elem) error {
+	if s.collectionPolicy == nil {
+		return nil
+	}
+	for _, w := range writes {
+		switch {
+		case w.RepoApplyWrites_Create != nil:
+			if err := s.checkCollection(ctx, "create", w.RepoApplyWrites_Create.Collection); err != nil {
+				return err
+			}
+		case w.RepoApplyWrites_Update != nil:
+			if err := s.checkCollection(ctx, "update", w.RepoApplyWrites_Update.Collection); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package pds
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollectionPolicyAllows(t *testing.T) {
+	assert := assert.New(t)
+
+	cp := &CollectionPolicy{
+		Allow: []string{"app.bsky.*", "com.example.thing"},
+		Deny:  []string{"app.bsky.feed.threadgate"},
+	}
+	assert.True(cp.Allows("app.bsky.feed.post"))
+	assert.True(cp.Allows("app.bsky.actor.profile"))
+	assert.True(cp.Allows("com.example.thing"))
+	assert.False(cp.Allows("com.example.other"))
+	assert.False(cp.Allows("app.bsky.feed.threadgate"))
+	// a prefix only matches whole segments
+	assert.False(cp.Allows("app.bskyx.feed.post"))
+
+	denyOnly := &CollectionPolicy{Deny: []string{"com.spam.*"}}
+	assert.True(denyOnly.Allows("app.bsky.feed.post"))
+	assert.False(denyOnly.Allows("com.spam.record"))
+}
+
+func TestCollectionPolicyEnforced(t *testing.T) {
+	assert := assert.New(t)
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	assert.Error(s.SetCollectionPolicy(&CollectionPolicy{Allow: []string{"not an nsid"}}))
+	assert.NoError(s.SetCollectionPolicy(&CollectionPolicy{
+		Allow: []string{"app.bsky.*"},
+		Deny:  []string{"app.bsky.feed.like"},
+	}))
+
+	e := "test@foo.com"
+	p := "password"
+	o, err := s.handleComAtprotoServerCreateAccount(context.Background(), &atproto.ServerCreateAccount_Input{
+		Email:    &e,
+		Password: &p,
+		Handle:   "testman.test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := s.lookupUserByDid(context.Background(), o.Did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), "user", u)
+
+	post := &lexutil.LexiconTypeDecoder{Val: &bsky.FeedPost{Text: "hello", CreatedAt: "2024-01-01T00:00:00.000Z"}}
+	_, err = s.handleComAtprotoRepoCreateRecord(ctx, &atproto.RepoCreateRecord_Input{
+		Repo:       u.Did,
+		Collection: "app.bsky.feed.post",
+		Record:     post,
+	})
+	assert.NoError(err)
+
+	_, err = s.handleComAtprotoRepoCreateRecord(ctx, &atproto.RepoCreateRecord_Input{
+		Repo:       u.Did,
+		Collection: "app.bsky.feed.like",
+		Record:     post,
+	})
+	var cerr *CollectionError
+	assert.True(errors.As(err, &cerr))
+	assert.Equal("app.bsky.feed.like", cerr.Collection)
+
+	_, err = s.handleComAtprotoRepoPutRecord(ctx, &atproto.RepoPutRecord_Input{
+		Repo:       u.Did,
+		Collection: "com.example.record",
+		Rkey:       "self",
+		Record:     post,
+	})
+	assert.ErrorIs(err, ErrCollectionNotAllowed)
+
+	// a single disallowed write rejects the whole batch
+	err = s.handleComAtprotoRepoApplyWrites(ctx, &atproto.RepoApplyWrites_Input{
+		Repo: u.Did,
+		Writes: []*atproto.RepoApplyWrites_Input_Writes_Elem{
+			{RepoApplyWrites_Create: &atproto.RepoApplyWrites_Create{Collection: "app.bsky.feed.post", Value: post}},
+			{RepoApplyWrites_Create: &atproto.RepoApplyWrites_Create{Collection: "com.example.record", Value: post}},
+		},
+	})
+	assert.ErrorIs(err, ErrCollectionNotAllowed)
+
+	// removing the policy allows everything again
+	assert.NoError(s.SetCollectionPolicy(nil))
+	_, err = s.handleComAtprotoRepoCreateRecord(ctx, &atproto.RepoCreateRecord_Input{
+		Repo:       u.Did,
+		Collection: "app.bsky.feed.like",
+		Record:     post,
+	})
+	assert.NoError(err)
+}
@@ -7,16 +7,19 @@ import (
 )
 
 type User struct {
-	ID          models.Uid `gorm:"primarykey"`
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
-	DeletedAt   gorm.DeletedAt `gorm:"index"`
-	Handle      string         `gorm:"uniqueIndex"`
-	Password    string
-	RecoveryKey string
-	Email       string
-	Did         string `gorm:"uniqueIndex"`
-	PDS         uint
+	ID        models.Uid `gorm:"primarykey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+	Handle    string         `gorm:"uniqueIndex"`
+	// visually normalized form of the handle's first label, for confusable checks
+	HandleSkeleton string `gorm:"index"`
+	Password       string
+	RecoveryKey    string
+	Email          string
+	Language       string
+	Did            string `gorm:"uniqueIndex"`
+	PDS            uint
 }
 
 type Peering struct {
@@ -0,0 +1,271 @@
+package pds
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/labstack/echo/v4"
+)
+
+// EmailKind identifies one of the outbound emails a PDS sends
+type EmailKind string
+
+const (
+	EmailConfirmation   EmailKind = "confirmation"
+	EmailPasswordReset  EmailKind = "password_reset"
+	EmailTakedownNotice EmailKind = "takedown_notice"
+	EmailSignInCode     EmailKind = "sign_in_code"
+)
+
+var allEmailKinds = []EmailKind{
+	EmailConfirmation,
+	EmailPasswordReset,
+	EmailTakedownNotice,
+	EmailSignInCode,
+}
+
+// DefaultEmailLanguage is used when an account has no language preference, or
+// when no template exists for the preferred language
+const DefaultEmailLanguage = "en"
+
+var ErrNoSuchEmailTemplate = fmt.Errorf("no such email template")
+
+//go:embed templates/email
+var defaultEmailTemplateFS embed.FS
+
+// Each template file lives at "<lang>/<kind>.tmpl" and must define a
+// "subject" and a "body" template.
+const emailTemplateExt = ".tmpl"
+
+// RenderedEmail is the output of rendering an email template
+type RenderedEmail struct {
+	Kind     EmailKind `json:"kind"`
+	Language string    `json:"lang"`
+	Subject  string    `json:"subject"`
+	Body     string    `json:"body"`
+}
+
+// EmailData is the set of values available to email templates
+type EmailData struct {
+	Handle     string
+	Did        string
+	Email      string
+	Token      string
+	Reason     string
+	ServiceUrl string
+}
+
+// Mailer delivers rendered emails. The PDS does not ship an SMTP
+// implementation; operators plug one in with [Server.SetMailer].
+type Mailer interface {
+	SendEmail(ctx context.Context, to string, email *RenderedEmail) error
+}
+
+// EmailTemplates loads and renders email templates. Templates in the operator
+// override directory (if any) take precedence over the embedded defaults.
+type EmailTemplates struct {
+	sources []fs.FS
+
+	lk    sync.Mutex
+	cache map[string]*template.Template
+}
+
+// NewEmailTemplates returns a template store backed by the embedded default
+// templates. If overrideDir is non-empty, templates found there are used in
+// preference to the defaults.
+func NewEmailTemplates(overrideDir string) (*EmailTemplates, error) {
+	defaults, err := fs.Sub(defaultEmailTemplateFS, "templates/email")
+	if err != nil {
+		return nil, err
+	}
+
+	var sources []fs.FS
+	if overrideDir != "" {
+		st, err := os.Stat(overrideDir)
+		if err != nil {
+			return nil, fmt.Errorf("email template directory: %w", err)
+		}
+		if !st.IsDir() {
+			return nil, fmt.Errorf("email template directory is not a directory: %s", overrideDir)
+		}
+		sources = append(sources, os.DirFS(overrideDir))
+	}
+	sources = append(sources, defaults)
+
+	return &EmailTemplates{
+		sources: sources,
+		cache:   make(map[string]*template.Template),
+	}, nil
+}
+
+// languageCandidates returns the ordered list of languages to try for the
+// given preference, eg "pt-BR" -> ["pt-BR", "pt", "en"]
+func languageCandidates(lang string) []string {
+	var out []string
+	if lang != "" {
+		out = append(out, lang)
+		if base, _, ok := strings.Cut(lang, "-"); ok {
+			out = append(out, base)
+		}
+	}
+	if lang != DefaultEmailLanguage {
+		out = append(out, DefaultEmailLanguage)
+	}
+	return out
+}
+
+func (et *EmailTemplates) load(kind EmailKind, lang string) (*template.Template, error) {
+	key := lang + "/" + string(kind)
+
+	et.lk.Lock()
+	defer et.lk.Unlock()
+
+	if t, ok := et.cache[key]; ok {
+		return t, nil
+	}
+
+	fname := path.Join(lang, string(kind)+emailTemplateExt)
+	for _, src := range et.sources {
+		b, err := fs.ReadFile(src, fname)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+
+		t, err := template.New(key).Parse(string(b))
+		if err != nil {
+			return nil, fmt.Errorf("parsing email template %s: %w", fname, err)
+		}
+
+		if t.Lookup("subject") == nil || t.Lookup("body") == nil {
+			return nil, fmt.Errorf("email template %s must define both 'subject' and 'body'", fname)
+		}
+
+		et.cache[key] = t
+		return t, nil
+	}
+
+	return nil, ErrNoSuchEmailTemplate
+}
+
+// Render renders the given kind of email, selecting the closest available
+// language variant to lang.
+func (et *EmailTemplates) Render(kind EmailKind, lang string, data *EmailData) (*RenderedEmail, error) {
+	for _, l := range languageCandidates(lang) {
+		t, err := et.load(kind, l)
+		if err != nil {
+			if errors.Is(err, ErrNoSuchEmailTemplate) {
+				continue
+			}
+			return nil, err
+		}
+
+		var subj, body bytes.Buffer
+		if err := t.ExecuteTemplate(&subj, "subject", data); err != nil {
+			return nil, fmt.Errorf("rendering email subject: %w", err)
+		}
+		if err := t.ExecuteTemplate(&body, "body", data); err != nil {
+			return nil, fmt.Errorf("rendering email body: %w", err)
+		}
+
+		return &RenderedEmail{
+			Kind:     kind,
+			Language: l,
+			Subject:  strings.TrimSpace(subj.String()),
+			Body:     strings.TrimSpace(body.String()) + "\n",
+		}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrNoSuchEmailTemplate, kind)
+}
+
+// Reload drops all cached templates, so that edits in the override directory
+// get picked up
+func (et *EmailTemplates) Reload() {
+	et.lk.Lock()
+	defer et.lk.Unlock()
+	et.cache = make(map[string]*template.Template)
+}
+
+func parseEmailKind(raw string) (EmailKind, error) {
+	for _, k := range allEmailKinds {
+		if string(k) == raw {
+			return k, nil
+		}
+	}
+	return "", fmt.Errorf("unknown email kind: %q", raw)
+}
+
+func (s *Server) SetMailer(m Mailer) {
+	s.mailer = m
+}
+
+func (s *Server) SetEmailTemplates(et *EmailTemplates) {
+	s.emailTemplates = et
+}
+
+// sendUserEmail renders the given kind of email in the user's preferred
+// language and hands it to the configured mailer. If no mailer is configured,
+// the email is dropped with a log message.
+func (s *Server) sendUserEmail(ctx context.Context, u *User, kind EmailKind, data *EmailData) error {
+	if u.Email == "" {
+		return fmt.Errorf("account has no email address")
+	}
+
+	if data == nil {
+		data = &EmailData{}
+	}
+	data.Handle = u.Handle
+	data.Did = u.Did
+	data.Email = u.Email
+	data.ServiceUrl = s.serviceUrl
+
+	rendered, err := s.emailTemplates.Render(kind, u.Language, data)
+	if err != nil {
+		return err
+	}
+
+	if s.mailer == nil {
+		s.log.Warn("no mailer configured, dropping outbound email", "did", u.Did, "kind", kind)
+		return nil
+	}
+
+	return s.mailer.SendEmail(ctx, u.Email, rendered)
+}
+
+// HandleRenderEmailTemplate renders an email template with placeholder data,
+// so operators can preview their template overrides
+func (s *Server) HandleRenderEmailTemplate(c echo.Context) error {
+	kind, err := parseEmailKind(c.QueryParam("kind"))
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	data := &EmailData{
+		Handle:     "alice.example.com",
+		Did:        "did:plc:ewvi7nxzyoun6zhxrhs64oiz",
+		Email:      "alice@example.com",
+		Token:      "ABCDE-12345",
+		Reason:     "Example takedown reason",
+		ServiceUrl: s.serviceUrl,
+	}
+
+	rendered, err := s.emailTemplates.Render(kind, c.QueryParam("lang"), data)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(200, rendered)
+}
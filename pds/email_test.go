@@ -0,0 +1,140 @@
+package pds
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestEmailTemplatesLanguageFallback(t *testing.T) {
+	et, err := NewEmailTemplates("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := &EmailData{Handle: "alice.test", Token: "TOKEN-1"}
+
+	tests := []struct {
+		lang string
+		want string
+	}{
+		{"", "en"},
+		{"en", "en"},
+		{"es", "es"},
+		{"es-MX", "es"},
+		{"xx", "en"},
+	}
+	for _, tc := range tests {
+		out, err := et.Render(EmailConfirmation, tc.lang, data)
+		if err != nil {
+			t.Fatalf("render %q: %s", tc.lang, err)
+		}
+		if out.Language != tc.want {
+			t.Fatalf("lang %q: expected %q, got %q", tc.lang, tc.want, out.Language)
+		}
+		if !strings.Contains(out.Body, "TOKEN-1") {
+			t.Fatalf("lang %q: token missing from body: %s", tc.lang, out.Body)
+		}
+	}
+}
+
+func TestEmailTemplatesAllKinds(t *testing.T) {
+	et, err := NewEmailTemplates("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, kind := range allEmailKinds {
+		for _, lang := range []string{"en", "es"} {
+			out, err := et.Render(kind, lang, &EmailData{})
+			if err != nil {
+				t.Fatalf("%s/%s: %s", lang, kind, err)
+			}
+			if out.Subject == "" || out.Body == "" {
+				t.Fatalf("%s/%s: empty subject or body", lang, kind)
+			}
+		}
+	}
+}
+
+func TestEmailTemplatesOverride(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "en"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := `{{define "subject"}}Custom subject{{end}}{{define "body"}}Custom body for {{.Handle}}{{end}}`
+	if err := os.WriteFile(filepath.Join(dir, "en", "password_reset.tmpl"), []byte(tmpl), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	et, err := NewEmailTemplates(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := et.Render(EmailPasswordReset, "en", &EmailData{Handle: "bob.test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Subject != "Custom subject" || out.Body != "Custom body for bob.test\n" {
+		t.Fatalf("override not applied: %+v", out)
+	}
+
+	// kinds without an override still come from the embedded defaults
+	out, err = et.Render(EmailConfirmation, "en", &EmailData{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Subject != "Confirm your email address" {
+		t.Fatalf("unexpected default subject: %q", out.Subject)
+	}
+
+	if _, err := et.Render(EmailKind("bogus"), "en", &EmailData{}); err == nil {
+		t.Fatal("expected error for unknown email kind")
+	}
+}
+
+func TestRenderEmailTemplateAdminAuth(t *testing.T) {
+	s := &Server{serviceUrl: "https://pds.test"}
+	et, err := NewEmailTemplates("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetEmailTemplates(et)
+
+	e := echo.New()
+	admin := e.Group("/admin", s.checkAdminAuth)
+	admin.GET("/emails/render", s.HandleRenderEmailTemplate)
+
+	render := func(password string) int {
+		req := httptest.NewRequest(http.MethodGet, "/admin/emails/render?kind=confirmation&lang=es", nil)
+		if password != "" {
+			req.SetBasicAuth("admin", password)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// admin routes are disabled until a password is set
+	if code := render("secret"); code != http.StatusForbidden {
+		t.Fatalf("expected 403 with no admin password configured, got %d", code)
+	}
+
+	s.SetAdminPassword("secret")
+	if code := render(""); code != http.StatusForbidden {
+		t.Fatalf("expected 403 without auth, got %d", code)
+	}
+	if code := render("wrong"); code != http.StatusForbidden {
+		t.Fatalf("expected 403 with wrong password, got %d", code)
+	}
+	if code := render("secret"); code != http.StatusOK {
+		t.Fatalf("expected 200 with admin auth, got %d", code)
+	}
+}
@@ -0,0 +1,228 @@
+package pds
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// A domain suffix under which this PDS hosts handles, with its own signup
+// policy
+type HandleDomain struct {
+	// handle suffix, including the leading dot (eg, ".example.com")
+	Suffix string
+	// new accounts under this domain need a valid invite code
+	InviteRequired bool
+	// handles whose first label starts with any of these can not be registered
+	ReservedPrefixes []string
+}
+
+// SetHandleDomains replaces the set of domains handles can be registered
+// under. By default this is just the suffix passed to NewServer.
+func (s *Server) SetHandleDomains(domains []HandleDomain) error {
+	if len(domains) == 0 {
+		return fmt.Errorf("at least one handle domain is required")
+	}
+	for i, hd := range domains {
+		if !strings.HasPrefix(hd.Suffix, ".") || len(hd.Suffix) < 2 {
+			return fmt.Errorf("handle domain must start with a dot: %q", hd.Suffix)
+		}
+		domains[i].Suffix = strings.ToLower(hd.Suffix)
+		for j, p := range hd.ReservedPrefixes {
+			domains[i].ReservedPrefixes[j] = strings.ToLower(p)
+		}
+	}
+	s.handleDomains = domains
+	return nil
+}
+
+// ParseHandleDomains builds handle domains from a list of suffixes, a list of
+// suffixes which require invites, and a list of reserved prefixes. Reserved
+// prefixes apply to all domains, unless given as "<suffix>:<prefix>".
+func ParseHandleDomains(suffixes, inviteRequired, reservedPrefixes []string) ([]HandleDomain, error) {
+	var out []HandleDomain
+	idx := make(map[string]int)
+	for _, suf := range suffixes {
+		suf = strings.ToLower(strings.TrimSpace(suf))
+		if suf == "" {
+			continue
+		}
+		if _, ok := idx[suf]; ok {
+			return nil, fmt.Errorf("duplicate handle domain: %s", suf)
+		}
+		idx[suf] = len(out)
+		out = append(out, HandleDomain{Suffix: suf})
+	}
+
+	for _, suf := range inviteRequired {
+		i, ok := idx[strings.ToLower(suf)]
+		if !ok {
+			return nil, fmt.Errorf("invite required for unknown handle domain: %s", suf)
+		}
+		out[i].InviteRequired = true
+	}
+
+	for _, rp := range reservedPrefixes {
+		suf, prefix, ok := strings.Cut(rp, ":")
+		if !ok {
+			for i := range out {
+				out[i].ReservedPrefixes = append(out[i].ReservedPrefixes, strings.ToLower(rp))
+			}
+			continue
+		}
+		i, found := idx[strings.ToLower(suf)]
+		if !found {
+			return nil, fmt.Errorf("reserved prefix for unknown handle domain: %s", rp)
+		}
+		out[i].ReservedPrefixes = append(out[i].ReservedPrefixes, strings.ToLower(prefix))
+	}
+
+	return out, nil
+}
+
+// handleDomainFor returns the hosted domain a handle falls under, or nil if the
+// handle isn't a single label under one of the configured domains. The longest
+// matching suffix wins, so eg ".foo.com" and ".eu.foo.com" can both be hosted.
+func (s *Server) handleDomainFor(handle string) *HandleDomain {
+	handle = strings.ToLower(handle)
+	var best *HandleDomain
+	for i, hd := range s.handleDomains {
+		if !strings.HasSuffix(handle, hd.Suffix) {
+			continue
+		}
+		label := strings.TrimSuffix(handle, hd.Suffix)
+		if label == "" || strings.Contains(label, ".") {
+			continue
+		}
+		if best == nil || len(hd.Suffix) > len(best.Suffix) {
+			best = &s.handleDomains[i]
+		}
+	}
+	return best
+}
+
+func (s *Server) handleSuffixes() []string {
+	out := make([]string, 0, len(s.handleDomains))
+	for _, hd := range s.handleDomains {
+		out = append(out, hd.Suffix)
+	}
+	return out
+}
+
+func (s *Server) anyInviteRequired() bool {
+	for _, hd := range s.handleDomains {
+		if hd.InviteRequired {
+			return true
+		}
+	}
+	return false
+}
+
+// An invite code for account creation
+type InviteCode struct {
+	Code string `gorm:"primarykey"`
+	// if set, the code can only be used for handles under this domain suffix
+	Domain        string
+	AvailableUses int
+	UseCount      int
+	Disabled      bool
+	CreatedAt     time.Time
+}
+
+var ErrInvalidInviteCode = fmt.Errorf("invalid invite code")
+
+// useInviteCode consumes one use of an invite code, for a handle under the
+// given domain
+func (s *Server) useInviteCode(ctx context.Context, code string, domain string) error {
+	res := s.db.WithContext(ctx).Model(&InviteCode{}).
+		Where("code = ? AND disabled = ? AND use_count < available_uses AND (domain = '' OR domain = ?)", code, false, domain).
+		UpdateColumn("use_count", gorm.Expr("use_count + 1"))
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrInvalidInviteCode
+	}
+	return nil
+}
+
+func newInviteCode() (string, error) {
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	enc := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf))
+	return enc[:5] + "-" + enc[5:10] + "-" + enc[10:], nil
+}
+
+type inviteCodesRequest struct {
+	// number of codes to create (default 1)
+	Count int `json:"count"`
+	// uses per code (default 1)
+	UseCount int    `json:"useCount"`
+	Domain   string `json:"domain,omitempty"`
+}
+
+func (s *Server) HandleAdminCreateInviteCodes(c echo.Context) error {
+	var req inviteCodesRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if req.Count <= 0 {
+		req.Count = 1
+	}
+	if req.UseCount <= 0 {
+		req.UseCount = 1
+	}
+	if req.Count > 1000 {
+		return echo.NewHTTPError(http.StatusBadRequest, "too many invite codes requested (max 1000)")
+	}
+	req.Domain = strings.ToLower(req.Domain)
+	if req.Domain != "" {
+		found := false
+		for _, hd := range s.handleDomains {
+			if hd.Suffix == req.Domain {
+				found = true
+			}
+		}
+		if !found {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("unknown handle domain: %s", req.Domain))
+		}
+	}
+
+	codes := make([]InviteCode, 0, req.Count)
+	for i := 0; i < req.Count; i++ {
+		code, err := newInviteCode()
+		if err != nil {
+			return err
+		}
+		codes = append(codes, InviteCode{
+			Code:          code,
+			Domain:        req.Domain,
+			AvailableUses: req.UseCount,
+		})
+	}
+	if err := s.db.WithContext(c.Request().Context()).Create(&codes).Error; err != nil {
+		return err
+	}
+
+	s.log.Info("created invite codes", "count", len(codes), "domain", req.Domain)
+	return c.JSON(http.StatusOK, codes)
+}
+
+// hostname of a request, without any port
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
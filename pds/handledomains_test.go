@@ -0,0 +1,114 @@
+package pds
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHandleDomains(t *testing.T) {
+	assert := assert.New(t)
+
+	domains, err := ParseHandleDomains([]string{".foo.com", " .bar.org"}, []string{".bar.org"}, []string{"admin", ".foo.com:staff"})
+	assert.NoError(err)
+	assert.Equal([]HandleDomain{
+		{Suffix: ".foo.com", ReservedPrefixes: []string{"admin", "staff"}},
+		{Suffix: ".bar.org", InviteRequired: true, ReservedPrefixes: []string{"admin"}},
+	}, domains)
+
+	_, err = ParseHandleDomains([]string{".foo.com"}, []string{".bar.org"}, nil)
+	assert.Error(err)
+	_, err = ParseHandleDomains([]string{".foo.com"}, nil, []string{".bar.org:admin"})
+	assert.Error(err)
+	_, err = ParseHandleDomains([]string{".foo.com", ".foo.com"}, nil, nil)
+	assert.Error(err)
+}
+
+func TestHandleDomains(t *testing.T) {
+	assert := assert.New(t)
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	assert.Error(s.SetHandleDomains([]HandleDomain{{Suffix: "foo.com"}}))
+	assert.NoError(s.SetHandleDomains([]HandleDomain{
+		{Suffix: ".foo.com", ReservedPrefixes: []string{"staff"}},
+		{Suffix: ".bar.org", InviteRequired: true},
+	}))
+	s.SetAdminPassword("secret")
+
+	createAccount := func(handle, invite string) error {
+		e := handle + "@example.com"
+		p := "password"
+		in := &atproto.ServerCreateAccount_Input{
+			Email:    &e,
+			Password: &p,
+			Handle:   handle,
+		}
+		if invite != "" {
+			in.InviteCode = &invite
+		}
+		_, err := s.handleComAtprotoServerCreateAccount(ctx, in)
+		return err
+	}
+
+	assert.NoError(createAccount("alice.foo.com", ""))
+	assert.Error(createAccount("alice.foo.com", ""))
+	assert.Error(createAccount("staffer.foo.com", ""))
+	assert.Error(createAccount("bob.baz.net", ""))
+	assert.Error(createAccount("bob.sub.foo.com", ""))
+
+	// invites are required on .bar.org
+	assert.Error(createAccount("bob.bar.org", ""))
+	assert.Error(createAccount("bob.bar.org", "bogus-code"))
+
+	ec := echo.New()
+	admin := ec.Group("/admin", s.checkAdminAuth)
+	admin.POST("/inviteCodes", s.HandleAdminCreateInviteCodes)
+	ec.GET("/.well-known/atproto-did", s.HandleResolveDid)
+
+	req := httptest.NewRequest("POST", "/admin/inviteCodes", strings.NewReader(`{"domain":".bar.org"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth("admin", "secret")
+	rec := httptest.NewRecorder()
+	ec.ServeHTTP(rec, req)
+	assert.Equal(http.StatusOK, rec.Code)
+
+	var codes []InviteCode
+	assert.NoError(json.Unmarshal(rec.Body.Bytes(), &codes))
+	assert.Len(codes, 1)
+
+	assert.NoError(createAccount("bob.bar.org", codes[0].Code))
+	// single-use code is now spent
+	assert.Error(createAccount("carol.bar.org", codes[0].Code))
+
+	out, err := s.handleComAtprotoServerDescribeServer(ctx)
+	assert.NoError(err)
+	assert.Equal([]string{".foo.com", ".bar.org"}, out.AvailableUserDomains)
+	assert.True(*out.InviteCodeRequired)
+
+	wellKnown := func(host string) (int, string) {
+		req := httptest.NewRequest("GET", "/.well-known/atproto-did", nil)
+		req.Host = host
+		rec := httptest.NewRecorder()
+		ec.ServeHTTP(rec, req)
+		return rec.Code, rec.Body.String()
+	}
+
+	bob, err := s.lookupUserByHandle(ctx, "bob.bar.org")
+	assert.NoError(err)
+	code, body := wellKnown("bob.bar.org:443")
+	assert.Equal(http.StatusOK, code)
+	assert.Equal(bob.Did, body)
+
+	code, _ = wellKnown("bob.elsewhere.net")
+	assert.Equal(http.StatusNotFound, code)
+}
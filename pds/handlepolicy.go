@@ -0,0 +1,168 @@
+package pds
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/identity/handlepolicy"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// A handle which an administrator has reserved, so it can not be registered
+type HandleReservation struct {
+	Handle    string `gorm:"primarykey"`
+	Reason    string
+	CreatedAt time.Time
+}
+
+// the first label of a handle, which for handles hosted on this PDS is the
+// part before the domain suffix
+func handleLabel(handle string) string {
+	label, _, _ := strings.Cut(strings.ToLower(handle), ".")
+	return label
+}
+
+// implements handlepolicy.Store against the PDS database
+type handlePolicyStore struct {
+	db *gorm.DB
+}
+
+func (hs *handlePolicyStore) IsReserved(ctx context.Context, handle syntax.Handle) (bool, error) {
+	var count int64
+	if err := hs.db.WithContext(ctx).Model(&HandleReservation{}).Where("handle = ?", handle.String()).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (hs *handlePolicyStore) HandlesWithSkeleton(ctx context.Context, domain, skeleton string) ([]handlepolicy.ExistingHandle, error) {
+	var users []User
+	if err := hs.db.WithContext(ctx).Select("handle", "did").Where("handle_skeleton = ? AND handle LIKE ?", skeleton, "%"+domain).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	out := make([]handlepolicy.ExistingHandle, 0, len(users))
+	for _, u := range users {
+		out = append(out, handlepolicy.ExistingHandle{
+			Handle: syntax.Handle(u.Handle),
+			DID:    syntax.DID(u.Did),
+		})
+	}
+	return out, nil
+}
+
+// SetHandlePolicy configures the policy used to check handles on account
+// creation and handle updates. If the policy has no Store, one backed by the
+// PDS database is used.
+func (s *Server) SetHandlePolicy(ctx context.Context, p *handlepolicy.Policy) error {
+	if err := s.db.AutoMigrate(&HandleReservation{}); err != nil {
+		return err
+	}
+	if p.Store == nil {
+		p.Store = &handlePolicyStore{db: s.db}
+	}
+	if err := s.backfillHandleSkeletons(ctx); err != nil {
+		return err
+	}
+	s.handlePolicy = p
+	return nil
+}
+
+// fills in the handle skeleton column for accounts created before it existed
+func (s *Server) backfillHandleSkeletons(ctx context.Context) error {
+	var users []User
+	return s.db.WithContext(ctx).Select("id", "handle").Where("handle_skeleton = '' OR handle_skeleton IS NULL").FindInBatches(&users, 1000, func(tx *gorm.DB, batch int) error {
+		for _, u := range users {
+			if err := s.db.WithContext(ctx).Model(&User{}).Where("id = ?", u.ID).UpdateColumn("handle_skeleton", handlepolicy.Skeleton(handleLabel(u.Handle))).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
+
+// validates a handle which is being registered or updated. did is empty for
+// new accounts.
+func (s *Server) validateHandle(ctx context.Context, handle string, did string) error {
+	hd := s.handleDomainFor(handle)
+	if hd == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid handle")
+	}
+
+	label := handleLabel(handle)
+	for _, p := range hd.ReservedPrefixes {
+		if strings.HasPrefix(label, p) {
+			return echo.NewHTTPError(http.StatusBadRequest, "handle is reserved")
+		}
+	}
+
+	if s.handlePolicy == nil {
+		return nil
+	}
+	err := s.handlePolicy.Check(ctx, handle, syntax.DID(did))
+	var pe *handlepolicy.PolicyError
+	if errors.As(err, &pe) {
+		s.log.Info("handle rejected by policy", "handle", handle, "reason", pe.Reason)
+		return echo.NewHTTPError(http.StatusBadRequest, pe.Message)
+	}
+	return err
+}
+
+type handleReservationRequest struct {
+	Handle string `json:"handle"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func (s *Server) HandleAdminReserveHandle(c echo.Context) error {
+	var req handleReservationRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	h, err := syntax.ParseHandle(req.Handle)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	res := HandleReservation{
+		Handle: h.Normalize().String(),
+		Reason: req.Reason,
+	}
+	if err := s.db.WithContext(c.Request().Context()).Clauses(clause.OnConflict{UpdateAll: true}).Create(&res).Error; err != nil {
+		return err
+	}
+	s.log.Info("reserved handle", "handle", res.Handle, "reason", res.Reason)
+	return c.JSON(http.StatusOK, res)
+}
+
+func (s *Server) HandleAdminReleaseHandle(c echo.Context) error {
+	var req handleReservationRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	h, err := syntax.ParseHandle(req.Handle)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	result := s.db.WithContext(c.Request().Context()).Delete(&HandleReservation{}, "handle = ?", h.Normalize().String())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "handle is not reserved")
+	}
+	s.log.Info("released handle", "handle", h.Normalize())
+	return c.String(http.StatusOK, "ok")
+}
+
+func (s *Server) HandleAdminListReservedHandles(c echo.Context) error {
+	var out []HandleReservation
+	if err := s.db.WithContext(c.Request().Context()).Order("handle").Find(&out).Error; err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, out)
+}
@@ -0,0 +1,70 @@
+package pds
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/identity/handlepolicy"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandlePolicy(t *testing.T) {
+	assert := assert.New(t)
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	if err := s.SetHandlePolicy(ctx, &handlepolicy.Policy{
+		Domains:        map[string]handlepolicy.DomainRule{".test": {MinLength: 3}},
+		ReservedLabels: []string{"admin"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s.SetAdminPassword("secret")
+
+	createAccount := func(handle string) error {
+		e := handle + "@foo.com"
+		p := "password"
+		_, err := s.handleComAtprotoServerCreateAccount(ctx, &atproto.ServerCreateAccount_Input{
+			Email:    &e,
+			Password: &p,
+			Handle:   handle,
+		})
+		return err
+	}
+
+	assert.NoError(createAccount("modern.test"))
+	assert.Error(createAccount("rnodern.test"))
+	assert.Error(createAccount("adm1n.test"))
+	assert.Error(createAccount("ab.test"))
+
+	ec := echo.New()
+	admin := ec.Group("/admin", s.checkAdminAuth)
+	admin.POST("/handles/reserve", s.HandleAdminReserveHandle)
+	admin.POST("/handles/release", s.HandleAdminReleaseHandle)
+
+	adminReq := func(path, body, password string) int {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if password != "" {
+			req.SetBasicAuth("admin", password)
+		}
+		rec := httptest.NewRecorder()
+		ec.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(http.StatusForbidden, adminReq("/admin/handles/reserve", `{"handle":"brand.test"}`, "wrong"))
+	assert.Equal(http.StatusOK, adminReq("/admin/handles/reserve", `{"handle":"brand.test","reason":"trademark"}`, "secret"))
+	assert.Error(createAccount("brand.test"))
+
+	assert.Equal(http.StatusOK, adminReq("/admin/handles/release", `{"handle":"brand.test"}`, "secret"))
+	assert.Equal(http.StatusNotFound, adminReq("/admin/handles/release", `{"handle":"brand.test"}`, "secret"))
+	assert.NoError(createAccount("brand.test"))
+}
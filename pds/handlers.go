@@ -4,14 +4,24 @@ import (
 	"bytes"
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"strconv"
 
 	comatprototypes "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/identity/handlepolicy"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/carstore"
 	lexutil "github.com/bluesky-social/indigo/lex/util"
 	"github.com/bluesky-social/indigo/models"
+	"github.com/bluesky-social/indigo/mst"
+	gojwt "github.com/golang-jwt/jwt"
 	"github.com/ipfs/go-cid"
-	"github.com/lestrrat-go/jwx/v2/jwt"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	car "github.com/ipld/go-car"
+	"github.com/labstack/echo/v4"
 )
 
 func (s *Server) handleComAtprotoServerCreateAccount(ctx context.Context, body *comatprototypes.ServerCreateAccount_Input) (*comatprototypes.ServerCreateAccount_Output, error) {
@@ -27,11 +37,16 @@ func (s *Server) handleComAtprotoServerCreateAccount(ctx context.Context, body *
 		return nil, err
 	}
 
-	if err := s.validateHandle(body.Handle); err != nil {
+	asn, err := s.checkSignup(ctx, *body.Email)
+	if err != nil {
 		return nil, err
 	}
 
-	_, err := s.lookupUserByHandle(ctx, body.Handle)
+	if err := s.validateHandle(ctx, body.Handle, ""); err != nil {
+		return nil, err
+	}
+
+	_, err = s.lookupUserByHandle(ctx, body.Handle)
 	switch err {
 	default:
 		return nil, err
@@ -41,16 +56,33 @@ func (s *Server) handleComAtprotoServerCreateAccount(ctx context.Context, body *
 		// handle is available, lets go
 	}
 
+	if err := s.checkSignupCaptcha(ctx); err != nil {
+		return nil, err
+	}
+
+	if hd := s.handleDomainFor(body.Handle); hd.InviteRequired {
+		if body.InviteCode == nil || *body.InviteCode == "" {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invite code required for %s handles", hd.Suffix))
+		}
+		if err := s.useInviteCode(ctx, *body.InviteCode, hd.Suffix); err != nil {
+			if errors.Is(err, ErrInvalidInviteCode) {
+				return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+			}
+			return nil, err
+		}
+	}
+
 	var recoveryKey string
 	if body.RecoveryKey != nil {
 		recoveryKey = *body.RecoveryKey
 	}
 
 	u := User{
-		Handle:      body.Handle,
-		Password:    *body.Password,
-		RecoveryKey: recoveryKey,
-		Email:       *body.Email,
+		Handle:         body.Handle,
+		HandleSkeleton: handlepolicy.Skeleton(handleLabel(body.Handle)),
+		Password:       *body.Password,
+		RecoveryKey:    recoveryKey,
+		Email:          *body.Email,
 	}
 	if err := s.db.Create(&u).Error; err != nil {
 		return nil, err
@@ -88,6 +120,9 @@ func (s *Server) handleComAtprotoServerCreateAccount(ctx context.Context, body *
 		return nil, err
 	}
 
+	s.recordSignup(ctx, u.ID, asn)
+	s.audit(ctx, &AuditEvent{Type: AuditAccountCreated, Did: d, Handle: body.Handle})
+
 	return &comatprototypes.ServerCreateAccount_Output{
 		Handle:     body.Handle,
 		Did:        d,
@@ -149,7 +184,16 @@ func (s *Server) handleComAtprotoRepoApplyWrites(ctx context.Context, body *coma
 		return fmt.Errorf("writes for non-user actors not supported (DID mismatch)")
 	}
 
-	return s.repoman.BatchWrite(ctx, u.ID, body.Writes)
+	swapCommit, err := parseSwapCid(body.SwapCommit)
+	if err != nil {
+		return err
+	}
+
+	if err := s.checkApplyWritesCollections(ctx, body.Writes); err != nil {
+		return err
+	}
+
+	return s.repoman.BatchWriteSwap(ctx, u.ID, body.Writes, swapCommit)
 }
 
 func (s *Server) handleComAtprotoRepoCreateRecord(ctx context.Context, input *comatprototypes.RepoCreateRecord_Input) (*comatprototypes.RepoCreateRecord_Output, error) {
@@ -158,7 +202,16 @@ func (s *Server) handleComAtprotoRepoCreateRecord(ctx context.Context, input *co
 		return nil, fmt.Errorf("get user: %w", err)
 	}
 
-	rpath, recid, err := s.repoman.CreateRecord(ctx, u.ID, input.Collection, input.Record.Val)
+	swapCommit, err := parseSwapCid(input.SwapCommit)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkCollection(ctx, "create", input.Collection); err != nil {
+		return nil, err
+	}
+
+	rpath, recid, err := s.repoman.CreateRecordSwap(ctx, u.ID, input.Collection, input.Record.Val, swapCommit)
 	if err != nil {
 		return nil, fmt.Errorf("record create: %w", err)
 	}
@@ -179,7 +232,12 @@ func (s *Server) handleComAtprotoRepoDeleteRecord(ctx context.Context, input *co
 		return fmt.Errorf("specified DID did not match authed user")
 	}
 
-	return s.repoman.DeleteRecord(ctx, u.ID, input.Collection, input.Rkey)
+	swap, err := parseSwap(input.SwapCommit, input.SwapRecord)
+	if err != nil {
+		return err
+	}
+
+	return s.repoman.DeleteRecordSwap(ctx, u.ID, input.Collection, input.Rkey, swap)
 }
 
 func (s *Server) handleComAtprotoRepoGetRecord(ctx context.Context, c string, collection string, repo string, rkey string) (*comatprototypes.RepoGetRecord_Output, error) {
@@ -215,17 +273,45 @@ func (s *Server) handleComAtprotoRepoListRecords(ctx context.Context, collection
 }
 
 func (s *Server) handleComAtprotoRepoPutRecord(ctx context.Context, input *comatprototypes.RepoPutRecord_Input) (*comatprototypes.RepoPutRecord_Output, error) {
-	panic("not yet implemented")
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Did != input.Repo {
+		return nil, fmt.Errorf("specified DID did not match authed user")
+	}
+
+	if input.Record == nil || input.Record.Val == nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "missing record")
+	}
+
+	swap, err := parseSwap(input.SwapCommit, input.SwapRecord)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkCollection(ctx, "update", input.Collection); err != nil {
+		return nil, err
+	}
+
+	recid, err := s.repoman.UpdateRecordSwap(ctx, u.ID, input.Collection, input.Rkey, input.Record.Val, swap)
+	if err != nil {
+		return nil, fmt.Errorf("record put: %w", err)
+	}
+
+	return &comatprototypes.RepoPutRecord_Output{
+		Uri: "at://" + u.Did + "/" + input.Collection + "/" + input.Rkey,
+		Cid: recid.String(),
+	}, nil
 }
 
 func (s *Server) handleComAtprotoServerDescribeServer(ctx context.Context) (*comatprototypes.ServerDescribeServer_Output, error) {
-	invcode := false
+	invcode := s.anyInviteRequired()
 	return &comatprototypes.ServerDescribeServer_Output{
-		InviteCodeRequired: &invcode,
-		AvailableUserDomains: []string{
-			s.handleSuffix,
-		},
-		Links: &comatprototypes.ServerDescribeServer_Links{},
+		InviteCodeRequired:   &invcode,
+		AvailableUserDomains: s.handleSuffixes(),
+		Links:                &comatprototypes.ServerDescribeServer_Links{},
 	}, nil
 }
 
@@ -234,28 +320,64 @@ var ErrInvalidUsernameOrPassword = fmt.Errorf("invalid username or password")
 func (s *Server) handleComAtprotoServerCreateSession(ctx context.Context, body *comatprototypes.ServerCreateSession_Input) (*comatprototypes.ServerCreateSession_Output, error) {
 	u, err := s.lookupUserByHandle(ctx, body.Identifier)
 	if err != nil {
+		if errors.Is(err, ErrNoSuchUser) {
+			s.audit(ctx, &AuditEvent{Type: AuditLoginFailed, Handle: body.Identifier, Reason: "no such user"})
+		}
 		return nil, err
 	}
 
 	if body.Password != u.Password {
+		s.audit(ctx, &AuditEvent{Type: AuditLoginFailed, Did: u.Did, Handle: body.Identifier, Reason: "invalid password"})
 		return nil, ErrInvalidUsernameOrPassword
 	}
 
+	// app passwords aren't supported yet, so this is always the account
+	// password
+	if err := s.checkSecondFactor(ctx, u, body.AuthFactorToken, false); err != nil {
+		return nil, err
+	}
+
 	tok, err := s.createAuthTokenForUser(ctx, body.Identifier, u.Did)
 	if err != nil {
 		return nil, err
 	}
 
+	s.audit(ctx, &AuditEvent{Type: AuditLogin, Did: u.Did, Handle: body.Identifier})
+
 	return &comatprototypes.ServerCreateSession_Output{
-		Handle:     body.Identifier,
-		Did:        u.Did,
-		AccessJwt:  tok.AccessJwt,
-		RefreshJwt: tok.RefreshJwt,
+		Handle:          body.Identifier,
+		Did:             u.Did,
+		AccessJwt:       tok.AccessJwt,
+		RefreshJwt:      tok.RefreshJwt,
+		EmailAuthFactor: s.emailAuthFactor(ctx, u.ID),
 	}, nil
 }
 
 func (s *Server) handleComAtprotoServerDeleteSession(ctx context.Context) error {
-	panic("not yet implemented")
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return err
+	}
+
+	if scope, _ := ctx.Value("authScope").(string); scope != "com.atproto.refresh" {
+		return fmt.Errorf("auth token did not have refresh scope")
+	}
+
+	tok, ok := ctx.Value("token").(*gojwt.Token)
+	if !ok {
+		return fmt.Errorf("internal auth error: token not set post auth check")
+	}
+
+	family := tokenClaim(tok, "fam")
+	if family == "" {
+		return ErrInvalidRefreshToken
+	}
+
+	if err := s.revokeSessionFamily(ctx, family); err != nil {
+		return err
+	}
+	s.audit(ctx, &AuditEvent{Type: AuditLogout, Did: u.Did, Handle: u.Handle})
+	return nil
 }
 
 func (s *Server) handleComAtprotoServerGetSession(ctx context.Context) (*comatprototypes.ServerGetSession_Output, error) {
@@ -285,16 +407,12 @@ func (s *Server) handleComAtprotoServerRefreshSession(ctx context.Context) (*com
 		return nil, fmt.Errorf("auth token did not have refresh scope")
 	}
 
-	tok, ok := ctx.Value("token").(*jwt.Token)
+	tok, ok := ctx.Value("token").(*gojwt.Token)
 	if !ok {
 		return nil, fmt.Errorf("internal auth error: token not set post auth check")
 	}
 
-	if err := s.invalidateToken(ctx, u, tok); err != nil {
-		return nil, err
-	}
-
-	outTok, err := s.createAuthTokenForUser(ctx, u.Handle, u.Did)
+	outTok, err := s.rotateRefreshToken(ctx, u, tok)
 	if err != nil {
 		return nil, err
 	}
@@ -313,7 +431,12 @@ func (s *Server) handleComAtprotoSyncUpdateRepo(ctx context.Context, r io.Reader
 }
 
 func (s *Server) handleComAtprotoSyncGetCheckout(ctx context.Context, did string) (io.Reader, error) {
-	panic("not yet implemented")
+	targetUser, err := s.lookupUser(ctx, did)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.repoman.StreamRepo(ctx, targetUser.ID, "")
 }
 
 func (s *Server) handleComAtprotoSyncGetHead(ctx context.Context, did string) (*comatprototypes.SyncGetHead_Output, error) {
@@ -332,24 +455,59 @@ func (s *Server) handleComAtprotoSyncGetHead(ctx context.Context, did string) (*
 	}, nil
 }
 
+// handleComAtprotoSyncGetRecord returns a CAR file containing the signed
+// commit, the MST nodes on the path to the record, and the record itself, so
+// that clients can authenticate the record against the repo's signing key.
 func (s *Server) handleComAtprotoSyncGetRecord(ctx context.Context, collection string, commit string, did string, rkey string) (io.Reader, error) {
-	panic("not yet implemented")
-}
-
-func (s *Server) handleComAtprotoSyncGetRepo(ctx context.Context, did string, since string) (io.Reader, error) {
 	targetUser, err := s.lookupUser(ctx, did)
 	if err != nil {
 		return nil, err
 	}
 
+	root, blks, err := s.repoman.GetRecordProof(ctx, targetUser.ID, collection, rkey)
+	if err != nil {
+		if errors.Is(err, mst.ErrNotFound) {
+			return nil, echo.NewHTTPError(http.StatusNotFound, "record not found in repo")
+		}
+		return nil, err
+	}
+
+	// we only keep the current state of the repo around, so proofs against
+	// older commits can't be served
+	if commit != "" && commit != root.String() {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "can only serve proofs against the latest commit")
+	}
+
 	buf := new(bytes.Buffer)
-	if err := s.repoman.ReadRepo(ctx, targetUser.ID, since, buf); err != nil {
+	hb, err := cbor.DumpObject(&car.CarHeader{
+		Roots:   []cid.Cid{root},
+		Version: 1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := carstore.LdWrite(buf, hb); err != nil {
 		return nil, err
 	}
 
+	for _, blk := range blks {
+		if _, err := carstore.LdWrite(buf, blk.Cid().Bytes(), blk.RawData()); err != nil {
+			return nil, err
+		}
+	}
+
 	return buf, nil
 }
 
+func (s *Server) handleComAtprotoSyncGetRepo(ctx context.Context, did string, since string) (io.Reader, error) {
+	targetUser, err := s.lookupUser(ctx, did)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.repoman.StreamRepo(ctx, targetUser.ID, since)
+}
+
 func (s *Server) handleComAtprotoSyncGetBlocks(ctx context.Context, cids []string, did string) (io.Reader, error) {
 	panic("nyi")
 }
@@ -362,21 +520,17 @@ func (s *Server) handleComAtprotoSyncRequestCrawl(ctx context.Context, body *com
 	panic("nyi")
 }
 
-func (s *Server) handleComAtprotoSyncGetBlob(ctx context.Context, cid string, did string) (io.Reader, error) {
-	panic("nyi")
-}
-
 func (s *Server) handleComAtprotoSyncListBlobs(ctx context.Context, cursor string, did string, limit int, since string) (*comatprototypes.SyncListBlobs_Output, error) {
 	panic("nyi")
 }
 
 func (s *Server) handleComAtprotoIdentityUpdateHandle(ctx context.Context, body *comatprototypes.IdentityUpdateHandle_Input) error {
-	if err := s.validateHandle(body.Handle); err != nil {
+	u, err := s.getUser(ctx)
+	if err != nil {
 		return err
 	}
 
-	u, err := s.getUser(ctx)
-	if err != nil {
+	if err := s.validateHandle(ctx, body.Handle, u.Did); err != nil {
 		return err
 	}
 
@@ -458,8 +612,50 @@ func (s *Server) handleComAtprotoAdminGetSubjectStatus(ctx context.Context, blob
 	panic("nyi")
 }
 
+// handleComAtprotoAdminUpdateSubjectStatus lets moderation services take down
+// (and restore) accounts. It is only available via service auth, to issuers
+// configured for the method.
 func (s *Server) handleComAtprotoAdminUpdateSubjectStatus(ctx context.Context, body *comatprototypes.AdminUpdateSubjectStatus_Input) (*comatprototypes.AdminUpdateSubjectStatus_Output, error) {
-	panic("nyi")
+	claims := serviceAuthClaims(ctx)
+	if claims == nil {
+		return nil, echo.NewHTTPError(http.StatusForbidden, "service auth required")
+	}
+	if body.Subject == nil || body.Subject.AdminDefs_RepoRef == nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "only repo subjects are supported")
+	}
+	did := body.Subject.AdminDefs_RepoRef.Did
+	if _, err := syntax.ParseDID(did); err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	var err error
+	if body.Takedown != nil {
+		if body.Takedown.Applied {
+			err = s.TakedownRepo(ctx, did)
+		} else {
+			err = s.ReactivateRepo(ctx, did)
+		}
+	}
+	evt := &AuditEvent{
+		Type:    AuditAdminAction,
+		Did:     did,
+		Details: map[string]string{"method": "com.atproto.admin.updateSubjectStatus", "issuer": claims.Iss},
+	}
+	if body.Takedown != nil {
+		evt.Details["takedown"] = strconv.FormatBool(body.Takedown.Applied)
+	}
+	if err != nil {
+		evt.Reason = err.Error()
+	}
+	s.audit(ctx, evt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &comatprototypes.AdminUpdateSubjectStatus_Output{
+		Subject:  &comatprototypes.AdminUpdateSubjectStatus_Output_Subject{AdminDefs_RepoRef: body.Subject.AdminDefs_RepoRef},
+		Takedown: body.Takedown,
+	}, nil
 }
 func (s *Server) handleComAtprotoServerConfirmEmail(ctx context.Context, body *comatprototypes.ServerConfirmEmail_Input) error {
 	panic("nyi")
@@ -474,7 +670,26 @@ func (s *Server) handleComAtprotoServerReserveSigningKey(ctx context.Context, bo
 	panic("nyi")
 }
 func (s *Server) handleComAtprotoServerUpdateEmail(ctx context.Context, body *comatprototypes.ServerUpdateEmail_Input) error {
-	panic("nyi")
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := validateEmail(body.Email); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if err := s.db.Model(&User{}).Where("id = ?", u.ID).Update("email", body.Email).Error; err != nil {
+		return err
+	}
+
+	s.audit(ctx, &AuditEvent{
+		Type:    AuditEmailChange,
+		Did:     u.Did,
+		Handle:  u.Handle,
+		Details: map[string]string{"old": u.Email, "new": body.Email},
+	})
+	return nil
 }
 func (s *Server) handleComAtprotoTempFetchLabels(ctx context.Context, limit int, since *int) (*comatprototypes.TempFetchLabels_Output, error) {
 	panic("nyi")
@@ -5,14 +5,20 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
 	"github.com/bluesky-social/indigo/carstore"
 	"github.com/bluesky-social/indigo/plc"
+	"github.com/bluesky-social/indigo/repo"
 	"github.com/bluesky-social/indigo/util/cliutil"
+	"github.com/ipfs/go-cid"
 	"github.com/whyrusleeping/go-did"
 	"gorm.io/gorm"
 )
@@ -128,3 +134,139 @@ func TestHandleComAtprotoSessionCreate(t *testing.T) {
 		t.Fatalf("expected error %s, got %s\n", ErrInvalidUsernameOrPassword, err)
 	}
 }
+
+func TestHandleComAtprotoSyncGetRecordProof(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	e := "test@foo.com"
+	p := "password"
+	o, err := s.handleComAtprotoServerCreateAccount(ctx, &atproto.ServerCreateAccount_Input{
+		Email:    &e,
+		Password: &p,
+		Handle:   "testman.test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := s.lookupUserByDid(ctx, o.Did)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rpaths []string
+	var rcids []cid.Cid
+	for i := 0; i < 20; i++ {
+		rpath, rcid, err := s.repoman.CreateRecord(ctx, u.ID, "app.bsky.feed.post", &bsky.FeedPost{
+			Text:      fmt.Sprintf("post number %d", i),
+			CreatedAt: "2024-01-01T00:00:00.000Z",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rpaths = append(rpaths, rpath)
+		rcids = append(rcids, rcid)
+	}
+
+	collection, rkey, _ := strings.Cut(rpaths[7], "/")
+	out, err := s.handleComAtprotoSyncGetRecord(ctx, collection, "", o.Did, rkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := repo.ReadRepoFromCar(ctx, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the commit must be signed by the repo's key
+	sc := r.SignedCommit()
+	sb, err := sc.Unsigned().BytesForSigning()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.signingKey.Public().Verify(sb, sc.Sig); err != nil {
+		t.Fatalf("commit signature did not verify: %s", err)
+	}
+	if r.RepoDid() != o.Did {
+		t.Fatalf("wrong repo DID in commit: %s", r.RepoDid())
+	}
+
+	// the record must be reachable from the signed commit using only the
+	// blocks in the response
+	rcid, rec, err := r.GetRecord(ctx, rpaths[7])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rcid != rcids[7] {
+		t.Fatalf("record CID mismatch: %s != %s", rcid, rcids[7])
+	}
+	if rec.(*bsky.FeedPost).Text != "post number 7" {
+		t.Fatal("got wrong record contents")
+	}
+
+	// and the response should be a proof, not the whole repo
+	if _, _, err := r.GetRecord(ctx, rpaths[8]); err == nil {
+		t.Fatal("expected unrelated record to be missing from proof")
+	}
+
+	if _, err := s.handleComAtprotoSyncGetRecord(ctx, collection, "", o.Did, "doesnotexist"); err == nil {
+		t.Fatal("expected error for missing record")
+	}
+}
+
+func TestHandleComAtprotoSyncGetCheckout(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	e := "test@foo.com"
+	p := "password"
+	o, err := s.handleComAtprotoServerCreateAccount(ctx, &atproto.ServerCreateAccount_Input{
+		Email:    &e,
+		Password: &p,
+		Handle:   "testman.test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := s.lookupUserByDid(ctx, o.Did)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rpaths []string
+	for i := 0; i < 50; i++ {
+		rpath, _, err := s.repoman.CreateRecord(ctx, u.ID, "app.bsky.feed.post", &bsky.FeedPost{
+			Text:      fmt.Sprintf("post number %d", i),
+			CreatedAt: "2024-01-01T00:00:00.000Z",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rpaths = append(rpaths, rpath)
+	}
+
+	for _, get := range []func() (io.Reader, error){
+		func() (io.Reader, error) { return s.handleComAtprotoSyncGetCheckout(ctx, o.Did) },
+		func() (io.Reader, error) { return s.handleComAtprotoSyncGetRepo(ctx, o.Did, "") },
+	} {
+		out, err := get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := repo.ReadRepoFromCar(ctx, out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.RepoDid() != o.Did {
+			t.Fatalf("wrong repo DID in commit: %s", r.RepoDid())
+		}
+		for _, rpath := range rpaths {
+			if _, _, err := r.GetRecord(ctx, rpath); err != nil {
+				t.Fatalf("record %s missing from checkout: %s", rpath, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,372 @@
+package pds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	comatprototypes "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/labels"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/models"
+
+	"github.com/gorilla/websocket"
+	"github.com/ipfs/go-cid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// LabelerConfig lets the PDS act as a labeler for its own accounts and
+// records, so operators can do basic moderation without running a separate
+// labeling service. Labels are applied through the admin API, signed, and
+// emitted on com.atproto.label.subscribeLabels.
+//
+// For the labels to be found and verified, the labeler DID's document must
+// have an "atproto_label" verification method for the signing key, and an
+// "atproto_labeler" service pointing at this PDS.
+type LabelerConfig struct {
+	DID syntax.DID
+	// Labels are signed with this key
+	SigningKey crypto.PrivateKey
+}
+
+// A label applied by the PDS's labeler, as signed. Negations are kept too,
+// so the stream can be replayed from any cursor.
+type PdsLabel struct {
+	Seq       int64  `gorm:"primarykey"`
+	Uri       string `gorm:"index"`
+	Cid       string
+	Val       string
+	Neg       bool
+	Cts       string
+	Exp       string
+	Sig       []byte
+	CreatedAt time.Time
+}
+
+func (l *PdsLabel) label(src string) *comatprototypes.LabelDefs_Label {
+	ver := int64(labels.LabelVersion)
+	out := &comatprototypes.LabelDefs_Label{
+		Src: src,
+		Uri: l.Uri,
+		Val: l.Val,
+		Cts: l.Cts,
+		Sig: l.Sig,
+		Ver: &ver,
+	}
+	if l.Cid != "" {
+		out.Cid = &l.Cid
+	}
+	if l.Neg {
+		neg := true
+		out.Neg = &neg
+	}
+	if l.Exp != "" {
+		out.Exp = &l.Exp
+	}
+	return out
+}
+
+// labelPersister replays the label stream from the database. Labels are
+// stored (and sequenced) by applyLabel before being added to the event
+// manager, so persisting only has to broadcast them.
+type labelPersister struct {
+	db  *gorm.DB
+	src string
+
+	broadcast func(*events.XRPCStreamEvent)
+}
+
+func (lp *labelPersister) Persist(ctx context.Context, e *events.XRPCStreamEvent) error {
+	lp.broadcast(e)
+	return nil
+}
+
+func (lp *labelPersister) Playback(ctx context.Context, since int64, cb func(*events.XRPCStreamEvent) error) error {
+	for {
+		var rows []PdsLabel
+		if err := lp.db.WithContext(ctx).Where("seq > ?", since).Order("seq asc").Limit(500).Find(&rows).Error; err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		for i := range rows {
+			evt := &events.XRPCStreamEvent{
+				LabelLabels: &comatprototypes.LabelSubscribeLabels_Labels{
+					Seq:    rows[i].Seq,
+					Labels: []*comatprototypes.LabelDefs_Label{rows[i].label(lp.src)},
+				},
+			}
+			if err := cb(evt); err != nil {
+				return err
+			}
+			since = rows[i].Seq
+		}
+	}
+}
+
+func (lp *labelPersister) TakeDownRepo(ctx context.Context, usr models.Uid) error {
+	return nil
+}
+
+func (lp *labelPersister) Flush(context.Context) error {
+	return nil
+}
+
+func (lp *labelPersister) Shutdown(context.Context) error {
+	return nil
+}
+
+func (lp *labelPersister) SetEventBroadcaster(brc func(*events.XRPCStreamEvent)) {
+	lp.broadcast = brc
+}
+
+// SetLabelerConfig enables the admin label endpoints and the label stream
+func (s *Server) SetLabelerConfig(cfg *LabelerConfig) error {
+	if cfg.SigningKey == nil {
+		return fmt.Errorf("labeler signing key is required")
+	}
+	if err := s.db.AutoMigrate(&PdsLabel{}); err != nil {
+		return err
+	}
+	s.labelerConfig = cfg
+	s.labelEvents = events.NewEventManager(&labelPersister{db: s.db, src: cfg.DID.String()})
+	return nil
+}
+
+// checkLabelSubject makes sure a label subject is an account, or a record,
+// hosted on this PDS
+func (s *Server) checkLabelSubject(ctx context.Context, uri, cidStr string) error {
+	if strings.HasPrefix(uri, "did:") {
+		if cidStr != "" {
+			return fmt.Errorf("labels on accounts can't have a cid")
+		}
+		if _, err := s.lookupUserByDid(ctx, uri); err != nil {
+			return fmt.Errorf("account is not hosted here: %w", err)
+		}
+		return nil
+	}
+
+	aturi, err := syntax.ParseATURI(uri)
+	if err != nil {
+		return err
+	}
+	did, err := aturi.Authority().AsDID()
+	if err != nil {
+		return fmt.Errorf("record uri must have a DID authority")
+	}
+	if aturi.Collection() == "" || aturi.RecordKey() == "" {
+		return fmt.Errorf("uri must be an account DID, or a record")
+	}
+	u, err := s.lookupUserByDid(ctx, did.String())
+	if err != nil {
+		return fmt.Errorf("account is not hosted here: %w", err)
+	}
+	want := cid.Undef
+	if cidStr != "" {
+		c, err := cid.Decode(cidStr)
+		if err != nil {
+			return fmt.Errorf("invalid cid: %w", err)
+		}
+		want = c
+	}
+	if _, _, err := s.repoman.GetRecord(ctx, u.ID, aturi.Collection().String(), aturi.RecordKey().String(), want); err != nil {
+		return fmt.Errorf("record not found: %w", err)
+	}
+	return nil
+}
+
+// applyLabel signs a label, stores it, and emits it on the label stream
+func (s *Server) applyLabel(ctx context.Context, l *comatprototypes.LabelDefs_Label) (*PdsLabel, error) {
+	l.Src = s.labelerConfig.DID.String()
+	if err := labels.Validate(l); err != nil {
+		return nil, err
+	}
+	if err := labels.Sign(l, s.labelerConfig.SigningKey); err != nil {
+		return nil, err
+	}
+
+	row := &PdsLabel{
+		Uri: l.Uri,
+		Val: l.Val,
+		Cts: l.Cts,
+		Sig: l.Sig,
+	}
+	if l.Cid != nil {
+		row.Cid = *l.Cid
+	}
+	if l.Neg != nil {
+		row.Neg = *l.Neg
+	}
+	if l.Exp != nil {
+		row.Exp = *l.Exp
+	}
+	if err := s.db.WithContext(ctx).Create(row).Error; err != nil {
+		return nil, err
+	}
+
+	if err := s.labelEvents.AddEvent(ctx, &events.XRPCStreamEvent{
+		LabelLabels: &comatprototypes.LabelSubscribeLabels_Labels{
+			Seq:    row.Seq,
+			Labels: []*comatprototypes.LabelDefs_Label{l},
+		},
+	}); err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+// activeLabels returns the labels currently in effect on a subject (the
+// latest for each value, unless it was negated or has expired)
+func (s *Server) activeLabels(ctx context.Context, uri string) ([]*comatprototypes.LabelDefs_Label, error) {
+	var rows []PdsLabel
+	if err := s.db.WithContext(ctx).Where("uri = ?", uri).Order("seq asc").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	latest := make(map[string]*PdsLabel)
+	var order []string
+	for i := range rows {
+		key := rows[i].Val + " " + rows[i].Cid
+		if _, ok := latest[key]; !ok {
+			order = append(order, key)
+		}
+		latest[key] = &rows[i]
+	}
+	now := time.Now()
+	out := []*comatprototypes.LabelDefs_Label{}
+	for _, key := range order {
+		row := latest[key]
+		if row.Neg {
+			continue
+		}
+		if row.Exp != "" {
+			if exp, err := syntax.ParseDatetimeLenient(row.Exp); err == nil && exp.Time().Before(now) {
+				continue
+			}
+		}
+		out = append(out, row.label(s.labelerConfig.DID.String()))
+	}
+	return out, nil
+}
+
+type adminLabelRequest struct {
+	// an account DID, or the AT-URI of a record
+	Uri string `json:"uri"`
+	// optionally, the version of the record the label applies to
+	Cid string `json:"cid,omitempty"`
+	Val string `json:"val"`
+	// when the label stops applying. Optional
+	Exp string `json:"exp,omitempty"`
+}
+
+func (s *Server) handleAdminLabel(c echo.Context, neg bool) error {
+	ctx := c.Request().Context()
+	if s.labelerConfig == nil {
+		return echo.NewHTTPError(http.StatusNotImplemented, "labeling is not enabled")
+	}
+	var req adminLabelRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := s.checkLabelSubject(ctx, req.Uri, req.Cid); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	l := &comatprototypes.LabelDefs_Label{
+		Uri: req.Uri,
+		Val: req.Val,
+		Cts: syntax.DatetimeNow().String(),
+	}
+	if req.Cid != "" {
+		l.Cid = &req.Cid
+	}
+	if req.Exp != "" {
+		l.Exp = &req.Exp
+	}
+	if neg {
+		l.Neg = &neg
+	}
+	row, err := s.applyLabel(ctx, l)
+	if errors.Is(err, labels.ErrInvalidLabel) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	} else if err != nil {
+		return err
+	}
+	s.log.Info("applied label", "uri", req.Uri, "cid", req.Cid, "val", req.Val, "neg", neg, "seq", row.Seq)
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"seq":   row.Seq,
+		"label": l,
+	})
+}
+
+// HandleAdminApplyLabel labels an account or record hosted on this PDS
+func (s *Server) HandleAdminApplyLabel(c echo.Context) error {
+	return s.handleAdminLabel(c, false)
+}
+
+// HandleAdminRemoveLabel negates a label previously applied to an account or
+// record
+func (s *Server) HandleAdminRemoveLabel(c echo.Context) error {
+	return s.handleAdminLabel(c, true)
+}
+
+// HandleAdminListLabels returns the labels in effect on an account or record
+func (s *Server) HandleAdminListLabels(c echo.Context) error {
+	if s.labelerConfig == nil {
+		return echo.NewHTTPError(http.StatusNotImplemented, "labeling is not enabled")
+	}
+	uri := c.QueryParam("uri")
+	if uri == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "uri is required")
+	}
+	out, err := s.activeLabels(c.Request().Context(), uri)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, map[string]any{"labels": out})
+}
+
+// HandleSubscribeLabels streams the labels applied by the PDS's labeler, from
+// an optional cursor
+func (s *Server) HandleSubscribeLabels(c echo.Context) error {
+	if s.labelerConfig == nil {
+		return echo.NewHTTPError(http.StatusNotImplemented, "labeling is not enabled")
+	}
+	var since *int64
+	if v := c.QueryParam("cursor"); v != "" {
+		cursor, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || cursor < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid cursor")
+		}
+		since = &cursor
+	}
+
+	conn, err := websocket.Upgrade(c.Response().Writer, c.Request(), c.Response().Header(), 1<<10, 1<<10)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx := c.Request().Context()
+	ident := c.RealIP() + "-" + c.Request().UserAgent()
+	evts, cancel, err := s.labelEvents.Subscribe(ctx, ident, nil, since)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	for evt := range evts {
+		if err := events.WriteEvent(conn, evt); err != nil {
+			return fmt.Errorf("failed to write event: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package pds
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/api/labels"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/events"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLabeler(t *testing.T) {
+	assert := assert.New(t)
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	key, err := crypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := key.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetLabelerConfig(&LabelerConfig{DID: "did:web:mod.pds.test", SigningKey: key}); err != nil {
+		t.Fatal(err)
+	}
+
+	e := "test@foo.com"
+	p := "password"
+	o, err := s.handleComAtprotoServerCreateAccount(context.Background(), &atproto.ServerCreateAccount_Input{
+		Email:    &e,
+		Password: &p,
+		Handle:   "testman.test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := s.lookupUserByDid(context.Background(), o.Did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), "user", u)
+	post, err := s.handleComAtprotoRepoCreateRecord(ctx, &atproto.RepoCreateRecord_Input{
+		Repo:       u.Did,
+		Collection: "app.bsky.feed.post",
+		Record:     &lexutil.LexiconTypeDecoder{Val: &bsky.FeedPost{Text: "hello", CreatedAt: "2024-01-01T00:00:00.000Z"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only local accounts and records can be labeled
+	assert.NoError(s.checkLabelSubject(ctx, u.Did, ""))
+	assert.NoError(s.checkLabelSubject(ctx, post.Uri, post.Cid))
+	assert.Error(s.checkLabelSubject(ctx, "did:plc:elsewhere", ""))
+	assert.Error(s.checkLabelSubject(ctx, u.Did, post.Cid))
+	assert.Error(s.checkLabelSubject(ctx, "at://"+u.Did+"/app.bsky.feed.post/nope", ""))
+
+	apply := func(uri, val string, neg bool) {
+		t.Helper()
+		l := &atproto.LabelDefs_Label{Uri: uri, Val: val, Cts: syntax.DatetimeNow().String()}
+		if neg {
+			l.Neg = &neg
+		}
+		if _, err := s.applyLabel(ctx, l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	apply(post.Uri, "spam", false)
+	apply(post.Uri, "rude", false)
+	apply(u.Did, "!hide", false)
+	apply(post.Uri, "spam", true)
+
+	active, err := s.activeLabels(ctx, post.Uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(active, 1) {
+		assert.Equal("rude", active[0].Val)
+		assert.Equal("did:web:mod.pds.test", active[0].Src)
+		assert.NoError(labels.Verify(active[0], pub))
+	}
+
+	// the stream replays every label, including negations, with signatures
+	// intact
+	var seen []*atproto.LabelDefs_Label
+	var lastSeq int64
+	lp := &labelPersister{db: s.db, src: "did:web:mod.pds.test"}
+	err = lp.Playback(ctx, 0, func(evt *events.XRPCStreamEvent) error {
+		assert.Greater(evt.LabelLabels.Seq, lastSeq)
+		lastSeq = evt.LabelLabels.Seq
+		seen = append(seen, evt.LabelLabels.Labels...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(seen, 4) {
+		for _, l := range seen {
+			assert.NoError(labels.Verify(l, pub))
+		}
+		assert.True(*seen[3].Neg)
+	}
+}
@@ -0,0 +1,183 @@
+package pds
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ServiceMode controls which requests the server accepts
+type ServiceMode string
+
+const (
+	// all requests are served
+	ModeNormal ServiceMode = "normal"
+	// reads (and the firehose) are served, but writes are rejected
+	ModeReadOnly ServiceMode = "read-only"
+	// everything except health checks and the admin API gets a 503
+	ModeMaintenance ServiceMode = "maintenance"
+)
+
+// default Retry-After sent in maintenance mode, if none is set
+const defaultMaintenanceRetryAfter = 5 * time.Minute
+
+// ServiceModeState is the current service mode, along with an optional
+// operator message which is included in rejections
+type ServiceModeState struct {
+	Mode    ServiceMode `json:"mode"`
+	Message string      `json:"message,omitempty"`
+	// how long clients should wait before retrying, in maintenance mode
+	RetryAfter time.Duration `json:"-"`
+	Since      time.Time     `json:"since"`
+}
+
+// procedures which are still allowed in read-only mode: they touch session
+// state, not repos or accounts, and clients need them to keep reading
+var readOnlyAllowedProcedures = map[string]bool{
+	"/xrpc/com.atproto.server.createSession":  true,
+	"/xrpc/com.atproto.server.refreshSession": true,
+	"/xrpc/com.atproto.server.deleteSession":  true,
+}
+
+// paths which are always served, even in maintenance mode
+var maintenanceExemptPaths = map[string]bool{
+	"/xrpc/_health": true,
+	"/_health":      true,
+}
+
+// SetServiceMode switches the server in to (or out of) read-only or
+// maintenance mode. Takes effect for the next request; in-flight requests and
+// open firehose connections are not interrupted.
+func (s *Server) SetServiceMode(mode ServiceMode, message string, retryAfter time.Duration) error {
+	switch mode {
+	case ModeNormal, ModeReadOnly, ModeMaintenance:
+	default:
+		return fmt.Errorf("unknown service mode: %q", mode)
+	}
+	if retryAfter == 0 {
+		retryAfter = defaultMaintenanceRetryAfter
+	}
+	prev := s.ServiceMode()
+	st := &ServiceModeState{
+		Mode:       mode,
+		Message:    message,
+		RetryAfter: retryAfter,
+		Since:      time.Now(),
+	}
+	if s.shared != nil {
+		if err := s.storeServiceMode(context.Background(), *st); err != nil {
+			return fmt.Errorf("storing shared service mode: %w", err)
+		}
+	}
+	s.serviceMode.Store(st)
+	if prev.Mode != mode {
+		s.log.Warn("service mode changed", "from", prev.Mode, "to", mode, "message", message)
+	}
+	return nil
+}
+
+// ServiceMode returns the current service mode
+func (s *Server) ServiceMode() ServiceModeState {
+	if st := s.serviceMode.Load(); st != nil {
+		return *st
+	}
+	return ServiceModeState{Mode: ModeNormal}
+}
+
+func isWriteMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	default:
+		return true
+	}
+}
+
+// prefix of the com.atproto.admin.* XRPC methods, which are exempt from
+// service modes along with the /admin/ routes
+const adminXrpcPrefix = "/xrpc/com.atproto.admin."
+
+// rejects requests according to the current service mode. the admin API
+// (including the com.atproto.admin XRPC methods) is always exempt, so that
+// the mode can be switched back and moderation actions still work
+func (s *Server) serviceModeMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		st := s.serviceMode.Load()
+		if st == nil || st.Mode == ModeNormal {
+			return next(c)
+		}
+		path := c.Request().URL.Path
+		if strings.HasPrefix(path, "/admin/") || strings.HasPrefix(path, adminXrpcPrefix) || maintenanceExemptPaths[path] {
+			return next(c)
+		}
+
+		switch st.Mode {
+		case ModeMaintenance:
+			msg := "server is down for maintenance"
+			if st.Message != "" {
+				msg += ": " + st.Message
+			}
+			c.Response().Header().Set("Retry-After", strconv.Itoa(int(st.RetryAfter.Seconds())))
+			return c.JSON(http.StatusServiceUnavailable, map[string]string{
+				"error":   "Maintenance",
+				"message": msg,
+			})
+		case ModeReadOnly:
+			if !isWriteMethod(c.Request().Method) || readOnlyAllowedProcedures[path] {
+				return next(c)
+			}
+			msg := "server is in read-only mode; writes are temporarily disabled"
+			if st.Message != "" {
+				msg += ": " + st.Message
+			}
+			return c.JSON(http.StatusServiceUnavailable, map[string]string{
+				"error":   "ReadOnly",
+				"message": msg,
+			})
+		}
+		return next(c)
+	}
+}
+
+type serviceModeRequest struct {
+	Mode    ServiceMode `json:"mode"`
+	Message string      `json:"message"`
+	// seconds
+	RetryAfter int `json:"retryAfter"`
+}
+
+type serviceModeResponse struct {
+	ServiceModeState
+	RetryAfter int `json:"retryAfter,omitempty"`
+}
+
+func modeResponse(st ServiceModeState) serviceModeResponse {
+	out := serviceModeResponse{ServiceModeState: st}
+	if st.Mode == ModeMaintenance {
+		out.RetryAfter = int(st.RetryAfter.Seconds())
+	}
+	return out
+}
+
+func (s *Server) HandleAdminGetServiceMode(c echo.Context) error {
+	return c.JSON(http.StatusOK, modeResponse(s.ServiceMode()))
+}
+
+func (s *Server) HandleAdminSetServiceMode(c echo.Context) error {
+	var req serviceModeRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if req.RetryAfter < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "retryAfter must not be negative")
+	}
+	if err := s.SetServiceMode(req.Mode, req.Message, time.Duration(req.RetryAfter)*time.Second); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, modeResponse(s.ServiceMode()))
+}
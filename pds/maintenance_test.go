@@ -0,0 +1,99 @@
+package pds
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestServiceModes(t *testing.T) {
+	s := &Server{log: slog.Default()}
+
+	e := echo.New()
+	e.Use(s.serviceModeMiddleware)
+	ok := func(c echo.Context) error {
+		return c.String(200, "ok")
+	}
+	e.GET("/xrpc/com.atproto.repo.getRecord", ok)
+	e.POST("/xrpc/com.atproto.repo.createRecord", ok)
+	e.POST("/xrpc/com.atproto.server.createSession", ok)
+	e.GET("/xrpc/_health", ok)
+	e.POST("/xrpc/com.atproto.admin.updateSubjectStatus", ok)
+	e.POST("/admin/mode", s.HandleAdminSetServiceMode)
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+	setMode := func(body string) {
+		req := httptest.NewRequest("POST", "/admin/mode", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != 200 {
+			t.Fatalf("setting mode failed: %d %s", rec.Code, rec.Body.String())
+		}
+	}
+
+	if rec := do("POST", "/xrpc/com.atproto.repo.createRecord"); rec.Code != 200 {
+		t.Fatalf("expected writes to work in normal mode, got %d", rec.Code)
+	}
+
+	setMode(`{"mode":"read-only","message":"migrating storage"}`)
+	if rec := do("GET", "/xrpc/com.atproto.repo.getRecord"); rec.Code != 200 {
+		t.Fatalf("expected reads in read-only mode, got %d", rec.Code)
+	}
+	if rec := do("POST", "/xrpc/com.atproto.server.createSession"); rec.Code != 200 {
+		t.Fatalf("expected logins in read-only mode, got %d", rec.Code)
+	}
+	rec := do("POST", "/xrpc/com.atproto.repo.createRecord")
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected writes to be rejected in read-only mode, got %d", rec.Code)
+	}
+	if rec := do("POST", "/xrpc/com.atproto.admin.updateSubjectStatus"); rec.Code != 200 {
+		t.Fatalf("expected admin XRPC writes in read-only mode, got %d", rec.Code)
+	}
+	var body map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body["error"] != "ReadOnly" || !strings.Contains(body["message"], "migrating storage") {
+		t.Fatalf("unexpected error body: %v", body)
+	}
+
+	setMode(`{"mode":"maintenance","retryAfter":120}`)
+	rec = do("GET", "/xrpc/com.atproto.repo.getRecord")
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "120" {
+		t.Fatalf("expected 503 with Retry-After in maintenance mode, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := do("GET", "/xrpc/_health"); rec.Code != 200 {
+		t.Fatalf("expected health checks in maintenance mode, got %d", rec.Code)
+	}
+	if rec := do("POST", "/xrpc/com.atproto.admin.updateSubjectStatus"); rec.Code != 200 {
+		t.Fatalf("expected admin XRPC methods in maintenance mode, got %d", rec.Code)
+	}
+
+	// the admin API stays up, so the mode can be switched back
+	setMode(`{"mode":"normal"}`)
+	if rec := do("POST", "/xrpc/com.atproto.repo.createRecord"); rec.Code != 200 {
+		t.Fatalf("expected writes to work again, got %d", rec.Code)
+	}
+
+	if err := s.SetServiceMode("bogus", "", 0); err == nil {
+		t.Fatal("expected error for unknown mode")
+	}
+	if err := s.SetServiceMode(ModeMaintenance, "", 0); err != nil {
+		t.Fatal(err)
+	}
+	if st := s.ServiceMode(); st.RetryAfter != defaultMaintenanceRetryAfter || time.Since(st.Since) > time.Minute {
+		t.Fatalf("unexpected state: %+v", st)
+	}
+}
@@ -0,0 +1,57 @@
+package pds
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var collectionWritesRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "pds_collection_writes_rejected",
+	Help: "Number of record writes rejected by the collection allow/deny policy, by collection and op",
+}, []string{"collection", "op"})
+
+var serviceAuthRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "pds_service_auth_requests",
+	Help: "Number of requests made with inter-service auth tokens, by method and result",
+}, []string{"method", "result"})
+
+var sharedRepoLockWait = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "pds_shared_repo_lock_wait_seconds",
+	Help:    "Time spent waiting for repo write locks shared with other instances",
+	Buckets: prometheus.ExponentialBuckets(0.001, 4, 8),
+})
+
+var signupChecks = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "pds_signup_checks",
+	Help: "Number of account creations checked by the signup protections, by result (allowed, or the reason for rejection)",
+}, []string{"result"})
+
+var relayPushConnected = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "pds_relay_push_connected",
+	Help: "Whether the push connection to each relay is up (1) or not (0)",
+}, []string{"relay"})
+
+var relayPushEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "pds_relay_push_events",
+	Help: "Number of events pushed to each relay",
+}, []string{"relay"})
+
+var blobCacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "pds_blob_cache_requests",
+	Help: "Number of blob reads through the blob cache, by result (hit, miss or error)",
+}, []string{"result"})
+
+var blobCacheBytes = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "pds_blob_cache_bytes",
+	Help: "Total size of blobs in the blob cache",
+})
+
+var outboxEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "pds_outbox_events",
+	Help: "Number of repo events taken from the event outbox, by result (dispatched, duplicate if already emitted, or dropped if undecodable)",
+}, []string{"result"})
+
+var outboxRecovered = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "pds_outbox_recovered",
+	Help: "Number of pending or interrupted commits recovered from the event outbox, by result (sync if the commit was written, rolled_back if not)",
+}, []string{"result"})
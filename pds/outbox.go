@@ -0,0 +1,378 @@
+package pds
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/bluesky-social/indigo/models"
+	"github.com/bluesky-social/indigo/repomgr"
+
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// OutboxConfig enables the event outbox, which makes firehose emission crash
+// consistent. Without it, a crash between a commit being written and its
+// event being emitted loses the event, and consumers never see the commit.
+//
+// The carstore and the PDS database can't share a transaction, so with the
+// outbox, each commit is recorded as pending in the database before it is
+// written to the carstore, and is marked ready (with its event, as committed)
+// once the write succeeds. Ready events are emitted in order by a dispatcher,
+// which records the last rev emitted for each repo, and marks the entry as
+// dispatching, in one transaction before emitting it; the entry is removed
+// once emitted. Commit events at or before a repo's last emitted rev are never
+// emitted again.
+//
+// After a crash, pending entries are resolved against the carstore head: if
+// the commit was written, a #sync event for the repo is emitted so consumers
+// resync; if not, the entry is dropped, so no event is ever emitted for a
+// commit which didn't happen. Entries left dispatching may or may not have
+// been emitted, so they are resolved with a #sync event too.
+type OutboxConfig struct {
+	// Identifies this instance's entries, when several instances share a
+	// database. Defaults to the hostname
+	Instance string
+	// How often to check for entries to dispatch or recover. Defaults to 1s
+	PollInterval time.Duration
+	// How long an entry may stay pending (ie, a commit being written) before
+	// it is assumed to be from a failed write and recovered. Defaults to 1m
+	PendingTimeout time.Duration
+}
+
+const (
+	outboxPending     = "pending"
+	outboxReady       = "ready"
+	outboxDispatching = "dispatching"
+)
+
+// OutboxEvent is a commit, and (once written) its firehose event, waiting to
+// be emitted
+type OutboxEvent struct {
+	ID       uint       `gorm:"primarykey"`
+	Usr      models.Uid `gorm:"index"`
+	Rev      string
+	State    string `gorm:"index"`
+	Instance string `gorm:"index"`
+	// JSON encoded outboxPayload
+	Event     []byte
+	CreatedAt time.Time
+}
+
+// OutboxHead is the last rev of a repo emitted from the outbox
+type OutboxHead struct {
+	Usr models.Uid `gorm:"primarykey"`
+	Rev string
+}
+
+// outboxPayload is the stored form of a queued event. Records are kept
+// separately, as CBOR, since RepoOp.Record can't be decoded from JSON.
+type outboxPayload struct {
+	Event *repomgr.RepoEvent
+	// by index in Event.Ops
+	Records map[int][]byte `json:",omitempty"`
+}
+
+type outbox struct {
+	s       *Server
+	cfg     OutboxConfig
+	started time.Time
+
+	// serializes dispatching, so that events are emitted in order
+	lk sync.Mutex
+}
+
+// SetOutboxConfig enables the event outbox. Must be called before the server
+// starts, and after SetSharedStateConfig (if used). RunOutbox must then be
+// run in the background.
+func (s *Server) SetOutboxConfig(cfg *OutboxConfig) error {
+	if cfg.Instance == "" {
+		cfg.Instance, _ = os.Hostname()
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = time.Second
+	}
+	if cfg.PendingTimeout <= 0 {
+		cfg.PendingTimeout = time.Minute
+	}
+
+	if err := s.db.AutoMigrate(&OutboxEvent{}, &OutboxHead{}); err != nil {
+		return err
+	}
+
+	ob := &outbox{s: s, cfg: *cfg, started: time.Now()}
+	s.repoman.SetCommitJournal(ob)
+	s.repoman.SetEventHandler(func(ctx context.Context, evt *repomgr.RepoEvent) {
+		if err := ob.enqueue(ctx, evt); err != nil {
+			// the entry stays pending, and is recovered later
+			s.log.Error("failed to queue repo event", "user", evt.User, "rev", evt.Rev, "err", err)
+			return
+		}
+		// emit right away, so that writes are visible on the firehose by
+		// the time they return
+		if err := ob.dispatch(ctx); err != nil {
+			s.log.Error("failed to dispatch repo events", "err", err)
+		}
+	}, true)
+	s.outbox = ob
+	return nil
+}
+
+// RunOutbox recovers entries left by an earlier run and commits whose writes
+// failed, and emits events which weren't emitted when their commit was
+// written, until ctx is done. Does nothing if the outbox isn't enabled.
+func (s *Server) RunOutbox(ctx context.Context) {
+	ob := s.outbox
+	if ob == nil {
+		return
+	}
+	t := time.NewTicker(ob.cfg.PollInterval)
+	defer t.Stop()
+
+	for {
+		if err := ob.recover(ctx); err != nil && ctx.Err() == nil {
+			s.log.Error("failed to recover pending commits", "err", err)
+		}
+		if err := ob.dispatch(ctx); err != nil && ctx.Err() == nil {
+			s.log.Error("failed to dispatch repo events", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (ob *outbox) PrepareCommit(ctx context.Context, user models.Uid, rev string) error {
+	return ob.s.db.WithContext(ctx).Create(&OutboxEvent{
+		Usr:      user,
+		Rev:      rev,
+		State:    outboxPending,
+		Instance: ob.cfg.Instance,
+	}).Error
+}
+
+func (ob *outbox) AbortCommit(ctx context.Context, user models.Uid, rev string) {
+	if err := ob.s.db.WithContext(ctx).
+		Where("usr = ? AND rev = ? AND instance = ? AND state = ?", user, rev, ob.cfg.Instance, outboxPending).
+		Delete(&OutboxEvent{}).Error; err != nil {
+		// harmless; recovery finds the commit wasn't written
+		ob.s.log.Warn("failed to remove aborted commit from outbox", "user", user, "rev", rev, "err", err)
+	}
+}
+
+// enqueue marks a commit's entry ready, with its event
+func (ob *outbox) enqueue(ctx context.Context, evt *repomgr.RepoEvent) error {
+	b, err := encodeOutboxEvent(evt)
+	if err != nil {
+		return err
+	}
+	res := ob.s.db.WithContext(ctx).Model(&OutboxEvent{}).
+		Where("usr = ? AND rev = ? AND instance = ? AND state = ?", evt.User, evt.Rev, ob.cfg.Instance, outboxPending).
+		Updates(map[string]any{"state": outboxReady, "event": b})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected > 0 {
+		return nil
+	}
+	// the entry was recovered as a failed write while the write was slow
+	return ob.s.db.WithContext(ctx).Create(&OutboxEvent{
+		Usr:      evt.User,
+		Rev:      evt.Rev,
+		State:    outboxReady,
+		Instance: ob.cfg.Instance,
+		Event:    b,
+	}).Error
+}
+
+// dispatch emits ready events, in the order their commits were prepared
+func (ob *outbox) dispatch(ctx context.Context) error {
+	ob.lk.Lock()
+	defer ob.lk.Unlock()
+
+	for {
+		var entries []OutboxEvent
+		if err := ob.s.db.WithContext(ctx).
+			Where("instance = ? AND state = ?", ob.cfg.Instance, outboxReady).
+			Order("id").Limit(100).Find(&entries).Error; err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+		for _, ent := range entries {
+			if err := ob.dispatchEntry(ctx, &ent); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (ob *outbox) dispatchEntry(ctx context.Context, ent *OutboxEvent) error {
+	evt, err := decodeOutboxEvent(ent.Event)
+	if err != nil {
+		// an event which can't be emitted would block the rest
+		ob.s.log.Error("dropping undecodable repo event", "user", ent.Usr, "rev", ent.Rev, "err", err)
+		outboxEvents.WithLabelValues("dropped").Inc()
+		return ob.s.db.WithContext(ctx).Delete(&OutboxEvent{}, ent.ID).Error
+	}
+
+	emit := true
+	err = ob.s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var head OutboxHead
+		if err := tx.Where("usr = ?", ent.Usr).Limit(1).Find(&head).Error; err != nil {
+			return err
+		}
+		// a sync event is always emitted, since resyncing again is harmless
+		// and it may stand for commits whose events were lost
+		if !evt.Sync && head.Rev != "" && ent.Rev <= head.Rev {
+			emit = false
+			return tx.Delete(&OutboxEvent{}, ent.ID).Error
+		}
+		if ent.Rev > head.Rev {
+			if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&OutboxHead{Usr: ent.Usr, Rev: ent.Rev}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&OutboxEvent{}).Where("id = ?", ent.ID).Update("state", outboxDispatching).Error
+	})
+	if err != nil {
+		return err
+	}
+	if !emit {
+		ob.s.log.Info("skipping repo event which was already emitted", "user", ent.Usr, "rev", ent.Rev)
+		outboxEvents.WithLabelValues("duplicate").Inc()
+		return nil
+	}
+
+	ob.emit(ctx, evt)
+	outboxEvents.WithLabelValues("dispatched").Inc()
+	return ob.s.db.WithContext(ctx).Delete(&OutboxEvent{}, ent.ID).Error
+}
+
+func (ob *outbox) emit(ctx context.Context, evt *repomgr.RepoEvent) {
+	if err := ob.s.indexer.HandleRepoEvent(ctx, evt); err != nil {
+		ob.s.log.Error("handle repo event failed", "user", evt.User, "err", err)
+	}
+	if err := ob.s.updateUsage(ctx, evt); err != nil {
+		ob.s.log.Error("failed to update account usage", "user", evt.User, "err", err)
+	}
+}
+
+// recover resolves pending entries left by this instance before it started,
+// and those pending for longer than the pending timeout, as well as entries
+// left dispatching before it started
+func (ob *outbox) recover(ctx context.Context) error {
+	var entries []OutboxEvent
+	if err := ob.s.db.WithContext(ctx).
+		Where("instance = ? AND ((state = ? AND (created_at < ? OR created_at < ?)) OR (state = ? AND created_at < ?))",
+			ob.cfg.Instance, outboxPending, ob.started, time.Now().Add(-ob.cfg.PendingTimeout), outboxDispatching, ob.started).
+		Order("id").Find(&entries).Error; err != nil {
+		return err
+	}
+	for _, ent := range entries {
+		if err := ob.recoverEntry(ctx, &ent); err != nil {
+			return fmt.Errorf("recovering commit %s for %d: %w", ent.Rev, ent.Usr, err)
+		}
+	}
+	return nil
+}
+
+func (ob *outbox) recoverEntry(ctx context.Context, ent *OutboxEvent) error {
+	rev, err := ob.s.cs.GetUserRepoRev(ctx, ent.Usr)
+	if err != nil {
+		return err
+	}
+	if ent.State == outboxPending && rev < ent.Rev {
+		// the commit wasn't written, so there's nothing to emit
+		res := ob.s.db.WithContext(ctx).Where("id = ? AND state = ?", ent.ID, outboxPending).Delete(&OutboxEvent{})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected > 0 {
+			ob.s.log.Info("dropped commit which was never written", "user", ent.Usr, "rev", ent.Rev)
+			outboxRecovered.WithLabelValues("rolled_back").Inc()
+		}
+		return nil
+	}
+
+	// the commit was written (or was followed by later commits, which may or
+	// may not have been based on it), but its event may never have been
+	// built or emitted, so tell consumers to resync from the current head
+	evt, err := ob.s.repoman.HeadSyncEvent(ctx, ent.Usr)
+	if err != nil {
+		return err
+	}
+	b, err := encodeOutboxEvent(evt)
+	if err != nil {
+		return err
+	}
+	// the write may have finished (and queued its own event) in the meantime
+	res := ob.s.db.WithContext(ctx).Model(&OutboxEvent{}).
+		Where("id = ? AND state = ?", ent.ID, ent.State).
+		Updates(map[string]any{"state": outboxReady, "rev": evt.Rev, "event": b})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected > 0 {
+		ob.s.log.Warn("emitting sync event for commit whose event may have been lost", "user", ent.Usr, "rev", ent.Rev, "state", ent.State, "head", evt.Rev)
+		outboxRecovered.WithLabelValues("sync").Inc()
+	}
+	return ob.dispatch(ctx)
+}
+
+func encodeOutboxEvent(evt *repomgr.RepoEvent) ([]byte, error) {
+	enc := *evt
+	payload := outboxPayload{Event: &enc}
+	enc.Ops = make([]repomgr.RepoOp, len(evt.Ops))
+	for i, op := range evt.Ops {
+		if op.Record != nil {
+			rec, ok := op.Record.(cbg.CBORMarshaler)
+			if !ok {
+				return nil, fmt.Errorf("record %s/%s can't be encoded (%T)", op.Collection, op.Rkey, op.Record)
+			}
+			buf := new(bytes.Buffer)
+			if err := rec.MarshalCBOR(buf); err != nil {
+				return nil, fmt.Errorf("encoding record %s/%s: %w", op.Collection, op.Rkey, err)
+			}
+			if payload.Records == nil {
+				payload.Records = make(map[int][]byte)
+			}
+			payload.Records[i] = buf.Bytes()
+			op.Record = nil
+		}
+		enc.Ops[i] = op
+	}
+	return json.Marshal(&payload)
+}
+
+func decodeOutboxEvent(b []byte) (*repomgr.RepoEvent, error) {
+	var payload outboxPayload
+	if err := json.Unmarshal(b, &payload); err != nil {
+		return nil, err
+	}
+	evt := payload.Event
+	if evt == nil {
+		return nil, fmt.Errorf("no event")
+	}
+	for i, rb := range payload.Records {
+		if i < 0 || i >= len(evt.Ops) {
+			return nil, fmt.Errorf("record for op %d of %d", i, len(evt.Ops))
+		}
+		rec, err := lexutil.CborDecodeValue(rb)
+		if err != nil {
+			return nil, fmt.Errorf("decoding record %s/%s: %w", evt.Ops[i].Collection, evt.Ops[i].Rkey, err)
+		}
+		evt.Ops[i].Record = rec
+	}
+	return evt, nil
+}
@@ -0,0 +1,183 @@
+package pds
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/events"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/bluesky-social/indigo/repomgr"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutbox(t *testing.T) {
+	assert := assert.New(t)
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	if err := s.SetOutboxConfig(&OutboxConfig{Instance: "test"}); err != nil {
+		t.Fatal(err)
+	}
+
+	e := "test@foo.com"
+	p := "password"
+	o, err := s.handleComAtprotoServerCreateAccount(context.Background(), &atproto.ServerCreateAccount_Input{
+		Email:    &e,
+		Password: &p,
+		Handle:   "testman.test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := s.lookupUserByDid(context.Background(), o.Did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), "user", u)
+
+	evts, cancel, err := s.events.Subscribe(ctx, "test", func(*events.XRPCStreamEvent) bool { return true }, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	next := func() *events.XRPCStreamEvent {
+		select {
+		case evt := <-evts:
+			return evt
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for event")
+			return nil
+		}
+	}
+	countEntries := func() int64 {
+		var n int64
+		if err := s.db.Model(&OutboxEvent{}).Count(&n).Error; err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	// writes are emitted through the outbox before they return
+	_, err = s.handleComAtprotoRepoCreateRecord(ctx, &atproto.RepoCreateRecord_Input{
+		Repo:       u.Did,
+		Collection: "app.bsky.feed.post",
+		Record:     &lexutil.LexiconTypeDecoder{Val: &bsky.FeedPost{Text: "hello", CreatedAt: "2024-01-01T00:00:00.000Z"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rev, err := s.cs.GetUserRepoRev(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	evt := next()
+	if assert.NotNil(evt.RepoCommit) {
+		assert.Equal(u.Did, evt.RepoCommit.Repo)
+		assert.Equal(rev, evt.RepoCommit.Rev)
+		assert.Len(evt.RepoCommit.Ops, 1)
+	}
+	assert.Equal(int64(0), countEntries())
+
+	// a commit which was written, but whose event was lost in a crash, is
+	// recovered as a sync event
+	crashed := time.Now().Add(-time.Hour)
+	if err := s.db.Create(&OutboxEvent{Usr: u.ID, Rev: rev, State: outboxPending, Instance: "test", CreatedAt: crashed}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := s.outbox.recover(ctx); err != nil {
+		t.Fatal(err)
+	}
+	evt = next()
+	if assert.NotNil(evt.RepoSync) {
+		assert.Equal(u.Did, evt.RepoSync.Did)
+		assert.Equal(rev, evt.RepoSync.Rev)
+	}
+	assert.Equal(int64(0), countEntries())
+
+	// as is an entry left dispatching, whose event may or may not have been
+	// emitted
+	b, err := encodeOutboxEvent(&repomgr.RepoEvent{User: u.ID, Rev: rev})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.db.Create(&OutboxEvent{Usr: u.ID, Rev: rev, State: outboxDispatching, Instance: "test", Event: b, CreatedAt: crashed}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := s.outbox.recover(ctx); err != nil {
+		t.Fatal(err)
+	}
+	evt = next()
+	if assert.NotNil(evt.RepoSync) {
+		assert.Equal(rev, evt.RepoSync.Rev)
+	}
+	assert.Equal(int64(0), countEntries())
+
+	// a commit event which was already emitted isn't emitted again
+	if err := s.db.Create(&OutboxEvent{Usr: u.ID, Rev: rev, State: outboxReady, Instance: "test", Event: b}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := s.outbox.dispatch(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case evt := <-evts:
+		t.Fatalf("unexpected event for commit which was already emitted: %+v", evt)
+	case <-time.After(100 * time.Millisecond):
+	}
+	assert.Equal(int64(0), countEntries())
+
+	// and one which was never written is dropped
+	if err := s.db.Create(&OutboxEvent{Usr: u.ID, Rev: rev + "z", State: outboxPending, Instance: "test", CreatedAt: crashed}).Error; err != nil {
+		t.Fatal(err)
+	}
+	// other instances' entries are left alone
+	if err := s.db.Create(&OutboxEvent{Usr: u.ID, Rev: rev, State: outboxPending, Instance: "other", CreatedAt: crashed}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := s.outbox.recover(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case evt := <-evts:
+		t.Fatalf("unexpected event for rolled back commit: %+v", evt)
+	case <-time.After(100 * time.Millisecond):
+	}
+	assert.Equal(int64(1), countEntries())
+}
+
+func TestOutboxPayload(t *testing.T) {
+	assert := assert.New(t)
+
+	post := &bsky.FeedPost{LexiconTypeID: "app.bsky.feed.post", Text: "hello", CreatedAt: "2024-01-01T00:00:00.000Z"}
+	b, err := encodeOutboxEvent(&repomgr.RepoEvent{
+		User: 1,
+		Rev:  "3kabc",
+		Ops: []repomgr.RepoOp{
+			{Kind: repomgr.EvtKindCreateRecord, Collection: "app.bsky.feed.post", Rkey: "1", Record: post},
+			{Kind: repomgr.EvtKindDeleteRecord, Collection: "app.bsky.feed.post", Rkey: "2"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// records are stored as committed, not read back from the repo
+	evt, err := decodeOutboxEvent(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal("3kabc", evt.Rev)
+	if assert.Len(evt.Ops, 2) {
+		assert.Equal(post, evt.Ops[0].Record)
+		assert.Nil(evt.Ops[1].Record)
+	}
+
+	_, err = encodeOutboxEvent(&repomgr.RepoEvent{
+		Ops: []repomgr.RepoOp{{Kind: repomgr.EvtKindCreateRecord, Record: map[string]any{"text": "hello"}}},
+	})
+	assert.Error(err)
+}
@@ -0,0 +1,201 @@
+package pds
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/models"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// limit on the total size of a user's stored preferences, as JSON
+	MaxPreferencesSize = 100 * 1024
+	// limit on the number of preference objects a user can store
+	MaxPreferencesCount = 100
+)
+
+// EmailLanguagePrefType is a PDS-specific preference selecting the language
+// of emails sent to the account, as a BCP-47 tag in a "lang" field. It is
+// stored and returned like any other preference, and copied to User.Language
+// on write.
+const EmailLanguagePrefType = "app.bsky.actor.defs#emailLanguagePref"
+
+// ActorPreferences holds a user's app.bsky preferences. The PDS stores them as
+// an opaque JSON array, so preference types this version of indigo doesn't
+// know about are kept and returned as-is.
+type ActorPreferences struct {
+	Usr       models.Uid `gorm:"primarykey"`
+	Prefs     []byte
+	UpdatedAt time.Time
+}
+
+// the app.bsky.actor.getPreferences output, with raw preference objects
+type actorPreferencesOutput struct {
+	Preferences []json.RawMessage `json:"preferences"`
+}
+
+// validatePreferences checks that each preference is an object with an
+// app.bsky $type. Preference types with generated lexicon types must decode
+// as that type; unknown types are accepted without further validation.
+func validatePreferences(prefs []json.RawMessage) error {
+	if len(prefs) > MaxPreferencesCount {
+		return fmt.Errorf("too many preferences (max %d)", MaxPreferencesCount)
+	}
+
+	for i, raw := range prefs {
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &obj); err != nil || obj == nil {
+			return fmt.Errorf("preference %d is not an object", i)
+		}
+		var typ string
+		if err := json.Unmarshal(obj["$type"], &typ); err != nil || typ == "" {
+			return fmt.Errorf("preference %d is missing $type", i)
+		}
+		if !strings.HasPrefix(typ, "app.bsky.") {
+			return fmt.Errorf("preference %d has $type outside the app.bsky namespace: %s", i, typ)
+		}
+
+		var elem appbsky.ActorDefs_Preferences_Elem
+		if err := elem.UnmarshalJSON(raw); err != nil {
+			return fmt.Errorf("invalid %s preference: %w", typ, err)
+		}
+
+		if typ == EmailLanguagePrefType {
+			var lang string
+			if err := json.Unmarshal(obj["lang"], &lang); err != nil {
+				return fmt.Errorf("invalid %s preference: missing lang", typ)
+			}
+			if _, err := syntax.ParseLanguage(lang); err != nil {
+				return fmt.Errorf("invalid %s preference: %w", typ, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// emailLanguage returns the language selected by an EmailLanguagePrefType
+// preference, or an empty string if there is none. The preferences must
+// already have been validated.
+func emailLanguage(prefs []json.RawMessage) string {
+	for _, raw := range prefs {
+		var pref struct {
+			Type string `json:"$type"`
+			Lang string `json:"lang"`
+		}
+		if err := json.Unmarshal(raw, &pref); err != nil {
+			continue
+		}
+		if pref.Type == EmailLanguagePrefType {
+			return pref.Lang
+		}
+	}
+	return ""
+}
+
+func (s *Server) getActorPreferences(ctx context.Context, uid models.Uid) ([]json.RawMessage, error) {
+	var ap ActorPreferences
+	if err := s.db.WithContext(ctx).First(&ap, "usr = ?", uid).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return []json.RawMessage{}, nil
+		}
+		return nil, err
+	}
+
+	var prefs []json.RawMessage
+	if err := json.Unmarshal(ap.Prefs, &prefs); err != nil {
+		return nil, fmt.Errorf("decoding stored preferences: %w", err)
+	}
+	return prefs, nil
+}
+
+func (s *Server) putActorPreferences(ctx context.Context, uid models.Uid, prefs []json.RawMessage) error {
+	if prefs == nil {
+		prefs = []json.RawMessage{}
+	}
+	b, err := json.Marshal(prefs)
+	if err != nil {
+		return err
+	}
+
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "usr"}},
+			DoUpdates: clause.AssignmentColumns([]string{"prefs", "updated_at"}),
+		}).Create(&ActorPreferences{Usr: uid, Prefs: b, UpdatedAt: time.Now()}).Error; err != nil {
+			return err
+		}
+
+		// the email language preference is kept on the user, so sending an
+		// email doesn't have to decode the full set
+		return tx.Model(User{}).Where("id = ?", uid).UpdateColumn("language", emailLanguage(prefs)).Error
+	})
+}
+
+func (s *Server) HandleAppBskyActorGetPreferences(c echo.Context) error {
+	ctx, span := otel.Tracer("server").Start(c.Request().Context(), "HandleAppBskyActorGetPreferences")
+	defer span.End()
+
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+
+	prefs, err := s.getActorPreferences(ctx, u.ID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, &actorPreferencesOutput{Preferences: prefs})
+}
+
+func (s *Server) HandleAppBskyActorPutPreferences(c echo.Context) error {
+	ctx, span := otel.Tracer("server").Start(c.Request().Context(), "HandleAppBskyActorPutPreferences")
+	defer span.End()
+
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+
+	// read one byte past the limit, so oversized bodies can be detected
+	body, err := io.ReadAll(io.LimitReader(c.Request().Body, MaxPreferencesSize+1))
+	if err != nil {
+		return err
+	}
+	if len(body) > MaxPreferencesSize {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("preferences too large (max %d bytes)", MaxPreferencesSize))
+	}
+
+	var in actorPreferencesOutput
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if err := dec.Decode(&in); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid preferences body: %s", err))
+	}
+	if in.Preferences == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "preferences field is required")
+	}
+	if err := validatePreferences(in.Preferences); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if err := s.putActorPreferences(ctx, u.ID, in.Preferences); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusOK)
+}
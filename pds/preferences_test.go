@@ -0,0 +1,112 @@
+package pds
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActorPreferences(t *testing.T) {
+	assert := assert.New(t)
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	e := "prefs@foo.com"
+	p := "password"
+	out, err := s.handleComAtprotoServerCreateAccount(ctx, &atproto.ServerCreateAccount_Input{
+		Email:    &e,
+		Password: &p,
+		Handle:   "prefs.test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := s.lookupUserByDid(ctx, out.Did)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ec := echo.New()
+	withUser := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.SetRequest(c.Request().WithContext(context.WithValue(c.Request().Context(), "user", u)))
+			return next(c)
+		}
+	}
+	ec.GET("/xrpc/app.bsky.actor.getPreferences", s.HandleAppBskyActorGetPreferences, withUser)
+	ec.POST("/xrpc/app.bsky.actor.putPreferences", s.HandleAppBskyActorPutPreferences, withUser)
+
+	put := func(body string) int {
+		req := httptest.NewRequest("POST", "/xrpc/app.bsky.actor.putPreferences", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		ec.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	get := func() []map[string]any {
+		req := httptest.NewRequest("GET", "/xrpc/app.bsky.actor.getPreferences", nil)
+		rec := httptest.NewRecorder()
+		ec.ServeHTTP(rec, req)
+		assert.Equal(http.StatusOK, rec.Code)
+		var out struct {
+			Preferences []map[string]any `json:"preferences"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+			t.Fatal(err)
+		}
+		return out.Preferences
+	}
+
+	// no stored preferences yet
+	assert.Empty(get())
+
+	prefs := `{"preferences": [
+		{"$type": "app.bsky.actor.defs#adultContentPref", "enabled": true},
+		{"$type": "app.bsky.actor.defs#threadViewPref", "sort": "newest"},
+		{"$type": "app.bsky.actor.defs#someFuturePref", "extra": [1, 2, 3]}
+	]}`
+	assert.Equal(http.StatusOK, put(prefs))
+
+	got := get()
+	assert.Len(got, 3)
+	assert.Equal("app.bsky.actor.defs#threadViewPref", got[1]["$type"])
+	assert.Equal("newest", got[1]["sort"])
+	// unknown preference types are round-tripped untouched
+	assert.Equal([]any{float64(1), float64(2), float64(3)}, got[2]["extra"])
+
+	// invalid writes are rejected, and leave stored preferences alone
+	assert.Equal(http.StatusBadRequest, put(`{"preferences": [{"enabled": true}]}`))
+	assert.Equal(http.StatusBadRequest, put(`{"preferences": [{"$type": "com.example.pref"}]}`))
+	assert.Equal(http.StatusBadRequest, put(`{"preferences": [{"$type": "app.bsky.actor.defs#adultContentPref", "enabled": "yes"}]}`))
+	assert.Equal(http.StatusBadRequest, put(`{}`))
+	big := `{"preferences": [{"$type": "app.bsky.actor.defs#hiddenPostsPref", "items": ["` + strings.Repeat("a", MaxPreferencesSize) + `"]}]}`
+	assert.Equal(http.StatusRequestEntityTooLarge, put(big))
+	assert.Len(get(), 3)
+
+	// the email language preference is copied to the user
+	assert.Equal(http.StatusBadRequest, put(`{"preferences": [{"$type": "app.bsky.actor.defs#emailLanguagePref", "lang": "not a language!"}]}`))
+	assert.Equal(http.StatusOK, put(`{"preferences": [{"$type": "app.bsky.actor.defs#emailLanguagePref", "lang": "es"}]}`))
+	u, err = s.lookupUserByDid(ctx, out.Did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal("es", u.Language)
+
+	// writes replace the full set
+	assert.Equal(http.StatusOK, put(`{"preferences": []}`))
+	assert.Empty(get())
+	u, err = s.lookupUserByDid(ctx, out.Did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal("", u.Language)
+}
@@ -0,0 +1,469 @@
+package pds
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/bluesky-social/indigo/models"
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/bluesky-social/indigo/repomgr"
+
+	"github.com/ipfs/go-cid"
+	"github.com/labstack/echo/v4"
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// QuotaError is returned by writes which would take an account over one of
+// its quotas
+type QuotaError struct {
+	// "records", "repoBytes" or "blobBytes"
+	Resource string
+	Used     int64
+	Adding   int64
+	Limit    int64
+}
+
+func (qe *QuotaError) Error() string {
+	return fmt.Sprintf("%s: %s would be %d, limit is %d", ErrQuotaExceeded, qe.Resource, qe.Used+qe.Adding, qe.Limit)
+}
+
+func (qe *QuotaError) Unwrap() error {
+	return ErrQuotaExceeded
+}
+
+// Quota limits an account's storage. Zero means unlimited.
+type Quota struct {
+	MaxRepoBytes int64 `json:"maxRepoBytes"`
+	MaxBlobBytes int64 `json:"maxBlobBytes"`
+	MaxRecords   int64 `json:"maxRecords"`
+}
+
+type QuotaConfig struct {
+	// Applies to accounts without an override (see AccountQuota)
+	Default Quota
+}
+
+// AccountUsage is the storage used by an account. It is calculated from the
+// repo the first time it's needed, and then updated as commits are made:
+// record creates and deletes are counted exactly. RepoBytes grows by the size of each commit,
+// and blobs are counted once referenced by a record but not released when
+// the record is deleted, so both can drift upwards until the usage is
+// recalculated from the repo (see RecalculateUsage).
+type AccountUsage struct {
+	Usr          models.Uid `gorm:"primarykey" json:"-"`
+	RepoBytes    int64      `json:"repoBytes"`
+	BlobBytes    int64      `json:"blobBytes"`
+	Records      int64      `json:"records"`
+	RecomputedAt *time.Time `json:"recomputedAt,omitempty"`
+	UpdatedAt    time.Time  `json:"updatedAt"`
+}
+
+// AccountBlob is a blob referenced by one of an account's records, so that
+// blobs referenced more than once are only counted once
+type AccountBlob struct {
+	Usr  models.Uid   `gorm:"primarykey"`
+	Cid  models.DbCID `gorm:"primarykey"`
+	Size int64
+}
+
+// AccountQuota overrides the default quota for a single account
+type AccountQuota struct {
+	Usr models.Uid `gorm:"primarykey"`
+	Quota
+}
+
+// SetQuotaConfig enables usage accounting and quota enforcement
+func (s *Server) SetQuotaConfig(cfg *QuotaConfig) error {
+	if err := s.db.AutoMigrate(&AccountUsage{}, &AccountBlob{}, &AccountQuota{}); err != nil {
+		return err
+	}
+	s.quotaConfig = cfg
+	// writes are checked with the repo locked, and usage is updated from the
+	// repo event handler (also under the lock), so concurrent writes can't
+	// both pass the check
+	s.repoman.SetWriteCheck(s.checkWriteQuota)
+	return nil
+}
+
+func (s *Server) quotaFor(ctx context.Context, uid models.Uid) (Quota, error) {
+	var aq AccountQuota
+	err := s.db.WithContext(ctx).Find(&aq, "usr = ?", uid).Error
+	if err != nil {
+		return Quota{}, err
+	}
+	if aq.Usr != 0 {
+		return aq.Quota, nil
+	}
+	return s.quotaConfig.Default, nil
+}
+
+// returns the account's usage, calculating it from the repo if it isn't
+// being tracked yet (eg, the account predates quotas being enabled)
+func (s *Server) getUsage(ctx context.Context, uid models.Uid) (*AccountUsage, error) {
+	var au AccountUsage
+	if err := s.db.WithContext(ctx).Find(&au, "usr = ?", uid).Error; err != nil {
+		return nil, err
+	}
+	if au.Usr == 0 {
+		return s.RecalculateUsage(ctx, uid)
+	}
+	return &au, nil
+}
+
+// pendingWrite summarizes a write for quota checks
+type pendingWrite struct {
+	records int64
+	bytes   int64
+	blobs   []data.Blob
+}
+
+func (pw *pendingWrite) add(op *repomgr.RepoOp) error {
+	if op.Kind == repomgr.EvtKindCreateRecord {
+		pw.records++
+	}
+	rec, ok := op.Record.(cbg.CBORMarshaler)
+	if !ok || rec == nil {
+		return nil
+	}
+	buf := new(bytes.Buffer)
+	if err := rec.MarshalCBOR(buf); err != nil {
+		return err
+	}
+	pw.bytes += int64(buf.Len())
+	pw.blobs = append(pw.blobs, recordBlobs(buf.Bytes())...)
+	return nil
+}
+
+// returns the blobs referenced by a CBOR-encoded record
+func recordBlobs(recb []byte) []data.Blob {
+	rec, err := data.UnmarshalCBOR(recb)
+	if err != nil {
+		// not every record is necessarily valid atproto data
+		return nil
+	}
+	return data.ExtractBlobs(rec)
+}
+
+// checkQuota returns a *QuotaError if the write would take the account over
+// its quota
+func (s *Server) checkQuota(ctx context.Context, uid models.Uid, pw *pendingWrite) error {
+	if s.quotaConfig == nil {
+		return nil
+	}
+	q, err := s.quotaFor(ctx, uid)
+	if err != nil {
+		return err
+	}
+	if q == (Quota{}) {
+		return nil
+	}
+	usage, err := s.getUsage(ctx, uid)
+	if err != nil {
+		return err
+	}
+
+	if q.MaxRecords > 0 && pw.records > 0 && usage.Records+pw.records > q.MaxRecords {
+		return &QuotaError{Resource: "records", Used: usage.Records, Adding: pw.records, Limit: q.MaxRecords}
+	}
+	if q.MaxRepoBytes > 0 && usage.RepoBytes+pw.bytes > q.MaxRepoBytes {
+		return &QuotaError{Resource: "repoBytes", Used: usage.RepoBytes, Adding: pw.bytes, Limit: q.MaxRepoBytes}
+	}
+	if q.MaxBlobBytes > 0 && len(pw.blobs) > 0 {
+		newBytes, err := s.newBlobBytes(ctx, uid, pw.blobs)
+		if err != nil {
+			return err
+		}
+		if newBytes > 0 && usage.BlobBytes+newBytes > q.MaxBlobBytes {
+			return &QuotaError{Resource: "blobBytes", Used: usage.BlobBytes, Adding: newBytes, Limit: q.MaxBlobBytes}
+		}
+	}
+	return nil
+}
+
+// returns the total size of the given blobs not already counted against the
+// account
+func (s *Server) newBlobBytes(ctx context.Context, uid models.Uid, blobs []data.Blob) (int64, error) {
+	sizes := make(map[cid.Cid]int64)
+	for _, b := range blobs {
+		sizes[cid.Cid(b.Ref)] = b.Size
+	}
+	cids := make([]models.DbCID, 0, len(sizes))
+	for c := range sizes {
+		cids = append(cids, models.DbCID{CID: c})
+	}
+	var known []AccountBlob
+	if err := s.db.WithContext(ctx).Find(&known, "usr = ? AND cid IN ?", uid, cids).Error; err != nil {
+		return 0, err
+	}
+	for _, ab := range known {
+		delete(sizes, ab.Cid.CID)
+	}
+	var total int64
+	for _, sz := range sizes {
+		total += sz
+	}
+	return total, nil
+}
+
+// repomgr.WriteCheck for local writes. puts of records which don't exist yet
+// arrive as creates, so they count against the record quota
+func (s *Server) checkWriteQuota(ctx context.Context, uid models.Uid, ops []repomgr.RepoOp) error {
+	if s.quotaConfig == nil {
+		return nil
+	}
+	var pw pendingWrite
+	for i := range ops {
+		if err := pw.add(&ops[i]); err != nil {
+			return err
+		}
+	}
+	return s.checkQuota(ctx, uid, &pw)
+}
+
+// accounts for a commit to a local repo. called from the repo event handler
+func (s *Server) updateUsage(ctx context.Context, evt *repomgr.RepoEvent) error {
+	if s.quotaConfig == nil {
+		return nil
+	}
+
+	var records int64
+	var blobs []AccountBlob
+	for _, op := range evt.Ops {
+		switch op.Kind {
+		case repomgr.EvtKindCreateRecord:
+			records++
+		case repomgr.EvtKindDeleteRecord:
+			records--
+		}
+		rec, ok := op.Record.(cbg.CBORMarshaler)
+		if !ok || rec == nil {
+			continue
+		}
+		buf := new(bytes.Buffer)
+		if err := rec.MarshalCBOR(buf); err != nil {
+			continue
+		}
+		for _, b := range recordBlobs(buf.Bytes()) {
+			blobs = append(blobs, AccountBlob{Usr: evt.User, Cid: models.DbCID{CID: cid.Cid(b.Ref)}, Size: b.Size})
+		}
+	}
+
+	if evt.Sync {
+		// the repo was replaced wholesale, so the ops tell us nothing about
+		// the record count; recount in the background (the repo may still be
+		// locked for this write)
+		go func() {
+			if _, err := s.RecalculateUsage(context.Background(), evt.User); err != nil {
+				s.log.Error("failed to recalculate usage after repo import", "user", evt.User, "err", err)
+			}
+		}()
+		return nil
+	}
+
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// accounts without usage yet are counted from scratch on first use
+		// (see getUsage), which will include this commit
+		var n int64
+		if err := tx.Model(&AccountUsage{}).Where("usr = ?", evt.User).Count(&n).Error; err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+
+		var blobBytes int64
+		for _, ab := range blobs {
+			res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&ab)
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected > 0 {
+				blobBytes += ab.Size
+			}
+		}
+
+		return tx.Model(&AccountUsage{}).Where("usr = ?", evt.User).Updates(map[string]any{
+			"repo_bytes": gorm.Expr("repo_bytes + ?", len(evt.RepoSlice)),
+			"blob_bytes": gorm.Expr("blob_bytes + ?", blobBytes),
+			"records":    gorm.Expr("records + ?", records),
+		}).Error
+	})
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	cw.n += int64(len(p))
+	return len(p), nil
+}
+
+// RecalculateUsage recomputes an account's usage exactly, from a full export
+// of its repo: the size of the CAR file, the number of records, and the
+// distinct blobs they reference.
+func (s *Server) RecalculateUsage(ctx context.Context, uid models.Uid) (*AccountUsage, error) {
+	if s.quotaConfig == nil {
+		return nil, fmt.Errorf("quotas are not enabled")
+	}
+
+	var carBuf bytes.Buffer
+	cw := &countingWriter{}
+	if err := s.repoman.ReadRepo(ctx, uid, "", io.MultiWriter(&carBuf, cw)); err != nil {
+		return nil, fmt.Errorf("reading repo: %w", err)
+	}
+	r, err := repo.ReadRepoFromCar(ctx, &carBuf)
+	if err != nil {
+		return nil, fmt.Errorf("parsing repo: %w", err)
+	}
+
+	now := time.Now()
+	usage := &AccountUsage{Usr: uid, RepoBytes: cw.n, RecomputedAt: &now}
+	blobs := make(map[cid.Cid]int64)
+	if err := r.ForEach(ctx, "", func(k string, _ cid.Cid) error {
+		usage.Records++
+		_, recb, err := r.GetRecordBytes(ctx, k)
+		if err != nil {
+			return err
+		}
+		for _, b := range recordBlobs(*recb) {
+			blobs[cid.Cid(b.Ref)] = b.Size
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("walking repo records: %w", err)
+	}
+
+	rows := make([]AccountBlob, 0, len(blobs))
+	for c, sz := range blobs {
+		rows = append(rows, AccountBlob{Usr: uid, Cid: models.DbCID{CID: c}, Size: sz})
+		usage.BlobBytes += sz
+	}
+
+	if err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&AccountBlob{}, "usr = ?", uid).Error; err != nil {
+			return err
+		}
+		if len(rows) > 0 {
+			if err := tx.CreateInBatches(rows, 500).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(usage).Error
+	}); err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+type usageResponse struct {
+	Did   string        `json:"did"`
+	Usage *AccountUsage `json:"usage"`
+	Quota Quota         `json:"quota"`
+}
+
+func (s *Server) usageResponse(ctx context.Context, u *User) (*usageResponse, error) {
+	usage, err := s.getUsage(ctx, u.ID)
+	if err != nil {
+		return nil, err
+	}
+	q, err := s.quotaFor(ctx, u.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &usageResponse{Did: u.Did, Usage: usage, Quota: q}, nil
+}
+
+// HandleAccountUsage returns the authenticated user's storage usage and quota
+func (s *Server) HandleAccountUsage(c echo.Context) error {
+	ctx := c.Request().Context()
+	if s.quotaConfig == nil {
+		return echo.NewHTTPError(http.StatusNotImplemented, "quotas are not enabled")
+	}
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+	resp, err := s.usageResponse(ctx, u)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// HandleAdminGetUsage returns an account's storage usage and quota. With
+// ?recalculate=true, usage is recomputed from the repo first.
+func (s *Server) HandleAdminGetUsage(c echo.Context) error {
+	ctx := c.Request().Context()
+	if s.quotaConfig == nil {
+		return echo.NewHTTPError(http.StatusNotImplemented, "quotas are not enabled")
+	}
+	u, err := s.lookupUser(ctx, c.QueryParam("did"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if c.QueryParam("recalculate") == "true" {
+		if _, err := s.RecalculateUsage(ctx, u.ID); err != nil {
+			return err
+		}
+	}
+	resp, err := s.usageResponse(ctx, u)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+type setQuotaRequest struct {
+	Did string `json:"did"`
+	// Remove the override, so the default quota applies
+	Reset bool `json:"reset"`
+	Quota
+}
+
+// HandleAdminSetQuota overrides the default quota for one account
+func (s *Server) HandleAdminSetQuota(c echo.Context) error {
+	ctx := c.Request().Context()
+	if s.quotaConfig == nil {
+		return echo.NewHTTPError(http.StatusNotImplemented, "quotas are not enabled")
+	}
+	var req setQuotaRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if req.MaxRepoBytes < 0 || req.MaxBlobBytes < 0 || req.MaxRecords < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "quotas must not be negative")
+	}
+	u, err := s.lookupUser(ctx, req.Did)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	if req.Reset {
+		if err := s.db.WithContext(ctx).Delete(&AccountQuota{}, "usr = ?", u.ID).Error; err != nil {
+			return err
+		}
+	} else {
+		aq := AccountQuota{Usr: u.ID, Quota: req.Quota}
+		if err := s.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(&aq).Error; err != nil {
+			return err
+		}
+	}
+	s.log.Info("set account quota", "did", u.Did, "quota", req.Quota, "reset", req.Reset)
+
+	resp, err := s.usageResponse(ctx, u)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, resp)
+}
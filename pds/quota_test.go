@@ -0,0 +1,271 @@
+package pds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuotaRecords(t *testing.T) {
+	assert := assert.New(t)
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	if err := s.SetQuotaConfig(&QuotaConfig{}); err != nil {
+		t.Fatal(err)
+	}
+
+	e := "test@foo.com"
+	p := "password"
+	o, err := s.handleComAtprotoServerCreateAccount(context.Background(), &atproto.ServerCreateAccount_Input{
+		Email:    &e,
+		Password: &p,
+		Handle:   "testman.test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := s.lookupUserByDid(context.Background(), o.Did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), "user", u)
+
+	// new accounts start with a profile record
+	initial, err := s.getUsage(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := initial.Records
+	s.quotaConfig.Default.MaxRecords = base + 3
+
+	create := func(i int) (*atproto.RepoCreateRecord_Output, error) {
+		return s.handleComAtprotoRepoCreateRecord(ctx, &atproto.RepoCreateRecord_Input{
+			Repo:       u.Did,
+			Collection: "app.bsky.feed.post",
+			Record:     &lexutil.LexiconTypeDecoder{Val: &bsky.FeedPost{Text: fmt.Sprintf("post %d", i), CreatedAt: "2024-01-01T00:00:00.000Z"}},
+		})
+	}
+
+	var last *atproto.RepoCreateRecord_Output
+	for i := 0; i < 3; i++ {
+		last, err = create(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = create(3)
+	var qerr *QuotaError
+	assert.True(errors.As(err, &qerr))
+	assert.True(errors.Is(err, ErrQuotaExceeded))
+	assert.Equal("records", qerr.Resource)
+
+	put := func(rkey string) error {
+		_, err := s.handleComAtprotoRepoPutRecord(ctx, &atproto.RepoPutRecord_Input{
+			Repo:       u.Did,
+			Collection: "app.bsky.feed.post",
+			Rkey:       rkey,
+			Record:     &lexutil.LexiconTypeDecoder{Val: &bsky.FeedPost{Text: "edited", CreatedAt: "2024-01-01T00:00:00.000Z"}},
+		})
+		return err
+	}
+	rkey := last.Uri[strings.LastIndex(last.Uri, "/")+1:]
+
+	// a put with a new rkey creates a record, so counts against the quota
+	err = put("3kaaaaaaaaaaa")
+	assert.True(errors.As(err, &qerr))
+	assert.Equal("records", qerr.Resource)
+	// but updating an existing record doesn't
+	assert.NoError(put(rkey))
+
+	usage, err := s.getUsage(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(base+3, usage.Records)
+	assert.Greater(usage.RepoBytes, initial.RepoBytes)
+
+	// deleting a record frees up room for another
+	assert.NoError(s.handleComAtprotoRepoDeleteRecord(ctx, &atproto.RepoDeleteRecord_Input{
+		Repo:       u.Did,
+		Collection: "app.bsky.feed.post",
+		Rkey:       rkey,
+	}))
+	_, err = create(4)
+	assert.NoError(err)
+
+	// recalculation agrees on the record count
+	recalc, err := s.RecalculateUsage(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(base+3, recalc.Records)
+	assert.NotNil(recalc.RecomputedAt)
+
+	// applyWrites counts every create in the batch
+	var writes []*atproto.RepoApplyWrites_Input_Writes_Elem
+	for i := 0; i < 2; i++ {
+		writes = append(writes, &atproto.RepoApplyWrites_Input_Writes_Elem{RepoApplyWrites_Create: &atproto.RepoApplyWrites_Create{
+			Collection: "app.bsky.feed.post",
+			Value:      &lexutil.LexiconTypeDecoder{Val: &bsky.FeedPost{Text: fmt.Sprintf("batch %d", i), CreatedAt: "2024-01-01T00:00:00.000Z"}},
+		}})
+	}
+	s.quotaConfig.Default.MaxRecords = base + 4
+	err = s.handleComAtprotoRepoApplyWrites(ctx, &atproto.RepoApplyWrites_Input{Repo: u.Did, Writes: writes})
+	assert.True(errors.As(err, &qerr))
+	assert.Equal(int64(2), qerr.Adding)
+	assert.NoError(s.handleComAtprotoRepoApplyWrites(ctx, &atproto.RepoApplyWrites_Input{Repo: u.Did, Writes: writes[:1]}))
+
+	// a per-account override takes precedence over the default
+	assert.NoError(s.db.Create(&AccountQuota{Usr: u.ID, Quota: Quota{MaxRecords: base + 10}}).Error)
+	_, err = create(5)
+	assert.NoError(err)
+}
+
+func TestQuotaConcurrentWrites(t *testing.T) {
+	assert := assert.New(t)
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	if err := s.SetQuotaConfig(&QuotaConfig{}); err != nil {
+		t.Fatal(err)
+	}
+
+	e := "test@foo.com"
+	p := "password"
+	o, err := s.handleComAtprotoServerCreateAccount(context.Background(), &atproto.ServerCreateAccount_Input{
+		Email:    &e,
+		Password: &p,
+		Handle:   "testman.test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := s.lookupUserByDid(context.Background(), o.Did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), "user", u)
+
+	initial, err := s.getUsage(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.quotaConfig.Default.MaxRecords = initial.Records + 1
+
+	// only one of these can fit in the quota
+	var wg sync.WaitGroup
+	errs := make([]error, 5)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = s.handleComAtprotoRepoCreateRecord(ctx, &atproto.RepoCreateRecord_Input{
+				Repo:       u.Did,
+				Collection: "app.bsky.feed.post",
+				Record:     &lexutil.LexiconTypeDecoder{Val: &bsky.FeedPost{Text: fmt.Sprintf("post %d", i), CreatedAt: "2024-01-01T00:00:00.000Z"}},
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	var ok int
+	for _, err := range errs {
+		if err == nil {
+			ok++
+		} else {
+			assert.True(errors.Is(err, ErrQuotaExceeded), err.Error())
+		}
+	}
+	assert.Equal(1, ok)
+
+	usage, err := s.RecalculateUsage(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(initial.Records+1, usage.Records)
+}
+
+func TestQuotaBlobs(t *testing.T) {
+	assert := assert.New(t)
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	if err := s.SetQuotaConfig(&QuotaConfig{Default: Quota{MaxBlobBytes: 100}}); err != nil {
+		t.Fatal(err)
+	}
+
+	e := "test@foo.com"
+	p := "password"
+	o, err := s.handleComAtprotoServerCreateAccount(context.Background(), &atproto.ServerCreateAccount_Input{
+		Email:    &e,
+		Password: &p,
+		Handle:   "testman.test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := s.lookupUserByDid(context.Background(), o.Did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), "user", u)
+
+	avatar, err := cid.Decode("bafkreiccldh766hwcnuxnf2wh6jgzepf2nlu2lvcllt63eww5p6chi4ity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	putProfile := func(size int64) error {
+		_, err := s.handleComAtprotoRepoPutRecord(ctx, &atproto.RepoPutRecord_Input{
+			Repo:       u.Did,
+			Collection: "app.bsky.actor.profile",
+			Rkey:       "self",
+			Record: &lexutil.LexiconTypeDecoder{Val: &bsky.ActorProfile{
+				Avatar: &lexutil.LexBlob{Ref: lexutil.LexLink(avatar), MimeType: "image/jpeg", Size: size},
+			}},
+		})
+		return err
+	}
+
+	assert.NoError(putProfile(80))
+	usage, err := s.getUsage(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(int64(80), usage.BlobBytes)
+
+	// the same blob again isn't counted twice
+	assert.NoError(putProfile(80))
+	usage, err = s.getUsage(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(int64(80), usage.BlobBytes)
+
+	// a different, large blob is rejected
+	other, err := cid.Decode("bafkreie7q3iidccmpvszul7kudcvvuavuo7u6gzlbobczuk5nqk3b4akba")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.handleComAtprotoRepoPutRecord(ctx, &atproto.RepoPutRecord_Input{
+		Repo:       u.Did,
+		Collection: "app.bsky.actor.profile",
+		Rkey:       "self",
+		Record: &lexutil.LexiconTypeDecoder{Val: &bsky.ActorProfile{
+			Banner: &lexutil.LexBlob{Ref: lexutil.LexLink(other), MimeType: "image/jpeg", Size: 50},
+		}},
+	})
+	var qerr *QuotaError
+	assert.True(errors.As(err, &qerr))
+	assert.Equal("blobBytes", qerr.Resource)
+}
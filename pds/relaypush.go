@@ -0,0 +1,137 @@
+package pds
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/events"
+
+	"github.com/carlmjohnson/versioninfo"
+	"github.com/gorilla/websocket"
+)
+
+// header on the relay's push connection response, with the last sequence
+// number it has from us
+const relayPushCursorHeader = "Atproto-Relay-Cursor"
+
+// RelayPushConfig has the PDS connect out to a relay and push its event
+// stream, instead of waiting for the relay to dial in. This lets a PDS without
+// public ingress (eg, hosted at home behind NAT) still be crawled.
+type RelayPushConfig struct {
+	// Base URL of the relay, eg "wss://relay.example.com"
+	URL string
+	// Push token for this PDS's hostname, issued by the relay's admin
+	Token string
+}
+
+// RunRelayPush pushes events to the relay until ctx is cancelled,
+// reconnecting as needed. The relay tells us where to resume from on each
+// connection.
+func (s *Server) RunRelayPush(ctx context.Context, cfg *RelayPushConfig) {
+	log := s.log.With("relay", cfg.URL)
+	var backoff int
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		sent, err := s.relayPush(ctx, cfg)
+		relayPushConnected.WithLabelValues(cfg.URL).Set(0)
+		if err != nil && ctx.Err() == nil {
+			log.Warn("relay push connection failed", "err", err, "backoff", backoff)
+		}
+
+		if sent > 0 {
+			backoff = 0
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(relayPushBackoff(backoff)):
+		}
+		if backoff < 10 {
+			backoff++
+		}
+	}
+}
+
+func relayPushBackoff(b int) time.Duration {
+	if b == 0 {
+		return time.Second
+	}
+	return min(time.Second<<b, time.Minute)
+}
+
+// relayPush runs one push connection, returning the number of events sent
+func (s *Server) relayPush(ctx context.Context, cfg *RelayPushConfig) (int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	d := websocket.Dialer{
+		HandshakeTimeout: time.Second * 10,
+	}
+	hdr := http.Header{}
+	hdr.Set("Authorization", "Bearer "+cfg.Token)
+	hdr.Set("User-Agent", "indigo-pds/"+versioninfo.Short())
+
+	con, resp, err := d.DialContext(ctx, strings.TrimSuffix(cfg.URL, "/")+"/push/subscribeRepos", hdr)
+	if err != nil {
+		if resp != nil {
+			return 0, fmt.Errorf("dialing relay: %w (status %d)", err, resp.StatusCode)
+		}
+		return 0, fmt.Errorf("dialing relay: %w", err)
+	}
+	defer con.Close()
+
+	cursor, err := strconv.ParseInt(resp.Header.Get(relayPushCursorHeader), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("relay sent bad cursor %q: %w", resp.Header.Get(relayPushCursorHeader), err)
+	}
+	relayPushConnected.WithLabelValues(cfg.URL).Set(1)
+
+	// the relay never sends data messages, but we need to read to process
+	// control frames and notice disconnects
+	go func() {
+		for {
+			if _, _, err := con.ReadMessage(); err != nil {
+				cancel()
+				return
+			}
+		}
+	}()
+
+	var since *int64
+	if cursor > 0 {
+		since = &cursor
+	}
+	s.log.Info("pushing events to relay", "relay", cfg.URL, "cursor", cursor)
+
+	evts, cleanup, err := s.events.Subscribe(ctx, "relay-push-"+cfg.URL, func(evt *events.XRPCStreamEvent) bool { return true }, since)
+	if err != nil {
+		return 0, err
+	}
+	defer cleanup()
+
+	sent := 0
+	for {
+		select {
+		case evt, ok := <-evts:
+			if !ok {
+				return sent, fmt.Errorf("event stream closed")
+			}
+			if err := events.WriteEvent(con, evt); err != nil {
+				return sent, err
+			}
+			sent++
+			relayPushEvents.WithLabelValues(cfg.URL).Inc()
+		case <-ctx.Done():
+			return sent, nil
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package pds
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/events/schedulers/sequential"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelayPush(t *testing.T) {
+	assert := assert.New(t)
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	type conn struct {
+		auth string
+		seqs chan int64
+	}
+	conns := make(chan *conn, 2)
+	relay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/push/subscribeRepos" {
+			http.NotFound(w, r)
+			return
+		}
+		c := &conn{auth: r.Header.Get("Authorization"), seqs: make(chan int64, 100)}
+		hdr := http.Header{}
+		hdr.Set(relayPushCursorHeader, "0")
+		con, err := websocket.Upgrade(w, r, hdr, 1<<10, 1<<10)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conns <- c
+		sched := sequential.NewScheduler("test", func(ctx context.Context, evt *events.XRPCStreamEvent) error {
+			c.seqs <- evt.Sequence()
+			return nil
+		})
+		events.HandleRepoStream(r.Context(), con, sched, nil)
+	}))
+	defer relay.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.RunRelayPush(ctx, &RelayPushConfig{
+		URL:   "ws" + strings.TrimPrefix(relay.URL, "http"),
+		Token: "push-token",
+	})
+
+	var c *conn
+	select {
+	case c = <-conns:
+	case <-time.After(5 * time.Second):
+		t.Fatal("PDS didn't connect to relay")
+	}
+	assert.Equal("Bearer push-token", c.auth)
+
+	// the PDS subscribes to its own events just after connecting, so keep
+	// creating accounts until one is seen
+	p := "password"
+	deadline := time.After(5 * time.Second)
+	for i := 0; ; i++ {
+		e := fmt.Sprintf("pusher%d@foo.com", i)
+		if _, err := s.handleComAtprotoServerCreateAccount(ctx, &atproto.ServerCreateAccount_Input{
+			Email:    &e,
+			Password: &p,
+			Handle:   fmt.Sprintf("pusher%d.test", i),
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case seq := <-c.seqs:
+			assert.True(seq > 0)
+			return
+		case <-time.After(100 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("relay didn't receive any events")
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package pds
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// SecurityConfig controls the CORS policy, security headers, and request body
+// size limits applied to all PDS HTTP routes.
+type SecurityConfig struct {
+	// Origins allowed to make cross-origin requests. Empty allows any origin
+	CORSAllowOrigins []string
+	// Request headers allowed on cross-origin requests
+	CORSAllowHeaders []string
+	// How long (in seconds) browsers may cache preflight responses. Zero omits the header
+	CORSMaxAge int
+
+	// If non-zero, a Strict-Transport-Security header is sent with this max-age (in seconds)
+	HSTSMaxAge            int
+	HSTSIncludeSubdomains bool
+
+	// Default maximum request body size, in bytes. Zero for no limit
+	MaxBodySize int64
+	// Per-route overrides of MaxBodySize, keyed by route path (eg, "/xrpc/com.atproto.repo.uploadBlob"). Zero for no limit
+	RouteBodyLimits map[string]int64
+}
+
+func DefaultSecurityConfig() *SecurityConfig {
+	return &SecurityConfig{
+		CORSAllowOrigins: []string{"*"},
+		CORSAllowHeaders: []string{
+			echo.HeaderOrigin,
+			echo.HeaderContentType,
+			echo.HeaderAccept,
+			echo.HeaderAuthorization,
+			"atproto-proxy",
+			"atproto-accept-labelers",
+		},
+		CORSMaxAge:  86400,
+		MaxBodySize: 1 << 20,
+		RouteBodyLimits: map[string]int64{
+			"/xrpc/com.atproto.repo.uploadBlob": 50 << 20,
+		},
+	}
+}
+
+func (s *Server) SetSecurityConfig(cfg *SecurityConfig) {
+	s.securityConfig = cfg
+}
+
+// installs CORS, security header, and body limit middleware on the echo instance
+func (s *Server) installSecurityMiddleware(e *echo.Echo) {
+	cfg := s.securityConfig
+	if cfg == nil {
+		cfg = DefaultSecurityConfig()
+	}
+
+	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
+		AllowOrigins: cfg.CORSAllowOrigins,
+		AllowHeaders: cfg.CORSAllowHeaders,
+		MaxAge:       cfg.CORSMaxAge,
+	}))
+
+	e.Use(middleware.SecureWithConfig(middleware.SecureConfig{
+		ContentTypeNosniff:    "nosniff",
+		XFrameOptions:         "DENY",
+		ReferrerPolicy:        "no-referrer",
+		HSTSMaxAge:            cfg.HSTSMaxAge,
+		HSTSExcludeSubdomains: !cfg.HSTSIncludeSubdomains,
+	}))
+
+	e.Use(bodyLimitMiddleware(cfg))
+}
+
+// like echo's BodyLimit middleware, but with per-route limits. Requests which
+// declare a too-large Content-Length are rejected up front; otherwise the body
+// is wrapped so that reading past the limit fails.
+func bodyLimitMiddleware(cfg *SecurityConfig) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			limit := cfg.MaxBodySize
+			if l, ok := cfg.RouteBodyLimits[c.Path()]; ok {
+				limit = l
+			}
+			if limit <= 0 {
+				return next(c)
+			}
+
+			req := c.Request()
+			if req.ContentLength > limit {
+				return echo.NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("request body too large (max %d bytes)", limit))
+			}
+			req.Body = http.MaxBytesReader(c.Response(), req.Body, limit)
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package pds
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestSecurityMiddleware(t *testing.T) {
+	cfg := DefaultSecurityConfig()
+	cfg.CORSAllowOrigins = []string{"https://app.example.com"}
+	cfg.HSTSMaxAge = 3600
+	cfg.MaxBodySize = 10
+	cfg.RouteBodyLimits["/xrpc/com.atproto.repo.uploadBlob"] = 100
+
+	s := &Server{}
+	s.SetSecurityConfig(cfg)
+
+	e := echo.New()
+	s.installSecurityMiddleware(e)
+	echoBody := func(c echo.Context) error {
+		b, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusRequestEntityTooLarge, err.Error())
+		}
+		return c.String(200, string(b))
+	}
+	e.POST("/xrpc/com.atproto.repo.createRecord", echoBody)
+	e.POST("/xrpc/com.atproto.repo.uploadBlob", echoBody)
+
+	do := func(path, body string, chunked bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Origin", "https://app.example.com")
+		// simulate HTTPS so HSTS is sent
+		req.Header.Set(echo.HeaderXForwardedProto, "https")
+		if chunked {
+			req.ContentLength = -1
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do("/xrpc/com.atproto.repo.createRecord", "small", false)
+	if rec.Code != 200 {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if rec.Header().Get(echo.HeaderAccessControlAllowOrigin) != "https://app.example.com" {
+		t.Fatalf("missing CORS header: %v", rec.Header())
+	}
+	if rec.Header().Get(echo.HeaderStrictTransportSecurity) != "max-age=3600" {
+		t.Fatalf("unexpected HSTS header: %q", rec.Header().Get(echo.HeaderStrictTransportSecurity))
+	}
+	if rec.Header().Get(echo.HeaderXContentTypeOptions) != "nosniff" {
+		t.Fatal("missing nosniff header")
+	}
+
+	// JSON routes use the default limit
+	if rec := do("/xrpc/com.atproto.repo.createRecord", strings.Repeat("x", 50), false); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %d", rec.Code)
+	}
+	if rec := do("/xrpc/com.atproto.repo.createRecord", strings.Repeat("x", 50), true); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413 for chunked body, got %d", rec.Code)
+	}
+
+	// blob uploads have their own limit
+	if rec := do("/xrpc/com.atproto.repo.uploadBlob", strings.Repeat("x", 50), false); rec.Code != 200 {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if rec := do("/xrpc/com.atproto.repo.uploadBlob", strings.Repeat("x", 150), false); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %d", rec.Code)
+	}
+}
@@ -11,11 +11,13 @@ import (
 	"net/mail"
 	"net/url"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/bluesky-social/indigo/api/atproto"
 	comatproto "github.com/bluesky-social/indigo/api/atproto"
 	bsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/identity/handlepolicy"
 	"github.com/bluesky-social/indigo/carstore"
 	"github.com/bluesky-social/indigo/events"
 	"github.com/bluesky-social/indigo/indexer"
@@ -33,7 +35,6 @@ import (
 	"github.com/ipfs/go-cid"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
-	"github.com/lestrrat-go/jwx/v2/jwt"
 	"github.com/whyrusleeping/go-did"
 	"gorm.io/gorm"
 )
@@ -51,11 +52,38 @@ type Server struct {
 	jwtSigningKey  []byte
 	enforcePeering bool
 
-	handleSuffix string
-	serviceUrl   string
+	handleDomains []HandleDomain
+	serviceUrl    string
 
 	plc plc.PLCClient
 
+	emailTemplates *EmailTemplates
+	mailer         Mailer
+	adminPassword  string
+
+	securityConfig *SecurityConfig
+	takeoutConfig  *TakeoutConfig
+	blobConfig     *BlobConfig
+	blobCache      *blobCache
+	handlePolicy   *handlepolicy.Policy
+	auditLog       *AuditLog
+	serviceMode    atomic.Pointer[ServiceModeState]
+	quotaConfig    *QuotaConfig
+	signupConfig   *SignupConfig
+	ipExtractor    echo.IPExtractor
+
+	collectionPolicy *CollectionPolicy
+	twoFactorConfig  *TwoFactorConfig
+
+	serviceAuthConfig *ServiceAuthConfig
+	serviceAuthNonces nonceStore
+
+	shared *sharedState
+	outbox *outbox
+
+	labelerConfig *LabelerConfig
+	labelEvents   *events.EventManager
+
 	log *slog.Logger
 }
 
@@ -69,6 +97,9 @@ const serverListenerBootTimeout = 5 * time.Second
 func NewServer(db *gorm.DB, cs carstore.CarStore, serkey *did.PrivKey, handleSuffix, serviceUrl string, didr plc.PLCClient, jwtkey []byte) (*Server, error) {
 	db.AutoMigrate(&User{})
 	db.AutoMigrate(&Peering{})
+	db.AutoMigrate(&ActorPreferences{})
+	db.AutoMigrate(&InviteCode{})
+	db.AutoMigrate(&RefreshToken{})
 
 	evtman := events.NewEventManager(events.NewMemPersister())
 
@@ -84,6 +115,11 @@ func NewServer(db *gorm.DB, cs carstore.CarStore, serkey *did.PrivKey, handleSuf
 		return nil, err
 	}
 
+	emailTemplates, err := NewEmailTemplates("")
+	if err != nil {
+		return nil, err
+	}
+
 	s := &Server{
 		signingKey:     serkey,
 		db:             db,
@@ -93,10 +129,11 @@ func NewServer(db *gorm.DB, cs carstore.CarStore, serkey *did.PrivKey, handleSuf
 		plc:            didr,
 		events:         evtman,
 		repoman:        repoman,
-		handleSuffix:   handleSuffix,
+		handleDomains:  []HandleDomain{{Suffix: strings.ToLower(handleSuffix)}},
 		serviceUrl:     serviceUrl,
 		jwtSigningKey:  jwtkey,
 		enforcePeering: false,
+		emailTemplates: emailTemplates,
 
 		log: slog.Default().With("system", "pds"),
 	}
@@ -105,6 +142,9 @@ func NewServer(db *gorm.DB, cs carstore.CarStore, serkey *did.PrivKey, handleSuf
 		if err := ix.HandleRepoEvent(ctx, evt); err != nil {
 			s.log.Error("handle repo event failed", "user", evt.User, "err", err)
 		}
+		if err := s.updateUsage(ctx, evt); err != nil {
+			s.log.Error("failed to update account usage", "user", evt.User, "err", err)
+		}
 	}, true)
 
 	//ix.SendRemoteFollow = s.sendRemoteFollow
@@ -246,10 +286,11 @@ func (s *Server) repoEventToFedEvent(ctx context.Context, evt *repomgr.RepoEvent
 	}
 
 	out := &comatproto.SyncSubscribeRepos_Commit{
-		Prev:   (*lexutil.LexLink)(evt.OldRoot),
-		Blocks: evt.RepoSlice,
-		Repo:   did,
-		Time:   time.Now().Format(bsutil.ISO8601),
+		Prev:     (*lexutil.LexLink)(evt.OldRoot),
+		PrevData: (*lexutil.LexLink)(evt.PrevData),
+		Blocks:   evt.RepoSlice,
+		Repo:     did,
+		Time:     time.Now().Format(bsutil.ISO8601),
 		//PrivUid: evt.User,
 	}
 
@@ -258,6 +299,7 @@ func (s *Server) repoEventToFedEvent(ctx context.Context, evt *repomgr.RepoEvent
 			Path:   op.Collection + "/" + op.Rkey,
 			Action: string(op.Kind),
 			Cid:    (*lexutil.LexLink)(op.RecCid),
+			Prev:   (*lexutil.LexLink)(op.PrevCid),
 		})
 	}
 
@@ -294,17 +336,30 @@ func (s *Server) RunAPIWithListener(listen net.Listener) error {
 	e := echo.New()
 	s.echo = e
 	e.HideBanner = true
+	e.IPExtractor = s.clientIPExtractor()
 	e.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{
 		Format: "method=${method}, uri=${uri}, status=${status} latency=${latency_human}\n",
 	}))
+	s.installSecurityMiddleware(e)
+	e.Use(auditContextMiddleware)
+	e.Use(captchaContextMiddleware)
+	e.Use(s.serviceModeMiddleware)
 
 	cfg := middleware.JWTConfig{
 		Skipper: func(c echo.Context) bool {
+			// already authenticated by serviceAuthMiddleware
+			if c.Get("serviceAuth") != nil {
+				return true
+			}
 			switch c.Path() {
 			case "/xrpc/_health":
 				return true
 			case "/xrpc/com.atproto.sync.subscribeRepos":
 				return true
+			case "/xrpc/com.atproto.label.subscribeLabels":
+				return true
+			case "/xrpc/com.atproto.sync.getBlob":
+				return true
 			case "/xrpc/com.atproto.account.create":
 				return true
 			case "/xrpc/com.atproto.identity.resolveHandle":
@@ -337,11 +392,24 @@ func (s *Server) RunAPIWithListener(listen net.Listener) error {
 				return true
 			case "/reactivateRepo":
 				return true
+			case "/takeout/download":
+				return true
+			case "/account/2fa/email-code":
+				return true
 			default:
-				return false
+				// admin routes use their own basic auth
+				return strings.HasPrefix(c.Path(), "/admin/")
 			}
 		},
 		SigningKey: s.jwtSigningKey,
+		ErrorHandlerWithContext: func(err error, c echo.Context) error {
+			s.audit(c.Request().Context(), &AuditEvent{
+				Type:    AuditAuthFailed,
+				Reason:  err.Error(),
+				Details: map[string]string{"path": c.Path()},
+			})
+			return err
+		},
 	}
 
 	e.HTTPErrorHandler = func(err error, ctx echo.Context) {
@@ -355,6 +423,47 @@ func (s *Server) RunAPIWithListener(listen net.Listener) error {
 			return
 		}
 
+		var cerr *CollectionError
+		if errors.As(err, &cerr) {
+			ctx.JSON(http.StatusBadRequest, map[string]string{
+				"error":   "InvalidRequest",
+				"message": cerr.Error(),
+			})
+			return
+		}
+
+		var afe *AuthFactorError
+		if errors.As(err, &afe) {
+			ctx.JSON(http.StatusUnauthorized, map[string]string{
+				"error":   afe.Name,
+				"message": afe.Message,
+			})
+			return
+		}
+
+		var qerr *QuotaError
+		if errors.As(err, &qerr) {
+			ctx.JSON(http.StatusBadRequest, map[string]string{
+				"error":   "QuotaExceeded",
+				"message": qerr.Error(),
+			})
+			return
+		}
+
+		if errors.Is(err, repomgr.ErrInvalidSwap) {
+			ctx.JSON(http.StatusBadRequest, map[string]string{
+				"error":   "InvalidSwap",
+				"message": err.Error(),
+			})
+			return
+		}
+
+		var herr *echo.HTTPError
+		if errors.As(err, &herr) {
+			ctx.JSON(herr.Code, herr)
+			return
+		}
+
 		ctx.Response().WriteHeader(500)
 	}
 
@@ -370,7 +479,7 @@ func (s *Server) RunAPIWithListener(listen net.Listener) error {
 		}
 
 		return c.String(200, "ok")
-	})
+	}, s.auditAdmin)
 
 	e.GET("/suspendRepo", func(c echo.Context) error {
 		ctx := c.Request().Context()
@@ -384,7 +493,7 @@ func (s *Server) RunAPIWithListener(listen net.Listener) error {
 		}
 
 		return c.String(200, "ok")
-	})
+	}, s.auditAdmin)
 
 	e.GET("/deactivateRepo", func(c echo.Context) error {
 		ctx := c.Request().Context()
@@ -398,7 +507,7 @@ func (s *Server) RunAPIWithListener(listen net.Listener) error {
 		}
 
 		return c.String(200, "ok")
-	})
+	}, s.auditAdmin)
 
 	e.GET("/reactivateRepo", func(c echo.Context) error {
 		ctx := c.Request().Context()
@@ -412,12 +521,45 @@ func (s *Server) RunAPIWithListener(listen net.Listener) error {
 		}
 
 		return c.String(200, "ok")
-	})
-
-	e.Use(middleware.JWTWithConfig(cfg), s.userCheckMiddleware)
+	}, s.auditAdmin)
+
+	e.GET("/account/usage", s.HandleAccountUsage)
+
+	e.GET("/account/2fa", s.HandleGetTwoFactor)
+	e.POST("/account/2fa/totp/enroll", s.HandleEnrollTOTP)
+	e.POST("/account/2fa/totp/confirm", s.HandleConfirmTOTP)
+	e.POST("/account/2fa/totp/disable", s.HandleDisableTOTP)
+	e.POST("/account/2fa/email", s.HandleSetEmailAuthFactor)
+	e.POST("/account/2fa/email-code", s.HandleRequestEmailAuthCode)
+
+	admin := e.Group("/admin", s.checkAdminAuth, s.auditAdmin)
+	admin.GET("/emails/render", s.HandleRenderEmailTemplate)
+	admin.POST("/handles/reserve", s.HandleAdminReserveHandle)
+	admin.POST("/handles/release", s.HandleAdminReleaseHandle)
+	admin.GET("/handles/reserved", s.HandleAdminListReservedHandles)
+	admin.POST("/inviteCodes", s.HandleAdminCreateInviteCodes)
+	admin.GET("/mode", s.HandleAdminGetServiceMode)
+	admin.POST("/mode", s.HandleAdminSetServiceMode)
+	admin.GET("/usage", s.HandleAdminGetUsage)
+	admin.POST("/quota", s.HandleAdminSetQuota)
+	admin.GET("/labels", s.HandleAdminListLabels)
+	admin.POST("/labels/apply", s.HandleAdminApplyLabel)
+	admin.POST("/labels/remove", s.HandleAdminRemoveLabel)
+
+	e.POST("/takeout", s.HandleTakeoutRequest)
+	e.GET("/takeout/status", s.HandleTakeoutStatus)
+	e.GET("/takeout/download", s.HandleTakeoutDownload)
+
+	e.Use(s.serviceAuthMiddleware, middleware.JWTWithConfig(cfg), s.userCheckMiddleware)
 	s.RegisterHandlersComAtproto(e)
+	// replaces the generated handler, to support range and conditional requests
+	e.GET("/xrpc/com.atproto.sync.getBlob", s.HandleGetBlob)
+
+	e.GET("/xrpc/app.bsky.actor.getPreferences", s.HandleAppBskyActorGetPreferences)
+	e.POST("/xrpc/app.bsky.actor.putPreferences", s.HandleAppBskyActorPutPreferences)
 
 	e.GET("/xrpc/com.atproto.sync.subscribeRepos", s.EventsHandler)
+	e.GET("/xrpc/com.atproto.label.subscribeLabels", s.HandleSubscribeLabels)
 	e.GET("/xrpc/_health", s.HandleHealthCheck)
 	e.GET("/.well-known/atproto-did", s.HandleResolveDid)
 
@@ -446,9 +588,10 @@ func (s *Server) HandleHealthCheck(c echo.Context) error {
 func (s *Server) HandleResolveDid(c echo.Context) error {
 	ctx := c.Request().Context()
 
-	handle := c.Request().Host
-	if hh := c.Request().Header.Get("Host"); hh != "" {
-		handle = hh
+	// only answer for handles under the domains we host
+	handle := requestHost(c.Request())
+	if s.handleDomainFor(handle) == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "no such handle on this server")
 	}
 
 	u, err := s.lookupUserByHandle(ctx, handle)
@@ -461,11 +604,6 @@ func (s *Server) HandleResolveDid(c echo.Context) error {
 
 type User = pdsdata.User
 
-type RefreshToken struct {
-	gorm.Model
-	Token string
-}
-
 func toTime(i interface{}) (time.Time, error) {
 	ival, ok := i.(float64)
 	if !ok {
@@ -574,7 +712,16 @@ func (s *Server) userCheckMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 		ctx = context.WithValue(ctx, "token", user)
 
 		scope, did, err := s.checkTokenValidity(user)
+		if err == nil {
+			err = s.checkSessionFamily(ctx, user)
+		}
 		if err != nil {
+			s.audit(ctx, &AuditEvent{
+				Type:    AuditAuthFailed,
+				Did:     tokenClaim(user, "sub"),
+				Reason:  err.Error(),
+				Details: map[string]string{"path": c.Path()},
+			})
 			return fmt.Errorf("invalid token: %w", err)
 		}
 
@@ -592,6 +739,49 @@ func (s *Server) userCheckMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	}
 }
 
+// checkAdminAuth requires admin basic auth. Admin routes are disabled unless
+// an admin password is configured.
+func (s *Server) checkAdminAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !xrpc.CheckAdminAuth(c.Request(), s.adminPassword) {
+			s.audit(c.Request().Context(), &AuditEvent{
+				Type:    AuditAdminDenied,
+				Details: map[string]string{"method": c.Request().Method, "path": c.Path()},
+			})
+			return echo.ErrForbidden
+		}
+		return next(c)
+	}
+}
+
+func (s *Server) SetAdminPassword(pw string) {
+	s.adminPassword = pw
+}
+
+// SetTrustedProxies sets the address ranges of reverse proxies in front of
+// the server, whose X-Forwarded-For headers are believed when finding a
+// client's IP (for signup throttles, audit events and so on). Without any,
+// the address of the connection is used, and forwarding headers are ignored.
+func (s *Server) SetTrustedProxies(cidrs []string) error {
+	opts := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, c := range cidrs {
+		_, ipnet, err := net.ParseCIDR(c)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy range %q: %w", c, err)
+		}
+		opts = append(opts, echo.TrustIPRange(ipnet))
+	}
+	s.ipExtractor = echo.ExtractIPFromXFFHeader(opts...)
+	return nil
+}
+
+func (s *Server) clientIPExtractor() echo.IPExtractor {
+	if s.ipExtractor != nil {
+		return s.ipExtractor
+	}
+	return echo.ExtractIPDirect()
+}
+
 func (s *Server) getUser(ctx context.Context) (*User, error) {
 	u, ok := ctx.Value("user").(*User)
 	if !ok {
@@ -612,22 +802,6 @@ func validateEmail(email string) error {
 	return nil
 }
 
-func (s *Server) validateHandle(handle string) error {
-	if !strings.HasSuffix(handle, s.handleSuffix) {
-		return fmt.Errorf("invalid handle")
-	}
-
-	if strings.Contains(strings.TrimSuffix(handle, s.handleSuffix), ".") {
-		return fmt.Errorf("invalid handle")
-	}
-
-	return nil
-}
-
-func (s *Server) invalidateToken(ctx context.Context, u *User, tok *jwt.Token) error {
-	panic("nyi")
-}
-
 type Peering = pdsdata.Peering
 
 func (s *Server) EventsHandler(c echo.Context) error {
@@ -671,55 +845,10 @@ func (s *Server) EventsHandler(c echo.Context) error {
 	}
 	defer cancel()
 
-	header := events.EventHeader{Op: events.EvtKindMessage}
 	for evt := range evts {
-		wc, err := conn.NextWriter(websocket.BinaryMessage)
-		if err != nil {
-			return err
-		}
-
-		var obj lexutil.CBOR
-
-		switch {
-		case evt.Error != nil:
-			header.Op = events.EvtKindErrorFrame
-			obj = evt.Error
-		case evt.RepoCommit != nil:
-			header.MsgType = "#commit"
-			obj = evt.RepoCommit
-		case evt.RepoHandle != nil:
-			header.MsgType = "#handle"
-			obj = evt.RepoHandle
-		case evt.RepoIdentity != nil:
-			header.MsgType = "#identity"
-			obj = evt.RepoIdentity
-		case evt.RepoAccount != nil:
-			header.MsgType = "#account"
-			obj = evt.RepoAccount
-		case evt.RepoInfo != nil:
-			header.MsgType = "#info"
-			obj = evt.RepoInfo
-		case evt.RepoMigrate != nil:
-			header.MsgType = "#migrate"
-			obj = evt.RepoMigrate
-		case evt.RepoTombstone != nil:
-			header.MsgType = "#tombstone"
-			obj = evt.RepoTombstone
-		default:
-			return fmt.Errorf("unrecognized event kind")
-		}
-
-		if err := header.MarshalCBOR(wc); err != nil {
-			return fmt.Errorf("failed to write header: %w", err)
-		}
-
-		if err := obj.MarshalCBOR(wc); err != nil {
+		if err := events.WriteEvent(conn, evt); err != nil {
 			return fmt.Errorf("failed to write event: %w", err)
 		}
-
-		if err := wc.Close(); err != nil {
-			return fmt.Errorf("failed to flush-close our event write: %w", err)
-		}
 	}
 
 	return nil
@@ -745,7 +874,10 @@ func (s *Server) UpdateUserHandle(ctx context.Context, u *User, handle string) e
 		return fmt.Errorf("failed to update handle: %w", err)
 	}
 
-	if err := s.db.Model(User{}).Where("id = ?", u.ID).UpdateColumn("handle", handle).Error; err != nil {
+	if err := s.db.Model(User{}).Where("id = ?", u.ID).UpdateColumns(map[string]any{
+		"handle":          handle,
+		"handle_skeleton": handlepolicy.Skeleton(handleLabel(handle)),
+	}).Error; err != nil {
 		return fmt.Errorf("failed to update handle: %w", err)
 	}
 
@@ -785,6 +917,19 @@ func (s *Server) TakedownRepo(ctx context.Context, did string) error {
 		return fmt.Errorf("failed to push event: %s", err)
 	}
 
+	// Let the account holder know, if they are one of ours
+	u, err := s.lookupUserByDid(ctx, did)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		return nil
+	}
+
+	if err := s.sendUserEmail(ctx, u, EmailTakedownNotice, nil); err != nil {
+		s.log.Error("failed to send takedown notice", "did", did, "err", err)
+	}
+
 	return nil
 }
 
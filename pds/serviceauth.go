@@ -0,0 +1,251 @@
+package pds
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ServiceAuthConfig enables inter-service auth: requests to the listed
+// methods may carry a service auth JWT (signed by the calling service's or
+// account's atproto key) in place of a session token.
+type ServiceAuthConfig struct {
+	// Methods maps the NSIDs of methods which accept service auth to the
+	// issuer DIDs allowed to call them. An empty list allows any issuer, so
+	// handlers must then check the issuer themselves. Admin methods
+	// (com.atproto.admin.*) must list their issuers.
+	Methods map[string][]string
+	// Audiences are the "aud" values accepted. Defaults to the did:web of
+	// the service URL host, with and without the "#atproto_pds" fragment
+	Audiences []string
+	// AllowMissingLxm accepts tokens which aren't bound to a method. Only
+	// for compatibility with older clients; tokens without "lxm" can be
+	// replayed against any method listed for the issuer.
+	AllowMissingLxm bool
+	// RequireNonce rejects tokens without a "jti" nonce. Nonces are always
+	// checked for replay when present.
+	RequireNonce bool
+	// Directory is used to resolve issuer signing keys. Defaults to
+	// identity.DefaultDirectory()
+	Directory identity.Directory
+	// KeyFunc overrides Directory for resolving issuer signing keys
+	KeyFunc xrpc.ServiceAuthKeyFunc
+}
+
+var ErrServiceAuthIssuer = errors.New("service auth issuer not allowed for method")
+var ErrServiceAuthReplay = errors.New("service auth token has already been used")
+
+// SetServiceAuthConfig enables service auth for the configured methods
+func (s *Server) SetServiceAuthConfig(cfg *ServiceAuthConfig) error {
+	for m := range cfg.Methods {
+		if _, err := syntax.ParseNSID(m); err != nil {
+			return fmt.Errorf("invalid service auth method: %w", err)
+		}
+	}
+	for m, dids := range cfg.Methods {
+		if len(dids) == 0 && strings.HasPrefix(m, "com.atproto.admin.") {
+			return fmt.Errorf("service auth for admin method %s must list its allowed issuers", m)
+		}
+		for _, d := range dids {
+			if _, err := syntax.ParseDID(d); err != nil {
+				return fmt.Errorf("invalid service auth issuer: %w", err)
+			}
+		}
+	}
+	if len(cfg.Audiences) == 0 {
+		u, err := url.Parse(s.serviceUrl)
+		if err != nil || u.Hostname() == "" {
+			return fmt.Errorf("service auth audience must be configured when the service URL has no host")
+		}
+		did := "did:web:" + u.Hostname()
+		cfg.Audiences = []string{did, did + "#atproto_pds"}
+	}
+	if cfg.KeyFunc == nil {
+		dir := cfg.Directory
+		if dir == nil {
+			dir = identity.DefaultDirectory()
+		}
+		cfg.KeyFunc = xrpc.IdentityKeyFunc(dir)
+	}
+	s.serviceAuthConfig = cfg
+	if s.serviceAuthNonces == nil {
+		s.serviceAuthNonces = newNonceCache()
+	}
+	return nil
+}
+
+// nonceStore remembers service auth nonces until their tokens expire
+type nonceStore interface {
+	// use records a nonce, returning false if it was already used
+	use(ctx context.Context, key string, exp time.Time) (bool, error)
+}
+
+// nonceCache is a nonceStore in memory
+type nonceCache struct {
+	lk        sync.Mutex
+	seen      map[string]time.Time
+	lastPrune time.Time
+}
+
+func newNonceCache() *nonceCache {
+	return &nonceCache{seen: make(map[string]time.Time)}
+}
+
+func (nc *nonceCache) use(ctx context.Context, key string, exp time.Time) (bool, error) {
+	nc.lk.Lock()
+	defer nc.lk.Unlock()
+
+	now := time.Now()
+	if now.Sub(nc.lastPrune) > time.Minute {
+		for k, e := range nc.seen {
+			if now.After(e) {
+				delete(nc.seen, k)
+			}
+		}
+		nc.lastPrune = now
+	}
+
+	if e, ok := nc.seen[key]; ok && now.Before(e) {
+		return false, nil
+	}
+	nc.seen[key] = exp
+	return true, nil
+}
+
+type serviceAuthKey struct{}
+
+// serviceAuthClaims returns the verified service auth claims of the request,
+// or nil if the request wasn't made with service auth
+func serviceAuthClaims(ctx context.Context) *xrpc.ServiceAuthClaims {
+	claims, _ := ctx.Value(serviceAuthKey{}).(*xrpc.ServiceAuthClaims)
+	return claims
+}
+
+// jwtAlg returns the "alg" of a JWT header, without verifying anything
+func jwtAlg(token string) string {
+	hdr, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return ""
+	}
+	b, err := base64.RawURLEncoding.DecodeString(hdr)
+	if err != nil {
+		return ""
+	}
+	var h struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(b, &h); err != nil {
+		return ""
+	}
+	return h.Alg
+}
+
+// serviceAuthMiddleware verifies service auth tokens on requests to methods
+// which accept them. Requests carrying session tokens (which are HMAC-signed)
+// are passed through to the session auth middleware.
+func (s *Server) serviceAuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		cfg := s.serviceAuthConfig
+		if cfg == nil {
+			return next(c)
+		}
+		method, ok := strings.CutPrefix(c.Request().URL.Path, "/xrpc/")
+		if !ok {
+			return next(c)
+		}
+		issuers, ok := cfg.Methods[method]
+		if !ok {
+			return next(c)
+		}
+		token, ok := strings.CutPrefix(c.Request().Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			return next(c)
+		}
+		switch jwtAlg(token) {
+		case "ES256K", "ES256":
+		default:
+			return next(c)
+		}
+
+		ctx := c.Request().Context()
+		claims, err := s.verifyServiceAuth(ctx, cfg, token, syntax.NSID(method), issuers)
+		if err != nil {
+			serviceAuthRequests.WithLabelValues(method, "rejected").Inc()
+			s.audit(ctx, &AuditEvent{
+				Type:    AuditAuthFailed,
+				Reason:  err.Error(),
+				Details: map[string]string{"path": c.Path(), "auth": "service"},
+			})
+			return echo.NewHTTPError(http.StatusUnauthorized, fmt.Sprintf("invalid service auth: %s", err))
+		}
+		serviceAuthRequests.WithLabelValues(method, "ok").Inc()
+
+		ctx = context.WithValue(ctx, serviceAuthKey{}, claims)
+		c.SetRequest(c.Request().WithContext(ctx))
+		c.Set("serviceAuth", claims)
+		return next(c)
+	}
+}
+
+func (s *Server) verifyServiceAuth(ctx context.Context, cfg *ServiceAuthConfig, token string, method syntax.NSID, issuers []string) (*xrpc.ServiceAuthClaims, error) {
+	var claims *xrpc.ServiceAuthClaims
+	var err error
+	for _, aud := range cfg.Audiences {
+		// the method binding is checked below, so that unbound tokens can be allowed
+		claims, err = xrpc.VerifyServiceAuth(ctx, token, aud, "", cfg.KeyFunc)
+		if !errors.Is(err, xrpc.ErrServiceAuthAudience) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case claims.Lxm == "" && !cfg.AllowMissingLxm:
+		return nil, fmt.Errorf("%w: token not bound to a method", xrpc.ErrServiceAuthMethod)
+	case claims.Lxm != "" && claims.Lxm != method.String():
+		return nil, fmt.Errorf("%w: %s", xrpc.ErrServiceAuthMethod, claims.Lxm)
+	}
+
+	if len(issuers) > 0 {
+		allowed := false
+		for _, iss := range issuers {
+			if iss == claims.Iss {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return nil, fmt.Errorf("%w: %s", ErrServiceAuthIssuer, claims.Iss)
+		}
+	}
+
+	if claims.Jti == "" {
+		if cfg.RequireNonce {
+			return nil, fmt.Errorf("%w: missing nonce", xrpc.ErrServiceAuthInvalid)
+		}
+	} else {
+		fresh, err := s.serviceAuthNonces.use(ctx, claims.Iss+" "+claims.Jti, time.Unix(claims.Exp, 0).Add(xrpc.ServiceAuthClockSkew))
+		if err != nil {
+			return nil, fmt.Errorf("checking service auth nonce: %w", err)
+		}
+		if !fresh {
+			return nil, ErrServiceAuthReplay
+		}
+	}
+	return claims, nil
+}
@@ -0,0 +1,166 @@
+package pds
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceAuth(t *testing.T) {
+	assert := assert.New(t)
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	mod := syntax.DID("did:plc:moderation")
+	other := syntax.DID("did:plc:other")
+	priv, err := crypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := priv.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	aud := "did:web:pds.example.com"
+	updateStatus := syntax.NSID("com.atproto.admin.updateSubjectStatus")
+
+	assert.Error(s.SetServiceAuthConfig(&ServiceAuthConfig{Methods: map[string][]string{"com.atproto.admin.updateSubjectStatus": {mod.String()}}}))
+	assert.NoError(s.SetServiceAuthConfig(&ServiceAuthConfig{
+		Methods: map[string][]string{
+			updateStatus.String():           {mod.String()},
+			"com.atproto.server.getSession": nil,
+		},
+		Audiences: []string{aud},
+		KeyFunc: func(ctx context.Context, iss syntax.DID, refresh bool) (crypto.PublicKey, error) {
+			return pub, nil
+		},
+	}))
+
+	e := echo.New()
+	e.Use(s.serviceAuthMiddleware)
+	e.POST("/xrpc/com.atproto.admin.updateSubjectStatus", s.HandleComAtprotoAdminUpdateSubjectStatus)
+	e.GET("/xrpc/com.atproto.server.getSession", func(c echo.Context) error {
+		claims := serviceAuthClaims(c.Request().Context())
+		if claims == nil {
+			return c.String(http.StatusOK, "")
+		}
+		return c.String(http.StatusOK, claims.Iss)
+	})
+
+	body := `{"subject": {"$type": "com.atproto.admin.defs#repoRef", "did": "did:plc:abc123"}, "takedown": {"applied": true}}`
+	do := func(method, path, token string) *httptest.ResponseRecorder {
+		var req *http.Request
+		if method == "POST" {
+			req = httptest.NewRequest(method, path, strings.NewReader(body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		} else {
+			req = httptest.NewRequest(method, path, nil)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+	sign := func(iss syntax.DID, aud string, lxm syntax.NSID) string {
+		tok, err := xrpc.SignServiceAuth(priv, iss, aud, lxm, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tok
+	}
+
+	tok := sign(mod, aud, updateStatus)
+	rec := do("POST", "/xrpc/com.atproto.admin.updateSubjectStatus", tok)
+	assert.Equal(http.StatusOK, rec.Code, rec.Body.String())
+	var out comatproto.AdminUpdateSubjectStatus_Output
+	assert.NoError(json.Unmarshal(rec.Body.Bytes(), &out))
+	assert.Equal("did:plc:abc123", out.Subject.AdminDefs_RepoRef.Did)
+	assert.True(out.Takedown.Applied)
+
+	// tokens can't be replayed
+	assert.Equal(http.StatusUnauthorized, do("POST", "/xrpc/com.atproto.admin.updateSubjectStatus", tok).Code)
+
+	// issuer, audience, and method all have to match
+	assert.Equal(http.StatusUnauthorized, do("POST", "/xrpc/com.atproto.admin.updateSubjectStatus", sign(other, aud, updateStatus)).Code)
+	assert.Equal(http.StatusUnauthorized, do("POST", "/xrpc/com.atproto.admin.updateSubjectStatus", sign(mod, "did:web:elsewhere.example.com", updateStatus)).Code)
+	assert.Equal(http.StatusUnauthorized, do("POST", "/xrpc/com.atproto.admin.updateSubjectStatus", sign(mod, aud, "com.atproto.server.getSession")).Code)
+	assert.Equal(http.StatusUnauthorized, do("POST", "/xrpc/com.atproto.admin.updateSubjectStatus", sign(mod, aud, "")).Code)
+
+	// without service auth, the method isn't available
+	assert.Equal(http.StatusForbidden, do("POST", "/xrpc/com.atproto.admin.updateSubjectStatus", "").Code)
+
+	// methods with no issuer list accept any issuer
+	rec = do("GET", "/xrpc/com.atproto.server.getSession", sign(other, aud, "com.atproto.server.getSession"))
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Equal(other.String(), rec.Body.String())
+
+	// session tokens are left for the session auth middleware
+	rec = do("GET", "/xrpc/com.atproto.server.getSession", "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9.e30.c2ln")
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Equal("", rec.Body.String())
+}
+
+func TestServiceAuthAdminIssuers(t *testing.T) {
+	assert := assert.New(t)
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	mod := syntax.DID("did:plc:moderation")
+	other := syntax.DID("did:plc:other")
+	priv, err := crypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := priv.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	aud := "did:web:pds.example.com"
+	updateStatus := syntax.NSID("com.atproto.admin.updateSubjectStatus")
+	keyFunc := func(ctx context.Context, iss syntax.DID, refresh bool) (crypto.PublicKey, error) {
+		return pub, nil
+	}
+
+	// admin methods can't be opened to any issuer
+	assert.Error(s.SetServiceAuthConfig(&ServiceAuthConfig{
+		Methods:   map[string][]string{updateStatus.String(): nil},
+		Audiences: []string{aud},
+		KeyFunc:   keyFunc,
+	}))
+	assert.NoError(s.SetServiceAuthConfig(&ServiceAuthConfig{
+		Methods:   map[string][]string{updateStatus.String(): {mod.String()}},
+		Audiences: []string{aud},
+		KeyFunc:   keyFunc,
+	}))
+
+	e := echo.New()
+	e.Use(s.serviceAuthMiddleware)
+	e.POST("/xrpc/com.atproto.admin.updateSubjectStatus", s.HandleComAtprotoAdminUpdateSubjectStatus)
+
+	// a valid token, from a DID which isn't the moderation service
+	tok, err := xrpc.SignServiceAuth(priv, other, aud, updateStatus, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := `{"subject": {"$type": "com.atproto.admin.defs#repoRef", "did": "did:plc:abc123"}, "takedown": {"applied": true}}`
+	req := httptest.NewRequest("POST", "/xrpc/com.atproto.admin.updateSubjectStatus", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("Authorization", "Bearer "+tok)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(http.StatusUnauthorized, rec.Code)
+	assert.Contains(rec.Body.String(), ErrServiceAuthIssuer.Error())
+}
@@ -0,0 +1,388 @@
+package pds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/models"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SharedStateConfig lets several PDS instances run behind a load balancer,
+// sharing one Postgres database (and carstore database, and shard storage).
+//
+// Sessions, refresh tokens and accounts already live in the database, and
+// access tokens are stateless given a shared JWT secret. With shared state
+// enabled, the rest is coordinated through the database too:
+//   - writes to a repo hold a row lock on the repo, so that commits from
+//     different instances can't race on the repo head
+//   - the carstore stops caching repo heads in memory
+//   - firehose events are persisted to the database, and each instance
+//     broadcasts every instance's events (in sequence order) to its
+//     subscribers by tailing the event table
+//   - service auth nonces are recorded in the database
+//   - the service mode (see SetServiceMode) applies to every instance
+type SharedStateConfig struct {
+	// Instance identifies this instance in logs. Defaults to the hostname
+	Instance string
+	// Maximum number of repos this instance holds write locks on at once. Each
+	// holds a database connection for the duration of the write, so this must
+	// be well below the database connection limit. Defaults to 16
+	MaxLockedRepos int
+	// How long to wait for another instance to release a repo lock before
+	// failing the write. Defaults to 30s
+	LockTimeout time.Duration
+	// How often to check for new firehose events. Defaults to 100ms
+	EventPollInterval time.Duration
+	// Minimum time to wait for a missing sequence number (an event which
+	// another instance hasn't committed yet) before skipping over it. A gap is
+	// only skipped once every database transaction which was open when it was
+	// found has also finished, so events which commit late are held back
+	// rather than lost. Defaults to 2s
+	EventGapTimeout time.Duration
+	// How often to check for service mode changes made on other instances.
+	// Defaults to 5s
+	ModePollInterval time.Duration
+}
+
+// RepoLock is a row per repo, locked (with SELECT ... FOR UPDATE) by the
+// instance writing to the repo
+type RepoLock struct {
+	Usr       models.Uid `gorm:"primarykey"`
+	CreatedAt time.Time
+}
+
+// ServiceAuthNonce records a used service auth nonce, until its token expires
+type ServiceAuthNonce struct {
+	Key       string `gorm:"primarykey"`
+	ExpiresAt time.Time
+}
+
+// ServiceModeRecord is the service mode shared by all instances. There is
+// only ever one row
+type ServiceModeRecord struct {
+	ID         uint `gorm:"primarykey"`
+	Mode       ServiceMode
+	Message    string
+	RetryAfter time.Duration
+	Since      time.Time
+	UpdatedAt  time.Time
+}
+
+type sharedState struct {
+	cfg    SharedStateConfig
+	events *sharedEvents
+}
+
+// SetSharedStateConfig enables running several instances against the same
+// database. The database must be Postgres, and carstore shard directories
+// must be on storage shared by all instances. Must be called before the
+// server starts, and RunSharedState must then be run in the background.
+func (s *Server) SetSharedStateConfig(cfg *SharedStateConfig) error {
+	if _, ok := s.db.Dialector.(*postgres.Dialector); !ok {
+		return fmt.Errorf("shared state requires a postgres database")
+	}
+	shcs, ok := s.cs.(interface{ SetShared(bool) })
+	if !ok {
+		return fmt.Errorf("carstore does not support sharing between instances")
+	}
+
+	if cfg.Instance == "" {
+		cfg.Instance, _ = os.Hostname()
+	}
+	if cfg.MaxLockedRepos <= 0 {
+		cfg.MaxLockedRepos = 16
+	}
+	if cfg.LockTimeout <= 0 {
+		cfg.LockTimeout = 30 * time.Second
+	}
+	if cfg.EventPollInterval <= 0 {
+		cfg.EventPollInterval = 100 * time.Millisecond
+	}
+	if cfg.EventGapTimeout <= 0 {
+		cfg.EventGapTimeout = 2 * time.Second
+	}
+	if cfg.ModePollInterval <= 0 {
+		cfg.ModePollInterval = 5 * time.Second
+	}
+
+	if err := s.db.AutoMigrate(&RepoLock{}, &ServiceAuthNonce{}, &ServiceModeRecord{}); err != nil {
+		return err
+	}
+
+	dbp, err := events.NewDbPersistence(s.db, s.cs, nil)
+	if err != nil {
+		return fmt.Errorf("setting up event persistence: %w", err)
+	}
+	se := &sharedEvents{
+		EventPersistence: dbp,
+		gapTimeout:       cfg.EventGapTimeout,
+		snapshot:         pgSnapshot(s.db),
+		log:              s.log,
+	}
+	if err := s.db.Model(&events.RepoEventRecord{}).Select("coalesce(max(seq), 0)").Scan(&se.last).Error; err != nil {
+		return fmt.Errorf("finding last event: %w", err)
+	}
+	s.events = events.NewEventManager(se)
+	s.indexer.SetEventManager(s.events)
+
+	shcs.SetShared(true)
+	s.repoman.SetRepoLocker(&dbRepoLocker{
+		db:      s.db,
+		timeout: cfg.LockTimeout,
+		sem:     make(chan struct{}, cfg.MaxLockedRepos),
+	})
+	s.serviceAuthNonces = &dbNonceStore{db: s.db}
+
+	// an instance starting up adopts the mode already in effect, if any
+	var n int64
+	if err := s.db.Model(&ServiceModeRecord{}).Count(&n).Error; err != nil {
+		return err
+	}
+	if local := s.ServiceMode(); n == 0 {
+		if local.Since.IsZero() {
+			local.Since = time.Now()
+		}
+		if err := s.storeServiceMode(context.Background(), local); err != nil {
+			return err
+		}
+	} else {
+		if err := s.syncServiceMode(context.Background()); err != nil {
+			return err
+		}
+		if st := s.ServiceMode(); st.Mode != local.Mode {
+			s.log.Warn("adopting service mode shared by other instances", "mode", st.Mode, "configured", local.Mode)
+		}
+	}
+
+	s.shared = &sharedState{cfg: *cfg, events: se}
+	s.log.Info("shared state enabled", "instance", cfg.Instance)
+	return nil
+}
+
+// RunSharedState broadcasts firehose events from all instances, and picks up
+// service mode changes made on other instances, until ctx is done. Does
+// nothing if shared state isn't enabled.
+func (s *Server) RunSharedState(ctx context.Context) {
+	if s.shared == nil {
+		return
+	}
+	evtTicker := time.NewTicker(s.shared.cfg.EventPollInterval)
+	defer evtTicker.Stop()
+	modeTicker := time.NewTicker(s.shared.cfg.ModePollInterval)
+	defer modeTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-evtTicker.C:
+			if err := s.shared.events.poll(ctx); err != nil && ctx.Err() == nil {
+				s.log.Error("failed to read new events", "err", err)
+			}
+		case <-modeTicker.C:
+			if err := s.syncServiceMode(ctx); err != nil && ctx.Err() == nil {
+				s.log.Error("failed to read shared service mode", "err", err)
+			}
+		}
+	}
+}
+
+// loads the shared service mode, if one has been stored
+func (s *Server) syncServiceMode(ctx context.Context) error {
+	var rec ServiceModeRecord
+	if err := s.db.WithContext(ctx).Limit(1).Find(&rec).Error; err != nil {
+		return err
+	}
+	if rec.ID == 0 {
+		return nil
+	}
+	prev := s.ServiceMode()
+	if prev.Mode == rec.Mode && prev.Message == rec.Message && prev.RetryAfter == rec.RetryAfter && prev.Since.Equal(rec.Since) {
+		return nil
+	}
+	s.serviceMode.Store(&ServiceModeState{
+		Mode:       rec.Mode,
+		Message:    rec.Message,
+		RetryAfter: rec.RetryAfter,
+		Since:      rec.Since,
+	})
+	if prev.Mode != rec.Mode {
+		s.log.Warn("service mode changed by another instance", "from", prev.Mode, "to", rec.Mode, "message", rec.Message)
+	}
+	return nil
+}
+
+func (s *Server) storeServiceMode(ctx context.Context, st ServiceModeState) error {
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(&ServiceModeRecord{
+		ID:         1,
+		Mode:       st.Mode,
+		Message:    st.Message,
+		RetryAfter: st.RetryAfter,
+		Since:      st.Since,
+	}).Error
+}
+
+// dbRepoLocker serializes repo writes across instances with a row lock held
+// for the duration of the write
+type dbRepoLocker struct {
+	db      *gorm.DB
+	timeout time.Duration
+	// bounds the number of connections held by locks
+	sem chan struct{}
+}
+
+func (l *dbRepoLocker) LockRepo(ctx context.Context, user models.Uid) (func(), error) {
+	select {
+	case l.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	release := func() { <-l.sem }
+
+	start := time.Now()
+	if err := l.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&RepoLock{Usr: user}).Error; err != nil {
+		release()
+		return nil, err
+	}
+
+	// the lock is released when the transaction ends, so it must outlive a
+	// cancelled request context until the write is finished
+	tx := l.db.WithContext(context.WithoutCancel(ctx)).Begin()
+	if tx.Error != nil {
+		release()
+		return nil, tx.Error
+	}
+	fail := func(err error) (func(), error) {
+		tx.Rollback()
+		release()
+		return nil, err
+	}
+	if err := tx.Exec(fmt.Sprintf("SET LOCAL lock_timeout = %d", l.timeout.Milliseconds())).Error; err != nil {
+		return fail(err)
+	}
+	var rl RepoLock
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&rl, "usr = ?", user).Error; err != nil {
+		return fail(err)
+	}
+	sharedRepoLockWait.Observe(time.Since(start).Seconds())
+
+	return func() {
+		// nothing was written, so there's nothing to commit
+		tx.Rollback()
+		release()
+	}, nil
+}
+
+// dbNonceStore records service auth nonces in the database, so that a token
+// can't be replayed against another instance
+type dbNonceStore struct {
+	db *gorm.DB
+}
+
+func (ns *dbNonceStore) use(ctx context.Context, key string, exp time.Time) (bool, error) {
+	now := time.Now()
+	// a nonce whose token has expired can be reused; the row is replaced
+	res := ns.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"expires_at"}),
+		Where: clause.Where{Exprs: []clause.Expression{
+			clause.Expr{SQL: "service_auth_nonces.expires_at < ?", Vars: []any{now}},
+		}},
+	}).Create(&ServiceAuthNonce{Key: key, ExpiresAt: exp})
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected > 0, nil
+}
+
+// sharedEvents persists events to the database without broadcasting them
+// directly; instead, each instance tails the event table and broadcasts every
+// event in sequence order, wherever it came from
+type sharedEvents struct {
+	events.EventPersistence
+
+	broadcast  func(*events.XRPCStreamEvent)
+	gapTimeout time.Duration
+	snapshot   func(ctx context.Context) (txnSnapshot, error)
+	log        *slog.Logger
+
+	// only accessed by poll
+	last     int64
+	gapSince time.Time
+	// transactions below this id may have been writing the missing event
+	gapXmax uint64
+}
+
+// txnSnapshot bounds the database transactions in progress: every transaction
+// with an id below Xmin has finished, and every transaction which had started
+// has an id below Xmax
+type txnSnapshot struct {
+	Xmin uint64
+	Xmax uint64
+}
+
+func pgSnapshot(db *gorm.DB) func(ctx context.Context) (txnSnapshot, error) {
+	return func(ctx context.Context) (txnSnapshot, error) {
+		var snap txnSnapshot
+		err := db.WithContext(ctx).Raw("SELECT txid_snapshot_xmin(s) AS xmin, txid_snapshot_xmax(s) AS xmax FROM txid_current_snapshot() s").Scan(&snap).Error
+		return snap, err
+	}
+}
+
+var errSharedEventGap = errors.New("gap in event sequence")
+
+func (se *sharedEvents) SetEventBroadcaster(brc func(*events.XRPCStreamEvent)) {
+	se.broadcast = brc
+	se.EventPersistence.SetEventBroadcaster(func(*events.XRPCStreamEvent) {})
+}
+
+// poll broadcasts events persisted since the last poll. Sequence numbers are
+// allocated before events are committed, so a missing number may be an event
+// another instance is still writing; events after it are held back until it
+// shows up. A number is only skipped (eg, a rolled back insert) once the gap
+// timeout has passed and every transaction which was open when the gap was
+// found has finished, since one of them may still commit the event.
+func (se *sharedEvents) poll(ctx context.Context) error {
+	err := se.Playback(ctx, se.last, func(evt *events.XRPCStreamEvent) error {
+		seq := evt.Sequence()
+		if seq != se.last+1 {
+			if se.gapSince.IsZero() {
+				snap, err := se.snapshot(ctx)
+				if err != nil {
+					return fmt.Errorf("checking open transactions: %w", err)
+				}
+				se.gapSince = time.Now()
+				se.gapXmax = snap.Xmax
+			}
+			// the timeout also covers the moment between a transaction
+			// allocating a sequence number and being assigned an id
+			if time.Since(se.gapSince) < se.gapTimeout {
+				return errSharedEventGap
+			}
+			snap, err := se.snapshot(ctx)
+			if err != nil {
+				return fmt.Errorf("checking open transactions: %w", err)
+			}
+			if snap.Xmin < se.gapXmax {
+				return errSharedEventGap
+			}
+			se.log.Warn("skipping gap in event sequence", "after", se.last, "next", seq, "waited", time.Since(se.gapSince))
+		}
+		se.gapSince = time.Time{}
+		se.last = seq
+		se.broadcast(evt)
+		return nil
+	})
+	if errors.Is(err, errSharedEventGap) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,146 @@
+package pds
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSharedStateRequiresPostgres(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	assert.Error(t, s.SetSharedStateConfig(&SharedStateConfig{}))
+}
+
+func TestDbNonceStore(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+	if err := s.db.AutoMigrate(&ServiceAuthNonce{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// two instances see the same nonces
+	a, b := &dbNonceStore{db: s.db}, &dbNonceStore{db: s.db}
+	exp := time.Now().Add(time.Minute)
+	ok, err := a.use(ctx, "did:plc:abc nonce1", exp)
+	assert.NoError(err)
+	assert.True(ok)
+	ok, err = b.use(ctx, "did:plc:abc nonce1", exp)
+	assert.NoError(err)
+	assert.False(ok)
+	ok, err = b.use(ctx, "did:plc:abc nonce2", exp)
+	assert.NoError(err)
+	assert.True(ok)
+
+	// nonces of expired tokens can be reused
+	ok, err = a.use(ctx, "did:plc:abc nonce3", time.Now().Add(-time.Second))
+	assert.NoError(err)
+	assert.True(ok)
+	ok, err = b.use(ctx, "did:plc:abc nonce3", exp)
+	assert.NoError(err)
+	assert.True(ok)
+}
+
+func TestSharedServiceMode(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+	if err := s.db.AutoMigrate(&ServiceModeRecord{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// another instance on the same database
+	other := &Server{db: s.db, log: s.log}
+	s.shared = &sharedState{}
+	other.shared = &sharedState{}
+
+	assert.NoError(s.SetServiceMode(ModeReadOnly, "migrating", 0))
+	assert.Equal(ModeNormal, other.ServiceMode().Mode)
+	assert.NoError(other.syncServiceMode(ctx))
+	st := other.ServiceMode()
+	assert.Equal(ModeReadOnly, st.Mode)
+	assert.Equal("migrating", st.Message)
+
+	assert.NoError(other.SetServiceMode(ModeNormal, "", 0))
+	assert.NoError(s.syncServiceMode(ctx))
+	assert.Equal(ModeNormal, s.ServiceMode().Mode)
+}
+
+// plays back a fixed list of events
+type fakePlayback struct {
+	events.EventPersistence
+	evts []*events.XRPCStreamEvent
+}
+
+func (fp *fakePlayback) Playback(ctx context.Context, since int64, cb func(*events.XRPCStreamEvent) error) error {
+	for _, evt := range fp.evts {
+		if evt.Sequence() <= since {
+			continue
+		}
+		if err := cb(evt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestSharedEventsPoll(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	evt := func(seq int64) *events.XRPCStreamEvent {
+		return &events.XRPCStreamEvent{RepoAccount: &atproto.SyncSubscribeRepos_Account{Seq: seq}}
+	}
+	fp := &fakePlayback{evts: []*events.XRPCStreamEvent{evt(1), evt(2), evt(4)}}
+	var sent []int64
+	snap := txnSnapshot{Xmin: 10, Xmax: 20}
+	se := &sharedEvents{
+		EventPersistence: fp,
+		broadcast:        func(evt *events.XRPCStreamEvent) { sent = append(sent, evt.Sequence()) },
+		gapTimeout:       50 * time.Millisecond,
+		snapshot:         func(context.Context) (txnSnapshot, error) { return snap, nil },
+		log:              s.log,
+	}
+
+	// events after a gap are held back until it is filled
+	assert.NoError(se.poll(ctx))
+	assert.Equal([]int64{1, 2}, sent)
+	fp.evts = []*events.XRPCStreamEvent{evt(1), evt(2), evt(3), evt(4), evt(6)}
+	assert.NoError(se.poll(ctx))
+	assert.Equal([]int64{1, 2, 3, 4}, sent)
+
+	// including after the gap timeout, while a transaction which was open
+	// when the gap was found is still open
+	assert.NoError(se.poll(ctx))
+	assert.Equal([]int64{1, 2, 3, 4}, sent)
+	time.Sleep(60 * time.Millisecond)
+	snap = txnSnapshot{Xmin: 15, Xmax: 30}
+	assert.NoError(se.poll(ctx))
+	assert.Equal([]int64{1, 2, 3, 4}, sent)
+
+	// so an event which commits late is still delivered, in order
+	fp.evts = []*events.XRPCStreamEvent{evt(1), evt(2), evt(3), evt(4), evt(5), evt(6), evt(7)}
+	assert.NoError(se.poll(ctx))
+	assert.Equal([]int64{1, 2, 3, 4, 5, 6, 7}, sent)
+
+	// a number which is never used is skipped once those transactions finish
+	fp.evts = append(fp.evts, evt(9))
+	assert.NoError(se.poll(ctx))
+	assert.Equal([]int64{1, 2, 3, 4, 5, 6, 7}, sent)
+	time.Sleep(60 * time.Millisecond)
+	assert.NoError(se.poll(ctx))
+	assert.Equal([]int64{1, 2, 3, 4, 5, 6, 7}, sent)
+	snap = txnSnapshot{Xmin: 30, Xmax: 40}
+	assert.NoError(se.poll(ctx))
+	assert.Equal([]int64{1, 2, 3, 4, 5, 6, 7, 9}, sent)
+}
@@ -0,0 +1,397 @@
+package pds
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/models"
+	"github.com/labstack/echo/v4"
+)
+
+// CaptchaTokenHeader carries the captcha response token on createAccount
+// requests, when a captcha verifier is configured
+const CaptchaTokenHeader = "X-Captcha-Token"
+
+// SignupConfig protects account creation from abuse. Throttles count
+// accounts created, not attempts, and are shared by all instances using the
+// same database.
+type SignupConfig struct {
+	// Maximum number of accounts created from one client IP within IPWindow.
+	// Zero for no limit
+	MaxPerIP int
+	IPWindow time.Duration
+
+	// Maximum number of accounts created from one autonomous system within
+	// ASNWindow. Requires ASNLookup. Zero for no limit
+	MaxPerASN int
+	ASNWindow time.Duration
+	ASNLookup ASNLookup
+
+	// Email domains which may not be used to sign up (eg, disposable email
+	// providers). Optional
+	DisposableDomains DomainList
+
+	// If set, createAccount requests must carry a captcha token (in the
+	// X-Captcha-Token header) which this verifies. Optional
+	Captcha CaptchaVerifier
+}
+
+// AccountSignup records where an account was created from, for signup
+// throttles
+type AccountSignup struct {
+	ID        uint       `gorm:"primarykey"`
+	Usr       models.Uid `gorm:"index"`
+	IP        string     `gorm:"index:idx_signup_ip_created"`
+	ASN       uint32     `gorm:"index:idx_signup_asn_created"`
+	CreatedAt time.Time  `gorm:"index:idx_signup_ip_created;index:idx_signup_asn_created"`
+}
+
+var (
+	ErrSignupThrottled  = errors.New("too many accounts created recently, try again later")
+	ErrDisposableEmail  = errors.New("email domain is not allowed")
+	ErrCaptchaRequired  = errors.New("captcha token required")
+	ErrCaptchaFailed    = errors.New("captcha verification failed")
+	errCaptchaTransient = errors.New("captcha verification unavailable")
+)
+
+// SetSignupConfig enables the signup protections in cfg
+func (s *Server) SetSignupConfig(cfg *SignupConfig) error {
+	if cfg.MaxPerASN > 0 && cfg.ASNLookup == nil {
+		return fmt.Errorf("per-ASN signup limit requires an ASN lookup")
+	}
+	if err := s.db.AutoMigrate(&AccountSignup{}); err != nil {
+		return err
+	}
+	s.signupConfig = cfg
+	return nil
+}
+
+// checkSignup applies the cheap signup protections to a createAccount request
+// (everything but the captcha, see checkSignupCaptcha). It returns the
+// client's ASN (zero if unknown), for recordSignup.
+func (s *Server) checkSignup(ctx context.Context, email string) (uint32, error) {
+	cfg := s.signupConfig
+	if cfg == nil {
+		return 0, nil
+	}
+	ip := remoteAddr(ctx)
+
+	if cfg.DisposableDomains != nil {
+		_, domain, _ := strings.Cut(email, "@")
+		if cfg.DisposableDomains.Contains(domain) {
+			signupChecks.WithLabelValues("disposable_email").Inc()
+			return 0, echo.NewHTTPError(http.StatusBadRequest, ErrDisposableEmail.Error())
+		}
+	}
+
+	if cfg.MaxPerIP > 0 && ip != "" {
+		n, err := s.countSignups(ctx, "ip = ?", ip, cfg.IPWindow)
+		if err != nil {
+			return 0, err
+		}
+		if n >= int64(cfg.MaxPerIP) {
+			signupChecks.WithLabelValues("ip_throttled").Inc()
+			return 0, echo.NewHTTPError(http.StatusTooManyRequests, ErrSignupThrottled.Error())
+		}
+	}
+
+	var asn uint32
+	if cfg.ASNLookup != nil && ip != "" {
+		if addr, err := netip.ParseAddr(ip); err == nil {
+			asn, _ = cfg.ASNLookup.LookupASN(addr)
+		}
+	}
+	if cfg.MaxPerASN > 0 && asn != 0 {
+		n, err := s.countSignups(ctx, "asn = ?", asn, cfg.ASNWindow)
+		if err != nil {
+			return 0, err
+		}
+		if n >= int64(cfg.MaxPerASN) {
+			signupChecks.WithLabelValues("asn_throttled").Inc()
+			return 0, echo.NewHTTPError(http.StatusTooManyRequests, ErrSignupThrottled.Error())
+		}
+	}
+
+	return asn, nil
+}
+
+// checkSignupCaptcha verifies the request's captcha token, if a captcha is
+// required. A token can only be verified once, so this goes after every other
+// check on the request (including the handle), just before the account is
+// created.
+func (s *Server) checkSignupCaptcha(ctx context.Context) error {
+	cfg := s.signupConfig
+	if cfg == nil {
+		return nil
+	}
+	if cfg.Captcha != nil {
+		token := captchaToken(ctx)
+		if token == "" {
+			signupChecks.WithLabelValues("captcha_missing").Inc()
+			return echo.NewHTTPError(http.StatusBadRequest, ErrCaptchaRequired.Error())
+		}
+		if err := cfg.Captcha.Verify(ctx, token, remoteAddr(ctx)); err != nil {
+			if errors.Is(err, ErrCaptchaFailed) {
+				signupChecks.WithLabelValues("captcha_failed").Inc()
+				return echo.NewHTTPError(http.StatusBadRequest, ErrCaptchaFailed.Error())
+			}
+			signupChecks.WithLabelValues("captcha_error").Inc()
+			return err
+		}
+	}
+
+	signupChecks.WithLabelValues("allowed").Inc()
+	return nil
+}
+
+func (s *Server) countSignups(ctx context.Context, where string, arg any, window time.Duration) (int64, error) {
+	q := s.db.WithContext(ctx).Model(&AccountSignup{}).Where(where, arg)
+	if window > 0 {
+		q = q.Where("created_at > ?", time.Now().Add(-window))
+	}
+	var n int64
+	if err := q.Count(&n).Error; err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// recordSignup counts a newly created account towards the signup throttles
+func (s *Server) recordSignup(ctx context.Context, uid models.Uid, asn uint32) {
+	if s.signupConfig == nil {
+		return
+	}
+	as := AccountSignup{Usr: uid, IP: remoteAddr(ctx), ASN: asn}
+	if err := s.db.WithContext(ctx).Create(&as).Error; err != nil {
+		s.log.Error("failed to record account signup", "uid", uid, "err", err)
+	}
+}
+
+type captchaTokenKey struct{}
+
+// records the captcha token from createAccount requests in the request context
+func captchaContextMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		if tok := req.Header.Get(CaptchaTokenHeader); tok != "" && req.URL.Path == "/xrpc/com.atproto.server.createAccount" {
+			c.SetRequest(req.WithContext(context.WithValue(req.Context(), captchaTokenKey{}, tok)))
+		}
+		return next(c)
+	}
+}
+
+func captchaToken(ctx context.Context) string {
+	tok, _ := ctx.Value(captchaTokenKey{}).(string)
+	return tok
+}
+
+// DomainList is a set of email domains
+type DomainList interface {
+	// Contains reports whether the domain, or any parent domain, is in the list
+	Contains(domain string) bool
+}
+
+// StaticDomainList is a DomainList held in memory
+type StaticDomainList map[string]bool
+
+func (dl StaticDomainList) Contains(domain string) bool {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	for domain != "" {
+		if dl[domain] {
+			return true
+		}
+		_, domain, _ = strings.Cut(domain, ".")
+	}
+	return false
+}
+
+// LoadDomainList reads a list of domains, one per line. Blank lines and
+// lines starting with # are ignored.
+func LoadDomainList(path string) (StaticDomainList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	dl := make(StaticDomainList)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		dl[strings.TrimSuffix(strings.ToLower(line), ".")] = true
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("reading domain list %s: %w", path, err)
+	}
+	return dl, nil
+}
+
+// ASNLookup maps client addresses to autonomous system numbers
+type ASNLookup interface {
+	// LookupASN returns the ASN announcing addr, or false if it isn't known
+	LookupASN(addr netip.Addr) (uint32, bool)
+}
+
+// PrefixASNTable is an ASNLookup from a table of IP prefixes. The most
+// specific matching prefix wins.
+type PrefixASNTable struct {
+	// prefix lengths present in the table, longest first
+	bits     []int
+	prefixes map[netip.Prefix]uint32
+}
+
+func NewPrefixASNTable() *PrefixASNTable {
+	return &PrefixASNTable{prefixes: make(map[netip.Prefix]uint32)}
+}
+
+func (t *PrefixASNTable) Add(p netip.Prefix, asn uint32) {
+	p = p.Masked()
+	if p.Addr().Is4In6() && p.Bits() >= 96 {
+		p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+	}
+	t.prefixes[p] = asn
+	i := sort.Search(len(t.bits), func(i int) bool { return t.bits[i] <= p.Bits() })
+	if i < len(t.bits) && t.bits[i] == p.Bits() {
+		return
+	}
+	t.bits = append(t.bits, 0)
+	copy(t.bits[i+1:], t.bits[i:])
+	t.bits[i] = p.Bits()
+}
+
+func (t *PrefixASNTable) LookupASN(addr netip.Addr) (uint32, bool) {
+	addr = addr.Unmap()
+	for _, bits := range t.bits {
+		if bits > addr.BitLen() {
+			continue
+		}
+		p, err := addr.Prefix(bits)
+		if err != nil {
+			continue
+		}
+		if asn, ok := t.prefixes[p]; ok {
+			return asn, true
+		}
+	}
+	return 0, false
+}
+
+// LoadPrefixASNTable reads a table of "<prefix> <asn>" lines (eg,
+// "192.0.2.0/24 64496" or "2001:db8::/32 AS64497"). Blank lines and lines
+// starting with # are ignored.
+func LoadPrefixASNTable(path string) (*PrefixASNTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	t := NewPrefixASNTable()
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: expected prefix and ASN", path, n)
+		}
+		p, err := netip.ParsePrefix(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		asn, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(fields[1]), "AS"), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: bad ASN: %w", path, n, err)
+		}
+		t.Add(p, uint32(asn))
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("reading ASN table %s: %w", path, err)
+	}
+	return t, nil
+}
+
+// CaptchaVerifier checks captcha response tokens with the captcha provider
+type CaptchaVerifier interface {
+	// Verify returns an error wrapping ErrCaptchaFailed if the token is not
+	// valid. Other errors mean the token couldn't be checked
+	Verify(ctx context.Context, token, remoteIP string) error
+}
+
+// SiteVerifyCaptcha verifies tokens with a "siteverify" API, as used by
+// hCaptcha and Cloudflare Turnstile
+type SiteVerifyCaptcha struct {
+	URL    string
+	Secret string
+	Client *http.Client
+}
+
+func NewHCaptchaVerifier(secret string) *SiteVerifyCaptcha {
+	return &SiteVerifyCaptcha{
+		URL:    "https://api.hcaptcha.com/siteverify",
+		Secret: secret,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func NewTurnstileVerifier(secret string) *SiteVerifyCaptcha {
+	return &SiteVerifyCaptcha{
+		URL:    "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+		Secret: secret,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (sv *SiteVerifyCaptcha) Verify(ctx context.Context, token, remoteIP string) error {
+	form := url.Values{"secret": {sv.Secret}, "response": {token}}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", sv.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client := sv.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errCaptchaTransient, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: siteverify returned status %d", errCaptchaTransient, resp.StatusCode)
+	}
+
+	var out struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&out); err != nil {
+		return fmt.Errorf("%w: decoding siteverify response: %w", errCaptchaTransient, err)
+	}
+	if !out.Success {
+		return fmt.Errorf("%w: %s", ErrCaptchaFailed, strings.Join(out.ErrorCodes, ", "))
+	}
+	return nil
+}
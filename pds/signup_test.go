@@ -0,0 +1,189 @@
+package pds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignupProtection(t *testing.T) {
+	assert := assert.New(t)
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	asns := NewPrefixASNTable()
+	asns.Add(netip.MustParsePrefix("192.0.2.0/24"), 64496)
+	asns.Add(netip.MustParsePrefix("198.51.100.0/24"), 64496)
+	if err := s.SetSignupConfig(&SignupConfig{
+		MaxPerIP:          2,
+		IPWindow:          time.Hour,
+		MaxPerASN:         3,
+		ASNWindow:         time.Hour,
+		ASNLookup:         asns,
+		DisposableDomains: StaticDomainList{"mailinator.com": true},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	n := 0
+	createAccount := func(ip, email string) int {
+		n++
+		ctx := context.WithValue(context.Background(), auditRemoteAddrKey{}, ip)
+		p := "password"
+		_, err := s.handleComAtprotoServerCreateAccount(ctx, &atproto.ServerCreateAccount_Input{
+			Email:    &email,
+			Password: &p,
+			Handle:   fmt.Sprintf("signup%d.test", n),
+		})
+		var he *echo.HTTPError
+		switch {
+		case err == nil:
+			return http.StatusOK
+		case errors.As(err, &he):
+			return he.Code
+		default:
+			t.Fatal(err)
+			return 0
+		}
+	}
+
+	assert.Equal(http.StatusBadRequest, createAccount("203.0.113.1", "someone@mailinator.com"))
+	assert.Equal(http.StatusBadRequest, createAccount("203.0.113.1", "someone@x.MAILINATOR.com"))
+
+	assert.Equal(http.StatusOK, createAccount("192.0.2.1", "a@foo.com"))
+	assert.Equal(http.StatusOK, createAccount("192.0.2.1", "b@foo.com"))
+	assert.Equal(http.StatusTooManyRequests, createAccount("192.0.2.1", "c@foo.com"))
+	// a different address in the same AS
+	assert.Equal(http.StatusOK, createAccount("198.51.100.1", "d@foo.com"))
+	assert.Equal(http.StatusTooManyRequests, createAccount("198.51.100.2", "e@foo.com"))
+	// unknown AS, only the IP limit applies
+	assert.Equal(http.StatusOK, createAccount("203.0.113.1", "f@foo.com"))
+
+	// signups outside the window don't count
+	assert.NoError(s.db.Model(&AccountSignup{}).Where("ip = ?", "192.0.2.1").Update("created_at", time.Now().Add(-2*time.Hour)).Error)
+	assert.Equal(http.StatusOK, createAccount("192.0.2.1", "g@foo.com"))
+}
+
+func TestSignupCaptcha(t *testing.T) {
+	assert := assert.New(t)
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	var lastIP string
+	verifies := 0
+	verify := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("secret") != "sekrit" {
+			http.Error(w, "bad secret", http.StatusForbidden)
+			return
+		}
+		verifies++
+		lastIP = r.FormValue("remoteip")
+		if r.FormValue("response") == "good-token" {
+			fmt.Fprint(w, `{"success": true}`)
+		} else {
+			fmt.Fprint(w, `{"success": false, "error-codes": ["invalid-input-response"]}`)
+		}
+	}))
+	defer verify.Close()
+
+	if err := s.SetSignupConfig(&SignupConfig{
+		Captcha: &SiteVerifyCaptcha{URL: verify.URL, Secret: "sekrit"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	createAccount := func(handle, token string) int {
+		ec := echo.New()
+		ec.IPExtractor = s.clientIPExtractor()
+		ec.Use(auditContextMiddleware, captchaContextMiddleware)
+		ec.POST("/xrpc/com.atproto.server.createAccount", s.HandleComAtprotoServerCreateAccount)
+
+		body := fmt.Sprintf(`{"handle": %q, "email": "%s@foo.com", "password": "password"}`, handle, handle)
+		req := httptest.NewRequest("POST", "/xrpc/com.atproto.server.createAccount", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = "192.0.2.7:4321"
+		req.Header.Set("X-Forwarded-For", "203.0.113.9")
+		req.Header.Set("X-Real-IP", "203.0.113.9")
+		if token != "" {
+			req.Header.Set(CaptchaTokenHeader, token)
+		}
+		rec := httptest.NewRecorder()
+		ec.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(http.StatusBadRequest, createAccount("nocaptcha.test", ""))
+	assert.Equal(http.StatusBadRequest, createAccount("badcaptcha.test", "bad-token"))
+	assert.Equal(http.StatusOK, createAccount("captcha.test", "good-token"))
+	assert.Equal(2, verifies)
+	// forwarding headers aren't believed from arbitrary clients
+	assert.Equal("192.0.2.7", lastIP)
+
+	// an invalid or taken handle is refused before the token is used up
+	assert.NotEqual(http.StatusOK, createAccount("not a handle", "good-token"))
+	assert.NotEqual(http.StatusOK, createAccount("captcha.test", "good-token"))
+	assert.Equal(2, verifies)
+
+	// but they are from a trusted proxy
+	if err := s.SetTrustedProxies([]string{"192.0.2.0/24"}); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(http.StatusOK, createAccount("proxied.test", "good-token"))
+	assert.Equal("203.0.113.9", lastIP)
+	assert.Error(s.SetTrustedProxies([]string{"192.0.2.7"}))
+
+	// a provider failure isn't the client's fault
+	sv := &SiteVerifyCaptcha{URL: verify.URL, Secret: "wrong"}
+	err := sv.Verify(context.Background(), "good-token", "")
+	assert.Error(err)
+	assert.False(errors.Is(err, ErrCaptchaFailed))
+}
+
+func TestPrefixASNTable(t *testing.T) {
+	assert := assert.New(t)
+
+	p := filepath.Join(t.TempDir(), "asn.txt")
+	table := "# prefix asn\n10.0.0.0/8 64500\n10.1.0.0/16 AS64501\n\n2001:db8::/32 64502\n"
+	if err := os.WriteFile(p, []byte(table), 0600); err != nil {
+		t.Fatal(err)
+	}
+	at, err := LoadPrefixASNTable(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for addr, want := range map[string]uint32{
+		"10.2.3.4":         64500,
+		"10.1.3.4":         64501,
+		"::ffff:10.1.3.4":  64501,
+		"2001:db8::1":      64502,
+		"11.0.0.1":         0,
+		"2001:db9::1":      0,
+		"::ffff:11.0.0.1":  0,
+		"0a01:0304::":      0,
+		"2001:db8:ffff::1": 64502,
+	} {
+		asn, ok := at.LookupASN(netip.MustParseAddr(addr))
+		assert.Equal(want, asn, addr)
+		assert.Equal(want != 0, ok, addr)
+	}
+
+	if err := os.WriteFile(p, []byte("10.0.0.0/8 notanasn\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadPrefixASNTable(p)
+	assert.Error(err)
+}
@@ -0,0 +1,36 @@
+package pds
+
+import (
+	"net/http"
+
+	"github.com/bluesky-social/indigo/repomgr"
+	"github.com/ipfs/go-cid"
+	"github.com/labstack/echo/v4"
+)
+
+// parses an optional swapCommit or swapRecord CID from a write request
+func parseSwapCid(s *string) (*cid.Cid, error) {
+	if s == nil || *s == "" {
+		return nil, nil
+	}
+	c, err := cid.Decode(*s)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "invalid swap CID: "+err.Error())
+	}
+	return &c, nil
+}
+
+func parseSwap(swapCommit, swapRecord *string) (*repomgr.Swap, error) {
+	commit, err := parseSwapCid(swapCommit)
+	if err != nil {
+		return nil, err
+	}
+	record, err := parseSwapCid(swapRecord)
+	if err != nil {
+		return nil, err
+	}
+	if commit == nil && record == nil {
+		return nil, nil
+	}
+	return &repomgr.Swap{Commit: commit, Record: record}, nil
+}
@@ -0,0 +1,124 @@
+package pds
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/bluesky-social/indigo/repomgr"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSwapWrites(t *testing.T) {
+	assert := assert.New(t)
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	e := "test@foo.com"
+	p := "password"
+	o, err := s.handleComAtprotoServerCreateAccount(context.Background(), &atproto.ServerCreateAccount_Input{
+		Email:    &e,
+		Password: &p,
+		Handle:   "testman.test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := s.lookupUserByDid(context.Background(), o.Did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), "user", u)
+
+	put := func(text string, swapRecord, swapCommit *string) (*atproto.RepoPutRecord_Output, error) {
+		return s.handleComAtprotoRepoPutRecord(ctx, &atproto.RepoPutRecord_Input{
+			Repo:       u.Did,
+			Collection: "app.bsky.feed.post",
+			Rkey:       "3kswaptest222",
+			Record:     &lexutil.LexiconTypeDecoder{Val: &bsky.FeedPost{Text: text, CreatedAt: "2024-01-01T00:00:00.000Z"}},
+			SwapRecord: swapRecord,
+			SwapCommit: swapCommit,
+		})
+	}
+
+	first, err := put("first", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// concurrent writers which all read the first version: only one may win
+	var wg sync.WaitGroup
+	var lk sync.Mutex
+	wins, swapErrs := 0, 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := put("second", &first.Cid, nil)
+			lk.Lock()
+			defer lk.Unlock()
+			if err == nil {
+				wins++
+			} else if errors.Is(err, repomgr.ErrInvalidSwap) {
+				swapErrs++
+			} else {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(1, wins)
+	assert.Equal(7, swapErrs)
+
+	// stale record CID
+	err = s.handleComAtprotoRepoDeleteRecord(ctx, &atproto.RepoDeleteRecord_Input{
+		Repo:       u.Did,
+		Collection: "app.bsky.feed.post",
+		Rkey:       "3kswaptest222",
+		SwapRecord: &first.Cid,
+	})
+	assert.ErrorIs(err, repomgr.ErrInvalidSwap)
+
+	// stale, then current, commit CID
+	head, err := s.repoman.GetRepoRoot(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	staleCommit := head.String()
+	if _, err := put("third", nil, &staleCommit); err != nil {
+		t.Fatal(err)
+	}
+	_, err = put("fourth", nil, &staleCommit)
+	assert.ErrorIs(err, repomgr.ErrInvalidSwap)
+
+	head, err = s.repoman.GetRepoRoot(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := head.String()
+	err = s.handleComAtprotoRepoApplyWrites(ctx, &atproto.RepoApplyWrites_Input{
+		Repo:       u.Did,
+		SwapCommit: &staleCommit,
+		Writes: []*atproto.RepoApplyWrites_Input_Writes_Elem{{
+			RepoApplyWrites_Delete: &atproto.RepoApplyWrites_Delete{Collection: "app.bsky.feed.post", Rkey: "3kswaptest222"},
+		}},
+	})
+	assert.ErrorIs(err, repomgr.ErrInvalidSwap)
+	err = s.handleComAtprotoRepoApplyWrites(ctx, &atproto.RepoApplyWrites_Input{
+		Repo:       u.Did,
+		SwapCommit: &current,
+		Writes: []*atproto.RepoApplyWrites_Input_Writes_Elem{{
+			RepoApplyWrites_Delete: &atproto.RepoApplyWrites_Delete{Collection: "app.bsky.feed.post", Rkey: "3kswaptest222"},
+		}},
+	})
+	assert.NoError(err)
+
+	// swapping against a record which no longer exists fails
+	_, err = put("fifth", &first.Cid, nil)
+	assert.ErrorIs(err, repomgr.ErrInvalidSwap)
+}
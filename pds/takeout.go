@@ -0,0 +1,446 @@
+package pds
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/bluesky-social/indigo/models"
+	"github.com/bluesky-social/indigo/repo"
+
+	"github.com/ipfs/go-cid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// TakeoutBlobSource provides blob contents for take-out archives. This PDS
+// does not store blobs itself, so archives only include blob data if a source
+// is configured; otherwise referenced blobs are listed in the manifest but not
+// included.
+type TakeoutBlobSource interface {
+	GetBlob(ctx context.Context, did string, c cid.Cid) (io.ReadCloser, error)
+}
+
+type TakeoutConfig struct {
+	// Directory where archives are written while they are available for download
+	Dir string
+	// How long a completed archive (and its download URL) remains available
+	TTL time.Duration
+	// Optional
+	BlobSource TakeoutBlobSource
+}
+
+const (
+	TakeoutStatePending  = "pending"
+	TakeoutStateRunning  = "running"
+	TakeoutStateComplete = "complete"
+	TakeoutStateFailed   = "failed"
+	TakeoutStateExpired  = "expired"
+)
+
+type TakeoutJob struct {
+	gorm.Model
+	Usr       models.Uid `gorm:"index"`
+	State     string
+	Error     string
+	Path      string
+	Size      int64
+	ExpiresAt *time.Time
+}
+
+// TakeoutManifest is included in each archive as manifest.json
+type TakeoutManifest struct {
+	Did         string                `json:"did"`
+	Handle      string                `json:"handle"`
+	CreatedAt   string                `json:"createdAt"`
+	RepoRoot    string                `json:"repoRoot"`
+	RepoRev     string                `json:"repoRev"`
+	Records     int                   `json:"records"`
+	Collections map[string]int        `json:"collections"`
+	Blobs       []TakeoutManifestBlob `json:"blobs"`
+}
+
+type TakeoutManifestBlob struct {
+	Cid      string `json:"cid"`
+	MimeType string `json:"mimeType,omitempty"`
+	Size     int64  `json:"size"`
+	// False if the blob contents could not be included in the archive
+	Included bool   `json:"included"`
+	Error    string `json:"error,omitempty"`
+}
+
+type takeoutStatus struct {
+	ID          uint       `json:"id"`
+	State       string     `json:"state"`
+	Error       string     `json:"error,omitempty"`
+	Size        int64      `json:"size,omitempty"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+	DownloadURL string     `json:"downloadUrl,omitempty"`
+}
+
+func (s *Server) SetTakeoutConfig(cfg *TakeoutConfig) error {
+	if cfg.Dir == "" {
+		return fmt.Errorf("takeout directory must be set")
+	}
+	if cfg.TTL == 0 {
+		cfg.TTL = 24 * time.Hour
+	}
+	if err := os.MkdirAll(cfg.Dir, 0700); err != nil {
+		return err
+	}
+	if err := s.db.AutoMigrate(&TakeoutJob{}); err != nil {
+		return err
+	}
+	// jobs run in-process, so any left unfinished by a previous run will never complete
+	if err := s.db.Model(&TakeoutJob{}).Where("state IN ?", []string{TakeoutStatePending, TakeoutStateRunning}).Updates(map[string]any{
+		"state": TakeoutStateFailed,
+		"error": "interrupted by server restart",
+	}).Error; err != nil {
+		return err
+	}
+	s.takeoutConfig = cfg
+	return nil
+}
+
+// HandleTakeoutRequest starts building a take-out archive for the
+// authenticated user, or returns the status of one which is already in
+// progress
+func (s *Server) HandleTakeoutRequest(c echo.Context) error {
+	ctx := c.Request().Context()
+	if s.takeoutConfig == nil {
+		return echo.NewHTTPError(http.StatusNotImplemented, "account export not enabled")
+	}
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+
+	var job TakeoutJob
+	err = s.db.Where("usr = ? AND state IN ?", u.ID, []string{TakeoutStatePending, TakeoutStateRunning}).First(&job).Error
+	if err == nil {
+		return c.JSON(http.StatusOK, s.takeoutStatus(&job))
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	job = TakeoutJob{
+		Usr:   u.ID,
+		State: TakeoutStatePending,
+	}
+	if err := s.db.Create(&job).Error; err != nil {
+		return err
+	}
+
+	go s.runTakeoutJob(context.Background(), job.ID, u)
+
+	return c.JSON(http.StatusAccepted, s.takeoutStatus(&job))
+}
+
+// HandleTakeoutStatus returns the status of the authenticated user's most
+// recent take-out archive, including a signed download URL once it is complete
+func (s *Server) HandleTakeoutStatus(c echo.Context) error {
+	ctx := c.Request().Context()
+	if s.takeoutConfig == nil {
+		return echo.NewHTTPError(http.StatusNotImplemented, "account export not enabled")
+	}
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+
+	var job TakeoutJob
+	if err := s.db.Where("usr = ?", u.ID).Order("id desc").First(&job).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "no account export found")
+		}
+		return err
+	}
+	return c.JSON(http.StatusOK, s.takeoutStatus(&job))
+}
+
+// HandleTakeoutDownload serves a completed archive. This route is not behind
+// session auth; instead the URL carries an expiry and signature.
+func (s *Server) HandleTakeoutDownload(c echo.Context) error {
+	if s.takeoutConfig == nil {
+		return echo.NewHTTPError(http.StatusNotImplemented, "account export not enabled")
+	}
+
+	id, err := strconv.ParseUint(c.QueryParam("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid id")
+	}
+	exp, err := strconv.ParseInt(c.QueryParam("exp"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid exp")
+	}
+	sig, err := hex.DecodeString(c.QueryParam("sig"))
+	if err != nil || !hmac.Equal(sig, s.takeoutSignature(uint(id), exp)) {
+		return echo.NewHTTPError(http.StatusForbidden, "invalid download signature")
+	}
+	if time.Now().Unix() > exp {
+		return echo.NewHTTPError(http.StatusGone, "download link expired")
+	}
+
+	var job TakeoutJob
+	if err := s.db.First(&job, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "no such account export")
+		}
+		return err
+	}
+	if job.State != TakeoutStateComplete {
+		return echo.NewHTTPError(http.StatusNotFound, "account export not available")
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filepath.Base(job.Path)))
+	return c.File(job.Path)
+}
+
+func (s *Server) takeoutStatus(job *TakeoutJob) *takeoutStatus {
+	st := &takeoutStatus{
+		ID:        job.ID,
+		State:     job.State,
+		Error:     job.Error,
+		Size:      job.Size,
+		ExpiresAt: job.ExpiresAt,
+	}
+	if job.State == TakeoutStateComplete && job.ExpiresAt != nil {
+		st.DownloadURL = s.takeoutDownloadURL(job.ID, job.ExpiresAt.Unix())
+	}
+	return st
+}
+
+func (s *Server) takeoutSignature(id uint, exp int64) []byte {
+	mac := hmac.New(sha256.New, s.jwtSigningKey)
+	fmt.Fprintf(mac, "takeout:%d:%d", id, exp)
+	return mac.Sum(nil)
+}
+
+func (s *Server) takeoutDownloadURL(id uint, exp int64) string {
+	q := url.Values{}
+	q.Set("id", strconv.FormatUint(uint64(id), 10))
+	q.Set("exp", strconv.FormatInt(exp, 10))
+	q.Set("sig", hex.EncodeToString(s.takeoutSignature(id, exp)))
+	base := strings.TrimSuffix(s.serviceUrl, "/")
+	if !strings.Contains(base, "://") {
+		// the service "URL" is often configured as a bare hostname
+		base = "https://" + base
+	}
+	return base + "/takeout/download?" + q.Encode()
+}
+
+func (s *Server) runTakeoutJob(ctx context.Context, id uint, u *User) {
+	log := s.log.With("takeout", id, "did", u.Did)
+
+	if err := s.db.Model(&TakeoutJob{}).Where("id = ?", id).Update("state", TakeoutStateRunning).Error; err != nil {
+		log.Error("failed to update takeout job", "err", err)
+		return
+	}
+
+	start := time.Now()
+	path, size, err := s.buildTakeoutArchive(ctx, id, u)
+	if err != nil {
+		log.Error("takeout archive failed", "err", err)
+		if path != "" {
+			os.Remove(path)
+		}
+		if err := s.db.Model(&TakeoutJob{}).Where("id = ?", id).Updates(map[string]any{
+			"state": TakeoutStateFailed,
+			"error": err.Error(),
+		}).Error; err != nil {
+			log.Error("failed to update takeout job", "err", err)
+		}
+		return
+	}
+
+	exp := time.Now().Add(s.takeoutConfig.TTL)
+	if err := s.db.Model(&TakeoutJob{}).Where("id = ?", id).Updates(map[string]any{
+		"state":      TakeoutStateComplete,
+		"path":       path,
+		"size":       size,
+		"expires_at": exp,
+	}).Error; err != nil {
+		log.Error("failed to update takeout job", "err", err)
+		return
+	}
+	log.Info("takeout archive complete", "size", size, "duration", time.Since(start))
+}
+
+// writes a zip archive containing repo.car, manifest.json, and blobs/<cid>
+func (s *Server) buildTakeoutArchive(ctx context.Context, id uint, u *User) (string, int64, error) {
+	var carBuf bytes.Buffer
+	if err := s.repoman.ReadRepo(ctx, u.ID, "", &carBuf); err != nil {
+		return "", 0, fmt.Errorf("reading repo: %w", err)
+	}
+	r, err := repo.ReadRepoFromCar(ctx, bytes.NewReader(carBuf.Bytes()))
+	if err != nil {
+		return "", 0, fmt.Errorf("parsing repo: %w", err)
+	}
+	rev, err := s.repoman.GetRepoRev(ctx, u.ID)
+	if err != nil {
+		return "", 0, err
+	}
+	root, err := s.repoman.GetRepoRoot(ctx, u.ID)
+	if err != nil {
+		return "", 0, err
+	}
+
+	manifest := TakeoutManifest{
+		Did:         u.Did,
+		Handle:      u.Handle,
+		CreatedAt:   time.Now().UTC().Format(time.RFC3339),
+		RepoRoot:    root.String(),
+		RepoRev:     rev,
+		Collections: make(map[string]int),
+	}
+
+	seen := make(map[string]bool)
+	err = r.ForEach(ctx, "", func(k string, _ cid.Cid) error {
+		manifest.Records++
+		collection, _, _ := strings.Cut(k, "/")
+		manifest.Collections[collection]++
+
+		_, recb, err := r.GetRecordBytes(ctx, k)
+		if err != nil {
+			return err
+		}
+		rec, err := data.UnmarshalCBOR(*recb)
+		if err != nil {
+			// not every record is necessarily valid atproto data; skip blob extraction
+			return nil
+		}
+		for _, b := range data.ExtractBlobs(rec) {
+			c := b.Ref.String()
+			if seen[c] {
+				continue
+			}
+			seen[c] = true
+			manifest.Blobs = append(manifest.Blobs, TakeoutManifestBlob{
+				Cid:      c,
+				MimeType: b.MimeType,
+				Size:     b.Size,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return "", 0, fmt.Errorf("walking repo records: %w", err)
+	}
+
+	path := filepath.Join(s.takeoutConfig.Dir, fmt.Sprintf("%s-%d.zip", strings.ReplaceAll(u.Did, ":", "_"), id))
+	fi, err := os.Create(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer fi.Close()
+
+	zw := zip.NewWriter(fi)
+	w, err := zw.Create("repo.car")
+	if err != nil {
+		return path, 0, err
+	}
+	if _, err := w.Write(carBuf.Bytes()); err != nil {
+		return path, 0, err
+	}
+
+	for i := range manifest.Blobs {
+		mb := &manifest.Blobs[i]
+		if err := s.addTakeoutBlob(ctx, zw, u.Did, mb); err != nil {
+			mb.Error = err.Error()
+		}
+	}
+
+	w, err = zw.Create("manifest.json")
+	if err != nil {
+		return path, 0, err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(&manifest); err != nil {
+		return path, 0, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return path, 0, err
+	}
+	st, err := fi.Stat()
+	if err != nil {
+		return path, 0, err
+	}
+	return path, st.Size(), nil
+}
+
+func (s *Server) addTakeoutBlob(ctx context.Context, zw *zip.Writer, did string, mb *TakeoutManifestBlob) error {
+	if s.takeoutConfig.BlobSource == nil {
+		return fmt.Errorf("blob storage not available")
+	}
+	c, err := cid.Decode(mb.Cid)
+	if err != nil {
+		return err
+	}
+	rc, err := s.takeoutConfig.BlobSource.GetBlob(ctx, did, c)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	w, err := zw.Create("blobs/" + mb.Cid)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, rc); err != nil {
+		return err
+	}
+	mb.Included = true
+	return nil
+}
+
+// RunTakeoutCleanup periodically deletes expired take-out archives, until the
+// context is cancelled
+func (s *Server) RunTakeoutCleanup(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if err := s.cleanupTakeouts(ctx); err != nil {
+			s.log.Error("takeout cleanup failed", "err", err)
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Server) cleanupTakeouts(ctx context.Context) error {
+	var expired []TakeoutJob
+	if err := s.db.WithContext(ctx).Where("state = ? AND expires_at < ?", TakeoutStateComplete, time.Now()).Find(&expired).Error; err != nil {
+		return err
+	}
+	for _, job := range expired {
+		if err := os.Remove(job.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			s.log.Error("failed to remove expired takeout archive", "takeout", job.ID, "path", job.Path, "err", err)
+			continue
+		}
+		if err := s.db.WithContext(ctx).Model(&TakeoutJob{}).Where("id = ?", job.ID).Update("state", TakeoutStateExpired).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package pds
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+
+	"github.com/ipfs/go-cid"
+	"github.com/labstack/echo/v4"
+)
+
+type testBlobSource map[string][]byte
+
+func (ts testBlobSource) GetBlob(ctx context.Context, did string, c cid.Cid) (io.ReadCloser, error) {
+	b, ok := ts[c.String()]
+	if !ok {
+		return nil, fmt.Errorf("blob not found")
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+func TestTakeoutArchive(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	avatar, err := cid.Decode("bafkreiccldh766hwcnuxnf2wh6jgzepf2nlu2lvcllt63eww5p6chi4ity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs := testBlobSource{avatar.String(): []byte("avatar bytes")}
+	if err := s.SetTakeoutConfig(&TakeoutConfig{Dir: t.TempDir(), BlobSource: blobs}); err != nil {
+		t.Fatal(err)
+	}
+
+	e := "test@foo.com"
+	p := "password"
+	o, err := s.handleComAtprotoServerCreateAccount(ctx, &atproto.ServerCreateAccount_Input{
+		Email:    &e,
+		Password: &p,
+		Handle:   "testman.test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := s.lookupUserByDid(ctx, o.Did)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = s.repoman.CreateRecord(ctx, u.ID, "app.bsky.actor.profile", &bsky.ActorProfile{
+		Avatar: &lexutil.LexBlob{Ref: lexutil.LexLink(avatar), MimeType: "image/jpeg", Size: 12},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		_, _, err := s.repoman.CreateRecord(ctx, u.ID, "app.bsky.feed.post", &bsky.FeedPost{
+			Text:      fmt.Sprintf("post number %d", i),
+			CreatedAt: "2024-01-01T00:00:00.000Z",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	job := TakeoutJob{Usr: u.ID, State: TakeoutStatePending}
+	if err := s.db.Create(&job).Error; err != nil {
+		t.Fatal(err)
+	}
+	s.runTakeoutJob(ctx, job.ID, u)
+	if err := s.db.First(&job, job.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if job.State != TakeoutStateComplete {
+		t.Fatalf("takeout job not complete: %s %s", job.State, job.Error)
+	}
+
+	// download via the signed URL
+	st := s.takeoutStatus(&job)
+	dlurl, err := url.Parse(st.DownloadURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ec := echo.New()
+	ec.GET("/takeout/download", s.HandleTakeoutDownload)
+	rec := httptest.NewRecorder()
+	ec.ServeHTTP(rec, httptest.NewRequest("GET", "/takeout/download?"+dlurl.RawQuery, nil))
+	if rec.Code != 200 {
+		t.Fatalf("download failed: %d %s", rec.Code, rec.Body.String())
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	for _, name := range []string{"repo.car", "manifest.json", "blobs/" + avatar.String()} {
+		if files[name] == nil {
+			t.Fatalf("archive missing %s", name)
+		}
+	}
+
+	mr, err := files["manifest.json"].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest TakeoutManifest
+	if err := json.NewDecoder(mr).Decode(&manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Did != o.Did || manifest.Records != 5 || manifest.Collections["app.bsky.feed.post"] != 3 {
+		t.Fatalf("unexpected manifest: %+v", manifest)
+	}
+	if len(manifest.Blobs) != 1 || !manifest.Blobs[0].Included {
+		t.Fatalf("unexpected manifest blobs: %+v", manifest.Blobs)
+	}
+
+	// tampered signature is rejected
+	q := dlurl.Query()
+	q.Set("exp", fmt.Sprint(job.ExpiresAt.Unix()+3600))
+	rec = httptest.NewRecorder()
+	ec.ServeHTTP(rec, httptest.NewRequest("GET", "/takeout/download?"+q.Encode(), nil))
+	if rec.Code != 403 {
+		t.Fatalf("expected forbidden, got %d", rec.Code)
+	}
+}
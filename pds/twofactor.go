@@ -0,0 +1,657 @@
+package pds
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/models"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// AppPasswordPolicy controls whether sessions created with an app password
+// need a second factor
+type AppPasswordPolicy string
+
+const (
+	// app password sessions skip the second factor (as app passwords are
+	// meant for clients which can't prompt for one)
+	AppPasswordBypass AppPasswordPolicy = "bypass"
+	// app password sessions need a second factor like any other
+	AppPasswordRequire AppPasswordPolicy = "require"
+)
+
+type TwoFactorConfig struct {
+	// Issuer is the account issuer shown in authenticator apps. Defaults to
+	// the host of the service URL
+	Issuer string
+	// EmailCodeTTL is how long emailed sign-in codes are valid for. Defaults
+	// to 10 minutes
+	EmailCodeTTL time.Duration
+	// MaxEmailCodeAttempts is how many wrong guesses invalidate an emailed
+	// code. Defaults to 5
+	MaxEmailCodeAttempts int
+	// MaxTOTPAttempts is how many wrong codes in a row lock an account's
+	// TOTP for TOTPLockout. Defaults to 5
+	MaxTOTPAttempts int
+	// TOTPLockout defaults to 15 minutes. Emailed codes still work while
+	// TOTP is locked
+	TOTPLockout time.Duration
+	// AppPasswords defaults to AppPasswordBypass
+	AppPasswords AppPasswordPolicy
+}
+
+// AccountTwoFactor is an account's second factor settings. Accounts without
+// a row don't use two-factor authentication.
+type AccountTwoFactor struct {
+	Usr models.Uid `gorm:"primarykey"`
+	// base32 TOTP secret, once enrollment is confirmed
+	TOTPSecret  string
+	TOTPEnabled bool
+	// secret awaiting confirmation with a first code
+	PendingTOTPSecret string
+	// last TOTP time step accepted, so codes can't be replayed
+	LastTOTPStep int64
+	// wrong codes since the last accepted second factor, and until when
+	// TOTP is locked after too many
+	FailedTOTPAttempts int
+	TOTPLockedUntil    time.Time
+	EmailEnabled       bool
+	UpdatedAt          time.Time
+}
+
+// EmailAuthCode is an emailed sign-in code. Only a hash of the code is
+// stored.
+type EmailAuthCode struct {
+	gorm.Model
+	Usr       models.Uid `gorm:"index"`
+	CodeHash  string
+	ExpiresAt time.Time
+	Attempts  int
+}
+
+// AuthFactorError is returned by createSession when a second factor is
+// needed, or the one given was wrong. Name is the XRPC error name.
+type AuthFactorError struct {
+	Name    string
+	Message string
+}
+
+func (afe *AuthFactorError) Error() string {
+	return fmt.Sprintf("%s: %s", afe.Name, afe.Message)
+}
+
+var ErrTwoFactorNotEnabled = errors.New("two-factor authentication is not enabled on this server")
+
+const (
+	totpStep   = 30 * time.Second
+	totpDigits = 6
+	// TOTP codes from one step either side of now are accepted, for clock
+	// skew
+	totpSkew = 1
+	// an emailed code is not resent within this long of the last one
+	emailCodeResendInterval = time.Minute
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// SetTwoFactorConfig enables two-factor authentication for accounts which
+// enroll in it
+func (s *Server) SetTwoFactorConfig(cfg *TwoFactorConfig) error {
+	switch cfg.AppPasswords {
+	case "":
+		cfg.AppPasswords = AppPasswordBypass
+	case AppPasswordBypass, AppPasswordRequire:
+	default:
+		return fmt.Errorf("invalid app password policy: %q", cfg.AppPasswords)
+	}
+	if cfg.EmailCodeTTL == 0 {
+		cfg.EmailCodeTTL = 10 * time.Minute
+	}
+	if cfg.MaxEmailCodeAttempts == 0 {
+		cfg.MaxEmailCodeAttempts = 5
+	}
+	if cfg.MaxTOTPAttempts == 0 {
+		cfg.MaxTOTPAttempts = 5
+	}
+	if cfg.TOTPLockout == 0 {
+		cfg.TOTPLockout = 15 * time.Minute
+	}
+	if cfg.Issuer == "" {
+		cfg.Issuer = "PDS"
+		if u, err := url.Parse(s.serviceUrl); err == nil && u.Host != "" {
+			cfg.Issuer = u.Host
+		}
+	}
+	if err := s.db.AutoMigrate(&AccountTwoFactor{}, &EmailAuthCode{}); err != nil {
+		return err
+	}
+	s.twoFactorConfig = cfg
+	return nil
+}
+
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	off := sum[len(sum)-1] & 0x0f
+	v := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, v%1000000)
+}
+
+// checkTOTP returns the time step a code is valid for (RFC 6238, with
+// HMAC-SHA1, 30 second steps and 6 digits), if it is later than lastStep
+func checkTOTP(secret, code string, now time.Time, lastStep int64) (int64, bool) {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	cur := now.Unix() / int64(totpStep/time.Second)
+	for step := cur - totpSkew; step <= cur+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func newTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// unambiguous characters for emailed codes
+const emailCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// newEmailCode returns a code like "ABCDE-23456"
+func newEmailCode() (string, error) {
+	var sb strings.Builder
+	for i := 0; i < 10; i++ {
+		if i == 5 {
+			sb.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(emailCodeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		sb.WriteByte(emailCodeAlphabet[n.Int64()])
+	}
+	return sb.String(), nil
+}
+
+func hashEmailCode(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *Server) getTwoFactor(ctx context.Context, uid models.Uid) (*AccountTwoFactor, error) {
+	var tf AccountTwoFactor
+	if err := s.db.WithContext(ctx).Find(&tf, "usr = ?", uid).Error; err != nil {
+		return nil, err
+	}
+	tf.Usr = uid
+	return &tf, nil
+}
+
+func (s *Server) saveTwoFactor(ctx context.Context, tf *AccountTwoFactor) error {
+	return s.db.WithContext(ctx).Save(tf).Error
+}
+
+// sendEmailAuthCode emails the account a new sign-in code, replacing any
+// outstanding one. If a code was sent very recently, nothing is sent.
+func (s *Server) sendEmailAuthCode(ctx context.Context, u *User) error {
+	var last EmailAuthCode
+	if err := s.db.WithContext(ctx).Where("usr = ?", u.ID).Order("created_at desc").Limit(1).Find(&last).Error; err != nil {
+		return err
+	}
+	if last.ID != 0 && time.Since(last.CreatedAt) < emailCodeResendInterval {
+		return nil
+	}
+
+	code, err := newEmailCode()
+	if err != nil {
+		return err
+	}
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("usr = ?", u.ID).Delete(&EmailAuthCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&EmailAuthCode{
+			Usr:       u.ID,
+			CodeHash:  hashEmailCode(code),
+			ExpiresAt: time.Now().Add(s.twoFactorConfig.EmailCodeTTL),
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+	return s.sendUserEmail(ctx, u, EmailSignInCode, &EmailData{Token: code})
+}
+
+// useEmailAuthCode checks an emailed code, consuming it if it matches
+func (s *Server) useEmailAuthCode(ctx context.Context, uid models.Uid, code string) (bool, error) {
+	var ec EmailAuthCode
+	if err := s.db.WithContext(ctx).Where("usr = ? AND expires_at > ?", uid, time.Now()).Order("created_at desc").Limit(1).Find(&ec).Error; err != nil {
+		return false, err
+	}
+	if ec.ID == 0 || ec.Attempts >= s.twoFactorConfig.MaxEmailCodeAttempts {
+		return false, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(ec.CodeHash), []byte(hashEmailCode(code))) != 1 {
+		err := s.db.WithContext(ctx).Model(&EmailAuthCode{}).Where("id = ?", ec.ID).Update("attempts", gorm.Expr("attempts + 1")).Error
+		return false, err
+	}
+	// only one request gets to use the code
+	res := s.db.WithContext(ctx).Unscoped().Where("id = ?", ec.ID).Delete(&EmailAuthCode{})
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
+
+// useTOTP checks a TOTP code, recording its time step so it can't be used
+// again. No code is accepted while TOTP is locked (see recordTOTPFailure).
+func (s *Server) useTOTP(ctx context.Context, tf *AccountTwoFactor, code string) (bool, error) {
+	if tf.totpLocked() {
+		return false, nil
+	}
+	step, ok := checkTOTP(tf.TOTPSecret, code, time.Now(), tf.LastTOTPStep)
+	if !ok {
+		return false, nil
+	}
+	// conditional, so concurrent requests can't both use the same code
+	res := s.db.WithContext(ctx).Model(&AccountTwoFactor{}).
+		Where("usr = ? AND last_totp_step < ?", tf.Usr, step).
+		Update("last_totp_step", step)
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
+
+func (tf *AccountTwoFactor) totpLocked() bool {
+	return time.Now().Before(tf.TOTPLockedUntil)
+}
+
+// recordTOTPFailure counts a wrong second factor against an account using
+// TOTP, locking TOTP for a while after too many in a row, so that the code
+// space can't be guessed through
+func (s *Server) recordTOTPFailure(ctx context.Context, tf *AccountTwoFactor) error {
+	if !tf.TOTPEnabled || tf.totpLocked() {
+		return nil
+	}
+	if err := s.db.WithContext(ctx).Model(&AccountTwoFactor{}).Where("usr = ?", tf.Usr).Update("failed_totp_attempts", gorm.Expr("failed_totp_attempts + 1")).Error; err != nil {
+		return err
+	}
+	var cur AccountTwoFactor
+	if err := s.db.WithContext(ctx).Find(&cur, "usr = ?", tf.Usr).Error; err != nil {
+		return err
+	}
+	if cur.FailedTOTPAttempts < s.twoFactorConfig.MaxTOTPAttempts {
+		return nil
+	}
+	tf.TOTPLockedUntil = time.Now().Add(s.twoFactorConfig.TOTPLockout)
+	return s.db.WithContext(ctx).Model(&AccountTwoFactor{}).Where("usr = ?", tf.Usr).Updates(map[string]any{
+		"failed_totp_attempts": 0,
+		"totp_locked_until":    tf.TOTPLockedUntil,
+	}).Error
+}
+
+// resetTOTPFailures clears the count of wrong codes once a second factor is
+// accepted
+func (s *Server) resetTOTPFailures(ctx context.Context, tf *AccountTwoFactor) error {
+	if tf.FailedTOTPAttempts == 0 {
+		return nil
+	}
+	return s.db.WithContext(ctx).Model(&AccountTwoFactor{}).Where("usr = ?", tf.Usr).Update("failed_totp_attempts", 0).Error
+}
+
+// checkSecondFactor is called by createSession once the password has been
+// checked. If the account uses two-factor authentication, the token must be a
+// current TOTP code or emailed sign-in code; if it's missing, a sign-in code
+// is emailed (for accounts using email codes) and an AuthFactorError asks for
+// it. Emailed codes are also accepted from TOTP accounts, as a fallback for a
+// lost authenticator (see HandleRequestEmailAuthCode), and while TOTP is
+// locked after too many wrong codes.
+func (s *Server) checkSecondFactor(ctx context.Context, u *User, token *string, appPassword bool) error {
+	if s.twoFactorConfig == nil {
+		return nil
+	}
+	if appPassword && s.twoFactorConfig.AppPasswords == AppPasswordBypass {
+		return nil
+	}
+	tf, err := s.getTwoFactor(ctx, u.ID)
+	if err != nil {
+		return err
+	}
+	if !tf.TOTPEnabled && !tf.EmailEnabled {
+		return nil
+	}
+
+	tok := ""
+	if token != nil {
+		tok = strings.TrimSpace(*token)
+	}
+	if tok == "" {
+		msg := "A code from your authenticator app is required"
+		if tf.EmailEnabled {
+			if err := s.sendEmailAuthCode(ctx, u); err != nil {
+				return fmt.Errorf("sending sign-in code: %w", err)
+			}
+			msg = "A sign in code has been sent to your email address"
+		}
+		s.audit(ctx, &AuditEvent{Type: AuditLoginFailed, Did: u.Did, Handle: u.Handle, Reason: "second factor required"})
+		return &AuthFactorError{Name: "AuthFactorTokenRequired", Message: msg}
+	}
+
+	ok := false
+	if tf.TOTPEnabled {
+		if ok, err = s.useTOTP(ctx, tf, tok); err != nil {
+			return err
+		}
+	}
+	if !ok {
+		if ok, err = s.useEmailAuthCode(ctx, u.ID, tok); err != nil {
+			return err
+		}
+	}
+	if ok {
+		return s.resetTOTPFailures(ctx, tf)
+	}
+
+	if err := s.recordTOTPFailure(ctx, tf); err != nil {
+		return err
+	}
+	s.audit(ctx, &AuditEvent{Type: AuditLoginFailed, Did: u.Did, Handle: u.Handle, Reason: "invalid second factor"})
+	if tf.totpLocked() {
+		return &AuthFactorError{Name: "InvalidToken", Message: "Too many invalid codes. Try again later, or use a sign in code sent to your email address"}
+	}
+	return &AuthFactorError{Name: "InvalidToken", Message: "Token is invalid"}
+}
+
+// emailAuthFactor reports whether the account uses emailed sign-in codes, for
+// session responses
+func (s *Server) emailAuthFactor(ctx context.Context, uid models.Uid) *bool {
+	if s.twoFactorConfig == nil {
+		return nil
+	}
+	tf, err := s.getTwoFactor(ctx, uid)
+	if err != nil {
+		s.log.Error("loading two-factor settings", "err", err)
+		return nil
+	}
+	return &tf.EmailEnabled
+}
+
+type twoFactorStatus struct {
+	TOTPEnabled  bool `json:"totpEnabled"`
+	TOTPPending  bool `json:"totpPending"`
+	EmailEnabled bool `json:"emailEnabled"`
+}
+
+// twoFactorUser returns the authenticated user, and their two-factor
+// settings
+func (s *Server) twoFactorUser(c echo.Context) (*User, *AccountTwoFactor, error) {
+	if s.twoFactorConfig == nil {
+		return nil, nil, echo.NewHTTPError(http.StatusNotImplemented, ErrTwoFactorNotEnabled.Error())
+	}
+	ctx := c.Request().Context()
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return nil, nil, echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+	tf, err := s.getTwoFactor(ctx, u.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return u, tf, nil
+}
+
+func (s *Server) auditTwoFactorChange(ctx context.Context, u *User, change string) {
+	s.audit(ctx, &AuditEvent{
+		Type:    AuditTwoFactorChange,
+		Did:     u.Did,
+		Handle:  u.Handle,
+		Details: map[string]string{"change": change},
+	})
+}
+
+// HandleGetTwoFactor returns the authenticated account's two-factor settings
+func (s *Server) HandleGetTwoFactor(c echo.Context) error {
+	_, tf, err := s.twoFactorUser(c)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, &twoFactorStatus{
+		TOTPEnabled:  tf.TOTPEnabled,
+		TOTPPending:  tf.PendingTOTPSecret != "",
+		EmailEnabled: tf.EmailEnabled,
+	})
+}
+
+type totpEnrollment struct {
+	Secret string `json:"secret"`
+	// otpauth:// URI, for QR codes
+	URI string `json:"uri"`
+}
+
+type totpEnrollInput struct {
+	Password string `json:"password"`
+}
+
+// HandleEnrollTOTP starts TOTP enrollment, returning a new secret to add to
+// an authenticator app. The account password is required, as enrolling
+// replaces any enrollment in progress. TOTP isn't required at sign-in until
+// enrollment is confirmed with a code (see HandleConfirmTOTP).
+func (s *Server) HandleEnrollTOTP(c echo.Context) error {
+	ctx := c.Request().Context()
+	u, tf, err := s.twoFactorUser(c)
+	if err != nil {
+		return err
+	}
+	var body totpEnrollInput
+	if err := c.Bind(&body); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if body.Password != u.Password {
+		return echo.NewHTTPError(http.StatusUnauthorized, ErrInvalidUsernameOrPassword.Error())
+	}
+	if tf.TOTPEnabled {
+		return echo.NewHTTPError(http.StatusBadRequest, "TOTP is already enabled; disable it first to enroll a new authenticator")
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return err
+	}
+	tf.PendingTOTPSecret = secret
+	if err := s.saveTwoFactor(ctx, tf); err != nil {
+		return err
+	}
+
+	issuer := s.twoFactorConfig.Issuer
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	uri := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + u.Handle,
+		RawQuery: q.Encode(),
+	}
+	return c.JSON(http.StatusOK, &totpEnrollment{Secret: secret, URI: uri.String()})
+}
+
+type totpCodeInput struct {
+	Code string `json:"code"`
+}
+
+// HandleConfirmTOTP completes TOTP enrollment with a code from the new
+// authenticator
+func (s *Server) HandleConfirmTOTP(c echo.Context) error {
+	ctx := c.Request().Context()
+	u, tf, err := s.twoFactorUser(c)
+	if err != nil {
+		return err
+	}
+	var body totpCodeInput
+	if err := c.Bind(&body); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if tf.PendingTOTPSecret == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "no TOTP enrollment in progress")
+	}
+	step, ok := checkTOTP(tf.PendingTOTPSecret, strings.TrimSpace(body.Code), time.Now(), 0)
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid code")
+	}
+
+	tf.TOTPSecret = tf.PendingTOTPSecret
+	tf.PendingTOTPSecret = ""
+	tf.TOTPEnabled = true
+	tf.LastTOTPStep = step
+	if err := s.saveTwoFactor(ctx, tf); err != nil {
+		return err
+	}
+	s.auditTwoFactorChange(ctx, u, "totp_enabled")
+	return c.JSON(http.StatusOK, &twoFactorStatus{TOTPEnabled: true, EmailEnabled: tf.EmailEnabled})
+}
+
+// HandleDisableTOTP turns off TOTP, given a current code (or an emailed
+// sign-in code)
+func (s *Server) HandleDisableTOTP(c echo.Context) error {
+	ctx := c.Request().Context()
+	u, tf, err := s.twoFactorUser(c)
+	if err != nil {
+		return err
+	}
+	var body totpCodeInput
+	if err := c.Bind(&body); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if !tf.TOTPEnabled {
+		return echo.NewHTTPError(http.StatusBadRequest, "TOTP is not enabled")
+	}
+	code := strings.TrimSpace(body.Code)
+	ok, err := s.useTOTP(ctx, tf, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if ok, err = s.useEmailAuthCode(ctx, u.ID, code); err != nil {
+			return err
+		}
+	}
+	if !ok {
+		if err := s.recordTOTPFailure(ctx, tf); err != nil {
+			return err
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid code")
+	}
+
+	if err := s.db.WithContext(ctx).Model(&AccountTwoFactor{}).Where("usr = ?", u.ID).Updates(map[string]any{
+		"totp_enabled":         false,
+		"totp_secret":          "",
+		"pending_totp_secret":  "",
+		"failed_totp_attempts": 0,
+		"totp_locked_until":    time.Time{},
+	}).Error; err != nil {
+		return err
+	}
+	s.auditTwoFactorChange(ctx, u, "totp_disabled")
+	return c.JSON(http.StatusOK, &twoFactorStatus{EmailEnabled: tf.EmailEnabled})
+}
+
+type emailAuthFactorInput struct {
+	Enabled  bool   `json:"enabled"`
+	Password string `json:"password"`
+}
+
+// HandleSetEmailAuthFactor turns emailed sign-in codes on or off. The account
+// password is required either way.
+func (s *Server) HandleSetEmailAuthFactor(c echo.Context) error {
+	ctx := c.Request().Context()
+	u, tf, err := s.twoFactorUser(c)
+	if err != nil {
+		return err
+	}
+	var body emailAuthFactorInput
+	if err := c.Bind(&body); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if body.Password != u.Password {
+		return echo.NewHTTPError(http.StatusUnauthorized, ErrInvalidUsernameOrPassword.Error())
+	}
+	if body.Enabled && u.Email == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "account has no email address")
+	}
+
+	tf.EmailEnabled = body.Enabled
+	if err := s.saveTwoFactor(ctx, tf); err != nil {
+		return err
+	}
+	if body.Enabled {
+		s.auditTwoFactorChange(ctx, u, "email_enabled")
+	} else {
+		s.auditTwoFactorChange(ctx, u, "email_disabled")
+	}
+	return c.JSON(http.StatusOK, &twoFactorStatus{TOTPEnabled: tf.TOTPEnabled, EmailEnabled: tf.EmailEnabled})
+}
+
+type emailAuthCodeRequest struct {
+	Identifier string `json:"identifier"`
+	Password   string `json:"password"`
+}
+
+// HandleRequestEmailAuthCode emails a sign-in code to an account using TOTP,
+// for when the authenticator isn't available. It takes the same credentials
+// as createSession, and doesn't need a session.
+func (s *Server) HandleRequestEmailAuthCode(c echo.Context) error {
+	ctx := c.Request().Context()
+	if s.twoFactorConfig == nil {
+		return echo.NewHTTPError(http.StatusNotImplemented, ErrTwoFactorNotEnabled.Error())
+	}
+	var body emailAuthCodeRequest
+	if err := c.Bind(&body); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	u, err := s.lookupUserByHandle(ctx, body.Identifier)
+	if err != nil || body.Password != u.Password {
+		return echo.NewHTTPError(http.StatusUnauthorized, ErrInvalidUsernameOrPassword.Error())
+	}
+	tf, err := s.getTwoFactor(ctx, u.ID)
+	if err != nil {
+		return err
+	}
+	if !tf.TOTPEnabled && !tf.EmailEnabled {
+		return echo.NewHTTPError(http.StatusBadRequest, "account does not use two-factor authentication")
+	}
+	if err := s.sendEmailAuthCode(ctx, u); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusOK)
+}
@@ -0,0 +1,229 @@
+package pds
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+type testMailer struct {
+	lk   sync.Mutex
+	sent []*RenderedEmail
+}
+
+func (tm *testMailer) SendEmail(ctx context.Context, to string, email *RenderedEmail) error {
+	tm.lk.Lock()
+	defer tm.lk.Unlock()
+	tm.sent = append(tm.sent, email)
+	return nil
+}
+
+var emailCodeRegex = regexp.MustCompile(`[A-Z2-9]{5}-[A-Z2-9]{5}`)
+
+func (tm *testMailer) lastCode() string {
+	tm.lk.Lock()
+	defer tm.lk.Unlock()
+	if len(tm.sent) == 0 {
+		return ""
+	}
+	return emailCodeRegex.FindString(tm.sent[len(tm.sent)-1].Body)
+}
+
+func TestTOTPVectors(t *testing.T) {
+	assert := assert.New(t)
+
+	// RFC 6238 appendix B (SHA1), truncated to 6 digits
+	key := []byte("12345678901234567890")
+	assert.Equal("287082", totpCode(key, 59/30))
+	assert.Equal("081804", totpCode(key, 1111111109/30))
+	assert.Equal("050471", totpCode(key, 1111111111/30))
+
+	secret := totpEncoding.EncodeToString(key)
+	now := time.Unix(1111111109, 0)
+	step, ok := checkTOTP(secret, "081804", now, 0)
+	assert.True(ok)
+	// codes can't be reused, and stale ones aren't accepted
+	_, ok = checkTOTP(secret, "081804", now, step)
+	assert.False(ok)
+	_, ok = checkTOTP(secret, "287082", now, 0)
+	assert.False(ok)
+	// one step of clock skew is allowed
+	_, ok = checkTOTP(secret, "081804", now.Add(30*time.Second), 0)
+	assert.True(ok)
+}
+
+func currentTOTP(t *testing.T, secret string) string {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return totpCode(key, time.Now().Unix()/30)
+}
+
+func TestTwoFactorSessions(t *testing.T) {
+	assert := assert.New(t)
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	mailer := &testMailer{}
+	s.SetMailer(mailer)
+	assert.Error(s.SetTwoFactorConfig(&TwoFactorConfig{AppPasswords: "sometimes"}))
+	assert.NoError(s.SetTwoFactorConfig(&TwoFactorConfig{Issuer: "Test PDS"}))
+
+	e := "test@foo.com"
+	p := "password"
+	o, err := s.handleComAtprotoServerCreateAccount(ctx, &atproto.ServerCreateAccount_Input{
+		Email:    &e,
+		Password: &p,
+		Handle:   "testman.test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := s.lookupUserByDid(ctx, o.Did)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ec := echo.New()
+	withUser := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.SetRequest(c.Request().WithContext(context.WithValue(c.Request().Context(), "user", u)))
+			return next(c)
+		}
+	}
+	ec.GET("/account/2fa", s.HandleGetTwoFactor, withUser)
+	ec.POST("/account/2fa/totp/enroll", s.HandleEnrollTOTP, withUser)
+	ec.POST("/account/2fa/totp/confirm", s.HandleConfirmTOTP, withUser)
+	ec.POST("/account/2fa/totp/disable", s.HandleDisableTOTP, withUser)
+	ec.POST("/account/2fa/email", s.HandleSetEmailAuthFactor, withUser)
+	ec.POST("/account/2fa/email-code", s.HandleRequestEmailAuthCode)
+	req := func(method, path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		ec.ServeHTTP(rec, r)
+		return rec
+	}
+	login := func(token string) (*atproto.ServerCreateSession_Output, error) {
+		in := &atproto.ServerCreateSession_Input{Identifier: "testman.test", Password: "password"}
+		if token != "" {
+			in.AuthFactorToken = &token
+		}
+		return s.handleComAtprotoServerCreateSession(ctx, in)
+	}
+	afeName := func(err error) string {
+		var afe *AuthFactorError
+		if errors.As(err, &afe) {
+			return afe.Name
+		}
+		return ""
+	}
+
+	// enrolling takes the password, as it replaces any pending secret
+	assert.Equal(http.StatusUnauthorized, req("POST", "/account/2fa/totp/enroll", "").Code)
+	assert.Equal(http.StatusUnauthorized, req("POST", "/account/2fa/totp/enroll", `{"password":"wrong"}`).Code)
+
+	// no second factor until enrollment is confirmed
+	rec := req("POST", "/account/2fa/totp/enroll", `{"password":"password"}`)
+	assert.Equal(http.StatusOK, rec.Code)
+	var enroll totpEnrollment
+	assert.NoError(json.Unmarshal(rec.Body.Bytes(), &enroll))
+	assert.True(strings.HasPrefix(enroll.URI, "otpauth://totp/Test%20PDS:testman.test?"), enroll.URI)
+	_, err = login("")
+	assert.NoError(err)
+
+	assert.Equal(http.StatusBadRequest, req("POST", "/account/2fa/totp/confirm", `{"code":"000000"}`).Code)
+	assert.Equal(http.StatusOK, req("POST", "/account/2fa/totp/confirm", `{"code":"`+currentTOTP(t, enroll.Secret)+`"}`).Code)
+
+	_, err = login("")
+	assert.Equal("AuthFactorTokenRequired", afeName(err))
+	_, err = login("123456")
+	assert.Equal("InvalidToken", afeName(err))
+
+	// too many wrong codes lock TOTP, so the code space can't be guessed
+	// through
+	for i := 1; i < 5; i++ {
+		_, err = login("123456")
+		assert.Equal("InvalidToken", afeName(err))
+	}
+	assert.NoError(s.db.Model(&AccountTwoFactor{}).Where("usr = ?", u.ID).Update("last_totp_step", 0).Error)
+	_, err = login(currentTOTP(t, enroll.Secret))
+	assert.Equal("InvalidToken", afeName(err))
+	assert.Contains(err.Error(), "Too many invalid codes")
+	assert.NoError(s.db.Model(&AccountTwoFactor{}).Where("usr = ?", u.ID).Update("totp_locked_until", time.Time{}).Error)
+	// the code used to confirm enrollment can't be used again, so rewind the
+	// recorded step to get a fresh one without waiting
+	assert.NoError(s.db.Model(&AccountTwoFactor{}).Where("usr = ?", u.ID).Update("last_totp_step", 0).Error)
+	out, err := login(currentTOTP(t, enroll.Secret))
+	assert.NoError(err)
+	assert.False(*out.EmailAuthFactor)
+	_, err = login(currentTOTP(t, enroll.Secret))
+	assert.Equal("InvalidToken", afeName(err))
+
+	// emailed codes are a fallback for a lost authenticator
+	assert.Equal(http.StatusUnauthorized, req("POST", "/account/2fa/email-code", `{"identifier":"testman.test","password":"wrong"}`).Code)
+	assert.Equal(http.StatusOK, req("POST", "/account/2fa/email-code", `{"identifier":"testman.test","password":"password"}`).Code)
+	code := mailer.lastCode()
+	assert.NotEmpty(code)
+	_, err = login(strings.ToLower(code))
+	assert.NoError(err)
+	_, err = login(code)
+	assert.Equal("InvalidToken", afeName(err))
+
+	assert.Equal(http.StatusBadRequest, req("POST", "/account/2fa/totp/disable", `{"code":"000000"}`).Code)
+	assert.NoError(s.db.Model(&AccountTwoFactor{}).Where("usr = ?", u.ID).Update("last_totp_step", 0).Error)
+	assert.Equal(http.StatusOK, req("POST", "/account/2fa/totp/disable", `{"code":"`+currentTOTP(t, enroll.Secret)+`"}`).Code)
+	_, err = login("")
+	assert.NoError(err)
+
+	// email codes: a missing token sends one
+	assert.Equal(http.StatusUnauthorized, req("POST", "/account/2fa/email", `{"enabled":true,"password":"wrong"}`).Code)
+	assert.Equal(http.StatusOK, req("POST", "/account/2fa/email", `{"enabled":true,"password":"password"}`).Code)
+	assert.NoError(s.db.Where("usr = ?", u.ID).Delete(&EmailAuthCode{}).Error)
+	sent := len(mailer.sent)
+	_, err = login("")
+	assert.Equal("AuthFactorTokenRequired", afeName(err))
+	assert.Len(mailer.sent, sent+1)
+	assert.Equal(EmailSignInCode, mailer.sent[sent].Kind)
+	code = mailer.lastCode()
+
+	// too many wrong guesses burn the code
+	for i := 0; i < 5; i++ {
+		_, err = login("AAAAA-AAAAA")
+		assert.Equal("InvalidToken", afeName(err))
+	}
+	_, err = login(code)
+	assert.Equal("InvalidToken", afeName(err))
+
+	// codes aren't resent within a minute, so clear the last one out
+	assert.NoError(s.db.Unscoped().Where("usr = ?", u.ID).Delete(&EmailAuthCode{}).Error)
+	_, err = login("")
+	assert.Equal("AuthFactorTokenRequired", afeName(err))
+	out, err = login(mailer.lastCode())
+	assert.NoError(err)
+	assert.True(*out.EmailAuthFactor)
+
+	rec = req("GET", "/account/2fa", "")
+	var status twoFactorStatus
+	assert.NoError(json.Unmarshal(rec.Body.Bytes(), &status))
+	assert.Equal(twoFactorStatus{EmailEnabled: true}, status)
+
+	// app passwords skip the second factor by default
+	assert.NoError(s.checkSecondFactor(ctx, u, nil, true))
+	s.twoFactorConfig.AppPasswords = AppPasswordRequire
+	assert.Equal("AuthFactorTokenRequired", afeName(s.checkSecondFactor(ctx, u, nil, true)))
+}
@@ -0,0 +1,182 @@
+package repo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// BlobRef is a reference to a blob found in a record
+type BlobRef struct {
+	Cid      cid.Cid
+	MimeType string
+	// declared size in bytes; -1 for legacy blob references, which don't include a size
+	Size int64
+}
+
+func blobRefFromData(b data.Blob) BlobRef {
+	return BlobRef{
+		Cid:      b.Ref.CID(),
+		MimeType: b.MimeType,
+		Size:     b.Size,
+	}
+}
+
+// ExtractBlobRefs returns all blob references in a CBOR-encoded record, in no
+// particular order. Duplicate references are only returned once.
+func ExtractBlobRefs(recCBOR []byte) ([]BlobRef, error) {
+	obj, err := data.UnmarshalCBOR(recCBOR)
+	if err != nil {
+		return nil, fmt.Errorf("parsing record: %w", err)
+	}
+
+	seen := make(map[cid.Cid]bool)
+	var out []BlobRef
+	for _, b := range data.ExtractBlobs(obj) {
+		ref := blobRefFromData(b)
+		if seen[ref.Cid] {
+			continue
+		}
+		seen[ref.Cid] = true
+		out = append(out, ref)
+	}
+	return out, nil
+}
+
+// ExtractBlobRefsFromRecord is like ExtractBlobRefs, for an already decoded record
+func ExtractBlobRefsFromRecord(rec cbg.CBORMarshaler) ([]BlobRef, error) {
+	buf := new(bytes.Buffer)
+	if err := rec.MarshalCBOR(buf); err != nil {
+		return nil, err
+	}
+	return ExtractBlobRefs(buf.Bytes())
+}
+
+var (
+	ErrBlobTooLarge     = errors.New("blob too large")
+	ErrBlobMimeType     = errors.New("blob mimetype not accepted")
+	ErrBlobSizeMismatch = errors.New("blob size does not match reference")
+	ErrBlobCIDMismatch  = errors.New("blob contents do not match reference CID")
+)
+
+// BlobConstraints describes what blobs are acceptable for a given field, as
+// with the "accept" and "maxSize" properties of a Lexicon blob definition
+type BlobConstraints struct {
+	// maximum size in bytes; zero for no limit
+	MaxSize int64
+	// accepted mimetypes, which may use wildcards like "image/*" or "*/*". empty accepts anything
+	Accept []string
+}
+
+func mimeTypeAccepted(mimeType string, accept []string) bool {
+	if len(accept) == 0 {
+		return true
+	}
+	for _, a := range accept {
+		if a == "*/*" || a == mimeType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(a, "/*"); ok && strings.HasPrefix(mimeType, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyBlob checks that uploaded blob contents match a reference (CID and
+// declared size), and that the blob satisfies the given constraints. cons may
+// be nil.
+func VerifyBlob(ref BlobRef, blob []byte, cons *BlobConstraints) error {
+	if ref.Size >= 0 && int64(len(blob)) != ref.Size {
+		return fmt.Errorf("%w: declared %d, got %d", ErrBlobSizeMismatch, ref.Size, len(blob))
+	}
+
+	// compare only the hash, since legacy references may use a different CID codec
+	mh, err := multihash.Sum(blob, ref.Cid.Prefix().MhType, -1)
+	if err != nil {
+		return fmt.Errorf("hashing blob: %w", err)
+	}
+	if !bytes.Equal(mh, ref.Cid.Hash()) {
+		return ErrBlobCIDMismatch
+	}
+
+	if cons != nil {
+		if cons.MaxSize > 0 && int64(len(blob)) > cons.MaxSize {
+			return fmt.Errorf("%w: %d > %d", ErrBlobTooLarge, len(blob), cons.MaxSize)
+		}
+		if !mimeTypeAccepted(ref.MimeType, cons.Accept) {
+			return fmt.Errorf("%w: %s", ErrBlobMimeType, ref.MimeType)
+		}
+	}
+	return nil
+}
+
+// BlobManifestEntry is a single blob in a BlobManifest
+type BlobManifestEntry struct {
+	Ref BlobRef
+	// paths (collection/rkey) of the records referencing this blob, sorted
+	Records []string
+}
+
+// BlobManifest lists every blob referenced from a repo's current records
+type BlobManifest struct {
+	Blobs map[cid.Cid]*BlobManifestEntry
+	// sum of declared sizes. legacy references without a size are not counted
+	TotalSize int64
+}
+
+// CIDs returns the blob CIDs in the manifest, sorted
+func (bm *BlobManifest) CIDs() []cid.Cid {
+	out := make([]cid.Cid, 0, len(bm.Blobs))
+	for c := range bm.Blobs {
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].KeyString() < out[j].KeyString() })
+	return out
+}
+
+// BlobManifest walks all records in the repo and collects the blobs they
+// reference. Returns an error if any record is not valid atproto data.
+func (r *Repo) BlobManifest(ctx context.Context) (*BlobManifest, error) {
+	bm := &BlobManifest{
+		Blobs: make(map[cid.Cid]*BlobManifestEntry),
+	}
+
+	if err := r.ForEach(ctx, "", func(k string, v cid.Cid) error {
+		blk, err := r.bs.Get(ctx, v)
+		if err != nil {
+			return fmt.Errorf("reading record %s: %w", k, err)
+		}
+		refs, err := ExtractBlobRefs(blk.RawData())
+		if err != nil {
+			return fmt.Errorf("record %s: %w", k, err)
+		}
+		for _, ref := range refs {
+			ent, ok := bm.Blobs[ref.Cid]
+			if !ok {
+				ent = &BlobManifestEntry{Ref: ref}
+				bm.Blobs[ref.Cid] = ent
+				if ref.Size > 0 {
+					bm.TotalSize += ref.Size
+				}
+			}
+			ent.Records = append(ent.Records, k)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	for _, ent := range bm.Blobs {
+		sort.Strings(ent.Records)
+	}
+	return bm, nil
+}
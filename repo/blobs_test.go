@@ -0,0 +1,142 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+)
+
+func testBlob(t *testing.T, body string, mimeType string) (*lexutil.LexBlob, []byte) {
+	t.Helper()
+	b := []byte(body)
+	c, err := cid.NewPrefixV1(cid.Raw, 0x12).Sum(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &lexutil.LexBlob{
+		Ref:      lexutil.LexLink(c),
+		MimeType: mimeType,
+		Size:     int64(len(b)),
+	}, b
+}
+
+func TestBlobManifest(t *testing.T) {
+	ctx := context.TODO()
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	r := NewRepo(ctx, "did:plc:abc123", bs)
+
+	img1, _ := testBlob(t, "image one", "image/png")
+	img2, _ := testBlob(t, "image two!", "image/jpeg")
+	avatar, _ := testBlob(t, "avatar", "image/jpeg")
+
+	if _, _, err := r.CreateRecord(ctx, "app.bsky.feed.post", &appbsky.FeedPost{
+		Text:      "two images",
+		CreatedAt: "2024-01-01T00:00:00.000Z",
+		Embed: &appbsky.FeedPost_Embed{
+			EmbedImages: &appbsky.EmbedImages{
+				Images: []*appbsky.EmbedImages_Image{
+					{Alt: "one", Image: img1},
+					{Alt: "two", Image: img2},
+				},
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := r.CreateRecord(ctx, "app.bsky.feed.post", &appbsky.FeedPost{
+		Text:      "same image again",
+		CreatedAt: "2024-01-01T00:00:00.000Z",
+		Embed: &appbsky.FeedPost_Embed{
+			EmbedImages: &appbsky.EmbedImages{
+				Images: []*appbsky.EmbedImages_Image{
+					{Alt: "one", Image: img1},
+				},
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.PutRecord(ctx, "app.bsky.actor.profile/self", &appbsky.ActorProfile{
+		Avatar: avatar,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := r.CreateRecord(ctx, "app.bsky.feed.post", &appbsky.FeedPost{
+		Text:      "no blobs",
+		CreatedAt: "2024-01-01T00:00:00.000Z",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := r.Commit(ctx, func(context.Context, string, []byte) ([]byte, error) { return []byte("sig"), nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	bm, err := r.BlobManifest(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bm.Blobs) != 3 {
+		t.Fatalf("expected 3 blobs, got %d", len(bm.Blobs))
+	}
+	if len(bm.CIDs()) != 3 {
+		t.Fatal("CIDs() returned wrong number of entries")
+	}
+	if bm.TotalSize != img1.Size+img2.Size+avatar.Size {
+		t.Fatalf("wrong total size: %d", bm.TotalSize)
+	}
+	ent := bm.Blobs[cid.Cid(img1.Ref)]
+	if ent == nil || len(ent.Records) != 2 {
+		t.Fatalf("expected shared image to be referenced by two records: %+v", ent)
+	}
+	if ent.Ref.MimeType != "image/png" {
+		t.Fatalf("wrong mimetype: %s", ent.Ref.MimeType)
+	}
+	ent = bm.Blobs[cid.Cid(avatar.Ref)]
+	if ent == nil || len(ent.Records) != 1 || ent.Records[0] != "app.bsky.actor.profile/self" {
+		t.Fatalf("unexpected avatar entry: %+v", ent)
+	}
+}
+
+func TestVerifyBlob(t *testing.T) {
+	lb, body := testBlob(t, "some image bytes", "image/png")
+	ref := BlobRef{Cid: cid.Cid(lb.Ref), MimeType: lb.MimeType, Size: lb.Size}
+	cons := &BlobConstraints{MaxSize: 1000, Accept: []string{"image/*"}}
+
+	if err := VerifyBlob(ref, body, cons); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyBlob(ref, body, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ref  BlobRef
+		body []byte
+		cons *BlobConstraints
+		want error
+	}{
+		{ref, []byte("some image bytez"), cons, ErrBlobCIDMismatch},
+		{ref, []byte("short"), cons, ErrBlobSizeMismatch},
+		{ref, body, &BlobConstraints{MaxSize: 4}, ErrBlobTooLarge},
+		{ref, body, &BlobConstraints{Accept: []string{"video/mp4"}}, ErrBlobMimeType},
+	}
+	for _, tc := range tests {
+		if err := VerifyBlob(tc.ref, tc.body, tc.cons); !errors.Is(err, tc.want) {
+			t.Errorf("expected %v, got %v", tc.want, err)
+		}
+	}
+
+	// legacy references have no declared size
+	legacy := ref
+	legacy.Size = -1
+	if err := VerifyBlob(legacy, body, cons); err != nil {
+		t.Fatal(err)
+	}
+}
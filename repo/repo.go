@@ -3,6 +3,7 @@ package repo
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 
@@ -346,7 +347,7 @@ func (r *Repo) ForEach(ctx context.Context, prefix string, cb func(k string, v c
 	t := mst.LoadMST(r.cst, r.sc.Data)
 
 	if err := t.WalkLeavesFrom(ctx, prefix, cb); err != nil {
-		if err != ErrDoneIterating {
+		if !errors.Is(err, ErrDoneIterating) {
 			return err
 		}
 	}
@@ -375,6 +376,16 @@ func (r *Repo) GetRecord(ctx context.Context, rpath string) (cid.Cid, cbg.CBORMa
 	return cc, rec, nil
 }
 
+// GetRecordCid returns the CID of the record at rpath, without reading the record itself
+func (r *Repo) GetRecordCid(ctx context.Context, rpath string) (cid.Cid, error) {
+	mst, err := r.getMst(ctx)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("getting repo mst: %w", err)
+	}
+
+	return mst.Get(ctx, rpath)
+}
+
 func (r *Repo) GetRecordBytes(ctx context.Context, rpath string) (cid.Cid, *[]byte, error) {
 	ctx, span := otel.Tracer("repo").Start(ctx, "GetRecordBytes")
 	defer span.End()
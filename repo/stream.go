@@ -0,0 +1,161 @@
+package repo
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/bluesky-social/indigo/mst"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	car "github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+)
+
+// DefaultStreamBufferBlocks is the read-ahead used by StreamCheckoutCAR when
+// none is given
+const DefaultStreamBufferBlocks = 128
+
+// walkCheckout calls cb for every block of the repo checkout at root: the
+// signed commit, then every MST node and record, walking the tree
+// incrementally
+func walkCheckout(ctx context.Context, bs blockstore.Blockstore, root cid.Cid, cb func(blocks.Block) error) error {
+	get := func(ctx context.Context, c cid.Cid) ([]byte, error) {
+		blk, err := bs.Get(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		return blk.RawData(), nil
+	}
+
+	commit, err := bs.Get(ctx, root)
+	if err != nil {
+		return fmt.Errorf("loading commit block: %w", err)
+	}
+	var sc SignedCommit
+	if err := sc.UnmarshalCBOR(bytes.NewReader(commit.RawData())); err != nil {
+		return fmt.Errorf("decoding commit: %w", err)
+	}
+	if err := cb(commit); err != nil {
+		return err
+	}
+
+	return mst.WalkBlocks(ctx, get, sc.Data, func(c cid.Cid, data []byte) error {
+		blk, err := blocks.NewBlockWithCid(data, c)
+		if err != nil {
+			return err
+		}
+		return cb(blk)
+	})
+}
+
+func writeCarHeader(w io.Writer, root cid.Cid) error {
+	hb, err := cbor.DumpObject(&car.CarHeader{
+		Roots:   []cid.Cid{root},
+		Version: 1,
+	})
+	if err != nil {
+		return err
+	}
+	return carutil.LdWrite(w, hb)
+}
+
+// WriteCheckoutCAR writes a CAR file with the current state of the repo whose
+// signed commit is root: the commit, every MST node, and every record, with
+// root as the CAR root. Unlike ReadUserCar in carstore, it doesn't include
+// blocks from earlier revisions.
+//
+// The tree is walked incrementally, so memory use doesn't grow with the size
+// of the repo.
+func WriteCheckoutCAR(ctx context.Context, bs blockstore.Blockstore, root cid.Cid, w io.Writer) error {
+	if err := writeCarHeader(w, root); err != nil {
+		return err
+	}
+	return walkCheckout(ctx, bs, root, func(blk blocks.Block) error {
+		return carutil.LdWrite(w, blk.Cid().Bytes(), blk.RawData())
+	})
+}
+
+type carStream struct {
+	*io.PipeReader
+	cancel func()
+}
+
+// Close stops the stream early, releasing its goroutines
+func (cs *carStream) Close() error {
+	cs.cancel()
+	return cs.PipeReader.Close()
+}
+
+// StreamCheckoutCAR is like WriteCheckoutCAR, but returns a reader which the
+// CAR can be read from as it is produced, eg to stream out as an HTTP
+// response.
+//
+// Blocks are read from bs by a background goroutine, up to buffer blocks
+// ahead of the consumer (DefaultStreamBufferBlocks if buffer is not
+// positive); when the consumer stops reading, the walk pauses. If ctx is
+// cancelled or the walk fails, the reader returns the error. The reader must
+// be read to the end or closed, to release the goroutines.
+func StreamCheckoutCAR(ctx context.Context, bs blockstore.Blockstore, root cid.Cid, buffer int) io.ReadCloser {
+	if buffer <= 0 {
+		buffer = DefaultStreamBufferBlocks
+	}
+
+	walkCtx, cancel := context.WithCancel(ctx)
+	pr, pw := io.Pipe()
+	blks := make(chan blocks.Block, buffer)
+	done := make(chan struct{})
+
+	var walkErr error
+	go func() {
+		defer close(blks)
+		walkErr = walkCheckout(walkCtx, bs, root, func(blk blocks.Block) error {
+			select {
+			case blks <- blk:
+				return nil
+			case <-walkCtx.Done():
+				return walkCtx.Err()
+			}
+		})
+	}()
+
+	go func() {
+		defer close(done)
+		defer cancel()
+
+		err := writeCarHeader(pw, root)
+		if err != nil {
+			cancel()
+		}
+		for blk := range blks {
+			if err != nil {
+				// drain, so the walker sees the cancellation
+				continue
+			}
+			if err = carutil.LdWrite(pw, blk.Cid().Bytes(), blk.RawData()); err != nil {
+				cancel()
+			}
+		}
+		if err == nil {
+			// the walker has finished, since blks is closed
+			err = walkErr
+		}
+		pw.CloseWithError(err)
+	}()
+
+	// a consumer which goes away without closing the reader (eg, an HTTP
+	// client disconnecting) would otherwise leave the writer blocked forever.
+	// Closing the write side unblocks it, and the reader sees ctx's error.
+	go func() {
+		select {
+		case <-ctx.Done():
+			pw.CloseWithError(ctx.Err())
+		case <-done:
+		}
+	}()
+
+	return &carStream{PipeReader: pr, cancel: cancel}
+}
@@ -0,0 +1,144 @@
+package repo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/ipld/go-car/v2"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testSigner(ctx context.Context, did string, b []byte) ([]byte, error) {
+	return []byte("signature"), nil
+}
+
+// builds a repo with n records, over a few revisions, and returns the root
+// of the latest commit
+func buildTestRepo(t *testing.T, bs blockstore.Blockstore, n int) cid.Cid {
+	ctx := context.Background()
+	r := NewRepo(ctx, "did:plc:streamtest", bs)
+	// any cbor-gen type will do as a record
+	data := cid.MustParse("bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm")
+	var root cid.Cid
+	for i := 0; i < n; i++ {
+		if _, _, err := r.CreateRecord(ctx, "app.bsky.feed.post", &UnsignedCommit{Did: fmt.Sprintf("record-%d", i), Data: data}); err != nil {
+			t.Fatal(err)
+		}
+		if i%50 == 49 || i == n-1 {
+			c, _, err := r.Commit(ctx, testSigner)
+			if err != nil {
+				t.Fatal(err)
+			}
+			root = c
+			if r, err = OpenRepo(ctx, bs, root); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return root
+}
+
+func countRecords(t *testing.T, r *Repo) int {
+	n := 0
+	if err := r.ForEach(context.Background(), "", func(k string, v cid.Cid) error {
+		n++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestWriteCheckoutCAR(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	root := buildTestRepo(t, bs, 300)
+
+	buf := new(bytes.Buffer)
+	assert.NoError(WriteCheckoutCAR(ctx, bs, root, buf))
+
+	br, err := car.NewBlockReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal([]cid.Cid{root}, br.Roots)
+	first, err := br.Next()
+	assert.NoError(err)
+	assert.Equal(root, first.Cid())
+
+	r, err := ReadRepoFromCar(ctx, bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(300, countRecords(t, r))
+
+	// the checkout doesn't carry the MST nodes of earlier revisions
+	all, err := bs.AllKeysChan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := 0
+	for range all {
+		stored++
+	}
+	blocks := 0
+	br, _ = car.NewBlockReader(bytes.NewReader(buf.Bytes()))
+	for {
+		if _, err := br.Next(); err != nil {
+			assert.ErrorIs(err, io.EOF)
+			break
+		}
+		blocks++
+	}
+	assert.Less(blocks, stored)
+}
+
+func TestStreamCheckoutCAR(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	root := buildTestRepo(t, bs, 200)
+
+	expected := new(bytes.Buffer)
+	assert.NoError(WriteCheckoutCAR(ctx, bs, root, expected))
+
+	// a tiny buffer forces the walk to wait on the reader
+	rc := StreamCheckoutCAR(ctx, bs, root, 1)
+	b, err := io.ReadAll(rc)
+	assert.NoError(err)
+	assert.NoError(rc.Close())
+	assert.Equal(expected.Bytes(), b)
+
+	// closing early stops the stream
+	rc = StreamCheckoutCAR(ctx, bs, root, 1)
+	_, err = rc.Read(make([]byte, 16))
+	assert.NoError(err)
+	assert.NoError(rc.Close())
+	_, err = io.ReadAll(rc)
+	assert.Error(err)
+
+	// as does cancelling the context
+	cctx, cancel := context.WithCancel(ctx)
+	rc = StreamCheckoutCAR(cctx, bs, root, 1)
+	_, err = rc.Read(make([]byte, 16))
+	assert.NoError(err)
+	cancel()
+	_, err = io.ReadAll(rc)
+	assert.True(errors.Is(err, context.Canceled))
+
+	// missing blocks surface as a read error
+	rc = StreamCheckoutCAR(ctx, bs, cid.MustParse("bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm"), 0)
+	_, err = io.ReadAll(rc)
+	assert.Error(err)
+}
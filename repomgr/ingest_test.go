@@ -235,3 +235,53 @@ func TestDuplicateRecord(t *testing.T) {
 	_ = c
 	_ = rec
 }
+
+type countingLocker struct {
+	locks, unlocks int
+	fail           bool
+}
+
+func (cl *countingLocker) LockRepo(ctx context.Context, user models.Uid) (func(), error) {
+	if cl.fail {
+		return nil, fmt.Errorf("lock unavailable")
+	}
+	cl.locks++
+	return func() { cl.unlocks++ }, nil
+}
+
+func TestRepoLocker(t *testing.T) {
+	dir, err := os.MkdirTemp("", "integtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cs := testCarstore(t, dir)
+	repoman := NewRepoManager(cs, &util.FakeKeyManager{})
+	cl := &countingLocker{}
+	repoman.SetRepoLocker(cl)
+
+	ctx := context.TODO()
+	if err := repoman.InitNewActor(ctx, 1, "hello.world", "did:plc:foobar", "", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := repoman.CreateRecord(ctx, 1, "app.bsky.feed.post", &bsky.FeedPost{Text: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	// reads don't take the external lock
+	if _, err := repoman.GetRepoRoot(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if cl.locks != 2 || cl.unlocks != 2 {
+		t.Fatalf("expected writes to lock and unlock: %+v", cl)
+	}
+
+	cl.fail = true
+	if _, _, err := repoman.CreateRecord(ctx, 1, "app.bsky.feed.post", &bsky.FeedPost{Text: "hello"}); err == nil {
+		t.Fatal("expected write to fail without the lock")
+	}
+	// the in-process lock is released after a failure
+	if _, err := repoman.GetRepoRoot(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+}
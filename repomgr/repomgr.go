@@ -42,6 +42,10 @@ func NewRepoManager(cs carstore.CarStore, kmgr KeyManager) *RepoManager {
 	}
 }
 
+// ErrInvalidSignature is returned when a commit's signature does not verify
+// against the repo's declared signing key
+var ErrInvalidSignature = errors.New("signature check failed")
+
 type KeyManager interface {
 	VerifyUserSignature(context.Context, string, []byte, []byte) error
 	SignForUser(context.Context, string, []byte) ([]byte, error)
@@ -52,15 +56,35 @@ func (rm *RepoManager) SetEventHandler(cb func(context.Context, *RepoEvent), hyd
 	rm.hydrateRecords = hydrateRecords
 }
 
+// WriteCheck is called before a local write is committed, with the repo
+// locked, so that checks against the current state of the repo (eg, quotas)
+// can't race with other writes. Ops always have Record set for creates and
+// updates. Returning an error aborts the write.
+type WriteCheck func(ctx context.Context, user models.Uid, ops []RepoOp) error
+
+func (rm *RepoManager) SetWriteCheck(cb WriteCheck) {
+	rm.writeCheck = cb
+}
+
+func (rm *RepoManager) checkWrite(ctx context.Context, user models.Uid, ops []RepoOp) error {
+	if rm.writeCheck == nil {
+		return nil
+	}
+	return rm.writeCheck(ctx, user, ops)
+}
+
 type RepoManager struct {
 	cs   carstore.CarStore
 	kmgr KeyManager
 
 	lklk      sync.Mutex
 	userLocks map[models.Uid]*userLock
+	locker    RepoLocker
 
 	events         func(context.Context, *RepoEvent)
 	hydrateRecords bool
+	writeCheck     WriteCheck
+	journal        CommitJournal
 
 	log *slog.Logger
 }
@@ -81,6 +105,10 @@ type RepoEvent struct {
 	RepoSlice []byte
 	PDS       uint
 	Ops       []RepoOp
+	// MST root (commit 'data' field) of the previous commit, when known
+	PrevData *cid.Cid
+	// indicates that the repo was replaced wholesale (eg, a fresh import), and Ops is not a diff against the previous state. RepoSlice contains only the commit block.
+	Sync bool
 }
 
 type RepoOp struct {
@@ -90,6 +118,8 @@ type RepoOp struct {
 	RecCid     *cid.Cid
 	Record     any
 	ActorInfo  *ActorInfo
+	// previous record CID, for updates and deletes
+	PrevCid *cid.Cid
 }
 
 type EventKind string
@@ -142,15 +172,124 @@ func (rm *RepoManager) lockUser(ctx context.Context, user models.Uid) func() {
 	}
 }
 
+// RepoLocker serializes writes to a repo across processes which share the same
+// storage, eg several PDS instances behind a load balancer. It is taken after
+// the in-process lock, and only for writes.
+type RepoLocker interface {
+	LockRepo(ctx context.Context, user models.Uid) (unlock func(), err error)
+}
+
+// SetRepoLocker sets a lock to take (in addition to the in-process one) around
+// every write to a repo
+func (rm *RepoManager) SetRepoLocker(l RepoLocker) {
+	rm.locker = l
+}
+
+// lockRepo takes the in-process lock for a repo, and the external lock if one
+// is configured
+func (rm *RepoManager) lockRepo(ctx context.Context, user models.Uid) (func(), error) {
+	unlock := rm.lockUser(ctx, user)
+	if rm.locker == nil {
+		return unlock, nil
+	}
+
+	ctx, span := otel.Tracer("repoman").Start(ctx, "lockRepo")
+	defer span.End()
+
+	unlockExt, err := rm.locker.LockRepo(ctx, user)
+	if err != nil {
+		unlock()
+		return nil, fmt.Errorf("locking repo: %w", err)
+	}
+	return func() {
+		unlockExt()
+		unlock()
+	}, nil
+}
+
+// CommitJournal is told about each commit just before it is written to the
+// carstore, and about writes which fail. The event handler is called after
+// each successful write, so a journal can tell commits whose events were lost
+// (eg, in a crash) from commits which were never written.
+type CommitJournal interface {
+	// PrepareCommit is called with the repo locked. If it fails, the commit
+	// isn't written
+	PrepareCommit(ctx context.Context, user models.Uid, rev string) error
+	// AbortCommit is called if writing a prepared commit failed
+	AbortCommit(ctx context.Context, user models.Uid, rev string)
+}
+
+// SetCommitJournal sets a journal to record every commit in
+func (rm *RepoManager) SetCommitJournal(j CommitJournal) {
+	rm.journal = j
+}
+
+// closeCommit writes a commit to the carstore, recording it in the journal
+// (if any), and returns the CAR slice of new blocks
+func (rm *RepoManager) closeCommit(ctx context.Context, ds *carstore.DeltaSession, user models.Uid, root cid.Cid, rev string) ([]byte, error) {
+	if rm.journal == nil {
+		return ds.CloseWithRoot(ctx, root, rev)
+	}
+	if err := rm.journal.PrepareCommit(ctx, user, rev); err != nil {
+		return nil, fmt.Errorf("journaling commit: %w", err)
+	}
+	rslice, err := ds.CloseWithRoot(ctx, root, rev)
+	if err != nil {
+		rm.journal.AbortCommit(context.WithoutCancel(ctx), user, rev)
+		return nil, err
+	}
+	return rslice, nil
+}
+
+// HeadSyncEvent returns a #sync event for the repo's current commit, telling
+// consumers to resync from it. This is for when the event for a commit may
+// have been lost, so consumers can't apply later commits as diffs.
+func (rm *RepoManager) HeadSyncEvent(ctx context.Context, user models.Uid) (*RepoEvent, error) {
+	unlock := rm.lockUser(ctx, user)
+	defer unlock()
+
+	ds, err := rm.cs.ReadOnlySession(user)
+	if err != nil {
+		return nil, err
+	}
+	root, err := rm.cs.GetUserRepoHead(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	rev, err := rm.cs.GetUserRepoRev(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	commitCar, err := commitOnlyCar(ctx, ds, root)
+	if err != nil {
+		return nil, fmt.Errorf("building sync event: %w", err)
+	}
+	return &RepoEvent{
+		User:      user,
+		NewRoot:   root,
+		Rev:       rev,
+		RepoSlice: commitCar,
+		Sync:      true,
+	}, nil
+}
+
 func (rm *RepoManager) CarStore() carstore.CarStore {
 	return rm.cs
 }
 
 func (rm *RepoManager) CreateRecord(ctx context.Context, user models.Uid, collection string, rec cbg.CBORMarshaler) (string, cid.Cid, error) {
+	return rm.CreateRecordSwap(ctx, user, collection, rec, nil)
+}
+
+// CreateRecordSwap is CreateRecord, failing with ErrInvalidSwap unless the repo is at the given commit (if not nil)
+func (rm *RepoManager) CreateRecordSwap(ctx context.Context, user models.Uid, collection string, rec cbg.CBORMarshaler, swapCommit *cid.Cid) (string, cid.Cid, error) {
 	ctx, span := otel.Tracer("repoman").Start(ctx, "CreateRecord")
 	defer span.End()
 
-	unlock := rm.lockUser(ctx, user)
+	unlock, err := rm.lockRepo(ctx, user)
+	if err != nil {
+		return "", cid.Undef, err
+	}
 	defer unlock()
 
 	rev, err := rm.cs.GetUserRepoRev(ctx, user)
@@ -164,23 +303,38 @@ func (rm *RepoManager) CreateRecord(ctx context.Context, user models.Uid, collec
 	}
 
 	head := ds.BaseCid()
+	if err := checkSwapCommit(head, swapCommit); err != nil {
+		return "", cid.Undef, err
+	}
 
 	r, err := repo.OpenRepo(ctx, ds, head)
 	if err != nil {
 		return "", cid.Undef, err
 	}
+	prevData := prevDataCid(r, head)
 
 	cc, tid, err := r.CreateRecord(ctx, collection, rec)
 	if err != nil {
 		return "", cid.Undef, err
 	}
 
+	op := RepoOp{
+		Kind:       EvtKindCreateRecord,
+		Collection: collection,
+		Rkey:       tid,
+		Record:     rec,
+		RecCid:     &cc,
+	}
+	if err := rm.checkWrite(ctx, user, []RepoOp{op}); err != nil {
+		return "", cid.Undef, err
+	}
+
 	nroot, nrev, err := r.Commit(ctx, rm.kmgr.SignForUser)
 	if err != nil {
 		return "", cid.Undef, err
 	}
 
-	rslice, err := ds.CloseWithRoot(ctx, nroot, nrev)
+	rslice, err := rm.closeCommit(ctx, ds, user, nroot, nrev)
 	if err != nil {
 		return "", cid.Undef, fmt.Errorf("close with root: %w", err)
 	}
@@ -192,18 +346,13 @@ func (rm *RepoManager) CreateRecord(ctx context.Context, user models.Uid, collec
 
 	if rm.events != nil {
 		rm.events(ctx, &RepoEvent{
-			User:    user,
-			OldRoot: oldroot,
-			NewRoot: nroot,
-			Rev:     nrev,
-			Since:   &rev,
-			Ops: []RepoOp{{
-				Kind:       EvtKindCreateRecord,
-				Collection: collection,
-				Rkey:       tid,
-				Record:     rec,
-				RecCid:     &cc,
-			}},
+			User:      user,
+			OldRoot:   oldroot,
+			PrevData:  prevData,
+			NewRoot:   nroot,
+			Rev:       nrev,
+			Since:     &rev,
+			Ops:       []RepoOp{op},
 			RepoSlice: rslice,
 		})
 	}
@@ -212,10 +361,18 @@ func (rm *RepoManager) CreateRecord(ctx context.Context, user models.Uid, collec
 }
 
 func (rm *RepoManager) UpdateRecord(ctx context.Context, user models.Uid, collection, rkey string, rec cbg.CBORMarshaler) (cid.Cid, error) {
+	return rm.UpdateRecordSwap(ctx, user, collection, rkey, rec, nil)
+}
+
+// UpdateRecordSwap is UpdateRecord (which creates the record if it doesn't exist), failing with ErrInvalidSwap unless the swap conditions hold
+func (rm *RepoManager) UpdateRecordSwap(ctx context.Context, user models.Uid, collection, rkey string, rec cbg.CBORMarshaler, swap *Swap) (cid.Cid, error) {
 	ctx, span := otel.Tracer("repoman").Start(ctx, "UpdateRecord")
 	defer span.End()
 
-	unlock := rm.lockUser(ctx, user)
+	unlock, err := rm.lockRepo(ctx, user)
+	if err != nil {
+		return cid.Undef, err
+	}
 	defer unlock()
 
 	rev, err := rm.cs.GetUserRepoRev(ctx, user)
@@ -234,18 +391,46 @@ func (rm *RepoManager) UpdateRecord(ctx context.Context, user models.Uid, collec
 		return cid.Undef, err
 	}
 
+	prevData := prevDataCid(r, head)
+
 	rpath := collection + "/" + rkey
-	cc, err := r.PutRecord(ctx, rpath, rec)
+	prevCid := prevRecordCid(ctx, r, rpath)
+	if err := swap.check(head, prevCid); err != nil {
+		return cid.Undef, err
+	}
+	var cc cid.Cid
+	if prevCid != nil {
+		cc, err = r.UpdateRecord(ctx, rpath, rec)
+	} else {
+		cc, err = r.PutRecord(ctx, rpath, rec)
+	}
 	if err != nil {
 		return cid.Undef, err
 	}
 
+	// putting a record which doesn't exist yet creates it
+	kind := EvtKindUpdateRecord
+	if prevCid == nil {
+		kind = EvtKindCreateRecord
+	}
+	op := RepoOp{
+		Kind:       kind,
+		Collection: collection,
+		Rkey:       rkey,
+		Record:     rec,
+		RecCid:     &cc,
+		PrevCid:    prevCid,
+	}
+	if err := rm.checkWrite(ctx, user, []RepoOp{op}); err != nil {
+		return cid.Undef, err
+	}
+
 	nroot, nrev, err := r.Commit(ctx, rm.kmgr.SignForUser)
 	if err != nil {
 		return cid.Undef, err
 	}
 
-	rslice, err := ds.CloseWithRoot(ctx, nroot, nrev)
+	rslice, err := rm.closeCommit(ctx, ds, user, nroot, nrev)
 	if err != nil {
 		return cid.Undef, fmt.Errorf("close with root: %w", err)
 	}
@@ -256,20 +441,14 @@ func (rm *RepoManager) UpdateRecord(ctx context.Context, user models.Uid, collec
 	}
 
 	if rm.events != nil {
-		op := RepoOp{
-			Kind:       EvtKindUpdateRecord,
-			Collection: collection,
-			Rkey:       rkey,
-			RecCid:     &cc,
-		}
-
-		if rm.hydrateRecords {
-			op.Record = rec
+		if !rm.hydrateRecords {
+			op.Record = nil
 		}
 
 		rm.events(ctx, &RepoEvent{
 			User:      user,
 			OldRoot:   oldroot,
+			PrevData:  prevData,
 			NewRoot:   nroot,
 			Rev:       nrev,
 			Since:     &rev,
@@ -282,10 +461,18 @@ func (rm *RepoManager) UpdateRecord(ctx context.Context, user models.Uid, collec
 }
 
 func (rm *RepoManager) DeleteRecord(ctx context.Context, user models.Uid, collection, rkey string) error {
+	return rm.DeleteRecordSwap(ctx, user, collection, rkey, nil)
+}
+
+// DeleteRecordSwap is DeleteRecord, failing with ErrInvalidSwap unless the swap conditions hold
+func (rm *RepoManager) DeleteRecordSwap(ctx context.Context, user models.Uid, collection, rkey string, swap *Swap) error {
 	ctx, span := otel.Tracer("repoman").Start(ctx, "DeleteRecord")
 	defer span.End()
 
-	unlock := rm.lockUser(ctx, user)
+	unlock, err := rm.lockRepo(ctx, user)
+	if err != nil {
+		return err
+	}
 	defer unlock()
 
 	rev, err := rm.cs.GetUserRepoRev(ctx, user)
@@ -304,7 +491,13 @@ func (rm *RepoManager) DeleteRecord(ctx context.Context, user models.Uid, collec
 		return err
 	}
 
+	prevData := prevDataCid(r, head)
+
 	rpath := collection + "/" + rkey
+	prevCid := prevRecordCid(ctx, r, rpath)
+	if err := swap.check(head, prevCid); err != nil {
+		return err
+	}
 	if err := r.DeleteRecord(ctx, rpath); err != nil {
 		return err
 	}
@@ -314,7 +507,7 @@ func (rm *RepoManager) DeleteRecord(ctx context.Context, user models.Uid, collec
 		return err
 	}
 
-	rslice, err := ds.CloseWithRoot(ctx, nroot, nrev)
+	rslice, err := rm.closeCommit(ctx, ds, user, nroot, nrev)
 	if err != nil {
 		return fmt.Errorf("close with root: %w", err)
 	}
@@ -326,15 +519,17 @@ func (rm *RepoManager) DeleteRecord(ctx context.Context, user models.Uid, collec
 
 	if rm.events != nil {
 		rm.events(ctx, &RepoEvent{
-			User:    user,
-			OldRoot: oldroot,
-			NewRoot: nroot,
-			Rev:     nrev,
-			Since:   &rev,
+			User:     user,
+			OldRoot:  oldroot,
+			PrevData: prevData,
+			NewRoot:  nroot,
+			Rev:      nrev,
+			Since:    &rev,
 			Ops: []RepoOp{{
 				Kind:       EvtKindDeleteRecord,
 				Collection: collection,
 				Rkey:       rkey,
+				PrevCid:    prevCid,
 			}},
 			RepoSlice: rslice,
 		})
@@ -345,7 +540,10 @@ func (rm *RepoManager) DeleteRecord(ctx context.Context, user models.Uid, collec
 }
 
 func (rm *RepoManager) InitNewActor(ctx context.Context, user models.Uid, handle, did, displayname string, declcid, actortype string) error {
-	unlock := rm.lockUser(ctx, user)
+	unlock, err := rm.lockRepo(ctx, user)
+	if err != nil {
+		return err
+	}
 	defer unlock()
 
 	if did == "" {
@@ -377,7 +575,7 @@ func (rm *RepoManager) InitNewActor(ctx context.Context, user models.Uid, handle
 		return fmt.Errorf("committing repo for actor init: %w", err)
 	}
 
-	rslice, err := ds.CloseWithRoot(ctx, root, nrev)
+	rslice, err := rm.closeCommit(ctx, ds, user, root, nrev)
 	if err != nil {
 		return fmt.Errorf("close with root: %w", err)
 	}
@@ -423,6 +621,45 @@ func (rm *RepoManager) ReadRepo(ctx context.Context, user models.Uid, since stri
 	return rm.cs.ReadUserCar(ctx, user, since, true, w)
 }
 
+// StreamRepo returns a reader which a CAR export of the user's repo is
+// streamed through as it is read from the carstore, for sync endpoints. With
+// no since, it is a checkout of the current repo state, produced by walking
+// the MST; otherwise it is the diff from ReadRepo. Either way the repo is
+// never held in memory, and reading pauses while the consumer isn't keeping
+// up.
+//
+// The reader must be read to the end or closed, or ctx cancelled, to release
+// the goroutines producing it.
+func (rm *RepoManager) StreamRepo(ctx context.Context, user models.Uid, since string) (io.ReadCloser, error) {
+	if since == "" {
+		bs, err := rm.cs.ReadOnlySession(user)
+		if err != nil {
+			return nil, err
+		}
+		head, err := rm.cs.GetUserRepoHead(ctx, user)
+		if err != nil {
+			return nil, err
+		}
+		return repo.StreamCheckoutCAR(ctx, bs, head, 0), nil
+	}
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pw.CloseWithError(rm.ReadRepo(ctx, user, since, pw))
+	}()
+	go func() {
+		// unblocks the writer if the consumer goes away without closing
+		select {
+		case <-ctx.Done():
+			pw.CloseWithError(ctx.Err())
+		case <-done:
+		}
+	}()
+	return pr, nil
+}
+
 func (rm *RepoManager) GetRecord(ctx context.Context, user models.Uid, collection string, rkey string, maybeCid cid.Cid) (cid.Cid, cbg.CBORMarshaler, error) {
 	bs, err := rm.cs.ReadOnlySession(user)
 	if err != nil {
@@ -523,7 +760,7 @@ func (rm *RepoManager) CheckRepoSig(ctx context.Context, r *repo.Repo, expdid st
 		return fmt.Errorf("commit serialization failed: %w", err)
 	}
 	if err := rm.kmgr.VerifyUserSignature(ctx, repoDid, scom.Sig, sb); err != nil {
-		return fmt.Errorf("signature check failed (sig: %x) (sb: %x) : %w", scom.Sig, sb, err)
+		return fmt.Errorf("%w (sig: %x) (sb: %x) : %w", ErrInvalidSignature, scom.Sig, sb, err)
 	}
 
 	return nil
@@ -537,7 +774,10 @@ func (rm *RepoManager) HandleExternalUserEvent(ctx context.Context, pdsid uint,
 
 	rm.log.Debug("HandleExternalUserEvent", "pds", pdsid, "uid", uid, "since", since, "nrev", nrev)
 
-	unlock := rm.lockUser(ctx, uid)
+	unlock, err := rm.lockRepo(ctx, uid)
+	if err != nil {
+		return err
+	}
 	defer unlock()
 
 	start := time.Now()
@@ -557,11 +797,13 @@ func (rm *RepoManager) HandleExternalUserEvent(ctx context.Context, pdsid uint,
 	openAndSigCheckDuration.Observe(time.Since(start).Seconds())
 
 	var skipcids map[cid.Cid]bool
+	var prevData *cid.Cid
 	if ds.BaseCid().Defined() {
 		oldrepo, err := repo.OpenRepo(ctx, ds, ds.BaseCid())
 		if err != nil {
 			return fmt.Errorf("failed to check data root in old repo: %w", err)
 		}
+		prevData = prevDataCid(oldrepo, ds.BaseCid())
 
 		// if the old commit has a 'prev', CalcDiff will error out while trying
 		// to walk it. This is an old repo thing that is being deprecated.
@@ -613,6 +855,7 @@ func (rm *RepoManager) HandleExternalUserEvent(ctx context.Context, pdsid uint,
 				Collection: parts[0],
 				Rkey:       parts[1],
 				RecCid:     (*cid.Cid)(op.Cid),
+				PrevCid:    (*cid.Cid)(op.Prev),
 			}
 
 			if rm.hydrateRecords {
@@ -630,6 +873,7 @@ func (rm *RepoManager) HandleExternalUserEvent(ctx context.Context, pdsid uint,
 				Kind:       EvtKindDeleteRecord,
 				Collection: parts[0],
 				Rkey:       parts[1],
+				PrevCid:    (*cid.Cid)(op.Prev),
 			})
 		default:
 			return fmt.Errorf("unrecognized external user event kind: %q", op.Action)
@@ -637,7 +881,7 @@ func (rm *RepoManager) HandleExternalUserEvent(ctx context.Context, pdsid uint,
 	}
 
 	start = time.Now()
-	rslice, err := ds.CloseWithRoot(ctx, root, nrev)
+	rslice, err := rm.closeCommit(ctx, ds, uid, root, nrev)
 	if err != nil {
 		return fmt.Errorf("close with root: %w", err)
 	}
@@ -647,6 +891,7 @@ func (rm *RepoManager) HandleExternalUserEvent(ctx context.Context, pdsid uint,
 		rm.events(ctx, &RepoEvent{
 			User: uid,
 			//OldRoot:   prev,
+			PrevData:  prevData,
 			NewRoot:   root,
 			Rev:       nrev,
 			Since:     since,
@@ -664,10 +909,18 @@ func rkeyForCollection(collection string) string {
 }
 
 func (rm *RepoManager) BatchWrite(ctx context.Context, user models.Uid, writes []*atproto.RepoApplyWrites_Input_Writes_Elem) error {
+	return rm.BatchWriteSwap(ctx, user, writes, nil)
+}
+
+// BatchWriteSwap is BatchWrite, failing with ErrInvalidSwap unless the repo is at the given commit (if not nil)
+func (rm *RepoManager) BatchWriteSwap(ctx context.Context, user models.Uid, writes []*atproto.RepoApplyWrites_Input_Writes_Elem, swapCommit *cid.Cid) error {
 	ctx, span := otel.Tracer("repoman").Start(ctx, "BatchWrite")
 	defer span.End()
 
-	unlock := rm.lockUser(ctx, user)
+	unlock, err := rm.lockRepo(ctx, user)
+	if err != nil {
+		return err
+	}
 	defer unlock()
 
 	rev, err := rm.cs.GetUserRepoRev(ctx, user)
@@ -681,11 +934,16 @@ func (rm *RepoManager) BatchWrite(ctx context.Context, user models.Uid, writes [
 	}
 
 	head := ds.BaseCid()
+	if err := checkSwapCommit(head, swapCommit); err != nil {
+		return err
+	}
 	r, err := repo.OpenRepo(ctx, ds, head)
 	if err != nil {
 		return err
 	}
 
+	prevData := prevDataCid(r, head)
+
 	ops := make([]RepoOp, 0, len(writes))
 	for _, w := range writes {
 		switch {
@@ -704,41 +962,34 @@ func (rm *RepoManager) BatchWrite(ctx context.Context, user models.Uid, writes [
 				return err
 			}
 
-			op := RepoOp{
+			ops = append(ops, RepoOp{
 				Kind:       EvtKindCreateRecord,
 				Collection: c.Collection,
 				Rkey:       rkey,
+				Record:     c.Value.Val,
 				RecCid:     &cc,
-			}
-
-			if rm.hydrateRecords {
-				op.Record = c.Value.Val
-			}
-
-			ops = append(ops, op)
+			})
 		case w.RepoApplyWrites_Update != nil:
 			u := w.RepoApplyWrites_Update
 
-			cc, err := r.PutRecord(ctx, u.Collection+"/"+u.Rkey, u.Value.Val)
+			prevCid := prevRecordCid(ctx, r, u.Collection+"/"+u.Rkey)
+			cc, err := r.UpdateRecord(ctx, u.Collection+"/"+u.Rkey, u.Value.Val)
 			if err != nil {
 				return err
 			}
 
-			op := RepoOp{
+			ops = append(ops, RepoOp{
 				Kind:       EvtKindUpdateRecord,
 				Collection: u.Collection,
 				Rkey:       u.Rkey,
+				Record:     u.Value.Val,
 				RecCid:     &cc,
-			}
-
-			if rm.hydrateRecords {
-				op.Record = u.Value.Val
-			}
-
-			ops = append(ops, op)
+				PrevCid:    prevCid,
+			})
 		case w.RepoApplyWrites_Delete != nil:
 			d := w.RepoApplyWrites_Delete
 
+			prevCid := prevRecordCid(ctx, r, d.Collection+"/"+d.Rkey)
 			if err := r.DeleteRecord(ctx, d.Collection+"/"+d.Rkey); err != nil {
 				return err
 			}
@@ -747,18 +998,23 @@ func (rm *RepoManager) BatchWrite(ctx context.Context, user models.Uid, writes [
 				Kind:       EvtKindDeleteRecord,
 				Collection: d.Collection,
 				Rkey:       d.Rkey,
+				PrevCid:    prevCid,
 			})
 		default:
 			return fmt.Errorf("no operation set in write enum")
 		}
 	}
 
+	if err := rm.checkWrite(ctx, user, ops); err != nil {
+		return err
+	}
+
 	nroot, nrev, err := r.Commit(ctx, rm.kmgr.SignForUser)
 	if err != nil {
 		return err
 	}
 
-	rslice, err := ds.CloseWithRoot(ctx, nroot, nrev)
+	rslice, err := rm.closeCommit(ctx, ds, user, nroot, nrev)
 	if err != nil {
 		return fmt.Errorf("close with root: %w", err)
 	}
@@ -769,9 +1025,15 @@ func (rm *RepoManager) BatchWrite(ctx context.Context, user models.Uid, writes [
 	}
 
 	if rm.events != nil {
+		if !rm.hydrateRecords {
+			for i := range ops {
+				ops[i].Record = nil
+			}
+		}
 		rm.events(ctx, &RepoEvent{
 			User:      user,
 			OldRoot:   oldroot,
+			PrevData:  prevData,
 			NewRoot:   nroot,
 			RepoSlice: rslice,
 			Rev:       nrev,
@@ -787,7 +1049,10 @@ func (rm *RepoManager) ImportNewRepo(ctx context.Context, user models.Uid, repoD
 	ctx, span := otel.Tracer("repoman").Start(ctx, "ImportNewRepo")
 	defer span.End()
 
-	unlock := rm.lockUser(ctx, user)
+	unlock, err := rm.lockRepo(ctx, user)
+	if err != nil {
+		return err
+	}
 	defer unlock()
 
 	currev, err := rm.cs.GetUserRepoRev(ctx, user)
@@ -825,7 +1090,16 @@ func (rm *RepoManager) ImportNewRepo(ctx context.Context, user models.Uid, repoD
 			return fmt.Errorf("commit serialization failed: %w", err)
 		}
 		if err := rm.kmgr.VerifyUserSignature(ctx, repoDid, scom.Sig, sb); err != nil {
-			return fmt.Errorf("new user signature check failed: %w", err)
+			return fmt.Errorf("new user %w: %w", ErrInvalidSignature, err)
+		}
+
+		var prevData *cid.Cid
+		if curhead.Defined() {
+			oldrepo, err := repo.OpenRepo(ctx, bs, curhead)
+			if err != nil {
+				return fmt.Errorf("opening previous repo state: %w", err)
+			}
+			prevData = prevDataCid(oldrepo, curhead)
 		}
 
 		diffops, err := r.DiffSince(ctx, curhead)
@@ -852,15 +1126,31 @@ func (rm *RepoManager) ImportNewRepo(ctx context.Context, user models.Uid, repoD
 		}
 
 		if rm.events != nil {
-			rm.events(ctx, &RepoEvent{
+			evt := &RepoEvent{
 				User: user,
 				//OldRoot:   oldroot,
+				PrevData:  prevData,
 				NewRoot:   root,
 				Rev:       scom.Rev,
 				Since:     &currev,
 				RepoSlice: slice,
 				Ops:       ops,
-			})
+			}
+
+			// without a base revision, the ops are not a diff against what
+			// downstream consumers have seen, so this is a #sync rather than
+			// a #commit
+			if rev == nil {
+				commitCar, err := commitOnlyCar(ctx, bs, root)
+				if err != nil {
+					return fmt.Errorf("building sync event: %w", err)
+				}
+				evt.Sync = true
+				evt.PrevData = nil
+				evt.RepoSlice = commitCar
+			}
+
+			rm.events(ctx, evt)
 		}
 
 		return nil
@@ -892,6 +1182,9 @@ func (rm *RepoManager) processOp(ctx context.Context, bs blockstore.Blockstore,
 			Rkey:       parts[1],
 			RecCid:     &op.NewCid,
 		}
+		if op.Op == "mut" && op.OldCid.Defined() {
+			outop.PrevCid = &op.OldCid
+		}
 
 		if hydrateRecords {
 			blk, err := bs.Get(ctx, op.NewCid)
@@ -913,12 +1206,16 @@ func (rm *RepoManager) processOp(ctx context.Context, bs blockstore.Blockstore,
 
 		return outop, nil
 	case "del":
-		return &RepoOp{
+		outop := &RepoOp{
 			Kind:       EvtKindDeleteRecord,
 			Collection: parts[0],
 			Rkey:       parts[1],
 			RecCid:     nil,
-		}, nil
+		}
+		if op.OldCid.Defined() {
+			outop.PrevCid = &op.OldCid
+		}
+		return outop, nil
 
 	default:
 		return nil, fmt.Errorf("diff returned invalid op type: %q", op.Op)
@@ -983,7 +1280,7 @@ func (rm *RepoManager) processNewRepo(ctx context.Context, user models.Uid, r io
 	}
 
 	finish := func(ctx context.Context, nrev string) ([]byte, error) {
-		return ds.CloseWithRoot(ctx, root, nrev)
+		return rm.closeCommit(ctx, ds, user, root, nrev)
 	}
 
 	if err := cb(ctx, root, finish, ds); err != nil {
@@ -993,6 +1290,47 @@ func (rm *RepoManager) processNewRepo(ctx context.Context, user models.Uid, r io
 	return nil
 }
 
+// returns the MST root of the repo as opened at 'head', or nil for an empty repo
+func prevDataCid(r *repo.Repo, head cid.Cid) *cid.Cid {
+	if !head.Defined() {
+		return nil
+	}
+	dc := r.DataCid()
+	if !dc.Defined() {
+		return nil
+	}
+	return &dc
+}
+
+// returns the current CID of the record at rpath, or nil if it doesn't exist
+func prevRecordCid(ctx context.Context, r *repo.Repo, rpath string) *cid.Cid {
+	cc, err := r.GetRecordCid(ctx, rpath)
+	if err != nil {
+		return nil
+	}
+	return &cc
+}
+
+// builds a CAR file containing just the commit block, as used in #sync events
+func commitOnlyCar(ctx context.Context, bs blockstore.Blockstore, root cid.Cid) ([]byte, error) {
+	blk, err := bs.Get(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	if err := car.WriteHeader(&car.CarHeader{
+		Roots:   []cid.Cid{root},
+		Version: 1,
+	}, buf); err != nil {
+		return nil, err
+	}
+	if _, err := carstore.LdWrite(buf, blk.Cid().Bytes(), blk.RawData()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 func stringOrNil(s *string) string {
 	if s == nil {
 		return "nil"
@@ -1050,7 +1388,10 @@ func (rm *RepoManager) walkTree(ctx context.Context, skip map[cid.Cid]bool, root
 }
 
 func (rm *RepoManager) TakeDownRepo(ctx context.Context, uid models.Uid) error {
-	unlock := rm.lockUser(ctx, uid)
+	unlock, err := rm.lockRepo(ctx, uid)
+	if err != nil {
+		return err
+	}
 	defer unlock()
 
 	return rm.cs.WipeUserData(ctx, uid)
@@ -1058,7 +1399,10 @@ func (rm *RepoManager) TakeDownRepo(ctx context.Context, uid models.Uid) error {
 
 // technically identical to TakeDownRepo, for now
 func (rm *RepoManager) ResetRepo(ctx context.Context, uid models.Uid) error {
-	unlock := rm.lockUser(ctx, uid)
+	unlock, err := rm.lockRepo(ctx, uid)
+	if err != nil {
+		return err
+	}
 	defer unlock()
 
 	return rm.cs.WipeUserData(ctx, uid)
@@ -0,0 +1,48 @@
+package repomgr
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+)
+
+// ErrInvalidSwap is returned when a write's compare-and-swap condition doesn't hold: the repo or record has been changed by another writer since the client last read it
+var ErrInvalidSwap = errors.New("invalid swap")
+
+// Swap holds optional compare-and-swap conditions for a single-record write. Conditions are checked while holding the repo lock, so concurrent writers with the same expectation can't both succeed.
+type Swap struct {
+	// if not nil, the repo's current commit CID must match
+	Commit *cid.Cid
+	// if not nil, the record's current CID must match (so the record must exist)
+	Record *cid.Cid
+}
+
+func checkSwapCommit(head cid.Cid, swapCommit *cid.Cid) error {
+	if swapCommit == nil {
+		return nil
+	}
+	if !head.Defined() || !head.Equals(*swapCommit) {
+		return fmt.Errorf("%w: commit was at %s", ErrInvalidSwap, head)
+	}
+	return nil
+}
+
+// checks the conditions against the repo's current head and the record's current CID (nil if it doesn't exist). a nil Swap always passes
+func (s *Swap) check(head cid.Cid, prevRecord *cid.Cid) error {
+	if s == nil {
+		return nil
+	}
+	if err := checkSwapCommit(head, s.Commit); err != nil {
+		return err
+	}
+	if s.Record != nil {
+		if prevRecord == nil {
+			return fmt.Errorf("%w: record does not exist", ErrInvalidSwap)
+		}
+		if !prevRecord.Equals(*s.Record) {
+			return fmt.Errorf("%w: record was at %s", ErrInvalidSwap, prevRecord)
+		}
+	}
+	return nil
+}
@@ -131,6 +131,20 @@ func (idx *Indexer) RunIndexer(ctx context.Context) error {
 			}
 			return nil
 		},
+		RepoAccount: func(evt *comatproto.SyncSubscribeRepos_Account) error {
+			if idx.Moderation == nil {
+				return nil
+			}
+			ctx := context.Background()
+			ctx, span := tracer.Start(ctx, "RepoAccount")
+			defer span.End()
+
+			if err := idx.Moderation.HandleAccount(ctx, evt); err != nil {
+				// TODO: handle this case (instead of return nil)
+				idx.logger.Error("failed to update account status", "did", evt.Did, "active", evt.Active, "seq", evt.Seq, "err", err)
+			}
+			return nil
+		},
 	}
 
 	return events.HandleRepoStream(
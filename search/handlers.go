@@ -212,6 +212,11 @@ func (s *Server) handleSearchPostsSkeleton(e echo.Context) error {
 
 	span.SetAttributes(attribute.Int("posts.length", len(out.Posts)))
 
+	if s.Moderation != nil {
+		return s.moderatedJSON(e, func(labelers []string) (any, error) {
+			return s.Moderation.ModeratePosts(ctx, labelers, out)
+		})
+	}
 	return e.JSON(200, out)
 }
 
@@ -275,6 +280,11 @@ func (s *Server) handleSearchActorsSkeleton(e echo.Context) error {
 
 	span.SetAttributes(attribute.Int("actors.length", len(out.Actors)))
 
+	if s.Moderation != nil {
+		return s.moderatedJSON(e, func(labelers []string) (any, error) {
+			return s.Moderation.ModerateActors(ctx, labelers, out)
+		})
+	}
 	return e.JSON(200, out)
 }
 
@@ -47,6 +47,9 @@ type Indexer struct {
 	profileQueue  chan *ProfileIndexJob
 	postQueue     chan *PostIndexJob
 	pagerankQueue chan *PagerankIndexJob
+
+	// Optional; receives account status changes from the relay
+	Moderation *Moderation
 }
 
 type IndexerConfig struct {
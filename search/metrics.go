@@ -156,3 +156,13 @@ func computeApproximateRequestSize(r *http.Request) int {
 	}
 	return s
 }
+
+var moderationLabels = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "search_moderation_labels",
+	Help: "Number of labels received from labelers, by whether they were applied or negated",
+}, []string{"action"})
+
+var moderationFiltered = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "search_moderation_filtered",
+	Help: "Number of search results removed by moderation, by result type",
+}, []string{"type"})
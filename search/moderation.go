@@ -0,0 +1,449 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	"github.com/carlmjohnson/versioninfo"
+	"github.com/labstack/echo/v4"
+	gorm "gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SubjectLabel is a label currently applied to a subject (an account DID, or
+// a record AT-URI) by a labeler. Negated labels are removed.
+type SubjectLabel struct {
+	Src string `gorm:"primarykey"`
+	Uri string `gorm:"primarykey"`
+	Val string `gorm:"primarykey"`
+	Cts string
+	Exp *time.Time
+}
+
+// AccountState records accounts which the relay reports as inactive (eg,
+// taken down). Active accounts have no row.
+type AccountState struct {
+	DID       string `gorm:"primarykey;column:did"`
+	Status    string
+	UpdatedAt time.Time
+}
+
+// LabelerCursor is the position in each labeler's subscribeLabels stream
+type LabelerCursor struct {
+	Labeler string `gorm:"primarykey"`
+	Seq     int64
+}
+
+type LabelerConfig struct {
+	DID syntax.DID
+	// Base URL of the labeler service. If empty, the labeler's
+	// #atproto_labeler service endpoint is resolved from its DID document
+	Host string
+}
+
+type ModerationConfig struct {
+	Logger *slog.Logger
+	// Labelers whose labels are consumed (by RunLabelers), and may be applied
+	// to search results
+	Labelers []LabelerConfig
+	// Labelers applied to requests which don't list any in the
+	// atproto-accept-labelers header. Defaults to all of Labelers
+	DefaultLabelers []syntax.DID
+	// Label values which remove results. Results with other labels from the
+	// applied labelers are annotated with them instead. Defaults to
+	// "!takedown" and "!hide"
+	HideLabels []string
+}
+
+// Moderation filters search results by moderation state: posts and profiles
+// of accounts the relay reports as inactive (taken down, suspended,
+// deactivated or deleted) are always removed, and labels from the labelers
+// a request asks for (with the atproto-accept-labelers header) either
+// remove or annotate results, depending on the label value.
+//
+// State is kept in the database, so that readonly API instances can share
+// the state kept up to date by the indexing instance.
+type Moderation struct {
+	db     *gorm.DB
+	dir    identity.Directory
+	logger *slog.Logger
+
+	labelers        []LabelerConfig
+	defaultLabelers []string
+	hideLabels      map[string]bool
+}
+
+func NewModeration(db *gorm.DB, dir identity.Directory, config ModerationConfig) (*Moderation, error) {
+	logger := config.Logger
+	if logger == nil {
+		logger = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+			Level: slog.LevelInfo,
+		}))
+	}
+	logger = logger.With("component", "moderation")
+
+	if err := db.AutoMigrate(&SubjectLabel{}, &AccountState{}, &LabelerCursor{}); err != nil {
+		return nil, fmt.Errorf("migrating moderation tables: %w", err)
+	}
+
+	m := &Moderation{
+		db:         db,
+		dir:        dir,
+		logger:     logger,
+		labelers:   config.Labelers,
+		hideLabels: make(map[string]bool),
+	}
+	defaults := config.DefaultLabelers
+	if defaults == nil {
+		for _, l := range config.Labelers {
+			defaults = append(defaults, l.DID)
+		}
+	}
+	for _, d := range defaults {
+		if !m.isLabeler(d.String()) {
+			return nil, fmt.Errorf("default labeler %s is not a configured labeler", d)
+		}
+		m.defaultLabelers = append(m.defaultLabelers, d.String())
+	}
+	hide := config.HideLabels
+	if hide == nil {
+		hide = []string{"!takedown", "!hide"}
+	}
+	for _, v := range hide {
+		m.hideLabels[v] = true
+	}
+	return m, nil
+}
+
+func (m *Moderation) isLabeler(did string) bool {
+	for _, l := range m.labelers {
+		if l.DID.String() == did {
+			return true
+		}
+	}
+	return false
+}
+
+// RunLabelers consumes the label streams of all configured labelers until ctx
+// is done. Only one instance should run this.
+func (m *Moderation) RunLabelers(ctx context.Context) error {
+	errs := make(chan error, len(m.labelers))
+	for _, l := range m.labelers {
+		go func(l LabelerConfig) {
+			errs <- m.runLabeler(ctx, l)
+		}(l)
+	}
+	for range m.labelers {
+		if err := <-errs; err != nil && ctx.Err() == nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Moderation) runLabeler(ctx context.Context, l LabelerConfig) error {
+	host := l.Host
+	if host == "" {
+		ident, err := m.dir.LookupDID(ctx, l.DID)
+		if err != nil {
+			return fmt.Errorf("resolving labeler %s: %w", l.DID, err)
+		}
+		host = ident.GetServiceEndpoint("atproto_labeler")
+		if host == "" {
+			return fmt.Errorf("labeler %s has no labeler service endpoint", l.DID)
+		}
+	}
+
+	src := l.DID.String()
+	rsc := &events.RepoStreamCallbacks{
+		LabelLabels: func(evt *comatproto.LabelSubscribeLabels_Labels) error {
+			return m.HandleLabels(ctx, src, evt.Labels)
+		},
+	}
+	sc, err := events.NewStreamClient(events.StreamClientConfig{
+		Host:      host,
+		Path:      "/xrpc/com.atproto.label.subscribeLabels",
+		UserAgent: fmt.Sprintf("palomar/%s", versioninfo.Short()),
+		Handler:   rsc.EventHandler,
+		Cursors:   &labelerCursorStore{db: m.db, labeler: src},
+		Logger:    m.logger.With("labeler", src),
+	})
+	if err != nil {
+		return err
+	}
+	m.logger.Info("consuming labels", "labeler", src, "host", host)
+	return sc.Run(ctx)
+}
+
+// HandleLabels records labels from a labeler's stream. Labels claiming to be
+// from other sources are ignored.
+func (m *Moderation) HandleLabels(ctx context.Context, labeler string, labels []*comatproto.LabelDefs_Label) error {
+	for _, l := range labels {
+		if l.Src != labeler {
+			m.logger.Warn("ignoring label from another source", "labeler", labeler, "src", l.Src, "uri", l.Uri, "val", l.Val)
+			continue
+		}
+		if l.Neg != nil && *l.Neg {
+			if err := m.db.WithContext(ctx).
+				Where("src = ? AND uri = ? AND val = ?", l.Src, l.Uri, l.Val).
+				Delete(&SubjectLabel{}).Error; err != nil {
+				return err
+			}
+			moderationLabels.WithLabelValues("negated").Inc()
+			continue
+		}
+
+		sl := SubjectLabel{Src: l.Src, Uri: l.Uri, Val: l.Val, Cts: l.Cts}
+		if l.Exp != nil {
+			exp, err := syntax.ParseDatetimeLenient(*l.Exp)
+			if err != nil {
+				m.logger.Warn("ignoring label with invalid expiry", "src", l.Src, "uri", l.Uri, "val", l.Val, "exp", *l.Exp)
+				continue
+			}
+			t := exp.Time()
+			sl.Exp = &t
+		}
+		if err := m.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(&sl).Error; err != nil {
+			return err
+		}
+		moderationLabels.WithLabelValues("applied").Inc()
+	}
+	return nil
+}
+
+// HandleAccount records an account status change from the relay
+func (m *Moderation) HandleAccount(ctx context.Context, evt *comatproto.SyncSubscribeRepos_Account) error {
+	if evt.Active {
+		return m.db.WithContext(ctx).Where("did = ?", evt.Did).Delete(&AccountState{}).Error
+	}
+	status := "inactive"
+	if evt.Status != nil {
+		status = *evt.Status
+	}
+	return m.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(&AccountState{
+		DID:    evt.Did,
+		Status: status,
+	}).Error
+}
+
+// requestLabelers returns the labelers to apply to a request, from its
+// atproto-accept-labelers header. Labelers which aren't configured are
+// ignored, since there are no labels from them.
+func (m *Moderation) requestLabelers(header string) []string {
+	if strings.TrimSpace(header) == "" {
+		return m.defaultLabelers
+	}
+	var out []string
+	for _, part := range strings.Split(header, ",") {
+		// parameters (eg, ";redact") don't change anything here
+		did, _, _ := strings.Cut(part, ";")
+		did = strings.TrimSpace(did)
+		if m.isLabeler(did) && !slices.Contains(out, did) {
+			out = append(out, did)
+		}
+	}
+	return out
+}
+
+// subjectState is the moderation state of a search result
+type subjectState struct {
+	hide   bool
+	labels []*comatproto.LabelDefs_Label
+}
+
+// subjectStates looks up the moderation state of results, each of which is
+// described by its subjects (eg, a post's URI and its author's DID)
+func (m *Moderation) subjectStates(ctx context.Context, labelers []string, results [][]string) ([]subjectState, error) {
+	var dids, subjects []string
+	for _, r := range results {
+		for _, s := range r {
+			subjects = append(subjects, s)
+			if strings.HasPrefix(s, "did:") {
+				dids = append(dids, s)
+			}
+		}
+	}
+
+	inactive := make(map[string]bool)
+	if len(dids) > 0 {
+		var states []AccountState
+		if err := m.db.WithContext(ctx).Where("did IN ?", dids).Find(&states).Error; err != nil {
+			return nil, err
+		}
+		for _, st := range states {
+			inactive[st.DID] = true
+		}
+	}
+
+	labels := make(map[string][]SubjectLabel)
+	if len(labelers) > 0 && len(subjects) > 0 {
+		var found []SubjectLabel
+		if err := m.db.WithContext(ctx).
+			Where("src IN ? AND uri IN ? AND (exp IS NULL OR exp > ?)", labelers, subjects, time.Now()).
+			Order("src, uri, val").Find(&found).Error; err != nil {
+			return nil, err
+		}
+		for _, l := range found {
+			labels[l.Uri] = append(labels[l.Uri], l)
+		}
+	}
+
+	out := make([]subjectState, len(results))
+	for i, r := range results {
+		for _, s := range r {
+			if inactive[s] {
+				out[i].hide = true
+			}
+			for _, l := range labels[s] {
+				if m.hideLabels[l.Val] {
+					out[i].hide = true
+				}
+				out[i].labels = append(out[i].labels, &comatproto.LabelDefs_Label{
+					Src: l.Src,
+					Uri: l.Uri,
+					Val: l.Val,
+					Cts: l.Cts,
+				})
+			}
+		}
+	}
+	return out, nil
+}
+
+type ModeratedSearchPost struct {
+	Uri    string                        `json:"uri"`
+	Labels []*comatproto.LabelDefs_Label `json:"labels,omitempty"`
+}
+
+// ModeratedSearchPostsOutput is app.bsky.unspecced.searchPostsSkeleton
+// output, with labels on each post
+type ModeratedSearchPostsOutput struct {
+	Cursor    *string                `json:"cursor,omitempty"`
+	HitsTotal *int64                 `json:"hitsTotal,omitempty"`
+	Posts     []*ModeratedSearchPost `json:"posts"`
+}
+
+type ModeratedSearchActor struct {
+	Did    string                        `json:"did"`
+	Labels []*comatproto.LabelDefs_Label `json:"labels,omitempty"`
+}
+
+// ModeratedSearchActorsOutput is app.bsky.unspecced.searchActorsSkeleton
+// output, with labels on each actor
+type ModeratedSearchActorsOutput struct {
+	Cursor    *string                 `json:"cursor,omitempty"`
+	HitsTotal *int64                  `json:"hitsTotal,omitempty"`
+	Actors    []*ModeratedSearchActor `json:"actors"`
+}
+
+// ModeratePosts removes hidden posts from search results, and labels the rest
+func (m *Moderation) ModeratePosts(ctx context.Context, labelers []string, in *appbsky.UnspeccedSearchPostsSkeleton_Output) (*ModeratedSearchPostsOutput, error) {
+	ctx, span := tracer.Start(ctx, "ModeratePosts")
+	defer span.End()
+
+	results := make([][]string, len(in.Posts))
+	for i, p := range in.Posts {
+		results[i] = []string{p.Uri}
+		if aturi, err := syntax.ParseATURI(p.Uri); err == nil {
+			results[i] = append(results[i], aturi.Authority().String())
+		}
+	}
+	states, err := m.subjectStates(ctx, labelers, results)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &ModeratedSearchPostsOutput{
+		Cursor:    in.Cursor,
+		HitsTotal: in.HitsTotal,
+		Posts:     []*ModeratedSearchPost{},
+	}
+	for i, p := range in.Posts {
+		if states[i].hide {
+			moderationFiltered.WithLabelValues("post").Inc()
+			continue
+		}
+		out.Posts = append(out.Posts, &ModeratedSearchPost{Uri: p.Uri, Labels: states[i].labels})
+	}
+	return out, nil
+}
+
+// ModerateActors removes hidden actors from search results, and labels the
+// rest
+func (m *Moderation) ModerateActors(ctx context.Context, labelers []string, in *appbsky.UnspeccedSearchActorsSkeleton_Output) (*ModeratedSearchActorsOutput, error) {
+	ctx, span := tracer.Start(ctx, "ModerateActors")
+	defer span.End()
+
+	results := make([][]string, len(in.Actors))
+	for i, a := range in.Actors {
+		// profile labels may be on the account, or the profile record
+		results[i] = []string{a.Did, fmt.Sprintf("at://%s/app.bsky.actor.profile/self", a.Did)}
+	}
+	states, err := m.subjectStates(ctx, labelers, results)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &ModeratedSearchActorsOutput{
+		Cursor:    in.Cursor,
+		HitsTotal: in.HitsTotal,
+		Actors:    []*ModeratedSearchActor{},
+	}
+	for i, a := range in.Actors {
+		if states[i].hide {
+			moderationFiltered.WithLabelValues("actor").Inc()
+			continue
+		}
+		out.Actors = append(out.Actors, &ModeratedSearchActor{Did: a.Did, Labels: states[i].labels})
+	}
+	return out, nil
+}
+
+// moderatedJSON writes moderated search results, saying which labelers were
+// applied
+func (s *Server) moderatedJSON(e echo.Context, moderate func(labelers []string) (any, error)) error {
+	labelers := s.Moderation.requestLabelers(e.Request().Header.Get(xrpc.HeaderAcceptLabelers))
+	out, err := moderate(labelers)
+	if err != nil {
+		return err
+	}
+	e.Response().Header().Set("atproto-content-labelers", strings.Join(labelers, ","))
+	return e.JSON(http.StatusOK, out)
+}
+
+// labelerCursorStore keeps a labeler stream's cursor in the database
+type labelerCursorStore struct {
+	db      *gorm.DB
+	labeler string
+}
+
+func (cs *labelerCursorStore) GetCursor(ctx context.Context) (int64, error) {
+	var lc LabelerCursor
+	if err := cs.db.WithContext(ctx).Where("labeler = ?", cs.labeler).Limit(1).Find(&lc).Error; err != nil {
+		return -1, err
+	}
+	if lc.Labeler == "" {
+		return -1, nil
+	}
+	return lc.Seq, nil
+}
+
+func (cs *labelerCursorStore) PutCursor(ctx context.Context, cursor int64) error {
+	return cs.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(&LabelerCursor{
+		Labeler: cs.labeler,
+		Seq:     cursor,
+	}).Error
+}
@@ -0,0 +1,158 @@
+package search
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestModeration(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	labelerA := syntax.DID("did:plc:labelera")
+	labelerB := syntax.DID("did:plc:labelerb")
+	dir := identity.NewMockDirectory()
+	m, err := NewModeration(db, &dir, ModerationConfig{
+		Labelers:        []LabelerConfig{{DID: labelerA}, {DID: labelerB}},
+		DefaultLabelers: []syntax.DID{labelerA},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal([]string{"did:plc:labelera"}, m.requestLabelers(""))
+	assert.Equal([]string{"did:plc:labelerb"}, m.requestLabelers("did:plc:labelerb;redact, did:plc:unknown"))
+
+	post := func(did, rkey string) *appbsky.UnspeccedDefs_SkeletonSearchPost {
+		return &appbsky.UnspeccedDefs_SkeletonSearchPost{Uri: "at://" + did + "/app.bsky.feed.post/" + rkey}
+	}
+	posts := &appbsky.UnspeccedSearchPostsSkeleton_Output{
+		Posts: []*appbsky.UnspeccedDefs_SkeletonSearchPost{
+			post("did:plc:alice", "3kaaaaaaaaaaa"),
+			post("did:plc:bob", "3kbbbbbbbbbbb"),
+			post("did:plc:carol", "3kccccccccccc"),
+			post("did:plc:dave", "3kddddddddddd"),
+		},
+	}
+	uris := func(out *ModeratedSearchPostsOutput) []string {
+		var s []string
+		for _, p := range out.Posts {
+			s = append(s, p.Uri)
+		}
+		return s
+	}
+
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	yes := true
+	if err := m.HandleLabels(ctx, "did:plc:labelera", []*comatproto.LabelDefs_Label{
+		{Src: "did:plc:labelera", Uri: posts.Posts[0].Uri, Val: "spam", Cts: "2024-01-01T00:00:00Z"},
+		{Src: "did:plc:labelera", Uri: "did:plc:bob", Val: "!hide", Cts: "2024-01-01T00:00:00Z"},
+		{Src: "did:plc:labelera", Uri: "did:plc:dave", Val: "!hide", Cts: "2024-01-01T00:00:00Z", Exp: &past},
+		// labels must come from the labeler whose stream they're on
+		{Src: "did:plc:labelerb", Uri: "did:plc:carol", Val: "!hide", Cts: "2024-01-01T00:00:00Z"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.HandleLabels(ctx, "did:plc:labelerb", []*comatproto.LabelDefs_Label{
+		{Src: "did:plc:labelerb", Uri: posts.Posts[2].Uri, Val: "!takedown", Cts: "2024-01-01T00:00:00Z"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// default labelers: bob is hidden, alice's post is labeled, and the
+	// label on dave has expired
+	out, err := m.ModeratePosts(ctx, m.requestLabelers(""), posts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal([]string{posts.Posts[0].Uri, posts.Posts[2].Uri, posts.Posts[3].Uri}, uris(out))
+	if assert.Len(out.Posts[0].Labels, 1) {
+		assert.Equal("spam", out.Posts[0].Labels[0].Val)
+	}
+	assert.Empty(out.Posts[1].Labels)
+
+	// only labeler B
+	out, err = m.ModeratePosts(ctx, []string{"did:plc:labelerb"}, posts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal([]string{posts.Posts[0].Uri, posts.Posts[1].Uri, posts.Posts[3].Uri}, uris(out))
+	assert.Empty(out.Posts[0].Labels)
+
+	// relay takedowns apply whatever the labelers
+	takendown := "takendown"
+	if err := m.HandleAccount(ctx, &comatproto.SyncSubscribeRepos_Account{Did: "did:plc:dave", Status: &takendown}); err != nil {
+		t.Fatal(err)
+	}
+	out, err = m.ModeratePosts(ctx, nil, posts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal([]string{posts.Posts[0].Uri, posts.Posts[1].Uri, posts.Posts[2].Uri}, uris(out))
+
+	actors, err := m.ModerateActors(ctx, nil, &appbsky.UnspeccedSearchActorsSkeleton_Output{
+		Actors: []*appbsky.UnspeccedDefs_SkeletonSearchActor{{Did: "did:plc:alice"}, {Did: "did:plc:dave"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(actors.Actors, 1) {
+		assert.Equal("did:plc:alice", actors.Actors[0].Did)
+	}
+
+	// reactivation and negation undo them
+	if err := m.HandleAccount(ctx, &comatproto.SyncSubscribeRepos_Account{Did: "did:plc:dave", Active: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.HandleLabels(ctx, "did:plc:labelera", []*comatproto.LabelDefs_Label{
+		{Src: "did:plc:labelera", Uri: "did:plc:bob", Val: "!hide", Cts: "2024-01-02T00:00:00Z", Neg: &yes},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	out, err = m.ModeratePosts(ctx, m.requestLabelers(""), posts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(out.Posts, 4)
+}
+
+func TestLabelerCursorStore(t *testing.T) {
+	ctx := context.Background()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&LabelerCursor{}); err != nil {
+		t.Fatal(err)
+	}
+	cs := &labelerCursorStore{db: db, labeler: "did:plc:labelera"}
+	cur, err := cs.GetCursor(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(-1), cur)
+	for _, c := range []int64{10, 42} {
+		if err := cs.PutCursor(ctx, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cur, err = cs.GetCursor(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(42), cur)
+}
@@ -43,6 +43,8 @@ type Server struct {
 	logger       *slog.Logger
 
 	Indexer *Indexer
+	// Optional; filters and labels search results
+	Moderation *Moderation
 }
 
 func NewServer(escli *es.Client, dir identity.Directory, config ServerConfig) (*Server, error) {
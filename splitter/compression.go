@@ -0,0 +1,133 @@
+package splitter
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	events "github.com/bluesky-social/indigo/events"
+
+	"github.com/gorilla/websocket"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/labstack/echo/v4"
+)
+
+// consumer encodings, as reported in logs and metrics
+const (
+	encodingNone    = "none"
+	encodingDeflate = "permessage-deflate"
+	encodingZstd    = "zstd"
+)
+
+// zstdKey identifies a compressed message: one event can become several JSON
+// stream messages
+type zstdKey struct {
+	json bool
+	seq  int64
+	idx  int
+}
+
+// zstdCompressor compresses messages for consumers which asked for zstd, as in
+// the Jetstream protocol: each websocket message is a separate zstd frame,
+// optionally compressed against a dictionary shared with the consumer.
+// Compressed messages are cached by sequence number, so each event is only
+// compressed once however many consumers there are.
+type zstdCompressor struct {
+	enc   *zstd.Encoder
+	dict  []byte
+	cache *lru.Cache[zstdKey, []byte]
+}
+
+func newZstdCompressor(dictFile string) (*zstdCompressor, error) {
+	opts := []zstd.EOption{
+		zstd.WithEncoderLevel(zstd.SpeedDefault),
+		// messages are compressed one at a time, with EncodeAll
+		zstd.WithEncoderConcurrency(1),
+	}
+	var dict []byte
+	if dictFile != "" {
+		b, err := os.ReadFile(dictFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading zstd dictionary: %w", err)
+		}
+		dict = b
+		opts = append(opts, zstd.WithEncoderDict(dict))
+	}
+	enc, err := zstd.NewWriter(nil, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid zstd dictionary: %w", err)
+	}
+	cache, _ := lru.New[zstdKey, []byte](10_000)
+	return &zstdCompressor{enc: enc, dict: dict, cache: cache}, nil
+}
+
+// compress returns the compressed form of msg. Messages with a key for an
+// unsequenced event (eg, #info frames) aren't cached.
+func (z *zstdCompressor) compress(key zstdKey, msg []byte) []byte {
+	if key.seq > 0 {
+		if out, ok := z.cache.Get(key); ok {
+			return out
+		}
+	}
+	out := z.enc.EncodeAll(msg, make([]byte, 0, len(msg)/2))
+	zstdBytesCounter.WithLabelValues("in").Add(float64(len(msg)))
+	zstdBytesCounter.WithLabelValues("out").Add(float64(len(out)))
+	if key.seq > 0 {
+		z.cache.Add(key, out)
+	}
+	return out
+}
+
+// wantsZstd returns true if the consumer asked for zstd compressed messages,
+// with either Jetstream's compress=true query parameter or a
+// "Socket-Encoding: zstd" header
+func wantsZstd(r *http.Request) bool {
+	if r.URL.Query().Get("compress") == "true" {
+		return true
+	}
+	for _, enc := range strings.Split(r.Header.Get("Socket-Encoding"), ",") {
+		if strings.TrimSpace(strings.ToLower(enc)) == "zstd" {
+			return true
+		}
+	}
+	return false
+}
+
+// offersDeflate returns true if the consumer offered permessage-deflate, which
+// the upgrader accepts whenever it is enabled
+func offersDeflate(r *http.Request) bool {
+	for _, ext := range r.Header.Values("Sec-WebSocket-Extensions") {
+		if strings.Contains(strings.ToLower(ext), "permessage-deflate") {
+			return true
+		}
+	}
+	return false
+}
+
+// writeFirehoseEvent writes an event to a subscribeRepos consumer, zstd
+// compressed if the consumer asked for it, returning the size of the message
+func (s *Splitter) writeFirehoseEvent(conn *websocket.Conn, evt *events.XRPCStreamEvent, useZstd bool) (int, error) {
+	if !useZstd {
+		return events.WriteEventSize(conn, evt)
+	}
+	var msg []byte
+	err := events.WithEventBytes(evt, func(b []byte) error {
+		msg = s.zstd.compress(zstdKey{seq: events.SequenceForEvent(evt)}, b)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(msg), conn.WriteMessage(websocket.BinaryMessage, msg)
+}
+
+// HandleZstdDictionary serves the dictionary zstd compressed messages are
+// compressed against, for consumers to decompress them with
+func (s *Splitter) HandleZstdDictionary(c echo.Context) error {
+	if s.zstd.dict == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "zstd messages are compressed without a dictionary")
+	}
+	return c.Blob(http.StatusOK, "application/octet-stream", s.zstd.dict)
+}
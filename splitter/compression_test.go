@@ -0,0 +1,152 @@
+package splitter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/events"
+
+	"github.com/gorilla/websocket"
+	"github.com/klauspost/compress/zstd"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// testCompressionSplitter returns a splitter serving the given events, and
+// the websocket base URL of its server
+func testCompressionSplitter(t *testing.T, evts ...*events.XRPCStreamEvent) (*Splitter, string) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	s := NewMemSplitter("localhost")
+	e := echo.New()
+	e.GET("/xrpc/com.atproto.sync.subscribeRepos", s.EventsHandler)
+	e.GET("/subscribe", s.JetstreamHandler)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+
+	for i, evt := range evts {
+		if err := s.events.AddEvent(ctx, evt); err != nil {
+			t.Fatal(i, err)
+		}
+	}
+	return s, "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+// readMessages reads n messages of the given type from a new connection
+func readMessages(t *testing.T, url string, h http.Header, mt, n int) [][]byte {
+	t.Helper()
+
+	con, _, err := websocket.DefaultDialer.Dial(url, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer con.Close()
+	con.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var out [][]byte
+	for range n {
+		typ, msg, err := con.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ != mt {
+			t.Fatalf("got message type %d, expected %d", typ, mt)
+		}
+		out = append(out, msg)
+	}
+	return out
+}
+
+func TestZstdFirehoseRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+
+	t0 := time.Now().Add(-time.Minute)
+	evts := []*events.XRPCStreamEvent{
+		testCommitEvent(t, 1, "did:example:abc", t0, testOp{"create", "app.bsky.feed.post/1", strings.Repeat("hello ", 100)}),
+		testIdentityEvent(2, "did:example:abc"),
+		testCommitEvent(t, 3, "did:example:abc", t0, testOp{"delete", "app.bsky.feed.post/1", ""}),
+	}
+	var frames [][]byte
+	for _, evt := range evts {
+		var buf bytes.Buffer
+		if err := evt.Serialize(&buf); err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, buf.Bytes())
+	}
+
+	_, base := testCompressionSplitter(t, evts...)
+	url := base + "/xrpc/com.atproto.sync.subscribeRepos?cursor=0"
+
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+
+	// both ways of asking for zstd get the same compressed messages
+	viaQuery := readMessages(t, url+"&compress=true", nil, websocket.BinaryMessage, len(evts))
+	viaHeader := readMessages(t, url, http.Header{"Socket-Encoding": []string{"zstd"}}, websocket.BinaryMessage, len(evts))
+	assert.Equal(viaQuery, viaHeader)
+
+	for i, msg := range viaQuery {
+		out, err := dec.DecodeAll(msg, nil)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		assert.Equal(frames[i], out, i)
+	}
+	assert.Less(len(viaQuery[0]), len(frames[0]))
+
+	// consumers which didn't ask get the frames as they are
+	plain := readMessages(t, url, nil, websocket.BinaryMessage, len(evts))
+	assert.Equal(frames, plain)
+}
+
+func TestZstdJetstreamRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+
+	t0 := time.Now().Add(-time.Minute).Truncate(time.Second)
+	evt := testCommitEvent(t, 1, "did:example:abc", t0,
+		testOp{"create", "app.bsky.feed.post/1", "one"},
+		testOp{"create", "app.bsky.feed.post/2", "two"},
+	)
+	s, base := testCompressionSplitter(t, evt)
+
+	frames, err := s.jetstreamFrames(evt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !assert.Len(frames, 2) {
+		return
+	}
+
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+
+	url := fmt.Sprintf("%s/subscribe?cursor=%d", base, t0.Add(-time.Second).UnixMicro())
+	msgs := readMessages(t, url+"&compress=true", nil, websocket.BinaryMessage, len(frames))
+	for i, msg := range msgs {
+		out, err := dec.DecodeAll(msg, nil)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		assert.Equal(frames[i].msg, out, i)
+	}
+
+	plain := readMessages(t, url, nil, websocket.TextMessage, len(frames))
+	for i, msg := range plain {
+		assert.Equal(frames[i].msg, msg, i)
+	}
+}
@@ -0,0 +1,421 @@
+package splitter
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	gojwt "github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
+)
+
+// ConsumerKey is an API key a consumer connects with. Only a hash of the key
+// is kept, so keys can't be recovered after they are created.
+type ConsumerKey struct {
+	// identifies the consumer, in logs and metrics
+	Name      string    `json:"name"`
+	KeyHash   string    `json:"keyHash"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ConsumerRevocation blocks JWTs with a subject (see
+// SplitterConfig.ConsumerJWTSecret) until it expires, which should be after
+// any token issued before the revocation expires.
+type ConsumerRevocation struct {
+	Subject   string    `json:"subject"`
+	Until     time.Time `json:"until"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+const consumerKeyPrefix = "rbw_"
+
+// Consumers are identified by the kind of credentials they authenticated
+// with, so that a JWT subject can't pass for a key's name, or the reverse
+func keyConsumer(name string) string    { return "key:" + name }
+func jwtConsumer(subject string) string { return "jwt:" + subject }
+
+var errConsumerUnauthorized = errors.New("invalid or revoked consumer credentials")
+
+type consumerAuthFile struct {
+	Keys        []*ConsumerKey        `json:"keys"`
+	Revocations []*ConsumerRevocation `json:"revocations"`
+}
+
+// consumerAuth checks the credentials consumers connect with: API keys
+// (managed through the admin API), and JWTs signed with a shared secret (for
+// operators issuing short-lived tokens from their own service). Expired
+// revocations are dropped whenever the state is next written.
+type consumerAuth struct {
+	lk sync.RWMutex
+	// by key hash
+	keys        map[string]*ConsumerKey
+	revocations map[string]*ConsumerRevocation
+
+	jwtSecret []byte
+	require   bool
+	// file the keys and revocations are persisted to. optional
+	path string
+}
+
+func newConsumerAuth(conf SplitterConfig) (*consumerAuth, error) {
+	ca := &consumerAuth{
+		keys:        make(map[string]*ConsumerKey),
+		revocations: make(map[string]*ConsumerRevocation),
+		require:     conf.RequireConsumerAuth,
+		path:        conf.ConsumerKeysFile,
+	}
+	if conf.ConsumerJWTSecret != "" {
+		ca.jwtSecret = []byte(conf.ConsumerJWTSecret)
+	}
+	if ca.path == "" {
+		return ca, nil
+	}
+	b, err := os.ReadFile(ca.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ca, nil
+		}
+		return nil, err
+	}
+	var f consumerAuthFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("reading consumer keys file: %w", err)
+	}
+	for _, k := range f.Keys {
+		ca.keys[k.KeyHash] = k
+	}
+	for _, r := range f.Revocations {
+		ca.revocations[r.Subject] = r
+	}
+	return ca, nil
+}
+
+func hashConsumerKey(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+// consumerCredentials returns the credentials a consumer connected with, from
+// the Authorization header, or the "token" query parameter for clients (eg,
+// browsers) which can't set headers on websocket requests
+func consumerCredentials(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); h != "" {
+		if tok, ok := strings.CutPrefix(h, "Bearer "); ok {
+			return strings.TrimSpace(tok)
+		}
+	}
+	return r.URL.Query().Get("token")
+}
+
+// Authenticate identifies the consumer making a request, as "key:<name>" or
+// "jwt:<subject>". Returns "" for consumers which didn't send credentials,
+// when auth isn't required.
+func (ca *consumerAuth) Authenticate(r *http.Request) (string, error) {
+	cred := consumerCredentials(r)
+	if cred == "" {
+		if ca.require {
+			consumerAuthFailures.WithLabelValues("missing").Inc()
+			return "", errConsumerUnauthorized
+		}
+		return "", nil
+	}
+
+	if strings.HasPrefix(cred, consumerKeyPrefix) {
+		ca.lk.RLock()
+		k, ok := ca.keys[hashConsumerKey(cred)]
+		ca.lk.RUnlock()
+		if !ok {
+			consumerAuthFailures.WithLabelValues("invalid_key").Inc()
+			return "", errConsumerUnauthorized
+		}
+		return keyConsumer(k.Name), nil
+	}
+
+	if ca.jwtSecret == nil {
+		consumerAuthFailures.WithLabelValues("invalid_key").Inc()
+		return "", errConsumerUnauthorized
+	}
+	var claims gojwt.StandardClaims
+	tok, err := gojwt.ParseWithClaims(cred, &claims, func(tok *gojwt.Token) (any, error) {
+		if _, ok := tok.Method.(*gojwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %s", tok.Header["alg"])
+		}
+		return ca.jwtSecret, nil
+	})
+	if err != nil || !tok.Valid || claims.Subject == "" || !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		consumerAuthFailures.WithLabelValues("invalid_jwt").Inc()
+		return "", errConsumerUnauthorized
+	}
+	if ca.IsRevoked(claims.Subject) {
+		consumerAuthFailures.WithLabelValues("revoked").Inc()
+		return "", errConsumerUnauthorized
+	}
+	return jwtConsumer(claims.Subject), nil
+}
+
+// IsRevoked checks whether JWTs for the subject are currently revoked
+func (ca *consumerAuth) IsRevoked(subject string) bool {
+	ca.lk.RLock()
+	defer ca.lk.RUnlock()
+	r, ok := ca.revocations[subject]
+	return ok && time.Now().Before(r.Until)
+}
+
+// CreateKey generates a new key for a consumer, returning the key itself,
+// which isn't kept
+func (ca *consumerAuth) CreateKey(name string) (*ConsumerKey, string, error) {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, "", err
+	}
+	key := consumerKeyPrefix + strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf))
+
+	ca.lk.Lock()
+	defer ca.lk.Unlock()
+	for _, k := range ca.keys {
+		if k.Name == name {
+			return nil, "", fmt.Errorf("a key named %q already exists", name)
+		}
+	}
+	k := &ConsumerKey{
+		Name:      name,
+		KeyHash:   hashConsumerKey(key),
+		CreatedAt: time.Now().UTC(),
+	}
+	ca.keys[k.KeyHash] = k
+	return k, key, ca.flush()
+}
+
+// ListKeys returns the consumer keys, ordered by name
+func (ca *consumerAuth) ListKeys() []*ConsumerKey {
+	ca.lk.RLock()
+	defer ca.lk.RUnlock()
+	out := []*ConsumerKey{}
+	for _, k := range ca.keys {
+		out = append(out, k)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// DeleteKey revokes a consumer key. Returns false if there is no such key.
+func (ca *consumerAuth) DeleteKey(name string) (bool, error) {
+	ca.lk.Lock()
+	defer ca.lk.Unlock()
+	for h, k := range ca.keys {
+		if k.Name == name {
+			delete(ca.keys, h)
+			return true, ca.flush()
+		}
+	}
+	return false, nil
+}
+
+// Revoke blocks JWTs for a subject, replacing any existing revocation of it
+func (ca *consumerAuth) Revoke(r *ConsumerRevocation) error {
+	ca.lk.Lock()
+	defer ca.lk.Unlock()
+	ca.revocations[r.Subject] = r
+	return ca.flush()
+}
+
+// Unrevoke lifts a revocation. Returns false if the subject wasn't revoked.
+func (ca *consumerAuth) Unrevoke(subject string) (bool, error) {
+	ca.lk.Lock()
+	defer ca.lk.Unlock()
+	if _, ok := ca.revocations[subject]; !ok {
+		return false, nil
+	}
+	delete(ca.revocations, subject)
+	return true, ca.flush()
+}
+
+// ListRevocations returns the current revocations, ordered by subject
+func (ca *consumerAuth) ListRevocations() []*ConsumerRevocation {
+	ca.lk.RLock()
+	defer ca.lk.RUnlock()
+	now := time.Now()
+	out := []*ConsumerRevocation{}
+	for _, r := range ca.revocations {
+		if now.Before(r.Until) {
+			out = append(out, r)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Subject < out[j].Subject })
+	return out
+}
+
+// drops expired revocations, and writes the state out. must be called with
+// the lock held
+func (ca *consumerAuth) flush() error {
+	now := time.Now()
+	f := consumerAuthFile{
+		Keys:        []*ConsumerKey{},
+		Revocations: []*ConsumerRevocation{},
+	}
+	for _, k := range ca.keys {
+		f.Keys = append(f.Keys, k)
+	}
+	for sub, r := range ca.revocations {
+		if !now.Before(r.Until) {
+			delete(ca.revocations, sub)
+			continue
+		}
+		f.Revocations = append(f.Revocations, r)
+	}
+	if ca.path == "" {
+		return nil
+	}
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	tmp := ca.path + ".tmp"
+	// keys are only stored hashed, but the file is still private
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, ca.path)
+}
+
+// authenticateConsumer checks a consumer's credentials before its websocket
+// is opened
+func (s *Splitter) authenticateConsumer(c echo.Context) (string, error) {
+	name, err := s.consumerAuth.Authenticate(c.Request())
+	if err != nil {
+		s.log.Info("rejected consumer", "remote_addr", c.RealIP(), "user_agent", c.Request().UserAgent(), "err", err)
+		return "", echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+	return name, nil
+}
+
+// disconnectConsumers closes the connections of consumers authenticated as
+// consumer (see keyConsumer and jwtConsumer), returning how many there were
+func (s *Splitter) disconnectConsumers(consumer string) int {
+	s.consumersLk.RLock()
+	defer s.consumersLk.RUnlock()
+	n := 0
+	for _, c := range s.consumers {
+		if c.Consumer == consumer && c.disconnect != nil {
+			c.disconnect()
+			n++
+		}
+	}
+	return n
+}
+
+// HandleAdminListConsumerKeys lists the consumer keys (without the keys
+// themselves)
+func (s *Splitter) HandleAdminListConsumerKeys(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]any{
+		"keys": s.consumerAuth.ListKeys(),
+	})
+}
+
+type consumerKeyRequest struct {
+	Name string `json:"name"`
+}
+
+// HandleAdminCreateConsumerKey creates a key for a consumer. The key is only
+// returned here, and can't be retrieved later.
+func (s *Splitter) HandleAdminCreateConsumerKey(c echo.Context) error {
+	var req consumerKeyRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid key request: %s", err))
+	}
+	if req.Name == "" || len(req.Name) > 64 {
+		return echo.NewHTTPError(http.StatusBadRequest, "name is required, and at most 64 characters")
+	}
+	k, key, err := s.consumerAuth.CreateKey(req.Name)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	s.log.Info("created consumer key", "name", k.Name)
+	return c.JSON(http.StatusOK, map[string]any{
+		"name":      k.Name,
+		"key":       key,
+		"createdAt": k.CreatedAt,
+	})
+}
+
+// HandleAdminDeleteConsumerKey revokes a consumer key, disconnecting
+// consumers using it
+func (s *Splitter) HandleAdminDeleteConsumerKey(c echo.Context) error {
+	name := c.Param("name")
+	ok, err := s.consumerAuth.DeleteKey(name)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "no such consumer key")
+	}
+	n := s.disconnectConsumers(keyConsumer(name))
+	s.log.Info("revoked consumer key", "name", name, "disconnected", n)
+	return c.JSON(http.StatusOK, map[string]any{"disconnected": n})
+}
+
+// HandleAdminListConsumerRevocations lists the JWT subjects which are
+// currently revoked
+func (s *Splitter) HandleAdminListConsumerRevocations(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]any{
+		"revocations": s.consumerAuth.ListRevocations(),
+	})
+}
+
+type consumerRevocationRequest struct {
+	Subject string    `json:"subject"`
+	Until   time.Time `json:"until"`
+	Reason  string    `json:"reason,omitempty"`
+}
+
+// HandleAdminRevokeConsumer revokes JWTs for a subject, disconnecting
+// consumers using them
+func (s *Splitter) HandleAdminRevokeConsumer(c echo.Context) error {
+	var req consumerRevocationRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid revocation request: %s", err))
+	}
+	if req.Subject == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "subject is required")
+	}
+	if !req.Until.After(time.Now()) {
+		return echo.NewHTTPError(http.StatusBadRequest, "until must be in the future")
+	}
+	r := &ConsumerRevocation{
+		Subject:   req.Subject,
+		Until:     req.Until.UTC(),
+		Reason:    req.Reason,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := s.consumerAuth.Revoke(r); err != nil {
+		return err
+	}
+	n := s.disconnectConsumers(jwtConsumer(r.Subject))
+	s.log.Info("revoked consumer", "subject", r.Subject, "until", r.Until, "reason", r.Reason, "disconnected", n)
+	return c.JSON(http.StatusOK, r)
+}
+
+// HandleAdminUnrevokeConsumer lifts a revocation
+func (s *Splitter) HandleAdminUnrevokeConsumer(c echo.Context) error {
+	sub := c.Param("subject")
+	ok, err := s.consumerAuth.Unrevoke(sub)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "subject is not revoked")
+	}
+	s.log.Info("lifted consumer revocation", "subject", sub)
+	return c.NoContent(http.StatusOK)
+}
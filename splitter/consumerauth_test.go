@@ -0,0 +1,171 @@
+package splitter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	gojwt "github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func consumerRequest(cred string) *http.Request {
+	r := httptest.NewRequest("GET", "/xrpc/com.atproto.sync.subscribeRepos", nil)
+	if cred != "" {
+		r.Header.Set("Authorization", "Bearer "+cred)
+	}
+	return r
+}
+
+func testConsumerJWT(t *testing.T, secret, sub string, exp time.Time) string {
+	t.Helper()
+	tok, err := gojwt.NewWithClaims(gojwt.SigningMethodHS256, gojwt.StandardClaims{
+		Subject:   sub,
+		ExpiresAt: exp.Unix(),
+	}).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tok
+}
+
+func TestConsumerKeys(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "consumer-keys.json")
+	ca, err := newConsumerAuth(SplitterConfig{ConsumerKeysFile: path, RequireConsumerAuth: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ca.Authenticate(consumerRequest(""))
+	assert.ErrorIs(err, errConsumerUnauthorized)
+
+	k, key, err := ca.CreateKey("feedgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(strings.HasPrefix(key, consumerKeyPrefix))
+	assert.NotContains(k.KeyHash, key)
+	_, _, err = ca.CreateKey("feedgen")
+	assert.Error(err)
+
+	name, err := ca.Authenticate(consumerRequest(key))
+	assert.NoError(err)
+	assert.Equal("key:feedgen", name)
+	// browsers can't set headers on websockets
+	name, err = ca.Authenticate(httptest.NewRequest("GET", "/xrpc/com.atproto.sync.subscribeRepos?token="+key, nil))
+	assert.NoError(err)
+	assert.Equal("key:feedgen", name)
+	_, err = ca.Authenticate(consumerRequest(key + "x"))
+	assert.ErrorIs(err, errConsumerUnauthorized)
+
+	// keys are persisted
+	ca2, err := newConsumerAuth(SplitterConfig{ConsumerKeysFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, err = ca2.Authenticate(consumerRequest(key))
+	assert.NoError(err)
+	assert.Equal("key:feedgen", name)
+	assert.Len(ca2.ListKeys(), 1)
+
+	ok, err := ca.DeleteKey("feedgen")
+	assert.NoError(err)
+	assert.True(ok)
+	ok, err = ca.DeleteKey("feedgen")
+	assert.NoError(err)
+	assert.False(ok)
+	_, err = ca.Authenticate(consumerRequest(key))
+	assert.ErrorIs(err, errConsumerUnauthorized)
+}
+
+func TestConsumerJWTs(t *testing.T) {
+	assert := assert.New(t)
+	ca, err := newConsumerAuth(SplitterConfig{ConsumerJWTSecret: "sekret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// credentials are optional, unless required
+	name, err := ca.Authenticate(consumerRequest(""))
+	assert.NoError(err)
+	assert.Equal("", name)
+
+	tok := testConsumerJWT(t, "sekret", "feedgen", time.Now().Add(time.Hour))
+	name, err = ca.Authenticate(consumerRequest(tok))
+	assert.NoError(err)
+	assert.Equal("jwt:feedgen", name)
+
+	for _, bad := range []string{
+		testConsumerJWT(t, "sekret", "feedgen", time.Now().Add(-time.Minute)),
+		testConsumerJWT(t, "other", "feedgen", time.Now().Add(time.Hour)),
+		testConsumerJWT(t, "sekret", "", time.Now().Add(time.Hour)),
+		"not.a.jwt",
+	} {
+		_, err = ca.Authenticate(consumerRequest(bad))
+		assert.ErrorIs(err, errConsumerUnauthorized, bad)
+	}
+
+	assert.NoError(ca.Revoke(&ConsumerRevocation{Subject: "feedgen", Until: time.Now().Add(time.Hour)}))
+	assert.True(ca.IsRevoked("feedgen"))
+	_, err = ca.Authenticate(consumerRequest(tok))
+	assert.ErrorIs(err, errConsumerUnauthorized)
+	assert.Len(ca.ListRevocations(), 1)
+
+	ok, err := ca.Unrevoke("feedgen")
+	assert.NoError(err)
+	assert.True(ok)
+	_, err = ca.Authenticate(consumerRequest(tok))
+	assert.NoError(err)
+
+	// expired revocations lapse
+	assert.NoError(ca.Revoke(&ConsumerRevocation{Subject: "feedgen", Until: time.Now().Add(-time.Second)}))
+	assert.False(ca.IsRevoked("feedgen"))
+	assert.Empty(ca.ListRevocations())
+}
+
+func TestConsumerRevokeDisconnects(t *testing.T) {
+	assert := assert.New(t)
+	s := NewMemSplitter("localhost")
+	ca, err := newConsumerAuth(SplitterConfig{ConsumerJWTSecret: "sekret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.consumerAuth = ca
+	_, key, err := ca.CreateKey("feedgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a key consumer, and a JWT consumer with the same name
+	disconnected := map[string]bool{}
+	for _, cred := range []string{key, testConsumerJWT(t, "sekret", "feedgen", time.Now().Add(time.Hour))} {
+		name, err := ca.Authenticate(consumerRequest(cred))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.registerConsumer(&SocketConsumer{Consumer: name, disconnect: func() { disconnected[name] = true }})
+	}
+
+	e := echo.New()
+	e.DELETE("/admin/consumer-keys/:name", s.HandleAdminDeleteConsumerKey)
+	e.POST("/admin/consumer-revocations", s.HandleAdminRevokeConsumer)
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("DELETE", "/admin/consumer-keys/feedgen", nil))
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.JSONEq(`{"disconnected": 1}`, rec.Body.String())
+	assert.Equal(map[string]bool{"key:feedgen": true}, disconnected)
+
+	body := `{"subject": "feedgen", "until": "` + time.Now().Add(time.Hour).UTC().Format(time.RFC3339) + `"}`
+	req := httptest.NewRequest("POST", "/admin/consumer-revocations", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(map[string]bool{"key:feedgen": true, "jwt:feedgen": true}, disconnected)
+}
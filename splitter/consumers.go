@@ -0,0 +1,203 @@
+package splitter
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	events "github.com/bluesky-social/indigo/events"
+
+	"github.com/labstack/echo/v4"
+)
+
+// consumerProgress is what a consumer has been sent so far. It is updated by
+// the consumer's connection, and read by the admin API.
+type consumerProgress struct {
+	messages atomic.Int64
+	bytes    atomic.Int64
+	// sequence number, and upstream time (unix micros), of the last event
+	// sent. zero until an event has been sent
+	lastSeq  atomic.Int64
+	lastTime atomic.Int64
+	// times the consumer was sent back to replay from the cache for
+	// falling behind
+	replays atomic.Int64
+}
+
+func (p *consumerProgress) sent(evt *events.XRPCStreamEvent, msgs, bytes int) {
+	p.messages.Add(int64(msgs))
+	p.bytes.Add(int64(bytes))
+	if seq := evt.Sequence(); seq > 0 {
+		p.lastSeq.Store(seq)
+		p.lastTime.Store(eventTime(evt).UnixMicro())
+	}
+}
+
+// ConsumerInfo describes a connected consumer, for the admin API
+type ConsumerInfo struct {
+	ID         uint64 `json:"id"`
+	RemoteAddr string `json:"remoteAddr"`
+	UserAgent  string `json:"userAgent"`
+	// key name or JWT subject, for authenticated consumers
+	Consumer string `json:"consumer,omitempty"`
+	// endpoint the consumer is subscribed to
+	Path        string    `json:"path"`
+	Encoding    string    `json:"encoding"`
+	ConnectedAt time.Time `json:"connectedAt"`
+	// cursor the consumer connected with, if any
+	Cursor *int64 `json:"cursor,omitempty"`
+	// websocket messages and payload bytes sent (before any
+	// permessage-deflate compression)
+	MessagesSent int64 `json:"messagesSent"`
+	BytesSent    int64 `json:"bytesSent"`
+	// sequence number of the last event sent. zero if none yet
+	LastSeq int64 `json:"lastSeq"`
+	// number of cached events the consumer has yet to be sent, and how far
+	// behind the upstream the last event sent was
+	LagEvents  int64   `json:"lagEvents"`
+	LagSeconds float64 `json:"lagSeconds"`
+	// times the consumer fell behind and was replayed from the cache (see
+	// SlowConsumerReplay)
+	Replays int64 `json:"replays"`
+}
+
+// listConsumers returns the connected consumers, oldest connection first.
+// head is the most recently cached sequence number, to compute lag from.
+func (s *Splitter) listConsumers(head int64) []ConsumerInfo {
+	s.consumersLk.RLock()
+	out := make([]ConsumerInfo, 0, len(s.consumers))
+	for id, c := range s.consumers {
+		info := ConsumerInfo{
+			ID:           id,
+			RemoteAddr:   c.RemoteAddr,
+			UserAgent:    c.UserAgent,
+			Consumer:     c.Consumer,
+			Path:         c.Path,
+			Encoding:     c.Encoding,
+			ConnectedAt:  c.ConnectedAt,
+			Cursor:       c.Cursor,
+			MessagesSent: c.progress.messages.Load(),
+			BytesSent:    c.progress.bytes.Load(),
+			LastSeq:      c.progress.lastSeq.Load(),
+			Replays:      c.progress.replays.Load(),
+		}
+		// consumers which haven't been sent anything yet are behind from
+		// their cursor (or, without one, are waiting for the next event)
+		from := info.LastSeq
+		if from == 0 && c.Cursor != nil {
+			from = *c.Cursor
+		}
+		if from > 0 && head > from {
+			info.LagEvents = head - from
+		}
+		if t := c.progress.lastTime.Load(); t > 0 && info.LagEvents > 0 {
+			info.LagSeconds = time.Since(time.UnixMicro(t)).Seconds()
+		}
+		out = append(out, info)
+	}
+	s.consumersLk.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// HandleAdminListConsumers lists connected consumers, with their progress
+func (s *Splitter) HandleAdminListConsumers(c echo.Context) error {
+	head, err := s.headSeq(c.Request().Context())
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, map[string]any{
+		"head":      head,
+		"consumers": s.listConsumers(head),
+	})
+}
+
+// HandleAdminDisconnectConsumer closes a consumer's connection, by ID. The
+// consumer is free to reconnect.
+func (s *Splitter) HandleAdminDisconnectConsumer(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid consumer id")
+	}
+	s.consumersLk.RLock()
+	sc, ok := s.consumers[id]
+	s.consumersLk.RUnlock()
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "consumer not connected")
+	}
+	sc.disconnect()
+	s.log.Info("disconnected consumer", "consumer_id", id, "remote_addr", sc.RemoteAddr, "consumer", sc.Consumer)
+	return c.JSON(http.StatusOK, map[string]any{"disconnected": id})
+}
+
+// UpstreamStatus describes the connection to the upstream firehose
+type UpstreamStatus struct {
+	Host      string `json:"host"`
+	Connected bool   `json:"connected"`
+	// when the current connection was opened, or the last one was closed
+	Since *time.Time `json:"since,omitempty"`
+	// sequence number, and receipt time, of the last upstream event
+	LastSeq     int64      `json:"lastSeq"`
+	LastEventAt *time.Time `json:"lastEventAt,omitempty"`
+	// connections opened since startup, and failed dial attempts
+	Connections int    `json:"connections"`
+	DialErrors  int    `json:"dialErrors"`
+	LastError   string `json:"lastError,omitempty"`
+}
+
+// upstreamState tracks the upstream connection, for the admin API
+type upstreamState struct {
+	lk     sync.Mutex
+	status UpstreamStatus
+}
+
+func (u *upstreamState) connected(host string) {
+	u.lk.Lock()
+	defer u.lk.Unlock()
+	now := time.Now()
+	u.status.Host = host
+	u.status.Connected = true
+	u.status.Since = &now
+	u.status.Connections++
+}
+
+func (u *upstreamState) disconnected(err error) {
+	u.lk.Lock()
+	defer u.lk.Unlock()
+	now := time.Now()
+	u.status.Connected = false
+	u.status.Since = &now
+	if err != nil {
+		u.status.LastError = err.Error()
+	}
+}
+
+func (u *upstreamState) dialFailed(host string, err error) {
+	u.lk.Lock()
+	defer u.lk.Unlock()
+	u.status.Host = host
+	u.status.DialErrors++
+	u.status.LastError = err.Error()
+}
+
+func (u *upstreamState) event(seq int64) {
+	u.lk.Lock()
+	defer u.lk.Unlock()
+	now := time.Now()
+	u.status.LastSeq = seq
+	u.status.LastEventAt = &now
+}
+
+func (u *upstreamState) get() UpstreamStatus {
+	u.lk.Lock()
+	defer u.lk.Unlock()
+	return u.status
+}
+
+// HandleAdminUpstreamStatus reports the state of the upstream connection
+func (s *Splitter) HandleAdminUpstreamStatus(c echo.Context) error {
+	return c.JSON(http.StatusOK, s.upstream.get())
+}
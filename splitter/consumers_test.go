@@ -0,0 +1,159 @@
+package splitter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+type testConsumerList struct {
+	Head      int64          `json:"head"`
+	Consumers []ConsumerInfo `json:"consumers"`
+}
+
+func TestAdminConsumers(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	s := NewMemSplitter("localhost")
+	e := echo.New()
+	e.GET("/xrpc/com.atproto.sync.subscribeRepos", s.EventsHandler)
+	e.GET("/admin/consumers", s.HandleAdminListConsumers)
+	e.DELETE("/admin/consumers/:id", s.HandleAdminDisconnectConsumer)
+	e.POST("/admin/consumers/:id/rewind", s.HandleAdminRewindConsumer)
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	var frames [][]byte
+	for seq := int64(1); seq <= 3; seq++ {
+		evt := testIdentityEvent(seq, "did:example:abc")
+		var buf bytes.Buffer
+		if err := evt.Serialize(&buf); err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, buf.Bytes())
+		if err := s.events.AddEvent(ctx, evt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list := func() *testConsumerList {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest("GET", "/admin/consumers", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("listing consumers: %d %s", rec.Code, rec.Body.String())
+		}
+		var out testConsumerList
+		if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+			t.Fatal(err)
+		}
+		return &out
+	}
+	admin := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/xrpc/com.atproto.sync.subscribeRepos"
+	replay, _, err := websocket.DefaultDialer.Dial(url+"?cursor=0", http.Header{"User-Agent": []string{"replay-agent"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replay.Close()
+	replay.SetReadDeadline(time.Now().Add(5 * time.Second))
+	read := func(con *websocket.Conn) []byte {
+		_, msg, err := con.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+	for _, frame := range frames {
+		assert.Equal(frame, read(replay))
+	}
+
+	live, _, err := websocket.DefaultDialer.Dial(url, http.Header{"User-Agent": []string{"live-agent"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer live.Close()
+
+	// progress is updated after each write, so wait for it to catch up
+	var cl *testConsumerList
+	for i := 0; ; i++ {
+		cl = list()
+		if len(cl.Consumers) == 2 && cl.Consumers[0].LastSeq == 3 {
+			break
+		}
+		if i > 100 {
+			t.Fatalf("consumers not listed: %+v", cl)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.Equal(int64(3), cl.Head)
+	rc, lc := cl.Consumers[0], cl.Consumers[1]
+	assert.Less(rc.ID, lc.ID)
+	assert.Equal("replay-agent", rc.UserAgent)
+	assert.Equal("/xrpc/com.atproto.sync.subscribeRepos", rc.Path)
+	assert.Equal(encodingNone, rc.Encoding)
+	if assert.NotNil(rc.Cursor) {
+		assert.Equal(int64(0), *rc.Cursor)
+	}
+	assert.Equal(int64(3), rc.MessagesSent)
+	assert.Equal(int64(len(frames[0])+len(frames[1])+len(frames[2])), rc.BytesSent)
+	assert.Equal(int64(0), rc.LagEvents)
+	assert.Equal("live-agent", lc.UserAgent)
+	assert.Nil(lc.Cursor)
+	assert.Equal(int64(0), lc.MessagesSent)
+
+	// rewinding replays the cache from the cursor, on the same connection
+	rec := admin("POST", fmt.Sprintf("/admin/consumers/%d/rewind?cursor=1", rc.ID))
+	assert.Equal(http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(frames[1], read(replay))
+	assert.Equal(frames[2], read(replay))
+
+	for path, code := range map[string]int{
+		fmt.Sprintf("/admin/consumers/%d/rewind?cursor=4", rc.ID):   http.StatusBadRequest,
+		fmt.Sprintf("/admin/consumers/%d/rewind?cursor=abc", rc.ID): http.StatusBadRequest,
+		"/admin/consumers/abc/rewind?cursor=1":                      http.StatusBadRequest,
+		"/admin/consumers/999/rewind?cursor=1":                      http.StatusNotFound,
+	} {
+		assert.Equal(code, admin("POST", path).Code, path)
+	}
+
+	// disconnecting closes the connection, and the consumer is unlisted
+	rec = admin("DELETE", fmt.Sprintf("/admin/consumers/%d", lc.ID))
+	assert.Equal(http.StatusOK, rec.Code, rec.Body.String())
+	live.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = live.ReadMessage()
+	var ne interface{ Timeout() bool }
+	if errors.As(err, &ne) && ne.Timeout() {
+		t.Fatal("disconnected consumer's connection still open")
+	}
+	assert.Error(err)
+
+	for i := 0; len(list().Consumers) != 1; i++ {
+		if i > 100 {
+			t.Fatal("disconnected consumer still listed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(rc.ID, list().Consumers[0].ID)
+
+	assert.Equal(http.StatusNotFound, admin("DELETE", fmt.Sprintf("/admin/consumers/%d", lc.ID)).Code)
+	assert.Equal(http.StatusBadRequest, admin("DELETE", "/admin/consumers/abc").Code)
+}
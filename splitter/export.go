@@ -0,0 +1,232 @@
+package splitter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	events "github.com/bluesky-social/indigo/events"
+	"github.com/labstack/echo/v4"
+)
+
+// Event export files are a sequence of frames, each a uvarint length prefix
+// followed by a single event serialized exactly as it is sent over
+// subscribeRepos (CBOR header object then CBOR body object). This is the same
+// section framing as CAR files, without the CAR header.
+
+// Maximum size of a single frame in an event export file
+const maxExportFrameSize = 16 << 20
+
+var errExportDone = errors.New("export reached end of range")
+
+// ExportStats summarizes an event export or import
+type ExportStats struct {
+	Events int   `json:"events"`
+	Bytes  int64 `json:"bytes"`
+	// FirstSeq and LastSeq are -1 if no events were processed
+	FirstSeq int64 `json:"firstSeq"`
+	LastSeq  int64 `json:"lastSeq"`
+	// Events which were skipped on import because they were already present
+	Skipped int `json:"skipped,omitempty"`
+}
+
+// WriteEventFrame writes a single event to an export stream
+func WriteEventFrame(w io.Writer, evt *events.XRPCStreamEvent) (int, error) {
+	if err := evt.Preserialize(); err != nil {
+		return 0, err
+	}
+	var lbuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lbuf[:], uint64(len(evt.Preserialized)))
+	if _, err := w.Write(lbuf[:n]); err != nil {
+		return 0, err
+	}
+	if _, err := w.Write(evt.Preserialized); err != nil {
+		return 0, err
+	}
+	return n + len(evt.Preserialized), nil
+}
+
+// ReadEventFrame reads a single event from an export stream. Returns io.EOF at
+// the end of the stream.
+func ReadEventFrame(r *bufio.Reader) (*events.XRPCStreamEvent, error) {
+	l, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if l > maxExportFrameSize {
+		return nil, fmt.Errorf("export frame too large: %d bytes", l)
+	}
+	buf := make([]byte, l)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, fmt.Errorf("reading export frame: %w", err)
+	}
+	evt := new(events.XRPCStreamEvent)
+	if err := evt.Deserialize(bytes.NewReader(buf)); err != nil {
+		return nil, fmt.Errorf("decoding export frame: %w", err)
+	}
+	evt.Preserialized = buf
+	return evt, nil
+}
+
+// playback iterates over cached events with sequence number >= since
+func (s *Splitter) playback(ctx context.Context, since int64, cb func(*events.XRPCStreamEvent) error) error {
+	if s.pp != nil {
+		return s.pp.Playback(ctx, since, cb)
+	}
+	// the ring buffer treats since as exclusive
+	return s.erb.Playback(ctx, since-1, cb)
+}
+
+// ExportEvents writes cached events with sequence numbers in the inclusive
+// range [since, until] to w. An until of zero or less means "through the
+// current head".
+func (s *Splitter) ExportEvents(ctx context.Context, w io.Writer, since, until int64) (*ExportStats, error) {
+	stats := &ExportStats{FirstSeq: -1, LastSeq: -1}
+	err := s.playback(ctx, since, func(evt *events.XRPCStreamEvent) error {
+		seq := events.SequenceForEvent(evt)
+		if seq < since {
+			return nil
+		}
+		if until > 0 && seq > until {
+			return errExportDone
+		}
+		n, err := WriteEventFrame(w, evt)
+		if err != nil {
+			return err
+		}
+		if stats.FirstSeq < 0 {
+			stats.FirstSeq = seq
+		}
+		stats.LastSeq = seq
+		stats.Events++
+		stats.Bytes += int64(n)
+		exportEventsCounter.WithLabelValues("export").Inc()
+		return nil
+	})
+	if errors.Is(err, errExportDone) {
+		err = nil
+	}
+	return stats, err
+}
+
+// ImportEvents reads events from an export stream and adds them to the event
+// cache, from which they are served to consumers like any other event. Events
+// at or before the current head are skipped, and sequence numbers must be
+// strictly increasing.
+//
+// Note that the upstream cursor is not updated: importing into an instance
+// which is also subscribed upstream only makes sense for filling in history
+// ahead of the live stream, or on an isolated (test) instance.
+func (s *Splitter) ImportEvents(ctx context.Context, r io.Reader) (*ExportStats, error) {
+	stats := &ExportStats{FirstSeq: -1, LastSeq: -1}
+	head, err := s.headSeq(ctx)
+	if err != nil {
+		return stats, err
+	}
+
+	br := bufio.NewReader(r)
+	last := head
+	for {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		evt, err := ReadEventFrame(br)
+		if err == io.EOF {
+			return stats, nil
+		}
+		if err != nil {
+			return stats, err
+		}
+
+		seq := events.SequenceForEvent(evt)
+		if seq < 0 {
+			// ignore info events and other unsupported types
+			continue
+		}
+		if seq <= last {
+			if seq <= head {
+				stats.Skipped++
+				continue
+			}
+			return stats, fmt.Errorf("out-of-order event in import (seq %d after %d)", seq, last)
+		}
+
+		if err := s.events.AddEvent(ctx, evt); err != nil {
+			return stats, err
+		}
+		if stats.FirstSeq < 0 {
+			stats.FirstSeq = seq
+		}
+		stats.LastSeq = seq
+		stats.Events++
+		stats.Bytes += int64(len(evt.Preserialized))
+		last = seq
+		exportEventsCounter.WithLabelValues("import").Inc()
+	}
+}
+
+// checkAdminAuth requires the configured admin token as a bearer token. Admin
+// routes are only registered when a token is configured.
+func (s *Splitter) checkAdminAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		authheader := c.Request().Header.Get("Authorization")
+		pref := "Bearer "
+		if !strings.HasPrefix(authheader, pref) || subtle.ConstantTimeCompare([]byte(authheader[len(pref):]), []byte(s.conf.AdminToken)) != 1 {
+			return echo.ErrForbidden
+		}
+		return next(c)
+	}
+}
+
+func (s *Splitter) HandleAdminExportEvents(c echo.Context) error {
+	var since, until int64
+	var err error
+	if q := c.QueryParam("since"); q != "" {
+		since, err = strconv.ParseInt(q, 10, 64)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid since: %s", err))
+		}
+	}
+	if q := c.QueryParam("until"); q != "" {
+		until, err = strconv.ParseInt(q, 10, 64)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid until: %s", err))
+		}
+	}
+
+	resp := c.Response()
+	resp.Header().Set(echo.HeaderContentType, echo.MIMEOctetStream)
+	resp.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=\"rainbow-events-%d.bin\"", since))
+	resp.WriteHeader(http.StatusOK)
+
+	bw := bufio.NewWriter(resp)
+	stats, err := s.ExportEvents(c.Request().Context(), bw, since, until)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		// headers are already sent, so all we can do is log and truncate the response
+		s.log.Error("event export failed", "since", since, "until", until, "events", stats.Events, "err", err)
+		return nil
+	}
+	s.log.Info("exported events", "since", since, "until", until, "events", stats.Events, "firstSeq", stats.FirstSeq, "lastSeq", stats.LastSeq)
+	return nil
+}
+
+func (s *Splitter) HandleAdminImportEvents(c echo.Context) error {
+	stats, err := s.ImportEvents(c.Request().Context(), c.Request().Body)
+	if err != nil {
+		s.log.Error("event import failed", "events", stats.Events, "err", err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("import failed after %d events: %s", stats.Events, err))
+	}
+	s.log.Info("imported events", "events", stats.Events, "skipped", stats.Skipped, "firstSeq", stats.FirstSeq, "lastSeq", stats.LastSeq)
+	return c.JSON(http.StatusOK, stats)
+}
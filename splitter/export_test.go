@@ -0,0 +1,118 @@
+package splitter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventFrameRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+
+	buf := new(bytes.Buffer)
+	for i := int64(1); i <= 3; i++ {
+		n, err := WriteEventFrame(buf, testIdentityEvent(i, "did:example:abc"))
+		assert.NoError(err)
+		assert.Greater(n, 0)
+	}
+
+	br := bufio.NewReader(buf)
+	for i := int64(1); i <= 3; i++ {
+		evt, err := ReadEventFrame(br)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(i, evt.Sequence())
+		assert.Equal("did:example:abc", evt.RepoIdentity.Did)
+	}
+	_, err := ReadEventFrame(br)
+	assert.Equal(io.EOF, err)
+}
+
+func TestExportImportEvents(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	src := newTestDiskSplitter(t)
+	for i := int64(1); i <= 5; i++ {
+		if err := src.events.AddEvent(ctx, testIdentityEvent(i, "did:example:abc")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	stats, err := src.ExportEvents(ctx, buf, 2, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(3, stats.Events)
+	assert.Equal(int64(2), stats.FirstSeq)
+	assert.Equal(int64(4), stats.LastSeq)
+	assert.Equal(int64(buf.Len()), stats.Bytes)
+
+	dst := newTestDiskSplitter(t)
+	stats, err = dst.ImportEvents(ctx, bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(3, stats.Events)
+	assert.Equal(int64(2), stats.FirstSeq)
+	assert.Equal(int64(4), stats.LastSeq)
+
+	seq, _, _, err := dst.pp.GetLast(ctx)
+	assert.NoError(err)
+	assert.Equal(int64(4), seq)
+
+	// importing the same events again skips all of them
+	stats, err = dst.ImportEvents(ctx, bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(0, stats.Events)
+	assert.Equal(3, stats.Skipped)
+
+	// the round trip preserves the events byte for byte
+	out := new(bytes.Buffer)
+	_, err = dst.ExportEvents(ctx, out, 0, 0)
+	assert.NoError(err)
+	assert.Equal(buf.Bytes(), out.Bytes())
+}
+
+func TestSplitterAdminAuth(t *testing.T) {
+	assert := assert.New(t)
+
+	s := newTestDiskSplitter(t)
+	s.conf.AdminToken = "secret"
+
+	e := echo.New()
+	e.GET("/admin/ping", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}, s.checkAdminAuth)
+
+	for _, tc := range []struct {
+		auth string
+		code int
+	}{
+		{"", http.StatusForbidden},
+		{"Bearer wrong", http.StatusForbidden},
+		{"secret", http.StatusForbidden},
+		{"Bearer secret", http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/admin/ping", nil)
+		if tc.auth != "" {
+			req.Header.Set("Authorization", tc.auth)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		assert.Equal(tc.code, rec.Code, "auth %q", tc.auth)
+	}
+}
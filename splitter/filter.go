@@ -0,0 +1,130 @@
+package splitter
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	events "github.com/bluesky-social/indigo/events"
+)
+
+const (
+	maxWantedCollections = 100
+	maxWantedDids        = 10_000
+)
+
+// wantedFilter limits the events sent to a consumer to those it asked for,
+// with the "wantedCollections" and "wantedDids" query parameters (each may be
+// repeated). Filtering on the server saves bandwidth for consumers which only
+// care about a few collections or accounts.
+//
+// Commit events are sent whole if any of their ops match a wanted collection,
+// since removing ops would invalidate the commit. Other events (identity,
+// account, sync) aren't about a collection, so they're only filtered by DID.
+type wantedFilter struct {
+	dids        map[string]bool
+	collections map[string]bool
+	// NSID prefixes, from collections like "app.bsky.graph.*"
+	prefixes []string
+}
+
+// parseWantedFilter returns nil if the query doesn't ask for any filtering
+func parseWantedFilter(q url.Values) (*wantedFilter, error) {
+	colls, dids := q["wantedCollections"], q["wantedDids"]
+	if len(colls) == 0 && len(dids) == 0 {
+		return nil, nil
+	}
+	if len(colls) > maxWantedCollections {
+		return nil, fmt.Errorf("too many wantedCollections (max %d)", maxWantedCollections)
+	}
+	if len(dids) > maxWantedDids {
+		return nil, fmt.Errorf("too many wantedDids (max %d)", maxWantedDids)
+	}
+
+	f := &wantedFilter{}
+	if len(dids) > 0 {
+		f.dids = make(map[string]bool, len(dids))
+		for _, d := range dids {
+			did, err := syntax.ParseDID(d)
+			if err != nil {
+				return nil, fmt.Errorf("invalid wantedDids: %w", err)
+			}
+			f.dids[did.String()] = true
+		}
+	}
+	if len(colls) > 0 {
+		f.collections = make(map[string]bool, len(colls))
+		for _, c := range colls {
+			if prefix, ok := strings.CutSuffix(c, ".*"); ok {
+				// the prefix must be at least a domain authority, eg "app.bsky"
+				if _, err := syntax.ParseNSID(prefix + ".x"); err != nil || !strings.Contains(prefix, ".") {
+					return nil, fmt.Errorf("invalid wantedCollections prefix: %s", c)
+				}
+				f.prefixes = append(f.prefixes, prefix+".")
+				continue
+			}
+			nsid, err := syntax.ParseNSID(c)
+			if err != nil {
+				return nil, fmt.Errorf("invalid wantedCollections: %w", err)
+			}
+			f.collections[nsid.String()] = true
+		}
+	}
+	return f, nil
+}
+
+func (f *wantedFilter) wantCollection(coll string) bool {
+	if f.collections[coll] {
+		return true
+	}
+	for _, p := range f.prefixes {
+		if strings.HasPrefix(coll, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match checks whether the event should be sent to the consumer
+func (f *wantedFilter) Match(evt *events.XRPCStreamEvent) bool {
+	if f.dids != nil {
+		if did := evt.Repo(); did != "" && !f.dids[did] {
+			return false
+		}
+	}
+	if f.collections != nil && evt.RepoCommit != nil {
+		for _, op := range evt.RepoCommit.Ops {
+			coll, _, _ := strings.Cut(op.Path, "/")
+			if f.wantCollection(coll) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// consumerFilter returns the subscription filter for a consumer's query
+// parameters: "muted" (see mutedFilter), and "wantedCollections" and
+// "wantedDids" (see wantedFilter)
+func (s *Splitter) consumerFilter(q url.Values) (func(*events.XRPCStreamEvent) bool, error) {
+	muted, err := s.mutedFilter(q.Get("muted"))
+	if err != nil {
+		return nil, err
+	}
+	wanted, err := parseWantedFilter(q)
+	if err != nil {
+		return nil, err
+	}
+	if wanted == nil {
+		return muted, nil
+	}
+	return func(evt *events.XRPCStreamEvent) bool {
+		if !wanted.Match(evt) {
+			wantedFilteredCounter.Inc()
+			return false
+		}
+		return muted(evt)
+	}, nil
+}
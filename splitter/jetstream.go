@@ -0,0 +1,265 @@
+package splitter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/data"
+	events "github.com/bluesky-social/indigo/events"
+
+	"github.com/gorilla/websocket"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
+	"github.com/labstack/echo/v4"
+)
+
+// JetstreamEvent is an event on the JSON stream (see JetstreamHandler), in
+// the format used by Jetstream: records are decoded, and each commit op is a
+// separate event.
+type JetstreamEvent struct {
+	Did string `json:"did,omitempty"`
+	// When the upstream sequenced the event, in unix microseconds. This is
+	// the stream's cursor. Not set for errors
+	TimeUS int64 `json:"time_us,omitempty"`
+	// "commit", "identity", "account", or "error" (sent before rainbow
+	// closes the connection, eg for a consumer which fell too far behind)
+	Kind     string                               `json:"kind"`
+	Commit   *JetstreamCommit                     `json:"commit,omitempty"`
+	Identity *atproto.SyncSubscribeRepos_Identity `json:"identity,omitempty"`
+	Account  *atproto.SyncSubscribeRepos_Account  `json:"account,omitempty"`
+	// the error name (eg, "ConsumerTooSlow") and message, for errors
+	Error   string `json:"error,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type JetstreamCommit struct {
+	Rev string `json:"rev"`
+	// "create", "update", or "delete"
+	Operation  string `json:"operation"`
+	Collection string `json:"collection"`
+	RKey       string `json:"rkey"`
+	// Not set for deletes
+	Record map[string]any `json:"record,omitempty"`
+	CID    string         `json:"cid,omitempty"`
+}
+
+// jetstreamFrame is a JetstreamEvent, encoded
+type jetstreamFrame struct {
+	// empty for events other than commits
+	collection string
+	msg        []byte
+}
+
+// recently converted events, by sequence number, so that each is only
+// converted once however many consumers there are
+type jetstreamCache = lru.Cache[int64, []jetstreamFrame]
+
+func newJetstreamCache() *jetstreamCache {
+	c, _ := lru.New[int64, []jetstreamFrame](10_000)
+	return c
+}
+
+// eventTime returns when the upstream sequenced an event, falling back to now
+func eventTime(evt *events.XRPCStreamEvent) time.Time {
+	var ts string
+	switch {
+	case evt.RepoCommit != nil:
+		ts = evt.RepoCommit.Time
+	case evt.RepoIdentity != nil:
+		ts = evt.RepoIdentity.Time
+	case evt.RepoAccount != nil:
+		ts = evt.RepoAccount.Time
+	}
+	t, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return time.Now()
+	}
+	return t
+}
+
+// jetstreamEvents converts a firehose event to JSON stream events. Events
+// which have no JSON equivalent (eg, #sync) convert to none.
+func jetstreamEvents(evt *events.XRPCStreamEvent) ([]*JetstreamEvent, error) {
+	if evt.Error != nil {
+		return []*JetstreamEvent{{Kind: "error", Error: evt.Error.Error, Message: evt.Error.Message}}, nil
+	}
+	ts := eventTime(evt).UnixMicro()
+	switch {
+	case evt.RepoCommit != nil:
+		commit := evt.RepoCommit
+		blocks, err := carBlocks(commit.Blocks)
+		if err != nil {
+			return nil, fmt.Errorf("reading commit blocks: %w", err)
+		}
+		out := make([]*JetstreamEvent, 0, len(commit.Ops))
+		for _, op := range commit.Ops {
+			coll, rkey, ok := strings.Cut(op.Path, "/")
+			if !ok {
+				continue
+			}
+			jc := &JetstreamCommit{
+				Rev:        commit.Rev,
+				Operation:  op.Action,
+				Collection: coll,
+				RKey:       rkey,
+			}
+			if op.Cid != nil && op.Action != "delete" {
+				c := cid.Cid(*op.Cid)
+				jc.CID = c.String()
+				// records missing from the blocks are sent without a body
+				if b, ok := blocks[c]; ok {
+					rec, err := data.UnmarshalCBOR(b)
+					if err != nil {
+						return nil, fmt.Errorf("decoding record %s: %w", op.Path, err)
+					}
+					jc.Record = rec
+				}
+			}
+			out = append(out, &JetstreamEvent{Did: commit.Repo, TimeUS: ts, Kind: "commit", Commit: jc})
+		}
+		return out, nil
+	case evt.RepoIdentity != nil:
+		return []*JetstreamEvent{{Did: evt.RepoIdentity.Did, TimeUS: ts, Kind: "identity", Identity: evt.RepoIdentity}}, nil
+	case evt.RepoAccount != nil:
+		return []*JetstreamEvent{{Did: evt.RepoAccount.Did, TimeUS: ts, Kind: "account", Account: evt.RepoAccount}}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// carBlocks reads the blocks in a CAR slice
+func carBlocks(b []byte) (map[cid.Cid][]byte, error) {
+	out := make(map[cid.Cid][]byte)
+	if len(b) == 0 {
+		return out, nil
+	}
+	cr, err := car.NewCarReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	for {
+		blk, err := cr.Next()
+		if errors.Is(err, io.EOF) {
+			return out, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		out[blk.Cid()] = blk.RawData()
+	}
+}
+
+// jetstreamFrames returns the encoded JSON stream events for a firehose event
+func (s *Splitter) jetstreamFrames(evt *events.XRPCStreamEvent) ([]jetstreamFrame, error) {
+	seq := evt.Sequence()
+	if frames, ok := s.jsonCache.Get(seq); ok && seq > 0 {
+		return frames, nil
+	}
+	jevts, err := jetstreamEvents(evt)
+	if err != nil {
+		return nil, err
+	}
+	frames := make([]jetstreamFrame, 0, len(jevts))
+	for _, je := range jevts {
+		msg, err := json.Marshal(je)
+		if err != nil {
+			return nil, err
+		}
+		f := jetstreamFrame{msg: msg}
+		if je.Commit != nil {
+			f.collection = je.Commit.Collection
+		}
+		frames = append(frames, f)
+	}
+	if seq > 0 {
+		s.jsonCache.Add(seq, frames)
+	}
+	return frames, nil
+}
+
+// JetstreamHandler streams events as JSON, in the format used by Jetstream,
+// for consumers which don't want to decode CBOR and CAR slices. It takes the
+// same wantedCollections, wantedDids, and muted query parameters as
+// subscribeRepos. Unlike subscribeRepos, commit ops in collections which
+// weren't asked for are dropped.
+//
+// The cursor is a time_us value. Events are replayed from the start of the
+// cache window, skipping those before the cursor, so reconnecting with a
+// cursor can be slow for a large window. Events with the same time_us as the
+// cursor are sent again.
+//
+// As with Jetstream, consumers can ask for zstd compressed messages with
+// compress=true; these are sent as binary messages.
+func (s *Splitter) JetstreamHandler(c echo.Context) error {
+	var cursor int64
+	if v := c.QueryParam("cursor"); v != "" {
+		cv, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || cv < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid cursor: expected time_us")
+		}
+		cursor = cv
+	}
+	filter, err := s.consumerFilter(c.QueryParams())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	wanted, err := parseWantedFilter(c.QueryParams())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	useZstd := wantsZstd(c.Request())
+
+	cs := &consumerStream{
+		filter: filter,
+		zstd:   useZstd,
+		write: func(conn *websocket.Conn, evt *events.XRPCStreamEvent) (int, int, error) {
+			// the event is sent converted, never in its wire form
+			defer events.ReleaseEvent(evt)
+			frames, err := s.jetstreamFrames(evt)
+			if err != nil {
+				// one bad event shouldn't disconnect the consumer
+				s.log.Warn("failed to convert event to JSON", "seq", evt.Sequence(), "err", err)
+				jetstreamErrorsCounter.Inc()
+				return 0, 0, nil
+			}
+			n, size := 0, 0
+			for i, f := range frames {
+				if wanted != nil && f.collection != "" && wanted.collections != nil && !wanted.wantCollection(f.collection) {
+					continue
+				}
+				msgType, msg := websocket.TextMessage, f.msg
+				if useZstd {
+					msgType = websocket.BinaryMessage
+					msg = s.zstd.compress(zstdKey{json: true, seq: evt.Sequence(), idx: i}, f.msg)
+				}
+				if err := conn.WriteMessage(msgType, msg); err != nil {
+					return n, size, fmt.Errorf("failed to write event: %w", err)
+				}
+				n++
+				size += len(msg)
+			}
+			return n, size, nil
+		},
+	}
+	if cursor > 0 {
+		replayFrom := int64(0)
+		cs.since = &replayFrom
+		cs.filter = func(evt *events.XRPCStreamEvent) bool {
+			if eventTime(evt).UnixMicro() < cursor {
+				return false
+			}
+			return filter(evt)
+		}
+	}
+	return s.serveConsumer(c, cs)
+}
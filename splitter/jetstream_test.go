@@ -0,0 +1,191 @@
+package splitter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/bluesky-social/indigo/events"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+
+	"github.com/gorilla/websocket"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	"github.com/labstack/echo/v4"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/assert"
+)
+
+type testOp struct {
+	action string
+	path   string
+	// record text, for creates and updates
+	text string
+}
+
+// testCommitEvent returns a commit event with the ops, and their records in
+// the CAR slice
+func testCommitEvent(t *testing.T, seq int64, did string, ts time.Time, ops ...testOp) *events.XRPCStreamEvent {
+	t.Helper()
+	blocks := new(bytes.Buffer)
+	root, err := cid.NewPrefixV1(cid.DagCBOR, multihash.SHA2_256).Sum([]byte("commit"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := car.WriteHeader(&car.CarHeader{Roots: []cid.Cid{root}, Version: 1}, blocks); err != nil {
+		t.Fatal(err)
+	}
+	commit := &atproto.SyncSubscribeRepos_Commit{
+		Repo:   did,
+		Rev:    fmt.Sprintf("rev%d", seq),
+		Seq:    seq,
+		Time:   ts.UTC().Format(time.RFC3339Nano),
+		Commit: lexutil.LexLink(root),
+		Blobs:  []lexutil.LexLink{},
+	}
+	for _, op := range ops {
+		rop := &atproto.SyncSubscribeRepos_RepoOp{Action: op.action, Path: op.path}
+		if op.action != "delete" {
+			rec, err := data.MarshalCBOR(map[string]any{"$type": "app.bsky.feed.post", "text": op.text})
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := cid.NewPrefixV1(cid.DagCBOR, multihash.SHA2_256).Sum(rec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := carutil.LdWrite(blocks, c.Bytes(), rec); err != nil {
+				t.Fatal(err)
+			}
+			link := lexutil.LexLink(c)
+			rop.Cid = &link
+		}
+		commit.Ops = append(commit.Ops, rop)
+	}
+	commit.Blocks = blocks.Bytes()
+	return &events.XRPCStreamEvent{RepoCommit: commit}
+}
+
+func TestJetstreamEvents(t *testing.T) {
+	assert := assert.New(t)
+	s := NewMemSplitter("localhost")
+
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	evt := testCommitEvent(t, 10, "did:example:abc", ts,
+		testOp{"create", "app.bsky.feed.post/1", "hello"},
+		testOp{"update", "app.bsky.feed.post/2", "edited"},
+		testOp{"delete", "app.bsky.feed.like/3", ""},
+	)
+	frames, err := s.jetstreamFrames(evt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !assert.Len(frames, 3) {
+		return
+	}
+	assert.Equal("app.bsky.feed.post", frames[0].collection)
+	assert.Equal("app.bsky.feed.like", frames[2].collection)
+
+	var create, update, del JetstreamEvent
+	assert.NoError(json.Unmarshal(frames[0].msg, &create))
+	assert.NoError(json.Unmarshal(frames[1].msg, &update))
+	assert.NoError(json.Unmarshal(frames[2].msg, &del))
+
+	assert.Equal("did:example:abc", create.Did)
+	assert.Equal(ts.UnixMicro(), create.TimeUS)
+	assert.Equal("commit", create.Kind)
+	assert.Equal("rev10", create.Commit.Rev)
+	assert.Equal("create", create.Commit.Operation)
+	assert.Equal("1", create.Commit.RKey)
+	assert.Equal("hello", create.Commit.Record["text"])
+	assert.Equal(cid.Cid(*evt.RepoCommit.Ops[0].Cid).String(), create.Commit.CID)
+
+	assert.Equal("update", update.Commit.Operation)
+	assert.Equal("edited", update.Commit.Record["text"])
+
+	assert.Equal("delete", del.Commit.Operation)
+	assert.Equal("app.bsky.feed.like", del.Commit.Collection)
+	assert.Equal("3", del.Commit.RKey)
+	assert.Nil(del.Commit.Record)
+	assert.Empty(del.Commit.CID)
+
+	// error frames are passed on
+	frames, err = s.jetstreamFrames(&events.XRPCStreamEvent{
+		Error: &events.ErrorFrame{Error: "FutureCursor", Message: "cursor is in the future"},
+	})
+	assert.NoError(err)
+	if assert.Len(frames, 1) {
+		assert.JSONEq(`{"kind": "error", "error": "FutureCursor", "message": "cursor is in the future"}`, string(frames[0].msg))
+	}
+}
+
+func TestJetstreamHandler(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	s := NewMemSplitter("localhost")
+	e := echo.New()
+	e.GET("/subscribe", s.JetstreamHandler)
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	t0 := time.Now().Add(-time.Minute).Truncate(time.Second)
+	for i, evt := range []*events.XRPCStreamEvent{
+		testCommitEvent(t, 1, "did:example:abc", t0, testOp{"create", "app.bsky.feed.post/1", "before the cursor"}),
+		testCommitEvent(t, 2, "did:example:abc", t0.Add(time.Second),
+			testOp{"create", "app.bsky.feed.post/2", "at the cursor"},
+			testOp{"create", "app.bsky.feed.like/2", "not wanted"},
+		),
+	} {
+		if err := s.events.AddEvent(ctx, evt); err != nil {
+			t.Fatal(i, err)
+		}
+	}
+
+	url := fmt.Sprintf("ws%s/subscribe?wantedCollections=app.bsky.feed.post&cursor=%d", strings.TrimPrefix(srv.URL, "http"), t0.Add(time.Second).UnixMicro())
+	con, _, err := websocket.DefaultDialer.DialContext(ctx, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer con.Close()
+	con.SetReadDeadline(time.Now().Add(5 * time.Second))
+	read := func() *JetstreamEvent {
+		mt, msg, err := con.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(websocket.TextMessage, mt)
+		var je JetstreamEvent
+		if err := json.Unmarshal(msg, &je); err != nil {
+			t.Fatal(err)
+		}
+		return &je
+	}
+
+	// events before the cursor, and ops in other collections, are skipped
+	je := read()
+	assert.Equal("2", je.Commit.RKey)
+	assert.Equal("at the cursor", je.Commit.Record["text"])
+
+	// as are live commits with no ops in wanted collections
+	for _, evt := range []*events.XRPCStreamEvent{
+		testCommitEvent(t, 3, "did:example:abc", time.Now(), testOp{"create", "app.bsky.feed.like/3", "not wanted"}),
+		testCommitEvent(t, 4, "did:example:abc", time.Now(), testOp{"delete", "app.bsky.feed.post/1", ""}),
+	} {
+		if err := s.events.AddEvent(ctx, evt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	je = read()
+	assert.Equal("rev4", je.Commit.Rev)
+	assert.Equal("delete", je.Commit.Operation)
+}
@@ -14,3 +14,73 @@ var activeClientGauge = promauto.NewGauge(prometheus.GaugeOpts{
 	Name: "spl_active_clients",
 	Help: "Current number of active clients",
 })
+
+var warmStartEventsCounter = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "spl_warm_start_events",
+	Help: "The total number of events copied from a peer during warm start",
+})
+
+var exportEventsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "spl_export_events",
+	Help: "The total number of events exported or imported via the admin API",
+}, []string{"direction"})
+
+var mutesActiveGauge = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "spl_active_mutes",
+	Help: "Current number of muted accounts",
+})
+
+var mutedEventsCounter = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "spl_muted_events_filtered",
+	Help: "The total number of events from muted accounts not sent to consumers which excluded them",
+})
+
+var wantedFilteredCounter = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "spl_wanted_events_filtered",
+	Help: "The total number of events not sent to consumers because they didn't match the consumer's wantedCollections or wantedDids",
+})
+
+var outdatedCursorCounter = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "spl_outdated_cursors",
+	Help: "Number of subscriptions with a cursor older than the cached event window",
+})
+
+var jetstreamErrorsCounter = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "spl_json_conversion_errors",
+	Help: "Number of events which couldn't be converted for the JSON stream",
+})
+
+var consumerEventsSentCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "spl_consumer_events_sent",
+	Help: "The total number of events sent to authenticated consumers, by key name or JWT subject",
+}, []string{"consumer"})
+
+var authedClientGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "spl_authenticated_clients",
+	Help: "Current number of authenticated clients, by key name or JWT subject",
+}, []string{"consumer"})
+
+var consumerAuthFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "spl_consumer_auth_failures",
+	Help: "Number of consumer connections rejected, by reason",
+}, []string{"reason"})
+
+var encodingClientGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "spl_clients_by_encoding",
+	Help: "Current number of active clients, by negotiated compression",
+}, []string{"encoding"})
+
+var zstdBytesCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "spl_zstd_bytes",
+	Help: "The total number of bytes zstd compressed for consumers, before (in) and after (out) compression",
+}, []string{"direction"})
+
+var peerHistoryEventsCounter = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "spl_peer_history_events",
+	Help: "The total number of events streamed from the shared history peer to subscribers with cursors older than the local window",
+})
+
+var slowConsumersCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "spl_slow_consumers",
+	Help: "Number of times consumers fell too far behind the live stream, by the action taken (disconnect or replay)",
+}, []string{"action"})
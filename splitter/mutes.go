@@ -0,0 +1,204 @@
+package splitter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	events "github.com/bluesky-social/indigo/events"
+	"github.com/labstack/echo/v4"
+)
+
+// Mute is a "soft" moderation action against an account, typically pushed by
+// automod (hepa): rather than a takedown, events from the account are marked
+// so that consumers can opt in to filtering them out (see EventsHandler), or
+// downrank them using the mute list.
+type Mute struct {
+	DID       string    `json:"did"`
+	Until     time.Time `json:"until"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// muteList is the set of currently muted accounts. Expired mutes are ignored,
+// and dropped whenever the list is next written.
+type muteList struct {
+	lk    sync.RWMutex
+	mutes map[string]*Mute
+	// file the list is persisted to. optional
+	path string
+}
+
+func newMuteList(path string) (*muteList, error) {
+	ml := &muteList{
+		mutes: make(map[string]*Mute),
+		path:  path,
+	}
+	if path == "" {
+		return ml, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ml, nil
+		}
+		return nil, err
+	}
+	var list []*Mute
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, fmt.Errorf("reading mutes file: %w", err)
+	}
+	for _, m := range list {
+		ml.mutes[m.DID] = m
+	}
+	mutesActiveGauge.Set(float64(len(ml.mutes)))
+	return ml, nil
+}
+
+// IsMuted checks whether the account is currently muted
+func (ml *muteList) IsMuted(did string) bool {
+	ml.lk.RLock()
+	defer ml.lk.RUnlock()
+	m, ok := ml.mutes[did]
+	return ok && time.Now().Before(m.Until)
+}
+
+// List returns the current mutes, ordered by DID
+func (ml *muteList) List() []*Mute {
+	ml.lk.RLock()
+	defer ml.lk.RUnlock()
+	now := time.Now()
+	out := []*Mute{}
+	for _, m := range ml.mutes {
+		if now.Before(m.Until) {
+			out = append(out, m)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].DID < out[j].DID })
+	return out
+}
+
+// Add mutes an account, replacing any existing mute of it
+func (ml *muteList) Add(m *Mute) error {
+	ml.lk.Lock()
+	defer ml.lk.Unlock()
+	ml.mutes[m.DID] = m
+	return ml.flush()
+}
+
+// Remove unmutes an account. Returns false if it wasn't muted.
+func (ml *muteList) Remove(did string) (bool, error) {
+	ml.lk.Lock()
+	defer ml.lk.Unlock()
+	if _, ok := ml.mutes[did]; !ok {
+		return false, nil
+	}
+	delete(ml.mutes, did)
+	return true, ml.flush()
+}
+
+// drops expired mutes, and writes the list out. must be called with the lock held
+func (ml *muteList) flush() error {
+	now := time.Now()
+	list := []*Mute{}
+	for did, m := range ml.mutes {
+		if !now.Before(m.Until) {
+			delete(ml.mutes, did)
+			continue
+		}
+		list = append(list, m)
+	}
+	mutesActiveGauge.Set(float64(len(list)))
+	if ml.path == "" {
+		return nil
+	}
+	b, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	tmp := ml.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0664); err != nil {
+		return err
+	}
+	return os.Rename(tmp, ml.path)
+}
+
+// mutedFilter returns the subscription filter for a consumer's "muted" query
+// parameter: "include" (the default) passes every event through, while
+// "exclude" drops events from muted accounts
+func (s *Splitter) mutedFilter(mode string) (func(*events.XRPCStreamEvent) bool, error) {
+	switch mode {
+	case "", "include":
+		return func(evt *events.XRPCStreamEvent) bool { return true }, nil
+	case "exclude":
+		return func(evt *events.XRPCStreamEvent) bool {
+			if did := evt.Repo(); did != "" && s.mutes.IsMuted(did) {
+				mutedEventsCounter.Inc()
+				return false
+			}
+			return true
+		}, nil
+	default:
+		return nil, fmt.Errorf("invalid muted mode (expected include or exclude): %s", mode)
+	}
+}
+
+// HandleAdminListMutes returns the accounts which are currently muted, so that
+// downstream services can downrank their content
+func (s *Splitter) HandleAdminListMutes(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]any{
+		"mutes": s.mutes.List(),
+	})
+}
+
+type muteRequest struct {
+	DID    string    `json:"did"`
+	Until  time.Time `json:"until"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// HandleAdminAddMute mutes an account until the given time. Muting an account
+// which is already muted replaces the earlier mute.
+func (s *Splitter) HandleAdminAddMute(c echo.Context) error {
+	var req muteRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid mute request: %s", err))
+	}
+	if _, err := syntax.ParseDID(req.DID); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid did: %s", err))
+	}
+	if !req.Until.After(time.Now()) {
+		return echo.NewHTTPError(http.StatusBadRequest, "until must be in the future")
+	}
+
+	m := &Mute{
+		DID:       req.DID,
+		Until:     req.Until.UTC(),
+		Reason:    req.Reason,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := s.mutes.Add(m); err != nil {
+		return err
+	}
+	s.log.Info("muted account", "did", m.DID, "until", m.Until, "reason", m.Reason)
+	return c.JSON(http.StatusOK, m)
+}
+
+// HandleAdminRemoveMute unmutes an account
+func (s *Splitter) HandleAdminRemoveMute(c echo.Context) error {
+	did := c.Param("did")
+	ok, err := s.mutes.Remove(did)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "account is not muted")
+	}
+	s.log.Info("unmuted account", "did", did)
+	return c.NoContent(http.StatusOK)
+}
@@ -0,0 +1,38 @@
+package splitter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiskSplitterMutes(t *testing.T) {
+	assert := assert.New(t)
+	s := newTestDiskSplitter(t)
+
+	e := echo.New()
+	e.POST("/admin/mutes", s.HandleAdminAddMute)
+	e.GET("/admin/mutes", s.HandleAdminListMutes)
+
+	body := `{"did": "did:example:muted", "until": "` + time.Now().Add(time.Hour).UTC().Format(time.RFC3339) + `"}`
+	req := httptest.NewRequest("POST", "/admin/mutes", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(http.StatusOK, rec.Code, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/admin/mutes", nil))
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Contains(rec.Body.String(), "did:example:muted")
+
+	filter, err := s.mutedFilter("exclude")
+	assert.NoError(err)
+	assert.False(filter(testIdentityEvent(1, "did:example:muted")))
+	assert.True(filter(testIdentityEvent(2, "did:example:other")))
+}
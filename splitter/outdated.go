@@ -0,0 +1,63 @@
+package splitter
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/bluesky-social/indigo/events"
+)
+
+// tailSeq returns the sequence number of the oldest cached event, or -1. With
+// retention by event type, this is where the complete window starts; some
+// older events may be cached too.
+func (s *Splitter) tailSeq(ctx context.Context) (int64, error) {
+	if s.pp != nil {
+		seq, err := s.pp.CompleteFrom(ctx)
+		if errors.Is(err, events.ErrNoLast) {
+			return -1, nil
+		}
+		if err != nil {
+			return -1, err
+		}
+		return seq, nil
+	}
+	return s.erb.FirstSeq(), nil
+}
+
+// outdatedCursor checks whether a subscriber's cursor is older than the
+// cached window. If so, it returns the #info frame to send before streaming
+// from the oldest cached event, pointing at the backfill host (if configured)
+// for the events in between. With retention by event type, the window is the
+// complete one, and subscribers are sent the older events which are kept
+// after the #info frame.
+func (s *Splitter) outdatedCursor(ctx context.Context, cursor int64) (*events.XRPCStreamEvent, error) {
+	if s.conf.SharedHistoryPeer != "" {
+		// older events come from the peer, which sends its own #info frame
+		// if the cursor is outdated there too
+		return nil, nil
+	}
+	first, err := s.tailSeq(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// the cursor is the last event the subscriber has seen, so it's only
+	// outdated if the event after it is gone
+	if first < 0 || cursor >= first-1 {
+		return nil, nil
+	}
+	outdatedCursorCounter.Inc()
+	return events.NewOutdatedCursorEvent(events.OutdatedCursorInfo{
+		Cursor:         cursor,
+		EarliestCursor: first - 1,
+		Backfill:       backfillURL(s.conf.BackfillHost),
+	}), nil
+}
+
+// backfillURL adds a scheme to a bare backfill hostname
+func backfillURL(host string) string {
+	if host == "" || strings.Contains(host, "://") {
+		return host
+	}
+	return "wss://" + host
+}
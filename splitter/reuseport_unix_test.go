@@ -0,0 +1,56 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package splitter
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReusePortListeners(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	lc := net.ListenConfig{Control: reusePortControl}
+	first, err := lc.Listen(ctx, "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	addr := first.Addr().String()
+
+	// a second process (here, listener) can bind the same port
+	second, err := lc.Listen(ctx, "tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	assert.Equal(addr, second.Addr().String())
+
+	// but not without SO_REUSEPORT
+	var plain net.ListenConfig
+	_, err = plain.Listen(ctx, "tcp", addr)
+	assert.Error(err)
+
+	// connections to the shared port are accepted by one listener or the other
+	accepted := make(chan net.Listener, 2)
+	for _, li := range []net.Listener{first, second} {
+		go func(li net.Listener) {
+			con, err := li.Accept()
+			if err != nil {
+				return
+			}
+			con.Close()
+			accepted <- li
+		}(li)
+	}
+	con, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	con.Close()
+	assert.Contains([]net.Listener{first, second}, <-accepted)
+}
@@ -0,0 +1,152 @@
+package splitter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	events "github.com/bluesky-social/indigo/events"
+
+	"github.com/labstack/echo/v4"
+)
+
+// CacheWindow describes the events available to replay, for the admin API
+type CacheWindow struct {
+	// "disk", "memory", or "shared" (see SplitterConfig.SharedHistoryPeer)
+	Cache string `json:"cache"`
+	// sequence numbers of the oldest and newest cached events. -1 if the
+	// cache is empty. Subscribers can replay from any cursor from one before
+	// OldestSeq (or, with a shared cache, any cursor the peer has)
+	OldestSeq int64 `json:"oldestSeq"`
+	NewestSeq int64 `json:"newestSeq"`
+	// with retention by event type (disk cache only), the oldest event from
+	// which every event is cached. Older cached events are only of the
+	// types kept longer
+	CompleteFromSeq int64 `json:"completeFromSeq"`
+	// when the oldest and newest events were cached. disk cache only
+	OldestAt *time.Time `json:"oldestAt,omitempty"`
+	NewestAt *time.Time `json:"newestAt,omitempty"`
+	// retention limits, and estimated size on disk. disk cache only
+	RetentionSeconds float64 `json:"retentionSeconds,omitempty"`
+	// frame types (eg, "#identity") kept for other than RetentionSeconds
+	RetentionSecondsByType map[string]float64 `json:"retentionSecondsByType,omitempty"`
+	MaxBytes               uint64             `json:"maxBytes,omitempty"`
+	DiskBytes              uint64             `json:"diskBytes,omitempty"`
+	// the peer older events are streamed from, with a shared cache
+	Peer string `json:"peer,omitempty"`
+}
+
+func (s *Splitter) cacheWindow(ctx context.Context) (*CacheWindow, error) {
+	w := &CacheWindow{OldestSeq: -1, NewestSeq: -1, CompleteFromSeq: -1}
+	switch {
+	case s.pp != nil:
+		w.Cache = "disk"
+		first, firstMillis, err := s.pp.GetFirst(ctx)
+		if errors.Is(err, events.ErrNoLast) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		last, lastMillis, _, err := s.pp.GetLast(ctx)
+		if err != nil && !errors.Is(err, events.ErrNoLast) {
+			return nil, err
+		}
+		complete, err := s.pp.CompleteFrom(ctx)
+		if err != nil && !errors.Is(err, events.ErrNoLast) {
+			return nil, err
+		}
+		oldestAt, newestAt := time.UnixMilli(firstMillis), time.UnixMilli(lastMillis)
+		w.OldestSeq, w.OldestAt = first, &oldestAt
+		w.NewestSeq, w.NewestAt = last, &newestAt
+		w.CompleteFromSeq = complete
+	case s.conf.SharedHistoryPeer != "":
+		w.Cache = "shared"
+		w.Peer = s.conf.SharedHistoryPeer
+		w.OldestSeq, w.NewestSeq = s.erb.FirstSeq(), s.erb.LastSeq()
+		w.CompleteFromSeq = w.OldestSeq
+	default:
+		w.Cache = "memory"
+		w.OldestSeq, w.NewestSeq = s.erb.FirstSeq(), s.erb.LastSeq()
+		w.CompleteFromSeq = w.OldestSeq
+	}
+	if s.pp != nil {
+		if opts := s.conf.PebbleOptions; opts != nil {
+			w.RetentionSeconds = opts.PersistDuration.Seconds()
+			w.MaxBytes = opts.MaxBytes
+			if len(opts.RetentionByType) > 0 {
+				w.RetentionSecondsByType = make(map[string]float64, len(opts.RetentionByType))
+				for t, d := range opts.RetentionByType {
+					w.RetentionSecondsByType[t] = d.Seconds()
+				}
+			}
+		}
+		size, err := s.pp.DiskUsage()
+		if err != nil {
+			return nil, err
+		}
+		w.DiskBytes = size
+	}
+	return w, nil
+}
+
+// HandleAdminEventWindow reports the range of cached events, which cursors
+// can be replayed from
+func (s *Splitter) HandleAdminEventWindow(c echo.Context) error {
+	w, err := s.cacheWindow(c.Request().Context())
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, w)
+}
+
+// HandleAdminRewindConsumer sends a connected consumer back to replay the
+// cache from a cursor (a sequence number, for JSON stream consumers too),
+// without disconnecting it. The cursor must be in the cached window.
+func (s *Splitter) HandleAdminRewindConsumer(c echo.Context) error {
+	ctx := c.Request().Context()
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid consumer id")
+	}
+	cursor, err := strconv.ParseInt(c.QueryParam("cursor"), 10, 64)
+	if err != nil || cursor < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid cursor")
+	}
+
+	w, err := s.cacheWindow(ctx)
+	if err != nil {
+		return err
+	}
+	if w.NewestSeq < 0 {
+		return echo.NewHTTPError(http.StatusConflict, "event cache is empty")
+	}
+	if cursor > w.NewestSeq {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("cursor is ahead of the newest cached event (%d)", w.NewestSeq))
+	}
+	if w.Cache != "shared" && cursor < w.OldestSeq-1 {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("cursor is older than the oldest cached event (%d)", w.OldestSeq))
+	}
+
+	s.consumersLk.RLock()
+	sc, ok := s.consumers[id]
+	s.consumersLk.RUnlock()
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "consumer not connected")
+	}
+	// a rewind not yet acted on is replaced
+	select {
+	case <-sc.rewind:
+	default:
+	}
+	select {
+	case sc.rewind <- cursor:
+	default:
+		return echo.NewHTTPError(http.StatusConflict, "consumer is already being rewound")
+	}
+	s.log.Info("rewinding consumer", "consumer_id", id, "remote_addr", sc.RemoteAddr, "consumer", sc.Consumer, "cursor", cursor)
+	return c.JSON(http.StatusOK, map[string]any{"rewound": id, "cursor": cursor})
+}
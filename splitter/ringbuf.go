@@ -64,6 +64,37 @@ func (er *EventRingBuffer) Persist(ctx context.Context, evt *events.XRPCStreamEv
 	return nil
 }
 
+// LastSeq returns the sequence number of the most recent event in the buffer, or -1 if it is empty
+func (er *EventRingBuffer) LastSeq() int64 {
+	er.lk.Lock()
+	defer er.lk.Unlock()
+
+	for i := len(er.chunks) - 1; i >= 0; i-- {
+		evts := er.chunks[i].events()
+		for j := len(evts) - 1; j >= 0; j-- {
+			if seq := events.SequenceForEvent(evts[j]); seq >= 0 {
+				return seq
+			}
+		}
+	}
+	return -1
+}
+
+// FirstSeq returns the sequence number of the oldest event in the buffer, or -1 if it is empty
+func (er *EventRingBuffer) FirstSeq() int64 {
+	er.lk.Lock()
+	defer er.lk.Unlock()
+
+	for _, c := range er.chunks {
+		for _, evt := range c.events() {
+			if seq := events.SequenceForEvent(evt); seq >= 0 {
+				return seq
+			}
+		}
+	}
+	return -1
+}
+
 func (er *EventRingBuffer) Flush(context.Context) error {
 	return nil
 }
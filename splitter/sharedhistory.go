@@ -0,0 +1,98 @@
+package splitter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	events "github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/events/schedulers/sequential"
+
+	"github.com/gorilla/websocket"
+)
+
+var errPeerCaughtUp = errors.New("peer history reached the local window")
+
+// peerHistory is the event cache of an instance in shared history mode (see
+// SplitterConfig.SharedHistoryPeer). Live events from the peer go into a
+// short in-memory window; subscribers with cursors older than that are
+// streamed the missing events from the peer, then continue from the window.
+type peerHistory struct {
+	*EventRingBuffer
+
+	// subscribeRepos URL of the peer
+	wsURL string
+	// credentials for the peer. optional
+	token string
+
+	log *slog.Logger
+}
+
+func newPeerHistory(peer, token string, erb *EventRingBuffer, log *slog.Logger) (*peerHistory, error) {
+	_, wsURL, err := peerURLs(peer)
+	if err != nil {
+		return nil, err
+	}
+	return &peerHistory{EventRingBuffer: erb, wsURL: wsURL, token: token, log: log}, nil
+}
+
+func (ph *peerHistory) Playback(ctx context.Context, since int64, cb func(*events.XRPCStreamEvent) error) error {
+	first := ph.FirstSeq()
+	if first >= 0 && since >= first-1 {
+		return ph.EventRingBuffer.Playback(ctx, since, cb)
+	}
+
+	last, err := ph.peerPlayback(ctx, since, cb)
+	if err != nil {
+		return err
+	}
+	return ph.EventRingBuffer.Playback(ctx, last, cb)
+}
+
+// peerPlayback streams events after since from the peer, until reaching
+// events which are in the local window. It returns the last sequence number
+// passed to cb. #info frames from the peer (eg, OutdatedCursor, if since is
+// older than the peer's window too) are passed on.
+func (ph *peerHistory) peerPlayback(ctx context.Context, since int64, cb func(*events.XRPCStreamEvent) error) (int64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	con, _, err := websocket.DefaultDialer.DialContext(ctx, fmt.Sprintf("%s?cursor=%d", ph.wsURL, since), peerHeader(ph.token))
+	if err != nil {
+		return since, fmt.Errorf("dialing shared history peer: %w", err)
+	}
+	defer con.Close()
+
+	last := since
+	var count int
+	sched := sequential.NewScheduler("splitter-peer-history", func(ctx context.Context, evt *events.XRPCStreamEvent) error {
+		seq := evt.Sequence()
+		if seq < 0 {
+			return cb(evt)
+		}
+		if seq <= last {
+			return nil
+		}
+		// the window keeps moving while we stream, so check it every time
+		if first := ph.FirstSeq(); first >= 0 && seq >= first {
+			return errPeerCaughtUp
+		}
+		if err := cb(evt); err != nil {
+			return err
+		}
+		last = seq
+		count++
+		return nil
+	})
+
+	err = events.HandleRepoStream(ctx, con, sched, ph.log)
+	peerHistoryEventsCounter.Add(float64(count))
+	if errors.Is(err, errPeerCaughtUp) {
+		return last, nil
+	}
+	if err == nil {
+		err = fmt.Errorf("shared history peer stream ended (last=%d)", last)
+	}
+	return last, err
+}
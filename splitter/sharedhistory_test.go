@@ -0,0 +1,44 @@
+package splitter
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/events"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeerHistoryToken(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	peer := testUpstream(t, "rbw_peer", testIdentityEvent(1, "did:example:a"), testIdentityEvent(2, "did:example:b"), testIdentityEvent(3, "did:example:c"))
+
+	newHistory := func(token string) *peerHistory {
+		erb := NewEventRingBuffer(10, 10)
+		erb.SetEventBroadcaster(func(*events.XRPCStreamEvent) {})
+		// the local window starts at 3
+		assert.NoError(erb.Persist(ctx, testIdentityEvent(3, "did:example:c")))
+		ph, err := newPeerHistory(peer, token, erb, slog.Default())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ph
+	}
+
+	var seqs []int64
+	err := newHistory("rbw_peer").Playback(ctx, 0, func(evt *events.XRPCStreamEvent) error {
+		seqs = append(seqs, evt.Sequence())
+		return nil
+	})
+	assert.NoError(err)
+	assert.Equal([]int64{1, 2, 3}, seqs)
+
+	// a peer requiring consumer auth rejects anonymous readers
+	err = newHistory("").Playback(ctx, 0, func(evt *events.XRPCStreamEvent) error { return nil })
+	assert.ErrorContains(err, "dialing shared history peer")
+}
@@ -0,0 +1,113 @@
+package splitter
+
+import (
+	"context"
+	"fmt"
+
+	events "github.com/bluesky-social/indigo/events"
+
+	"golang.org/x/time/rate"
+)
+
+// What to do with a consumer which falls too far behind the live stream (see
+// SplitterConfig.SlowConsumerPolicy)
+const (
+	// send a ConsumerTooSlow error frame and close the connection
+	SlowConsumerDisconnect = "disconnect"
+	// drop the consumer's queued events, and stream it the events it
+	// missed from the cache, as if it had reconnected with a cursor
+	SlowConsumerReplay = "replay"
+)
+
+// subscription is a consumer's subscription to the event stream, which is
+// replaced when the consumer is sent back to replay from the cache
+type subscription struct {
+	evts    <-chan *events.XRPCStreamEvent
+	cleanup func()
+	// signalled when the consumer exceeds its queue limits. evts is closed
+	// right after
+	overflow chan struct{}
+}
+
+// subscribe subscribes a consumer to the event stream, with the configured
+// queue limits
+func (s *Splitter) subscribe(ctx context.Context, ident string, filter func(*events.XRPCStreamEvent) bool, since *int64) (*subscription, error) {
+	sub := &subscription{overflow: make(chan struct{}, 1)}
+	limits := events.SubscriberLimits{
+		MaxQueuedEvents: s.conf.ConsumerMaxQueuedEvents,
+		MaxQueuedBytes:  s.conf.ConsumerMaxQueuedBytes,
+		// called at most once, so never blocks
+		OnOverflow: func() { sub.overflow <- struct{}{} },
+	}
+	evts, cleanup, err := s.events.SubscribeWithLimits(ctx, ident, filter, since, limits)
+	if err != nil {
+		return nil, err
+	}
+	sub.evts = evts
+	sub.cleanup = cleanup
+	return sub, nil
+}
+
+// overflowed reports whether the subscription ended because the consumer
+// fell behind
+func (sub *subscription) overflowed() bool {
+	select {
+	case <-sub.overflow:
+		return true
+	default:
+		return false
+	}
+}
+
+// replayCursor returns the cursor to resubscribe a consumer which fell
+// behind from: the last event it was sent, or the cursor it connected with.
+// It returns false if the consumer should be disconnected instead: the
+// policy is SlowConsumerDisconnect, the consumer hasn't been sent anything
+// yet, or the events it missed have left the cache.
+func (s *Splitter) replayCursor(ctx context.Context, consumer *SocketConsumer) (int64, bool, error) {
+	if s.conf.SlowConsumerPolicy != SlowConsumerReplay {
+		return 0, false, nil
+	}
+	cursor := consumer.progress.lastSeq.Load()
+	if cursor == 0 && consumer.Cursor != nil {
+		cursor = *consumer.Cursor
+	}
+	if cursor <= 0 {
+		return 0, false, nil
+	}
+	info, err := s.outdatedCursor(ctx, cursor)
+	if err != nil {
+		return 0, false, err
+	}
+	return cursor, info == nil, nil
+}
+
+// consumerTooSlow is the error frame sent to consumers before they are
+// disconnected for falling behind
+func consumerTooSlow() *events.XRPCStreamEvent {
+	return &events.XRPCStreamEvent{
+		Error: &events.ErrorFrame{
+			Error:   "ConsumerTooSlow",
+			Message: "consumer fell too far behind the stream",
+		},
+	}
+}
+
+// consumerLimiter returns the rate limiter for a new consumer's messages, or
+// nil if consumers aren't rate limited
+func (s *Splitter) consumerLimiter() *rate.Limiter {
+	if s.conf.ConsumerRateLimit <= 0 {
+		return nil
+	}
+	// a second's worth of burst
+	return rate.NewLimiter(rate.Limit(s.conf.ConsumerRateLimit), max(1, int(s.conf.ConsumerRateLimit)))
+}
+
+func validSlowConsumerPolicy(policy string) error {
+	switch policy {
+	case "", SlowConsumerDisconnect, SlowConsumerReplay:
+		return nil
+	default:
+		return fmt.Errorf("unknown slow consumer policy %q (expected %q or %q)", policy, SlowConsumerDisconnect, SlowConsumerReplay)
+	}
+}
@@ -38,6 +38,30 @@ type Splitter struct {
 
 	conf SplitterConfig
 
+	// tallies upstream ops per collection. nil if not enabled
+	collectionStats *events.CollectionStats
+
+	// private listener for sibling processes. nil if not configured
+	loopback net.Listener
+
+	// accounts whose events consumers may opt out of
+	mutes *muteList
+
+	// measures the age of upstream events as they arrive
+	ageWatchdog *events.AgeWatchdog
+
+	// events converted for the JSON stream
+	jsonCache *jetstreamCache
+
+	// checks consumers' credentials
+	consumerAuth *consumerAuth
+
+	// compresses messages for consumers which ask for zstd
+	zstd *zstdCompressor
+
+	// state of the upstream connection, for the admin API
+	upstream upstreamState
+
 	log *slog.Logger
 }
 
@@ -45,6 +69,102 @@ type SplitterConfig struct {
 	UpstreamHost  string
 	CursorFile    string
 	PebbleOptions *events.PebblePersistOptions
+	// WarmStartPeer is another rainbow instance to copy the retained event
+	// window from when starting with an empty cache. Optional.
+	WarmStartPeer string
+	// PeerToken is a bearer token (a consumer key or JWT) to authenticate to
+	// WarmStartPeer or SharedHistoryPeer with, for peers with
+	// RequireConsumerAuth. Optional.
+	PeerToken string
+	// AdminToken enables the /admin/ endpoints (eg, event export and import),
+	// authenticated with this bearer token. Optional.
+	AdminToken string
+	// TLSHostnames enables TLS on the API listener, with certificates for
+	// these hostnames provisioned automatically over ACME. Optional.
+	TLSHostnames []string
+	// TLSCacheDir is where provisioned certificates and the ACME account key
+	// are kept between restarts
+	TLSCacheDir string
+	// ACMEEmail is the contact address given to the ACME provider. Optional.
+	ACMEEmail string
+	// ACMEHTTPListen is the address to answer HTTP-01 challenges on (which
+	// must be reachable on port 80). Defaults to ":80"
+	ACMEHTTPListen string
+	// CollectionStats enables tallying of upstream repo ops by collection
+	// and op type, exported as metrics and reported at
+	// /admin/events/collections. The upstream is a relay, so PDS hosts are
+	// not known and are all counted as "unknown"
+	CollectionStats bool
+	// ReusePort opens the API listener with SO_REUSEPORT, so several rainbow
+	// processes on one host can share the port, with the kernel spreading
+	// new connections between them. See LoopbackListen for consuming the
+	// upstream only once.
+	ReusePort bool
+	// LoopbackListen is an additional, private address (eg, 127.0.0.1:2482)
+	// to serve the API on, for sibling processes sharing the API port to
+	// use as their upstream. It is never TLS or SO_REUSEPORT. Optional.
+	LoopbackListen string
+	// MutesFile is where the list of muted accounts (see Mute) is kept
+	// between restarts. If not set, mutes are only kept in memory.
+	MutesFile string
+	// EventAgeSLA is the maximum acceptable p99 age of upstream events
+	// (time since creation) as they arrive. Breaches are logged, reported
+	// in metrics, and posted to EventAgeAlertWebhook. Zero disables alerting,
+	// but ages are always measured.
+	EventAgeSLA time.Duration
+	// EventAgeAlertWebhook is a URL to post event age alerts to (eg, a Slack
+	// incoming webhook). Optional.
+	EventAgeAlertWebhook string
+	// BackfillHost is a service with longer retention than the event cache
+	// (eg, the upstream relay), which subscribers whose cursor is older than
+	// the cache are pointed at in the OutdatedCursor #info frame. Optional.
+	BackfillHost string
+	// ConsumerKeysFile is where consumer API keys (created through the admin
+	// API) and JWT revocations are kept between restarts. If not set, they
+	// are only kept in memory.
+	ConsumerKeysFile string
+	// ConsumerJWTSecret lets consumers connect with HS256 JWTs signed with
+	// this secret, identified by the token's subject. Tokens must expire.
+	// Optional.
+	ConsumerJWTSecret string
+	// RequireConsumerAuth rejects consumers which don't connect with a key
+	// or JWT. Otherwise, credentials are optional (but checked if given).
+	RequireConsumerAuth bool
+	// PerMessageDeflate negotiates permessage-deflate websocket compression
+	// with consumers which offer it. Consumers which ask for zstd get that
+	// instead.
+	PerMessageDeflate bool
+	// ZstdDictionaryFile is a zstd dictionary (eg, the one published with
+	// Jetstream) to compress messages for zstd consumers against. It is
+	// served at /_rainbow/zstd-dictionary. If not set, messages are
+	// compressed without a dictionary. Optional.
+	ZstdDictionaryFile string
+	// SharedHistoryPeer is another rainbow instance, with a persistent
+	// event cache, whose window this instance serves without a copy of its
+	// own. Live events are consumed from the peer (in place of
+	// UpstreamHost) into a short in-memory window, and subscribers with
+	// older cursors are streamed the missing events from the peer. Any
+	// number of instances can share one peer's cursor space this way.
+	// Incompatible with PebbleOptions. Optional.
+	SharedHistoryPeer string
+	// ConsumerMaxQueuedEvents is the number of live events which may be
+	// waiting to be sent to a consumer before it is considered too slow (see
+	// SlowConsumerPolicy). Zero means the default (16k).
+	ConsumerMaxQueuedEvents int
+	// ConsumerMaxQueuedBytes is the total size of the live events which may
+	// be waiting to be sent to a consumer before it is considered too slow.
+	// Zero means no limit.
+	ConsumerMaxQueuedBytes int64
+	// SlowConsumerPolicy is what happens to consumers which exceed their
+	// queue limits: SlowConsumerDisconnect (the default), or
+	// SlowConsumerReplay, which keeps them connected but streams them the
+	// events they missed from the cache, at their own pace.
+	SlowConsumerPolicy string
+	// ConsumerRateLimit is the most messages per second sent to any one
+	// consumer, including during playback. Consumers held back by it queue
+	// live events, and are subject to SlowConsumerPolicy. Zero means no
+	// limit.
+	ConsumerRateLimit float64
 }
 
 func NewMemSplitter(host string) *Splitter {
@@ -53,30 +173,38 @@ func NewMemSplitter(host string) *Splitter {
 		CursorFile:   "cursor-file",
 	}
 
-	erb := NewEventRingBuffer(20_000, 10_000)
-
-	em := events.NewEventManager(erb)
-	return &Splitter{
-		conf:      conf,
-		erb:       erb,
-		events:    em,
-		consumers: make(map[uint64]*SocketConsumer),
-		log:       slog.Default().With("system", "splitter"),
-	}
+	// nothing to load, so this can't fail
+	s, _ := newSplitter(conf, slog.Default().With("system", "splitter"))
+	s.erb = NewEventRingBuffer(20_000, 10_000)
+	s.events = events.NewEventManager(s.erb)
+	return s
 }
+
 func NewSplitter(conf SplitterConfig) (*Splitter, error) {
-	if conf.PebbleOptions == nil {
+	s, err := newSplitter(conf, slog.Default().With("system", "splitter"))
+	if err != nil {
+		return nil, err
+	}
+	if err := validSlowConsumerPolicy(conf.SlowConsumerPolicy); err != nil {
+		return nil, err
+	}
+
+	if conf.SharedHistoryPeer != "" {
+		if conf.PebbleOptions != nil {
+			return nil, fmt.Errorf("shared history mode keeps no local event cache")
+		}
+		// the local window only needs to cover reconnects to the peer, and
+		// playbacks catching up from it
+		s.erb = NewEventRingBuffer(20_000, 50)
+		ph, err := newPeerHistory(conf.SharedHistoryPeer, conf.PeerToken, s.erb, s.log)
+		if err != nil {
+			return nil, err
+		}
+		s.events = events.NewEventManager(ph)
+	} else if conf.PebbleOptions == nil {
 		// mem splitter
-		erb := NewEventRingBuffer(20_000, 10_000)
-
-		em := events.NewEventManager(erb)
-		return &Splitter{
-			conf:      conf,
-			erb:       erb,
-			events:    em,
-			consumers: make(map[uint64]*SocketConsumer),
-			log:       slog.Default().With("system", "splitter"),
-		}, nil
+		s.erb = NewEventRingBuffer(20_000, 10_000)
+		s.events = events.NewEventManager(s.erb)
 	} else {
 		pp, err := events.NewPebblePersistance(conf.PebbleOptions)
 		if err != nil {
@@ -84,16 +212,12 @@ func NewSplitter(conf SplitterConfig) (*Splitter, error) {
 		}
 
 		go pp.GCThread(context.Background())
-		em := events.NewEventManager(pp)
-		return &Splitter{
-			conf:      conf,
-			pp:        pp,
-			events:    em,
-			consumers: make(map[uint64]*SocketConsumer),
-			log:       slog.Default().With("system", "splitter"),
-		}, nil
+		s.pp = pp
+		s.events = events.NewEventManager(pp)
 	}
+	return s, nil
 }
+
 func NewDiskSplitter(host, path string, persistHours float64, maxBytes int64) (*Splitter, error) {
 	ppopts := events.PebblePersistOptions{
 		DbPath:          path,
@@ -106,38 +230,110 @@ func NewDiskSplitter(host, path string, persistHours float64, maxBytes int64) (*
 		CursorFile:    "cursor-file",
 		PebbleOptions: &ppopts,
 	}
+	s, err := newSplitter(conf, slog.Default().With("system", "splitter"))
+	if err != nil {
+		return nil, err
+	}
 	pp, err := events.NewPebblePersistance(&ppopts)
 	if err != nil {
 		return nil, err
 	}
 
 	go pp.GCThread(context.Background())
-	em := events.NewEventManager(pp)
+	s.pp = pp
+	s.events = events.NewEventManager(pp)
+	return s, nil
+}
+
+// newSplitter sets up everything a splitter has whatever its event cache,
+// which the constructors then add
+func newSplitter(conf SplitterConfig, log *slog.Logger) (*Splitter, error) {
+	mutes, err := newMuteList(conf.MutesFile)
+	if err != nil {
+		return nil, err
+	}
+	ca, err := newConsumerAuth(conf)
+	if err != nil {
+		return nil, err
+	}
+	zc, err := newZstdCompressor(conf.ZstdDictionaryFile)
+	if err != nil {
+		return nil, err
+	}
 	return &Splitter{
-		conf:      conf,
-		pp:        pp,
-		events:    em,
-		consumers: make(map[uint64]*SocketConsumer),
-		log:       slog.Default().With("system", "splitter"),
+		conf:            conf,
+		consumers:       make(map[uint64]*SocketConsumer),
+		jsonCache:       newJetstreamCache(),
+		consumerAuth:    ca,
+		zstd:            zc,
+		collectionStats: newCollectionStats(conf),
+		mutes:           mutes,
+		ageWatchdog:     newAgeWatchdog(conf),
+		log:             log,
 	}, nil
 }
 
+func newAgeWatchdog(conf SplitterConfig) *events.AgeWatchdog {
+	aw := events.NewAgeWatchdog("rainbow", conf.EventAgeSLA, nil)
+	aw.Logger = slog.Default().With("system", "splitter")
+	if conf.EventAgeAlertWebhook != "" {
+		aw.OnAlert = events.NewWebhookAlerter(conf.EventAgeAlertWebhook, aw.Logger)
+	}
+	return aw
+}
+
 func (s *Splitter) Start(addr string) error {
 	var lc net.ListenConfig
+	if s.conf.ReusePort {
+		lc.Control = reusePortControl
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	defer cancel()
 
-	curs, err := s.getLastCursor()
-	if err != nil {
-		return fmt.Errorf("loading cursor failed: %w", err)
+	upstream, curs := s.conf.UpstreamHost, int64(-1)
+	if s.conf.SharedHistoryPeer != "" {
+		// history is served from the peer, so we only need events from its
+		// live head on
+		upstream = s.conf.SharedHistoryPeer
+	} else {
+		var err error
+		curs, err = s.getLastCursor()
+		if err != nil {
+			return fmt.Errorf("loading cursor failed: %w", err)
+		}
+	}
+
+	if curs < 0 && s.conf.WarmStartPeer != "" && s.conf.SharedHistoryPeer == "" {
+		// a failed warm start isn't fatal: we continue from whatever was
+		// copied, or from the upstream's live head if nothing was
+		last, err := s.warmStart(context.Background(), s.conf.WarmStartPeer)
+		if err != nil {
+			s.log.Error("warm start from peer failed", "peer", s.conf.WarmStartPeer, "err", err)
+		}
+		if last >= 0 {
+			curs = last
+			if err := s.writeCursor(curs); err != nil {
+				s.log.Error("write cursor failed", "err", err)
+			}
+		}
 	}
 
-	go s.subscribeWithRedialer(context.Background(), s.conf.UpstreamHost, curs)
+	go s.subscribeWithRedialer(context.Background(), upstream, curs)
+
+	if s.conf.LoopbackListen != "" {
+		var llc net.ListenConfig
+		lli, err := llc.Listen(ctx, "tcp", s.conf.LoopbackListen)
+		if err != nil {
+			return fmt.Errorf("loopback listener: %w", err)
+		}
+		s.loopback = lli
+	}
 
 	li, err := lc.Listen(ctx, "tcp", addr)
 	if err != nil {
 		return err
 	}
+	s.log.Info("API listening", "addr", li.Addr(), "reuseport", s.conf.ReusePort, "pid", os.Getpid())
 	return s.StartWithListener(li)
 }
 
@@ -201,6 +397,33 @@ func (s *Splitter) StartWithListener(listen net.Listener) error {
 	}
 
 	e.GET("/xrpc/com.atproto.sync.subscribeRepos", s.EventsHandler)
+	e.GET("/subscribe", s.JetstreamHandler)
+
+	e.GET("/_rainbow/head", s.HandleHead)
+	e.GET("/_rainbow/zstd-dictionary", s.HandleZstdDictionary)
+
+	if s.conf.AdminToken != "" {
+		admin := e.Group("/admin", s.checkAdminAuth)
+		admin.GET("/events/export", s.HandleAdminExportEvents)
+		admin.POST("/events/import", s.HandleAdminImportEvents)
+		admin.GET("/events/stats", s.HandleAdminEventStats)
+		admin.GET("/events/collections", s.HandleAdminCollectionStats)
+		admin.GET("/events/zstd-dictionary", s.HandleAdminTrainDictionary)
+		admin.GET("/events/window", s.HandleAdminEventWindow)
+		admin.GET("/mutes", s.HandleAdminListMutes)
+		admin.POST("/mutes", s.HandleAdminAddMute)
+		admin.DELETE("/mutes/:did", s.HandleAdminRemoveMute)
+		admin.GET("/consumer-keys", s.HandleAdminListConsumerKeys)
+		admin.POST("/consumer-keys", s.HandleAdminCreateConsumerKey)
+		admin.DELETE("/consumer-keys/:name", s.HandleAdminDeleteConsumerKey)
+		admin.GET("/consumer-revocations", s.HandleAdminListConsumerRevocations)
+		admin.POST("/consumer-revocations", s.HandleAdminRevokeConsumer)
+		admin.DELETE("/consumer-revocations/:subject", s.HandleAdminUnrevokeConsumer)
+		admin.GET("/consumers", s.HandleAdminListConsumers)
+		admin.DELETE("/consumers/:id", s.HandleAdminDisconnectConsumer)
+		admin.POST("/consumers/:id/rewind", s.HandleAdminRewindConsumer)
+		admin.GET("/upstream", s.HandleAdminUpstreamStatus)
+	}
 
 	e.GET("/xrpc/_health", s.HandleHealthCheck)
 	e.GET("/_health", s.HandleHealthCheck)
@@ -209,6 +432,17 @@ func (s *Splitter) StartWithListener(listen net.Listener) error {
 	// In order to support booting on random ports in tests, we need to tell the
 	// Echo instance it's already got a port, and then use its StartServer
 	// method to re-use that listener.
+	if s.loopback != nil {
+		go func() {
+			s.log.Info("serving sibling processes on loopback listener", "addr", s.loopback.Addr())
+			if err := http.Serve(s.loopback, e); err != nil {
+				s.log.Error("loopback listener failed", "err", err)
+			}
+		}()
+	}
+	if len(s.conf.TLSHostnames) > 0 {
+		listen = s.acmeListener(listen)
+	}
 	e.Listener = listen
 	srv := &http.Server{}
 	return e.StartServer(srv)
@@ -247,15 +481,84 @@ func (s *Splitter) EventsHandler(c echo.Context) error {
 		}
 		since = &sval
 	}
+	filter, err := s.consumerFilter(c.QueryParams())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	useZstd := wantsZstd(c.Request())
+
+	return s.serveConsumer(c, &consumerStream{
+		since:  since,
+		filter: filter,
+		zstd:   useZstd,
+		start: func(ctx context.Context, conn *websocket.Conn) error {
+			if since == nil {
+				return nil
+			}
+			info, err := s.outdatedCursor(ctx, *since)
+			if err != nil {
+				s.log.Error("failed to check cursor against event cache", "err", err)
+			} else if info != nil {
+				if _, err := s.writeFirehoseEvent(conn, info, useZstd); err != nil {
+					return fmt.Errorf("failed to write info frame: %w", err)
+				}
+			}
+			return nil
+		},
+		write: func(conn *websocket.Conn, evt *events.XRPCStreamEvent) (int, int, error) {
+			size, err := s.writeFirehoseEvent(conn, evt, useZstd)
+			if err != nil {
+				return 0, 0, fmt.Errorf("failed to write event: %w", err)
+			}
+			return 1, size, nil
+		},
+	})
+}
+
+// consumerStream is what differs between the event stream endpoints
+type consumerStream struct {
+	since  *int64
+	filter func(*events.XRPCStreamEvent) bool
+	// the consumer asked for zstd compressed messages, which write sends
+	zstd bool
+	// called after the websocket is opened, before any events are sent. Optional
+	start func(ctx context.Context, conn *websocket.Conn) error
+	// sends an event, returning the number of messages, and payload bytes,
+	// written
+	write func(conn *websocket.Conn, evt *events.XRPCStreamEvent) (int, int, error)
+}
+
+// serveConsumer upgrades the request to a websocket, and streams events to it
+// until either side disconnects
+func (s *Splitter) serveConsumer(c echo.Context, cs *consumerStream) error {
+	name, err := s.authenticateConsumer(c)
+	if err != nil {
+		return err
+	}
 
 	ctx, cancel := context.WithCancel(c.Request().Context())
 	defer cancel()
 
-	// TODO: authhhh
-	conn, err := websocket.Upgrade(c.Response(), c.Request(), c.Response().Header(), 10<<10, 10<<10)
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:    10 << 10,
+		WriteBufferSize:   10 << 10,
+		EnableCompression: s.conf.PerMessageDeflate,
+		CheckOrigin:       func(r *http.Request) bool { return true },
+	}
+	conn, err := upgrader.Upgrade(c.Response(), c.Request(), c.Response().Header())
 	if err != nil {
 		return fmt.Errorf("upgrading websocket: %w", err)
 	}
+	defer conn.Close()
+
+	encoding := encodingNone
+	if cs.zstd {
+		// messages are already compressed
+		conn.EnableWriteCompression(false)
+		encoding = encodingZstd
+	} else if s.conf.PerMessageDeflate && offersDeflate(c.Request()) {
+		encoding = encodingDeflate
+	}
 
 	lastWriteLk := sync.Mutex{}
 	lastWrite := time.Now()
@@ -311,22 +614,41 @@ func (s *Splitter) EventsHandler(c echo.Context) error {
 		}
 	}()
 
+	if cs.start != nil {
+		if err := cs.start(ctx, conn); err != nil {
+			return err
+		}
+	}
+
 	ident := c.RealIP() + "-" + c.Request().UserAgent()
 
-	evts, cleanup, err := s.events.Subscribe(ctx, ident, func(evt *events.XRPCStreamEvent) bool { return true }, since)
+	sub, err := s.subscribe(ctx, ident, cs.filter, cs.since)
 	if err != nil {
 		return err
 	}
-	defer cleanup()
+	// the subscription may be replaced, so clean up whichever is current
+	defer func() { sub.cleanup() }()
 
 	// Keep track of the consumer for metrics and admin endpoints
 	consumer := SocketConsumer{
 		RemoteAddr:  c.RealIP(),
 		UserAgent:   c.Request().UserAgent(),
+		Consumer:    name,
+		Path:        c.Path(),
+		Encoding:    encoding,
 		ConnectedAt: time.Now(),
+		Cursor:      cs.since,
+		disconnect:  cancel,
+		rewind:      make(chan int64, 1),
 	}
 	sentCounter := eventsSentCounter.WithLabelValues(consumer.RemoteAddr, consumer.UserAgent)
 	consumer.EventsSent = sentCounter
+	var consumerSent promclient.Counter
+	if name != "" {
+		consumerSent = consumerEventsSentCounter.WithLabelValues(name)
+		authedClientGauge.WithLabelValues(name).Inc()
+		defer authedClientGauge.WithLabelValues(name).Dec()
+	}
 
 	consumerID := s.registerConsumer(&consumer)
 	defer s.cleanupConsumer(consumerID)
@@ -334,44 +656,82 @@ func (s *Splitter) EventsHandler(c echo.Context) error {
 	s.log.Info("new consumer",
 		"remote_addr", consumer.RemoteAddr,
 		"user_agent", consumer.UserAgent,
-		"cursor", since,
+		"consumer", consumer.Consumer,
+		"encoding", consumer.Encoding,
+		"path", c.Path(),
+		"cursor", cs.since,
 		"consumer_id", consumerID,
 	)
 	activeClientGauge.Inc()
 	defer activeClientGauge.Dec()
+	encodingClientGauge.WithLabelValues(encoding).Inc()
+	defer encodingClientGauge.WithLabelValues(encoding).Dec()
+
+	limiter := s.consumerLimiter()
 
 	for {
 		select {
-		case evt, ok := <-evts:
+		case evt, ok := <-sub.evts:
 			if !ok {
-				s.log.Error("event stream closed unexpectedly")
-				return nil
+				if !sub.overflowed() {
+					s.log.Error("event stream closed unexpectedly")
+					return nil
+				}
+				// the consumer fell behind: disconnect it, or start over
+				// from the last event sent
+				cursor, replay, err := s.replayCursor(ctx, &consumer)
+				if err != nil {
+					s.log.Error("failed to check cursor against event cache", "err", err)
+				}
+				if !replay {
+					s.log.Warn("disconnecting slow consumer", "consumer_id", consumerID, "policy", s.conf.SlowConsumerPolicy)
+					slowConsumersCounter.WithLabelValues(SlowConsumerDisconnect).Inc()
+					if _, _, err := cs.write(conn, consumerTooSlow()); err != nil {
+						s.log.Warn("failed to write error frame to slow consumer", "err", err)
+					}
+					return nil
+				}
+				s.log.Info("replaying slow consumer from the event cache", "consumer_id", consumerID, "cursor", cursor)
+				slowConsumersCounter.WithLabelValues(SlowConsumerReplay).Inc()
+				consumer.progress.replays.Add(1)
+				sub.cleanup()
+				sub, err = s.subscribe(ctx, ident, cs.filter, &cursor)
+				if err != nil {
+					return err
+				}
+				continue
 			}
 
-			wc, err := conn.NextWriter(websocket.BinaryMessage)
-			if err != nil {
-				s.log.Error("failed to get next writer", "err", err)
-				return err
+			if limiter != nil {
+				if err := limiter.Wait(ctx); err != nil {
+					return nil
+				}
 			}
 
-			if evt.Preserialized != nil {
-				_, err = wc.Write(evt.Preserialized)
-			} else {
-				err = evt.Serialize(wc)
-			}
+			n, size, err := cs.write(conn, evt)
 			if err != nil {
-				return fmt.Errorf("failed to write event: %w", err)
+				return err
 			}
-
-			if err := wc.Close(); err != nil {
-				s.log.Warn("failed to flush-close our event write", "err", err)
-				return nil
+			if n == 0 {
+				continue
 			}
+			consumer.progress.sent(evt, n, size)
 
 			lastWriteLk.Lock()
 			lastWrite = time.Now()
 			lastWriteLk.Unlock()
-			sentCounter.Inc()
+			sentCounter.Add(float64(n))
+			if consumerSent != nil {
+				consumerSent.Add(float64(n))
+			}
+		case cursor := <-consumer.rewind:
+			sub.cleanup()
+			sub, err = s.subscribe(ctx, ident, cs.filter, &cursor)
+			if err != nil {
+				return err
+			}
+			// replay from here if it falls behind before being sent anything
+			consumer.progress.lastSeq.Store(cursor)
 		case <-ctx.Done():
 			return nil
 		}
@@ -379,10 +739,28 @@ func (s *Splitter) EventsHandler(c echo.Context) error {
 }
 
 type SocketConsumer struct {
-	UserAgent   string
-	RemoteAddr  string
+	UserAgent  string
+	RemoteAddr string
+	// the name of the key ("key:<name>"), or the JWT subject
+	// ("jwt:<subject>"), the consumer authenticated with. empty for
+	// anonymous consumers
+	Consumer string
+	// the endpoint the consumer is subscribed to
+	Path string
+	// compression negotiated with the consumer: "none",
+	// "permessage-deflate", or "zstd"
+	Encoding    string
 	ConnectedAt time.Time
-	EventsSent  promclient.Counter
+	// the cursor the consumer connected with. nil for live consumers
+	Cursor     *int64
+	EventsSent promclient.Counter
+
+	progress consumerProgress
+
+	// closes the connection
+	disconnect context.CancelFunc
+	// cursors to replay from, sent by the admin API
+	rewind chan int64
 }
 
 func (s *Splitter) registerConsumer(c *SocketConsumer) uint64 {
@@ -412,6 +790,7 @@ func (s *Splitter) cleanupConsumer(id uint64) {
 		"consumer_id", id,
 		"remote_addr", c.RemoteAddr,
 		"user_agent", c.UserAgent,
+		"consumer", c.Consumer,
 		"events_sent", m.Counter.GetValue())
 
 	delete(s.consumers, id)
@@ -445,16 +824,28 @@ func (s *Splitter) subscribeWithRedialer(ctx context.Context, host string, curso
 		header := http.Header{
 			"User-Agent": []string{"bgs-rainbow-v0"},
 		}
+		if host == s.conf.SharedHistoryPeer {
+			header = peerHeader(s.conf.PeerToken)
+		}
 
-		var url string
-		if cursor < 0 {
-			url = fmt.Sprintf("%s://%s/xrpc/com.atproto.sync.subscribeRepos", protocol, host)
-		} else {
-			url = fmt.Sprintf("%s://%s/xrpc/com.atproto.sync.subscribeRepos?cursor=%d", protocol, host, cursor)
+		url := fmt.Sprintf("%s://%s/xrpc/com.atproto.sync.subscribeRepos", protocol, host)
+		if strings.Contains(host, "://") {
+			// an explicit scheme, eg ws://127.0.0.1:2482 for a sibling
+			// process's loopback listener
+			_, wsURL, err := peerURLs(host)
+			if err != nil {
+				s.log.Error("invalid upstream host", "host", host, "err", err)
+				return
+			}
+			url = wsURL
+		}
+		if cursor >= 0 {
+			url = fmt.Sprintf("%s?cursor=%d", url, cursor)
 		}
 		con, res, err := d.DialContext(ctx, url, header)
 		if err != nil {
 			s.log.Warn("dialing failed", "host", host, "err", err, "backoff", backoff)
+			s.upstream.dialFailed(host, err)
 			time.Sleep(sleepForBackoff(backoff))
 			backoff++
 
@@ -462,10 +853,13 @@ func (s *Splitter) subscribeWithRedialer(ctx context.Context, host string, curso
 		}
 
 		s.log.Info("event subscription response", "code", res.StatusCode)
+		s.upstream.connected(host)
 
-		if err := s.handleConnection(ctx, host, con, &cursor); err != nil {
+		err = s.handleConnection(ctx, host, con, &cursor)
+		if err != nil {
 			s.log.Warn("connection failed", "host", host, "err", err)
 		}
+		s.upstream.disconnected(err)
 	}
 }
 
@@ -480,11 +874,16 @@ func (s *Splitter) handleConnection(ctx context.Context, host string, con *webso
 			return nil
 		}
 
+		if s.collectionStats != nil {
+			s.collectionStats.Observe("", evt)
+		}
+		s.ageWatchdog.Observe(evt)
+
 		if err := s.events.AddEvent(ctx, evt); err != nil {
 			return err
 		}
 
-		if seq%5000 == 0 {
+		if seq%5000 == 0 && s.conf.SharedHistoryPeer == "" {
 			// TODO: don't need this after we move to getting seq from pebble
 			if err := s.writeCursor(seq); err != nil {
 				s.log.Error("write cursor failed", "err", err)
@@ -492,6 +891,7 @@ func (s *Splitter) handleConnection(ctx context.Context, host string, con *webso
 		}
 
 		*lastCursor = seq
+		s.upstream.event(seq)
 		return nil
 	})
 
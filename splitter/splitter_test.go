@@ -0,0 +1,98 @@
+package splitter
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/events"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+// serves the given events to each subscriber, then closes the connection, and
+// the last of them as /_rainbow/head. If token is set, subscribers without it
+// are rejected
+func testUpstream(t *testing.T, token string, evts ...*events.XRPCStreamEvent) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/_rainbow/head" {
+			json.NewEncoder(w).Encode(HeadStatus{Seq: evts[len(evts)-1].Sequence()})
+			return
+		}
+		if token != "" && r.Header.Get("Authorization") != "Bearer "+token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		upgrader := websocket.Upgrader{}
+		con, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer con.Close()
+		for _, evt := range evts {
+			wc, err := con.NextWriter(websocket.BinaryMessage)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if err := evt.Serialize(wc); err != nil {
+				t.Error(err)
+				return
+			}
+			if err := wc.Close(); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		con.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func testIdentityEvent(seq int64, did string) *events.XRPCStreamEvent {
+	return &events.XRPCStreamEvent{RepoIdentity: &comatproto.SyncSubscribeRepos_Identity{
+		Did:  did,
+		Seq:  seq,
+		Time: time.Now().UTC().Format(time.RFC3339Nano),
+	}}
+}
+
+func newTestDiskSplitter(t *testing.T) *Splitter {
+	t.Helper()
+	s, err := NewDiskSplitter("localhost", filepath.Join(t.TempDir(), "events"), 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.conf.CursorFile = filepath.Join(t.TempDir(), "cursor-file")
+	return s
+}
+
+func TestDiskSplitterHandleConnection(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	s := newTestDiskSplitter(t)
+	con, _, err := websocket.DefaultDialer.DialContext(ctx, testUpstream(t, "", testIdentityEvent(7, "did:example:abc")), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var last int64 = -1
+	// returns when the upstream closes the connection
+	s.handleConnection(ctx, "localhost", con, &last)
+	assert.Equal(int64(7), last)
+
+	seq, _, _, err := s.pp.GetLast(ctx)
+	assert.NoError(err)
+	assert.Equal(int64(7), seq)
+}
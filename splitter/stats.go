@@ -0,0 +1,142 @@
+package splitter
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	events "github.com/bluesky-social/indigo/events"
+	"github.com/labstack/echo/v4"
+)
+
+// HandleAdminEventStats returns statistics for a window of cached events. The
+// window may be selected by sequence number ("since" and "until"), by
+// persisted time ("sinceTime" and "untilTime", RFC 3339), or relative to now
+// ("last", a duration like "15m" or "2h"). "top" includes the N most active
+// repos.
+func (s *Splitter) HandleAdminEventStats(c echo.Context) error {
+	if s.pp == nil {
+		return echo.NewHTTPError(http.StatusNotImplemented, "event statistics require the disk event cache")
+	}
+
+	var w events.EventWindow
+	for _, p := range []struct {
+		name string
+		dest *int64
+	}{
+		{"since", &w.SinceSeq},
+		{"until", &w.UntilSeq},
+	} {
+		if q := c.QueryParam(p.name); q != "" {
+			v, err := strconv.ParseInt(q, 10, 64)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid %s: %s", p.name, err))
+			}
+			*p.dest = v
+		}
+	}
+	for _, p := range []struct {
+		name string
+		dest *time.Time
+	}{
+		{"sinceTime", &w.Since},
+		{"untilTime", &w.Until},
+	} {
+		if q := c.QueryParam(p.name); q != "" {
+			v, err := time.Parse(time.RFC3339, q)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid %s: %s", p.name, err))
+			}
+			*p.dest = v
+		}
+	}
+	if q := c.QueryParam("last"); q != "" {
+		d, err := time.ParseDuration(q)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid last: %s", err))
+		}
+		w.Since = time.Now().Add(-d)
+	}
+	if q := c.QueryParam("top"); q != "" {
+		v, err := strconv.Atoi(q)
+		if err != nil || v < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid top")
+		}
+		w.TopRepos = v
+	}
+
+	start := time.Now()
+	stats, err := s.pp.WindowStats(c.Request().Context(), w)
+	if err != nil {
+		return err
+	}
+	s.log.Info("computed event window stats", "events", stats.Total.Events, "duration", time.Since(start))
+	return c.JSON(http.StatusOK, stats)
+}
+
+func newCollectionStats(conf SplitterConfig) *events.CollectionStats {
+	if !conf.CollectionStats {
+		return nil
+	}
+	return events.NewCollectionStats(nil)
+}
+
+// HandleAdminCollectionStats returns the most active record collections over
+// the last hour of upstream events, along with any collections first seen in
+// that time. "limit" bounds the number of collections returned (default 50).
+func (s *Splitter) HandleAdminCollectionStats(c echo.Context) error {
+	if s.collectionStats == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "collection stats are not enabled")
+	}
+
+	limit := 50
+	if q := c.QueryParam("limit"); q != "" {
+		v, err := strconv.Atoi(q)
+		if err != nil || v < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid limit")
+		}
+		limit = v
+	}
+
+	return c.JSON(http.StatusOK, s.collectionStats.Report(limit))
+}
+
+// HandleAdminTrainDictionary trains a zstd dictionary for compressing the
+// disk event cache (see --persist-zstd-dictionary) on the most recently
+// cached events. "samples" is how many events to train on (default 10000),
+// and "size" the maximum dictionary size in bytes (default 112640, as for
+// the zstd CLI).
+func (s *Splitter) HandleAdminTrainDictionary(c echo.Context) error {
+	if s.pp == nil {
+		return echo.NewHTTPError(http.StatusNotImplemented, "dictionary training requires the disk event cache")
+	}
+
+	samples, size := 10_000, 110<<10
+	for _, p := range []struct {
+		name string
+		dest *int
+	}{
+		{"samples", &samples},
+		{"size", &size},
+	} {
+		if q := c.QueryParam(p.name); q != "" {
+			v, err := strconv.Atoi(q)
+			if err != nil || v <= 0 {
+				return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid %s", p.name))
+			}
+			*p.dest = v
+		}
+	}
+
+	start := time.Now()
+	dict, err := s.pp.TrainZstdDictionary(c.Request().Context(), samples, size)
+	if errors.Is(err, events.ErrNoLast) {
+		return echo.NewHTTPError(http.StatusNotFound, "no cached events to train on")
+	} else if err != nil {
+		return err
+	}
+	s.log.Info("trained zstd dictionary", "samples", samples, "bytes", len(dict), "duration", time.Since(start))
+	return c.Blob(http.StatusOK, "application/octet-stream", dict)
+}
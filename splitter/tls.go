@@ -0,0 +1,39 @@
+package splitter
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// wraps the API listener with TLS, using certificates provisioned from an
+// ACME provider (eg, Let's Encrypt). Also starts a plain HTTP listener to
+// answer HTTP-01 challenges, which redirects all other requests to HTTPS.
+func (s *Splitter) acmeListener(li net.Listener) net.Listener {
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(s.conf.TLSHostnames...),
+		Cache:      autocert.DirCache(s.conf.TLSCacheDir),
+		Email:      s.conf.ACMEEmail,
+	}
+
+	httpListen := s.conf.ACMEHTTPListen
+	if httpListen == "" {
+		httpListen = ":80"
+	}
+	go func() {
+		s.log.Info("starting ACME HTTP-01 challenge listener", "addr", httpListen)
+		if err := http.ListenAndServe(httpListen, m.HTTPHandler(nil)); err != nil {
+			// TLS-ALPN-01 challenges on the main listener may still work
+			s.log.Error("ACME HTTP-01 challenge listener failed", "addr", httpListen, "err", err)
+		}
+	}()
+
+	conf := m.TLSConfig()
+	// websocket clients need plain HTTP/1.1 connections
+	conf.NextProtos = []string{"http/1.1", "acme-tls/1"}
+	conf.MinVersion = tls.VersionTLS12
+	return tls.NewListener(li, conf)
+}
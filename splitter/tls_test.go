@@ -0,0 +1,117 @@
+package splitter
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writes a self-signed certificate for host into an autocert cache directory,
+// so the ACME listener can serve it without contacting a provider
+func writeCachedCert(t *testing.T, dir, host string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	pem.Encode(buf, &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	pem.Encode(buf, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, host), buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestACMEListenerHandshake(t *testing.T) {
+	assert := assert.New(t)
+
+	s := newTestDiskSplitter(t)
+	s.conf.TLSHostnames = []string{"rainbow.example.com"}
+	s.conf.TLSCacheDir = t.TempDir()
+	s.conf.ACMEHTTPListen = "127.0.0.1:0"
+	cert := writeCachedCert(t, s.conf.TLSCacheDir, "rainbow.example.com")
+
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	li := s.acmeListener(inner)
+	defer li.Close()
+
+	go func() {
+		for {
+			con, err := li.Accept()
+			if err != nil {
+				return
+			}
+			// the handshake happens on first write
+			io.WriteString(con, "hello")
+			con.Close()
+		}
+	}()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	con, err := tls.Dial("tcp", inner.Addr().String(), &tls.Config{
+		ServerName: "rainbow.example.com",
+		RootCAs:    pool,
+		NextProtos: []string{"http/1.1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer con.Close()
+
+	state := con.ConnectionState()
+	assert.Equal("http/1.1", state.NegotiatedProtocol)
+	assert.GreaterOrEqual(state.Version, uint16(tls.VersionTLS12))
+	assert.Equal(cert.Raw, state.PeerCertificates[0].Raw)
+
+	msg, err := io.ReadAll(con)
+	assert.NoError(err)
+	assert.Equal("hello", string(msg))
+
+	// hostnames outside the configured list are refused
+	_, err = tls.Dial("tcp", inner.Addr().String(), &tls.Config{
+		ServerName:         "other.example.com",
+		InsecureSkipVerify: true,
+	})
+	assert.Error(err)
+}
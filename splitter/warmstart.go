@@ -0,0 +1,180 @@
+package splitter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	events "github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/events/schedulers/sequential"
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+)
+
+// HeadStatus is returned by the /_rainbow/head endpoint, and describes the
+// most recent event in this instance's event cache
+type HeadStatus struct {
+	// Seq is the upstream sequence number of the most recent event, or -1 if
+	// the cache is empty
+	Seq int64 `json:"seq"`
+}
+
+var errWarmStartDone = errors.New("warm start reached peer head")
+
+// headSeq returns the sequence number of the most recently cached event, or -1
+func (s *Splitter) headSeq(ctx context.Context) (int64, error) {
+	if s.pp != nil {
+		seq, _, _, err := s.pp.GetLast(ctx)
+		if errors.Is(err, events.ErrNoLast) {
+			return -1, nil
+		}
+		if err != nil {
+			return -1, err
+		}
+		return seq, nil
+	}
+	return s.erb.LastSeq(), nil
+}
+
+func (s *Splitter) HandleHead(c echo.Context) error {
+	seq, err := s.headSeq(c.Request().Context())
+	if err != nil {
+		return err
+	}
+	return c.JSON(200, HeadStatus{Seq: seq})
+}
+
+// peerURLs returns the HTTP base URL and the WebSocket subscribeRepos URL for
+// a peer rainbow instance. The peer may be given as a bare host (assumed to
+// be TLS), or with an http(s):// or ws(s):// scheme.
+func peerURLs(peer string) (string, string, error) {
+	if !strings.Contains(peer, "://") {
+		peer = "https://" + peer
+	}
+	u, err := url.Parse(peer)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid warm start peer: %w", err)
+	}
+	switch u.Scheme {
+	case "https", "wss":
+		u.Scheme = "https"
+	case "http", "ws":
+		u.Scheme = "http"
+	default:
+		return "", "", fmt.Errorf("unsupported warm start peer scheme: %s", u.Scheme)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	httpBase := u.String()
+
+	if u.Scheme == "https" {
+		u.Scheme = "wss"
+	} else {
+		u.Scheme = "ws"
+	}
+	return httpBase, u.String() + "/xrpc/com.atproto.sync.subscribeRepos", nil
+}
+
+// peerHeader is the header to subscribe to a peer instance with,
+// authenticating with token (see SplitterConfig.PeerToken) if set
+func peerHeader(token string) http.Header {
+	h := http.Header{
+		"User-Agent": []string{"bgs-rainbow-v0"},
+	}
+	if token != "" {
+		h.Set("Authorization", "Bearer "+token)
+	}
+	return h
+}
+
+// warmStart fills an empty event cache by streaming the retained window from a
+// peer rainbow instance, up to the peer's head at the time of the call. It
+// returns the last sequence number persisted (or -1 if none), which is
+// suitable as a cursor for subscribing upstream.
+//
+// Events must arrive with strictly increasing sequence numbers; any
+// regression aborts the warm start, keeping what was persisted up to that
+// point. Note that persisted events get retention timestamps from when they
+// were copied, not from when the peer first received them.
+func (s *Splitter) warmStart(ctx context.Context, peer string) (int64, error) {
+	httpBase, wsURL, err := peerURLs(peer)
+	if err != nil {
+		return -1, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", httpBase+"/_rainbow/head", nil)
+	if err != nil {
+		return -1, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return -1, fmt.Errorf("fetching peer head: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return -1, fmt.Errorf("fetching peer head: status %d", resp.StatusCode)
+	}
+	var head HeadStatus
+	if err := json.NewDecoder(resp.Body).Decode(&head); err != nil {
+		return -1, fmt.Errorf("decoding peer head: %w", err)
+	}
+	if head.Seq < 0 {
+		s.log.Info("warm start peer has no events, skipping", "peer", peer)
+		return -1, nil
+	}
+
+	s.log.Info("starting warm start from peer", "peer", peer, "peerHead", head.Seq)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// cursor=0 asks for the entire retained window
+	con, _, err := websocket.DefaultDialer.DialContext(ctx, wsURL+"?cursor=0", peerHeader(s.conf.PeerToken))
+	if err != nil {
+		return -1, fmt.Errorf("dialing peer: %w", err)
+	}
+	defer con.Close()
+
+	start := time.Now()
+	last := int64(-1)
+	var count int
+	sched := sequential.NewScheduler("splitter-warmstart", func(ctx context.Context, evt *events.XRPCStreamEvent) error {
+		seq := events.SequenceForEvent(evt)
+		if seq < 0 {
+			// ignore info events and other unsupported types
+			return nil
+		}
+		if seq <= last {
+			return fmt.Errorf("peer sent out-of-order event (seq %d after %d)", seq, last)
+		}
+
+		if err := s.events.AddEvent(ctx, evt); err != nil {
+			return err
+		}
+		last = seq
+		count++
+		warmStartEventsCounter.Inc()
+
+		if count%50_000 == 0 {
+			s.log.Info("warm start progress", "seq", seq, "peerHead", head.Seq, "events", count)
+		}
+		if seq >= head.Seq {
+			return errWarmStartDone
+		}
+		return nil
+	})
+
+	err = events.HandleRepoStream(ctx, con, sched, s.log)
+	if errors.Is(err, errWarmStartDone) {
+		err = nil
+	} else if err == nil {
+		err = fmt.Errorf("peer stream ended before reaching head (last=%d, head=%d)", last, head.Seq)
+	}
+
+	s.log.Info("warm start finished", "peer", peer, "events", count, "lastSeq", last, "duration", time.Since(start), "err", err)
+	return last, err
+}
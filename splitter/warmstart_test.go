@@ -0,0 +1,32 @@
+package splitter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWarmStartPeerToken(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	peer := testUpstream(t, "rbw_peer", testIdentityEvent(1, "did:example:a"), testIdentityEvent(2, "did:example:b"))
+
+	// a peer requiring consumer auth rejects anonymous instances
+	s := newTestDiskSplitter(t)
+	last, err := s.warmStart(ctx, peer)
+	assert.ErrorContains(err, "dialing peer")
+	assert.Equal(int64(-1), last)
+
+	s = newTestDiskSplitter(t)
+	s.conf.PeerToken = "rbw_peer"
+	last, err = s.warmStart(ctx, peer)
+	assert.NoError(err)
+	assert.Equal(int64(2), last)
+	seq, _, _, err := s.pp.GetLast(ctx)
+	assert.NoError(err)
+	assert.Equal(int64(2), seq)
+}
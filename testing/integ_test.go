@@ -11,6 +11,7 @@ import (
 	"time"
 
 	atproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/bgs"
 	"github.com/bluesky-social/indigo/events"
 	"github.com/bluesky-social/indigo/repo"
 	"github.com/bluesky-social/indigo/xrpc"
@@ -110,6 +111,57 @@ func socialSim(t *testing.T, users []*TestUser, postiter, likeiter int) []*atpro
 	return posts
 }
 
+func TestRelayMirror(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping Relay test in 'short' test mode")
+	}
+	assert := assert.New(t)
+	didr := TestPLC(t)
+	p1 := MustSetupPDS(t, ".tpds", didr)
+	p1.Run(t)
+
+	// the downstream relay doesn't crawl the PDS, it only hears about it
+	// through events mirrored from the upstream relay
+	down := MustSetupRelay(t, didr)
+	down.Run(t)
+	down.tr.TrialHosts = []string{p1.RawHost()}
+	if err := down.bgs.CreateAdminToken("mirror"); err != nil {
+		t.Fatal(err)
+	}
+
+	conf := bgs.DefaultBGSConfig()
+	conf.MirrorUpstream = "ws://" + down.Host()
+	conf.MirrorUpstreamToken = "mirror"
+	up := MustSetupRelayWithConfig(t, didr, conf)
+	up.Run(t)
+	up.tr.TrialHosts = []string{p1.RawHost()}
+
+	p1.RequestScraping(t, up)
+	p1.BumpLimits(t, up)
+
+	time.Sleep(time.Millisecond * 50)
+
+	evts := down.Events(t, -1)
+	defer evts.Cancel()
+
+	bob := p1.MustNewUser(t, "bob.tpds")
+	e1 := evts.Next()
+	assert.NotNil(e1.RepoCommit)
+	assert.Equal(bob.DID(), e1.RepoCommit.Repo)
+
+	post := bob.Post(t, "cats for cats")
+	e2 := evts.Next()
+	assert.NotNil(e2.RepoCommit)
+	assert.Equal(bob.DID(), e2.RepoCommit.Repo)
+	assert.Greater(e2.RepoCommit.Seq, e1.RepoCommit.Seq)
+
+	// the downstream relay applied the commit to its own copy of the repo
+	time.Sleep(time.Millisecond * 50)
+	if _, err := down.bgs.Index.GetPost(context.Background(), post.Uri); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestRelayMultiPDS(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping Relay test in 'short' test mode")
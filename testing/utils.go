@@ -519,9 +519,13 @@ func (t *TestRelay) Host() string {
 }
 
 func MustSetupRelay(t *testing.T, didr plc.PLCClient) *TestRelay {
+	return MustSetupRelayWithConfig(t, didr, bgs.DefaultBGSConfig())
+}
+
+func MustSetupRelayWithConfig(t *testing.T, didr plc.PLCClient, config *bgs.BGSConfig) *TestRelay {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	tbgs, err := SetupRelay(ctx, didr)
+	tbgs, err := SetupRelayWithConfig(ctx, didr, config)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -530,6 +534,10 @@ func MustSetupRelay(t *testing.T, didr plc.PLCClient) *TestRelay {
 }
 
 func SetupRelay(ctx context.Context, didr plc.PLCClient) (*TestRelay, error) {
+	return SetupRelayWithConfig(ctx, didr, bgs.DefaultBGSConfig())
+}
+
+func SetupRelayWithConfig(ctx context.Context, didr plc.PLCClient, bgsConfig *bgs.BGSConfig) (*TestRelay, error) {
 	dir, err := os.MkdirTemp("", "integtest")
 	if err != nil {
 		return nil, err
@@ -582,7 +590,6 @@ func SetupRelay(ctx context.Context, didr plc.PLCClient) (*TestRelay, error) {
 
 	tr := &api.TestHandleResolver{}
 
-	bgsConfig := bgs.DefaultBGSConfig()
 	bgsConfig.SSL = false
 	b, err := bgs.NewBGS(maindb, ix, repoman, evtman, didr, rf, tr, bgsConfig)
 	if err != nil {
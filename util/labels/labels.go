@@ -2,8 +2,10 @@ package labels
 
 import (
 	"bytes"
+	"fmt"
 
 	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/crypto"
 )
 
 // UnsignedLabel is a label without the signature so we can validate it
@@ -37,3 +39,29 @@ func (ul *UnsignedLabel) BytesForSigning() ([]byte, error) {
 	}
 	return buf.Bytes(), nil
 }
+
+// ToUnsigned returns a copy of the label with the signature stripped
+func (sl *SignedLabel) ToUnsigned() *UnsignedLabel {
+	return &UnsignedLabel{
+		Cid: sl.Cid,
+		Cts: sl.Cts,
+		Exp: sl.Exp,
+		Neg: sl.Neg,
+		Src: sl.Src,
+		Uri: sl.Uri,
+		Val: sl.Val,
+		Ver: sl.Ver,
+	}
+}
+
+// VerifySignature checks the label's signature against the labeler's public key
+func (sl *SignedLabel) VerifySignature(pub crypto.PublicKey) error {
+	if len(sl.Sig) == 0 {
+		return fmt.Errorf("label is not signed")
+	}
+	b, err := sl.ToUnsigned().BytesForSigning()
+	if err != nil {
+		return err
+	}
+	return pub.HashAndVerifyLenient(b, sl.Sig)
+}
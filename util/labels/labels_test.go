@@ -0,0 +1,66 @@
+package labels
+
+import (
+	"testing"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func signedTestLabel(t *testing.T, priv crypto.PrivateKey) *SignedLabel {
+	t.Helper()
+
+	ver := int64(1)
+	sl := &SignedLabel{
+		Cts: "2024-01-01T00:00:00.000Z",
+		Src: "did:plc:labeler",
+		Uri: "at://did:plc:alice/app.bsky.feed.post/3kabc",
+		Val: "spam",
+		Ver: &ver,
+	}
+	b, err := sl.ToUnsigned().BytesForSigning()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sl.Sig, err = priv.HashAndSign(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sl
+}
+
+func TestVerifySignature(t *testing.T) {
+	assert := assert.New(t)
+
+	priv, err := crypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := priv.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sl := signedTestLabel(t, priv)
+	assert.NoError(sl.VerifySignature(pub))
+
+	// any change to the signed fields invalidates the signature
+	tampered := *sl
+	tampered.Val = "!takedown"
+	assert.Error(tampered.VerifySignature(pub))
+
+	// a different labeler key does not verify
+	other, err := crypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, err := other.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Error(sl.VerifySignature(otherPub))
+
+	unsigned := *sl
+	unsigned.Sig = nil
+	assert.Error(unsigned.VerifySignature(pub))
+}
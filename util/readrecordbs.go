@@ -12,7 +12,8 @@ import (
 type LoggingBstore struct {
 	base blockstore.Blockstore
 
-	set map[cid.Cid]blockformat.Block
+	set   map[cid.Cid]blockformat.Block
+	order []cid.Cid
 }
 
 func NewLoggingBstore(base blockstore.Blockstore) *LoggingBstore {
@@ -24,10 +25,12 @@ func NewLoggingBstore(base blockstore.Blockstore) *LoggingBstore {
 
 var _ blockstore.Blockstore = (*LoggingBstore)(nil)
 
+// GetLoggedBlocks returns every block read so far, in the order they were
+// first read
 func (bs *LoggingBstore) GetLoggedBlocks() []blockformat.Block {
-	var out []blockformat.Block
-	for _, v := range bs.set {
-		out = append(out, v)
+	out := make([]blockformat.Block, 0, len(bs.order))
+	for _, c := range bs.order {
+		out = append(out, bs.set[c])
 	}
 	return out
 }
@@ -42,7 +45,10 @@ func (bs *LoggingBstore) Get(ctx context.Context, c cid.Cid) (blockformat.Block,
 		return nil, err
 	}
 
-	bs.set[c] = blk
+	if _, ok := bs.set[c]; !ok {
+		bs.set[c] = blk
+		bs.order = append(bs.order, c)
+	}
 
 	return blk, nil
 }
@@ -0,0 +1,276 @@
+package xrpc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// AdminAuthHeader returns the value of an HTTP Authorization header for admin basic auth with the given password.
+func AdminAuthHeader(password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte("admin:"+password))
+}
+
+// CheckAdminAuth returns true if the request carries admin basic auth matching the given password. The comparison is constant-time.
+func CheckAdminAuth(r *http.Request, password string) bool {
+	if password == "" {
+		return false
+	}
+	user, pass, ok := r.BasicAuth()
+	if !ok || user != "admin" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(pass), []byte(password)) == 1
+}
+
+var (
+	ErrServiceAuthInvalid  = errors.New("invalid service auth token")
+	ErrServiceAuthExpired  = errors.New("service auth token expired")
+	ErrServiceAuthAudience = errors.New("service auth token audience mismatch")
+	ErrServiceAuthMethod   = errors.New("service auth token lexicon method mismatch")
+)
+
+// Maximum allowed clock skew when checking service auth token timestamps
+var ServiceAuthClockSkew = 30 * time.Second
+
+// Default lifetime of service auth tokens minted by SignServiceAuth
+var DefaultServiceAuthTTL = 60 * time.Second
+
+// Claims included in inter-service auth JWTs
+type ServiceAuthClaims struct {
+	// DID of the account or service making the request
+	Iss string `json:"iss"`
+	// DID (optionally with service fragment) of the service the token is intended for
+	Aud string `json:"aud"`
+	Exp int64  `json:"exp"`
+	Iat int64  `json:"iat"`
+	// NSID of the lexicon method the token is bound to. Optional, but verifiers may require it.
+	Lxm string `json:"lxm,omitempty"`
+	Jti string `json:"jti,omitempty"`
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+}
+
+func jwtAlgForKey(pub crypto.PublicKey) (string, error) {
+	switch pub.(type) {
+	case *crypto.PublicKeyK256:
+		return "ES256K", nil
+	case *crypto.PublicKeyP256:
+		return "ES256", nil
+	default:
+		return "", fmt.Errorf("unsupported key type for service auth: %T", pub)
+	}
+}
+
+// SignServiceAuth mints a service auth JWT, signed by the issuer's private key. The token is bound to the audience service and (if non-empty) a specific lexicon method. If ttl is zero, DefaultServiceAuthTTL is used.
+func SignServiceAuth(priv crypto.PrivateKey, iss syntax.DID, aud string, lxm syntax.NSID, ttl time.Duration) (string, error) {
+	pub, err := priv.PublicKey()
+	if err != nil {
+		return "", err
+	}
+	alg, err := jwtAlgForKey(pub)
+	if err != nil {
+		return "", err
+	}
+	if ttl == 0 {
+		ttl = DefaultServiceAuthTTL
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := ServiceAuthClaims{
+		Iss: iss.String(),
+		Aud: aud,
+		Iat: now.Unix(),
+		Exp: now.Add(ttl).Unix(),
+		Lxm: lxm.String(),
+		Jti: hex.EncodeToString(nonce),
+	}
+
+	hb, err := json.Marshal(jwtHeader{Alg: alg, Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	cb, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(hb) + "." + base64.RawURLEncoding.EncodeToString(cb)
+	sig, err := priv.HashAndSign([]byte(signingInput))
+	if err != nil {
+		return "", fmt.Errorf("signing service auth token: %w", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// ServiceAuthSigner mints service auth tokens for a Client calling another atproto service, so that every request carries a token bound to the method being called. Tokens are cached per method, and re-used until they are close to expiry.
+//
+// Note that tokens are re-used across requests; services which reject replayed tokens (by "jti") should be called with a zero-TTL signer, which mints a fresh token for each request.
+type ServiceAuthSigner struct {
+	Key crypto.PrivateKey
+	// DID of the account or service making requests
+	Iss syntax.DID
+	// DID (optionally with service fragment) of the service being called, eg "did:web:api.bsky.app#bsky_appview"
+	Aud string
+	// Lifetime of minted tokens. If zero, DefaultServiceAuthTTL is used, and tokens are not cached
+	TTL time.Duration
+
+	lk     sync.Mutex
+	tokens map[string]cachedServiceAuth
+}
+
+type cachedServiceAuth struct {
+	token string
+	// when to stop using the token, somewhat before it actually expires
+	refreshAt time.Time
+}
+
+func NewServiceAuthSigner(key crypto.PrivateKey, iss syntax.DID, aud string, ttl time.Duration) *ServiceAuthSigner {
+	return &ServiceAuthSigner{
+		Key: key,
+		Iss: iss,
+		Aud: aud,
+		TTL: ttl,
+	}
+}
+
+// Token returns a service auth token for the given lexicon method, minting a new one if there isn't a cached token with enough lifetime left.
+func (s *ServiceAuthSigner) Token(lxm syntax.NSID) (string, error) {
+	if s.TTL <= 0 {
+		return SignServiceAuth(s.Key, s.Iss, s.Aud, lxm, 0)
+	}
+
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	now := time.Now()
+	if c, ok := s.tokens[lxm.String()]; ok && now.Before(c.refreshAt) {
+		return c.token, nil
+	}
+
+	tok, err := SignServiceAuth(s.Key, s.Iss, s.Aud, lxm, s.TTL)
+	if err != nil {
+		return "", err
+	}
+	if s.tokens == nil {
+		s.tokens = make(map[string]cachedServiceAuth)
+	}
+	// leave a quarter of the lifetime as margin for clock skew and slow requests
+	s.tokens[lxm.String()] = cachedServiceAuth{token: tok, refreshAt: now.Add(s.TTL - s.TTL/4)}
+	return tok, nil
+}
+
+// ServiceAuthKeyFunc returns the current signing key for an issuer DID. If refresh is true, any cached key material should be bypassed (eg, to handle key rotation).
+type ServiceAuthKeyFunc func(ctx context.Context, iss syntax.DID, refresh bool) (crypto.PublicKey, error)
+
+// IdentityKeyFunc returns a ServiceAuthKeyFunc which looks up the atproto signing key for the issuer via an identity directory.
+func IdentityKeyFunc(dir identity.Directory) ServiceAuthKeyFunc {
+	return func(ctx context.Context, iss syntax.DID, refresh bool) (crypto.PublicKey, error) {
+		if refresh {
+			if err := dir.Purge(ctx, iss.AtIdentifier()); err != nil {
+				return nil, err
+			}
+		}
+		ident, err := dir.LookupDID(ctx, iss)
+		if err != nil {
+			return nil, err
+		}
+		return ident.PublicKey()
+	}
+}
+
+// VerifyServiceAuth parses and validates a service auth JWT: signature (against the issuer's key), expiry, audience, and (if lxm is non-empty) the lexicon method binding. Returns the verified claims.
+func VerifyServiceAuth(ctx context.Context, token string, aud string, lxm syntax.NSID, keyFunc ServiceAuthKeyFunc) (*ServiceAuthClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed JWT", ErrServiceAuthInvalid)
+	}
+
+	hb, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: header encoding: %w", ErrServiceAuthInvalid, err)
+	}
+	var hdr jwtHeader
+	if err := json.Unmarshal(hb, &hdr); err != nil {
+		return nil, fmt.Errorf("%w: header: %w", ErrServiceAuthInvalid, err)
+	}
+
+	cb, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: claims encoding: %w", ErrServiceAuthInvalid, err)
+	}
+	var claims ServiceAuthClaims
+	if err := json.Unmarshal(cb, &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %w", ErrServiceAuthInvalid, err)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature encoding: %w", ErrServiceAuthInvalid, err)
+	}
+
+	now := time.Now()
+	if claims.Exp == 0 || now.After(time.Unix(claims.Exp, 0).Add(ServiceAuthClockSkew)) {
+		return nil, ErrServiceAuthExpired
+	}
+	if claims.Iat != 0 && time.Unix(claims.Iat, 0).After(now.Add(ServiceAuthClockSkew)) {
+		return nil, fmt.Errorf("%w: issued in the future", ErrServiceAuthInvalid)
+	}
+	if claims.Aud != aud {
+		return nil, fmt.Errorf("%w: %s", ErrServiceAuthAudience, claims.Aud)
+	}
+	if lxm != "" && claims.Lxm != lxm.String() {
+		return nil, fmt.Errorf("%w: %s", ErrServiceAuthMethod, claims.Lxm)
+	}
+
+	iss, err := syntax.ParseDID(claims.Iss)
+	if err != nil {
+		return nil, fmt.Errorf("%w: issuer: %w", ErrServiceAuthInvalid, err)
+	}
+
+	signingInput := []byte(parts[0] + "." + parts[1])
+	checkSig := func(refresh bool) error {
+		pub, err := keyFunc(ctx, iss, refresh)
+		if err != nil {
+			return fmt.Errorf("fetching issuer key: %w", err)
+		}
+		alg, err := jwtAlgForKey(pub)
+		if err != nil {
+			return err
+		}
+		if alg != hdr.Alg {
+			return fmt.Errorf("%w: alg %s does not match issuer key", ErrServiceAuthInvalid, hdr.Alg)
+		}
+		if err := pub.HashAndVerifyLenient(signingInput, sig); err != nil {
+			return fmt.Errorf("%w: %w", ErrServiceAuthInvalid, err)
+		}
+		return nil
+	}
+
+	if err := checkSig(false); err != nil {
+		// the issuer may have rotated keys; retry once with fresh key material
+		if err := checkSig(true); err != nil {
+			return nil, err
+		}
+	}
+	return &claims, nil
+}
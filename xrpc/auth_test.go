@@ -0,0 +1,162 @@
+package xrpc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+func TestAdminAuth(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/xrpc/com.atproto.admin.getAccountInfo", nil)
+	req.Header.Set("Authorization", AdminAuthHeader("hunter2"))
+	if !CheckAdminAuth(req, "hunter2") {
+		t.Fatal("expected admin auth to pass")
+	}
+	if CheckAdminAuth(req, "hunter3") {
+		t.Fatal("expected admin auth to fail with wrong password")
+	}
+	if CheckAdminAuth(req, "") {
+		t.Fatal("expected admin auth to fail with empty password")
+	}
+}
+
+func TestServiceAuth(t *testing.T) {
+	ctx := context.Background()
+	iss := syntax.DID("did:plc:abc111")
+	aud := "did:web:service.example.com"
+	lxm := syntax.NSID("com.atproto.moderation.createReport")
+
+	for _, gen := range []func() (crypto.PrivateKey, error){
+		func() (crypto.PrivateKey, error) { return crypto.GeneratePrivateKeyK256() },
+		func() (crypto.PrivateKey, error) { return crypto.GeneratePrivateKeyP256() },
+	} {
+		priv, err := gen()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pub, err := priv.PublicKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		refreshed := false
+		keyFunc := func(ctx context.Context, did syntax.DID, refresh bool) (crypto.PublicKey, error) {
+			if did != iss {
+				return nil, errors.New("unknown issuer")
+			}
+			refreshed = refreshed || refresh
+			return pub, nil
+		}
+
+		tok, err := SignServiceAuth(priv, iss, aud, lxm, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		claims, err := VerifyServiceAuth(ctx, tok, aud, lxm, keyFunc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if claims.Iss != iss.String() || claims.Lxm != lxm.String() {
+			t.Fatalf("unexpected claims: %+v", claims)
+		}
+		if refreshed {
+			t.Fatal("key should not have been refreshed for a valid token")
+		}
+
+		if _, err := VerifyServiceAuth(ctx, tok, "did:web:other.example.com", lxm, keyFunc); !errors.Is(err, ErrServiceAuthAudience) {
+			t.Fatalf("expected audience error, got: %v", err)
+		}
+		if _, err := VerifyServiceAuth(ctx, tok, aud, syntax.NSID("com.atproto.repo.createRecord"), keyFunc); !errors.Is(err, ErrServiceAuthMethod) {
+			t.Fatalf("expected method error, got: %v", err)
+		}
+
+		expired, err := SignServiceAuth(priv, iss, aud, lxm, -time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := VerifyServiceAuth(ctx, expired, aud, lxm, keyFunc); !errors.Is(err, ErrServiceAuthExpired) {
+			t.Fatalf("expected expiry error, got: %v", err)
+		}
+
+		// signature from a different key fails, after refreshing the issuer key
+		other, err := gen()
+		if err != nil {
+			t.Fatal(err)
+		}
+		forged, err := SignServiceAuth(other, iss, aud, lxm, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := VerifyServiceAuth(ctx, forged, aud, lxm, keyFunc); !errors.Is(err, ErrServiceAuthInvalid) {
+			t.Fatalf("expected signature error, got: %v", err)
+		}
+		if !refreshed {
+			t.Fatal("expected key refresh on signature failure")
+		}
+	}
+}
+
+func TestServiceAuthSigner(t *testing.T) {
+	ctx := context.Background()
+	iss := syntax.DID("did:plc:abc111")
+	aud := "did:web:service.example.com#svc"
+
+	priv, err := crypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := priv.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFunc := func(ctx context.Context, did syntax.DID, refresh bool) (crypto.PublicKey, error) {
+		return pub, nil
+	}
+
+	var tokens []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tok := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		lxm := syntax.NSID(strings.TrimPrefix(r.URL.Path, "/xrpc/"))
+		if _, err := VerifyServiceAuth(r.Context(), tok, aud, lxm, keyFunc); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"AuthRequired","message":"bad token"}`))
+			return
+		}
+		tokens = append(tokens, tok)
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	c := &Client{
+		Host:        srv.URL,
+		ServiceAuth: NewServiceAuthSigner(priv, iss, aud, time.Minute),
+	}
+	for _, method := range []string{"com.example.first", "com.example.first", "com.example.second"} {
+		if err := c.Do(ctx, Query, "", method, nil, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// cached per method
+	if tokens[0] != tokens[1] || tokens[0] == tokens[2] {
+		t.Fatal("expected service auth token to be re-used for the same method only")
+	}
+
+	// zero TTL mints a fresh token every time
+	c.ServiceAuth = NewServiceAuthSigner(priv, iss, aud, 0)
+	tokens = nil
+	for i := 0; i < 2; i++ {
+		if err := c.Do(ctx, Query, "", "com.example.first", nil, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tokens[0] == tokens[1] {
+		t.Fatal("expected a fresh token for each request")
+	}
+}
@@ -0,0 +1,264 @@
+package xrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// ErrCircuitOpen is returned (wrapped) by Client.Do when the circuit breaker
+// for the host is open, without making a request.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+type BreakerState int
+
+const (
+	// Requests are made as usual
+	BreakerClosed BreakerState = iota
+	// Requests fail immediately, until the open timeout has passed
+	BreakerOpen
+	// A limited number of probe requests are allowed through; the breaker
+	// closes if they succeed, and re-opens if any fails
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("BreakerState(%d)", int(s))
+	}
+}
+
+type BreakerConfig struct {
+	// Consecutive failures after which the breaker for a host opens. Defaults to 5
+	FailureThreshold int
+	// How long an open breaker rejects requests before allowing probes. Defaults to 30 seconds
+	OpenTimeout time.Duration
+	// Number of concurrent probe requests allowed when half-open. Defaults to 1
+	HalfOpenProbes int
+	// Optional; decides whether a request error counts as a failure. The default counts network errors and 5xx responses, but not 4xx responses (the host is up), or errors from the caller's context being cancelled
+	IsFailure func(ctx context.Context, err error) bool
+	// Optional; called (synchronously, without locks held) when the breaker for a host changes state
+	OnStateChange func(host string, from, to BreakerState)
+}
+
+// Breakers tracks circuit breaker state per host, so that a client doesn't
+// keep hammering a host which is down. One Breakers is typically shared by
+// every client in a service, either by setting Client.Breakers, or with
+// SetDefaultBreakers.
+type Breakers struct {
+	cfg BreakerConfig
+
+	lk    sync.Mutex
+	hosts map[string]*hostBreaker
+}
+
+type hostBreaker struct {
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probes   int
+}
+
+func NewBreakers(cfg BreakerConfig) *Breakers {
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = 5
+	}
+	if cfg.OpenTimeout == 0 {
+		cfg.OpenTimeout = 30 * time.Second
+	}
+	if cfg.HalfOpenProbes == 0 {
+		cfg.HalfOpenProbes = 1
+	}
+	if cfg.IsFailure == nil {
+		cfg.IsFailure = DefaultIsFailure
+	}
+	return &Breakers{
+		cfg:   cfg,
+		hosts: make(map[string]*hostBreaker),
+	}
+}
+
+// DefaultIsFailure counts network errors and 5xx responses as failures
+func DefaultIsFailure(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	var xe *Error
+	if errors.As(err, &xe) {
+		return xe.StatusCode >= http.StatusInternalServerError
+	}
+	return true
+}
+
+var defaultBreakers atomic.Pointer[Breakers]
+
+// SetDefaultBreakers sets the circuit breakers used by clients which don't
+// have Client.Breakers set. Passing nil disables them.
+func SetDefaultBreakers(b *Breakers) {
+	defaultBreakers.Store(b)
+}
+
+// State returns the current state of the breaker for a host
+func (b *Breakers) State(host string) BreakerState {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	hb, ok := b.hosts[host]
+	if !ok {
+		return BreakerClosed
+	}
+	if hb.state == BreakerOpen && time.Since(hb.openedAt) >= b.cfg.OpenTimeout {
+		return BreakerHalfOpen
+	}
+	return hb.state
+}
+
+// Reset closes the breaker for a host, eg after an operator has confirmed it is back up
+func (b *Breakers) Reset(host string) {
+	b.lk.Lock()
+	hb, ok := b.hosts[host]
+	var from BreakerState
+	if ok {
+		from = hb.state
+		delete(b.hosts, host)
+	}
+	b.lk.Unlock()
+	if ok && from != BreakerClosed {
+		b.changed(host, from, BreakerClosed)
+	}
+}
+
+// allow checks whether a request to host may be made. If so, the returned
+// function must be called with the request's result.
+func (b *Breakers) allow(host string) (func(ctx context.Context, err error), error) {
+	b.lk.Lock()
+	hb, ok := b.hosts[host]
+	if !ok {
+		hb = &hostBreaker{}
+		b.hosts[host] = hb
+	}
+	var changed bool
+	switch hb.state {
+	case BreakerOpen:
+		if time.Since(hb.openedAt) < b.cfg.OpenTimeout {
+			b.lk.Unlock()
+			breakerRejections.Inc()
+			return nil, fmt.Errorf("%w: %s", ErrCircuitOpen, host)
+		}
+		hb.state = BreakerHalfOpen
+		hb.probes = 0
+		changed = true
+		fallthrough
+	case BreakerHalfOpen:
+		if hb.probes >= b.cfg.HalfOpenProbes {
+			b.lk.Unlock()
+			if changed {
+				b.changed(host, BreakerOpen, BreakerHalfOpen)
+			}
+			breakerRejections.Inc()
+			return nil, fmt.Errorf("%w: %s", ErrCircuitOpen, host)
+		}
+		hb.probes++
+	}
+	b.lk.Unlock()
+	if changed {
+		b.changed(host, BreakerOpen, BreakerHalfOpen)
+	}
+
+	return func(ctx context.Context, err error) {
+		if err != nil && ctx.Err() != nil {
+			// the caller gave up, so this says nothing about the host
+			b.release(host)
+			return
+		}
+		b.record(host, b.cfg.IsFailure(ctx, err))
+	}, nil
+}
+
+// release returns a half-open probe slot without recording a result
+func (b *Breakers) release(host string) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	if hb, ok := b.hosts[host]; ok && hb.state == BreakerHalfOpen && hb.probes > 0 {
+		hb.probes--
+	}
+}
+
+func (b *Breakers) record(host string, failed bool) {
+	b.lk.Lock()
+	hb, ok := b.hosts[host]
+	if !ok {
+		// reset while the request was in flight
+		b.lk.Unlock()
+		return
+	}
+	from := hb.state
+	switch {
+	case !failed && from == BreakerClosed:
+		// nothing to track for hosts which are up
+		delete(b.hosts, host)
+	case !failed && from == BreakerHalfOpen:
+		delete(b.hosts, host)
+	case !failed:
+		// a request which started before the breaker opened
+	case from == BreakerHalfOpen:
+		hb.state = BreakerOpen
+		hb.openedAt = time.Now()
+	case from == BreakerClosed:
+		hb.failures++
+		if hb.failures >= b.cfg.FailureThreshold {
+			hb.state = BreakerOpen
+			hb.openedAt = time.Now()
+		}
+	}
+	to := BreakerClosed
+	if hb2, ok := b.hosts[host]; ok {
+		to = hb2.state
+	}
+	b.lk.Unlock()
+
+	if to != from {
+		b.changed(host, from, to)
+	}
+}
+
+func (b *Breakers) changed(host string, from, to BreakerState) {
+	breakerTransitions.WithLabelValues(to.String()).Inc()
+	switch {
+	case to == BreakerOpen && from == BreakerClosed:
+		breakersOpen.Inc()
+	case to == BreakerClosed:
+		breakersOpen.Dec()
+	}
+	if b.cfg.OnStateChange != nil {
+		b.cfg.OnStateChange(host, from, to)
+	}
+}
+
+var breakerTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "xrpc_breaker_transitions_total",
+	Help: "Number of circuit breaker state changes, by the state changed to",
+}, []string{"state"})
+
+var breakerRejections = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "xrpc_breaker_rejected_total",
+	Help: "Number of requests not made because the circuit breaker for the host was open",
+})
+
+var breakersOpen = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "xrpc_breakers_open",
+	Help: "Number of hosts whose circuit breaker is open or half-open",
+})
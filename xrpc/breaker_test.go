@@ -0,0 +1,134 @@
+package xrpc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBreakers(t *testing.T) {
+	var down atomic.Bool
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error":"Unavailable","message":"down"}`))
+			return
+		}
+		if r.URL.Query().Get("bad") != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"InvalidRequest","message":"bad"}`))
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	var lk sync.Mutex
+	var transitions []BreakerState
+	b := NewBreakers(BreakerConfig{
+		FailureThreshold: 3,
+		OpenTimeout:      50 * time.Millisecond,
+		OnStateChange: func(host string, from, to BreakerState) {
+			lk.Lock()
+			defer lk.Unlock()
+			if host != srv.URL {
+				t.Errorf("unexpected host: %s", host)
+			}
+			transitions = append(transitions, to)
+		},
+	})
+	// the default client retries 5xx responses, which is slow
+	c := &Client{Host: srv.URL, Breakers: b, Client: srv.Client()}
+	ctx := context.Background()
+	call := func(params map[string]any) error {
+		return c.Do(ctx, Query, "", "com.example.test", params, nil, nil)
+	}
+
+	// client errors don't count
+	for i := 0; i < 5; i++ {
+		if err := call(map[string]any{"bad": "1"}); err == nil {
+			t.Fatal("expected error")
+		}
+	}
+	if s := b.State(srv.URL); s != BreakerClosed {
+		t.Fatalf("expected closed breaker, got %s", s)
+	}
+
+	down.Store(true)
+	for i := 0; i < 3; i++ {
+		if err := call(nil); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("expected request error, got %v", err)
+		}
+	}
+	if s := b.State(srv.URL); s != BreakerOpen {
+		t.Fatalf("expected open breaker, got %s", s)
+	}
+	n := requests.Load()
+	if err := call(nil); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+	if requests.Load() != n {
+		t.Fatal("request made with open breaker")
+	}
+
+	// a failed probe re-opens the breaker
+	time.Sleep(60 * time.Millisecond)
+	if s := b.State(srv.URL); s != BreakerHalfOpen {
+		t.Fatalf("expected half-open breaker, got %s", s)
+	}
+	if err := call(nil); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected probe error, got %v", err)
+	}
+	if err := call(nil); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+
+	// and a successful one closes it
+	down.Store(false)
+	time.Sleep(60 * time.Millisecond)
+	if err := call(nil); err != nil {
+		t.Fatal(err)
+	}
+	if s := b.State(srv.URL); s != BreakerClosed {
+		t.Fatalf("expected closed breaker, got %s", s)
+	}
+
+	lk.Lock()
+	defer lk.Unlock()
+	expected := []BreakerState{BreakerOpen, BreakerHalfOpen, BreakerOpen, BreakerHalfOpen, BreakerClosed}
+	if len(transitions) != len(expected) {
+		t.Fatalf("unexpected transitions: %v", transitions)
+	}
+	for i := range expected {
+		if transitions[i] != expected[i] {
+			t.Fatalf("unexpected transitions: %v", transitions)
+		}
+	}
+}
+
+func TestDefaultBreakers(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	SetDefaultBreakers(NewBreakers(BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Hour}))
+	defer SetDefaultBreakers(nil)
+
+	c := &Client{Host: srv.URL, Client: srv.Client()}
+	ctx := context.Background()
+	if err := c.Do(ctx, Query, "", "com.example.test", nil, nil, nil); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected request error, got %v", err)
+	}
+	if err := c.Do(ctx, Query, "", "com.example.test", nil, nil, nil); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+}
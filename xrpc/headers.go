@@ -0,0 +1,48 @@
+package xrpc
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// Request headers which affect how responses are hydrated
+const (
+	HeaderAcceptLabelers = "atproto-accept-labelers"
+	HeaderAcceptLanguage = "Accept-Language"
+)
+
+type headersKey struct{}
+
+// WithHeader returns a context carrying an extra request header. Client.Do sends headers from the request context on every call, so they apply to the generated API functions without needing a separate client per set of headers. Context headers take precedence over Client.Headers. An empty value removes a header previously set on the context.
+func WithHeader(ctx context.Context, key, value string) context.Context {
+	h := HeadersFromContext(ctx)
+	if h == nil {
+		h = make(http.Header)
+	}
+	if value == "" {
+		h.Del(key)
+	} else {
+		h.Set(key, value)
+	}
+	return context.WithValue(ctx, headersKey{}, h)
+}
+
+// WithAcceptLabelers returns a context which sends the atproto-accept-labelers header, listing labeler DIDs (optionally with parameters, eg "did:plc:abc;redact") whose labels should be applied to responses.
+func WithAcceptLabelers(ctx context.Context, labelers ...string) context.Context {
+	return WithHeader(ctx, HeaderAcceptLabelers, strings.Join(labelers, ", "))
+}
+
+// WithAcceptLanguage returns a context which sends the Accept-Language header, with language tags in order of preference.
+func WithAcceptLanguage(ctx context.Context, langs ...string) context.Context {
+	return WithHeader(ctx, HeaderAcceptLanguage, strings.Join(langs, ", "))
+}
+
+// HeadersFromContext returns a copy of the request headers set on ctx with WithHeader, or nil if there are none.
+func HeadersFromContext(ctx context.Context) http.Header {
+	h, ok := ctx.Value(headersKey{}).(http.Header)
+	if !ok {
+		return nil
+	}
+	return h.Clone()
+}
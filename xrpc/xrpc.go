@@ -3,7 +3,6 @@ package xrpc
 import (
 	"bytes"
 	"context"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -13,6 +12,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/bluesky-social/indigo/atproto/syntax"
 	"github.com/bluesky-social/indigo/util"
 	"github.com/carlmjohnson/versioninfo"
 )
@@ -22,9 +22,13 @@ type Client struct {
 	Client     *http.Client
 	Auth       *AuthInfo
 	AdminToken *string
-	Host       string
-	UserAgent  *string
-	Headers    map[string]string
+	// If set, each request (other than admin requests) is authenticated with a service auth token for the method being called, instead of Auth
+	ServiceAuth *ServiceAuthSigner
+	Host        string
+	UserAgent   *string
+	Headers     map[string]string
+	// Circuit breakers for requests to Host. If not set, defaults to those set with SetDefaultBreakers (if any)
+	Breakers *Breakers
 }
 
 func (c *Client) getClient() *http.Client {
@@ -182,17 +186,47 @@ func (c *Client) Do(ctx context.Context, kind XRPCRequestType, inpenc string, me
 			req.Header.Set(k, v)
 		}
 	}
+	if h, ok := ctx.Value(headersKey{}).(http.Header); ok {
+		for k, vs := range h {
+			req.Header[k] = vs
+		}
+	}
 
 	// use admin auth if we have it configured and are doing a request that requires it
 	if c.AdminToken != nil && (strings.HasPrefix(method, "com.atproto.admin.") || strings.HasPrefix(method, "tools.ozone.") || method == "com.atproto.server.createInviteCode" || method == "com.atproto.server.createInviteCodes") {
-		req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("admin:"+*c.AdminToken)))
+		req.Header.Set("Authorization", AdminAuthHeader(*c.AdminToken))
+	} else if c.ServiceAuth != nil {
+		lxm, err := syntax.ParseNSID(method)
+		if err != nil {
+			return fmt.Errorf("service auth for method %q: %w", method, err)
+		}
+		tok, err := c.ServiceAuth.Token(lxm)
+		if err != nil {
+			return fmt.Errorf("minting service auth token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+tok)
 	} else if c.Auth != nil {
 		req.Header.Set("Authorization", "Bearer "+c.Auth.AccessJwt)
 	}
 
+	breakers := c.Breakers
+	if breakers == nil {
+		breakers = defaultBreakers.Load()
+	}
+	// records the result with the circuit breaker, if there is one
+	breakerDone := func(context.Context, error) {}
+	if breakers != nil {
+		breakerDone, err = breakers.allow(c.Host)
+		if err != nil {
+			return err
+		}
+	}
+
 	resp, err := c.getClient().Do(req.WithContext(ctx))
 	if err != nil {
-		return fmt.Errorf("request failed: %w", err)
+		err = fmt.Errorf("request failed: %w", err)
+		breakerDone(ctx, err)
+		return err
 	}
 
 	defer resp.Body.Close()
@@ -200,10 +234,15 @@ func (c *Client) Do(ctx context.Context, kind XRPCRequestType, inpenc string, me
 	if resp.StatusCode != 200 {
 		var xe XRPCError
 		if err := json.NewDecoder(resp.Body).Decode(&xe); err != nil {
-			return errorFromHTTPResponse(resp, fmt.Errorf("failed to decode xrpc error message: %w", err))
+			err = errorFromHTTPResponse(resp, fmt.Errorf("failed to decode xrpc error message: %w", err))
+			breakerDone(ctx, err)
+			return err
 		}
-		return errorFromHTTPResponse(resp, &xe)
+		err = errorFromHTTPResponse(resp, &xe)
+		breakerDone(ctx, err)
+		return err
 	}
+	breakerDone(ctx, nil)
 
 	if out != nil {
 		if buf, ok := out.(*bytes.Buffer); ok {
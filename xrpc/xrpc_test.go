@@ -1,6 +1,9 @@
 package xrpc
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -57,3 +60,48 @@ func TestMakeParams(t *testing.T) {
 		})
 	}
 }
+
+func TestContextHeaders(t *testing.T) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+
+	c := &Client{
+		Host:    srv.URL,
+		Headers: map[string]string{"atproto-accept-labelers": "did:plc:default", "x-extra": "1"},
+	}
+
+	ctx := WithAcceptLabelers(context.Background(), "did:plc:one;redact", "did:plc:two")
+	ctx = WithAcceptLanguage(ctx, "pt-BR", "en")
+	if err := c.Do(ctx, Query, "", "app.bsky.feed.getTimeline", nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if v := got.Get(HeaderAcceptLabelers); v != "did:plc:one;redact, did:plc:two" {
+		t.Errorf("unexpected labelers header: %q", v)
+	}
+	if v := got.Get(HeaderAcceptLanguage); v != "pt-BR, en" {
+		t.Errorf("unexpected language header: %q", v)
+	}
+	if v := got.Get("x-extra"); v != "1" {
+		t.Errorf("client headers should still be sent: %q", v)
+	}
+
+	// derived contexts don't affect their parent
+	child := WithHeader(ctx, HeaderAcceptLanguage, "")
+	if HeadersFromContext(child).Get(HeaderAcceptLanguage) != "" || HeadersFromContext(ctx).Get(HeaderAcceptLanguage) != "pt-BR, en" {
+		t.Error("context headers should be copied when derived")
+	}
+
+	if err := c.Do(context.Background(), Query, "", "app.bsky.feed.getTimeline", nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if v := got.Get(HeaderAcceptLabelers); v != "did:plc:default" {
+		t.Errorf("expected client default without context headers: %q", v)
+	}
+	if v := got.Get(HeaderAcceptLanguage); v != "" {
+		t.Errorf("unexpected language header: %q", v)
+	}
+}
@@ -0,0 +1,206 @@
+// Package xrpctest provides helpers for testing code which makes XRPC calls:
+// a Mock server which answers requests in-process from registered handlers,
+// and a Recorder which captures real interactions to a golden file and
+// replays them deterministically.
+//
+// Both work by swapping out the HTTP transport of an xrpc.Client, so code
+// under test (including the generated api/atproto and api/bsky functions)
+// doesn't need to change.
+package xrpctest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// MockHost is the Host of clients returned by Mock.Client. Requests never
+// leave the process.
+const MockHost = "http://xrpc.mock"
+
+// Request is a single XRPC call received by a Mock
+type Request struct {
+	// HTTP method: GET for queries, POST for procedures
+	Method string
+	// NSID of the XRPC method called
+	NSID        string
+	Params      url.Values
+	Header      http.Header
+	ContentType string
+	Body        []byte
+}
+
+// DecodeBody unmarshals the JSON request body into v
+func (r *Request) DecodeBody(v any) error {
+	return json.Unmarshal(r.Body, v)
+}
+
+// HandlerFunc answers a mocked XRPC call. The returned value is encoded as
+// the JSON response body, unless it is a []byte, which is returned as-is (eg,
+// for CAR files). Returning an *Error sends an XRPC error response; any other
+// error is reported as a 500 InternalServerError.
+type HandlerFunc func(req *Request) (any, error)
+
+// Error is an XRPC error response returned by a HandlerFunc
+type Error struct {
+	StatusCode int
+	Name       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, e.Name, e.Message)
+}
+
+// NewError builds an XRPC error response with the given HTTP status, error
+// name, and message
+func NewError(status int, name, msg string) *Error {
+	return &Error{StatusCode: status, Name: name, Message: msg}
+}
+
+// Mock is an in-process XRPC server. Handlers are registered per method NSID;
+// calls to methods without a handler get a 501 MethodNotImplemented error.
+// Every call is kept, so tests can check what was sent.
+type Mock struct {
+	lk       sync.Mutex
+	handlers map[string]HandlerFunc
+	calls    []*Request
+}
+
+func NewMock() *Mock {
+	return &Mock{
+		handlers: make(map[string]HandlerFunc),
+	}
+}
+
+// Handle registers the handler for an XRPC method, replacing any existing
+// one
+func (m *Mock) Handle(nsid string, fn HandlerFunc) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	m.handlers[nsid] = fn
+}
+
+// HandleJSON registers a handler which always returns out
+func (m *Mock) HandleJSON(nsid string, out any) {
+	m.Handle(nsid, func(*Request) (any, error) {
+		return out, nil
+	})
+}
+
+// HandleError registers a handler which always fails with the given XRPC
+// error
+func (m *Mock) HandleError(nsid string, status int, name, msg string) {
+	m.Handle(nsid, func(*Request) (any, error) {
+		return nil, NewError(status, name, msg)
+	})
+}
+
+// Calls returns the requests received so far, in order
+func (m *Mock) Calls() []*Request {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	return append([]*Request(nil), m.calls...)
+}
+
+// CallsTo returns the requests received so far for one method
+func (m *Mock) CallsTo(nsid string) []*Request {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	var out []*Request
+	for _, c := range m.calls {
+		if c.NSID == nsid {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// Client returns an xrpc.Client whose requests are answered by the mock
+func (m *Mock) Client() *xrpc.Client {
+	return &xrpc.Client{
+		Client: &http.Client{Transport: m},
+		Host:   MockHost,
+	}
+}
+
+// RoundTrip implements http.RoundTripper
+func (m *Mock) RoundTrip(r *http.Request) (*http.Response, error) {
+	nsid, ok := strings.CutPrefix(r.URL.Path, "/xrpc/")
+	if !ok {
+		return errorResponse(r, NewError(http.StatusNotFound, "NotFound", "not an XRPC path: "+r.URL.Path)), nil
+	}
+
+	req := &Request{
+		Method:      r.Method,
+		NSID:        nsid,
+		Params:      r.URL.Query(),
+		Header:      r.Header.Clone(),
+		ContentType: r.Header.Get("Content-Type"),
+	}
+	if r.Body != nil {
+		b, err := io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = b
+	}
+
+	m.lk.Lock()
+	m.calls = append(m.calls, req)
+	fn := m.handlers[nsid]
+	m.lk.Unlock()
+
+	if fn == nil {
+		return errorResponse(r, NewError(http.StatusNotImplemented, "MethodNotImplemented", "no mock handler for "+nsid)), nil
+	}
+
+	out, err := fn(req)
+	if err != nil {
+		xe, ok := err.(*Error)
+		if !ok {
+			xe = NewError(http.StatusInternalServerError, "InternalServerError", err.Error())
+		}
+		return errorResponse(r, xe), nil
+	}
+
+	if b, ok := out.([]byte); ok {
+		return response(r, http.StatusOK, "application/octet-stream", b), nil
+	}
+	b, err := json.Marshal(out)
+	if err != nil {
+		return nil, fmt.Errorf("encoding mock response for %s: %w", nsid, err)
+	}
+	return response(r, http.StatusOK, "application/json", b), nil
+}
+
+func errorResponse(r *http.Request, e *Error) *http.Response {
+	b, _ := json.Marshal(xrpc.XRPCError{ErrStr: e.Name, Message: e.Message})
+	return response(r, e.StatusCode, "application/json", b)
+}
+
+func response(r *http.Request, status int, contentType string, body []byte) *http.Response {
+	header := make(http.Header)
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       r,
+	}
+}
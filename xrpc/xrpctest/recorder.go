@@ -0,0 +1,315 @@
+package xrpctest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/bluesky-social/indigo/util"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// RecordEnv is the environment variable which switches RecorderClient into
+// record mode, eg: XRPCTEST_RECORD=1 go test ./...
+const RecordEnv = "XRPCTEST_RECORD"
+
+type Mode int
+
+const (
+	// ModeReplay answers requests from a golden file, without any network
+	// access. Requests which weren't recorded fail.
+	ModeReplay Mode = iota
+	// ModeRecord passes requests through to the real host and captures the
+	// interactions, to be written out by Save.
+	ModeRecord
+)
+
+// ModeFromEnv returns ModeRecord if RecordEnv is set to a non-empty value,
+// and ModeReplay otherwise
+func ModeFromEnv() Mode {
+	if os.Getenv(RecordEnv) != "" {
+		return ModeRecord
+	}
+	return ModeReplay
+}
+
+var ErrNoInteraction = errors.New("no recorded interaction matches request")
+
+// Interaction is a single recorded request and its response. Request headers
+// are never recorded, so auth tokens don't end up in golden files; response
+// bodies may still contain secrets (eg, createSession), which a Redact hook
+// can scrub.
+//
+// Bodies which are valid JSON are stored as JSON to keep golden files
+// readable and diffable; anything else is stored base64 encoded.
+type Interaction struct {
+	Method       string            `json:"method"`
+	Path         string            `json:"path"`
+	Query        string            `json:"query,omitempty"`
+	RequestJSON  json.RawMessage   `json:"requestJson,omitempty"`
+	RequestBytes []byte            `json:"requestBytes,omitempty"`
+	Status       int               `json:"status"`
+	Header       map[string]string `json:"header,omitempty"`
+	ResponseJSON json.RawMessage   `json:"responseJson,omitempty"`
+	ResponseBody []byte            `json:"responseBytes,omitempty"`
+}
+
+type cassette struct {
+	Interactions []*Interaction `json:"interactions"`
+}
+
+// response headers which are worth keeping in golden files; everything else
+// (dates, cookies, tracing, ...) is noise or sensitive
+var recordedHeaders = []string{
+	"Content-Type",
+	"Ratelimit-Limit",
+	"Ratelimit-Remaining",
+	"Ratelimit-Reset",
+	"Ratelimit-Policy",
+}
+
+// Recorder is an http.RoundTripper which records XRPC interactions to a
+// golden file, or replays them from one.
+//
+// On replay, a request matches the first unused interaction with the same
+// HTTP method, path, query string and body, so repeated calls to the same
+// endpoint are answered in the order they were recorded. The host is not part
+// of the match, so recordings made against one server replay for any Host.
+type Recorder struct {
+	Mode Mode
+	// Next is the transport used to reach the real host in record mode.
+	// Defaults to the transport of util.RobustHTTPClient.
+	Next http.RoundTripper
+	// Redact, if set, is called on every interaction before it is recorded
+	Redact func(*Interaction)
+
+	path string
+
+	lk           sync.Mutex
+	interactions []*Interaction
+	used         []bool
+}
+
+// NewRecorder creates a recorder backed by the golden file at path. In replay
+// mode the file must already exist.
+func NewRecorder(path string, mode Mode) (*Recorder, error) {
+	r := &Recorder{
+		Mode: mode,
+		path: path,
+	}
+	if mode == ModeRecord {
+		return r, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("loading xrpc recording (set %s=1 to record it): %w", RecordEnv, err)
+	}
+	var c cassette
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("parsing xrpc recording %s: %w", path, err)
+	}
+	r.interactions = c.Interactions
+	r.used = make([]bool, len(c.Interactions))
+	return r, nil
+}
+
+// Client returns an xrpc.Client for host whose requests go through the
+// recorder
+func (r *Recorder) Client(host string) *xrpc.Client {
+	return &xrpc.Client{
+		Client: &http.Client{Transport: r},
+		Host:   host,
+	}
+}
+
+// Interactions returns the interactions recorded or loaded so far
+func (r *Recorder) Interactions() []*Interaction {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	return append([]*Interaction(nil), r.interactions...)
+}
+
+// Unused returns the loaded interactions which haven't been replayed. Tests
+// can check this is empty to catch calls which the code no longer makes.
+func (r *Recorder) Unused() []*Interaction {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	var out []*Interaction
+	for i, used := range r.used {
+		if !used {
+			out = append(out, r.interactions[i])
+		}
+	}
+	return out
+}
+
+// Save writes the recorded interactions to the golden file, creating parent
+// directories as needed. It does nothing in replay mode.
+func (r *Recorder) Save() error {
+	if r.Mode != ModeRecord {
+		return nil
+	}
+	r.lk.Lock()
+	c := cassette{Interactions: r.interactions}
+	b, err := json.MarshalIndent(c, "", "  ")
+	r.lk.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(r.path, append(b, '\n'), 0644)
+}
+
+// RoundTrip implements http.RoundTripper
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		b, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = b
+	}
+
+	if r.Mode == ModeRecord {
+		return r.record(req, body)
+	}
+	return r.replay(req, body)
+}
+
+func (r *Recorder) record(req *http.Request, body []byte) (*http.Response, error) {
+	next := r.Next
+	if next == nil {
+		next = util.RobustHTTPClient().Transport
+	}
+
+	out := req.Clone(req.Context())
+	out.Body = io.NopCloser(bytes.NewReader(body))
+	out.ContentLength = int64(len(body))
+	resp, err := next.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("reading response to record: %w", err)
+	}
+
+	in := &Interaction{
+		Method: req.Method,
+		Path:   req.URL.Path,
+		Query:  req.URL.Query().Encode(),
+		Status: resp.StatusCode,
+		Header: make(map[string]string),
+	}
+	in.RequestJSON, in.RequestBytes = encodeBody(body)
+	in.ResponseJSON, in.ResponseBody = encodeBody(respBody)
+	for _, h := range recordedHeaders {
+		if v := resp.Header.Get(h); v != "" {
+			in.Header[h] = v
+		}
+	}
+	if r.Redact != nil {
+		r.Redact(in)
+	}
+
+	r.lk.Lock()
+	r.interactions = append(r.interactions, in)
+	r.used = append(r.used, true)
+	r.lk.Unlock()
+
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+	resp.ContentLength = int64(len(respBody))
+	return resp, nil
+}
+
+func (r *Recorder) replay(req *http.Request, body []byte) (*http.Response, error) {
+	query := req.URL.Query().Encode()
+	reqJSON, reqBytes := encodeBody(body)
+
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	for i, in := range r.interactions {
+		if r.used[i] || in.Method != req.Method || in.Path != req.URL.Path || in.Query != query {
+			continue
+		}
+		inJSON, inBytes := encodeBody(in.requestBody())
+		if !bytes.Equal(inJSON, reqJSON) || !bytes.Equal(inBytes, reqBytes) {
+			continue
+		}
+		r.used[i] = true
+
+		b := in.responseBody()
+		resp := response(req, in.Status, "", b)
+		for k, v := range in.Header {
+			resp.Header.Set(k, v)
+		}
+		return resp, nil
+	}
+	return nil, fmt.Errorf("%w: %s %s?%s", ErrNoInteraction, req.Method, req.URL.Path, query)
+}
+
+func (in *Interaction) requestBody() []byte {
+	if in.RequestJSON != nil {
+		return in.RequestJSON
+	}
+	return in.RequestBytes
+}
+
+func (in *Interaction) responseBody() []byte {
+	if in.ResponseJSON != nil {
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, in.ResponseJSON); err == nil {
+			return buf.Bytes()
+		}
+		return in.ResponseJSON
+	}
+	return in.ResponseBody
+}
+
+// encodeBody returns a body as compacted JSON if it is valid JSON, or as
+// raw bytes otherwise. Empty bodies are nil in both.
+func encodeBody(b []byte) (json.RawMessage, []byte) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+	if json.Valid(b) {
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, b); err == nil {
+			return buf.Bytes(), nil
+		}
+	}
+	return nil, b
+}
+
+// RecorderClient returns an xrpc.Client for host backed by the golden file
+// at path. By default it replays, and fails the test if the file is missing;
+// with RecordEnv set it talks to host and rewrites the file when the test
+// finishes.
+func RecorderClient(t testing.TB, path, host string) *xrpc.Client {
+	t.Helper()
+	rec, err := NewRecorder(path, ModeFromEnv())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Mode == ModeRecord {
+		t.Cleanup(func() {
+			if err := rec.Save(); err != nil {
+				t.Errorf("saving xrpc recording: %v", err)
+			}
+		})
+	}
+	return rec.Client(host)
+}
@@ -0,0 +1,143 @@
+package xrpctest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMock(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	m := NewMock()
+	m.HandleJSON("com.atproto.server.describeServer", &comatproto.ServerDescribeServer_Output{
+		Did:                  "did:web:pds.example.com",
+		AvailableUserDomains: []string{".example.com"},
+	})
+	m.Handle("com.atproto.identity.resolveHandle", func(req *Request) (any, error) {
+		if req.Params.Get("handle") != "alice.example.com" {
+			return nil, NewError(http.StatusBadRequest, "InvalidRequest", "Unable to resolve handle")
+		}
+		return &comatproto.IdentityResolveHandle_Output{Did: "did:plc:alice"}, nil
+	})
+	m.HandleError("com.atproto.server.createSession", http.StatusUnauthorized, "AuthenticationRequired", "Invalid identifier or password")
+	c := m.Client()
+
+	desc, err := comatproto.ServerDescribeServer(ctx, c)
+	assert.NoError(err)
+	assert.Equal("did:web:pds.example.com", desc.Did)
+
+	out, err := comatproto.IdentityResolveHandle(ctx, c, "alice.example.com")
+	assert.NoError(err)
+	assert.Equal("did:plc:alice", out.Did)
+
+	_, err = comatproto.IdentityResolveHandle(ctx, c, "bob.example.com")
+	var xerr *xrpc.Error
+	assert.True(errors.As(err, &xerr))
+	assert.Equal(http.StatusBadRequest, xerr.StatusCode)
+	var xe *xrpc.XRPCError
+	assert.True(errors.As(err, &xe))
+	assert.Equal("InvalidRequest", xe.ErrStr)
+
+	_, err = comatproto.ServerCreateSession(ctx, c, &comatproto.ServerCreateSession_Input{Identifier: "alice", Password: "hunter2"})
+	assert.True(errors.As(err, &xerr))
+	assert.Equal(http.StatusUnauthorized, xerr.StatusCode)
+
+	_, err = comatproto.SyncGetLatestCommit(ctx, c, "did:plc:alice")
+	assert.True(errors.As(err, &xerr))
+	assert.Equal(http.StatusNotImplemented, xerr.StatusCode)
+
+	calls := m.CallsTo("com.atproto.server.createSession")
+	assert.Len(calls, 1)
+	var in comatproto.ServerCreateSession_Input
+	assert.NoError(calls[0].DecodeBody(&in))
+	assert.Equal("hunter2", in.Password)
+	assert.Len(m.Calls(), 5)
+}
+
+func TestRecordReplay(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "testdata", "session.json")
+
+	n := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Ratelimit-Remaining", "99")
+		switch r.URL.Path {
+		case "/xrpc/com.atproto.server.createSession":
+			json.NewEncoder(w).Encode(&comatproto.ServerCreateSession_Output{Did: "did:plc:alice", Handle: "alice.test", AccessJwt: "secret-token"})
+		case "/xrpc/com.atproto.identity.resolveHandle":
+			if r.URL.Query().Get("handle") != "alice.test" {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(&xrpc.XRPCError{ErrStr: "InvalidRequest", Message: "Unable to resolve handle"})
+				return
+			}
+			json.NewEncoder(w).Encode(&comatproto.IdentityResolveHandle_Output{Did: "did:plc:alice"})
+		}
+	}))
+	defer srv.Close()
+
+	rec, err := NewRecorder(path, ModeRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.Redact = func(in *Interaction) {
+		if in.Path == "/xrpc/com.atproto.server.createSession" {
+			in.ResponseJSON = json.RawMessage(`{"did":"did:plc:alice","handle":"alice.test","accessJwt":"REDACTED","refreshJwt":"REDACTED"}`)
+		}
+	}
+	c := rec.Client(srv.URL)
+	sess, err := comatproto.ServerCreateSession(ctx, c, &comatproto.ServerCreateSession_Input{Identifier: "alice.test", Password: "hunter2"})
+	assert.NoError(err)
+	assert.Equal("secret-token", sess.AccessJwt)
+	_, err = comatproto.IdentityResolveHandle(ctx, c, "alice.test")
+	assert.NoError(err)
+	_, err = comatproto.IdentityResolveHandle(ctx, c, "bob.test")
+	assert.Error(err)
+	assert.NoError(rec.Save())
+	assert.Equal(3, n)
+
+	// replay doesn't touch the server, and works against any host
+	rep, err := NewRecorder(path, ModeReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = rep.Client("https://elsewhere.example.com")
+	sess, err = comatproto.ServerCreateSession(ctx, c, &comatproto.ServerCreateSession_Input{Identifier: "alice.test", Password: "hunter2"})
+	assert.NoError(err)
+	assert.Equal("REDACTED", sess.AccessJwt)
+
+	_, err = comatproto.IdentityResolveHandle(ctx, c, "bob.test")
+	var xe *xrpc.XRPCError
+	assert.True(errors.As(err, &xe))
+	assert.Equal("InvalidRequest", xe.ErrStr)
+	assert.Len(rep.Unused(), 1)
+
+	out, err := comatproto.IdentityResolveHandle(ctx, c, "alice.test")
+	assert.NoError(err)
+	assert.Equal("did:plc:alice", out.Did)
+	assert.Empty(rep.Unused())
+	assert.Equal(3, n)
+
+	// each interaction is only replayed once, and different request bodies
+	// don't match
+	_, err = comatproto.IdentityResolveHandle(ctx, c, "alice.test")
+	assert.ErrorIs(err, ErrNoInteraction)
+	_, err = comatproto.ServerCreateSession(ctx, c, &comatproto.ServerCreateSession_Input{Identifier: "alice.test", Password: "wrong"})
+	assert.ErrorIs(err, ErrNoInteraction)
+
+	_, err = NewRecorder(filepath.Join(t.TempDir(), "missing.json"), ModeReplay)
+	assert.Error(err)
+}